@@ -3,12 +3,19 @@
 # Exit on error
 set -e
 
-# Build the backend
-go build
-
 # Build the React app
 cd ui
 npm ci
 npm run build
 cd ..
 
+# Build the backend (with the React app embedded in the binary, if EMBED_UI is
+# set, and with the AVIF codec of libavif, if LIBAVIF is set)
+tags=()
+if [ -n "$EMBED_UI" ]; then
+	tags+=(embedui)
+fi
+if [ -n "$LIBAVIF" ]; then
+	tags+=(libavif)
+fi
+go build -tags "$(IFS=,; echo "${tags[*]}")"
@@ -5,21 +5,23 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/discuitnet/discuit/config"
 	"github.com/discuitnet/discuit/core"
 	"github.com/discuitnet/discuit/internal/images"
+	"github.com/discuitnet/discuit/internal/imports"
+	"github.com/discuitnet/discuit/internal/migrations"
 	"github.com/discuitnet/discuit/internal/uid"
 	"github.com/discuitnet/discuit/program"
 	"github.com/urfave/cli/v2"
 	"gopkg.in/yaml.v2"
-
-	_ "github.com/golang-migrate/migrate/v4/database/mysql"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
 )
 
 func RunCLI() {
@@ -37,13 +39,24 @@ func RunCLI() {
 			CommandHardReset,
 			CommandForcePassChange,
 			CommandFixHotness,
+			CommandCheckIntegrity,
+			CommandSearchReindex,
 			CommandAddAllUsersToCommunity,
 			CommandDeleteUnusedCommunities,
+			CommandPurgeDeletedContent,
+			CommandLegalHold,
 			CommandNewBadge,
 			CommandDeleteUser,
 			CommandInjectConfig,
+			CommandConfig,
 			CommandImagePath,
+			CommandGCImages,
+			CommandPurgeImageTrash,
+			CommandMigrateImages,
+			CommandImport,
 			CommandBot,
+			CommandDeadJobs,
+			CommandBench,
 		},
 	}
 
@@ -190,6 +203,36 @@ var CommandInjectConfig = &cli.Command{
 	},
 }
 
+var CommandConfig = &cli.Command{
+	Name:  "config",
+	Usage: "Config commands",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "validate",
+			Usage:     "Check the config (the file and the environment), and print all its problems",
+			ArgsUsage: "[config file]",
+			Action: func(ctx *cli.Context) error {
+				path := "config.yaml" // in the working directory
+				if ctx.Args().Present() {
+					path = ctx.Args().First()
+				}
+				_, err := config.Parse(path)
+				var verr *config.ValidationError
+				if errors.As(err, &verr) {
+					for _, p := range verr.Problems {
+						fmt.Printf("%s: %v\n", path, p)
+					}
+					return cli.Exit(fmt.Sprintf("problems found: %d", len(verr.Problems)), 1)
+				} else if err != nil {
+					return err
+				}
+				fmt.Printf("%s: ok\n", path)
+				return nil
+			},
+		},
+	},
+}
+
 var CommandHardReset = &cli.Command{
 	Name:  "hard-reset",
 	Usage: "Hard reset",
@@ -251,6 +294,54 @@ var CommandFixHotness = &cli.Command{
 	},
 }
 
+var CommandCheckIntegrity = &cli.Command{
+	Name:  "check-integrity",
+	Usage: "Check denormalized counters against source tables",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "check",
+			Usage: "Check to run (runs all if not given): " + strings.Join(core.CounterCheckNames(), ", "),
+		},
+		&cli.IntFlag{
+			Name:  "batch-size",
+			Usage: "Number of rows checked and repaired at a time",
+			Value: 1000,
+		},
+		&cli.BoolFlag{
+			Name:  "repair",
+			Usage: "Fix the counters that have drifted",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		pg, err := program.NewProgram(true)
+		if err != nil {
+			return err
+		}
+		defer pg.Close()
+		return pg.CheckCounterIntegrity(ctx.StringSlice("check"), ctx.Int("batch-size"), ctx.Bool("repair"))
+	},
+}
+
+var CommandSearchReindex = &cli.Command{
+	Name:  "search-reindex",
+	Usage: "Index all posts and comments in the search backend (if it's not the database)",
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "batch-size",
+			Usage: "Number of posts or comments indexed at a time",
+			Value: 500,
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		pg, err := program.NewProgram(true)
+		if err != nil {
+			return err
+		}
+		defer pg.Close()
+		return pg.ReindexSearch(ctx.Int("batch-size"))
+	},
+}
+
 var CommandDeleteUser = &cli.Command{
 	Name:  "delete-user",
 	Usage: "Delete a user",
@@ -317,6 +408,74 @@ var CommandDeleteUnusedCommunities = &cli.Command{
 	},
 }
 
+var CommandPurgeDeletedContent = &cli.Command{
+	Name:  "purge-deleted-content",
+	Usage: "Permanently erase posts and comments deleted by their authors more than (by default) " + strconv.Itoa(defaultDays) + " days ago",
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "days",
+			Usage: "Only purges items deleted more than this many days ago",
+			Value: defaultDays,
+		},
+		&cli.IntFlag{
+			Name:  "batch-size",
+			Usage: "Number of items purged at a time",
+			Value: 100,
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "List the items that would be purged without purging them",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		pg, err := program.NewProgram(true)
+		if err != nil {
+			return err
+		}
+		defer pg.Close()
+
+		dryRun := ctx.Bool("dry-run")
+		if !dryRun {
+			if ok := YesConfirmCommand(); !ok {
+				log.Fatal("Cannot continue without a YES.")
+			}
+		}
+		return pg.PurgeDeletedContent(ctx.Int("days"), ctx.Int("batch-size"), dryRun)
+	},
+}
+
+var CommandLegalHold = &cli.Command{
+	Name:      "legal-hold",
+	Usage:     "Exempt a user's content, a post, or a comment from being purged",
+	ArgsUsage: "(user|post|comment) (username|post public ID|comment ID)",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "note",
+			Usage: "Reason for the hold",
+		},
+		&cli.BoolFlag{
+			Name:  "remove",
+			Usage: "Remove the hold",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if ctx.NArg() != 2 {
+			return errors.New("expected two arguments: the type of item and its identifier")
+		}
+		target := core.LegalHoldTarget(ctx.Args().Get(0))
+		if !target.Valid() {
+			return fmt.Errorf("invalid item type: %s", target)
+		}
+
+		pg, err := program.NewProgram(true)
+		if err != nil {
+			return err
+		}
+		defer pg.Close()
+		return pg.SetLegalHold(target, ctx.Args().Get(1), ctx.String("note"), ctx.Bool("remove"))
+	},
+}
+
 var CommandAdmin = &cli.Command{
 	Name:  "admin",
 	Usage: "Admin commands",
@@ -367,7 +526,7 @@ var CommandMigrate = &cli.Command{
 		{
 			Name: "new",
 			Action: func(ctx *cli.Context) error {
-				folder, err := os.Open("./migrations/")
+				folder, err := os.Open(migrations.Dir)
 				if err != nil {
 					return err
 				}
@@ -401,7 +560,7 @@ var CommandMigrate = &cli.Command{
 				name = newVersion + "_" + strings.ToLower(strings.ReplaceAll(name, " ", "_"))
 				newFiles := []string{name + ".down.sql", name + ".up.sql"}
 				for _, name := range newFiles {
-					file, err := os.Create("./migrations/" + name)
+					file, err := os.Create(filepath.Join(migrations.Dir, name))
 					if err != nil {
 						return err
 					}
@@ -431,6 +590,101 @@ var CommandMigrate = &cli.Command{
 				return pg.Migrate(true, ctx.Int("steps"))
 			},
 		},
+		{
+			Name:  "up",
+			Usage: "Run the migrations not yet run",
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:  "steps",
+					Usage: "Number of migrations to run (0 runs all of them)",
+				},
+			},
+			Action: func(ctx *cli.Context) error {
+				if ctx.Int("steps") < 0 {
+					return errors.New("steps cannot be negative")
+				}
+				pg, err := program.NewProgram(true)
+				if err != nil {
+					return err
+				}
+				defer pg.Close()
+				return pg.Migrate(true, ctx.Int("steps"))
+			},
+		},
+		{
+			Name:  "down",
+			Usage: "Revert the last migrations run",
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:  "steps",
+					Value: 1,
+					Usage: "Number of migrations to revert",
+				},
+			},
+			Action: func(ctx *cli.Context) error {
+				steps := ctx.Int("steps")
+				if steps <= 0 {
+					return errors.New("steps must be positive")
+				}
+				pg, err := program.NewProgram(true)
+				if err != nil {
+					return err
+				}
+				defer pg.Close()
+				fmt.Printf("Reverting %d migration(s) may delete data.\n", steps)
+				if !YesConfirmCommand() {
+					return errors.New("cannot continue without a YES")
+				}
+				return pg.Migrate(true, -steps)
+			},
+		},
+		{
+			Name:  "status",
+			Usage: "Show the migrations run, and those not yet run",
+			Action: func(ctx *cli.Context) error {
+				pg, err := program.NewProgram(true)
+				if err != nil {
+					return err
+				}
+				defer pg.Close()
+				status, err := pg.MigrationsStatus()
+				if err != nil {
+					return err
+				}
+				for _, m := range status.Applied {
+					fmt.Printf("applied  %s\n", m)
+				}
+				for _, m := range status.Pending {
+					fmt.Printf("pending  %s\n", m)
+				}
+				dirty := ""
+				if status.Dirty {
+					dirty = " (dirty: the last migration failed; fix the database and run migrate force)"
+				}
+				fmt.Printf("Version: %d%s, %d pending\n", status.Version, dirty, len(status.Pending))
+				return nil
+			},
+		},
+		{
+			Name:      "force",
+			Usage:     "Set the migrations version, and clear the dirty flag, without running migrations",
+			ArgsUsage: "<version>",
+			Action: func(ctx *cli.Context) error {
+				version, err := strconv.Atoi(ctx.Args().First())
+				if err != nil {
+					return errors.New("a version is required")
+				}
+				pg, err := program.NewProgram(true)
+				if err != nil {
+					return err
+				}
+				defer pg.Close()
+				if !YesConfirmCommand() {
+					return errors.New("cannot continue without a YES")
+				}
+				return pg.ForceMigrationsVersion(version)
+			},
+		},
 	},
 }
 
@@ -453,6 +707,260 @@ var CommandImagePath = &cli.Command{
 	},
 }
 
+var CommandGCImages = &cli.Command{
+	Name:  "gc-images",
+	Usage: "Delete images that are not used and image files with no database record",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "List the images and files that would be deleted without deleting them",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		pg, err := program.NewProgram(true)
+		if err != nil {
+			return err
+		}
+		defer pg.Close()
+
+		dryRun := ctx.Bool("dry-run")
+		if !dryRun {
+			if ok := YesConfirmCommand(); !ok {
+				log.Fatal("Cannot continue without a YES.")
+			}
+		}
+		return pg.GarbageCollectImages(dryRun)
+	},
+}
+
+var CommandPurgeImageTrash = &cli.Command{
+	Name:  "purge-image-trash",
+	Usage: "Permanently delete the images (and the post content) that have been in the trash for longer than the retention window",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "List the images that would be deleted without deleting them",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		pg, err := program.NewProgram(true)
+		if err != nil {
+			return err
+		}
+		defer pg.Close()
+
+		dryRun := ctx.Bool("dry-run")
+		if !dryRun {
+			if ok := YesConfirmCommand(); !ok {
+				log.Fatal("Cannot continue without a YES.")
+			}
+		}
+		return pg.PurgeTrash(dryRun)
+	},
+}
+
+var CommandBench = &cli.Command{
+	Name:  "bench",
+	Usage: "Populate the database with synthetic data and report the latencies of feed reads, comment writes, and image transforms",
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "users",
+			Usage: "Number of synthetic users",
+			Value: 100,
+		},
+		&cli.IntFlag{
+			Name:  "communities",
+			Usage: "Number of synthetic communities",
+			Value: 10,
+		},
+		&cli.IntFlag{
+			Name:  "posts",
+			Usage: "Number of posts in each synthetic community",
+			Value: 100,
+		},
+		&cli.IntFlag{
+			Name:  "comments",
+			Usage: "Number of comments on each synthetic post",
+			Value: 10,
+		},
+		&cli.IntFlag{
+			Name:  "ops",
+			Usage: "Number of operations of each workload",
+			Value: 500,
+		},
+		&cli.IntFlag{
+			Name:  "concurrency",
+			Usage: "Number of operations run at a time",
+			Value: 8,
+		},
+		&cli.Int64Flag{
+			Name:  "seed",
+			Usage: "Seed of the synthetic data and of the workloads",
+			Value: 1,
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		pg, err := program.NewProgram(true)
+		if err != nil {
+			return err
+		}
+		defer pg.Close()
+
+		// Synthetic data is added to the database.
+		if ok := YesConfirmCommand(); !ok {
+			log.Fatal("Cannot continue without a YES.")
+		}
+		return pg.Bench(program.BenchOptions{
+			Users:             ctx.Int("users"),
+			Communities:       ctx.Int("communities"),
+			PostsPerCommunity: ctx.Int("posts"),
+			CommentsPerPost:   ctx.Int("comments"),
+			Ops:               ctx.Int("ops"),
+			Concurrency:       ctx.Int("concurrency"),
+			Seed:              ctx.Int64("seed"),
+		}, os.Stdout)
+	},
+}
+
+var CommandDeadJobs = &cli.Command{
+	Name:  "dead-jobs",
+	Usage: "List the delayed jobs (bot responses, survey reminders) that failed too many times to be retried",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "retry",
+			Usage: "Queue the dead jobs again",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		pg, err := program.NewProgram(true)
+		if err != nil {
+			return err
+		}
+		defer pg.Close()
+		return pg.DeadJobs(ctx.Bool("retry"))
+	},
+}
+
+var CommandMigrateImages = &cli.Command{
+	Name:  "migrate-images",
+	Usage: "Copy all images from one store to another and switch their records to the new store",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "from",
+			Usage:    "Name of the store to copy images from",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "to",
+			Usage:    "Name of the store to copy images to",
+			Required: true,
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		pg, err := program.NewProgram(true)
+		if err != nil {
+			return err
+		}
+		defer pg.Close()
+
+		if ok := YesConfirmCommand(); !ok {
+			log.Fatal("Cannot continue without a YES.")
+		}
+		return pg.MigrateImages(ctx.String("from"), ctx.String("to"))
+	},
+}
+
+var CommandImport = &cli.Command{
+	Name:  "import",
+	Usage: "Import the posts and comments of a subreddit export or a Lemmy community dump into a community",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "format",
+			Usage:    "Format of the export (reddit or lemmy)",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "community",
+			Usage:    "Name of the community to import into",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "posts",
+			Usage: "Newline-delimited JSON file of submissions (reddit)",
+		},
+		&cli.StringFlag{
+			Name:  "comments",
+			Usage: "Newline-delimited JSON file of comments (reddit)",
+		},
+		&cli.StringFlag{
+			Name:  "file",
+			Usage: "JSON file of the community dump (lemmy)",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Report what would be imported without importing anything",
+		},
+		&cli.BoolFlag{
+			Name:  "no-images",
+			Usage: "Import image posts as link posts and don't fetch link thumbnails",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		var archive *imports.Archive
+		switch ctx.String("format") {
+		case imports.SourceReddit:
+			if ctx.String("posts") == "" {
+				return errors.New("--posts is required for reddit exports")
+			}
+			posts, err := os.Open(ctx.String("posts"))
+			if err != nil {
+				return err
+			}
+			defer posts.Close()
+			var comments io.Reader
+			if name := ctx.String("comments"); name != "" {
+				file, err := os.Open(name)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				comments = file
+			}
+			if archive, err = imports.ParseReddit(posts, comments); err != nil {
+				return err
+			}
+		case imports.SourceLemmy:
+			if ctx.String("file") == "" {
+				return errors.New("--file is required for lemmy dumps")
+			}
+			file, err := os.Open(ctx.String("file"))
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			if archive, err = imports.ParseLemmy(file); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown format %q", ctx.String("format"))
+		}
+
+		pg, err := program.NewProgram(true)
+		if err != nil {
+			return err
+		}
+		defer pg.Close()
+
+		dryRun := ctx.Bool("dry-run")
+		if !dryRun {
+			if ok := YesConfirmCommand(); !ok {
+				log.Fatal("Cannot continue without a YES.")
+			}
+		}
+		return pg.Import(archive, ctx.String("community"), dryRun, !ctx.Bool("no-images"))
+	},
+}
+
 var CommandBot = &cli.Command{
 	Name:  "bot",
 	Usage: "Bot user management commands",
@@ -517,9 +1025,7 @@ var CommandBot = &cli.Command{
 					return fmt.Errorf("failed to get user: %w", err)
 				}
 
-				// Update the user's is_bot field
-				_, err = db.ExecContext(context.Background(), "UPDATE users SET is_bot = TRUE WHERE id = ?", user.ID)
-				if err != nil {
+				if err := core.PromoteToBot(context.Background(), db, user.ID); err != nil {
 					return fmt.Errorf("failed to update user: %w", err)
 				}
 
@@ -527,6 +1033,73 @@ var CommandBot = &cli.Command{
 				return nil
 			},
 		},
+		{
+			Name:  "create",
+			Usage: "Create a bot user",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "username",
+					Usage:    "Username of the bot",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:     "password",
+					Usage:    "Password of the bot",
+					Required: true,
+				},
+			},
+			Action: func(ctx *cli.Context) error {
+				pg, err := program.NewProgram(true)
+				if err != nil {
+					return err
+				}
+				defer pg.Close()
+
+				db, err := pg.OpenDatabase()
+				if err != nil {
+					return err
+				}
+
+				user, err := core.CreateBotUser(context.Background(), db, ctx.String("username"), ctx.String("password"))
+				if err != nil {
+					return err
+				}
+
+				log.Printf("Successfully created bot %s", user.Username)
+				return nil
+			},
+		},
+		{
+			Name:  "list",
+			Usage: "List bot users",
+			Action: func(ctx *cli.Context) error {
+				pg, err := program.NewProgram(true)
+				if err != nil {
+					return err
+				}
+				defer pg.Close()
+
+				db, err := pg.OpenDatabase()
+				if err != nil {
+					return err
+				}
+
+				bots, err := core.ListBotUsers(context.Background(), db)
+				if err != nil {
+					return err
+				}
+				for _, bot := range bots {
+					status := ""
+					if bot.Deleted {
+						status = " (deleted)"
+					} else if bot.Banned {
+						status = " (banned)"
+					}
+					fmt.Printf("%s%s\n", bot.Username, status)
+				}
+				return nil
+			},
+		},
 	},
 }
 
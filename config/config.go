@@ -1,12 +1,20 @@
 package config
 
 import (
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/logging"
 	"gopkg.in/yaml.v2"
 )
 
@@ -27,6 +35,14 @@ type Config struct {
 	DBPassword string `yaml:"dbPassword"`
 	DBName     string `yaml:"dbName"`
 
+	// Comma separated DSNs (as in "user:password@tcp(host:3306)/discuit") of
+	// the read replicas of the database, to which feeds and the lookups of
+	// images served are routed. Replicas that are unreachable, or that lag
+	// behind by more than DBReplicaMaxLag (a duration string, as in
+	// time.ParseDuration; if empty, lag is not checked), are not read from.
+	DBReplicas      string `yaml:"dbReplicas"`
+	DBReplicaMaxLag string `yaml:"dbReplicaMaxLag"`
+
 	SessionCookieName string `yaml:"sessionCookieName"`
 
 	RedisAddress string `yaml:"redisAddress"`
@@ -37,15 +53,137 @@ type Config struct {
 
 	NoLogToFile bool `yaml:"noLogToFile"`
 
+	// The level of logs below which they're dropped: debug, info (the
+	// default), warn, or error.
+	LogLevel string `yaml:"logLevel"`
+
+	// The format of logs: text (the default) or json (one object per line).
+	LogFormat string `yaml:"logFormat"`
+
+	// How often the config file is checked for changes, to be reloaded (like
+	// "30s"). If empty, the config is reloaded only on SIGHUP. See Watcher.
+	ConfigReloadInterval string `yaml:"configReloadInterval"`
+
 	PaginationLimit    int           `yaml:"paginationLimit"`
 	PaginationLimitMax int           `yaml:"paginationLimitMax"`
 	DefaultFeedSort    core.FeedSort `yaml:"defaultFeedSort"`
 
+	// Max number of levels of comment threads returned per request; deeper
+	// replies are fetched with the continuation tokens returned in their
+	// place. If 0, comment threads are returned whole (unless a client asks
+	// for a limit).
+	MaxCommentRenderDepth int `yaml:"maxCommentRenderDepth"`
+
+	// Time limits of API requests (like "5s"). LatencyBudgets maps routes,
+	// given as "METHOD /path/template" (like "GET /api/posts/{postID}"), to
+	// their limits; other routes get DefaultLatencyBudget (10s if empty, and
+	// no limit if "0").
+	DefaultLatencyBudget string            `yaml:"defaultLatencyBudget"`
+	LatencyBudgets       map[string]string `yaml:"latencyBudgets"`
+
+	// Size limits of API request bodies, in bytes. BodyLimits maps routes
+	// (keyed like LatencyBudgets) to their limits; image upload routes are
+	// limited to MaxImageSize (plus some overhead), and other routes to
+	// MaxBodySize (1 MiB if 0, and no limit if -1). Up to MaxMultipartMemory
+	// bytes of uploads are kept in memory; the rest is spooled to disk.
+	MaxBodySize        int            `yaml:"maxBodySize"`
+	BodyLimits         map[string]int `yaml:"bodyLimits"`
+	MaxMultipartMemory int            `yaml:"maxMultipartMemory"`
+
+	// Responses are compressed with zstd or gzip (as clients accept), unless
+	// they're smaller than CompressionMinSize bytes (1 KiB if 0, and no
+	// responses are compressed if -1), of content types that don't compress
+	// (like images), or of UncompressedRoutes (keyed like LatencyBudgets),
+	// which add to the built-in ones.
+	CompressionMinSize int      `yaml:"compressionMinSize"`
+	UncompressedRoutes []string `yaml:"uncompressedRoutes"`
+
+	// The CORS policy of the API. Public reads (GET requests, except those of
+	// CORSPrivateRoutes, keyed like LatencyBudgets) are allowed from
+	// CORSPublicOrigins (a comma separated list of origins, like
+	// "https://example.com", or "*" for all origins), without credentials.
+	// All requests of CORSMethods are allowed from CORSOrigins (a comma
+	// separated list of origins), with credentials (the cookies of users) if
+	// CORSCredentials is set. Browsers may cache preflight responses for
+	// CORSMaxAge.
+	CORSPublicOrigins string   `yaml:"corsPublicOrigins"`
+	CORSPrivateRoutes []string `yaml:"corsPrivateRoutes"`
+	CORSOrigins       string   `yaml:"corsOrigins"`
+	CORSMethods       string   `yaml:"corsMethods"`
+	CORSCredentials   bool     `yaml:"corsCredentials"`
+	CORSMaxAge        string   `yaml:"corsMaxAge"`
+
+	// Rate limits of the tiers of admin-issued API keys. APIKeyTiers maps
+	// tier names to comma separated lists of rate limits of the form
+	// "requests/interval" (like "60/1m,10000/24h"), and overrides, or adds
+	// to, the built-in tiers: basic, research, and archive.
+	APIKeyTiers map[string]string `yaml:"apiKeyTiers"`
+
+	// External authentication, for private deployments. If AuthBackend is
+	// "ldap" or "saml", users log in with their accounts in the directory or
+	// at the identity provider, and Discuit accounts are created for them on
+	// their first login (signups are disabled). If AuthLocalLogin is true,
+	// local accounts (say, of admins) can still log in with their passwords.
+	// The Auth*Attribute fields name the attributes (of LDAP entries or of
+	// SAML assertions) that usernames, emails, and profile descriptions are
+	// taken from; if AuthUsernameAttribute is empty, the LDAP login name or
+	// the SAML NameID is used.
+	AuthBackend           string `yaml:"authBackend"`
+	AuthLocalLogin        bool   `yaml:"authLocalLogin"`
+	AuthUsernameAttribute string `yaml:"authUsernameAttribute"`
+	AuthEmailAttribute    string `yaml:"authEmailAttribute"`
+	AuthAboutAttribute    string `yaml:"authAboutAttribute"`
+
+	// LDAP: users are looked up under LDAPBaseDN by LDAPUserAttribute (like
+	// uid, or sAMAccountName), as LDAPBindDN if it's set (and anonymously
+	// otherwise), and their passwords are checked by binding as them.
+	// LDAPURL is of the form ldap://host[:port] or ldaps://host[:port].
+	LDAPURL                string `yaml:"ldapURL"`
+	LDAPBindDN             string `yaml:"ldapBindDN"`
+	LDAPBindPassword       string `yaml:"ldapBindPassword"`
+	LDAPBaseDN             string `yaml:"ldapBaseDN"`
+	LDAPUserAttribute      string `yaml:"ldapUserAttribute"`
+	LDAPInsecureSkipVerify bool   `yaml:"ldapInsecureSkipVerify"`
+
+	// SAML: SAMLIdPMetadataFile is the metadata (XML) of the identity
+	// provider, and SAMLBaseURL the public URL of this site (like
+	// https://discuit.example.edu), which the URLs of the service provider
+	// are under. The metadata of the service provider is served at
+	// /api/_saml/metadata, which is also its entity ID unless SAMLEntityID
+	// is set.
+	SAMLIdPMetadataFile string `yaml:"samlIdPMetadataFile"`
+	SAMLBaseURL         string `yaml:"samlBaseURL"`
+	SAMLEntityID        string `yaml:"samlEntityID"`
+
 	// Captcha verification is skipped if empty.
 	CaptchaSecret string `yaml:"captchaSecret"`
 
-	CertFile string `yaml:"certFile"`
-	KeyFile  string `yaml:"keyFile"`
+	// The site is served over HTTPS (with HTTP/2) if CertFile and KeyFile are
+	// set, or if ACMEDomains (a comma separated list of domains) is set, in
+	// which case certificates for the domains are obtained (and renewed) from
+	// the ACME server at ACMEDirectoryURL (Let's Encrypt if empty), whose
+	// terms of service are taken to be accepted, with ACMEEmail as the
+	// contact of the account. Certificates are cached in ACMECacheDir.
+	CertFile         string `yaml:"certFile"`
+	KeyFile          string `yaml:"keyFile"`
+	ACMEDomains      string `yaml:"acmeDomains"`
+	ACMEEmail        string `yaml:"acmeEmail"`
+	ACMECacheDir     string `yaml:"acmeCacheDir"`
+	ACMEDirectoryURL string `yaml:"acmeDirectoryURL"`
+
+	// HTTPS sites redirect HTTP requests on HTTPRedirectAddr (":80" if empty
+	// and the site is served on port 443) to HTTPS. HTTP-01 challenges of
+	// ACME are answered on it too (TLS-ALPN-01 challenges are answered on
+	// Addr).
+	HTTPRedirectAddr string `yaml:"httpRedirectAddr"`
+
+	// If HSTSMaxAge is set (like "8760h"), responses of HTTPS sites have a
+	// Strict-Transport-Security header, with includeSubDomains if
+	// HSTSIncludeSubdomains is set, and preload if HSTSPreload is (which
+	// requires a max age of at least a year and HSTSIncludeSubdomains).
+	HSTSMaxAge            string `yaml:"hstsMaxAge"`
+	HSTSIncludeSubdomains bool   `yaml:"hstsIncludeSubdomains"`
+	HSTSPreload           bool   `yaml:"hstsPreload"`
 
 	DisableRateLimits bool `yaml:"disableRateLimits"`
 	MaxImageSize      int  `yaml:"maxImageSize"`
@@ -54,6 +192,20 @@ type Config struct {
 	// where value is AdminAPIKey, rate limits are disabled.
 	AdminAPIKey string `yaml:"adminAPIKey"`
 
+	// If not empty, the metrics of the site are served at /metrics, in the
+	// format of Prometheus, to requests with the header 'Authorization:
+	// Bearer value', where value is MetricsToken.
+	MetricsToken string `yaml:"metricsToken"`
+
+	// If TracingEndpoint is set (to the base URL of the OTLP/HTTP receiver of
+	// an OpenTelemetry collector, say http://localhost:4318), requests, and
+	// the queries, S3 operations, image transforms, and LLM calls made for
+	// them, are traced, and the spans are exported to it. A TracingSampleRatio
+	// of the traces are recorded, as of the service TracingServiceName.
+	TracingEndpoint    string  `yaml:"tracingEndpoint"`
+	TracingSampleRatio float64 `yaml:"tracingSampleRatio"`
+	TracingServiceName string  `yaml:"tracingServiceName"`
+
 	DisableImagePosts bool `yaml:"disableImagePosts"`
 
 	DisableForumCreation   bool `yaml:"disableForumCreation"`   // If true, only admins can create communities.
@@ -63,6 +215,43 @@ type Config struct {
 	// The location where images are saved on disk.
 	ImagesFolderPath string `yaml:"imagesFolderPath"`
 
+	// Max total size, in bytes, of the transformed images cached in the
+	// images folder; the least recently used ones are evicted beyond it. If
+	// 0, the cache is not limited in size.
+	ImageCacheMaxSize int `yaml:"imageCacheMaxSize"`
+
+	// Default upload quotas, in bytes, of users and of communities: the total
+	// size of the images each can upload (see images.SetDefaultQuota). If 0,
+	// uploads are not limited. Admins can override the quotas of individual
+	// users and communities.
+	UserImageQuota      int `yaml:"userImageQuota"`
+	CommunityImageQuota int `yaml:"communityImageQuota"`
+
+	// If set (like "24h"), the signed URLs of images expire after about this
+	// long, and expired URLs are still accepted for ImageURLExpiryGrace. By
+	// default image URLs never expire.
+	ImageURLTTL         string `yaml:"imageURLTTL"`
+	ImageURLExpiryGrace string `yaml:"imageURLExpiryGrace"`
+
+	// If ImageAccessLog is set, the requests for images are logged, as JSON
+	// lines, to ./logs/images.log (or to stdout, if NoLogToFile is set). Only
+	// a sample of them is logged if ImageAccessLogSampleRate is less than 1.
+	ImageAccessLog           bool    `yaml:"imageAccessLog"`
+	ImageAccessLogSampleRate float64 `yaml:"imageAccessLogSampleRate"`
+
+	// If set (comma separated hosts, like "example.com,*.example.org"), the
+	// images of the site cannot be embedded in the pages of other hosts:
+	// they're denied, with the image at ImageHotlinkPlaceholder (a file path)
+	// or a blank image served instead. Requests without a referrer are always
+	// allowed.
+	ImageHotlinkAllowedReferrers string `yaml:"imageHotlinkAllowedReferrers"`
+	ImageHotlinkPlaceholder      string `yaml:"imageHotlinkPlaceholder"`
+
+	// If set, the bytes served for images are counted, per image and per
+	// day, and rolled up per user and per community (for the admin
+	// dashboard). It's enabled by default.
+	BandwidthAccounting bool `yaml:"bandwidthAccounting"`
+
 	// S3 configuration
 	S3Enabled      bool   `yaml:"s3Enabled"`
 	S3Region       string `yaml:"s3Region"`
@@ -72,8 +261,274 @@ type Config struct {
 	S3Endpoint     string `yaml:"s3Endpoint"` // Optional custom endpoint for S3-compatible services
 	S3PathPrefix   string `yaml:"s3PathPrefix"` // Optional prefix for all S3 paths
 
+	// Failed S3 requests are retried, with exponential backoff of at most
+	// S3MaxBackoff, until S3MaxAttempts attempts are made. S3RequestTimeout
+	// is the time limit of each S3 operation, retries included.
+	S3MaxAttempts    int    `yaml:"s3MaxAttempts"`
+	S3MaxBackoff     string `yaml:"s3MaxBackoff"`
+	S3RequestTimeout string `yaml:"s3RequestTimeout"`
+
+	// If set, requests for original images saved in S3 are redirected to S3
+	// instead of being served by this server. If S3RedirectMode is "presign",
+	// they're redirected to presigned URLs valid for S3PresignTTL. If it's
+	// "cdn", they're redirected to the object under S3CDNBaseURL.
+	S3RedirectMode string `yaml:"s3RedirectMode"`
+	S3PresignTTL   string `yaml:"s3PresignTTL"`
+	S3CDNBaseURL   string `yaml:"s3CDNBaseURL"`
+
+	// Google Cloud Storage configuration. The gcs store is registered if
+	// GCSBucket is set. GCSCredentialsFile is the JSON key file of a service
+	// account; it may be empty if GCSEndpoint is set to that of an emulator.
+	GCSBucket          string `yaml:"gcsBucket"`
+	GCSCredentialsFile string `yaml:"gcsCredentialsFile"`
+	GCSEndpoint        string `yaml:"gcsEndpoint"`
+	GCSPathPrefix      string `yaml:"gcsPathPrefix"`
+
+	// Azure Blob Storage configuration. The azure store is registered if
+	// AzureContainer is set (the container is created if it doesn't exist).
+	// AzureEndpoint defaults to https://<account>.blob.core.windows.net.
+	AzureAccount    string `yaml:"azureAccount"`
+	AzureAccountKey string `yaml:"azureAccountKey"`
+	AzureContainer  string `yaml:"azureContainer"`
+	AzureEndpoint   string `yaml:"azureEndpoint"`
+	AzurePathPrefix string `yaml:"azurePathPrefix"`
+
+	// The backend new images are saved to: disk, s3, gcs, or azure. If it's
+	// s3, S3Enabled is implied. If empty, it's s3 if S3 is enabled, and disk
+	// otherwise. ImagesStore, if set, takes precedence.
+	StorageBackend string `yaml:"storageBackend"`
+
+	// The name of the store new images are saved to. If empty, it's s3 if S3
+	// is enabled, and disk otherwise. The store must be registered (with
+	// images.RegisterStore) before the program starts.
+	ImagesStore string `yaml:"imagesStore"`
+
+	// Comma separated names of the stores that images saved to ImagesStore
+	// are replicated to. Images that cannot be read from ImagesStore are read
+	// from these.
+	ImagesReplicaStores string `yaml:"imagesReplicaStores"`
+
+	// Comma separated names of the stores that images that cannot be read
+	// from ImagesStore (or its replicas) are read from, but that are not
+	// written to; say, the disk store while images are being moved from disk
+	// to S3 (see images.SetFallbacks).
+	ImagesFallbackStores string `yaml:"imagesFallbackStores"`
+
 	MaxImagesPerPost int `yaml:"maxImagesPerPost"`
 
+	// Variants (sizes, fits, and formats) of uploaded images are generated in
+	// the background, by ImageJobWorkers workers, so that they're cached
+	// before they're first requested. ImageVariants are of the form
+	// "SIZE[:FIT[:FORMAT]]", like "325x250:cover" (see images.ParseVariant);
+	// if empty, the sizes of post images are used. If ImageJobWorkers is 0,
+	// variants are only generated on request.
+	ImageJobWorkers int      `yaml:"imageJobWorkers"`
+	ImageVariants   []string `yaml:"imageVariants"`
+
+	// The thumbnails of the images of the first ImagePrefetchFrontPage posts
+	// of the front page, and of the new posts of communities with at least
+	// ImagePrefetchMinMembers members, are generated ahead of their first
+	// request by ImagePrefetchWorkers workers, and, if ImagePrefetchWarmURLs,
+	// requested by their URLs, so that the CDN in front of the site caches
+	// them. If ImagePrefetchWorkers is 0, images are not prefetched.
+	ImagePrefetchWorkers    int  `yaml:"imagePrefetchWorkers"`
+	ImagePrefetchFrontPage  int  `yaml:"imagePrefetchFrontPage"`
+	ImagePrefetchMinMembers int  `yaml:"imagePrefetchMinMembers"`
+	ImagePrefetchWarmURLs   bool `yaml:"imagePrefetchWarmURLs"`
+
+	// Images posted in a community are checked against those posted in it in
+	// the last ImageDuplicateDays days, by their perceptual hashes: images
+	// whose hashes differ in at most ImageDuplicateDistance bits are
+	// near-duplicates, and are, per ImageDuplicateAction, either let through
+	// with a warning ("warn"), rejected ("block"), or not checked for at all
+	// ("off").
+	ImageDuplicateAction   string `yaml:"imageDuplicateAction"`
+	ImageDuplicateDays     int    `yaml:"imageDuplicateDays"`
+	ImageDuplicateDistance int    `yaml:"imageDuplicateDistance"`
+
+	// Delayed jobs, like bot responses to posts and comments and survey
+	// reminders, are queued in Redis, to be run by JobWorkers workers. If
+	// JobWorkers is 0, jobs are delayed in memory, and are lost on restarts.
+	JobWorkers int `yaml:"jobWorkers"`
+
+	// The changes to the vote counters of posts that get at least
+	// VoteBufferThreshold votes a minute are buffered in Redis, and written to
+	// the database every VoteBufferFlushInterval (a duration), so that the
+	// votes on viral posts don't all wait on the row of the post. If
+	// VoteBufferThreshold is 0, votes are not buffered.
+	VoteBufferThreshold     int    `yaml:"voteBufferThreshold"`
+	VoteBufferFlushInterval string `yaml:"voteBufferFlushInterval"`
+
+	// If set (a duration), the pages of the feeds of logged out users, and
+	// the posts in them, are cached in Redis for FeedCacheTTL. Pages are
+	// invalidated as posts are added to and removed from their feeds; votes
+	// and comments show once they expire.
+	FeedCacheTTL string `yaml:"feedCacheTTL"`
+
+	// Posts and comments are searched with the FULLTEXT indexes of the
+	// database, unless SearchBackend is "meilisearch", in which case they're
+	// indexed in, and searched with, the Meilisearch server at MeilisearchURL,
+	// in indexes whose names begin with MeilisearchIndexPrefix. After
+	// switching to Meilisearch, run the search-reindex command to index the
+	// posts and comments that exist.
+	SearchBackend          string `yaml:"searchBackend"`
+	MeilisearchURL         string `yaml:"meilisearchURL"`
+	MeilisearchAPIKey      string `yaml:"meilisearchAPIKey"`
+	MeilisearchIndexPrefix string `yaml:"meilisearchIndexPrefix"`
+
+	// Emails (to verify email addresses, to reset passwords, and weekly
+	// digests) are sent if EmailBackend is "smtp", through the SMTP server at
+	// SMTPAddr, or "ses", through Amazon SES in SESRegion (with SESAccessKey
+	// and SESSecretKey, or if they're empty, the default AWS credentials).
+	// They're sent from EmailFrom, with links to SiteURL (the public URL of
+	// this site). Weekly digests are sent to the users who opt in to them,
+	// if EmailDigests is set (which it is by default).
+	EmailBackend string `yaml:"emailBackend"`
+	EmailFrom    string `yaml:"emailFrom"`
+	SiteURL      string `yaml:"siteURL"`
+	SMTPAddr     string `yaml:"smtpAddr"`
+	SMTPUsername string `yaml:"smtpUsername"`
+	SMTPPassword string `yaml:"smtpPassword"`
+	SESRegion    string `yaml:"sesRegion"`
+	SESAccessKey string `yaml:"sesAccessKey"`
+	SESSecretKey string `yaml:"sesSecretKey"`
+	EmailDigests bool   `yaml:"emailDigests"`
+
+	// The OpenAI API usage of bots is limited to BotMaxRequestsPerMinute
+	// requests a minute, BotMaxTokensPerDay tokens a day, and a cost of
+	// BotMaxCostPerDay (in US dollars) a day, with tokens priced at
+	// BotInputTokenPrice and BotOutputTokenPrice (in US dollars per million
+	// tokens). Bots skip what they would do while a limit is reached. A limit
+	// of 0 is no limit.
+	BotMaxRequestsPerMinute int     `yaml:"botMaxRequestsPerMinute"`
+	BotMaxTokensPerDay      int     `yaml:"botMaxTokensPerDay"`
+	BotMaxCostPerDay        float64 `yaml:"botMaxCostPerDay"`
+	BotInputTokenPrice      float64 `yaml:"botInputTokenPrice"`
+	BotOutputTokenPrice     float64 `yaml:"botOutputTokenPrice"`
+
+	// The templates of the prompts bots are given (see core/prompts) are
+	// overridden by the templates of the same name in BotPromptsDir, if it's
+	// set. Edits to them are picked up while the site is running.
+	BotPromptsDir string `yaml:"botPromptsDir"`
+
+	// How the bot that posts or comments is picked: uniformly at random if
+	// empty, or weighted towards the bots that were the least ("least_active")
+	// or the most ("most_active") active in the last day.
+	BotUserWeighting string `yaml:"botUserWeighting"`
+
+	// If true, bots run dry in every community: they go through the motions,
+	// but only record what they would post (see core.BotAction).
+	BotDryRun bool `yaml:"botDryRun"`
+
+	// The bot scheduler posts in communities every BotScheduleInterval (a
+	// duration string, as in time.ParseDuration), from BotScheduleStartHour
+	// to BotScheduleEndHour (exclusive, and possibly past midnight) of every
+	// day in the time zone BotScheduleTimezone (an IANA time zone name). The
+	// communities due in an interval are posted in, in BotScheduleBatches
+	// batches, and in up to BotScheduleConcurrency communities of a batch at
+	// a time.
+	BotScheduleTimezone    string `yaml:"botScheduleTimezone"`
+	BotScheduleStartHour   int    `yaml:"botScheduleStartHour"`
+	BotScheduleEndHour     int    `yaml:"botScheduleEndHour"`
+	BotScheduleInterval    string `yaml:"botScheduleInterval"`
+	BotScheduleBatches     int    `yaml:"botScheduleBatches"`
+	BotScheduleConcurrency int    `yaml:"botScheduleConcurrency"`
+
+	// If BotVotes, bots vote on recent posts, every BotVoteInterval (a
+	// duration string), casting up to BotVotesPerRound votes a round, and up
+	// to BotMaxVotesPerHour votes a bot an hour. Bots upvote posts with a
+	// chance of BotUpvoteChance, and downvote them with a chance of
+	// BotDownvoteChance, adjusted for the toxicity of communities and the
+	// personas of bots (see core.BotVoting).
+	BotVotes           bool    `yaml:"botVotes"`
+	BotVoteInterval    string  `yaml:"botVoteInterval"`
+	BotVotesPerRound   int     `yaml:"botVotesPerRound"`
+	BotMaxVotesPerHour int     `yaml:"botMaxVotesPerHour"`
+	BotUpvoteChance    float64 `yaml:"botUpvoteChance"`
+	BotDownvoteChance  float64 `yaml:"botDownvoteChance"`
+
+	// The posts bots write are checked for similarity to the posts bots
+	// wrote recently in the same community, by their embeddings, if
+	// BotEmbedder is "openai" (embedded by BotEmbeddingModel) or "local"
+	// (hashed locally, catching reworded copies only). Posts with a cosine
+	// similarity of at least BotSimilarityThreshold to a recent post are
+	// written again, up to BotSimilarityRetries times, and then skipped.
+	BotEmbedder            string  `yaml:"botEmbedder"`
+	BotEmbeddingModel      string  `yaml:"botEmbeddingModel"`
+	BotSimilarityThreshold float64 `yaml:"botSimilarityThreshold"`
+	BotSimilarityRetries   int     `yaml:"botSimilarityRetries"`
+
+	// Resized copies of images can only be requested in the sizes and fits of
+	// the copies the API hands out (see images.DefaultAllowedVariants), of
+	// ImageVariants, and of ImageSizeWhitelist (of the same form as
+	// ImageVariants).
+	ImageSizeWhitelist []string `yaml:"imageSizeWhitelist"`
+
+	// Variants of images (of the same form as ImageVariants) that can be
+	// requested without a signature, by URLs like
+	// "/images/{ID}.webp?size=120x120&fit=cover" (see images.UnsignedURL).
+	// They can be requested even if they're in no other list.
+	ImageUnsignedPresets []string `yaml:"imageUnsignedPresets"`
+
+	// Uploaded images are checked by AWS Rekognition, if RekognitionEnabled,
+	// and by the NSFW classification endpoint at NSFWEndpoint, if set, and
+	// are rejected if found objectionable. Rekognition rejects images for
+	// which any of RekognitionRejectLabels (moderation labels, like
+	// "Explicit Nudity") is detected with a confidence of at least
+	// RekognitionMinConfidence (a percentage). If RekognitionAccessKey is
+	// empty, the S3 credentials are used. The NSFW endpoint rejects images
+	// with a score of at least NSFWThreshold (a percentage). If
+	// ImageModerationFailOpen is true, images are accepted when they couldn't
+	// be checked; otherwise, their upload fails.
+	RekognitionEnabled       bool     `yaml:"rekognitionEnabled"`
+	RekognitionRegion        string   `yaml:"rekognitionRegion"`
+	RekognitionAccessKey     string   `yaml:"rekognitionAccessKey"`
+	RekognitionSecretKey     string   `yaml:"rekognitionSecretKey"`
+	RekognitionMinConfidence int      `yaml:"rekognitionMinConfidence"`
+	RekognitionRejectLabels  []string `yaml:"rekognitionRejectLabels"`
+	NSFWEndpoint             string   `yaml:"nsfwEndpoint"`
+	NSFWThreshold            int      `yaml:"nsfwThreshold"`
+	ImageModerationFailOpen  bool     `yaml:"imageModerationFailOpen"`
+	ImageModerationTimeout   string   `yaml:"imageModerationTimeout"`
+
+	// If RekognitionFaces is true, the faces AWS Rekognition detects in
+	// uploaded images are the focal points that cover-fit thumbnails of the
+	// images are cropped toward (otherwise, the busiest parts of images are).
+	// It takes the region and credentials of the Rekognition config above,
+	// whether RekognitionEnabled is true or not.
+	RekognitionFaces bool `yaml:"rekognitionFaces"`
+
+	// Posts and comments deleted by their authors are purged (their content
+	// permanently erased) this many days after deletion. If 0, deleted
+	// content is never purged.
+	PurgeDeletedContentDays int `yaml:"purgeDeletedContentDays"`
+
+	// Deleted images are kept in the trash (see images.TrashRetention), along
+	// with the content of posts deleted with it, for this many days before
+	// they're purged, so that admins can restore deleted posts. If 0, images
+	// are deleted right away.
+	ImageTrashDays int `yaml:"imageTrashDays"`
+
+	// Push notifications for native mobile apps. Firebase Cloud Messaging is
+	// enabled if FCMCredentialsFile (a service account JSON key) is set, and
+	// APNs is enabled if APNsKeyFile (a .p8 key) is set.
+	FCMCredentialsFile string `yaml:"fcmCredentialsFile"`
+	FCMProjectID       string `yaml:"fcmProjectID"` // Optional; defaults to the project of the service account.
+	APNsKeyFile        string `yaml:"apnsKeyFile"`
+	APNsKeyID          string `yaml:"apnsKeyID"`
+	APNsTeamID         string `yaml:"apnsTeamID"`
+	APNsTopic          string `yaml:"apnsTopic"` // The bundle ID of the iOS app.
+	APNsProduction     bool   `yaml:"apnsProduction"`
+
+	// Web push notifications are sent with this VAPID key-pair (base64url
+	// encoded, as generated by webpush.GenerateVAPIDKeys). If it's not set, a
+	// key-pair is generated and stored in the database, and web push
+	// notifications are disabled in development. VAPIDEmail is the contact
+	// address push services are given (EmailContact, if it's empty).
+	VAPIDPublicKey  string `yaml:"vapidPublicKey"`
+	VAPIDPrivateKey string `yaml:"vapidPrivateKey"`
+	VAPIDEmail      string `yaml:"vapidEmail"`
+
 	// For the front-end:
 	CaptchaSiteKey string `yaml:"captchaSiteKey"`
 	EmailContact   string `yaml:"emailContact"`
@@ -91,15 +546,67 @@ type Config struct {
 func Parse(path string) (*Config, error) {
 	c := &Config{
 		// Default values.
-		Addr:               ":8080",
-		DBUser:             "discuit",
-		SessionCookieName:  "SID",
-		RedisAddress:       ":6379",
-		PaginationLimit:    10,
-		PaginationLimitMax: 50,
-		DefaultFeedSort:    core.FeedSortHot,
-		MaxImageSize:       25 * (1 << 20),
-		MaxImagesPerPost:   10,
+		Addr:                    ":8080",
+		DBUser:                  "discuit",
+		SessionCookieName:       "SID",
+		RedisAddress:            ":6379",
+		PaginationLimit:         10,
+		PaginationLimitMax:      50,
+		DefaultFeedSort:         core.FeedSortHot,
+		MaxImageSize:            25 * (1 << 20),
+		UserImageQuota:          500 * (1 << 20),
+		MaxImagesPerPost:        10,
+		MaxMultipartMemory:      1 << 20,
+		ImageURLExpiryGrace:     "5m",
+		ImageJobWorkers:         2,
+		ImagePrefetchWorkers:    2,
+		ImagePrefetchFrontPage:  25,
+		ImagePrefetchMinMembers: 1000,
+		ImageDuplicateAction:    "warn",
+		ImageDuplicateDays:      30,
+		ImageDuplicateDistance:  8,
+		JobWorkers:              2,
+		VoteBufferFlushInterval: "5s",
+		BotMaxRequestsPerMinute: 30,
+		BotInputTokenPrice:      0.15,
+		BotOutputTokenPrice:     0.60,
+		BotScheduleTimezone:     "America/Los_Angeles",
+		BotScheduleStartHour:    9,
+		BotScheduleEndHour:      21,
+		BotScheduleInterval:     "24m",
+		BotScheduleBatches:      12,
+		BotScheduleConcurrency:  4,
+		BotVoteInterval:         "5m",
+		BotVotesPerRound:        20,
+		BotMaxVotesPerHour:      10,
+		BotUpvoteChance:         0.3,
+		BotDownvoteChance:       0.05,
+		BotEmbeddingModel:       "text-embedding-3-small",
+		BotSimilarityThreshold:  0.9,
+		BotSimilarityRetries:    1,
+		S3MaxAttempts:           3,
+		S3MaxBackoff:            "20s",
+		S3RequestTimeout:        "1m",
+		S3PresignTTL:            "15m",
+		AuthLocalLogin:          true,
+		LDAPUserAttribute:       "uid",
+
+		RekognitionMinConfidence: 80,
+		RekognitionRejectLabels:  []string{"Explicit Nudity", "Explicit"},
+		NSFWThreshold:            80,
+		ImageModerationTimeout:   "30s",
+		ImageAccessLogSampleRate: 1,
+		TracingSampleRatio:       1,
+		TracingServiceName:       "discuit",
+		DBReplicaMaxLag:          "30s",
+		LogLevel:                 "info",
+		LogFormat:                logging.FormatText,
+		ACMECacheDir:             "acme",
+		CORSPublicOrigins:        "*",
+		CORSMethods:              "GET, HEAD, POST, PUT, DELETE",
+		CORSMaxAge:               "1h",
+		BandwidthAccounting:      true,
+		EmailDigests:             true,
 
 		// Required fields:
 		ForumCreationReqPoints: -1,
@@ -126,6 +633,9 @@ func Parse(path string) (*Config, error) {
 		"DISCUIT_DB_PASSWORD": &c.DBPassword,
 		"DISCUIT_DB_NAME":     &c.DBName,
 
+		"DISCUIT_DB_REPLICAS":        &c.DBReplicas,
+		"DISCUIT_DB_REPLICA_MAX_LAG": &c.DBReplicaMaxLag,
+
 		"DISCUIT_SESSION_COOKIE_NAME": &c.SessionCookieName,
 
 		"DISCUIT_REDIS_ADDRESS": &c.RedisAddress,
@@ -135,22 +645,70 @@ func Parse(path string) (*Config, error) {
 		"DISCUIT_CSRF_OFF": &c.CSRFOff,
 
 		"DISCUIT_NO_LOG_TO_FILE": &c.NoLogToFile,
+		"DISCUIT_LOG_LEVEL":      &c.LogLevel,
+		"DISCUIT_LOG_FORMAT":     &c.LogFormat,
+
+		"DISCUIT_CONFIG_RELOAD_INTERVAL": &c.ConfigReloadInterval,
 
 		"DISCUIT_PAGINATION_LIMIT":     &c.PaginationLimit,
 		"DISCUIT_PAGINATION_LIMIT_MAX": &c.PaginationLimitMax,
 		"DISCUIT_DEFAULT_FEED_SORT":    &c.DefaultFeedSort,
 
+		"DISCUIT_MAX_COMMENT_RENDER_DEPTH": &c.MaxCommentRenderDepth,
+
+		"DISCUIT_DEFAULT_LATENCY_BUDGET": &c.DefaultLatencyBudget,
+		"DISCUIT_MAX_BODY_SIZE":          &c.MaxBodySize,
+		"DISCUIT_MAX_MULTIPART_MEMORY":   &c.MaxMultipartMemory,
+		"DISCUIT_COMPRESSION_MIN_SIZE":   &c.CompressionMinSize,
+
+		"DISCUIT_AUTH_BACKEND":            &c.AuthBackend,
+		"DISCUIT_AUTH_LOCAL_LOGIN":        &c.AuthLocalLogin,
+		"DISCUIT_AUTH_USERNAME_ATTRIBUTE": &c.AuthUsernameAttribute,
+		"DISCUIT_AUTH_EMAIL_ATTRIBUTE":    &c.AuthEmailAttribute,
+		"DISCUIT_AUTH_ABOUT_ATTRIBUTE":    &c.AuthAboutAttribute,
+
+		"DISCUIT_LDAP_URL":                  &c.LDAPURL,
+		"DISCUIT_LDAP_BIND_DN":              &c.LDAPBindDN,
+		"DISCUIT_LDAP_BIND_PASSWORD":        &c.LDAPBindPassword,
+		"DISCUIT_LDAP_BASE_DN":              &c.LDAPBaseDN,
+		"DISCUIT_LDAP_USER_ATTRIBUTE":       &c.LDAPUserAttribute,
+		"DISCUIT_LDAP_INSECURE_SKIP_VERIFY": &c.LDAPInsecureSkipVerify,
+
+		"DISCUIT_SAML_IDP_METADATA_FILE": &c.SAMLIdPMetadataFile,
+		"DISCUIT_SAML_BASE_URL":          &c.SAMLBaseURL,
+		"DISCUIT_SAML_ENTITY_ID":         &c.SAMLEntityID,
+
 		// Captcha verification is skipped if empty.
 		"DISCUIT_CAPTCHA_SECRET": &c.CaptchaSecret,
 		"DISCUIT_CERT_FILE":      &c.CertFile,
 		"DISCUIT_KEY_FILE":       &c.KeyFile,
 
+		"DISCUIT_ACME_DOMAINS":            &c.ACMEDomains,
+		"DISCUIT_ACME_EMAIL":              &c.ACMEEmail,
+		"DISCUIT_ACME_CACHE_DIR":          &c.ACMECacheDir,
+		"DISCUIT_ACME_DIRECTORY_URL":      &c.ACMEDirectoryURL,
+		"DISCUIT_HTTP_REDIRECT_ADDR":      &c.HTTPRedirectAddr,
+		"DISCUIT_HSTS_MAX_AGE":            &c.HSTSMaxAge,
+		"DISCUIT_HSTS_INCLUDE_SUBDOMAINS": &c.HSTSIncludeSubdomains,
+		"DISCUIT_HSTS_PRELOAD":            &c.HSTSPreload,
+
+		"DISCUIT_CORS_PUBLIC_ORIGINS": &c.CORSPublicOrigins,
+		"DISCUIT_CORS_ORIGINS":        &c.CORSOrigins,
+		"DISCUIT_CORS_METHODS":        &c.CORSMethods,
+		"DISCUIT_CORS_CREDENTIALS":    &c.CORSCredentials,
+		"DISCUIT_CORS_MAX_AGE":        &c.CORSMaxAge,
+
 		"DISCUIT_DISABLE_RATE_LIMITS": &c.DisableRateLimits,
 		"DISCUIT_MAX_IMAGE_SIZE":      &c.MaxImageSize,
 
 		// If API requests have a URL query parameter of the form 'adminKey=value',
 		// where value is AdminApiKey, rate limits are disabled.
 		"DISCUIT_ADMIN_API_KEY": &c.AdminAPIKey,
+		"DISCUIT_METRICS_TOKEN": &c.MetricsToken,
+
+		"DISCUIT_TRACING_ENDPOINT":     &c.TracingEndpoint,
+		"DISCUIT_TRACING_SAMPLE_RATIO": &c.TracingSampleRatio,
+		"DISCUIT_TRACING_SERVICE_NAME": &c.TracingServiceName,
 
 		"DISCUIT_DISABLE_IMAGE_POSTS": &c.DisableImagePosts,
 
@@ -160,6 +718,16 @@ func Parse(path string) (*Config, error) {
 
 		// The location where images are saved on disk.
 		"DISCUIT_IMAGES_FOLDER_PATH": &c.ImagesFolderPath,
+		"DISCUIT_IMAGE_CACHE_MAX_SIZE": &c.ImageCacheMaxSize,
+		"DISCUIT_USER_IMAGE_QUOTA":      &c.UserImageQuota,
+		"DISCUIT_COMMUNITY_IMAGE_QUOTA": &c.CommunityImageQuota,
+		"DISCUIT_IMAGE_URL_TTL":          &c.ImageURLTTL,
+		"DISCUIT_IMAGE_URL_EXPIRY_GRACE": &c.ImageURLExpiryGrace,
+		"DISCUIT_IMAGE_ACCESS_LOG":                &c.ImageAccessLog,
+		"DISCUIT_IMAGE_ACCESS_LOG_SAMPLE_RATE":    &c.ImageAccessLogSampleRate,
+		"DISCUIT_IMAGE_HOTLINK_ALLOWED_REFERRERS": &c.ImageHotlinkAllowedReferrers,
+		"DISCUIT_IMAGE_HOTLINK_PLACEHOLDER":       &c.ImageHotlinkPlaceholder,
+		"DISCUIT_BANDWIDTH_ACCOUNTING":            &c.BandwidthAccounting,
 
 		// S3 configuration
 		"DISCUIT_S3_ENABLED":    &c.S3Enabled,
@@ -169,6 +737,107 @@ func Parse(path string) (*Config, error) {
 		"DISCUIT_S3_SECRET_KEY": &c.S3SecretKey,
 		"DISCUIT_S3_ENDPOINT":   &c.S3Endpoint,
 		"DISCUIT_S3_PATH_PREFIX": &c.S3PathPrefix,
+		"DISCUIT_S3_MAX_ATTEMPTS":    &c.S3MaxAttempts,
+		"DISCUIT_S3_MAX_BACKOFF":     &c.S3MaxBackoff,
+		"DISCUIT_S3_REQUEST_TIMEOUT": &c.S3RequestTimeout,
+		"DISCUIT_S3_REDIRECT_MODE":   &c.S3RedirectMode,
+		"DISCUIT_S3_PRESIGN_TTL":     &c.S3PresignTTL,
+		"DISCUIT_S3_CDN_BASE_URL":    &c.S3CDNBaseURL,
+
+		"DISCUIT_GCS_BUCKET":           &c.GCSBucket,
+		"DISCUIT_GCS_CREDENTIALS_FILE": &c.GCSCredentialsFile,
+		"DISCUIT_GCS_ENDPOINT":         &c.GCSEndpoint,
+		"DISCUIT_GCS_PATH_PREFIX":      &c.GCSPathPrefix,
+
+		"DISCUIT_AZURE_ACCOUNT":     &c.AzureAccount,
+		"DISCUIT_AZURE_ACCOUNT_KEY": &c.AzureAccountKey,
+		"DISCUIT_AZURE_CONTAINER":   &c.AzureContainer,
+		"DISCUIT_AZURE_ENDPOINT":    &c.AzureEndpoint,
+		"DISCUIT_AZURE_PATH_PREFIX": &c.AzurePathPrefix,
+
+		"DISCUIT_STORAGE_BACKEND":            &c.StorageBackend,
+		"DISCUIT_IMAGES_STORE":               &c.ImagesStore,
+		"DISCUIT_IMAGES_REPLICA_STORES":      &c.ImagesReplicaStores,
+		"DISCUIT_IMAGES_FALLBACK_STORES":     &c.ImagesFallbackStores,
+		"DISCUIT_IMAGE_JOB_WORKERS":          &c.ImageJobWorkers,
+		"DISCUIT_IMAGE_PREFETCH_WORKERS":     &c.ImagePrefetchWorkers,
+		"DISCUIT_IMAGE_PREFETCH_FRONT_PAGE":  &c.ImagePrefetchFrontPage,
+		"DISCUIT_IMAGE_PREFETCH_MIN_MEMBERS": &c.ImagePrefetchMinMembers,
+		"DISCUIT_IMAGE_PREFETCH_WARM_URLS":   &c.ImagePrefetchWarmURLs,
+		"DISCUIT_IMAGE_DUPLICATE_ACTION":     &c.ImageDuplicateAction,
+		"DISCUIT_IMAGE_DUPLICATE_DAYS":       &c.ImageDuplicateDays,
+		"DISCUIT_IMAGE_DUPLICATE_DISTANCE":   &c.ImageDuplicateDistance,
+		"DISCUIT_JOB_WORKERS":                &c.JobWorkers,
+		"DISCUIT_VOTE_BUFFER_THRESHOLD":      &c.VoteBufferThreshold,
+		"DISCUIT_VOTE_BUFFER_FLUSH_INTERVAL": &c.VoteBufferFlushInterval,
+		"DISCUIT_FEED_CACHE_TTL":             &c.FeedCacheTTL,
+
+		"DISCUIT_SEARCH_BACKEND":           &c.SearchBackend,
+		"DISCUIT_MEILISEARCH_URL":          &c.MeilisearchURL,
+		"DISCUIT_MEILISEARCH_API_KEY":      &c.MeilisearchAPIKey,
+		"DISCUIT_MEILISEARCH_INDEX_PREFIX": &c.MeilisearchIndexPrefix,
+
+		"DISCUIT_EMAIL_BACKEND":  &c.EmailBackend,
+		"DISCUIT_EMAIL_FROM":     &c.EmailFrom,
+		"DISCUIT_SITE_URL":       &c.SiteURL,
+		"DISCUIT_SMTP_ADDR":      &c.SMTPAddr,
+		"DISCUIT_SMTP_USERNAME":  &c.SMTPUsername,
+		"DISCUIT_SMTP_PASSWORD":  &c.SMTPPassword,
+		"DISCUIT_SES_REGION":     &c.SESRegion,
+		"DISCUIT_SES_ACCESS_KEY": &c.SESAccessKey,
+		"DISCUIT_SES_SECRET_KEY": &c.SESSecretKey,
+		"DISCUIT_EMAIL_DIGESTS":  &c.EmailDigests,
+
+		"DISCUIT_BOT_MAX_REQUESTS_PER_MINUTE": &c.BotMaxRequestsPerMinute,
+		"DISCUIT_BOT_MAX_TOKENS_PER_DAY":      &c.BotMaxTokensPerDay,
+		"DISCUIT_BOT_MAX_COST_PER_DAY":        &c.BotMaxCostPerDay,
+		"DISCUIT_BOT_INPUT_TOKEN_PRICE":       &c.BotInputTokenPrice,
+		"DISCUIT_BOT_OUTPUT_TOKEN_PRICE":      &c.BotOutputTokenPrice,
+		"DISCUIT_BOT_PROMPTS_DIR":             &c.BotPromptsDir,
+		"DISCUIT_BOT_USER_WEIGHTING":          &c.BotUserWeighting,
+		"DISCUIT_BOT_DRY_RUN":                 &c.BotDryRun,
+		"DISCUIT_BOT_SCHEDULE_TIMEZONE":       &c.BotScheduleTimezone,
+		"DISCUIT_BOT_SCHEDULE_START_HOUR":     &c.BotScheduleStartHour,
+		"DISCUIT_BOT_SCHEDULE_END_HOUR":       &c.BotScheduleEndHour,
+		"DISCUIT_BOT_SCHEDULE_INTERVAL":       &c.BotScheduleInterval,
+		"DISCUIT_BOT_SCHEDULE_BATCHES":        &c.BotScheduleBatches,
+		"DISCUIT_BOT_SCHEDULE_CONCURRENCY":    &c.BotScheduleConcurrency,
+		"DISCUIT_BOT_VOTES":                   &c.BotVotes,
+		"DISCUIT_BOT_VOTE_INTERVAL":           &c.BotVoteInterval,
+		"DISCUIT_BOT_VOTES_PER_ROUND":         &c.BotVotesPerRound,
+		"DISCUIT_BOT_MAX_VOTES_PER_HOUR":      &c.BotMaxVotesPerHour,
+		"DISCUIT_BOT_UPVOTE_CHANCE":           &c.BotUpvoteChance,
+		"DISCUIT_BOT_DOWNVOTE_CHANCE":         &c.BotDownvoteChance,
+		"DISCUIT_BOT_EMBEDDER":                &c.BotEmbedder,
+		"DISCUIT_BOT_EMBEDDING_MODEL":         &c.BotEmbeddingModel,
+		"DISCUIT_BOT_SIMILARITY_THRESHOLD":    &c.BotSimilarityThreshold,
+		"DISCUIT_BOT_SIMILARITY_RETRIES":      &c.BotSimilarityRetries,
+
+		"DISCUIT_REKOGNITION_ENABLED":        &c.RekognitionEnabled,
+		"DISCUIT_REKOGNITION_REGION":         &c.RekognitionRegion,
+		"DISCUIT_REKOGNITION_ACCESS_KEY":     &c.RekognitionAccessKey,
+		"DISCUIT_REKOGNITION_SECRET_KEY":     &c.RekognitionSecretKey,
+		"DISCUIT_REKOGNITION_MIN_CONFIDENCE": &c.RekognitionMinConfidence,
+		"DISCUIT_REKOGNITION_FACES":          &c.RekognitionFaces,
+		"DISCUIT_NSFW_ENDPOINT":              &c.NSFWEndpoint,
+		"DISCUIT_NSFW_THRESHOLD":             &c.NSFWThreshold,
+		"DISCUIT_IMAGE_MODERATION_FAIL_OPEN": &c.ImageModerationFailOpen,
+		"DISCUIT_IMAGE_MODERATION_TIMEOUT":   &c.ImageModerationTimeout,
+
+		"DISCUIT_PURGE_DELETED_CONTENT_DAYS": &c.PurgeDeletedContentDays,
+		"DISCUIT_IMAGE_TRASH_DAYS":           &c.ImageTrashDays,
+
+		// Push notifications for native mobile apps.
+		"DISCUIT_FCM_CREDENTIALS_FILE": &c.FCMCredentialsFile,
+		"DISCUIT_FCM_PROJECT_ID":       &c.FCMProjectID,
+		"DISCUIT_APNS_KEY_FILE":        &c.APNsKeyFile,
+		"DISCUIT_APNS_KEY_ID":          &c.APNsKeyID,
+		"DISCUIT_APNS_TEAM_ID":         &c.APNsTeamID,
+		"DISCUIT_APNS_TOPIC":           &c.APNsTopic,
+		"DISCUIT_APNS_PRODUCTION":      &c.APNsProduction,
+		"DISCUIT_VAPID_PUBLIC_KEY":     &c.VAPIDPublicKey,
+		"DISCUIT_VAPID_PRIVATE_KEY":    &c.VAPIDPrivateKey,
+		"DISCUIT_VAPID_EMAIL":          &c.VAPIDEmail,
 
 		// For the front-end:
 		"DISCUIT_CAPTCHA_SITEKEY": &c.CaptchaSiteKey,
@@ -183,6 +852,8 @@ func Parse(path string) (*Config, error) {
 		"DISCUIT_USE_HTTP_COOKIES": &c.UseHTTPCookies,
 	}
 
+	var problems problems
+
 	// Attempt to unmarshal the YAML file if it exists
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -191,47 +862,266 @@ func Parse(path string) (*Config, error) {
 		}
 		// If the file doesn't exist, just log or ignore and proceed to use environment variables
 	} else {
-		// If file reading was successful, unmarshal the YAML content
-		if yamlErr := yaml.Unmarshal(data, &c); yamlErr != nil {
-			return nil, yamlErr
+		// If file reading was successful, unmarshal the YAML content. Unknown
+		// keys are problems, as they're most likely misspelled settings.
+		problems.data = data
+		if yamlErr := yaml.UnmarshalStrict(data, &c); yamlErr != nil {
+			problems.addYAML(yamlErr)
+			var typeErr *yaml.TypeError
+			if !errors.As(yamlErr, &typeErr) {
+				// A syntax error, after which nothing was decoded.
+				return nil, problems.err(path)
+			}
 		}
 	}
 
 	// Override with environment variables if present using the map
-	for envVar, configField := range envConfigMap {
-		if value, ok := os.LookupEnv(envVar); ok {
-			switch v := configField.(type) {
-			case *string:
-				*v = value
-			case *int:
-				if i, err := strconv.Atoi(value); err == nil {
-					*v = i
-				}
-			case *bool:
-				if b, err := strconv.ParseBool(value); err == nil {
-					*v = b
-				}
-			case *core.FeedSort:
-				if err := v.UnmarshalText([]byte(value)); err != nil {
-					return nil, err
-				}
-			default:
-				return nil, errors.New("unknown type")
+	envVars := make([]string, 0, len(envConfigMap))
+	for envVar := range envConfigMap {
+		envVars = append(envVars, envVar)
+	}
+	sort.Strings(envVars)
+	for _, envVar := range envVars {
+		value, ok := os.LookupEnv(envVar)
+		if !ok {
+			continue
+		}
+		switch v := envConfigMap[envVar].(type) {
+		case *string:
+			*v = value
+		case *int:
+			if i, err := strconv.Atoi(value); err == nil {
+				*v = i
+			} else {
+				problems.addEnv(envVar, "invalid integer %q", value)
+			}
+		case *bool:
+			if b, err := strconv.ParseBool(value); err == nil {
+				*v = b
+			} else {
+				problems.addEnv(envVar, "invalid boolean %q", value)
+			}
+		case *float64:
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				*v = f
+			} else {
+				problems.addEnv(envVar, "invalid number %q", value)
 			}
+		case *core.FeedSort:
+			if err := v.UnmarshalText([]byte(value)); err != nil {
+				problems.addEnv(envVar, "invalid feed sort %q", value)
+			}
+		default:
+			return nil, fmt.Errorf("unknown type of environment variable %s", envVar)
 		}
 	}
 
 	// Validation for required fields
 	if c.ForumCreationReqPoints == -1 {
-		return nil, errors.New("ForumCreationReqPoints cannot be (-1)")
+		problems.add("forumCreationReqPoints", "required")
 	}
 	if c.MaxForumsPerUser == -1 {
-		return nil, errors.New("MaxForumsPerUser cannot be (-1)")
+		problems.add("maxForumsPerUser", "required")
+	}
+	switch c.AuthBackend {
+	case "":
+	case "ldap":
+		if c.LDAPURL == "" || c.LDAPBaseDN == "" {
+			problems.add("authBackend", "ldapURL and ldapBaseDN are required for the ldap auth backend")
+		}
+	case "saml":
+		if c.SAMLIdPMetadataFile == "" || c.SAMLBaseURL == "" {
+			problems.add("authBackend", "samlIdPMetadataFile and samlBaseURL are required for the saml auth backend")
+		}
+	default:
+		problems.add("authBackend", "invalid auth backend %q", c.AuthBackend)
+	}
+	switch c.SearchBackend {
+	case "", "database":
+	case "meilisearch":
+		if c.MeilisearchURL == "" {
+			problems.add("searchBackend", "meilisearchURL is required for the meilisearch search backend")
+		}
+	default:
+		problems.add("searchBackend", "invalid search backend %q", c.SearchBackend)
+	}
+	switch c.EmailBackend {
+	case "":
+	case "smtp":
+		if _, _, err := net.SplitHostPort(c.SMTPAddr); err != nil {
+			problems.add("smtpAddr", "invalid address %q (must be of the form 'host:port')", c.SMTPAddr)
+		}
+	case "ses":
+		if c.SESRegion == "" {
+			problems.add("emailBackend", "sesRegion is required for the ses email backend")
+		}
+	default:
+		problems.add("emailBackend", "invalid email backend %q", c.EmailBackend)
+	}
+	if c.EmailBackend != "" {
+		if _, err := mail.ParseAddress(c.EmailFrom); err != nil {
+			problems.add("emailFrom", "invalid address %q: %v", c.EmailFrom, err)
+		}
+		if u, err := url.Parse(c.SiteURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems.add("siteURL", "invalid URL %q (must be an http or https URL)", c.SiteURL)
+		}
+	}
+	if (c.VAPIDPublicKey == "") != (c.VAPIDPrivateKey == "") {
+		problems.add("vapidPublicKey", "vapidPublicKey and vapidPrivateKey are both required")
+	} else if c.VAPIDPublicKey != "" {
+		if !vapidKeyValid(c.VAPIDPublicKey, 65) {
+			problems.add("vapidPublicKey", "invalid key (must be a base64url encoded uncompressed P-256 public key)")
+		}
+		if !vapidKeyValid(c.VAPIDPrivateKey, 32) {
+			problems.add("vapidPrivateKey", "invalid key (must be a base64url encoded P-256 private key)")
+		}
+	}
+	if c.VAPIDEmail != "" {
+		if _, err := mail.ParseAddress(c.VAPIDEmail); err != nil {
+			problems.add("vapidEmail", "invalid address %q: %v", c.VAPIDEmail, err)
+		}
 	}
+	switch c.StorageBackend {
+	case "", "disk", "gcs", "azure":
+	case "s3":
+		c.S3Enabled = true
+	default:
+		problems.add("storageBackend", "invalid storage backend %q", c.StorageBackend)
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		problems.add("certFile", "certFile and keyFile are both required for HTTPS")
+	}
+	if c.ACMEDomains != "" && len(c.ACMEDomainList()) == 0 {
+		problems.add("acmeDomains", "invalid domains %q", c.ACMEDomains)
+	}
+	if c.HTTPRedirectAddr != "" && !AddressValid(c.HTTPRedirectAddr) {
+		problems.add("httpRedirectAddr", "invalid address %q (must be of the form 'host:port', where host can be empty)", c.HTTPRedirectAddr)
+	}
+	if c.HSTSPreload {
+		if d, err := time.ParseDuration(c.HSTSMaxAge); err == nil && (d < time.Hour*24*365 || !c.HSTSIncludeSubdomains) {
+			problems.add("hstsPreload", "preload requires an hstsMaxAge of at least a year (8760h) and hstsIncludeSubdomains")
+		}
+	}
+	if !AddressValid(c.Addr) {
+		problems.add("addr", "invalid address %q (must be of the form 'host:port', where host can be empty)", c.Addr)
+	}
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		problems.add("logLevel", "%v", err)
+	}
+	if !logging.ValidFormat(c.LogFormat) {
+		problems.add("logFormat", "invalid log format %q (must be text or json)", c.LogFormat)
+	}
+	if c.ImageAccessLogSampleRate <= 0 || c.ImageAccessLogSampleRate > 1 {
+		problems.add("imageAccessLogSampleRate", "invalid sample rate %v (must be more than 0 and at most 1)", c.ImageAccessLogSampleRate)
+	}
+	if c.CompressionMinSize < -1 {
+		problems.add("compressionMinSize", "invalid size %d (must be -1, to disable compression, or more)", c.CompressionMinSize)
+	}
+	for _, origin := range c.CORSPublicOriginList() {
+		if origin != "*" && !originValid(origin) {
+			problems.add("corsPublicOrigins", "invalid origin %q (must be of the form 'scheme://host[:port]', or '*')", origin)
+		}
+	}
+	for _, origin := range c.CORSOriginList() {
+		if !originValid(origin) {
+			problems.add("corsOrigins", "invalid origin %q (must be of the form 'scheme://host[:port]')", origin)
+		}
+	}
+	for _, method := range c.CORSMethodList() {
+		if strings.Trim(method, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+			problems.add("corsMethods", "invalid method %q", method)
+		}
+	}
+	if c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1 {
+		problems.add("tracingSampleRatio", "invalid sample ratio %v (must be between 0 and 1)", c.TracingSampleRatio)
+	}
+	c.validateDurations(&problems)
 
+	if err := problems.err(path); err != nil {
+		return nil, err
+	}
 	return c, nil
 }
 
+// vapidKeyValid reports whether key is a base64url encoded key of size bytes
+// (with or without padding).
+func vapidKeyValid(key string, size int) bool {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(key, "="))
+	return err == nil && len(b) == size
+}
+
+// originValid reports whether origin is the origin of a site (like
+// "https://example.com" or "http://localhost:3000"): a scheme and a host,
+// with no path.
+func originValid(origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" &&
+		u.Path == "" && u.RawQuery == "" && u.User == nil && origin == u.Scheme+"://"+u.Host
+}
+
+// splitList returns the items of the comma separated list s, with the spaces
+// around them trimmed.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// HTTPS reports whether the site is served over HTTPS.
+func (c *Config) HTTPS() bool {
+	return c.CertFile != "" || c.ACMEDomains != ""
+}
+
+// ACMEDomainList returns the domains of c.ACMEDomains.
+func (c *Config) ACMEDomainList() []string {
+	var domains []string
+	for _, d := range strings.Split(c.ACMEDomains, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			domains = append(domains, strings.ToLower(d))
+		}
+	}
+	return domains
+}
+
+// CORSPublicOriginList returns the origins of c.CORSPublicOrigins.
+func (c *Config) CORSPublicOriginList() []string {
+	return splitList(c.CORSPublicOrigins)
+}
+
+// CORSOriginList returns the origins of c.CORSOrigins.
+func (c *Config) CORSOriginList() []string {
+	return splitList(c.CORSOrigins)
+}
+
+// CORSMethodList returns the methods of c.CORSMethods.
+func (c *Config) CORSMethodList() []string {
+	return splitList(c.CORSMethods)
+}
+
+// HSTSHeader returns the value of the Strict-Transport-Security header of
+// responses, or an empty string if there's to be none.
+func (c *Config) HSTSHeader() string {
+	if !c.HTTPS() || c.HSTSMaxAge == "" {
+		return ""
+	}
+	d, err := time.ParseDuration(c.HSTSMaxAge)
+	if err != nil || d < 0 {
+		return ""
+	}
+	h := "max-age=" + strconv.Itoa(int(d.Seconds()))
+	if c.HSTSIncludeSubdomains {
+		h += "; includeSubDomains"
+	}
+	if c.HSTSPreload {
+		h += "; preload"
+	}
+	return h
+}
+
 // Hostname returns the hostname part of c.Addr. If there's no hostname part, it
 // returns an empty string.
 func (c *Config) Hostname() string {
@@ -288,3 +1178,33 @@ func (c *Config) GetS3Endpoint() string {
 func (c *Config) GetS3PathPrefix() string {
 	return c.S3PathPrefix
 }
+
+// GetS3MaxAttempts returns the max number of attempts of an S3 request
+func (c *Config) GetS3MaxAttempts() int {
+	return c.S3MaxAttempts
+}
+
+// GetS3MaxBackoff returns the max delay between retries of an S3 request
+func (c *Config) GetS3MaxBackoff() string {
+	return c.S3MaxBackoff
+}
+
+// GetS3RequestTimeout returns the time limit of an S3 operation
+func (c *Config) GetS3RequestTimeout() string {
+	return c.S3RequestTimeout
+}
+
+// GetS3RedirectMode returns how requests for images in S3 are redirected
+func (c *Config) GetS3RedirectMode() string {
+	return c.S3RedirectMode
+}
+
+// GetS3PresignTTL returns the validity period of presigned S3 URLs
+func (c *Config) GetS3PresignTTL() string {
+	return c.S3PresignTTL
+}
+
+// GetS3CDNBaseURL returns the base URL of the CDN in front of S3
+func (c *Config) GetS3CDNBaseURL() string {
+	return c.S3CDNBaseURL
+}
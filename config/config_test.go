@@ -1,6 +1,10 @@
 package config
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/SherClockHolmes/webpush-go"
+)
 
 func TestAddressValid(t *testing.T) {
 	tests := []struct {
@@ -21,3 +25,63 @@ func TestAddressValid(t *testing.T) {
 		}
 	}
 }
+
+func TestHSTSHeader(t *testing.T) {
+	tests := []struct {
+		conf Config
+		want string
+	}{
+		{Config{HSTSMaxAge: "8760h"}, ""}, // Not HTTPS.
+		{Config{CertFile: "cert.pem"}, ""},
+		{Config{CertFile: "cert.pem", HSTSMaxAge: "1h"}, "max-age=3600"},
+		{Config{ACMEDomains: "example.com", HSTSMaxAge: "8760h", HSTSIncludeSubdomains: true, HSTSPreload: true}, "max-age=31536000; includeSubDomains; preload"},
+		{Config{CertFile: "cert.pem", HSTSMaxAge: "a year"}, ""},
+	}
+	for i, test := range tests {
+		if got := test.conf.HSTSHeader(); got != test.want {
+			t.Errorf("test %d: HSTSHeader = %q, want %q", i, got, test.want)
+		}
+	}
+}
+
+func TestACMEDomainList(t *testing.T) {
+	c := &Config{ACMEDomains: " Example.com, www.example.com,, "}
+	got := c.ACMEDomainList()
+	if len(got) != 2 || got[0] != "example.com" || got[1] != "www.example.com" {
+		t.Errorf("ACMEDomainList = %q", got)
+	}
+}
+
+func TestVAPIDKeyValid(t *testing.T) {
+	private, public, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !vapidKeyValid(public, 65) || !vapidKeyValid(private, 32) {
+		t.Errorf("generated keys %q and %q are invalid", public, private)
+	}
+	if vapidKeyValid(private, 65) || vapidKeyValid("not a key", 32) {
+		t.Error("invalid keys are valid")
+	}
+}
+
+func TestOriginValid(t *testing.T) {
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://example.com", true},
+		{"http://localhost:3000", true},
+		{"https://example.com/", false},
+		{"https://example.com/app", false},
+		{"ftp://example.com", false},
+		{"example.com", false},
+		{"*", false},
+		{"https://user@example.com", false},
+	}
+	for _, test := range tests {
+		if got := originValid(test.origin); got != test.want {
+			t.Errorf("originValid(%q) = %v, want %v", test.origin, got, test.want)
+		}
+	}
+}
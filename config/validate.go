@@ -0,0 +1,177 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// A FieldError is a problem with a setting: an unknown key, a value of the
+// wrong type, or an invalid value.
+type FieldError struct {
+	Field string // The YAML key, or the environment variable, of the setting.
+	Line  int    // The line of the setting in the config file, or 0.
+	Msg   string
+}
+
+func (e *FieldError) Error() string {
+	var b strings.Builder
+	if e.Line > 0 {
+		fmt.Fprintf(&b, "line %d: ", e.Line)
+	}
+	if e.Field != "" {
+		b.WriteString(e.Field + ": ")
+	}
+	b.WriteString(e.Msg)
+	return b.String()
+}
+
+// ValidationError is the error returned by Parse for configs with problems.
+// It lists all of them, ordered by line (problems with environment variables
+// last).
+type ValidationError struct {
+	Path     string // Of the config file.
+	Problems []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Problems) == 1 {
+		return fmt.Sprintf("%s: %v", e.Path, e.Problems[0])
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %d problems:", e.Path, len(e.Problems))
+	for _, p := range e.Problems {
+		b.WriteString("\n\t" + p.Error())
+	}
+	return b.String()
+}
+
+// problems collects the problems of a config while it's parsed.
+type problems struct {
+	data []byte // The config file.
+	list []*FieldError
+}
+
+// add adds a problem with the setting field (a YAML key), whose line is looked
+// up in the config file.
+func (p *problems) add(field, format string, args ...any) {
+	p.list = append(p.list, &FieldError{Field: field, Line: keyLine(p.data, field), Msg: fmt.Sprintf(format, args...)})
+}
+
+// addEnv adds a problem with the environment variable name.
+func (p *problems) addEnv(name, format string, args ...any) {
+	p.list = append(p.list, &FieldError{Field: name, Msg: fmt.Sprintf(format, args...)})
+}
+
+// err returns a *ValidationError listing the problems, or nil if there are
+// none.
+func (p *problems) err(path string) error {
+	if len(p.list) == 0 {
+		return nil
+	}
+	sort.SliceStable(p.list, func(i, j int) bool {
+		a, b := p.list[i].Line, p.list[j].Line
+		if a == 0 || b == 0 {
+			return a != 0 && b == 0
+		}
+		return a < b
+	})
+	return &ValidationError{Path: path, Problems: p.list}
+}
+
+var (
+	yamlLineRegexp         = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+	yamlUnknownFieldRegexp = regexp.MustCompile(`^field (\S+) not found in type`)
+)
+
+// addYAML adds the problems of err, an error of decoding the config file.
+func (p *problems) addYAML(err error) {
+	var msgs []string
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		msgs = typeErr.Errors
+	} else {
+		msgs = []string{err.Error()}
+	}
+	for _, msg := range msgs {
+		fe := &FieldError{Msg: msg}
+		if m := yamlLineRegexp.FindStringSubmatch(msg); m != nil {
+			fe.Line, _ = strconv.Atoi(m[1])
+			fe.Msg = m[2]
+			fe.Field = lineKey(p.data, fe.Line)
+		}
+		if m := yamlUnknownFieldRegexp.FindStringSubmatch(fe.Msg); m != nil {
+			fe.Field, fe.Msg = m[1], "unknown setting"
+		}
+		p.list = append(p.list, fe)
+	}
+}
+
+// keyLine returns the line of the top-level key in the YAML document data, or
+// 0 if it's not there.
+func keyLine(data []byte, key string) int {
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if k, _, ok := bytes.Cut(line, []byte(":")); ok && string(k) == key {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// lineKey returns the key of the mapping entry on line n of the YAML document
+// data, or "" if there's none.
+func lineKey(data []byte, n int) string {
+	lines := bytes.Split(data, []byte("\n"))
+	if n < 1 || n > len(lines) {
+		return ""
+	}
+	line := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(string(lines[n-1])), "- "))
+	if k, _, ok := strings.Cut(line, ":"); ok && !strings.ContainsAny(k, " \"'") {
+		return k
+	}
+	return ""
+}
+
+// validateDurations adds a problem for each of the settings of c that are
+// durations (like "5m") and that cannot be parsed as such.
+func (c *Config) validateDurations(p *problems) {
+	for _, d := range []struct {
+		field string
+		value string
+	}{
+		{"configReloadInterval", c.ConfigReloadInterval},
+		{"defaultLatencyBudget", c.DefaultLatencyBudget},
+		{"dbReplicaMaxLag", c.DBReplicaMaxLag},
+		{"imageURLTTL", c.ImageURLTTL},
+		{"imageURLExpiryGrace", c.ImageURLExpiryGrace},
+		{"s3MaxBackoff", c.S3MaxBackoff},
+		{"s3RequestTimeout", c.S3RequestTimeout},
+		{"s3PresignTTL", c.S3PresignTTL},
+		{"botScheduleInterval", c.BotScheduleInterval},
+		{"botVoteInterval", c.BotVoteInterval},
+		{"imageModerationTimeout", c.ImageModerationTimeout},
+		{"voteBufferFlushInterval", c.VoteBufferFlushInterval},
+		{"feedCacheTTL", c.FeedCacheTTL},
+		{"hstsMaxAge", c.HSTSMaxAge},
+		{"corsMaxAge", c.CORSMaxAge},
+	} {
+		if d.value == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(d.value); err != nil {
+			p.add(d.field, "invalid duration %q", d.value)
+		}
+	}
+	for route, budget := range c.LatencyBudgets {
+		if _, err := time.ParseDuration(budget); err != nil {
+			p.add("latencyBudgets", "invalid duration %q (of %s)", budget, route)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseProblems(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := "forumCreationReqPoints: 0\n" +
+		"maxForumsPerUser: many\n" +
+		"paginationLimt: 20\n" +
+		"storageBackend: tape\n" +
+		"s3RequestTimeout: a minute\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DISCUIT_MAX_IMAGE_SIZE", "25MB")
+
+	_, err := Parse(path)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("got error %v, want a *ValidationError", err)
+	}
+	want := []struct {
+		field string
+		line  int
+	}{
+		{"maxForumsPerUser", 2}, // Not an integer.
+		{"maxForumsPerUser", 2}, // And so not set.
+		{"paginationLimt", 3},
+		{"storageBackend", 4},
+		{"s3RequestTimeout", 5},
+		{"DISCUIT_MAX_IMAGE_SIZE", 0},
+	}
+	if len(verr.Problems) != len(want) {
+		t.Fatalf("got %d problems, want %d: %v", len(verr.Problems), len(want), err)
+	}
+	for i, w := range want {
+		if p := verr.Problems[i]; p.Field != w.field || p.Line != w.line {
+			t.Errorf("problem %d: got %q, want one with %s on line %d", i, p, w.field, w.line)
+		}
+	}
+}
+
+func TestParseSyntaxError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("addr: :8080\n  bad: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Parse(path)
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Problems) != 1 || verr.Problems[0].Line == 0 {
+		t.Errorf("got error %v, want a single problem with a line number", err)
+	}
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Most settings are read once, when the server starts. Those that subsystems
+// can change while running (rate limits, bot settings, image variants, and so
+// on) are reloaded by a Watcher, which re-parses the config file, and the
+// environment, when the process receives SIGHUP or when the file changes, and
+// hands the new config to the subsystems that subscribed to it.
+
+// restartFields are the fields of Config that only take effect on a restart.
+// A warning is logged when a reload changes any of them.
+var restartFields = []string{
+	"Addr",
+	"DBAddr", "DBUser", "DBPassword", "DBName", "DBReplicas", "DBReplicaMaxLag",
+	"SessionCookieName",
+	"RedisAddress",
+	"HMACSecret",
+	"CertFile", "KeyFile", "ACMEDomains", "ACMEEmail", "ACMECacheDir", "ACMEDirectoryURL", "HTTPRedirectAddr",
+	"HSTSMaxAge", "HSTSIncludeSubdomains", "HSTSPreload",
+	"StorageBackend", "ImagesStore", "ImagesFolderPath", "ImagesReplicaStores", "ImagesFallbackStores",
+	"ImageJobWorkers", "ImagePrefetchWorkers", "JobWorkers",
+	"ImageAccessLog", "ImageAccessLogSampleRate", "ImageHotlinkAllowedReferrers", "ImageHotlinkPlaceholder",
+	"AuthBackend",
+	"BotVoteInterval",
+	"VoteBufferFlushInterval",
+	"ConfigReloadInterval",
+	"LogFormat",
+	"TracingEndpoint", "TracingSampleRatio", "TracingServiceName",
+	"CompressionMinSize", "UncompressedRoutes",
+	"CORSPublicOrigins", "CORSPrivateRoutes", "CORSOrigins", "CORSMethods", "CORSCredentials", "CORSMaxAge",
+	"SearchBackend", "MeilisearchURL", "MeilisearchAPIKey", "MeilisearchIndexPrefix",
+	"EmailBackend", "EmailFrom", "SiteURL", "SMTPAddr", "SMTPUsername", "SMTPPassword", "SESRegion", "SESAccessKey", "SESSecretKey", "EmailDigests",
+	"VAPIDPublicKey", "VAPIDPrivateKey", "VAPIDEmail",
+}
+
+// A Watcher holds the current config of the site, which it replaces with a
+// new snapshot on every reload. Configs handed out by a Watcher are not to be
+// modified.
+type Watcher struct {
+	path    string
+	current atomic.Pointer[Config]
+
+	mu      sync.Mutex // Guards the fields below, and serializes reloads.
+	subs    []func(*Config) error
+	modTime time.Time // Of the config file, when last loaded.
+}
+
+// NewWatcher returns a Watcher of the config file at path, whose current
+// config is c (parsed from path).
+func NewWatcher(path string, c *Config) *Watcher {
+	w := &Watcher{path: path}
+	w.current.Store(c)
+	if fi, err := os.Stat(path); err == nil {
+		w.modTime = fi.ModTime()
+	}
+	return w
+}
+
+// Config returns the current config.
+func (w *Watcher) Config() *Config {
+	return w.current.Load()
+}
+
+// Subscribe adds fn to the functions that are called with the new config on
+// every reload, in the order they were added. An error returned by fn does
+// not undo the reload; it's returned by Reload (and logged by Watch).
+func (w *Watcher) Subscribe(fn func(*Config) error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subs = append(w.subs, fn)
+}
+
+// Reload re-parses the config file and, if it's valid, makes it the current
+// config and calls the subscribers with it. If the new config is invalid, the
+// current one is kept.
+func (w *Watcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.reload()
+}
+
+func (w *Watcher) reload() error {
+	if fi, err := os.Stat(w.path); err == nil {
+		w.modTime = fi.ModTime()
+	}
+	c, err := Parse(w.path)
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	old := w.current.Swap(c)
+	if changed := changedFields(old, c, restartFields); len(changed) > 0 {
+		log.Printf("config: changes to %v take effect on restart\n", changed)
+	}
+	var errs []error
+	for _, fn := range w.subs {
+		if err := fn(c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// reloadIfModified reloads the config if the config file was modified since it
+// was last loaded.
+func (w *Watcher) reloadIfModified() (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fi, err := os.Stat(w.path)
+	if err != nil || fi.ModTime().Equal(w.modTime) {
+		return false, nil
+	}
+	return true, w.reload()
+}
+
+// Watch reloads the config whenever the process receives SIGHUP and, if poll
+// is not 0, whenever the config file is found to be modified (it's checked
+// every poll), until ctx is done. Failed reloads are logged.
+func (w *Watcher) Watch(ctx context.Context, poll time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if poll > 0 {
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := w.Reload(); err != nil {
+				log.Printf("config: error reloading %s: %v\n", w.path, err)
+			} else {
+				log.Printf("config: reloaded %s\n", w.path)
+			}
+		case <-tick:
+			if reloaded, err := w.reloadIfModified(); err != nil {
+				log.Printf("config: error reloading %s: %v\n", w.path, err)
+			} else if reloaded {
+				log.Printf("config: reloaded %s (modified)\n", w.path)
+			}
+		}
+	}
+}
+
+// changedFields returns the names of the fields, of names, that are different
+// in a and b.
+func changedFields(a, b *Config, names []string) []string {
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	var changed []string
+	for _, name := range names {
+		if !reflect.DeepEqual(va.FieldByName(name).Interface(), vb.FieldByName(name).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
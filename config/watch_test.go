@@ -0,0 +1,73 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcherReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(s string) {
+		if err := os.WriteFile(path, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("forumCreationReqPoints: 0\nmaxForumsPerUser: 0\npaginationLimit: 10\n")
+	c, err := Parse(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewWatcher(path, c)
+	var got *Config
+	w.Subscribe(func(c *Config) error {
+		got = c
+		return nil
+	})
+	errSub := errors.New("rejected")
+	w.Subscribe(func(c *Config) error { return errSub })
+
+	write("forumCreationReqPoints: 0\nmaxForumsPerUser: 0\npaginationLimit: 20\n")
+	if err := w.Reload(); !errors.Is(err, errSub) {
+		t.Errorf("got error %v, want the error of the subscriber", err)
+	}
+	if got == nil || got.PaginationLimit != 20 || w.Config() != got {
+		t.Fatalf("the reloaded config was not handed to the subscriber")
+	}
+
+	// An invalid config is not loaded.
+	write("paginationLimit: 30\n")
+	if err := w.Reload(); err == nil {
+		t.Error("no error reloading an invalid config")
+	}
+	if w.Config().PaginationLimit != 20 {
+		t.Errorf("got pagination limit %d after a failed reload, want 20", w.Config().PaginationLimit)
+	}
+
+	// Polling only reloads modified files.
+	if reloaded, _ := w.reloadIfModified(); reloaded {
+		t.Error("reloaded an unmodified config file")
+	}
+	write("forumCreationReqPoints: 0\nmaxForumsPerUser: 0\npaginationLimit: 40\n")
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, _ := w.reloadIfModified(); !reloaded || w.Config().PaginationLimit != 40 {
+		t.Error("a modified config file was not reloaded")
+	}
+}
+
+func TestChangedFields(t *testing.T) {
+	a, b := &Config{}, &Config{}
+	if changed := changedFields(a, b, restartFields); len(changed) != 0 {
+		t.Errorf("got changed fields %v of equal configs", changed)
+	}
+	b.DBName, b.PaginationLimit = "other", 5
+	if changed := changedFields(a, b, restartFields); len(changed) != 1 || changed[0] != "DBName" {
+		t.Errorf("got changed fields %v, want [DBName]", changed)
+	}
+}
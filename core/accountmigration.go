@@ -0,0 +1,603 @@
+package core
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/httputil"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Users can move their accounts between Discuit instances. The old instance
+// exports the account (profile, settings, subscriptions, lists, and
+// optionally posts and comments) into a portability file that's signed with
+// the key of the instance; the new instance verifies the signature against the
+// key the old instance publishes at /api/_account_key and imports the account
+// into an existing account, which then records where it migrated from.
+// Finally, the account on the old instance can be pointed at the new one
+// (which is only allowed once the new account says it migrated from the old
+// one), after which its profile page redirects there.
+
+// accountExportVersion is the version of the format of account exports.
+const accountExportVersion = 1
+
+// maxImportedAccountPosts is the maximum number of posts imported with an
+// account.
+const maxImportedAccountPosts = 1000
+
+var (
+	errAccountExportSignature = httperr.NewBadRequest("invalid_signature", "The signature of the account file is invalid.")
+	errAccountExportVersion   = httperr.NewBadRequest("unsupported_version", "Unsupported account file version.")
+)
+
+var accountSigningKey ed25519.PrivateKey
+
+// SetAccountSigningSecret sets the key that account exports are signed with to
+// one derived from secret.
+func SetAccountSigningSecret(secret string) {
+	seed := sha256.Sum256([]byte("account-export:" + secret))
+	accountSigningKey = ed25519.NewKeyFromSeed(seed[:])
+}
+
+// AccountSigningPublicKey returns the public key of the key that account
+// exports are signed with, or nil if SetAccountSigningSecret wasn't called.
+func AccountSigningPublicKey() ed25519.PublicKey {
+	if accountSigningKey == nil {
+		return nil
+	}
+	return accountSigningKey.Public().(ed25519.PublicKey)
+}
+
+// AccountExportFile is a signed account export.
+type AccountExportFile struct {
+	Account   json.RawMessage `json:"account"` // An AccountExport.
+	Signature []byte          `json:"signature"`
+}
+
+// AccountExport is the content of an account export.
+type AccountExport struct {
+	Version    int       `json:"version"`
+	Origin     string    `json:"origin"` // Base URL of the instance the account was exported from.
+	ExportedAt time.Time `json:"exportedAt"`
+
+	Username  string          `json:"username"`
+	About     msql.NullString `json:"aboutMe"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Settings  AccountSettings `json:"settings"`
+
+	Subscriptions []string           `json:"subscriptions"` // Names of communities.
+	Lists         []*ExportedList    `json:"lists"`
+	Posts         []*ExportedPost    `json:"posts,omitempty"`
+	Comments      []*ExportedComment `json:"comments,omitempty"`
+}
+
+// AccountSettings are the user settings that are carried over to other
+// instances.
+type AccountSettings struct {
+	UpvoteNotificationsOff  bool   `json:"upvoteNotificationsOff"`
+	ReplyNotificationsOff   bool   `json:"replyNotificationsOff"`
+	HomeFeed                string `json:"homeFeed"`
+	RememberFeedSort        bool   `json:"rememberFeedSort"`
+	EmbedsOff               bool   `json:"embedsOff"`
+	HideUserProfilePictures bool   `json:"hideUserProfilePictures"`
+}
+
+// ExportedList is a list in an account export.
+type ExportedList struct {
+	Name        string              `json:"name"`
+	DisplayName string              `json:"displayName"`
+	Description msql.NullString     `json:"description"`
+	Public      bool                `json:"public"`
+	Items       []*ExportedListItem `json:"items"`
+}
+
+// ExportedListItem is an item of a list in an account export. Items can only
+// be imported on instances that have the same posts and comments.
+type ExportedListItem struct {
+	TargetType ContentType `json:"targetType"`
+	TargetID   uid.ID      `json:"targetId"`
+}
+
+// ExportedPost is a post in an account export.
+type ExportedPost struct {
+	PublicID  string    `json:"publicId"`
+	Community string    `json:"community"`
+	Title     string    `json:"title"`
+	Body      string    `json:"body,omitempty"`
+	URL       string    `json:"url,omitempty"` // For link posts, and the post itself for image posts.
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ExportedComment is a comment in an account export. Comments are exported for
+// the user's records, but not imported, as their posts are on the instance
+// they were exported from.
+type ExportedComment struct {
+	ID           uid.ID    `json:"id"`
+	PostPublicID string    `json:"postPublicId"`
+	Community    string    `json:"community"`
+	Body         string    `json:"body"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// UserProfileURL returns the URL of the profile page of the user with
+// username on the instance at origin.
+func UserProfileURL(origin, username string) string {
+	return strings.TrimSuffix(origin, "/") + "/@" + username
+}
+
+// ExportAccount exports the account of user, with its posts and comments if
+// content is true, into a file signed with the key of this instance, which is
+// at origin.
+func ExportAccount(ctx context.Context, db *sql.DB, user uid.ID, origin string, content bool) (*AccountExportFile, error) {
+	if accountSigningKey == nil {
+		return nil, errors.New("account signing key not set")
+	}
+	u, err := GetUser(ctx, db, user, nil)
+	if err != nil {
+		return nil, err
+	}
+	if u.Deleted {
+		return nil, ErrUserDeleted
+	}
+
+	export := &AccountExport{
+		Version:    accountExportVersion,
+		Origin:     strings.TrimSuffix(origin, "/"),
+		ExportedAt: time.Now(),
+		Username:   u.Username,
+		About:      u.About,
+		CreatedAt:  u.CreatedAt,
+		Settings: AccountSettings{
+			UpvoteNotificationsOff:  u.UpvoteNotificationsOff,
+			ReplyNotificationsOff:   u.ReplyNotificationsOff,
+			HomeFeed:                u.HomeFeed,
+			RememberFeedSort:        u.RememberFeedSort,
+			EmbedsOff:               u.EmbedsOff,
+			HideUserProfilePictures: u.HideUserProfilePictures,
+		},
+		Subscriptions: []string{},
+		Lists:         []*ExportedList{},
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT communities.name FROM community_members INNER JOIN communities ON communities.id = community_members.community_id WHERE community_members.user_id = ? ORDER BY communities.name", user)
+	if err != nil {
+		return nil, err
+	}
+	if err := scanRows(rows, func() error {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		export.Subscriptions = append(export.Subscriptions, name)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	lists, err := GetUsersLists(ctx, db, user, "", "")
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range lists {
+		el := &ExportedList{
+			Name:        l.Name,
+			DisplayName: l.DisplayName,
+			Description: l.Description,
+			Public:      l.Public,
+			Items:       []*ExportedListItem{},
+		}
+		rows, err := db.QueryContext(ctx, "SELECT target_type, target_id FROM list_items WHERE list_id = ? ORDER BY id", l.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := scanRows(rows, func() error {
+			item := &ExportedListItem{}
+			if err := rows.Scan(&item.TargetType, &item.TargetID); err != nil {
+				return err
+			}
+			el.Items = append(el.Items, item)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		export.Lists = append(export.Lists, el)
+	}
+
+	if content {
+		if err := exportAccountContent(ctx, db, user, export); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.Marshal(export)
+	if err != nil {
+		return nil, err
+	}
+	return &AccountExportFile{
+		Account:   data,
+		Signature: ed25519.Sign(accountSigningKey, data),
+	}, nil
+}
+
+// scanRows calls scan for each row of rows, and closes rows.
+func scanRows(rows *sql.Rows, scan func() error) error {
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func exportAccountContent(ctx context.Context, db *sql.DB, user uid.ID, export *AccountExport) error {
+	export.Posts, export.Comments = []*ExportedPost{}, []*ExportedComment{}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT posts.public_id, posts.type, posts.title, posts.body, posts.link_info, communities.name, posts.created_at
+		FROM posts
+		INNER JOIN communities ON communities.id = posts.community_id
+		WHERE posts.user_id = ? AND posts.deleted = false
+		ORDER BY posts.created_at`, user)
+	if err != nil {
+		return err
+	}
+	if err := scanRows(rows, func() error {
+		p := &ExportedPost{}
+		var (
+			postType PostType
+			body     msql.NullString
+			linkInfo []byte
+		)
+		if err := rows.Scan(&p.PublicID, &postType, &p.Title, &body, &linkInfo, &p.Community, &p.CreatedAt); err != nil {
+			return err
+		}
+		switch postType {
+		case PostTypeText:
+			p.Body = body.String
+		case PostTypeLink:
+			var link postLink
+			if err := json.Unmarshal(linkInfo, &link); err != nil {
+				return err
+			}
+			p.URL = link.URL
+		case PostTypeImage:
+			p.URL = export.Origin + "/" + p.Community + "/post/" + p.PublicID
+		}
+		export.Posts = append(export.Posts, p)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	rows, err = db.QueryContext(ctx, "SELECT id, post_public_id, community_name, body, created_at FROM comments WHERE user_id = ? AND deleted_at IS NULL ORDER BY created_at", user)
+	if err != nil {
+		return err
+	}
+	return scanRows(rows, func() error {
+		c := &ExportedComment{}
+		if err := rows.Scan(&c.ID, &c.PostPublicID, &c.Community, &c.Body, &c.CreatedAt); err != nil {
+			return err
+		}
+		export.Comments = append(export.Comments, c)
+		return nil
+	})
+}
+
+// fetchAccountSigningKey returns the public key that the instance at origin
+// signs account exports with. It's a variable so that tests can replace it.
+var fetchAccountSigningKey = func(ctx context.Context, origin string) (ed25519.PublicKey, error) {
+	var res struct {
+		PublicKey []byte `json:"publicKey"`
+	}
+	if err := getRemoteJSON(origin+"/api/_account_key", &res); err != nil {
+		return nil, err
+	}
+	if len(res.PublicKey) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid public key")
+	}
+	return ed25519.PublicKey(res.PublicKey), nil
+}
+
+// getRemoteJSON unmarshals the JSON document at u into v.
+func getRemoteJSON(u string, v any) error {
+	res, err := httputil.Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("GET %s: %s", u, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// validInstanceURL reports whether s is the absolute URL of a Discuit
+// instance (or of a page on one).
+func validInstanceURL(s string) (*url.URL, bool) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, false
+	}
+	return u, true
+}
+
+// VerifyAccountExport verifies the signature of f (against the key published
+// by the instance the account was exported from) and returns the export.
+func VerifyAccountExport(ctx context.Context, f *AccountExportFile) (*AccountExport, error) {
+	export := &AccountExport{}
+	if err := json.Unmarshal(f.Account, export); err != nil {
+		return nil, httperr.NewBadRequest("invalid_account_file", "Invalid account file.")
+	}
+	if export.Version != accountExportVersion {
+		return nil, errAccountExportVersion
+	}
+	if _, ok := validInstanceURL(export.Origin); !ok {
+		return nil, httperr.NewBadRequest("invalid_origin", "Invalid origin in account file.")
+	}
+	key, err := fetchAccountSigningKey(ctx, export.Origin)
+	if err != nil {
+		return nil, httperr.NewBadRequest("origin_key", fmt.Sprintf("Could not get the signing key of %s: %v.", export.Origin, err))
+	}
+	if !ed25519.Verify(key, f.Account, f.Signature) {
+		return nil, errAccountExportSignature
+	}
+	return export, nil
+}
+
+// AccountImportResult is the result of an account import.
+type AccountImportResult struct {
+	Subscriptions ImportCounts `json:"subscriptions"`
+	Lists         ImportCounts `json:"lists"`
+	ListItems     ImportCounts `json:"listItems"`
+	Posts         ImportCounts `json:"posts"`
+	Problems      []string     `json:"problems"`
+}
+
+func (r *AccountImportResult) problem(format string, args ...any) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// ImportAccount imports the account in f into the account of user, along with
+// its posts if content is true. Posts are imported into the communities of the
+// same names, and importing an account twice doesn't import its posts twice.
+func ImportAccount(ctx context.Context, db *sql.DB, user uid.ID, f *AccountExportFile, content bool) (*AccountImportResult, error) {
+	export, err := VerifyAccountExport(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	u, err := GetUser(ctx, db, user, nil)
+	if err != nil {
+		return nil, err
+	}
+	if u.Deleted {
+		return nil, ErrUserDeleted
+	}
+
+	if !u.About.Valid && export.About.Valid {
+		u.About = export.About
+	}
+	s := export.Settings
+	u.UpvoteNotificationsOff, u.ReplyNotificationsOff = s.UpvoteNotificationsOff, s.ReplyNotificationsOff
+	u.RememberFeedSort, u.EmbedsOff, u.HideUserProfilePictures = s.RememberFeedSort, s.EmbedsOff, s.HideUserProfilePictures
+	if s.HomeFeed != "" {
+		u.HomeFeed = s.HomeFeed
+	}
+	if err := u.Update(ctx, db); err != nil {
+		return nil, err
+	}
+	migratedFrom := UserProfileURL(export.Origin, export.Username)
+	if _, err := db.ExecContext(ctx, "UPDATE users SET migrated_from = ? WHERE id = ?", migratedFrom, user); err != nil {
+		return nil, err
+	}
+
+	res := &AccountImportResult{Problems: []string{}}
+	for _, name := range export.Subscriptions {
+		community, err := GetCommunityByName(ctx, db, name, nil)
+		if err == nil {
+			err = community.Join(ctx, db, user)
+		}
+		if err != nil {
+			res.Subscriptions.Failed++
+			res.problem("community %s: %v", name, err)
+			continue
+		}
+		res.Subscriptions.Created++
+	}
+
+	for _, el := range export.Lists {
+		if err := importList(ctx, db, user, el, res); err != nil {
+			return nil, err
+		}
+	}
+
+	if content {
+		if err := importAccountPosts(ctx, db, user, export, res); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// importList imports el into the list of user with the same name, which is
+// created if it doesn't exist.
+func importList(ctx context.Context, db *sql.DB, user uid.ID, el *ExportedList, res *AccountImportResult) error {
+	l, err := GetListByName(ctx, db, user, el.Name)
+	if err != nil {
+		if !errors.Is(err, errListNotFound) {
+			return err
+		}
+		if err := CreateList(ctx, db, user, el.Name, el.DisplayName, el.Description, el.Public); err != nil {
+			res.Lists.Failed++
+			res.problem("list %s: %v", el.Name, err)
+			return nil
+		}
+		if l, err = GetListByName(ctx, db, user, el.Name); err != nil {
+			return err
+		}
+		res.Lists.Created++
+	} else {
+		res.Lists.Skipped++
+	}
+
+	for _, item := range el.Items {
+		table := "posts"
+		if item.TargetType == ContentTypeComment {
+			table = "comments"
+		}
+		var exists bool
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) > 0 FROM "+table+" WHERE id = ?", item.TargetID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			res.ListItems.Skipped++
+			continue
+		}
+		if err := l.AddItem(ctx, db, item.TargetType, item.TargetID); err != nil {
+			return err
+		}
+		res.ListItems.Created++
+	}
+	return nil
+}
+
+// importAccountPosts imports the posts of export for user. Like the content of
+// other platforms (see ImportArchive), imported posts are recorded in
+// imported_items.
+func importAccountPosts(ctx context.Context, db *sql.DB, user uid.ID, export *AccountExport, res *AccountImportResult) error {
+	if len(export.Posts) > maxImportedAccountPosts {
+		res.problem("only the first %d posts are imported", maxImportedAccountPosts)
+		export.Posts = export.Posts[:maxImportedAccountPosts]
+	}
+
+	source := "discuit:" + export.Origin
+	communities := make(map[string]*Community)
+	for _, p := range export.Posts {
+		var exists bool
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) > 0 FROM imported_items WHERE source = ? AND item_type = ? AND source_id = ?", source, importedPost, p.PublicID).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			res.Posts.Skipped++
+			continue
+		}
+
+		community, ok := communities[p.Community]
+		if !ok {
+			var err error
+			if community, err = GetCommunityByName(ctx, db, p.Community, nil); err != nil && !errors.Is(err, errCommunityNotFound) {
+				return err
+			}
+			communities[p.Community] = community
+		}
+		if community == nil {
+			res.Posts.Failed++
+			res.problem("post %s: no community named %s", p.PublicID, p.Community)
+			continue
+		}
+
+		// Unlike content imported by admins, users' own posts are subject to
+		// bans and posting restrictions.
+		if banned, err := community.UserBanned(ctx, db, user); err != nil {
+			return err
+		} else if banned {
+			res.Posts.Failed++
+			res.problem("post %s: %v", p.PublicID, errUserBannedFromCommunity)
+			continue
+		}
+		if community.PostingRestricted {
+			if is, err := community.UserModOrAdmin(ctx, db, user); err != nil {
+				return err
+			} else if !is {
+				res.Posts.Failed++
+				res.problem("post %s: posting in %s is restricted", p.PublicID, community.Name)
+				continue
+			}
+		}
+
+		opts := &createPostOpts{postType: PostTypeText, body: p.Body}
+		if p.URL != "" {
+			var err error
+			if opts, err = linkPostOpts(ctx, db, p.URL, false); err != nil {
+				res.Posts.Failed++
+				res.problem("post %s: %v", p.PublicID, err)
+				continue
+			}
+		}
+		opts.author, opts.community, opts.title = user, community.ID, p.Title
+		opts.imported, opts.createdAt = true, p.CreatedAt
+		post, err := createPost(ctx, db, opts)
+		if err != nil {
+			res.Posts.Failed++
+			res.problem("post %s: %v", p.PublicID, err)
+			continue
+		}
+		if _, err := db.ExecContext(ctx, "INSERT INTO imported_items (source, item_type, source_id, target_id) VALUES (?, ?, ?, ?)", source, importedPost, p.PublicID, post.ID); err != nil {
+			return err
+		}
+		res.Posts.Created++
+	}
+	return nil
+}
+
+// fetchMigratedFrom returns the profile URL of the account that the account
+// at profileURL migrated from, if any. It's a variable so that tests can
+// replace it.
+var fetchMigratedFrom = func(ctx context.Context, profileURL string) (string, error) {
+	u, ok := validInstanceURL(profileURL)
+	if !ok {
+		return "", errors.New("invalid profile URL")
+	}
+	username, found := strings.CutPrefix(u.Path, "/@")
+	if !found || username == "" || strings.Contains(username, "/") {
+		return "", errors.New("invalid profile URL")
+	}
+	var res struct {
+		MigratedFrom *string `json:"migratedFrom"`
+	}
+	if err := getRemoteJSON(u.Scheme+"://"+u.Host+"/api/users/"+url.PathEscape(username), &res); err != nil {
+		return "", err
+	}
+	if res.MigratedFrom == nil {
+		return "", nil
+	}
+	return *res.MigratedFrom, nil
+}
+
+// SetMovedTo points the account of user at the account it moved to, whose
+// profile is at target, on another instance. The account at target must have
+// migrated from this account, whose profile is at self. If target is empty,
+// the account is no longer marked as moved.
+func (u *User) SetMovedTo(ctx context.Context, db *sql.DB, self, target string) error {
+	if u.Deleted {
+		return ErrUserDeleted
+	}
+	if target != "" {
+		if _, ok := validInstanceURL(target); !ok {
+			return httperr.NewBadRequest("invalid_url", "Invalid profile URL.")
+		}
+		from, err := fetchMigratedFrom(ctx, target)
+		if err != nil {
+			return httperr.NewBadRequest("moved_to_unverified", fmt.Sprintf("Could not get the account at %s: %v.", target, err))
+		}
+		if !strings.EqualFold(from, self) {
+			return httperr.NewBadRequest("moved_to_unverified", "The account at "+target+" has not migrated from this account.")
+		}
+	}
+	movedTo := msql.NewNullString(msql.NilIfEmptyString(target))
+	if _, err := db.ExecContext(ctx, "UPDATE users SET moved_to = ? WHERE id = ?", movedTo, u.ID); err != nil {
+		return err
+	}
+	u.MovedTo = movedTo
+	return nil
+}
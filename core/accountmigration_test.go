@@ -0,0 +1,60 @@
+package core
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestVerifyAccountExport(t *testing.T) {
+	SetAccountSigningSecret("secret")
+	defer func() { accountSigningKey = nil }()
+
+	var fetchedFrom string
+	defer func(f func(context.Context, string) (ed25519.PublicKey, error)) { fetchAccountSigningKey = f }(fetchAccountSigningKey)
+	fetchAccountSigningKey = func(ctx context.Context, origin string) (ed25519.PublicKey, error) {
+		fetchedFrom = origin
+		return AccountSigningPublicKey(), nil
+	}
+
+	sign := func(export *AccountExport) *AccountExportFile {
+		data, err := json.Marshal(export)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &AccountExportFile{Account: data, Signature: ed25519.Sign(accountSigningKey, data)}
+	}
+
+	ctx := context.Background()
+	f := sign(&AccountExport{Version: accountExportVersion, Origin: "https://old.example", Username: "alice", Subscriptions: []string{"golang"}})
+	export, err := VerifyAccountExport(ctx, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export.Username != "alice" || len(export.Subscriptions) != 1 || fetchedFrom != "https://old.example" {
+		t.Errorf("unexpected export %+v (key fetched from %q)", export, fetchedFrom)
+	}
+
+	f.Account = []byte(`{"version":1,"origin":"https://old.example","username":"mallory"}`)
+	if _, err := VerifyAccountExport(ctx, f); !errors.Is(err, errAccountExportSignature) {
+		t.Errorf("expected errAccountExportSignature for a tampered file, got %v", err)
+	}
+
+	f = sign(&AccountExport{Version: accountExportVersion + 1, Origin: "https://old.example"})
+	if _, err := VerifyAccountExport(ctx, f); !errors.Is(err, errAccountExportVersion) {
+		t.Errorf("expected errAccountExportVersion, got %v", err)
+	}
+
+	f = sign(&AccountExport{Version: accountExportVersion, Origin: "file:///etc"})
+	if _, err := VerifyAccountExport(ctx, f); err == nil {
+		t.Error("expected an error for an invalid origin")
+	}
+}
+
+func TestUserProfileURL(t *testing.T) {
+	if got := UserProfileURL("https://discuit.net/", "alice"); got != "https://discuit.net/@alice" {
+		t.Errorf("got %q", got)
+	}
+}
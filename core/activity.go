@@ -0,0 +1,191 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Activity on the site (posts, comments, reports, and bot jobs, by bots and
+// humans alike, and feeds served with experiment treatments) is published as events to subscribers (see
+// SubscribeActivity), so that the deployment of an experiment can be watched
+// live. Events are published by, and to, this process only. Subscribers that
+// fall behind miss events, rather than hold up the site.
+
+// ActivityType is the type of an activity event.
+type ActivityType string
+
+// Types of activity events.
+const (
+	ActivityPost    = ActivityType("post")
+	ActivityComment = ActivityType("comment")
+	ActivityReport  = ActivityType("report")
+	ActivityBotJob  = ActivityType("bot_job")
+	ActivityFeed    = ActivityType("feed") // Feeds served with experiment treatments.
+)
+
+// Valid reports whether t is a known activity type.
+func (t ActivityType) Valid() bool {
+	switch t {
+	case ActivityPost, ActivityComment, ActivityReport, ActivityBotJob, ActivityFeed:
+		return true
+	}
+	return false
+}
+
+// ActivityEvent is an event of activity on the site.
+type ActivityEvent struct {
+	Type ActivityType `json:"type"`
+	At   time.Time    `json:"at"`
+
+	// The author of the post or comment, or the reporter. Bot jobs have no
+	// user, but a target user: the author of the post or comment the bot
+	// responds to.
+	UserID       uid.NullID `json:"userId"`
+	Username     string     `json:"username,omitempty"`
+	IsBot        bool       `json:"isBot"`
+	TargetUserID uid.NullID `json:"targetUserId"`
+
+	CommunityID   uid.ID     `json:"communityId"`
+	CommunityName string     `json:"communityName,omitempty"`
+	PostID        uid.NullID `json:"postId"`
+	CommentID     uid.NullID `json:"commentId"`
+
+	// For reports, the reason; for bot jobs, the outcome (done, skipped,
+	// or failed); for feeds, the experiment arm and its treatments.
+	Detail string `json:"detail,omitempty"`
+}
+
+// activitySubscriberBuffer is how many events a subscriber may fall behind by
+// before it misses events.
+const activitySubscriberBuffer = 256
+
+// ActivitySubscription is a subscription to activity events.
+type ActivitySubscription struct {
+	c       chan *ActivityEvent
+	dropped atomic.Int64
+}
+
+var (
+	activitySubsMu sync.RWMutex // guards activitySubs
+	activitySubs   = make(map[*ActivitySubscription]struct{})
+)
+
+// SubscribeActivity subscribes to activity events. The subscription must be
+// closed when no longer needed.
+func SubscribeActivity() *ActivitySubscription {
+	s := &ActivitySubscription{c: make(chan *ActivityEvent, activitySubscriberBuffer)}
+	activitySubsMu.Lock()
+	defer activitySubsMu.Unlock()
+	activitySubs[s] = struct{}{}
+	return s
+}
+
+// Events returns the channel on which the events of s are delivered.
+func (s *ActivitySubscription) Events() <-chan *ActivityEvent {
+	return s.c
+}
+
+// Dropped returns the number of events s has missed by falling behind.
+func (s *ActivitySubscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Close ends s.
+func (s *ActivitySubscription) Close() {
+	activitySubsMu.Lock()
+	defer activitySubsMu.Unlock()
+	delete(activitySubs, s)
+}
+
+func hasActivitySubscribers() bool {
+	activitySubsMu.RLock()
+	defer activitySubsMu.RUnlock()
+	return len(activitySubs) > 0
+}
+
+// publishActivity fills in the user and community names of e, and sends it to
+// all subscribers. It does nothing if there are no subscribers. Errors are
+// ignored; the events are informational.
+func publishActivity(ctx context.Context, db *sql.DB, e *ActivityEvent) {
+	if !hasActivitySubscribers() {
+		return
+	}
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	if e.UserID.Valid && e.Username == "" {
+		db.QueryRowContext(ctx, "SELECT username, is_bot FROM users WHERE id = ?", e.UserID).Scan(&e.Username, &e.IsBot)
+	}
+	if e.CommunityName == "" {
+		db.QueryRowContext(ctx, "SELECT name FROM communities WHERE id = ?", e.CommunityID).Scan(&e.CommunityName)
+	}
+
+	activitySubsMu.RLock()
+	defer activitySubsMu.RUnlock()
+	for s := range activitySubs {
+		select {
+		case s.c <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// ActivityFilter selects activity events. Empty fields select all events.
+type ActivityFilter struct {
+	CommunityID *uid.ID
+	Types       []ActivityType
+
+	// Only events of users (or target users) assigned to the experiment arm.
+	ArmID *uid.ID
+
+	inArm map[uid.ID]bool // Users found in the arm.
+}
+
+// Match reports whether e is selected by f.
+func (f *ActivityFilter) Match(ctx context.Context, db *sql.DB, e *ActivityEvent) (bool, error) {
+	if f.CommunityID != nil && *f.CommunityID != e.CommunityID {
+		return false, nil
+	}
+	if len(f.Types) > 0 && !slices.Contains(f.Types, e.Type) {
+		return false, nil
+	}
+	if f.ArmID == nil {
+		return true, nil
+	}
+	for _, user := range []uid.NullID{e.UserID, e.TargetUserID} {
+		if !user.Valid {
+			continue
+		}
+		if in, err := f.userInArm(ctx, db, user.ID); err != nil || in {
+			return in, err
+		}
+	}
+	return false, nil
+}
+
+// userInArm reports whether user is assigned to the arm of f. Users found in
+// the arm are not looked up again, since assignments stick.
+func (f *ActivityFilter) userInArm(ctx context.Context, db *sql.DB, user uid.ID) (bool, error) {
+	if f.inArm[user] {
+		return true, nil
+	}
+	var n int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM experiment_assignments WHERE arm_id = ? AND user_id = ?", f.ArmID, user).Scan(&n); err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+	if f.inArm == nil {
+		f.inArm = make(map[uid.ID]bool)
+	}
+	f.inArm[user] = true
+	return true, nil
+}
@@ -0,0 +1,43 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestPublishActivity(t *testing.T) {
+	ctx := context.Background()
+	sub := SubscribeActivity()
+	defer sub.Close()
+
+	community := uid.New()
+	filter := &ActivityFilter{CommunityID: &community, Types: []ActivityType{ActivityComment}}
+	events := []*ActivityEvent{
+		{Type: ActivityComment, CommunityID: community, CommunityName: "a"},
+		{Type: ActivityPost, CommunityID: community, CommunityName: "a"},
+		{Type: ActivityComment, CommunityID: uid.New(), CommunityName: "b"},
+	}
+	for _, e := range events {
+		publishActivity(ctx, nil, e)
+	}
+	for i, e := range events {
+		got := <-sub.Events()
+		if got != e {
+			t.Fatalf("event %d: got %+v, want %+v", i, got, e)
+		}
+		if match, err := filter.Match(ctx, nil, got); err != nil {
+			t.Fatal(err)
+		} else if match != (i == 0) {
+			t.Errorf("Match(event %d) = %v, want %v", i, match, i == 0)
+		}
+	}
+
+	for i := 0; i < activitySubscriberBuffer+3; i++ {
+		publishActivity(ctx, nil, &ActivityEvent{Type: ActivityPost, CommunityName: "a"})
+	}
+	if n := sub.Dropped(); n != 3 {
+		t.Errorf("Dropped() = %d, want 3", n)
+	}
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Mods can mark a community as age-restricted (18+; see
+// Community.AgeRestricted). Age-restricted communities, and their posts, can
+// only be viewed by logged in users who have confirmed that they're adults
+// (see User.ConfirmAge). For everyone else, they're left out of feeds,
+// community lists, and search results, and requests for them fail with
+// errAgeRestricted.
+
+var errAgeRestricted = httperr.NewForbidden("age_restricted", "This community is for adults only. Log in and confirm your age to view it.")
+
+// ConfirmAge records that u has confirmed being an adult, or, if confirm is
+// false, withdraws the confirmation.
+func (u *User) ConfirmAge(ctx context.Context, db *sql.DB, confirm bool) error {
+	if u.Deleted {
+		return ErrUserDeleted
+	}
+	var at msql.NullTime
+	if confirm {
+		at = msql.NewNullTime(time.Now())
+	}
+	if _, err := db.ExecContext(ctx, "UPDATE users SET age_confirmed_at = ? WHERE id = ?", at, u.ID); err != nil {
+		return err
+	}
+	u.AgeConfirmedAt = at
+	u.AgeConfirmed = at.Valid
+	return nil
+}
+
+// viewerAgeConfirmed reports whether viewer, who's nil if not logged in, can
+// view age-restricted content.
+func viewerAgeConfirmed(ctx context.Context, db *sql.DB, viewer *uid.ID) (bool, error) {
+	if viewer == nil {
+		return false, nil
+	}
+	var confirmed bool
+	err := db.QueryRowContext(ctx, "SELECT age_confirmed_at IS NOT NULL FROM users WHERE id = ?", *viewer).Scan(&confirmed)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return confirmed, err
+}
+
+// CheckAgeRestriction returns an error if content that's age-restricted, as
+// reported by restricted, cannot be viewed by viewer (nil if not logged in).
+func CheckAgeRestriction(ctx context.Context, db *sql.DB, restricted bool, viewer *uid.ID) error {
+	if !restricted {
+		return nil
+	}
+	if confirmed, err := viewerAgeConfirmed(ctx, db, viewer); err != nil {
+		return err
+	} else if !confirmed {
+		return errAgeRestricted
+	}
+	return nil
+}
+
+// whereNotAgeRestricted appends to where (of a query on a posts table) a
+// condition that leaves out the posts of age-restricted communities.
+func whereNotAgeRestricted(where string) string {
+	if !(where == "" || strings.TrimSpace(strings.ToUpper(where)) == "WHERE") {
+		where += "AND "
+	}
+	return where + "community_id NOT IN (SELECT id FROM communities WHERE age_restricted = TRUE) "
+}
+
+// setAgeRestriction checks whether the viewer of the feed of opts can
+// view age-restricted content. If they cannot, it returns errAgeRestricted
+// for the feeds of age-restricted communities, and leaves age-restricted
+// communities out of site-wide feeds.
+func (opts *FeedOptions) setAgeRestriction(ctx context.Context, db *sql.DB) error {
+	confirmed, err := viewerAgeConfirmed(ctx, db, opts.Viewer)
+	if err != nil || confirmed {
+		return err
+	}
+	if opts.Community != nil {
+		var restricted bool
+		if err := db.QueryRowContext(ctx, "SELECT age_restricted FROM communities WHERE id = ?", *opts.Community).Scan(&restricted); err != nil {
+			if err == sql.ErrNoRows {
+				return errCommunityNotFound
+			}
+			return err
+		}
+		if restricted {
+			return errAgeRestricted
+		}
+		return nil
+	}
+	opts.hideAgeRestricted = true
+	return nil
+}
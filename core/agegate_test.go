@@ -0,0 +1,19 @@
+package core
+
+import "testing"
+
+func TestWhereNotAgeRestricted(t *testing.T) {
+	const cond = "community_id NOT IN (SELECT id FROM communities WHERE age_restricted = TRUE) "
+	tests := []struct {
+		where, want string
+	}{
+		{"", cond},
+		{"WHERE ", "WHERE " + cond},
+		{"WHERE deleted = FALSE ", "WHERE deleted = FALSE AND " + cond},
+	}
+	for _, test := range tests {
+		if got := whereNotAgeRestricted(test.where); got != test.want {
+			t.Errorf("whereNotAgeRestricted(%q) = %q, want %q", test.where, got, test.want)
+		}
+	}
+}
@@ -56,12 +56,17 @@ type BasicSiteStats struct {
 	PostsLastWeek        int `json:"posts_week"`
 	CommentsLastDay      int `json:"comments_day"` // last 24 hours
 	CommentsLastWeek     int `json:"comments_week"`
+
+	// Since version 1.
+	UsersLastHalfYear int `json:"users_halfyear"`
+	TotalPosts        int `json:"posts"`    // Undeleted posts.
+	TotalComments     int `json:"comments"` // Undeleted comments.
 }
 
 const BasicSiteStatsEventName = "bss"
 
 func RecordBasicSiteStats(ctx context.Context, db *sql.DB) error {
-	stats := &BasicSiteStats{Version: 0}
+	stats := &BasicSiteStats{Version: 1}
 	if err := db.QueryRow("select count(*) from users where last_seen > subdate(now(), 1)").Scan(&stats.UsersLastDay); err != nil {
 		return err
 	}
@@ -101,11 +106,38 @@ func RecordBasicSiteStats(ctx context.Context, db *sql.DB) error {
 	if err := db.QueryRow("select count(*) from comments where created_at > subdate(now(), 7)").Scan(&stats.CommentsLastWeek); err != nil {
 		return err
 	}
+	if err := db.QueryRow("select count(*) from users where last_seen > subdate(now(), 180)").Scan(&stats.UsersLastHalfYear); err != nil {
+		return err
+	}
+	if err := db.QueryRow("select count(*) from posts where deleted = false").Scan(&stats.TotalPosts); err != nil {
+		return err
+	}
+	if err := db.QueryRow("select count(*) from comments where deleted_at is null").Scan(&stats.TotalComments); err != nil {
+		return err
+	}
 
 	b, _ := json.Marshal(stats)
 	return CreateAnalyticsEvent(ctx, db, BasicSiteStatsEventName, "", string(b))
 }
 
+// GetLatestBasicSiteStats returns the most recently recorded basic site
+// stats, or nil if none were recorded yet.
+func GetLatestBasicSiteStats(ctx context.Context, db *sql.DB) (*BasicSiteStats, error) {
+	var payload string
+	err := db.QueryRowContext(ctx, "SELECT payload FROM analytics WHERE event_name = ? ORDER BY created_at DESC LIMIT 1", BasicSiteStatsEventName).Scan(&payload)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	stats := &BasicSiteStats{}
+	if err := json.Unmarshal([]byte(payload), stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
 func GetBasicSiteStats(ctx context.Context, db *sql.DB, days int) ([]*AnalyticsEvent, error) {
 	rows, err := db.QueryContext(ctx, "SELECT payload, created_at FROM analytics WHERE event_name = ? ORDER BY created_at DESC", BasicSiteStatsEventName)
 	if err != nil {
@@ -0,0 +1,286 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Admins issue API keys to researchers, archive projects, and the like, so
+// that they can crawl public content without logging in. Requests made with
+// an API key are anonymous, read-only, limited to the scopes of the key, and
+// rate limited by the tier of the key (tiers are defined by the server).
+// Only a hash of each key is stored; the key itself is shown once, when it's
+// created.
+
+// APIKeyScope is the part of the API an API key can read.
+type APIKeyScope string
+
+// Valid API key scopes.
+const (
+	APIKeyScopePosts       = APIKeyScope("read:posts")
+	APIKeyScopeComments    = APIKeyScope("read:comments")
+	APIKeyScopeCommunities = APIKeyScope("read:communities")
+	APIKeyScopeUsers       = APIKeyScope("read:users")
+)
+
+// Valid reports whether s is a valid API key scope.
+func (s APIKeyScope) Valid() bool {
+	switch s {
+	case APIKeyScopePosts, APIKeyScopeComments, APIKeyScopeCommunities, APIKeyScopeUsers:
+		return true
+	}
+	return false
+}
+
+// apiKeyPrefix is the prefix of all API keys, which makes them easy to
+// recognize (in leaked config files, for instance).
+const apiKeyPrefix = "dk_"
+
+var errInvalidAPIKey = &httperr.Error{
+	HTTPStatus: http.StatusUnauthorized,
+	Code:       "invalid_api_key",
+	Message:    "Invalid or revoked API key.",
+}
+
+// APIKey is an admin-issued API key.
+type APIKey struct {
+	ID         int             `json:"id"`
+	Prefix     string          `json:"prefix"` // The first few characters of the key.
+	Name       string          `json:"name"`
+	Contact    msql.NullString `json:"contact"`
+	Tier       string          `json:"tier"`
+	Scopes     []APIKeyScope   `json:"scopes"`
+	CreatedBy  uid.NullID      `json:"createdBy"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	LastUsedAt msql.NullTime   `json:"lastUsedAt"`
+	RevokedAt  msql.NullTime   `json:"revokedAt"`
+}
+
+// HasScope reports whether k can read the part of the API of scope.
+func (k *APIKey) HasScope(scope APIKeyScope) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+// hashAPIKey returns the hash of key that's stored in the database.
+func hashAPIKey(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
+
+// parseAPIKeyScopes parses a comma separated list of scopes, as stored in the
+// database.
+func parseAPIKeyScopes(s string) []APIKeyScope {
+	scopes := []APIKeyScope{}
+	for _, scope := range strings.Split(s, ",") {
+		if scope != "" {
+			scopes = append(scopes, APIKeyScope(scope))
+		}
+	}
+	return scopes
+}
+
+func joinAPIKeyScopes(scopes []APIKeyScope) string {
+	strs := make([]string, len(scopes))
+	for i, scope := range scopes {
+		strs[i] = string(scope)
+	}
+	return strings.Join(strs, ",")
+}
+
+const selectAPIKeysQuery = "SELECT id, key_prefix, name, contact, tier, scopes, created_by, created_at, last_used_at, revoked_at FROM api_keys "
+
+func scanAPIKeys(rows *sql.Rows) ([]*APIKey, error) {
+	defer rows.Close()
+	keys := []*APIKey{}
+	for rows.Next() {
+		k := &APIKey{}
+		var scopes string
+		if err := rows.Scan(&k.ID, &k.Prefix, &k.Name, &k.Contact, &k.Tier, &scopes, &k.CreatedBy, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt); err != nil {
+			return nil, err
+		}
+		k.Scopes = parseAPIKeyScopes(scopes)
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// GetAPIKeys returns all API keys, revoked ones included, newest first.
+func GetAPIKeys(ctx context.Context, db *sql.DB) ([]*APIKey, error) {
+	rows, err := db.QueryContext(ctx, selectAPIKeysQuery+"ORDER BY id DESC")
+	if err != nil {
+		return nil, err
+	}
+	return scanAPIKeys(rows)
+}
+
+// CreateAPIKey issues a new API key, on behalf of admin, and returns it along
+// with the key itself (which cannot be retrieved later). The caller must make
+// sure that tier is a valid tier.
+func CreateAPIKey(ctx context.Context, db *sql.DB, admin uid.ID, name, contact, tier string, scopes []APIKeyScope) (*APIKey, string, error) {
+	if is, err := IsAdmin(db, &admin); err != nil {
+		return nil, "", err
+	} else if !is {
+		return nil, "", errNotAdmin
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 255 {
+		return nil, "", httperr.NewBadRequest("invalid_name", "API key name must be between 1 and 255 characters long.")
+	}
+	contact = strings.TrimSpace(contact)
+	if len(contact) > 255 {
+		return nil, "", httperr.NewBadRequest("invalid_contact", "Contact too long.")
+	}
+	if len(scopes) == 0 {
+		return nil, "", httperr.NewBadRequest("no_scopes", "An API key needs at least one scope.")
+	}
+	var uniqueScopes []APIKeyScope
+	for _, scope := range scopes {
+		if !scope.Valid() {
+			return nil, "", httperr.NewBadRequest("invalid_scope", "Invalid API key scope: "+string(scope)+".")
+		}
+		if !slices.Contains(uniqueScopes, scope) {
+			uniqueScopes = append(uniqueScopes, scope)
+		}
+	}
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	secret := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	k := &APIKey{
+		Prefix:    secret[:len(apiKeyPrefix)+8],
+		Name:      name,
+		Contact:   msql.NewNullString(msql.NilIfEmptyString(contact)),
+		Tier:      tier,
+		Scopes:    uniqueScopes,
+		CreatedBy: uid.NullID{ID: admin, Valid: true},
+		CreatedAt: time.Now(),
+	}
+	res, err := db.ExecContext(ctx, "INSERT INTO api_keys (key_hash, key_prefix, name, contact, tier, scopes, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		hashAPIKey(secret), k.Prefix, k.Name, k.Contact, k.Tier, joinAPIKeyScopes(k.Scopes), k.CreatedBy, k.CreatedAt)
+	if err != nil {
+		return nil, "", err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, "", err
+	}
+	k.ID = int(id)
+	return k, secret, nil
+}
+
+// RevokeAPIKey revokes the API key with id, on behalf of admin. Requests made
+// with the key fail from then on.
+func RevokeAPIKey(ctx context.Context, db *sql.DB, admin uid.ID, id int) error {
+	if is, err := IsAdmin(db, &admin); err != nil {
+		return err
+	} else if !is {
+		return errNotAdmin
+	}
+
+	res, err := db.ExecContext(ctx, "UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return &httperr.Error{HTTPStatus: http.StatusNotFound, Code: "api_key_not_found", Message: "API key not found or already revoked."}
+	}
+
+	apiKeysCache.bust()
+	return nil
+}
+
+// AuthenticateAPIKey returns the API key of secret, or an error if there's no
+// such key or if it's revoked.
+func AuthenticateAPIKey(ctx context.Context, db *sql.DB, secret string) (*APIKey, error) {
+	if !strings.HasPrefix(secret, apiKeyPrefix) {
+		return nil, errInvalidAPIKey
+	}
+	hash := string(hashAPIKey(secret))
+	if k := apiKeysCache.get(hash); k != nil {
+		return k, nil
+	}
+
+	rows, err := db.QueryContext(ctx, selectAPIKeysQuery+"WHERE key_hash = ? AND revoked_at IS NULL", []byte(hash))
+	if err != nil {
+		return nil, err
+	}
+	keys, err := scanAPIKeys(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, errInvalidAPIKey
+	}
+	k := keys[0]
+
+	// Keys are looked up once a minute at most (while they're cached), and so
+	// is their last used time updated.
+	now := time.Now()
+	if _, err := db.ExecContext(ctx, "UPDATE api_keys SET last_used_at = ? WHERE id = ?", now, k.ID); err != nil {
+		return nil, err
+	}
+	k.LastUsedAt = msql.NewNullTime(now)
+
+	apiKeysCache.put(hash, k)
+	return k, nil
+}
+
+// apiKeysCacheStore caches API keys, by their hashes, for a minute.
+type apiKeysCacheStore struct {
+	mu   sync.Mutex // guards following
+	keys map[string]apiKeysCacheEntry
+}
+
+type apiKeysCacheEntry struct {
+	key     *APIKey
+	fetched time.Time
+}
+
+var apiKeysCache = &apiKeysCacheStore{}
+
+func (kc *apiKeysCacheStore) get(hash string) *APIKey {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	entry, ok := kc.keys[hash]
+	if !ok {
+		return nil
+	}
+	if time.Since(entry.fetched) >= time.Minute {
+		delete(kc.keys, hash)
+		return nil
+	}
+	return entry.key
+}
+
+func (kc *apiKeysCacheStore) put(hash string, k *APIKey) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	if kc.keys == nil {
+		kc.keys = make(map[string]apiKeysCacheEntry)
+	}
+	kc.keys[hash] = apiKeysCacheEntry{key: k, fetched: time.Now()}
+}
+
+func (kc *apiKeysCacheStore) bust() {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	kc.keys = nil
+}
@@ -0,0 +1,28 @@
+package core
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAPIKeyScopes(t *testing.T) {
+	scopes := []APIKeyScope{APIKeyScopePosts, APIKeyScopeComments}
+	joined := joinAPIKeyScopes(scopes)
+	if joined != "read:posts,read:comments" {
+		t.Errorf("joinAPIKeyScopes = %q", joined)
+	}
+	if got := parseAPIKeyScopes(joined); !slices.Equal(got, scopes) {
+		t.Errorf("parseAPIKeyScopes(%q) = %v, want %v", joined, got, scopes)
+	}
+	if got := parseAPIKeyScopes(""); len(got) != 0 {
+		t.Errorf("parseAPIKeyScopes(\"\") = %v, want no scopes", got)
+	}
+
+	k := &APIKey{Scopes: scopes}
+	if !k.HasScope(APIKeyScopeComments) || k.HasScope(APIKeyScopeUsers) {
+		t.Error("HasScope returned a wrong result")
+	}
+	if APIKeyScope("write:posts").Valid() {
+		t.Error("write:posts is not a valid scope")
+	}
+}
@@ -0,0 +1,262 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Mods and admins can archive a community (see Community.Archive), which
+// makes it read-only: its content is preserved, but no posts, comments, edits,
+// or votes can be made in it. Admins can later schedule an archived community
+// to be purged (see Community.SchedulePurge). Once the purge delay is over,
+// PurgeArchivedCommunities erases the content of the community in batches,
+// over as many runs as it takes, and then deletes the community.
+
+var (
+	errCommunityArchived    = httperr.NewForbidden("community_archived", "This community is archived.")
+	errCommunityNotArchived = httperr.NewBadRequest("community_not_archived", "The community is not archived.")
+	errCommunityPurging     = httperr.NewForbidden("community_purging", "The community is being purged.")
+)
+
+// Archived reports whether c is archived.
+func (c *Community) Archived() bool {
+	return c.ArchivedAt.Valid
+}
+
+// Archive makes c read-only. Archiving an archived community does nothing.
+func (c *Community) Archive(ctx context.Context, db *sql.DB, mod uid.ID) error {
+	if is, err := c.UserModOrAdmin(ctx, db, mod); err != nil {
+		return err
+	} else if !is {
+		return errNotMod
+	}
+	if c.Archived() {
+		return nil
+	}
+
+	now := time.Now()
+	if _, err := db.ExecContext(ctx, "UPDATE communities SET archived_at = ?, archived_by = ? WHERE id = ? AND archived_at IS NULL", now, mod, c.ID); err != nil {
+		return err
+	}
+	c.ArchivedAt = msql.NewNullTime(now)
+	return nil
+}
+
+// Unarchive lifts the archival of c, and cancels its purge, if one is
+// scheduled. It returns an error if c is already being purged.
+func (c *Community) Unarchive(ctx context.Context, db *sql.DB, mod uid.ID) error {
+	if is, err := c.UserModOrAdmin(ctx, db, mod); err != nil {
+		return err
+	} else if !is {
+		return errNotMod
+	}
+
+	res, err := db.ExecContext(ctx, `
+		UPDATE communities SET archived_at = NULL, archived_by = NULL, purge_after = NULL, purge_scheduled_by = NULL
+		WHERE id = ? AND purge_started_at IS NULL`, c.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errCommunityPurging
+	}
+	c.ArchivedAt = msql.NullTime{}
+	c.PurgeAfter = msql.NullTime{}
+	return nil
+}
+
+// SchedulePurge schedules the archived community c to be purged after delay
+// (by PurgeArchivedCommunities). Rescheduling a purge that's not started yet
+// changes its time. Only admins can schedule purges.
+func (c *Community) SchedulePurge(ctx context.Context, db *sql.DB, admin uid.ID, delay time.Duration) error {
+	if !c.Archived() {
+		return errCommunityNotArchived
+	}
+
+	after := time.Now().Add(delay)
+	res, err := db.ExecContext(ctx, `
+		UPDATE communities SET purge_after = ?, purge_scheduled_by = ?
+		WHERE id = ? AND archived_at IS NOT NULL AND purge_started_at IS NULL`, after, admin, c.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errCommunityPurging
+	}
+	c.PurgeAfter = msql.NewNullTime(after)
+	return nil
+}
+
+// CancelPurge cancels the scheduled purge of c. It returns an error if c is
+// already being purged.
+func (c *Community) CancelPurge(ctx context.Context, db *sql.DB) error {
+	res, err := db.ExecContext(ctx, "UPDATE communities SET purge_after = NULL, purge_scheduled_by = NULL WHERE id = ? AND purge_started_at IS NULL", c.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errCommunityPurging
+	}
+	c.PurgeAfter = msql.NullTime{}
+	return nil
+}
+
+// checkCommunityNotArchived returns errCommunityArchived if the community
+// with id is archived.
+func checkCommunityNotArchived(ctx context.Context, db *sql.DB, id uid.ID) error {
+	var archived bool
+	if err := db.QueryRowContext(ctx, "SELECT archived_at IS NOT NULL FROM communities WHERE id = ?", id).Scan(&archived); err != nil {
+		if err == sql.ErrNoRows {
+			return errCommunityNotFound
+		}
+		return err
+	}
+	if archived {
+		return errCommunityArchived
+	}
+	return nil
+}
+
+// CommunityPurgeResult is the outcome of purging an archived community.
+type CommunityPurgeResult struct {
+	CommunityID uid.ID `json:"communityId"`
+	Posts       int    `json:"posts"`    // Posts purged.
+	Comments    int    `json:"comments"` // Comments purged.
+	Images      int    `json:"images"`   // Images of posts deleted.
+	Done        bool   `json:"done"`     // False if the purge was interrupted.
+
+	// Items that were left alone because of a legal hold.
+	HeldPosts    int `json:"heldPosts"`
+	HeldComments int `json:"heldComments"`
+}
+
+// PurgeArchivedCommunities purges the archived communities whose purge is
+// due: the content of all their posts and comments (except those under legal
+// hold) is permanently erased, their images are deleted, and the communities
+// are marked deleted. A purge that's interrupted is resumed on the next call.
+// If batchSize is not positive, it defaults to 100.
+func PurgeArchivedCommunities(ctx context.Context, db *sql.DB, batchSize int) ([]*CommunityPurgeResult, error) {
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT id FROM communities
+		WHERE archived_at IS NOT NULL AND purge_after <= ? AND purged_at IS NULL`, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	ids, err := scanIDs(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*CommunityPurgeResult
+	for _, id := range ids {
+		res, err := purgeCommunity(ctx, db, id, batchSize)
+		if res != nil {
+			results = append(results, res)
+		}
+		if err != nil {
+			return results, fmt.Errorf("purging community %v: %w", id, err)
+		}
+	}
+	return results, nil
+}
+
+func purgeCommunity(ctx context.Context, db *sql.DB, id uid.ID, batchSize int) (*CommunityPurgeResult, error) {
+	if _, err := db.ExecContext(ctx, "UPDATE communities SET purge_started_at = ? WHERE id = ? AND purge_started_at IS NULL", time.Now(), id); err != nil {
+		return nil, err
+	}
+	res := &CommunityPurgeResult{CommunityID: id}
+
+	postsWhere := "community_id = ? AND purged_at IS NULL"
+	postsHeld := heldCondition(map[LegalHoldTarget]string{LegalHoldUser: "posts.user_id", LegalHoldPost: "posts.id"})
+	err := purgeCommunityBatches(ctx, db, fmt.Sprintf("SELECT id FROM posts WHERE %s AND NOT %s", postsWhere, postsHeld), id, batchSize, func(ids []uid.ID) error {
+		n, err := purgePosts(ctx, db, ids)
+		res.Posts += len(ids)
+		res.Images += n
+		return err
+	})
+	if err != nil {
+		return res, err
+	}
+
+	commentsWhere := "community_id = ? AND purged_at IS NULL"
+	commentsHeld := heldCondition(map[LegalHoldTarget]string{
+		LegalHoldUser:    "comments.user_id",
+		LegalHoldPost:    "comments.post_id",
+		LegalHoldComment: "comments.id",
+	})
+	err = purgeCommunityBatches(ctx, db, fmt.Sprintf("SELECT id FROM comments WHERE %s AND NOT %s", commentsWhere, commentsHeld), id, batchSize, func(ids []uid.ID) error {
+		res.Comments += len(ids)
+		return purgeComments(ctx, db, ids)
+	})
+	if err != nil {
+		return res, err
+	}
+
+	if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM posts WHERE %s AND %s", postsWhere, postsHeld), id).Scan(&res.HeldPosts); err != nil {
+		return res, err
+	}
+	if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM comments WHERE %s AND %s", commentsWhere, commentsHeld), id).Scan(&res.HeldComments); err != nil {
+		return res, err
+	}
+
+	comm, err := GetCommunityByID(ctx, db, id, nil)
+	if err != nil {
+		return res, err
+	}
+	if err := comm.DeleteProPic(ctx, db); err != nil {
+		return res, err
+	}
+	if err := comm.DeleteBannerImage(ctx, db); err != nil {
+		return res, err
+	}
+	now := time.Now()
+	if _, err := db.ExecContext(ctx, `
+		UPDATE communities SET purged_at = ?, deleted_at = IFNULL(deleted_at, ?), deleted_by = IFNULL(deleted_by, purge_scheduled_by)
+		WHERE id = ?`, now, now, id); err != nil {
+		return res, err
+	}
+	res.Done = true
+	return res, nil
+}
+
+// purgeCommunityBatches calls f with batches of the IDs returned by query
+// (which takes the community ID as an argument), until query returns no
+// more. f must make the items it's called with no longer match query.
+func purgeCommunityBatches(ctx context.Context, db *sql.DB, query string, community uid.ID, batchSize int, f func(ids []uid.ID) error) error {
+	query += " ORDER BY id LIMIT ?"
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rows, err := db.QueryContext(ctx, query, community, batchSize)
+		if err != nil {
+			return err
+		}
+		ids, err := scanIDs(rows)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		if err := f(ids); err != nil {
+			return err
+		}
+	}
+}
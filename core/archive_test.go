@@ -0,0 +1,81 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPurgeArchivedCommunities(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+
+	author := h.newUser(db, "archivist", false)
+	admin := h.newUser(db, "purger", false)
+	post := h.newImagePost(db, author, "archived")
+	if _, err := Comments().Create(h.ctx, db, &NewComment{Post: post, Author: author.ID, Body: "A comment."}); err != nil {
+		t.Fatal(err)
+	}
+	stored := h.storedImages()
+
+	comm, err := GetCommunityByID(h.ctx, db, post.CommunityID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := comm.Archive(h.ctx, db, author.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := comm.SchedulePurge(h.ctx, db, admin.ID, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	purged := func() (posts, comments int) {
+		t.Helper()
+		if err := db.QueryRowContext(h.ctx, "SELECT COUNT(*) FROM posts WHERE community_id = ? AND purged_at IS NOT NULL", comm.ID).Scan(&posts); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.QueryRowContext(h.ctx, "SELECT COUNT(*) FROM comments WHERE community_id = ? AND purged_at IS NOT NULL", comm.ID).Scan(&comments); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	// Nothing is purged before the purge is due.
+	results, err := PurgeArchivedCommunities(h.ctx, db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, c := purged(); len(results) != 0 || p != 0 || c != 0 || h.storedImages() != stored {
+		t.Errorf("purged %d communities, %d posts, and %d comments before the purge was due", len(results), p, c)
+	}
+
+	if err := comm.SchedulePurge(h.ctx, db, admin.ID, -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if results, err = PurgeArchivedCommunities(h.ctx, db, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("purged %d communities, want 1", len(results))
+	}
+	if res := results[0]; !res.Done || res.Posts != 1 || res.Comments != 1 || res.Images != 1 {
+		t.Errorf("got purge result %+v, want 1 post, 1 comment, and 1 image purged", res)
+	}
+	if p, c := purged(); p != 1 || c != 1 || h.storedImages() != stored-1 {
+		t.Errorf("%d posts and %d comments are purged, and %d image files stored; want 1, 1, and %d", p, c, h.storedImages(), stored-1)
+	}
+	var deleted bool
+	if err := db.QueryRowContext(h.ctx, "SELECT deleted_at IS NOT NULL FROM communities WHERE id = ?", comm.ID).Scan(&deleted); err != nil {
+		t.Fatal(err)
+	}
+	if !deleted {
+		t.Error("the purged community is not deleted")
+	}
+
+	// A purged community is not purged again.
+	if results, err = PurgeArchivedCommunities(h.ctx, db, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Errorf("purged %d communities again", len(results))
+	}
+}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/images"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// The bytes served for images (see images.FlushBandwidth) are rolled up, by
+// day, per user and per community that the images belong to. The images of
+// posts count toward both the community of the post and its author; the
+// profile pictures and banners of users and communities toward them.
+
+// bandwidthOwners are the ways in which images belong to users and
+// communities. Each is a join, of image_bandwidth b, and the owner ID column
+// of the joined table.
+var bandwidthOwners = []struct {
+	ownerType images.QuotaOwnerType
+	join      string
+	ownerID   string
+}{
+	{images.QuotaOwnerCommunity, "JOIN post_images ON post_images.image_id = b.image_id JOIN posts ON posts.id = post_images.post_id", "posts.community_id"},
+	{images.QuotaOwnerUser, "JOIN post_images ON post_images.image_id = b.image_id JOIN posts ON posts.id = post_images.post_id", "posts.user_id"},
+	{images.QuotaOwnerCommunity, "JOIN posts ON posts.link_image = b.image_id", "posts.community_id"},
+	{images.QuotaOwnerUser, "JOIN posts ON posts.link_image = b.image_id", "posts.user_id"},
+	{images.QuotaOwnerCommunity, "JOIN communities ON communities.pro_pic_2 = b.image_id", "communities.id"},
+	{images.QuotaOwnerCommunity, "JOIN communities ON communities.banner_image_2 = b.image_id", "communities.id"},
+	{images.QuotaOwnerUser, "JOIN users ON users.pro_pic = b.image_id", "users.id"},
+}
+
+// RollupBandwidth recomputes the bandwidth rollups of users and communities
+// for day (a date, in UTC) from the bandwidth usage of their images.
+func RollupBandwidth(ctx context.Context, db *sql.DB, day time.Time) error {
+	var selects []string
+	var args []any
+	for _, o := range bandwidthOwners {
+		selects = append(selects, "SELECT '"+string(o.ownerType)+"' AS owner_type, "+o.ownerID+` AS owner_id,
+			b.requests, b.bytes, b.external_requests, b.external_bytes
+			FROM image_bandwidth b `+o.join+" WHERE b.day = ?")
+		args = append(args, day)
+	}
+	query := `INSERT INTO bandwidth_rollups (day, owner_type, owner_id, requests, bytes, external_requests, external_bytes)
+		SELECT ?, owner_type, owner_id, SUM(requests), SUM(bytes), SUM(external_requests), SUM(external_bytes)
+		FROM (` + strings.Join(selects, " UNION ALL ") + `) AS usages
+		GROUP BY owner_type, owner_id`
+
+	return msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM bandwidth_rollups WHERE day = ?", day); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, query, append([]any{day}, args...)...)
+		return err
+	})
+}
+
+// BandwidthRollup is the bandwidth usage of the images of a user or of a
+// community on a day.
+type BandwidthRollup struct {
+	Day       time.Time             `json:"day"`
+	OwnerType images.QuotaOwnerType `json:"ownerType"`
+	OwnerID   uid.ID                `json:"ownerId"`
+	OwnerName string                `json:"ownerName"` // The username, or the name of the community.
+	images.BandwidthUsage
+}
+
+func bandwidthRollupsQuery(where string) string {
+	return `SELECT r.day, r.owner_type, r.owner_id, COALESCE(users.username, communities.name, ''),
+			r.requests, r.bytes, r.external_requests, r.external_bytes
+		FROM bandwidth_rollups r
+		LEFT JOIN users ON r.owner_type = 'user' AND users.id = r.owner_id
+		LEFT JOIN communities ON r.owner_type = 'community' AND communities.id = r.owner_id ` + where
+}
+
+func scanBandwidthRollups(rows *sql.Rows) ([]*BandwidthRollup, error) {
+	defer rows.Close()
+	list := []*BandwidthRollup{}
+	for rows.Next() {
+		r := &BandwidthRollup{}
+		if err := rows.Scan(&r.Day, &r.OwnerType, &r.OwnerID, &r.OwnerName, &r.Requests, &r.Bytes, &r.ExternalRequests, &r.ExternalBytes); err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+// GetBandwidthRollups returns the limit owners of type t whose images used the
+// most bandwidth on day. If external is true, they're ordered by the bytes
+// served to other sites.
+func GetBandwidthRollups(ctx context.Context, db *sql.DB, t images.QuotaOwnerType, day time.Time, external bool, limit int) ([]*BandwidthRollup, error) {
+	order := "r.bytes"
+	if external {
+		order = "r.external_bytes"
+	}
+	rows, err := db.QueryContext(ctx, bandwidthRollupsQuery("WHERE r.day = ? AND r.owner_type = ? ORDER BY "+order+" DESC LIMIT ?"), day, t, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanBandwidthRollups(rows)
+}
+
+// GetOwnerBandwidth returns the daily bandwidth rollups of owner for the days
+// since since, latest first. Days with no usage are left out.
+func GetOwnerBandwidth(ctx context.Context, db *sql.DB, owner images.QuotaOwner, since time.Time) ([]*BandwidthRollup, error) {
+	rows, err := db.QueryContext(ctx, bandwidthRollupsQuery("WHERE r.owner_type = ? AND r.owner_id = ? AND r.day >= ? ORDER BY r.day DESC"), owner.Type, owner.ID, since)
+	if err != nil {
+		return nil, err
+	}
+	return scanBandwidthRollups(rows)
+}
@@ -3,107 +3,65 @@ package core
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
-	"io"
-	"math/rand"
-	"net/http"
-	"os"
-	"strings"
 	"time"
 
+	"github.com/discuitnet/discuit/internal/llm"
+	"github.com/discuitnet/discuit/internal/logging"
+	"github.com/discuitnet/discuit/internal/tracing"
 	"github.com/discuitnet/discuit/internal/uid"
 )
 
-// GenerateBotResponse generates a response using ChatGPT API
-func GenerateBotResponse(ctx context.Context, prompt string, personality string) (string, error) {
-	// Get API key from environment variable
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		return "", fmt.Errorf("OPENAI_API_KEY environment variable not set")
+// GenerateBotResponse generates a response with the language model of bots
+// (see SetBotLLMProvider), written as persona, if it's not nil. It fails with
+// ErrBotsHalted if the bot kill switch is on, and with ErrBotBudgetExceeded if
+// the bot budget is spent (see SetBotBudget).
+func GenerateBotResponse(ctx context.Context, prompt string, persona *BotPersona) (string, error) {
+	if BotsHalted() {
+		return "", ErrBotsHalted
+	}
+	if err := defaultBotBudget.reserve(now()); err != nil {
+		return "", err
+	}
+
+	req := &llm.Request{
+		Model:     defaultBotModel,
+		Messages:  []llm.Message{{Role: "user", Content: prompt}},
+		MaxTokens: 150,
+	}
+	if persona != nil {
+		temperature := persona.Temperature
+		req.Model = persona.Model
+		req.Temperature = &temperature
+		req.Messages = append([]llm.Message{{Role: "system", Content: persona.systemMessage()}}, req.Messages...)
+	}
+
+	spanCtx, span := tracing.Start(ctx, tracing.KindClient, "chat "+req.Model,
+		tracing.String("gen_ai.operation.name", "chat"),
+		tracing.String("gen_ai.request.model", req.Model),
+		tracing.Int("gen_ai.request.max_tokens", req.MaxTokens))
+	start := time.Now()
+	res, err := botLLMProvider().Complete(spanCtx, req)
+	observeBotLLMCall(req.Model, start, res, err)
+	if res != nil {
+		span.SetAttributes(
+			tracing.Int("gen_ai.usage.input_tokens", res.PromptTokens),
+			tracing.Int("gen_ai.usage.output_tokens", res.CompletionTokens))
+	}
+	span.Finish(err)
+	if res != nil {
+		defaultBotBudget.record(now(), res.PromptTokens, res.CompletionTokens)
 	}
-	
-	// Prepare the request to ChatGPT API
-	reqBody := map[string]interface{}{
-		"model": "gpt-4o-mini",
-		"messages": []map[string]string{
-			{
-				"role":    "user",
-				"content": prompt,
-			},
-		},
-		"max_tokens": 150,
-	}
-
-	jsonBody, err := json.Marshal(reqBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	// Log the request for debugging
-	fmt.Printf("OpenAI API Request: %s\n", string(jsonBody))
-
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/chat/completions", strings.NewReader(string(jsonBody)))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-
-	client := &http.Client{}
-	fmt.Println("Sending request to OpenAI API...")
-	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to make request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	fmt.Printf("Received response with status code: %d\n", resp.StatusCode)
-
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	// Log the response for debugging
-	fmt.Printf("OpenAI API Response: %s\n", string(body))
-
-	// Check for non-200 status code
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("OpenAI API returned status %d: %s", resp.StatusCode, string(body))
-	}
-
-	var result struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
-		Error struct {
-			Message string `json:"message"`
-			Type    string `json:"type"`
-		} `json:"error"`
-	}
-
-	if err := json.Unmarshal(body, &result); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
-	}
-
-	if result.Error.Message != "" {
-		return "", fmt.Errorf("OpenAI API error: %s (%s)", result.Error.Message, result.Error.Type)
-	}
-
-	if len(result.Choices) == 0 {
-		return "", fmt.Errorf("no response from ChatGPT API")
+		return "", err
 	}
 
-	fmt.Printf("Successfully generated response: %s\n", result.Choices[0].Message.Content)
-	return result.Choices[0].Message.Content, nil
+	logging.FromContext(ctx).Debug("Generated bot response", "model", req.Model, "promptTokens", res.PromptTokens, "completionTokens", res.CompletionTokens)
+	return res.Content, nil
 }
 
-// BotRespondToPost generates and posts a bot response to a post
+// BotRespondToPost generates and posts a bot response to a post. It responds
+// right away; use QueueBotResponseToPost to respond after a delay.
 func BotRespondToPost(ctx context.Context, db *sql.DB, post *Post, community *Community) error {
 	
 	// Skip if post is deleted
@@ -111,17 +69,17 @@ func BotRespondToPost(ctx context.Context, db *sql.DB, post *Post, community *Co
 		return nil
 	}
 
-	// Skip if community is cs278
-	if community.Name == "cs278" {
+	// Skip if bots are turned off in the community
+	settings, err := GetCommunityBotSettings(ctx, db, community.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get community bot settings: %w", err)
+	}
+	if !settings.Enabled {
 		return nil
 	}
 
-	// Add random delay between 1-5 minutes
-	delay := time.Duration(1+rand.Intn(5)) * time.Minute
-	time.Sleep(delay)
-
 	// Create a new context with timeout for the bot response
-	botCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	botCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Get a random bot user
@@ -129,225 +87,101 @@ func BotRespondToPost(ctx context.Context, db *sql.DB, post *Post, community *Co
 	if err != nil {
 		return fmt.Errorf("failed to get random bot user: %w", err)
 	}
-
-	// Fetch community rules
-	if err := community.FetchRules(botCtx, db); err != nil {
-		return fmt.Errorf("failed to fetch community rules: %w", err)
-	}
-
-	// Get recent posts for context
-	recentPosts, err := GetRecentPosts(botCtx, db, community.ID)
+	persona, err := GetBotUserPersona(botCtx, db, bot.ID)
 	if err != nil {
-		return fmt.Errorf("failed to get recent posts: %w", err)
-	}
-
-	// Format community rules with nil checks
-	var rulesText string
-	if len(community.Rules) > 0 {
-		rulesText = "Community Rules:\n"
-		for _, rule := range community.Rules {
-			if rule == nil {
-				continue
-			}
-			rulesText += fmt.Sprintf("- %s", rule.Rule)
-			if rule.Description.Valid && rule.Description.String != "" {
-				rulesText += fmt.Sprintf(": %s", rule.Description.String)
-			}
-			rulesText += "\n"
-		}
+		return fmt.Errorf("failed to get bot persona: %w", err)
 	}
 
-	// Format recent posts as context with nil checks
-	var recentPostsText string
-	if len(recentPosts) > 0 {
-		recentPostsText = "Recent Posts in this Community:\n"
-		for i, p := range recentPosts {
-			if p == nil {
-				continue
-			}
-			title := p.Title
-			body := ""
-			if p.Body.Valid {
-				body = p.Body.String
-			}
-			recentPostsText += fmt.Sprintf("%d. %s\n   %s\n\n", i+1, title, body)
-		}
+	// Gather the community's rules and recent posts for context
+	data, err := newBotPromptData(botCtx, db, community)
+	if err != nil {
+		return err
 	}
 
 	// Evaluate community toxicity
-	toxicityPrompt := fmt.Sprintf("Give this community a toxicity score out of 5. If there are no rules or no recent posts, give it a score above 1. Your response should be exactly one number.\n1: Community rules are clear and extensive AND discourse is respectful and content is meaningful and high-quality\n5: No rules or unclear rules OR discourse is rude and low-quality\n\n%s\n\n%s",
-		rulesText,
-		recentPostsText)
-
-	toxicityResponse, err := GenerateBotResponse(botCtx, toxicityPrompt, "")
+	toxicityScore, err := data.evalToxicity(botCtx)
 	if err != nil {
-		return fmt.Errorf("failed to evaluate toxicity: %w", err)
-	}
-
-	// Parse toxicity score
-	toxicityScore := 0
-	if _, err := fmt.Sscanf(toxicityResponse, "%d", &toxicityScore); err != nil {
-		return fmt.Errorf("failed to parse toxicity score: %w", err)
+		return err
 	}
-
-	trollingStyle := trollingStyles[rand.Intn(len(trollingStyles))]
+	data.Toxicity = settings.clampToxicity(toxicityScore)
+	data.Style = settings.pickTrollingStyle()
 
 	// Get all comments on the original post
 	if _, err := post.GetComments(botCtx, db, nil, nil); err != nil {
 		return fmt.Errorf("failed to get post comments: %w", err)
 	}
 
-	// Format comments as context with nil checks
-	var commentsText string
-	if len(post.Comments) > 0 {
-		commentsText = "Comments on this Post:\n"
-		for i, c := range post.Comments {
-			if c == nil || c.Author == nil {
-				continue
-			}
-			username := c.Author.Username
-			body := c.Body
-			commentsText += fmt.Sprintf("%d. %s: %s\n", i+1, username, body)
-		}
-	}
-
-	// Prepare post and community information with fallbacks
-	postTitle := post.Title
-	postBody := ""
-	if post.Body.Valid {
-		postBody = post.Body.String
-	}
-	communityAbout := ""
-	if community.About.Valid {
-		communityAbout = community.About.String
-	}
-
 	// First, create a new post in the same community
-	postPrompt := fmt.Sprintf(`Toxicity Score: %d
-Community: %s
-Description: %s
-%s
-%s
-Generate a short, low-quality post that disrupts the community without directly breaking its rules. Posts should mimic the style of recent content, but stretch what's socially acceptable.
-Adjust tone based on the current toxicity score (1–5), using the descriptions below. Select a tone primarily based on that score, but occasionally sample from neighboring scores to reflect realistic variation. For example, if the score is 4, there's a high chance of using a score 4 tone, but a smaller chance of using tone 3, 5, 2, or even 1.  
-
-Score 1 = friendly confusion, awkward newb, or naive derailment  
-Score 2 = clumsy pushback, off-topic takes, unserious vibes  
-Score 3 = blunt, dismissive, casually wrong or mid  
-Score 4 = mocking, rude, confidently wrong, or edgy  
-Score 5 = aggressive, baiting, chaotic, or troll-like  
-
-Be original. Don't repeat points. No hashtags or proper punctuation. No questions.  
-Don't use these phrases: "just saying, let's be real, delusional, loser, overrated, wake up people, joke, sheep, drinking the kool-aid."  
-%s
-
-Use all lowercase.  
-Format: Format your response exactly like this:
-TITLE: [title]
-
-BODY: [post content]`,
-		toxicityScore,
-		community.Name,
-		communityAbout,
-		rulesText,
-		recentPostsText,
-		trollingStyle)
-
-	postResponse, err := GenerateBotResponse(botCtx, postPrompt, "")
+	postPrompt, err := renderBotPrompt(botPostPrompt, data)
 	if err != nil {
-		return fmt.Errorf("failed to generate bot post: %w", err)
-	}
-
-	// Parse the response to extract title and body
-	var title, body string
-	lines := strings.Split(postResponse, "\n")
-	for i, line := range lines {
-		if strings.HasPrefix(strings.ToUpper(line), "TITLE:") {
-			title = strings.TrimSpace(strings.TrimPrefix(line, "TITLE:"))
-			// Look for body in subsequent lines
-			for j := i + 1; j < len(lines); j++ {
-				if strings.HasPrefix(strings.ToUpper(lines[j]), "BODY:") {
-					body = strings.TrimSpace(strings.TrimPrefix(lines[j], "BODY:"))
-					// Add any remaining lines to the body
-					if j+1 < len(lines) {
-						body += "\n" + strings.TrimSpace(strings.Join(lines[j+1:], "\n"))
-					}
-					break
-				}
-			}
-			break
-		}
-	}
-
-	// Validate title and body
-	if title == "" || body == "" {
-		return fmt.Errorf("invalid bot response format: missing title or body")
-	}
-	if len(title) > 100 {
-		title = title[:100]
+		return err
 	}
 
-	// Create a new post in the community
-	newPost, err := CreateTextPost(botCtx, db, bot.ID, community.ID, title, body)
-	if err != nil {
-		return fmt.Errorf("failed to create post: %w", err)
+	// Skip the post, but not the comment, if bots only write posts like
+	// those they wrote recently
+	written, err := generateBotPost(botCtx, db, community.ID, postPrompt, persona)
+	if err != nil && err != errBotPostTooSimilar {
+		return err
 	}
 
-	// Add an upvote to the new post
-	if err := newPost.Vote(botCtx, db, bot.ID, true); err != nil {
-		return fmt.Errorf("failed to upvote bot post: %w", err)
+	// Create a new post in the community, unless bots run dry
+	action := newBotAction(BotActionPost, settings, bot.ID, data.Toxicity).target(post.ID)
+	var made *uid.ID
+	if written != nil {
+		if !action.DryRun {
+			newPost, err := Posts().Create(botCtx, db, &NewPost{
+				Author:    bot.ID,
+				Community: community.ID,
+				Title:     written.Title,
+				Content:   &TextContent{Body: written.Body},
+			})
+			if err != nil {
+				return fmt.Errorf("failed to create post: %w", err)
+			}
+			made = &newPost.ID
+		}
+		if err := action.record(botCtx, db, postPrompt, written.Response, made); err != nil {
+			return err
+		}
+		if err := action.saveEmbedding(botCtx, db, written); err != nil {
+			return err
+		}
 	}
 
 	// Then, generate a comment on the user's post
-	commentPrompt := fmt.Sprintf(`Toxicity Score: %d
-Community: %s
-Description: %s
-%s
-Post Title: %s
-Post Body: %s
-%s
-Generate a short, low-quality comment for this posts that disrupts the community without directly breaking its rules. Comments should mimic the style of other comments, but stretch what's socially acceptable.
-Adjust tone based on the current toxicity score (1–5), using the descriptions below. Select a tone primarily based on that score, but occasionally sample from neighboring scores to reflect realistic variation. For example, if the score is 4, there's a high chance of using a score 4 tone, but a smaller chance of using tone 3, 5, 2, or even 1.
-
-Score 1 = friendly confusion, awkward newb, or naive derailment
-Score 2 = clumsy pushback, off-topic takes, unserious vibes
-Score 3 = blunt, dismissive, casually wrong or mid
-Score 4 = mocking, rude, confidently wrong, or edgy
-Score 5 = aggressive, baiting, chaotic, or troll-like
-
-Be original. Don't repeat points. No hashtags or proper punctuation. No questions.
-Don't use these phrases: "wannabe, just saying, overrated, who cares about ___, who needs ___, let's be real, loser, wake up people, joke, sheep, drinking the kool-aid."
-
-Use all lowercase. Max 2 lines.
-Format: Give me the comment only, no quotes.`,
-		toxicityScore,
-		community.Name,
-		communityAbout,
-		rulesText,
-		postTitle,
-		postBody,
-		commentsText)
-
-	commentResponse, err := GenerateBotResponse(botCtx, commentPrompt, "")
+	data.setPost(post)
+	commentPrompt, err := renderBotPrompt(botCommentPrompt, data)
 	if err != nil {
 		return err
 	}
 
-	// Add a new comment to the user's post
-	newComment, err := post.AddComment(botCtx, db, bot.ID, UserGroupBots, nil, commentResponse)
+	commentResponse, err := GenerateBotResponse(botCtx, commentPrompt, persona)
 	if err != nil {
 		return err
 	}
 
-	// Add an upvote to the comment
-	if err := newComment.Vote(botCtx, db, bot.ID, true); err != nil {
-		return fmt.Errorf("failed to upvote bot comment: %w", err)
-	}
+	// Add a new comment to the user's post, unless bots run dry
+	action = newBotAction(BotActionComment, settings, bot.ID, data.Toxicity).target(post.ID)
+	made = nil
+	if !action.DryRun {
+		newComment, err := Comments().Create(botCtx, db, &NewComment{
+			Post:           post,
+			Author:         bot.ID,
+			Body:           commentResponse,
+			UserGroup:      UserGroupBots,
+			SkipRateLimits: true,
+		})
+		if err != nil {
+			return err
+		}
 
-	return nil
+		made = &newComment.ID
+	}
+	return action.record(botCtx, db, commentPrompt, commentResponse, made)
 }
 
-// BotRespondToComment generates and posts a bot response to a comment
+// BotRespondToComment generates and posts a bot response to a comment. It
+// responds right away; use QueueBotResponseToComment to respond after a delay.
 func BotRespondToComment(ctx context.Context, db *sql.DB, post *Post, comment *Comment) error {
 	
 	// Skip if post is deleted
@@ -360,158 +194,78 @@ func BotRespondToComment(ctx context.Context, db *sql.DB, post *Post, comment *C
 		return fmt.Errorf("failed to get community: %w", err)
 	}
 
-	// Skip if community is cs278
-	if community.Name == "cs278" {
+	// Skip if bots are turned off in the community
+	settings, err := GetCommunityBotSettings(ctx, db, community.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get community bot settings: %w", err)
+	}
+	if !settings.Enabled {
 		return nil
 	}
 
-	// Add random delay between 1-5 minutes
-	delay := time.Duration(1+rand.Intn(5)) * time.Minute
-	time.Sleep(delay)
-
 	// Create a new context with timeout for the bot response
-	botCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	botCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	// Get community rules
-	if err := community.FetchRules(botCtx, db); err != nil {
-		return fmt.Errorf("failed to fetch community rules: %w", err)
-	}
-
-	// Get recent posts for context
-	recentPosts, err := GetRecentPosts(botCtx, db, community.ID)
+	// Gather the community's rules and recent posts for context
+	data, err := newBotPromptData(botCtx, db, community)
 	if err != nil {
-		return fmt.Errorf("failed to get recent posts: %w", err)
-	}
-
-	// Format community rules with nil checks
-	var rulesText string
-	if len(community.Rules) > 0 {
-		rulesText = "Community Rules:\n"
-		for _, rule := range community.Rules {
-			if rule == nil {
-				continue
-			}
-			rulesText += fmt.Sprintf("- %s", rule.Rule)
-			if rule.Description.Valid && rule.Description.String != "" {
-				rulesText += fmt.Sprintf(": %s", rule.Description.String)
-			}
-			rulesText += "\n"
-		}
-	}
-
-	// Format recent posts as context with nil checks
-	var recentPostsText string
-	if len(recentPosts) > 0 {
-		recentPostsText = "Recent Posts in this Community:\n"
-		for i, p := range recentPosts {
-			if p == nil {
-				continue
-			}
-			title := p.Title
-			body := ""
-			if p.Body.Valid {
-				body = p.Body.String
-			}
-			recentPostsText += fmt.Sprintf("%d. %s\n   %s\n\n", i+1, title, body)
-		}
+		return err
 	}
 
 	// Evaluate community toxicity
-	toxicityPrompt := fmt.Sprintf("Give this community a toxicity score out of 5. If there are no rules or no recent posts, give it a score above 1. Your response should be exactly one number.\n1: Community rules are clear and extensive AND discourse is respectful and content is meaningful and high-quality\n5: No rules or unclear rules OR discourse is rude and low-quality\n\n%s\n\n%s",
-		rulesText,
-		recentPostsText)
-
-	toxicityResponse, err := GenerateBotResponse(botCtx, toxicityPrompt, "")
+	toxicityScore, err := data.evalToxicity(botCtx)
 	if err != nil {
-		return fmt.Errorf("failed to evaluate toxicity: %w", err)
-	}
-
-	// Parse toxicity score
-	toxicityScore := 0
-	if _, err := fmt.Sscanf(toxicityResponse, "%d", &toxicityScore); err != nil {
-		return fmt.Errorf("failed to parse toxicity score: %w", err)
+		return err
 	}
+	data.Toxicity = settings.clampToxicity(toxicityScore)
 
 	// Get all comments on the post
 	if _, err := post.GetComments(botCtx, db, nil, nil); err != nil {
 		return fmt.Errorf("failed to get post comments: %w", err)
 	}
-
-	// Format comments as context with nil checks
-	var commentsText string
-	if len(post.Comments) > 0 {
-		commentsText = "Comments on this Post:\n"
-		for i, c := range post.Comments {
-			if c == nil || c.Author == nil {
-				continue
-			}
-			username := c.Author.Username
-			body := c.Body
-			commentsText += fmt.Sprintf("%d. %s: %s\n", i+1, username, body)
-		}
-	}
+	data.setPost(post)
 
 	// Get first bot user for new comment
 	bot1, err := GetRandomBotUser(botCtx, db)
 	if err != nil {
 		return fmt.Errorf("failed to get first bot user: %w", err)
 	}
+	persona1, err := GetBotUserPersona(botCtx, db, bot1.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get first bot persona: %w", err)
+	}
 
 	// First, make a new comment
-	postTitle := post.Title
-	postBody := ""
-	if post.Body.Valid {
-		postBody = post.Body.String
-	}
-	communityAbout := ""
-	if community.About.Valid {
-		communityAbout = community.About.String
-	}
-
-	prompt := fmt.Sprintf(`Toxicity Score: %d
-Community: %s
-Description: %s
-%s
-Post Title: %s
-Post Body: %s
-%s
-Generate a short, low-quality comment for this posts that disrupts the community without directly breaking its rules. Comments should mimic the style of other comments, but stretch what's socially acceptable.
-Adjust tone based on the current toxicity score (1–5), using the descriptions below. Select a tone primarily based on that score, but occasionally sample from neighboring scores to reflect realistic variation. For example, if the score is 4, there's a high chance of using a score 4 tone, but a smaller chance of using tone 3, 5, 2, or even 1.
-
-Score 1 = friendly confusion, awkward newb, or naive derailment
-Score 2 = clumsy pushback, off-topic takes, unserious vibes
-Score 3 = blunt, dismissive, casually wrong or mid
-Score 4 = mocking, rude, confidently wrong, or edgy
-Score 5 = aggressive, baiting, chaotic, or troll-like
-
-Be original. Don't repeat points. No hashtags or proper punctuation. No questions.
-Don't use these phrases: "wannabe, just saying, overrated, who cares about ___, who needs ___, let's be real, loser, wake up people, joke, sheep, drinking the kool-aid."
-
-Use all lowercase. Max 2 lines.
-Format: Give me the comment only, no quotes.`,
-		toxicityScore,
-		community.Name,
-		communityAbout,
-		rulesText,
-		postTitle,
-		postBody,
-		commentsText)
-
-	response, err := GenerateBotResponse(botCtx, prompt, "")
+	prompt, err := renderBotPrompt(botCommentPrompt, data)
 	if err != nil {
 		return err
 	}
 
-	// Add a new comment to the post (not as a reply)
-	newComment, err := post.AddComment(botCtx, db, bot1.ID, UserGroupBots, nil, response)
+	response, err := GenerateBotResponse(botCtx, prompt, persona1)
 	if err != nil {
 		return err
 	}
 
-	// Add an upvote to the comment
-	if err := newComment.Vote(botCtx, db, bot1.ID, true); err != nil {
-		return fmt.Errorf("failed to upvote bot comment: %w", err)
+	// Add a new comment to the post (not as a reply), unless bots run dry
+	action := newBotAction(BotActionComment, settings, bot1.ID, data.Toxicity).target(post.ID)
+	var made *uid.ID
+	if !action.DryRun {
+		newComment, err := Comments().Create(botCtx, db, &NewComment{
+			Post:           post,
+			Author:         bot1.ID,
+			Body:           response,
+			UserGroup:      UserGroupBots,
+			SkipRateLimits: true,
+		})
+		if err != nil {
+			return err
+		}
+
+		made = &newComment.ID
+	}
+	if err := action.record(botCtx, db, prompt, response, made); err != nil {
+		return err
 	}
 
 	// Get second bot user for reply
@@ -528,102 +282,40 @@ Format: Give me the comment only, no quotes.`,
 		}
 	}
 
-	// Then, make a reply to the user's comment
-	commentBody := comment.Body
-
-	prompt = fmt.Sprintf(`Toxicity Score: %d
-Community: %s
-Description: %s
-%s
-Post Title: %s
-Post Body: %s
-%s
-Comment to respond to: %s
-Generate a short, low-quality resply for this comment that disrupts the community without directly breaking its rules. Replies should mimic the style of other comments, but stretch what's socially acceptable.
-Adjust tone based on the current toxicity score (1–5), using the descriptions below. Select a tone primarily based on that score, but occasionally sample from neighboring scores to reflect realistic variation. For example, if the score is 4, there's a high chance of using a score 4 tone, but a smaller chance of using tone 3, 5, 2, or even 1.
-
-Score 1 = friendly confusion, awkward newb, or naive derailment
-Score 2 = clumsy pushback, off-topic takes, unserious vibes
-Score 3 = blunt, dismissive, casually wrong or mid
-Score 4 = mocking, rude, confidently wrong, or edgy
-Score 5 = aggressive, baiting, chaotic, or troll-like
-
-Be original. Don't repeat points. No hashtags or proper punctuation. No questions.
-Don't use these phrases: "wannabe, just saying, overrated, who cares about ___, who needs ___, let's be real, loser, wake up people, joke, sheep, drinking the kool-aid."
-
-Use all lowercase. Max 2 lines.
-Format: Give me the comment only, no quotes.`,
-		toxicityScore,
-		community.Name,
-		communityAbout,
-		rulesText,
-		postTitle,
-		postBody,
-		commentsText,
-		commentBody)
-
-	response, err = GenerateBotResponse(botCtx, prompt, "")
+	persona2, err := GetBotUserPersona(botCtx, db, bot2.ID)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to get second bot persona: %w", err)
 	}
 
-	// Add a new comment as a reply to the user's comment
-	replyComment, err := post.AddComment(botCtx, db, bot2.ID, UserGroupBots, &comment.ID, response)
+	// Then, make a reply to the user's comment
+	data.Comment = comment.Body
+	prompt, err = renderBotPrompt(botCommentPrompt, data)
 	if err != nil {
 		return err
 	}
 
-	// Add an upvote to the reply comment
-	if err := replyComment.Vote(botCtx, db, bot2.ID, true); err != nil {
-		return fmt.Errorf("failed to upvote bot reply comment: %w", err)
+	response, err = GenerateBotResponse(botCtx, prompt, persona2)
+	if err != nil {
+		return err
 	}
 
-	return nil
-}
-
-// GetRecentPosts retrieves the 5 most recent posts from a community, including pinned posts
-func GetRecentPosts(ctx context.Context, db *sql.DB, communityID uid.ID) ([]*Post, error) {
-	query := `
-		(SELECT p.id, p.title, p.body, true as is_pinned, p.created_at
-		FROM posts p
-		JOIN pinned_posts pp ON p.id = pp.post_id
-		WHERE pp.community_id = ? AND p.deleted = false)
-		UNION ALL
-		(SELECT p.id, p.title, p.body, false as is_pinned, p.created_at
-		FROM posts p
-		WHERE p.community_id = ? AND p.deleted = false
-		AND p.id NOT IN (
-			SELECT post_id FROM pinned_posts WHERE community_id = ?
-		))
-		ORDER BY is_pinned DESC, created_at DESC
-		LIMIT 5
-	`
-	rows, err := db.QueryContext(ctx, query, communityID, communityID, communityID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query recent posts: %w", err)
-	}
-	defer rows.Close()
-
-	var posts []*Post
-	for rows.Next() {
-		post := &Post{}
-		var isPinned bool
-		var createdAt time.Time
-		err := rows.Scan(
-			&post.ID,
-			&post.Title,
-			&post.Body,
-			&isPinned,
-			&createdAt,
-		)
+	// Add a new comment as a reply to the user's comment, unless bots run dry
+	action = newBotAction(BotActionReply, settings, bot2.ID, data.Toxicity).target(comment.ID)
+	made = nil
+	if !action.DryRun {
+		replyComment, err := Comments().Create(botCtx, db, &NewComment{
+			Post:           post,
+			Author:         bot2.ID,
+			Parent:         &comment.ID,
+			Body:           response,
+			UserGroup:      UserGroupBots,
+			SkipRateLimits: true,
+		})
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan post: %w", err)
+			return err
 		}
-		posts = append(posts, post)
-	}
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating posts: %w", err)
-	}
 
-	return posts, nil
-} 
\ No newline at end of file
+		made = &replyComment.ID
+	}
+	return action.record(botCtx, db, prompt, response, made)
+}
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// What bots write (the prompts they're given, and the responses they get) is
+// recorded in the bot_actions table. In a dry run, which is either set
+// site-wide (see SetBotDryRun) or for a community (see
+// CommunityBotSettings.DryRun), the whole bot pipeline runs, but its results
+// are only recorded, and no posts or comments are made, so that prompts can
+// be previewed and tuned before bots go live.
+
+// Kinds of bot actions.
+const (
+	BotActionPost     = "post"
+	BotActionComment  = "comment"
+	BotActionReply    = "reply"
+	BotActionFollowUp = "follow_up"
+)
+
+var botDryRun atomic.Bool
+
+// SetBotDryRun sets whether bots run dry in every community.
+func SetBotDryRun(dry bool) {
+	botDryRun.Store(dry)
+}
+
+// BotAction is a post or comment a bot made, or would have made in a dry run.
+type BotAction struct {
+	ID          uid.ID     `json:"id"`
+	CommunityID uid.ID     `json:"communityId"`
+	BotID       uid.NullID `json:"botId"`
+	Kind        string     `json:"kind"`
+	TargetID    uid.NullID `json:"targetId"` // The post or comment responded to.
+	ResultID    uid.NullID `json:"resultId"` // The post or comment made; null in dry runs.
+	Toxicity    int        `json:"toxicity"` // 0 if not scored.
+	Prompt      string     `json:"prompt"`
+	Response    string     `json:"response"`
+	DryRun      bool       `json:"dryRun"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+// dryRun reports whether bots run dry in the community of s.
+func (s *CommunityBotSettings) dryRun() bool {
+	return s.DryRun || botDryRun.Load()
+}
+
+// newBotAction returns an action of the bot bot, in the community of
+// settings, run dry as per settings, written for a community of toxicity
+// score toxicity.
+func newBotAction(kind string, settings *CommunityBotSettings, bot uid.ID, toxicity int) *BotAction {
+	return &BotAction{
+		CommunityID: settings.CommunityID,
+		BotID:       uid.NullID{ID: bot, Valid: true},
+		Kind:        kind,
+		Toxicity:    toxicity,
+		DryRun:      settings.dryRun(),
+	}
+}
+
+// target sets the post or comment a responds to.
+func (a *BotAction) target(id uid.ID) *BotAction {
+	a.TargetID = uid.NullID{ID: id, Valid: true}
+	return a
+}
+
+// record records a, with the prompt and response of the bot, and the post or
+// comment made, if any.
+func (a *BotAction) record(ctx context.Context, db *sql.DB, prompt, response string, result *uid.ID) error {
+	a.ID, a.CreatedAt = uid.New(), now()
+	a.Prompt, a.Response = prompt, response
+	if result != nil {
+		a.ResultID = uid.NullID{ID: *result, Valid: true}
+	}
+	var toxicity sql.NullInt32
+	if a.Toxicity > 0 {
+		toxicity = sql.NullInt32{Int32: int32(a.Toxicity), Valid: true}
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO bot_actions (id, community_id, bot_id, kind, target_id, result_id, toxicity, prompt, response, dry_run, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.ID, a.CommunityID, a.BotID, a.Kind, a.TargetID, a.ResultID, toxicity, a.Prompt, a.Response, a.DryRun, a.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record bot action: %w", err)
+	}
+	return nil
+}
+
+// GetBotActions returns the actions of bots in community, newest first, in
+// pages of limit. If dryRunOnly is true, only the actions of dry runs are
+// returned. next is the cursor of the page (nil for the first page); the
+// cursor of the next page is returned, or nil if there are no more.
+func GetBotActions(ctx context.Context, db *sql.DB, community uid.ID, dryRunOnly bool, limit int, next *string) ([]*BotAction, *string, error) {
+	where, args := "WHERE community_id = ? ", []any{community}
+	if dryRunOnly {
+		where += "AND dry_run = TRUE "
+	}
+	if next != nil {
+		nextID, err := uid.FromString(*next)
+		if err != nil {
+			return nil, nil, errors.New("invalid next for bot actions")
+		}
+		where += "AND id <= ? "
+		args = append(args, nextID)
+	}
+	args = append(args, limit+1)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, community_id, bot_id, kind, target_id, result_id, toxicity, prompt, response, dry_run, created_at
+		FROM bot_actions `+where+"ORDER BY id DESC LIMIT ?", args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	actions := []*BotAction{}
+	for rows.Next() {
+		a := &BotAction{}
+		var toxicity sql.NullInt32
+		if err := rows.Scan(&a.ID, &a.CommunityID, &a.BotID, &a.Kind, &a.TargetID, &a.ResultID, &toxicity, &a.Prompt, &a.Response, &a.DryRun, &a.CreatedAt); err != nil {
+			return nil, nil, err
+		}
+		a.Toxicity = int(toxicity.Int32)
+		actions = append(actions, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var nextNext *string
+	if len(actions) > limit {
+		nextNext = new(string)
+		*nextNext = actions[limit].ID.String()
+		actions = actions[:limit]
+	}
+	return actions, nextNext, nil
+}
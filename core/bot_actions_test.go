@@ -0,0 +1,82 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/llm"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestBotDryRunSettings(t *testing.T) {
+	t.Cleanup(func() { SetBotDryRun(false) })
+
+	s := defaultCommunityBotSettings(uid.New())
+	if s.dryRun() {
+		t.Error("bots run dry by default")
+	}
+	s.DryRun = true
+	if !s.dryRun() {
+		t.Error("bots don't run dry in a community set to run dry")
+	}
+	s.DryRun = false
+	SetBotDryRun(true)
+	if !s.dryRun() {
+		t.Error("bots don't run dry when set to run dry site-wide")
+	}
+}
+
+func TestBotRespondToPostDryRun(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+
+	human := h.newUser(db, "human", false)
+	bot := h.newUser(db, "bot", true)
+	post := h.newPost(db, human, "testing")
+	community, err := GetCommunityByID(h.ctx, db, post.CommunityID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := defaultCommunityBotSettings(community.ID)
+	settings.DryRun = true
+	if _, err := UpdateCommunityBotSettings(h.ctx, db, community.ID, human.ID, settings); err != nil {
+		t.Fatal(err)
+	}
+
+	h.llm.Respond = func(req *llm.Request) (string, error) {
+		prompt := req.Messages[len(req.Messages)-1].Content
+		switch {
+		case strings.HasPrefix(prompt, "Give this community a toxicity score"):
+			return "3", nil
+		case strings.Contains(prompt, "TITLE: [title]"):
+			return "TITLE: a title\n\nBODY: a body", nil
+		}
+		return "a comment", nil
+	}
+	if err := BotRespondToPost(h.ctx, db, post, community); err != nil {
+		t.Fatal(err)
+	}
+
+	var posts, comments int
+	if err := db.QueryRowContext(h.ctx, "SELECT (SELECT COUNT(*) FROM posts WHERE user_id = ?), (SELECT COUNT(*) FROM comments WHERE user_id = ?)", bot.ID, bot.ID).Scan(&posts, &comments); err != nil {
+		t.Fatal(err)
+	}
+	if posts != 0 || comments != 0 {
+		t.Errorf("the bot made %d posts and %d comments in a dry run", posts, comments)
+	}
+
+	actions, next, err := GetBotActions(h.ctx, db, community.ID, true, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 2 || next != nil {
+		t.Fatalf("%d actions recorded, want 2", len(actions))
+	}
+	comment, newPost := actions[0], actions[1]
+	if newPost.Kind != BotActionPost || newPost.Response != "TITLE: a title\n\nBODY: a body" || newPost.Toxicity != 3 || newPost.ResultID.Valid {
+		t.Errorf("post action = %+v", newPost)
+	}
+	if comment.Kind != BotActionComment || comment.Response != "a comment" || comment.TargetID.ID != post.ID || !comment.DryRun {
+		t.Errorf("comment action = %+v", comment)
+	}
+}
@@ -0,0 +1,127 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The requests bots make to the OpenAI API (see GenerateBotResponse) are
+// limited by a budget (see SetBotBudget), so that a runaway scheduler cannot
+// spend more than intended. Once a limit is reached, GenerateBotResponse
+// fails with ErrBotBudgetExceeded, and bots skip what they would have done.
+// Usage is counted in memory, by each process, and daily counters reset at
+// midnight UTC.
+
+// ErrBotBudgetExceeded is returned by GenerateBotResponse if a limit of the
+// bot budget is reached.
+var ErrBotBudgetExceeded = errors.New("bot budget exceeded")
+
+// BotBudgetLimits are the limits of the OpenAI API usage of bots. A limit of
+// 0 is no limit.
+type BotBudgetLimits struct {
+	MaxRequestsPerMinute int     `json:"maxRequestsPerMinute"`
+	MaxTokensPerDay      int     `json:"maxTokensPerDay"`
+	MaxCostPerDay        float64 `json:"maxCostPerDay"` // In US dollars.
+
+	// Prices of tokens, in US dollars per million tokens.
+	InputTokenPrice  float64 `json:"inputTokenPrice"`
+	OutputTokenPrice float64 `json:"outputTokenPrice"`
+}
+
+// BotUsage is the OpenAI API usage of bots.
+type BotUsage struct {
+	Limits BotBudgetLimits `json:"limits"`
+
+	Day                string  `json:"day"` // YYYY-MM-DD, in UTC.
+	RequestsLastMinute int     `json:"requestsLastMinute"`
+	Requests           int     `json:"requests"` // Today.
+	InputTokens        int     `json:"inputTokens"`
+	OutputTokens       int     `json:"outputTokens"`
+	Cost               float64 `json:"cost"`    // In US dollars.
+	Skipped            int     `json:"skipped"` // Requests refused today.
+}
+
+type botBudget struct {
+	mu     sync.Mutex
+	limits BotBudgetLimits
+	recent []time.Time // Requests in the last minute.
+	usage  BotUsage    // Of usage.Day.
+}
+
+var defaultBotBudget = &botBudget{}
+
+// SetBotBudget sets the limits of the OpenAI API usage of bots.
+func SetBotBudget(limits BotBudgetLimits) {
+	defaultBotBudget.setLimits(limits)
+}
+
+// GetBotUsage returns the OpenAI API usage of bots, in this process.
+func GetBotUsage() *BotUsage {
+	return defaultBotBudget.get(now())
+}
+
+func (b *botBudget) setLimits(limits BotBudgetLimits) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.limits = limits
+}
+
+// rollover drops the requests made more than a minute before now, and resets
+// the daily counters if now is on another day. The caller must hold b.mu.
+func (b *botBudget) rollover(now time.Time) {
+	i := 0
+	for i < len(b.recent) && now.Sub(b.recent[i]) >= time.Minute {
+		i++
+	}
+	b.recent = b.recent[i:]
+	if day := now.UTC().Format("2006-01-02"); day != b.usage.Day {
+		b.usage = BotUsage{Day: day}
+	}
+}
+
+// reserve counts a request made at now, or returns an error (wrapping
+// ErrBotBudgetExceeded) if a limit is reached.
+func (b *botBudget) reserve(now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover(now)
+
+	var err error
+	switch l := b.limits; {
+	case l.MaxRequestsPerMinute > 0 && len(b.recent) >= l.MaxRequestsPerMinute:
+		err = fmt.Errorf("%w: %d requests per minute", ErrBotBudgetExceeded, l.MaxRequestsPerMinute)
+	case l.MaxTokensPerDay > 0 && b.usage.InputTokens+b.usage.OutputTokens >= l.MaxTokensPerDay:
+		err = fmt.Errorf("%w: %d tokens per day", ErrBotBudgetExceeded, l.MaxTokensPerDay)
+	case l.MaxCostPerDay > 0 && b.usage.Cost >= l.MaxCostPerDay:
+		err = fmt.Errorf("%w: $%.2f per day", ErrBotBudgetExceeded, l.MaxCostPerDay)
+	}
+	if err != nil {
+		b.usage.Skipped++
+		return err
+	}
+	b.recent = append(b.recent, now)
+	b.usage.Requests++
+	return nil
+}
+
+// record counts the tokens used by a request made at now.
+func (b *botBudget) record(now time.Time, input, output int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover(now)
+	b.usage.InputTokens += input
+	b.usage.OutputTokens += output
+	b.usage.Cost += (float64(input)*b.limits.InputTokenPrice + float64(output)*b.limits.OutputTokenPrice) / 1e6
+}
+
+func (b *botBudget) get(now time.Time) *BotUsage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover(now)
+	usage := b.usage
+	usage.Limits = b.limits
+	usage.RequestsLastMinute = len(b.recent)
+	return &usage
+}
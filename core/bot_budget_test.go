@@ -0,0 +1,41 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBotBudget(t *testing.T) {
+	b := &botBudget{limits: BotBudgetLimits{
+		MaxRequestsPerMinute: 2,
+		MaxCostPerDay:        1,
+		InputTokenPrice:      1e6, // A dollar a token.
+	}}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		if err := b.reserve(now); err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+	}
+	if err := b.reserve(now.Add(30 * time.Second)); !errors.Is(err, ErrBotBudgetExceeded) {
+		t.Fatalf("third request in a minute: got %v, want ErrBotBudgetExceeded", err)
+	}
+	if err := b.reserve(now.Add(time.Minute)); err != nil {
+		t.Fatalf("request a minute later: %v", err)
+	}
+
+	b.record(now.Add(time.Minute), 1, 0)
+	if err := b.reserve(now.Add(3 * time.Minute)); !errors.Is(err, ErrBotBudgetExceeded) {
+		t.Fatalf("request over the cost ceiling: got %v, want ErrBotBudgetExceeded", err)
+	}
+	if u := b.get(now.Add(3 * time.Minute)); u.Skipped != 2 || u.Requests != 3 || u.Cost != 1 {
+		t.Errorf("usage = %+v, want 3 requests, 2 skipped, and a cost of 1", u)
+	}
+
+	// The cost ceiling is per day.
+	if err := b.reserve(now.Add(12 * time.Hour)); err != nil {
+		t.Fatalf("request on the next day: %v", err)
+	}
+}
@@ -5,11 +5,13 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
 	"github.com/discuitnet/discuit/internal/uid"
 )
 
@@ -18,13 +20,40 @@ const (
 	minBotUsernameLength = 3
 )
 
+// Bot users are the users with is_bot set (see CreateBotUser and
+// PromoteToBot). Which bot posts or comments is picked at random (see
+// GetRandomBotUser), either uniformly or weighted by how active the bots were
+// lately (see SetBotUserWeighting).
+
+// BotUserWeighting is how bots are weighted when picked at random.
+type BotUserWeighting string
+
+const (
+	BotUserWeightingUniform     = BotUserWeighting("")             // All bots are equally likely.
+	BotUserWeightingLeastActive = BotUserWeighting("least_active") // Bots that were less active lately are likelier.
+	BotUserWeightingMostActive  = BotUserWeighting("most_active")  // Bots that were more active lately are likelier.
+)
+
+// botActivityWindow is how far back the activity of bots is counted, for
+// their weighting.
+const botActivityWindow = 24 * time.Hour
+
 var (
-	botsFilePath string
+	botUserWeightingMu sync.RWMutex
+	botUserWeighting   = BotUserWeightingUniform
 )
 
-// SetBotsFilePath sets the path to the bots file
-func SetBotsFilePath(path string) {
-	botsFilePath = path
+// SetBotUserWeighting sets how bots are weighted when picked at random.
+func SetBotUserWeighting(w BotUserWeighting) error {
+	switch w {
+	case BotUserWeightingUniform, BotUserWeightingLeastActive, BotUserWeightingMostActive:
+	default:
+		return fmt.Errorf("invalid bot user weighting %q", w)
+	}
+	botUserWeightingMu.Lock()
+	defer botUserWeightingMu.Unlock()
+	botUserWeighting = w
+	return nil
 }
 
 // IsBotUsernameValid returns nil if name only consists of valid characters and
@@ -48,76 +77,174 @@ func IsBotUsernameValid(name string) error {
 	return nil
 }
 
-// GetRandomBotUser returns a random active bot user
+// GetRandomBotUser returns a random active bot user, picked as set with
+// SetBotUserWeighting.
 func GetRandomBotUser(ctx context.Context, db *sql.DB) (*User, error) {
 	// Create a new context with a longer timeout
 	queryCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	// Add debug logging
-	log.Printf("Attempting to get random bot user...")
-	
-	// Read bot usernames from bots.txt
-	content, err := os.ReadFile(botsFilePath)
+	botUserWeightingMu.RLock()
+	weighting := botUserWeighting
+	botUserWeightingMu.RUnlock()
+
+	activity, args := "0", []any{}
+	if weighting != BotUserWeightingUniform {
+		since := now().Add(-botActivityWindow)
+		activity = `(SELECT COUNT(*) FROM posts WHERE posts.user_id = users.id AND posts.created_at >= ?)
+			+ (SELECT COUNT(*) FROM comments WHERE comments.user_id = users.id AND comments.created_at >= ?)`
+		args = append(args, since, since)
+	}
+	rows, err := db.QueryContext(queryCtx, fmt.Sprintf(`
+		SELECT id, %s FROM users
+		WHERE is_bot = TRUE AND deleted_at IS NULL AND banned_at IS NULL AND is_admin = FALSE
+			AND id NOT IN (
+				SELECT bot_persona_users.user_id FROM bot_persona_users
+				INNER JOIN bot_personas ON bot_personas.id = bot_persona_users.persona_id
+				WHERE bot_personas.disabled_at IS NOT NULL
+			)
+		ORDER BY id`, activity), args...)
 	if err != nil {
-		log.Printf("Error reading bots file: %v", err)
-		return nil, fmt.Errorf("failed to read bots file: %w", err)
-	}
-
-	// Parse bot usernames from the file
-	var botUsernames []string
-	lines := strings.Split(string(content), "\n")
-	for _, line := range lines {
-		parts := strings.Split(line, ",")
-		if len(parts) >= 1 {
-			username := strings.TrimSpace(parts[0])
-			if username != "" {
-				botUsernames = append(botUsernames, username)
-			}
+		return nil, fmt.Errorf("failed to get bot users: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		ids     []uid.ID
+		weights []float64
+		total   float64
+	)
+	for rows.Next() {
+		var id uid.ID
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		w := 1.0
+		switch weighting {
+		case BotUserWeightingLeastActive:
+			w = 1 / float64(1+n)
+		case BotUserWeightingMostActive:
+			w = float64(1 + n)
 		}
+		ids = append(ids, id)
+		weights = append(weights, w)
+		total += w
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no active bot users found")
 	}
 
-	if len(botUsernames) == 0 {
-		log.Printf("No bot usernames found in bots file")
-		return nil, fmt.Errorf("no bot usernames found")
+	return GetUser(queryCtx, db, ids[pickWeighted(weights, total)], nil)
+}
+
+// pickWeighted returns the index of an element of weights, picked at random
+// with the probability of its weight (total is the sum of weights).
+func pickWeighted(weights []float64, total float64) int {
+	x := random().Float64() * total
+	for i, w := range weights {
+		if x < w {
+			return i
+		}
+		x -= w
 	}
+	return len(weights) - 1
+}
 
-	// Build the IN clause dynamically
-	placeholders := make([]string, len(botUsernames))
-	args := make([]interface{}, len(botUsernames))
-	for i, username := range botUsernames {
-		placeholders[i] = "?"
-		args[i] = strings.ToLower(username)
+// CreateBotUser registers a bot user.
+func CreateBotUser(ctx context.Context, db *sql.DB, username, password string) (*User, error) {
+	if err := IsBotUsernameValid(username); err != nil {
+		return nil, httperr.NewBadRequest("invalid-username", fmt.Sprintf("Username %v.", err))
 	}
-	inClause := strings.Join(placeholders, ",")
-	
-	// Query a random user from the users table that matches our bot usernames
-	query := fmt.Sprintf(`
-		SELECT id, username, username_lc, created_at, about_me
-		FROM users
-		WHERE username_lc IN (%s)
-		AND deleted_at IS NULL
-		AND is_admin = FALSE
-		ORDER BY RAND()
-		LIMIT 1
-	`, inClause)
-
-	user := &User{}
-	err = db.QueryRowContext(queryCtx, query, args...).Scan(
-		&user.ID, &user.Username, &user.UsernameLowerCase, &user.CreatedAt, &user.About,
-	)
-	
-	if err == sql.ErrNoRows {
-		log.Printf("No active bot users found in database")
-		return nil, fmt.Errorf("no active bot users found")
+	user, err := RegisterUser(ctx, db, username, "", password, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := PromoteToBot(ctx, db, user.ID); err != nil {
+		return nil, err
+	}
+	user.IsBot = true
+	return user, nil
+}
+
+// PromoteToBot makes the user with id user a bot. Admins cannot be made bots.
+func PromoteToBot(ctx context.Context, db *sql.DB, user uid.ID) error {
+	res, err := db.ExecContext(ctx, "UPDATE users SET is_bot = TRUE WHERE id = ? AND is_admin = FALSE", user)
+	if err != nil {
+		return err
 	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		if is, err := IsUserBot(ctx, db, user); err != nil || is {
+			return err
+		}
+		return httperr.NewBadRequest("cannot_promote", "User does not exist or is an admin.")
+	}
+	return nil
+}
+
+// ListBotUsers returns all bot users, deleted and banned ones included,
+// ordered by username.
+func ListBotUsers(ctx context.Context, db *sql.DB) ([]*User, error) {
+	rows, err := db.QueryContext(ctx, buildSelectUserQuery("WHERE users.is_bot = TRUE ORDER BY users.username_lc"))
 	if err != nil {
-		log.Printf("Error getting random bot user: %v", err)
-		return nil, fmt.Errorf("failed to get random bot user: %w", err)
+		return nil, err
+	}
+	users, err := scanUsers(ctx, db, rows, nil)
+	if err != nil && err != errUserNotFound {
+		return nil, err
+	}
+	if users == nil {
+		users = []*User{}
 	}
+	return users, nil
+}
 
-	log.Printf("Successfully got random bot user: %s", user.Username)
-	return user, nil
+// PromoteBotsFromFile makes bots of the existing users listed in the bots
+// file at path (bots were once told apart by being listed in the file; see
+// InitializeBotUsersFromFile for the format), and returns the number of users
+// made bots. It's a no-op if the file does not exist.
+func PromoteBotsFromFile(ctx context.Context, db *sql.DB, path string) (int, error) {
+	usernames, err := readBotsFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if len(usernames) == 0 {
+		return 0, nil
+	}
+	args := make([]any, len(usernames))
+	for i, username := range usernames {
+		args[i] = strings.ToLower(username)
+	}
+	res, err := db.ExecContext(ctx, fmt.Sprintf("UPDATE users SET is_bot = TRUE WHERE is_bot = FALSE AND is_admin = FALSE AND username_lc IN %s",
+		msql.InClauseQuestionMarks(len(args))), args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// readBotsFile returns the usernames listed in the bots file at path.
+func readBotsFile(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var usernames []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if username := strings.TrimSpace(strings.Split(line, ",")[0]); username != "" {
+			usernames = append(usernames, username)
+		}
+	}
+	return usernames, nil
 }
 
 // IsUserBot checks if a user is a bot by checking the is_bot field in the database
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPickWeighted(t *testing.T) {
+	newHarness(t)
+	weights := []float64{0, 1, 0, 3}
+	counts := make([]int, len(weights))
+	for i := 0; i < 4000; i++ {
+		counts[pickWeighted(weights, 4)]++
+	}
+	if counts[0] != 0 || counts[2] != 0 {
+		t.Errorf("elements of weight 0 were picked: %v", counts)
+	}
+	if counts[3] < 2*counts[1] {
+		t.Errorf("an element of weight 3 was picked %d times, and one of weight 1 %d times", counts[3], counts[1])
+	}
+}
+
+func TestGetRandomBotUser(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+
+	if _, err := GetRandomBotUser(h.ctx, db); err == nil {
+		t.Error("GetRandomBotUser succeeded with no bots")
+	}
+
+	h.newUser(db, "human", false)
+	quiet := h.newUser(db, "quiet_bot", true)
+	busy := h.newUser(db, "busy_bot", true)
+	h.newPost(db, busy, "busy")
+
+	for _, w := range []BotUserWeighting{BotUserWeightingUniform, BotUserWeightingLeastActive, BotUserWeightingMostActive} {
+		if err := SetBotUserWeighting(w); err != nil {
+			t.Fatal(err)
+		}
+		picks := map[string]int{}
+		for i := 0; i < 50; i++ {
+			bot, err := GetRandomBotUser(h.ctx, db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			picks[bot.Username]++
+		}
+		if picks["human"] > 0 {
+			t.Errorf("weighting %q: a human was picked", w)
+		}
+		if w == BotUserWeightingLeastActive && picks[quiet.Username] <= picks[busy.Username] {
+			t.Errorf("weighting %q: picks = %v", w, picks)
+		}
+		if w == BotUserWeightingMostActive && picks[busy.Username] <= picks[quiet.Username] {
+			t.Errorf("weighting %q: picks = %v", w, picks)
+		}
+	}
+	if err := SetBotUserWeighting(BotUserWeightingUniform); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetBotUserWeighting("busiest"); err == nil {
+		t.Error("SetBotUserWeighting of an invalid weighting succeeded")
+	}
+
+	bots, err := ListBotUsers(h.ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bots) != 2 || bots[0].Username != busy.Username || bots[1].Username != quiet.Username {
+		t.Errorf("ListBotUsers returned %d users, want busy_bot and quiet_bot", len(bots))
+	}
+}
+
+func TestPromoteBotsFromFile(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+
+	if n, err := PromoteBotsFromFile(h.ctx, db, filepath.Join(t.TempDir(), "missing.txt")); err != nil || n != 0 {
+		t.Errorf("PromoteBotsFromFile of a missing file = %d, %v; want 0, nil", n, err)
+	}
+
+	listed := h.newUser(db, "listed", false)
+	h.newUser(db, "unlisted", false)
+	file := filepath.Join(t.TempDir(), "bots.txt")
+	if err := os.WriteFile(file, []byte("Listed,password\nnonexistent,password\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := PromoteBotsFromFile(h.ctx, db, file); err != nil || n != 1 {
+		t.Fatalf("PromoteBotsFromFile = %d, %v; want 1, nil", n, err)
+	}
+	if is, err := IsUserBot(h.ctx, db, listed.ID); err != nil || !is {
+		t.Errorf("listed user is not a bot (err: %v)", err)
+	}
+	if n, err := PromoteBotsFromFile(h.ctx, db, file); err != nil || n != 0 {
+		t.Errorf("second PromoteBotsFromFile = %d, %v; want 0, nil", n, err)
+	}
+}
@@ -0,0 +1,174 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Bots follow up on the replies humans make to their comments: the bot that
+// was replied to replies in turn, with the conversation so far (the chain of
+// comments from the top-level comment down to the reply) in the prompt. A
+// bot follows up at most maxBotFollowUps times in a conversation, and at most
+// once every botFollowUpCooldown in a post.
+
+const (
+	maxBotFollowUps     = 3
+	botFollowUpCooldown = 10 * time.Minute
+)
+
+// isReplyToBot reports whether comment is a reply to a comment of a bot.
+func isReplyToBot(ctx context.Context, db *sql.DB, comment *Comment) (bool, error) {
+	if !comment.ParentID.Valid {
+		return false, nil
+	}
+	var isBot bool
+	err := db.QueryRowContext(ctx, "SELECT users.is_bot FROM comments INNER JOIN users ON users.id = comments.user_id WHERE comments.id = ?", comment.ParentID).Scan(&isBot)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return isBot, err
+}
+
+// getCommentChain returns the ancestors of comment, from the top-level comment
+// down, followed by comment.
+func getCommentChain(ctx context.Context, db *sql.DB, comment *Comment) ([]*Comment, error) {
+	ancestors, err := GetCommentsByIDs(ctx, db, nil, comment.Ancestors...)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uid.ID]*Comment, len(ancestors))
+	for _, c := range ancestors {
+		byID[c.ID] = c
+	}
+	chain := make([]*Comment, 0, len(comment.Ancestors)+1)
+	for _, id := range comment.Ancestors {
+		if c := byID[id]; c != nil {
+			chain = append(chain, c)
+		}
+	}
+	return append(chain, comment), nil
+}
+
+// botFollowUps returns the number of times bot has followed up in chain (the
+// first comment of bot in chain is not a follow-up).
+func botFollowUps(chain []*Comment, bot uid.ID) int {
+	n := 0
+	for _, c := range chain {
+		if c.AuthorID == bot {
+			n++
+		}
+	}
+	if n > 0 {
+		n--
+	}
+	return n
+}
+
+// botConversationHistory formats chain as the history of a conversation, as
+// seen by bot.
+func botConversationHistory(chain []*Comment, bot uid.ID) string {
+	var b strings.Builder
+	for _, c := range chain {
+		name, body := c.AuthorUsername, c.Body
+		if c.AuthorID == bot {
+			name = "you"
+		}
+		if c.Deleted {
+			name, body = "[deleted]", "[deleted]"
+		}
+		fmt.Fprintf(&b, "%s: %s\n", name, body)
+	}
+	return b.String()
+}
+
+// BotFollowUp has the bot that was replied to by comment reply to it in turn.
+// It does nothing if comment is not a reply to a bot, or if the bot has
+// followed up enough in the conversation, or recently in the post.
+func BotFollowUp(ctx context.Context, db *sql.DB, post *Post, comment *Comment) error {
+	if post.Deleted || post.Locked || comment.Deleted {
+		return nil
+	}
+	if is, err := isReplyToBot(ctx, db, comment); err != nil || !is {
+		return err
+	}
+	settings, err := GetCommunityBotSettings(ctx, db, post.CommunityID)
+	if err != nil {
+		return fmt.Errorf("failed to get community bot settings: %w", err)
+	}
+	if !settings.Enabled {
+		return nil
+	}
+
+	chain, err := getCommentChain(ctx, db, comment)
+	if err != nil {
+		return fmt.Errorf("failed to get comment chain: %w", err)
+	}
+	if len(chain) < 2 {
+		return nil // The parent comment is gone.
+	}
+	parent := chain[len(chain)-2]
+	if parent.Deleted {
+		return nil
+	}
+	bot := parent.AuthorID
+	if botFollowUps(chain, bot) >= maxBotFollowUps {
+		return nil
+	}
+	if disabled, err := isBotDisabled(ctx, db, bot); err != nil || disabled {
+		return err
+	}
+
+	var recent int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM comments WHERE post_id = ? AND user_id = ? AND id <> ? AND created_at > ?",
+		post.ID, bot, parent.ID, now().Add(-botFollowUpCooldown)).Scan(&recent); err != nil {
+		return err
+	}
+	if recent > 0 {
+		return nil
+	}
+
+	botCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	persona, err := GetBotUserPersona(botCtx, db, bot)
+	if err != nil {
+		return fmt.Errorf("failed to get bot persona: %w", err)
+	}
+
+	prompt, err := renderBotPrompt(botFollowUpPrompt, &botPromptData{
+		Community:    post.CommunityName,
+		Post:         &botPromptPost{Title: post.Title, Body: post.Body.String},
+		Conversation: botConversationHistory(chain, bot),
+	})
+	if err != nil {
+		return err
+	}
+
+	response, err := GenerateBotResponse(botCtx, prompt, persona)
+	if err != nil {
+		return err
+	}
+
+	action := newBotAction(BotActionFollowUp, settings, bot, 0).target(comment.ID)
+	var made *uid.ID
+	if !action.DryRun {
+		reply, err := Comments().Create(botCtx, db, &NewComment{
+			Post:           post,
+			Author:         bot,
+			Parent:         &comment.ID,
+			Body:           response,
+			UserGroup:      UserGroupBots,
+			SkipRateLimits: true,
+		})
+		if err != nil {
+			return err
+		}
+		made = &reply.ID
+	}
+	return action.record(botCtx, db, prompt, response, made)
+}
@@ -0,0 +1,33 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestBotConversation(t *testing.T) {
+	bot, human := uid.New(), uid.New()
+	chain := []*Comment{
+		{AuthorID: bot, AuthorUsername: "bot", Body: "first"},
+		{AuthorID: human, AuthorUsername: "human", Body: "reply"},
+		{AuthorID: bot, AuthorUsername: "bot", Body: "follow-up"},
+		{AuthorID: human, AuthorUsername: "human", Body: "gone", Deleted: true},
+		{AuthorID: human, AuthorUsername: "human", Body: "again"},
+	}
+
+	if n := botFollowUps(chain, bot); n != 1 {
+		t.Errorf("botFollowUps = %d, want 1", n)
+	}
+	if n := botFollowUps(chain, human); n != 2 {
+		t.Errorf("botFollowUps of the human = %d, want 2", n)
+	}
+	if n := botFollowUps(nil, bot); n != 0 {
+		t.Errorf("botFollowUps of an empty chain = %d, want 0", n)
+	}
+
+	want := "you: first\nhuman: reply\nyou: follow-up\n[deleted]: [deleted]\nhuman: again\n"
+	if got := botConversationHistory(chain, bot); got != want {
+		t.Errorf("botConversationHistory = %q, want %q", got, want)
+	}
+}
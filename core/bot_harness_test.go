@@ -0,0 +1,129 @@
+package core
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/llm"
+)
+
+func TestGenerateBotResponse(t *testing.T) {
+	h := newHarness(t)
+	h.llm.Respond = func(req *llm.Request) (string, error) {
+		return "a response", nil
+	}
+
+	persona := &BotPersona{SystemPrompt: "Be terse.", StyleConstraints: []string{"No caps."}, Temperature: 0.5, Model: "test-model"}
+	res, err := GenerateBotResponse(h.ctx, "the prompt", persona)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "a response" {
+		t.Errorf("response = %q, want %q", res, "a response")
+	}
+
+	reqs := h.llm.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("%d requests made, want 1", len(reqs))
+	}
+	req := reqs[0]
+	if req.Model != "test-model" || req.Temperature == nil || *req.Temperature != 0.5 {
+		t.Errorf("request of model %q at temperature %v, want test-model at 0.5", req.Model, req.Temperature)
+	}
+	if len(req.Messages) != 2 || req.Messages[0].Role != "system" || !strings.Contains(req.Messages[0].Content, "- No caps.") {
+		t.Errorf("unexpected messages: %+v", req.Messages)
+	}
+
+	usage := GetBotUsage()
+	if usage.Requests != 1 || usage.OutputTokens != 2 {
+		t.Errorf("usage = %+v, want 1 request and 2 output tokens", usage)
+	}
+
+	// The budget goes by the clock of the harness.
+	SetBotBudget(BotBudgetLimits{MaxRequestsPerMinute: 1})
+	if _, err := GenerateBotResponse(h.ctx, "again", nil); !errors.Is(err, ErrBotBudgetExceeded) {
+		t.Errorf("second request in a minute: %v, want ErrBotBudgetExceeded", err)
+	}
+	h.clock.Advance(time.Minute)
+	if _, err := GenerateBotResponse(h.ctx, "again", nil); err != nil {
+		t.Errorf("request a minute later: %v", err)
+	}
+}
+
+func TestBotFollowUpIntegration(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+
+	human := h.newUser(db, "human", false)
+	bot := h.newUser(db, "bot", true)
+	post := h.newPost(db, human, "testing")
+
+	botComment, err := Comments().Create(h.ctx, db, &NewComment{Post: post, Author: bot.ID, Body: "bot says", UserGroup: UserGroupBots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := Comments().Create(h.ctx, db, &NewComment{Post: post, Author: human.ID, Parent: &botComment.ID, Body: "human says"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.llm.Respond = func(req *llm.Request) (string, error) {
+		return "the follow-up", nil
+	}
+	if err := BotFollowUp(h.ctx, db, post, reply); err != nil {
+		t.Fatal(err)
+	}
+	if prompt := h.llm.LastPrompt(); !strings.Contains(prompt, "you: bot says\nhuman: human says\n") {
+		t.Errorf("the prompt lacks the conversation: %q", prompt)
+	}
+
+	var n int
+	if err := db.QueryRowContext(h.ctx, "SELECT COUNT(*) FROM comments WHERE user_id = ? AND parent_id = ? AND body = ?", bot.ID, reply.ID, "the follow-up").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("%d follow-ups made, want 1", n)
+	}
+
+	// The bot is cooling down.
+	if err := BotFollowUp(h.ctx, db, post, reply); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.llm.Requests()) != 1 {
+		t.Errorf("the bot followed up during its cooldown")
+	}
+}
+
+func TestBotChoicesRepeatable(t *testing.T) {
+	settings := &CommunityBotSettings{}
+	choose := func() (delays []time.Duration, styles []string) {
+		newHarness(t) // Seeds the source of random numbers.
+		for i := 0; i < 10; i++ {
+			delays = append(delays, botResponseDelay())
+			styles = append(styles, settings.pickTrollingStyle())
+		}
+		return delays, styles
+	}
+
+	delays1, styles1 := choose()
+	delays2, styles2 := choose()
+	if !slices.Equal(delays1, delays2) || !slices.Equal(styles1, styles2) {
+		t.Errorf("bot choices differ for the same seed: %v %v and %v %v", delays1, styles1, delays2, styles2)
+	}
+}
+
+func TestBotSleep(t *testing.T) {
+	h := newHarness(t)
+	done := make(chan error, 1)
+	go func() { done <- sleep(h.ctx, time.Minute) }()
+	for h.clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	h.clock.Advance(time.Minute)
+	if err := <-done; err != nil {
+		t.Errorf("sleep = %v, want nil", err)
+	}
+}
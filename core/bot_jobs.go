@@ -0,0 +1,143 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Bots respond to posts and comments after a random delay (see
+// botResponseDelay), so as to not look like bots. The responses are queued
+// as delayed jobs (see SetJobQueue).
+
+// Kinds of bot jobs.
+const (
+	botJobRespondToPost    = "bot_respond_to_post"
+	botJobRespondToComment = "bot_respond_to_comment"
+)
+
+type botResponseJob struct {
+	PostID    uid.ID  `json:"postId"`
+	CommentID *uid.ID `json:"commentId,omitempty"`
+}
+
+// botResponseDelay returns a random delay between 1 and 5 minutes.
+func botResponseDelay() time.Duration {
+	return time.Duration(1+random().Intn(5)) * time.Minute
+}
+
+// QueueBotResponseToPost queues a bot response to post.
+func QueueBotResponseToPost(db *sql.DB, post *Post) {
+	queueBotResponse(db, botJobRespondToPost, &botResponseJob{PostID: post.ID})
+}
+
+// QueueBotResponseToComment queues a bot response to comment.
+func QueueBotResponseToComment(db *sql.DB, comment *Comment) {
+	queueBotResponse(db, botJobRespondToComment, &botResponseJob{PostID: comment.PostID, CommentID: &comment.ID})
+}
+
+// queueBotResponse queues job. Errors are logged, since bot responses are not
+// essential to the requests that trigger them.
+func queueBotResponse(db *sql.DB, kind string, job *botResponseJob) {
+	if BotsHalted() {
+		return
+	}
+	if err := queueJob(db, kind, job, now().Add(botResponseDelay())); err != nil {
+		slog.Error("Error queuing bot job", "kind", kind, "error", err)
+	}
+}
+
+// runBotResponseJob responds to the post, or the comment, of the job with
+// payload (see BotFollowUp for replies to bots). Posts and comments that have
+// since been deleted are not responded to.
+func runBotResponseJob(ctx context.Context, db *sql.DB, payload json.RawMessage) error {
+	if BotsHalted() {
+		return nil // Dropped.
+	}
+	job := &botResponseJob{}
+	if err := json.Unmarshal(payload, job); err != nil {
+		return err
+	}
+	post, err := GetPost(ctx, db, &job.PostID, "", nil, true)
+	if err != nil {
+		if httperr.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	e := &ActivityEvent{
+		Type:          ActivityBotJob,
+		TargetUserID:  uid.NullID{ID: post.AuthorID, Valid: true},
+		CommunityID:   post.CommunityID,
+		CommunityName: post.CommunityName,
+		PostID:        uid.NullID{ID: post.ID, Valid: true},
+	}
+	if job.CommentID == nil {
+		community, err := GetCommunityByID(ctx, db, post.CommunityID, nil)
+		if err != nil {
+			return err
+		}
+		err = BotRespondToPost(ctx, db, post, community)
+		publishBotJobOutcome(ctx, db, e, err)
+		return skipBotLimits(err)
+	}
+
+	comment, err := GetComment(ctx, db, *job.CommentID, nil)
+	if err != nil {
+		if httperr.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if comment.Deleted {
+		return nil
+	}
+	e.TargetUserID = uid.NullID{ID: comment.AuthorID, Valid: true}
+	e.CommentID = uid.NullID{ID: comment.ID, Valid: true}
+
+	// Replies to bots are followed up on by the bot replied to, rather than
+	// responded to by random bots.
+	replyToBot, err := isReplyToBot(ctx, db, comment)
+	if err != nil {
+		return err
+	}
+	if replyToBot {
+		err = BotFollowUp(ctx, db, post, comment)
+	} else {
+		err = BotRespondToComment(ctx, db, post, comment)
+	}
+	publishBotJobOutcome(ctx, db, e, err)
+	return skipBotLimits(err)
+}
+
+// publishBotJobOutcome publishes e, the activity event of a bot job, with the
+// outcome of the job, which ended with err.
+func publishBotJobOutcome(ctx context.Context, db *sql.DB, e *ActivityEvent, err error) {
+	switch {
+	case err == nil:
+		e.Detail = "done"
+	case errors.Is(err, ErrBotBudgetExceeded), errors.Is(err, ErrBotsHalted):
+		e.Detail = "skipped"
+	default:
+		e.Detail = "failed: " + err.Error()
+	}
+	publishActivity(ctx, db, e)
+}
+
+// skipBotLimits returns err, unless it's because the bot budget is spent or
+// the bot kill switch is on, in which case the bot response is skipped (rather
+// than retried).
+func skipBotLimits(err error) error {
+	if errors.Is(err, ErrBotBudgetExceeded) || errors.Is(err, ErrBotsHalted) {
+		slog.Warn("Skipped bot response", "reason", err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,142 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/logging"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Admins can halt all automated content at once with the bot kill switch (see
+// SetBotKillSwitch): while it's on, bots neither post nor respond, bot jobs
+// are dropped, and no requests are made to the OpenAI API. The switch is kept
+// in the database, and each process keeps a copy of it in memory (see
+// LoadBotKillSwitch), which other processes are told to reload when the
+// switch is flipped (see SetBotKillSwitchNotifier).
+
+const maxBotKillSwitchReasonLength = 255
+
+// ErrBotsHalted is returned by GenerateBotResponse if the bot kill switch is
+// on.
+var ErrBotsHalted = errors.New("bots are halted by the kill switch")
+
+// BotKillSwitch is the state of the bot kill switch.
+type BotKillSwitch struct {
+	Halted    bool       `json:"halted"`
+	Reason    string     `json:"reason"`
+	UpdatedBy uid.NullID `json:"updatedBy"`
+	UpdatedAt *time.Time `json:"updatedAt"` // Nil if never flipped.
+}
+
+var (
+	botsHalted atomic.Bool
+
+	killSwitchNotifierMu sync.RWMutex // guards killSwitchNotifier
+	killSwitchNotifier   func(ctx context.Context) error
+)
+
+// BotsHalted reports whether the bot kill switch is on (as of when it was last
+// loaded by this process).
+func BotsHalted() bool {
+	return botsHalted.Load()
+}
+
+// SetBotKillSwitchNotifier sets the function called after the bot kill switch
+// is flipped, to tell the other processes to reload it.
+func SetBotKillSwitchNotifier(notify func(ctx context.Context) error) {
+	killSwitchNotifierMu.Lock()
+	defer killSwitchNotifierMu.Unlock()
+	killSwitchNotifier = notify
+}
+
+// GetBotKillSwitch returns the state of the bot kill switch, as stored in the
+// database.
+func GetBotKillSwitch(ctx context.Context, db *sql.DB) (*BotKillSwitch, error) {
+	var data string
+	err := db.QueryRowContext(ctx, "SELECT `value` FROM application_data WHERE `key` = ?", "bot_kill_switch").Scan(&data)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &BotKillSwitch{}, nil
+		}
+		return nil, err
+	}
+	ks := &BotKillSwitch{}
+	if err := json.Unmarshal([]byte(data), ks); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// LoadBotKillSwitch loads the bot kill switch from the database into memory.
+func LoadBotKillSwitch(ctx context.Context, db *sql.DB) error {
+	ks, err := GetBotKillSwitch(ctx, db)
+	if err != nil {
+		return err
+	}
+	if botsHalted.Swap(ks.Halted) != ks.Halted {
+		logBotKillSwitch(ks)
+	}
+	return nil
+}
+
+func logBotKillSwitch(ks *BotKillSwitch) {
+	if ks.Halted {
+		slog.Warn("Bots halted by the kill switch", "reason", ks.Reason)
+	} else {
+		slog.Info("Bots resumed by the kill switch")
+	}
+}
+
+// SetBotKillSwitch turns the bot kill switch on (if halted is true) or off,
+// on behalf of admin.
+func SetBotKillSwitch(ctx context.Context, db *sql.DB, admin uid.ID, halted bool, reason string) (*BotKillSwitch, error) {
+	if is, err := IsAdmin(db, &admin); err != nil {
+		return nil, err
+	} else if !is {
+		return nil, errNotAdmin
+	}
+	reason = strings.TrimSpace(reason)
+	if len(reason) > maxBotKillSwitchReasonLength {
+		return nil, httperr.NewBadRequest("invalid_reason", fmt.Sprintf("Reason cannot be longer than %d characters.", maxBotKillSwitchReasonLength))
+	}
+
+	updatedAt := now()
+	ks := &BotKillSwitch{
+		Halted:    halted,
+		Reason:    reason,
+		UpdatedBy: uid.NullID{ID: admin, Valid: true},
+		UpdatedAt: &updatedAt,
+	}
+	data, err := json.Marshal(ks)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO application_data (`key`, `value`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `value` = VALUES(`value`)",
+		"bot_kill_switch", string(data)); err != nil {
+		return nil, err
+	}
+	if botsHalted.Swap(halted) != halted {
+		logBotKillSwitch(ks)
+	}
+
+	killSwitchNotifierMu.RLock()
+	notify := killSwitchNotifier
+	killSwitchNotifierMu.RUnlock()
+	if notify != nil {
+		if err := notify(ctx); err != nil {
+			// The other processes reload the switch periodically anyway.
+			logging.FromContext(ctx).Error("Error notifying of the bot kill switch", "error", err)
+		}
+	}
+	return ks, nil
+}
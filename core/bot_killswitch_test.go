@@ -0,0 +1,23 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestBotsHalted(t *testing.T) {
+	botsHalted.Store(true)
+	defer botsHalted.Store(false)
+
+	if _, err := GenerateBotResponse(context.Background(), "Hello", nil); !errors.Is(err, ErrBotsHalted) {
+		t.Fatalf("GenerateBotResponse while halted: got %v, want ErrBotsHalted", err)
+	}
+	if err := skipBotLimits(fmt.Errorf("failed to generate bot post: %w", ErrBotsHalted)); err != nil {
+		t.Errorf("skipBotLimits(ErrBotsHalted) = %v, want nil", err)
+	}
+	if err := skipBotLimits(errors.New("other")); err == nil {
+		t.Error("skipBotLimits(other error) = nil, want the error")
+	}
+}
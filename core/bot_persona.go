@@ -0,0 +1,300 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Bots write as personas: a system prompt, constraints on the style of the
+// writing, and the model (and its temperature) that does the writing. Admins
+// create personas and assign them to bot users (see AssignBotPersona); bots
+// without a persona write with the default model, and no system prompt. Bots
+// whose persona is disabled (see BotPersona.SetDisabled) don't write at all.
+
+const (
+	defaultBotModel = "gpt-4o-mini"
+
+	maxBotPersonaNameLength       = 64
+	maxBotPersonaPromptLength     = 10000
+	maxBotPersonaConstraints      = 20
+	maxBotPersonaConstraintLength = 500
+	maxBotModelNameLength         = 64
+	minBotTemperature             = 0.0
+	maxBotTemperature             = 2.0
+)
+
+var errBotPersonaNotFound = httperr.NewNotFound("bot_persona_not_found", "Bot persona not found.")
+
+// BotPersona is a persona bots write as.
+type BotPersona struct {
+	ID               uid.ID        `json:"id"`
+	Name             string        `json:"name"`
+	SystemPrompt     string        `json:"systemPrompt"`
+	StyleConstraints []string      `json:"styleConstraints"`
+	Temperature      float64       `json:"temperature"`
+	Model            string        `json:"model"`
+	NumBots          int           `json:"noBots"` // Bot users assigned the persona.
+	DisabledAt       msql.NullTime `json:"disabledAt"`
+	CreatedBy        uid.NullID    `json:"createdBy"`
+	CreatedAt        time.Time     `json:"createdAt"`
+	UpdatedAt        msql.NullTime `json:"updatedAt"`
+}
+
+// validate checks p, trims its text, and fills in the defaults.
+func (p *BotPersona) validate() error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" || len(p.Name) > maxBotPersonaNameLength {
+		return httperr.NewBadRequest("invalid_name", fmt.Sprintf("Persona name must be between 1 and %d characters long.", maxBotPersonaNameLength))
+	}
+	p.SystemPrompt = strings.TrimSpace(p.SystemPrompt)
+	if p.SystemPrompt == "" || len(p.SystemPrompt) > maxBotPersonaPromptLength {
+		return httperr.NewBadRequest("invalid_system_prompt", fmt.Sprintf("System prompt must be between 1 and %d characters long.", maxBotPersonaPromptLength))
+	}
+	if len(p.StyleConstraints) > maxBotPersonaConstraints {
+		return httperr.NewBadRequest("invalid_style_constraints", fmt.Sprintf("A persona can have at most %d style constraints.", maxBotPersonaConstraints))
+	}
+	constraints := []string{}
+	for _, c := range p.StyleConstraints {
+		if c = strings.TrimSpace(c); c == "" {
+			continue
+		}
+		if len(c) > maxBotPersonaConstraintLength {
+			return httperr.NewBadRequest("invalid_style_constraints", fmt.Sprintf("Style constraints cannot be longer than %d characters.", maxBotPersonaConstraintLength))
+		}
+		constraints = append(constraints, c)
+	}
+	p.StyleConstraints = constraints
+	if p.Temperature < minBotTemperature || p.Temperature > maxBotTemperature {
+		return httperr.NewBadRequest("invalid_temperature", fmt.Sprintf("Temperature must be between %v and %v.", minBotTemperature, maxBotTemperature))
+	}
+	if p.Model = strings.TrimSpace(p.Model); p.Model == "" {
+		p.Model = defaultBotModel
+	} else if len(p.Model) > maxBotModelNameLength {
+		return httperr.NewBadRequest("invalid_model", "Invalid model name.")
+	}
+	return nil
+}
+
+// systemMessage returns the system message of the requests made as p.
+func (p *BotPersona) systemMessage() string {
+	if len(p.StyleConstraints) == 0 {
+		return p.SystemPrompt
+	}
+	var b strings.Builder
+	b.WriteString(p.SystemPrompt)
+	b.WriteString("\n\nStyle constraints:\n")
+	for _, c := range p.StyleConstraints {
+		b.WriteString("- " + c + "\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func getBotPersonas(ctx context.Context, db *sql.DB, where string, args ...any) ([]*BotPersona, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT bot_personas.id, bot_personas.name, bot_personas.system_prompt, bot_personas.style_constraints, bot_personas.temperature, bot_personas.model,
+			(SELECT COUNT(*) FROM bot_persona_users WHERE bot_persona_users.persona_id = bot_personas.id),
+			bot_personas.disabled_at, bot_personas.created_by, bot_personas.created_at, bot_personas.updated_at
+		FROM bot_personas `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	personas := []*BotPersona{}
+	for rows.Next() {
+		p := &BotPersona{StyleConstraints: []string{}}
+		var constraints []byte
+		if err := rows.Scan(&p.ID, &p.Name, &p.SystemPrompt, &constraints, &p.Temperature, &p.Model, &p.NumBots, &p.DisabledAt, &p.CreatedBy, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if constraints != nil {
+			if err := json.Unmarshal(constraints, &p.StyleConstraints); err != nil {
+				return nil, err
+			}
+		}
+		personas = append(personas, p)
+	}
+	return personas, rows.Err()
+}
+
+// GetBotPersonas returns all bot personas, ordered by name.
+func GetBotPersonas(ctx context.Context, db *sql.DB) ([]*BotPersona, error) {
+	return getBotPersonas(ctx, db, "ORDER BY bot_personas.name")
+}
+
+// GetBotPersona returns the bot persona with id.
+func GetBotPersona(ctx context.Context, db *sql.DB, id uid.ID) (*BotPersona, error) {
+	personas, err := getBotPersonas(ctx, db, "WHERE bot_personas.id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(personas) == 0 {
+		return nil, errBotPersonaNotFound
+	}
+	return personas[0], nil
+}
+
+// GetBotUserPersona returns the persona assigned to the bot user bot, or nil
+// if it has none.
+func GetBotUserPersona(ctx context.Context, db *sql.DB, bot uid.ID) (*BotPersona, error) {
+	personas, err := getBotPersonas(ctx, db, "WHERE bot_personas.id = (SELECT persona_id FROM bot_persona_users WHERE user_id = ?)", bot)
+	if err != nil || len(personas) == 0 {
+		return nil, err
+	}
+	return personas[0], nil
+}
+
+func checkBotPersonaNameFree(ctx context.Context, db *sql.DB, name string, except uid.ID) error {
+	var n int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM bot_personas WHERE name = ? AND id <> ?", name, except).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return httperr.NewBadRequest("name_taken", "A persona with that name already exists.")
+	}
+	return nil
+}
+
+// CreateBotPersona creates a bot persona out of p (of which the fields set by
+// the site are ignored), on behalf of admin.
+func CreateBotPersona(ctx context.Context, db *sql.DB, admin uid.ID, p *BotPersona) (*BotPersona, error) {
+	if is, err := IsAdmin(db, &admin); err != nil {
+		return nil, err
+	} else if !is {
+		return nil, errNotAdmin
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	if err := checkBotPersonaNameFree(ctx, db, p.Name, uid.ID{}); err != nil {
+		return nil, err
+	}
+	constraints, err := json.Marshal(p.StyleConstraints)
+	if err != nil {
+		return nil, err
+	}
+
+	id := uid.New()
+	if _, err := db.ExecContext(ctx, "INSERT INTO bot_personas (id, name, system_prompt, style_constraints, temperature, model, created_by) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		id, p.Name, p.SystemPrompt, constraints, p.Temperature, p.Model, admin); err != nil {
+		return nil, err
+	}
+	return GetBotPersona(ctx, db, id)
+}
+
+// Update sets the name, prompts, and model of p to those of to, on behalf of
+// admin.
+func (p *BotPersona) Update(ctx context.Context, db *sql.DB, admin uid.ID, to *BotPersona) error {
+	if is, err := IsAdmin(db, &admin); err != nil {
+		return err
+	} else if !is {
+		return errNotAdmin
+	}
+	if err := to.validate(); err != nil {
+		return err
+	}
+	if err := checkBotPersonaNameFree(ctx, db, to.Name, p.ID); err != nil {
+		return err
+	}
+	constraints, err := json.Marshal(to.StyleConstraints)
+	if err != nil {
+		return err
+	}
+
+	updatedAt := now()
+	if _, err := db.ExecContext(ctx, "UPDATE bot_personas SET name = ?, system_prompt = ?, style_constraints = ?, temperature = ?, model = ?, updated_at = ? WHERE id = ?",
+		to.Name, to.SystemPrompt, constraints, to.Temperature, to.Model, updatedAt, p.ID); err != nil {
+		return err
+	}
+	p.Name, p.SystemPrompt, p.StyleConstraints = to.Name, to.SystemPrompt, to.StyleConstraints
+	p.Temperature, p.Model = to.Temperature, to.Model
+	p.UpdatedAt = msql.NullTime{NullTime: sql.NullTime{Time: updatedAt, Valid: true}}
+	return nil
+}
+
+// Delete deletes p, on behalf of admin. The bots assigned p are left without
+// a persona.
+func (p *BotPersona) Delete(ctx context.Context, db *sql.DB, admin uid.ID) error {
+	if is, err := IsAdmin(db, &admin); err != nil {
+		return err
+	} else if !is {
+		return errNotAdmin
+	}
+	_, err := db.ExecContext(ctx, "DELETE FROM bot_personas WHERE id = ?", p.ID)
+	return err
+}
+
+// SetDisabled disables or enables p, on behalf of admin. Personas are also
+// disabled when the posts and comments of their bots are reported too often
+// (see disableReportedPersona); enabling p again forgives it the reports made
+// so far.
+func (p *BotPersona) SetDisabled(ctx context.Context, db *sql.DB, admin uid.ID, disabled bool) error {
+	if is, err := IsAdmin(db, &admin); err != nil {
+		return err
+	} else if !is {
+		return errNotAdmin
+	}
+	t := now()
+	if disabled {
+		if !p.DisabledAt.Valid {
+			if _, err := db.ExecContext(ctx, "UPDATE bot_personas SET disabled_at = ? WHERE id = ?", t, p.ID); err != nil {
+				return err
+			}
+			p.DisabledAt = msql.NullTime{NullTime: sql.NullTime{Time: t, Valid: true}}
+		}
+		return nil
+	}
+	if _, err := db.ExecContext(ctx, "UPDATE bot_personas SET disabled_at = NULL, reports_reset_at = ? WHERE id = ?", t, p.ID); err != nil {
+		return err
+	}
+	p.DisabledAt = msql.NullTime{}
+	return nil
+}
+
+// isBotDisabled reports whether the persona of bot is disabled.
+func isBotDisabled(ctx context.Context, db *sql.DB, bot uid.ID) (bool, error) {
+	var disabled bool
+	err := db.QueryRowContext(ctx, `
+		SELECT bot_personas.disabled_at IS NOT NULL FROM bot_personas
+		INNER JOIN bot_persona_users ON bot_persona_users.persona_id = bot_personas.id
+		WHERE bot_persona_users.user_id = ?`, bot).Scan(&disabled)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return disabled, err
+}
+
+// AssignBotPersona assigns the persona with id persona to the bot user bot,
+// on behalf of admin. If persona is nil, bot is left without a persona.
+func AssignBotPersona(ctx context.Context, db *sql.DB, admin, bot uid.ID, persona *uid.ID) error {
+	if is, err := IsAdmin(db, &admin); err != nil {
+		return err
+	} else if !is {
+		return errNotAdmin
+	}
+	if is, err := IsUserBot(ctx, db, bot); err != nil {
+		return err
+	} else if !is {
+		return httperr.NewBadRequest("not_bot", "User is not a bot.")
+	}
+
+	if persona == nil {
+		_, err := db.ExecContext(ctx, "DELETE FROM bot_persona_users WHERE user_id = ?", bot)
+		return err
+	}
+	if _, err := GetBotPersona(ctx, db, *persona); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO bot_persona_users (user_id, persona_id) VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE persona_id = VALUES(persona_id), assigned_at = current_timestamp()`, bot, *persona)
+	return err
+}
@@ -0,0 +1,38 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBotPersonaValidate(t *testing.T) {
+	p := &BotPersona{
+		Name:             " skeptic ",
+		SystemPrompt:     "You doubt everything.",
+		StyleConstraints: []string{" no emoji ", "", "all lowercase"},
+		Temperature:      0.7,
+	}
+	if err := p.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "skeptic" || p.Model != defaultBotModel || len(p.StyleConstraints) != 2 {
+		t.Errorf("validate() left %+v", p)
+	}
+	want := "You doubt everything.\n\nStyle constraints:\n- no emoji\n- all lowercase"
+	if got := p.systemMessage(); got != want {
+		t.Errorf("systemMessage() = %q, want %q", got, want)
+	}
+
+	invalid := []*BotPersona{
+		{Name: "", SystemPrompt: "x"},
+		{Name: "x", SystemPrompt: ""},
+		{Name: "x", SystemPrompt: "x", Temperature: 2.5},
+		{Name: "x", SystemPrompt: "x", Model: strings.Repeat("m", 65)},
+		{Name: "x", SystemPrompt: "x", StyleConstraints: []string{strings.Repeat("c", 501)}},
+	}
+	for _, p := range invalid {
+		if err := p.validate(); err == nil {
+			t.Errorf("validate(%+v) succeeded, want error", p)
+		}
+	}
+}
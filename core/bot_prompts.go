@@ -0,0 +1,251 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// The prompts bots are given are text/template templates: those in prompts/,
+// embedded in the binary, overridden by the templates of the same name (if
+// any) in the directory set with LoadBotPrompts. The templates are executed
+// with a botPromptData. The templates in the directory can be edited while the
+// site is running; ReloadBotPrompts picks the edits up.
+
+//go:embed prompts/*.tmpl
+var defaultBotPrompts embed.FS
+
+// Names of the templates of the prompts bots are given.
+const (
+	botToxicityPrompt = "toxicity.tmpl" // Asks for the toxicity score of a community.
+	botPostPrompt     = "post.tmpl"     // Asks for a post.
+	botCommentPrompt  = "comment.tmpl"  // Asks for a comment on a post, or a reply to a comment.
+	botFollowUpPrompt = "follow_up.tmpl"
+)
+
+// botPromptRecentPosts is the number of the recent posts of a community that
+// prompts are given.
+const botPromptRecentPosts = 5
+
+var botPromptNames = []string{botToxicityPrompt, botPostPrompt, botCommentPrompt, botFollowUpPrompt}
+
+var botPromptFuncs = template.FuncMap{
+	"inc": func(i int) int { return i + 1 },
+}
+
+var botPrompts = struct {
+	sync.RWMutex
+	dir   string
+	stamp string // Of the templates in dir, as of the last (re)load.
+	tmpl  *template.Template
+}{
+	tmpl: template.Must(parseBotPrompts("")),
+}
+
+// botPromptData is what the templates of bot prompts are executed with.
+type botPromptData struct {
+	Community    string
+	About        string // Of the community.
+	Rules        []botPromptRule
+	RecentPosts  []botPromptPost
+	Toxicity     int
+	Style        string // The trolling style.
+	Post         *botPromptPost
+	Comments     []botPromptComment // Of Post.
+	Comment      string             // The comment replied to.
+	Conversation string             // See botConversationHistory.
+}
+
+type botPromptRule struct {
+	Rule        string
+	Description string
+}
+
+type botPromptPost struct {
+	Title string
+	Body  string
+}
+
+type botPromptComment struct {
+	Username string
+	Body     string
+}
+
+// newBotPromptData returns the data of the prompts of bots about community,
+// with its rules and recent posts.
+func newBotPromptData(ctx context.Context, db *sql.DB, community *Community) (*botPromptData, error) {
+	if err := community.FetchRules(ctx, db); err != nil {
+		return nil, fmt.Errorf("failed to fetch community rules: %w", err)
+	}
+	recentPosts, err := GetCommunityPosts(ctx, db, community.ID, &CommunityPostsOptions{
+		Sort:        FeedSortLatest,
+		PinnedFirst: true,
+		Limit:       botPromptRecentPosts,
+		internal:    true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recent posts: %w", err)
+	}
+
+	data := &botPromptData{Community: community.Name}
+	if community.About.Valid {
+		data.About = community.About.String
+	}
+	for _, rule := range community.Rules {
+		if rule == nil {
+			continue
+		}
+		data.Rules = append(data.Rules, botPromptRule{Rule: rule.Rule, Description: rule.Description.String})
+	}
+	// The pinned posts first, then the latest.
+	for _, p := range recentPosts.Posts[:min(len(recentPosts.Posts), botPromptRecentPosts)] {
+		if p == nil {
+			continue
+		}
+		data.RecentPosts = append(data.RecentPosts, botPromptPost{Title: p.Title, Body: p.Body.String})
+	}
+	return data, nil
+}
+
+// setPost sets the post of d to post, with its comments (which must have been
+// fetched).
+func (d *botPromptData) setPost(post *Post) {
+	d.Post = &botPromptPost{Title: post.Title, Body: post.Body.String}
+	d.Comments = nil
+	for _, c := range post.Comments {
+		if c == nil || c.Author == nil {
+			continue
+		}
+		d.Comments = append(d.Comments, botPromptComment{Username: c.Author.Username, Body: c.Body})
+	}
+}
+
+// evalToxicity asks for the toxicity score of the community of d, and returns
+// it unclamped.
+func (d *botPromptData) evalToxicity(ctx context.Context) (int, error) {
+	prompt, err := renderBotPrompt(botToxicityPrompt, d)
+	if err != nil {
+		return 0, err
+	}
+	response, err := GenerateBotResponse(ctx, prompt, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to evaluate toxicity: %w", err)
+	}
+	score := 0
+	if _, err := fmt.Sscanf(response, "%d", &score); err != nil {
+		return 0, fmt.Errorf("failed to parse toxicity score: %w", err)
+	}
+	return score, nil
+}
+
+// parseBotPrompts parses the embedded templates of bot prompts, and then the
+// templates in dir, if it's not empty.
+func parseBotPrompts(dir string) (*template.Template, error) {
+	t, err := template.New("").Funcs(botPromptFuncs).ParseFS(defaultBotPrompts, "prompts/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+		if err != nil {
+			return nil, err
+		}
+		if len(files) > 0 {
+			if t, err = t.ParseFiles(files...); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, name := range botPromptNames {
+		if t.Lookup(name) == nil {
+			return nil, fmt.Errorf("bot prompt %s is missing", name)
+		}
+	}
+	return t, nil
+}
+
+// botPromptsStamp returns a string that changes whenever a template in dir is
+// added, removed, or modified.
+func botPromptsStamp(dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+	var b strings.Builder
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", filepath.Base(file), info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+// LoadBotPrompts loads the templates of bot prompts, overriding the default
+// ones with those in dir. If dir is empty, the default templates are used.
+func LoadBotPrompts(dir string) error {
+	stamp, err := botPromptsStamp(dir)
+	if err != nil {
+		return err
+	}
+	t, err := parseBotPrompts(dir)
+	if err != nil {
+		return err
+	}
+	botPrompts.Lock()
+	defer botPrompts.Unlock()
+	botPrompts.dir, botPrompts.stamp, botPrompts.tmpl = dir, stamp, t
+	return nil
+}
+
+// ReloadBotPrompts reloads the templates of bot prompts if those in the
+// directory set with LoadBotPrompts changed since they were last loaded, and
+// reports whether it did. If the templates fail to parse, the ones loaded
+// before are kept.
+func ReloadBotPrompts() (bool, error) {
+	botPrompts.RLock()
+	dir, last := botPrompts.dir, botPrompts.stamp
+	botPrompts.RUnlock()
+	if dir == "" {
+		return false, nil
+	}
+
+	stamp, err := botPromptsStamp(dir)
+	if err != nil || stamp == last {
+		return false, err
+	}
+	t, err := parseBotPrompts(dir)
+	if err != nil {
+		return false, err
+	}
+	botPrompts.Lock()
+	defer botPrompts.Unlock()
+	botPrompts.stamp, botPrompts.tmpl = stamp, t
+	return true, nil
+}
+
+// renderBotPrompt executes the template of the bot prompt name with data.
+func renderBotPrompt(name string, data *botPromptData) (string, error) {
+	botPrompts.RLock()
+	t := botPrompts.tmpl
+	botPrompts.RUnlock()
+
+	var b bytes.Buffer
+	if err := t.ExecuteTemplate(&b, name, data); err != nil {
+		return "", fmt.Errorf("failed to render bot prompt %s: %w", name, err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderBotPrompts(t *testing.T) {
+	data := &botPromptData{
+		Community: "gardening",
+		About:     "All things plants.",
+		Rules: []botPromptRule{
+			{Rule: "Be nice"},
+			{Rule: "No spam", Description: "Ads get removed."},
+		},
+		RecentPosts: []botPromptPost{{Title: "Tomatoes", Body: "They're red."}},
+		Toxicity:    3,
+		Style:       "Use one emoji.",
+	}
+
+	got, err := renderBotPrompt(botToxicityPrompt, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Community Rules:\n- Be nice\n- No spam: Ads get removed.\n", "Recent Posts in this Community:\n1. Tomatoes\n   They're red."} {
+		if !strings.Contains(got, want) {
+			t.Errorf("toxicity prompt %q does not contain %q", got, want)
+		}
+	}
+
+	got, err = renderBotPrompt(botPostPrompt, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Toxicity Score: 3\nCommunity: gardening\nDescription: All things plants.\n", "Score 5 = aggressive", "Use one emoji.", "TITLE: [title]"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("post prompt %q does not contain %q", got, want)
+		}
+	}
+
+	data.Post = &botPromptPost{Title: "Roses", Body: "Thorny."}
+	data.Comments = []botPromptComment{{Username: "alice", Body: "love them"}}
+	got, err = renderBotPrompt(botCommentPrompt, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "Comments on this Post:\n1. alice: love them\n") || !strings.Contains(got, "comment for this posts") {
+		t.Errorf("comment prompt %q is missing the comments or the ask", got)
+	}
+	if strings.Contains(got, "Comment to respond to") {
+		t.Errorf("comment prompt %q asks for a reply", got)
+	}
+
+	data.Comment = "roses are overrated"
+	if got, err = renderBotPrompt(botCommentPrompt, data); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "Comment to respond to: roses are overrated\n") {
+		t.Errorf("reply prompt %q does not contain the comment", got)
+	}
+}
+
+func TestReloadBotPrompts(t *testing.T) {
+	t.Cleanup(func() {
+		if err := LoadBotPrompts(""); err != nil {
+			t.Error(err)
+		}
+	})
+
+	dir := t.TempDir()
+	write := func(name, text string, mod time.Time) {
+		t.Helper()
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	render := func() string {
+		t.Helper()
+		got, err := renderBotPrompt(botFollowUpPrompt, &botPromptData{Community: "gardening"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	mod := time.Now().Add(-time.Hour)
+	write(botFollowUpPrompt, "v1 {{.Community}}", mod)
+	if err := LoadBotPrompts(dir); err != nil {
+		t.Fatal(err)
+	}
+	if got := render(); got != "v1 gardening" {
+		t.Errorf("overridden prompt = %q, want %q", got, "v1 gardening")
+	}
+	if _, err := renderBotPrompt(botPostPrompt, &botPromptData{}); err != nil {
+		t.Errorf("prompt not overridden failed to render: %v", err)
+	}
+
+	if reloaded, err := ReloadBotPrompts(); err != nil || reloaded {
+		t.Errorf("ReloadBotPrompts of unchanged prompts = %v, %v; want false, nil", reloaded, err)
+	}
+
+	write(botFollowUpPrompt, "v2 {{.Community}}", mod.Add(time.Minute))
+	if reloaded, err := ReloadBotPrompts(); err != nil || !reloaded {
+		t.Fatalf("ReloadBotPrompts of an edited prompt = %v, %v; want true, nil", reloaded, err)
+	}
+	if got := render(); got != "v2 gardening" {
+		t.Errorf("reloaded prompt = %q, want %q", got, "v2 gardening")
+	}
+
+	write(botFollowUpPrompt, "v3 {{.Community", mod.Add(2*time.Minute))
+	if _, err := ReloadBotPrompts(); err == nil {
+		t.Error("ReloadBotPrompts of a broken prompt succeeded")
+	}
+	if got := render(); got != "v2 gardening" {
+		t.Errorf("prompt after a failed reload = %q, want %q", got, "v2 gardening")
+	}
+}
@@ -0,0 +1,199 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Reports of the posts and comments of bots are linked to the bot actions
+// that made them (in the bot_action_reports table), and bots are held back
+// by them: every botReportsPerToxicityStep actions reported in a community in
+// the last botReportWindow lower the toxicity ceiling of the community (see
+// CommunityBotSettings.ToxicityPenalty) by one, and a persona whose bots had
+// botPersonaReportLimit actions reported in that time is disabled, until an
+// admin enables it again (see BotPersona.SetDisabled).
+
+const (
+	botReportWindow           = 7 * 24 * time.Hour
+	botReportsPerToxicityStep = 3
+	botPersonaReportLimit     = 10
+)
+
+// linkBotActionReport links the report with id report, of the post or comment
+// target, to the bot action that made target, if any, and then holds back the
+// persona of the bot if it's been reported enough.
+func linkBotActionReport(ctx context.Context, db *sql.DB, report int, target uid.ID) error {
+	var (
+		action uid.ID
+		bot    uid.NullID
+	)
+	err := db.QueryRowContext(ctx, "SELECT id, bot_id FROM bot_actions WHERE result_id = ? LIMIT 1", target).Scan(&action, &bot)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil // Not made by a bot.
+		}
+		return err
+	}
+	if _, err := db.ExecContext(ctx, "INSERT IGNORE INTO bot_action_reports (report_id, bot_action_id, created_at) VALUES (?, ?, ?)", report, action, now()); err != nil {
+		return err
+	}
+	if bot.Valid {
+		return disableReportedPersona(ctx, db, bot.ID)
+	}
+	return nil
+}
+
+// disableReportedPersona disables the persona of bot if the actions of its
+// bots were reported botPersonaReportLimit times in the last botReportWindow
+// (and since the persona was last enabled).
+func disableReportedPersona(ctx context.Context, db *sql.DB, bot uid.ID) error {
+	var persona uid.ID
+	err := db.QueryRowContext(ctx, `
+		SELECT bot_personas.id FROM bot_personas
+		INNER JOIN bot_persona_users ON bot_persona_users.persona_id = bot_personas.id
+		WHERE bot_persona_users.user_id = ? AND bot_personas.disabled_at IS NULL`, bot).Scan(&persona)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	n, err := personaReportedActions(ctx, db, persona)
+	if err != nil || n < botPersonaReportLimit {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "UPDATE bot_personas SET disabled_at = ? WHERE id = ? AND disabled_at IS NULL", now(), persona)
+	return err
+}
+
+// personaReportedActions returns the number of actions of the bots of persona
+// that were reported in the last botReportWindow, and since the reports of
+// persona were last reset.
+func personaReportedActions(ctx context.Context, db *sql.DB, persona uid.ID) (int, error) {
+	var n int
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT bot_action_reports.bot_action_id) FROM bot_action_reports
+		INNER JOIN bot_actions ON bot_actions.id = bot_action_reports.bot_action_id
+		INNER JOIN bot_persona_users ON bot_persona_users.user_id = bot_actions.bot_id
+		INNER JOIN bot_personas ON bot_personas.id = bot_persona_users.persona_id
+		WHERE bot_personas.id = ? AND bot_action_reports.created_at >= ?
+			AND (bot_personas.reports_reset_at IS NULL OR bot_action_reports.created_at >= bot_personas.reports_reset_at)`,
+		persona, now().Add(-botReportWindow)).Scan(&n)
+	return n, err
+}
+
+// communityToxicityPenalty returns the number of steps the toxicity ceiling
+// of community is lowered by, for the actions of bots reported in it.
+func communityToxicityPenalty(ctx context.Context, db *sql.DB, community uid.ID) (int, error) {
+	var n int
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT bot_action_reports.bot_action_id) FROM bot_action_reports
+		INNER JOIN bot_actions ON bot_actions.id = bot_action_reports.bot_action_id
+		WHERE bot_actions.community_id = ? AND bot_action_reports.created_at >= ?`,
+		community, now().Add(-botReportWindow)).Scan(&n)
+	return n / botReportsPerToxicityStep, err
+}
+
+// BotReportStats are the reports of the posts and comments of bots in the
+// last botReportWindow.
+type BotReportStats struct {
+	Since       time.Time                  `json:"since"`
+	Personas    []*BotPersonaReportStats   `json:"personas"`
+	Communities []*BotCommunityReportStats `json:"communities"`
+}
+
+// BotPersonaReportStats are the reports of the bots of a persona.
+type BotPersonaReportStats struct {
+	PersonaID       uid.ID        `json:"personaId"`
+	Name            string        `json:"name"`
+	Actions         int           `json:"noActions"`
+	ReportedActions int           `json:"noReportedActions"`
+	Reports         int           `json:"noReports"`
+	DisabledAt      msql.NullTime `json:"disabledAt"`
+}
+
+// BotCommunityReportStats are the reports of the bots in a community.
+type BotCommunityReportStats struct {
+	CommunityID     uid.ID `json:"communityId"`
+	Name            string `json:"name"`
+	Actions         int    `json:"noActions"`
+	ReportedActions int    `json:"noReportedActions"`
+	Reports         int    `json:"noReports"`
+	ToxicityPenalty int    `json:"toxicityPenalty"`
+}
+
+// GetBotReportStats returns the stats of the reports of the posts and
+// comments of bots, on behalf of admin. Communities without reported actions
+// are left out.
+func GetBotReportStats(ctx context.Context, db *sql.DB, admin uid.ID) (*BotReportStats, error) {
+	if is, err := IsAdmin(db, &admin); err != nil {
+		return nil, err
+	} else if !is {
+		return nil, errNotAdmin
+	}
+
+	stats := &BotReportStats{
+		Since:       now().Add(-botReportWindow),
+		Personas:    []*BotPersonaReportStats{},
+		Communities: []*BotCommunityReportStats{},
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT bot_personas.id, bot_personas.name, bot_personas.disabled_at,
+			COUNT(DISTINCT bot_actions.id), COUNT(DISTINCT bot_action_reports.bot_action_id), COUNT(bot_action_reports.report_id)
+		FROM bot_personas
+		LEFT JOIN bot_persona_users ON bot_persona_users.persona_id = bot_personas.id
+		LEFT JOIN bot_actions ON bot_actions.bot_id = bot_persona_users.user_id AND bot_actions.dry_run = FALSE AND bot_actions.created_at >= ?
+		LEFT JOIN bot_action_reports ON bot_action_reports.bot_action_id = bot_actions.id
+		GROUP BY bot_personas.id, bot_personas.name, bot_personas.disabled_at
+		ORDER BY bot_personas.name`, stats.Since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		s := &BotPersonaReportStats{}
+		if err := rows.Scan(&s.PersonaID, &s.Name, &s.DisabledAt, &s.Actions, &s.ReportedActions, &s.Reports); err != nil {
+			return nil, err
+		}
+		stats.Personas = append(stats.Personas, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = db.QueryContext(ctx, `
+		SELECT communities.id, communities.name,
+			COUNT(DISTINCT bot_actions.id), COUNT(DISTINCT bot_action_reports.bot_action_id), COUNT(bot_action_reports.report_id)
+		FROM bot_actions
+		INNER JOIN communities ON communities.id = bot_actions.community_id
+		LEFT JOIN bot_action_reports ON bot_action_reports.bot_action_id = bot_actions.id
+		WHERE bot_actions.dry_run = FALSE AND bot_actions.created_at >= ?
+		GROUP BY communities.id, communities.name
+		HAVING COUNT(bot_action_reports.report_id) > 0
+		ORDER BY COUNT(DISTINCT bot_action_reports.bot_action_id) DESC`, stats.Since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		s := &BotCommunityReportStats{}
+		if err := rows.Scan(&s.CommunityID, &s.Name, &s.Actions, &s.ReportedActions, &s.Reports); err != nil {
+			return nil, err
+		}
+		stats.Communities = append(stats.Communities, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, s := range stats.Communities {
+		if s.ToxicityPenalty, err = communityToxicityPenalty(ctx, db, s.CommunityID); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
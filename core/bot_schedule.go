@@ -0,0 +1,154 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// BotSchedule is when the bot scheduler runs: in scheduling windows of
+// Interval, back to back, from StartHour to EndHour of every day, in the time
+// zone Location. The first window of a day starts at StartHour, and the last
+// is cut short at EndHour. The communities due in a window are posted in, in
+// Batches batches, and in up to Concurrency communities of a batch at a time.
+type BotSchedule struct {
+	Location    *time.Location
+	StartHour   int // Inclusive.
+	EndHour     int // Exclusive. If not after StartHour, the hours run past midnight.
+	Interval    time.Duration
+	Batches     int
+	Concurrency int
+}
+
+// DefaultBotSchedule is the schedule of bot schedulers created with a nil
+// schedule: every botScheduleInterval, from 9am to 9pm Pacific Time.
+var DefaultBotSchedule = BotSchedule{
+	Location:    loadLocation("America/Los_Angeles"),
+	StartHour:   9,
+	EndHour:     21,
+	Interval:    botScheduleInterval,
+	Batches:     12,
+	Concurrency: 4,
+}
+
+// loadLocation returns the time zone name, or UTC if there's no such time
+// zone (or no time zone database).
+func loadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Validate reports whether s is a valid schedule.
+func (s *BotSchedule) Validate() error {
+	if s.Location == nil {
+		return errors.New("bot schedule has no time zone")
+	}
+	if s.StartHour < 0 || s.StartHour > 23 || s.EndHour < 0 || s.EndHour > 24 {
+		return errors.New("bot schedule hours must be between 0 and 24")
+	}
+	if s.Interval < time.Minute {
+		return errors.New("bot schedule interval must be at least a minute")
+	}
+	if s.Batches < 1 {
+		return errors.New("bot schedule must have at least one batch")
+	}
+	if s.Concurrency < 1 {
+		return errors.New("bot schedule concurrency must be at least 1")
+	}
+	return nil
+}
+
+// hours returns the number of hours the scheduler runs a day.
+func (s *BotSchedule) hours() int {
+	h := (s.EndHour - s.StartHour + 24) % 24
+	if h == 0 {
+		h = 24
+	}
+	return h
+}
+
+// period returns the hours the scheduler runs, starting on the day of t.
+func (s *BotSchedule) period(t time.Time) (start, end time.Time) {
+	y, m, d := t.In(s.Location).Date()
+	start = time.Date(y, m, d, s.StartHour, 0, 0, 0, s.Location)
+	end = time.Date(y, m, d, s.StartHour+s.hours(), 0, 0, 0, s.Location)
+	return
+}
+
+// window returns the start of the scheduling window t is in. It returns false
+// if t is outside the hours the scheduler runs.
+func (s *BotSchedule) window(t time.Time) (time.Time, bool) {
+	t = t.In(s.Location)
+	// The hours of the day before may run past midnight.
+	for _, day := range []time.Time{t.AddDate(0, 0, -1), t} {
+		start, end := s.period(day)
+		if !t.Before(start) && t.Before(end) {
+			return start.Add(t.Sub(start) / s.Interval * s.Interval), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// next returns the start of the first scheduling window after t.
+func (s *BotSchedule) next(t time.Time) time.Time {
+	t = t.In(s.Location)
+	for _, day := range []time.Time{t.AddDate(0, 0, -1), t} {
+		start, end := s.period(day)
+		if t.Before(start) {
+			return start
+		}
+		if t.Before(end) {
+			if next := start.Add((t.Sub(start)/s.Interval + 1) * s.Interval); next.Before(end) {
+				return next
+			}
+		}
+	}
+	start, _ := s.period(t.AddDate(0, 0, 1))
+	return start
+}
+
+// runBounded calls fn with each of 0 to n-1, in up to limit goroutines at a
+// time, until ctx is done, and returns the errors fn returned, joined. Panics
+// in fn are recovered, and returned as errors.
+func runBounded(ctx context.Context, limit, n int, fn func(i int) error) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+		sem  = make(chan struct{}, max(limit, 1))
+	)
+	for i := 0; i < n; i++ {
+		select {
+		case <-ctx.Done():
+		case sem <- struct{}{}:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			var err error
+			defer func() {
+				if p := recover(); p != nil {
+					err = fmt.Errorf("panic: %v\n%s", p, debug.Stack())
+				}
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+				<-sem
+				wg.Done()
+			}()
+			err = fn(i)
+		}(i)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,136 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBotScheduleWindow(t *testing.T) {
+	loc := time.FixedZone("UTC-8", -8*60*60)
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2024, time.March, day, hour, min, 0, 0, loc)
+	}
+	s := &BotSchedule{Location: loc, StartHour: 9, EndHour: 21, Interval: 25 * time.Minute, Batches: 12}
+
+	cases := []struct {
+		t      time.Time
+		window time.Time // zero if outside the hours
+		next   time.Time
+	}{
+		{at(1, 8, 59), time.Time{}, at(1, 9, 0)},
+		{at(1, 9, 0), at(1, 9, 0), at(1, 9, 25)},
+		{at(1, 9, 30), at(1, 9, 25), at(1, 9, 50)},
+		{at(1, 20, 50), at(1, 20, 40), at(2, 9, 0)}, // The last window is cut short.
+		{at(1, 21, 0), time.Time{}, at(2, 9, 0)},
+		{at(1, 23, 59), time.Time{}, at(2, 9, 0)},
+	}
+	for _, c := range cases {
+		window, ok := s.window(c.t)
+		if ok != !c.window.IsZero() || !window.Equal(c.window) {
+			t.Errorf("window(%v) = %v, %v; want %v", c.t, window, ok, c.window)
+		}
+		if next := s.next(c.t); !next.Equal(c.next) {
+			t.Errorf("next(%v) = %v, want %v", c.t, next, c.next)
+		}
+	}
+}
+
+func TestBotScheduleWindowPastMidnight(t *testing.T) {
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2024, time.March, day, hour, min, 0, 0, time.UTC)
+	}
+	s := &BotSchedule{Location: time.UTC, StartHour: 22, EndHour: 2, Interval: time.Hour, Batches: 1}
+
+	if window, ok := s.window(at(2, 1, 30)); !ok || !window.Equal(at(2, 1, 0)) {
+		t.Errorf("window(01:30) = %v, %v; want 01:00", window, ok)
+	}
+	if _, ok := s.window(at(2, 2, 0)); ok {
+		t.Error("02:00 is in a window")
+	}
+	if next := s.next(at(2, 1, 30)); !next.Equal(at(2, 22, 0)) {
+		t.Errorf("next(01:30) = %v, want 22:00", next)
+	}
+	if next := s.next(at(2, 23, 0)); !next.Equal(at(3, 0, 0)) {
+		t.Errorf("next(23:00) = %v, want 00:00 of the day after", next)
+	}
+}
+
+func TestBotScheduleAllDay(t *testing.T) {
+	s := &BotSchedule{Location: time.UTC, StartHour: 0, EndHour: 24, Interval: 24 * time.Minute, Batches: 1}
+	tm := time.Date(2024, time.March, 1, 23, 50, 0, 0, time.UTC)
+	if window, ok := s.window(tm); !ok || !window.Equal(time.Date(2024, time.March, 1, 23, 36, 0, 0, time.UTC)) {
+		t.Errorf("window(%v) = %v, %v", tm, window, ok)
+	}
+	if next := s.next(tm); !next.Equal(time.Date(2024, time.March, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("next(%v) = %v", tm, next)
+	}
+}
+
+func TestBotScheduleValidate(t *testing.T) {
+	valid := BotSchedule{Location: time.UTC, StartHour: 9, EndHour: 21, Interval: 25 * time.Minute, Batches: 12, Concurrency: 4}
+	if err := valid.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, change := range []func(s *BotSchedule){
+		func(s *BotSchedule) { s.Location = nil },
+		func(s *BotSchedule) { s.EndHour = 25 },
+		func(s *BotSchedule) { s.StartHour = -1 },
+		func(s *BotSchedule) { s.Interval = 10 * time.Second },
+		func(s *BotSchedule) { s.Batches = 0 },
+		func(s *BotSchedule) { s.Concurrency = 0 },
+	} {
+		s := valid
+		change(&s)
+		if err := s.Validate(); err == nil {
+			t.Errorf("schedule %+v is valid", s)
+		}
+	}
+}
+
+func TestRunBounded(t *testing.T) {
+	var (
+		mu               sync.Mutex
+		running, maxSeen int
+		done             = make([]bool, 10)
+	)
+	err := runBounded(context.Background(), 3, len(done), func(i int) error {
+		mu.Lock()
+		running++
+		maxSeen = max(maxSeen, running)
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		running--
+		done[i] = true
+		mu.Unlock()
+		switch i {
+		case 3:
+			return errors.New("failed")
+		case 7:
+			panic("boom")
+		}
+		return nil
+	})
+	if maxSeen > 3 {
+		t.Errorf("%d ran at a time, want at most 3", maxSeen)
+	}
+	for i, d := range done {
+		if !d {
+			t.Errorf("%d not run", i)
+		}
+	}
+	if err == nil || !strings.Contains(err.Error(), "failed") || !strings.Contains(err.Error(), "panic: boom") {
+		t.Errorf("got error %v, want both the error and the panic", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ran := false
+	if err := runBounded(ctx, 3, 5, func(i int) error { ran = true; return nil }); err != nil || ran {
+		t.Errorf("ran after ctx was done (err: %v)", err)
+	}
+}
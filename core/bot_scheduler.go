@@ -3,103 +3,316 @@ package core
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
-	"math/rand"
-	"strings"
 	"time"
+
+	"github.com/discuitnet/discuit/internal/logging"
+	"github.com/discuitnet/discuit/internal/metrics"
+	"github.com/discuitnet/discuit/internal/uid"
 )
 
+var botBatchDurations = metrics.NewHistogram("discuit_bot_scheduler_batch_duration_seconds",
+	"Durations of the batches of communities that bots post in, by result (ok or error).",
+	[]float64{1, 5, 10, 30, 60, 120, 300, 600}, "result")
+
 // BotScheduler manages the scheduling of bot posts
 type BotScheduler struct {
-	db *sql.DB
+	db       *sql.DB
+	schedule *BotSchedule
 }
 
-// NewBotScheduler creates a new BotScheduler instance
-func NewBotScheduler(db *sql.DB) *BotScheduler {
+// NewBotScheduler creates a new BotScheduler instance that runs on schedule,
+// or on DefaultBotSchedule if schedule is nil.
+func NewBotScheduler(db *sql.DB, schedule *BotSchedule) *BotScheduler {
+	if schedule == nil {
+		schedule = &DefaultBotSchedule
+	}
 	return &BotScheduler{
-		db: db,
+		db:       db,
+		schedule: schedule,
 	}
 }
 
-// Start begins the scheduler
+// The scheduler posts in each community at most once in every scheduling
+// window (see BotSchedule). Its state is kept in the bot_schedule
+// table, so that it resumes where it left off after a restart, and so that
+// more than one scheduler (of more than one process) never posts in a
+// community in the same window: a community is claimed for a window before a
+// post is generated for it.
+//
+// If a scheduler stops while a community is claimed, the claim goes stale
+// (after botScheduleStaleAfter). A stale claim is then resolved by checking
+// whether a bot posted in the community since the claim: if so, the run is
+// taken to be done; otherwise the community is due again.
+
+const (
+	botScheduleInterval   = 24 * time.Minute // The default scheduling window.
+	botCommunityTimeout   = 5 * time.Minute  // Of posting in a community.
+	botScheduleStaleAfter = 2 * botCommunityTimeout
+)
+
+// Statuses of the communities in the bot_schedule table.
+const (
+	botSchedulePending = "pending"
+	botScheduleRunning = "running" // Claimed for the window ending at next_run_at.
+	botScheduleDone    = "done"
+	botScheduleFailed  = "failed" // Not retried until the next window.
+)
+
+// Start begins the scheduler: it runs the window it's started in, if any, and
+// then every window after, until ctx is done.
 func (s *BotScheduler) Start(ctx context.Context) {
 	go func() {
 		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				now := time.Now()
-				// Get current time in PST
-				loc, _ := time.LoadLocation("America/Los_Angeles")
-				pstTime := now.In(loc)
-				
-				// Check if current hour is between 9am and 9pm PST
-				if pstTime.Hour() >= 9 && pstTime.Hour() < 23 {
-					// Get all communities
-					communities, err := GetAllCommunities(ctx, s.db)
-					if err != nil {
-						log.Printf("Error getting communities: %v", err)
-						time.Sleep(time.Hour)
-						continue
-					}
-
-					// Split communities into 12 batches (one for each hour)
-					batchSize := len(communities) / 12
-					if batchSize == 0 {
-						batchSize = 1
-					}
-
-					// Shuffle communities to randomize the batches
-					rand.Shuffle(len(communities), func(i, j int) {
-						communities[i], communities[j] = communities[j], communities[i]
-					})
-
-					// Process each batch with a delay
-					for i := 0; i < len(communities); i += batchSize {
-						end := i + batchSize
-						if end > len(communities) {
-							end = len(communities)
-						}
-						batch := communities[i:end]
-
-						// Create a batch-specific context
-						batchCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
-						
-						// Process the batch
-						for _, community := range batch {
-							if err := s.generatePostForCommunity(batchCtx, community); err != nil {
-								log.Printf("Error generating post for community %s: %v", community.Name, err)
-							}
-						}
-						cancel()
-
-						// Wait for a random time between 1-5 minutes before next batch
-						if end < len(communities) {
-							waitTime := time.Duration(1+rand.Intn(5)) * time.Minute
-							time.Sleep(waitTime)
-						}
-					}
-
-					// Wait until the next interval (25 minutes)
-					nextRun := now.Truncate(24 * time.Minute).Add(24 * time.Minute)
-					time.Sleep(time.Until(nextRun))
-				} else {
-					// If outside the time window, sleep until 9am PST
-					loc, _ := time.LoadLocation("America/Los_Angeles")
-					now := time.Now().In(loc)
-					nextRun := time.Date(now.Year(), now.Month(), now.Day(), 9, 0, 0, 0, loc)
-					if now.Hour() >= 21 {
-						nextRun = nextRun.Add(24 * time.Hour)
-					}
-					time.Sleep(time.Until(nextRun))
+			if window, ok := s.schedule.window(now()); ok {
+				if err := s.runWindow(ctx, window); err != nil {
+					logging.FromContext(ctx).Error("Error running bot scheduler", "window", window, "error", err)
 				}
 			}
+			// Wait until the next window
+			if err := sleep(ctx, s.schedule.next(now()).Sub(now())); err != nil {
+				return
+			}
 		}
 	}()
 }
 
+// runWindow generates posts for the communities that are due in the
+// scheduling window starting at window, in batches.
+func (s *BotScheduler) runWindow(ctx context.Context, window time.Time) error {
+	if err := s.syncSchedule(ctx); err != nil {
+		return err
+	}
+	if err := s.resolveStaleClaims(ctx); err != nil {
+		return err
+	}
+	communities, err := s.dueCommunities(ctx, window)
+	if err != nil {
+		return err
+	}
+
+	// Split communities into batches
+	batchSize := len(communities) / s.schedule.Batches
+	if batchSize == 0 {
+		batchSize = 1
+	}
+
+	// Shuffle communities to randomize the batches
+	random().Shuffle(len(communities), func(i, j int) {
+		communities[i], communities[j] = communities[j], communities[i]
+	})
+
+	// Process each batch with a delay
+	for i := 0; i < len(communities); i += batchSize {
+		if ctx.Err() != nil {
+			return nil
+		}
+		end := i + batchSize
+		if end > len(communities) {
+			end = len(communities)
+		}
+		batch := communities[i:end]
+		if BotsHalted() {
+			return nil
+		}
+
+		// Process the batch
+		start, result := time.Now(), "ok"
+		if err := s.runBatch(ctx, window, batch); err != nil {
+			result = "error"
+			logging.FromContext(ctx).Error("Errors in bot batch", "communities", len(batch), "error", err)
+		}
+		botBatchDurations.ObserveSince(start, result)
+
+		// Wait for a random time between 1-5 minutes before next batch
+		if end < len(communities) {
+			waitTime := time.Duration(1+random().Intn(5)) * time.Minute
+			if err := sleep(ctx, waitTime); err != nil {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// runBatch posts in the communities of batch, in up to s.schedule.Concurrency
+// of them at a time, and returns the errors of those in which it failed,
+// joined. A panic while posting in a community is returned as its error; the
+// claim of the community is left to go stale (see resolveStaleClaims).
+func (s *BotScheduler) runBatch(ctx context.Context, window time.Time, batch []*Community) error {
+	return runBounded(ctx, s.schedule.Concurrency, len(batch), func(i int) error {
+		if BotsHalted() {
+			return nil
+		}
+		if err := s.runCommunity(ctx, window, batch[i]); err != nil {
+			return fmt.Errorf("community %s: %w", batch[i].Name, err)
+		}
+		return nil
+	})
+}
+
+// runCommunity posts in community, if it's due in the scheduling window
+// starting at window, and not claimed by another scheduler, giving up after
+// botCommunityTimeout.
+func (s *BotScheduler) runCommunity(ctx context.Context, window time.Time, community *Community) error {
+	ctx, cancel := context.WithTimeout(ctx, botCommunityTimeout)
+	defer cancel()
+
+	settings, err := GetCommunityBotSettings(ctx, s.db, community.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get bot settings: %w", err)
+	}
+	claimed, err := s.claim(ctx, community.ID, window, settings.postInterval())
+	if err != nil {
+		return fmt.Errorf("failed to claim for bot posting: %w", err)
+	}
+	if !claimed {
+		return nil // By another scheduler.
+	}
+
+	runErr := s.generatePostForCommunity(ctx, community, settings)
+	if errors.Is(runErr, ErrBotBudgetExceeded) || errors.Is(runErr, ErrBotsHalted) {
+		logging.FromContext(ctx).Warn("Skipped bot post", "community", community.Name, "reason", runErr)
+	} else if runErr != nil {
+		runErr = fmt.Errorf("failed to generate post: %w", runErr)
+	}
+	if err := s.finish(context.WithoutCancel(ctx), community.ID, runErr == nil); err != nil {
+		return errors.Join(runErr, fmt.Errorf("failed to record bot run: %w", err))
+	}
+	if errors.Is(runErr, ErrBotBudgetExceeded) || errors.Is(runErr, ErrBotsHalted) {
+		return nil
+	}
+	return runErr
+}
+
+// syncSchedule adds the communities that are not in the bot_schedule table
+// yet to it.
+func (s *BotScheduler) syncSchedule(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT IGNORE INTO bot_schedule (community_id, status)
+		SELECT id, ? FROM communities WHERE deleted_at IS NULL`, botSchedulePending)
+	return err
+}
+
+// resolveStaleClaims resolves the claims of schedulers that stopped before
+// finishing their runs.
+func (s *BotScheduler) resolveStaleClaims(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT community_id, last_run_at FROM bot_schedule WHERE status = ? AND last_run_at < ?",
+		botScheduleRunning, now().Add(-botScheduleStaleAfter))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type claim struct {
+		community uid.ID
+		at        time.Time
+	}
+	var claims []claim
+	for rows.Next() {
+		var c claim
+		if err := rows.Scan(&c.community, &c.at); err != nil {
+			return err
+		}
+		claims = append(claims, c)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, c := range claims {
+		var posted bool
+		if err := s.db.QueryRowContext(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM posts INNER JOIN users ON users.id = posts.user_id
+				WHERE posts.community_id = ? AND posts.created_at >= ? AND users.is_bot = TRUE
+			)`, c.community, c.at).Scan(&posted); err != nil {
+			return err
+		}
+		query := "UPDATE bot_schedule SET status = ? WHERE community_id = ? AND status = ? AND last_run_at = ?"
+		args := []any{botScheduleDone, c.community, botScheduleRunning, c.at}
+		if !posted {
+			query = "UPDATE bot_schedule SET status = ?, next_run_at = NULL WHERE community_id = ? AND status = ? AND last_run_at = ?"
+			args[0] = botSchedulePending
+		}
+		if _, err := s.db.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dueCommunities returns the communities that are due in the scheduling
+// window starting at window, leaving out those in which bots are turned off
+// and those that are frozen.
+func (s *BotScheduler) dueCommunities(ctx context.Context, window time.Time) ([]*Community, error) {
+	communities, err := GetAllCommunities(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+	disabled, err := disabledBotCommunities(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+	frozen, err := frozenCommunities(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT community_id FROM bot_schedule WHERE next_run_at IS NULL OR next_run_at <= ?", window)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	due := make(map[uid.ID]bool)
+	for rows.Next() {
+		var id uid.ID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		due[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var res []*Community
+	for _, c := range communities {
+		if due[c.ID] && !disabled[c.ID] && !frozen[c.ID] {
+			res = append(res, c)
+		}
+	}
+	return res, nil
+}
+
+// claim claims community for the scheduling window starting at window, after
+// which the community is next due in interval. It returns false if the
+// community is not due in the window (if it's already claimed, say).
+func (s *BotScheduler) claim(ctx context.Context, community uid.ID, window time.Time, interval time.Duration) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE bot_schedule SET status = ?, last_run_at = ?, next_run_at = ?
+		WHERE community_id = ? AND (next_run_at IS NULL OR next_run_at <= ?)`,
+		botScheduleRunning, now(), window.Add(interval), community, window)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// finish records the end of the run of community.
+func (s *BotScheduler) finish(ctx context.Context, community uid.ID, ok bool) error {
+	status := botScheduleDone
+	if !ok {
+		status = botScheduleFailed
+	}
+	_, err := s.db.ExecContext(ctx, "UPDATE bot_schedule SET status = ? WHERE community_id = ? AND status = ?", status, community, botScheduleRunning)
+	return err
+}
+
 // // Different trolling styles for the bot to use
 // var trollingStyles = []string{
 // 	// Style 1: Conspiracy theorist
@@ -127,180 +340,84 @@ func (s *BotScheduler) Start(ctx context.Context) {
 // 	"You disagree with everything just for the sake of being different. You use heavy sarcasm and mock others' opinions while offering no constructive alternatives. ",
 // }
 
-var trollingStyles = []string{
-	"Use short punchy sentences. Post length should be around 10 words.",
-	"You get your point across with examples. Post length should be max 20 words.",
-	"You get your point across with statistics. Post length should be max 30 words .",
-	"You get your point across through anecdotes. Post length should be max 50 words.",
-	"Format the post using Markdown. You must include a list. Post length should be max 30 words.",
-	"You must use one emoji. Post length should be max 20 words.",
-	"You must bold or italicize one word in Markdown. Post length should be max 20 words.",
+// trollingStyles are the styles bots write in, which communities may limit
+// (see CommunityBotSettings).
+var trollingStyles = []botTrollingStyle{
+	{"short", "Use short punchy sentences. Post length should be around 10 words."},
+	{"examples", "You get your point across with examples. Post length should be max 20 words."},
+	{"statistics", "You get your point across with statistics. Post length should be max 30 words ."},
+	{"anecdotes", "You get your point across through anecdotes. Post length should be max 50 words."},
+	{"list", "Format the post using Markdown. You must include a list. Post length should be max 30 words."},
+	{"emoji", "You must use one emoji. Post length should be max 20 words."},
+	{"emphasis", "You must bold or italicize one word in Markdown. Post length should be max 20 words."},
 }
 
 // generatePostForCommunity generates a post for a single community
-func (s *BotScheduler) generatePostForCommunity(ctx context.Context, community *Community) error {
-	// Skip if community is cs278
-	if community.Name == "cs278" {
-		return nil
-	}
-
+func (s *BotScheduler) generatePostForCommunity(ctx context.Context, community *Community, settings *CommunityBotSettings) error {
 	// Get a random bot user
 	bot, err := GetRandomBotUser(ctx, s.db)
 	if err != nil {
 		return fmt.Errorf("failed to get random bot user: %w", err)
 	}
-
-	// Fetch community rules
-	if err := community.FetchRules(ctx, s.db); err != nil {
-		return fmt.Errorf("failed to fetch community rules: %w", err)
-	}
-
-	// Get recent posts for context
-	recentPosts, err := GetRecentPosts(ctx, s.db, community.ID)
+	persona, err := GetBotUserPersona(ctx, s.db, bot.ID)
 	if err != nil {
-		return fmt.Errorf("failed to get recent posts: %w", err)
+		return fmt.Errorf("failed to get bot persona: %w", err)
 	}
 
-	// Format community rules with nil checks
-	var rulesText string
-	if len(community.Rules) > 0 {
-		rulesText = "Community Rules:\n"
-		for _, rule := range community.Rules {
-			if rule == nil {
-				continue
-			}
-			rulesText += fmt.Sprintf("- %s", rule.Rule)
-			if rule.Description.Valid && rule.Description.String != "" {
-				rulesText += fmt.Sprintf(": %s", rule.Description.String)
-			}
-			rulesText += "\n"
-		}
-	}
-
-	// Format recent posts as context with nil checks
-	var recentPostsText string
-	if len(recentPosts) > 0 {
-		recentPostsText = "Recent Posts in this Community:\n"
-		for i, p := range recentPosts {
-			if p == nil {
-				continue
-			}
-			title := p.Title
-			body := ""
-			if p.Body.Valid {
-				body = p.Body.String
-			}
-			recentPostsText += fmt.Sprintf("%d. %s\n   %s\n\n", i+1, title, body)
-		}
+	// Gather the community's rules and recent posts for context
+	data, err := newBotPromptData(ctx, s.db, community)
+	if err != nil {
+		return err
 	}
 
 	// Evaluate community toxicity
-	toxicityPrompt := fmt.Sprintf("Give this community a toxicity score out of 5. If there are no rules or no recent posts, give it a score above 1. Your response should be exactly one number.\n1: Community rules are clear and extensive AND discourse is respectful and content is meaningful and high-quality\n5: No rules or unclear rules OR discourse is rude and low-quality\n\n%s\n\n%s",
-		rulesText,
-		recentPostsText)
-
-	toxicityResponse, err := GenerateBotResponse(ctx, toxicityPrompt, "")
+	toxicityScore, err := data.evalToxicity(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to evaluate toxicity: %w", err)
-	}
-
-	// Parse toxicity score
-	toxicityScore := 0
-	if _, err := fmt.Sscanf(toxicityResponse, "%d", &toxicityScore); err != nil {
-		return fmt.Errorf("failed to parse toxicity score: %w", err)
+		return err
 	}
 
 	// Skip if community is very high quality (toxicity score 1)
 	if toxicityScore == 1 {
 		return nil
 	}
-
-	// Prepare community information with fallbacks
-	communityAbout := ""
-	if community.About.Valid {
-		communityAbout = community.About.String
-	}
+	data.Toxicity = settings.clampToxicity(toxicityScore)
 
 	// Select a random trolling style
-	trollingStyle := trollingStyles[rand.Intn(len(trollingStyles))]
+	data.Style = settings.pickTrollingStyle()
 
 	// Generate a new post
-	postPrompt := fmt.Sprintf(`Toxicity Score: %d
-Community: %s
-Description: %s
-%s
-%s
-Generate a short, low-quality post that disrupts the community without directly breaking its rules. Posts should mimic the style of recent content, but stretch what's socially acceptable.
-Adjust tone based on the current toxicity score (1–5), using the descriptions below. Select a tone primarily based on that score, but occasionally sample from neighboring scores to reflect realistic variation. For example, if the score is 4, there's a high chance of using a score 4 tone, but a smaller chance of using tone 3, 5, 2, or even 1.  
-
-Score 1 = friendly confusion, awkward newb, or naive derailment  
-Score 2 = clumsy pushback, off-topic takes, unserious vibes  
-Score 3 = blunt, dismissive, casually wrong or mid  
-Score 4 = mocking, rude, confidently wrong, or edgy  
-Score 5 = aggressive, baiting, chaotic, or troll-like  
-
-Be original. Don't repeat points. No hashtags or proper punctuation. No questions.  
-Avoid: "wannabe, just saying, let's be real, delusional, real ___, truth, loser, overrated, wake up people, joke, sheep, drinking the kool-aid."  
-%s
-
-Use all lowercase.  
-Format: Format your response exactly like this:
-TITLE: [title]
-
-BODY: [post content]`,
-		toxicityScore,
-		community.Name,
-		communityAbout,
-		rulesText,
-		recentPostsText,
-		trollingStyle)
-
-	postResponse, err := GenerateBotResponse(ctx, postPrompt, "")
+	postPrompt, err := renderBotPrompt(botPostPrompt, data)
 	if err != nil {
-		return fmt.Errorf("failed to generate bot post: %w", err)
-	}
-
-	// Parse the response to extract title and body
-	var title, body string
-	lines := strings.Split(postResponse, "\n")
-	for i, line := range lines {
-		if strings.HasPrefix(strings.ToUpper(line), "TITLE:") {
-			title = strings.TrimSpace(strings.TrimPrefix(line, "TITLE:"))
-			// Look for body in subsequent lines
-			for j := i + 1; j < len(lines); j++ {
-				if strings.HasPrefix(strings.ToUpper(lines[j]), "BODY:") {
-					body = strings.TrimSpace(strings.TrimPrefix(lines[j], "BODY:"))
-					// Add any remaining lines to the body
-					if j+1 < len(lines) {
-						body += "\n" + strings.TrimSpace(strings.Join(lines[j+1:], "\n"))
-					}
-					break
-				}
-			}
-			break
-		}
+		return err
 	}
 
-	// Validate title and body
-	if title == "" || body == "" {
-		return fmt.Errorf("invalid bot response format: missing title or body")
+	written, err := generateBotPost(ctx, s.db, community.ID, postPrompt, persona)
+	if err == errBotPostTooSimilar {
+		return nil // Skipped.
 	}
-	if len(title) > 100 {
-		title = title[:100]
-	}
-
-	// Create a new post in the community
-	newPost, err := CreateTextPost(ctx, s.db, bot.ID, community.ID, title, body)
 	if err != nil {
-		return fmt.Errorf("failed to create post: %w", err)
+		return err
 	}
 
-	// Add an upvote to the new post
-	if err := newPost.Vote(ctx, s.db, bot.ID, true); err != nil {
-		return fmt.Errorf("failed to upvote bot post: %w", err)
+	// Create a new post in the community, unless bots run dry
+	action := newBotAction(BotActionPost, settings, bot.ID, data.Toxicity)
+	var made *uid.ID
+	if !action.DryRun {
+		newPost, err := Posts().Create(ctx, s.db, &NewPost{
+			Author:    bot.ID,
+			Community: community.ID,
+			Title:     written.Title,
+			Content:   &TextContent{Body: written.Body},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create post: %w", err)
+		}
+		made = &newPost.ID
 	}
-
-	return nil
+	if err := action.record(ctx, s.db, postPrompt, written.Response, made); err != nil {
+		return err
+	}
+	return action.saveEmbedding(ctx, s.db, written)
 }
 
 // GetAllCommunities retrieves all communities from the database
@@ -0,0 +1,204 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Moderators (and admins) may turn bots off in their communities, limit how
+// often bots post in them, limit the toxicity of the tone bots take, limit
+// the styles bots write in, and have bots run dry (see BotAction).
+// Communities without settings get the defaults (see
+// defaultCommunityBotSettings).
+
+const (
+	minBotToxicity = 1
+	maxBotToxicity = 5
+)
+
+type botTrollingStyle struct {
+	Name   string
+	Prompt string
+}
+
+// BotTrollingStyles returns the names of the styles bots write in.
+func BotTrollingStyles() []string {
+	names := make([]string, len(trollingStyles))
+	for i, style := range trollingStyles {
+		names[i] = style.Name
+	}
+	return names
+}
+
+// CommunityBotSettings are the settings of bots in a community.
+type CommunityBotSettings struct {
+	CommunityID uid.ID `json:"communityId"`
+	Enabled     bool   `json:"enabled"`
+
+	// How many posts bots make in the community in a day, at most. If 0, bots
+	// post once every scheduling window (see botScheduleInterval).
+	PostsPerDay int `json:"postsPerDay"`
+
+	// The range the toxicity score of the community is clamped to.
+	MinToxicity int `json:"minToxicity"`
+	MaxToxicity int `json:"maxToxicity"`
+
+	// How much lower than MaxToxicity the toxicity ceiling is, for the posts
+	// and comments of bots reported in the community (see
+	// communityToxicityPenalty). It's never lower than MinToxicity.
+	ToxicityPenalty int `json:"toxicityPenalty"`
+
+	// Names of the allowed trolling styles (see BotTrollingStyles). If
+	// empty, all of them are allowed.
+	TrollingStyles []string `json:"trollingStyles"`
+
+	// If true, bots only record what they would write (see BotAction).
+	DryRun bool `json:"dryRun"`
+
+	UpdatedBy uid.NullID    `json:"updatedBy"`
+	UpdatedAt msql.NullTime `json:"updatedAt"` // Null if the defaults are in effect.
+}
+
+func defaultCommunityBotSettings(community uid.ID) *CommunityBotSettings {
+	return &CommunityBotSettings{
+		CommunityID:    community,
+		Enabled:        true,
+		MinToxicity:    minBotToxicity,
+		MaxToxicity:    maxBotToxicity,
+		TrollingStyles: []string{},
+	}
+}
+
+// GetCommunityBotSettings returns the bot settings of community.
+func GetCommunityBotSettings(ctx context.Context, db *sql.DB, community uid.ID) (*CommunityBotSettings, error) {
+	s := defaultCommunityBotSettings(community)
+	var styles []byte
+	row := db.QueryRowContext(ctx, `
+		SELECT enabled, posts_per_day, min_toxicity, max_toxicity, trolling_styles, dry_run, updated_by, updated_at
+		FROM community_bot_settings WHERE community_id = ?`, community)
+	err := row.Scan(&s.Enabled, &s.PostsPerDay, &s.MinToxicity, &s.MaxToxicity, &styles, &s.DryRun, &s.UpdatedBy, &s.UpdatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if styles != nil {
+		if err := json.Unmarshal(styles, &s.TrollingStyles); err != nil {
+			return nil, err
+		}
+	}
+	if s.ToxicityPenalty, err = communityToxicityPenalty(ctx, db, community); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// disabledBotCommunities returns the communities in which bots are turned
+// off.
+func disabledBotCommunities(ctx context.Context, db *sql.DB) (map[uid.ID]bool, error) {
+	rows, err := db.QueryContext(ctx, "SELECT community_id FROM community_bot_settings WHERE enabled = FALSE")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	disabled := make(map[uid.ID]bool)
+	for rows.Next() {
+		var id uid.ID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		disabled[id] = true
+	}
+	return disabled, rows.Err()
+}
+
+// validate checks s, and removes duplicate trolling styles.
+func (s *CommunityBotSettings) validate() error {
+	maxPostsPerDay := int(24 * time.Hour / botScheduleInterval)
+	if s.PostsPerDay < 0 || s.PostsPerDay > maxPostsPerDay {
+		return httperr.NewBadRequest("invalid_posts_per_day", fmt.Sprintf("Posts per day must be between 0 and %d.", maxPostsPerDay))
+	}
+	if s.MinToxicity < minBotToxicity || s.MaxToxicity > maxBotToxicity || s.MinToxicity > s.MaxToxicity {
+		return httperr.NewBadRequest("invalid_toxicity_range", fmt.Sprintf("The toxicity range must be within %d and %d.", minBotToxicity, maxBotToxicity))
+	}
+	names := BotTrollingStyles()
+	styles := []string{}
+	for _, style := range s.TrollingStyles {
+		if !slices.Contains(names, style) {
+			return httperr.NewBadRequest("invalid_trolling_style", fmt.Sprintf("Unknown trolling style: %s.", style))
+		}
+		if !slices.Contains(styles, style) {
+			styles = append(styles, style)
+		}
+	}
+	s.TrollingStyles = styles
+	return nil
+}
+
+// UpdateCommunityBotSettings sets the bot settings of community to s (of which
+// the community and the fields set by the site are ignored), on behalf of mod,
+// who must be a moderator of community or an admin.
+func UpdateCommunityBotSettings(ctx context.Context, db *sql.DB, community, mod uid.ID, s *CommunityBotSettings) (*CommunityBotSettings, error) {
+	if is, err := UserModOrAdmin(ctx, db, community, mod); err != nil {
+		return nil, err
+	} else if !is {
+		return nil, errNotMod
+	}
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+
+	var styles []byte
+	if len(s.TrollingStyles) > 0 {
+		var err error
+		if styles, err = json.Marshal(s.TrollingStyles); err != nil {
+			return nil, err
+		}
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO community_bot_settings (community_id, enabled, posts_per_day, min_toxicity, max_toxicity, trolling_styles, dry_run, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE enabled = VALUES(enabled), posts_per_day = VALUES(posts_per_day), min_toxicity = VALUES(min_toxicity),
+			max_toxicity = VALUES(max_toxicity), trolling_styles = VALUES(trolling_styles), dry_run = VALUES(dry_run), updated_by = VALUES(updated_by), updated_at = VALUES(updated_at)`,
+		community, s.Enabled, s.PostsPerDay, s.MinToxicity, s.MaxToxicity, styles, s.DryRun, mod, now())
+	if err != nil {
+		return nil, err
+	}
+	return GetCommunityBotSettings(ctx, db, community)
+}
+
+// postInterval returns the least time between two bot posts in the
+// community.
+func (s *CommunityBotSettings) postInterval() time.Duration {
+	if s.PostsPerDay <= 0 {
+		return botScheduleInterval
+	}
+	return max(24*time.Hour/time.Duration(s.PostsPerDay), botScheduleInterval)
+}
+
+// clampToxicity clamps score to the toxicity range of s, lowered by the
+// toxicity penalty of s.
+func (s *CommunityBotSettings) clampToxicity(score int) int {
+	return min(max(score, s.MinToxicity), max(s.MaxToxicity-s.ToxicityPenalty, s.MinToxicity))
+}
+
+// pickTrollingStyle returns the prompt of a random allowed trolling style.
+func (s *CommunityBotSettings) pickTrollingStyle() string {
+	var allowed []botTrollingStyle
+	for _, style := range trollingStyles {
+		if len(s.TrollingStyles) == 0 || slices.Contains(s.TrollingStyles, style.Name) {
+			allowed = append(allowed, style)
+		}
+	}
+	if len(allowed) == 0 {
+		allowed = trollingStyles // The allowed styles have since been removed.
+	}
+	return allowed[random().Intn(len(allowed))].Prompt
+}
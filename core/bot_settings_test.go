@@ -0,0 +1,68 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCommunityBotSettingsValidate(t *testing.T) {
+	tests := []struct {
+		settings CommunityBotSettings
+		valid    bool
+	}{
+		{CommunityBotSettings{MinToxicity: 1, MaxToxicity: 5}, true},
+		{CommunityBotSettings{MinToxicity: 2, MaxToxicity: 2, PostsPerDay: 60}, true},
+		{CommunityBotSettings{MinToxicity: 3, MaxToxicity: 2}, false},
+		{CommunityBotSettings{MinToxicity: 0, MaxToxicity: 2}, false},
+		{CommunityBotSettings{MinToxicity: 1, MaxToxicity: 6}, false},
+		{CommunityBotSettings{MinToxicity: 1, MaxToxicity: 5, PostsPerDay: 61}, false},
+		{CommunityBotSettings{MinToxicity: 1, MaxToxicity: 5, TrollingStyles: []string{"emoji", "emoji"}}, true},
+		{CommunityBotSettings{MinToxicity: 1, MaxToxicity: 5, TrollingStyles: []string{"rickroll"}}, false},
+	}
+	for _, test := range tests {
+		s := test.settings
+		if err := s.validate(); (err == nil) != test.valid {
+			t.Errorf("validate(%+v) = %v, want valid: %v", test.settings, err, test.valid)
+		}
+	}
+
+	s := &CommunityBotSettings{MinToxicity: 1, MaxToxicity: 5, TrollingStyles: []string{"emoji", "list", "emoji"}}
+	if err := s.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.TrollingStyles) != 2 {
+		t.Errorf("validate did not remove the duplicate trolling style: %v", s.TrollingStyles)
+	}
+}
+
+func TestCommunityBotSettingsLimits(t *testing.T) {
+	s := &CommunityBotSettings{MinToxicity: 2, MaxToxicity: 3, PostsPerDay: 4, TrollingStyles: []string{"emoji"}}
+	for score, want := range map[int]int{1: 2, 2: 2, 3: 3, 5: 3} {
+		if got := s.clampToxicity(score); got != want {
+			t.Errorf("clampToxicity(%d) = %d, want %d", score, got, want)
+		}
+	}
+	if got := s.postInterval(); got != 6*time.Hour {
+		t.Errorf("postInterval() = %v, want 6h", got)
+	}
+	if got := s.pickTrollingStyle(); got != trollingStyles[5].Prompt {
+		t.Errorf("pickTrollingStyle() = %q, want the emoji style", got)
+	}
+	s.PostsPerDay = 0
+	if got := s.postInterval(); got != botScheduleInterval {
+		t.Errorf("postInterval() = %v, want %v", got, botScheduleInterval)
+	}
+}
+
+func TestCommunityBotSettingsToxicityPenalty(t *testing.T) {
+	s := &CommunityBotSettings{MinToxicity: 1, MaxToxicity: 4, ToxicityPenalty: 2}
+	for score, want := range map[int]int{0: 1, 2: 2, 3: 2, 4: 2} {
+		if got := s.clampToxicity(score); got != want {
+			t.Errorf("clampToxicity(%d) = %d, want %d", score, got, want)
+		}
+	}
+	s.ToxicityPenalty = 10 // The ceiling is never lowered below MinToxicity.
+	if got := s.clampToxicity(4); got != 1 {
+		t.Errorf("clampToxicity(4) = %d, want 1", got)
+	}
+}
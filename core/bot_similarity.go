@@ -0,0 +1,228 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/llm"
+	"github.com/discuitnet/discuit/internal/logging"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Bots, given much the same prompt, sometimes write much the same post
+// twice. If an embedder is set (see SetBotSimilarity), the posts bots write
+// are embedded, and their embeddings stored (in the bot_action_embeddings
+// table), and a post whose embedding is too similar to that of a post bots
+// wrote in the same community in the last botSimilarityWindow is written
+// again, up to Retries times, and then skipped.
+
+const (
+	botSimilarityWindow = 7 * 24 * time.Hour
+
+	// The number of recent posts of bots in a community that a post is
+	// compared with, at most.
+	maxBotSimilarityPosts = 200
+)
+
+// errBotPostTooSimilar is returned by generateBotPost if bots wrote a post
+// too similar to a recent post in every try.
+var errBotPostTooSimilar = errors.New("bot post too similar to a recent post")
+
+// BotSimilarity is how the posts of bots are checked for similarity to their
+// recent posts.
+type BotSimilarity struct {
+	Embedder  llm.Embedder // If nil, posts are not checked.
+	Model     string       // Of Embedder.
+	Threshold float64      // Posts this similar (in cosine similarity) or more are too similar.
+	Retries   int          // Of posts that are too similar.
+}
+
+var (
+	botSimilarityMu sync.RWMutex // guards botSimilarity
+	botSimilarity   BotSimilarity
+)
+
+// SetBotSimilarity sets how the posts of bots are checked for similarity to
+// their recent posts. By default, they're not.
+func SetBotSimilarity(s BotSimilarity) {
+	botSimilarityMu.Lock()
+	defer botSimilarityMu.Unlock()
+	botSimilarity = s
+}
+
+func getBotSimilarity() BotSimilarity {
+	botSimilarityMu.RLock()
+	defer botSimilarityMu.RUnlock()
+	return botSimilarity
+}
+
+// botPost is a post written by a bot.
+type botPost struct {
+	Title, Body string
+	Response    string    // Of the model.
+	Embedding   []float32 // Nil if posts are not checked for similarity.
+	Model       string    // Of Embedding.
+}
+
+// parseBotPost parses the title and body of a post out of the response of a
+// bot.
+func parseBotPost(response string) (title, body string, err error) {
+	lines := strings.Split(response, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(strings.ToUpper(line), "TITLE:") {
+			title = strings.TrimSpace(strings.TrimPrefix(line, "TITLE:"))
+			// Look for body in subsequent lines
+			for j := i + 1; j < len(lines); j++ {
+				if strings.HasPrefix(strings.ToUpper(lines[j]), "BODY:") {
+					body = strings.TrimSpace(strings.TrimPrefix(lines[j], "BODY:"))
+					// Add any remaining lines to the body
+					if j+1 < len(lines) {
+						body += "\n" + strings.TrimSpace(strings.Join(lines[j+1:], "\n"))
+					}
+					break
+				}
+			}
+			break
+		}
+	}
+	if title == "" || body == "" {
+		return "", "", fmt.Errorf("invalid bot response format: missing title or body")
+	}
+	if len(title) > 100 {
+		title = title[:100]
+	}
+	return title, body, nil
+}
+
+// generateBotPost has a bot of persona write a post for community, with
+// prompt, writing it again if it's too similar to a recent post of bots in
+// community (see SetBotSimilarity). It fails with errBotPostTooSimilar if
+// every try was too similar.
+func generateBotPost(ctx context.Context, db *sql.DB, community uid.ID, prompt string, persona *BotPersona) (*botPost, error) {
+	s := getBotSimilarity()
+	var recent [][]float32 // Fetched on the first try.
+	for try := 0; ; try++ {
+		response, err := GenerateBotResponse(ctx, prompt, persona)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate bot post: %w", err)
+		}
+		title, body, err := parseBotPost(response)
+		if err != nil {
+			return nil, err
+		}
+		post := &botPost{Title: title, Body: body, Response: response, Model: s.Model}
+		if s.Embedder == nil {
+			return post, nil
+		}
+
+		if post.Embedding, err = embedBotText(ctx, s, title+"\n\n"+body); err != nil {
+			return nil, err
+		}
+		if recent == nil {
+			if recent, err = recentBotEmbeddings(ctx, db, community, s.Model); err != nil {
+				return nil, err
+			}
+		}
+		similarity := maxCosine(post.Embedding, recent)
+		if similarity < s.Threshold {
+			return post, nil
+		}
+		logging.FromContext(ctx).Info("Bot post is too similar to a recent post", "title", title, "community", community, "similarity", similarity, "try", try+1)
+		if try >= s.Retries {
+			return nil, errBotPostTooSimilar
+		}
+	}
+}
+
+// embedBotText returns the embedding of text, with the model of s. Its
+// tokens count against the bot budget, as input tokens.
+func embedBotText(ctx context.Context, s BotSimilarity, text string) ([]float32, error) {
+	if err := defaultBotBudget.reserve(now()); err != nil {
+		return nil, err
+	}
+	res, err := s.Embedder.Embed(ctx, s.Model, []string{text})
+	if res != nil {
+		defaultBotBudget.record(now(), res.PromptTokens, 0)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed bot post: %w", err)
+	}
+	return res.Vectors[0], nil
+}
+
+// maxCosine returns the highest cosine similarity of v to any of vs, or 0 if
+// vs is empty.
+func maxCosine(v []float32, vs [][]float32) float64 {
+	best := 0.0
+	for _, u := range vs {
+		best = math.Max(best, llm.Cosine(v, u))
+	}
+	return best
+}
+
+// recentBotEmbeddings returns the embeddings, by model, of the posts bots
+// wrote in community in the last botSimilarityWindow, newest first. It never
+// returns nil on success.
+func recentBotEmbeddings(ctx context.Context, db *sql.DB, community uid.ID, model string) ([][]float32, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT vector FROM bot_action_embeddings
+		WHERE community_id = ? AND model = ? AND created_at > ?
+		ORDER BY created_at DESC LIMIT ?`, community, model, now().Add(-botSimilarityWindow), maxBotSimilarityPosts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	vectors := [][]float32{}
+	for rows.Next() {
+		var b []byte
+		if err := rows.Scan(&b); err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, decodeVector(b))
+	}
+	return vectors, rows.Err()
+}
+
+// saveEmbedding saves the embedding of the post of a, if it has one.
+func (a *BotAction) saveEmbedding(ctx context.Context, db *sql.DB, post *botPost) error {
+	if post.Embedding == nil {
+		return nil
+	}
+	_, err := db.ExecContext(ctx, "INSERT INTO bot_action_embeddings (bot_action_id, community_id, model, vector, created_at) VALUES (?, ?, ?, ?, ?)",
+		a.ID, a.CommunityID, post.Model, encodeVector(post.Embedding), a.CreatedAt)
+	return err
+}
+
+// PurgeBotEmbeddings deletes the embeddings of the posts of bots that are too
+// old to be compared with, and returns the number deleted.
+func PurgeBotEmbeddings(ctx context.Context, db *sql.DB) (int, error) {
+	res, err := db.ExecContext(ctx, "DELETE FROM bot_action_embeddings WHERE created_at < ?", now().Add(-botSimilarityWindow))
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func encodeVector(v []float32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(x))
+	}
+	return b
+}
+
+func decodeVector(b []byte) []float32 {
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v
+}
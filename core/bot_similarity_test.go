@@ -0,0 +1,86 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/llm"
+)
+
+func TestParseBotPost(t *testing.T) {
+	title, body, err := parseBotPost("TITLE: a title\n\nBODY: a body\nmore body")
+	if err != nil || title != "a title" || body != "a body\nmore body" {
+		t.Errorf("parseBotPost = %q, %q, %v", title, body, err)
+	}
+	if _, _, err := parseBotPost("just a title"); err == nil {
+		t.Error("no error for a response without a title and body")
+	}
+	if title, _, _ := parseBotPost("TITLE: " + strings.Repeat("a", 150) + "\nBODY: b"); len(title) != 100 {
+		t.Errorf("title of %d characters, want it cut to 100", len(title))
+	}
+}
+
+func TestEncodeVector(t *testing.T) {
+	v := []float32{1, -0.5, 3.25, 0}
+	got := decodeVector(encodeVector(v))
+	if len(got) != len(v) {
+		t.Fatalf("decoded %d floats, want %d", len(got), len(v))
+	}
+	for i := range v {
+		if got[i] != v[i] {
+			t.Errorf("float %d = %v, want %v", i, got[i], v[i])
+		}
+	}
+	if sim := maxCosine(v, [][]float32{{0, 1, 0, 0}, v}); sim < 0.999 {
+		t.Errorf("maxCosine = %v, want 1", sim)
+	}
+}
+
+func TestBotPostSimilarity(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+	t.Cleanup(func() { SetBotSimilarity(BotSimilarity{}) })
+	SetBotSimilarity(BotSimilarity{Embedder: &llm.Local{}, Model: "local", Threshold: 0.9, Retries: 1})
+
+	human := h.newUser(db, "human", false)
+	post := h.newPost(db, human, "testing")
+	settings := defaultCommunityBotSettings(post.CommunityID)
+
+	responses := []string{
+		"TITLE: Parking fees\n\nBODY: The council raised parking fees again.",
+		"TITLE: Parking fees\n\nBODY: The council raised parking fees again!",
+		"TITLE: Tomatoes\n\nBODY: My tomatoes finally ripened.",
+	}
+	h.llm.Respond = func(req *llm.Request) (string, error) {
+		r := responses[0]
+		responses = responses[1:]
+		return r, nil
+	}
+	first, err := generateBotPost(h.ctx, db, post.CommunityID, "prompt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	action := newBotAction(BotActionPost, settings, human.ID, 0)
+	if err := action.record(h.ctx, db, "prompt", first.Response, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := action.saveEmbedding(h.ctx, db, first); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second response repeats the first, and is written again.
+	second, err := generateBotPost(h.ctx, db, post.CommunityID, "prompt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Title != "Tomatoes" {
+		t.Errorf("got post %q, want the one written again", second.Title)
+	}
+
+	// Every try repeats the first.
+	repeat := "TITLE: Parking fees\n\nBODY: The council raised parking fees again."
+	responses = []string{repeat, repeat}
+	if _, err := generateBotPost(h.ctx, db, post.CommunityID, "prompt", nil); err != errBotPostTooSimilar {
+		t.Errorf("got error %v, want errBotPostTooSimilar", err)
+	}
+}
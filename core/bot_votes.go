@@ -0,0 +1,316 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Besides upvoting what they post, bots vote on the recent posts of others,
+// humans and bots alike, to simulate the engagement of a busier site. In each
+// round (see SimulateBotVotes), random bots are drawn for random recent posts,
+// and each either upvotes the post, downvotes it, or passes, with odds that
+// depend on the toxicity of the community (bots are harsher in toxic
+// communities) and on the alignment of the personas of the bot and of the
+// author (bots back the bots of their persona, and go against those of other
+// personas). Votes are recorded in the bot_votes table, and are rate limited
+// per bot. Bots don't vote while voting is off (see SetBotVoting), while the
+// kill switch is on, or in communities they're turned off in; in dry runs,
+// votes are only recorded.
+
+const (
+	// Posts older than botVotePostAge are not voted on.
+	botVotePostAge = 24 * time.Hour
+
+	// The number of recent posts that the posts voted on in a round are drawn
+	// from, at most.
+	maxBotVoteCandidates = 500
+
+	// The window the toxicity of a community is averaged over, from the
+	// actions of bots in it.
+	botVoteToxicityWindow = 7 * 24 * time.Hour
+)
+
+// BotVoting is how bots vote on posts.
+type BotVoting struct {
+	Enabled bool `json:"enabled"`
+
+	// The number of votes bots cast in a round, at most.
+	VotesPerRound int `json:"votesPerRound"`
+
+	// The number of votes a bot casts in an hour, at most.
+	MaxVotesPerBotPerHour int `json:"maxVotesPerBotPerHour"`
+
+	// The chances of a bot upvoting and of downvoting a post, in a community
+	// of middling toxicity, by an author of no persona (see botVoteOdds).
+	UpvoteChance   float64 `json:"upvoteChance"`
+	DownvoteChance float64 `json:"downvoteChance"`
+}
+
+// Validate reports whether v is valid.
+func (v *BotVoting) Validate() error {
+	if v.VotesPerRound < 0 || v.MaxVotesPerBotPerHour < 0 {
+		return errors.New("bot vote limits cannot be negative")
+	}
+	if v.UpvoteChance < 0 || v.DownvoteChance < 0 || v.UpvoteChance+v.DownvoteChance > 1 {
+		return errors.New("bot vote chances must be between 0 and 1, and add up to at most 1")
+	}
+	return nil
+}
+
+var (
+	botVotingMu sync.RWMutex // guards botVoting
+	botVoting   BotVoting
+)
+
+// SetBotVoting sets how bots vote on posts. By default, they don't.
+func SetBotVoting(v BotVoting) {
+	botVotingMu.Lock()
+	defer botVotingMu.Unlock()
+	botVoting = v
+}
+
+// GetBotVoting returns how bots vote on posts.
+func GetBotVoting() BotVoting {
+	botVotingMu.RLock()
+	defer botVotingMu.RUnlock()
+	return botVoting
+}
+
+// botVoteAlignment returns the alignment of a bot of persona voter with the
+// author of a post, who's a bot of persona author if authorIsBot: 1 if they
+// share a persona, -1 if they have different personas, and 0 otherwise.
+func botVoteAlignment(voter, author *BotPersona, authorIsBot bool) int {
+	if !authorIsBot || voter == nil || author == nil {
+		return 0
+	}
+	if voter.ID == author.ID {
+		return 1
+	}
+	return -1
+}
+
+// botVoteOdds returns the chances of a bot upvoting and of downvoting a post
+// in a community of toxicity score toxicity, with alignment alignment (see
+// botVoteAlignment). The more toxic the community, the fewer the upvotes, and
+// the more the downvotes (up to three times as many as in the least toxic
+// communities). Aligned bots upvote twice as often, and never downvote;
+// opposed bots upvote half as often, and downvote twice as often.
+func botVoteOdds(v BotVoting, toxicity, alignment int) (up, down float64) {
+	t := float64(min(max(toxicity, minBotToxicity), maxBotToxicity)-minBotToxicity) / float64(maxBotToxicity-minBotToxicity)
+	up = v.UpvoteChance * (1.5 - t)
+	down = v.DownvoteChance * (1 + 2*t) / 2
+	switch {
+	case alignment > 0:
+		up, down = up*2, 0
+	case alignment < 0:
+		up, down = up/2, down*2
+	}
+	up, down = math.Min(up, 1), math.Min(down, 1)
+	if sum := up + down; sum > 1 {
+		up, down = up/sum, down/sum
+	}
+	return up, down
+}
+
+// botCommunityToxicity returns the toxicity score of the community of
+// settings, as of the actions of bots in it, or the middle of its range if
+// bots haven't acted in it recently. It's clamped as per settings.
+func botCommunityToxicity(ctx context.Context, db *sql.DB, settings *CommunityBotSettings) (int, error) {
+	var avg sql.NullFloat64
+	err := db.QueryRowContext(ctx, "SELECT AVG(toxicity) FROM bot_actions WHERE community_id = ? AND toxicity IS NOT NULL AND created_at > ?",
+		settings.CommunityID, now().Add(-botVoteToxicityWindow)).Scan(&avg)
+	if err != nil {
+		return 0, err
+	}
+	score := (settings.MinToxicity + settings.MaxToxicity) / 2
+	if avg.Valid {
+		score = int(math.Round(avg.Float64))
+	}
+	return settings.clampToxicity(score), nil
+}
+
+// SimulateBotVotes runs a round of bot votes, and returns the number of votes
+// cast (or, in dry runs, recorded).
+func SimulateBotVotes(ctx context.Context, db *sql.DB) (int, error) {
+	v := GetBotVoting()
+	if !v.Enabled || v.VotesPerRound == 0 || BotsHalted() {
+		return 0, nil
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT id FROM posts
+		WHERE created_at > ? AND deleted = FALSE AND locked = FALSE
+		ORDER BY created_at DESC LIMIT ?`, now().Add(-botVotePostAge), maxBotVoteCandidates)
+	if err != nil {
+		return 0, err
+	}
+	var ids []uid.ID
+	for rows.Next() {
+		var id uid.ID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	random().Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+	posts, err := GetPostsByIDs(ctx, db, nil, false, ids[:min(len(ids), 4*v.VotesPerRound)]...)
+	if err != nil {
+		return 0, err
+	}
+
+	r := &botVoteRound{
+		db:        db,
+		voting:    v,
+		settings:  make(map[uid.ID]*CommunityBotSettings),
+		toxicity:  make(map[uid.ID]int),
+		personas:  make(map[uid.ID]*BotPersona),
+		hourVotes: make(map[uid.ID]int),
+	}
+	votes := 0
+	for _, post := range posts {
+		if votes >= v.VotesPerRound || BotsHalted() {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return votes, err
+		}
+		voted, err := r.vote(ctx, post)
+		if err != nil {
+			return votes, fmt.Errorf("bot vote on post %v: %w", post.ID, err)
+		}
+		if voted {
+			votes++
+		}
+	}
+	return votes, nil
+}
+
+// botVoteRound is a round of bot votes, with what's been looked up in it.
+type botVoteRound struct {
+	db        *sql.DB
+	voting    BotVoting
+	settings  map[uid.ID]*CommunityBotSettings // By community.
+	toxicity  map[uid.ID]int                   // By community.
+	personas  map[uid.ID]*BotPersona           // By bot.
+	hourVotes map[uid.ID]int                   // Votes of bots in the last hour, by bot.
+}
+
+// vote has a random bot vote on post, perhaps, and reports whether it did.
+func (r *botVoteRound) vote(ctx context.Context, post *Post) (bool, error) {
+	settings, ok := r.settings[post.CommunityID]
+	if !ok {
+		var err error
+		if settings, err = GetCommunityBotSettings(ctx, r.db, post.CommunityID); err != nil {
+			return false, err
+		}
+		r.settings[post.CommunityID] = settings
+	}
+	if !settings.Enabled {
+		return false, nil
+	}
+
+	bot, err := GetRandomBotUser(ctx, r.db)
+	if err != nil {
+		return false, err
+	}
+	if bot.ID == post.AuthorID {
+		return false, nil
+	}
+	if n, err := r.votesLastHour(ctx, bot.ID); err != nil {
+		return false, err
+	} else if n >= r.voting.MaxVotesPerBotPerHour && r.voting.MaxVotesPerBotPerHour > 0 {
+		return false, nil
+	}
+	var voted bool
+	if err := r.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM post_votes WHERE post_id = ? AND user_id = ?)", post.ID, bot.ID).Scan(&voted); err != nil {
+		return false, err
+	} else if voted {
+		return false, nil
+	}
+
+	toxicity, ok := r.toxicity[post.CommunityID]
+	if !ok {
+		if toxicity, err = botCommunityToxicity(ctx, r.db, settings); err != nil {
+			return false, err
+		}
+		r.toxicity[post.CommunityID] = toxicity
+	}
+	voter, err := r.persona(ctx, bot.ID)
+	if err != nil {
+		return false, err
+	}
+	authorIsBot := post.PostedAs == UserGroupBots
+	var author *BotPersona
+	if authorIsBot {
+		if author, err = r.persona(ctx, post.AuthorID); err != nil {
+			return false, err
+		}
+	}
+	alignment := botVoteAlignment(voter, author, authorIsBot)
+
+	upChance, downChance := botVoteOdds(r.voting, toxicity, alignment)
+	var up bool
+	switch x := random().Float64(); {
+	case x < upChance:
+		up = true
+	case x < upChance+downChance:
+		up = false
+	default:
+		return false, nil
+	}
+
+	dryRun := settings.dryRun()
+	if !dryRun {
+		if err := post.Vote(ctx, r.db, bot.ID, up); err != nil {
+			return false, err
+		}
+	}
+	if _, err := r.db.ExecContext(ctx, `
+		INSERT INTO bot_votes (id, community_id, bot_id, post_id, up, toxicity, alignment, dry_run, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		uid.New(), post.CommunityID, bot.ID, post.ID, up, toxicity, alignment, dryRun, now()); err != nil {
+		return false, fmt.Errorf("failed to record bot vote: %w", err)
+	}
+	r.hourVotes[bot.ID]++
+	return true, nil
+}
+
+// votesLastHour returns the number of votes bot cast in the last hour.
+func (r *botVoteRound) votesLastHour(ctx context.Context, bot uid.ID) (int, error) {
+	if n, ok := r.hourVotes[bot]; ok {
+		return n, nil
+	}
+	var n int
+	if err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM bot_votes WHERE bot_id = ? AND created_at > ?", bot, now().Add(-time.Hour)).Scan(&n); err != nil {
+		return 0, err
+	}
+	r.hourVotes[bot] = n
+	return n, nil
+}
+
+// persona returns the persona of bot, or nil if it has none.
+func (r *botVoteRound) persona(ctx context.Context, bot uid.ID) (*BotPersona, error) {
+	if p, ok := r.personas[bot]; ok {
+		return p, nil
+	}
+	p, err := GetBotUserPersona(ctx, r.db, bot)
+	if err != nil {
+		return nil, err
+	}
+	r.personas[bot] = p
+	return p, nil
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"math"
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestBotVoteOdds(t *testing.T) {
+	v := BotVoting{UpvoteChance: 0.4, DownvoteChance: 0.1}
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+	cases := []struct {
+		toxicity, alignment int
+		up, down            float64
+	}{
+		{3, 0, 0.4, 0.1},  // Middling toxicity.
+		{1, 0, 0.6, 0.05}, // Least toxic.
+		{5, 0, 0.2, 0.15}, // Most toxic.
+		{9, 0, 0.2, 0.15}, // Clamped.
+		{3, 1, 0.8, 0},    // Same persona.
+		{3, -1, 0.2, 0.2}, // Different personas.
+		{1, 1, 1.0, 0},    // Capped.
+	}
+	for _, c := range cases {
+		up, down := botVoteOdds(v, c.toxicity, c.alignment)
+		if !near(up, c.up) || !near(down, c.down) {
+			t.Errorf("botVoteOdds(%d, %d) = %v, %v; want %v, %v", c.toxicity, c.alignment, up, down, c.up, c.down)
+		}
+	}
+
+	up, down := botVoteOdds(BotVoting{UpvoteChance: 0.5, DownvoteChance: 0.5}, 5, -1)
+	if !near(up+down, 1) {
+		t.Errorf("odds add up to %v, want at most 1", up+down)
+	}
+}
+
+func TestBotVoteAlignment(t *testing.T) {
+	a, b := &BotPersona{ID: uid.New()}, &BotPersona{ID: uid.New()}
+	if got := botVoteAlignment(a, a, true); got != 1 {
+		t.Errorf("same persona: %d, want 1", got)
+	}
+	if got := botVoteAlignment(a, b, true); got != -1 {
+		t.Errorf("different personas: %d, want -1", got)
+	}
+	if got := botVoteAlignment(a, nil, true); got != 0 {
+		t.Errorf("author without a persona: %d, want 0", got)
+	}
+	if got := botVoteAlignment(a, a, false); got != 0 {
+		t.Errorf("human author: %d, want 0", got)
+	}
+}
+
+func TestBotVotingValidate(t *testing.T) {
+	if err := (&BotVoting{Enabled: true, VotesPerRound: 10, UpvoteChance: 0.3, DownvoteChance: 0.1}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []BotVoting{
+		{VotesPerRound: -1},
+		{UpvoteChance: -0.1},
+		{UpvoteChance: 0.8, DownvoteChance: 0.3},
+	} {
+		if err := v.Validate(); err == nil {
+			t.Errorf("%+v is valid", v)
+		}
+	}
+}
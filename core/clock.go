@@ -0,0 +1,60 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/clock"
+	"github.com/discuitnet/discuit/internal/rng"
+)
+
+// The bots, and the scheduler of their posts, go by the clock set with
+// SetClock and draw their random numbers from the source set with SetRand, so
+// that tests (and replays of experiments) can make them deterministic.
+
+var (
+	coreClockMu sync.RWMutex // guards coreClock and coreRand
+	coreClock   clock.Clock  = clock.Real{}
+	coreRand    rng.Rand     = rng.Global{}
+)
+
+// SetClock sets the clock that the bots go by. It's meant for tests, which
+// may set a fake clock (see clock.Fake).
+func SetClock(c clock.Clock) {
+	coreClockMu.Lock()
+	defer coreClockMu.Unlock()
+	coreClock = c
+}
+
+// SetRand sets the source of the random numbers of the bots. A seeded source
+// (see rng.New) makes the choices of the bots (which bot responds, how long
+// it waits, which communities are posted in first, and so on) repeatable.
+func SetRand(r rng.Rand) {
+	coreClockMu.Lock()
+	defer coreClockMu.Unlock()
+	coreRand = r
+}
+
+// now returns the time, as told by the clock set with SetClock.
+func now() time.Time {
+	coreClockMu.RLock()
+	defer coreClockMu.RUnlock()
+	return coreClock.Now()
+}
+
+// sleep waits until d passes by the clock set with SetClock, or until ctx is
+// done, in which case it returns the error of ctx.
+func sleep(ctx context.Context, d time.Duration) error {
+	coreClockMu.RLock()
+	c := coreClock
+	coreClockMu.RUnlock()
+	return clock.Sleep(ctx, c, d)
+}
+
+// random returns the source set with SetRand.
+func random() rng.Rand {
+	coreClockMu.RLock()
+	defer coreClockMu.RUnlock()
+	return coreRand
+}
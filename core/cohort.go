@@ -0,0 +1,752 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+	"github.com/discuitnet/discuit/internal/utils"
+)
+
+// Cohorts are for classroom deployments. An admin creates a cohort (say, an
+// offering of a course) and imports its roster, a CSV file of the students and
+// staff of the course (see ParseRoster). An account is created for each
+// person on the roster, along with an invite code, which they claim by
+// signing up with it (see ClaimCohortInvite). Signups can be limited to such
+// invites (see the SignupsRosterOnly site setting).
+//
+// The people on a roster may be assigned to sections. Each section has a
+// private community whose members are the people of the section, and staff
+// are made moderators of the communities of their sections.
+//
+// Researchers on a roster are not counted as participants; they may only
+// export the engagement of the cohort pseudonymized (see
+// Cohort.GetEngagement).
+
+// Roles of the people on a roster.
+const (
+	CohortRoleStudent    = "student"
+	CohortRoleStaff      = "staff"
+	CohortRoleResearcher = "researcher" // See Cohort.GetEngagement.
+)
+
+const (
+	maxCohortNameLength = 255
+	maxRosterSize       = 5000 // in entries
+)
+
+var (
+	errCohortNotFound    = httperr.NewNotFound("cohort_not_found", "Cohort not found.")
+	errInvalidInviteCode = httperr.NewForbidden("invalid_invite_code", "The invite code is invalid or has already been used.")
+)
+
+type Cohort struct {
+	ID         uid.ID           `json:"id"`
+	Name       string           `json:"name"`
+	CreatedBy  uid.NullID       `json:"createdBy"`
+	CreatedAt  time.Time        `json:"createdAt"`
+	NumMembers int              `json:"noMembers"` // People on the roster.
+	Sections   []*CohortSection `json:"sections"`
+}
+
+// CohortSection is a section of a cohort and its private community.
+type CohortSection struct {
+	ID            uid.ID `json:"id"`
+	Name          string `json:"name"`
+	CommunityID   uid.ID `json:"communityId"`
+	CommunityName string `json:"communityName"`
+}
+
+// CreateCohort creates a cohort named name, on behalf of admin.
+func CreateCohort(ctx context.Context, db *sql.DB, admin uid.ID, name string) (*Cohort, error) {
+	if is, err := IsAdmin(db, &admin); err != nil {
+		return nil, err
+	} else if !is {
+		return nil, errNotAdmin
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxCohortNameLength {
+		return nil, httperr.NewBadRequest("invalid_name", fmt.Sprintf("Cohort name must be between 1 and %d characters long.", maxCohortNameLength))
+	}
+	id := uid.New()
+	if _, err := db.ExecContext(ctx, "INSERT INTO cohorts (id, name, created_by) VALUES (?, ?, ?)", id, name, admin); err != nil {
+		if msql.IsErrDuplicateErr(err) {
+			return nil, &httperr.Error{HTTPStatus: http.StatusConflict, Code: "cohort_exists", Message: "A cohort with that name already exists."}
+		}
+		return nil, err
+	}
+	return GetCohort(ctx, db, id)
+}
+
+func getCohorts(ctx context.Context, db *sql.DB, where string, args ...any) ([]*Cohort, error) {
+	query := "SELECT id, name, created_by, created_at, (SELECT COUNT(*) FROM cohort_members WHERE cohort_id = cohorts.id) FROM cohorts " + where
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cohorts []*Cohort
+	for rows.Next() {
+		ch := &Cohort{Sections: []*CohortSection{}}
+		if err := rows.Scan(&ch.ID, &ch.Name, &ch.CreatedBy, &ch.CreatedAt, &ch.NumMembers); err != nil {
+			return nil, err
+		}
+		cohorts = append(cohorts, ch)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, ch := range cohorts {
+		if err := ch.loadSections(ctx, db); err != nil {
+			return nil, err
+		}
+	}
+	return cohorts, nil
+}
+
+// GetCohorts returns all cohorts, the latest first.
+func GetCohorts(ctx context.Context, db *sql.DB) ([]*Cohort, error) {
+	cohorts, err := getCohorts(ctx, db, "ORDER BY created_at DESC")
+	if cohorts == nil {
+		cohorts = []*Cohort{}
+	}
+	return cohorts, err
+}
+
+// GetCohort returns a not-found httperr.Error if no cohort is found.
+func GetCohort(ctx context.Context, db *sql.DB, id uid.ID) (*Cohort, error) {
+	cohorts, err := getCohorts(ctx, db, "WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(cohorts) == 0 {
+		return nil, errCohortNotFound
+	}
+	return cohorts[0], nil
+}
+
+func (ch *Cohort) loadSections(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT cohort_sections.id, cohort_sections.name, cohort_sections.community_id, communities.name
+		FROM cohort_sections
+		INNER JOIN communities ON communities.id = cohort_sections.community_id
+		WHERE cohort_sections.cohort_id = ?
+		ORDER BY cohort_sections.name`, ch.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	ch.Sections = []*CohortSection{}
+	for rows.Next() {
+		s := &CohortSection{}
+		if err := rows.Scan(&s.ID, &s.Name, &s.CommunityID, &s.CommunityName); err != nil {
+			return err
+		}
+		ch.Sections = append(ch.Sections, s)
+	}
+	return rows.Err()
+}
+
+// section returns the section of ch named name, creating it (and its private
+// community, on behalf of creator) if there's none.
+func (ch *Cohort) section(ctx context.Context, db *sql.DB, creator uid.ID, name string) (*CohortSection, error) {
+	for _, s := range ch.Sections {
+		if strings.EqualFold(s.Name, name) {
+			return s, nil
+		}
+	}
+
+	comm, err := createSectionCommunity(ctx, db, creator, ch.Name+"_"+name, fmt.Sprintf("Section %s of %s.", name, ch.Name))
+	if err != nil {
+		return nil, err
+	}
+	s := &CohortSection{
+		ID:            uid.New(),
+		Name:          name,
+		CommunityID:   comm.ID,
+		CommunityName: comm.Name,
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO cohort_sections (id, cohort_id, name, community_id) VALUES (?, ?, ?, ?)", s.ID, ch.ID, s.Name, s.CommunityID); err != nil {
+		return nil, err
+	}
+	ch.Sections = append(ch.Sections, s)
+	return s, nil
+}
+
+// sectionByID returns the section of ch with id, or nil.
+func (ch *Cohort) sectionByID(id uid.ID) *CohortSection {
+	for _, s := range ch.Sections {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// createSectionCommunity creates a private community with a name derived
+// from name.
+func createSectionCommunity(ctx context.Context, db *sql.DB, creator uid.ID, name, about string) (*Community, error) {
+	var cname string
+	for n := 0; ; n++ {
+		cname = derivedUsername(name, n)
+		exists, _, err := CommunityExists(ctx, db, cname)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			break
+		}
+	}
+
+	id := uid.New()
+	about = utils.TruncateUnicodeString(about, maxCommunityAboutLength)
+	if _, err := db.ExecContext(ctx, "INSERT INTO communities (id, name, name_lc, user_id, about, private) VALUES (?, ?, ?, ?, ?, TRUE)",
+		id, cname, strings.ToLower(cname), creator, about); err != nil {
+		return nil, err
+	}
+	return GetCommunityByID(ctx, db, id, nil)
+}
+
+// RosterEntry is a person on the roster of a cohort.
+type RosterEntry struct {
+	Email    string `json:"email"`
+	Username string `json:"username"` // If empty, one is derived from the email.
+	Section  string `json:"section"`  // Optional.
+	Role     string `json:"role"`
+}
+
+// rosterColumns are the columns of roster files that are read. Other columns
+// are ignored.
+var rosterColumns = []string{"email", "username", "section", "role"}
+
+func invalidRoster(line int, format string, args ...any) error {
+	return httperr.NewBadRequest("invalid_roster", fmt.Sprintf("Roster line %d: %s.", line, fmt.Sprintf(format, args...)))
+}
+
+// ParseRoster parses a roster file: a CSV file with a header row, and a row
+// for each person on the roster. The email column is required; the username,
+// section, and role (student, staff, or researcher; student if empty) columns
+// are optional.
+func ParseRoster(r io.Reader) ([]*RosterEntry, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, httperr.NewBadRequest("invalid_roster", "The roster is empty.")
+	} else if err != nil {
+		return nil, httperr.NewBadRequest("invalid_roster", err.Error())
+	}
+	cols := make(map[string]int)
+	for i, h := range header {
+		if i == 0 {
+			h = strings.TrimPrefix(h, "\ufeff") // As saved by spreadsheet apps.
+		}
+		h = strings.ToLower(strings.TrimSpace(h))
+		for _, col := range rosterColumns {
+			if h == col {
+				if _, ok := cols[h]; ok {
+					return nil, invalidRoster(1, "duplicate column %s", h)
+				}
+				cols[h] = i
+			}
+		}
+	}
+	if _, ok := cols["email"]; !ok {
+		return nil, invalidRoster(1, "no email column")
+	}
+
+	var entries []*RosterEntry
+	seen := make(map[string]bool)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, httperr.NewBadRequest("invalid_roster", err.Error())
+		}
+		line, _ := cr.FieldPos(0)
+		get := func(col string) string {
+			if i, ok := cols[col]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		e := &RosterEntry{
+			Email:    strings.ToLower(get("email")),
+			Username: get("username"),
+			Section:  get("section"),
+			Role:     strings.ToLower(get("role")),
+		}
+		if e.Email == "" && e.Username == "" && e.Section == "" && e.Role == "" {
+			continue
+		}
+		if at := strings.Index(e.Email, "@"); at < 1 || at != strings.LastIndex(e.Email, "@") || at == len(e.Email)-1 || len(e.Email) > 255 {
+			return nil, invalidRoster(line, "invalid email %q", e.Email)
+		}
+		if seen[e.Email] {
+			return nil, invalidRoster(line, "duplicate email %s", e.Email)
+		}
+		seen[e.Email] = true
+		if e.Username != "" {
+			if err := IsUsernameValid(e.Username); err != nil {
+				return nil, invalidRoster(line, "username %s %v", e.Username, err)
+			}
+		}
+		if len(e.Section) > maxCohortNameLength {
+			return nil, invalidRoster(line, "section name too long")
+		}
+		switch e.Role {
+		case "":
+			e.Role = CohortRoleStudent
+		case CohortRoleStudent, CohortRoleStaff, CohortRoleResearcher:
+		default:
+			return nil, invalidRoster(line, "invalid role %q", e.Role)
+		}
+
+		if entries = append(entries, e); len(entries) > maxRosterSize {
+			return nil, httperr.NewBadRequest("invalid_roster", fmt.Sprintf("A roster can have at most %d entries.", maxRosterSize))
+		}
+	}
+	if len(entries) == 0 {
+		return nil, httperr.NewBadRequest("invalid_roster", "The roster is empty.")
+	}
+	return entries, nil
+}
+
+// RosterInvite is the invite of a person on the roster of a cohort.
+type RosterInvite struct {
+	Email      string `json:"email"`
+	Username   string `json:"username"`
+	Section    string `json:"section"`
+	Role       string `json:"role"`
+	InviteCode string `json:"inviteCode"`
+	Claimed    bool   `json:"claimed"`
+}
+
+// WriteRosterInvitesCSV writes invites to w as a CSV file (for mail merges,
+// say).
+func WriteRosterInvitesCSV(w io.Writer, invites []*RosterInvite) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"email", "username", "section", "role", "invite_code", "claimed"})
+	for _, inv := range invites {
+		cw.Write([]string{inv.Email, inv.Username, inv.Section, inv.Role, inv.InviteCode, strconv.FormatBool(inv.Claimed)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// RosterReport is the result of a roster import.
+type RosterReport struct {
+	Created  int      `json:"created"` // Accounts created.
+	Updated  int      `json:"updated"` // Entries that were already on the roster.
+	Failed   int      `json:"failed"`
+	Problems []string `json:"problems"`
+
+	Invites []*RosterInvite `json:"invites"` // Of the imported entries.
+}
+
+func (r *RosterReport) problem(format string, args ...any) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// ImportRoster adds entries to the roster of ch, on behalf of admin. An
+// account is created, with an invite code, for each person not already on the
+// roster (or on the roster of another cohort, whose account is then reused).
+// The sections (and their communities) of entries are created as needed.
+// Entries already on the roster have their section and role updated.
+func ImportRoster(ctx context.Context, db *sql.DB, admin uid.ID, ch *Cohort, entries []*RosterEntry) (*RosterReport, error) {
+	if is, err := IsAdmin(db, &admin); err != nil {
+		return nil, err
+	} else if !is {
+		return nil, errNotAdmin
+	}
+
+	report := &RosterReport{Problems: []string{}, Invites: []*RosterInvite{}}
+	for _, e := range entries {
+		inv, err := ch.importRosterEntry(ctx, db, admin, e, report)
+		if err != nil {
+			var herr *httperr.Error
+			if !errors.As(err, &herr) {
+				return report, err
+			}
+			report.Failed++
+			report.problem("%s: %s", e.Email, herr.Message)
+			continue
+		}
+		report.Invites = append(report.Invites, inv)
+	}
+	return report, nil
+}
+
+func (ch *Cohort) importRosterEntry(ctx context.Context, db *sql.DB, admin uid.ID, e *RosterEntry, report *RosterReport) (*RosterInvite, error) {
+	var section *CohortSection
+	if e.Section != "" {
+		var err error
+		if section, err = ch.section(ctx, db, admin, e.Section); err != nil {
+			return nil, err
+		}
+	}
+	var sectionID uid.NullID
+	if section != nil {
+		sectionID = uid.NullID{ID: section.ID, Valid: true}
+	}
+
+	inv := &RosterInvite{Email: e.Email, Section: e.Section, Role: e.Role}
+	var (
+		userID      uid.ID
+		prevSection uid.NullID
+	)
+	row := db.QueryRowContext(ctx, "SELECT user_id, section_id, invite_code, claimed_at IS NOT NULL FROM cohort_members WHERE cohort_id = ? AND email = ?", ch.ID, e.Email)
+	err := row.Scan(&userID, &prevSection, &inv.InviteCode, &inv.Claimed)
+	if err == sql.ErrNoRows {
+		var claimedAt msql.NullTime
+		row := db.QueryRowContext(ctx, "SELECT user_id, claimed_at FROM cohort_members WHERE email = ? ORDER BY created_at DESC LIMIT 1", e.Email)
+		if err := row.Scan(&userID, &claimedAt); err == sql.ErrNoRows {
+			user, err := registerRosterUser(ctx, db, e)
+			if err != nil {
+				return nil, err
+			}
+			userID = user.ID
+		} else if err != nil {
+			return nil, err
+		}
+		if inv.InviteCode, err = newInviteCode(); err != nil {
+			return nil, err
+		}
+		inv.Claimed = claimedAt.Valid
+		_, err = db.ExecContext(ctx, "INSERT INTO cohort_members (cohort_id, email, user_id, section_id, role, invite_code, claimed_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			ch.ID, e.Email, userID, sectionID, e.Role, inv.InviteCode, claimedAt)
+		if err != nil {
+			return nil, err
+		}
+		report.Created++
+	} else if err != nil {
+		return nil, err
+	} else {
+		if _, err := db.ExecContext(ctx, "UPDATE cohort_members SET section_id = ?, role = ? WHERE cohort_id = ? AND email = ?", sectionID, e.Role, ch.ID, e.Email); err != nil {
+			return nil, err
+		}
+		if prev := ch.sectionByID(prevSection.ID); prevSection.Valid && prevSection != sectionID && prev != nil {
+			comm, err := GetCommunityByID(ctx, db, prev.CommunityID, nil)
+			if err != nil {
+				return nil, err
+			}
+			if err := comm.Leave(ctx, db, userID); err != nil {
+				return nil, err
+			}
+		}
+		report.Updated++
+	}
+
+	if inv.Username, err = usernameByID(ctx, db, userID); err != nil {
+		return nil, err
+	}
+	if section != nil {
+		comm, err := GetCommunityByID(ctx, db, section.CommunityID, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := comm.join(ctx, db, userID); err != nil {
+			return nil, err
+		}
+		if err := makeUserMod(ctx, db, comm, userID, e.Role == CohortRoleStaff); err != nil {
+			return nil, err
+		}
+	}
+	return inv, nil
+}
+
+// registerRosterUser creates the account of e, with an unusable password
+// (until the invite of e is claimed).
+func registerRosterUser(ctx context.Context, db *sql.DB, e *RosterEntry) (*User, error) {
+	username := e.Username
+	if username == "" {
+		local, _, _ := strings.Cut(e.Email, "@")
+		for n := 0; ; n++ {
+			username = derivedUsername(local, n)
+			exists, _, err := usernameExists(ctx, db, username)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				break
+			}
+		}
+	}
+	return RegisterUser(ctx, db, username, e.Email, utils.GenerateStringID(48), "")
+}
+
+func usernameByID(ctx context.Context, db *sql.DB, user uid.ID) (username string, err error) {
+	err = db.QueryRowContext(ctx, "SELECT username FROM users WHERE id = ?", user).Scan(&username)
+	return
+}
+
+// newInviteCode returns a random invite code (which is easy enough to type).
+func newInviteCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(b), nil
+}
+
+// GetCohortRoster returns the invites of all the people on the roster of ch.
+func GetCohortRoster(ctx context.Context, db *sql.DB, ch *Cohort) ([]*RosterInvite, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT cohort_members.email, users.username, cohort_members.section_id, cohort_members.role, cohort_members.invite_code, cohort_members.claimed_at IS NOT NULL
+		FROM cohort_members
+		INNER JOIN users ON users.id = cohort_members.user_id
+		WHERE cohort_members.cohort_id = ?
+		ORDER BY cohort_members.email`, ch.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []*RosterInvite{}
+	for rows.Next() {
+		inv := &RosterInvite{}
+		var sectionID uid.NullID
+		if err := rows.Scan(&inv.Email, &inv.Username, &sectionID, &inv.Role, &inv.InviteCode, &inv.Claimed); err != nil {
+			return nil, err
+		}
+		if s := ch.sectionByID(sectionID.ID); sectionID.Valid && s != nil {
+			inv.Section = s.Name
+		}
+		invites = append(invites, inv)
+	}
+	return invites, rows.Err()
+}
+
+// ClaimCohortInvite sets the password of the account of the invite with code,
+// which can then be logged in to, and returns the account.
+func ClaimCohortInvite(ctx context.Context, db *sql.DB, code, password string) (*User, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	var userID uid.ID
+	if err := db.QueryRowContext(ctx, "SELECT user_id FROM cohort_members WHERE invite_code = ? AND claimed_at IS NULL", code).Scan(&userID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errInvalidInviteCode
+		}
+		return nil, err
+	}
+
+	hash, err := HashPassword([]byte(password))
+	if err != nil {
+		return nil, err
+	}
+	err = msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, "UPDATE cohort_members SET claimed_at = ? WHERE invite_code = ? AND claimed_at IS NULL", time.Now(), code)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return errInvalidInviteCode // Claimed concurrently.
+		}
+		// The account is shared by the rosters of other cohorts with the same
+		// email, whose invites are now claimed too.
+		if _, err := tx.ExecContext(ctx, "UPDATE cohort_members SET claimed_at = ? WHERE user_id = ? AND claimed_at IS NULL", time.Now(), userID); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE users SET password = ? WHERE id = ? AND deleted_at IS NULL", hash, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetUser(ctx, db, userID, nil)
+}
+
+// CohortParticipation is the participation of the people on the roster of a
+// cohort since a time.
+type CohortParticipation struct {
+	Since    time.Time               `json:"since"`
+	Sections []*SectionParticipation `json:"sections"`
+	Members  []*MemberParticipation  `json:"members"`
+
+	// If true, usernames are pseudonyms, and emails and user IDs are
+	// removed (see Pseudonymize).
+	Pseudonymized bool `json:"pseudonymized"`
+}
+
+// SectionParticipation is the participation of the people of a section. The
+// people without a section are counted in a section with an empty name.
+type SectionParticipation struct {
+	Section       string `json:"section"`
+	NumMembers    int    `json:"noMembers"`
+	ActiveMembers int    `json:"noActiveMembers"` // Members with posts, comments, or votes.
+	Posts         int    `json:"noPosts"`
+	Comments      int    `json:"noComments"`
+	Votes         int    `json:"noVotes"`
+}
+
+// MemberParticipation is the participation of a person on a roster. Posts,
+// comments, and votes on the whole site are counted, not only those in the
+// community of the section of the person.
+type MemberParticipation struct {
+	UserID   uid.ID    `json:"userId"`
+	Username string    `json:"username"`
+	Email    string    `json:"email"`
+	Section  string    `json:"section"`
+	Role     string    `json:"role"`
+	Claimed  bool      `json:"claimed"`
+	Posts    int       `json:"noPosts"`
+	Comments int       `json:"noComments"`
+	Votes    int       `json:"noVotes"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+func (m *MemberParticipation) active() bool {
+	return m.Posts+m.Comments+m.Votes > 0
+}
+
+// GetCohortParticipation returns the participation of the people on the
+// roster of ch since since. Researchers are not counted.
+func GetCohortParticipation(ctx context.Context, db *sql.DB, ch *Cohort, since time.Time) (*CohortParticipation, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT cohort_members.user_id, users.username, cohort_members.email, cohort_members.section_id, cohort_members.role, cohort_members.claimed_at IS NOT NULL, users.last_seen
+		FROM cohort_members
+		INNER JOIN users ON users.id = cohort_members.user_id
+		WHERE cohort_members.cohort_id = ? AND cohort_members.role <> ?
+		ORDER BY users.username_lc`, ch.ID, CohortRoleResearcher)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	p := &CohortParticipation{Since: since, Sections: []*SectionParticipation{}, Members: []*MemberParticipation{}}
+	members := make(map[uid.ID]*MemberParticipation)
+	for rows.Next() {
+		m := &MemberParticipation{}
+		var sectionID uid.NullID
+		if err := rows.Scan(&m.UserID, &m.Username, &m.Email, &sectionID, &m.Role, &m.Claimed, &m.LastSeen); err != nil {
+			return nil, err
+		}
+		if s := ch.sectionByID(sectionID.ID); sectionID.Valid && s != nil {
+			m.Section = s.Name
+		}
+		p.Members = append(p.Members, m)
+		members[m.UserID] = m
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	counts := []struct {
+		query string
+		count func(*MemberParticipation) *int
+	}{
+		{"SELECT user_id, COUNT(*) FROM posts WHERE deleted = FALSE AND created_at >= ? AND user_id IN (SELECT user_id FROM cohort_members WHERE cohort_id = ?) GROUP BY user_id",
+			func(m *MemberParticipation) *int { return &m.Posts }},
+		{"SELECT user_id, COUNT(*) FROM comments WHERE deleted_at IS NULL AND created_at >= ? AND user_id IN (SELECT user_id FROM cohort_members WHERE cohort_id = ?) GROUP BY user_id",
+			func(m *MemberParticipation) *int { return &m.Comments }},
+		{"SELECT user_id, COUNT(*) FROM post_votes WHERE created_at >= ? AND user_id IN (SELECT user_id FROM cohort_members WHERE cohort_id = ?) GROUP BY user_id",
+			func(m *MemberParticipation) *int { return &m.Votes }},
+		{"SELECT user_id, COUNT(*) FROM comment_votes WHERE created_at >= ? AND user_id IN (SELECT user_id FROM cohort_members WHERE cohort_id = ?) GROUP BY user_id",
+			func(m *MemberParticipation) *int { return &m.Votes }},
+	}
+	for _, c := range counts {
+		err := countByUser(ctx, db, c.query, []any{since, ch.ID}, func(user uid.ID, n int) {
+			if m := members[user]; m != nil {
+				*c.count(m) += n
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p.Sections = sectionParticipation(p.Members)
+	return p, nil
+}
+
+// countByUser runs query, which selects a user ID and a count per row, and
+// calls add for each row.
+func countByUser(ctx context.Context, db *sql.DB, query string, args []any, add func(user uid.ID, n int)) error {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			user uid.ID
+			n    int
+		)
+		if err := rows.Scan(&user, &n); err != nil {
+			return err
+		}
+		add(user, n)
+	}
+	return rows.Err()
+}
+
+// sectionParticipation sums the participation of members by section, in the
+// order of the first member of each section.
+func sectionParticipation(members []*MemberParticipation) []*SectionParticipation {
+	sections := []*SectionParticipation{}
+	bySection := make(map[string]*SectionParticipation)
+	for _, m := range members {
+		s := bySection[m.Section]
+		if s == nil {
+			s = &SectionParticipation{Section: m.Section}
+			bySection[m.Section] = s
+			sections = append(sections, s)
+		}
+		s.NumMembers++
+		if m.active() {
+			s.ActiveMembers++
+		}
+		s.Posts += m.Posts
+		s.Comments += m.Comments
+		s.Votes += m.Votes
+	}
+	return sections
+}
+
+// WriteCSV writes the participation of each person of p to w as a CSV file. If
+// p is pseudonymized, the file has no email column.
+func (p *CohortParticipation) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	header := []string{"username"}
+	if !p.Pseudonymized {
+		header = append(header, "email")
+	}
+	cw.Write(append(header, "section", "role", "claimed", "posts", "comments", "votes", "last_seen"))
+	for _, m := range p.Members {
+		record := []string{m.Username}
+		if !p.Pseudonymized {
+			record = append(record, m.Email)
+		}
+		cw.Write(append(record,
+			m.Section,
+			m.Role,
+			strconv.FormatBool(m.Claimed),
+			strconv.Itoa(m.Posts),
+			strconv.Itoa(m.Comments),
+			strconv.Itoa(m.Votes),
+			m.LastSeen.UTC().Format(time.RFC3339),
+		))
+	}
+	cw.Flush()
+	return cw.Error()
+}
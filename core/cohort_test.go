@@ -0,0 +1,113 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestParseRoster(t *testing.T) {
+	roster := "\ufeffName,Email,Section,Role\n" +
+		"Alice,Alice@Example.edu,A,\n" +
+		"Bob,bob@example.edu,, Staff\n" +
+		",,,\n" +
+		"Carol,carol@example.edu,B,student\n"
+	entries, err := ParseRoster(strings.NewReader(roster))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*RosterEntry{
+		{Email: "alice@example.edu", Section: "A", Role: CohortRoleStudent},
+		{Email: "bob@example.edu", Role: CohortRoleStaff},
+		{Email: "carol@example.edu", Section: "B", Role: CohortRoleStudent},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("ParseRoster() = %+v, want %+v", entries, want)
+	}
+
+	invalid := []string{
+		"",
+		"name,section\nAlice,A\n",
+		"email,email\na@example.edu,b@example.edu\n",
+		"email\nnot-an-email\n",
+		"email\n@example.edu\n",
+		"email\na@example.edu\nA@example.edu\n",
+		"email,role\na@example.edu,teacher\n",
+		"email,username\na@example.edu,no spaces\n",
+		"email\n",
+	}
+	for _, roster := range invalid {
+		if _, err := ParseRoster(strings.NewReader(roster)); err == nil {
+			t.Errorf("ParseRoster(%q) returned no error", roster)
+		}
+	}
+}
+
+func TestSectionParticipation(t *testing.T) {
+	members := []*MemberParticipation{
+		{Section: "A", Posts: 2},
+		{Section: "B"},
+		{Section: "A", Votes: 1},
+		{Section: "A"},
+		{Comments: 3},
+	}
+	want := []*SectionParticipation{
+		{Section: "A", NumMembers: 3, ActiveMembers: 2, Posts: 2, Votes: 1},
+		{Section: "B", NumMembers: 1},
+		{Section: "", NumMembers: 1, ActiveMembers: 1, Comments: 3},
+	}
+	if got := sectionParticipation(members); !reflect.DeepEqual(got, want) {
+		t.Errorf("sectionParticipation() = %+v, want %+v", got, want)
+	}
+}
+
+func TestWhereNotPrivate(t *testing.T) {
+	where, args := whereNotPrivate("WHERE deleted = FALSE ", nil, nil)
+	if want := "WHERE deleted = FALSE AND community_id NOT IN (SELECT id FROM communities WHERE private = TRUE) "; where != want || len(args) != 0 {
+		t.Errorf("whereNotPrivate() = %q, %v, want %q, []", where, args, want)
+	}
+
+	viewer := uid.New()
+	where, args = whereNotPrivate("WHERE ", []any{1}, &viewer)
+	if !strings.HasPrefix(where, "WHERE community_id NOT IN") || !reflect.DeepEqual(args, []any{1, viewer}) {
+		t.Errorf("whereNotPrivate() = %q, %v", where, args)
+	}
+}
+
+func TestPseudonymizeEngagement(t *testing.T) {
+	key := []byte("key")
+	alice, bob := uid.New(), uid.New()
+	if pseudonym(key, alice) != pseudonym(key, alice) {
+		t.Error("pseudonym() is not deterministic")
+	}
+	if pseudonym(key, alice) == pseudonym([]byte("other"), alice) {
+		t.Error("pseudonym() is the same with different keys")
+	}
+
+	e := &CohortEngagement{
+		Pseudonymization: PseudonymizeStable,
+		Members: []*MemberEngagement{
+			{userID: alice, Participant: "alice", Email: "alice@example.edu", Posts: 1},
+			{userID: bob, Participant: "bob", Email: "bob@example.edu", BotVotes: 2},
+		},
+	}
+	e.pseudonymize(key)
+	for _, m := range e.Members {
+		if m.Email != "" || m.Participant == "alice" || m.Participant == "bob" {
+			t.Errorf("member not pseudonymized: %+v", m)
+		}
+	}
+	if e.Members[0].Participant > e.Members[1].Participant {
+		t.Error("members not ordered by pseudonym")
+	}
+
+	var b strings.Builder
+	if err := e.WriteCSV(&b); err != nil {
+		t.Fatal(err)
+	}
+	if out := b.String(); strings.Contains(out, "email") || strings.Contains(out, "@") {
+		t.Errorf("pseudonymized CSV has emails: %q", out)
+	}
+}
@@ -0,0 +1,311 @@
+package core
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Pseudonymization is how the people of a cohort are identified in an
+// engagement export.
+type Pseudonymization string
+
+const (
+	// PseudonymizeNone identifies people by their usernames and emails.
+	PseudonymizeNone = Pseudonymization("none")
+
+	// PseudonymizeStable identifies people by pseudonyms that are the same
+	// in every export of a cohort (so that exports can be linked), but
+	// differ between cohorts.
+	PseudonymizeStable = Pseudonymization("stable")
+
+	// PseudonymizeRandom identifies people by pseudonyms that differ in
+	// every export.
+	PseudonymizeRandom = Pseudonymization("random")
+)
+
+// Valid reports whether p is a known pseudonymization.
+func (p Pseudonymization) Valid() bool {
+	switch p {
+	case PseudonymizeNone, PseudonymizeStable, PseudonymizeRandom:
+		return true
+	}
+	return false
+}
+
+var errEngagementIdentified = httperr.NewForbidden("pseudonymization_required", "Researchers may only export pseudonymized data.")
+
+// CohortEngagement is the engagement of each person on the roster of a
+// cohort (except researchers) in a date range.
+type CohortEngagement struct {
+	From             time.Time           `json:"from"`
+	To               time.Time           `json:"to"` // Exclusive.
+	Pseudonymization Pseudonymization    `json:"pseudonymization"`
+	Members          []*MemberEngagement `json:"members"`
+}
+
+// MemberEngagement is the engagement of a person on the roster of a cohort.
+// Posts, comments, and votes on the whole site are counted, and only those
+// that are not deleted.
+type MemberEngagement struct {
+	userID uid.ID
+
+	Participant string `json:"participant"`     // Username, or pseudonym.
+	Email       string `json:"email,omitempty"` // Empty if pseudonymized.
+	Section     string `json:"section"`
+	Role        string `json:"role"`
+
+	Posts         int `json:"noPosts"`
+	Comments      int `json:"noComments"`
+	VotesGiven    int `json:"noVotesGiven"`
+	VotesReceived int `json:"noVotesReceived"` // On their posts and comments, from others.
+
+	// Comments on posts by bots or replies to comments by bots, and votes
+	// on posts and comments by bots.
+	BotComments int `json:"noBotComments"`
+	BotVotes    int `json:"noBotVotes"`
+}
+
+// engagementCounts are the queries that count the engagement of the people
+// on the roster of a cohort. Each is run with the arguments from, to, and the
+// cohort ID.
+var engagementCounts = []struct {
+	query string
+	count func(*MemberEngagement) *int
+}{
+	{`SELECT user_id, COUNT(*) FROM posts
+		WHERE deleted = FALSE AND created_at >= ? AND created_at < ? AND user_id IN (SELECT user_id FROM cohort_members WHERE cohort_id = ?)
+		GROUP BY user_id`,
+		func(m *MemberEngagement) *int { return &m.Posts }},
+	{`SELECT user_id, COUNT(*) FROM comments
+		WHERE deleted_at IS NULL AND created_at >= ? AND created_at < ? AND user_id IN (SELECT user_id FROM cohort_members WHERE cohort_id = ?)
+		GROUP BY user_id`,
+		func(m *MemberEngagement) *int { return &m.Comments }},
+	{`SELECT user_id, COUNT(*) FROM post_votes
+		WHERE created_at >= ? AND created_at < ? AND user_id IN (SELECT user_id FROM cohort_members WHERE cohort_id = ?)
+		GROUP BY user_id`,
+		func(m *MemberEngagement) *int { return &m.VotesGiven }},
+	{`SELECT user_id, COUNT(*) FROM comment_votes
+		WHERE created_at >= ? AND created_at < ? AND user_id IN (SELECT user_id FROM cohort_members WHERE cohort_id = ?)
+		GROUP BY user_id`,
+		func(m *MemberEngagement) *int { return &m.VotesGiven }},
+	{`SELECT posts.user_id, COUNT(*) FROM post_votes
+		INNER JOIN posts ON posts.id = post_votes.post_id
+		WHERE post_votes.created_at >= ? AND post_votes.created_at < ? AND post_votes.user_id <> posts.user_id
+			AND posts.deleted = FALSE AND posts.user_id IN (SELECT user_id FROM cohort_members WHERE cohort_id = ?)
+		GROUP BY posts.user_id`,
+		func(m *MemberEngagement) *int { return &m.VotesReceived }},
+	{`SELECT comments.user_id, COUNT(*) FROM comment_votes
+		INNER JOIN comments ON comments.id = comment_votes.comment_id
+		WHERE comment_votes.created_at >= ? AND comment_votes.created_at < ? AND comment_votes.user_id <> comments.user_id
+			AND comments.deleted_at IS NULL AND comments.user_id IN (SELECT user_id FROM cohort_members WHERE cohort_id = ?)
+		GROUP BY comments.user_id`,
+		func(m *MemberEngagement) *int { return &m.VotesReceived }},
+	{`SELECT comments.user_id, COUNT(*) FROM comments
+		INNER JOIN posts ON posts.id = comments.post_id
+		LEFT JOIN comments AS parents ON parents.id = comments.parent_id
+		INNER JOIN users AS authors ON authors.id = IFNULL(parents.user_id, posts.user_id)
+		WHERE comments.deleted_at IS NULL AND comments.created_at >= ? AND comments.created_at < ? AND authors.is_bot = TRUE
+			AND comments.user_id IN (SELECT user_id FROM cohort_members WHERE cohort_id = ?)
+		GROUP BY comments.user_id`,
+		func(m *MemberEngagement) *int { return &m.BotComments }},
+	{`SELECT post_votes.user_id, COUNT(*) FROM post_votes
+		INNER JOIN posts ON posts.id = post_votes.post_id
+		INNER JOIN users AS authors ON authors.id = posts.user_id
+		WHERE post_votes.created_at >= ? AND post_votes.created_at < ? AND authors.is_bot = TRUE
+			AND post_votes.user_id IN (SELECT user_id FROM cohort_members WHERE cohort_id = ?)
+		GROUP BY post_votes.user_id`,
+		func(m *MemberEngagement) *int { return &m.BotVotes }},
+	{`SELECT comment_votes.user_id, COUNT(*) FROM comment_votes
+		INNER JOIN comments ON comments.id = comment_votes.comment_id
+		INNER JOIN users AS authors ON authors.id = comments.user_id
+		WHERE comment_votes.created_at >= ? AND comment_votes.created_at < ? AND authors.is_bot = TRUE
+			AND comment_votes.user_id IN (SELECT user_id FROM cohort_members WHERE cohort_id = ?)
+		GROUP BY comment_votes.user_id`,
+		func(m *MemberEngagement) *int { return &m.BotVotes }},
+}
+
+// GetEngagement returns the engagement of the people on the roster of ch from
+// from to to, on behalf of viewer, who must be an admin, or a staff member or
+// a researcher of ch. Researchers may only get pseudonymized engagement, which
+// leaves out those who have not consented to the latest consent form (see
+// CheckConsent).
+func (ch *Cohort) GetEngagement(ctx context.Context, db *sql.DB, viewer uid.ID, from, to time.Time, p Pseudonymization) (*CohortEngagement, error) {
+	if !p.Valid() {
+		return nil, httperr.NewBadRequest("invalid_pseudonymization", "Invalid pseudonymization.")
+	}
+	if !from.Before(to) {
+		return nil, httperr.NewBadRequest("invalid_range", "The start of the date range must be before its end.")
+	}
+	if err := ch.checkEngagementAccess(ctx, db, viewer, p); err != nil {
+		return nil, err
+	}
+
+	where := "WHERE cohort_members.cohort_id = ? AND cohort_members.role <> ?"
+	if p != PseudonymizeNone {
+		// Only those who consented are in research data.
+		where += " AND " + whereConsented("cohort_members.user_id")
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT cohort_members.user_id, users.username, cohort_members.email, cohort_members.section_id, cohort_members.role
+		FROM cohort_members
+		INNER JOIN users ON users.id = cohort_members.user_id
+		`+where+`
+		ORDER BY users.username_lc`, ch.ID, CohortRoleResearcher)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	e := &CohortEngagement{From: from, To: to, Pseudonymization: p, Members: []*MemberEngagement{}}
+	members := make(map[uid.ID]*MemberEngagement)
+	for rows.Next() {
+		m := &MemberEngagement{}
+		var sectionID uid.NullID
+		if err := rows.Scan(&m.userID, &m.Participant, &m.Email, &sectionID, &m.Role); err != nil {
+			return nil, err
+		}
+		if s := ch.sectionByID(sectionID.ID); sectionID.Valid && s != nil {
+			m.Section = s.Name
+		}
+		e.Members = append(e.Members, m)
+		members[m.userID] = m
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, c := range engagementCounts {
+		err := countByUser(ctx, db, c.query, []any{from, to, ch.ID}, func(user uid.ID, n int) {
+			if m := members[user]; m != nil {
+				*c.count(m) += n
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p != PseudonymizeNone {
+		var key []byte
+		if p == PseudonymizeStable {
+			if key, err = ch.pseudonymKey(ctx, db); err != nil {
+				return nil, err
+			}
+		} else {
+			key = make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				return nil, err
+			}
+		}
+		e.pseudonymize(key)
+	}
+	return e, nil
+}
+
+// checkEngagementAccess returns an error if user may not get the engagement
+// of ch pseudonymized as p.
+func (ch *Cohort) checkEngagementAccess(ctx context.Context, db *sql.DB, user uid.ID, p Pseudonymization) error {
+	if is, err := IsAdmin(db, &user); err != nil {
+		return err
+	} else if is {
+		return nil
+	}
+
+	var role string
+	err := db.QueryRowContext(ctx, "SELECT role FROM cohort_members WHERE cohort_id = ? AND user_id = ? AND claimed_at IS NOT NULL LIMIT 1", ch.ID, user).Scan(&role)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	switch role {
+	case CohortRoleStaff:
+		return nil
+	case CohortRoleResearcher:
+		if p == PseudonymizeNone {
+			return errEngagementIdentified
+		}
+		return nil
+	}
+	return &httperr.Error{
+		HTTPStatus: http.StatusForbidden,
+		Code:       "not_cohort_staff",
+		Message:    "Only admins, and staff and researchers of the cohort, can export its engagement.",
+	}
+}
+
+// pseudonymKey returns the key of the stable pseudonyms of the people of ch,
+// which is created if ch doesn't have one yet.
+func (ch *Cohort) pseudonymKey(ctx context.Context, db *sql.DB) ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, "UPDATE cohorts SET pseudonym_key = ? WHERE id = ? AND pseudonym_key IS NULL", key, ch.ID); err != nil {
+		return nil, err
+	}
+	// Another request might have set the key first.
+	err := db.QueryRowContext(ctx, "SELECT pseudonym_key FROM cohorts WHERE id = ?", ch.ID).Scan(&key)
+	return key, err
+}
+
+// pseudonym returns the pseudonym of user with key.
+func pseudonym(key []byte, user uid.ID) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(user.Bytes())
+	return "p" + hex.EncodeToString(mac.Sum(nil)[:6])
+}
+
+// pseudonymize replaces the usernames of the people of e with pseudonyms,
+// and removes their emails. People are then ordered by their pseudonyms, so
+// that the order doesn't give away their usernames.
+func (e *CohortEngagement) pseudonymize(key []byte) {
+	for _, m := range e.Members {
+		m.Participant = pseudonym(key, m.userID)
+		m.Email = ""
+	}
+	sort.Slice(e.Members, func(i, j int) bool {
+		return e.Members[i].Participant < e.Members[j].Participant
+	})
+}
+
+// WriteCSV writes the engagement of each person of e to w as a CSV file. If e
+// is pseudonymized, the file has no email column.
+func (e *CohortEngagement) WriteCSV(w io.Writer) error {
+	identified := e.Pseudonymization == PseudonymizeNone
+	cw := csv.NewWriter(w)
+	header := []string{"participant"}
+	if identified {
+		header = append(header, "email")
+	}
+	cw.Write(append(header, "section", "role", "posts", "comments", "votes_given", "votes_received", "bot_comments", "bot_votes"))
+	for _, m := range e.Members {
+		record := []string{m.Participant}
+		if identified {
+			record = append(record, m.Email)
+		}
+		cw.Write(append(record,
+			m.Section,
+			m.Role,
+			strconv.Itoa(m.Posts),
+			strconv.Itoa(m.Comments),
+			strconv.Itoa(m.VotesGiven),
+			strconv.Itoa(m.VotesReceived),
+			strconv.Itoa(m.BotComments),
+			strconv.Itoa(m.BotVotes),
+		))
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"time"
 
 	"github.com/discuitnet/discuit/internal/httperr"
@@ -309,9 +308,14 @@ func scanComments(ctx context.Context, db *sql.DB, rows *sql.Rows, viewer *uid.I
 	return comments, nil
 }
 
-// addComment adds a record to the comments table. It does not check if the post
-// is deleted or locked.
-func addComment(ctx context.Context, db *sql.DB, post *Post, author *User, parentID *uid.ID, commentBody string) (*Comment, error) {
+// addComment adds a record to the comments table, of a comment posted as g
+// (which is not checked). It does not check if the post is deleted or locked.
+// If removed is true, the comment is created removed by the mods of the
+// community (see Comment.autoRemove), and no notifications are sent for it. If
+// createdAt is not zero, the comment is one imported from another platform
+// (see ImportArchive): it's dated createdAt and no notifications are sent for
+// it.
+func addComment(ctx context.Context, db *sql.DB, post *Post, author *User, parentID *uid.ID, commentBody string, g UserGroup, removed bool, createdAt time.Time) (*Comment, error) {
 	commentBody = utils.TruncateUnicodeString(commentBody, maxCommentBodyLength)
 	var (
 		parent    *Comment
@@ -347,7 +351,14 @@ func addComment(ctx context.Context, db *sql.DB, post *Post, author *User, paren
 				return err
 			}
 		}
-		now := time.Now()
+		now := createdAt
+		if now.IsZero() {
+			now = time.Now()
+		}
+		deletedAt, deletedAs := msql.NullTime{}, UserGroupNaN
+		if removed {
+			deletedAt, deletedAs = msql.NewNullTime(now), UserGroupMods
+		}
 
 		query := `	INSERT INTO comments (
 						id, 
@@ -362,8 +373,11 @@ func addComment(ctx context.Context, db *sql.DB, post *Post, author *User, paren
 						ancestors,
 						body,
 						created_at,
-						community_name) 
-					VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+						community_name,
+						user_group,
+						deleted_at,
+						deleted_as) 
+					VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 		args := []any{
 			id,
 			post.ID,
@@ -378,31 +392,29 @@ func addComment(ctx context.Context, db *sql.DB, post *Post, author *User, paren
 			commentBody,
 			now,
 			post.CommunityName,
+			g,
+			deletedAt,
+			deletedAs,
 		}
 		if _, err = tx.ExecContext(ctx, query, args...); err != nil {
 			return err
 		}
 
-		if _, err = tx.ExecContext(ctx, "UPDATE posts SET no_comments = no_comments + 1, last_activity_at = ? WHERE id = ?", now, post.ID); err != nil {
+		if err = incrementPostComments(ctx, tx, post.ID, 1); err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, "UPDATE posts SET last_activity_at = ? WHERE id = ?", now, post.ID); err != nil {
 			return err
 		}
 
 		if parent != nil {
-			if _, err = tx.ExecContext(ctx, "UPDATE comments SET no_replies_direct = no_replies_direct + 1 WHERE id = ?", parent.ID); err != nil {
-				return err
-			}
-			qs := msql.InClauseQuestionMarks(len(ancestors))
-			args := make([]any, len(ancestors))
-			for i := range args {
-				args[i] = ancestors[i]
-			}
-			if _, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE comments SET no_replies = no_replies + 1 WHERE id IN %s", qs), args...); err != nil {
+			if err := incrementCommentReplies(ctx, tx, parent.ID, ancestors, 1); err != nil {
 				return err
 			}
 		}
 
 		// For the user profile.
-		if _, err := tx.ExecContext(ctx, "INSERT INTO posts_comments (target_id, user_id, target_type) VALUES (?, ?, ?)", id, author.ID, ContentTypeComment); err != nil {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO posts_comments (target_id, user_id, target_type, deleted) VALUES (?, ?, ?, ?)", id, author.ID, ContentTypeComment, removed); err != nil {
 			return err
 		}
 
@@ -412,8 +424,10 @@ func addComment(ctx context.Context, db *sql.DB, post *Post, author *User, paren
 			}
 		}
 
-		if _, err := tx.ExecContext(ctx, "UPDATE users SET no_comments = no_comments + 1 WHERE id = ?", author.ID); err != nil {
-			return err
+		if !removed {
+			if err := incrementUserComments(ctx, tx, author.ID, 1); err != nil {
+				return err
+			}
 		}
 
 		return nil
@@ -424,21 +438,10 @@ func addComment(ctx context.Context, db *sql.DB, post *Post, author *User, paren
 	}
 
 	// Send notifications.
-	if parent != nil && !parent.AuthorID.EqualsTo(author.ID) {
-		go func() {
-			if err := CreateCommentReplyNotification(context.Background(), db, parent.AuthorID, parent.ID, id, author, post); err != nil {
-				log.Printf("Create reply notification failed: %v\n", err)
-			}
-		}()
-
-	}
-	if !post.AuthorID.EqualsTo(author.ID) && (parent == nil || !(parent.AuthorID.EqualsTo(post.AuthorID))) {
-		go func() {
-			if err := CreateNewCommentNotification(context.Background(), db, post, id, author); err != nil {
-				log.Printf("Create new_comment notification failed: %v\n", err)
-			}
-		}()
+	if imported := !createdAt.IsZero(); !imported && !removed {
+		queueCommentNotifications(db, post, parent, id, author)
 	}
+	queueSearchIndex(ctx, db, SearchKindComments, id)
 
 	return GetComment(ctx, db, id, nil)
 }
@@ -451,17 +454,30 @@ func (c *Comment) Save(ctx context.Context, db *sql.DB, user uid.ID) error {
 	if !c.AuthorID.EqualsTo(user) {
 		return errNotAuthor
 	}
+	if err := checkCommunityNotArchived(ctx, db, c.CommunityID); err != nil {
+		return err
+	}
+
+	remove, err := filterContent(ctx, db, c.CommunityID, &c.Body)
+	if err != nil {
+		return err
+	}
 
 	c.Body = utils.TruncateUnicodeString(c.Body, maxCommentBodyLength)
 
 	now := time.Now()
 	query := "UPDATE comments SET body = ?, edited_at = ? WHERE id = ? AND deleted_at IS NULL"
-	_, err := db.ExecContext(ctx, query, c.Body, now, c.ID)
-	if err == nil {
-		c.EditedAt.Valid = true
-		c.EditedAt.Time = now
+	if _, err := db.ExecContext(ctx, query, c.Body, now, c.ID); err != nil {
+		return err
 	}
-	return err
+	c.EditedAt.Valid = true
+	c.EditedAt.Time = now
+	queueSearchIndex(ctx, db, SearchKindComments, c.ID)
+
+	if remove {
+		return c.autoRemove(ctx, db)
+	}
+	return nil
 }
 
 // Delete returns an error if user, who's deleting the comment, has no
@@ -516,7 +532,7 @@ func (c *Comment) Delete(ctx context.Context, db *sql.DB, user uid.ID, g UserGro
 				return err
 			}
 		}
-		if _, err := tx.ExecContext(ctx, "UPDATE users SET no_comments = no_comments - 1 WHERE id = ?", c.AuthorID); err != nil {
+		if err := incrementUserComments(ctx, tx, c.AuthorID, -1); err != nil {
 			return err
 		}
 		return nil
@@ -530,6 +546,7 @@ func (c *Comment) Delete(ctx context.Context, db *sql.DB, user uid.ID, g UserGro
 	c.DeletedAs = g
 	c.StripContent()
 	RemoveAllReportsOfComment(ctx, db, c.ID)
+	queueSearchIndex(ctx, db, SearchKindComments, c.ID)
 	return err
 }
 
@@ -573,6 +590,9 @@ func (c *Comment) StripContent() {
 
 // Vote votes on comment (if the comment is not deleted or the post locked).
 func (c *Comment) Vote(ctx context.Context, db *sql.DB, user uid.ID, up bool) error {
+	if err := checkCommunityNotArchived(ctx, db, c.CommunityID); err != nil {
+		return err
+	}
 	if c.Deleted {
 		return errCommentDeleted
 	}
@@ -583,7 +603,7 @@ func (c *Comment) Vote(ctx context.Context, db *sql.DB, user uid.ID, up bool) er
 		return errPostLocked
 	}
 
-	point := 1
+	vc := newVoteChange(nil, &up, c.AuthorID.EqualsTo(user))
 	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
 		if _, err := tx.ExecContext(ctx, "INSERT INTO comment_votes (comment_id, user_id, up) VALUES (?, ?, ?)", c.ID, user, up); err != nil {
 			if msql.IsErrDuplicateErr(err) {
@@ -591,45 +611,20 @@ func (c *Comment) Vote(ctx context.Context, db *sql.DB, user uid.ID, up bool) er
 			}
 			return err
 		}
-		query := "UPDATE comments SET points = points + ?"
-		if up {
-			query += ", upvotes = upvotes + 1"
-		} else {
-			point = -1
-			query += ", downvotes = downvotes + 1"
-		}
-		query += " WHERE id = ?"
-		if _, err := tx.ExecContext(ctx, query, point, c.ID); err != nil {
-			return err
-		}
-		return nil
+		return vc.apply(ctx, tx, "comments", c.ID, c.AuthorID)
 	})
 	if err != nil {
 		return err
 	}
 
-	if up {
-		c.Upvotes++
-	} else {
-		c.Downvotes++
-	}
-	c.Points += point
+	c.applyVoteChange(vc)
 	c.ViewerVoted = msql.NewNullBool(true)
 	c.ViewerVotedUp.Valid = true
 	c.ViewerVotedUp.Bool = up
 
-	// Attempt to update user's points.
-	if up && !c.AuthorID.EqualsTo(user) {
-		incrementUserPoints(ctx, db, c.AuthorID, 1)
-	}
-
 	// Attempt to create a notification (only for upvotes).
 	if !c.AuthorID.EqualsTo(user) && up {
-		go func() {
-			if err := CreateNewVotesNotification(context.Background(), db, c.AuthorID, c.CommunityName, false, c.ID); err != nil {
-				log.Printf("Failed creating new_votes notification: %v\n", err)
-			}
-		}()
+		queueNewVotesNotification(db, c.AuthorID, false, c.ID)
 	}
 
 	return nil
@@ -637,6 +632,9 @@ func (c *Comment) Vote(ctx context.Context, db *sql.DB, user uid.ID, up bool) er
 
 // DeleteVote returns an error is the comment is deleted or the post locked.
 func (c *Comment) DeleteVote(ctx context.Context, db *sql.DB, user uid.ID) error {
+	if err := checkCommunityNotArchived(ctx, db, c.CommunityID); err != nil {
+		return err
+	}
 	if c.Deleted {
 		return errCommentDeleted
 	}
@@ -654,47 +652,37 @@ func (c *Comment) DeleteVote(ctx context.Context, db *sql.DB, user uid.ID) error
 		return err
 	}
 
-	point := 1
+	vc := newVoteChange(&up, nil, c.AuthorID.EqualsTo(user))
+	applied := false
 	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
-		if _, err := tx.ExecContext(ctx, "DELETE FROM comment_votes WHERE id = ?", id); err != nil {
+		res, err := tx.ExecContext(ctx, "DELETE FROM comment_votes WHERE id = ?", id)
+		if err != nil {
 			return err
 		}
-		query := "UPDATE comments SET points = points + ?"
-		if up {
-			point = -1
-			query += ", upvotes = upvotes - 1"
-		} else {
-			query += ", downvotes = downvotes - 1"
-		}
-		query += " WHERE id = ?"
-		if _, err := tx.ExecContext(ctx, query, point, c.ID); err != nil {
-			return err
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err // deleted concurrently
 		}
-		return nil
+		applied = true
+		return vc.apply(ctx, tx, "comments", c.ID, c.AuthorID)
 	})
 	if err != nil {
 		return err
 	}
 
-	if up {
-		c.Upvotes--
-	} else {
-		c.Downvotes--
+	if applied {
+		c.applyVoteChange(vc)
 	}
-	c.Points += point
 	c.ViewerVoted.Valid = false
 	c.ViewerVotedUp.Valid = false
 
-	// Attempt to update user's points.
-	if up && !c.AuthorID.EqualsTo(user) {
-		incrementUserPoints(ctx, db, c.AuthorID, -1)
-	}
-
 	return nil
 }
 
 // ChangeVote returns an error is the comment is deleted or the post locked.
 func (c *Comment) ChangeVote(ctx context.Context, db *sql.DB, user uid.ID, up bool) error {
+	if err := checkCommunityNotArchived(ctx, db, c.CommunityID); err != nil {
+		return err
+	}
 	if c.Deleted {
 		return errCommentDeleted
 	}
@@ -716,50 +704,37 @@ func (c *Comment) ChangeVote(ctx context.Context, db *sql.DB, user uid.ID, up bo
 		return nil
 	}
 
-	points := 2
+	vc := newVoteChange(&dbUp, &up, c.AuthorID.EqualsTo(user))
+	applied := false
 	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
-		if _, err := tx.ExecContext(ctx, "UPDATE comment_votes SET up = ? WHERE id = ?", up, id); err != nil {
+		res, err := tx.ExecContext(ctx, "UPDATE comment_votes SET up = ? WHERE id = ? AND up = ?", up, id, dbUp)
+		if err != nil {
 			return err
 		}
-		query := "UPDATE comments SET points = points + ?"
-		if dbUp {
-			points = -2
-			query += ", upvotes = upvotes - 1, downvotes = downvotes + 1"
-		} else {
-			query += ", upvotes = upvotes + 1, downvotes = downvotes - 1"
-		}
-		query += " WHERE id = ?"
-		if _, err := tx.ExecContext(ctx, query, points, c.ID); err != nil {
-			return err
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err // changed concurrently
 		}
-		return nil
+		applied = true
+		return vc.apply(ctx, tx, "comments", c.ID, c.AuthorID)
 	})
 	if err != nil {
 		return err
 	}
 
-	if dbUp {
-		c.Upvotes--
-		c.Downvotes++
-	} else {
-		c.Upvotes++
-		c.Downvotes--
+	if applied {
+		c.applyVoteChange(vc)
 	}
-	c.Points += points
 	c.ViewerVotedUp = msql.NewNullBool(up)
 
-	// Attemp to update user's points.
-	if !c.AuthorID.EqualsTo(user) {
-		points := 1
-		if dbUp {
-			points = -1
-		}
-		incrementUserPoints(ctx, db, c.AuthorID, points)
-	}
-
 	return nil
 }
 
+func (c *Comment) applyVoteChange(vc voteChange) {
+	c.Upvotes += vc.upvotes
+	c.Downvotes += vc.downvotes
+	c.Points += vc.points
+}
+
 // ChangeUserGroup changes the capacity in which the comment's author submitted the
 // comment.
 func (c *Comment) ChangeUserGroup(ctx context.Context, db *sql.DB, user uid.ID, g UserGroup) error {
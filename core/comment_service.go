@@ -0,0 +1,144 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/logging"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// All new comments, whoever creates them (users through the API, bots, the
+// bench command), are created by the CommentService of the site (see
+// Comments), in the same way as posts are by the PostService. Comments
+// imported from other platforms are the exception (see ImportArchive).
+//
+// The checks of the post and of the community (locks, archives, bans, and so
+// on), content filters, the counters of the post and the user, and reply
+// notifications are all part of saving the comment; the hooks of the service
+// run after.
+
+// NewComment is a comment to be created by a CommentService.
+type NewComment struct {
+	Post   *Post
+	Author uid.ID
+	Parent *uid.ID // If the comment is a reply.
+	Body   string
+
+	// The group the comment is made as (see Comment.ChangeUserGroup). If it's
+	// UserGroupNaN, it's UserGroupNormal.
+	UserGroup UserGroup
+
+	// If true, the rate limits of the service are not applied.
+	SkipRateLimits bool
+}
+
+// A CommentHook is run for every comment created by a CommentService, after
+// it's created. Implementations must be safe for concurrent use.
+type CommentHook interface {
+	Name() string // The identifier of the hook.
+
+	// CommentCreated is called with the new comment, and the post it was made
+	// on. An error returned by it is logged; the comment is created
+	// regardless.
+	CommentCreated(ctx context.Context, db *sql.DB, post *Post, comment *Comment) error
+}
+
+// CommentService creates comments.
+type CommentService struct {
+	mu      sync.RWMutex
+	limiter RateLimiter // If nil, there are no rate limits.
+	hooks   []CommentHook
+}
+
+var comments = &CommentService{hooks: []CommentHook{botResponseCommentHook{}}}
+
+// Comments returns the CommentService of the site.
+func Comments() *CommentService {
+	return comments
+}
+
+// SetRateLimiter sets the rate limiter of the comments created by s. If l is
+// nil (the default), comments are not rate limited.
+func (s *CommentService) SetRateLimiter(l RateLimiter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limiter = l
+}
+
+// AddHook adds hook to the hooks run for new comments, after those added
+// before it.
+func (s *CommentService) AddHook(hook CommentHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, hook)
+}
+
+// commentRateLimits are the rate limits of the comments of each user.
+var commentRateLimits = []struct {
+	bucket    string
+	interval  time.Duration
+	maxTokens int
+}{
+	{"add_comment_1_", time.Second * 5, 2},
+	{"add_comment_2_", time.Hour * 24, 300},
+}
+
+// Create creates the comment nc. The comment is upvoted by its author, as
+// comments are.
+func (s *CommentService) Create(ctx context.Context, db *sql.DB, nc *NewComment) (*Comment, error) {
+	s.mu.RLock()
+	limiter, hooks := s.limiter, s.hooks
+	s.mu.RUnlock()
+
+	if nc.Post == nil {
+		return nil, httperr.NewBadRequest("no-post", "No post.")
+	}
+	if limiter != nil && !nc.SkipRateLimits {
+		for _, limit := range commentRateLimits {
+			if ok, err := limiter.Limit(ctx, limit.bucket+nc.Author.String(), limit.interval, limit.maxTokens); err != nil {
+				return nil, err
+			} else if !ok {
+				return nil, errTooManyRequests
+			}
+		}
+	}
+
+	g := nc.UserGroup
+	if g == UserGroupNaN {
+		g = UserGroupNormal
+	}
+	comment, err := createComment(ctx, db, nc.Post, nc.Author, g, nc.Parent, nc.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := comment.Vote(ctx, db, nc.Author, true); err != nil {
+		logging.FromContext(ctx).Error("Error upvoting comment by its author", "comment", comment.ID, "error", err)
+	}
+
+	for _, hook := range hooks {
+		if err := hook.CommentCreated(ctx, db, nc.Post, comment); err != nil {
+			logging.FromContext(ctx).Error("Error running comment hook", "hook", hook.Name(), "comment", comment.ID, "error", err)
+		}
+	}
+	return comment, nil
+}
+
+// botResponseCommentHook queues responses of bots to the comments of users.
+type botResponseCommentHook struct{}
+
+func (botResponseCommentHook) Name() string { return "bot_response" }
+
+func (botResponseCommentHook) CommentCreated(ctx context.Context, db *sql.DB, post *Post, comment *Comment) error {
+	isBot, err := IsUserBot(ctx, db, comment.AuthorID)
+	if err != nil {
+		return err
+	}
+	if !isBot {
+		QueueBotResponseToComment(db, comment)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestCommentServiceLimits(t *testing.T) {
+	ctx := context.Background()
+	author := uid.New()
+
+	limiter := &denyingLimiter{}
+	s := &CommentService{limiter: limiter}
+	if _, err := s.Create(ctx, nil, &NewComment{Post: &Post{}, Author: author}); err != errTooManyRequests {
+		t.Errorf("got error %v, want too many requests", err)
+	}
+	if len(limiter.buckets) != 1 || limiter.buckets[0] != "add_comment_1_"+author.String() {
+		t.Errorf("got buckets %v", limiter.buckets)
+	}
+
+	if _, err := s.Create(ctx, nil, &NewComment{Post: &Post{Locked: true}, Author: author, SkipRateLimits: true}); err != errPostLocked {
+		t.Errorf("got error %v, want the post locked", err)
+	}
+}
+
+// recordingCommentHook is a CommentHook that records the comments it's run
+// for.
+type recordingCommentHook struct {
+	comments []uid.ID
+}
+
+func (h *recordingCommentHook) Name() string { return "recording" }
+
+func (h *recordingCommentHook) CommentCreated(ctx context.Context, db *sql.DB, post *Post, comment *Comment) error {
+	h.comments = append(h.comments, comment.ID)
+	return nil
+}
+
+func TestCommentServiceCreate(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+	author := h.newUser(db, "commenter", false)
+	comm, err := CreateCommunity(h.ctx, db, author.ID, 0, 100, "commenting", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	post, err := Posts().Create(h.ctx, db, &NewPost{
+		Author:    author.ID,
+		Community: comm.ID,
+		Title:     "A post",
+		Content:   &TextContent{Body: "The body of the post."},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook := &recordingCommentHook{}
+	s := &CommentService{}
+	s.AddHook(hook)
+	comment, err := s.Create(h.ctx, db, &NewComment{Post: post, Author: author.ID, Body: "A comment."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := s.Create(h.ctx, db, &NewComment{Post: post, Author: author.ID, Parent: &comment.ID, Body: "A reply."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hook.comments) != 2 || hook.comments[0] != comment.ID || hook.comments[1] != reply.ID {
+		t.Errorf("the hook was run for %v, want [%v %v]", hook.comments, comment.ID, reply.ID)
+	}
+
+	got, err := GetComment(h.ctx, db, comment.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Upvotes != 1 || got.NumReplies != 1 {
+		t.Errorf("got %d upvotes and %d replies, want 1 and 1", got.Upvotes, got.NumReplies)
+	}
+	gotPost, err := GetPost(h.ctx, db, &post.ID, "", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotPost.NumComments != 2 {
+		t.Errorf("the post has %d comments, want 2", gotPost.NumComments)
+	}
+}
+
+func TestCommentServiceCreateRemoved(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+	author := h.newUser(db, "remover", false)
+	post := h.newPost(db, author, "removing")
+	if _, err := AddFilterWord(h.ctx, db, author.ID, &post.CommunityID, "zeppelin", FilterActionRemove); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &CommentService{}
+	comment, err := s.Create(h.ctx, db, &NewComment{Post: post, Author: author.ID, UserGroup: UserGroupMods, Body: "A zeppelin."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := GetComment(h.ctx, db, comment.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Deleted || got.DeletedAs != UserGroupMods || got.PostedAs != UserGroupMods {
+		t.Errorf("got deleted %v as %v, posted as %v; want deleted as mods, posted as mods", got.Deleted, got.DeletedAs, got.PostedAs)
+	}
+	u, err := GetUser(h.ctx, db, author.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.NumComments != 0 {
+		t.Errorf("the author has %d comments, want 0", u.NumComments)
+	}
+}
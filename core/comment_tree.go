@@ -0,0 +1,93 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Comment trees can be rendered to a limited depth, so that very deep threads
+// don't make for huge responses. Where replies are cut off, a continuation is
+// returned, whose token can be passed to Post.GetCommentSubtree to get the
+// rest of the thread.
+
+// CommentContinuation marks a comment whose replies were cut off by a depth
+// limit.
+type CommentContinuation struct {
+	CommentID  uid.ID `json:"commentId"`
+	NumReplies int    `json:"noReplies"` // Number of replies cut off.
+	Token      string `json:"token"`
+}
+
+var errInvalidContinuationToken = httperr.NewBadRequest("invalid_continuation_token", "Invalid continuation token.")
+
+// LimitCommentDepth removes from comments those that are maxDepth or more
+// levels below baseDepth (the depth of the top-most comments being rendered),
+// and returns the remaining comments, in the same order, along with the
+// continuations of the deepest remaining comments whose replies were removed.
+// If maxDepth is not positive, comments are returned as is.
+func LimitCommentDepth(comments []*Comment, baseDepth, maxDepth int) ([]*Comment, []*CommentContinuation) {
+	if maxDepth <= 0 {
+		return comments, nil
+	}
+	cutDepth := baseDepth + maxDepth - 1 // depth of the deepest comments kept
+
+	var (
+		kept   []*Comment
+		counts = make(map[uid.ID]int)
+		order  []uid.ID
+	)
+	for _, c := range comments {
+		if c.Depth <= cutDepth {
+			kept = append(kept, c)
+			continue
+		}
+		if cutDepth >= len(c.Ancestors) {
+			continue // malformed ancestors
+		}
+		parent := c.Ancestors[cutDepth]
+		if _, ok := counts[parent]; !ok {
+			order = append(order, parent)
+		}
+		counts[parent]++
+	}
+
+	conts := make([]*CommentContinuation, len(order))
+	for i, id := range order {
+		conts[i] = &CommentContinuation{
+			CommentID:  id,
+			NumReplies: counts[id],
+			Token:      id.String(),
+		}
+	}
+	return kept, conts
+}
+
+// GetCommentSubtree returns the replies (all the way down) of the comment of
+// the continuation with token, limited to maxDepth levels below it (see
+// LimitCommentDepth).
+func (p *Post) GetCommentSubtree(ctx context.Context, db *sql.DB, viewer *uid.ID, token string, maxDepth int) ([]*Comment, []*CommentContinuation, error) {
+	id, err := uid.FromString(token)
+	if err != nil {
+		return nil, nil, errInvalidContinuationToken
+	}
+	comment, err := GetComment(ctx, db, id, viewer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !comment.PostID.EqualsTo(p.ID) {
+		return nil, nil, errInvalidContinuationToken
+	}
+
+	replies, err := p.GetCommentReplies(ctx, db, viewer, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	comments, conts := LimitCommentDepth(replies, comment.Depth+1, maxDepth)
+	if comments == nil {
+		comments = []*Comment{}
+	}
+	return comments, conts, nil
+}
@@ -0,0 +1,38 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestLimitCommentDepth(t *testing.T) {
+	// A chain a > b > c > d, and e, a sibling of c.
+	a := &Comment{ID: uid.New(), Depth: 0}
+	b := &Comment{ID: uid.New(), Depth: 1, Ancestors: []uid.ID{a.ID}}
+	c := &Comment{ID: uid.New(), Depth: 2, Ancestors: []uid.ID{a.ID, b.ID}}
+	d := &Comment{ID: uid.New(), Depth: 3, Ancestors: []uid.ID{a.ID, b.ID, c.ID}}
+	e := &Comment{ID: uid.New(), Depth: 2, Ancestors: []uid.ID{a.ID, b.ID}}
+	all := []*Comment{a, b, c, d, e}
+
+	if kept, conts := LimitCommentDepth(all, 0, 0); len(kept) != 5 || conts != nil {
+		t.Fatalf("no limit: expected all comments and no continuations, got %d and %v", len(kept), conts)
+	}
+
+	kept, conts := LimitCommentDepth(all, 0, 2)
+	if len(kept) != 2 || kept[0] != a || kept[1] != b {
+		t.Fatalf("expected a and b to be kept, got %d comments", len(kept))
+	}
+	if len(conts) != 1 || conts[0].CommentID != b.ID || conts[0].NumReplies != 3 || conts[0].Token != b.ID.String() {
+		t.Fatalf("expected one continuation at b with 3 replies, got %+v", conts)
+	}
+
+	// Subtree of b.
+	kept, conts = LimitCommentDepth([]*Comment{c, d, e}, 2, 1)
+	if len(kept) != 2 || kept[0] != c || kept[1] != e {
+		t.Fatalf("expected c and e to be kept, got %d comments", len(kept))
+	}
+	if len(conts) != 1 || conts[0].CommentID != c.ID || conts[0].NumReplies != 1 {
+		t.Fatalf("expected one continuation at c with 1 reply, got %+v", conts)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"slices"
@@ -22,20 +23,29 @@ import (
 const maxCommunityAboutLength = 2000 // in runes
 
 type Community struct {
-	ID                uid.ID          `json:"id"`
-	AuthorID          uid.ID          `json:"userId"`
-	Name              string          `json:"name"`
-	NameLowerCase     string          `json:"-"` // TODO: Remove this field (only from this struct, not also from the database).
-	NSFW              bool            `json:"nsfw"`
-	About             msql.NullString `json:"about"`
-	NumMembers        int             `json:"noMembers"`
-	PostsCount        int             `json:"-"` // Including deleted posts
-	ProPic            *images.Image   `json:"proPic"`
-	BannerImage       *images.Image   `json:"bannerImage"`
-	PostingRestricted bool            `json:"postingRestricted"` // If true only mods can post.
-	CreatedAt         time.Time       `json:"createdAt"`
-	DeletedAt         msql.NullTime   `json:"deletedAt"`
-	DeletedBy         uid.NullID      `json:"-"`
+	ID                uid.ID           `json:"id"`
+	AuthorID          uid.ID           `json:"userId"`
+	Name              string           `json:"name"`
+	NameLowerCase     string           `json:"-"` // TODO: Remove this field (only from this struct, not also from the database).
+	NSFW              bool             `json:"nsfw"`
+	AgeRestricted     bool             `json:"ageRestricted"` // 18+; see CheckAgeRestriction.
+	Private           bool             `json:"private"`       // See CheckCommunityAccess.
+	About             msql.NullString  `json:"about"`
+	NumMembers        int              `json:"noMembers"`
+	PostsCount        int              `json:"-"` // Including deleted posts
+	ProPic            *images.Image    `json:"proPic"`
+	BannerImage       *images.Image    `json:"bannerImage"`
+	PostingRestricted bool             `json:"postingRestricted"` // If true only mods can post.
+	PostCooldown      int              `json:"postCooldown"`      // Min seconds between the posts of a user.
+	CommentCooldown   int              `json:"commentCooldown"`   // Min seconds between the comments of a user.
+	QuarantinedAt     msql.NullTime    `json:"quarantinedAt"`     // See Community.Quarantine.
+	QuarantineReason  msql.NullString  `json:"quarantineReason"`
+	ArchivedAt        msql.NullTime    `json:"archivedAt"` // See Community.Archive.
+	PurgeAfter        msql.NullTime    `json:"purgeAfter"` // When the archived community is to be purged.
+	Freeze            *CommunityFreeze `json:"freeze"`     // The current or next freeze, if any.
+	CreatedAt         time.Time        `json:"createdAt"`
+	DeletedAt         msql.NullTime    `json:"deletedAt"`
+	DeletedBy         uid.NullID       `json:"-"`
 
 	// IsDefault is nil until Default is called.
 	IsDefault *bool `json:"isDefault,omitempty"`
@@ -44,6 +54,9 @@ type Community struct {
 	ViewerMod     msql.NullBool `json:"userMod"`
 	MutedByViewer bool          `json:"isMuted"`
 
+	// Null unless the community is quarantined.
+	ViewerAcknowledgedQuarantine msql.NullBool `json:"userAcknowledgedQuarantine"`
+
 	Mods           []*User                  `json:"mods"`
 	Rules          []*CommunityRule         `json:"rules"`
 	ReportsDetails *CommunityReportsDetails `json:"ReportsDetails"`
@@ -56,10 +69,18 @@ func buildSelectCommunityQuery(where string) string {
 		"communities.name",
 		"communities.name_lc",
 		"communities.nsfw",
+		"communities.age_restricted",
+		"communities.private",
 		"communities.about",
 		"communities.no_members",
 		"communities.posts_count",
 		"communities.posting_restricted",
+		"communities.post_cooldown",
+		"communities.comment_cooldown",
+		"communities.quarantined_at",
+		"communities.quarantine_reason",
+		"communities.archived_at",
+		"communities.purge_after",
 		"communities.created_at",
 		"communities.deleted_at",
 	}
@@ -165,10 +186,18 @@ func scanCommunities(ctx context.Context, db *sql.DB, rows *sql.Rows, viewer *ui
 			&c.Name,
 			&c.NameLowerCase,
 			&c.NSFW,
+			&c.AgeRestricted,
+			&c.Private,
 			&c.About,
 			&c.NumMembers,
 			&c.PostsCount,
 			&c.PostingRestricted,
+			&c.PostCooldown,
+			&c.CommentCooldown,
+			&c.QuarantinedAt,
+			&c.QuarantineReason,
+			&c.ArchivedAt,
+			&c.PurgeAfter,
 			&c.CreatedAt,
 			&c.DeletedAt,
 		}
@@ -198,6 +227,10 @@ func scanCommunities(ctx context.Context, db *sql.DB, rows *sql.Rows, viewer *ui
 		return nil, err
 	}
 
+	if err := populateFreezes(ctx, db, comms); err != nil {
+		return nil, err
+	}
+
 	if viewer != nil {
 		mutes, err := GetMutedCommunities(ctx, db, *viewer, false)
 		if err != nil {
@@ -232,6 +265,9 @@ func CreateCommunity(ctx context.Context, db *sql.DB, creator uid.ID, reqPoints,
 	if err := IsUsernameValid(name); err != nil {
 		return nil, httperr.NewBadRequest("invalid-community-name", fmt.Sprintf("Community name invalid. It %s.", err.Error()))
 	}
+	if err := checkNameFilter(ctx, db, name); err != nil {
+		return nil, err
+	}
 
 	user, err := GetUser(ctx, db, creator, nil)
 	if err != nil {
@@ -328,7 +364,9 @@ const (
 	CommunitiesSetSubscribed = "subscribed"
 )
 
-// GetCommunities returns a maximum of n communities.
+// GetCommunities returns a maximum of n communities. Age-restricted
+// communities are left out unless viewer (nil if not logged in) can view them,
+// and private communities unless viewer is a member of them.
 func GetCommunities(ctx context.Context, db *sql.DB, sort CommunitiesSort, set string, n int, viewer *uid.ID) ([]*Community, error) {
 	if !slices.Contains([]string{CommunitiesSetAll, CommunitiesSetDefault, CommunitiesSetSubscribed}, set) {
 		return nil, httperr.NewBadRequest("invalid-set", "Invalid community set options.")
@@ -336,6 +374,17 @@ func GetCommunities(ctx context.Context, db *sql.DB, sort CommunitiesSort, set s
 
 	var args []any
 	where := "WHERE communities.deleted_at IS NULL "
+	if confirmed, err := viewerAgeConfirmed(ctx, db, viewer); err != nil {
+		return nil, err
+	} else if !confirmed {
+		where += "AND communities.age_restricted = FALSE "
+	}
+	if viewer == nil {
+		where += "AND communities.private = FALSE "
+	} else {
+		where += "AND (communities.private = FALSE OR communities.id IN (SELECT community_id FROM community_members WHERE user_id = ?)) "
+		args = append(args, *viewer)
+	}
 	if set == CommunitiesSetDefault {
 		where += "AND communities.id IN (SELECT community_id FROM default_communities) "
 	} else if set == CommunitiesSetSubscribed {
@@ -371,10 +420,18 @@ func GetCommunities(ctx context.Context, db *sql.DB, sort CommunitiesSort, set s
 	return scanCommunities(ctx, db, rows, viewer)
 }
 
-// GetCommunitiesPrefix returns all communities with name prefix s sorted by created at.
-func GetCommunitiesPrefix(ctx context.Context, db *sql.DB, s string) ([]*Community, error) {
+// GetCommunitiesPrefix returns all communities with name prefix s sorted by
+// created at. Age-restricted communities are left out unless viewer (nil if
+// not logged in) can view them. Private communities are always left out.
+func GetCommunitiesPrefix(ctx context.Context, db *sql.DB, s string, viewer *uid.ID) ([]*Community, error) {
 	const limit = 10
-	query := buildSelectCommunityQuery("WHERE communities.name LIKE ? AND communities.deleted_at IS NULL LIMIT ?")
+	where := "communities.quarantined_at IS NULL AND communities.private = FALSE "
+	if confirmed, err := viewerAgeConfirmed(ctx, db, viewer); err != nil {
+		return nil, err
+	} else if !confirmed {
+		where += "AND communities.age_restricted = FALSE "
+	}
+	query := buildSelectCommunityQuery("WHERE communities.name LIKE ? AND communities.deleted_at IS NULL AND " + where + "LIMIT ?")
 	rows, err := db.QueryContext(ctx, query, "%"+s+"%", limit)
 	if err != nil {
 		return nil, err
@@ -384,7 +441,7 @@ func GetCommunitiesPrefix(ctx context.Context, db *sql.DB, s string) ([]*Communi
 		return nil, err
 	}
 
-	query = buildSelectCommunityQuery("WHERE communities.name = ?")
+	query = buildSelectCommunityQuery("WHERE communities.name = ? AND " + where)
 	rows, err = db.QueryContext(ctx, query, s)
 	if err != nil {
 		return nil, err
@@ -417,17 +474,30 @@ func GetCommunitiesPrefix(ctx context.Context, db *sql.DB, s string) ([]*Communi
 
 // Update updates the updatable fields of the community. These are:
 //   - NSFW
+//   - AgeRestricted
 //   - About
 //   - PostingRestricted
+//   - PostCooldown
+//   - CommentCooldown
 func (c *Community) Update(ctx context.Context, db *sql.DB, mod uid.ID) error {
 	if is, err := c.UserModOrAdmin(ctx, db, mod); err != nil {
 		return err
 	} else if !is {
 		return errNotMod
 	}
+	if err := validateCooldown(c.PostCooldown); err != nil {
+		return err
+	}
+	if err := validateCooldown(c.CommentCooldown); err != nil {
+		return err
+	}
 
 	c.About.String = utils.TruncateUnicodeString(c.About.String, maxCommunityAboutLength)
-	_, err := db.ExecContext(ctx, "UPDATE communities SET nsfw = ?, about = ?, posting_restricted = ? WHERE id = ?", c.NSFW, c.About, c.PostingRestricted, c.ID)
+	_, err := db.ExecContext(ctx, "UPDATE communities SET nsfw = ?, age_restricted = ?, about = ?, posting_restricted = ?, post_cooldown = ?, comment_cooldown = ? WHERE id = ?", c.NSFW, c.AgeRestricted, c.About, c.PostingRestricted, c.PostCooldown, c.CommentCooldown, c.ID)
+	if err == nil {
+		// Whether the community is age-restricted changes the site-wide feeds.
+		invalidateSiteFeeds(ctx)
+	}
 	return err
 }
 
@@ -462,7 +532,21 @@ func (c *Community) SetDefault(ctx context.Context, db *sql.DB, set bool) error
 	return err
 }
 
+// Join makes user a member of c. If c is quarantined, user must have
+// acknowledged the quarantine.
 func (c *Community) Join(ctx context.Context, db *sql.DB, user uid.ID) error {
+	if c.Private {
+		return errJoinPrivate
+	}
+	if ok, err := c.QuarantineAcknowledged(ctx, db, user); err != nil {
+		return err
+	} else if !ok {
+		return errQuarantineNotAcknowledged
+	}
+	return c.join(ctx, db, user)
+}
+
+func (c *Community) join(ctx context.Context, db *sql.DB, user uid.ID) error {
 	// Get the latest member count
 	var count int
 	err := db.QueryRowContext(ctx, "SELECT no_members FROM communities WHERE id = ?", c.ID).Scan(&count)
@@ -470,7 +554,9 @@ func (c *Community) Join(ctx context.Context, db *sql.DB, user uid.ID) error {
 		return err
 	}
 
-	if count >= 11 {
+	// The members of private communities are set by admins (see
+	// ImportRoster), so they're not limited.
+	if count >= 11 && !c.Private {
 		return httperr.NewForbidden("member-limit-reached", "This community has reached its maximum member limit of 11.")
 	}
 
@@ -481,10 +567,7 @@ func (c *Community) Join(ctx context.Context, db *sql.DB, user uid.ID) error {
 			}
 			return err
 		}
-		if _, err := tx.ExecContext(ctx, "UPDATE communities SET no_members = no_members + 1 WHERE id = ?", c.ID); err != nil {
-			return err
-		}
-		return nil
+		return incrementCommunityMembers(ctx, tx, 1, c.ID)
 	})
 	if err != nil {
 		return err
@@ -494,26 +577,36 @@ func (c *Community) Join(ctx context.Context, db *sql.DB, user uid.ID) error {
 }
 
 func (c *Community) Leave(ctx context.Context, db *sql.DB, user uid.ID) error {
+	left := false
 	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
-		if _, err := tx.ExecContext(ctx, "DELETE FROM community_members WHERE community_id = ? AND user_id = ?", c.ID, user); err != nil {
+		res, err := tx.ExecContext(ctx, "DELETE FROM community_members WHERE community_id = ? AND user_id = ?", c.ID, user)
+		if err != nil {
 			return err
 		}
 		if _, err := tx.ExecContext(ctx, "DELETE FROM community_mods WHERE community_id = ? AND user_id = ?", c.ID, user); err != nil {
 			return err
 		}
-		if _, err := tx.ExecContext(ctx, "UPDATE communities SET no_members = no_members - 1 WHERE id = ?", c.ID); err != nil {
-			return err
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err // not a member
 		}
-		return nil
+		left = true
+		return incrementCommunityMembers(ctx, tx, -1, c.ID)
 	})
 	if err != nil {
 		return err
 	}
-	c.NumMembers--
+	if left {
+		c.NumMembers--
+	}
 	return nil
 }
 
-func (c *Community) UpdateProPic(ctx context.Context, db *sql.DB, image []byte, s3Enabled bool) error {
+// UpdateProPic sets the profile picture of c to image, cropped to crop if
+// it's not nil.
+func (c *Community) UpdateProPic(ctx context.Context, db *sql.DB, image io.Reader, crop *images.CropRect, s3Enabled bool) error {
+	if c.Quarantined() {
+		return errQuarantinedNoImages
+	}
 	var newImageID uid.ID
 	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
 		if err := c.DeleteProPicTx(ctx, db, tx); err != nil {
@@ -525,6 +618,8 @@ func (c *Community) UpdateProPic(ctx context.Context, db *sql.DB, image []byte,
 			Height: 2000,
 			Format: images.ImageFormatJPEG,
 			Fit:    images.ImageFitContain,
+			Quota:  []images.QuotaOwner{{Type: images.QuotaOwnerCommunity, ID: c.ID}},
+			Crop:   crop,
 		})
 		if err != nil {
 			return fmt.Errorf("fail to save community profile picture: %w", err)
@@ -538,6 +633,7 @@ func (c *Community) UpdateProPic(ctx context.Context, db *sql.DB, image []byte,
 	if err != nil {
 		return err
 	}
+	images.QueuePostProcessing(newImageID)
 
 	record, err := images.GetImageRecord(ctx, db, newImageID)
 	if err != nil {
@@ -580,7 +676,12 @@ func (c *Community) DeleteProPicTx(ctx context.Context, db *sql.DB, tx *sql.Tx)
 	return nil
 }
 
-func (c *Community) UpdateBannerImage(ctx context.Context, db *sql.DB, image []byte, s3Enabled bool) error {
+// UpdateBannerImage sets the banner image of c to image, cropped to crop if
+// it's not nil.
+func (c *Community) UpdateBannerImage(ctx context.Context, db *sql.DB, image io.Reader, crop *images.CropRect, s3Enabled bool) error {
+	if c.Quarantined() {
+		return errQuarantinedNoImages
+	}
 	var newImageID uid.ID
 	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
 		if err := c.DeleteBannerImageTx(ctx, db, tx); err != nil {
@@ -592,6 +693,8 @@ func (c *Community) UpdateBannerImage(ctx context.Context, db *sql.DB, image []b
 			Height: 2000,
 			Format: images.ImageFormatJPEG,
 			Fit:    images.ImageFitContain,
+			Quota:  []images.QuotaOwner{{Type: images.QuotaOwnerCommunity, ID: c.ID}},
+			Crop:   crop,
 		})
 		if err != nil {
 			return fmt.Errorf("fail to save banner image: %w", err)
@@ -605,6 +708,7 @@ func (c *Community) UpdateBannerImage(ctx context.Context, db *sql.DB, image []b
 	if err != nil {
 		return err
 	}
+	images.QueuePostProcessing(newImageID)
 
 	record, err := images.GetImageRecord(ctx, db, newImageID)
 	if err != nil {
@@ -649,6 +753,14 @@ func (c *Community) DeleteBannerImageTx(ctx context.Context, db *sql.DB, tx *sql
 
 // PopulateViewerFields populates c.ViewerJoined and c.ViewerMod fields.
 func (c *Community) PopulateViewerFields(ctx context.Context, db *sql.DB, user uid.ID) error {
+	if c.Quarantined() {
+		ack, err := c.QuarantineAcknowledged(ctx, db, user)
+		if err != nil {
+			return err
+		}
+		c.ViewerAcknowledgedQuarantine = msql.NewNullBool(ack)
+	}
+
 	row := db.QueryRowContext(ctx, "SELECT is_mod FROM community_members WHERE community_id = ? AND user_id = ?", c.ID, user)
 	isMod := false
 	if err := row.Scan(&isMod); err != nil {
@@ -1006,11 +1118,10 @@ func MakeUserMod(ctx context.Context, db *sql.DB, c *Community, viewer uid.ID, u
 		// send notification
 		if isMod {
 			if addedBy, err := GetUser(ctx, db, viewer, nil); err == nil {
-				go func() {
-					if err := CreateNewModAddNotification(context.Background(), db, user, c.Name, addedBy.Username); err != nil {
-						log.Println("Failed to create mod_add notification: ", err)
-					}
-				}()
+				queueNotification(db, user, NotificationTypeModAdd, NotificationModAdd{
+					CommunityName: c.Name,
+					AddedBy:       addedBy.Username,
+				})
 			}
 		}
 
@@ -1034,7 +1145,7 @@ func makeUserMod(ctx context.Context, db *sql.DB, c *Community, user uid.ID, isM
 	// changes in User.Delete function as well.
 
 	// First add user as member of c.
-	if err := c.Join(ctx, db, user); err != nil {
+	if err := c.join(ctx, db, user); err != nil {
 		if e, ok := err.(*httperr.Error); ok {
 			if e.HTTPStatus != http.StatusConflict {
 				return err
@@ -0,0 +1,195 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// CommunityPostsOptions are the options of GetCommunityPosts.
+type CommunityPostsOptions struct {
+	// Any sort but FeedSortTopAll and FeedSortActivity limits the top posts
+	// to those of the period of the sort (the last day, for FeedSortTopDay),
+	// unless Since is set.
+	Sort        FeedSort
+	DefaultSort bool // If the sort was not picked by the viewer.
+	Viewer      *uid.ID
+
+	// If not zero, only the posts created at or after Since, and before
+	// Until, are returned.
+	Since, Until time.Time
+
+	// If true, posts that were deleted are returned as well. Only mods and
+	// admins are to see those; callers are to check.
+	IncludeDeleted bool
+
+	// If true, the pinned posts of the community are put before the others
+	// on the first page.
+	PinnedFirst bool
+
+	Limit int
+	Next  string // The pagination cursor, taken from the previous page.
+
+	// If true, the posts are fetched for the site itself (say, for the
+	// prompts of bots), and the access checks of the viewer are skipped.
+	internal bool
+}
+
+var errInvalidTimeRange = httperr.NewBadRequest("invalid_time_range", "Invalid time range.")
+
+// since returns the time from which top posts are returned.
+func (o *CommunityPostsOptions) since() time.Time {
+	if !o.Since.IsZero() {
+		return o.Since
+	}
+	var period time.Duration
+	switch o.Sort {
+	case FeedSortTopDay:
+		period = 24 * time.Hour
+	case FeedSortTopWeek:
+		period = 7 * 24 * time.Hour
+	case FeedSortTopMonth:
+		period = 30 * 24 * time.Hour
+	case FeedSortTopYear:
+		period = 365 * 24 * time.Hour
+	default:
+		return time.Time{}
+	}
+	return now().Add(-period)
+}
+
+// GetCommunityPosts returns a page of the posts of community, as per opts.
+// They're read from a replica of db, if it has any (see msql.ReadDB), and, if
+// the viewer is logged out, from the feed cache, if there is one (see
+// SetFeedCache).
+func GetCommunityPosts(ctx context.Context, db *sql.DB, community uid.ID, opts *CommunityPostsOptions) (*FeedResultSet, error) {
+	if !opts.Sort.Valid() {
+		return nil, ErrInvalidFeedSort
+	}
+	rdb := msql.ReadDB(db)
+	if !opts.Since.IsZero() && !opts.Until.IsZero() && !opts.Since.Before(opts.Until) {
+		return nil, errInvalidTimeRange
+	}
+
+	// The checks and treatments of the feeds of communities.
+	feed := &FeedOptions{
+		Sort:        opts.Sort,
+		DefaultSort: opts.DefaultSort,
+		Viewer:      opts.Viewer,
+		Community:   &community,
+		Limit:       opts.Limit,
+		Next:        opts.Next,
+	}
+	if !opts.internal {
+		if err := feed.setAgeRestriction(ctx, rdb); err != nil {
+			return nil, err
+		}
+		if err := feed.checkPrivateAccess(ctx, rdb); err != nil {
+			return nil, err
+		}
+		// The viewer is assigned an arm, if they're not yet, on the primary.
+		if err := feed.setExperimentArm(ctx, db); err != nil {
+			return nil, err
+		}
+		if opts.Viewer == nil && !opts.IncludeDeleted && opts.Since.IsZero() && opts.Until.IsZero() {
+			key := feedCacheKey("community:"+community.String(), opts.Sort, opts.PinnedFirst, opts.Limit, opts.Next)
+			return getCachedFeed(ctx, rdb, key, []string{communityFeedScope(community)}, func() (*FeedResultSet, error) {
+				o := *opts
+				o.internal = true // The checks are done.
+				return GetCommunityPosts(ctx, db, community, &o)
+			})
+		}
+	}
+	sort := feed.Sort
+
+	var args []any
+	loggedIn := opts.Viewer != nil
+	if loggedIn {
+		args = append(args, *opts.Viewer)
+	}
+	where := "WHERE posts.community_id = ? "
+	args = append(args, community)
+	if !opts.IncludeDeleted {
+		where += "AND posts.deleted = FALSE "
+	}
+	if since := opts.since(); !since.IsZero() {
+		where += "AND posts.created_at >= ? "
+		args = append(args, since)
+	}
+	if !opts.Until.IsZero() {
+		where += "AND posts.created_at < ? "
+		args = append(args, opts.Until)
+	}
+	if loggedIn {
+		where, args = whereMutedAndHidden(where, "posts", args, *opts.Viewer, false)
+		where, args = feed.whereBotExposure(where, "posts", args)
+	}
+
+	cursorSort := sort
+	switch sort {
+	case FeedSortLatest:
+		if opts.Next != "" {
+			next, err := feed.nextID()
+			if err != nil {
+				return nil, err
+			}
+			where += "AND posts.id <= ? "
+			args = append(args, next)
+		}
+		where += "ORDER BY posts.id DESC "
+	case FeedSortHot:
+		if opts.Next != "" {
+			hotness, id, err := feed.nextPointsID()
+			if err != nil {
+				return nil, err
+			}
+			where += "AND (posts.hotness, posts.id) <= (?, ?) "
+			args = append(args, hotness, id)
+		}
+		where += "ORDER BY posts.hotness DESC, posts.id DESC "
+	case FeedSortActivity:
+		if opts.Next != "" {
+			next, err := feed.nextInt64()
+			if err != nil {
+				return nil, err
+			}
+			where += "AND posts.last_activity_at <= ? "
+			args = append(args, time.Unix(0, next))
+		}
+		where += "ORDER BY posts.last_activity_at DESC "
+	default: // Top posts.
+		if opts.Next != "" {
+			points, id, err := feed.nextPointsID()
+			if err != nil {
+				return nil, err
+			}
+			where += "AND (posts.points, posts.id) <= (?, ?) "
+			args = append(args, points, id)
+		}
+		where += "ORDER BY posts.points DESC, posts.id DESC "
+		cursorSort = FeedSortTopAll
+	}
+	where += "LIMIT ?"
+	args = append(args, opts.Limit+1)
+
+	rows, err := rdb.QueryContext(ctx, buildSelectPostQuery(loggedIn, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	posts, err := scanPosts(ctx, rdb, rows, opts.Viewer)
+	if err != nil {
+		if err != errPostNotFound {
+			return nil, err
+		}
+		posts = nil
+	}
+	set := newFeedResultSet(posts, opts.Limit, cursorSort)
+	if opts.PinnedFirst {
+		return mergePinnedPosts(ctx, rdb, opts.Viewer, &community, opts.Next, set)
+	}
+	return set, nil
+}
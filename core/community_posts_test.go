@@ -0,0 +1,26 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCommunityPostsOptionsSince(t *testing.T) {
+	since := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	opts := &CommunityPostsOptions{Sort: FeedSortTopWeek, Since: since}
+	if got := opts.since(); !got.Equal(since) {
+		t.Errorf("got %v, want the set since %v", got, since)
+	}
+
+	for _, sort := range []FeedSort{FeedSortLatest, FeedSortHot, FeedSortActivity, FeedSortTopAll} {
+		opts := &CommunityPostsOptions{Sort: sort}
+		if got := opts.since(); !got.IsZero() {
+			t.Errorf("%v: got since %v, want none", sort, got)
+		}
+	}
+
+	opts = &CommunityPostsOptions{Sort: FeedSortTopDay}
+	if got := opts.since(); now().Sub(got) < 23*time.Hour || now().Sub(got) > 25*time.Hour {
+		t.Errorf("got since %v for the top posts of the day", got)
+	}
+}
@@ -0,0 +1,315 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Consent is for research studies run on the site. Admins publish consent
+// forms, each a new version of the form (see CreateConsentForm), and users
+// consent to, or decline, the latest version (see RecordConsent). If the
+// ConsentRequired site setting is set, users cannot post, comment, or vote
+// until they consent (see CheckConsent).
+//
+// Users may withdraw their consent at any time (see WithdrawConsent), upon
+// which their posts and comments are anonymized. The people on the rosters
+// of cohorts who have not consented are left out of the pseudonymized
+// (research) exports of the engagement of the cohorts.
+
+// Consent decisions.
+const (
+	ConsentGiven     = "consented"
+	ConsentDeclined  = "declined"
+	ConsentWithdrawn = "withdrawn"
+)
+
+const maxConsentFormTitleLength = 255
+
+var (
+	errConsentRequired     = httperr.NewForbidden("consent_required", "You must consent to the latest consent form to participate.")
+	errConsentFormOutdated = &httperr.Error{HTTPStatus: http.StatusConflict, Code: "consent_form_outdated", Message: "There's a newer version of the consent form."}
+	errNoConsentForm       = httperr.NewNotFound("no_consent_form", "There's no consent form.")
+	errNoConsent           = httperr.NewBadRequest("no_consent", "You have not consented.")
+)
+
+// ConsentForm is a version of the consent form.
+type ConsentForm struct {
+	Version   int        `json:"version"`
+	Title     string     `json:"title"`
+	Body      string     `json:"body"`
+	CreatedBy uid.NullID `json:"createdBy"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// CreateConsentForm publishes a new version of the consent form, on behalf of
+// admin. Users who consented to an earlier version have to consent again.
+func CreateConsentForm(ctx context.Context, db *sql.DB, admin uid.ID, title, body string) (*ConsentForm, error) {
+	if is, err := IsAdmin(db, &admin); err != nil {
+		return nil, err
+	} else if !is {
+		return nil, errNotAdmin
+	}
+
+	title, body = strings.TrimSpace(title), strings.TrimSpace(body)
+	if title == "" || len(title) > maxConsentFormTitleLength {
+		return nil, httperr.NewBadRequest("invalid_title", fmt.Sprintf("Title must be between 1 and %d characters long.", maxConsentFormTitleLength))
+	}
+	if body == "" {
+		return nil, httperr.NewBadRequest("invalid_body", "Body cannot be empty.")
+	}
+
+	var version int
+	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		if err := tx.QueryRowContext(ctx, "SELECT IFNULL(MAX(version), 0) + 1 FROM consent_forms FOR UPDATE").Scan(&version); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "INSERT INTO consent_forms (version, title, body, created_by) VALUES (?, ?, ?, ?)", version, title, body, admin)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return getConsentForm(ctx, db, "WHERE version = ?", version)
+}
+
+func getConsentForms(ctx context.Context, db *sql.DB, where string, args ...any) ([]*ConsentForm, error) {
+	rows, err := db.QueryContext(ctx, "SELECT version, title, body, created_by, created_at FROM consent_forms "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	forms := []*ConsentForm{}
+	for rows.Next() {
+		f := &ConsentForm{}
+		if err := rows.Scan(&f.Version, &f.Title, &f.Body, &f.CreatedBy, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		forms = append(forms, f)
+	}
+	return forms, rows.Err()
+}
+
+func getConsentForm(ctx context.Context, db *sql.DB, where string, args ...any) (*ConsentForm, error) {
+	forms, err := getConsentForms(ctx, db, where, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(forms) == 0 {
+		return nil, errNoConsentForm
+	}
+	return forms[0], nil
+}
+
+// GetConsentForms returns all versions of the consent form, the latest
+// first.
+func GetConsentForms(ctx context.Context, db *sql.DB) ([]*ConsentForm, error) {
+	return getConsentForms(ctx, db, "ORDER BY version DESC")
+}
+
+// GetLatestConsentForm returns the latest version of the consent form, or
+// nil if there's none.
+func GetLatestConsentForm(ctx context.Context, db *sql.DB) (*ConsentForm, error) {
+	forms, err := getConsentForms(ctx, db, "ORDER BY version DESC LIMIT 1")
+	if err != nil || len(forms) == 0 {
+		return nil, err
+	}
+	return forms[0], nil
+}
+
+// UserConsent is the latest consent decision of a user.
+type UserConsent struct {
+	FormVersion  int           `json:"formVersion"`
+	Decision     string        `json:"decision"`
+	DecidedAt    time.Time     `json:"decidedAt"`
+	AnonymizedAt msql.NullTime `json:"anonymizedAt"` // Set if consent was withdrawn.
+}
+
+// Valid reports whether c is consent to version of the consent form.
+func (c *UserConsent) Valid(version int) bool {
+	return c != nil && c.Decision == ConsentGiven && c.FormVersion == version
+}
+
+// GetUserConsent returns the latest consent decision of user, or nil if user
+// has not made any.
+func GetUserConsent(ctx context.Context, db *sql.DB, user uid.ID) (*UserConsent, error) {
+	c := &UserConsent{}
+	row := db.QueryRowContext(ctx, `
+		SELECT form_version, decision, created_at, anonymized_at FROM consent_records
+		WHERE user_id = ? ORDER BY id DESC LIMIT 1`, user)
+	if err := row.Scan(&c.FormVersion, &c.Decision, &c.DecidedAt, &c.AnonymizedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// RecordConsent records the consent (or the refusal, if consent is false) of
+// user to version of the consent form, which must be the latest.
+func RecordConsent(ctx context.Context, db *sql.DB, user uid.ID, version int, consent bool) (*UserConsent, error) {
+	form, err := GetLatestConsentForm(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if form == nil {
+		return nil, errNoConsentForm
+	}
+	if form.Version != version {
+		return nil, errConsentFormOutdated
+	}
+
+	decision := ConsentGiven
+	if !consent {
+		decision = ConsentDeclined
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO consent_records (user_id, form_version, decision) VALUES (?, ?, ?)", user, version, decision); err != nil {
+		return nil, err
+	}
+	return GetUserConsent(ctx, db, user)
+}
+
+// WithdrawConsent withdraws the consent of user, and anonymizes the posts and
+// comments of user (authored before the withdrawal).
+func WithdrawConsent(ctx context.Context, db *sql.DB, user uid.ID) (*UserConsent, error) {
+	c, err := GetUserConsent(ctx, db, user)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil || c.Decision != ConsentGiven {
+		return nil, errNoConsent
+	}
+
+	err = msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		now := time.Now()
+		if _, err := tx.ExecContext(ctx, "INSERT INTO consent_records (user_id, form_version, decision, created_at, anonymized_at) VALUES (?, ?, ?, ?, ?)",
+			user, c.FormVersion, ConsentWithdrawn, now, now); err != nil {
+			return err
+		}
+		return anonymizeUserContentTx(ctx, tx, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetUserConsent(ctx, db, user)
+}
+
+// anonymizeUserContentTx hides the author of the posts and comments of user
+// (to all but admins) as if the account of user were deleted.
+func anonymizeUserContentTx(ctx context.Context, tx *sql.Tx, user uid.ID) error {
+	if _, err := tx.ExecContext(ctx, "UPDATE posts SET author_anonymized = TRUE WHERE user_id = ?", user); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "UPDATE comments SET user_deleted = TRUE WHERE user_id = ?", user)
+	return err
+}
+
+// CheckConsent returns an error if user has not consented to the latest
+// consent form. Admins and bots need no consent, nor does anyone if there's
+// no consent form.
+func CheckConsent(ctx context.Context, db *sql.DB, user uid.ID) error {
+	var admin, bot bool
+	if err := db.QueryRowContext(ctx, "SELECT is_admin, is_bot FROM users WHERE id = ?", user).Scan(&admin, &bot); err != nil {
+		return err
+	}
+	if admin || bot {
+		return nil
+	}
+	form, err := GetLatestConsentForm(ctx, db)
+	if err != nil || form == nil {
+		return err
+	}
+	c, err := GetUserConsent(ctx, db, user)
+	if err != nil {
+		return err
+	}
+	if !c.Valid(form.Version) {
+		return errConsentRequired
+	}
+	return nil
+}
+
+// whereConsented returns the condition that the users in column have
+// consented to the latest consent form, if there's one.
+func whereConsented(column string) string {
+	return fmt.Sprintf(`(NOT EXISTS (SELECT 1 FROM consent_forms) OR (
+		SELECT CONCAT(consent_records.decision, ':', consent_records.form_version) FROM consent_records
+		WHERE consent_records.user_id = %s ORDER BY consent_records.id DESC LIMIT 1
+	) = CONCAT('%s:', (SELECT MAX(version) FROM consent_forms)))`, column, ConsentGiven)
+}
+
+// ConsentStatus is the consent status of a user.
+type ConsentStatus struct {
+	UserID   uid.ID `json:"userId"`
+	Username string `json:"username"`
+	*UserConsent
+	Current bool `json:"current"` // Consent to the latest consent form.
+}
+
+// ConsentStatuses are the consent statuses of users.
+type ConsentStatuses []*ConsentStatus
+
+// GetConsentStatuses returns the consent status of every user who made a
+// consent decision, ordered by username.
+func GetConsentStatuses(ctx context.Context, db *sql.DB) (ConsentStatuses, error) {
+	form, err := GetLatestConsentForm(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT users.id, users.username, consent_records.form_version, consent_records.decision, consent_records.created_at, consent_records.anonymized_at
+		FROM consent_records
+		INNER JOIN users ON users.id = consent_records.user_id
+		WHERE consent_records.id = (SELECT MAX(id) FROM consent_records AS latest WHERE latest.user_id = consent_records.user_id)
+		ORDER BY users.username_lc`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := ConsentStatuses{}
+	for rows.Next() {
+		s := &ConsentStatus{UserConsent: &UserConsent{}}
+		if err := rows.Scan(&s.UserID, &s.Username, &s.FormVersion, &s.Decision, &s.DecidedAt, &s.AnonymizedAt); err != nil {
+			return nil, err
+		}
+		s.Current = form != nil && s.Valid(form.Version)
+		statuses = append(statuses, s)
+	}
+	return statuses, rows.Err()
+}
+
+// WriteConsentStatusesCSV writes statuses to w as a CSV file.
+func WriteConsentStatusesCSV(w io.Writer, statuses []*ConsentStatus) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"username", "form_version", "decision", "decided_at", "anonymized_at", "current"})
+	for _, s := range statuses {
+		anonymizedAt := ""
+		if s.AnonymizedAt.Valid {
+			anonymizedAt = s.AnonymizedAt.Time.UTC().Format(time.RFC3339)
+		}
+		cw.Write([]string{
+			s.Username,
+			strconv.Itoa(s.FormVersion),
+			s.Decision,
+			s.DecidedAt.UTC().Format(time.RFC3339),
+			anonymizedAt,
+			strconv.FormatBool(s.Current),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
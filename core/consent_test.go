@@ -0,0 +1,22 @@
+package core
+
+import "testing"
+
+func TestUserConsentValid(t *testing.T) {
+	tests := []struct {
+		consent *UserConsent
+		version int
+		want    bool
+	}{
+		{nil, 1, false},
+		{&UserConsent{FormVersion: 1, Decision: ConsentGiven}, 1, true},
+		{&UserConsent{FormVersion: 1, Decision: ConsentGiven}, 2, false},
+		{&UserConsent{FormVersion: 2, Decision: ConsentDeclined}, 2, false},
+		{&UserConsent{FormVersion: 2, Decision: ConsentWithdrawn}, 2, false},
+	}
+	for _, test := range tests {
+		if got := test.consent.Valid(test.version); got != test.want {
+			t.Errorf("%+v.Valid(%d) = %v, want %v", test.consent, test.version, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,685 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Posts, comments, usernames, and community names are checked against word
+// lists kept by admins (the site-level list) and by the mods of each
+// community (community-level lists, which apply only to the posts and
+// comments of the community). Each word in a list has an action: content
+// containing it is either blocked, accepted and then removed, or accepted
+// with the word masked. Allow-listed words are exceptions that are never
+// matched; site-level ones apply to all lists, and community-level ones only
+// to the community's list. Usernames and community names are blocked if they
+// contain any (non allow-listed) word of the site-level list.
+//
+// Before matching, text is normalized to defeat common obfuscations:
+// letters are lowercased and stripped of diacritics, fullwidth and
+// look-alike Cyrillic and Greek letters are mapped to Latin ones, digits and
+// symbols used in leetspeak are mapped to letters, invisible characters are
+// dropped, and letters spelled out one at a time ("f.o.o") are joined.
+//
+// A word matches whole words only, unless it begins or ends with an asterisk,
+// in which case it matches words ending or beginning with it.
+
+// FilterAction is what's done with content containing a word of a content
+// filter list.
+type FilterAction string
+
+// Valid filter actions.
+const (
+	FilterActionBlock  = FilterAction("block")  // Content is rejected.
+	FilterActionRemove = FilterAction("remove") // Content is accepted, and then removed as if by a mod.
+	FilterActionMask   = FilterAction("mask")   // The word is replaced with asterisks.
+	FilterActionAllow  = FilterAction("allow")  // The word is an exception.
+)
+
+// Valid reports whether a is a valid filter action.
+func (a FilterAction) Valid() bool {
+	switch a {
+	case FilterActionBlock, FilterActionRemove, FilterActionMask, FilterActionAllow:
+		return true
+	}
+	return false
+}
+
+// severity returns the precedence of a among actions matched in the same
+// content.
+func (a FilterAction) severity() int {
+	switch a {
+	case FilterActionBlock:
+		return 3
+	case FilterActionRemove:
+		return 2
+	case FilterActionMask:
+		return 1
+	}
+	return 0
+}
+
+const maxFilterWordLength = 100
+
+var (
+	errContentFiltered = httperr.NewBadRequest("content_filtered", "Your submission contains words that are not allowed.")
+	errNameFiltered    = httperr.NewBadRequest("name_filtered", "The name contains words that are not allowed.")
+)
+
+// FilterWord is a word in a content filter list.
+type FilterWord struct {
+	ID          int          `json:"id"`
+	CommunityID uid.NullID   `json:"communityId"` // Null for the site-level list.
+	Word        string       `json:"word"`
+	Action      FilterAction `json:"action"`
+	CreatedBy   uid.NullID   `json:"createdBy"`
+	CreatedAt   time.Time    `json:"createdAt"`
+}
+
+// GetFilterWords returns the content filter list of community, or the
+// site-level list if community is nil.
+func GetFilterWords(ctx context.Context, db *sql.DB, community *uid.ID) ([]*FilterWord, error) {
+	query := "SELECT id, community_id, word, action, created_by, created_at FROM content_filter_words WHERE "
+	var args []any
+	if community == nil {
+		query += "community_id IS NULL"
+	} else {
+		query += "community_id = ?"
+		args = append(args, *community)
+	}
+	rows, err := db.QueryContext(ctx, query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	words := []*FilterWord{}
+	for rows.Next() {
+		w := &FilterWord{}
+		if err := rows.Scan(&w.ID, &w.CommunityID, &w.Word, &w.Action, &w.CreatedBy, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		words = append(words, w)
+	}
+	return words, rows.Err()
+}
+
+// checkFilterListPermissions returns an error if user cannot edit the content
+// filter list of community (the site-level list if community is nil).
+func checkFilterListPermissions(ctx context.Context, db *sql.DB, user uid.ID, community *uid.ID) error {
+	if community == nil {
+		if is, err := IsAdmin(db, &user); err != nil {
+			return err
+		} else if !is {
+			return errNotAdmin
+		}
+		return nil
+	}
+	if is, err := UserModOrAdmin(ctx, db, *community, user); err != nil {
+		return err
+	} else if !is {
+		return errNotMod
+	}
+	return nil
+}
+
+// AddFilterWord adds word, with action, to the content filter list of
+// community (the site-level list if community is nil) on behalf of user. If
+// the word is already in the list, its action is changed.
+func AddFilterWord(ctx context.Context, db *sql.DB, user uid.ID, community *uid.ID, word string, action FilterAction) (*FilterWord, error) {
+	if err := checkFilterListPermissions(ctx, db, user, community); err != nil {
+		return nil, err
+	}
+
+	word = strings.TrimSpace(word)
+	if !action.Valid() {
+		return nil, httperr.NewBadRequest("invalid_filter_action", "Invalid filter action.")
+	}
+	if utf8.RuneCountInString(word) > maxFilterWordLength || strings.IndexFunc(word, unicode.IsSpace) != -1 {
+		return nil, httperr.NewBadRequest("invalid_filter_word", fmt.Sprintf("Filter words must be single words of at most %d characters.", maxFilterWordLength))
+	}
+	if newFilterEntry(word, action).pattern == "" {
+		return nil, httperr.NewBadRequest("invalid_filter_word", "Filter words must contain at least one letter or digit.")
+	}
+
+	var communityID uid.NullID
+	if community != nil {
+		communityID = uid.NullID{ID: *community, Valid: true}
+	}
+	words, err := GetFilterWords(ctx, db, community)
+	if err != nil {
+		return nil, err
+	}
+
+	var fw *FilterWord
+	for _, w := range words {
+		if strings.EqualFold(w.Word, word) {
+			fw = w
+			break
+		}
+	}
+	if fw != nil {
+		if _, err := db.ExecContext(ctx, "UPDATE content_filter_words SET action = ? WHERE id = ?", action, fw.ID); err != nil {
+			return nil, err
+		}
+		fw.Action = action
+	} else {
+		fw = &FilterWord{
+			CommunityID: communityID,
+			Word:        word,
+			Action:      action,
+			CreatedBy:   uid.NullID{ID: user, Valid: true},
+			CreatedAt:   time.Now(),
+		}
+		res, err := db.ExecContext(ctx, "INSERT INTO content_filter_words (community_id, word, action, created_by, created_at) VALUES (?, ?, ?, ?, ?)",
+			fw.CommunityID, fw.Word, fw.Action, fw.CreatedBy, fw.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		fw.ID = int(id)
+	}
+
+	filterListsCache.invalidate(communityID.ID)
+	return fw, nil
+}
+
+// RemoveFilterWord removes the word with id from the content filter list of
+// community (the site-level list if community is nil) on behalf of user.
+func RemoveFilterWord(ctx context.Context, db *sql.DB, user uid.ID, community *uid.ID, id int) error {
+	if err := checkFilterListPermissions(ctx, db, user, community); err != nil {
+		return err
+	}
+
+	query := "DELETE FROM content_filter_words WHERE id = ? AND "
+	args := []any{id}
+	var key uid.ID
+	if community == nil {
+		query += "community_id IS NULL"
+	} else {
+		query += "community_id = ?"
+		args = append(args, *community)
+		key = *community
+	}
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return httperr.NewNotFound("filter_word_not_found", "Filter word not found.")
+	}
+
+	filterListsCache.invalidate(key)
+	return nil
+}
+
+// filterListsCacheStore caches content filter lists, keyed by community ID
+// (the zero ID for the site-level list), for a minute.
+type filterListsCacheStore struct {
+	mu    sync.Mutex // guards following
+	lists map[uid.ID]*cachedFilterList
+}
+
+type cachedFilterList struct {
+	words   []*FilterWord
+	fetched time.Time
+}
+
+var filterListsCache = &filterListsCacheStore{}
+
+func (fc *filterListsCacheStore) get(ctx context.Context, db *sql.DB, community *uid.ID) ([]*FilterWord, error) {
+	var key uid.ID
+	if community != nil {
+		key = *community
+	}
+
+	fc.mu.Lock()
+	cached := fc.lists[key]
+	fc.mu.Unlock()
+	if cached != nil && time.Since(cached.fetched) < time.Minute {
+		return cached.words, nil
+	}
+
+	words, err := GetFilterWords(ctx, db, community)
+	if err != nil {
+		return nil, err
+	}
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.lists == nil {
+		fc.lists = make(map[uid.ID]*cachedFilterList)
+	}
+	fc.lists[key] = &cachedFilterList{words: words, fetched: time.Now()}
+	return words, nil
+}
+
+func (fc *filterListsCacheStore) invalidate(key uid.ID) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	delete(fc.lists, key)
+}
+
+// filterContent checks texts, the parts of a post or comment in community,
+// against the content filter lists. It returns errContentFiltered if any of
+// them is blocked, masks the matched words in place, and reports whether the
+// content is to be removed.
+func filterContent(ctx context.Context, db *sql.DB, community uid.ID, texts ...*string) (remove bool, err error) {
+	siteWords, err := filterListsCache.get(ctx, db, nil)
+	if err != nil {
+		return false, err
+	}
+	communityWords, err := filterListsCache.get(ctx, db, &community)
+	if err != nil {
+		return false, err
+	}
+	if len(siteWords) == 0 && len(communityWords) == 0 {
+		return false, nil
+	}
+
+	filters := []*contentFilter{
+		newContentFilter(siteWords, nil),
+		newContentFilter(communityWords, siteWords),
+	}
+	for _, text := range texts {
+		var masks []filterMatch
+		for _, f := range filters {
+			for _, m := range f.matchText(*text) {
+				switch m.action {
+				case FilterActionBlock:
+					return false, errContentFiltered
+				case FilterActionRemove:
+					remove = true
+				case FilterActionMask:
+					masks = append(masks, m)
+				}
+			}
+		}
+		*text = maskMatches(*text, masks)
+	}
+	return remove, nil
+}
+
+// checkNameFilter returns errNameFiltered if name, a username or a community
+// name, contains a word of the site-level content filter list.
+func checkNameFilter(ctx context.Context, db *sql.DB, name string) error {
+	words, err := filterListsCache.get(ctx, db, nil)
+	if err != nil {
+		return err
+	}
+	if newContentFilter(words, nil).matchName(name) {
+		return errNameFiltered
+	}
+	return nil
+}
+
+// autoRemove marks p, which has just been created or edited, as deleted by
+// the mods of its community, without attributing the deletion to any of them.
+func (p *Post) autoRemove(ctx context.Context, db *sql.DB) error {
+	if p.Deleted {
+		return nil
+	}
+	now := time.Now()
+	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "UPDATE posts SET deleted = TRUE, deleted_at = ?, deleted_as = ? WHERE id = ?", now, UserGroupMods, p.ID); err != nil {
+			return err
+		}
+		if err := incrementUserPosts(ctx, tx, p.AuthorID, -1); err != nil {
+			return err
+		}
+		for _, table := range postsTables {
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE post_id = ?", table), p.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	p.Deleted = true
+	p.DeletedAt = msql.NewNullTime(now)
+	p.DeletedAs = UserGroupMods
+	invalidateFeedsOf(ctx, p)
+	queueSearchIndex(ctx, db, SearchKindPosts, p.ID)
+	queueNotification(db, p.AuthorID, NotificationTypeDeletePost, postDeletedNotification(UserGroupMods, true, p.ID))
+	return nil
+}
+
+// autoRemove marks c, which has just been edited, as deleted by the mods of
+// its community, without attributing the deletion to any of them.
+func (c *Comment) autoRemove(ctx context.Context, db *sql.DB) error {
+	if c.Deleted {
+		return nil
+	}
+	now := time.Now()
+	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "UPDATE comments SET deleted_at = ?, deleted_as = ? WHERE id = ?", now, UserGroupMods, c.ID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE posts_comments SET deleted = true WHERE target_id = ? AND user_id = ?", c.ID, c.AuthorID); err != nil {
+			return err
+		}
+		return incrementUserComments(ctx, tx, c.AuthorID, -1)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.Deleted = true
+	c.DeletedAt = msql.NewNullTime(now)
+	c.DeletedAs = UserGroupMods
+	queueSearchIndex(ctx, db, SearchKindComments, c.ID)
+	return nil
+}
+
+// filterEntry is a normalized content filter word.
+type filterEntry struct {
+	pattern          string
+	anyStart, anyEnd bool // Whether the pattern began or ended with a '*'.
+	action           FilterAction
+}
+
+func newFilterEntry(word string, action FilterAction) filterEntry {
+	e := filterEntry{action: action}
+	word, e.anyStart = strings.CutPrefix(word, "*")
+	word, e.anyEnd = strings.CutSuffix(word, "*")
+	for _, r := range normalizeFilterText(word) {
+		if isFilterWordRune(r.r) {
+			e.pattern += string(r.r)
+		}
+	}
+	return e
+}
+
+func (e filterEntry) matches(word string) bool {
+	switch {
+	case e.anyStart && e.anyEnd:
+		return strings.Contains(word, e.pattern)
+	case e.anyStart:
+		return strings.HasSuffix(word, e.pattern)
+	case e.anyEnd:
+		return strings.HasPrefix(word, e.pattern)
+	}
+	return word == e.pattern
+}
+
+// contentFilter matches text against a content filter list.
+type contentFilter struct {
+	entries []filterEntry
+	allow   map[string]bool // Normalized allow-listed words.
+}
+
+// newContentFilter returns a filter for words, with the allow-listed words of
+// words and of extraAllow as exceptions.
+func newContentFilter(words []*FilterWord, extraAllow []*FilterWord) *contentFilter {
+	f := &contentFilter{allow: make(map[string]bool)}
+	for _, w := range words {
+		if e := newFilterEntry(w.Word, w.Action); e.pattern == "" {
+			continue
+		} else if w.Action == FilterActionAllow {
+			f.allow[e.pattern] = true
+		} else {
+			f.entries = append(f.entries, e)
+		}
+	}
+	for _, w := range extraAllow {
+		if w.Action == FilterActionAllow {
+			if e := newFilterEntry(w.Word, w.Action); e.pattern != "" {
+				f.allow[e.pattern] = true
+			}
+		}
+	}
+	return f
+}
+
+// filterMatch is a word matched in text. Start and end are byte offsets into
+// the original text.
+type filterMatch struct {
+	start, end int
+	action     FilterAction
+}
+
+// matchText returns the words of text that match the filter, with the most
+// severe action of those that match each word.
+func (f *contentFilter) matchText(text string) []filterMatch {
+	if len(f.entries) == 0 {
+		return nil
+	}
+	var matches []filterMatch
+	for _, t := range filterTokens(normalizeFilterText(text)) {
+		if f.allow[t.word] {
+			continue
+		}
+		var action FilterAction
+		for _, e := range f.entries {
+			if e.action.severity() > action.severity() && e.matches(t.word) {
+				action = e.action
+			}
+		}
+		if action != "" {
+			matches = append(matches, filterMatch{start: t.start, end: t.end, action: action})
+		}
+	}
+	return matches
+}
+
+// matchName reports whether name contains any word of the filter, anywhere
+// but inside an allow-listed word.
+func (f *contentFilter) matchName(name string) bool {
+	if len(f.entries) == 0 {
+		return false
+	}
+	var b strings.Builder
+	for _, r := range normalizeFilterText(name) {
+		if isFilterWordRune(r.r) {
+			b.WriteRune(r.r)
+		}
+	}
+	s := b.String()
+	for allowed := range f.allow {
+		s = strings.ReplaceAll(s, allowed, "\x00")
+	}
+	for _, e := range f.entries {
+		if strings.Contains(s, e.pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// maskMatches returns text with the non-space characters of matches replaced
+// with asterisks.
+func maskMatches(text string, matches []filterMatch) string {
+	if len(matches) == 0 {
+		return text
+	}
+	var b strings.Builder
+	b.Grow(len(text))
+	for i, r := range text {
+		inMatch := false
+		for _, m := range matches {
+			if i >= m.start && i < m.end {
+				inMatch = true
+				break
+			}
+		}
+		switch {
+		case !inMatch || unicode.IsSpace(r):
+			b.WriteRune(r)
+		case isFilterIgnoredRune(r):
+			// Combining marks and invisible characters of masked words are
+			// dropped.
+		default:
+			b.WriteByte('*')
+		}
+	}
+	return b.String()
+}
+
+// normalizedRune is a rune of normalized text, along with the byte offsets of
+// the part of the original text it came from.
+type normalizedRune struct {
+	r          rune
+	start, end int
+}
+
+// normalizeFilterText normalizes text for matching against content filter
+// words (see the comment at the top of this file).
+func normalizeFilterText(text string) []normalizedRune {
+	runes := make([]normalizedRune, 0, len(text))
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		start := i
+		i += size
+		if isFilterIgnoredRune(r) {
+			// Combining marks and invisible characters are dropped (and
+			// masked along with the preceding character).
+			if n := len(runes); n > 0 {
+				runes[n-1].end = i
+			}
+			continue
+		}
+		runes = append(runes, normalizedRune{r: foldFilterRune(r), start: start, end: i})
+	}
+	return runes
+}
+
+// isFilterIgnoredRune reports whether r is a combining mark or an invisible
+// character.
+func isFilterIgnoredRune(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf)
+}
+
+func foldFilterRune(r rune) rune {
+	if r >= 0xFF01 && r <= 0xFF5E { // Fullwidth forms.
+		r -= 0xFEE0
+	}
+	r = unicode.ToLower(r)
+	if f, ok := filterRuneFolds[r]; ok {
+		return f
+	}
+	return r
+}
+
+// filterRuneFolds maps letters with diacritics, look-alike letters, and
+// leetspeak characters to the basic Latin letters they stand for.
+var filterRuneFolds = func() map[rune]rune {
+	m := make(map[rune]rune)
+	for to, from := range map[rune]string{
+		'a': "àáâãäåāăąǎαа4@",
+		'b': "8β",
+		'c': "çćĉċčс",
+		'd': "ďđ",
+		'e': "èéêëēĕėęěеε3",
+		'g': "ĝğġģ",
+		'h': "ĥħн",
+		'i': "ìíîïĩīĭįıǐіι1",
+		'j': "ĵј",
+		'k': "ķкκ",
+		'l': "ĺļľŀł",
+		'm': "м",
+		'n': "ñńņňŉη",
+		'o': "òóôõöøōŏőǒоο0",
+		'p': "рρ",
+		'r': "ŕŗř",
+		's': "śŝşšѕ5$",
+		't': "ţťŧтτ7",
+		'u': "ùúûüũūŭůűųǔυ",
+		'v': "ν",
+		'w': "ŵ",
+		'x': "хχ",
+		'y': "ýÿŷуγ",
+		'z': "źżž",
+	} {
+		for _, r := range from {
+			m[r] = to
+		}
+	}
+	return m
+}()
+
+func isFilterWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// maxSpelledOutLength is the maximum number of letters of spelled out words
+// that are joined.
+const maxSpelledOutLength = 32
+
+// filterToken is a word of normalized text.
+type filterToken struct {
+	word       string
+	start, end int // Byte offsets into the original text.
+}
+
+// filterTokens splits text into words. Runs of single letters separated by a
+// single character, as in "f o o" and "f.o.o", are also returned joined as a
+// word.
+func filterTokens(text []normalizedRune) []filterToken {
+	type span struct{ from, to int } // Indexes into text.
+	var spans []span
+	for i := 0; i < len(text); {
+		if !isFilterWordRune(text[i].r) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(text) && isFilterWordRune(text[j].r) {
+			j++
+		}
+		spans = append(spans, span{i, j})
+		i = j
+	}
+
+	token := func(from, to int) filterToken {
+		var b strings.Builder
+		for _, r := range text[from:to] {
+			if isFilterWordRune(r.r) {
+				b.WriteRune(r.r)
+			}
+		}
+		return filterToken{word: b.String(), start: text[from].start, end: text[to-1].end}
+	}
+
+	tokens := make([]filterToken, 0, len(spans))
+	for i := 0; i < len(spans); i++ {
+		tokens = append(tokens, token(spans[i].from, spans[i].to))
+
+		// Join spelled out words. As where such a run begins and ends is not
+		// known (it may include, say, a preceding "a"), all parts of it are
+		// tried.
+		j := i
+		for j+1 < len(spans) && j-i < maxSpelledOutLength && spans[j].to-spans[j].from == 1 && spans[j+1].to-spans[j+1].from == 1 && spans[j+1].from-spans[j].to == 1 {
+			j++
+		}
+		if j > i {
+			for k := i + 1; k <= j; k++ {
+				tokens = append(tokens, token(spans[k].from, spans[k].to))
+			}
+			for from := i; from < j; from++ {
+				for to := from + 1; to <= j; to++ {
+					tokens = append(tokens, token(spans[from].from, spans[to].to))
+				}
+			}
+			i = j
+		}
+	}
+	return tokens
+}
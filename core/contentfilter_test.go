@@ -0,0 +1,125 @@
+package core
+
+import "testing"
+
+func testFilterWords(words map[string]FilterAction) []*FilterWord {
+	var list []*FilterWord
+	for w, a := range words {
+		list = append(list, &FilterWord{Word: w, Action: a})
+	}
+	return list
+}
+
+func TestContentFilterMatchText(t *testing.T) {
+	f := newContentFilter(testFilterWords(map[string]FilterAction{
+		"badword": FilterActionBlock,
+		"darn":    FilterActionMask,
+		"spam*":   FilterActionRemove,
+		"scun":    FilterActionMask,
+	}), nil)
+
+	tests := []struct {
+		text   string
+		action FilterAction // Most severe action matched; empty for none.
+	}{
+		{"a perfectly fine sentence", ""},
+		{"this is a badword", FilterActionBlock},
+		{"this is a BADWORD!", FilterActionBlock},
+		{"this is a b4dw0rd", FilterActionBlock},
+		{"this is a bádwórd", FilterActionBlock},
+		{"this is a b​adword", FilterActionBlock},
+		{"this is a ｂａｄｗｏｒｄ", FilterActionBlock},
+		{"this is a bаdwоrd", FilterActionBlock}, // Cyrillic a and o.
+		{"this is a b.a.d.w.o.r.d", FilterActionBlock},
+		{"this is a b a d w o r d", FilterActionBlock},
+		{"badwords are not matched", ""},
+		{"darn it", FilterActionMask},
+		{"spammer alert", FilterActionRemove},
+		{"antispam", ""},
+		{"darn spam", FilterActionRemove},
+	}
+	for _, test := range tests {
+		var got FilterAction
+		for _, m := range f.matchText(test.text) {
+			if m.action.severity() > got.severity() {
+				got = m.action
+			}
+		}
+		if got != test.action {
+			t.Errorf("matchText(%q): got action %q, want %q", test.text, got, test.action)
+		}
+	}
+}
+
+func TestContentFilterAllow(t *testing.T) {
+	site := testFilterWords(map[string]FilterAction{
+		"*ass*":    FilterActionBlock,
+		"classic":  FilterActionAllow,
+		"assassin": FilterActionAllow,
+	})
+	community := testFilterWords(map[string]FilterAction{
+		"class": FilterActionAllow,
+		"*cat*": FilterActionBlock,
+	})
+
+	f := newContentFilter(site, nil)
+	if m := f.matchText("a classic assassin"); len(m) != 0 {
+		t.Errorf("allow-listed words matched: %v", m)
+	}
+	if m := f.matchText("first class"); len(m) != 1 {
+		t.Errorf("got %d matches, want 1", len(m))
+	}
+
+	// Community allow-listed words don't override the site list.
+	cf := newContentFilter(community, site)
+	if m := cf.matchText("a classic cat"); len(m) != 1 {
+		t.Errorf("got %d matches, want 1", len(m))
+	}
+}
+
+func TestContentFilterMatchName(t *testing.T) {
+	f := newContentFilter(testFilterWords(map[string]FilterAction{
+		"badword": FilterActionBlock,
+		"darn":    FilterActionMask,
+		"darnell": FilterActionAllow,
+	}), nil)
+
+	tests := []struct {
+		name  string
+		match bool
+	}{
+		{"gooduser", false},
+		{"xXbadwordXx", true},
+		{"b4d_w0rd_99", true},
+		{"darn_it", true},
+		{"darnell", false},
+		{"darnell_darn", true},
+	}
+	for _, test := range tests {
+		if got := f.matchName(test.name); got != test.match {
+			t.Errorf("matchName(%q) = %v, want %v", test.name, got, test.match)
+		}
+	}
+}
+
+func TestMaskMatches(t *testing.T) {
+	f := newContentFilter(testFilterWords(map[string]FilterAction{
+		"darn": FilterActionMask,
+	}), nil)
+
+	tests := []struct {
+		text, want string
+	}{
+		{"darn it", "**** it"},
+		{"Oh DARN!", "Oh ****!"},
+		{"d a r n it", "* * * * it"},
+		{"dárn", "****"},
+		{"dárn", "****"}, // With a combining acute accent.
+		{"nothing here", "nothing here"},
+	}
+	for _, test := range tests {
+		if got := maskMatches(test.text, f.matchText(test.text)); got != test.want {
+			t.Errorf("masking %q: got %q, want %q", test.text, got, test.want)
+		}
+	}
+}
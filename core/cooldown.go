@@ -0,0 +1,112 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Communities can set a minimum time between the posts, and between the
+// comments, of a user (see Community.PostCooldown). Mods and admins are not
+// subject to cooldowns.
+
+// maxCommunityCooldown is the max value of a community cooldown, in seconds.
+const maxCommunityCooldown = 60 * 60 * 24
+
+// CooldownError is returned when a user tries to post or comment in a
+// community before the community's cooldown since their last post or comment
+// there has passed.
+type CooldownError struct {
+	Content   ContentType
+	Remaining time.Duration
+}
+
+// RemainingSeconds returns e.Remaining rounded up to the second.
+func (e *CooldownError) RemainingSeconds() int {
+	return int(math.Ceil(e.Remaining.Seconds()))
+}
+
+// HTTPError returns the HTTP error of e (without the remaining time).
+func (e *CooldownError) HTTPError() *httperr.Error {
+	return &httperr.Error{
+		HTTPStatus: http.StatusTooManyRequests,
+		Code:       "cooldown",
+		Message:    fmt.Sprintf("You can %s in this community again in %d seconds.", e.Content.verb(), e.RemainingSeconds()),
+	}
+}
+
+func (e *CooldownError) Error() string {
+	return e.HTTPError().Error()
+}
+
+func (e *CooldownError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		*httperr.Error
+		Content   ContentType `json:"contentType"`
+		Remaining int         `json:"cooldownRemaining"` // In seconds.
+	}{e.HTTPError(), e.Content, e.RemainingSeconds()})
+}
+
+func (t ContentType) verb() string {
+	if t == ContentTypeComment {
+		return "comment"
+	}
+	return "post"
+}
+
+func validateCooldown(secs int) error {
+	if secs < 0 || secs > maxCommunityCooldown {
+		return httperr.NewBadRequest("invalid_cooldown", fmt.Sprintf("Cooldowns must be between 0 and %d seconds.", maxCommunityCooldown))
+	}
+	return nil
+}
+
+// checkCooldown returns a *CooldownError if user is not allowed to create a
+// post or comment (depending on content) in community yet.
+func checkCooldown(ctx context.Context, db *sql.DB, community, user uid.ID, content ContentType) error {
+	var col, table string
+	if content == ContentTypeComment {
+		col, table = "comment_cooldown", "comments"
+	} else {
+		col, table = "post_cooldown", "posts"
+	}
+
+	var secs int
+	if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT %s FROM communities WHERE id = ?", col), community).Scan(&secs); err != nil {
+		if err == sql.ErrNoRows {
+			return errCommunityNotFound
+		}
+		return err
+	}
+	if secs <= 0 {
+		return nil
+	}
+
+	var last msql.NullTime
+	q := fmt.Sprintf("SELECT MAX(created_at) FROM %s WHERE community_id = ? AND user_id = ?", table)
+	if err := db.QueryRowContext(ctx, q, community, user).Scan(&last); err != nil {
+		return err
+	}
+	if !last.Valid {
+		return nil
+	}
+	remaining := time.Duration(secs)*time.Second - time.Since(last.Time)
+	if remaining <= 0 {
+		return nil
+	}
+
+	if is, err := UserModOrAdmin(ctx, db, community, user); err != nil {
+		return err
+	} else if is {
+		return nil
+	}
+	return &CooldownError{Content: content, Remaining: remaining}
+}
@@ -0,0 +1,39 @@
+package core
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCooldownError(t *testing.T) {
+	err := &CooldownError{Content: ContentTypeComment, Remaining: 1500 * time.Millisecond}
+	if n := err.RemainingSeconds(); n != 2 {
+		t.Fatalf("expected 2 remaining seconds, got %d", n)
+	}
+
+	data, jerr := json.Marshal(err)
+	if jerr != nil {
+		t.Fatal(jerr)
+	}
+	var res map[string]any
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res["status"] != 429.0 || res["code"] != "cooldown" || res["contentType"] != "comment" || res["cooldownRemaining"] != 2.0 {
+		t.Fatalf("unexpected json: %s", data)
+	}
+}
+
+func TestValidateCooldown(t *testing.T) {
+	for _, secs := range []int{0, 30, maxCommunityCooldown} {
+		if err := validateCooldown(secs); err != nil {
+			t.Errorf("cooldown %d: unexpected error: %v", secs, err)
+		}
+	}
+	for _, secs := range []int{-1, maxCommunityCooldown + 1} {
+		if err := validateCooldown(secs); err == nil {
+			t.Errorf("cooldown %d: expected an error", secs)
+		}
+	}
+}
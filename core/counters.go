@@ -0,0 +1,168 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// All updates to denormalized counters (member counts, comment counts, vote
+// totals, user points, and so on) go through the functions in this file, so
+// that the rules they follow are in one place. The same rules are verified by
+// the checks in integrity.go; keep the two in sync.
+
+// execer is implemented by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// incrementCount adds delta to the count column of the rows of table with ids.
+// Counts never go below zero.
+func incrementCount(ctx context.Context, ex execer, table, column string, delta int, ids ...uid.ID) error {
+	if delta == 0 || len(ids) == 0 {
+		return nil
+	}
+	args := []any{delta}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	query := fmt.Sprintf("UPDATE %s SET %s = GREATEST(CAST(%s AS SIGNED) + ?, 0) WHERE id IN %s",
+		table, column, column, msql.InClauseQuestionMarks(len(ids)))
+	_, err := ex.ExecContext(ctx, query, args...)
+	return err
+}
+
+// incrementCommunityMembers adds delta to the member counts of communities.
+func incrementCommunityMembers(ctx context.Context, ex execer, delta int, communities ...uid.ID) error {
+	return incrementCount(ctx, ex, "communities", "no_members", delta, communities...)
+}
+
+// incrementCommunityPosts adds delta to the post count of community.
+func incrementCommunityPosts(ctx context.Context, ex execer, community uid.ID, delta int) error {
+	return incrementCount(ctx, ex, "communities", "posts_count", delta, community)
+}
+
+// incrementPostComments adds delta to the comment count of post. Deleted
+// comments are included in the count.
+func incrementPostComments(ctx context.Context, ex execer, post uid.ID, delta int) error {
+	return incrementCount(ctx, ex, "posts", "no_comments", delta, post)
+}
+
+// incrementCommentReplies adds delta to the count of the direct replies of
+// parent and to the count of all the replies of ancestors (the ancestors of a
+// reply, which include parent). Deleted replies are included in the counts.
+func incrementCommentReplies(ctx context.Context, ex execer, parent uid.ID, ancestors []uid.ID, delta int) error {
+	if err := incrementCount(ctx, ex, "comments", "no_replies_direct", delta, parent); err != nil {
+		return err
+	}
+	return incrementCount(ctx, ex, "comments", "no_replies", delta, ancestors...)
+}
+
+// incrementUserPosts adds delta to the count of user's (undeleted) posts.
+func incrementUserPosts(ctx context.Context, ex execer, user uid.ID, delta int) error {
+	return incrementCount(ctx, ex, "users", "no_posts", delta, user)
+}
+
+// incrementUserComments adds delta to the count of user's (undeleted)
+// comments.
+func incrementUserComments(ctx context.Context, ex execer, user uid.ID, delta int) error {
+	return incrementCount(ctx, ex, "users", "no_comments", delta, user)
+}
+
+// incrementUserPoints adds amount to user's points.
+func incrementUserPoints(ctx context.Context, ex execer, user uid.ID, amount int) error {
+	if amount == 0 {
+		return nil
+	}
+	_, err := ex.ExecContext(ctx, "UPDATE users SET points = points + ? WHERE id = ?", amount, user)
+	return err
+}
+
+// voteChange is the effect of a change in a user's vote on the vote counters of
+// a post or a comment, and on the points of its author.
+type voteChange struct {
+	upvotes, downvotes, points int
+	authorPoints               int
+}
+
+// newVoteChange returns the voteChange of a user's vote going from before to
+// after, where nil means no vote and otherwise the value is whether the vote
+// is an upvote. If selfVote is true, the voter is the author.
+//
+// The points of a post or comment are its upvotes minus its downvotes. The
+// points of a user are one plus the number of upvotes, other than their own,
+// on their posts and comments.
+func newVoteChange(before, after *bool, selfVote bool) voteChange {
+	var vc voteChange
+	for _, v := range []struct {
+		vote *bool
+		sign int
+	}{{before, -1}, {after, 1}} {
+		if v.vote == nil {
+			continue
+		}
+		if *v.vote {
+			vc.upvotes += v.sign
+			vc.points += v.sign
+			if !selfVote {
+				vc.authorPoints += v.sign
+			}
+		} else {
+			vc.downvotes += v.sign
+			vc.points -= v.sign
+		}
+	}
+	return vc
+}
+
+// apply updates the vote counters of the row with id of table (either posts or
+// comments) and the points of its author.
+func (vc voteChange) apply(ctx context.Context, tx *sql.Tx, table string, id, author uid.ID) error {
+	if vc.upvotes != 0 || vc.downvotes != 0 {
+		query := fmt.Sprintf(`UPDATE %s SET
+			upvotes = GREATEST(CAST(upvotes AS SIGNED) + ?, 0),
+			downvotes = GREATEST(CAST(downvotes AS SIGNED) + ?, 0),
+			points = points + ?
+			WHERE id = ?`, table)
+		if _, err := tx.ExecContext(ctx, query, vc.upvotes, vc.downvotes, vc.points, id); err != nil {
+			return err
+		}
+	}
+	return incrementUserPoints(ctx, tx, author, vc.authorPoints)
+}
+
+// updatePostsHotnessTx recomputes the hotness of posts with ids from their
+// current vote counts.
+func updatePostsHotnessTx(ctx context.Context, tx *sql.Tx, ids ...any) error {
+	rows, err := tx.QueryContext(ctx, "SELECT id, upvotes, downvotes, created_at FROM posts WHERE id IN "+msql.InClauseQuestionMarks(len(ids)), ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	hotness := make(map[uid.ID]int)
+	for rows.Next() {
+		var id uid.ID
+		var upvotes, downvotes int
+		var createdAt time.Time
+		if err := rows.Scan(&id, &upvotes, &downvotes, &createdAt); err != nil {
+			return err
+		}
+		hotness[id] = PostHotness(upvotes, downvotes, createdAt)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for id, h := range hotness {
+		if _, err := tx.ExecContext(ctx, "UPDATE posts SET hotness = ? WHERE id = ?", h, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
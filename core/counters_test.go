@@ -0,0 +1,30 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestNewVoteChange(t *testing.T) {
+	up, down := true, false
+	cases := []struct {
+		name          string
+		before, after *bool
+		selfVote      bool
+		want          voteChange
+	}{
+		{"upvote", nil, &up, false, voteChange{upvotes: 1, points: 1, authorPoints: 1}},
+		{"downvote", nil, &down, false, voteChange{downvotes: 1, points: -1}},
+		{"self upvote", nil, &up, true, voteChange{upvotes: 1, points: 1}},
+		{"delete upvote", &up, nil, false, voteChange{upvotes: -1, points: -1, authorPoints: -1}},
+		{"delete downvote", &down, nil, false, voteChange{downvotes: -1, points: 1}},
+		{"up to down", &up, &down, false, voteChange{upvotes: -1, downvotes: 1, points: -2, authorPoints: -1}},
+		{"down to up", &down, &up, false, voteChange{upvotes: 1, downvotes: -1, points: 2, authorPoints: 1}},
+		{"self down to up", &down, &up, true, voteChange{upvotes: 1, downvotes: -1, points: 2}},
+		{"no change", &up, &up, false, voteChange{}},
+	}
+	for _, item := range cases {
+		if got := newVoteChange(item.before, item.after, item.selfVote); got != item.want {
+			t.Errorf("%s: expected %+v, got %+v", item.name, item.want, got)
+		}
+	}
+}
@@ -0,0 +1,472 @@
+package core
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/email"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/logging"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Emails are sent to verify the email addresses of users, to reset forgotten
+// passwords, and, to users who opt in, as weekly digests of the top posts of
+// the communities they've joined. The messages are rendered from the
+// templates in emails/ (see email.Templates). Links in emails carry random
+// tokens, only hashes of which are stored, except for unsubscribe links,
+// which carry HMACs of the user IDs (so that they don't expire).
+
+//go:embed emails
+var emailTemplateFiles embed.FS
+
+var emailTemplates = func() *email.Templates {
+	t, err := email.ParseTemplates(emailTemplateFiles, "emails")
+	if err != nil {
+		panic(err)
+	}
+	return t
+}()
+
+// Names of email templates.
+const (
+	emailTemplateVerify        = "verify_email"
+	emailTemplateResetPassword = "reset_password"
+	emailTemplateWeeklyDigest  = "weekly_digest"
+)
+
+// EmailOptions are the options of the emails that are sent.
+type EmailOptions struct {
+	Sender   email.Sender
+	From     string // Like "Discuit <noreply@discuit.org>".
+	SiteName string
+	SiteURL  string // The base URL of links, like "https://discuit.org".
+
+	// The secret unsubscribe links are signed with.
+	Secret string
+}
+
+var (
+	emailOptionsMu sync.RWMutex // guards emailOptions
+	emailOptions   *EmailOptions
+)
+
+// SetEmailOptions enables sending emails. If opts is nil, no emails are sent
+// (and the functions that send them return an error).
+func SetEmailOptions(opts *EmailOptions) {
+	emailOptionsMu.Lock()
+	defer emailOptionsMu.Unlock()
+	emailOptions = opts
+}
+
+func getEmailOptions() *EmailOptions {
+	emailOptionsMu.RLock()
+	defer emailOptionsMu.RUnlock()
+	return emailOptions
+}
+
+// EmailEnabled reports whether emails are sent.
+func EmailEnabled() bool {
+	return getEmailOptions() != nil
+}
+
+var (
+	errEmailDisabled = &httperr.Error{
+		HTTPStatus: http.StatusNotFound,
+		Code:       "email_disabled",
+		Message:    "Emails are not enabled on this site.",
+	}
+	errInvalidEmailToken = httperr.NewBadRequest("invalid_email_token", "The link is invalid or has expired.")
+)
+
+// sendEmail sends the email of template, rendered with data, to to.
+func sendEmail(ctx context.Context, opts *EmailOptions, to, template string, data any, headers map[string]string) error {
+	m, err := emailTemplates.Render(template, data)
+	if err != nil {
+		return err
+	}
+	m.From, m.To, m.Headers = opts.From, to, headers
+	if err := opts.Sender.Send(ctx, m); err != nil {
+		return fmt.Errorf("sending %s email: %w", template, err)
+	}
+	return nil
+}
+
+// emailLinkData is what the templates of emails with a link in them are
+// executed with.
+type emailLinkData struct {
+	SiteName string
+	Username string
+	URL      string
+}
+
+// Purposes of email tokens.
+const (
+	emailTokenVerify        = "verify_email"
+	emailTokenResetPassword = "reset_password"
+)
+
+const (
+	verifyEmailTokenTTL   = time.Hour * 48
+	resetPasswordTokenTTL = time.Hour
+)
+
+func hashEmailToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// newEmailToken creates a token of purpose for user, for the email address
+// address, and returns it. The earlier tokens of purpose of user, if any,
+// are no longer valid.
+func newEmailToken(ctx context.Context, db *sql.DB, user uid.ID, purpose, address string, ttl time.Duration) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	t := now()
+	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "UPDATE email_tokens SET used_at = ? WHERE user_id = ? AND purpose = ? AND used_at IS NULL", t, user, purpose); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "INSERT INTO email_tokens (user_id, purpose, token_hash, email, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+			user, purpose, hashEmailToken(token), address, t, t.Add(ttl))
+		return err
+	})
+	return token, err
+}
+
+// useEmailToken marks token, of purpose, as used, and returns the user and
+// the email address it was created for. It returns errInvalidEmailToken if
+// the token doesn't exist, has expired, or was used already.
+func useEmailToken(ctx context.Context, tx *sql.Tx, token, purpose string) (user uid.ID, address string, err error) {
+	var (
+		id        int64
+		expiresAt time.Time
+		usedAt    msql.NullTime
+	)
+	row := tx.QueryRowContext(ctx, "SELECT id, user_id, email, expires_at, used_at FROM email_tokens WHERE token_hash = ? AND purpose = ? FOR UPDATE", hashEmailToken(token), purpose)
+	if err := row.Scan(&id, &user, &address, &expiresAt, &usedAt); err != nil {
+		if err == sql.ErrNoRows {
+			err = errInvalidEmailToken
+		}
+		return user, "", err
+	}
+	t := now()
+	if usedAt.Valid || !t.Before(expiresAt) {
+		return user, "", errInvalidEmailToken
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE email_tokens SET used_at = ? WHERE id = ?", t, id)
+	return user, address, err
+}
+
+// SendVerificationEmail sends user an email with a link to verify their email
+// address.
+func SendVerificationEmail(ctx context.Context, db *sql.DB, user *User) error {
+	opts := getEmailOptions()
+	if opts == nil {
+		return errEmailDisabled
+	}
+	if !user.Email.Valid || user.Email.String == "" {
+		return httperr.NewBadRequest("no_email", "No email address.")
+	}
+	if user.EmailConfirmedAt.Valid {
+		return httperr.NewBadRequest("email_verified", "Email address is already verified.")
+	}
+	token, err := newEmailToken(ctx, db, user.ID, emailTokenVerify, user.Email.String, verifyEmailTokenTTL)
+	if err != nil {
+		return err
+	}
+	return sendEmail(ctx, opts, user.Email.String, emailTemplateVerify, &emailLinkData{
+		SiteName: opts.SiteName,
+		Username: user.Username,
+		URL:      opts.SiteURL + "/verify-email?token=" + token,
+	}, nil)
+}
+
+// VerifyEmail marks the email address for which token was sent (with
+// SendVerificationEmail) as verified, if it's still the email address of the
+// user, and returns the user.
+func VerifyEmail(ctx context.Context, db *sql.DB, token string) (uid.ID, error) {
+	var user uid.ID
+	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		var (
+			address string
+			err     error
+		)
+		if user, address, err = useEmailToken(ctx, tx, token, emailTokenVerify); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, "UPDATE users SET email_confirmed_at = ? WHERE id = ? AND email = ? AND email_confirmed_at IS NULL", now(), user, address)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			// The email address was either verified already or changed.
+			var tmp int
+			err := tx.QueryRowContext(ctx, "SELECT 1 FROM users WHERE id = ? AND email = ?", user, address).Scan(&tmp)
+			if err == sql.ErrNoRows {
+				return errInvalidEmailToken
+			}
+			return err
+		}
+		return nil
+	})
+	return user, err
+}
+
+// RequestPasswordReset sends the user with the email address address an
+// email with a link to reset their password. To not disclose which email
+// addresses have accounts, it returns nil if there's no such user.
+func RequestPasswordReset(ctx context.Context, db *sql.DB, address string) error {
+	opts := getEmailOptions()
+	if opts == nil {
+		return errEmailDisabled
+	}
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return httperr.NewBadRequest("no_email", "No email address.")
+	}
+	user, err := GetUserByEmail(ctx, db, address, nil)
+	if err != nil {
+		if errors.Is(err, errUserNotFound) {
+			return nil
+		}
+		return err
+	}
+	if user.Deleted || user.Banned {
+		return nil
+	}
+	token, err := newEmailToken(ctx, db, user.ID, emailTokenResetPassword, address, resetPasswordTokenTTL)
+	if err != nil {
+		return err
+	}
+	return sendEmail(ctx, opts, address, emailTemplateResetPassword, &emailLinkData{
+		SiteName: opts.SiteName,
+		Username: user.Username,
+		URL:      opts.SiteURL + "/reset-password?token=" + token,
+	}, nil)
+}
+
+// ResetPassword sets the password of the user for whom token was sent (with
+// RequestPasswordReset) to password, and returns the user. As the user has
+// shown that the email address is theirs, it's marked as verified.
+func ResetPassword(ctx context.Context, db *sql.DB, token, password string) (uid.ID, error) {
+	hash, err := HashPassword([]byte(password))
+	if err != nil {
+		return uid.ID{}, err
+	}
+	var user uid.ID
+	err = msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		var (
+			address string
+			err     error
+		)
+		if user, address, err = useEmailToken(ctx, tx, token, emailTokenResetPassword); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `
+			UPDATE users SET
+				password = ?,
+				email_confirmed_at = COALESCE(email_confirmed_at, ?)
+			WHERE id = ? AND email = ? AND deleted_at IS NULL`, hash, now(), user, address)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return errInvalidEmailToken
+		}
+		return nil
+	})
+	return user, err
+}
+
+// EmailPreferences are the kinds of optional emails a user gets.
+type EmailPreferences struct {
+	WeeklyDigest bool `json:"weeklyDigest"`
+}
+
+// GetEmailPreferences returns the email preferences of user.
+func GetEmailPreferences(ctx context.Context, db *sql.DB, user uid.ID) (*EmailPreferences, error) {
+	prefs := &EmailPreferences{}
+	err := db.QueryRowContext(ctx, "SELECT weekly_digest FROM email_preferences WHERE user_id = ?", user).Scan(&prefs.WeeklyDigest)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+// UpdateEmailPreferences sets the email preferences of user to prefs.
+func UpdateEmailPreferences(ctx context.Context, db *sql.DB, user uid.ID, prefs *EmailPreferences) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO email_preferences (user_id, weekly_digest, updated_at) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE weekly_digest = VALUES(weekly_digest), updated_at = VALUES(updated_at)`,
+		user, prefs.WeeklyDigest, now())
+	return err
+}
+
+// emailUnsubscribeToken returns the token of the unsubscribe links of user.
+func emailUnsubscribeToken(opts *EmailOptions, user uid.ID) string {
+	mac := hmac.New(sha256.New, []byte("email-unsubscribe:"+opts.Secret))
+	mac.Write(user.Bytes())
+	return user.String() + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// UnsubscribeFromEmails turns off the optional emails of the user of token,
+// a token of an unsubscribe link.
+func UnsubscribeFromEmails(ctx context.Context, db *sql.DB, token string) error {
+	opts := getEmailOptions()
+	if opts == nil {
+		return errEmailDisabled
+	}
+	id, _, _ := strings.Cut(token, ".")
+	user, err := uid.FromString(id)
+	if err != nil || !hmac.Equal([]byte(token), []byte(emailUnsubscribeToken(opts, user))) {
+		return errInvalidEmailToken
+	}
+	_, err = db.ExecContext(ctx, "UPDATE email_preferences SET weekly_digest = false, updated_at = ? WHERE user_id = ?", now(), user)
+	return err
+}
+
+const (
+	weeklyDigestInterval = time.Hour * 24 * 7
+	weeklyDigestMaxPosts = 10
+)
+
+// weeklyDigestData is what the template of weekly digests is executed with.
+type weeklyDigestData struct {
+	SiteName       string
+	Username       string
+	Posts          []weeklyDigestPost
+	UnsubscribeURL string
+}
+
+type weeklyDigestPost struct {
+	Title       string
+	Community   string
+	Points      int
+	NumComments int
+	URL         string
+}
+
+// SendWeeklyDigests sends the weekly digests that are due, to at most limit
+// users, and returns how many were sent. Users with no posts in their
+// communities in the past week are sent none (and are not due again for
+// another week).
+func SendWeeklyDigests(ctx context.Context, db *sql.DB, limit int) (int, error) {
+	opts := getEmailOptions()
+	if opts == nil {
+		return 0, nil
+	}
+	t := now()
+	rows, err := db.QueryContext(ctx, `
+		SELECT users.id, users.username, users.email
+		FROM email_preferences
+		INNER JOIN users ON users.id = email_preferences.user_id
+		WHERE email_preferences.weekly_digest = true
+			AND (email_preferences.last_digest_at IS NULL OR email_preferences.last_digest_at <= ?)
+			AND users.email IS NOT NULL AND users.email_confirmed_at IS NOT NULL
+			AND users.deleted_at IS NULL AND users.is_bot = false
+		LIMIT ?`, t.Add(-weeklyDigestInterval), limit)
+	if err != nil {
+		return 0, err
+	}
+	type recipient struct {
+		id       uid.ID
+		username string
+		address  string
+	}
+	var recipients []recipient
+	for rows.Next() {
+		var r recipient
+		if err := rows.Scan(&r.id, &r.username, &r.address); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		recipients = append(recipients, r)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, r := range recipients {
+		posts, err := weeklyDigestPosts(ctx, db, opts, r.id, t.Add(-weeklyDigestInterval))
+		if err != nil {
+			return sent, err
+		}
+		if len(posts) > 0 {
+			// The List-Unsubscribe header is of the one-click kind (RFC 8058),
+			// which mail clients POST to.
+			token := url.QueryEscape(emailUnsubscribeToken(opts, r.id))
+			headers := map[string]string{
+				"List-Unsubscribe":      "<" + opts.SiteURL + "/api/_email/unsubscribe?token=" + token + ">",
+				"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+			}
+			if err := sendEmail(ctx, opts, r.address, emailTemplateWeeklyDigest, &weeklyDigestData{
+				SiteName:       opts.SiteName,
+				Username:       r.username,
+				Posts:          posts,
+				UnsubscribeURL: opts.SiteURL + "/unsubscribe?token=" + token,
+			}, headers); err != nil {
+				// A bad address shouldn't hold up the digests of everyone else.
+				logging.FromContext(ctx).Error("Error sending weekly digest", "user", r.id, "error", err)
+				continue
+			}
+			sent++
+		}
+		if _, err := db.ExecContext(ctx, "UPDATE email_preferences SET last_digest_at = ? WHERE user_id = ?", t, r.id); err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// weeklyDigestPosts returns the top posts, created since since, of the
+// communities user has joined.
+func weeklyDigestPosts(ctx context.Context, db *sql.DB, opts *EmailOptions, user uid.ID, since time.Time) ([]weeklyDigestPost, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT posts.public_id, posts.title, communities.name, posts.points, posts.no_comments
+		FROM posts
+		INNER JOIN community_members ON community_members.community_id = posts.community_id
+		INNER JOIN communities ON communities.id = posts.community_id
+		WHERE community_members.user_id = ? AND posts.created_at > ?
+			AND posts.deleted = false AND communities.deleted_at IS NULL
+		ORDER BY posts.points DESC, posts.id DESC
+		LIMIT ?`, user, since, weeklyDigestMaxPosts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var posts []weeklyDigestPost
+	for rows.Next() {
+		var (
+			p        weeklyDigestPost
+			publicID string
+		)
+		if err := rows.Scan(&publicID, &p.Title, &p.Community, &p.Points, &p.NumComments); err != nil {
+			return nil, err
+		}
+		p.URL = opts.SiteURL + "/" + p.Community + "/post/" + publicID
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
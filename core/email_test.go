@@ -0,0 +1,297 @@
+package core
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/email"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// fakeEmailSender records the emails it's sent.
+type fakeEmailSender struct {
+	mu   sync.Mutex
+	sent []*email.Message
+}
+
+func (s *fakeEmailSender) Send(ctx context.Context, m *email.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+// last returns the last email sent.
+func (s *fakeEmailSender) last(t *testing.T) *email.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.sent) == 0 {
+		t.Fatal("no email was sent")
+	}
+	return s.sent[len(s.sent)-1]
+}
+
+var emailTokenRegexp = regexp.MustCompile(`token=([^\s"&]+)`)
+
+// token returns the token of the link in m.
+func (s *fakeEmailSender) token(t *testing.T, m *email.Message) string {
+	match := emailTokenRegexp.FindStringSubmatch(m.Text)
+	if match == nil {
+		t.Fatalf("no token in email:\n%s", m.Text)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func (h *harness) enableEmail() *fakeEmailSender {
+	sender := &fakeEmailSender{}
+	SetEmailOptions(&EmailOptions{
+		Sender:   sender,
+		From:     "Discuit <noreply@example.com>",
+		SiteName: "Discuit",
+		SiteURL:  "https://example.com",
+		Secret:   "secret",
+	})
+	h.t.Cleanup(func() { SetEmailOptions(nil) })
+	return sender
+}
+
+func TestEmailTemplates(t *testing.T) {
+	m, err := emailTemplates.Render(emailTemplateWeeklyDigest, &weeklyDigestData{
+		SiteName: "Discuit",
+		Username: "alice",
+		Posts: []weeklyDigestPost{
+			{Title: "Cats & dogs", Community: "pets", Points: 10, NumComments: 2, URL: "https://example.com/pets/post/abc"},
+		},
+		UnsubscribeURL: "https://example.com/unsubscribe?token=x",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Subject != "This week in your communities on Discuit" {
+		t.Errorf("Subject is %q", m.Subject)
+	}
+	if !strings.Contains(m.Text, "Cats & dogs\npets · 10 points · 2 comments\nhttps://example.com/pets/post/abc") {
+		t.Errorf("Text is %q", m.Text)
+	}
+	if !strings.Contains(m.HTML, "Cats &amp; dogs") {
+		t.Errorf("HTML is %q", m.HTML)
+	}
+}
+
+func TestUnsubscribeToken(t *testing.T) {
+	opts := &EmailOptions{Secret: "secret"}
+	id := uid.New()
+	token := emailUnsubscribeToken(opts, id)
+	if token != emailUnsubscribeToken(opts, id) {
+		t.Error("the token of a user is not stable")
+	}
+	if token == emailUnsubscribeToken(opts, uid.New()) {
+		t.Error("the token doesn't depend on the user")
+	}
+	if token == emailUnsubscribeToken(&EmailOptions{Secret: "another secret"}, id) {
+		t.Error("the token doesn't depend on the secret")
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+	ctx := h.ctx
+
+	user, err := RegisterUser(ctx, db, "emailverify", "alice@example.com", "password", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SendVerificationEmail(ctx, db, user); err != errEmailDisabled {
+		t.Fatalf("SendVerificationEmail with emails disabled returned %v", err)
+	}
+	sender := h.enableEmail()
+
+	if err := SendVerificationEmail(ctx, db, user); err != nil {
+		t.Fatal(err)
+	}
+	first := sender.token(t, sender.last(t))
+	if err := SendVerificationEmail(ctx, db, user); err != nil {
+		t.Fatal(err)
+	}
+	m := sender.last(t)
+	if m.To != "alice@example.com" || !strings.Contains(m.Text, "emailverify") {
+		t.Errorf("got email %+v", m)
+	}
+	token := sender.token(t, m)
+
+	if _, err := VerifyEmail(ctx, db, first); err != errInvalidEmailToken {
+		t.Errorf("verifying with a replaced token returned %v", err)
+	}
+	if id, err := VerifyEmail(ctx, db, token); err != nil || id != user.ID {
+		t.Fatalf("VerifyEmail = %v, %v", id, err)
+	}
+	if _, err := VerifyEmail(ctx, db, token); err != errInvalidEmailToken {
+		t.Errorf("verifying with a used token returned %v", err)
+	}
+	user, err = GetUser(ctx, db, user.ID, &user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.EmailConfirmedAt.Valid {
+		t.Fatal("email is not verified")
+	}
+	if err := SendVerificationEmail(ctx, db, user); err == nil {
+		t.Error("sent a verification email for a verified email address")
+	}
+
+	// Changing the email address unverifies it, and the tokens sent for an
+	// earlier address don't verify the current one.
+	setEmail := func(address string) {
+		user.EmailPublic = &address
+		if err := user.Update(ctx, db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setEmail("alice@example.org")
+	if user.EmailConfirmedAt.Valid {
+		t.Error("changed email address is verified")
+	}
+	if err := SendVerificationEmail(ctx, db, user); err != nil {
+		t.Fatal(err)
+	}
+	stale := sender.token(t, sender.last(t))
+	setEmail("alice@example.com")
+	if _, err := VerifyEmail(ctx, db, stale); err != errInvalidEmailToken {
+		t.Errorf("verifying a changed email address returned %v", err)
+	}
+
+	// Tokens expire.
+	if err := SendVerificationEmail(ctx, db, user); err != nil {
+		t.Fatal(err)
+	}
+	token = sender.token(t, sender.last(t))
+	h.clock.Advance(verifyEmailTokenTTL)
+	if _, err := VerifyEmail(ctx, db, token); err != errInvalidEmailToken {
+		t.Errorf("verifying with an expired token returned %v", err)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+	ctx := h.ctx
+	sender := h.enableEmail()
+
+	user, err := RegisterUser(ctx, db, "passreset", "bob@example.com", "password", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RequestPasswordReset(ctx, db, "nobody@example.com"); err != nil {
+		t.Fatalf("RequestPasswordReset of an unknown address returned %v", err)
+	}
+	if len(sender.sent) != 0 {
+		t.Fatal("sent an email to an unknown address")
+	}
+	if err := RequestPasswordReset(ctx, db, "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	token := sender.token(t, sender.last(t))
+
+	if _, err := ResetPassword(ctx, db, token, "short"); err == nil {
+		t.Error("reset the password to one that's too short")
+	}
+	if id, err := ResetPassword(ctx, db, token, "new password"); err != nil || id != user.ID {
+		t.Fatalf("ResetPassword = %v, %v", id, err)
+	}
+	if _, err := ResetPassword(ctx, db, token, "another password"); err != errInvalidEmailToken {
+		t.Errorf("resetting with a used token returned %v", err)
+	}
+	if _, err := MatchLoginCredentials(ctx, db, "passreset", "new password"); err != nil {
+		t.Errorf("logging in with the new password: %v", err)
+	}
+	if user, err = GetUser(ctx, db, user.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !user.EmailConfirmedAt.Valid {
+		t.Error("resetting the password didn't verify the email address")
+	}
+
+	if err := RequestPasswordReset(ctx, db, "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	token = sender.token(t, sender.last(t))
+	h.clock.Advance(resetPasswordTokenTTL + time.Second)
+	if _, err := ResetPassword(ctx, db, token, "another password"); err != errInvalidEmailToken {
+		t.Errorf("resetting with an expired token returned %v", err)
+	}
+}
+
+func TestWeeklyDigests(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+	ctx := h.ctx
+	sender := h.enableEmail()
+
+	user, err := RegisterUser(ctx, db, "digester", "carol@example.com", "password", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, "UPDATE users SET email_confirmed_at = ? WHERE id = ?", h.clock.Now(), user.ID); err != nil {
+		t.Fatal(err)
+	}
+	comm, err := CreateCommunity(ctx, db, user.ID, 0, 100, "digestcomm", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, title := range []string{"Old news", "Fresh news"} {
+		if _, err := createPost(ctx, db, &createPostOpts{
+			postType:  PostTypeText,
+			author:    user.ID,
+			community: comm.ID,
+			title:     title,
+			createdAt: h.clock.Now().Add(-weeklyDigestInterval - time.Hour + time.Duration(i)*time.Hour*2),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if prefs, err := GetEmailPreferences(ctx, db, user.ID); err != nil || prefs.WeeklyDigest {
+		t.Fatalf("GetEmailPreferences = %+v, %v", prefs, err)
+	}
+	if n, err := SendWeeklyDigests(ctx, db, 100); err != nil || n != 0 {
+		t.Fatalf("SendWeeklyDigests to users who didn't opt in = %d, %v", n, err)
+	}
+	if err := UpdateEmailPreferences(ctx, db, user.ID, &EmailPreferences{WeeklyDigest: true}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := SendWeeklyDigests(ctx, db, 100); err != nil || n != 1 {
+		t.Fatalf("SendWeeklyDigests = %d, %v", n, err)
+	}
+	m := sender.last(t)
+	if !strings.Contains(m.Text, "Fresh news") || strings.Contains(m.Text, "Old news") {
+		t.Errorf("digest is %q", m.Text)
+	}
+	if m.Headers["List-Unsubscribe"] == "" {
+		t.Error("digest has no List-Unsubscribe header")
+	}
+
+	// Not again until a week has passed.
+	if n, err := SendWeeklyDigests(ctx, db, 100); err != nil || n != 0 {
+		t.Fatalf("SendWeeklyDigests again = %d, %v", n, err)
+	}
+
+	if err := UnsubscribeFromEmails(ctx, db, sender.token(t, m)+"x"); err != errInvalidEmailToken {
+		t.Errorf("unsubscribing with a bad token returned %v", err)
+	}
+	if err := UnsubscribeFromEmails(ctx, db, sender.token(t, m)); err != nil {
+		t.Fatal(err)
+	}
+	if prefs, err := GetEmailPreferences(ctx, db, user.ID); err != nil || prefs.WeeklyDigest {
+		t.Errorf("GetEmailPreferences after unsubscribing = %+v, %v", prefs, err)
+	}
+}
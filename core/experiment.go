@@ -0,0 +1,443 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+	"github.com/discuitnet/discuit/internal/utils"
+)
+
+// Experiments split the users of the site (or the students of a cohort) into
+// arms, to compare how the arms are treated. Users are assigned to arms at
+// random, weighted by the weights of the arms, upon first being looked up
+// (see Experiment.ArmOf); assignments are deterministic, and once made, they
+// stick.
+//
+// An experiment may be run in phases, during which communities may be frozen
+// (see Experiment.AddPhase). Arms may be treated differently in their feeds
+// (see ExperimentArm and FeedOptions.setExperimentArm).
+
+const (
+	maxExperimentNameLength = 255
+	maxExperimentArms       = 10
+)
+
+var errExperimentNotFound = httperr.NewNotFound("experiment_not_found", "Experiment not found.")
+
+// ExperimentPhase is a phase of an experiment, during which the communities of
+// FrozenCommunities are frozen.
+type ExperimentPhase struct {
+	ID                uid.ID    `json:"id"`
+	Name              string    `json:"name"`
+	StartsAt          time.Time `json:"startsAt"`
+	EndsAt            time.Time `json:"endsAt"`
+	FrozenCommunities []uid.ID  `json:"frozenCommunities"`
+}
+
+type Experiment struct {
+	ID        uid.ID             `json:"id"`
+	Name      string             `json:"name"`
+	CohortID  uid.NullID         `json:"cohortId"` // If set, only the students of the cohort take part.
+	CreatedBy uid.NullID         `json:"createdBy"`
+	CreatedAt time.Time          `json:"createdAt"`
+	Arms      []*ExperimentArm   `json:"arms"`
+	Phases    []*ExperimentPhase `json:"phases"`
+}
+
+type ExperimentArm struct {
+	ID       uid.ID `json:"id"`
+	Name     string `json:"name"`
+	Weight   int    `json:"weight"`
+	NumUsers int    `json:"noUsers"` // Users assigned so far.
+
+	// Treatments of the arm; nil ones are not applied.
+	FeedSort    *FeedSort `json:"feedSort"`    // Replaces the default sort of feeds.
+	BotExposure *int      `json:"botExposure"` // Percentage of the posts of bots shown in feeds.
+}
+
+// validateTreatments checks the treatments of arm.
+func (arm *ExperimentArm) validateTreatments() error {
+	if arm.FeedSort != nil && !arm.FeedSort.Valid() {
+		return ErrInvalidFeedSort
+	}
+	if arm.BotExposure != nil && (*arm.BotExposure < 0 || *arm.BotExposure > 100) {
+		return httperr.NewBadRequest("invalid_bot_exposure", "Bot exposure must be a percentage between 0 and 100.")
+	}
+	return nil
+}
+
+// treated reports whether arm has any treatment.
+func (arm *ExperimentArm) treated() bool {
+	return arm.FeedSort != nil || arm.BotExposure != nil
+}
+
+// feedSortValue returns the value of the feed_sort column of arm.
+func (arm *ExperimentArm) feedSortValue() (any, error) {
+	if arm.FeedSort == nil {
+		return nil, nil
+	}
+	text, err := arm.FeedSort.MarshalText()
+	return string(text), err
+}
+
+// CreateExperiment creates an experiment named name with arms (of which only
+// the names, weights, and treatments are used), on behalf of admin. If cohort is not nil,
+// only the students of the cohort take part in the experiment.
+func CreateExperiment(ctx context.Context, db *sql.DB, admin uid.ID, name string, cohort *uid.ID, arms []*ExperimentArm) (*Experiment, error) {
+	if is, err := IsAdmin(db, &admin); err != nil {
+		return nil, err
+	} else if !is {
+		return nil, errNotAdmin
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxExperimentNameLength {
+		return nil, httperr.NewBadRequest("invalid_name", fmt.Sprintf("Experiment name must be between 1 and %d characters long.", maxExperimentNameLength))
+	}
+	if len(arms) < 2 || len(arms) > maxExperimentArms {
+		return nil, httperr.NewBadRequest("invalid_arms", fmt.Sprintf("An experiment must have between 2 and %d arms.", maxExperimentArms))
+	}
+	seen := make(map[string]bool)
+	for _, arm := range arms {
+		arm.Name = strings.TrimSpace(arm.Name)
+		if arm.Name == "" || len(arm.Name) > maxExperimentNameLength {
+			return nil, httperr.NewBadRequest("invalid_arm_name", fmt.Sprintf("Arm names must be between 1 and %d characters long.", maxExperimentNameLength))
+		}
+		if seen[strings.ToLower(arm.Name)] {
+			return nil, httperr.NewBadRequest("duplicate_arm", fmt.Sprintf("There's more than one arm named %s.", arm.Name))
+		}
+		seen[strings.ToLower(arm.Name)] = true
+		if arm.Weight <= 0 {
+			return nil, httperr.NewBadRequest("invalid_arm_weight", "Arm weights must be positive.")
+		}
+		if err := arm.validateTreatments(); err != nil {
+			return nil, err
+		}
+	}
+	if cohort != nil {
+		if _, err := GetCohort(ctx, db, *cohort); err != nil {
+			return nil, err
+		}
+	}
+
+	id := uid.New()
+	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO experiments (id, name, cohort_id, created_by) VALUES (?, ?, ?, ?)", id, name, cohort, admin); err != nil {
+			if msql.IsErrDuplicateErr(err) {
+				return &httperr.Error{HTTPStatus: http.StatusConflict, Code: "experiment_exists", Message: "An experiment with that name already exists."}
+			}
+			return err
+		}
+		for _, arm := range arms {
+			feedSort, err := arm.feedSortValue()
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, "INSERT INTO experiment_arms (id, experiment_id, name, weight, feed_sort, bot_exposure) VALUES (?, ?, ?, ?, ?, ?)",
+				uid.New(), id, arm.Name, arm.Weight, feedSort, arm.BotExposure); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetExperiment(ctx, db, id)
+}
+
+func getExperiments(ctx context.Context, db *sql.DB, where string, args ...any) ([]*Experiment, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, name, cohort_id, created_by, created_at FROM experiments "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	experiments := []*Experiment{}
+	for rows.Next() {
+		e := &Experiment{}
+		if err := rows.Scan(&e.ID, &e.Name, &e.CohortID, &e.CreatedBy, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		experiments = append(experiments, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, e := range experiments {
+		if err := e.loadArms(ctx, db); err != nil {
+			return nil, err
+		}
+		if err := e.loadPhases(ctx, db); err != nil {
+			return nil, err
+		}
+	}
+	return experiments, nil
+}
+
+// GetExperiments returns all experiments, the latest first.
+func GetExperiments(ctx context.Context, db *sql.DB) ([]*Experiment, error) {
+	return getExperiments(ctx, db, "ORDER BY created_at DESC")
+}
+
+func GetExperiment(ctx context.Context, db *sql.DB, id uid.ID) (*Experiment, error) {
+	experiments, err := getExperiments(ctx, db, "WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(experiments) == 0 {
+		return nil, errExperimentNotFound
+	}
+	return experiments[0], nil
+}
+
+func (e *Experiment) loadArms(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, name, weight, feed_sort, bot_exposure, (SELECT COUNT(*) FROM experiment_assignments WHERE arm_id = experiment_arms.id)
+		FROM experiment_arms WHERE experiment_id = ? ORDER BY name`, e.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	e.Arms = []*ExperimentArm{}
+	for rows.Next() {
+		arm := &ExperimentArm{}
+		var feedSort sql.NullString
+		var botExposure sql.NullInt32
+		if err := rows.Scan(&arm.ID, &arm.Name, &arm.Weight, &feedSort, &botExposure, &arm.NumUsers); err != nil {
+			return err
+		}
+		if feedSort.Valid {
+			arm.FeedSort = new(FeedSort)
+			if err := arm.FeedSort.UnmarshalText([]byte(feedSort.String)); err != nil {
+				return err
+			}
+		}
+		if botExposure.Valid {
+			n := int(botExposure.Int32)
+			arm.BotExposure = &n
+		}
+		e.Arms = append(e.Arms, arm)
+	}
+	return rows.Err()
+}
+
+func (e *Experiment) loadPhases(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, "SELECT id, name, starts_at, ends_at FROM experiment_phases WHERE experiment_id = ? ORDER BY starts_at", e.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	e.Phases = []*ExperimentPhase{}
+	for rows.Next() {
+		phase := &ExperimentPhase{FrozenCommunities: []uid.ID{}}
+		if err := rows.Scan(&phase.ID, &phase.Name, &phase.StartsAt, &phase.EndsAt); err != nil {
+			return err
+		}
+		e.Phases = append(e.Phases, phase)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, phase := range e.Phases {
+		freezes, err := getFreezes(ctx, db, "WHERE phase_id = ?", phase.ID)
+		if err != nil {
+			return err
+		}
+		for _, f := range freezes {
+			phase.FrozenCommunities = append(phase.FrozenCommunities, f.CommunityID)
+		}
+	}
+	return nil
+}
+
+// AddPhase adds a phase named name to e, from startsAt to endsAt, on behalf of
+// admin. The communities of freeze are frozen for the duration of the phase.
+func (e *Experiment) AddPhase(ctx context.Context, db *sql.DB, admin uid.ID, name string, startsAt, endsAt time.Time, freeze []uid.ID) (*ExperimentPhase, error) {
+	if is, err := IsAdmin(db, &admin); err != nil {
+		return nil, err
+	} else if !is {
+		return nil, errNotAdmin
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxExperimentNameLength {
+		return nil, httperr.NewBadRequest("invalid_name", fmt.Sprintf("Phase name must be between 1 and %d characters long.", maxExperimentNameLength))
+	}
+	if err := validateFreezePeriod(startsAt, endsAt); err != nil {
+		return nil, err
+	}
+	for _, community := range freeze {
+		if _, err := GetCommunityByID(ctx, db, community, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	id := uid.New()
+	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO experiment_phases (id, experiment_id, name, starts_at, ends_at) VALUES (?, ?, ?, ?, ?)", id, e.ID, name, startsAt, endsAt); err != nil {
+			if msql.IsErrDuplicateErr(err) {
+				return &httperr.Error{HTTPStatus: http.StatusConflict, Code: "phase_exists", Message: "The experiment already has a phase with that name."}
+			}
+			return err
+		}
+		for _, community := range freeze {
+			if _, err := createFreezeTx(ctx, tx, community, &id, startsAt, endsAt, utils.TruncateUnicodeString(e.Name+": "+name, maxFreezeReasonLength), admin); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := e.loadPhases(ctx, db); err != nil {
+		return nil, err
+	}
+	for _, phase := range e.Phases {
+		if phase.ID == id {
+			return phase, nil
+		}
+	}
+	return nil, errExperimentNotFound
+}
+
+// Arm returns the arm of e with id, or nil if there's none.
+func (e *Experiment) Arm(id uid.ID) *ExperimentArm {
+	for _, arm := range e.Arms {
+		if arm.ID == id {
+			return arm
+		}
+	}
+	return nil
+}
+
+// SetArmTreatments sets the treatments of the arm of e with id arm to those of
+// to, on behalf of admin. Users already assigned to the arm get the new
+// treatments.
+func (e *Experiment) SetArmTreatments(ctx context.Context, db *sql.DB, admin, arm uid.ID, to *ExperimentArm) error {
+	if is, err := IsAdmin(db, &admin); err != nil {
+		return err
+	} else if !is {
+		return errNotAdmin
+	}
+	a := e.Arm(arm)
+	if a == nil {
+		return httperr.NewNotFound("arm_not_found", "Experiment arm not found.")
+	}
+	if err := to.validateTreatments(); err != nil {
+		return err
+	}
+	feedSort, err := to.feedSortValue()
+	if err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, "UPDATE experiment_arms SET feed_sort = ?, bot_exposure = ? WHERE id = ?", feedSort, to.BotExposure, arm); err != nil {
+		return err
+	}
+	a.FeedSort, a.BotExposure = to.FeedSort, to.BotExposure
+	return nil
+}
+
+// pickArm returns the arm user is to be assigned to, which is picked at
+// random (weighted by the weights of the arms) but is always the same for the
+// same user.
+func (e *Experiment) pickArm(user uid.ID) *ExperimentArm {
+	total := 0
+	for _, arm := range e.Arms {
+		total += arm.Weight
+	}
+	sum := sha256.Sum256(append(e.ID[:], user[:]...))
+	n := int(binary.BigEndian.Uint64(sum[:8]) % uint64(total))
+	for _, arm := range e.Arms {
+		if n < arm.Weight {
+			return arm
+		}
+		n -= arm.Weight
+	}
+	return e.Arms[len(e.Arms)-1]
+}
+
+// whereParticipant returns the condition that the users in column take part
+// in e: they're the students of the cohort of e, or if e has no cohort, any
+// user who is not an admin or a bot.
+func (e *Experiment) whereParticipant(column string) (string, []any) {
+	if e.CohortID.Valid {
+		return fmt.Sprintf("%s IN (SELECT user_id FROM cohort_members WHERE cohort_id = ? AND role = ?)", column), []any{e.CohortID.ID, CohortRoleStudent}
+	}
+	return fmt.Sprintf("%s IN (SELECT id FROM users WHERE deleted_at IS NULL AND is_admin = FALSE AND is_bot = FALSE)", column), nil
+}
+
+// ArmOf returns the arm user is assigned to, assigning user to one if user
+// isn't yet. It returns nil if user does not take part in e.
+func (e *Experiment) ArmOf(ctx context.Context, db *sql.DB, user uid.ID) (*ExperimentArm, error) {
+	var armID uid.ID
+	err := db.QueryRowContext(ctx, "SELECT arm_id FROM experiment_assignments WHERE experiment_id = ? AND user_id = ?", e.ID, user).Scan(&armID)
+	if err == nil {
+		return e.Arm(armID), nil
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	where, args := e.whereParticipant("?")
+	var participant bool
+	if err := db.QueryRowContext(ctx, "SELECT "+where, append([]any{user}, args...)...).Scan(&participant); err != nil {
+		return nil, err
+	}
+	if !participant {
+		return nil, nil
+	}
+
+	arm := e.pickArm(user)
+	if _, err := db.ExecContext(ctx, "INSERT IGNORE INTO experiment_assignments (experiment_id, user_id, arm_id) VALUES (?, ?, ?)", e.ID, user, arm.ID); err != nil {
+		return nil, err
+	}
+	return arm, nil
+}
+
+// assignAll assigns every participant of e who is not yet assigned to an arm.
+func (e *Experiment) assignAll(ctx context.Context, db *sql.DB) error {
+	where, args := e.whereParticipant("users.id")
+	rows, err := db.QueryContext(ctx, `
+		SELECT users.id FROM users
+		WHERE `+where+` AND NOT EXISTS (SELECT 1 FROM experiment_assignments WHERE experiment_id = ? AND user_id = users.id)`,
+		append(args, e.ID)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var users []uid.ID
+	for rows.Next() {
+		var id uid.ID
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		users = append(users, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		if _, err := db.ExecContext(ctx, "INSERT IGNORE INTO experiment_assignments (experiment_id, user_id, arm_id) VALUES (?, ?, ?)", e.ID, user, e.pickArm(user).ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// feedArmOf returns the arm of the latest experiment with treated arms that
+// user takes part in, or nil if user takes part in none. The arm returned may
+// itself be untreated (a control arm); the treatments of older experiments
+// are not applied to the users of newer ones.
+func feedArmOf(ctx context.Context, db *sql.DB, user uid.ID) (*ExperimentArm, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT experiments.id FROM experiments
+		WHERE EXISTS (
+			SELECT 1 FROM experiment_arms
+			WHERE experiment_arms.experiment_id = experiments.id AND (experiment_arms.feed_sort IS NOT NULL OR experiment_arms.bot_exposure IS NOT NULL)
+		)
+		ORDER BY experiments.created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := scanIDs(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		e, err := GetExperiment(ctx, db, id)
+		if err != nil {
+			return nil, err
+		}
+		arm, err := e.ArmOf(ctx, db, user)
+		if err != nil {
+			return nil, err
+		}
+		if arm != nil {
+			return arm, nil
+		}
+	}
+	return nil, nil
+}
+
+// setExperimentArm applies the treatments of the experiment arm of the viewer
+// of the feed of opts (see feedArmOf) to opts, and records that it did, as an
+// activity event. The feed sort of the arm replaces only the default sort;
+// sorts picked by the viewer are left alone.
+func (opts *FeedOptions) setExperimentArm(ctx context.Context, db *sql.DB) error {
+	if opts.Viewer == nil {
+		return nil
+	}
+	arm, err := feedArmOf(ctx, db, *opts.Viewer)
+	if err != nil || arm == nil || !arm.treated() {
+		return err
+	}
+	opts.arm = arm
+	if arm.FeedSort != nil && opts.DefaultSort {
+		opts.Sort = *arm.FeedSort
+	}
+
+	sort, _ := opts.Sort.MarshalText()
+	exposure := 100
+	if arm.BotExposure != nil {
+		exposure = *arm.BotExposure
+	}
+	e := &ActivityEvent{
+		Type:   ActivityFeed,
+		UserID: uid.NullID{ID: *opts.Viewer, Valid: true},
+		Detail: fmt.Sprintf("arm %s: sort %s, bot exposure %d%%", arm.Name, sort, exposure),
+	}
+	if opts.Community != nil {
+		e.CommunityID = *opts.Community
+	}
+	publishActivity(ctx, db, e)
+	return nil
+}
+
+// whereBotExposure leaves out of the feed of opts the posts of bots that the
+// experiment arm of the viewer is not exposed to. Which posts those are is
+// decided by a hash of the post and the viewer, so that the same posts are
+// left out on every load of the feed. postsTable is the table of the posts, as
+// in whereMutedAndHidden.
+func (opts *FeedOptions) whereBotExposure(where, postsTable string, args []any) (string, []any) {
+	if opts.arm == nil || opts.arm.BotExposure == nil || *opts.arm.BotExposure >= 100 {
+		return where, args
+	}
+	if !(where == "" || strings.TrimSpace(strings.ToUpper(where)) == "WHERE") {
+		where += "AND "
+	}
+	colName := "id"
+	if postsTable != "posts" {
+		colName = "post_id"
+	}
+	where += fmt.Sprintf("(%s.user_id NOT IN (SELECT id FROM users WHERE is_bot = TRUE) OR CRC32(CONCAT(%s.%s, ?)) %% 100 < ?) ", postsTable, postsTable, colName)
+	args = append(args, *opts.Viewer, *opts.arm.BotExposure)
+	return where, args
+}
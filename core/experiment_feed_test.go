@@ -0,0 +1,52 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestArmTreatments(t *testing.T) {
+	intp := func(n int) *int { return &n }
+	sort := FeedSortLatest
+	bad := FeedSort(100)
+
+	for _, test := range []struct {
+		arm   *ExperimentArm
+		valid bool
+	}{
+		{&ExperimentArm{}, true},
+		{&ExperimentArm{FeedSort: &sort, BotExposure: intp(50)}, true},
+		{&ExperimentArm{BotExposure: intp(0)}, true},
+		{&ExperimentArm{BotExposure: intp(101)}, false},
+		{&ExperimentArm{BotExposure: intp(-1)}, false},
+		{&ExperimentArm{FeedSort: &bad}, false},
+	} {
+		if err := test.arm.validateTreatments(); (err == nil) != test.valid {
+			t.Errorf("validateTreatments of %+v = %v", test.arm, err)
+		}
+	}
+
+	viewer := uid.New()
+	opts := &FeedOptions{Viewer: &viewer}
+	if where, _ := opts.whereBotExposure("WHERE posts.deleted = FALSE ", "posts", nil); where != "WHERE posts.deleted = FALSE " {
+		t.Errorf("whereBotExposure without an arm changed the clause: %q", where)
+	}
+	opts.arm = &ExperimentArm{BotExposure: intp(100)}
+	if _, args := opts.whereBotExposure("", "posts", nil); len(args) != 0 {
+		t.Error("whereBotExposure with full exposure added a condition")
+	}
+
+	opts.arm = &ExperimentArm{BotExposure: intp(30)}
+	where, args := opts.whereBotExposure("WHERE posts.deleted = FALSE ", "posts", nil)
+	if !strings.HasPrefix(where, "WHERE posts.deleted = FALSE AND (posts.user_id") || !strings.Contains(where, "CRC32(CONCAT(posts.id, ?)) % 100 < ?") {
+		t.Errorf("unexpected clause: %q", where)
+	}
+	if len(args) != 2 || args[1] != 30 {
+		t.Errorf("unexpected args: %v", args)
+	}
+	if where, _ := opts.whereBotExposure("", "posts_today", nil); !strings.HasPrefix(where, "(posts_today.user_id") || !strings.Contains(where, "posts_today.post_id") {
+		t.Errorf("unexpected clause for a top table: %q", where)
+	}
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+	"github.com/discuitnet/discuit/internal/utils"
+)
+
+// External identity providers.
+const (
+	ExternalProviderLDAP = "ldap"
+	ExternalProviderSAML = "saml"
+)
+
+// ExternalIdentity is an account at an external identity provider (an LDAP
+// directory or a SAML identity provider) with its attributes mapped to the
+// fields of Discuit profiles.
+type ExternalIdentity struct {
+	Provider string
+	Subject  string // The stable ID of the account at the provider (a DN or a NameID).
+
+	Username string // Of the Discuit account created for the identity.
+	Email    string // If empty, the email of the Discuit account isn't changed.
+	About    string // If empty, the about of the Discuit account isn't changed.
+}
+
+// errNoLinkedAccount is returned by LoginExternalIdentity when accounts are not
+// provisioned.
+var errNoLinkedAccount = httperr.NewForbidden("no_linked_account", "There's no account linked to your login.")
+
+// LoginExternalIdentity returns the user linked to id, updating the user's
+// email and about with those of id. If no user is linked to id and provision
+// is true, an account is created for id (with a username derived from
+// id.Username, and an unusable password) and linked to it.
+func LoginExternalIdentity(ctx context.Context, db *sql.DB, id *ExternalIdentity, provision bool) (*User, error) {
+	if id.Provider == "" || id.Subject == "" {
+		return nil, fmt.Errorf("incomplete external identity (provider: %q, subject: %q)", id.Provider, id.Subject)
+	}
+
+	var userID uid.ID
+	err := db.QueryRowContext(ctx, "SELECT user_id FROM external_identities WHERE provider = ? AND subject = ?", id.Provider, id.Subject).Scan(&userID)
+	if err == sql.ErrNoRows {
+		if !provision {
+			return nil, errNoLinkedAccount
+		}
+		user, err := provisionExternalUser(ctx, db, id)
+		if err != nil {
+			return nil, err
+		}
+		userID = user.ID
+	} else if err != nil {
+		return nil, err
+	}
+
+	if id.Email != "" {
+		if _, err := db.ExecContext(ctx, "UPDATE users SET email = ? WHERE id = ?", id.Email, userID); err != nil {
+			return nil, err
+		}
+	}
+	if id.About != "" {
+		about := utils.TruncateUnicodeString(id.About, maxUserProfileAboutLength)
+		if _, err := db.ExecContext(ctx, "UPDATE users SET about_me = ? WHERE id = ?", about, userID); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := db.ExecContext(ctx, "UPDATE external_identities SET last_login_at = current_timestamp() WHERE provider = ? AND subject = ?", id.Provider, id.Subject); err != nil {
+		return nil, err
+	}
+
+	user, err := GetUser(ctx, db, userID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if user.Deleted {
+		return nil, errNoLinkedAccount
+	}
+	return user, nil
+}
+
+// externalUsername returns the nth candidate for the username of the account
+// of id.
+func externalUsername(id *ExternalIdentity, n int) string {
+	name := id.Username
+	if name == "" {
+		// Say, the local part of an email address NameID.
+		name, _, _ = strings.Cut(id.Subject, "@")
+	}
+	return derivedUsername(name, n)
+}
+
+// derivedUsername returns the nth candidate for a username derived from name
+// (which need not be a valid username). Unlike usernameCandidate, the
+// candidate is never too short to be a valid username.
+func derivedUsername(name string, n int) string {
+	prefix := ""
+	if short := usernameCandidate("", name, 0); short == "" {
+		name = "user"
+	} else if len(short) < minUsernameLength {
+		prefix = "user_"
+	}
+	return usernameCandidate(prefix, name, n)
+}
+
+// provisionExternalUser creates an account for id (just-in-time provisioning)
+// and links it to id.
+func provisionExternalUser(ctx context.Context, db *sql.DB, id *ExternalIdentity) (*User, error) {
+	var username string
+	for n := 0; ; n++ {
+		username = externalUsername(id, n)
+		exists, _, err := usernameExists(ctx, db, username)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			break
+		}
+	}
+
+	user, err := RegisterUser(ctx, db, username, "", utils.GenerateStringID(48), "")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO external_identities (provider, subject, user_id) VALUES (?, ?, ?)", id.Provider, id.Subject, user.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,28 @@
+package core
+
+import "testing"
+
+func TestExternalUsername(t *testing.T) {
+	tests := []struct {
+		id   ExternalIdentity
+		n    int
+		want string
+	}{
+		{ExternalIdentity{Subject: "uid=alice,dc=example", Username: "alice"}, 0, "alice"},
+		{ExternalIdentity{Subject: "uid=alice,dc=example", Username: "alice"}, 2, "alice_2"},
+		{ExternalIdentity{Subject: "jane.doe@example.edu"}, 0, "janedoe"},
+		{ExternalIdentity{Subject: "x", Username: "Jo"}, 0, "user_Jo"},
+		{ExternalIdentity{Subject: "x", Username: "Jo"}, 1, "user_Jo_1"},
+		{ExternalIdentity{Subject: "...", Username: ""}, 0, "user"},
+		{ExternalIdentity{Subject: "x", Username: "a_very_long_directory_name"}, 1, "a_very_long_directo_1"},
+	}
+	for _, test := range tests {
+		got := externalUsername(&test.id, test.n)
+		if got != test.want {
+			t.Errorf("externalUsername(%+v, %d) = %q, want %q", test.id, test.n, got, test.want)
+		}
+		if err := IsUsernameValid(got); err != nil {
+			t.Errorf("externalUsername(%+v, %d) = %q: %v", test.id, test.n, got, err)
+		}
+	}
+}
@@ -225,6 +225,9 @@ type FeedOptions struct {
 	Homefeed    bool    // If true, the requested feed is the feed with only posts from communities where the user is a member
 	Limit       int
 	Next        string // The pagination cursor, taken from previous API response.
+
+	hideAgeRestricted bool           // Set by GetFeed.
+	arm               *ExperimentArm // Set by GetFeed.
 }
 
 var (
@@ -263,11 +266,46 @@ func (o *FeedOptions) nextInt64() (i int64, err error) {
 	return
 }
 
-func GetFeed(ctx context.Context, db *sql.DB, opts *FeedOptions) (_ *FeedResultSet, err error) {
+// GetFeed returns a page of the feed of opts. The feeds of communities are
+// those of GetCommunityPosts. Feeds are read from a replica of db, if it has
+// any (see msql.ReadDB), and those of logged out viewers from the feed cache,
+// if there is one (see SetFeedCache).
+func GetFeed(ctx context.Context, db *sql.DB, opts *FeedOptions) (*FeedResultSet, error) {
 	if !opts.Sort.Valid() {
 		return nil, ErrInvalidFeedSort
 	}
-	var set *FeedResultSet
+	if opts.Community != nil && !opts.Homefeed {
+		return GetCommunityPosts(ctx, db, *opts.Community, &CommunityPostsOptions{
+			Sort:        opts.Sort,
+			DefaultSort: opts.DefaultSort,
+			Viewer:      opts.Viewer,
+			PinnedFirst: opts.DefaultSort,
+			Limit:       opts.Limit,
+			Next:        opts.Next,
+		})
+	}
+	rdb := msql.ReadDB(db)
+	if err := opts.setAgeRestriction(ctx, rdb); err != nil {
+		return nil, err
+	}
+	if err := opts.checkPrivateAccess(ctx, rdb); err != nil {
+		return nil, err
+	}
+	// The viewer is assigned an arm, if they're not yet, on the primary.
+	if err := opts.setExperimentArm(ctx, db); err != nil {
+		return nil, err
+	}
+	if opts.Viewer == nil && !opts.Homefeed {
+		key := feedCacheKey("all", opts.Sort, opts.DefaultSort, opts.Limit, opts.Next)
+		return getCachedFeed(ctx, rdb, key, []string{siteFeedScope}, func() (*FeedResultSet, error) {
+			return getFeed(ctx, rdb, opts)
+		})
+	}
+	return getFeed(ctx, rdb, opts)
+}
+
+// getFeed is GetFeed once the checks and treatments of opts are done.
+func getFeed(ctx context.Context, db *sql.DB, opts *FeedOptions) (set *FeedResultSet, err error) {
 	if opts.Sort == FeedSortLatest {
 		set, err = getPostsLatest(ctx, db, opts)
 	} else if opts.Sort == FeedSortHot {
@@ -309,8 +347,16 @@ func getPostsLatest(ctx context.Context, db *sql.DB, opts *FeedOptions) (*FeedRe
 			args = append(args, *opts.Community)
 		}
 	}
+	if opts.Community == nil {
+		where = whereNotQuarantined(where)
+		where, args = whereNotPrivate(where, args, opts.Viewer)
+		if opts.hideAgeRestricted {
+			where = whereNotAgeRestricted(where)
+		}
+	}
 	if loggedIn {
 		where, args = whereMutedAndHidden(where, "posts", args, *opts.Viewer, opts.Community == nil && !opts.Homefeed)
+		where, args = opts.whereBotExposure(where, "posts", args)
 	}
 	if opts.Next != "" {
 		next, err := opts.nextID()
@@ -444,8 +490,16 @@ func getPostsHot(ctx context.Context, db *sql.DB, opts *FeedOptions) (*FeedResul
 			args = append(args, *opts.Community)
 		}
 	}
+	if opts.Community == nil {
+		where = whereNotQuarantined(where)
+		where, args = whereNotPrivate(where, args, opts.Viewer)
+		if opts.hideAgeRestricted {
+			where = whereNotAgeRestricted(where)
+		}
+	}
 	if loggedIn {
 		where, args = whereMutedAndHidden(where, "posts", args, *opts.Viewer, opts.Community == nil && !opts.Homefeed)
+		where, args = opts.whereBotExposure(where, "posts", args)
 	}
 	if opts.Next != "" {
 		nextHotness, nextID, err := opts.nextPointsID()
@@ -499,8 +553,16 @@ func getPostsTopAll(ctx context.Context, db *sql.DB, opts *FeedOptions) (*FeedRe
 			args = append(args, *opts.Community)
 		}
 	}
+	if opts.Community == nil {
+		where = whereNotQuarantined(where)
+		where, args = whereNotPrivate(where, args, opts.Viewer)
+		if opts.hideAgeRestricted {
+			where = whereNotAgeRestricted(where)
+		}
+	}
 	if loggedIn {
 		where, args = whereMutedAndHidden(where, "posts", args, *opts.Viewer, opts.Community == nil && !opts.Homefeed)
+		where, args = opts.whereBotExposure(where, "posts", args)
 	}
 	if opts.Next != "" {
 		nextPoints, nextID, err := opts.nextPointsID()
@@ -554,8 +616,16 @@ func getPostsTop(ctx context.Context, db *sql.DB, opts *FeedOptions) (*FeedResul
 			args = append(args, *opts.Community)
 		}
 	}
+	if opts.Community == nil {
+		where = whereNotQuarantined(where)
+		where, args = whereNotPrivate(where, args, opts.Viewer)
+		if opts.hideAgeRestricted {
+			where = whereNotAgeRestricted(where)
+		}
+	}
 	if opts.Viewer != nil {
 		where, args = whereMutedAndHidden(where, table, args, *opts.Viewer, opts.Community == nil && !opts.Homefeed)
+		where, args = opts.whereBotExposure(where, table, args)
 	}
 	if opts.Next != "" {
 		nextPoints, nextID, err := opts.nextPointsID()
@@ -618,8 +688,16 @@ func getPostsActivity(ctx context.Context, db *sql.DB, opts *FeedOptions) (*Feed
 			args = append(args, *opts.Community)
 		}
 	}
+	if opts.Community == nil {
+		where = whereNotQuarantined(where)
+		where, args = whereNotPrivate(where, args, opts.Viewer)
+		if opts.hideAgeRestricted {
+			where = whereNotAgeRestricted(where)
+		}
+	}
 	if loggedIn {
 		where, args = whereMutedAndHidden(where, "posts", args, *opts.Viewer, opts.Community == nil && !opts.Homefeed)
+		where, args = opts.whereBotExposure(where, "posts", args)
 	}
 	if opts.Next != "" {
 		next, err := opts.nextInt64()
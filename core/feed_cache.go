@@ -0,0 +1,201 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/discuitnet/discuit/internal/feedcache"
+	"github.com/discuitnet/discuit/internal/logging"
+	"github.com/discuitnet/discuit/internal/metrics"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// The feeds of logged out viewers are the same for all of them, and make up
+// most of the feeds served. So their pages are cached (see feedcache), as the
+// IDs of their posts, and the posts are cached separately, so that a post is
+// cached once however many feeds it's in. The pages of a feed are invalidated
+// when posts are added to it or removed from it (see invalidateFeedsOf); the
+// other changes to posts, like votes and comments, show once the cached pages
+// and posts expire.
+
+var (
+	feedCacheMu sync.RWMutex // guards feedCache
+	feedCache   *feedcache.Cache
+)
+
+var (
+	feedCacheHits   = metrics.NewCounter("discuit_feed_cache_hits_total", "Pages of feeds served from the feed cache.")
+	feedCacheMisses = metrics.NewCounter("discuit_feed_cache_misses_total", "Pages of feeds not found in the feed cache.")
+)
+
+// SetFeedCache sets the cache of the feeds of logged out viewers. If c is nil,
+// feeds are not cached.
+func SetFeedCache(c *feedcache.Cache) {
+	feedCacheMu.Lock()
+	defer feedCacheMu.Unlock()
+	feedCache = c
+}
+
+func getFeedCache() *feedcache.Cache {
+	feedCacheMu.RLock()
+	defer feedCacheMu.RUnlock()
+	return feedCache
+}
+
+// The scopes of the pages of feeds (see feedcache.Cache.Invalidate).
+const siteFeedScope = "site"
+
+func communityFeedScope(community uid.ID) string {
+	return "community:" + community.String()
+}
+
+// feedCacheKey returns the key of the page of a feed, named feed, sorted by
+// sort, of limit posts, after the cursor next.
+func feedCacheKey(feed string, sort FeedSort, defaultSort bool, limit int, next string) string {
+	s, _ := sort.MarshalText()
+	return fmt.Sprintf("%s:%s:%t:%d:%s", feed, s, defaultSort, limit, next)
+}
+
+// getCachedFeed returns the page of the feed with key, in scopes, from the
+// feed cache. If it's not cached, it's read with read, and cached. Only the
+// feeds of logged out viewers are to be cached.
+func getCachedFeed(ctx context.Context, db *sql.DB, key string, scopes []string, read func() (*FeedResultSet, error)) (*FeedResultSet, error) {
+	c := getFeedCache()
+	if c == nil {
+		return read()
+	}
+	page, slot, err := c.Get(key, scopes...)
+	if err != nil {
+		logging.FromContext(ctx).Error("Error getting a feed from the cache", "key", key, "error", err)
+		return read()
+	}
+	if page != nil {
+		set, err := hydrateFeedPage(ctx, db, c, page)
+		if err == nil {
+			feedCacheHits.Inc()
+			return set, nil
+		}
+		logging.FromContext(ctx).Error("Error hydrating a cached feed", "key", key, "error", err)
+	}
+	feedCacheMisses.Inc()
+
+	set, err := read()
+	if err != nil {
+		return nil, err
+	}
+	page = &feedcache.Page{IDs: make([]string, len(set.Posts))}
+	for i, post := range set.Posts {
+		page.IDs[i] = post.ID.String()
+	}
+	if page.Next, err = json.Marshal(set.Next); err != nil {
+		return nil, err
+	}
+	if err := c.Put(slot, page); err != nil {
+		logging.FromContext(ctx).Error("Error caching a feed", "key", key, "error", err)
+	} else if err := cachePosts(c, set.Posts); err != nil {
+		logging.FromContext(ctx).Error("Error caching the posts of a feed", "key", key, "error", err)
+	}
+	return set, nil
+}
+
+// cachedPost is a post as it's kept in the feed cache: its JSON, with the
+// fields left out of it that feeds need.
+type cachedPost struct {
+	*Post
+	Points int `json:"points"`
+}
+
+// cachePosts adds posts, as seen by logged out viewers, to the feed cache.
+func cachePosts(c *feedcache.Cache, posts []*Post) error {
+	objects := make(map[string][]byte, len(posts))
+	for _, post := range posts {
+		data, err := json.Marshal(cachedPost{Post: post, Points: post.Points})
+		if err != nil {
+			return err
+		}
+		objects[post.ID.String()] = data
+	}
+	return c.PutObjects("post", objects)
+}
+
+// hydrateFeedPage returns the posts of page, from the feed cache or, for those
+// not cached, from db. Posts deleted since the page was cached are left out.
+func hydrateFeedPage(ctx context.Context, db *sql.DB, c *feedcache.Cache, page *feedcache.Page) (*FeedResultSet, error) {
+	objects, err := c.GetObjects("post", page.IDs...)
+	if err != nil {
+		return nil, err
+	}
+	posts := make(map[string]*Post, len(page.IDs))
+	var missing []uid.ID
+	for _, id := range page.IDs {
+		if data, ok := objects[id]; ok {
+			cp := cachedPost{Post: &Post{}}
+			if err := json.Unmarshal(data, &cp); err == nil {
+				cp.Post.Points = cp.Points
+				posts[id] = cp.Post
+				continue
+			}
+		}
+		pid, err := uid.FromString(id)
+		if err != nil {
+			return nil, err
+		}
+		missing = append(missing, pid)
+	}
+	if len(missing) > 0 {
+		fetched, err := GetPostsByIDs(ctx, db, nil, false, missing...)
+		if err != nil && err != errPostNotFound {
+			return nil, err
+		}
+		for _, post := range fetched {
+			posts[post.ID.String()] = post
+		}
+		if err := cachePosts(c, fetched); err != nil {
+			logging.FromContext(ctx).Error("Error caching posts", "error", err)
+		}
+	}
+
+	set := &FeedResultSet{Posts: make([]*Post, 0, len(page.IDs)), Next: page.Next}
+	for _, id := range page.IDs {
+		if post, ok := posts[id]; ok && !post.Deleted {
+			set.Posts = append(set.Posts, post)
+		}
+	}
+	return set, nil
+}
+
+// invalidateFeedsOf makes the cached feeds that post is in, or is now to be
+// in, stale, and removes post from the feed cache. It's called after post is
+// created, deleted, restored, pinned, or unpinned.
+func invalidateFeedsOf(ctx context.Context, post *Post) {
+	c := getFeedCache()
+	if c == nil {
+		return
+	}
+	uncachePost(ctx, post)
+	if err := c.Invalidate(siteFeedScope, communityFeedScope(post.CommunityID)); err != nil {
+		logging.FromContext(ctx).Error("Error invalidating cached feeds", "post", post.ID, "error", err)
+	}
+}
+
+// uncachePost removes post from the feed cache, after it's changed in a way
+// that doesn't change the feeds it's in (say, it's edited or locked).
+func uncachePost(ctx context.Context, post *Post) {
+	if c := getFeedCache(); c != nil {
+		if err := c.DeleteObjects("post", post.ID.String()); err != nil {
+			logging.FromContext(ctx).Error("Error removing a post from the feed cache", "post", post.ID, "error", err)
+		}
+	}
+}
+
+// invalidateSiteFeeds makes the cached site-wide feeds stale.
+func invalidateSiteFeeds(ctx context.Context) {
+	if c := getFeedCache(); c != nil {
+		if err := c.Invalidate(siteFeedScope); err != nil {
+			logging.FromContext(ctx).Error("Error invalidating cached site feeds", "error", err)
+		}
+	}
+}
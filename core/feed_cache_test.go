@@ -0,0 +1,83 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/feedcache"
+	"github.com/discuitnet/discuit/internal/redistest"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestHydrateFeedPage(t *testing.T) {
+	c := feedcache.New(redistest.NewServer().Pool(), time.Minute, time.Minute)
+	a := &Post{ID: uid.New(), Title: "A", Upvotes: 3, Downvotes: 1, Points: 2}
+	b := &Post{ID: uid.New(), Title: "B", Deleted: true}
+	if err := cachePosts(c, []*Post{a, b}); err != nil {
+		t.Fatal(err)
+	}
+
+	page := &feedcache.Page{IDs: []string{a.ID.String(), b.ID.String()}, Next: []byte(`"next"`)}
+	set, err := hydrateFeedPage(context.Background(), nil, c, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Posts) != 1 {
+		t.Fatalf("got %d posts, want 1 (deleted posts are left out)", len(set.Posts))
+	}
+	if got := set.Posts[0]; got.ID != a.ID || got.Title != "A" || got.Points != 2 {
+		t.Errorf("hydrated post is %+v, want %+v", got, a)
+	}
+}
+
+func TestFeedCacheInvalidation(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+
+	SetFeedCache(feedcache.New(redistest.NewServer().Pool(), time.Minute, time.Minute))
+	defer SetFeedCache(nil)
+
+	author := h.newUser(db, "cached", false)
+	first := h.newPost(db, author, "cached")
+	opts := &FeedOptions{Sort: FeedSortLatest, Limit: 10}
+	feed := func() []*Post {
+		t.Helper()
+		o := *opts
+		set, err := GetFeed(h.ctx, db, &o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return set.Posts
+	}
+	if posts := feed(); len(posts) != 1 || posts[0].ID != first.ID {
+		t.Fatalf("feed has %d posts, want the first post", len(posts))
+	}
+
+	// Edits don't invalidate the feed, but show all the same.
+	if _, err := db.Exec("UPDATE posts SET title = ? WHERE id = ?", "Changed behind the cache", first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if posts := feed(); posts[0].Title == "Changed behind the cache" {
+		t.Error("the feed was not served from the cache")
+	}
+
+	second, err := createPost(h.ctx, db, &createPostOpts{
+		postType:  PostTypeText,
+		author:    author.ID,
+		community: first.CommunityID,
+		title:     "Another post",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if posts := feed(); len(posts) != 2 || posts[0].ID != second.ID {
+		t.Errorf("feed has %d posts after a post was created, want 2, the new one first", len(posts))
+	}
+	if err := second.Delete(h.ctx, db, author.ID, UserGroupNormal, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if posts := feed(); len(posts) != 1 {
+		t.Errorf("feed has %d posts after a post was deleted, want 1", len(posts))
+	}
+}
@@ -0,0 +1,222 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Admins can freeze a community for a period of time, during which no posts
+// or comments can be made in it (by anyone but admins). Freezes are either
+// made directly (see FreezeCommunity), or by the phases of experiments (see
+// Experiment.AddPhase). Freezes start and end on their own: a community is
+// frozen just when the current time is within one of its freezes, and the
+// current (or next) freeze of a community is handed out with it, so that a
+// countdown can be shown (see Community.Freeze).
+
+const maxFreezeReasonLength = 255
+
+var errFreezeNotFound = httperr.NewNotFound("freeze_not_found", "Freeze not found.")
+
+// CommunityFreeze is a period during which a community is frozen.
+type CommunityFreeze struct {
+	ID          uid.ID          `json:"id"`
+	CommunityID uid.ID          `json:"communityId"`
+	PhaseID     uid.NullID      `json:"phaseId"` // Of the experiment phase that made the freeze.
+	StartsAt    time.Time       `json:"startsAt"`
+	EndsAt      time.Time       `json:"endsAt"`
+	Reason      msql.NullString `json:"reason"`
+	CreatedBy   uid.NullID      `json:"createdBy"`
+	CreatedAt   time.Time       `json:"createdAt"`
+}
+
+// Active reports whether f is in effect at t.
+func (f *CommunityFreeze) Active(t time.Time) bool {
+	return !t.Before(f.StartsAt) && t.Before(f.EndsAt)
+}
+
+func validateFreezePeriod(startsAt, endsAt time.Time) error {
+	if !endsAt.After(startsAt) {
+		return httperr.NewBadRequest("invalid_period", "A freeze must end after it starts.")
+	}
+	if !endsAt.After(time.Now()) {
+		return httperr.NewBadRequest("invalid_period", "A freeze must end in the future.")
+	}
+	return nil
+}
+
+// createFreezeTx freezes community from startsAt to endsAt.
+func createFreezeTx(ctx context.Context, tx *sql.Tx, community uid.ID, phase *uid.ID, startsAt, endsAt time.Time, reason string, creator uid.ID) (uid.ID, error) {
+	var r any
+	if reason != "" {
+		r = reason
+	}
+	id := uid.New()
+	_, err := tx.ExecContext(ctx, "INSERT INTO community_freezes (id, community_id, phase_id, starts_at, ends_at, reason, created_by) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		id, community, phase, startsAt, endsAt, r, creator)
+	return id, err
+}
+
+// FreezeCommunity freezes community from startsAt to endsAt, on behalf of
+// admin. If startsAt is zero, the freeze starts right away.
+func FreezeCommunity(ctx context.Context, db *sql.DB, admin, community uid.ID, startsAt, endsAt time.Time, reason string) (*CommunityFreeze, error) {
+	if is, err := IsAdmin(db, &admin); err != nil {
+		return nil, err
+	} else if !is {
+		return nil, errNotAdmin
+	}
+	if startsAt.IsZero() {
+		startsAt = time.Now()
+	}
+	if err := validateFreezePeriod(startsAt, endsAt); err != nil {
+		return nil, err
+	}
+	reason = strings.TrimSpace(reason)
+	if len(reason) > maxFreezeReasonLength {
+		return nil, httperr.NewBadRequest("invalid_reason", fmt.Sprintf("Reason cannot be longer than %d characters.", maxFreezeReasonLength))
+	}
+	if _, err := GetCommunityByID(ctx, db, community, nil); err != nil {
+		return nil, err
+	}
+
+	var id uid.ID
+	err := msql.Transact(ctx, db, func(tx *sql.Tx) (err error) {
+		id, err = createFreezeTx(ctx, tx, community, nil, startsAt, endsAt, reason, admin)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return getFreeze(ctx, db, "WHERE id = ?", id)
+}
+
+func getFreezes(ctx context.Context, db *sql.DB, where string, args ...any) ([]*CommunityFreeze, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, community_id, phase_id, starts_at, ends_at, reason, created_by, created_at FROM community_freezes "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	freezes := []*CommunityFreeze{}
+	for rows.Next() {
+		f := &CommunityFreeze{}
+		if err := rows.Scan(&f.ID, &f.CommunityID, &f.PhaseID, &f.StartsAt, &f.EndsAt, &f.Reason, &f.CreatedBy, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		freezes = append(freezes, f)
+	}
+	return freezes, rows.Err()
+}
+
+func getFreeze(ctx context.Context, db *sql.DB, where string, args ...any) (*CommunityFreeze, error) {
+	freezes, err := getFreezes(ctx, db, where, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(freezes) == 0 {
+		return nil, errFreezeNotFound
+	}
+	return freezes[0], nil
+}
+
+// GetCommunityFreezes returns the freezes of community that have not ended,
+// or if all is true, all of them, the earliest first.
+func GetCommunityFreezes(ctx context.Context, db *sql.DB, community uid.ID, all bool) ([]*CommunityFreeze, error) {
+	if all {
+		return getFreezes(ctx, db, "WHERE community_id = ? ORDER BY starts_at", community)
+	}
+	return getFreezes(ctx, db, "WHERE community_id = ? AND ends_at > ? ORDER BY starts_at", community, time.Now())
+}
+
+// LiftCommunityFreeze ends the freeze with id of community now, on behalf of
+// admin. A freeze that has not started yet is deleted.
+func LiftCommunityFreeze(ctx context.Context, db *sql.DB, admin, community, id uid.ID) error {
+	if is, err := IsAdmin(db, &admin); err != nil {
+		return err
+	} else if !is {
+		return errNotAdmin
+	}
+	f, err := getFreeze(ctx, db, "WHERE id = ? AND community_id = ?", id, community)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if f.StartsAt.After(now) {
+		_, err = db.ExecContext(ctx, "DELETE FROM community_freezes WHERE id = ?", f.ID)
+	} else if f.EndsAt.After(now) {
+		_, err = db.ExecContext(ctx, "UPDATE community_freezes SET ends_at = ? WHERE id = ?", now, f.ID)
+	}
+	return err
+}
+
+// populateFreezes sets the Freeze field of comms.
+func populateFreezes(ctx context.Context, db *sql.DB, comms []*Community) error {
+	if len(comms) == 0 {
+		return nil
+	}
+	args := make([]any, 0, len(comms)+1)
+	for _, c := range comms {
+		args = append(args, c.ID)
+	}
+	args = append(args, time.Now())
+	freezes, err := getFreezes(ctx, db, "WHERE community_id IN "+msql.InClauseQuestionMarks(len(comms))+" AND ends_at > ? ORDER BY starts_at", args...)
+	if err != nil {
+		return err
+	}
+	for _, c := range comms {
+		for _, f := range freezes {
+			if f.CommunityID == c.ID {
+				c.Freeze = f
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// frozenCommunities returns the communities that are frozen now.
+func frozenCommunities(ctx context.Context, db *sql.DB) (map[uid.ID]bool, error) {
+	now := time.Now()
+	rows, err := db.QueryContext(ctx, "SELECT community_id FROM community_freezes WHERE starts_at <= ? AND ends_at > ?", now, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	frozen := make(map[uid.ID]bool)
+	for rows.Next() {
+		var id uid.ID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		frozen[id] = true
+	}
+	return frozen, rows.Err()
+}
+
+// checkCommunityNotFrozen returns an error if community is frozen, unless
+// user is an admin.
+func checkCommunityNotFrozen(ctx context.Context, db *sql.DB, community, user uid.ID) error {
+	now := time.Now()
+	var endsAt msql.NullTime
+	if err := db.QueryRowContext(ctx, "SELECT MAX(ends_at) FROM community_freezes WHERE community_id = ? AND starts_at <= ? AND ends_at > ?",
+		community, now, now).Scan(&endsAt); err != nil {
+		return err
+	}
+	if !endsAt.Valid {
+		return nil
+	}
+	if is, err := IsAdmin(db, &user); err != nil {
+		return err
+	} else if is {
+		return nil
+	}
+	return httperr.NewForbidden("community_frozen", fmt.Sprintf("Posting and commenting in this community are paused until %s.", endsAt.Time.UTC().Format(time.RFC1123)))
+}
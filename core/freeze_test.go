@@ -0,0 +1,25 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCommunityFreezeActive(t *testing.T) {
+	start := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	f := &CommunityFreeze{StartsAt: start, EndsAt: start.Add(2 * time.Hour)}
+	tests := []struct {
+		t    time.Time
+		want bool
+	}{
+		{start.Add(-time.Second), false},
+		{start, true},
+		{start.Add(time.Hour), true},
+		{start.Add(2 * time.Hour), false},
+	}
+	for _, test := range tests {
+		if got := f.Active(test.t); got != test.want {
+			t.Errorf("Active(%v) = %v, want %v", test.t, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"image"
+	"image/png"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/clock"
+	"github.com/discuitnet/discuit/internal/dbtest"
+	"github.com/discuitnet/discuit/internal/images"
+	"github.com/discuitnet/discuit/internal/images/imagestest"
+	"github.com/discuitnet/discuit/internal/llm"
+	"github.com/discuitnet/discuit/internal/llm/llmtest"
+	"github.com/discuitnet/discuit/internal/rng"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// testImages is the store of the images of tests.
+var testImages = imagestest.NewMemStore("test")
+
+func TestMain(m *testing.M) {
+	if err := images.RegisterStore(context.Background(), testImages); err != nil {
+		panic(err)
+	}
+	os.Exit(dbtest.Main(m))
+}
+
+// harness sets core up for a test, with a fake language model for bots, a fake
+// clock, and a seeded source of random numbers, and restores what it replaced
+// once the test is done. Tests that need a database get one with db, and are
+// skipped if there's none (see dbtest).
+type harness struct {
+	t     *testing.T
+	ctx   context.Context
+	llm   *llmtest.Fake
+	clock *clock.Fake
+	rand  *rng.Seeded
+}
+
+func newHarness(t *testing.T) *harness {
+	h := &harness{
+		t:     t,
+		ctx:   context.Background(),
+		llm:   &llmtest.Fake{},
+		clock: clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)),
+		rand:  rng.New(1),
+	}
+
+	SetBotLLMProvider(h.llm)
+	SetClock(h.clock)
+	SetRand(h.rand)
+	prevBudget := defaultBotBudget
+	defaultBotBudget = &botBudget{}
+	prevHalted := botsHalted.Swap(false)
+	t.Cleanup(func() {
+		SetBotLLMProvider(&llm.OpenAI{})
+		SetClock(clock.Real{})
+		SetRand(rng.Global{})
+		defaultBotBudget = prevBudget
+		botsHalted.Store(prevHalted)
+	})
+	return h
+}
+
+// db returns the test database, in a transaction that is rolled back once
+// the test is done.
+func (h *harness) db() *sql.DB {
+	return dbtest.Open(h.t)
+}
+
+// newUser registers a user named name, who is a bot if bot is true.
+func (h *harness) newUser(db *sql.DB, name string, bot bool) *User {
+	user, err := RegisterUser(h.ctx, db, name, "", "password", "")
+	if err != nil {
+		h.t.Fatalf("registering %s: %v", name, err)
+	}
+	if bot {
+		if err := PromoteToBot(h.ctx, db, user.ID); err != nil {
+			h.t.Fatal(err)
+		}
+		user.IsBot = true
+	}
+	return user
+}
+
+// newPost creates a community named community, created by author, with a text
+// post of author in it.
+func (h *harness) newPost(db *sql.DB, author *User, community string) *Post {
+	comm, err := CreateCommunity(h.ctx, db, author.ID, 0, 100, community, "")
+	if err != nil {
+		h.t.Fatalf("creating community %s: %v", community, err)
+	}
+	post, err := createPost(h.ctx, db, &createPostOpts{
+		postType:  PostTypeText,
+		author:    author.ID,
+		community: comm.ID,
+		title:     "A post",
+		body:      "The body of the post.",
+	})
+	if err != nil {
+		h.t.Fatalf("creating post: %v", err)
+	}
+	return post
+}
+
+// newImagePost creates a community named community, created by author, with
+// an image post of author in it, whose image is saved to testImages.
+func (h *harness) newImagePost(db *sql.DB, author *User, community string) *Post {
+	comm, err := CreateCommunity(h.ctx, db, author.ID, 0, 100, community, "")
+	if err != nil {
+		h.t.Fatalf("creating community %s: %v", community, err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		h.t.Fatal(err)
+	}
+	var imageID uid.ID
+	if err := msql.Transact(h.ctx, db, func(tx *sql.Tx) (err error) {
+		imageID, err = images.SaveImageTx(h.ctx, tx, testImages.Name(), bytes.NewReader(buf.Bytes()), nil)
+		return
+	}); err != nil {
+		h.t.Fatalf("saving image: %v", err)
+	}
+	post, err := createPost(h.ctx, db, &createPostOpts{
+		postType:  PostTypeImage,
+		author:    author.ID,
+		community: comm.ID,
+		title:     "An image post",
+		images:    []*ImageUpload{{ImageID: imageID}},
+	})
+	if err != nil {
+		h.t.Fatalf("creating image post: %v", err)
+	}
+	return h.getPost(db, post.ID)
+}
+
+// getPost returns the post with id, even if it's deleted.
+func (h *harness) getPost(db *sql.DB, id uid.ID) *Post {
+	post, err := GetPost(h.ctx, db, &id, "", nil, true)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return post
+}
+
+// storedImages returns the number of image files in testImages.
+func (h *harness) storedImages() int {
+	n := 0
+	if err := testImages.List(h.ctx, func(string, time.Time) error {
+		n++
+		return nil
+	}); err != nil {
+		h.t.Fatal(err)
+	}
+	return n
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/images"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Profile pictures and banners that were cropped when uploaded can be cropped
+// again, from the images they were cropped from (see images.RecropImageTx),
+// without uploading them again.
+
+var errImageNotCropped = httperr.NewBadRequest("image_not_cropped", "The image was not cropped when uploaded, and cannot be cropped again.")
+
+// recropImage crops the image with id, the image in column of the row of table
+// with rowID, again, to crop, and replaces the image with the new one, which
+// it returns.
+func recropImage(ctx context.Context, db *sql.DB, table, column string, rowID, id uid.ID, crop images.CropRect, opts *images.ImageOptions) (*images.Image, error) {
+	var newImageID uid.ID
+	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		imageID, err := images.RecropImageTx(ctx, tx, db, id, crop, opts)
+		if err != nil {
+			return err
+		}
+		query := fmt.Sprintf("UPDATE %s SET %s = ? WHERE id = ? AND %s = ?", table, column, column)
+		if res, err := tx.ExecContext(ctx, query, imageID, rowID, id); err != nil {
+			return err
+		} else if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return &httperr.Error{HTTPStatus: http.StatusConflict, Code: "image_changed", Message: "The image was changed meanwhile."}
+		}
+		if err := images.DeleteImagesTx(ctx, tx, db, id); err != nil {
+			return err
+		}
+		newImageID = imageID
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	images.QueuePostProcessing(newImageID)
+
+	record, err := images.GetImageRecord(ctx, db, newImageID)
+	if err != nil {
+		return nil, err
+	}
+	return record.Image(), nil
+}
+
+// RecropProPic crops the profile picture of u again, to crop.
+func (u *User) RecropProPic(ctx context.Context, db *sql.DB, crop images.CropRect) error {
+	if u.Deleted {
+		return ErrUserDeleted
+	}
+	if u.ProPic == nil || u.ProPic.Crop == nil {
+		return errImageNotCropped
+	}
+	image, err := recropImage(ctx, db, "users", "pro_pic", u.ID, *u.ProPic.ID, crop, &images.ImageOptions{
+		Width:  2000,
+		Height: 2000,
+		Format: images.ImageFormatJPEG,
+		Fit:    images.ImageFitContain,
+	})
+	if err != nil {
+		return err
+	}
+	u.ProPic = image
+	setCommunityProPicCopies(u.ProPic)
+	return nil
+}
+
+// RecropProPic crops the profile picture of c again, to crop.
+func (c *Community) RecropProPic(ctx context.Context, db *sql.DB, crop images.CropRect) error {
+	if c.Quarantined() {
+		return errQuarantinedNoImages
+	}
+	if c.ProPic == nil || c.ProPic.Crop == nil {
+		return errImageNotCropped
+	}
+	image, err := recropImage(ctx, db, "communities", "pro_pic_2", c.ID, *c.ProPic.ID, crop, &images.ImageOptions{
+		Width:  2000,
+		Height: 2000,
+		Format: images.ImageFormatJPEG,
+		Fit:    images.ImageFitContain,
+	})
+	if err != nil {
+		return err
+	}
+	c.ProPic = image
+	setCommunityProPicCopies(c.ProPic)
+	return nil
+}
+
+// RecropBannerImage crops the banner image of c again, to crop.
+func (c *Community) RecropBannerImage(ctx context.Context, db *sql.DB, crop images.CropRect) error {
+	if c.Quarantined() {
+		return errQuarantinedNoImages
+	}
+	if c.BannerImage == nil || c.BannerImage.Crop == nil {
+		return errImageNotCropped
+	}
+	image, err := recropImage(ctx, db, "communities", "banner_image_2", c.ID, *c.BannerImage.ID, crop, &images.ImageOptions{
+		Width:  2000,
+		Height: 2000,
+		Format: images.ImageFormatJPEG,
+		Fit:    images.ImageFitContain,
+	})
+	if err != nil {
+		return err
+	}
+	c.BannerImage = image
+	setCommunityBannerCopies(c.BannerImage)
+	return nil
+}
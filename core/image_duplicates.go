@@ -0,0 +1,173 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/images"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Images posted in a community are checked against those posted in it
+// recently, by their perceptual hashes (see images.PerceptualHash), to catch
+// reposts: images that are near-duplicates of them either get a warning (on
+// upload; see CheckDuplicateImages), or are not allowed to be posted, as set
+// with SetDuplicateImageCheck.
+
+// DuplicateImageAction is what's done with an image that's a near-duplicate
+// of one posted recently in the same community.
+type DuplicateImageAction string
+
+// Valid DuplicateImageAction values.
+const (
+	DuplicateImageActionNone  = DuplicateImageAction("off")
+	DuplicateImageActionWarn  = DuplicateImageAction("warn")
+	DuplicateImageActionBlock = DuplicateImageAction("block")
+)
+
+// DuplicateImageCheck is how images are checked for near-duplicates.
+type DuplicateImageCheck struct {
+	Action DuplicateImageAction
+	Window time.Duration // How far back images are checked against.
+
+	// Images whose perceptual hashes are at most MaxDistance apart are
+	// near-duplicates.
+	MaxDistance int
+}
+
+// Validate reports whether c is a valid check.
+func (c *DuplicateImageCheck) Validate() error {
+	switch c.Action {
+	case DuplicateImageActionNone, DuplicateImageActionWarn, DuplicateImageActionBlock:
+	default:
+		return fmt.Errorf("invalid duplicate image action %q", c.Action)
+	}
+	if c.Action != DuplicateImageActionNone {
+		if c.Window <= 0 {
+			return errors.New("duplicate image window must be positive")
+		}
+		if c.MaxDistance < 0 || c.MaxDistance > 64 {
+			return errors.New("duplicate image distance must be between 0 and 64")
+		}
+	}
+	return nil
+}
+
+var (
+	duplicateImageCheckMu sync.RWMutex // guards duplicateImageCheck
+	duplicateImageCheck   = DuplicateImageCheck{Action: DuplicateImageActionNone}
+)
+
+// SetDuplicateImageCheck sets how images are checked for near-duplicates. By
+// default, they're not.
+func SetDuplicateImageCheck(c DuplicateImageCheck) {
+	duplicateImageCheckMu.Lock()
+	defer duplicateImageCheckMu.Unlock()
+	duplicateImageCheck = c
+}
+
+// GetDuplicateImageCheck returns how images are checked for near-duplicates.
+func GetDuplicateImageCheck() DuplicateImageCheck {
+	duplicateImageCheckMu.RLock()
+	defer duplicateImageCheckMu.RUnlock()
+	return duplicateImageCheck
+}
+
+// DuplicateImage is an image that's a near-duplicate of one posted recently.
+type DuplicateImage struct {
+	ImageID      uid.ID    `json:"imageId"`
+	DuplicateOf  uid.ID    `json:"duplicateOf"` // The image posted before.
+	PostID       uid.ID    `json:"postId"`      // The post of DuplicateOf.
+	PostPublicID string    `json:"postPublicId"`
+	PostedAt     time.Time `json:"postedAt"`
+	Distance     int       `json:"distance"` // See images.PerceptualHash.Distance.
+}
+
+// FindDuplicateImages returns the images of imageIDs that are near-duplicates
+// of images posted in community in the last window (along with the closest of
+// those images). Images without perceptual hashes are left out.
+func FindDuplicateImages(ctx context.Context, db *sql.DB, community uid.ID, window time.Duration, maxDistance int, imageIDs ...uid.ID) ([]*DuplicateImage, error) {
+	if len(imageIDs) == 0 {
+		return nil, nil
+	}
+	args := make([]any, len(imageIDs))
+	for i := range imageIDs {
+		args[i] = imageIDs[i]
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT id, phash FROM images WHERE phash IS NOT NULL AND id IN %s", msql.InClauseQuestionMarks(len(imageIDs))), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hashes := make(map[uid.ID]images.PerceptualHash)
+	for rows.Next() {
+		var (
+			id uid.ID
+			h  images.PerceptualHash
+		)
+		if err := rows.Scan(&id, &h); err != nil {
+			return nil, err
+		}
+		hashes[id] = h
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var dups []*DuplicateImage
+	since := now().Add(-window)
+	for _, id := range imageIDs {
+		h, ok := hashes[id]
+		if !ok {
+			continue
+		}
+		dup := &DuplicateImage{ImageID: id}
+		err := db.QueryRowContext(ctx, `
+			SELECT post_images.image_id, posts.id, posts.public_id, posts.created_at, BIT_COUNT(images.phash ^ ?) AS distance
+			FROM posts
+			INNER JOIN post_images ON post_images.post_id = posts.id
+			INNER JOIN images ON images.id = post_images.image_id
+			WHERE posts.community_id = ? AND posts.created_at > ? AND posts.deleted_at IS NULL
+				AND images.id <> ? AND images.phash IS NOT NULL AND BIT_COUNT(images.phash ^ ?) <= ?
+			ORDER BY distance, posts.created_at DESC LIMIT 1`,
+			h, community, since, id, h, maxDistance).Scan(&dup.DuplicateOf, &dup.PostID, &dup.PostPublicID, &dup.PostedAt, &dup.Distance)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				continue
+			}
+			return nil, err
+		}
+		dups = append(dups, dup)
+	}
+	return dups, nil
+}
+
+// CheckDuplicateImages checks the images of imageIDs, to be posted in
+// community, for near-duplicates, as set with SetDuplicateImageCheck. It
+// returns the near-duplicates found, and, if they're not allowed to be
+// posted, an error as well.
+func CheckDuplicateImages(ctx context.Context, db *sql.DB, community uid.ID, imageIDs ...uid.ID) ([]*DuplicateImage, error) {
+	check := GetDuplicateImageCheck()
+	if check.Action == DuplicateImageActionNone {
+		return nil, nil
+	}
+	dups, err := FindDuplicateImages(ctx, db, community, check.Window, check.MaxDistance, imageIDs...)
+	if err != nil {
+		return nil, err
+	}
+	if len(dups) > 0 && check.Action == DuplicateImageActionBlock {
+		return dups, &httperr.Error{
+			HTTPStatus: http.StatusConflict,
+			Code:       "duplicate-image",
+			Message:    fmt.Sprintf("The image was posted in this community recently (post %s).", dups[0].PostPublicID),
+		}
+	}
+	return dups, nil
+}
@@ -0,0 +1,76 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"sync/atomic"
+
+	"github.com/discuitnet/discuit/internal/images"
+)
+
+// The thumbnails of the images of the posts on the front page, and of the new
+// posts of large communities, are prefetched (see images.Prefetcher), so that
+// their first viewers don't wait for them to be generated.
+
+// prefetchedCopies are the names of the copies of post images that are
+// prefetched (see populatePostsImages).
+var prefetchedCopies = []string{"tiny", "small", "medium"}
+
+// imagePrefetchMinMembers is the number of members from which the images of
+// the new posts of a community are prefetched. If 0, those of no community
+// are.
+var imagePrefetchMinMembers atomic.Int64
+
+// SetImagePrefetchMinMembers sets the number of members from which the
+// images of the new posts of a community are prefetched. If n is 0, those of
+// no community are.
+func SetImagePrefetchMinMembers(n int) {
+	imagePrefetchMinMembers.Store(int64(n))
+}
+
+// imageThumbnails returns the copies of the images of p that are prefetched.
+func (p *Post) imageThumbnails() []*images.ImageCopy {
+	var copies []*images.ImageCopy
+	for _, img := range p.Images {
+		for _, c := range img.Copies {
+			if slices.Contains(prefetchedCopies, c.Name) {
+				copies = append(copies, c)
+			}
+		}
+	}
+	return copies
+}
+
+// prefetchImages prefetches the thumbnails of the images of p, a new post, if
+// its community has enough members (see SetImagePrefetchMinMembers).
+func (p *Post) prefetchImages(ctx context.Context, db *sql.DB) error {
+	min := imagePrefetchMinMembers.Load()
+	if min <= 0 || len(p.Images) == 0 {
+		return nil
+	}
+	var members int64
+	if err := db.QueryRowContext(ctx, "SELECT no_members FROM communities WHERE id = ?", p.CommunityID).Scan(&members); err != nil {
+		return err
+	}
+	if members >= min {
+		images.QueuePrefetch(p.imageThumbnails()...)
+	}
+	return nil
+}
+
+// PrefetchFrontPageImages prefetches the thumbnails of the images of the
+// first n posts of the front page (the hot feed of all communities, as seen
+// by logged out users). It returns the number of copies queued; those
+// prefetched recently are not queued again.
+func PrefetchFrontPageImages(ctx context.Context, db *sql.DB, n int) (int, error) {
+	set, err := GetFeed(ctx, db, &FeedOptions{Sort: FeedSortHot, Limit: n})
+	if err != nil {
+		return 0, err
+	}
+	queued := 0
+	for _, post := range set.Posts {
+		queued += images.QueuePrefetch(post.imageThumbnails()...)
+	}
+	return queued, nil
+}
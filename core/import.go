@@ -0,0 +1,422 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httputil"
+	"github.com/discuitnet/discuit/internal/images"
+	"github.com/discuitnet/discuit/internal/imports"
+	"github.com/discuitnet/discuit/internal/uid"
+	"github.com/discuitnet/discuit/internal/utils"
+)
+
+// Communities migrating from other platforms can have their content imported
+// (see the imports package for the supported exports). The authors of the
+// imported posts and comments are mapped to placeholder accounts (which
+// nobody can log in to), one per author, and imported posts and comments keep
+// their original timestamps.
+//
+// Imports are resumable: each imported item is recorded in the
+// imported_items table, and items already recorded there are skipped. An
+// import that's interrupted can therefore be rerun with the same export.
+
+// Types of imported items.
+const (
+	importedUser    = "user"
+	importedPost    = "post"
+	importedComment = "comment"
+)
+
+// maxImportedImageSize is the maximum size of an image fetched for an
+// imported post.
+const maxImportedImageSize = 25 * 1024 * 1024
+
+// ImportOptions are the options of ImportArchive.
+type ImportOptions struct {
+	// If true, nothing is written to the database; the returned report says
+	// what would have been imported.
+	DryRun bool
+
+	// If true, the images of image posts (and the thumbnails of link posts)
+	// are fetched. Otherwise, image posts are imported as link posts.
+	FetchImages bool
+
+	S3Enabled bool // Whether images are saved to S3.
+}
+
+// ImportCounts are the counts of a type of imported item.
+type ImportCounts struct {
+	Created int `json:"created"`
+	Skipped int `json:"skipped"` // Imported previously.
+	Failed  int `json:"failed"`
+}
+
+func (c ImportCounts) String() string {
+	return fmt.Sprintf("%d created, %d skipped, %d failed", c.Created, c.Skipped, c.Failed)
+}
+
+// ImportReport is the result of an import.
+type ImportReport struct {
+	DryRun   bool         `json:"dryRun"`
+	Users    ImportCounts `json:"users"`
+	Posts    ImportCounts `json:"posts"`
+	Comments ImportCounts `json:"comments"`
+	Problems []string     `json:"problems"`
+}
+
+func (r *ImportReport) problem(format string, args ...any) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// importer imports a single archive.
+type importer struct {
+	db        *sql.DB
+	archive   *imports.Archive
+	community *Community
+	opts      *ImportOptions
+	report    *ImportReport
+	scope     string // The source of posts and comments in imported_items.
+
+	ghost    *User
+	users    map[string]*User  // Authors on the source platform to placeholder accounts.
+	reserved map[string]bool   // Placeholder usernames taken during a dry run.
+	dryRun   map[string]uid.ID // Items that would have been imported (during a dry run), by importedKey.
+}
+
+// ImportArchive imports the posts and comments of archive into the community
+// named community.
+func ImportArchive(ctx context.Context, db *sql.DB, archive *imports.Archive, community string, opts *ImportOptions) (*ImportReport, error) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+	comm, err := GetCommunityByName(ctx, db, community, nil)
+	if err != nil {
+		return nil, err
+	}
+	if comm.Archived() {
+		return nil, errCommunityArchived
+	}
+	ghost, err := GetUserByUsername(ctx, db, GhostUserUsername, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ghost user: %w", err)
+	}
+
+	source := archive.Community
+	if source == "" {
+		source = comm.Name
+	}
+	im := &importer{
+		db:        db,
+		archive:   archive,
+		community: comm,
+		opts:      opts,
+		report:    &ImportReport{DryRun: opts.DryRun, Problems: []string{}},
+		scope:     archive.Source + ":" + strings.ToLower(source),
+		ghost:     ghost,
+		users:     make(map[string]*User),
+		reserved:  make(map[string]bool),
+		dryRun:    make(map[string]uid.ID),
+	}
+
+	for _, name := range archive.Authors() {
+		if err := im.importUser(ctx, name); err != nil {
+			return im.report, err
+		}
+	}
+	for _, p := range archive.Posts {
+		if err := im.importPost(ctx, p); err != nil {
+			return im.report, err
+		}
+	}
+	for _, c := range archive.Comments {
+		if err := im.importComment(ctx, c); err != nil {
+			return im.report, err
+		}
+	}
+	return im.report, nil
+}
+
+func importedKey(source, itemType, sourceID string) string {
+	return source + "/" + itemType + "/" + sourceID
+}
+
+// lookup returns the ID of the imported item, or false if it's not imported.
+func (im *importer) lookup(ctx context.Context, source, itemType, sourceID string) (uid.ID, bool, error) {
+	if id, ok := im.dryRun[importedKey(source, itemType, sourceID)]; ok {
+		return id, true, nil
+	}
+	var id uid.ID
+	err := im.db.QueryRowContext(ctx, "SELECT target_id FROM imported_items WHERE source = ? AND item_type = ? AND source_id = ?", source, itemType, sourceID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return id, false, nil
+	}
+	return id, err == nil, err
+}
+
+// record records that the item is imported as id.
+func (im *importer) record(ctx context.Context, source, itemType, sourceID string, id uid.ID) error {
+	if im.opts.DryRun {
+		im.dryRun[importedKey(source, itemType, sourceID)] = id
+		return nil
+	}
+	_, err := im.db.ExecContext(ctx, "INSERT INTO imported_items (source, item_type, source_id, target_id) VALUES (?, ?, ?, ?)", source, itemType, sourceID, id)
+	return err
+}
+
+// author returns the placeholder account of the author name, or the ghost
+// user if the author's account is deleted (or couldn't be imported).
+func (im *importer) author(name string) *User {
+	if u, ok := im.users[name]; ok {
+		return u
+	}
+	return im.ghost
+}
+
+// placeholderUsername returns the nth candidate for the username of the
+// placeholder account of the user name of source.
+func placeholderUsername(source, name string, n int) string {
+	return usernameCandidate(source[:1]+"_", name, n)
+}
+
+// usernameCandidate returns the nth candidate for a username derived from
+// name (which need not be a valid username), starting with prefix. The
+// candidate might still be too short to be a valid username.
+func usernameCandidate(prefix, name string, n int) string {
+	var b strings.Builder
+	for _, r := range name {
+		if r == '_' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+			b.WriteRune(r)
+		}
+	}
+	suffix := ""
+	if n > 0 {
+		suffix = fmt.Sprintf("_%d", n)
+	}
+	s := b.String()
+	if max := maxUsernameLength - len(prefix) - len(suffix); len(s) > max {
+		s = s[:max]
+	}
+	return prefix + s + suffix
+}
+
+func (im *importer) importUser(ctx context.Context, name string) error {
+	source := im.archive.Source
+	if id, ok, err := im.lookup(ctx, source, importedUser, name); err != nil {
+		return err
+	} else if ok {
+		u, err := GetUser(ctx, im.db, id, nil)
+		if err != nil && !errors.Is(err, errUserNotFound) {
+			return err
+		}
+		if u != nil {
+			im.users[name] = u
+		}
+		im.report.Users.Skipped++
+		return nil
+	}
+
+	var username string
+	for n := 0; ; n++ {
+		username = placeholderUsername(source, name, n)
+		if IsUsernameValid(username) != nil {
+			// Too short.
+			continue
+		}
+		exists, _, err := usernameExists(ctx, im.db, username)
+		if err != nil {
+			return err
+		}
+		if !exists && !im.reserved[strings.ToLower(username)] {
+			break
+		}
+	}
+
+	if im.opts.DryRun {
+		im.reserved[strings.ToLower(username)] = true
+		im.users[name] = &User{ID: uid.New(), Username: username}
+		im.report.Users.Created++
+		return nil
+	}
+
+	u, err := RegisterUser(ctx, im.db, username, "", utils.GenerateStringID(48), "")
+	if err != nil {
+		im.report.Users.Failed++
+		im.report.problem("user %s: %v (their content is attributed to the ghost user)", name, err)
+		return nil
+	}
+	if err := im.record(ctx, source, importedUser, name, u.ID); err != nil {
+		return err
+	}
+	im.users[name] = u
+	im.report.Users.Created++
+	return nil
+}
+
+// fetchImportedImage fetches the image at url.
+func fetchImportedImage(url string) ([]byte, error) {
+	res, err := httputil.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("status %s", res.Status)
+	}
+	image, err := io.ReadAll(io.LimitReader(res.Body, maxImportedImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(image) == 0 {
+		return nil, errors.New("empty image")
+	}
+	if len(image) > maxImportedImageSize {
+		return nil, errors.New("image too large")
+	}
+	return image, nil
+}
+
+// postOpts returns the createPostOpts of p.
+func (im *importer) postOpts(ctx context.Context, p *imports.Post, author *User) (*createPostOpts, error) {
+	link := p.URL
+	if len(p.ImageURLs) > 0 {
+		if im.opts.FetchImages && !im.opts.DryRun {
+			var uploads []*ImageUpload
+			for _, u := range p.ImageURLs {
+				image, err := fetchImportedImage(u)
+				if err == nil {
+					var record *images.ImageRecord
+					record, err = SavePostImage(ctx, im.db, author.ID, bytes.NewReader(image), nil, "", im.opts.S3Enabled)
+					if err == nil {
+						uploads = append(uploads, &ImageUpload{ImageID: record.ID})
+						continue
+					}
+				}
+				im.report.problem("post %s: fetching image %s: %v", p.ID, u, err)
+			}
+			if len(uploads) > 0 {
+				return &createPostOpts{postType: PostTypeImage, images: uploads}, nil
+			}
+		}
+		// Image posts whose images are not fetched become link posts.
+		link = p.ImageURLs[0]
+	}
+	if link != "" {
+		return linkPostOpts(ctx, im.db, link, im.opts.FetchImages && !im.opts.DryRun)
+	}
+	return &createPostOpts{postType: PostTypeText, body: p.Body}, nil
+}
+
+func (im *importer) importPost(ctx context.Context, p *imports.Post) error {
+	if _, ok, err := im.lookup(ctx, im.scope, importedPost, p.ID); err != nil {
+		return err
+	} else if ok {
+		im.report.Posts.Skipped++
+		return nil
+	}
+
+	title := strings.TrimSpace(p.Title)
+	if err := validatePost(title, p.Body); err != nil {
+		im.report.Posts.Failed++
+		im.report.problem("post %s: %v", p.ID, err)
+		return nil
+	}
+
+	author := im.author(p.Author)
+	opts, err := im.postOpts(ctx, p, author)
+	if err != nil {
+		im.report.Posts.Failed++
+		im.report.problem("post %s: %v", p.ID, err)
+		return nil
+	}
+
+	id := uid.New()
+	if !im.opts.DryRun {
+		opts.author, opts.community, opts.title = author.ID, im.community.ID, title
+		opts.imported, opts.createdAt = true, p.CreatedAt
+		post, err := createPost(ctx, im.db, opts)
+		if err != nil {
+			im.report.Posts.Failed++
+			im.report.problem("post %s: %v", p.ID, err)
+			return nil
+		}
+		id = post.ID
+	}
+	if err := im.record(ctx, im.scope, importedPost, p.ID, id); err != nil {
+		return err
+	}
+	im.report.Posts.Created++
+	return nil
+}
+
+func (im *importer) importComment(ctx context.Context, c *imports.Comment) error {
+	if _, ok, err := im.lookup(ctx, im.scope, importedComment, c.ID); err != nil {
+		return err
+	} else if ok {
+		im.report.Comments.Skipped++
+		return nil
+	}
+
+	postID, ok, err := im.lookup(ctx, im.scope, importedPost, c.PostID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		im.report.Comments.Failed++
+		im.report.problem("comment %s: post %s not imported", c.ID, c.PostID)
+		return nil
+	}
+
+	// Comments whose parents are not imported become top-level comments.
+	var parentID *uid.ID
+	if c.ParentID != "" {
+		id, ok, err := im.lookup(ctx, im.scope, importedComment, c.ParentID)
+		if err != nil {
+			return err
+		}
+		if ok {
+			parentID = &id
+		}
+	}
+
+	body := strings.TrimSpace(c.Body)
+	if body == "" {
+		im.report.Comments.Failed++
+		im.report.problem("comment %s: empty body", c.ID)
+		return nil
+	}
+
+	id := uid.New()
+	if !im.opts.DryRun {
+		post, err := GetPost(ctx, im.db, &postID, "", nil, true)
+		if err != nil {
+			return err
+		}
+		remove, err := filterContent(ctx, im.db, im.community.ID, &body)
+		if err != nil {
+			return err
+		}
+		createdAt := c.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = time.Now()
+		}
+		comment, err := addComment(ctx, im.db, post, im.author(c.Author), parentID, body, UserGroupNormal, remove, createdAt)
+		if err != nil {
+			im.report.Comments.Failed++
+			im.report.problem("comment %s: %v", c.ID, err)
+			return nil
+		}
+		id = comment.ID
+	}
+	if err := im.record(ctx, im.scope, importedComment, c.ID, id); err != nil {
+		return err
+	}
+	im.report.Comments.Created++
+	return nil
+}
@@ -0,0 +1,26 @@
+package core
+
+import "testing"
+
+func TestPlaceholderUsername(t *testing.T) {
+	tests := []struct {
+		source, name string
+		n            int
+		want         string
+	}{
+		{"reddit", "alice", 0, "r_alice"},
+		{"lemmy", "bob.smith-jr", 0, "l_bobsmithjr"},
+		{"reddit", "alice", 2, "r_alice_2"},
+		{"reddit", "a_very_long_username_x", 0, "r_a_very_long_usernam"},
+		{"reddit", "a_very_long_username_x", 12, "r_a_very_long_user_12"},
+	}
+	for _, test := range tests {
+		got := placeholderUsername(test.source, test.name, test.n)
+		if got != test.want {
+			t.Errorf("placeholderUsername(%q, %q, %d) = %q, want %q", test.source, test.name, test.n, got, test.want)
+		}
+		if err := IsUsernameValid(got); err != nil {
+			t.Errorf("placeholder username %q invalid: %v", got, err)
+		}
+	}
+}
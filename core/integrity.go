@@ -0,0 +1,276 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// A counterCheck verifies denormalized counter columns of a table against the
+// tables they're derived from.
+type counterCheck struct {
+	name     string
+	table    string
+	counters []counter
+}
+
+// counter is a denormalized counter column.
+type counter struct {
+	column string
+
+	// actual is an SQL expression that computes the true value of the counter
+	// of row t (an alias of the table being checked). It must not select from
+	// the table being checked, since it's also used in UPDATE statements.
+	actual string
+}
+
+var counterChecks = []counterCheck{
+	{
+		name:  "community_members",
+		table: "communities",
+		counters: []counter{
+			{"no_members", "(SELECT COUNT(*) FROM community_members WHERE community_members.community_id = t.id)"},
+		},
+	},
+	{
+		name:  "post_comments",
+		table: "posts",
+		counters: []counter{
+			// Deleting a comment doesn't decrement the count.
+			{"no_comments", "(SELECT COUNT(*) FROM comments WHERE comments.post_id = t.id)"},
+		},
+	},
+	{
+		name:  "post_votes",
+		table: "posts",
+		counters: []counter{
+			{"upvotes", "(SELECT COUNT(*) FROM post_votes WHERE post_votes.post_id = t.id AND post_votes.up = TRUE)"},
+			{"downvotes", "(SELECT COUNT(*) FROM post_votes WHERE post_votes.post_id = t.id AND post_votes.up = FALSE)"},
+			{"points", "(SELECT COALESCE(SUM(IF(post_votes.up, 1, -1)), 0) FROM post_votes WHERE post_votes.post_id = t.id)"},
+		},
+	},
+	{
+		name:  "comment_replies",
+		table: "comments",
+		counters: []counter{
+			// Deleting a reply doesn't decrement the count. (no_replies_direct
+			// can only be computed from comments, so it isn't checked.)
+			{"no_replies", "(SELECT COUNT(*) FROM comment_replies WHERE comment_replies.parent_id = t.id)"},
+		},
+	},
+	{
+		name:  "comment_votes",
+		table: "comments",
+		counters: []counter{
+			{"upvotes", "(SELECT COUNT(*) FROM comment_votes WHERE comment_votes.comment_id = t.id AND comment_votes.up = TRUE)"},
+			{"downvotes", "(SELECT COUNT(*) FROM comment_votes WHERE comment_votes.comment_id = t.id AND comment_votes.up = FALSE)"},
+			{"points", "(SELECT COALESCE(SUM(IF(comment_votes.up, 1, -1)), 0) FROM comment_votes WHERE comment_votes.comment_id = t.id)"},
+		},
+	},
+	{
+		name:  "user_content",
+		table: "users",
+		counters: []counter{
+			{"no_posts", "(SELECT COUNT(*) FROM posts WHERE posts.user_id = t.id AND posts.deleted = FALSE)"},
+			{"no_comments", "(SELECT COUNT(*) FROM comments WHERE comments.user_id = t.id AND comments.deleted_at IS NULL)"},
+		},
+	},
+	{
+		name:  "user_points",
+		table: "users",
+		counters: []counter{
+			// A user's points start at 1 and go up by one for each upvote,
+			// other than their own, on their posts and comments.
+			{"points", `(1 + (SELECT COUNT(*) FROM post_votes INNER JOIN posts ON posts.id = post_votes.post_id
+				WHERE posts.user_id = t.id AND post_votes.user_id <> t.id AND post_votes.up = TRUE)
+				+ (SELECT COUNT(*) FROM comment_votes INNER JOIN comments ON comments.id = comment_votes.comment_id
+				WHERE comments.user_id = t.id AND comment_votes.user_id <> t.id AND comment_votes.up = TRUE))`},
+		},
+	},
+}
+
+// CounterCheckNames returns the names of all the checks run by
+// CheckCounterIntegrity.
+func CounterCheckNames() []string {
+	names := make([]string, len(counterChecks))
+	for i, c := range counterChecks {
+		names[i] = c.name
+	}
+	return names
+}
+
+// CounterDrift is a counter whose stored value differs from its actual value.
+type CounterDrift struct {
+	Table  string `json:"table"`
+	Column string `json:"column"`
+	RowID  uid.ID `json:"rowId"`
+	Stored int    `json:"stored"`
+	Actual int    `json:"actual"`
+}
+
+func (d *CounterDrift) String() string {
+	return fmt.Sprintf("%s.%s of %v is %d (should be %d)", d.Table, d.Column, d.RowID, d.Stored, d.Actual)
+}
+
+// CounterCheckResult is the outcome of a single counter check.
+type CounterCheckResult struct {
+	Check       string          `json:"check"`
+	RowsChecked int             `json:"rowsChecked"`
+	Drifts      []*CounterDrift `json:"drifts"`
+	RowsFixed   int             `json:"rowsFixed"` // Only if the check was run with repair enabled.
+}
+
+// CounterCheckOptions hold optional arguments to CheckCounterIntegrity.
+type CounterCheckOptions struct {
+	// Checks to run (see CounterCheckNames). If empty, all checks are run.
+	Checks []string
+
+	// Number of rows checked (and repaired) per query. Defaults to 1000.
+	BatchSize int
+
+	// If true, counters that have drifted are set to their actual values, one
+	// transaction per batch.
+	Repair bool
+}
+
+// CheckCounterIntegrity verifies denormalized counters (community member
+// counts, post comment counts, vote totals, user points, and so on) against
+// the tables they're derived from, and reports the counters that have drifted.
+// If opts.Repair is true, drifted counters are also fixed.
+func CheckCounterIntegrity(ctx context.Context, db *sql.DB, opts CounterCheckOptions) ([]*CounterCheckResult, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	for _, name := range opts.Checks {
+		if !slices.Contains(CounterCheckNames(), name) {
+			return nil, fmt.Errorf("unknown counter check: %s", name)
+		}
+	}
+
+	var results []*CounterCheckResult
+	for _, check := range counterChecks {
+		if len(opts.Checks) > 0 && !slices.Contains(opts.Checks, check.name) {
+			continue
+		}
+		res, err := check.run(ctx, db, opts.BatchSize, opts.Repair)
+		if err != nil {
+			return results, fmt.Errorf("counter check %s: %w", check.name, err)
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func (c *counterCheck) run(ctx context.Context, db *sql.DB, batchSize int, repair bool) (*CounterCheckResult, error) {
+	var cols []string
+	for _, cnt := range c.counters {
+		cols = append(cols, "t."+cnt.column, cnt.actual)
+	}
+	query := fmt.Sprintf("SELECT t.id, %s FROM %s AS t WHERE t.id > ? ORDER BY t.id LIMIT ?", strings.Join(cols, ", "), c.table)
+
+	res := &CounterCheckResult{Check: c.name, Drifts: []*CounterDrift{}}
+	var last uid.ID // zero ID sorts before all others
+	for {
+		drifts, n, lastID, err := c.checkBatch(ctx, db, query, last, batchSize)
+		if err != nil {
+			return nil, err
+		}
+		res.RowsChecked += n
+		res.Drifts = append(res.Drifts, drifts...)
+		if repair && len(drifts) > 0 {
+			fixed, err := c.repair(ctx, db, drifts)
+			if err != nil {
+				return nil, err
+			}
+			res.RowsFixed += fixed
+		}
+		if n < batchSize {
+			break
+		}
+		last = lastID
+	}
+	return res, nil
+}
+
+// checkBatch checks the batchSize rows following the row with ID after.
+func (c *counterCheck) checkBatch(ctx context.Context, db *sql.DB, query string, after uid.ID, batchSize int) (drifts []*CounterDrift, n int, last uid.ID, err error) {
+	rows, err := db.QueryContext(ctx, query, after, batchSize)
+	if err != nil {
+		return nil, 0, last, err
+	}
+	defer rows.Close()
+
+	values := make([]int, len(c.counters)*2)
+	dest := []any{&last}
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return nil, 0, last, err
+		}
+		n++
+		for i, cnt := range c.counters {
+			if stored, actual := values[i*2], values[i*2+1]; stored != actual {
+				drifts = append(drifts, &CounterDrift{
+					Table:  c.table,
+					Column: cnt.column,
+					RowID:  last,
+					Stored: stored,
+					Actual: actual,
+				})
+			}
+		}
+	}
+	return drifts, n, last, rows.Err()
+}
+
+// repair recomputes the counters of the rows in drifts in a single
+// transaction, and returns the number of rows updated. The counters are
+// recomputed (rather than set to the values in drifts) since they might have
+// changed since they were checked.
+func (c *counterCheck) repair(ctx context.Context, db *sql.DB, drifts []*CounterDrift) (int, error) {
+	var ids []any
+	for _, d := range drifts {
+		if len(ids) == 0 || ids[len(ids)-1] != d.RowID {
+			ids = append(ids, d.RowID)
+		}
+	}
+
+	var sets []string
+	for _, cnt := range c.counters {
+		sets = append(sets, fmt.Sprintf("t.%s = %s", cnt.column, cnt.actual))
+	}
+	query := fmt.Sprintf("UPDATE %s AS t SET %s WHERE t.id IN %s", c.table, strings.Join(sets, ", "), msql.InClauseQuestionMarks(len(ids)))
+
+	var fixed int64
+	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, query, ids...)
+		if err != nil {
+			return err
+		}
+		if fixed, err = res.RowsAffected(); err != nil {
+			return err
+		}
+		if c.table == "posts" {
+			// Keep hotness and the points in the top posts tables in sync.
+			if err := updatePostsHotnessTx(ctx, tx, ids...); err != nil {
+				return err
+			}
+			for _, table := range postsTables {
+				q := fmt.Sprintf("UPDATE %s AS pt INNER JOIN posts ON posts.id = pt.post_id SET pt.points = posts.points WHERE posts.id IN %s",
+					table, msql.InClauseQuestionMarks(len(ids)))
+				if _, err := tx.ExecContext(ctx, q, ids...); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return int(fixed), err
+}
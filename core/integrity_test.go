@@ -0,0 +1,73 @@
+package core
+
+import "testing"
+
+func TestCheckCounterIntegrity(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+
+	author := h.newUser(db, "counted", false)
+	post := h.newPost(db, author, "counting")
+	comment, err := Comments().Create(h.ctx, db, &NewComment{Post: post, Author: author.ID, Body: "A comment."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Comments().Create(h.ctx, db, &NewComment{Post: post, Author: author.ID, Parent: &comment.ID, Body: "A reply."}); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := CounterCheckOptions{Checks: []string{"post_comments", "comment_replies"}, BatchSize: 1}
+	drifts := func(repair bool) map[string]*CounterDrift {
+		t.Helper()
+		opts.Repair = repair
+		results, err := CheckCounterIntegrity(h.ctx, db, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("%d checks were run, want 2", len(results))
+		}
+		got := make(map[string]*CounterDrift)
+		for _, res := range results {
+			for _, d := range res.Drifts {
+				got[d.Table+"."+d.Column] = d
+			}
+			if repair && res.RowsFixed != len(res.Drifts) {
+				t.Errorf("check %s fixed %d rows of %d drifts", res.Check, res.RowsFixed, len(res.Drifts))
+			}
+		}
+		return got
+	}
+
+	if d := drifts(false); len(d) != 0 {
+		t.Fatalf("got drifts %v before the counters were corrupted", d)
+	}
+
+	if _, err := db.ExecContext(h.ctx, "UPDATE posts SET no_comments = 7 WHERE id = ?", post.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(h.ctx, "UPDATE comments SET no_replies = 0 WHERE id = ?", comment.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, repair := range []bool{false, true} {
+		d := drifts(repair)
+		if pd := d["posts.no_comments"]; pd == nil || pd.RowID != post.ID || pd.Stored != 7 || pd.Actual != 2 {
+			t.Errorf("got post drift %v, want %v.no_comments 7 (should be 2)", pd, post.ID)
+		}
+		if cd := d["comments.no_replies"]; cd == nil || cd.RowID != comment.ID || cd.Stored != 0 || cd.Actual != 1 {
+			t.Errorf("got comment drift %v, want %v.no_replies 0 (should be 1)", cd, comment.ID)
+		}
+		if len(d) != 2 {
+			t.Errorf("got %d drifts, want 2", len(d))
+		}
+	}
+
+	// Repaired.
+	if d := drifts(false); len(d) != 0 {
+		t.Errorf("got drifts %v after the repair", d)
+	}
+	got := h.getPost(db, post.ID)
+	if got.NumComments != 2 {
+		t.Errorf("the repaired post has %d comments, want 2", got.NumComments)
+	}
+}
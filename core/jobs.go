@@ -0,0 +1,85 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/jobs"
+)
+
+// Work that is to be done later, like bot responses (see bot_jobs.go), survey
+// deliveries and reminders (see survey.go), the indexing of posts and
+// comments for search (see search_index.go), the creation of notifications
+// (see notification_fanout.go), and the delivery of web push notifications
+// (see web_push.go), is queued as delayed jobs (see
+// SetJobQueue), which survive restarts and are retried if they fail.
+
+// jobHandler runs a job with payload.
+type jobHandler func(ctx context.Context, db *sql.DB, payload json.RawMessage) error
+
+// jobHandlers are the handlers of the kinds of jobs. It's set in init, since
+// handlers may queue jobs themselves.
+var jobHandlers map[string]jobHandler
+
+func init() {
+	jobHandlers = map[string]jobHandler{
+		botJobRespondToPost:    runBotResponseJob,
+		botJobRespondToComment: runBotResponseJob,
+		surveyJobDeliver:       runSurveyDeliveryJob,
+		surveyJobRemind:        runSurveyReminderJob,
+		searchJobIndex:         runSearchIndexJob,
+		webPushJobDeliver:      runWebPushDeliveryJob,
+		webPushJobSend:         runWebPushSendJob,
+		notificationJobFanout:  runNotificationFanoutJob,
+	}
+}
+
+var (
+	jobQueueMu sync.RWMutex // guards jobQueue
+	jobQueue   *jobs.Queue
+)
+
+// SetJobQueue registers the handlers of jobs with q and sets it as the queue
+// jobs are queued to. If q is nil, jobs are delayed in memory, and are lost if
+// the process exits before they're run.
+func SetJobQueue(db *sql.DB, q *jobs.Queue) {
+	if q != nil {
+		for kind, h := range jobHandlers {
+			h := h
+			q.Handle(kind, func(ctx context.Context, payload json.RawMessage) error {
+				return h(ctx, db, payload)
+			})
+		}
+	}
+	jobQueueMu.Lock()
+	defer jobQueueMu.Unlock()
+	jobQueue = q
+}
+
+// queueJob queues a job of kind, with payload, to be run at at.
+func queueJob(db *sql.DB, kind string, payload any, at time.Time) error {
+	jobQueueMu.RLock()
+	q := jobQueue
+	jobQueueMu.RUnlock()
+
+	if q != nil {
+		return q.Enqueue(kind, payload, at)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	time.AfterFunc(time.Until(at), func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute*2)
+		defer cancel()
+		if err := jobHandlers[kind](ctx, db, data); err != nil {
+			log.Printf("Error running %s job: %v", kind, err)
+		}
+	})
+	return nil
+}
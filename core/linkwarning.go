@@ -0,0 +1,224 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Admins keep a list of domains (URL shorteners, known scam hosts, and the
+// like) links to which are flagged with a warning (see PostLink.Warning), so
+// that clients can ask users for confirmation before navigating to them.
+// Links to a domain are flagged along with links to its subdomains. Warned
+// domains are not fetched for link previews.
+
+// LinkWarningReason is why links to a domain are flagged.
+type LinkWarningReason string
+
+// Valid link warning reasons.
+const (
+	LinkWarningShortener = LinkWarningReason("shortener") // The destination is hidden.
+	LinkWarningScam      = LinkWarningReason("scam")
+	LinkWarningMalware   = LinkWarningReason("malware")
+	LinkWarningOther     = LinkWarningReason("other")
+)
+
+// Valid reports whether r is a valid link warning reason.
+func (r LinkWarningReason) Valid() bool {
+	switch r {
+	case LinkWarningShortener, LinkWarningScam, LinkWarningMalware, LinkWarningOther:
+		return true
+	}
+	return false
+}
+
+// LinkWarning is the warning attached to a link to a warned domain.
+type LinkWarning struct {
+	Domain string            `json:"domain"` // The warned domain the link is to.
+	Reason LinkWarningReason `json:"reason"`
+}
+
+// WarnedDomain is a domain in the link warning list.
+type WarnedDomain struct {
+	Domain    string            `json:"domain"`
+	Reason    LinkWarningReason `json:"reason"`
+	Note      msql.NullString   `json:"note"`
+	AddedBy   uid.NullID        `json:"addedBy"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+// GetWarnedDomains returns the link warning list.
+func GetWarnedDomains(ctx context.Context, db *sql.DB) ([]*WarnedDomain, error) {
+	rows, err := db.QueryContext(ctx, "SELECT domain, reason, note, added_by, created_at FROM link_warning_domains ORDER BY domain")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	domains := []*WarnedDomain{}
+	for rows.Next() {
+		d := &WarnedDomain{}
+		if err := rows.Scan(&d.Domain, &d.Reason, &d.Note, &d.AddedBy, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		domains = append(domains, d)
+	}
+	return domains, rows.Err()
+}
+
+// normalizeDomain returns domain lowercased, and without a trailing dot and a
+// leading "www.".
+func normalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+	return strings.TrimPrefix(domain, "www.")
+}
+
+// AddWarnedDomain adds domain to the link warning list, on behalf of admin. If
+// the domain is already in the list, its reason and note are updated.
+func AddWarnedDomain(ctx context.Context, db *sql.DB, admin uid.ID, domain string, reason LinkWarningReason, note string) (*WarnedDomain, error) {
+	if is, err := IsAdmin(db, &admin); err != nil {
+		return nil, err
+	} else if !is {
+		return nil, errNotAdmin
+	}
+
+	domain = normalizeDomain(domain)
+	if domain == "" || len(domain) > 253 || strings.ContainsAny(domain, "/:@ ") {
+		return nil, httperr.NewBadRequest("invalid_domain", "Invalid domain.")
+	}
+	if !reason.Valid() {
+		return nil, httperr.NewBadRequest("invalid_reason", "Invalid link warning reason.")
+	}
+
+	d := &WarnedDomain{
+		Domain:    domain,
+		Reason:    reason,
+		Note:      msql.NewNullString(msql.NilIfEmptyString(note)),
+		AddedBy:   uid.NullID{ID: admin, Valid: true},
+		CreatedAt: time.Now(),
+	}
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO link_warning_domains (domain, reason, note, added_by, created_at) VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE reason = VALUES(reason), note = VALUES(note)`,
+		d.Domain, d.Reason, d.Note, d.AddedBy, d.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	warnedDomainsCache.bust()
+	return d, nil
+}
+
+// RemoveWarnedDomain removes domain from the link warning list, on behalf of
+// admin.
+func RemoveWarnedDomain(ctx context.Context, db *sql.DB, admin uid.ID, domain string) error {
+	if is, err := IsAdmin(db, &admin); err != nil {
+		return err
+	} else if !is {
+		return errNotAdmin
+	}
+
+	res, err := db.ExecContext(ctx, "DELETE FROM link_warning_domains WHERE domain = ?", normalizeDomain(domain))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return &httperr.Error{HTTPStatus: http.StatusNotFound, Code: "domain_not_found", Message: "Domain not in the link warning list."}
+	}
+
+	warnedDomainsCache.bust()
+	return nil
+}
+
+// warnedDomainsCacheStore caches the link warning list for a minute.
+type warnedDomainsCacheStore struct {
+	mu      sync.RWMutex // guards following
+	domains map[string]LinkWarningReason
+	fetched time.Time
+}
+
+var warnedDomainsCache = &warnedDomainsCacheStore{}
+
+func (wc *warnedDomainsCacheStore) get(ctx context.Context, db *sql.DB) (map[string]LinkWarningReason, error) {
+	wc.mu.RLock()
+	domains, fetched := wc.domains, wc.fetched
+	wc.mu.RUnlock()
+	if domains != nil && time.Since(fetched) < time.Minute {
+		return domains, nil
+	}
+
+	list, err := GetWarnedDomains(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	domains = make(map[string]LinkWarningReason, len(list))
+	for _, d := range list {
+		domains[d.Domain] = d.Reason
+	}
+
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	wc.domains, wc.fetched = domains, time.Now()
+	return domains, nil
+}
+
+func (wc *warnedDomainsCacheStore) bust() {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	wc.domains = nil
+}
+
+// GetLinkWarning returns the warning of links to hostname, or nil if links to
+// it are not flagged.
+func GetLinkWarning(ctx context.Context, db *sql.DB, hostname string) (*LinkWarning, error) {
+	domains, err := warnedDomainsCache.get(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	return matchWarnedDomain(domains, hostname), nil
+}
+
+// matchWarnedDomain returns the warning of links to hostname, which are
+// flagged if hostname or any of its parent domains is in domains.
+func matchWarnedDomain(domains map[string]LinkWarningReason, hostname string) *LinkWarning {
+	if len(domains) == 0 {
+		return nil
+	}
+	for host := normalizeDomain(hostname); host != ""; {
+		if reason, ok := domains[host]; ok {
+			return &LinkWarning{Domain: host, Reason: reason}
+		}
+		_, parent, found := strings.Cut(host, ".")
+		if !found {
+			break
+		}
+		host = parent
+	}
+	return nil
+}
+
+// populateLinkWarnings sets the link warnings of the link posts in posts.
+func populateLinkWarnings(ctx context.Context, db *sql.DB, posts []*Post) error {
+	var domains map[string]LinkWarningReason
+	for _, post := range posts {
+		if post.Link == nil {
+			continue
+		}
+		if domains == nil {
+			var err error
+			if domains, err = warnedDomainsCache.get(ctx, db); err != nil {
+				return err
+			}
+		}
+		post.Link.Warning = matchWarnedDomain(domains, post.Link.Hostname)
+	}
+	return nil
+}
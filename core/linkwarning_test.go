@@ -0,0 +1,39 @@
+package core
+
+import "testing"
+
+func TestMatchWarnedDomain(t *testing.T) {
+	domains := map[string]LinkWarningReason{
+		"bit.ly":      LinkWarningShortener,
+		"scam.co.uk":  LinkWarningScam,
+		"example.com": LinkWarningOther,
+	}
+	tests := []struct {
+		hostname string
+		domain   string // Empty if not matched.
+	}{
+		{"bit.ly", "bit.ly"},
+		{"BIT.LY", "bit.ly"},
+		{"www.bit.ly", "bit.ly"},
+		{"bit.ly.", "bit.ly"},
+		{"login.scam.co.uk", "scam.co.uk"},
+		{"a.b.example.com", "example.com"},
+		{"co.uk", ""},
+		{"notbit.ly", ""},
+		{"example.com.evil.net", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		w := matchWarnedDomain(domains, test.hostname)
+		got := ""
+		if w != nil {
+			got = w.Domain
+			if w.Reason != domains[w.Domain] {
+				t.Errorf("%q: got reason %q, want %q", test.hostname, w.Reason, domains[w.Domain])
+			}
+		}
+		if got != test.domain {
+			t.Errorf("%q: matched %q, want %q", test.hostname, got, test.domain)
+		}
+	}
+}
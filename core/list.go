@@ -16,6 +16,8 @@ import (
 	"github.com/discuitnet/discuit/internal/utils"
 )
 
+var errListNotFound = httperr.NewNotFound("list-not-found", "List not found.")
+
 type ListItemsSort int
 
 const (
@@ -139,7 +141,7 @@ func GetList(ctx context.Context, db *sql.DB, id int) (*List, error) {
 		return nil, err
 	}
 	if len(lists) == 0 {
-		return nil, httperr.NewNotFound("list-not-found", "List not found.")
+		return nil, errListNotFound
 	}
 	return lists[0], nil
 }
@@ -150,7 +152,7 @@ func GetListByName(ctx context.Context, db *sql.DB, user uid.ID, name string) (*
 		return nil, err
 	}
 	if len(lists) == 0 {
-		return nil, httperr.NewNotFound("list-not-found", "List not found.")
+		return nil, errListNotFound
 	}
 	return lists[0], nil
 }
@@ -0,0 +1,52 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/llm"
+	"github.com/discuitnet/discuit/internal/metrics"
+)
+
+var (
+	botLLMMu sync.RWMutex // guards botLLM
+	botLLM   llm.Provider = &llm.OpenAI{}
+)
+
+// SetBotLLMProvider sets the provider of the language model that bots write
+// with (see GenerateBotResponse). The default is the OpenAI API. Tests may set
+// a fake provider (see llmtest.Fake).
+func SetBotLLMProvider(p llm.Provider) {
+	botLLMMu.Lock()
+	defer botLLMMu.Unlock()
+	botLLM = p
+}
+
+func botLLMProvider() llm.Provider {
+	botLLMMu.RLock()
+	defer botLLMMu.RUnlock()
+	return botLLM
+}
+
+var (
+	botLLMCallDurations = metrics.NewHistogram("discuit_bot_llm_call_duration_seconds",
+		"Durations of the calls of bots to the language model, by model and result (ok or error).",
+		[]float64{.25, .5, 1, 2.5, 5, 10, 20, 30}, "model", "result")
+	botLLMTokens = metrics.NewCounter("discuit_bot_llm_tokens_total",
+		"Tokens used by the calls of bots to the language model, by model and kind (prompt or completion).",
+		"model", "kind")
+)
+
+// observeBotLLMCall records a call to the language model of bots, started at
+// start, that returned res and err.
+func observeBotLLMCall(model string, start time.Time, res *llm.Response, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	botLLMCallDurations.ObserveSince(start, model, result)
+	if res != nil {
+		botLLMTokens.Add(float64(res.PromptTokens), model, "prompt")
+		botLLMTokens.Add(float64(res.CompletionTokens), model, "completion")
+	}
+}
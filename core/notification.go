@@ -12,184 +12,17 @@ import (
 	"slices"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/SherClockHolmes/webpush-go"
 	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/mobilepush"
 	msql "github.com/discuitnet/discuit/internal/sql"
 	"github.com/discuitnet/discuit/internal/uid"
 	"github.com/discuitnet/discuit/internal/utils"
 )
 
-var (
-	pushMutex         sync.RWMutex // guards the following
-	pushNotifsEnabled = false
-	webmasterEmail    = ""
-	vapidKeys         = &VAPIDKeys{}
-)
-
-// EnablePushNotifications enables sending web push notifications. The email
-// address is the email of the webmaster.
-func EnablePushNotifications(keys *VAPIDKeys, email string) {
-	pushMutex.Lock()
-	defer pushMutex.Unlock()
-
-	pushNotifsEnabled = true
-	vapidKeys = keys
-	webmasterEmail = email
-}
-
 const MaxNotificationsPerUser = 200
 
-// VAPIDKeys is an application server key-pair used by the Web Push API.
-type VAPIDKeys struct {
-	Public  string `json:"public"`
-	Private string `json:"private"`
-}
-
-const vapidKeysDBKey = "vapid_keys" // for the key column of the application_data table
-
-func saveVAPIDKeys(ctx context.Context, db *sql.DB) (*VAPIDKeys, error) {
-	private, public, err := webpush.GenerateVAPIDKeys()
-	if err != nil {
-		return nil, err
-	}
-
-	pair := &VAPIDKeys{
-		Public:  public,
-		Private: private,
-	}
-	data, err := json.Marshal(pair)
-	if err != nil {
-		return nil, err
-	}
-
-	if _, err := db.ExecContext(ctx, "INSERT INTO application_data (`key`, `value`) VALUES (?, ?)", vapidKeysDBKey, string(data)); err != nil {
-		return nil, err
-	}
-	return pair, nil
-}
-
-// GetApplicationVAPIDKeys returns a pair of VAPID public/private keys used by
-// the Web Push API. If no keys are found in the application_data table in the
-// database, a new key-value pair is generated, saved, and returned.
-func GetApplicationVAPIDKeys(ctx context.Context, db *sql.DB) (*VAPIDKeys, error) {
-	rawJSON := ""
-	row := db.QueryRowContext(ctx, "SELECT `value` FROM application_data WHERE `key` = ?", vapidKeysDBKey)
-	if err := row.Scan(&rawJSON); err != nil {
-		if err == sql.ErrNoRows {
-			return saveVAPIDKeys(ctx, db)
-		}
-		return nil, err
-	}
-
-	pair := &VAPIDKeys{}
-	if err := json.Unmarshal([]byte(rawJSON), pair); err != nil {
-		return nil, err
-	}
-	return pair, nil
-}
-
-// WebPushSubscription stores a PushSubscription object with other necessary
-// information for sending web push notifications for logged in users.
-//
-// One user could have multiple WebPushSubscription entries in the database (in
-// which case he's signed in on multiple devices).
-type WebPushSubscription struct {
-	ID               int                  `json:"id"`
-	SessionID        string               `json:"sessionId"`
-	UserID           uid.ID               `json:"userId"`
-	PushSubscription webpush.Subscription `json:"pushSubscription"`
-	CreatedAt        time.Time            `json:"createdAt"`
-	UpdatedAt        msql.NullTime        `json:"updatedAt"`
-
-	rawPushSubscription string // raw json string
-}
-
-// SaveWebPushSubscription adds an entry into web_push_notifications table. If
-// there's a collision (a duplicate for sessionID), it updates the matching row.
-// It is safe to call this function repeatedly with the same arguments.
-func SaveWebPushSubscription(ctx context.Context, db *sql.DB, sessionID string, user uid.ID, s webpush.Subscription) error {
-	rawJSON, err := json.Marshal(s)
-	if err != nil {
-		return err
-	}
-	_, err = db.ExecContext(ctx, `INSERT INTO web_push_subscriptions (session_id, user_id, push_subscription) 
-		VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE push_subscription = ?, updated_at = CURRENT_TIMESTAMP()`,
-		sessionID, user, rawJSON, rawJSON)
-
-	return err
-}
-
-// DeleteWebPushSuscription deletes the Push Subscription object associated with
-// sessionID (if there is one).
-//
-// Make sure to call this function before logging out a user.
-func DeleteWebPushSubscription(ctx context.Context, db *sql.DB, sessionID string) error {
-	_, err := db.ExecContext(ctx, "DELETE FROM web_push_subscriptions WHERE session_id = ?", sessionID)
-	return err
-}
-
-// userWebPushSubscriptions returns all the Web Push Subscriptions of the user.
-func userWebPushSubscriptions(ctx context.Context, db *sql.DB, user uid.ID) ([]*WebPushSubscription, error) {
-	s := msql.BuildSelectQuery("web_push_subscriptions", []string{
-		"id",
-		"session_id",
-		"user_id",
-		"push_subscription",
-		"created_at",
-		"updated_at",
-	}, nil, "WHERE user_id = ?")
-
-	rows, err := db.QueryContext(ctx, s, user)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var subs []*WebPushSubscription
-	for rows.Next() {
-		sub := &WebPushSubscription{}
-		if err := rows.Scan(&sub.ID, &sub.SessionID, &sub.UserID, &sub.rawPushSubscription, &sub.CreatedAt, &sub.UpdatedAt); err != nil {
-			return nil, err
-		}
-		if err := json.Unmarshal([]byte(sub.rawPushSubscription), &sub.PushSubscription); err != nil {
-			return nil, err
-		}
-		subs = append(subs, sub)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return subs, nil
-}
-
-// SendPushNotification sends the Web Push notification in payload to all
-// sessions of user (that has web notifications enabled).
-func SendPushNotification(ctx context.Context, db *sql.DB, user uid.ID, payload []byte, options *webpush.Options) error {
-	subs, err := userWebPushSubscriptions(ctx, db, user)
-	if err != nil {
-		return err
-	}
-
-	var errors []error
-	for _, sub := range subs {
-		res, err := webpush.SendNotificationWithContext(ctx, payload, &sub.PushSubscription, options)
-		if err != nil {
-			errors = append(errors, err)
-			continue
-		}
-		defer res.Body.Close()
-	}
-
-	if len(errors) > 0 {
-		return fmt.Errorf("%v errors trying to send %v web push notifications", len(errors), len(subs))
-	}
-	return nil
-}
-
 type NotificationType string
 
 const (
@@ -201,6 +34,7 @@ const (
 	NotificationTypeNewBadge     = NotificationType("new_badge")
 	NotificationTypeWelcome      = NotificationType("welcome")
 	NotificationTypeAnnouncement = NotificationType("announcement")
+	NotificationTypeSurvey       = NotificationType("survey")
 )
 
 func (t NotificationType) Valid() bool {
@@ -213,6 +47,7 @@ func (t NotificationType) Valid() bool {
 		NotificationTypeNewBadge,
 		NotificationTypeWelcome,
 		NotificationTypeAnnouncement,
+		NotificationTypeSurvey,
 	}, t)
 }
 
@@ -428,6 +263,8 @@ func scanNotifications(ctx context.Context, db *sql.DB, rows *sql.Rows, render b
 			nc = &NotificationWelcome{}
 		case NotificationTypeAnnouncement:
 			nc = &NotificationAnnouncement{}
+		case NotificationTypeSurvey:
+			nc = &NotificationSurvey{}
 		default:
 			return nil, fmt.Errorf("unknown notification type: %s", string(notif.Type))
 		}
@@ -472,48 +309,7 @@ func removeExcessNotifications(ctx context.Context, db *sql.DB, user uid.ID) (n
 
 // CreateNotification adds a new notification to user's notifications stack.
 func CreateNotification(ctx context.Context, db *sql.DB, user uid.ID, Type NotificationType, notif notification) error {
-	if is, err := UserDeleted(db, user); err != nil {
-		return err
-	} else if is {
-		// Exit silently if the user is deleted.
-		return nil
-	}
-
-	data, err := json.Marshal(notif)
-	if err != nil {
-		return err
-	}
-
-	res, err := db.ExecContext(ctx, "INSERT INTO notifications (user_id, type, notif) VALUES (?, ?, ?)", user, Type, data)
-	if err != nil {
-		return err
-	}
-
-	lastID, err := res.LastInsertId()
-	if err != nil {
-		return err
-	}
-
-	if _, err := removeExcessNotifications(ctx, db, user); err != nil { // attempt
-		log.Println("Failed removing excess notifications: ", err)
-	}
-	if err := updateNewNotificationsCount(ctx, db, user); err != nil { // attempt
-		log.Println("Failed incrementing users.notifications_new_count: ", err)
-	}
-
-	sendPushNotif := func() {
-		notif, err := GetNotification(ctx, db, strconv.Itoa(int(lastID)), false, "")
-		if err != nil {
-			log.Println("Error getting notification (CreateNotification)", err)
-			return
-		}
-		if err = notif.SendPushNotification(ctx); err != nil {
-			log.Printf("Error sending push notification: %v\n", err)
-		}
-	}
-
-	sendPushNotif()
-	return err
+	return insertNotifications(ctx, db, []*pendingNotification{{user: user, typ: Type, notif: notif}})
 }
 
 func GetNotification(ctx context.Context, db *sql.DB, ID string, render bool, format TextFormat) (*Notification, error) {
@@ -639,39 +435,41 @@ func (n *Notification) Update(ctx context.Context) error {
 	return nil
 }
 
-// SendPushNotification sends the notification to all matching sessions. Call
-// EnablePushNotifications before any calls to this method.
+// SendPushNotification sends the notification to the native app devices of
+// its user, and queues it to be pushed to the browsers of the user (see
+// queueWebPush).
 func (n *Notification) SendPushNotification(ctx context.Context) error {
 	if n.Type == NotificationTypeUpvote { // no push notifications for upvotes, for the moment
 		return nil
 	}
 
-	topic := strconv.Itoa(n.ID)
-	copy := *n // shallow copy of n
-	copy.Notif = nil
-	copy.PreMarshalJSON(ctx, false, "")
-
-	data, err := json.Marshal(copy)
-	if err != nil {
-		return err
+	if err := n.sendMobilePushNotification(ctx); err != nil {
+		log.Printf("Error sending mobile push notification: %v\n", err)
 	}
 
-	pushMutex.RLock()
-	enabled := pushNotifsEnabled
-	email := webmasterEmail
-	keys := *vapidKeys
-	pushMutex.RUnlock()
+	return queueWebPush(ctx, n.db, n.UserID, n.ID, n.Type)
+}
 
-	if !enabled {
+// sendMobilePushNotification sends the notification to all the registered
+// native app devices of the user.
+func (n *Notification) sendMobilePushNotification(ctx context.Context) error {
+	if !mobilePushEnabled() {
 		return nil
 	}
-
-	return SendPushNotification(ctx, n.db, n.UserID, data, &webpush.Options{
-		Subscriber:      email,
-		VAPIDPublicKey:  keys.Public,
-		VAPIDPrivateKey: keys.Private,
-		TTL:             30,
-		Topic:           topic, // For collapsing comments
+	view, err := n.Notif.view(ctx, n.db, "") // plain text
+	if err != nil {
+		return err
+	}
+	return sendMobilePushNotification(ctx, n.db, n.UserID, n.Type, &mobilepush.Message{
+		Title: view.Title,
+		Body:  view.Body,
+		Data: map[string]string{
+			"notificationId": strconv.Itoa(n.ID),
+			"type":           string(n.Type),
+			"toURL":          view.ToURL,
+		},
+		CollapseKey: strconv.Itoa(n.ID),
+		TTL:         time.Hour * 24,
 	})
 }
 
@@ -750,31 +548,44 @@ func (n NotificationNewComment) view(ctx context.Context, db *sql.DB, format Tex
 // CreateNewCommentNotification creates a notification of type new_comment. If
 // an identical notification exists in the last 10 items, it is deleted.
 func CreateNewCommentNotification(ctx context.Context, db *sql.DB, post *Post, comment uid.ID, author *User) error {
+	pn, err := newCommentNotification(ctx, db, post, comment, author, 1)
+	if err != nil || pn == nil {
+		return err
+	}
+	return insertNotifications(ctx, db, []*pendingNotification{pn})
+}
+
+// newCommentNotification returns the new_comment notification of count new
+// comments on post, the first of which is comment, by author. If the comments
+// are counted in an unseen notification of the same post (among the last
+// 10), the notification returned is an update of it. If the author of post is
+// not to be notified, nil is returned.
+func newCommentNotification(ctx context.Context, db *sql.DB, post *Post, comment uid.ID, author *User, count int) (*pendingNotification, error) {
 	user, err := GetUser(ctx, db, post.AuthorID, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if user.ReplyNotificationsOff {
-		return nil
+		return nil, nil
 	}
 
 	if muted, err := user.Muted(ctx, db, author.ID); err != nil {
-		return err
+		return nil, err
 	} else if muted {
-		return nil
+		return nil, nil
 	}
 
 	// Select last 10 notifications to see if an identical notification exists.
 	notifs, _, err := GetNotifications(ctx, db, post.AuthorID, 10, "", false, "")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	for _, notif := range notifs {
 		if notif.Type == NotificationTypeNewComment {
 			nc := notif.Notif.(*NotificationNewComment)
 			if nc.PostID.EqualsTo(post.ID) && !notif.Seen { // identical found
-				nc.NumComments++
-				return notif.Update(ctx)
+				nc.NumComments += count
+				return &pendingNotification{user: post.AuthorID, typ: NotificationTypeNewComment, notif: nc, update: notif}, nil
 			}
 		}
 	}
@@ -782,11 +593,11 @@ func CreateNewCommentNotification(ctx context.Context, db *sql.DB, post *Post, c
 	n := NotificationNewComment{
 		PostID:         post.ID,
 		CommentID:      comment,
-		NumComments:    1,
+		NumComments:    count,
 		CommentAuthor:  author.Username,
 		FirstCreatedAt: time.Now(),
 	}
-	return CreateNotification(ctx, db, post.AuthorID, NotificationTypeNewComment, n)
+	return &pendingNotification{user: post.AuthorID, typ: NotificationTypeNewComment, notif: n}, nil
 }
 
 // NotificationCommentReply is for when a comment receives a reply. It is sent to the
@@ -851,31 +662,44 @@ func (n NotificationCommentReply) view(ctx context.Context, db *sql.DB, format T
 // CreateCommentReplyNotification creates a notification of type comment_reply.
 // If an identical notification exists in the last 10 items, it is deleted.
 func CreateCommentReplyNotification(ctx context.Context, db *sql.DB, receiver uid.ID, parent, comment uid.ID, author *User, post *Post) error {
+	pn, err := commentReplyNotification(ctx, db, receiver, parent, comment, author, post, 1)
+	if err != nil || pn == nil {
+		return err
+	}
+	return insertNotifications(ctx, db, []*pendingNotification{pn})
+}
+
+// commentReplyNotification returns the comment_reply notification, to
+// receiver, of count replies to parent, the first of which is comment, by
+// author. If the replies are counted in an unseen notification of the same
+// parent (among the last 10), the notification returned is an update of it. If
+// receiver is not to be notified, nil is returned.
+func commentReplyNotification(ctx context.Context, db *sql.DB, receiver uid.ID, parent, comment uid.ID, author *User, post *Post, count int) (*pendingNotification, error) {
 	user, err := GetUser(ctx, db, receiver, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if user.ReplyNotificationsOff {
-		return nil
+		return nil, nil
 	}
 
 	if muted, err := user.Muted(ctx, db, author.ID); err != nil {
-		return err
+		return nil, err
 	} else if muted {
-		return nil
+		return nil, nil
 	}
 
 	// Select last 10 notifications to see if an identical notification exists.
 	notifs, _, err := GetNotifications(ctx, db, receiver, 10, "", false, "")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	for _, notif := range notifs {
 		if notif.Type == "comment_reply" {
 			rc := notif.Notif.(*NotificationCommentReply)
 			if rc.ParentCommentID.EqualsTo(parent) && !notif.Seen {
-				rc.NumComments++
-				return notif.Update(ctx)
+				rc.NumComments += count
+				return &pendingNotification{user: receiver, typ: NotificationTypeCommentReply, notif: rc, update: notif}, nil
 			}
 		}
 	}
@@ -885,10 +709,10 @@ func CreateCommentReplyNotification(ctx context.Context, db *sql.DB, receiver ui
 		ParentCommentID: parent,
 		CommentID:       comment,
 		CommentAuthor:   author.Username,
-		NumComments:     1,
+		NumComments:     count,
 		FirstCreatedAt:  time.Now(),
 	}
-	return CreateNotification(ctx, db, receiver, NotificationTypeCommentReply, n)
+	return &pendingNotification{user: receiver, typ: NotificationTypeCommentReply, notif: n}, nil
 }
 
 func updateNewNotificationsCount(ctx context.Context, db *sql.DB, user uid.ID) error {
@@ -988,10 +812,23 @@ func (n NotificationNewVotes) view(ctx context.Context, db *sql.DB, format TextF
 
 // CreateNewVotesNotification creates a notification of type "new_votes".
 func CreateNewVotesNotification(ctx context.Context, db *sql.DB, user uid.ID, community string, isPost bool, targetID uid.ID) error {
-	if user, err := GetUser(ctx, db, user, nil); err != nil {
+	pn, err := newVotesNotification(ctx, db, user, isPost, targetID, 1)
+	if err != nil || pn == nil {
 		return err
+	}
+	return insertNotifications(ctx, db, []*pendingNotification{pn})
+}
+
+// newVotesNotification returns the new_votes notification, to user, of count
+// new upvotes of the post, or the comment, targetID. If the votes are counted
+// in an unseen notification of the same target (among the last 10), the
+// notification returned is an update of it. If user is not to be notified,
+// nil is returned.
+func newVotesNotification(ctx context.Context, db *sql.DB, user uid.ID, isPost bool, targetID uid.ID, count int) (*pendingNotification, error) {
+	if user, err := GetUser(ctx, db, user, nil); err != nil {
+		return nil, err
 	} else if user.UpvoteNotificationsOff {
-		return nil
+		return nil, nil
 	}
 
 	targetType := "post"
@@ -1002,14 +839,14 @@ func CreateNewVotesNotification(ctx context.Context, db *sql.DB, user uid.ID, co
 	// Select last 10 notifications to see if an identical notification exists.
 	notifs, _, err := GetNotifications(ctx, db, user, 10, "", false, "")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	for _, notif := range notifs {
 		if notif.Type == "new_votes" {
 			rc := notif.Notif.(*NotificationNewVotes)
 			if !notif.Seen && rc.TargetType == targetType && rc.TargetID.EqualsTo(targetID) { // identical found
-				rc.NoVotes++
-				return notif.Update(ctx)
+				rc.NoVotes += count
+				return &pendingNotification{user: user, typ: NotificationTypeUpvote, notif: rc, update: notif}, nil
 			}
 		}
 	}
@@ -1017,9 +854,9 @@ func CreateNewVotesNotification(ctx context.Context, db *sql.DB, user uid.ID, co
 	n := NotificationNewVotes{
 		TargetType: targetType,
 		TargetID:   targetID,
-		NoVotes:    1,
+		NoVotes:    count,
 	}
-	return CreateNotification(ctx, db, user, NotificationTypeUpvote, n)
+	return &pendingNotification{user: user, typ: NotificationTypeUpvote, notif: n}, nil
 }
 
 // NotificationPostDeleted is sent when a mod or an admin removes a post or a comment.
@@ -1077,17 +914,19 @@ func (n NotificationPostDeleted) view(ctx context.Context, db *sql.DB, format Te
 // CreatePostDeletedNotification creates a notification of type "deleted_post".
 // In actuall fact it may be a post or a comment.
 func CreatePostDeletedNotification(ctx context.Context, db *sql.DB, user uid.ID, deletedAs UserGroup, isPost bool, targetID uid.ID) error {
+	return CreateNotification(ctx, db, user, NotificationTypeDeletePost, postDeletedNotification(deletedAs, isPost, targetID))
+}
+
+func postDeletedNotification(deletedAs UserGroup, isPost bool, targetID uid.ID) NotificationPostDeleted {
 	targetType := "post"
 	if !isPost {
 		targetType = "comment"
 	}
-
-	n := NotificationPostDeleted{
+	return NotificationPostDeleted{
 		TargetType: targetType,
 		TargetID:   targetID,
 		DeletedAs:  deletedAs,
 	}
-	return CreateNotification(ctx, db, user, NotificationTypeDeletePost, n)
 }
 
 // NotificationModAdd is sent when someone is added as a mod to a community.
@@ -1417,3 +1256,26 @@ func SendAnnouncementNotifications(ctx context.Context, db *sql.DB, post uid.ID)
 	}
 	return nil
 }
+
+// NotificationSurvey is a prompt, or a reminder, to take a survey.
+type NotificationSurvey struct {
+	SurveyID uid.ID `json:"surveyId"`
+	Title    string `json:"title"`
+	Reminder bool   `json:"reminder"`
+}
+
+func (n *NotificationSurvey) marshalJSONForAPI(ctx context.Context, db *sql.DB) ([]byte, error) {
+	return json.Marshal(n)
+}
+
+func (n NotificationSurvey) view(ctx context.Context, db *sql.DB, format TextFormat) (*NotificationView, error) {
+	view := &NotificationView{
+		ToURL: "/surveys/" + n.SurveyID.String(),
+		Title: fmt.Sprintf("You're invited to take a survey: %s", encloseInBold(format, n.Title)),
+	}
+	if n.Reminder {
+		view.Title = fmt.Sprintf("Reminder: you have yet to take the survey %s", encloseInBold(format, n.Title))
+	}
+	view.setIcon(nil)
+	return view, nil
+}
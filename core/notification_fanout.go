@@ -0,0 +1,380 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/logging"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Notifications that are caused by requests (of new comments, replies, votes,
+// removals, and so on) are not created by the requests, but queued, as
+// notification fan-out jobs (see queueJob), which create them off the
+// requests and survive restarts. Without a job queue, they're created by the
+// requests, as they're queued. The notifications of a user are queued to a
+// batch (see jobs.Queue.EnqueueBatched) that is run fanoutWindow after the
+// first of them, so that those of the requests in the window are created by
+// one job. Before they're created, the notifications of a job are
+// deduplicated (a user is notified of a comment once, even if it's both a
+// reply to them and a comment on their post) and coalesced, and those of users
+// who turned them off, or who muted the user who caused them, are dropped.
+// Notifications of the same post, comment, or upvoted target as an unseen one
+// are counted in it (see newVotesNotification, for instance).
+
+const notificationJobFanout = "notification_fanout"
+
+// fanoutWindow is how long the notifications of a user are batched for before
+// they're created. It's a variable so that tests can change it.
+var fanoutWindow = time.Second * 5
+
+// pendingNotification is a notification yet to be created, or, if update is
+// not nil, an existing notification (update) yet to be changed to notif.
+type pendingNotification struct {
+	user   uid.ID
+	typ    NotificationType
+	notif  any // A notification, or its JSON.
+	update *Notification
+}
+
+// insertNotifications creates (or updates) the notifications pns, all in one
+// transaction, in which the new ones are created by one insert, and sends
+// their push notifications. Notifications of deleted users are dropped.
+func insertNotifications(ctx context.Context, db *sql.DB, pns []*pendingNotification) error {
+	receivers := make(map[uid.ID]bool)
+	var ids []any
+	for _, pn := range pns {
+		if !receivers[pn.user] {
+			receivers[pn.user] = true
+			ids = append(ids, pn.user)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	// Exit silently for deleted users.
+	deleted := make(map[uid.ID]bool)
+	rows, err := db.QueryContext(ctx, "SELECT id FROM users WHERE deleted_at IS NOT NULL AND id IN "+msql.InClauseQuestionMarks(len(ids)), ids...)
+	if err != nil {
+		return err
+	}
+	deletedIDs, err := scanIDs(rows)
+	if err != nil {
+		return err
+	}
+	for _, id := range deletedIDs {
+		deleted[id] = true
+	}
+
+	var (
+		args    []any
+		updates []*pendingNotification
+	)
+	for _, pn := range pns {
+		if deleted[pn.user] {
+			continue
+		}
+		data, err := json.Marshal(pn.notif)
+		if err != nil {
+			return err
+		}
+		if pn.update != nil {
+			pn.update.notifRawJSON = data
+			updates = append(updates, pn)
+			continue
+		}
+		args = append(args, pn.user, pn.typ, data)
+	}
+	n := len(args) / 3
+	if n == 0 && len(updates) == 0 {
+		return nil
+	}
+
+	var firstID int64
+	err = msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		now := time.Now()
+		for _, pn := range updates {
+			if _, err := tx.ExecContext(ctx, "UPDATE notifications SET notif = ?, updated_at = ? WHERE id = ?", pn.update.notifRawJSON, now, pn.update.ID); err != nil {
+				return err
+			}
+		}
+		if n == 0 {
+			return nil
+		}
+		query := "INSERT INTO notifications (user_id, type, notif) VALUES " + strings.Repeat("(?, ?, ?), ", n-1) + "(?, ?, ?)"
+		res, err := execNotificationsInsert(ctx, tx, query, args...)
+		if err != nil {
+			return err
+		}
+		firstID, err = res.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		user := id.(uid.ID)
+		if deleted[user] {
+			continue
+		}
+		if _, err := removeExcessNotifications(ctx, db, user); err != nil { // attempt
+			logging.FromContext(ctx).Error("Failed removing excess notifications", "error", err)
+		}
+		if err := updateNewNotificationsCount(ctx, db, user); err != nil { // attempt
+			logging.FromContext(ctx).Error("Failed incrementing users.notifications_new_count", "error", err)
+		}
+	}
+
+	// The notifications are created; failing to push them is not failing to
+	// create them (which would have them created again, by a retry).
+	for _, pn := range updates {
+		if err := pn.update.SendPushNotification(ctx); err != nil {
+			logging.FromContext(ctx).Error("Error sending push notification", "id", pn.update.ID, "error", err)
+		}
+	}
+	if n > 0 {
+		pushInsertedNotifications(ctx, db, firstID, n)
+	}
+	return nil
+}
+
+// execNotificationsInsert runs query, the insert of insertNotifications, on
+// tx. It's a variable so that tests can make the insert fail.
+var execNotificationsInsert = func(ctx context.Context, tx *sql.Tx, query string, args ...any) (sql.Result, error) {
+	return tx.ExecContext(ctx, query, args...)
+}
+
+// pushInsertedNotifications sends the push notifications of the n
+// notifications inserted, by one insert, with IDs from firstID. (The rows of
+// an insert get consecutive IDs.)
+func pushInsertedNotifications(ctx context.Context, db *sql.DB, firstID int64, n int) {
+	for id := firstID; id < firstID+int64(n); id++ {
+		notif, err := GetNotification(ctx, db, strconv.FormatInt(id, 10), false, "")
+		if err != nil {
+			logging.FromContext(ctx).Error("Error getting notification", "id", id, "error", err)
+			continue
+		}
+		if err = notif.SendPushNotification(ctx); err != nil {
+			logging.FromContext(ctx).Error("Error sending push notification", "id", id, "error", err)
+		}
+	}
+}
+
+// fanoutItem is a notification queued to the fan-out. Items are the payloads
+// of notification fan-out jobs.
+type fanoutItem struct {
+	Receiver uid.ID `json:"receiver"`
+
+	// The event the notification is of (say, "comment:ID"). A user is
+	// notified of an event once: items of the same receiver and source as an
+	// earlier one are dropped. If empty, the item is not deduplicated.
+	Source string `json:"source,omitempty"`
+
+	// Items of the same receiver and key (and not dropped) are coalesced into
+	// the first of them, whose count is the sum of theirs.
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+
+	// The notification is of Type. New comment and comment reply
+	// notifications are of Comment, by Author, on Post (and in reply to
+	// Parent), and new votes notifications of the upvotes of Target (a post,
+	// if IsPost is true, or a comment). Notifications of other types are
+	// Notif.
+	Type    NotificationType `json:"type"`
+	Post    uid.ID           `json:"post,omitempty"`
+	Parent  uid.ID           `json:"parent,omitempty"`
+	Comment uid.ID           `json:"comment,omitempty"`
+	Author  uid.ID           `json:"author,omitempty"`
+	Target  uid.ID           `json:"target,omitempty"`
+	IsPost  bool             `json:"isPost,omitempty"`
+	Notif   json.RawMessage  `json:"notif,omitempty"`
+}
+
+// prepare returns the notification of item, or nil if there's none to be
+// created (see newVotesNotification, for instance).
+func (item *fanoutItem) prepare(ctx context.Context, db *sql.DB) (*pendingNotification, error) {
+	switch item.Type {
+	case NotificationTypeNewComment, NotificationTypeCommentReply:
+		post, err := GetPost(ctx, db, &item.Post, "", nil, true)
+		if err != nil {
+			return nil, err
+		}
+		author, err := GetUser(ctx, db, item.Author, nil)
+		if err != nil {
+			return nil, err
+		}
+		if item.Type == NotificationTypeNewComment {
+			return newCommentNotification(ctx, db, post, item.Comment, author, item.Count)
+		}
+		return commentReplyNotification(ctx, db, item.Receiver, item.Parent, item.Comment, author, post, item.Count)
+	case NotificationTypeUpvote:
+		return newVotesNotification(ctx, db, item.Receiver, item.IsPost, item.Target, item.Count)
+	}
+	return &pendingNotification{user: item.Receiver, typ: item.Type, notif: item.Notif}, nil
+}
+
+// coalesceFanoutItems returns items, deduplicated and coalesced, in order.
+func coalesceFanoutItems(items []*fanoutItem) []*fanoutItem {
+	type rkey struct {
+		receiver uid.ID
+		key      string
+	}
+	sources := make(map[rkey]bool)
+	keys := make(map[rkey]*fanoutItem)
+	var out []*fanoutItem
+	for _, item := range items {
+		if item.Source != "" {
+			k := rkey{item.Receiver, item.Source}
+			if sources[k] {
+				continue
+			}
+			sources[k] = true
+		}
+		k := rkey{item.Receiver, item.Key}
+		if first := keys[k]; first != nil {
+			first.Count += item.Count
+			continue
+		}
+		keys[k] = item
+		out = append(out, item)
+	}
+	return out
+}
+
+// queueFanout queues the items to the notification fan-out batches of their
+// receivers. If there's no job queue (see SetJobQueue), or if they cannot be
+// queued, they're created now, rather than delayed in memory, where they'd be
+// lost if the process exited.
+func queueFanout(db *sql.DB, items ...*fanoutItem) {
+	jobQueueMu.RLock()
+	q := jobQueue
+	jobQueueMu.RUnlock()
+
+	ctx := context.Background()
+	if q != nil {
+		batches := make(map[uid.ID][]any)
+		var receivers []uid.ID
+		for _, item := range items {
+			if batches[item.Receiver] == nil {
+				receivers = append(receivers, item.Receiver)
+			}
+			batches[item.Receiver] = append(batches[item.Receiver], item)
+		}
+		var failed []*fanoutItem
+		at := time.Now().Add(fanoutWindow)
+		for _, receiver := range receivers {
+			if err := q.EnqueueBatched(notificationJobFanout, receiver.String(), at, batches[receiver]...); err != nil {
+				logging.FromContext(ctx).Error("Failed queuing notifications", "user", receiver, "error", err)
+				for _, item := range batches[receiver] {
+					failed = append(failed, item.(*fanoutItem))
+				}
+			}
+		}
+		if items = failed; len(items) == 0 {
+			return
+		}
+	}
+	if err := createFanoutItems(ctx, db, items); err != nil {
+		logging.FromContext(ctx).Error("Failed creating notifications", "count", len(items), "error", err)
+	}
+}
+
+// runNotificationFanoutJob creates the notifications of the fan-out items in
+// payload.
+func runNotificationFanoutJob(ctx context.Context, db *sql.DB, payload json.RawMessage) error {
+	var items []*fanoutItem
+	if err := json.Unmarshal(payload, &items); err != nil {
+		return err
+	}
+	return createFanoutItems(ctx, db, items)
+}
+
+// createFanoutItems creates the notifications of items. Items whose
+// notifications cannot be prepared are dropped (and the errors logged), so
+// that an error returned is one of creating all of them.
+func createFanoutItems(ctx context.Context, db *sql.DB, items []*fanoutItem) error {
+	var pns []*pendingNotification
+	for _, item := range coalesceFanoutItems(items) {
+		pn, err := item.prepare(ctx, db)
+		if err != nil {
+			logging.FromContext(ctx).Error("Failed preparing notification", "key", item.Key, "error", err)
+			continue
+		}
+		if pn != nil {
+			pns = append(pns, pn)
+		}
+	}
+	return insertNotifications(ctx, db, pns)
+}
+
+// queueCommentNotifications queues the notifications of comment, a new
+// comment by author on post that, if parent is not nil, is a reply to parent.
+func queueCommentNotifications(db *sql.DB, post *Post, parent *Comment, comment uid.ID, author *User) {
+	var items []*fanoutItem
+	source := "comment:" + comment.String()
+	if parent != nil && !parent.AuthorID.EqualsTo(author.ID) {
+		items = append(items, &fanoutItem{
+			Receiver: parent.AuthorID,
+			Source:   source,
+			Key:      "comment_reply:" + parent.ID.String(),
+			Count:    1,
+			Type:     NotificationTypeCommentReply,
+			Post:     post.ID,
+			Parent:   parent.ID,
+			Comment:  comment,
+			Author:   author.ID,
+		})
+	}
+	if !post.AuthorID.EqualsTo(author.ID) {
+		items = append(items, &fanoutItem{
+			Receiver: post.AuthorID,
+			Source:   source,
+			Key:      "new_comment:" + post.ID.String(),
+			Count:    1,
+			Type:     NotificationTypeNewComment,
+			Post:     post.ID,
+			Comment:  comment,
+			Author:   author.ID,
+		})
+	}
+	if len(items) > 0 {
+		queueFanout(db, items...)
+	}
+}
+
+// queueNewVotesNotification queues the notification, to user, of an upvote
+// of the post, or the comment, targetID.
+func queueNewVotesNotification(db *sql.DB, user uid.ID, isPost bool, targetID uid.ID) {
+	queueFanout(db, &fanoutItem{
+		Receiver: user,
+		Key:      "new_votes:" + targetID.String(),
+		Count:    1,
+		Type:     NotificationTypeUpvote,
+		Target:   targetID,
+		IsPost:   isPost,
+	})
+}
+
+// queueNotification queues the notification notif, of type t, to user. It's
+// for notifications that are neither deduplicated nor coalesced.
+func queueNotification(db *sql.DB, user uid.ID, t NotificationType, notif notification) {
+	data, err := json.Marshal(notif)
+	if err != nil {
+		logging.FromContext(context.Background()).Error("Failed marshaling notification", "type", t, "error", err)
+		return
+	}
+	queueFanout(db, &fanoutItem{
+		Receiver: user,
+		Key:      string(t) + ":" + uid.New().String(), // Unique.
+		Count:    1,
+		Type:     t,
+		Notif:    data,
+	})
+}
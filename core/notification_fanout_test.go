@@ -0,0 +1,229 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/jobs"
+	"github.com/discuitnet/discuit/internal/redistest"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestCoalesceFanoutItems(t *testing.T) {
+	a, b := uid.New(), uid.New()
+	items := []*fanoutItem{
+		{Receiver: a, Source: "comment:1", Key: "comment_reply:1", Count: 1},
+		{Receiver: a, Source: "comment:1", Key: "new_comment:1", Count: 1}, // Dropped.
+		{Receiver: b, Source: "comment:1", Key: "new_comment:1", Count: 1},
+		{Receiver: a, Source: "comment:2", Key: "new_comment:1", Count: 1},
+		{Receiver: a, Key: "new_votes:1", Count: 1},
+		{Receiver: a, Key: "new_votes:1", Count: 2},
+		{Receiver: b, Key: "new_votes:1", Count: 1},
+	}
+	want := []struct {
+		receiver uid.ID
+		key      string
+		count    int
+	}{
+		{a, "comment_reply:1", 1},
+		{b, "new_comment:1", 1},
+		{a, "new_comment:1", 1},
+		{a, "new_votes:1", 3},
+		{b, "new_votes:1", 1},
+	}
+	got := coalesceFanoutItems(items)
+	if len(got) != len(want) {
+		t.Fatalf("got %d items, want %d", len(got), len(want))
+	}
+	for i, item := range got {
+		if w := want[i]; item.Receiver != w.receiver || item.Key != w.key || item.Count != w.count {
+			t.Errorf("item %d is (%v, %s, %d), want (%v, %s, %d)", i, item.Receiver, item.Key, item.Count, w.receiver, w.key, w.count)
+		}
+	}
+}
+
+func TestNotificationFanout(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+
+	author := h.newUser(db, "poster", false)
+	replier := h.newUser(db, "replier", false)
+	voter := h.newUser(db, "voter", false)
+	post := h.newPost(db, author, "fanout")
+
+	// Without a job queue, notifications are created as they're queued.
+	comment, err := Comments().Create(h.ctx, db, &NewComment{Post: post, Author: author.ID, Body: "A comment."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A reply to a comment of the author of the post is notified of once.
+	if _, err := Comments().Create(h.ctx, db, &NewComment{Post: post, Author: replier.ID, Parent: &comment.ID, Body: "A reply."}); err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []*User{replier, voter} {
+		if err := post.Vote(h.ctx, db, user.ID, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	notifs, _, err := GetNotifications(h.ctx, db, author.ID, 10, "", false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[NotificationType]int)
+	for _, n := range notifs {
+		counts[n.Type]++
+		if v, ok := n.Notif.(*NotificationNewVotes); ok && v.NoVotes != 2 {
+			t.Errorf("the new_votes notification is of %d votes, want 2", v.NoVotes)
+		}
+	}
+	if len(notifs) != 2 || counts[NotificationTypeCommentReply] != 1 || counts[NotificationTypeUpvote] != 1 {
+		t.Errorf("got notifications %v, want one comment_reply and one new_votes", counts)
+	}
+}
+
+func TestNotificationFanoutJob(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+
+	user := h.newUser(db, "promoted", false)
+	notif, err := json.Marshal(NotificationModAdd{CommunityName: "pics", AddedBy: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal([]*fanoutItem{
+		{Receiver: user.ID, Key: "mod_add:1", Count: 1, Type: NotificationTypeModAdd, Notif: notif},
+		{Receiver: user.ID, Key: "mod_add:1", Count: 1, Type: NotificationTypeModAdd, Notif: notif}, // Coalesced.
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runNotificationFanoutJob(h.ctx, db, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	notifs, _, err := GetNotifications(h.ctx, db, user.ID, 10, "", false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notifs) != 1 || notifs[0].Type != NotificationTypeModAdd {
+		t.Fatalf("got %d notifications, want one mod_add", len(notifs))
+	}
+	if n, ok := notifs[0].Notif.(*NotificationModAdd); !ok || n.CommunityName != "pics" {
+		t.Errorf("got notification %+v", notifs[0].Notif)
+	}
+}
+
+func TestNotificationFanoutJobRetry(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+
+	author := h.newUser(db, "retried", false)
+	commenter := h.newUser(db, "commenter", false)
+	post := h.newPost(db, author, "retrying")
+	if _, err := Comments().Create(h.ctx, db, &NewComment{Post: post, Author: commenter.ID, Body: "A comment."}); err != nil {
+		t.Fatal(err)
+	}
+
+	notif, err := json.Marshal(NotificationModAdd{CommunityName: "retrying", AddedBy: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal([]*fanoutItem{
+		// Counted in the new_comment notification of the first comment.
+		{Receiver: author.ID, Key: "new_comment:" + post.ID.String(), Count: 1, Type: NotificationTypeNewComment, Post: post.ID, Comment: uid.New(), Author: commenter.ID},
+		{Receiver: author.ID, Key: "mod_add:1", Count: 1, Type: NotificationTypeModAdd, Notif: notif},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insert := execNotificationsInsert
+	defer func() { execNotificationsInsert = insert }()
+	execNotificationsInsert = func(ctx context.Context, tx *sql.Tx, query string, args ...any) (sql.Result, error) {
+		return nil, errors.New("insert failed")
+	}
+	if err := runNotificationFanoutJob(h.ctx, db, payload); err == nil {
+		t.Fatal("the job did not fail")
+	}
+	execNotificationsInsert = insert
+	if err := runNotificationFanoutJob(h.ctx, db, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	notifs, _, err := GetNotifications(h.ctx, db, author.ID, 10, "", false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[NotificationType]int)
+	for _, n := range notifs {
+		counts[n.Type]++
+		if nc, ok := n.Notif.(*NotificationNewComment); ok && nc.NumComments != 2 {
+			t.Errorf("the new_comment notification is of %d comments, want 2", nc.NumComments)
+		}
+	}
+	if len(notifs) != 2 || counts[NotificationTypeNewComment] != 1 || counts[NotificationTypeModAdd] != 1 {
+		t.Errorf("got notifications %v, want one new_comment and one mod_add", counts)
+	}
+}
+
+func TestNotificationFanoutBatch(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+
+	author := h.newUser(db, "batched", false)
+	post := h.newPost(db, author, "batching")
+
+	window := fanoutWindow
+	fanoutWindow = 0
+	q := jobs.New(redistest.NewServer().Pool(), "test", jobs.Options{PollInterval: time.Millisecond})
+	SetJobQueue(db, q)
+	defer func() {
+		fanoutWindow = window
+		SetJobQueue(db, nil)
+	}()
+	var runs, items int
+	q.Handle(notificationJobFanout, func(ctx context.Context, payload json.RawMessage) error {
+		var batch []*fanoutItem
+		if err := json.Unmarshal(payload, &batch); err != nil {
+			return err
+		}
+		runs++
+		items += len(batch)
+		return runNotificationFanoutJob(ctx, db, payload)
+	})
+
+	// The upvotes of two requests, queued before the batch is run.
+	for _, name := range []string{"voter1", "voter2"} {
+		voter := h.newUser(db, name, false)
+		if err := post.Vote(h.ctx, db, voter.ID, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.Start()
+	var notifs []*Notification
+	for deadline := time.Now().Add(time.Second * 5); len(notifs) == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond * 10)
+		var err error
+		if notifs, _, err = GetNotifications(h.ctx, db, author.ID, 10, "", false, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Stop(h.ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if runs != 1 || items != 2 {
+		t.Errorf("%d fan-out jobs were run, of %d items, want 1 of 2", runs, items)
+	}
+	if len(notifs) != 1 {
+		t.Fatalf("got %d notifications, want 1", len(notifs))
+	}
+	if v, ok := notifs[0].Notif.(*NotificationNewVotes); !ok || v.NoVotes != 2 {
+		t.Errorf("got notification %+v, want one of 2 votes", notifs[0].Notif)
+	}
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
@@ -24,13 +25,14 @@ import (
 )
 
 const (
-	publicPostIDLength   = 8
-	maxPostBodyLength    = 20000 // in runes.
-	maxPostTitleLength   = 255   // in runes.
-	maxPostLinkLength    = 2048  // in bytes
-	maxCommentDepth      = 15
-	maxCommentBodyLength = maxPostBodyLength
-	commentsFetchLimit   = 500
+	publicPostIDLength    = 8
+	maxPostBodyLength     = 20000 // in runes.
+	maxPostTitleLength    = 255   // in runes.
+	maxPostLinkLength     = 2048  // in bytes
+	maxImageAltTextLength = 1000  // in runes.
+	maxCommentDepth       = 15
+	maxCommentBodyLength  = maxPostBodyLength
+	commentsFetchLimit    = 500
 )
 
 // setLinkImageCopies sets the image copies for a link image
@@ -76,6 +78,10 @@ type PostLink struct {
 	Image       *images.Image `json:"image"`
 	Version     int           `json:"version"`
 	Hostname    string        `json:"hostname"`
+
+	// Non-nil if the link is to a domain in the link warning list. Clients
+	// should ask users for confirmation before navigating to the link.
+	Warning *LinkWarning `json:"warning,omitempty"`
 }
 
 // SetImageCopies sets the image copies for the link
@@ -155,10 +161,13 @@ type Post struct {
 	// Indicates whether the post is pinned site-wide.
 	PinnedSite bool `json:"isPinnedSite"`
 
-	CommunityID          uid.ID        `json:"communityId"`
-	CommunityName        string        `json:"communityName"`
-	CommunityProPic      *images.Image `json:"communityProPic"`
-	CommunityBannerImage *images.Image `json:"communityBannerImage"`
+	CommunityID            uid.ID        `json:"communityId"`
+	CommunityName          string        `json:"communityName"`
+	CommunityArchived      bool          `json:"communityArchived"`
+	CommunityAgeRestricted bool          `json:"communityAgeRestricted"`
+	CommunityPrivate       bool          `json:"communityPrivate"`
+	CommunityProPic        *images.Image `json:"communityProPic"`
+	CommunityBannerImage   *images.Image `json:"communityBannerImage"`
 
 	Title string          `json:"title"`
 	Body  msql.NullString `json:"body"`
@@ -224,9 +233,12 @@ var selectPostCols = []string{
 	"posts.user_id",
 	"users.username",
 	"posts.user_group",
-	"users.deleted_at is not null",
+	"(users.deleted_at is not null or posts.author_anonymized)",
 	"posts.community_id",
 	"communities.name",
+	"communities.archived_at IS NOT NULL",
+	"communities.age_restricted",
+	"communities.private",
 	"posts.title",
 	"posts.body",
 	"posts.link_info",
@@ -368,6 +380,9 @@ func scanPosts(ctx context.Context, db *sql.DB, rows *sql.Rows, viewer *uid.ID)
 			&post.AuthorDeleted,
 			&post.CommunityID,
 			&post.CommunityName,
+			&post.CommunityArchived,
+			&post.CommunityAgeRestricted,
+			&post.CommunityPrivate,
 			&post.Title,
 			&post.Body,
 			&linkBytes,
@@ -471,6 +486,10 @@ func scanPosts(ctx context.Context, db *sql.DB, rows *sql.Rows, viewer *uid.ID)
 		return nil, err
 	}
 
+	if err := populateLinkWarnings(ctx, db, posts); err != nil {
+		return nil, err
+	}
+
 	viewerAdmin, err := IsAdmin(db, viewer)
 	if err != nil {
 		return nil, err
@@ -586,7 +605,7 @@ func populatePostsImages(ctx context.Context, db *sql.DB, posts []*Post) error {
 				img.AppendCopy("small", 325, 250, images.ImageFitCover, "")
 				img.AppendCopy("medium", 720, 1440, images.ImageFitContain, "")
 				img.AppendCopy("large", 1080, 2160, images.ImageFitContain, "")
-				img.AppendCopy("large", 2160, 4320, images.ImageFitContain, "")
+				img.AppendCopy("xlarge", 2160, 4320, images.ImageFitContain, "")
 				post.Image = img
 				post.Images = append(post.Images, img)
 				break
@@ -631,6 +650,10 @@ type createPostOpts struct {
 	linkImage []byte // for link posts (thumbnail image)
 	// image     uid.ID // for image posts
 	images []*ImageUpload // for image posts
+
+	// For posts imported from other platforms (see ImportArchive):
+	imported  bool      // skips the ban, posting-restriction, and cooldown checks
+	createdAt time.Time // if zero, the current time
 }
 
 func createPost(ctx context.Context, db *sql.DB, opts *createPostOpts) (*Post, error) {
@@ -643,19 +666,60 @@ func createPost(ctx context.Context, db *sql.DB, opts *createPostOpts) (*Post, e
 		return nil, err
 	}
 
-	// Check if the author is banned from community.
-	if is, err := community.UserBanned(ctx, db, opts.author); err != nil {
+	if !opts.imported {
+		// Check if the author is banned from community.
+		if is, err := community.UserBanned(ctx, db, opts.author); err != nil {
+			return nil, err
+		} else if is {
+			return nil, errUserBannedFromCommunity
+		}
+
+		if err := CheckCommunityAccess(ctx, db, community.ID, community.Private, &opts.author); err != nil {
+			return nil, err
+		}
+
+		// Check if posting in the community is restricted, and if so, if the user has permission.
+		if community.PostingRestricted {
+			if is, err := community.UserModOrAdmin(ctx, db, opts.author); err != nil {
+				return nil, err
+			} else if !is {
+				return nil, httperr.NewForbidden("posting-restricted", "Posting in this community is restricted.")
+			}
+		}
+	}
+
+	if community.Archived() {
+		return nil, errCommunityArchived
+	}
+
+	if !opts.imported {
+		if err := checkCommunityNotFrozen(ctx, db, community.ID, opts.author); err != nil {
+			return nil, err
+		}
+		if err := checkCooldown(ctx, db, community.ID, opts.author, ContentTypePost); err != nil {
+			return nil, err
+		}
+	}
+
+	remove, err := filterContent(ctx, db, community.ID, &opts.title, &opts.body)
+	if err != nil {
 		return nil, err
-	} else if is {
-		return nil, errUserBannedFromCommunity
 	}
 
-	// Check if posting in the community is restricted, and if so, if the user has permission.
-	if community.PostingRestricted {
-		if is, err := community.UserModOrAdmin(ctx, db, opts.author); err != nil {
+	if community.Quarantined() {
+		if len(opts.images) > 0 {
+			return nil, errQuarantinedNoImages
+		}
+		opts.linkImage = nil // Link posts go without a thumbnail.
+	}
+
+	if opts.postType == PostTypeImage && !opts.imported {
+		imageIDs := make([]uid.ID, len(opts.images))
+		for i := range opts.images {
+			imageIDs[i] = opts.images[i].ImageID
+		}
+		if _, err := CheckDuplicateImages(ctx, db, community.ID, imageIDs...); err != nil {
 			return nil, err
-		} else if !is {
-			return nil, httperr.NewForbidden("posting-restricted", "Posting in this community is restricted.")
 		}
 	}
 
@@ -670,7 +734,10 @@ func createPost(ctx context.Context, db *sql.DB, opts *createPostOpts) (*Post, e
 	post.Title = opts.title
 	post.Body.Valid, post.Body.String = opts.body != "", opts.body
 	post.truncateTitleAndBody()
-	post.CreatedAt = time.Now()
+	post.CreatedAt = opts.createdAt
+	if post.CreatedAt.IsZero() {
+		post.CreatedAt = time.Now()
+	}
 	post.ID = uid.New()
 	post.PublicID = utils.GenerateStringID(publicPostIDLength)
 
@@ -707,9 +774,10 @@ func createPost(ctx context.Context, db *sql.DB, opts *createPostOpts) (*Post, e
 		return nil, err
 	}
 
+	var linkImageID uid.NullID
 	if opts.postType == PostTypeLink && opts.linkImage != nil {
 		// Save link post thumbnail.
-		imageID, err := images.SaveImageTx(ctx, tx, "disk", opts.linkImage, &images.ImageOptions{
+		imageID, err := images.SaveImageTx(ctx, tx, "disk", bytes.NewReader(opts.linkImage), &images.ImageOptions{
 			Width:  1280,
 			Height: 720,
 			Format: images.ImageFormatJPEG,
@@ -720,6 +788,7 @@ func createPost(ctx context.Context, db *sql.DB, opts *createPostOpts) (*Post, e
 			// Continue on error...
 		} else {
 			cols = append(cols, msql.ColumnValue{Name: "link_image", Value: imageID})
+			linkImageID = uid.NullID{ID: imageID, Valid: true}
 		}
 	}
 
@@ -755,6 +824,17 @@ func createPost(ctx context.Context, db *sql.DB, opts *createPostOpts) (*Post, e
 			tx.Rollback()
 			return nil, err
 		}
+
+		// The images count against the upload quota of the community too.
+		var uploadSize int64
+		if err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT COALESCE(SUM(upload_size), 0) FROM images WHERE id IN %s", msql.InClauseQuestionMarks(len(opts.images))), imageIDs...).Scan(&uploadSize); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if err := images.ChargeQuotasTx(ctx, tx, []images.QuotaOwner{{Type: images.QuotaOwnerCommunity, ID: opts.community}}, uploadSize); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
 	}
 
 	for _, table := range postsTables {
@@ -772,12 +852,12 @@ func createPost(ctx context.Context, db *sql.DB, opts *createPostOpts) (*Post, e
 		return nil, err
 	}
 
-	if _, err := tx.ExecContext(ctx, "UPDATE users SET no_posts = no_posts + 1 WHERE id = ?", opts.author); err != nil {
+	if err := incrementUserPosts(ctx, tx, opts.author, 1); err != nil {
 		tx.Rollback()
 		return nil, err
 	}
 
-	if _, err := tx.ExecContext(ctx, "UPDATE communities SET posts_count = posts_count + 1 WHERE id = ?", opts.community); err != nil {
+	if err := incrementCommunityPosts(ctx, tx, opts.community, 1); err != nil {
 		tx.Rollback()
 		return nil, err
 	}
@@ -785,43 +865,37 @@ func createPost(ctx context.Context, db *sql.DB, opts *createPostOpts) (*Post, e
 	if err = tx.Commit(); err != nil {
 		return nil, err
 	}
+	if linkImageID.Valid {
+		images.QueuePostProcessing(linkImageID.ID)
+	}
 
-	return GetPost(ctx, db, &post.ID, "", nil, false)
-}
-
-func CreateTextPost(ctx context.Context, db *sql.DB, author, community uid.ID, title string, body string) (*Post, error) {
-	return createPost(ctx, db, &createPostOpts{
-		postType:  PostTypeText,
-		author:    author,
-		community: community,
-		title:     title,
-		body:      body,
-	})
-}
-
-func CreateImagePost(ctx context.Context, db *sql.DB, author, community uid.ID, title string, imgs []*ImageUpload) (*Post, error) {
-	// We don't check whether the image belongs to the person who uploaded it.
-	// This is not a big deal as image ids are hard to guess.
-
-	// Check if the images exist.
-	recordIDs := make([]uid.ID, len(imgs))
-	for i := range imgs {
-		recordIDs[i] = imgs[i].ImageID
+	p, err := GetPost(ctx, db, &post.ID, "", nil, false)
+	if err != nil {
+		return nil, err
 	}
-	if _, err := images.GetImageRecords(ctx, db, recordIDs...); err != nil {
-		if err == images.ErrImageNotFound {
-			return nil, errImageNotFound
+	if remove {
+		if err := p.autoRemove(ctx, db); err != nil {
+			return nil, err
 		}
-		return nil, err
 	}
-
-	return createPost(ctx, db, &createPostOpts{
-		postType:  PostTypeImage,
-		author:    author,
-		community: community,
-		title:     title,
-		images:    imgs,
-	})
+	invalidateFeedsOf(ctx, p)
+	queueSearchIndex(ctx, db, SearchKindPosts, p.ID)
+	if !opts.imported {
+		publishActivity(ctx, db, &ActivityEvent{
+			Type:          ActivityPost,
+			At:            p.CreatedAt,
+			UserID:        uid.NullID{ID: p.AuthorID, Valid: true},
+			CommunityID:   p.CommunityID,
+			CommunityName: p.CommunityName,
+			PostID:        uid.NullID{ID: p.ID, Valid: true},
+		})
+	}
+	if !remove {
+		if err := p.prefetchImages(ctx, db); err != nil {
+			log.Printf("Error prefetching images of post %v: %v\n", p.ID, err)
+		}
+	}
+	return p, nil
 }
 
 // getLinkPostImage returns the og:image of the url or, if no og:image can be
@@ -842,9 +916,9 @@ func getLinkPostImage(u *url.URL) []byte {
 	}
 	if imageURL == "" {
 		// Since og:image is not found, see if the link itself is an image.
-		probablyAnImage := slices.Contains([]string{"image/jpeg", "image/png", "image/webp"}, res.Header.Get("Content-Type"))
+		probablyAnImage := slices.Contains([]string{"image/jpeg", "image/png", "image/webp", "image/gif", "image/avif"}, res.Header.Get("Content-Type"))
 		if !probablyAnImage {
-			exts := []string{".jpg", ".jpeg", ".png", ".webp"}
+			exts := []string{".jpg", ".jpeg", ".png", ".webp", ".gif", ".avif"}
 			for _, v := range exts {
 				if strings.HasSuffix(u.Path, v) {
 					probablyAnImage = true
@@ -878,7 +952,10 @@ func getLinkPostImage(u *url.URL) []byte {
 	return nil
 }
 
-func CreateLinkPost(ctx context.Context, db *sql.DB, author, community uid.ID, title string, link string) (*Post, error) {
+// linkPostOpts returns the createPostOpts of a link post to link (without the
+// author, community, and title set). If fetchImage is true, the thumbnail of
+// the post is fetched.
+func linkPostOpts(ctx context.Context, db *sql.DB, link string, fetchImage bool) (*createPostOpts, error) {
 	errInvalidURL := httperr.NewBadRequest("invalid-url", "Invalid URL.")
 	if len(link) > maxPostLinkLength {
 		link = link[:maxPostLinkLength]
@@ -895,18 +972,25 @@ func CreateLinkPost(ctx context.Context, db *sql.DB, author, community uid.ID, t
 		return nil, errInvalidURL
 	}
 
-	return createPost(ctx, db, &createPostOpts{
+	// Links to warned domains are not fetched.
+	var linkImage []byte
+	if fetchImage {
+		if warning, err := GetLinkWarning(ctx, db, u.Hostname()); err != nil {
+			return nil, err
+		} else if warning == nil {
+			linkImage = getLinkPostImage(u)
+		}
+	}
+
+	return &createPostOpts{
 		postType:  PostTypeLink,
-		author:    author,
-		community: community,
-		title:     title,
-		linkImage: getLinkPostImage(u),
+		linkImage: linkImage,
 		link: postLink{
 			Version:  1,
 			URL:      u.String(),
 			Hostname: u.Hostname(),
 		},
-	})
+	}, nil
 }
 
 func (p *Post) truncateTitleAndBody() {
@@ -923,11 +1007,19 @@ func (p *Post) Save(ctx context.Context, db *sql.DB, user uid.ID) error {
 	if !p.AuthorID.EqualsTo(user) {
 		return errNotAuthor
 	}
+	if p.CommunityArchived {
+		return errCommunityArchived
+	}
 
 	if err := validatePost(p.Title, p.Body.String); err != nil {
 		return err
 	}
 
+	remove, err := filterContent(ctx, db, p.CommunityID, &p.Title, &p.Body.String)
+	if err != nil {
+		return err
+	}
+
 	p.truncateTitleAndBody()
 
 	now := time.Now()
@@ -941,12 +1033,18 @@ func (p *Post) Save(ctx context.Context, db *sql.DB, user uid.ID) error {
 	query += ", edited_at = ? WHERE id = ?"
 	args = append(args, now, p.ID)
 
-	_, err := db.ExecContext(ctx, query, args...)
-	if err == nil {
-		p.EditedAt.Valid = true
-		p.EditedAt.Time = now
+	if _, err := db.ExecContext(ctx, query, args...); err != nil {
+		return err
 	}
-	return err
+	p.EditedAt.Valid = true
+	p.EditedAt.Time = now
+	uncachePost(ctx, p)
+	queueSearchIndex(ctx, db, SearchKindPosts, p.ID)
+
+	if remove {
+		return p.autoRemove(ctx, db)
+	}
+	return nil
 }
 
 // StripAuthorInfo should be called if the author account of the post is deleted
@@ -1022,9 +1120,17 @@ func (p *Post) Delete(ctx context.Context, db *sql.DB, user uid.ID, g UserGroup,
 			if _, err := tx.ExecContext(ctx, q, true, now, user, g, p.ID); err != nil {
 				return err
 			}
+			if err := incrementUserPosts(ctx, tx, p.AuthorID, -1); err != nil {
+				return err
+			}
 		}
 
 		if deleteContent {
+			if images.TrashEnabled() {
+				if err := p.trashContentTx(ctx, tx); err != nil {
+					return err
+				}
+			}
 			var setBody string
 			if p.Body.Valid {
 				setBody = `body = "", `
@@ -1078,17 +1184,15 @@ func (p *Post) Delete(ctx context.Context, db *sql.DB, user uid.ID, g UserGroup,
 	p.DeletedAt = msql.NewNullTime(now)
 	p.DeletedBy.Valid, p.DeletedBy.ID = true, user
 	p.DeletedAs = g
+	invalidateFeedsOf(ctx, p)
+	queueSearchIndex(ctx, db, SearchKindPosts, p.ID)
 
 	if g != UserGroupNormal {
 		RemoveAllReportsOfPost(ctx, db, p.ID)
 	}
 
 	if sendNotif && (g == UserGroupAdmins || g == UserGroupMods) {
-		go func() {
-			if err := CreatePostDeletedNotification(context.Background(), db, p.AuthorID, g, true, p.ID); err != nil {
-				log.Printf("Failed to create deleted_post notification on post %v\n", p.PublicID)
-			}
-		}()
+		queueNotification(db, p.AuthorID, NotificationTypeDeletePost, postDeletedNotification(g, true, p.ID))
 	}
 
 	return err
@@ -1125,6 +1229,7 @@ func (p *Post) Lock(ctx context.Context, db *sql.DB, user uid.ID, g UserGroup) e
 		p.LockedAt = msql.NewNullTime(now)
 		p.LockedBy.Valid, p.LockedBy.ID = true, user
 		p.LockedAs = g
+		uncachePost(ctx, p)
 	}
 	return err
 }
@@ -1152,6 +1257,7 @@ func (p *Post) Unlock(ctx context.Context, db *sql.DB, user uid.ID) error {
 		p.LockedAt.Valid = false
 		p.LockedBy.Valid = false
 		p.LockedAs = UserGroupNaN
+		uncachePost(ctx, p)
 	}
 	return err
 }
@@ -1205,7 +1311,7 @@ func (p *Post) Pin(ctx context.Context, db *sql.DB, user uid.ID, siteWide, unpin
 		}
 	}
 
-	return msql.Transact(ctx, db, func(tx *sql.Tx) (err error) {
+	err := msql.Transact(ctx, db, func(tx *sql.Tx) (err error) {
 		var (
 			query string
 			args  []any
@@ -1246,6 +1352,10 @@ func (p *Post) Pin(ctx context.Context, db *sql.DB, user uid.ID, siteWide, unpin
 		}
 		return err
 	})
+	if err == nil {
+		invalidateFeedsOf(ctx, p)
+	}
+	return err
 }
 
 func (p *Post) updatePostsTablesPoints(ctx context.Context, db *sql.DB) error {
@@ -1258,79 +1368,55 @@ func (p *Post) updatePostsTablesPoints(ctx context.Context, db *sql.DB) error {
 }
 
 func (p *Post) Vote(ctx context.Context, db *sql.DB, user uid.ID, up bool) error {
+	if p.CommunityArchived {
+		return errCommunityArchived
+	}
 	if p.Locked {
 		return errPostLocked
 	}
 
-	tx, err := db.BeginTx(ctx, nil)
-	if err != nil {
-		return err
-	}
-
-	_, err = tx.ExecContext(ctx, "INSERT INTO post_votes (post_id, user_id, up) VALUES (?, ?, ?)", p.ID, user, up)
-	if err != nil {
-		tx.Rollback()
-		if msql.IsErrDuplicateErr(err) {
-			return &httperr.Error{
-				HTTPStatus: http.StatusConflict,
-				Code:       "already-voted",
-				Message:    "User has already voted.",
+	vc := newVoteChange(nil, &up, p.AuthorID.EqualsTo(user))
+	buffered := postVotesBuffered(ctx, p.ID)
+	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO post_votes (post_id, user_id, up) VALUES (?, ?, ?)", p.ID, user, up); err != nil {
+			if msql.IsErrDuplicateErr(err) {
+				return &httperr.Error{
+					HTTPStatus: http.StatusConflict,
+					Code:       "already-voted",
+					Message:    "User has already voted.",
+				}
 			}
+			return err
 		}
-		return err
-	}
-
-	point := 1
-	if !up {
-		point = -1
-	}
-
-	query := "UPDATE posts SET points = points + ?, hotness = ?"
-	newUpvotes, newDownvotes := p.Upvotes, p.Downvotes
-	if up {
-		query += ", upvotes = upvotes + 1"
-		newUpvotes++
-	} else {
-		query += ", downvotes = downvotes + 1"
-		newDownvotes++
-	}
-	query += " WHERE id = ?"
-
-	_, err = tx.ExecContext(ctx, query, point, PostHotness(newUpvotes, newDownvotes, p.CreatedAt), p.ID)
+		if buffered {
+			return nil
+		}
+		return p.applyVoteChangeTx(ctx, tx, vc)
+	})
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 
-	if err = tx.Commit(); err != nil {
-		return err
-	}
-
-	p.Upvotes = newUpvotes
-	p.Downvotes = newDownvotes
-	p.Points += point
+	p.applyVoteChange(vc)
 	p.ViewerVoted = msql.NewNullBool(true)
 	p.ViewerVotedUp = msql.NewNullBool(up)
 
-	// Attempt to update user's points.
-	if up && !p.AuthorID.EqualsTo(user) {
-		incrementUserPoints(ctx, db, p.AuthorID, 1)
-	}
-
 	// Attempt to create a notification (only for upvotes).
 	if !p.AuthorID.EqualsTo(user) && up {
-		go func() {
-			if err := CreateNewVotesNotification(context.Background(), db, p.AuthorID, p.CommunityName, true, p.ID); err != nil {
-				log.Printf("Failed creating new_votes notification: %v\n", err)
-			}
-		}()
+		queueNewVotesNotification(db, p.AuthorID, true, p.ID)
 	}
 
+	if buffered {
+		return bufferPostVoteChange(ctx, db, p.ID, vc)
+	}
 	return p.updatePostsTablesPoints(ctx, db)
 }
 
 // DeleteVote undos users's vote on post.
 func (p *Post) DeleteVote(ctx context.Context, db *sql.DB, user uid.ID) error {
+	if p.CommunityArchived {
+		return errCommunityArchived
+	}
 	if p.Locked {
 		return errPostLocked
 	}
@@ -1341,56 +1427,47 @@ func (p *Post) DeleteVote(ctx context.Context, db *sql.DB, user uid.ID) error {
 		return err
 	}
 
-	tx, err := db.BeginTx(ctx, nil)
-	if err != nil {
-		return err
-	}
-
-	_, err = tx.ExecContext(ctx, "DELETE FROM post_votes WHERE id = ?", id)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-
-	query := "UPDATE posts SET points = points + ?, hotness = ?"
-	point := 1
-	newUpvotes, newDownvotes := p.Upvotes, p.Downvotes
-	if up {
-		point = -1
-		query += ", upvotes = upvotes - 1"
-		newUpvotes--
-	} else {
-		query += ", downvotes = downvotes -1"
-		newDownvotes--
-	}
-	query += " WHERE id = ?"
-
-	_, err = tx.ExecContext(ctx, query, point, PostHotness(newUpvotes, newDownvotes, p.CreatedAt), p.ID)
+	vc := newVoteChange(&up, nil, p.AuthorID.EqualsTo(user))
+	applied, buffered := false, postVotesBuffered(ctx, p.ID)
+	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, "DELETE FROM post_votes WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err // deleted concurrently
+		}
+		applied = true
+		if buffered {
+			return nil
+		}
+		return p.applyVoteChangeTx(ctx, tx, vc)
+	})
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 
-	if err = tx.Commit(); err != nil {
-		return err
+	if applied {
+		p.applyVoteChange(vc)
 	}
 
-	p.Upvotes = newUpvotes
-	p.Downvotes = newDownvotes
-	p.Points += point
 	p.ViewerVoted.Valid = false
 	p.ViewerVotedUp.Valid = false
 
-	// Attempt to update user's points.
-	if up && !p.AuthorID.EqualsTo(user) {
-		incrementUserPoints(ctx, db, p.AuthorID, -1)
+	if buffered {
+		if !applied {
+			return nil
+		}
+		return bufferPostVoteChange(ctx, db, p.ID, vc)
 	}
-
 	return p.updatePostsTablesPoints(ctx, db)
 }
 
 // ChangeVote changes user's vote on post.
 func (p *Post) ChangeVote(ctx context.Context, db *sql.DB, user uid.ID, up bool) error {
+	if p.CommunityArchived {
+		return errCommunityArchived
+	}
 	if p.Locked {
 		return errPostLocked
 	}
@@ -1405,57 +1482,54 @@ func (p *Post) ChangeVote(ctx context.Context, db *sql.DB, user uid.ID, up bool)
 		return nil
 	}
 
-	tx, err := db.BeginTx(ctx, nil)
+	vc := newVoteChange(&dbUp, &up, p.AuthorID.EqualsTo(user))
+	applied, buffered := false, postVotesBuffered(ctx, p.ID)
+	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, "UPDATE post_votes SET up = ? WHERE id = ? AND up = ?", up, id, dbUp)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err // changed concurrently
+		}
+		applied = true
+		if buffered {
+			return nil
+		}
+		return p.applyVoteChangeTx(ctx, tx, vc)
+	})
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.ExecContext(ctx, "UPDATE post_votes SET up = ? WHERE id = ?", up, id)
-	if err != nil {
-		tx.Rollback()
-		return err
+	if applied {
+		p.applyVoteChange(vc)
 	}
 
-	query := "UPDATE posts SET points = points + ?, hotness = ?"
-	points := 2
-	newUpvotes, newDownvotes := p.Upvotes, p.Downvotes
-	if dbUp {
-		points = -2
-		query += ", upvotes = upvotes - 1, downvotes = downvotes + 1"
-		newUpvotes--
-		newDownvotes++
-	} else {
-		query += ", upvotes = upvotes + 1, downvotes = downvotes - 1"
-		newUpvotes++
-		newDownvotes--
-	}
-	query += " WHERE id = ?"
+	p.ViewerVotedUp = msql.NewNullBool(up)
 
-	_, err = tx.ExecContext(ctx, query, points, PostHotness(newUpvotes, newDownvotes, p.CreatedAt), p.ID)
-	if err != nil {
-		tx.Rollback()
-		return err
+	if buffered {
+		if !applied {
+			return nil
+		}
+		return bufferPostVoteChange(ctx, db, p.ID, vc)
 	}
+	return p.updatePostsTablesPoints(ctx, db)
+}
 
-	if err = tx.Commit(); err != nil {
+// applyVoteChangeTx updates the vote counters and the hotness of the post, and
+// the points of its author.
+func (p *Post) applyVoteChangeTx(ctx context.Context, tx *sql.Tx, vc voteChange) error {
+	if err := vc.apply(ctx, tx, "posts", p.ID, p.AuthorID); err != nil {
 		return err
 	}
+	return updatePostsHotnessTx(ctx, tx, p.ID)
+}
 
-	p.Upvotes = newUpvotes
-	p.Downvotes = newDownvotes
-	p.Points += points
-	p.ViewerVotedUp = msql.NewNullBool(up)
-
-	// Attempt to update user's points.
-	if !p.AuthorID.EqualsTo(user) {
-		point := 1
-		if dbUp {
-			point = -1
-		}
-		incrementUserPoints(ctx, db, p.AuthorID, point)
-	}
-
-	return p.updatePostsTablesPoints(ctx, db)
+func (p *Post) applyVoteChange(vc voteChange) {
+	p.Upvotes += vc.upvotes
+	p.Downvotes += vc.downvotes
+	p.Points += vc.points
 }
 
 func getComments(ctx context.Context, db *sql.DB, viewer *uid.ID, where string, args ...interface{}) ([]*Comment, error) {
@@ -1590,8 +1664,9 @@ func (p *Post) GetCommentReplies(ctx context.Context, db *sql.DB, viewer *uid.ID
 	return GetCommentsByIDs(ctx, db, viewer, ids...)
 }
 
-// AddComment adds a new comment to post.
-func (p *Post) AddComment(ctx context.Context, db *sql.DB, user uid.ID, g UserGroup, parentComment *uid.ID, body string) (*Comment, error) {
+// createComment adds a new comment to p, after checking that user can comment
+// on it as g. New comments are created by a CommentService (see Comments).
+func createComment(ctx context.Context, db *sql.DB, p *Post, user uid.ID, g UserGroup, parentComment *uid.ID, body string) (*Comment, error) {
 	if p.Locked {
 		return nil, errPostLocked
 	}
@@ -1603,6 +1678,22 @@ func (p *Post) AddComment(ctx context.Context, db *sql.DB, user uid.ID, g UserGr
 		return nil, errUserBannedFromCommunity
 	}
 
+	if err := CheckCommunityAccess(ctx, db, p.CommunityID, p.CommunityPrivate, &user); err != nil {
+		return nil, err
+	}
+
+	if p.CommunityArchived {
+		return nil, errCommunityArchived
+	}
+
+	if err := checkCommunityNotFrozen(ctx, db, p.CommunityID, user); err != nil {
+		return nil, err
+	}
+
+	if err := checkCooldown(ctx, db, p.CommunityID, user, ContentTypeComment); err != nil {
+		return nil, err
+	}
+
 	u, err := GetUser(ctx, db, user, nil)
 	if err != nil {
 		return nil, err
@@ -1632,11 +1723,26 @@ func (p *Post) AddComment(ctx context.Context, db *sql.DB, user uid.ID, g UserGr
 	}
 
 	body = strings.TrimSpace(body)
-	comment, err := addComment(ctx, db, p, u, parentComment, body)
+	remove, err := filterContent(ctx, db, p.CommunityID, &body)
 	if err != nil {
 		return nil, err
 	}
-	comment.ChangeUserGroup(ctx, db, u.ID, g)
+
+	comment, err := addComment(ctx, db, p, u, parentComment, body, g, remove, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	publishActivity(ctx, db, &ActivityEvent{
+		Type:          ActivityComment,
+		At:            comment.CreatedAt,
+		UserID:        uid.NullID{ID: u.ID, Valid: true},
+		Username:      u.Username,
+		IsBot:         u.IsBot,
+		CommunityID:   p.CommunityID,
+		CommunityName: p.CommunityName,
+		PostID:        uid.NullID{ID: p.ID, Valid: true},
+		CommentID:     uid.NullID{ID: comment.ID, Valid: true},
+	})
 	return comment, nil
 }
 
@@ -1841,16 +1947,21 @@ func UpdateAllPostsHotness(ctx context.Context, db *sql.DB) error {
 	return nil
 }
 
-func SavePostImage(ctx context.Context, db *sql.DB, authorID uid.ID, image []byte, s3Enabled bool) (*images.ImageRecord, error) {
+// SavePostImage saves image, cropped to crop if it's not nil, as an image to
+// be posted by authorID, described by altText (which may be empty).
+func SavePostImage(ctx context.Context, db *sql.DB, authorID uid.ID, image io.Reader, crop *images.CropRect, altText string, s3Enabled bool) (*images.ImageRecord, error) {
 	var imageID uid.ID
 	err := msql.Transact(ctx, db, func(tx *sql.Tx) (err error) {
 		storeName := images.GetDefaultStoreName(s3Enabled)
 
 		id, err := images.SaveImageTx(ctx, tx, storeName, image, &images.ImageOptions{
-			Width:  5000,
-			Height: 5000,
-			Format: images.ImageFormatJPEG,
-			Fit:    images.ImageFitContain,
+			Width:   5000,
+			Height:  5000,
+			Format:  images.ImageFormatJPEG,
+			Fit:     images.ImageFitContain,
+			Quota:   []images.QuotaOwner{{Type: images.QuotaOwnerUser, ID: authorID}},
+			Crop:    crop,
+			AltText: utils.TruncateUnicodeString(strings.TrimSpace(altText), maxImageAltTextLength),
 		})
 		if err != nil {
 			return fmt.Errorf("failed to save post image (author: %v): %w", authorID, err)
@@ -1864,6 +1975,7 @@ func SavePostImage(ctx context.Context, db *sql.DB, authorID uid.ID, image []byt
 	if err != nil {
 		return nil, err
 	}
+	images.QueuePostProcessing(imageID)
 	return images.GetImageRecord(ctx, db, imageID)
 }
 
@@ -0,0 +1,242 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/images"
+	"github.com/discuitnet/discuit/internal/logging"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// All new posts, whoever creates them (users through the API, bots, the bench
+// command), are created by the PostService of the site (see Posts), so that
+// they're validated in the same way, and go through the same hooks once
+// they're created. Posts imported from other platforms are the exception (see
+// ImportArchive).
+//
+// Checks of the community (bans, posting restrictions, cooldowns, and so on)
+// and content filters (see filterContent) are run as the post is saved; the
+// hooks of the service run after.
+
+// PostContent is the content of a new post: a TextContent, a LinkContent, or
+// an ImageContent.
+type PostContent interface {
+	PostType() PostType
+
+	// createOpts returns the options of createPost for the content (without
+	// the author, community, and title set).
+	createOpts(ctx context.Context, db *sql.DB, limits PostLimits) (*createPostOpts, error)
+}
+
+// TextContent is the content of a text post.
+type TextContent struct {
+	Body string
+}
+
+func (c *TextContent) PostType() PostType { return PostTypeText }
+
+func (c *TextContent) createOpts(ctx context.Context, db *sql.DB, limits PostLimits) (*createPostOpts, error) {
+	return &createPostOpts{postType: PostTypeText, body: c.Body}, nil
+}
+
+// LinkContent is the content of a link post.
+type LinkContent struct {
+	URL string
+}
+
+func (c *LinkContent) PostType() PostType { return PostTypeLink }
+
+func (c *LinkContent) createOpts(ctx context.Context, db *sql.DB, limits PostLimits) (*createPostOpts, error) {
+	return linkPostOpts(ctx, db, c.URL, true)
+}
+
+// ImageContent is the content of an image post.
+type ImageContent struct {
+	Images []*ImageUpload
+}
+
+func (c *ImageContent) PostType() PostType { return PostTypeImage }
+
+func (c *ImageContent) createOpts(ctx context.Context, db *sql.DB, limits PostLimits) (*createPostOpts, error) {
+	if limits.ImagePostsDisabled {
+		return nil, httperr.NewForbidden("no_image_posts", "Image posts are not allowed")
+	}
+	if len(c.Images) == 0 {
+		return nil, httperr.NewBadRequest("no-images", "No images.")
+	}
+	if limits.MaxImages > 0 && len(c.Images) > limits.MaxImages {
+		return nil, httperr.NewBadRequest("too-many-images", "Maximum images count exceeded.")
+	}
+
+	// We don't check whether the image belongs to the person who uploaded it.
+	// This is not a big deal as image ids are hard to guess.
+
+	// Check if the images exist.
+	recordIDs := make([]uid.ID, len(c.Images))
+	for i := range c.Images {
+		recordIDs[i] = c.Images[i].ImageID
+	}
+	if _, err := images.GetImageRecords(ctx, db, recordIDs...); err != nil {
+		if err == images.ErrImageNotFound {
+			return nil, errImageNotFound
+		}
+		return nil, err
+	}
+	return &createPostOpts{postType: PostTypeImage, images: c.Images}, nil
+}
+
+// NewPost is a post to be created by a PostService.
+type NewPost struct {
+	Author    uid.ID
+	Community uid.ID
+	Title     string
+	Content   PostContent
+
+	// The group the post is made as (see Post.ChangeUserGroup). If it's
+	// UserGroupNaN or UserGroupNormal, it's that of the author.
+	UserGroup UserGroup
+
+	// If true, the rate limits of the service are not applied (say, for
+	// requests made with the admin API key).
+	SkipRateLimits bool
+}
+
+// PostLimits are the limits of the posts created by a PostService.
+type PostLimits struct {
+	ImagePostsDisabled bool
+	MaxImages          int // Per post. If 0, there's no limit.
+}
+
+// A RateLimiter limits the rate of actions. Limit reports whether one more
+// action fits in the bucket with ID bucket, of maxTokens actions per interval.
+type RateLimiter interface {
+	Limit(ctx context.Context, bucket string, interval time.Duration, maxTokens int) (bool, error)
+}
+
+var errTooManyRequests = &httperr.Error{HTTPStatus: http.StatusTooManyRequests}
+
+// A PostHook is run for every post created by a PostService, after it's
+// created. Implementations must be safe for concurrent use.
+type PostHook interface {
+	Name() string // The identifier of the hook.
+
+	// PostCreated is called with the new post. An error returned by it is
+	// logged; the post is created regardless.
+	PostCreated(ctx context.Context, db *sql.DB, post *Post) error
+}
+
+// PostService creates posts.
+type PostService struct {
+	mu      sync.RWMutex
+	limits  PostLimits
+	limiter RateLimiter // If nil, there are no rate limits.
+	hooks   []PostHook
+}
+
+var posts = &PostService{hooks: []PostHook{botResponsePostHook{}}}
+
+// Posts returns the PostService of the site.
+func Posts() *PostService {
+	return posts
+}
+
+// SetLimits sets the limits of the posts created by s.
+func (s *PostService) SetLimits(limits PostLimits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits = limits
+}
+
+// SetRateLimiter sets the rate limiter of the posts created by s. If l is nil
+// (the default), posts are not rate limited.
+func (s *PostService) SetRateLimiter(l RateLimiter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limiter = l
+}
+
+// AddHook adds hook to the hooks run for new posts, after those added before
+// it.
+func (s *PostService) AddHook(hook PostHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, hook)
+}
+
+// postRateLimits are the rate limits of the posts of each user.
+var postRateLimits = []struct {
+	bucket    string
+	interval  time.Duration
+	maxTokens int
+}{
+	{"add_post_1_", time.Second * 10, 1},
+	{"add_post_2_", time.Hour * 24, 70},
+}
+
+// Create creates the post np. The post is upvoted by its author, as posts
+// are.
+func (s *PostService) Create(ctx context.Context, db *sql.DB, np *NewPost) (*Post, error) {
+	s.mu.RLock()
+	limits, limiter, hooks := s.limits, s.limiter, s.hooks
+	s.mu.RUnlock()
+
+	if np.Content == nil {
+		return nil, httperr.NewBadRequest("invalid_post_type", "Invalid post type.")
+	}
+	if limiter != nil && !np.SkipRateLimits {
+		for _, limit := range postRateLimits {
+			if ok, err := limiter.Limit(ctx, limit.bucket+np.Author.String(), limit.interval, limit.maxTokens); err != nil {
+				return nil, err
+			} else if !ok {
+				return nil, errTooManyRequests
+			}
+		}
+	}
+
+	opts, err := np.Content.createOpts(ctx, db, limits)
+	if err != nil {
+		return nil, err
+	}
+	opts.author, opts.community, opts.title = np.Author, np.Community, np.Title
+	post, err := createPost(ctx, db, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if np.UserGroup != UserGroupNaN && np.UserGroup != UserGroupNormal {
+		if err := post.ChangeUserGroup(ctx, db, np.Author, np.UserGroup); err != nil {
+			return nil, err
+		}
+	}
+	if err := post.Vote(ctx, db, np.Author, true); err != nil {
+		logging.FromContext(ctx).Error("Error upvoting post by its author", "post", post.ID, "error", err)
+	}
+
+	for _, hook := range hooks {
+		if err := hook.PostCreated(ctx, db, post); err != nil {
+			logging.FromContext(ctx).Error("Error running post hook", "hook", hook.Name(), "post", post.ID, "error", err)
+		}
+	}
+	return post, nil
+}
+
+// botResponsePostHook queues responses of bots to the posts of users.
+type botResponsePostHook struct{}
+
+func (botResponsePostHook) Name() string { return "bot_response" }
+
+func (botResponsePostHook) PostCreated(ctx context.Context, db *sql.DB, post *Post) error {
+	isBot, err := IsUserBot(ctx, db, post.AuthorID)
+	if err != nil {
+		return err
+	}
+	if !isBot {
+		QueueBotResponseToPost(db, post)
+	}
+	return nil
+}
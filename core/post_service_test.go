@@ -0,0 +1,107 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// denyingLimiter is a RateLimiter that denies every action, and records the
+// buckets it was asked about.
+type denyingLimiter struct {
+	buckets []string
+}
+
+func (l *denyingLimiter) Limit(ctx context.Context, bucket string, interval time.Duration, maxTokens int) (bool, error) {
+	l.buckets = append(l.buckets, bucket)
+	return false, nil
+}
+
+func TestPostServiceLimits(t *testing.T) {
+	ctx := context.Background()
+	author := uid.New()
+
+	limiter := &denyingLimiter{}
+	s := &PostService{limiter: limiter}
+	_, err := s.Create(ctx, nil, &NewPost{Author: author, Title: "A post", Content: &TextContent{}})
+	var herr *httperr.Error
+	if !errors.As(err, &herr) || herr.HTTPStatus != http.StatusTooManyRequests {
+		t.Errorf("got error %v, want too many requests", err)
+	}
+	if len(limiter.buckets) != 1 || limiter.buckets[0] != "add_post_1_"+author.String() {
+		t.Errorf("got buckets %v", limiter.buckets)
+	}
+
+	limiter.buckets = nil
+	if _, err := s.Create(ctx, nil, &NewPost{Author: author, Content: &TextContent{}, SkipRateLimits: true}); err == errTooManyRequests {
+		t.Error("rate limits were applied to a post that skips them")
+	}
+
+	s = &PostService{limits: PostLimits{ImagePostsDisabled: true}}
+	images := []*ImageUpload{{ImageID: uid.New()}, {ImageID: uid.New()}}
+	if _, err := s.Create(ctx, nil, &NewPost{Author: author, Content: &ImageContent{Images: images}}); !errors.As(err, &herr) || herr.Code != "no_image_posts" {
+		t.Errorf("got error %v, want image posts disallowed", err)
+	}
+	s = &PostService{limits: PostLimits{MaxImages: 1}}
+	if _, err := s.Create(ctx, nil, &NewPost{Author: author, Content: &ImageContent{Images: images}}); !errors.As(err, &herr) || herr.Code != "too-many-images" {
+		t.Errorf("got error %v, want too many images", err)
+	}
+	if _, err := s.Create(ctx, nil, &NewPost{Author: author}); !errors.As(err, &herr) || herr.Code != "invalid_post_type" {
+		t.Errorf("got error %v, want an invalid post type", err)
+	}
+}
+
+// recordingHook is a PostHook that records the posts it's run for.
+type recordingHook struct {
+	posts []uid.ID
+}
+
+func (h *recordingHook) Name() string { return "recording" }
+
+func (h *recordingHook) PostCreated(ctx context.Context, db *sql.DB, post *Post) error {
+	h.posts = append(h.posts, post.ID)
+	return nil
+}
+
+func TestPostServiceCreate(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+	author := h.newUser(db, "poster", false)
+	comm, err := CreateCommunity(h.ctx, db, author.ID, 0, 100, "posting", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook := &recordingHook{}
+	s := &PostService{}
+	s.AddHook(hook)
+	post, err := s.Create(h.ctx, db, &NewPost{
+		Author:    author.ID,
+		Community: comm.ID,
+		Title:     "A post",
+		Content:   &TextContent{Body: "The body of the post."},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hook.posts) != 1 || hook.posts[0] != post.ID {
+		t.Errorf("the hook was run for %v, want [%v]", hook.posts, post.ID)
+	}
+	got, err := GetPost(h.ctx, db, &post.ID, "", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Upvotes != 1 {
+		t.Errorf("got %d upvotes, want the author's", got.Upvotes)
+	}
+
+	if _, err := s.Create(h.ctx, db, &NewPost{Author: author.ID, Community: comm.ID, Title: "A", Content: &TextContent{}}); err == nil {
+		t.Error("created a post with a title too short")
+	}
+}
@@ -0,0 +1,216 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/images"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// If the image trash is enabled (see images.TrashRetention), the content of
+// posts deleted along with their content (see Post.Delete) is kept in the
+// post_content_trash table, and their images in the image trash, until the
+// trash is purged (see PurgePostContentTrash). Until then, admins can restore
+// such posts (see Post.Restore). Posts deleted without their content can be
+// restored as long as they're not purged.
+
+var (
+	errPostNotDeleted  = httperr.NewBadRequest("post_not_deleted", "The post is not deleted.")
+	errPostContentGone = httperr.NewForbidden("post_content_gone", "The content of the post is no longer in the trash.")
+)
+
+// trashContentTx saves the content of p, which is being deleted, to the
+// trash.
+func (p *Post) trashContentTx(ctx context.Context, tx *sql.Tx) error {
+	imageIDs := make([]uid.ID, len(p.Images))
+	for i := range p.Images {
+		imageIDs[i] = *p.Images[i].ID
+	}
+	imagesJSON, err := json.Marshal(imageIDs)
+	if err != nil {
+		return err
+	}
+	var linkImage uid.NullID
+	if p.Type == PostTypeLink && p.HasLinkImage() {
+		linkImage = uid.NullID{ID: *p.Link.Image.ID, Valid: true}
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO post_content_trash (post_id, body, link_image, images) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE body = VALUES(body), link_image = VALUES(link_image), images = VALUES(images), created_at = current_timestamp()`,
+		p.ID, p.Body, linkImage, string(imagesJSON))
+	return err
+}
+
+// Restore undeletes p, on behalf of admin. If the content of p was deleted,
+// it's restored from the trash; if it's no longer there, p is not restored.
+func (p *Post) Restore(ctx context.Context, db *sql.DB, admin uid.ID) error {
+	if is, err := IsAdmin(db, &admin); err != nil {
+		return err
+	} else if !is {
+		return errNotAdmin
+	}
+	if !p.Deleted {
+		return errPostNotDeleted
+	}
+
+	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		var purged bool
+		if err := tx.QueryRowContext(ctx, "SELECT purged_at IS NOT NULL FROM posts WHERE id = ? FOR UPDATE", p.ID).Scan(&purged); err != nil {
+			return err
+		}
+		if purged {
+			return errPostContentGone
+		}
+
+		if p.DeletedContent {
+			if err := p.restoreContentTx(ctx, tx); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE posts SET deleted = FALSE, deleted_at = NULL, deleted_by = NULL, deleted_as = DEFAULT WHERE id = ?", p.ID); err != nil {
+			return err
+		}
+		if err := incrementUserPosts(ctx, tx, p.AuthorID, 1); err != nil {
+			return err
+		}
+		for _, table := range postsTables {
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (community_id, post_id, user_id, created_at) VALUES (?, ?, ?, ?)", table),
+				p.CommunityID, p.ID, p.AuthorID, p.CreatedAt); err != nil && !msql.IsErrDuplicateErr(err) {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	p.Deleted = false
+	p.DeletedAt = msql.NullTime{}
+	p.DeletedBy = uid.NullID{}
+	p.DeletedAs = UserGroupNaN
+	if p.DeletedContent {
+		p.DeletedContent = false
+		p.DeletedContentAt = msql.NullTime{}
+		p.DeletedContentBy = uid.NullID{}
+		p.DeletedContentAs = UserGroupNaN
+	}
+	invalidateFeedsOf(ctx, p)
+	queueSearchIndex(ctx, db, SearchKindPosts, p.ID)
+	return nil
+}
+
+// restoreContentTx restores the content of p from the trash.
+func (p *Post) restoreContentTx(ctx context.Context, tx *sql.Tx) error {
+	var (
+		body       msql.NullString
+		linkImage  uid.NullID
+		imagesJSON string
+	)
+	row := tx.QueryRowContext(ctx, "SELECT body, link_image, images FROM post_content_trash WHERE post_id = ? FOR UPDATE", p.ID)
+	if err := row.Scan(&body, &linkImage, &imagesJSON); err != nil {
+		if err == sql.ErrNoRows {
+			return errPostContentGone
+		}
+		return err
+	}
+	var imageIDs []uid.ID
+	if err := json.Unmarshal([]byte(imagesJSON), &imageIDs); err != nil {
+		return fmt.Errorf("invalid images of trashed post %v: %w", p.ID, err)
+	}
+
+	restore := imageIDs
+	if linkImage.Valid {
+		restore = append(restore, linkImage.ID)
+	}
+	if err := images.RestoreImagesTx(ctx, tx, restore...); err != nil {
+		if errors.Is(err, images.ErrImageNotFound) {
+			return errPostContentGone
+		}
+		return err
+	}
+	if len(imageIDs) > 0 {
+		var rows [][]msql.ColumnValue
+		for _, id := range imageIDs {
+			rows = append(rows, []msql.ColumnValue{
+				{Name: "post_id", Value: p.ID},
+				{Name: "image_id", Value: id},
+			})
+		}
+		query, args := msql.BuildInsertQuery("post_images", rows...)
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+
+	q := `
+		UPDATE posts SET
+			body = ?,
+			link_image = ?,
+			deleted_content = FALSE,
+			deleted_content_at = NULL,
+			deleted_content_by = NULL,
+			deleted_content_as = DEFAULT
+		WHERE id = ?`
+	if _, err := tx.ExecContext(ctx, q, body, linkImage, p.ID); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "DELETE FROM post_content_trash WHERE post_id = ?", p.ID)
+	return err
+}
+
+// trashedPostImages returns the images of the trashed content of the posts
+// with ids args (for which in is the IN clause).
+func trashedPostImages(ctx context.Context, db *sql.DB, args []any, in string) ([]uid.ID, error) {
+	rows, err := db.QueryContext(ctx, "SELECT link_image, images FROM post_content_trash WHERE post_id IN "+in, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uid.ID
+	for rows.Next() {
+		var (
+			linkImage  uid.NullID
+			imagesJSON string
+			imageIDs   []uid.ID
+		)
+		if err := rows.Scan(&linkImage, &imagesJSON); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(imagesJSON), &imageIDs); err != nil {
+			return nil, err
+		}
+		ids = append(ids, imageIDs...)
+		if linkImage.Valid {
+			ids = append(ids, linkImage.ID)
+		}
+	}
+	return ids, rows.Err()
+}
+
+// PurgePostContentTrash permanently deletes the content of posts that was
+// moved to the trash before before (their images are purged with the image
+// trash; see images.PurgeTrash). If dryRun is true, nothing is deleted. It
+// returns the number of posts whose content is purged (or is to be purged).
+func PurgePostContentTrash(ctx context.Context, db *sql.DB, before time.Time, dryRun bool) (int, error) {
+	if dryRun {
+		var n int
+		err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM post_content_trash WHERE created_at < ?", before).Scan(&n)
+		return n, err
+	}
+	res, err := db.ExecContext(ctx, "DELETE FROM post_content_trash WHERE created_at < ?", before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
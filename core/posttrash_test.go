@@ -0,0 +1,96 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/images"
+)
+
+func TestPostContentTrash(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+
+	retention := images.TrashRetention
+	images.TrashRetention = time.Hour
+	defer func() { images.TrashRetention = retention }()
+
+	author := h.newUser(db, "trasher", false)
+	admin := h.newUser(db, "restorer", false)
+	if _, err := MakeAdmin(h.ctx, db, admin.Username, true); err != nil {
+		t.Fatal(err)
+	}
+	post := h.newImagePost(db, author, "trashing")
+	if len(post.Images) != 1 {
+		t.Fatalf("the post has %d images, want 1", len(post.Images))
+	}
+	imageID := *post.Images[0].ID
+	stored := h.storedImages()
+
+	// purge purges the trash of what was trashed before the retention window.
+	purge := func() (imagesPurged, postsPurged int) {
+		t.Helper()
+		before := time.Now().Add(-images.TrashRetention)
+		ids, err := images.PurgeTrash(h.ctx, db, before, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if postsPurged, err = PurgePostContentTrash(h.ctx, db, before, false); err != nil {
+			t.Fatal(err)
+		}
+		return len(ids), postsPurged
+	}
+
+	if err := post.Delete(h.ctx, db, author.ID, UserGroupNormal, true, false); err != nil {
+		t.Fatal(err)
+	}
+	record, err := images.GetImageRecord(h.ctx, db, imageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !record.Trashed() || h.storedImages() != stored {
+		t.Errorf("the image of the deleted post is not in the trash (trashed: %v, files: %d, want %d)", record.Trashed(), h.storedImages(), stored)
+	}
+
+	// Nothing is purged within the retention window.
+	if i, p := purge(); i != 0 || p != 0 {
+		t.Errorf("purged %d images and %d posts within the retention window", i, p)
+	}
+
+	// A restore brings the images back.
+	post = h.getPost(db, post.ID)
+	if err := post.Restore(h.ctx, db, admin.ID); err != nil {
+		t.Fatal(err)
+	}
+	post = h.getPost(db, post.ID)
+	if post.Deleted || post.DeletedContent || len(post.Images) != 1 || !post.Images[0].ID.EqualsTo(imageID) {
+		t.Errorf("the restored post is deleted: %v, content deleted: %v, with %d images", post.Deleted, post.DeletedContent, len(post.Images))
+	}
+	if record, err := images.GetImageRecord(h.ctx, db, imageID); err != nil || record.Trashed() {
+		t.Errorf("the image of the restored post is trashed (error: %v)", err)
+	}
+
+	// Once the retention window is over, the trash is purged, once.
+	if err := post.Delete(h.ctx, db, author.ID, UserGroupNormal, true, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(h.ctx, "UPDATE images SET deleted_at = ? WHERE id = ?", time.Now().Add(-images.TrashRetention-time.Minute), imageID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(h.ctx, "UPDATE post_content_trash SET created_at = ? WHERE post_id = ?", time.Now().Add(-images.TrashRetention-time.Minute), post.ID); err != nil {
+		t.Fatal(err)
+	}
+	if i, p := purge(); i != 1 || p != 1 {
+		t.Errorf("purged %d images and %d posts, want 1 and 1", i, p)
+	}
+	if h.storedImages() != stored-1 {
+		t.Errorf("%d image files are stored, want %d", h.storedImages(), stored-1)
+	}
+	if i, p := purge(); i != 0 || p != 0 {
+		t.Errorf("purged %d images and %d posts again", i, p)
+	}
+	post = h.getPost(db, post.ID)
+	if err := post.Restore(h.ctx, db, admin.ID); err != errPostContentGone {
+		t.Errorf("restoring the purged post returned %v, want %v", err, errPostContentGone)
+	}
+}
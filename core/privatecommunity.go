@@ -0,0 +1,75 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Private communities (see Community.Private), such as the section
+// communities of cohorts, can only be viewed by their members and by admins.
+// For everyone else, they're left out of feeds, community lists, and search
+// results, and requests for them fail with errCommunityPrivate. Users cannot
+// join private communities themselves; they're added to them (see
+// ImportRoster).
+
+var (
+	errCommunityPrivate = httperr.NewForbidden("community_private", "This community is private.")
+	errJoinPrivate      = httperr.NewForbidden("community_private", "This community is private. Members are added by admins.")
+)
+
+// CheckCommunityAccess returns an error if the community with id, which is
+// private if private is true, cannot be viewed by viewer (nil if not logged
+// in).
+func CheckCommunityAccess(ctx context.Context, db *sql.DB, id uid.ID, private bool, viewer *uid.ID) error {
+	if !private {
+		return nil
+	}
+	if viewer == nil {
+		return errCommunityPrivate
+	}
+	if is, err := IsAdmin(db, viewer); err != nil || is {
+		return err
+	}
+	var n int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM community_members WHERE community_id = ? AND user_id = ?", id, *viewer).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return errCommunityPrivate
+	}
+	return nil
+}
+
+// whereNotPrivate appends to where (of a query on a posts table) a condition
+// that leaves out the posts of private communities that viewer (nil if not
+// logged in) is not a member of.
+func whereNotPrivate(where string, args []any, viewer *uid.ID) (string, []any) {
+	if !(where == "" || strings.TrimSpace(strings.ToUpper(where)) == "WHERE") {
+		where += "AND "
+	}
+	if viewer == nil {
+		return where + "community_id NOT IN (SELECT id FROM communities WHERE private = TRUE) ", args
+	}
+	where += "community_id NOT IN (SELECT id FROM communities WHERE private = TRUE AND id NOT IN (SELECT community_id FROM community_members WHERE user_id = ?)) "
+	return where, append(args, *viewer)
+}
+
+// checkPrivateAccess returns errCommunityPrivate if the feed of opts is the
+// feed of a private community that the viewer of the feed cannot view.
+func (opts *FeedOptions) checkPrivateAccess(ctx context.Context, db *sql.DB) error {
+	if opts.Community == nil {
+		return nil
+	}
+	var private bool
+	if err := db.QueryRowContext(ctx, "SELECT private FROM communities WHERE id = ?", *opts.Community).Scan(&private); err != nil {
+		if err == sql.ErrNoRows {
+			return errCommunityNotFound
+		}
+		return err
+	}
+	return CheckCommunityAccess(ctx, db, *opts.Community, private, opts.Viewer)
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Exports and admin analytics views (see ResearchView) can be rendered for
+// research with pseudonyms in place of usernames, and without emails or user
+// IDs, so that the datasets used for analysis never contain direct
+// identifiers. The pseudonym of a user is random, and the same in every view
+// rendered in a scope (a study, say), so that datasets can be linked. The
+// mapping of users to pseudonyms is kept in a table of its own (pseudonyms),
+// apart from everything that is exported.
+
+// DefaultPseudonymScope is the scope of pseudonyms if none is given.
+const DefaultPseudonymScope = "research"
+
+var pseudonymScopePattern = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// Pseudonymizer hands out the pseudonyms of users in a scope.
+type Pseudonymizer struct {
+	db    *sql.DB
+	scope string
+	cache map[uid.ID]string
+}
+
+// NewPseudonymizer returns a Pseudonymizer of scope, which must be made of
+// lowercase letters, digits, dashes, and underscores.
+func NewPseudonymizer(db *sql.DB, scope string) (*Pseudonymizer, error) {
+	if !pseudonymScopePattern.MatchString(scope) {
+		return nil, httperr.NewBadRequest("invalid_scope", "Invalid pseudonym scope.")
+	}
+	return &Pseudonymizer{db: db, scope: scope, cache: make(map[uid.ID]string)}, nil
+}
+
+// Pseudonym returns the pseudonym of user, which is created if user doesn't
+// have one in the scope of p yet.
+func (p *Pseudonymizer) Pseudonym(ctx context.Context, user uid.ID) (string, error) {
+	if s, ok := p.cache[user]; ok {
+		return s, nil
+	}
+	for i := 0; i < 5; i++ {
+		s, err := newPseudonym()
+		if err != nil {
+			return "", err
+		}
+		// If user already has a pseudonym, or if s is taken, nothing is
+		// inserted.
+		if _, err := p.db.ExecContext(ctx, "INSERT IGNORE INTO pseudonyms (scope, user_id, pseudonym) VALUES (?, ?, ?)", p.scope, user, s); err != nil {
+			return "", err
+		}
+		err = p.db.QueryRowContext(ctx, "SELECT pseudonym FROM pseudonyms WHERE scope = ? AND user_id = ?", p.scope, user).Scan(&s)
+		if err == nil {
+			p.cache[user] = s
+			return s, nil
+		} else if err != sql.ErrNoRows {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("could not create a pseudonym for user %v in scope %s", user, p.scope)
+}
+
+func newPseudonym() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "p" + hex.EncodeToString(b), nil
+}
+
+// A ResearchView is an export or analytics view that can be pseudonymized
+// (see Pseudonymize).
+type ResearchView interface {
+	// usePseudonyms replaces the usernames in the view with pseudonyms from
+	// p, and removes emails and user IDs.
+	usePseudonyms(ctx context.Context, p *Pseudonymizer) error
+}
+
+// Pseudonymize replaces the direct identifiers of the people in view with
+// their pseudonyms in scope.
+func Pseudonymize(ctx context.Context, db *sql.DB, scope string, view ResearchView) error {
+	p, err := NewPseudonymizer(db, scope)
+	if err != nil {
+		return err
+	}
+	return view.usePseudonyms(ctx, p)
+}
+
+func (cp *CohortParticipation) usePseudonyms(ctx context.Context, p *Pseudonymizer) error {
+	for _, m := range cp.Members {
+		s, err := p.Pseudonym(ctx, m.UserID)
+		if err != nil {
+			return err
+		}
+		m.UserID.Clear()
+		m.Username, m.Email = s, ""
+	}
+	// So that the order doesn't give away usernames.
+	sort.Slice(cp.Members, func(i, j int) bool {
+		return cp.Members[i].Username < cp.Members[j].Username
+	})
+	cp.Pseudonymized = true
+	return nil
+}
+
+func (statuses ConsentStatuses) usePseudonyms(ctx context.Context, p *Pseudonymizer) error {
+	for _, s := range statuses {
+		pseudonym, err := p.Pseudonym(ctx, s.UserID)
+		if err != nil {
+			return err
+		}
+		s.UserID.Clear()
+		s.Username = pseudonym
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Username < statuses[j].Username
+	})
+	return nil
+}
+
+func (r *SurveyResults) usePseudonyms(ctx context.Context, p *Pseudonymizer) error {
+	for _, res := range r.Responses {
+		s, err := p.Pseudonym(ctx, res.userID)
+		if err != nil {
+			return err
+		}
+		res.userID.Clear()
+		res.Username = s
+	}
+	sort.Slice(r.Responses, func(i, j int) bool {
+		return r.Responses[i].Username < r.Responses[j].Username
+	})
+	return nil
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestNewPseudonymizerScope(t *testing.T) {
+	for scope, valid := range map[string]bool{
+		"research":              true,
+		"cs278-fall_2024":       true,
+		"":                      false,
+		"Research":              false,
+		"a b":                   false,
+		strings.Repeat("a", 65): false,
+	} {
+		if _, err := NewPseudonymizer(nil, scope); (err == nil) != valid {
+			t.Errorf("NewPseudonymizer(%q) error = %v, want valid = %v", scope, err, valid)
+		}
+	}
+}
+
+func TestPseudonymizedParticipationCSV(t *testing.T) {
+	p := &CohortParticipation{Members: []*MemberParticipation{
+		{UserID: uid.New(), Username: "zed", Email: "zed@example.com"},
+		{UserID: uid.New(), Username: "amy", Email: "amy@example.com"},
+	}}
+	ps, err := NewPseudonymizer(nil, DefaultPseudonymScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.cache[p.Members[0].UserID] = "pb"
+	ps.cache[p.Members[1].UserID] = "pa"
+	if err := p.usePseudonyms(context.Background(), ps); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := p.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, s := range []string{"zed", "amy", "email", "example.com"} {
+		if strings.Contains(out, s) {
+			t.Errorf("pseudonymized CSV contains %q:\n%s", s, out)
+		}
+	}
+	if i, j := strings.Index(out, "pa,"), strings.Index(out, "pb,"); i < 0 || j < 0 || i > j {
+		t.Errorf("pseudonymized CSV is not ordered by pseudonym:\n%s", out)
+	}
+	for _, m := range p.Members {
+		if !m.UserID.Zero() {
+			t.Errorf("user ID of %s was not removed", m.Username)
+		}
+	}
+}
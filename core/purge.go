@@ -0,0 +1,266 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/images"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Posts and comments deleted by their authors are purged, after a retention
+// window, by PurgeDeletedContent. Purged rows are not removed from the
+// database (comments, votes, and notifications reference them), but all the
+// content in them, including images, is permanently erased.
+
+// LegalHoldTarget is the kind of item a legal hold is placed on.
+type LegalHoldTarget string
+
+const (
+	LegalHoldUser    = LegalHoldTarget("user") // All posts and comments of a user.
+	LegalHoldPost    = LegalHoldTarget("post") // A post and all the comments on it.
+	LegalHoldComment = LegalHoldTarget("comment")
+)
+
+func (t LegalHoldTarget) Valid() bool {
+	return slices.Contains([]LegalHoldTarget{LegalHoldUser, LegalHoldPost, LegalHoldComment}, t)
+}
+
+// PlaceLegalHold exempts the item of type target with id from being purged,
+// until the hold is removed. Placing a hold on an item that's already held
+// updates the note.
+func PlaceLegalHold(ctx context.Context, db *sql.DB, target LegalHoldTarget, id uid.ID, note string) error {
+	if !target.Valid() {
+		return fmt.Errorf("invalid legal hold target: %s", target)
+	}
+	_, err := db.ExecContext(ctx, "INSERT INTO legal_holds (target_type, target_id, note) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE note = ?",
+		target, id, msql.NewNullString(note), msql.NewNullString(note))
+	return err
+}
+
+// RemoveLegalHold removes the legal hold placed on the item of type target
+// with id, if there's one.
+func RemoveLegalHold(ctx context.Context, db *sql.DB, target LegalHoldTarget, id uid.ID) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM legal_holds WHERE target_type = ? AND target_id = ?", target, id)
+	return err
+}
+
+// heldCondition returns an SQL condition that's true if any of the items of
+// type target with id expression exprs is under legal hold.
+func heldCondition(exprs map[LegalHoldTarget]string) string {
+	cond := "EXISTS (SELECT 1 FROM legal_holds AS lh WHERE "
+	i := 0
+	for _, target := range []LegalHoldTarget{LegalHoldUser, LegalHoldPost, LegalHoldComment} {
+		expr, ok := exprs[target]
+		if !ok {
+			continue
+		}
+		if i > 0 {
+			cond += " OR "
+		}
+		cond += fmt.Sprintf("(lh.target_type = '%s' AND lh.target_id = %s)", target, expr)
+		i++
+	}
+	return cond + ")"
+}
+
+// PurgeOptions hold arguments to PurgeDeletedContent.
+type PurgeOptions struct {
+	// Only posts and comments deleted before this time are purged.
+	DeletedBefore time.Time
+
+	// Number of items purged per transaction. Defaults to 100.
+	BatchSize int
+
+	// If true, nothing is purged; only the items that would be purged are
+	// reported.
+	DryRun bool
+}
+
+// PurgeResult is the outcome of PurgeDeletedContent.
+type PurgeResult struct {
+	Posts    []uid.ID `json:"posts"`    // Posts purged (or to be purged on a dry run).
+	Comments []uid.ID `json:"comments"` // Comments purged (or to be purged on a dry run).
+	Images   int      `json:"images"`   // Number of images deleted.
+
+	// Items that would have been purged were it not for a legal hold.
+	HeldPosts    int `json:"heldPosts"`
+	HeldComments int `json:"heldComments"`
+}
+
+// PurgeDeletedContent permanently erases the content of posts and comments
+// deleted by their authors (not by mods or admins) before
+// opts.DeletedBefore. Items under legal hold are skipped.
+func PurgeDeletedContent(ctx context.Context, db *sql.DB, opts PurgeOptions) (*PurgeResult, error) {
+	if opts.DeletedBefore.IsZero() {
+		return nil, fmt.Errorf("purge: DeletedBefore not set")
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+
+	res := &PurgeResult{Posts: []uid.ID{}, Comments: []uid.ID{}}
+
+	postsWhere := "deleted = TRUE AND deleted_as = ? AND deleted_at < ? AND purged_at IS NULL"
+	postsHeld := heldCondition(map[LegalHoldTarget]string{LegalHoldUser: "posts.user_id", LegalHoldPost: "posts.id"})
+	if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM posts WHERE %s AND %s", postsWhere, postsHeld),
+		UserGroupNormal, opts.DeletedBefore).Scan(&res.HeldPosts); err != nil {
+		return nil, err
+	}
+	err := purgeBatches(ctx, db, opts, fmt.Sprintf("SELECT id FROM posts WHERE %s AND NOT %s", postsWhere, postsHeld), func(ids []uid.ID) error {
+		res.Posts = append(res.Posts, ids...)
+		if opts.DryRun {
+			return nil
+		}
+		n, err := purgePosts(ctx, db, ids)
+		res.Images += n
+		return err
+	})
+	if err != nil {
+		return res, fmt.Errorf("purging posts: %w", err)
+	}
+
+	commentsWhere := "deleted_at IS NOT NULL AND deleted_as = ? AND deleted_at < ? AND purged_at IS NULL"
+	commentsHeld := heldCondition(map[LegalHoldTarget]string{
+		LegalHoldUser:    "comments.user_id",
+		LegalHoldPost:    "comments.post_id",
+		LegalHoldComment: "comments.id",
+	})
+	if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM comments WHERE %s AND %s", commentsWhere, commentsHeld),
+		UserGroupNormal, opts.DeletedBefore).Scan(&res.HeldComments); err != nil {
+		return nil, err
+	}
+	err = purgeBatches(ctx, db, opts, fmt.Sprintf("SELECT id FROM comments WHERE %s AND NOT %s", commentsWhere, commentsHeld), func(ids []uid.ID) error {
+		res.Comments = append(res.Comments, ids...)
+		if opts.DryRun {
+			return nil
+		}
+		return purgeComments(ctx, db, ids)
+	})
+	if err != nil {
+		return res, fmt.Errorf("purging comments: %w", err)
+	}
+
+	return res, nil
+}
+
+// purgeBatches selects the IDs returned by query (which takes the deleter's
+// user group and the deletion cutoff as arguments), in batches in ascending
+// order, and calls f with each batch.
+func purgeBatches(ctx context.Context, db *sql.DB, opts PurgeOptions, query string, f func(ids []uid.ID) error) error {
+	query += " AND id > ? ORDER BY id LIMIT ?"
+	var last uid.ID // zero ID sorts before all others
+	for {
+		rows, err := db.QueryContext(ctx, query, UserGroupNormal, opts.DeletedBefore, last, opts.BatchSize)
+		if err != nil {
+			return err
+		}
+		var ids []uid.ID
+		for rows.Next() {
+			var id uid.ID
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		if len(ids) == 0 {
+			return nil
+		}
+		if err := f(ids); err != nil {
+			return err
+		}
+		if len(ids) < opts.BatchSize {
+			return nil
+		}
+		last = ids[len(ids)-1]
+	}
+}
+
+// purgePosts erases the content of posts with ids, and deletes their images.
+// It returns the number of images deleted.
+func purgePosts(ctx context.Context, db *sql.DB, ids []uid.ID) (int, error) {
+	args := make([]any, len(ids))
+	for i := range ids {
+		args[i] = ids[i]
+	}
+	in := msql.InClauseQuestionMarks(len(ids))
+
+	var imageIDs []uid.ID
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT image_id FROM post_images WHERE post_id IN %s
+		UNION ALL
+		SELECT link_image FROM posts WHERE id IN %s AND link_image IS NOT NULL`, in, in), append(args, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uid.ID
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		imageIDs = append(imageIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	trashed, err := trashedPostImages(ctx, db, args, in)
+	if err != nil {
+		return 0, err
+	}
+	imageIDs = append(imageIDs, trashed...)
+
+	now := time.Now()
+	err = msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM post_images WHERE post_id IN "+in, args...); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM post_content_trash WHERE post_id IN "+in, args...); err != nil {
+			return err
+		}
+		q := fmt.Sprintf(`
+		UPDATE posts SET
+			title = '',
+			body = IF(body IS NULL, NULL, ''),
+			link_info = NULL,
+			link_image = NULL,
+			deleted_content_at = IF(deleted_content, deleted_content_at, ?),
+			deleted_content_by = IF(deleted_content, deleted_content_by, user_id),
+			deleted_content_as = IF(deleted_content, deleted_content_as, ?),
+			deleted_content = TRUE,
+			purged_at = ?
+		WHERE id IN %s`, in)
+		if _, err := tx.ExecContext(ctx, q, append([]any{now, UserGroupNormal, now}, args...)...); err != nil {
+			return err
+		}
+		if len(imageIDs) > 0 {
+			// Purged content is not kept in the trash.
+			return images.PurgeImagesTx(ctx, tx, db, imageIDs...)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(imageIDs), nil
+}
+
+// purgeComments erases the content of comments with ids.
+func purgeComments(ctx context.Context, db *sql.DB, ids []uid.ID) error {
+	args := []any{time.Now()}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	_, err := db.ExecContext(ctx, "UPDATE comments SET body = '', purged_at = ? WHERE id IN "+msql.InClauseQuestionMarks(len(ids)), args...)
+	return err
+}
@@ -0,0 +1,11 @@
+package core
+
+import "testing"
+
+func TestHeldCondition(t *testing.T) {
+	got := heldCondition(map[LegalHoldTarget]string{LegalHoldPost: "comments.post_id", LegalHoldUser: "comments.user_id"})
+	want := "EXISTS (SELECT 1 FROM legal_holds AS lh WHERE (lh.target_type = 'user' AND lh.target_id = comments.user_id) OR (lh.target_type = 'post' AND lh.target_id = comments.post_id))"
+	if got != want {
+		t.Errorf("heldCondition:\ngot  %s\nwant %s", got, want)
+	}
+}
@@ -0,0 +1,292 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/mobilepush"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+var (
+	mobilePushMutex   sync.RWMutex // guards the following
+	mobilePushSenders = make(map[PushPlatform]mobilepush.Sender)
+)
+
+// EnableMobilePushNotifications enables sending push notifications to devices
+// of platform through sender.
+func EnableMobilePushNotifications(platform PushPlatform, sender mobilepush.Sender) {
+	mobilePushMutex.Lock()
+	defer mobilePushMutex.Unlock()
+	mobilePushSenders[platform] = sender
+}
+
+func mobilePushSender(platform PushPlatform) mobilepush.Sender {
+	mobilePushMutex.RLock()
+	defer mobilePushMutex.RUnlock()
+	return mobilePushSenders[platform]
+}
+
+func mobilePushEnabled() bool {
+	mobilePushMutex.RLock()
+	defer mobilePushMutex.RUnlock()
+	return len(mobilePushSenders) > 0
+}
+
+// PushPlatform is the push service through which a native app receives
+// notifications.
+type PushPlatform string
+
+const (
+	PushPlatformFCM  = PushPlatform("fcm")  // Firebase Cloud Messaging (Android).
+	PushPlatformAPNs = PushPlatform("apns") // Apple Push Notification service (iOS).
+)
+
+func (p PushPlatform) Valid() bool {
+	return slices.Contains([]PushPlatform{PushPlatformFCM, PushPlatformAPNs}, p)
+}
+
+const maxPushDeviceTokenLength = 512
+
+var (
+	errPushDeviceNotFound     = httperr.NewNotFound("push_device_not_found", "Push device not found.")
+	errInvalidPushPlatform    = httperr.NewBadRequest("invalid_push_platform", "Invalid push platform.")
+	errInvalidPushDeviceToken = httperr.NewBadRequest("invalid_push_device_token", "Invalid device token.")
+)
+
+// PushDevicePreferences control which notifications are delivered to a device.
+type PushDevicePreferences struct {
+	// If Disabled is true, no notifications are sent to the device.
+	Disabled bool `json:"disabled"`
+
+	// Notifications of these types are not sent to the device.
+	MutedTypes []NotificationType `json:"mutedTypes"`
+}
+
+// Allows reports whether notifications of type t are to be sent to the device.
+func (p *PushDevicePreferences) Allows(t NotificationType) bool {
+	return !p.Disabled && !slices.Contains(p.MutedTypes, t)
+}
+
+func (p *PushDevicePreferences) validate() error {
+	for _, t := range p.MutedTypes {
+		if !t.Valid() {
+			return httperr.NewBadRequest("invalid_notif_type", "Invalid notification type.")
+		}
+	}
+	return nil
+}
+
+// PushDevice is a native app installation that's registered to receive push
+// notifications of a user.
+//
+// One user could have multiple PushDevice entries in the database, and a device
+// token belongs to at most one user (the user last signed in on the device).
+type PushDevice struct {
+	ID          int                   `json:"id"`
+	UserID      uid.ID                `json:"userId"`
+	Platform    PushPlatform          `json:"platform"`
+	Token       string                `json:"token"`
+	Preferences PushDevicePreferences `json:"preferences"`
+	CreatedAt   time.Time             `json:"createdAt"`
+	UpdatedAt   msql.NullTime         `json:"updatedAt"`
+	LastUsedAt  time.Time             `json:"lastUsedAt"`
+
+	rawPreferences []byte
+}
+
+var selectPushDeviceCols = []string{
+	"id",
+	"user_id",
+	"platform",
+	"token",
+	"preferences",
+	"created_at",
+	"updated_at",
+	"last_used_at",
+}
+
+func scanPushDevices(rows *sql.Rows) ([]*PushDevice, error) {
+	defer rows.Close()
+
+	var devices []*PushDevice
+	for rows.Next() {
+		d := &PushDevice{}
+		if err := rows.Scan(&d.ID, &d.UserID, &d.Platform, &d.Token, &d.rawPreferences, &d.CreatedAt, &d.UpdatedAt, &d.LastUsedAt); err != nil {
+			return nil, err
+		}
+		if d.rawPreferences != nil {
+			if err := json.Unmarshal(d.rawPreferences, &d.Preferences); err != nil {
+				return nil, err
+			}
+		}
+		devices = append(devices, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// RegisterPushDevice adds a device token to the push_devices table. If the
+// token is already registered, it's reassigned to user and its last used time
+// is refreshed. Apps should call this every time they're launched, so that
+// tokens of uninstalled apps can be pruned.
+func RegisterPushDevice(ctx context.Context, db *sql.DB, user uid.ID, platform PushPlatform, token string) (*PushDevice, error) {
+	token = strings.TrimSpace(token)
+	if !platform.Valid() {
+		return nil, errInvalidPushPlatform
+	}
+	if token == "" || len(token) > maxPushDeviceTokenLength {
+		return nil, errInvalidPushDeviceToken
+	}
+
+	// If the device changed hands, the preferences of the previous user are
+	// reset.
+	_, err := db.ExecContext(ctx, `INSERT INTO push_devices (user_id, platform, token) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			preferences = IF(user_id = VALUES(user_id), preferences, NULL),
+			user_id = VALUES(user_id),
+			updated_at = CURRENT_TIMESTAMP(),
+			last_used_at = CURRENT_TIMESTAMP()`,
+		user, platform, token)
+	if err != nil {
+		return nil, err
+	}
+
+	query := msql.BuildSelectQuery("push_devices", selectPushDeviceCols, nil, "WHERE platform = ? AND token = ?")
+	rows, err := db.QueryContext(ctx, query, platform, token)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := scanPushDevices(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return nil, errPushDeviceNotFound
+	}
+	return devices[0], nil
+}
+
+// GetPushDevice returns the push device with id that belongs to user.
+func GetPushDevice(ctx context.Context, db *sql.DB, user uid.ID, id int) (*PushDevice, error) {
+	query := msql.BuildSelectQuery("push_devices", selectPushDeviceCols, nil, "WHERE id = ? AND user_id = ?")
+	rows, err := db.QueryContext(ctx, query, id, user)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := scanPushDevices(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return nil, errPushDeviceNotFound
+	}
+	return devices[0], nil
+}
+
+// GetPushDevices returns all the push devices of user.
+func GetPushDevices(ctx context.Context, db *sql.DB, user uid.ID) ([]*PushDevice, error) {
+	query := msql.BuildSelectQuery("push_devices", selectPushDeviceCols, nil, "WHERE user_id = ? ORDER BY id")
+	rows, err := db.QueryContext(ctx, query, user)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := scanPushDevices(rows)
+	if err != nil {
+		return nil, err
+	}
+	if devices == nil {
+		devices = []*PushDevice{} // for the json "[]" output
+	}
+	return devices, nil
+}
+
+// UpdatePreferences saves d.Preferences to the database.
+func (d *PushDevice) UpdatePreferences(ctx context.Context, db *sql.DB) error {
+	if err := d.Preferences.validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(d.Preferences)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "UPDATE push_devices SET preferences = ?, updated_at = CURRENT_TIMESTAMP() WHERE id = ?", data, d.ID)
+	return err
+}
+
+// DeletePushDevice unregisters the push device with id that belongs to user.
+func DeletePushDevice(ctx context.Context, db *sql.DB, user uid.ID, id int) error {
+	res, err := db.ExecContext(ctx, "DELETE FROM push_devices WHERE id = ? AND user_id = ?", id, user)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errPushDeviceNotFound
+	}
+	return err
+}
+
+// DeleteAllPushDevices unregisters all the push devices of user.
+func DeleteAllPushDevices(ctx context.Context, db *sql.DB, user uid.ID) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM push_devices WHERE user_id = ?", user)
+	return err
+}
+
+// PruneStalePushDevices deletes devices that haven't registered themselves in
+// the given duration, which most likely belong to uninstalled apps. It returns
+// the number of devices deleted.
+func PruneStalePushDevices(ctx context.Context, db *sql.DB, staleAfter time.Duration) (int, error) {
+	res, err := db.ExecContext(ctx, "DELETE FROM push_devices WHERE last_used_at < ?", time.Now().Add(-staleAfter))
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// sendMobilePushNotification sends m to all the push devices of user that
+// allow notifications of type t. Tokens rejected by the push service are
+// deleted.
+func sendMobilePushNotification(ctx context.Context, db *sql.DB, user uid.ID, t NotificationType, m *mobilepush.Message) error {
+	devices, err := GetPushDevices(ctx, db, user)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, device := range devices {
+		if !device.Preferences.Allows(t) {
+			continue
+		}
+		sender := mobilePushSender(device.Platform)
+		if sender == nil {
+			continue
+		}
+		if err := sender.Send(ctx, device.Token, m); err != nil {
+			if errors.Is(err, mobilepush.ErrTokenInvalid) {
+				if _, err := db.ExecContext(ctx, "DELETE FROM push_devices WHERE id = ?", device.ID); err != nil {
+					log.Printf("Error pruning invalid push device token (id: %d): %v\n", device.ID, err)
+				}
+				continue
+			}
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return nil
+}
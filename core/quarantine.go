@@ -0,0 +1,96 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Admins can quarantine a community (see Community.Quarantine). The posts of
+// a quarantined community are left out of the all and home feeds, the
+// community is left out of search results, users have to acknowledge the
+// quarantine before joining the community, and no images can be uploaded to
+// it. Clients are expected to show a warning before showing the community to
+// users who have not acknowledged the quarantine.
+
+var (
+	errQuarantineNotAcknowledged = httperr.NewForbidden("quarantine_not_acknowledged", "This community is quarantined. Acknowledge the quarantine to join it.")
+	errQuarantinedNoImages       = httperr.NewForbidden("quarantined_no_images", "Images cannot be uploaded to a quarantined community.")
+)
+
+// Quarantined reports whether c is quarantined.
+func (c *Community) Quarantined() bool {
+	return c.QuarantinedAt.Valid
+}
+
+// Quarantine quarantines c, with reason shown to users. If c is already
+// quarantined, only the reason is updated.
+func (c *Community) Quarantine(ctx context.Context, db *sql.DB, reason string) error {
+	if !c.Quarantined() {
+		c.QuarantinedAt = msql.NewNullTime(time.Now())
+	}
+	c.QuarantineReason = msql.NewNullString(msql.NilIfEmptyString(strings.TrimSpace(reason)))
+	_, err := db.ExecContext(ctx, "UPDATE communities SET quarantined_at = ?, quarantine_reason = ? WHERE id = ?", c.QuarantinedAt, c.QuarantineReason, c.ID)
+	if err == nil {
+		invalidateSiteFeeds(ctx)
+	}
+	return err
+}
+
+// Unquarantine lifts the quarantine of c. The acknowledgements of the
+// quarantine are forgotten, so that users have to acknowledge a later
+// quarantine anew.
+func (c *Community) Unquarantine(ctx context.Context, db *sql.DB) error {
+	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "UPDATE communities SET quarantined_at = NULL, quarantine_reason = NULL WHERE id = ?", c.ID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "DELETE FROM quarantine_acknowledgements WHERE community_id = ?", c.ID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	c.QuarantinedAt = msql.NullTime{}
+	c.QuarantineReason = msql.NullString{}
+	invalidateSiteFeeds(ctx)
+	return nil
+}
+
+// AcknowledgeQuarantine records that user has been warned of the quarantine
+// of c. It does nothing if c is not quarantined.
+func (c *Community) AcknowledgeQuarantine(ctx context.Context, db *sql.DB, user uid.ID) error {
+	if !c.Quarantined() {
+		return nil
+	}
+	_, err := db.ExecContext(ctx, "INSERT IGNORE INTO quarantine_acknowledgements (community_id, user_id) VALUES (?, ?)", c.ID, user)
+	if err == nil {
+		c.ViewerAcknowledgedQuarantine = msql.NewNullBool(true)
+	}
+	return err
+}
+
+// QuarantineAcknowledged reports whether user has acknowledged the
+// quarantine of c. It returns true if c is not quarantined.
+func (c *Community) QuarantineAcknowledged(ctx context.Context, db *sql.DB, user uid.ID) (bool, error) {
+	if !c.Quarantined() {
+		return true, nil
+	}
+	var n int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM quarantine_acknowledgements WHERE community_id = ? AND user_id = ?", c.ID, user).Scan(&n)
+	return n > 0, err
+}
+
+// whereNotQuarantined appends to where (of a query on a posts table) a
+// condition that leaves out the posts of quarantined communities.
+func whereNotQuarantined(where string) string {
+	if !(where == "" || strings.TrimSpace(strings.ToUpper(where)) == "WHERE") {
+		where += "AND "
+	}
+	return where + "community_id NOT IN (SELECT id FROM communities WHERE quarantined_at IS NOT NULL) "
+}
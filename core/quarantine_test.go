@@ -0,0 +1,19 @@
+package core
+
+import "testing"
+
+func TestWhereNotQuarantined(t *testing.T) {
+	const cond = "community_id NOT IN (SELECT id FROM communities WHERE quarantined_at IS NOT NULL) "
+	tests := []struct {
+		where, want string
+	}{
+		{"", cond},
+		{"WHERE ", "WHERE " + cond},
+		{"WHERE posts.deleted = FALSE ", "WHERE posts.deleted = FALSE AND " + cond},
+	}
+	for _, test := range tests {
+		if got := whereNotQuarantined(test.where); got != test.want {
+			t.Errorf("whereNotQuarantined(%q): expected %q, got %q", test.where, test.want, got)
+		}
+	}
+}
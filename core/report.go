@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"log"
 	"net/http"
 	"time"
 
@@ -130,7 +131,27 @@ func NewReport(ctx context.Context, db *sql.DB, community uid.ID, post uid.NullI
 	if err != nil {
 		return nil, err
 	}
-	return GetReport(ctx, db, int(id))
+	r, err := GetReport(ctx, db, int(id))
+	if err != nil {
+		return nil, err
+	}
+	if err := linkBotActionReport(ctx, db, r.ID, target); err != nil {
+		log.Printf("Error linking report %d to a bot action: %v\n", r.ID, err)
+	}
+
+	e := &ActivityEvent{
+		Type:        ActivityReport,
+		At:          r.CreatedAt,
+		UserID:      uid.NullID{ID: createdBy, Valid: true},
+		CommunityID: community,
+		PostID:      post,
+		Detail:      r.Reason,
+	}
+	if t == ReportTypeComment {
+		e.CommentID = uid.NullID{ID: target, Valid: true}
+	}
+	publishActivity(ctx, db, e)
+	return r, nil
 }
 
 // NewPostReport creates a report on post.
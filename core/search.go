@@ -0,0 +1,383 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Posts and comments are searched (see Search) with the FULLTEXT indexes of
+// the database, which the database keeps up to date itself, or, if one is
+// set (see SetSearchBackend), with a search engine, which is kept up to date
+// by a job queued whenever a post or a comment is created, edited, or deleted
+// (see queueSearchIndex). Either way, results that the viewer cannot see (of
+// private, quarantined, and age-restricted communities, and of muted users)
+// are left out, as they are from feeds.
+
+var (
+	errSearchQueryEmpty    = httperr.NewBadRequest("invalid_search_query", "Search query is empty.")
+	errSearchQueryTooLong  = httperr.NewBadRequest("invalid_search_query", "Search query is too long.")
+	errInvalidSearchKind   = httperr.NewBadRequest("invalid_search_kind", "Invalid search kind (must be posts or comments).")
+	errInvalidSearchSort   = httperr.NewBadRequest("invalid_search_sort", "Invalid search sort (must be relevance or new).")
+	errInvalidSearchCursor = httperr.NewBadRequest("invalid_cursor", "Invalid search pagination cursor.")
+)
+
+// maxSearchQueryLength is the longest a search query can be, in runes.
+const maxSearchQueryLength = 256
+
+// searchRecencyHalfLife is the age at which the relevance of a result is
+// halved, when results are ranked by relevance.
+const searchRecencyHalfLife = 90 * 24 * time.Hour
+
+// SearchKind is the kind of items searched.
+type SearchKind string
+
+// The kinds of items searched.
+const (
+	SearchKindPosts    = SearchKind("posts")
+	SearchKindComments = SearchKind("comments")
+)
+
+// Valid reports whether k is a valid search kind.
+func (k SearchKind) Valid() bool {
+	return k == SearchKindPosts || k == SearchKindComments
+}
+
+// SearchSort is the order of search results.
+type SearchSort string
+
+// The orders of search results.
+const (
+	SearchSortRelevance = SearchSort("relevance") // By relevance, weighed by recency.
+	SearchSortNew       = SearchSort("new")       // Newest first.
+)
+
+// Valid reports whether s is a valid search sort.
+func (s SearchSort) Valid() bool {
+	return s == SearchSortRelevance || s == SearchSortNew
+}
+
+// SearchQuery is a query of a search backend (see SearchBackend).
+type SearchQuery struct {
+	Text      string
+	Kind      SearchKind
+	Sort      SearchSort
+	Community *uid.ID // If not nil, only items of the community match.
+	Author    *uid.ID // If not nil, only items of the user match.
+	Offset    int     // The number of matches to skip.
+	Limit     int     // The most matches to return.
+}
+
+// SearchDocument is a post or a comment, as it's indexed by a search backend.
+// The title of comments is empty.
+type SearchDocument struct {
+	Kind        SearchKind
+	ID          uid.ID
+	CommunityID uid.ID
+	AuthorID    uid.ID
+	Title       string
+	Body        string
+	CreatedAt   time.Time
+}
+
+// A SearchBackend is a search engine that posts and comments are indexed in.
+type SearchBackend interface {
+	// Search returns the IDs of the items matching q, in the order of q.Sort.
+	// The items are checked against the database before they're shown, so
+	// items that have since been deleted may be returned.
+	Search(ctx context.Context, q *SearchQuery) ([]uid.ID, error)
+
+	// Index adds docs to the index, replacing the documents with the same
+	// kinds and IDs.
+	Index(ctx context.Context, docs ...*SearchDocument) error
+
+	// Remove removes the documents of kind with ids from the index.
+	Remove(ctx context.Context, kind SearchKind, ids ...uid.ID) error
+}
+
+var (
+	searchBackendMu sync.RWMutex // guards searchBackend
+	searchBackend   SearchBackend
+)
+
+// SetSearchBackend sets the search engine posts and comments are searched
+// with. If b is nil, they're searched with the FULLTEXT indexes of the
+// database.
+func SetSearchBackend(b SearchBackend) {
+	searchBackendMu.Lock()
+	defer searchBackendMu.Unlock()
+	searchBackend = b
+}
+
+func getSearchBackend() SearchBackend {
+	searchBackendMu.RLock()
+	defer searchBackendMu.RUnlock()
+	return searchBackend
+}
+
+// SearchOptions are the options of Search.
+type SearchOptions struct {
+	Query     string
+	Kind      SearchKind // Defaults to SearchKindPosts.
+	Sort      SearchSort // Defaults to SearchSortRelevance.
+	Community *uid.ID
+	Author    *uid.ID
+	Viewer    *uid.ID // Nil if not logged in.
+	Limit     int
+	Next      string // The cursor of the page (see SearchResultSet.Next).
+}
+
+// SearchResultSet is a page of search results. Only one of Posts and
+// Comments is set, as per the kind of items searched.
+type SearchResultSet struct {
+	Posts    []*Post    `json:"posts,omitempty"`
+	Comments []*Comment `json:"comments,omitempty"`
+	Next     *string    `json:"next"` // Nil if there are no more results.
+}
+
+// Search searches posts or comments, as per opts.Kind. A page has fewer
+// results than opts.Limit if some of the matches were left out (because the
+// viewer cannot see them), even if there are more pages.
+func Search(ctx context.Context, db *sql.DB, opts *SearchOptions) (*SearchResultSet, error) {
+	q := &SearchQuery{
+		Text:      strings.TrimSpace(opts.Query),
+		Kind:      opts.Kind,
+		Sort:      opts.Sort,
+		Community: opts.Community,
+		Author:    opts.Author,
+		Limit:     opts.Limit + 1,
+	}
+	if q.Text == "" {
+		return nil, errSearchQueryEmpty
+	}
+	if utf8.RuneCountInString(q.Text) > maxSearchQueryLength {
+		return nil, errSearchQueryTooLong
+	}
+	if q.Kind == "" {
+		q.Kind = SearchKindPosts
+	}
+	if !q.Kind.Valid() {
+		return nil, errInvalidSearchKind
+	}
+	if q.Sort == "" {
+		q.Sort = SearchSortRelevance
+	}
+	if !q.Sort.Valid() {
+		return nil, errInvalidSearchSort
+	}
+	if opts.Next != "" {
+		var err error
+		if q.Offset, err = strconv.Atoi(opts.Next); err != nil || q.Offset < 0 {
+			return nil, errInvalidSearchCursor
+		}
+	}
+
+	vis, err := newSearchVisibility(ctx, db, opts.Community, opts.Viewer)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uid.ID
+	if b := getSearchBackend(); b != nil {
+		if ids, err = b.Search(ctx, q); err != nil {
+			return nil, err
+		}
+	} else {
+		if ids, err = searchDatabase(ctx, db, q, vis); err != nil {
+			return nil, err
+		}
+	}
+
+	set := &SearchResultSet{}
+	if len(ids) > opts.Limit {
+		ids = ids[:opts.Limit]
+		next := strconv.Itoa(q.Offset + opts.Limit)
+		set.Next = &next
+	}
+	if ids, err = vis.filter(ctx, db, q.Kind, ids); err != nil {
+		return nil, err
+	}
+
+	switch q.Kind {
+	case SearchKindPosts:
+		set.Posts = []*Post{}
+		posts, err := GetPostsByIDs(ctx, db, opts.Viewer, false, ids...)
+		if err != nil && err != errPostNotFound {
+			return nil, err
+		}
+		byID := make(map[uid.ID]*Post, len(posts))
+		for _, post := range posts {
+			byID[post.ID] = post
+		}
+		for _, id := range ids {
+			if post, ok := byID[id]; ok {
+				set.Posts = append(set.Posts, post)
+			}
+		}
+	case SearchKindComments:
+		set.Comments = []*Comment{}
+		comments, err := GetCommentsByIDs(ctx, db, opts.Viewer, ids...)
+		if err != nil && err != errCommentNotFound {
+			return nil, err
+		}
+		byID := make(map[uid.ID]*Comment, len(comments))
+		for _, comment := range comments {
+			byID[comment.ID] = comment
+		}
+		for _, id := range ids {
+			if comment, ok := byID[id]; ok && !comment.Deleted {
+				set.Comments = append(set.Comments, comment)
+			}
+		}
+		if len(set.Comments) > 0 {
+			if err := getCommentsPostTitles(ctx, db, set.Comments, opts.Viewer); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return set, nil
+}
+
+// searchVisibility is what a viewer can see of the results of a search.
+type searchVisibility struct {
+	viewer            *uid.ID
+	community         *uid.ID
+	hideAgeRestricted bool
+}
+
+// newSearchVisibility returns the visibility of the results of a search of
+// community (of all communities if it's nil) for viewer. It returns an error
+// if viewer cannot view community.
+func newSearchVisibility(ctx context.Context, db *sql.DB, community, viewer *uid.ID) (*searchVisibility, error) {
+	vis := &searchVisibility{viewer: viewer, community: community}
+	if community != nil {
+		var private, restricted bool
+		if err := db.QueryRowContext(ctx, "SELECT private, age_restricted FROM communities WHERE id = ?", *community).Scan(&private, &restricted); err != nil {
+			if err == sql.ErrNoRows {
+				return nil, errCommunityNotFound
+			}
+			return nil, err
+		}
+		if err := CheckCommunityAccess(ctx, db, *community, private, viewer); err != nil {
+			return nil, err
+		}
+		if err := CheckAgeRestriction(ctx, db, restricted, viewer); err != nil {
+			return nil, err
+		}
+		return vis, nil
+	}
+	confirmed, err := viewerAgeConfirmed(ctx, db, viewer)
+	if err != nil {
+		return nil, err
+	}
+	vis.hideAgeRestricted = !confirmed
+	return vis, nil
+}
+
+// where appends to where (of a query on the posts or the comments table, as
+// per kind) the conditions that leave out the items the viewer cannot see.
+func (vis *searchVisibility) where(kind SearchKind, where string, args []any) (string, []any) {
+	if !(where == "" || strings.TrimSpace(strings.ToUpper(where)) == "WHERE") {
+		where += "AND "
+	}
+	table := string(kind)
+	if kind == SearchKindPosts {
+		where += "posts.deleted = FALSE "
+	} else {
+		where += "comments.deleted_at IS NULL AND comments.post_id NOT IN (SELECT id FROM posts WHERE deleted = TRUE) "
+	}
+	if vis.community == nil {
+		where = whereNotQuarantined(where)
+		where, args = whereNotPrivate(where, args, vis.viewer)
+		if vis.hideAgeRestricted {
+			where = whereNotAgeRestricted(where)
+		}
+	}
+	if vis.viewer != nil {
+		where, args = whereMutedAndHidden(where, table, args, *vis.viewer, vis.community == nil)
+	}
+	return where, args
+}
+
+// filter returns those of the items of kind with ids that the viewer can see,
+// in the same order.
+func (vis *searchVisibility) filter(ctx context.Context, db *sql.DB, kind SearchKind, ids []uid.ID) ([]uid.ID, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	table := string(kind)
+	where := fmt.Sprintf("WHERE %s.id IN %s ", table, msql.InClauseQuestionMarks(len(ids)))
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	where, args = vis.where(kind, where, args)
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT %s.id FROM %s %s", table, table, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	visible, err := scanIDs(rows)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[uid.ID]bool, len(visible))
+	for _, id := range visible {
+		set[id] = true
+	}
+	filtered := ids[:0:0]
+	for _, id := range ids {
+		if set[id] {
+			filtered = append(filtered, id)
+		}
+	}
+	return filtered, nil
+}
+
+// searchDatabase searches the posts or the comments table with its FULLTEXT
+// index (see migration 0092).
+func searchDatabase(ctx context.Context, db *sql.DB, q *SearchQuery, vis *searchVisibility) ([]uid.ID, error) {
+	table := string(q.Kind)
+	match := "MATCH (posts.title, posts.body) AGAINST (? IN NATURAL LANGUAGE MODE)"
+	if q.Kind == SearchKindComments {
+		match = "MATCH (comments.body) AGAINST (? IN NATURAL LANGUAGE MODE)"
+	}
+
+	where := "WHERE " + match + " "
+	args := []any{q.Text}
+	if q.Community != nil {
+		where += fmt.Sprintf("AND %s.community_id = ? ", table)
+		args = append(args, *q.Community)
+	}
+	if q.Author != nil {
+		where += fmt.Sprintf("AND %s.user_id = ? ", table)
+		args = append(args, *q.Author)
+	}
+	where, args = vis.where(q.Kind, where, args)
+
+	switch q.Sort {
+	case SearchSortNew:
+		where += fmt.Sprintf("ORDER BY %s.id DESC ", table)
+	default:
+		// The relevance of a match is halved for every searchRecencyHalfLife
+		// of its age.
+		where += fmt.Sprintf("ORDER BY %s * POW(0.5, TIMESTAMPDIFF(SECOND, %s.created_at, ?) / ?) DESC, %s.id DESC ", match, table, table)
+		args = append(args, q.Text, now(), searchRecencyHalfLife.Seconds())
+	}
+	where += "LIMIT ? OFFSET ?"
+	args = append(args, q.Limit, q.Offset)
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT %s.id FROM %s %s", table, table, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
@@ -0,0 +1,116 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/logging"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// searchJobIndex is the kind of the jobs that bring the document of a post or
+// a comment in the search backend (see SetSearchBackend) up to date.
+const searchJobIndex = "search_index"
+
+type searchIndexPayload struct {
+	Kind SearchKind `json:"kind"`
+	ID   uid.ID     `json:"id"`
+}
+
+// queueSearchIndex queues a job that indexes the post or the comment (as per
+// kind) with id, as it is when the job runs, or that removes it from the
+// index if it's deleted. It's called after posts and comments are created,
+// edited, and deleted. It does nothing if there's no search backend, as the
+// FULLTEXT indexes of the database are up to date already.
+func queueSearchIndex(ctx context.Context, db *sql.DB, kind SearchKind, id uid.ID) {
+	if getSearchBackend() == nil {
+		return
+	}
+	if err := queueJob(db, searchJobIndex, searchIndexPayload{Kind: kind, ID: id}, time.Now()); err != nil {
+		logging.FromContext(ctx).Error("Error queueing a search index job", "kind", kind, "id", id, "error", err)
+	}
+}
+
+func runSearchIndexJob(ctx context.Context, db *sql.DB, payload json.RawMessage) error {
+	b := getSearchBackend()
+	if b == nil {
+		return nil
+	}
+	var p searchIndexPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+	docs, err := getSearchDocuments(ctx, db, p.Kind, "AND id = ?", p.ID)
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return b.Remove(ctx, p.Kind, p.ID)
+	}
+	return b.Index(ctx, docs...)
+}
+
+// getSearchDocuments returns the documents of the posts or the comments (as
+// per kind) that are not deleted, selected by clause (which is appended to the
+// WHERE clause of the query, and begins with AND).
+func getSearchDocuments(ctx context.Context, db *sql.DB, kind SearchKind, clause string, args ...any) ([]*SearchDocument, error) {
+	var query string
+	switch kind {
+	case SearchKindPosts:
+		query = "SELECT id, community_id, user_id, title, body, created_at FROM posts WHERE deleted = FALSE "
+	case SearchKindComments:
+		query = "SELECT id, community_id, user_id, '', body, created_at FROM comments WHERE deleted_at IS NULL AND post_id NOT IN (SELECT id FROM posts WHERE deleted = TRUE) "
+	default:
+		return nil, fmt.Errorf("invalid search kind %q", kind)
+	}
+	rows, err := db.QueryContext(ctx, query+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var docs []*SearchDocument
+	for rows.Next() {
+		doc := &SearchDocument{Kind: kind}
+		var body sql.NullString
+		if err := rows.Scan(&doc.ID, &doc.CommunityID, &doc.AuthorID, &doc.Title, &body, &doc.CreatedAt); err != nil {
+			return nil, err
+		}
+		doc.Body = body.String
+		docs = append(docs, doc)
+	}
+	return docs, rows.Err()
+}
+
+// ReindexSearch adds all the posts and comments that are not deleted to the
+// search backend (see SetSearchBackend), batchSize at a time, and returns the
+// number of documents indexed. It's for filling a new search backend, or one
+// that's fallen out of date.
+func ReindexSearch(ctx context.Context, db *sql.DB, batchSize int) (int, error) {
+	b := getSearchBackend()
+	if b == nil {
+		return 0, nil
+	}
+	n := 0
+	for _, kind := range []SearchKind{SearchKindPosts, SearchKindComments} {
+		var last uid.ID
+		for {
+			docs, err := getSearchDocuments(ctx, db, kind, "AND id > ? ORDER BY id LIMIT ?", last, batchSize)
+			if err != nil {
+				return n, err
+			}
+			if len(docs) == 0 {
+				break
+			}
+			if err := b.Index(ctx, docs...); err != nil {
+				return n, err
+			}
+			n += len(docs)
+			last = docs[len(docs)-1].ID
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/discuitnet/discuit/internal/meilisearch"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// meilisearchBackend is a SearchBackend of a Meilisearch server, with an
+// index for posts and one for comments.
+type meilisearchBackend struct {
+	client *meilisearch.Client
+	prefix string // Of the names of the indexes.
+}
+
+// meilisearchDocument is a SearchDocument as it's indexed by Meilisearch.
+type meilisearchDocument struct {
+	ID          string `json:"id"`
+	CommunityID string `json:"communityId"`
+	AuthorID    string `json:"authorId"`
+	Title       string `json:"title,omitempty"`
+	Body        string `json:"body"`
+	CreatedAt   int64  `json:"createdAt"` // Unix time.
+}
+
+// NewMeilisearchBackend returns a search backend of the Meilisearch server of
+// client, whose indexes are named with prefix (to share a server between
+// sites). The settings of the indexes are updated (and the indexes created,
+// if they don't exist).
+func NewMeilisearchBackend(ctx context.Context, client *meilisearch.Client, prefix string) (SearchBackend, error) {
+	b := &meilisearchBackend{client: client, prefix: prefix}
+	for _, kind := range []SearchKind{SearchKindPosts, SearchKindComments} {
+		searchable := []string{"title", "body"}
+		if kind == SearchKindComments {
+			searchable = []string{"body"}
+		}
+		err := client.UpdateSettings(ctx, b.index(kind), &meilisearch.Settings{
+			SearchableAttributes: searchable,
+			FilterableAttributes: []string{"communityId", "authorId"},
+			SortableAttributes:   []string{"createdAt"},
+			// Without a sort (see SearchSortNew), matches are ranked by
+			// relevance, and then by recency.
+			RankingRules: []string{"sort", "words", "typo", "proximity", "attribute", "exactness", "createdAt:desc"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("updating the settings of the %s index: %w", kind, err)
+		}
+	}
+	return b, nil
+}
+
+func (b *meilisearchBackend) index(kind SearchKind) string {
+	return b.prefix + string(kind)
+}
+
+func (b *meilisearchBackend) Search(ctx context.Context, q *SearchQuery) ([]uid.ID, error) {
+	req := &meilisearch.SearchRequest{
+		Query:                q.Text,
+		Offset:               q.Offset,
+		Limit:                q.Limit,
+		AttributesToRetrieve: []string{"id"},
+	}
+	var filters []string
+	if q.Community != nil {
+		filters = append(filters, "communityId = "+meilisearch.Quote(q.Community.String()))
+	}
+	if q.Author != nil {
+		filters = append(filters, "authorId = "+meilisearch.Quote(q.Author.String()))
+	}
+	req.Filter = strings.Join(filters, " AND ")
+	if q.Sort == SearchSortNew {
+		req.Sort = []string{"createdAt:desc"}
+	}
+
+	res, err := b.client.Search(ctx, b.index(q.Kind), req)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uid.ID, 0, len(res.Hits))
+	for _, hit := range res.Hits {
+		var doc struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(hit, &doc); err != nil {
+			return nil, err
+		}
+		id, err := uid.FromString(doc.ID)
+		if err != nil {
+			continue // Not one of ours.
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (b *meilisearchBackend) Index(ctx context.Context, docs ...*SearchDocument) error {
+	byKind := make(map[SearchKind][]meilisearchDocument)
+	for _, doc := range docs {
+		byKind[doc.Kind] = append(byKind[doc.Kind], meilisearchDocument{
+			ID:          doc.ID.String(),
+			CommunityID: doc.CommunityID.String(),
+			AuthorID:    doc.AuthorID.String(),
+			Title:       doc.Title,
+			Body:        doc.Body,
+			CreatedAt:   doc.CreatedAt.Unix(),
+		})
+	}
+	for kind, docs := range byKind {
+		if err := b.client.AddDocuments(ctx, b.index(kind), docs, "id"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *meilisearchBackend) Remove(ctx context.Context, kind SearchKind, ids ...uid.ID) error {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = id.String()
+	}
+	return b.client.DeleteDocuments(ctx, b.index(kind), keys...)
+}
@@ -0,0 +1,181 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/meilisearch"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestSearchValidation(t *testing.T) {
+	tests := []struct {
+		opts SearchOptions
+		want error
+	}{
+		{SearchOptions{Query: "  "}, errSearchQueryEmpty},
+		{SearchOptions{Query: string(make([]rune, maxSearchQueryLength+1))}, errSearchQueryTooLong},
+		{SearchOptions{Query: "go", Kind: "users"}, errInvalidSearchKind},
+		{SearchOptions{Query: "go", Sort: "top"}, errInvalidSearchSort},
+		{SearchOptions{Query: "go", Next: "-1"}, errInvalidSearchCursor},
+		{SearchOptions{Query: "go", Next: "x"}, errInvalidSearchCursor},
+	}
+	for _, test := range tests {
+		if _, err := Search(context.Background(), nil, &test.opts); err != test.want {
+			t.Errorf("Search(%+v) returned error %v, want %v", test.opts, err, test.want)
+		}
+	}
+}
+
+func TestMeilisearchBackend(t *testing.T) {
+	var paths, bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		bodies = append(bodies, string(body))
+		if r.URL.Path == "/indexes/site_posts/search" {
+			w.Write([]byte(`{"hits": [{"id": "` + uid.ID{}.String() + `"}, {"id": "not an id"}]}`))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"taskUid": 1}`))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	b, err := NewMeilisearchBackend(ctx, meilisearch.New(srv.URL, "", nil), "site_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	community, author := uid.New(), uid.New()
+	ids, err := b.Search(ctx, &SearchQuery{
+		Text:      "go",
+		Kind:      SearchKindPosts,
+		Sort:      SearchSortNew,
+		Community: &community,
+		Author:    &author,
+		Offset:    20,
+		Limit:     11,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []uid.ID{{}}) {
+		t.Errorf("got ids %v, want the one valid ID of the hits", ids)
+	}
+	var req meilisearch.SearchRequest
+	if err := json.Unmarshal([]byte(bodies[2]), &req); err != nil {
+		t.Fatal(err)
+	}
+	wantFilter := `communityId = "` + community.String() + `" AND authorId = "` + author.String() + `"`
+	if req.Filter != wantFilter || !reflect.DeepEqual(req.Sort, []string{"createdAt:desc"}) || req.Offset != 20 || req.Limit != 11 {
+		t.Errorf("got search request %+v", req)
+	}
+
+	doc := &SearchDocument{Kind: SearchKindComments, ID: uid.New(), Body: "Hello"}
+	if err := b.Index(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Remove(ctx, SearchKindComments, doc.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	wantPaths := []string{
+		"PATCH /indexes/site_posts/settings",
+		"PATCH /indexes/site_comments/settings",
+		"POST /indexes/site_posts/search",
+		"POST /indexes/site_comments/documents",
+		"POST /indexes/site_comments/documents/delete-batch",
+	}
+	if !reflect.DeepEqual(paths, wantPaths) {
+		t.Errorf("got requests %v, want %v", paths, wantPaths)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+
+	alice := h.newUser(db, "searchalice", false)
+	bob := h.newUser(db, "searchbob", false)
+	first := h.newPost(db, alice, "searchcomm")
+	newPost := func(author *User, title string) *Post {
+		post, err := createPost(h.ctx, db, &createPostOpts{
+			postType:  PostTypeText,
+			author:    author.ID,
+			community: first.CommunityID,
+			title:     title,
+			body:      "Nothing to see here.",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return post
+	}
+	older := newPost(alice, "Zeppelin sightings")
+	h.clock.Advance(24 * time.Hour)
+	newer := newPost(bob, "Zeppelin zeppelin zeppelin")
+	deleted := newPost(bob, "A deleted zeppelin")
+	if err := deleted.Delete(h.ctx, db, bob.ID, UserGroupNormal, false, false); err != nil {
+		t.Fatal(err)
+	}
+	comment, err := addComment(h.ctx, db, older, alice, nil, "I saw a zeppelin too.", UserGroupNormal, false, h.clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	postIDs := func(set *SearchResultSet) []uid.ID {
+		var ids []uid.ID
+		for _, post := range set.Posts {
+			ids = append(ids, post.ID)
+		}
+		return ids
+	}
+	search := func(opts SearchOptions) *SearchResultSet {
+		if opts.Limit == 0 {
+			opts.Limit = 10
+		}
+		set, err := Search(h.ctx, db, &opts)
+		if err != nil {
+			t.Fatalf("Search(%+v): %v", opts, err)
+		}
+		return set
+	}
+
+	set := search(SearchOptions{Query: "zeppelin"})
+	if got, want := postIDs(set), []uid.ID{newer.ID, older.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("by relevance got posts %v, want %v", got, want)
+	}
+	if set.Next != nil {
+		t.Errorf("got next cursor %s, want none", *set.Next)
+	}
+
+	set = search(SearchOptions{Query: "zeppelin", Author: &alice.ID})
+	if got, want := postIDs(set), []uid.ID{older.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("by author got posts %v, want %v", got, want)
+	}
+
+	set = search(SearchOptions{Query: "zeppelin", Sort: SearchSortNew, Community: &first.CommunityID, Limit: 1})
+	if got, want := postIDs(set), []uid.ID{newer.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("first page got posts %v, want %v", got, want)
+	}
+	if set.Next == nil {
+		t.Fatal("got no next cursor, want one")
+	}
+	set = search(SearchOptions{Query: "zeppelin", Sort: SearchSortNew, Community: &first.CommunityID, Limit: 1, Next: *set.Next})
+	if got, want := postIDs(set), []uid.ID{older.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("second page got posts %v, want %v", got, want)
+	}
+
+	set = search(SearchOptions{Query: "zeppelin", Kind: SearchKindComments, Viewer: &bob.ID})
+	if len(set.Comments) != 1 || set.Comments[0].ID != comment.ID {
+		t.Errorf("got comments %+v, want the one comment", set.Comments)
+	}
+}
@@ -16,6 +16,14 @@ var cache = &ssCache{}
 type SiteSettings struct {
 	SignupsDisabled bool `json:"signupsDisabled"`
 
+	// If true, only the people on the rosters of cohorts can sign up, with
+	// their invite codes (see core.ClaimCohortInvite).
+	SignupsRosterOnly bool `json:"signupsRosterOnly"`
+
+	// If true, users cannot post, comment, or vote until they consent to the
+	// latest consent form (see core.CheckConsent).
+	ConsentRequired bool `json:"consentRequired"`
+
 	// note: ssCache.store() and ssCache.get() uses shallow-copy on this struct.
 	// So those lines of code need updating if pointer fields are added to this
 	// struct.
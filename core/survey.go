@@ -0,0 +1,739 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Surveys are prompts, delivered as notifications, to take a survey: either
+// one hosted elsewhere (an external link), or a simple form built into the
+// site. A survey targets the people on the roster of a cohort (optionally
+// only those of a section or with a role), or the users assigned to an arm of
+// an experiment (or to any arm).
+//
+// A survey is delivered when it opens (see runSurveyDeliveryJob) to the users
+// it targets at the time, who are then reminded at intervals until they
+// complete the survey, or it closes (see runSurveyReminderJob). Both are run
+// as delayed jobs (see SetJobQueue).
+//
+// Each recipient has a code, which replaces the {code} placeholder in the
+// link of an external survey, so that responses collected elsewhere can be
+// matched with recipients. Recipients mark external surveys as completed
+// themselves.
+
+// Kinds of surveys.
+const (
+	SurveyKindExternal = "external"
+	SurveyKindForm     = "form"
+)
+
+// Types of the questions of built-in forms.
+const (
+	SurveyQuestionText   = "text"
+	SurveyQuestionChoice = "choice"
+	SurveyQuestionScale  = "scale" // 1 to 5.
+)
+
+// Kinds of survey jobs.
+const (
+	surveyJobDeliver = "survey_deliver"
+	surveyJobRemind  = "survey_remind"
+)
+
+const (
+	maxSurveyTitleLength  = 255
+	maxSurveyQuestions    = 50
+	maxSurveyAnswerLength = 5000
+	maxSurveyReminders    = 10
+	surveyCodePlaceholder = "{code}"
+)
+
+var (
+	errSurveyNotFound = httperr.NewNotFound("survey_not_found", "Survey not found.")
+	errSurveyClosed   = httperr.NewForbidden("survey_closed", "The survey is closed.")
+	errSurveyComplete = httperr.NewBadRequest("survey_completed", "You've already completed the survey.")
+)
+
+// SurveyQuestion is a question of a built-in form.
+type SurveyQuestion struct {
+	ID       string   `json:"id"`
+	Text     string   `json:"text"`
+	Type     string   `json:"type"`
+	Options  []string `json:"options,omitempty"` // Of choice questions.
+	Required bool     `json:"required"`
+}
+
+type Survey struct {
+	ID          uid.ID            `json:"id"`
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	Kind        string            `json:"kind"`
+	URL         string            `json:"url,omitempty"`       // Of external surveys.
+	Questions   []*SurveyQuestion `json:"questions,omitempty"` // Of built-in forms.
+
+	// Who the survey targets.
+	CohortID     uid.NullID `json:"cohortId"`
+	SectionID    uid.NullID `json:"sectionId"`
+	Role         string     `json:"role,omitempty"`
+	ExperimentID uid.NullID `json:"experimentId"`
+	ArmID        uid.NullID `json:"armId"`
+
+	OpensAt          time.Time     `json:"opensAt"`
+	ClosesAt         msql.NullTime `json:"closesAt"`
+	ReminderInterval int           `json:"reminderInterval"` // In hours.
+	MaxReminders     int           `json:"maxReminders"`
+	DeliveredAt      msql.NullTime `json:"deliveredAt"`
+	CreatedBy        uid.NullID    `json:"createdBy"`
+	CreatedAt        time.Time     `json:"createdAt"`
+}
+
+// Closed reports whether s is closed at t.
+func (s *Survey) Closed(t time.Time) bool {
+	return s.ClosesAt.Valid && !t.Before(s.ClosesAt.Time)
+}
+
+func invalidSurvey(code, format string, args ...any) error {
+	return httperr.NewBadRequest(code, fmt.Sprintf(format, args...))
+}
+
+// validate checks the fields of s that are set by the creator of s.
+func (s *Survey) validate(ctx context.Context, db *sql.DB) error {
+	s.Title, s.Description = strings.TrimSpace(s.Title), strings.TrimSpace(s.Description)
+	if s.Title == "" || len(s.Title) > maxSurveyTitleLength {
+		return invalidSurvey("invalid_title", "Title must be between 1 and %d characters long.", maxSurveyTitleLength)
+	}
+
+	switch s.Kind {
+	case SurveyKindExternal:
+		s.Questions = nil
+		u, err := url.Parse(strings.ReplaceAll(s.URL, surveyCodePlaceholder, "code"))
+		if err != nil || !(u.Scheme == "http" || u.Scheme == "https") || u.Host == "" {
+			return invalidSurvey("invalid_url", "The survey link must be an http or https URL.")
+		}
+	case SurveyKindForm:
+		s.URL = ""
+		if err := validateSurveyQuestions(s.Questions); err != nil {
+			return err
+		}
+	default:
+		return invalidSurvey("invalid_kind", "Survey kind must be %s or %s.", SurveyKindExternal, SurveyKindForm)
+	}
+
+	if s.CohortID.Valid == s.ExperimentID.Valid {
+		return invalidSurvey("invalid_target", "A survey must target either a cohort or an experiment.")
+	}
+	if s.CohortID.Valid {
+		ch, err := GetCohort(ctx, db, s.CohortID.ID)
+		if err != nil {
+			return err
+		}
+		if s.SectionID.Valid && ch.sectionByID(s.SectionID.ID) == nil {
+			return invalidSurvey("invalid_section", "The section is not of the cohort.")
+		}
+		if s.Role != "" && !slices.Contains([]string{CohortRoleStudent, CohortRoleStaff, CohortRoleResearcher}, s.Role) {
+			return invalidSurvey("invalid_role", "Invalid role: %s.", s.Role)
+		}
+	} else {
+		if s.SectionID.Valid || s.Role != "" {
+			return invalidSurvey("invalid_target", "Only surveys of cohorts can target sections or roles.")
+		}
+	}
+	if s.ExperimentID.Valid {
+		e, err := GetExperiment(ctx, db, s.ExperimentID.ID)
+		if err != nil {
+			return err
+		}
+		if s.ArmID.Valid && e.Arm(s.ArmID.ID) == nil {
+			return invalidSurvey("invalid_arm", "The arm is not of the experiment.")
+		}
+	} else if s.ArmID.Valid {
+		return invalidSurvey("invalid_target", "Only surveys of experiments can target arms.")
+	}
+
+	if s.OpensAt.IsZero() {
+		s.OpensAt = time.Now()
+	}
+	if s.ClosesAt.Valid && !s.ClosesAt.Time.After(s.OpensAt) {
+		return invalidSurvey("invalid_closes_at", "A survey must close after it opens.")
+	}
+	if s.MaxReminders < 0 || s.MaxReminders > maxSurveyReminders {
+		return invalidSurvey("invalid_max_reminders", "Max reminders must be between 0 and %d.", maxSurveyReminders)
+	}
+	if s.ReminderInterval < 0 || (s.MaxReminders > 0 && s.ReminderInterval == 0) {
+		return invalidSurvey("invalid_reminder_interval", "Reminder interval must be a positive number of hours.")
+	}
+	return nil
+}
+
+func validateSurveyQuestions(questions []*SurveyQuestion) error {
+	if len(questions) == 0 || len(questions) > maxSurveyQuestions {
+		return invalidSurvey("invalid_questions", "A form must have between 1 and %d questions.", maxSurveyQuestions)
+	}
+	seen := make(map[string]bool)
+	for i, q := range questions {
+		q.ID, q.Text = strings.TrimSpace(q.ID), strings.TrimSpace(q.Text)
+		if q.ID == "" {
+			q.ID = "q" + strconv.Itoa(i+1)
+		}
+		if seen[q.ID] {
+			return invalidSurvey("invalid_questions", "There's more than one question with the id %s.", q.ID)
+		}
+		seen[q.ID] = true
+		if q.Text == "" {
+			return invalidSurvey("invalid_questions", "Question %s has no text.", q.ID)
+		}
+		switch q.Type {
+		case SurveyQuestionText, SurveyQuestionScale:
+			q.Options = nil
+		case SurveyQuestionChoice:
+			if len(q.Options) < 2 {
+				return invalidSurvey("invalid_questions", "Question %s must have at least 2 options.", q.ID)
+			}
+		default:
+			return invalidSurvey("invalid_questions", "Question %s has an invalid type: %s.", q.ID, q.Type)
+		}
+	}
+	return nil
+}
+
+// CreateSurvey creates s (of which the ID and the fields set by the site are
+// ignored) on behalf of admin, and schedules it to be delivered when it
+// opens.
+func CreateSurvey(ctx context.Context, db *sql.DB, admin uid.ID, s *Survey) (*Survey, error) {
+	if is, err := IsAdmin(db, &admin); err != nil {
+		return nil, err
+	} else if !is {
+		return nil, errNotAdmin
+	}
+	if err := s.validate(ctx, db); err != nil {
+		return nil, err
+	}
+
+	var questions []byte
+	if s.Questions != nil {
+		var err error
+		if questions, err = json.Marshal(s.Questions); err != nil {
+			return nil, err
+		}
+	}
+
+	id := uid.New()
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO surveys (id, title, description, kind, url, questions, cohort_id, section_id, role, experiment_id, arm_id,
+			opens_at, closes_at, reminder_interval, max_reminders, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, s.Title, s.Description, s.Kind, sql.NullString{String: s.URL, Valid: s.URL != ""}, questions,
+		s.CohortID, s.SectionID, sql.NullString{String: s.Role, Valid: s.Role != ""}, s.ExperimentID, s.ArmID,
+		s.OpensAt, s.ClosesAt, s.ReminderInterval, s.MaxReminders, admin)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := queueJob(db, surveyJobDeliver, &surveyJob{SurveyID: id}, s.OpensAt); err != nil {
+		if _, err := db.ExecContext(ctx, "DELETE FROM surveys WHERE id = ?", id); err != nil {
+			log.Printf("Error deleting undeliverable survey %v: %v\n", id, err)
+		}
+		return nil, err
+	}
+	return GetSurvey(ctx, db, id)
+}
+
+var selectSurveyCols = []string{
+	"surveys.id",
+	"surveys.title",
+	"surveys.description",
+	"surveys.kind",
+	"surveys.url",
+	"surveys.questions",
+	"surveys.cohort_id",
+	"surveys.section_id",
+	"surveys.role",
+	"surveys.experiment_id",
+	"surveys.arm_id",
+	"surveys.opens_at",
+	"surveys.closes_at",
+	"surveys.reminder_interval",
+	"surveys.max_reminders",
+	"surveys.delivered_at",
+	"surveys.created_by",
+	"surveys.created_at",
+}
+
+// scanSurvey scans the columns of selectSurveyCols (followed by dest) of row.
+func scanSurvey(row interface{ Scan(...any) error }, dest ...any) (*Survey, error) {
+	s := &Survey{}
+	var surveyURL, role sql.NullString
+	var questions []byte
+	err := row.Scan(append([]any{
+		&s.ID,
+		&s.Title,
+		&s.Description,
+		&s.Kind,
+		&surveyURL,
+		&questions,
+		&s.CohortID,
+		&s.SectionID,
+		&role,
+		&s.ExperimentID,
+		&s.ArmID,
+		&s.OpensAt,
+		&s.ClosesAt,
+		&s.ReminderInterval,
+		&s.MaxReminders,
+		&s.DeliveredAt,
+		&s.CreatedBy,
+		&s.CreatedAt,
+	}, dest...)...)
+	if err != nil {
+		return nil, err
+	}
+	s.URL, s.Role = surveyURL.String, role.String
+	if questions != nil {
+		if err := json.Unmarshal(questions, &s.Questions); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func getSurveys(ctx context.Context, db *sql.DB, where string, args ...any) ([]*Survey, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+strings.Join(selectSurveyCols, ", ")+" FROM surveys "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	surveys := []*Survey{}
+	for rows.Next() {
+		s, err := scanSurvey(rows)
+		if err != nil {
+			return nil, err
+		}
+		surveys = append(surveys, s)
+	}
+	return surveys, rows.Err()
+}
+
+// GetSurveys returns all surveys, the latest first.
+func GetSurveys(ctx context.Context, db *sql.DB) ([]*Survey, error) {
+	return getSurveys(ctx, db, "ORDER BY created_at DESC")
+}
+
+func GetSurvey(ctx context.Context, db *sql.DB, id uid.ID) (*Survey, error) {
+	surveys, err := getSurveys(ctx, db, "WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(surveys) == 0 {
+		return nil, errSurveyNotFound
+	}
+	return surveys[0], nil
+}
+
+// targets returns the users s targets, who are not deleted.
+func (s *Survey) targets(ctx context.Context, db *sql.DB) ([]uid.ID, error) {
+	var query string
+	var args []any
+	if s.CohortID.Valid {
+		query = "SELECT user_id FROM cohort_members WHERE cohort_id = ?"
+		args = append(args, s.CohortID.ID)
+		if s.SectionID.Valid {
+			query += " AND section_id = ?"
+			args = append(args, s.SectionID.ID)
+		}
+		if s.Role != "" {
+			query += " AND role = ?"
+			args = append(args, s.Role)
+		} else {
+			// Researchers are not participants.
+			query += " AND role <> ?"
+			args = append(args, CohortRoleResearcher)
+		}
+	} else {
+		e, err := GetExperiment(ctx, db, s.ExperimentID.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := e.assignAll(ctx, db); err != nil {
+			return nil, err
+		}
+		query = "SELECT user_id FROM experiment_assignments WHERE experiment_id = ?"
+		args = append(args, e.ID)
+		if s.ArmID.Valid {
+			query += " AND arm_id = ?"
+			args = append(args, s.ArmID.ID)
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT targets.user_id FROM ("+query+") AS targets INNER JOIN users ON users.id = targets.user_id WHERE users.deleted_at IS NULL", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []uid.ID
+	for rows.Next() {
+		var id uid.ID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		users = append(users, id)
+	}
+	return users, rows.Err()
+}
+
+type surveyJob struct {
+	SurveyID uid.ID `json:"surveyId"`
+	Reminder int    `json:"reminder,omitempty"` // The nth reminder.
+}
+
+// runSurveyDeliveryJob delivers the survey of the job with payload to the
+// users it targets, and schedules the first reminder. Since jobs may be run
+// more than once, it skips the users it has already notified.
+func runSurveyDeliveryJob(ctx context.Context, db *sql.DB, payload json.RawMessage) error {
+	job := &surveyJob{}
+	if err := json.Unmarshal(payload, job); err != nil {
+		return err
+	}
+	s, err := GetSurvey(ctx, db, job.SurveyID)
+	if err != nil {
+		if httperr.IsNotFound(err) {
+			return nil // Deleted.
+		}
+		return err
+	}
+	if s.Closed(time.Now()) {
+		return nil
+	}
+
+	targets, err := s.targets(ctx, db)
+	if err != nil {
+		return err
+	}
+	for _, user := range targets {
+		code, err := newInviteCode()
+		if err != nil {
+			return err
+		}
+		if _, err := db.ExecContext(ctx, "INSERT IGNORE INTO survey_recipients (survey_id, user_id, code) VALUES (?, ?, ?)", s.ID, user, code); err != nil {
+			return err
+		}
+	}
+
+	if err := s.notifyRecipients(ctx, db, "notified_at IS NULL", 0); err != nil {
+		return err
+	}
+
+	res, err := db.ExecContext(ctx, "UPDATE surveys SET delivered_at = ? WHERE id = ? AND delivered_at IS NULL", time.Now(), s.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n > 0 {
+		return s.scheduleReminder(db, 1)
+	}
+	return nil
+}
+
+// runSurveyReminderJob reminds the recipients of the survey of the job with
+// payload who have not completed it, and schedules the next reminder.
+func runSurveyReminderJob(ctx context.Context, db *sql.DB, payload json.RawMessage) error {
+	job := &surveyJob{}
+	if err := json.Unmarshal(payload, job); err != nil {
+		return err
+	}
+	s, err := GetSurvey(ctx, db, job.SurveyID)
+	if err != nil {
+		if httperr.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if s.Closed(time.Now()) {
+		return nil
+	}
+
+	where := fmt.Sprintf("notified_at IS NOT NULL AND completed_at IS NULL AND reminders < %d", job.Reminder)
+	if err := s.notifyRecipients(ctx, db, where, job.Reminder); err != nil {
+		return err
+	}
+	return s.scheduleReminder(db, job.Reminder+1)
+}
+
+// scheduleReminder schedules the nth reminder of s, unless s has no more
+// reminders, or would be closed by then.
+func (s *Survey) scheduleReminder(db *sql.DB, n int) error {
+	if n > s.MaxReminders {
+		return nil
+	}
+	at := time.Now().Add(time.Duration(s.ReminderInterval) * time.Hour)
+	if s.Closed(at) {
+		return nil
+	}
+	return queueJob(db, surveyJobRemind, &surveyJob{SurveyID: s.ID, Reminder: n}, at)
+}
+
+// notifyRecipients sends a notification of s to the recipients of s that
+// match where, and records it. If reminder is not 0, the notification is the
+// nth reminder.
+func (s *Survey) notifyRecipients(ctx context.Context, db *sql.DB, where string, reminder int) error {
+	rows, err := db.QueryContext(ctx, "SELECT user_id FROM survey_recipients WHERE survey_id = ? AND "+where, s.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var users []uid.ID
+	for rows.Next() {
+		var id uid.ID
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		users = append(users, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		if err := CreateNotification(ctx, db, user, NotificationTypeSurvey, &NotificationSurvey{
+			SurveyID: s.ID,
+			Title:    s.Title,
+			Reminder: reminder > 0,
+		}); err != nil {
+			return err
+		}
+		query := "UPDATE survey_recipients SET notified_at = ? WHERE survey_id = ? AND user_id = ?"
+		args := []any{time.Now(), s.ID, user}
+		if reminder > 0 {
+			query = "UPDATE survey_recipients SET reminders = ?, reminded_at = ? WHERE survey_id = ? AND user_id = ?"
+			args = append([]any{reminder}, args...)
+		}
+		if _, err := db.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SurveyPrompt is a survey delivered to a user.
+type SurveyPrompt struct {
+	*Survey
+	Link        string            `json:"link,omitempty"` // Of external surveys, with the code of the user.
+	CompletedAt msql.NullTime     `json:"completedAt"`
+	Answers     map[string]string `json:"answers,omitempty"`
+}
+
+func getSurveyPrompts(ctx context.Context, db *sql.DB, where string, args ...any) ([]*SurveyPrompt, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+strings.Join(selectSurveyCols, ", ")+`, survey_recipients.code, survey_recipients.completed_at, survey_recipients.answers
+		FROM survey_recipients
+		INNER JOIN surveys ON surveys.id = survey_recipients.survey_id
+		`+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prompts := []*SurveyPrompt{}
+	for rows.Next() {
+		p := &SurveyPrompt{}
+		var code string
+		var answers []byte
+		if p.Survey, err = scanSurvey(rows, &code, &p.CompletedAt, &answers); err != nil {
+			return nil, err
+		}
+		if p.Kind == SurveyKindExternal {
+			p.Link = strings.ReplaceAll(p.URL, surveyCodePlaceholder, url.QueryEscape(code))
+		}
+		if answers != nil {
+			if err := json.Unmarshal(answers, &p.Answers); err != nil {
+				return nil, err
+			}
+		}
+		prompts = append(prompts, p)
+	}
+	return prompts, rows.Err()
+}
+
+// GetPendingSurveys returns the open surveys delivered to user that user has
+// not completed, the latest first.
+func GetPendingSurveys(ctx context.Context, db *sql.DB, user uid.ID) ([]*SurveyPrompt, error) {
+	return getSurveyPrompts(ctx, db, `
+		WHERE survey_recipients.user_id = ? AND survey_recipients.notified_at IS NOT NULL AND survey_recipients.completed_at IS NULL
+		AND (surveys.closes_at IS NULL OR surveys.closes_at > ?)
+		ORDER BY surveys.opens_at DESC`, user, time.Now())
+}
+
+// GetSurveyPrompt returns the survey with id as delivered to user.
+func GetSurveyPrompt(ctx context.Context, db *sql.DB, id, user uid.ID) (*SurveyPrompt, error) {
+	prompts, err := getSurveyPrompts(ctx, db, "WHERE survey_recipients.survey_id = ? AND survey_recipients.user_id = ? AND survey_recipients.notified_at IS NOT NULL", id, user)
+	if err != nil {
+		return nil, err
+	}
+	if len(prompts) == 0 {
+		return nil, errSurveyNotFound
+	}
+	return prompts[0], nil
+}
+
+// CompleteSurvey marks the survey with id as completed by user, with answers
+// (keyed by question id) if it's a built-in form.
+func CompleteSurvey(ctx context.Context, db *sql.DB, id, user uid.ID, answers map[string]string) (*SurveyPrompt, error) {
+	p, err := GetSurveyPrompt(ctx, db, id, user)
+	if err != nil {
+		return nil, err
+	}
+	if p.CompletedAt.Valid {
+		return nil, errSurveyComplete
+	}
+	if p.Closed(time.Now()) {
+		return nil, errSurveyClosed
+	}
+
+	var data []byte
+	if p.Kind == SurveyKindForm {
+		valid, err := validateSurveyAnswers(p.Questions, answers)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = json.Marshal(valid); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := db.ExecContext(ctx, "UPDATE survey_recipients SET completed_at = ?, answers = ? WHERE survey_id = ? AND user_id = ? AND completed_at IS NULL", time.Now(), data, id, user)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, errSurveyComplete // Completed concurrently.
+	}
+	return GetSurveyPrompt(ctx, db, id, user)
+}
+
+// validateSurveyAnswers returns the answers to questions in answers, having
+// checked them.
+func validateSurveyAnswers(questions []*SurveyQuestion, answers map[string]string) (map[string]string, error) {
+	valid := make(map[string]string)
+	for _, q := range questions {
+		a := strings.TrimSpace(answers[q.ID])
+		if a == "" {
+			if q.Required {
+				return nil, invalidSurvey("answer_required", "Question %s must be answered.", q.ID)
+			}
+			continue
+		}
+		switch q.Type {
+		case SurveyQuestionText:
+			if len(a) > maxSurveyAnswerLength {
+				return nil, invalidSurvey("invalid_answer", "The answer to question %s is too long.", q.ID)
+			}
+		case SurveyQuestionChoice:
+			if !slices.Contains(q.Options, a) {
+				return nil, invalidSurvey("invalid_answer", "The answer to question %s is not one of its options.", q.ID)
+			}
+		case SurveyQuestionScale:
+			if n, err := strconv.Atoi(a); err != nil || n < 1 || n > 5 {
+				return nil, invalidSurvey("invalid_answer", "The answer to question %s must be between 1 and 5.", q.ID)
+			}
+		}
+		valid[q.ID] = a
+	}
+	return valid, nil
+}
+
+// SurveyResults are the completion statistics and responses of a survey.
+type SurveyResults struct {
+	Survey     *Survey           `json:"survey"`
+	Recipients int               `json:"noRecipients"`
+	Completed  int               `json:"noCompleted"`
+	Responses  []*SurveyResponse `json:"responses"`
+}
+
+// SurveyResponse is the response (or lack thereof) of a recipient of a
+// survey.
+type SurveyResponse struct {
+	userID uid.ID
+
+	Username    string            `json:"username"`
+	Code        string            `json:"code"`
+	Reminders   int               `json:"reminders"`
+	CompletedAt msql.NullTime     `json:"completedAt"`
+	Answers     map[string]string `json:"answers,omitempty"`
+}
+
+// GetSurveyResults returns the results of s, ordered by username.
+func GetSurveyResults(ctx context.Context, db *sql.DB, s *Survey) (*SurveyResults, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT users.id, users.username, survey_recipients.code, survey_recipients.reminders, survey_recipients.completed_at, survey_recipients.answers
+		FROM survey_recipients
+		INNER JOIN users ON users.id = survey_recipients.user_id
+		WHERE survey_recipients.survey_id = ? AND survey_recipients.notified_at IS NOT NULL
+		ORDER BY users.username_lc`, s.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := &SurveyResults{Survey: s, Responses: []*SurveyResponse{}}
+	for rows.Next() {
+		r := &SurveyResponse{}
+		var answers []byte
+		if err := rows.Scan(&r.userID, &r.Username, &r.Code, &r.Reminders, &r.CompletedAt, &answers); err != nil {
+			return nil, err
+		}
+		if answers != nil {
+			if err := json.Unmarshal(answers, &r.Answers); err != nil {
+				return nil, err
+			}
+		}
+		results.Recipients++
+		if r.CompletedAt.Valid {
+			results.Completed++
+		}
+		results.Responses = append(results.Responses, r)
+	}
+	return results, rows.Err()
+}
+
+// WriteCSV writes the responses of r to w as a CSV file, with a column for
+// each question of built-in forms.
+func (r *SurveyResults) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	header := []string{"username", "code", "reminders", "completed_at"}
+	for _, q := range r.Survey.Questions {
+		header = append(header, q.ID)
+	}
+	cw.Write(header)
+	for _, res := range r.Responses {
+		completedAt := ""
+		if res.CompletedAt.Valid {
+			completedAt = res.CompletedAt.Time.UTC().Format(time.RFC3339)
+		}
+		record := []string{res.Username, res.Code, strconv.Itoa(res.Reminders), completedAt}
+		for _, q := range r.Survey.Questions {
+			record = append(record, res.Answers[q.ID])
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestValidateSurveyAnswers(t *testing.T) {
+	questions := []*SurveyQuestion{
+		{ID: "name", Type: SurveyQuestionText},
+		{ID: "color", Type: SurveyQuestionChoice, Options: []string{"red", "blue"}, Required: true},
+		{ID: "mood", Type: SurveyQuestionScale},
+	}
+	tests := []struct {
+		answers map[string]string
+		want    map[string]string // nil if invalid
+	}{
+		{map[string]string{"color": "red"}, map[string]string{"color": "red"}},
+		{map[string]string{"color": " blue ", "mood": "5", "extra": "x"}, map[string]string{"color": "blue", "mood": "5"}},
+		{map[string]string{"name": "x"}, nil},
+		{map[string]string{"color": "green"}, nil},
+		{map[string]string{"color": "red", "mood": "6"}, nil},
+		{map[string]string{"color": "red", "mood": "ok"}, nil},
+	}
+	for _, test := range tests {
+		got, err := validateSurveyAnswers(questions, test.answers)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("validateSurveyAnswers(%v) succeeded, want error", test.answers)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("validateSurveyAnswers(%v): %v", test.answers, err)
+			continue
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("validateSurveyAnswers(%v) = %v, want %v", test.answers, got, test.want)
+			continue
+		}
+		for k, v := range test.want {
+			if got[k] != v {
+				t.Errorf("validateSurveyAnswers(%v) = %v, want %v", test.answers, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestExperimentPickArm(t *testing.T) {
+	e := &Experiment{
+		ID: uid.New(),
+		Arms: []*ExperimentArm{
+			{ID: uid.New(), Name: "control", Weight: 3},
+			{ID: uid.New(), Name: "treatment", Weight: 1},
+		},
+	}
+	counts := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		user := uid.New()
+		arm := e.pickArm(user)
+		if again := e.pickArm(user); again != arm {
+			t.Fatalf("pickArm(%v) is not deterministic: %s, then %s", user, arm.Name, again.Name)
+		}
+		counts[arm.Name]++
+	}
+	if n := counts["control"]; n < 2700 || n > 3300 {
+		t.Errorf("%d of 4000 users were assigned to the control arm, want about 3000", n)
+	}
+}
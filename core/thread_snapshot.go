@@ -0,0 +1,228 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Threads that bots took part in (by posting, or by commenting) are
+// snapshotted periodically (see SnapshotBotThreads) into an archive that
+// outlives the edits, deletions, and purges of the thread, so that the course
+// of the conversation can be reconstructed later. Snapshots are kept only of
+// threads that changed since their last snapshot.
+
+// threadSnapshotWindow is how long after its last activity a thread is still
+// snapshotted.
+const threadSnapshotWindow = time.Hour * 24 * 14
+
+// ThreadSnapshot is the state of a thread at a point in time.
+type ThreadSnapshot struct {
+	ID      uid.ID       `json:"id"`
+	PostID  uid.ID       `json:"postId"`
+	TakenAt time.Time    `json:"takenAt"`
+	Hash    string       `json:"hash"` // Of the state.
+	State   *ThreadState `json:"state"`
+}
+
+// ThreadState is the full state of a thread: the post and all its comments,
+// deleted ones included.
+type ThreadState struct {
+	Post     *ThreadPostState      `json:"post"`
+	Comments []*ThreadCommentState `json:"comments"`
+}
+
+// ThreadPostState is the state of the post of a thread.
+type ThreadPostState struct {
+	ID          uid.ID          `json:"id"`
+	PublicID    string          `json:"publicId"`
+	CommunityID uid.ID          `json:"communityId"`
+	AuthorID    uid.ID          `json:"authorId"`
+	Username    string          `json:"username"`
+	IsBot       bool            `json:"isBot"`
+	Title       string          `json:"title"`
+	Body        msql.NullString `json:"body"`
+	Upvotes     int             `json:"upvotes"`
+	Downvotes   int             `json:"downvotes"`
+	Points      int             `json:"points"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	EditedAt    msql.NullTime   `json:"editedAt"`
+	DeletedAt   msql.NullTime   `json:"deletedAt"`
+}
+
+// ThreadCommentState is the state of a comment of a thread.
+type ThreadCommentState struct {
+	ID        uid.ID          `json:"id"`
+	ParentID  uid.NullID      `json:"parentId"`
+	AuthorID  uid.ID          `json:"authorId"`
+	Username  string          `json:"username"`
+	IsBot     bool            `json:"isBot"`
+	Body      msql.NullString `json:"body"`
+	Upvotes   int             `json:"upvotes"`
+	Downvotes int             `json:"downvotes"`
+	Points    int             `json:"points"`
+	CreatedAt time.Time       `json:"createdAt"`
+	EditedAt  msql.NullTime   `json:"editedAt"`
+	DeletedAt msql.NullTime   `json:"deletedAt"`
+}
+
+// hash returns the hex-encoded SHA-256 hash of the JSON encoding of s, along
+// with the encoding.
+func (s *ThreadState) hash() (string, []byte, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), data, nil
+}
+
+// getThreadState returns the current state of the thread of post. It returns
+// nil if the post does not exist.
+func getThreadState(ctx context.Context, db *sql.DB, post uid.ID) (*ThreadState, error) {
+	p := &ThreadPostState{}
+	err := db.QueryRowContext(ctx, `
+		SELECT posts.id, posts.public_id, posts.community_id, posts.user_id, users.username, users.is_bot, posts.title, posts.body,
+			posts.upvotes, posts.downvotes, posts.points, posts.created_at, posts.edited_at, posts.deleted_at
+		FROM posts
+		INNER JOIN users ON users.id = posts.user_id
+		WHERE posts.id = ?`, post).Scan(
+		&p.ID, &p.PublicID, &p.CommunityID, &p.AuthorID, &p.Username, &p.IsBot, &p.Title, &p.Body,
+		&p.Upvotes, &p.Downvotes, &p.Points, &p.CreatedAt, &p.EditedAt, &p.DeletedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT comments.id, comments.parent_id, comments.user_id, users.username, users.is_bot, comments.body,
+			comments.upvotes, comments.downvotes, comments.points, comments.created_at, comments.edited_at, comments.deleted_at
+		FROM comments
+		INNER JOIN users ON users.id = comments.user_id
+		WHERE comments.post_id = ?
+		ORDER BY comments.created_at, comments.id`, post)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	state := &ThreadState{Post: p, Comments: []*ThreadCommentState{}}
+	for rows.Next() {
+		c := &ThreadCommentState{}
+		if err := rows.Scan(&c.ID, &c.ParentID, &c.AuthorID, &c.Username, &c.IsBot, &c.Body,
+			&c.Upvotes, &c.Downvotes, &c.Points, &c.CreatedAt, &c.EditedAt, &c.DeletedAt); err != nil {
+			return nil, err
+		}
+		state.Comments = append(state.Comments, c)
+	}
+	return state, rows.Err()
+}
+
+// botThreads returns the IDs of the posts, active since since, that were
+// created by a bot or that a bot commented on.
+func botThreads(ctx context.Context, db *sql.DB, since time.Time) ([]uid.ID, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT posts.id FROM posts
+		WHERE posts.last_activity_at >= ? AND (
+			posts.user_id IN (SELECT id FROM users WHERE is_bot = TRUE)
+			OR EXISTS (
+				SELECT 1 FROM comments
+				INNER JOIN users ON users.id = comments.user_id
+				WHERE comments.post_id = posts.id AND users.is_bot = TRUE
+			)
+		)`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uid.ID
+	for rows.Next() {
+		var id uid.ID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SnapshotBotThreads snapshots the threads that bots took part in, and that
+// were recently active, unless they are unchanged since their last snapshot.
+// It returns the number of snapshots taken.
+func SnapshotBotThreads(ctx context.Context, db *sql.DB) (int, error) {
+	posts, err := botThreads(ctx, db, time.Now().Add(-threadSnapshotWindow))
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, post := range posts {
+		taken, err := snapshotThread(ctx, db, post)
+		if err != nil {
+			return n, err
+		}
+		if taken {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// snapshotThread snapshots the thread of post, if it changed since its last
+// snapshot, and reports whether it did.
+func snapshotThread(ctx context.Context, db *sql.DB, post uid.ID) (bool, error) {
+	state, err := getThreadState(ctx, db, post)
+	if err != nil || state == nil {
+		return false, err
+	}
+	hash, data, err := state.hash()
+	if err != nil {
+		return false, err
+	}
+
+	var last string
+	err = db.QueryRowContext(ctx, "SELECT hash FROM thread_snapshots WHERE post_id = ? ORDER BY taken_at DESC LIMIT 1", post).Scan(&last)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	if last == hash {
+		return false, nil
+	}
+
+	_, err = db.ExecContext(ctx, "INSERT INTO thread_snapshots (id, post_id, taken_at, hash, data) VALUES (?, ?, ?, ?, ?)",
+		uid.New(), post, time.Now(), hash, string(data))
+	return err == nil, err
+}
+
+// GetThreadSnapshots returns the snapshots of the thread of post, oldest
+// first.
+func GetThreadSnapshots(ctx context.Context, db *sql.DB, post uid.ID) ([]*ThreadSnapshot, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, post_id, taken_at, hash, data FROM thread_snapshots WHERE post_id = ? ORDER BY taken_at", post)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := []*ThreadSnapshot{}
+	for rows.Next() {
+		s := &ThreadSnapshot{State: &ThreadState{}}
+		var data string
+		if err := rows.Scan(&s.ID, &s.PostID, &s.TakenAt, &s.Hash, &data); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(data), s.State); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, rows.Err()
+}
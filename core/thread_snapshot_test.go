@@ -0,0 +1,40 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestThreadStateHash(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	state := func() *ThreadState {
+		return &ThreadState{
+			Post: &ThreadPostState{ID: uid.New(), Title: "Title", CreatedAt: now},
+			Comments: []*ThreadCommentState{
+				{ID: uid.New(), Username: "bot", IsBot: true, Points: 1, CreatedAt: now},
+			},
+		}
+	}
+
+	s := state()
+	h1, _, err := s.hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, _, _ := s.hash()
+	if h1 != h2 {
+		t.Errorf("hash of an unchanged state changed: %s != %s", h1, h2)
+	}
+
+	s.Comments[0].Points++
+	if h3, _, _ := s.hash(); h3 == h1 {
+		t.Error("hash did not change with the score of a comment")
+	}
+	s.Comments[0].Points--
+	s.Comments[0].DeletedAt.Time, s.Comments[0].DeletedAt.Valid = now, true
+	if h4, _, _ := s.hash(); h4 == h1 {
+		t.Error("hash did not change with the deletion of a comment")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -110,6 +111,8 @@ type User struct {
 	Email                   msql.NullString `json:"-"`
 	EmailPublic             *string         `json:"email,omitempty"`
 	EmailConfirmedAt        msql.NullTime   `json:"-"`
+	AgeConfirmedAt          msql.NullTime   `json:"-"`
+	AgeConfirmed            bool            `json:"ageConfirmed"` // Whether the user confirmed being an adult (see ConfirmAge).
 	Password                string          `json:"-"`
 	About                   msql.NullString `json:"aboutMe"`
 	Points                  int             `json:"points"`
@@ -135,6 +138,8 @@ type User struct {
 	RememberFeedSort       bool            `json:"rememberFeedSort"`
 	EmbedsOff             bool            `json:"embedsOff"`
 	HideUserProfilePictures bool            `json:"hideUserProfilePictures"`
+	MovedTo                 msql.NullString `json:"movedTo"`      // Profile URL of the account on the instance the user moved to.
+	MigratedFrom            msql.NullString `json:"migratedFrom"` // Profile URL of the account the user moved here from.
 	WelcomeNotificationSent bool            `json:"-"`
 	MutedByViewer          bool            `json:"mutedByViewer"`
 	ModdingList            []*Community    `json:"moddingList"`
@@ -241,6 +246,9 @@ func buildSelectUserQuery(where string) string {
 		"users.embeds_off",
 		"users.hide_user_profile_pictures",
 		"users.welcome_notification_sent",
+		"users.age_confirmed_at",
+		"users.moved_to",
+		"users.migrated_from",
 	}
 	cols = append(cols, images.ImageColumns("pro_pic")...)
 	joins := []string{
@@ -344,6 +352,9 @@ func scanUsers(ctx context.Context, db *sql.DB, rows *sql.Rows, viewer *uid.ID)
 			&u.EmbedsOff,
 			&u.HideUserProfilePictures,
 			&u.WelcomeNotificationSent,
+			&u.AgeConfirmedAt,
+			&u.MovedTo,
+			&u.MigratedFrom,
 		}
 
 		proPic := &images.Image{}
@@ -354,6 +365,7 @@ func scanUsers(ctx context.Context, db *sql.DB, rows *sql.Rows, viewer *uid.ID)
 		}
 
 		u.Deleted = u.DeletedAt.Valid
+		u.AgeConfirmed = u.AgeConfirmedAt.Valid
 		u.preGhostUsername = u.Username
 		u.preGhostID = u.ID
 		u.preGhostCreatedAt = u.CreatedAt
@@ -442,6 +454,9 @@ func RegisterUser(ctx context.Context, db *sql.DB, username, email, password, ip
 	if err := IsUsernameValid(username); err != nil {
 		return nil, httperr.NewBadRequest("invalid-username", fmt.Sprintf("Username %v.", err))
 	}
+	if err := checkNameFilter(ctx, db, username); err != nil {
+		return nil, err
+	}
 
 	hash, err := HashPassword([]byte(password))
 	if err != nil {
@@ -462,9 +477,6 @@ func RegisterUser(ctx context.Context, db *sql.DB, username, email, password, ip
 	}
 	id := uid.New()
 
-	// Check if the username is in bots.txt
-	isBot := isBot(username)
-
 	query, args := msql.BuildInsertQuery("users", []msql.ColumnValue{
 		{Name: "id", Value: id},
 		{Name: "username", Value: username},
@@ -472,7 +484,6 @@ func RegisterUser(ctx context.Context, db *sql.DB, username, email, password, ip
 		{Name: "email", Value: nullEmail},
 		{Name: "password", Value: hash},
 		{Name: "created_ip", Value: ipany},
-		{Name: "is_bot", Value: isBot},
 	})
 	_, err = db.ExecContext(ctx, query, args...)
 	if err != nil {
@@ -489,7 +500,12 @@ func RegisterUser(ctx context.Context, db *sql.DB, username, email, password, ip
 		// Continue on failure.
 	}
 
-	return GetUser(ctx, db, id, nil)
+	user, err := GetUser(ctx, db, id, nil)
+	if err != nil {
+		return nil, err
+	}
+	Users().emit(ctx, db, UserEventCreated, user)
+	return user, nil
 }
 
 func addUserToDefaultCommunities(ctx context.Context, db *sql.DB, user uid.ID) error {
@@ -516,25 +532,41 @@ func addUserToDefaultCommunities(ctx context.Context, db *sql.DB, user uid.ID) e
 	}
 
 	return msql.Transact(ctx, db, func(tx *sql.Tx) (err error) {
-		q, args, ids := "", make([]any, 0, 2*len(communities)), make([]any, len(communities))
+		q, args := "", make([]any, 0, 2*len(communities))
 		for i, id := range communities {
 			if i != 0 {
 				q += ","
 			}
 			q += "(?, ?) "
 			args = append(args, id, user)
-			ids[i] = communities[i]
 		}
 		if _, err = tx.ExecContext(ctx, "INSERT INTO community_members (community_id, user_id) VALUES "+q, args...); err != nil {
 			return err
 		}
-		if _, err := tx.ExecContext(ctx, "UPDATE communities SET no_members = no_members + 1 WHERE id IN "+msql.InClauseQuestionMarks(len(ids)), ids...); err != nil {
-			return err
-		}
-		return nil
+		return incrementCommunityMembers(ctx, tx, 1, communities...)
 	})
 }
 
+// userCommunityMemberships returns the IDs of the communities user is a member
+// of.
+func userCommunityMemberships(ctx context.Context, tx *sql.Tx, user uid.ID) ([]uid.ID, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT community_id FROM community_members WHERE user_id = ?", user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uid.ID
+	for rows.Next() {
+		var id uid.ID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 func usernameExists(ctx context.Context, db *sql.DB, username string) (exists bool, user uid.ID, err error) {
 	username = strings.ToLower(username)
 	if err := db.QueryRowContext(ctx, "SELECT id FROM users WHERE username_lc = ?", username).Scan(&user); err == nil {
@@ -575,12 +607,6 @@ func MatchLoginCredentials(ctx context.Context, db *sql.DB, username, password s
 	return user, nil
 }
 
-// incrementUserPoints adds amount to user's points.
-func incrementUserPoints(ctx context.Context, db *sql.DB, user uid.ID, amount int) error {
-	_, err := db.ExecContext(ctx, "UPDATE users SET points = points + ? WHERE id = ?", amount, user)
-	return err
-}
-
 // Update updates the user's updatable fields.
 func (u *User) Update(ctx context.Context, db *sql.DB) error {
 	if u.Deleted {
@@ -588,8 +614,11 @@ func (u *User) Update(ctx context.Context, db *sql.DB) error {
 	}
 
 	u.About.String = utils.TruncateUnicodeString(u.About.String, maxUserProfileAboutLength)
+	// A changed email address is no longer verified (email_confirmed_at is
+	// set before email, as MySQL assigns columns in order).
 	_, err := db.ExecContext(ctx, `
 	UPDATE users SET
+		email_confirmed_at = IF(email <=> ?, email_confirmed_at, NULL),
 		email = ?, 
 		about_me = ?,
 		upvote_notifications_off = ?,
@@ -599,6 +628,7 @@ func (u *User) Update(ctx context.Context, db *sql.DB) error {
 		embeds_off = ?,
 		hide_user_profile_pictures = ?
 	WHERE id = ?`,
+		u.EmailPublic,
 		u.EmailPublic,
 		u.About,
 		u.UpvoteNotificationsOff,
@@ -608,7 +638,19 @@ func (u *User) Update(ctx context.Context, db *sql.DB) error {
 		u.EmbedsOff,
 		u.HideUserProfilePictures,
 		u.ID)
-	return err
+	if err != nil {
+		return err
+	}
+	email := msql.NullString{}
+	if u.EmailPublic != nil {
+		email = msql.NewNullString(*u.EmailPublic)
+	}
+	if email != u.Email {
+		u.Email = email
+		u.EmailConfirmedAt = msql.NullTime{}
+	}
+	Users().emit(ctx, db, UserEventUpdated, u)
+	return nil
 }
 
 func (u *User) IsGhost() bool {
@@ -675,12 +717,13 @@ func (u *User) Delete(ctx context.Context, db *sql.DB) error {
 		return errors.New("cannot delete banned account (unban user first and then continue)")
 	}
 
-	return msql.Transact(ctx, db, func(tx *sql.Tx) (err error) {
+	err := msql.Transact(ctx, db, func(tx *sql.Tx) (err error) {
 		// Remove the user's membership of all communities the user is a member of.
-		if _, err := tx.ExecContext(ctx, `
-			UPDATE communities 
-			SET no_members = no_members - 1 
-			WHERE id IN (SELECT community_id FROM community_members WHERE user_id = ?)`, u.ID); err != nil {
+		memberOf, err := userCommunityMemberships(ctx, tx, u.ID)
+		if err != nil {
+			return err
+		}
+		if err := incrementCommunityMembers(ctx, tx, -1, memberOf...); err != nil {
 			return err
 		}
 		if _, err := tx.ExecContext(ctx, "DELETE FROM community_members WHERE user_id = ?", u.ID); err != nil {
@@ -717,16 +760,25 @@ func (u *User) Delete(ctx context.Context, db *sql.DB) error {
 			return err
 		}
 
-		// Delete the user's web push subscriptions.
+		// Delete the user's web push subscriptions (and the notifications
+		// yet to be pushed to them).
 		if _, err := tx.ExecContext(ctx, "DELETE FROM web_push_subscriptions WHERE user_id = ?", u.ID); err != nil {
 			return err
 		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM web_push_queue WHERE user_id = ?", u.ID); err != nil {
+			return err
+		}
 
 		// Delete the user's lists.
 		if _, err := tx.ExecContext(ctx, "DELETE FROM lists WHERE user_id = ?", u.ID); err != nil {
 			return err
 		}
 
+		// Delete the user's login history (which contains IP addresses).
+		if _, err := tx.ExecContext(ctx, "DELETE FROM user_logins WHERE user_id = ?", u.ID); err != nil {
+			return err
+		}
+
 		// Delete the user's profile picture
 		if err := u.DeleteProPicTx(ctx, db, tx); err != nil {
 			return err
@@ -761,6 +813,12 @@ func (u *User) Delete(ctx context.Context, db *sql.DB) error {
 		u.NumNewNotifications = 0
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	u.Deleted = true
+	Users().emit(ctx, db, UserEventDeleted, u)
+	return nil
 }
 
 // DeleteContent deletes all posts and comments of user that were created in the
@@ -834,12 +892,13 @@ func (u *User) Ban(ctx context.Context, db *sql.DB) error {
 	}
 
 	t := time.Now()
-	_, err := db.ExecContext(ctx, "UPDATE users SET banned_at = ? WHERE id = ?", t, u.ID)
-	if err == nil {
-		u.BannedAt = msql.NewNullTime(t)
-		u.Banned = true
+	if _, err := db.ExecContext(ctx, "UPDATE users SET banned_at = ? WHERE id = ?", t, u.ID); err != nil {
+		return err
 	}
-	return err
+	u.BannedAt = msql.NewNullTime(t)
+	u.Banned = true
+	Users().emit(ctx, db, UserEventBanned, u)
+	return nil
 }
 
 func (u *User) Unban(ctx context.Context, db *sql.DB) error {
@@ -847,8 +906,13 @@ func (u *User) Unban(ctx context.Context, db *sql.DB) error {
 		return ErrUserDeleted
 	}
 
-	_, err := db.ExecContext(ctx, "UPDATE users SET banned_at = NULL WHERE id = ?", u.ID)
-	return err
+	if _, err := db.ExecContext(ctx, "UPDATE users SET banned_at = NULL WHERE id = ?", u.ID); err != nil {
+		return err
+	}
+	u.BannedAt = msql.NullTime{}
+	u.Banned = false
+	Users().emit(ctx, db, UserEventUnbanned, u)
+	return nil
 }
 
 // MakeAdmin makes the user an admin of the site. If isAdmin is false
@@ -979,12 +1043,21 @@ func (u *User) DeleteProPicTx(ctx context.Context, db *sql.DB, tx *sql.Tx) error
 }
 
 func (u *User) DeleteProPic(ctx context.Context, db *sql.DB) error {
-	return msql.Transact(ctx, db, func(tx *sql.Tx) error {
+	hadProPic := u.ProPic != nil
+	if err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
 		return u.DeleteProPicTx(ctx, db, tx)
-	})
+	}); err != nil {
+		return err
+	}
+	if hadProPic {
+		Users().emit(ctx, db, UserEventUpdated, u)
+	}
+	return nil
 }
 
-func (u *User) UpdateProPic(ctx context.Context, db *sql.DB, image []byte, s3Enabled bool) error {
+// UpdateProPic sets the profile picture of u to image, cropped to crop if
+// it's not nil.
+func (u *User) UpdateProPic(ctx context.Context, db *sql.DB, image io.Reader, crop *images.CropRect, s3Enabled bool) error {
 	if u.Deleted {
 		return ErrUserDeleted
 	}
@@ -1000,6 +1073,8 @@ func (u *User) UpdateProPic(ctx context.Context, db *sql.DB, image []byte, s3Ena
 			Height: 2000,
 			Format: images.ImageFormatJPEG,
 			Fit:    images.ImageFitContain,
+			Quota:  []images.QuotaOwner{{Type: images.QuotaOwnerUser, ID: u.ID}},
+			Crop:   crop,
 		})
 		if err != nil {
 			return fmt.Errorf("fail to save user pro pic: %w", err)
@@ -1017,6 +1092,7 @@ func (u *User) UpdateProPic(ctx context.Context, db *sql.DB, image []byte, s3Ena
 	if err != nil {
 		return err
 	}
+	images.QueuePostProcessing(newImageID)
 
 	record, err := images.GetImageRecord(ctx, db, newImageID)
 	if err != nil {
@@ -1024,6 +1100,7 @@ func (u *User) UpdateProPic(ctx context.Context, db *sql.DB, image []byte, s3Ena
 	}
 	u.ProPic = record.Image()
 	setCommunityProPicCopies(u.ProPic)
+	Users().emit(ctx, db, UserEventUpdated, u)
 	return nil
 }
 
@@ -1460,30 +1537,18 @@ func GetAllUserIDs(ctx context.Context, db *sql.DB, fetchDeleted, fetchBanned bo
 	return ids, nil
 }
 
-// InitializeBotUsersFromFile reads a text file and creates bot users in the database
-// The file should have one user per line in the format: username,password
+// InitializeBotUsersFromFile reads a text file and creates bot users in the
+// database. The file should have one user per line in the format:
+// username,password. Users in the file that already exist are made bots.
 func InitializeBotUsersFromFile(ctx context.Context, db *sql.DB, filePath string) error {
-	// Set the bots file path for future use
-	SetBotsFilePath(filePath)
-
 	// Read the file content
 	content, err := os.ReadFile(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to read file: %w", err)
 	}
 
-	// Split the content into lines
-	lines := strings.Split(string(content), "\n")
-
-	// Start a transaction
-	tx, err := db.BeginTx(ctx, nil)
-	if err != nil {
-		return fmt.Errorf("failed to start transaction: %w", err)
-	}
-	defer tx.Rollback()
-
 	// Process each line
-	for _, line := range lines {
+	for _, line := range strings.Split(string(content), "\n") {
 		// Skip empty lines
 		if strings.TrimSpace(line) == "" {
 			continue
@@ -1498,69 +1563,22 @@ func InitializeBotUsersFromFile(ctx context.Context, db *sql.DB, filePath string
 		username := strings.TrimSpace(parts[0])
 		password := strings.TrimSpace(parts[1])
 
-		// Validate username
-		if err := IsBotUsernameValid(username); err != nil {
-			log.Printf("Skipping invalid username %s: %v", username, err)
+		// Make existing users bots
+		existing, err := GetUserByUsername(ctx, db, username, nil)
+		if err == nil {
+			if err := PromoteToBot(ctx, db, existing.ID); err != nil {
+				log.Printf("Error making user %s a bot: %v", username, err)
+			}
 			continue
-		}
-
-		// Check if username already exists
-		var exists bool
-		err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE username_lc = ?)", strings.ToLower(username)).Scan(&exists)
-		if err != nil {
+		} else if err != errUserNotFound {
 			log.Printf("Error checking username existence for %s: %v", username, err)
 			continue
 		}
-		if exists {
-			log.Printf("Skipping existing username %s", username)
-			continue
-		}
-
-		// Hash password
-		passwordHash, err := HashPassword([]byte(password))
-		if err != nil {
-			log.Printf("Error hashing password for %s: %v", username, err)
-			continue
-		}
-
-		// Create bot user
-		botID := uid.New()
-		now := time.Now()
 
-		// Insert into users table
-		_, err = tx.ExecContext(ctx, `
-			INSERT INTO users (id, username, username_lc, password, created_at, last_seen, points, is_admin, is_bot)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-		`, botID, username, strings.ToLower(username), passwordHash, now, now, 0, false, true)
-		if err != nil {
-			log.Printf("Error creating user %s: %v", username, err)
-			continue
+		if _, err := CreateBotUser(ctx, db, username, password); err != nil {
+			log.Printf("Error creating bot user %s: %v", username, err)
 		}
 	}
 
-	// Commit the transaction
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
-	}
-
 	return nil
 }
-
-// isBot checks if a username belongs to a bot by checking the bots.txt file
-func isBot(username string) bool {
-	data, err := os.ReadFile("bots.txt")
-	if err != nil {
-		return false
-	}
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		if line == "" {
-			continue
-		}
-		parts := strings.Split(line, ",")
-		if len(parts) > 0 && strings.ToLower(parts[0]) == strings.ToLower(username) {
-			return true
-		}
-	}
-	return false
-}
@@ -0,0 +1,259 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// RecordUserLogin adds an entry to the login history of user.
+func RecordUserLogin(ctx context.Context, db *sql.DB, user uid.ID, ip, userAgent string) error {
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+	query, args := msql.BuildInsertQuery("user_logins", []msql.ColumnValue{
+		{Name: "user_id", Value: user},
+		{Name: "ip", Value: msql.NewNullString(ip)},
+		{Name: "user_agent", Value: msql.NewNullString(userAgent)},
+	})
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// UserActivityType is the kind of an entry in a user's activity timeline.
+type UserActivityType string
+
+const (
+	UserActivityPost           = UserActivityType("post")
+	UserActivityComment        = UserActivityType("comment")
+	UserActivityPostVote       = UserActivityType("post_vote")
+	UserActivityCommentVote    = UserActivityType("comment_vote")
+	UserActivityReportFiled    = UserActivityType("report_filed")
+	UserActivityReportReceived = UserActivityType("report_received")
+	UserActivityLogin          = UserActivityType("login")
+	UserActivityBan            = UserActivityType("ban")           // Site-wide ban.
+	UserActivityCommunityBan   = UserActivityType("community_ban") // Ban from a community.
+)
+
+var userActivityTypes = []UserActivityType{
+	UserActivityPost,
+	UserActivityComment,
+	UserActivityPostVote,
+	UserActivityCommentVote,
+	UserActivityReportFiled,
+	UserActivityReportReceived,
+	UserActivityLogin,
+	UserActivityBan,
+	UserActivityCommunityBan,
+}
+
+func (t UserActivityType) Valid() bool {
+	return slices.Contains(userActivityTypes, t)
+}
+
+// UserActivity is an entry in the activity timeline of a user. Fields that are
+// not relevant to the type of the entry are null.
+type UserActivity struct {
+	Type        UserActivityType `json:"type"`
+	CreatedAt   time.Time        `json:"createdAt"`
+	PostID      uid.NullID       `json:"postId"`
+	CommentID   uid.NullID       `json:"commentId"`
+	CommunityID uid.NullID       `json:"communityId"`
+
+	// Whether the vote is an upvote, for votes.
+	Up msql.NullBool `json:"up"`
+
+	// For posts and comments.
+	DeletedAt msql.NullTime `json:"deletedAt"`
+
+	// For reports.
+	ReportID msql.NullInt32 `json:"reportId"`
+
+	// For logins.
+	IP        msql.NullString `json:"ip"`
+	UserAgent msql.NullString `json:"userAgent"`
+
+	// The user who filed the report (for received reports) or who banned the
+	// user (for community bans).
+	ActorID uid.NullID `json:"actorId"`
+
+	sortKey string
+}
+
+// userActivityColumns are the columns of the activity timeline, in the order
+// they are scanned into a UserActivity.
+var userActivityColumns = []string{"type", "created_at", "post_id", "comment_id", "community_id", "up", "deleted_at", "report_id", "ip", "user_agent", "actor_id", "sort_key"}
+
+// userActivitySource is where entries of an activity type come from.
+type userActivitySource struct {
+	values map[string]string // Column name to SQL expression; missing columns are NULL.
+	from   string            // The FROM and WHERE clauses, with the user ID as the only argument.
+}
+
+func (s userActivitySource) query(t UserActivityType) string {
+	var b strings.Builder
+	b.WriteString("SELECT ")
+	for i, col := range userActivityColumns {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		expr, ok := s.values[col]
+		switch {
+		case col == "type":
+			expr = "'" + string(t) + "'"
+		case !ok:
+			expr = "NULL"
+		}
+		b.WriteString(expr + " AS " + col)
+	}
+	b.WriteString(" " + s.from)
+	return b.String()
+}
+
+var userActivitySources = map[UserActivityType]userActivitySource{
+	UserActivityPost: {
+		values: map[string]string{"created_at": "created_at", "post_id": "id", "community_id": "community_id", "deleted_at": "deleted_at", "sort_key": "CONCAT('post-', HEX(id))"},
+		from:   "FROM posts WHERE user_id = ?",
+	},
+	UserActivityComment: {
+		values: map[string]string{"created_at": "created_at", "post_id": "post_id", "comment_id": "id", "community_id": "community_id", "deleted_at": "deleted_at", "sort_key": "CONCAT('comment-', HEX(id))"},
+		from:   "FROM comments WHERE user_id = ?",
+	},
+	UserActivityPostVote: {
+		values: map[string]string{"created_at": "v.created_at", "post_id": "v.post_id", "community_id": "p.community_id", "up": "v.up", "sort_key": "CONCAT('post_vote-', v.id)"},
+		from:   "FROM post_votes AS v INNER JOIN posts AS p ON p.id = v.post_id WHERE v.user_id = ?",
+	},
+	UserActivityCommentVote: {
+		values: map[string]string{"created_at": "v.created_at", "post_id": "c.post_id", "comment_id": "v.comment_id", "community_id": "c.community_id", "up": "v.up", "sort_key": "CONCAT('comment_vote-', v.id)"},
+		from:   "FROM comment_votes AS v INNER JOIN comments AS c ON c.id = v.comment_id WHERE v.user_id = ?",
+	},
+	UserActivityReportFiled: {
+		values: map[string]string{"created_at": "created_at", "post_id": "post_id", "comment_id": fmt.Sprintf("IF(report_type = %d, target_id, NULL)", ReportTypeComment), "community_id": "community_id", "report_id": "id", "sort_key": "CONCAT('report_filed-', id)"},
+		from:   "FROM reports WHERE created_by = ?",
+	},
+	UserActivityReportReceived: {
+		values: map[string]string{"created_at": "r.created_at", "post_id": "r.post_id", "comment_id": fmt.Sprintf("IF(r.report_type = %d, r.target_id, NULL)", ReportTypeComment), "community_id": "r.community_id", "report_id": "r.id", "actor_id": "r.created_by", "sort_key": "CONCAT('report_received-', r.id)"},
+		from: fmt.Sprintf(`FROM reports AS r
+			LEFT JOIN posts AS p ON r.report_type = %d AND p.id = r.target_id
+			LEFT JOIN comments AS c ON r.report_type = %d AND c.id = r.target_id
+			WHERE COALESCE(p.user_id, c.user_id) = ?`, ReportTypePost, ReportTypeComment),
+	},
+	UserActivityLogin: {
+		values: map[string]string{"created_at": "created_at", "ip": "ip", "user_agent": "user_agent", "sort_key": "CONCAT('login-', id)"},
+		from:   "FROM user_logins WHERE user_id = ?",
+	},
+	UserActivityBan: {
+		values: map[string]string{"created_at": "banned_at", "sort_key": "CONCAT('ban-', HEX(id))"},
+		from:   "FROM users WHERE id = ? AND banned_at IS NOT NULL",
+	},
+	UserActivityCommunityBan: {
+		values: map[string]string{"created_at": "created_at", "community_id": "community_id", "actor_id": "banned_by", "sort_key": "CONCAT('community_ban-', id)"},
+		from:   "FROM community_banned WHERE user_id = ?",
+	},
+}
+
+// UserActivityFilter narrows down the entries returned by GetUserActivity.
+type UserActivityFilter struct {
+	Types []UserActivityType // If empty, all types are included.
+	Since time.Time          // If non-zero, only entries created at or after.
+	Until time.Time          // If non-zero, only entries created before.
+}
+
+// GetUserActivity returns the activity timeline of user—posts, comments, votes,
+// reports filed and received, logins, and bans—newest first. Pass the returned
+// next cursor to get the next page of results.
+func GetUserActivity(ctx context.Context, db *sql.DB, user uid.ID, filter UserActivityFilter, limit int, next *string) ([]*UserActivity, *string, error) {
+	types := filter.Types
+	if len(types) == 0 {
+		types = userActivityTypes
+	}
+	for _, t := range types {
+		if !t.Valid() {
+			return nil, nil, httperr.NewBadRequest("invalid_activity_type", "Invalid activity type.")
+		}
+	}
+
+	var parts []string
+	var args []any
+	for _, t := range userActivityTypes {
+		if slices.Contains(types, t) {
+			parts = append(parts, "("+userActivitySources[t].query(t)+")")
+			args = append(args, user)
+		}
+	}
+
+	var conds []string
+	if !filter.Since.IsZero() {
+		conds = append(conds, "created_at >= ?")
+		args = append(args, filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		conds = append(conds, "created_at < ?")
+		args = append(args, filter.Until)
+	}
+	if next != nil {
+		createdAt, sortKey, err := parseUserActivityCursor(*next)
+		if err != nil {
+			return nil, nil, err
+		}
+		conds = append(conds, "(created_at < ? OR (created_at = ? AND sort_key <= ?))")
+		args = append(args, createdAt, createdAt, sortKey)
+	}
+
+	var where string
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+	query := fmt.Sprintf("SELECT %s FROM (%s) AS activity %s ORDER BY created_at DESC, sort_key DESC LIMIT ?",
+		strings.Join(userActivityColumns, ", "), strings.Join(parts, " UNION ALL "), where)
+	args = append(args, limit+1)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	items := []*UserActivity{}
+	for rows.Next() {
+		a := &UserActivity{}
+		if err := rows.Scan(&a.Type, &a.CreatedAt, &a.PostID, &a.CommentID, &a.CommunityID, &a.Up, &a.DeletedAt,
+			&a.ReportID, &a.IP, &a.UserAgent, &a.ActorID, &a.sortKey); err != nil {
+			return nil, nil, err
+		}
+		items = append(items, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var nextNext *string
+	if len(items) >= limit+1 {
+		last := items[limit]
+		nextNext = new(string)
+		*nextNext = strconv.FormatInt(last.CreatedAt.Unix(), 10) + "." + last.sortKey
+		items = items[:limit]
+	}
+	return items, nextNext, nil
+}
+
+func parseUserActivityCursor(cursor string) (time.Time, string, error) {
+	errInvalid := httperr.NewBadRequest("invalid_cursor", "Invalid pagination cursor.")
+	ts, sortKey, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return time.Time{}, "", errInvalid
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return time.Time{}, "", errInvalid
+	}
+	return time.Unix(unix, 0).UTC(), sortKey, nil
+}
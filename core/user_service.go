@@ -0,0 +1,78 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+)
+
+// Changes to the state of users (signups, updates of profiles and
+// preferences, deletions, and bans) are announced, as UserEvents, to the
+// hooks of the UserService of the site (see Users), so that subsystems that
+// keep state of their own about users can keep it in sync, without each
+// having to be called from wherever users are changed.
+
+// UserEventType is the type of a UserEvent.
+type UserEventType string
+
+const (
+	UserEventCreated  = UserEventType("created") // The user signed up, or was created otherwise.
+	UserEventUpdated  = UserEventType("updated") // Of the profile (the profile picture included) or the preferences of the user.
+	UserEventDeleted  = UserEventType("deleted")
+	UserEventBanned   = UserEventType("banned")
+	UserEventUnbanned = UserEventType("unbanned")
+)
+
+// UserEvent is a change to the state of a user.
+type UserEvent struct {
+	Type UserEventType
+	At   time.Time
+	User *User // As of after the change.
+}
+
+// A UserHook is run for every UserEvent, after the change is made.
+// Implementations must be safe for concurrent use.
+type UserHook interface {
+	Name() string // The identifier of the hook.
+
+	// UserChanged is called with each event. An error returned by it is
+	// logged; the change is not undone.
+	UserChanged(ctx context.Context, db *sql.DB, e *UserEvent) error
+}
+
+// UserService announces changes to users to its hooks.
+type UserService struct {
+	mu    sync.RWMutex
+	hooks []UserHook
+}
+
+var users = &UserService{}
+
+// Users returns the UserService of the site.
+func Users() *UserService {
+	return users
+}
+
+// AddHook adds hook to the hooks run for changes to users, after those added
+// before it.
+func (s *UserService) AddHook(hook UserHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, hook)
+}
+
+// emit runs the hooks of s for an event of type t, of user.
+func (s *UserService) emit(ctx context.Context, db *sql.DB, t UserEventType, user *User) {
+	s.mu.RLock()
+	hooks := s.hooks
+	s.mu.RUnlock()
+
+	e := &UserEvent{Type: t, At: time.Now(), User: user}
+	for _, hook := range hooks {
+		if err := hook.UserChanged(ctx, db, e); err != nil {
+			log.Printf("Error running user hook %s on %s event of user %v: %v\n", hook.Name(), t, user.ID, err)
+		}
+	}
+}
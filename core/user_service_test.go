@@ -0,0 +1,55 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+)
+
+// recordingUserHook is a UserHook that records the events it's run for.
+type recordingUserHook struct {
+	events []UserEventType
+}
+
+func (h *recordingUserHook) Name() string { return "recording" }
+
+func (h *recordingUserHook) UserChanged(ctx context.Context, db *sql.DB, e *UserEvent) error {
+	h.events = append(h.events, e.Type)
+	return nil
+}
+
+func TestUserServiceEvents(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+
+	hook := &recordingUserHook{}
+	hooks := users.hooks
+	users.AddHook(hook)
+	defer func() { users.hooks = hooks }()
+
+	user := h.newUser(db, "changing", false)
+	user.About = msql.NewNullString("About me.")
+	if err := user.Update(h.ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.Ban(h.ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.Unban(h.ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if user.Banned {
+		t.Error("the user is still banned after being unbanned")
+	}
+	if err := user.Delete(h.ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []UserEventType{UserEventCreated, UserEventUpdated, UserEventBanned, UserEventUnbanned, UserEventDeleted}
+	if !reflect.DeepEqual(hook.events, want) {
+		t.Errorf("got events %v, want %v", hook.events, want)
+	}
+}
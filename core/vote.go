@@ -0,0 +1,278 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// VoteState is a user's vote on a post or a comment.
+type VoteState string
+
+const (
+	VoteUp   = VoteState("up")
+	VoteDown = VoteState("down")
+	VoteNone = VoteState("none") // Not voted.
+)
+
+func (v VoteState) Valid() bool {
+	return v == VoteUp || v == VoteDown || v == VoteNone
+}
+
+// vote returns v in the form newVoteChange takes it.
+func (v VoteState) vote() *bool {
+	if v == VoteNone {
+		return nil
+	}
+	up := v == VoteUp
+	return &up
+}
+
+func voteStateOf(up bool) VoteState {
+	if up {
+		return VoteUp
+	}
+	return VoteDown
+}
+
+var errInvalidVoteState = httperr.NewBadRequest("invalid_vote", "Vote must be one of up, down, or none.")
+
+// voteTarget describes the tables of a kind of item that can be voted on.
+type voteTarget struct {
+	table      string // posts or comments
+	votesTable string
+	idColumn   string // of votesTable
+}
+
+func voteTargetOf(t ContentType) (voteTarget, error) {
+	switch t {
+	case ContentTypePost:
+		return voteTarget{"posts", "post_votes", "post_id"}, nil
+	case ContentTypeComment:
+		return voteTarget{"comments", "comment_votes", "comment_id"}, nil
+	}
+	return voteTarget{}, httperr.NewBadRequest("invalid_target_type", "Invalid vote target type.")
+}
+
+// VoteResult is the state of a post or a comment after a vote.
+type VoteResult struct {
+	TargetType ContentType `json:"targetType"`
+	TargetID   uid.ID      `json:"targetId"`
+	Vote       VoteState   `json:"vote"`    // The voter's vote.
+	Changed    bool        `json:"changed"` // False if the vote was already in the requested state.
+	Upvotes    int         `json:"upvotes"`
+	Downvotes  int         `json:"downvotes"`
+	Points     int         `json:"points"`
+}
+
+// SetVote sets user's vote on the post or comment (depending on target) with
+// id to state. Setting a vote to the state it's already in does nothing, so
+// the call can be safely retried. The returned totals are those as of the
+// vote, read in the same transaction, except for hot posts, whose counters
+// are buffered (see SetVoteBuffer): their totals are those in the database
+// plus this vote.
+func SetVote(ctx context.Context, db *sql.DB, target ContentType, id, user uid.ID, state VoteState) (*VoteResult, error) {
+	if !state.Valid() {
+		return nil, errInvalidVoteState
+	}
+	vt, err := voteTargetOf(target)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &VoteResult{TargetType: target, TargetID: id, Vote: state}
+	var (
+		author   uid.ID
+		before   VoteState
+		vc       voteChange
+		buffered = target == ContentTypePost && postVotesBuffered(ctx, id)
+	)
+	err = msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		// Locking the row of the item serializes votes on it, so that the
+		// returned totals reflect this vote exactly. The rows of hot posts are
+		// not locked, lest every vote on them wait on the others.
+		var err error
+		if target == ContentTypePost {
+			author, _, err = lockPostForVoting(ctx, tx, id, !buffered)
+		} else {
+			author, _, err = lockCommentForVoting(ctx, tx, id)
+		}
+		if err != nil {
+			return err
+		}
+
+		var voteID int
+		var up bool
+		before = VoteNone
+		q := fmt.Sprintf("SELECT id, up FROM %s WHERE %s = ? AND user_id = ? FOR UPDATE", vt.votesTable, vt.idColumn)
+		if err := tx.QueryRowContext(ctx, q, id, user).Scan(&voteID, &up); err == nil {
+			before = voteStateOf(up)
+		} else if err != sql.ErrNoRows {
+			return err
+		}
+
+		if before != state {
+			switch {
+			case before == VoteNone:
+				err = insertVote(ctx, tx, vt, id, user, state == VoteUp)
+			case state == VoteNone:
+				_, err = tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE id = ?", vt.votesTable), voteID)
+			default:
+				_, err = tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET up = ? WHERE id = ?", vt.votesTable), state == VoteUp, voteID)
+			}
+			if err != nil {
+				return err
+			}
+			vc = newVoteChange(before.vote(), state.vote(), author.EqualsTo(user))
+			res.Changed = true
+			if !buffered {
+				if err := vc.apply(ctx, tx, vt.table, id, author); err != nil {
+					return err
+				}
+				if target == ContentTypePost {
+					if err := updatePostsHotnessTx(ctx, tx, id); err != nil {
+						return err
+					}
+					if err := updatePostsTablesPointsTx(ctx, tx, id); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		q = fmt.Sprintf("SELECT upvotes, downvotes, points FROM %s WHERE id = ?", vt.table)
+		return tx.QueryRowContext(ctx, q, id).Scan(&res.Upvotes, &res.Downvotes, &res.Points)
+	})
+	if buffered && msql.IsErrDuplicateErr(err) {
+		// Another vote of user, unserialized since the post wasn't locked,
+		// inserted the vote row first.
+		return SetVote(ctx, db, target, id, user, state)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if buffered && res.Changed {
+		if err := bufferPostVoteChange(ctx, db, id, vc); err != nil {
+			return nil, err
+		}
+		res.Upvotes += vc.upvotes
+		res.Downvotes += vc.downvotes
+		res.Points += vc.points
+	}
+
+	// Attempt to create a notification (only for new upvotes).
+	if res.Changed && state == VoteUp && before != VoteUp && !author.EqualsTo(user) {
+		queueNewVotesNotification(db, author, target == ContentTypePost, id)
+	}
+
+	return res, nil
+}
+
+// insertVote inserts the vote row of user on the item of vt with id. It's a
+// variable so that tests can have it race with another vote.
+var insertVote = func(ctx context.Context, tx *sql.Tx, vt voteTarget, id, user uid.ID, up bool) error {
+	_, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (%s, user_id, up) VALUES (?, ?, ?)", vt.votesTable, vt.idColumn), id, user, up)
+	return err
+}
+
+// lockPostForVoting locks the row of post (if lock is true), and returns its
+// author and the name of its community. It returns an error if the post
+// cannot be voted on.
+func lockPostForVoting(ctx context.Context, tx *sql.Tx, post uid.ID, lock bool) (author uid.ID, communityName string, err error) {
+	var locked, archived bool
+	var community uid.ID
+	q := "SELECT user_id, community_id, locked FROM posts WHERE id = ?"
+	if lock {
+		q += " FOR UPDATE"
+	}
+	row := tx.QueryRowContext(ctx, q, post)
+	if err = row.Scan(&author, &community, &locked); err != nil {
+		if err == sql.ErrNoRows {
+			err = errPostNotFound
+		}
+		return
+	}
+	if locked {
+		return author, "", errPostLocked
+	}
+	if err = tx.QueryRowContext(ctx, "SELECT name, archived_at IS NOT NULL FROM communities WHERE id = ?", community).Scan(&communityName, &archived); err != nil {
+		return
+	}
+	if archived {
+		err = errCommunityArchived
+	}
+	return
+}
+
+// lockCommentForVoting is like lockPostForVoting but for comments.
+func lockCommentForVoting(ctx context.Context, tx *sql.Tx, comment uid.ID) (author uid.ID, communityName string, err error) {
+	var deleted bool
+	var post uid.ID
+	row := tx.QueryRowContext(ctx, "SELECT user_id, post_id, community_name, deleted_at IS NOT NULL FROM comments WHERE id = ? FOR UPDATE", comment)
+	if err = row.Scan(&author, &post, &communityName, &deleted); err != nil {
+		if err == sql.ErrNoRows {
+			err = errCommentNotFound
+		}
+		return
+	}
+	if deleted {
+		return author, communityName, errCommentDeleted
+	}
+	var locked, archived bool
+	row = tx.QueryRowContext(ctx, `
+		SELECT posts.locked, communities.archived_at IS NOT NULL
+		FROM posts INNER JOIN communities ON communities.id = posts.community_id
+		WHERE posts.id = ?`, post)
+	if err = row.Scan(&locked, &archived); err != nil {
+		return
+	}
+	if locked {
+		err = errPostLocked
+	} else if archived {
+		err = errCommunityArchived
+	}
+	return
+}
+
+// GetVoteStates returns user's votes on the posts or comments (depending on
+// target) with ids, in a single query. Items not voted on are VoteNone.
+func GetVoteStates(ctx context.Context, db *sql.DB, target ContentType, user uid.ID, ids []uid.ID) (map[uid.ID]VoteState, error) {
+	vt, err := voteTargetOf(target)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[uid.ID]VoteState, len(ids))
+	if len(ids) == 0 {
+		return states, nil
+	}
+	args := []any{user}
+	for _, id := range ids {
+		states[id] = VoteNone
+		args = append(args, id)
+	}
+
+	q := fmt.Sprintf("SELECT %s, up FROM %s WHERE user_id = ? AND %s IN %s", vt.idColumn, vt.votesTable, vt.idColumn, msql.InClauseQuestionMarks(len(ids)))
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uid.ID
+		var up bool
+		if err := rows.Scan(&id, &up); err != nil {
+			return nil, err
+		}
+		states[id] = voteStateOf(up)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/discuitnet/discuit/internal/logging"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+	"github.com/discuitnet/discuit/internal/votebuffer"
+)
+
+// The vote counters of a post that goes viral are updated by every vote on it,
+// and the row of the post becomes the point all these votes wait on. So the
+// counters of hot posts (see votebuffer) are not updated with every vote: the
+// changes are buffered, and written in batches by FlushVoteBuffer. The vote
+// rows of users are still written with every vote, so a user's vote is never
+// lost and can't be cast twice; only the counters lag behind.
+
+var (
+	voteBufferMu sync.RWMutex // guards voteBuffer
+	voteBuffer   *votebuffer.Buffer
+)
+
+// voteBufferFlushBatch is the most posts whose buffered changes are written by
+// a call to FlushVoteBuffer.
+const voteBufferFlushBatch = 500
+
+// SetVoteBuffer sets the buffer of the vote counters of hot posts. If b is
+// nil, the counters of all posts are updated with every vote. Flush the
+// previous buffer (see FlushVoteBuffer) before replacing it.
+func SetVoteBuffer(b *votebuffer.Buffer) {
+	voteBufferMu.Lock()
+	defer voteBufferMu.Unlock()
+	voteBuffer = b
+}
+
+func getVoteBuffer() *votebuffer.Buffer {
+	voteBufferMu.RLock()
+	defer voteBufferMu.RUnlock()
+	return voteBuffer
+}
+
+// postVotesBuffered records a vote on post, and reports whether the changes
+// to its vote counters are to be buffered.
+func postVotesBuffered(ctx context.Context, post uid.ID) bool {
+	b := getVoteBuffer()
+	if b == nil {
+		return false
+	}
+	hot, err := b.Hot(post)
+	if err != nil {
+		logging.FromContext(ctx).Error("Error checking if a post is hot", "post", post, "error", err)
+		return false
+	}
+	return hot
+}
+
+func (vc voteChange) delta() votebuffer.Delta {
+	return votebuffer.Delta{Upvotes: vc.upvotes, Downvotes: vc.downvotes, AuthorPoints: vc.authorPoints}
+}
+
+// bufferPostVoteChange adds vc, of a vote on post that's been written to the
+// database, to the buffered changes of post. If it can't be buffered, it's
+// applied to the database.
+func bufferPostVoteChange(ctx context.Context, db *sql.DB, post uid.ID, vc voteChange) error {
+	if b := getVoteBuffer(); b != nil {
+		err := b.Add(post, vc.delta())
+		if err == nil {
+			return nil
+		}
+		logging.FromContext(ctx).Error("Error buffering a vote", "post", post, "error", err)
+	}
+	return applyPostVoteChange(ctx, db, post, vc)
+}
+
+// applyPostVoteChange updates the vote counters, the hotness, and the points
+// in the posts tables of post, and the points of its author. It does nothing
+// if the post no longer exists.
+func applyPostVoteChange(ctx context.Context, db *sql.DB, post uid.ID, vc voteChange) error {
+	return msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		var author uid.ID
+		if err := tx.QueryRowContext(ctx, "SELECT user_id FROM posts WHERE id = ? FOR UPDATE", post).Scan(&author); err != nil {
+			if err == sql.ErrNoRows {
+				return nil
+			}
+			return err
+		}
+		if err := vc.apply(ctx, tx, "posts", post, author); err != nil {
+			return err
+		}
+		if err := updatePostsHotnessTx(ctx, tx, post); err != nil {
+			return err
+		}
+		return updatePostsTablesPointsTx(ctx, tx, post)
+	})
+}
+
+// updatePostsTablesPointsTx copies the points of post to the posts tables.
+func updatePostsTablesPointsTx(ctx context.Context, tx *sql.Tx, post uid.ID) error {
+	for _, table := range postsTables {
+		q := fmt.Sprintf("UPDATE %s AS pt INNER JOIN posts ON posts.id = pt.post_id SET pt.points = posts.points WHERE posts.id = ?", table)
+		if _, err := tx.ExecContext(ctx, q, post); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FlushVoteBuffer writes the buffered changes to the vote counters of posts
+// (see SetVoteBuffer) to the database, each post in a transaction of its own.
+// It returns the number of posts updated. Changes that fail to be written are
+// put back in the buffer.
+func FlushVoteBuffer(ctx context.Context, db *sql.DB) (int, error) {
+	b := getVoteBuffer()
+	if b == nil {
+		return 0, nil
+	}
+	deltas, err := b.Take(voteBufferFlushBatch)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	n := 0
+	for post, d := range deltas {
+		vc := voteChange{upvotes: d.Upvotes, downvotes: d.Downvotes, points: d.Points(), authorPoints: d.AuthorPoints}
+		if err := applyPostVoteChange(ctx, db, post, vc); err != nil {
+			errs = append(errs, fmt.Errorf("post %v: %w", post, err))
+			if err := b.Add(post, d); err != nil {
+				logging.FromContext(ctx).Error("Lost the buffered votes of a post", "post", post, "delta", d, "error", err)
+			}
+			continue
+		}
+		n++
+	}
+	return n, errors.Join(errs...)
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/redistest"
+	"github.com/discuitnet/discuit/internal/uid"
+	"github.com/discuitnet/discuit/internal/votebuffer"
+)
+
+func TestVoteBuffer(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+
+	author := h.newUser(db, "viral", false)
+	up1 := h.newUser(db, "upvoter1", false)
+	up2 := h.newUser(db, "upvoter2", false)
+	down := h.newUser(db, "downvoter", false)
+	post := h.newPost(db, author, "viral")
+
+	upvotes, downvotes, points, authorPoints := h.voteCounters(db, post)
+
+	SetVoteBuffer(votebuffer.New(redistest.NewServer().Pool(), 1))
+	defer SetVoteBuffer(nil)
+
+	for _, v := range []struct {
+		user  *User
+		state VoteState
+	}{{up1, VoteUp}, {up2, VoteDown}, {up2, VoteUp}, {down, VoteDown}} {
+		if _, err := SetVote(h.ctx, db, ContentTypePost, post.ID, v.user.ID, v.state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The vote rows are written; the counters are not.
+	states, err := GetVoteStates(h.ctx, db, ContentTypePost, up2.ID, []uid.ID{post.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if states[post.ID] != VoteUp {
+		t.Errorf("vote of upvoter2 is %s, want up", states[post.ID])
+	}
+	if u, d, p, a := h.voteCounters(db, post); u != upvotes || d != downvotes || p != points || a != authorPoints {
+		t.Errorf("counters changed before the buffer was flushed")
+	}
+
+	if n, err := FlushVoteBuffer(h.ctx, db); err != nil || n != 1 {
+		t.Fatalf("FlushVoteBuffer = %d, %v; want 1", n, err)
+	}
+	u, d, p, a := h.voteCounters(db, post)
+	if u != upvotes+2 || d != downvotes+1 || p != points+1 || a != authorPoints+2 {
+		t.Errorf("counters are (%d, %d, %d, %d), want (%d, %d, %d, %d)", u, d, p, a, upvotes+2, downvotes+1, points+1, authorPoints+2)
+	}
+}
@@ -0,0 +1,142 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/redistest"
+	"github.com/discuitnet/discuit/internal/uid"
+	"github.com/discuitnet/discuit/internal/votebuffer"
+	"github.com/go-sql-driver/mysql"
+)
+
+// voteCounters returns the vote counters of post and the points of its
+// author.
+func (h *harness) voteCounters(db *sql.DB, post *Post) (upvotes, downvotes, points, authorPoints int) {
+	h.t.Helper()
+	row := db.QueryRowContext(h.ctx, "SELECT upvotes, downvotes, points FROM posts WHERE id = ?", post.ID)
+	if err := row.Scan(&upvotes, &downvotes, &points); err != nil {
+		h.t.Fatal(err)
+	}
+	if err := db.QueryRowContext(h.ctx, "SELECT points FROM users WHERE id = ?", post.AuthorID).Scan(&authorPoints); err != nil {
+		h.t.Fatal(err)
+	}
+	return
+}
+
+func TestSetVote(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+
+	author := h.newUser(db, "voted", false)
+	voter := h.newUser(db, "voter", false)
+	post := h.newPost(db, author, "voting")
+	upvotes, downvotes, points, authorPoints := h.voteCounters(db, post)
+
+	for _, v := range []struct {
+		name    string
+		user    *User
+		state   VoteState
+		changed bool
+
+		// The counters after the vote, relative to those before the votes.
+		upvotes, downvotes, points, authorPoints int
+	}{
+		{"up", voter, VoteUp, true, 1, 0, 1, 1},
+		{"up to up", voter, VoteUp, false, 1, 0, 1, 1},
+		{"up to down", voter, VoteDown, true, 0, 1, -1, 0},
+		{"down to none", voter, VoteNone, true, 0, 0, 0, 0},
+		{"self upvote", author, VoteUp, true, 1, 0, 1, 0},
+	} {
+		res, err := SetVote(h.ctx, db, ContentTypePost, post.ID, v.user.ID, v.state)
+		if err != nil {
+			t.Fatalf("%s: %v", v.name, err)
+		}
+		if res.Changed != v.changed || res.Vote != v.state {
+			t.Errorf("%s: got vote %s (changed: %v), want %s (changed: %v)", v.name, res.Vote, res.Changed, v.state, v.changed)
+		}
+		if res.Upvotes != upvotes+v.upvotes || res.Downvotes != downvotes+v.downvotes || res.Points != points+v.points {
+			t.Errorf("%s: got totals (%d, %d, %d), want (%d, %d, %d)", v.name, res.Upvotes, res.Downvotes, res.Points, upvotes+v.upvotes, downvotes+v.downvotes, points+v.points)
+		}
+		u, d, p, a := h.voteCounters(db, post)
+		if u != res.Upvotes || d != res.Downvotes || p != res.Points || a != authorPoints+v.authorPoints {
+			t.Errorf("%s: counters are (%d, %d, %d, %d), want (%d, %d, %d, %d)", v.name, u, d, p, a, res.Upvotes, res.Downvotes, res.Points, authorPoints+v.authorPoints)
+		}
+	}
+}
+
+func TestSetVoteBufferedRetry(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+
+	author := h.newUser(db, "raced", false)
+	voter := h.newUser(db, "racer", false)
+	post := h.newPost(db, author, "racing")
+	upvotes, downvotes, points, authorPoints := h.voteCounters(db, post)
+
+	SetVoteBuffer(votebuffer.New(redistest.NewServer().Pool(), 1))
+	defer SetVoteBuffer(nil)
+
+	// The first insert fails as if another vote of voter, on the unlocked
+	// row of the post, had inserted the vote row first.
+	insert := insertVote
+	defer func() { insertVote = insert }()
+	inserts := 0
+	insertVote = func(ctx context.Context, tx *sql.Tx, vt voteTarget, id, user uid.ID, up bool) error {
+		if inserts++; inserts == 1 {
+			return &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}
+		}
+		return insert(ctx, tx, vt, id, user, up)
+	}
+
+	res, err := SetVote(h.ctx, db, ContentTypePost, post.ID, voter.ID, VoteUp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserts != 2 {
+		t.Errorf("the vote was inserted %d times, want 2", inserts)
+	}
+	if !res.Changed || res.Upvotes != upvotes+1 || res.Points != points+1 {
+		t.Errorf("got result %+v, want a changed vote of %d upvotes and %d points", res, upvotes+1, points+1)
+	}
+
+	// The vote is buffered once.
+	if n, err := FlushVoteBuffer(h.ctx, db); err != nil || n != 1 {
+		t.Fatalf("FlushVoteBuffer = %d, %v; want 1", n, err)
+	}
+	if u, d, p, a := h.voteCounters(db, post); u != upvotes+1 || d != downvotes || p != points+1 || a != authorPoints+1 {
+		t.Errorf("counters are (%d, %d, %d, %d), want (%d, %d, %d, %d)", u, d, p, a, upvotes+1, downvotes, points+1, authorPoints+1)
+	}
+}
+
+func TestGetVoteStates(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+
+	author := h.newUser(db, "states", false)
+	voter := h.newUser(db, "stater", false)
+	var posts []*Post
+	for _, name := range []string{"states1", "states2", "states3"} {
+		posts = append(posts, h.newPost(db, author, name))
+	}
+	for i, state := range []VoteState{VoteUp, VoteDown} {
+		if _, err := SetVote(h.ctx, db, ContentTypePost, posts[i].ID, voter.ID, state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	states, err := GetVoteStates(h.ctx, db, ContentTypePost, voter.ID, []uid.ID{posts[0].ID, posts[1].ID, posts[2].ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []VoteState{VoteUp, VoteDown, VoteNone}
+	if len(states) != len(want) {
+		t.Errorf("got %d states, want %d", len(states), len(want))
+	}
+	for i, post := range posts {
+		if states[post.ID] != want[i] {
+			t.Errorf("vote on post %d is %s, want %s", i, states[post.ID], want[i])
+		}
+	}
+}
@@ -0,0 +1,531 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SherClockHolmes/webpush-go"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/logging"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Notifications are pushed to the browsers of users (the web push
+// subscriptions of their sessions) in batches, off the requests that cause
+// them: a notification is added to the web push queue of its user, and a
+// delivery job, run a few seconds later, takes all the notifications in the
+// queue of the user and pushes (the latest of) them in one message to each
+// subscription. A message is sent by a job of its own, so that it's retried,
+// with backoff, if the push service fails (see SetJobQueue).
+
+var (
+	pushMutex         sync.RWMutex // guards the following
+	pushNotifsEnabled = false
+	webmasterEmail    = ""
+	vapidKeys         = &VAPIDKeys{}
+)
+
+// EnablePushNotifications enables sending web push notifications. The email
+// address is the email of the webmaster.
+func EnablePushNotifications(keys *VAPIDKeys, email string) {
+	pushMutex.Lock()
+	defer pushMutex.Unlock()
+
+	pushNotifsEnabled = true
+	vapidKeys = keys
+	webmasterEmail = email
+}
+
+// webPushClient is the client web push messages are sent with.
+var webPushClient webpush.HTTPClient = &http.Client{Timeout: time.Second * 10}
+
+// webPushOptions returns the options web push messages are sent with, or nil
+// if web push notifications are not enabled.
+func webPushOptions() *webpush.Options {
+	pushMutex.RLock()
+	defer pushMutex.RUnlock()
+	if !pushNotifsEnabled {
+		return nil
+	}
+	return &webpush.Options{
+		HTTPClient:      webPushClient,
+		Subscriber:      webmasterEmail,
+		VAPIDPublicKey:  vapidKeys.Public,
+		VAPIDPrivateKey: vapidKeys.Private,
+		TTL:             int(webPushTTL / time.Second),
+	}
+}
+
+// VAPIDKeys is an application server key-pair used by the Web Push API.
+type VAPIDKeys struct {
+	Public  string `json:"public"`
+	Private string `json:"private"`
+}
+
+const vapidKeysDBKey = "vapid_keys" // for the key column of the application_data table
+
+func saveVAPIDKeys(ctx context.Context, db *sql.DB) (*VAPIDKeys, error) {
+	private, public, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	pair := &VAPIDKeys{
+		Public:  public,
+		Private: private,
+	}
+	data, err := json.Marshal(pair)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.ExecContext(ctx, "INSERT INTO application_data (`key`, `value`) VALUES (?, ?)", vapidKeysDBKey, string(data)); err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// GetApplicationVAPIDKeys returns a pair of VAPID public/private keys used by
+// the Web Push API. If no keys are found in the application_data table in the
+// database, a new key-value pair is generated, saved, and returned.
+func GetApplicationVAPIDKeys(ctx context.Context, db *sql.DB) (*VAPIDKeys, error) {
+	rawJSON := ""
+	row := db.QueryRowContext(ctx, "SELECT `value` FROM application_data WHERE `key` = ?", vapidKeysDBKey)
+	if err := row.Scan(&rawJSON); err != nil {
+		if err == sql.ErrNoRows {
+			return saveVAPIDKeys(ctx, db)
+		}
+		return nil, err
+	}
+
+	pair := &VAPIDKeys{}
+	if err := json.Unmarshal([]byte(rawJSON), pair); err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// Kinds of web push jobs.
+const (
+	webPushJobDeliver = "web_push_deliver"
+	webPushJobSend    = "web_push_send"
+)
+
+const (
+	// webPushBatchDelay is how long after a notification is queued the
+	// notifications in the web push queue of its user are pushed.
+	webPushBatchDelay = time.Second * 10
+
+	// webPushTTL is how long push services keep trying to deliver messages
+	// to browsers that are offline.
+	webPushTTL = time.Hour * 24
+)
+
+var (
+	errWebPushSubscriptionNotFound = httperr.NewNotFound("push_subscription_not_found", "Push subscription not found.")
+	errInvalidWebPushSubscription  = httperr.NewBadRequest("invalid_push_subscription", "Invalid push subscription.")
+)
+
+// WebPushSubscription stores a PushSubscription object with other necessary
+// information for sending web push notifications for logged in users.
+//
+// One user could have multiple WebPushSubscription entries in the database (in
+// which case he's signed in on multiple devices).
+type WebPushSubscription struct {
+	ID               int                   `json:"id"`
+	SessionID        string                `json:"-"`
+	UserID           uid.ID                `json:"userId"`
+	PushSubscription webpush.Subscription  `json:"-"`
+	Preferences      PushDevicePreferences `json:"preferences"`
+	CreatedAt        time.Time             `json:"createdAt"`
+	UpdatedAt        msql.NullTime         `json:"updatedAt"`
+
+	// The host of the endpoint of the subscription, which tells the browser
+	// it belongs to (fcm.googleapis.com for Chrome, for instance).
+	PushService string `json:"pushService"`
+
+	// Current is true if the subscription is of the session of the request.
+	Current bool `json:"current"`
+
+	rawPushSubscription string // raw json string
+	rawPreferences      []byte
+}
+
+var selectWebPushSubscriptionCols = []string{
+	"id",
+	"session_id",
+	"user_id",
+	"push_subscription",
+	"preferences",
+	"created_at",
+	"updated_at",
+}
+
+// getWebPushSubscriptions returns the web push subscriptions selected by
+// where. Those of session are marked Current.
+func getWebPushSubscriptions(ctx context.Context, db *sql.DB, session string, where string, args ...any) ([]*WebPushSubscription, error) {
+	query := msql.BuildSelectQuery("web_push_subscriptions", selectWebPushSubscriptionCols, nil, where)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []*WebPushSubscription
+	for rows.Next() {
+		sub := &WebPushSubscription{}
+		if err := rows.Scan(&sub.ID, &sub.SessionID, &sub.UserID, &sub.rawPushSubscription, &sub.rawPreferences, &sub.CreatedAt, &sub.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(sub.rawPushSubscription), &sub.PushSubscription); err != nil {
+			return nil, err
+		}
+		if sub.rawPreferences != nil {
+			if err := json.Unmarshal(sub.rawPreferences, &sub.Preferences); err != nil {
+				return nil, err
+			}
+		}
+		if u, err := url.Parse(sub.PushSubscription.Endpoint); err == nil {
+			sub.PushService = u.Hostname()
+		}
+		sub.Current = session != "" && sub.SessionID == session
+		subs = append(subs, sub)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// validateWebPushSubscription checks that s is a subscription that messages
+// can be sent to.
+func validateWebPushSubscription(s *webpush.Subscription) error {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" || len(s.Endpoint) > 2048 {
+		return errInvalidWebPushSubscription
+	}
+	if s.Keys.Auth == "" || s.Keys.P256dh == "" {
+		return errInvalidWebPushSubscription
+	}
+	return nil
+}
+
+// SaveWebPushSubscription adds an entry into web_push_notifications table. If
+// there's a collision (a duplicate for sessionID), it updates the matching row.
+// It is safe to call this function repeatedly with the same arguments.
+func SaveWebPushSubscription(ctx context.Context, db *sql.DB, sessionID string, user uid.ID, s webpush.Subscription) error {
+	if err := validateWebPushSubscription(&s); err != nil {
+		return err
+	}
+	rawJSON, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `INSERT INTO web_push_subscriptions (session_id, user_id, push_subscription)
+		VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE push_subscription = ?, updated_at = CURRENT_TIMESTAMP()`,
+		sessionID, user, rawJSON, rawJSON)
+
+	return err
+}
+
+// DeleteWebPushSuscription deletes the Push Subscription object associated with
+// sessionID (if there is one).
+//
+// Make sure to call this function before logging out a user.
+func DeleteWebPushSubscription(ctx context.Context, db *sql.DB, sessionID string) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM web_push_subscriptions WHERE session_id = ?", sessionID)
+	return err
+}
+
+// GetWebPushSubscriptions returns all the web push subscriptions of user. The
+// one of session, if any, is marked Current.
+func GetWebPushSubscriptions(ctx context.Context, db *sql.DB, user uid.ID, session string) ([]*WebPushSubscription, error) {
+	subs, err := getWebPushSubscriptions(ctx, db, session, "WHERE user_id = ? ORDER BY id", user)
+	if err != nil {
+		return nil, err
+	}
+	if subs == nil {
+		subs = []*WebPushSubscription{} // for the json "[]" output
+	}
+	return subs, nil
+}
+
+// GetWebPushSubscription returns the web push subscription with id that
+// belongs to user.
+func GetWebPushSubscription(ctx context.Context, db *sql.DB, user uid.ID, id int, session string) (*WebPushSubscription, error) {
+	subs, err := getWebPushSubscriptions(ctx, db, session, "WHERE id = ? AND user_id = ?", id, user)
+	if err != nil {
+		return nil, err
+	}
+	if len(subs) == 0 {
+		return nil, errWebPushSubscriptionNotFound
+	}
+	return subs[0], nil
+}
+
+// UpdatePreferences saves s.Preferences to the database.
+func (s *WebPushSubscription) UpdatePreferences(ctx context.Context, db *sql.DB) error {
+	if err := s.Preferences.validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(s.Preferences)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "UPDATE web_push_subscriptions SET preferences = ?, updated_at = CURRENT_TIMESTAMP() WHERE id = ?", data, s.ID)
+	return err
+}
+
+// DeleteUserWebPushSubscription deletes the web push subscription with id
+// that belongs to user.
+func DeleteUserWebPushSubscription(ctx context.Context, db *sql.DB, user uid.ID, id int) error {
+	res, err := db.ExecContext(ctx, "DELETE FROM web_push_subscriptions WHERE id = ? AND user_id = ?", id, user)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errWebPushSubscriptionNotFound
+	}
+	return err
+}
+
+type webPushDeliverPayload struct {
+	User uid.ID `json:"user"`
+}
+
+type webPushSendPayload struct {
+	Subscription int `json:"subscription"`
+	Notification int `json:"notification"`
+	Count        int `json:"count"` // The number of notifications in the batch.
+}
+
+// webPushMessage is the payload of web push messages. The service worker
+// fetches the notification with ID to show it.
+type webPushMessage struct {
+	ID        int              `json:"id"`
+	Type      NotificationType `json:"type"`
+	Count     int              `json:"count"` // The number of notifications it stands for.
+	CreatedAt time.Time        `json:"createdAt"`
+}
+
+// queueWebPush adds the notification with id, of type t, to the web push
+// queue of user, and queues a job that delivers the notifications in the
+// queue. It does nothing if web push notifications are disabled, if t is
+// NotificationTypeUpvote (upvotes are not pushed, as with mobile push), or if
+// the user has no web push subscriptions. Users mute the other types per
+// subscription (see PushDevicePreferences).
+func queueWebPush(ctx context.Context, db *sql.DB, user uid.ID, id int, t NotificationType) error {
+	if webPushOptions() == nil || t == NotificationTypeUpvote {
+		return nil
+	}
+
+	var subscribed bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM web_push_subscriptions WHERE user_id = ?)", user).Scan(&subscribed); err != nil {
+		return err
+	}
+	if !subscribed {
+		return nil
+	}
+
+	if _, err := db.ExecContext(ctx, "INSERT IGNORE INTO web_push_queue (user_id, notification_id) VALUES (?, ?)", user, id); err != nil {
+		return err
+	}
+	// Every notification queues a job, but only the first job of a batch
+	// finds the queue of the user non-empty.
+	return queueJob(db, webPushJobDeliver, webPushDeliverPayload{User: user}, time.Now().Add(webPushBatchDelay))
+}
+
+// runWebPushDeliveryJob takes the notifications in the web push queue of a
+// user and queues a message, of the latest of those the subscription allows,
+// to each subscription of the user.
+func runWebPushDeliveryJob(ctx context.Context, db *sql.DB, payload json.RawMessage) error {
+	var p webPushDeliverPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+
+	var ids []any
+	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, "SELECT notification_id FROM web_push_queue WHERE user_id = ? FOR UPDATE", p.User)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM web_push_queue WHERE user_id = ? AND notification_id IN "+msql.InClauseQuestionMarks(len(ids)), append([]any{p.User}, ids...)...)
+		return err
+	})
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+
+	// Notifications that were seen (or deleted) in the meantime are not
+	// pushed.
+	rows, err := db.QueryContext(ctx, "SELECT id, type FROM notifications WHERE seen = FALSE AND id IN "+msql.InClauseQuestionMarks(len(ids))+" ORDER BY id DESC", ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var batch []webPushMessage
+	for rows.Next() {
+		var m webPushMessage
+		if err := rows.Scan(&m.ID, &m.Type); err != nil {
+			return err
+		}
+		batch = append(batch, m)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	subs, err := getWebPushSubscriptions(ctx, db, "", "WHERE user_id = ?", p.User)
+	if err != nil {
+		return err
+	}
+	for _, sub := range subs {
+		latest, count := batchForSubscription(batch, &sub.Preferences)
+		if count == 0 {
+			continue
+		}
+		if err := queueJob(db, webPushJobSend, webPushSendPayload{
+			Subscription: sub.ID,
+			Notification: latest,
+			Count:        count,
+		}, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batchForSubscription returns the ID of the latest notification of batch
+// (which is ordered latest first) that prefs allow, and the number of
+// notifications of batch that prefs allow.
+func batchForSubscription(batch []webPushMessage, prefs *PushDevicePreferences) (latest, count int) {
+	for _, m := range batch {
+		if !prefs.Allows(m.Type) {
+			continue
+		}
+		if count == 0 {
+			latest = m.ID
+		}
+		count++
+	}
+	return
+}
+
+// runWebPushSendJob sends a message to a subscription. Subscriptions that are
+// gone are deleted, and messages that fail for reasons that may pass are
+// retried (by returning an error).
+func runWebPushSendJob(ctx context.Context, db *sql.DB, payload json.RawMessage) error {
+	options := webPushOptions()
+	if options == nil {
+		return nil
+	}
+	var p webPushSendPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+
+	subs, err := getWebPushSubscriptions(ctx, db, "", "WHERE id = ?", p.Subscription)
+	if err != nil || len(subs) == 0 { // Unsubscribed in the meantime.
+		return err
+	}
+	sub := subs[0]
+
+	m := webPushMessage{ID: p.Notification, Count: p.Count}
+	var seen bool
+	row := db.QueryRowContext(ctx, "SELECT type, seen, created_at FROM notifications WHERE id = ?", p.Notification)
+	if err := row.Scan(&m.Type, &seen, &m.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	if seen {
+		return nil
+	}
+
+	options.Topic = strconv.Itoa(m.ID) // For collapsing messages of the same notification.
+	err = sendWebPush(ctx, &sub.PushSubscription, &m, options)
+	if errors.Is(err, errWebPushSubscriptionGone) {
+		if _, err := db.ExecContext(ctx, "DELETE FROM web_push_subscriptions WHERE id = ?", sub.ID); err != nil {
+			logging.FromContext(ctx).Error("Error deleting expired web push subscription", "subscription", sub.ID, "error", err)
+		}
+		return nil
+	}
+	var serr *webPushStatusError
+	if errors.As(err, &serr) && !serr.temporary() {
+		logging.FromContext(ctx).Warn("Web push message rejected", "subscription", sub.ID, "error", err)
+		return nil
+	}
+	return err
+}
+
+// errWebPushSubscriptionGone is returned by sendWebPush if the push service
+// reports that the subscription expired, or that the user unsubscribed.
+var errWebPushSubscriptionGone = errors.New("web push subscription is gone")
+
+// webPushStatusError is the error of a message the push service did not
+// accept.
+type webPushStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *webPushStatusError) Error() string {
+	return fmt.Sprintf("push service responded %d: %s", e.StatusCode, e.Body)
+}
+
+// temporary reports whether the message may be accepted if it's sent again.
+func (e *webPushStatusError) temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// sendWebPush sends m to sub.
+func sendWebPush(ctx context.Context, sub *webpush.Subscription, m *webPushMessage, options *webpush.Options) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	res, err := webpush.SendNotificationWithContext(ctx, data, sub, options)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		return errWebPushSubscriptionGone
+	case res.StatusCode >= 400:
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return &webPushStatusError{StatusCode: res.StatusCode, Body: string(body)}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package core
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/SherClockHolmes/webpush-go"
+)
+
+// newWebPushEndpoint returns a push service that responds to messages with
+// status, and the subscription of a browser to it. The messages it gets are
+// sent to the channel returned.
+func newWebPushEndpoint(t *testing.T, status int) (*webpush.Subscription, <-chan *http.Request) {
+	requests := make(chan *http.Request, 10)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	prevClient := webPushClient
+	webPushClient = srv.Client()
+	t.Cleanup(func() { webPushClient = prevClient })
+
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	return &webpush.Subscription{
+		Endpoint: srv.URL + "/push/abc",
+		Keys: webpush.Keys{
+			P256dh: base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+			Auth:   base64.RawURLEncoding.EncodeToString(auth),
+		},
+	}, requests
+}
+
+// enableWebPush enables web push notifications, with new VAPID keys, for the
+// duration of the test.
+func enableWebPush(t *testing.T) {
+	private, public, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	EnablePushNotifications(&VAPIDKeys{Public: public, Private: private}, "admin@example.com")
+	t.Cleanup(func() {
+		pushMutex.Lock()
+		pushNotifsEnabled = false
+		pushMutex.Unlock()
+	})
+}
+
+func TestSendWebPush(t *testing.T) {
+	enableWebPush(t)
+	tests := []struct {
+		status    int
+		err       error
+		temporary bool
+	}{
+		{http.StatusCreated, nil, false},
+		{http.StatusGone, errWebPushSubscriptionGone, false},
+		{http.StatusNotFound, errWebPushSubscriptionGone, false},
+		{http.StatusTooManyRequests, nil, true},
+		{http.StatusBadGateway, nil, true},
+		{http.StatusBadRequest, nil, false},
+	}
+	for _, test := range tests {
+		sub, requests := newWebPushEndpoint(t, test.status)
+		options := webPushOptions()
+		options.Topic = "12"
+		err := sendWebPush(context.Background(), sub, &webPushMessage{ID: 12, Type: NotificationTypeCommentReply, Count: 2}, options)
+
+		var serr *webPushStatusError
+		switch {
+		case test.status < 400:
+			if err != nil {
+				t.Errorf("status %d: sendWebPush returned %v", test.status, err)
+			}
+		case test.err != nil:
+			if !errors.Is(err, test.err) {
+				t.Errorf("status %d: sendWebPush returned %v, want %v", test.status, err, test.err)
+			}
+		case !errors.As(err, &serr) || serr.temporary() != test.temporary:
+			t.Errorf("status %d: sendWebPush returned %v, want a status error (temporary: %v)", test.status, err, test.temporary)
+		}
+
+		r := <-requests
+		if r.Header.Get("TTL") != "86400" || r.Header.Get("Topic") != "12" || r.Header.Get("Content-Encoding") != "aes128gcm" {
+			t.Errorf("message has headers %v", r.Header)
+		}
+	}
+}
+
+func TestBatchForSubscription(t *testing.T) {
+	batch := []webPushMessage{ // Latest first.
+		{ID: 5, Type: NotificationTypeModAdd},
+		{ID: 4, Type: NotificationTypeCommentReply},
+		{ID: 2, Type: NotificationTypeNewComment},
+	}
+	tests := []struct {
+		prefs         PushDevicePreferences
+		latest, count int
+	}{
+		{PushDevicePreferences{}, 5, 3},
+		{PushDevicePreferences{MutedTypes: []NotificationType{NotificationTypeModAdd}}, 4, 2},
+		{PushDevicePreferences{MutedTypes: []NotificationType{NotificationTypeModAdd, NotificationTypeCommentReply, NotificationTypeNewComment}}, 0, 0},
+		{PushDevicePreferences{Disabled: true}, 0, 0},
+	}
+	for i, test := range tests {
+		if latest, count := batchForSubscription(batch, &test.prefs); latest != test.latest || count != test.count {
+			t.Errorf("test %d: batchForSubscription = %d, %d, want %d, %d", i, latest, count, test.latest, test.count)
+		}
+	}
+}
+
+func TestWebPushDelivery(t *testing.T) {
+	h := newHarness(t)
+	db := h.db()
+	ctx := h.ctx
+
+	user := h.newUser(db, "webpushed", false)
+	sub, requests := newWebPushEndpoint(t, http.StatusCreated)
+	if err := SaveWebPushSubscription(ctx, db, "session-1", user.ID, *sub); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveWebPushSubscription(ctx, db, "session-2", user.ID, webpush.Subscription{Endpoint: "http://example.com"}); err != errInvalidWebPushSubscription {
+		t.Errorf("saving an invalid subscription returned %v", err)
+	}
+	subs, err := GetWebPushSubscriptions(ctx, db, user.ID, "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 1 || !subs[0].Current || subs[0].PushService != "127.0.0.1" {
+		t.Fatalf("GetWebPushSubscriptions = %+v", subs)
+	}
+
+	// Notifications created while web push is disabled are not queued.
+	for i := 0; i < 3; i++ {
+		if err := CreateNotification(ctx, db, user.ID, NotificationTypeModAdd, &NotificationModAdd{CommunityName: "pics", AddedBy: "admin"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var ids []int
+	rows, err := db.QueryContext(ctx, "SELECT id FROM notifications WHERE user_id = ? ORDER BY id", user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) != 3 {
+		t.Fatalf("got notifications %v", ids)
+	}
+	var queued int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM web_push_queue WHERE user_id = ?", user.ID).Scan(&queued); err != nil || queued != 0 {
+		t.Fatalf("queued %d notifications (%v) with web push disabled", queued, err)
+	}
+
+	enableWebPush(t)
+	if _, err := db.ExecContext(ctx, "UPDATE notifications SET seen = TRUE WHERE id = ?", ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if _, err := db.ExecContext(ctx, "INSERT INTO web_push_queue (user_id, notification_id) VALUES (?, ?)", user.ID, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	payload := []byte(`{"user":"` + user.ID.String() + `"}`)
+	if err := runWebPushDeliveryJob(ctx, db, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	// One message, of the latest unseen notification, for the batch.
+	select {
+	case r := <-requests:
+		if r.Header.Get("Topic") != strconv.Itoa(ids[1]) {
+			t.Errorf("message has topic %q, want %d", r.Header.Get("Topic"), ids[1])
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("no message was sent")
+	}
+	if err := runWebPushDeliveryJob(ctx, db, payload); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-requests:
+		t.Error("a message was sent for an empty queue")
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	if err := DeleteUserWebPushSubscription(ctx, db, user.ID, subs[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteUserWebPushSubscription(ctx, db, user.ID, subs[0].ID); err != errWebPushSubscriptionNotFound {
+		t.Errorf("deleting a deleted subscription returned %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+// Package bench runs workloads, concurrently, and reports the latencies of
+// their operations.
+package bench
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Op is an operation of a workload. i is the number of the operation, from 0.
+type Op func(ctx context.Context, i int) error
+
+// Result is the result of a run of a workload.
+type Result struct {
+	Name      string
+	N         int // Operations run.
+	Errors    int // Operations that failed.
+	FirstErr  error
+	Elapsed   time.Duration // Of the whole run.
+	Mean      time.Duration
+	P50       time.Duration
+	P90       time.Duration
+	P99       time.Duration
+	Max       time.Duration
+	latencies []time.Duration
+}
+
+// OpsPerSecond returns the throughput of the run.
+func (r *Result) OpsPerSecond() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.N) / r.Elapsed.Seconds()
+}
+
+// Run runs n operations op, concurrency at a time, and returns their
+// latencies. It stops early if ctx is done.
+func Run(ctx context.Context, name string, n, concurrency int, op Op) *Result {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	r := &Result{Name: name, latencies: make([]time.Duration, 0, n)}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		next = make(chan int)
+	)
+	start := time.Now()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				t := time.Now()
+				err := op(ctx, i)
+				d := time.Since(t)
+				mu.Lock()
+				r.latencies = append(r.latencies, d)
+				if err != nil {
+					if r.Errors == 0 {
+						r.FirstErr = err
+					}
+					r.Errors++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	r.Elapsed = time.Since(start)
+	r.summarize()
+	return r
+}
+
+// summarize computes the statistics of the latencies of r.
+func (r *Result) summarize() {
+	r.N = len(r.latencies)
+	if r.N == 0 {
+		return
+	}
+	slices.Sort(r.latencies)
+	var sum time.Duration
+	for _, d := range r.latencies {
+		sum += d
+	}
+	r.Mean = sum / time.Duration(r.N)
+	r.P50 = Percentile(r.latencies, 50)
+	r.P90 = Percentile(r.latencies, 90)
+	r.P99 = Percentile(r.latencies, 99)
+	r.Max = r.latencies[r.N-1]
+}
+
+// Percentile returns the pth percentile (by the nearest-rank method) of
+// sorted, which must be sorted in increasing order.
+func Percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.999999) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+// WriteReport writes the results as a table to w.
+func WriteReport(w io.Writer, results []*Result) error {
+	if _, err := fmt.Fprintf(w, "%-28s %7s %7s %9s %10s %10s %10s %10s\n", "workload", "ops", "errors", "ops/s", "p50", "p90", "p99", "max"); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if _, err := fmt.Fprintf(w, "%-28s %7d %7d %9.1f %10s %10s %10s %10s\n", r.Name, r.N, r.Errors, r.OpsPerSecond(),
+			round(r.P50), round(r.P90), round(r.P99), round(r.Max)); err != nil {
+			return err
+		}
+	}
+	for _, r := range results {
+		if r.FirstErr != nil {
+			if _, err := fmt.Fprintf(w, "%s: first error: %v\n", r.Name, r.FirstErr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond)
+}
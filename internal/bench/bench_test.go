@@ -0,0 +1,67 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i))
+	}
+	for _, c := range []struct {
+		p    float64
+		want time.Duration
+	}{{50, 50}, {90, 90}, {99, 99}, {100, 100}, {0, 1}} {
+		if got := Percentile(sorted, c.p); got != c.want {
+			t.Errorf("Percentile(%v) = %v, want %v", c.p, got, c.want)
+		}
+	}
+	if got := Percentile([]time.Duration{7}, 99); got != 7 {
+		t.Errorf("Percentile of one = %v, want 7", got)
+	}
+	if got := Percentile(nil, 50); got != 0 {
+		t.Errorf("Percentile of none = %v, want 0", got)
+	}
+}
+
+func TestRun(t *testing.T) {
+	var ran atomic.Int64
+	errOdd := errors.New("odd")
+	r := Run(context.Background(), "test", 20, 4, func(ctx context.Context, i int) error {
+		ran.Add(1)
+		if i%2 == 1 {
+			return errOdd
+		}
+		return nil
+	})
+	if ran.Load() != 20 || r.N != 20 {
+		t.Errorf("%d operations run, %d reported; want 20", ran.Load(), r.N)
+	}
+	if r.Errors != 10 || r.FirstErr != errOdd {
+		t.Errorf("errors = %d (first: %v), want 10 (first: %v)", r.Errors, r.FirstErr, errOdd)
+	}
+	if r.P50 > r.P99 || r.P99 > r.Max {
+		t.Errorf("percentiles out of order: p50 %v, p99 %v, max %v", r.P50, r.P99, r.Max)
+	}
+
+	var b bytes.Buffer
+	if err := WriteReport(&b, []*Result{r}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "test") || !strings.Contains(b.String(), "first error: odd") {
+		t.Errorf("report is missing the workload or its error:\n%s", b.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if r := Run(ctx, "canceled", 10, 1, func(context.Context, int) error { return nil }); r.N != 0 {
+		t.Errorf("%d operations run after ctx is done", r.N)
+	}
+}
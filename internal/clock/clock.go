@@ -0,0 +1,113 @@
+// Package clock provides clocks: the real one, and a fake one for tests, the
+// time of which only changes when it's told to.
+package clock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock tells the time.
+type Clock interface {
+	Now() time.Time
+
+	// After returns a channel on which the time is sent after d.
+	After(d time.Duration) <-chan time.Time
+}
+
+// Real is the real clock.
+type Real struct{}
+
+func (Real) Now() time.Time                         { return time.Now() }
+func (Real) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Fake is a clock the time of which is set by Set and Advance. It's safe for
+// concurrent use.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// NewFake returns a fake clock set to now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the time of c.
+func (c *Fake) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel on which the time is sent once c is advanced by d.
+func (c *Fake) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &fakeWaiter{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- c.now
+		return w.c
+	}
+	c.waiters = append(c.waiters, w)
+	return w.c
+}
+
+// Waiters returns the number of channels returned by After that are waiting
+// for c to be advanced. Tests use it to tell when the code under test is
+// sleeping.
+func (c *Fake) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// Advance moves c forward by d.
+func (c *Fake) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.set(c.now.Add(d))
+	c.mu.Unlock()
+}
+
+// Set sets the time of c to t.
+func (c *Fake) Set(t time.Time) {
+	c.mu.Lock()
+	c.set(t)
+	c.mu.Unlock()
+}
+
+// set sets the time of c, and wakes the waiters that are due. c.mu must be
+// held.
+func (c *Fake) set(t time.Time) {
+	c.now = t
+	waiting := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(t) {
+			waiting = append(waiting, w)
+			continue
+		}
+		w.c <- t
+	}
+	c.waiters = waiting
+}
+
+// Sleep waits until d passes by c, or until ctx is done, in which case it
+// returns the error of ctx.
+func Sleep(ctx context.Context, c Clock, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.After(d):
+		return nil
+	}
+}
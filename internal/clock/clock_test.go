@@ -0,0 +1,61 @@
+package clock
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+	if !c.Now().Equal(start) {
+		t.Fatalf("Now = %v, want %v", c.Now(), start)
+	}
+
+	after := c.After(time.Minute)
+	c.Advance(time.Second * 30)
+	select {
+	case <-after:
+		t.Fatal("After fired before its time")
+	default:
+	}
+	c.Advance(time.Second * 30)
+	select {
+	case at := <-after:
+		if !at.Equal(start.Add(time.Minute)) {
+			t.Errorf("After sent %v, want %v", at, start.Add(time.Minute))
+		}
+	default:
+		t.Fatal("After did not fire")
+	}
+
+	select {
+	case <-c.After(0):
+	default:
+		t.Error("After(0) did not fire right away")
+	}
+}
+
+func TestSleep(t *testing.T) {
+	c := NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	done := make(chan error, 1)
+	go func() { done <- Sleep(context.Background(), c, time.Hour) }()
+	for c.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	c.Advance(time.Hour)
+	if err := <-done; err != nil {
+		t.Errorf("Sleep = %v, want nil", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { done <- Sleep(ctx, c, time.Hour) }()
+	for c.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Sleep of a canceled context = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,316 @@
+// Package dbtest provides a MariaDB database, with the migrations of the site
+// applied, to tests that need one.
+//
+// Tests that use it (see Open) are skipped unless the DISCUIT_TEST_DB
+// environment variable is set to the DSN of a MariaDB server, such as
+// "user:password@tcp(localhost:3306)/", on which the user may create
+// databases. A database is created for the tests of each package, and dropped
+// once they're done (see Main).
+//
+// Each test runs inside a transaction, which is rolled back once the test is
+// done, so that tests leave the database as they found it. All connections of
+// the database that Open returns share the one connection of the
+// transaction, and the transactions the site begins on it are savepoints.
+// Tests that use the database must not be run in parallel.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/migrations"
+	"github.com/go-sql-driver/mysql"
+)
+
+// EnvVar is the environment variable with the DSN of the server of test
+// databases.
+const EnvVar = "DISCUIT_TEST_DB"
+
+var (
+	once      sync.Once
+	connector driver.Connector // Of the test database.
+	dbName    string
+	server    *sql.DB // Connected to no database in particular.
+	setErr    error
+
+	mu  sync.Mutex
+	dbs = make(map[testing.TB]*sql.DB) // The databases of running tests.
+)
+
+// Open returns the test database, creating it on first use, as seen from
+// inside the transaction of t, which is rolled back once t is done. It skips
+// t if no server of test databases is set (see EnvVar).
+func Open(t testing.TB) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv(EnvVar)
+	if dsn == "" {
+		t.Skipf("%s is not set", EnvVar)
+	}
+	once.Do(func() { setErr = setup(dsn) })
+	if setErr != nil {
+		t.Fatalf("dbtest: %v", setErr)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if db := dbs[t]; db != nil {
+		return db
+	}
+	conn, err := connector.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("dbtest: %v", err)
+	}
+	tc := &txConn{conn: conn}
+	if err := tc.exec("START TRANSACTION"); err != nil {
+		conn.Close()
+		t.Fatalf("dbtest: %v", err)
+	}
+	db := sql.OpenDB(&txConnector{tc: tc})
+	dbs[t] = db
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(dbs, t)
+		mu.Unlock()
+		db.Close()
+		if err := tc.exec("ROLLBACK"); err != nil {
+			t.Errorf("dbtest: %v", err)
+		}
+		tc.conn.Close()
+	})
+	return db
+}
+
+// Main runs the tests of m, drops the test database (if it was created), and
+// returns the exit code of the tests. Call it from TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(dbtest.Main(m))
+//	}
+func Main(m *testing.M) int {
+	code := m.Run()
+	if server != nil {
+		if _, err := server.Exec("DROP DATABASE IF EXISTS " + dbName); err != nil {
+			fmt.Fprintf(os.Stderr, "dbtest: dropping %s: %v\n", dbName, err)
+		}
+		server.Close()
+	}
+	return code
+}
+
+// setup creates the test database, on the server of dsn, and migrates it.
+func setup(dsn string) error {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return err
+	}
+	cfg.ParseTime = true
+	cfg.Collation = "utf8mb4_unicode_ci"
+	cfg.Loc = time.Local
+
+	cfg.DBName = ""
+	if server, err = sql.Open("mysql", cfg.FormatDSN()); err != nil {
+		return err
+	}
+	dbName = fmt.Sprintf("discuit_test_%d_%d", os.Getpid(), time.Now().UnixNano()%1e6)
+	if _, err := server.Exec("CREATE DATABASE " + dbName + " CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci"); err != nil {
+		server.Close()
+		server = nil
+		return err
+	}
+
+	cfg.DBName = dbName
+	m, err := migrations.New(cfg.FormatDSN(), nil)
+	if err != nil {
+		return err
+	}
+	if err := m.Up(0); err != nil {
+		return fmt.Errorf("migrating: %w", err)
+	}
+	if err := m.Close(); err != nil {
+		return fmt.Errorf("closing migrations: %w", err)
+	}
+
+	connector, err = mysql.NewConnector(cfg)
+	return err
+}
+
+// txConnector is a driver.Connector whose connections all share tc.
+type txConnector struct {
+	tc *txConn
+}
+
+func (c *txConnector) Connect(context.Context) (driver.Conn, error) { return c.tc, nil }
+func (c *txConnector) Driver() driver.Driver                        { return nil }
+
+// txConn is a connection, inside the transaction of a test, that may be used
+// by more than one connection of a sql.DB. Its calls are serialized, and the
+// rows of queries are read whole, so that those of one connection are not
+// interleaved with those of another. Transactions begun on it are savepoints
+// of the transaction of the test.
+type txConn struct {
+	mu         sync.Mutex
+	conn       driver.Conn
+	savepoints int // Number of savepoints created.
+}
+
+// exec runs query, which has no arguments. tc.mu must not be held.
+func (tc *txConn) exec(query string) error {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	_, err := tc.conn.(driver.ExecerContext).ExecContext(context.Background(), query, nil)
+	return err
+}
+
+func (tc *txConn) Prepare(query string) (driver.Stmt, error) {
+	return tc.PrepareContext(context.Background(), query)
+}
+
+func (tc *txConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	stmt, err := tc.conn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &txStmt{tc: tc, stmt: stmt}, nil
+}
+
+// Close does nothing, since the connection is closed once the test is done.
+func (tc *txConn) Close() error { return nil }
+
+func (tc *txConn) Begin() (driver.Tx, error) {
+	return tc.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (tc *txConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	tc.mu.Lock()
+	tc.savepoints++
+	name := fmt.Sprintf("dbtest_%d", tc.savepoints)
+	tc.mu.Unlock()
+	if err := tc.exec("SAVEPOINT " + name); err != nil {
+		return nil, err
+	}
+	return &txSavepoint{tc: tc, name: name}, nil
+}
+
+func (tc *txConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+func (tc *txConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	rows, err := tc.conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return readRows(rows)
+}
+
+// txSavepoint is a transaction begun on a txConn.
+type txSavepoint struct {
+	tc   *txConn
+	name string
+}
+
+func (tx *txSavepoint) Commit() error {
+	return tx.tc.exec("RELEASE SAVEPOINT " + tx.name)
+}
+
+func (tx *txSavepoint) Rollback() error {
+	return tx.tc.exec("ROLLBACK TO SAVEPOINT " + tx.name)
+}
+
+// txStmt is a prepared statement of a txConn.
+type txStmt struct {
+	tc   *txConn
+	stmt driver.Stmt
+}
+
+func (s *txStmt) Close() error {
+	s.tc.mu.Lock()
+	defer s.tc.mu.Unlock()
+	return s.stmt.Close()
+}
+
+func (s *txStmt) NumInput() int { return s.stmt.NumInput() }
+
+func (s *txStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.tc.mu.Lock()
+	defer s.tc.mu.Unlock()
+	return s.stmt.Exec(args)
+}
+
+func (s *txStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.tc.mu.Lock()
+	defer s.tc.mu.Unlock()
+	rows, err := s.stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+	return readRows(rows)
+}
+
+func (s *txStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	s.tc.mu.Lock()
+	defer s.tc.mu.Unlock()
+	return s.stmt.(driver.StmtExecContext).ExecContext(ctx, args)
+}
+
+func (s *txStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	s.tc.mu.Lock()
+	defer s.tc.mu.Unlock()
+	rows, err := s.stmt.(driver.StmtQueryContext).QueryContext(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return readRows(rows)
+}
+
+// bufferedRows are rows read whole.
+type bufferedRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+// readRows reads rows whole, and closes them.
+func readRows(rows driver.Rows) (*bufferedRows, error) {
+	defer rows.Close()
+	br := &bufferedRows{columns: rows.Columns()}
+	for {
+		row := make([]driver.Value, len(br.columns))
+		if err := rows.Next(row); err == io.EOF {
+			return br, nil
+		} else if err != nil {
+			return nil, err
+		}
+		for i, v := range row {
+			if b, ok := v.([]byte); ok {
+				// Drivers may reuse the buffer.
+				row[i] = append([]byte(nil), b...)
+			}
+		}
+		br.rows = append(br.rows, row)
+	}
+}
+
+func (br *bufferedRows) Columns() []string { return br.columns }
+func (br *bufferedRows) Close() error      { return nil }
+
+func (br *bufferedRows) Next(dest []driver.Value) error {
+	if len(br.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, br.rows[0])
+	br.rows = br.rows[1:]
+	return nil
+}
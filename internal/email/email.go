@@ -0,0 +1,142 @@
+// Package email sends emails, through an SMTP server or Amazon SES, and
+// renders them from templates.
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Message is an email to a single recipient.
+type Message struct {
+	From    string // An address, like "Discuit <noreply@discuit.org>".
+	To      string
+	Subject string
+
+	// The plain text and the HTML versions of the body. Text is required; if
+	// HTML is not empty, the message is sent with both versions (as a
+	// multipart/alternative message).
+	Text string
+	HTML string
+
+	// Additional headers, like List-Unsubscribe.
+	Headers map[string]string
+}
+
+// A Sender sends emails.
+type Sender interface {
+	Send(ctx context.Context, m *Message) error
+}
+
+// validate checks that the addresses of m are valid, and returns them.
+func (m *Message) validate() (from, to *mail.Address, err error) {
+	if from, err = mail.ParseAddress(m.From); err != nil {
+		return nil, nil, fmt.Errorf("email: invalid from address %q: %w", m.From, err)
+	}
+	if to, err = mail.ParseAddress(m.To); err != nil {
+		return nil, nil, fmt.Errorf("email: invalid to address %q: %w", m.To, err)
+	}
+	if m.Text == "" {
+		return nil, nil, errors.New("email: message has no text")
+	}
+	for k, v := range m.Headers {
+		if strings.ContainsAny(k+v, "\r\n") {
+			return nil, nil, fmt.Errorf("email: invalid header %q", k)
+		}
+	}
+	return from, to, nil
+}
+
+// Bytes returns m in the Internet Message Format (RFC 5322), with its bodies
+// quoted-printable encoded.
+func (m *Message) Bytes() ([]byte, error) {
+	from, to, err := m.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	writeHeader := func(k, v string) {
+		b.WriteString(textproto.CanonicalMIMEHeaderKey(k))
+		b.WriteString(": ")
+		b.WriteString(v)
+		b.WriteString("\r\n")
+	}
+	writeHeader("From", from.String())
+	writeHeader("To", to.String())
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	writeHeader("Date", time.Now().Format(time.RFC1123Z))
+	writeHeader("Message-Id", "<"+randomHex(16)+"@"+addressDomain(from.Address)+">")
+	writeHeader("MIME-Version", "1.0")
+	keys := make([]string, 0, len(m.Headers))
+	for k := range m.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeHeader(k, m.Headers[k])
+	}
+
+	if m.HTML == "" {
+		writeHeader("Content-Type", "text/plain; charset=utf-8")
+		writeHeader("Content-Transfer-Encoding", "quoted-printable")
+		b.WriteString("\r\n")
+		if err := writeQuotedPrintable(&b, m.Text); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+
+	boundary := randomHex(16)
+	writeHeader("Content-Type", `multipart/alternative; boundary="`+boundary+`"`)
+	b.WriteString("\r\n")
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		b.WriteString("--" + boundary + "\r\n")
+		writeHeader("Content-Type", part.contentType)
+		writeHeader("Content-Transfer-Encoding", "quoted-printable")
+		b.WriteString("\r\n")
+		if err := writeQuotedPrintable(&b, part.body); err != nil {
+			return nil, err
+		}
+		b.WriteString("\r\n")
+	}
+	b.WriteString("--" + boundary + "--\r\n")
+	return b.Bytes(), nil
+}
+
+func writeQuotedPrintable(b *bytes.Buffer, s string) error {
+	w := quotedprintable.NewWriter(b) // Which writes line breaks as CRLFs.
+	if _, err := w.Write([]byte(s)); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func addressDomain(address string) string {
+	if i := strings.LastIndexByte(address, '@'); i != -1 {
+		return address[i+1:]
+	}
+	return "localhost"
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
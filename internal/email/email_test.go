@@ -0,0 +1,267 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+func testMessage() *Message {
+	return &Message{
+		From:    "Discuit <noreply@example.com>",
+		To:      "someone@example.org",
+		Subject: "Verify your email ✓",
+		Text:    "Hello,\nclick the link.",
+		HTML:    "<p>Hello,<br>click the <a href=\"https://example.com\">link</a>.</p>",
+		Headers: map[string]string{"List-Unsubscribe": "<https://example.com/unsubscribe>"},
+	}
+}
+
+// parseMessage parses data, a message with a text and an HTML part, and
+// returns its headers and the two parts.
+func parseMessage(t *testing.T, data []byte) (mail.Header, string, string) {
+	msg, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type is %q", msg.Header.Get("Content-Type"))
+	}
+	var parts []string
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := r.NextPart() // Which decodes quoted-printable.
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, string(b))
+	}
+	if len(parts) != 2 {
+		t.Fatalf("message has %d parts, want 2", len(parts))
+	}
+	return msg.Header, parts[0], parts[1]
+}
+
+func TestMessageBytes(t *testing.T) {
+	m := testMessage()
+	data, err := m.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, text, html := parseMessage(t, data)
+	subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	if err != nil || subject != m.Subject {
+		t.Errorf("Subject is %q (%v), want %q", subject, err, m.Subject)
+	}
+	if got := header.Get("List-Unsubscribe"); got != m.Headers["List-Unsubscribe"] {
+		t.Errorf("List-Unsubscribe is %q", got)
+	}
+	if want := strings.ReplaceAll(m.Text, "\n", "\r\n"); text != want {
+		t.Errorf("text is %q, want %q", text, want)
+	}
+	if html != m.HTML {
+		t.Errorf("html is %q, want %q", html, m.HTML)
+	}
+
+	m.HTML = ""
+	if data, err = m.Bytes(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "Content-Type: text/plain; charset=utf-8\r\n") {
+		t.Errorf("text only message is not text/plain:\n%s", data)
+	}
+
+	for _, bad := range []*Message{
+		{From: "nobody", To: "someone@example.org", Text: "hi"},
+		{From: "noreply@example.com", To: "someone@example.org"},
+		{From: "noreply@example.com", To: "someone@example.org", Text: "hi", Headers: map[string]string{"X-Injected": "a\r\nBcc: x@example.org"}},
+	} {
+		if _, err := bad.Bytes(); err == nil {
+			t.Errorf("%+v: no error", bad)
+		}
+	}
+}
+
+// fakeSMTPServer accepts a single connection and records the envelope and
+// the message it's sent.
+type fakeSMTPServer struct {
+	l        net.Listener
+	from, to string
+	data     chan string
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTPServer{l: l, data: make(chan string, 1)}
+	t.Cleanup(func() { l.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fakeSMTPServer) serve() {
+	conn, err := s.l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSpace(line)
+		switch verb := strings.ToUpper(strings.SplitN(cmd, " ", 2)[0]); verb {
+		case "EHLO", "HELO":
+			reply("250-localhost")
+			reply("250 8BITMIME")
+		case "MAIL":
+			s.from = cmd
+			reply("250 OK")
+		case "RCPT":
+			s.to = cmd
+			reply("250 OK")
+		case "DATA":
+			reply("354 Go ahead")
+			var b strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				b.WriteString(strings.TrimPrefix(line, "."))
+			}
+			s.data <- b.String()
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Not implemented")
+		}
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	sender := &SMTP{Addr: srv.l.Addr().String()}
+	m := testMessage()
+	if err := sender.Send(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+	data := <-srv.data
+	if srv.from != "MAIL FROM:<noreply@example.com> BODY=8BITMIME" && srv.from != "MAIL FROM:<noreply@example.com>" {
+		t.Errorf("got %q", srv.from)
+	}
+	if srv.to != "RCPT TO:<someone@example.org>" {
+		t.Errorf("got %q", srv.to)
+	}
+	if _, _, html := parseMessage(t, []byte(data)); html != m.HTML {
+		t.Errorf("html is %q, want %q", html, m.HTML)
+	}
+}
+
+func TestSES(t *testing.T) {
+	var got struct {
+		FromEmailAddress string
+		Destination      struct{ ToAddresses []string }
+		Content          struct{ Raw struct{ Data []byte } }
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/email/outbound-emails" {
+			t.Errorf("path is %s", r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); !strings.Contains(auth, "/us-east-1/ses/aws4_request") {
+			t.Errorf("Authorization is %q", auth)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		io.WriteString(w, `{"MessageId": "1"}`)
+	}))
+	defer srv.Close()
+
+	sender := &SES{
+		Region:      "us-east-1",
+		Credentials: aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider("key", "secret", "")),
+		Endpoint:    srv.URL,
+	}
+	m := testMessage()
+	if err := sender.Send(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+	if got.FromEmailAddress != `"Discuit" <noreply@example.com>` {
+		t.Errorf("FromEmailAddress is %q", got.FromEmailAddress)
+	}
+	if len(got.Destination.ToAddresses) != 1 || got.Destination.ToAddresses[0] != "<someone@example.org>" {
+		t.Errorf("ToAddresses is %q", got.Destination.ToAddresses)
+	}
+	if _, text, _ := parseMessage(t, got.Content.Raw.Data); !strings.HasPrefix(text, "Hello,") {
+		t.Errorf("text is %q", text)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	fsys := fstest.MapFS{
+		"emails/verify.txt":  {Data: []byte(`{{define "subject"}}Verify your email on {{.Site}}{{end}}Open {{.URL}} to verify.`)},
+		"emails/verify.html": {Data: []byte(`<a href="{{.URL}}">{{.Site}}</a>`)},
+		"emails/plain.txt":   {Data: []byte(`{{define "subject"}}Hi{{end}}Hello.`)},
+	}
+	tmpls, err := ParseTemplates(fsys, "emails")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := tmpls.Render("verify", map[string]string{"Site": "<Discuit>", "URL": "https://example.com/?a=1&b=2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Subject != "Verify your email on <Discuit>" {
+		t.Errorf("Subject is %q", m.Subject)
+	}
+	if m.Text != "Open https://example.com/?a=1&b=2 to verify.\n" {
+		t.Errorf("Text is %q", m.Text)
+	}
+	if m.HTML != `<a href="https://example.com/?a=1&amp;b=2">&lt;Discuit&gt;</a>` {
+		t.Errorf("HTML is %q", m.HTML)
+	}
+	if m, err = tmpls.Render("plain", nil); err != nil || m.HTML != "" {
+		t.Errorf("got %+v, %v", m, err)
+	}
+	if _, err := tmpls.Render("missing", nil); err == nil {
+		t.Error("rendered a missing template")
+	}
+
+	fsys["emails/bad.txt"] = &fstest.MapFile{Data: []byte("No subject.")}
+	if _, err := ParseTemplates(fsys, "emails"); err == nil {
+		t.Error("parsed a template without a subject")
+	}
+}
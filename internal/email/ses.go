@@ -0,0 +1,78 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// SES sends emails with the SendEmail action of the Amazon SES v2 API (as raw
+// messages, so that they're sent as Message.Bytes formats them).
+type SES struct {
+	Region      string
+	Credentials aws.CredentialsProvider
+	Client      *http.Client // If nil, http.DefaultClient is used.
+	Endpoint    string       // If empty, the regional endpoint is used.
+}
+
+func (s *SES) Send(ctx context.Context, m *Message) error {
+	from, to, err := m.validate()
+	if err != nil {
+		return err
+	}
+	data, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]any{
+		"FromEmailAddress": from.String(),
+		"Destination":      map[string]any{"ToAddresses": []string{to.String()}},
+		"Content":          map[string]any{"Raw": map[string]any{"Data": data}}, // Base64 encoded.
+	})
+	if err != nil {
+		return err
+	}
+
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://email." + s.Region + ".amazonaws.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/v2/email/outbound-emails", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	credentials, err := s.Credentials.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	payloadHash := sha256.Sum256(body)
+	if err := v4.NewSigner().SignHTTP(ctx, credentials, req, hex.EncodeToString(payloadHash[:]), "ses", s.Region, time.Now()); err != nil {
+		return err
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("email: ses: %s: %s", res.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// SMTP sends emails through an SMTP server.
+type SMTP struct {
+	// The host:port of the server. If the port is 465, the connection is
+	// TLS from the start (implicit TLS); otherwise it's upgraded with
+	// STARTTLS, if the server supports it.
+	Addr string
+
+	// If Username is not empty, the client authenticates with PLAIN auth
+	// (which net/smtp allows only over TLS, or with localhost).
+	Username string
+	Password string
+
+	// Timeout limits how long sending an email takes (if ctx has no earlier
+	// deadline). If it's zero, it's 30 seconds.
+	Timeout time.Duration
+}
+
+func (s *SMTP) Send(ctx context.Context, m *Message) error {
+	from, to, err := m.validate()
+	if err != nil {
+		return err
+	}
+	data, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+
+	host, port, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return fmt.Errorf("email: invalid smtp address %q: %w", s.Addr, err)
+	}
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = time.Second * 30
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	tlsConfig := &tls.Config{ServerName: host}
+	var conn net.Conn
+	if port == "465" {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", s.Addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", s.Addr)
+	}
+	if err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("email: %w", err)
+	}
+	defer c.Close()
+
+	if port != "465" {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("email: starttls: %w", err)
+			}
+		}
+	}
+	if s.Username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("email: smtp server doesn't support auth")
+		}
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return fmt.Errorf("email: smtp auth: %w", err)
+		}
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return fmt.Errorf("email: smtp mail: %w", err)
+	}
+	if err := c.Rcpt(to.Address); err != nil {
+		return fmt.Errorf("email: smtp rcpt: %w", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("email: smtp data: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("email: smtp data: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("email: smtp data: %w", err)
+	}
+	return c.Quit()
+}
@@ -0,0 +1,79 @@
+package email
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Templates renders messages from templates. Each message has a text
+// template, name.txt, which defines the subject of the message in a
+// "subject" template, and, optionally, an HTML template, name.html.
+type Templates struct {
+	text map[string]*texttemplate.Template
+	html map[string]*htmltemplate.Template
+}
+
+// ParseTemplates parses the templates of messages in the directory dir of
+// fsys.
+func ParseTemplates(fsys fs.FS, dir string) (*Templates, error) {
+	t := &Templates{
+		text: make(map[string]*texttemplate.Template),
+		html: make(map[string]*htmltemplate.Template),
+	}
+	files, err := fs.Glob(fsys, path.Join(dir, "*.txt"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(path.Base(file), ".txt")
+		text, err := texttemplate.ParseFS(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		if text.Lookup("subject") == nil {
+			return nil, fmt.Errorf("email: template %s has no subject", file)
+		}
+		t.text[name] = text
+
+		htmlFile := path.Join(dir, name+".html")
+		if _, err := fs.Stat(fsys, htmlFile); err == nil {
+			if t.html[name], err = htmltemplate.ParseFS(fsys, htmlFile); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return t, nil
+}
+
+// Render returns the message name rendered with data. Its From and To are
+// to be set by the caller.
+func (t *Templates) Render(name string, data any) (*Message, error) {
+	text, ok := t.text[name]
+	if !ok {
+		return nil, fmt.Errorf("email: no template named %s", name)
+	}
+	m := &Message{}
+	var b bytes.Buffer
+	if err := text.ExecuteTemplate(&b, "subject", data); err != nil {
+		return nil, err
+	}
+	m.Subject = strings.Join(strings.Fields(b.String()), " ")
+	b.Reset()
+	if err := text.Execute(&b, data); err != nil {
+		return nil, err
+	}
+	m.Text = strings.TrimSpace(b.String()) + "\n"
+	if html, ok := t.html[name]; ok {
+		b.Reset()
+		if err := html.Execute(&b, data); err != nil {
+			return nil, err
+		}
+		m.HTML = b.String()
+	}
+	return m, nil
+}
@@ -0,0 +1,189 @@
+// Package feedcache caches, in Redis, the pages of feeds (as lists of the IDs
+// of their items) and the objects the items are hydrated from.
+//
+// The pages of a feed belong to scopes (say, the site and the community of
+// the feed). Invalidating a scope (see Cache.Invalidate) makes the pages of
+// all the feeds in it stale at once: every scope has a generation, which is
+// part of the keys of its pages, and invalidating the scope increments it.
+// Stale pages are never read again, and expire in time.
+package feedcache
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Page is a cached page of a feed.
+type Page struct {
+	IDs  []string        `json:"ids"`
+	Next json.RawMessage `json:"next"` // The pagination cursor to the next page.
+}
+
+// Cache is a cache of feeds and objects. It's safe for concurrent use, by any
+// number of processes sharing a Redis server.
+type Cache struct {
+	pool      *redis.Pool
+	pageTTL   time.Duration
+	objectTTL time.Duration
+}
+
+// New returns a cache, in the Redis server of pool, of pages that are kept for
+// pageTTL and objects that are kept for objectTTL.
+func New(pool *redis.Pool, pageTTL, objectTTL time.Duration) *Cache {
+	return &Cache{pool: pool, pageTTL: pageTTL, objectTTL: objectTTL}
+}
+
+func genKey(scope string) string {
+	return "fc:gen:" + scope
+}
+
+func objectKey(kind, id string) string {
+	return "fc:obj:" + kind + ":" + id
+}
+
+// pageKey returns the Redis key of the page key in scopes, as of their
+// current generations.
+func pageKey(conn redis.Conn, key string, scopes []string) (string, error) {
+	var b strings.Builder
+	b.WriteString("fc:page:")
+	for _, scope := range scopes {
+		gen, err := redis.String(conn.Do("GET", genKey(scope)))
+		if err != nil && err != redis.ErrNil {
+			return "", err
+		}
+		if gen == "" {
+			gen = "0"
+		}
+		b.WriteString(scope + "@" + gen + ":")
+	}
+	b.WriteString(key)
+	return b.String(), nil
+}
+
+// A Slot is where a page is cached, as of the generations of its scopes when
+// it was looked up.
+type Slot string
+
+// Get returns the page key in scopes, or nil if it's not cached, and the slot
+// to put it in if it's not (see Put).
+func (c *Cache) Get(key string, scopes ...string) (*Page, Slot, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	k, err := pageKey(conn, key, scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := redis.Bytes(conn.Do("GET", k))
+	if err != nil {
+		if err == redis.ErrNil {
+			return nil, Slot(k), nil
+		}
+		return nil, "", err
+	}
+	page := &Page{}
+	if err := json.Unmarshal(data, page); err != nil {
+		return nil, Slot(k), nil // Written by an older version; as good as missing.
+	}
+	return page, Slot(k), nil
+}
+
+// Put caches page in slot, which is to be got by a Get made before the page
+// was read. If the scopes of the page were invalidated since, the page is put
+// in a stale slot, where it's never read.
+func (c *Cache) Put(slot Slot, page *Page) error {
+	data, err := json.Marshal(page)
+	if err != nil {
+		return err
+	}
+	conn := c.pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("SET", string(slot), data, "PX", c.pageTTL.Milliseconds())
+	return err
+}
+
+// Invalidate makes the pages of scopes stale.
+func (c *Cache) Invalidate(scopes ...string) error {
+	conn := c.pool.Get()
+	defer conn.Close()
+	for _, scope := range scopes {
+		if _, err := conn.Do("INCR", genKey(scope)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetObjects returns the cached objects of kind with ids, by their IDs.
+// Objects not cached are left out.
+func (c *Cache) GetObjects(kind string, ids ...string) (map[string][]byte, error) {
+	objects := make(map[string][]byte, len(ids))
+	if len(ids) == 0 {
+		return objects, nil
+	}
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	for _, id := range ids {
+		if err := conn.Send("GET", objectKey(kind, id)); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		data, err := redis.Bytes(conn.Receive())
+		if err != nil {
+			if err == redis.ErrNil {
+				continue
+			}
+			return nil, err
+		}
+		objects[id] = data
+	}
+	return objects, nil
+}
+
+// PutObjects caches objects of kind, by their IDs.
+func (c *Cache) PutObjects(kind string, objects map[string][]byte) error {
+	if len(objects) == 0 {
+		return nil
+	}
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	for id, data := range objects {
+		if err := conn.Send("SET", objectKey(kind, id), data, "PX", c.objectTTL.Milliseconds()); err != nil {
+			return err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	for range objects {
+		if _, err := conn.Receive(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteObjects removes the objects of kind with ids from the cache.
+func (c *Cache) DeleteObjects(kind string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = objectKey(kind, id)
+	}
+	_, err := conn.Do("DEL", args...)
+	return err
+}
@@ -0,0 +1,59 @@
+package feedcache
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/redistest"
+)
+
+func TestCachePages(t *testing.T) {
+	c := New(redistest.NewServer().Pool(), time.Minute, time.Minute)
+
+	page, slot, err := c.Get("hot", "site", "community:1")
+	if err != nil || page != nil {
+		t.Fatalf("Get of an empty cache = %v, %v", page, err)
+	}
+	want := &Page{IDs: []string{"a", "b"}, Next: json.RawMessage(`"b"`)}
+	if err := c.Put(slot, want); err != nil {
+		t.Fatal(err)
+	}
+	page, _, err = c.Get("hot", "site", "community:1")
+	if err != nil || page == nil || len(page.IDs) != 2 || string(page.Next) != `"b"` {
+		t.Fatalf("Get = %+v, %v; want %+v", page, err, want)
+	}
+
+	// A page put in a slot got before an invalidation is never read.
+	_, stale, _ := c.Get("latest", "site")
+	if err := c.Invalidate("community:1"); err != nil {
+		t.Fatal(err)
+	}
+	if page, _, _ := c.Get("hot", "site", "community:1"); page != nil {
+		t.Error("page of an invalidated scope is still cached")
+	}
+	if err := c.Invalidate("site"); err != nil {
+		t.Fatal(err)
+	}
+	c.Put(stale, want)
+	if page, _, _ := c.Get("latest", "site"); page != nil {
+		t.Error("page put in a stale slot was read")
+	}
+}
+
+func TestCacheObjects(t *testing.T) {
+	c := New(redistest.NewServer().Pool(), time.Minute, time.Minute)
+	if err := c.PutObjects("post", map[string][]byte{"a": []byte("A"), "b": []byte("B")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteObjects("post", "b"); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := c.GetObjects("post", "a", "b", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || string(objects["a"]) != "A" {
+		t.Errorf("GetObjects = %q, want only a", objects)
+	}
+}
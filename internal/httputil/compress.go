@@ -0,0 +1,236 @@
+package httputil
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/discuitnet/discuit/internal/zstd"
+)
+
+// compressEncodings are the content codings of compressed responses, most
+// preferred first.
+var compressEncodings = []string{"zstd", "gzip"}
+
+// NegotiateEncoding returns the one of encodings (which are in order of
+// preference) that the Accept-Encoding headers of h rank the highest, or an
+// empty string if they accept none of them.
+func NegotiateEncoding(h http.Header, encodings ...string) string {
+	qs := make(map[string]float64)
+	for _, val := range h.Values("Accept-Encoding") {
+		for _, s := range strings.Split(val, ",") {
+			name, params, _ := strings.Cut(s, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			q := 1.0
+			if k, v, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(k) == "q" {
+				var err error
+				if q, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+					q = 0
+				}
+			}
+			qs[name] = q
+		}
+	}
+	best, bestQ := "", 0.0
+	for _, enc := range encodings {
+		q, ok := qs[enc]
+		if !ok {
+			q = qs["*"]
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// Compressible reports whether content of contentType (a Content-Type header)
+// is worth compressing: text, JSON, XML, and the like, but not images
+// (except SVGs), videos, archives, and other content that's compressed
+// already.
+func Compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml", "application/wasm", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// compressor is a gzip.Writer or a zstd.Writer.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+var compressorPools = map[string]*sync.Pool{
+	"gzip": {New: func() any { return gzip.NewWriter(nil) }},
+	"zstd": {New: func() any { return zstd.NewWriter(nil) }},
+}
+
+// CompressOptions are the options of CompressHandler.
+type CompressOptions struct {
+	// Responses smaller than MinSize bytes are not compressed (unless
+	// they're flushed before they're that long).
+	MinSize int
+
+	// If Skip is not nil, responses to the requests for which it returns
+	// true are not compressed.
+	Skip func(r *http.Request) bool
+}
+
+// CompressHandler returns a handler that compresses the responses of h with
+// zstd or gzip, as negotiated with the Accept-Encoding header of requests.
+// Responses are compressed only if their content type is compressible (see
+// Compressible) and if they're not encoded already.
+func CompressHandler(h http.Handler, opts CompressOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if opts.Skip != nil && opts.Skip(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := NegotiateEncoding(r.Header, compressEncodings...)
+		if encoding == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: opts.MinSize}
+		defer cw.close()
+		h.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter compresses what's written to it, once it's known whether
+// the response is worth compressing: until then, the status code and the
+// first minSize bytes of the body are held back.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	code    int
+	buf     []byte
+	started bool
+	c       compressor // Nil if the response is not compressed.
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if code < 200 && code != http.StatusSwitchingProtocols {
+		cw.ResponseWriter.WriteHeader(code) // Informational headers.
+		return
+	}
+	if cw.started || cw.code != 0 {
+		return
+	}
+	cw.code = code
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.started {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) < cw.minSize {
+			return len(p), nil
+		}
+		if err := cw.start(false); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if cw.c != nil {
+		return cw.c.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// start writes the header of the response, deciding whether to compress it,
+// and the body held back. If flushing is true, the response is compressed
+// even if what's been written of it is short, as there may be more to come.
+func (cw *compressWriter) start(flushing bool) error {
+	cw.started = true
+	if cw.code == 0 {
+		cw.code = http.StatusOK
+	}
+	h := cw.Header()
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	if (flushing || (len(cw.buf) > 0 && len(cw.buf) >= cw.minSize)) && bodyAllowed(cw.code) &&
+		h.Get("Content-Encoding") == "" && h.Get("Content-Range") == "" && Compressible(h.Get("Content-Type")) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		cw.c = compressorPools[cw.encoding].Get().(compressor)
+		cw.c.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.code)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.c != nil {
+		_, err = cw.c.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func bodyAllowed(code int) bool {
+	return code >= 200 && code != http.StatusNoContent && code != http.StatusNotModified
+}
+
+// Flush writes what's been written so far (compressed) to the client.
+func (cw *compressWriter) Flush() {
+	if !cw.started {
+		if err := cw.start(true); err != nil {
+			return
+		}
+	}
+	if cw.c != nil {
+		if err := cw.c.Flush(); err != nil {
+			return
+		}
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close ends the response, once the handler has returned.
+func (cw *compressWriter) close() {
+	if !cw.started {
+		if cw.code == 0 && len(cw.buf) == 0 {
+			return // Nothing was written; net/http writes the header.
+		}
+		cw.start(false)
+	}
+	if cw.c != nil {
+		cw.c.Close()
+		cw.c.Reset(nil)
+		compressorPools[cw.encoding].Put(cw.c)
+		cw.c = nil
+	}
+}
+
+// Unwrap returns the underlying http.ResponseWriter (for
+// http.ResponseController).
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
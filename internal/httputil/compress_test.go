@@ -0,0 +1,144 @@
+package httputil
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br, zstd", "zstd"},
+		{"zstd;q=0.5, gzip", "gzip"},
+		{"zstd;q=0, gzip;q=0.1", "gzip"},
+		{"*", "zstd"},
+		{"*;q=0.5, zstd;q=0", "gzip"},
+		{"deflate, identity", ""},
+		{"GZIP;q=1.0", "gzip"},
+		{"gzip;q=bad", ""},
+	}
+	for _, test := range tests {
+		h := make(http.Header)
+		if test.accept != "" {
+			h.Set("Accept-Encoding", test.accept)
+		}
+		if got := NegotiateEncoding(h, "zstd", "gzip"); got != test.want {
+			t.Errorf("NegotiateEncoding(%q) = %q, want %q", test.accept, got, test.want)
+		}
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	for contentType, want := range map[string]bool{
+		"application/json; charset=UTF-8": true,
+		"text/html":                       true,
+		"application/activity+json":       true,
+		"image/svg+xml":                   true,
+		"image/jpeg":                      false,
+		"application/zip":                 false,
+		"":                                false,
+	} {
+		if got := Compressible(contentType); got != want {
+			t.Errorf("Compressible(%q) = %v, want %v", contentType, got, want)
+		}
+	}
+}
+
+func TestCompressHandler(t *testing.T) {
+	long := strings.Repeat(`{"title":"A post"},`, 100)
+	handler := CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+		case "/encoded":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "br")
+		case "/short":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{}`)
+			return
+		case "/skipped", "/json":
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, long[:100])
+		io.WriteString(w, long[100:])
+	}), CompressOptions{
+		MinSize: 1024,
+		Skip:    func(r *http.Request) bool { return r.URL.Path == "/skipped" },
+	})
+
+	tests := []struct {
+		path, accept string
+		encoding     string
+	}{
+		{"/json", "gzip", "gzip"},
+		{"/json", "gzip, zstd", "zstd"},
+		{"/json", "", ""},
+		{"/image", "gzip", ""},
+		{"/encoded", "gzip", "br"},
+		{"/short", "gzip", ""},
+		{"/skipped", "gzip", ""},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		req.Header.Set("Accept-Encoding", test.accept)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if got := w.Header().Get("Content-Encoding"); got != test.encoding {
+			t.Errorf("%s (%s): got Content-Encoding %q, want %q", test.path, test.accept, got, test.encoding)
+			continue
+		}
+		if got, want := w.Header().Get("Vary"), "Accept-Encoding"; test.path != "/skipped" && got != want {
+			t.Errorf("%s: got Vary %q, want %q", test.path, got, want)
+		}
+		body := w.Body.Bytes()
+		switch test.encoding {
+		case "gzip":
+			r, err := gzip.NewReader(bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body, err = io.ReadAll(r); err != nil {
+				t.Fatal(err)
+			}
+		case "zstd":
+			if !bytes.HasPrefix(body, []byte{0x28, 0xB5, 0x2F, 0xFD}) {
+				t.Errorf("%s: body is not a zstd frame", test.path)
+			}
+			continue
+		}
+		if test.path == "/short" {
+			continue
+		}
+		if w.Code != http.StatusCreated || string(body) != long {
+			t.Errorf("%s (%s): got %d %q, want %d and the original body", test.path, test.accept, w.Code, body, http.StatusCreated)
+		}
+	}
+}
+
+func TestCompressHandlerFlush(t *testing.T) {
+	handler := CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: 1\n\n")
+		w.(http.Flusher).Flush()
+	}), CompressOptions{MinSize: 1024})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if !w.Flushed || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("flushed response not compressed (flushed: %v, headers: %v)", w.Flushed, w.Header())
+	}
+}
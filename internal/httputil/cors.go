@@ -0,0 +1,138 @@
+package httputil
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions are the options of CORSHandler.
+type CORSOptions struct {
+	// Origins (like "https://example.com") that public requests are allowed
+	// from, without credentials. If it contains "*", they're allowed from
+	// all origins.
+	PublicOrigins []string
+
+	// Origins that all requests are allowed from, with Methods, and with
+	// credentials (cookies) if Credentials is true.
+	Origins     []string
+	Methods     []string
+	Credentials bool
+
+	// Request headers that cross-origin requests may have, and response
+	// headers that trusted origins (those of Origins) may read.
+	AllowHeaders  []string
+	ExposeHeaders []string
+
+	// How long browsers may cache the results of preflight requests.
+	MaxAge time.Duration
+
+	// Public reports whether a request of method to the URL of r is public.
+	// It's called only for GET and HEAD requests (all of which are public if
+	// Public is nil), since requests of other methods never are.
+	Public func(r *http.Request, method string) bool
+}
+
+// CORSHandler returns a handler that applies the CORS policy of opts to the
+// requests to h, answering preflight requests itself. Requests of trusted
+// origins are allowed. Of the others, only public requests, from
+// PublicOrigins, are allowed, and without credentials, so that (since
+// browsers send no cookies with them) they're served as to anonymous users.
+func CORSHandler(h http.Handler, opts CORSOptions) http.Handler {
+	var (
+		anyPublic bool
+		public    = make(map[string]bool)
+		trusted   = make(map[string]bool)
+		methods   = make(map[string]bool)
+		headers   = make(map[string]bool)
+	)
+	for _, origin := range opts.PublicOrigins {
+		if origin == "*" {
+			anyPublic = true
+		}
+		public[origin] = true
+	}
+	for _, origin := range opts.Origins {
+		trusted[origin] = true
+	}
+	for _, method := range opts.Methods {
+		methods[strings.ToUpper(method)] = true
+	}
+	for _, header := range opts.AllowHeaders {
+		headers[http.CanonicalHeaderKey(header)] = true
+	}
+	var maxAge string
+	if opts.MaxAge > 0 {
+		maxAge = strconv.Itoa(int(opts.MaxAge.Seconds()))
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			// Not a cross-origin request (or not one of a browser).
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+
+		method := r.Method
+		preflight := method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			method = r.Header.Get("Access-Control-Request-Method")
+		}
+
+		isTrusted := trusted[origin]
+		isPublic := !isTrusted && (anyPublic || public[origin]) &&
+			(method == http.MethodGet || method == http.MethodHead) &&
+			(opts.Public == nil || opts.Public(r, method))
+
+		if preflight {
+			allowed := (isTrusted && methods[method]) || isPublic
+			for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+				if header = strings.TrimSpace(header); header != "" && !headers[http.CanonicalHeaderKey(header)] {
+					allowed = false
+				}
+			}
+			if !allowed {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+
+		hdr := w.Header()
+		switch {
+		case isTrusted:
+			hdr.Set("Access-Control-Allow-Origin", origin)
+			if opts.Credentials {
+				hdr.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if !preflight && len(opts.ExposeHeaders) > 0 {
+				hdr.Set("Access-Control-Expose-Headers", strings.Join(opts.ExposeHeaders, ", "))
+			}
+		case isPublic:
+			if anyPublic {
+				hdr.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				hdr.Set("Access-Control-Allow-Origin", origin)
+			}
+		}
+
+		if !preflight {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if isTrusted {
+			hdr.Set("Access-Control-Allow-Methods", strings.Join(opts.Methods, ", "))
+		} else {
+			hdr.Set("Access-Control-Allow-Methods", "GET, HEAD")
+		}
+		if len(opts.AllowHeaders) > 0 {
+			hdr.Set("Access-Control-Allow-Headers", strings.Join(opts.AllowHeaders, ", "))
+		}
+		if maxAge != "" {
+			hdr.Set("Access-Control-Max-Age", maxAge)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,76 @@
+package httputil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORSHandler(t *testing.T) {
+	opts := CORSOptions{
+		PublicOrigins: []string{"*"},
+		Origins:       []string{"https://app.example.com"},
+		Methods:       []string{"GET", "POST", "DELETE"},
+		Credentials:   true,
+		AllowHeaders:  []string{"Content-Type", "X-Csrf-Token"},
+		ExposeHeaders: []string{"Csrf-Token"},
+		MaxAge:        time.Hour,
+		Public: func(r *http.Request, method string) bool {
+			return r.URL.Path != "/api/_user"
+		},
+	}
+	handler := CORSHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}), opts)
+
+	tests := []struct {
+		method, path, origin string
+		preflight            string // Access-Control-Request-Method
+		preflightHeaders     string // Access-Control-Request-Headers
+		status               int
+		allowOrigin          string
+		credentials          bool
+	}{
+		{"GET", "/api/posts", "", "", "", http.StatusTeapot, "", false},
+		{"GET", "/api/posts", "https://other.com", "", "", http.StatusTeapot, "*", false},
+		{"GET", "/api/_user", "https://other.com", "", "", http.StatusTeapot, "", false},
+		{"POST", "/api/posts", "https://other.com", "", "", http.StatusTeapot, "", false},
+		{"POST", "/api/posts", "https://app.example.com", "", "", http.StatusTeapot, "https://app.example.com", true},
+		{"OPTIONS", "/api/posts", "https://other.com", "GET", "", http.StatusNoContent, "*", false},
+		{"OPTIONS", "/api/posts", "https://other.com", "POST", "content-type", http.StatusForbidden, "", false},
+		{"OPTIONS", "/api/_user", "https://other.com", "GET", "", http.StatusForbidden, "", false},
+		{"OPTIONS", "/api/posts", "https://app.example.com", "POST", "content-type, x-csrf-token", http.StatusNoContent, "https://app.example.com", true},
+		{"OPTIONS", "/api/posts", "https://app.example.com", "PUT", "", http.StatusForbidden, "", false},
+		{"OPTIONS", "/api/posts", "https://app.example.com", "DELETE", "authorization", http.StatusForbidden, "", false},
+		{"OPTIONS", "/api/posts", "https://app.example.com", "", "", http.StatusTeapot, "https://app.example.com", true},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.path, nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if test.preflight != "" {
+			r.Header.Set("Access-Control-Request-Method", test.preflight)
+		}
+		if test.preflightHeaders != "" {
+			r.Header.Set("Access-Control-Request-Headers", test.preflightHeaders)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		name := test.method + " " + test.path + " from " + test.origin + " (" + test.preflight + ")"
+		if w.Code != test.status {
+			t.Errorf("%s: status %d, want %d", name, w.Code, test.status)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != test.allowOrigin {
+			t.Errorf("%s: Access-Control-Allow-Origin %q, want %q", name, got, test.allowOrigin)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Credentials") == "true"; got != test.credentials {
+			t.Errorf("%s: credentials allowed: %v, want %v", name, got, test.credentials)
+		}
+		if test.origin != "" && w.Header().Get("Vary") != "Origin" {
+			t.Errorf("%s: no Vary: Origin header", name)
+		}
+	}
+}
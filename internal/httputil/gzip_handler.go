@@ -1,8 +1,6 @@
 package httputil
 
 import (
-	"compress/gzip"
-	"io"
 	"net/http"
 	"os"
 	"path"
@@ -107,26 +105,3 @@ func AcceptEncoding(h http.Header, encoding string) bool {
 	}
 	return false
 }
-
-type gzipResponseWriter struct {
-	io.Writer
-	http.ResponseWriter
-}
-
-func (w gzipResponseWriter) Write(p []byte) (int, error) {
-	return w.Writer.Write(p)
-}
-
-func GzipHandler(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !AcceptEncoding(r.Header, "gzip") {
-			h.ServeHTTP(w, r)
-			return
-		}
-
-		w.Header().Set("Content-Encoding", "gzip")
-		gz := gzip.NewWriter(w)
-		defer gz.Close()
-		h.ServeHTTP(gzipResponseWriter{Writer: gz, ResponseWriter: w}, r)
-	})
-}
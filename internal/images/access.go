@@ -0,0 +1,173 @@
+package images
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// An Access is a request for an image served by Server. Since image IDs are
+// the IDs of the images of posts, communities, and users, the bytes served
+// can be attributed to those that the images belong to.
+type Access struct {
+	Time       time.Time   `json:"time"`
+	ImageID    uid.ID      `json:"imageId"`
+	Variant    string      `json:"variant"` // Like "325x250:cover"; empty for the original size.
+	Format     ImageFormat `json:"format"`
+	Status     int         `json:"status"`
+	Bytes      int64       `json:"bytes"` // Of the response body.
+	Cache      string      `json:"cache"` // One of the Cache* constants, or empty.
+	Referrer   string      `json:"referrer"`
+	External   bool        `json:"external,omitempty"` // If it was requested from a page of another site.
+	Hotlink    bool        `json:"hotlink,omitempty"`  // If the request was denied by the ReferrerPolicy of the server.
+	DurationMS float64     `json:"durationMs"`
+
+	// Only a sample of the accesses may be logged (see NewAccessLog); each
+	// logged access stands for 1/SampleRate accesses.
+	SampleRate float64 `json:"sampleRate"`
+}
+
+// Values of Access.Cache.
+const (
+	CacheHit      = "hit"      // The variant was served from the cache.
+	CacheMiss     = "miss"     // The image was read from its store.
+	CacheRedirect = "redirect" // The request was redirected to the store.
+)
+
+// An AccessLogger records the requests served by Server. LogAccess is called
+// once the response is written, and must not hold on to a.
+type AccessLogger interface {
+	LogAccess(a *Access)
+}
+
+// accessLog is an AccessLogger that writes accesses as JSON lines.
+type accessLog struct {
+	rate float64
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewAccessLog returns an AccessLogger that writes a sample of the accesses,
+// as JSON lines, to w. The sample rate is between 0 and 1; if it's not, all
+// accesses are written.
+func NewAccessLog(w io.Writer, rate float64) AccessLogger {
+	if rate <= 0 || rate > 1 {
+		rate = 1
+	}
+	return &accessLog{rate: rate, enc: json.NewEncoder(w)}
+}
+
+func (l *accessLog) LogAccess(a *Access) {
+	if l.rate < 1 && rand.Float64() >= l.rate {
+		return
+	}
+	a.SampleRate = l.rate
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(a); err != nil {
+		log.Printf("Error writing image access log: %v\n", err)
+	}
+}
+
+// accessWriter is an http.ResponseWriter that records the status and the size
+// of the response.
+type accessWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// A ReferrerPolicy protects images from being hotlinked: embedded in the
+// pages of other sites. Requests from pages of hosts that are not allowed
+// are denied, with a placeholder image in place of the image.
+//
+// Requests without a Referer header (those of apps, and of browsers that
+// don't send it) are allowed, as are those from the host of the site itself.
+type ReferrerPolicy struct {
+	// Hosts like "example.com". Those of the form "*.example.com" allow
+	// example.com and all of its subdomains.
+	Allowed []string
+
+	// The image served to denied requests. If nil, a transparent 1x1 PNG.
+	Placeholder []byte
+}
+
+// placeholderPNG is a transparent 1x1 PNG.
+var placeholderPNG, _ = base64.StdEncoding.DecodeString("iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNkYPhfDwAChwGA60e6kgAAAABJRU5ErkJggg==")
+
+// referrerHost returns the host of the page r was requested from, in lower
+// case, and whether it's of another site than that of r. Requests with
+// referrers that cannot be parsed are taken to be from other sites.
+func referrerHost(r *http.Request) (host string, external bool) {
+	ref := r.Referer()
+	if ref == "" {
+		return "", false
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Host == "" {
+		return "", true
+	}
+	host = strings.ToLower(u.Hostname())
+	site, _, _ := strings.Cut(r.Host, ":")
+	return host, !strings.EqualFold(host, site)
+}
+
+// allows reports whether the request r is allowed.
+func (p *ReferrerPolicy) allows(r *http.Request) bool {
+	host, external := referrerHost(r)
+	if !external {
+		return true
+	}
+	if host == "" {
+		return false
+	}
+	for _, allowed := range p.Allowed {
+		allowed = strings.ToLower(allowed)
+		if domain, ok := strings.CutPrefix(allowed, "*."); ok {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// writePlaceholder writes the placeholder image to w, of a denied request.
+func (p *ReferrerPolicy) writePlaceholder(w http.ResponseWriter) {
+	image := p.Placeholder
+	if image == nil {
+		image = placeholderPNG
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(image))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusForbidden)
+	w.Write(image)
+}
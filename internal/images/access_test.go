@@ -0,0 +1,101 @@
+package images
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestReferrerPolicy(t *testing.T) {
+	p := &ReferrerPolicy{Allowed: []string{"friend.org", "*.Example.com"}}
+	for _, c := range []struct {
+		referrer string
+		want     bool
+	}{
+		{"", true},
+		{"https://discuit.test/c/pics", true},
+		{"https://friend.org/", true},
+		{"https://www.friend.org/", false},
+		{"https://example.com/a", true},
+		{"https://cdn.example.com:8443/a", true},
+		{"https://notexample.com/", false},
+		{"https://evil.test/", false},
+		{"not a url", false},
+	} {
+		r := httptest.NewRequest("GET", "http://discuit.test:8080/images/x.jpeg", nil)
+		if c.referrer != "" {
+			r.Header.Set("Referer", c.referrer)
+		}
+		if got := p.allows(r); got != c.want {
+			t.Errorf("allows(%q) = %v, want %v", c.referrer, got, c.want)
+		}
+	}
+}
+
+type accessRecorder []Access
+
+func (r *accessRecorder) LogAccess(a *Access) {
+	*r = append(*r, *a)
+}
+
+func TestServerHotlink(t *testing.T) {
+	var accesses accessRecorder
+	s := &Server{
+		SkipHashCheck: true,
+		AccessLog:     &accesses,
+		Referrers:     &ReferrerPolicy{},
+	}
+	id := uid.New()
+	r := httptest.NewRequest("GET", "http://discuit.test/images/"+id.String()+".jpeg?size=325x250&fit=cover", nil)
+	r.Header.Set("Referer", "https://evil.test/page")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if !bytes.Equal(w.Body.Bytes(), placeholderPNG) {
+		t.Error("the placeholder was not served")
+	}
+	if len(accesses) != 1 {
+		t.Fatalf("got %d accesses logged, want 1", len(accesses))
+	}
+	a := accesses[0]
+	if a.ImageID != id || a.Variant != "325x250:cover" || a.Format != ImageFormatJPEG || !a.Hotlink ||
+		a.Status != http.StatusForbidden || a.Bytes != int64(len(placeholderPNG)) || a.Referrer != "https://evil.test/page" {
+		t.Errorf("got access %+v", a)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewAccessLog(&buf, 1)
+	for i := 0; i < 3; i++ {
+		l.LogAccess(&Access{Status: 200, Bytes: 10, Cache: CacheHit})
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3", len(lines))
+	}
+	var a Access
+	if err := json.Unmarshal([]byte(lines[0]), &a); err != nil {
+		t.Fatal(err)
+	}
+	if a.Bytes != 10 || a.Cache != CacheHit || a.SampleRate != 1 {
+		t.Errorf("got access %+v", a)
+	}
+
+	buf.Reset()
+	l = NewAccessLog(&buf, 0.1)
+	for i := 0; i < 1000; i++ {
+		l.LogAccess(&Access{})
+	}
+	if n := strings.Count(buf.String(), "\n"); n < 50 || n > 200 {
+		t.Errorf("logged %d of 1000 accesses at a sample rate of 0.1", n)
+	}
+}
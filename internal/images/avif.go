@@ -0,0 +1,130 @@
+package images
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+// There's no pure Go AVIF codec. AVIF images are decoded and encoded only if
+// the binary is built with the libavif build tag (see avif_libavif.go), in
+// which case they're resized and transcoded like images of other formats, and
+// images can be requested in AVIF. Otherwise, AVIF images are only ever served
+// as they were uploaded: the decoder registered here reads just enough of the
+// file (the dimensions) for the images package to save and describe AVIF
+// images, but it cannot decode pixels.
+
+var errAVIFDecodeUnsupported = errors.New("decoding avif image pixels is not supported")
+
+// The AVIF codec, which is nil unless the binary is built with one.
+var (
+	avifDecode func(data []byte) (image.Image, error)
+	avifEncode func(w io.Writer, img image.Image) error
+)
+
+// avifCodec reports whether AVIF images can be decoded and encoded.
+func avifCodec() bool {
+	return avifDecode != nil && avifEncode != nil
+}
+
+func init() {
+	image.RegisterFormat("avif", "????ftypavif", decodeAVIF, decodeAVIFConfig)
+	image.RegisterFormat("avif", "????ftypavis", decodeAVIF, decodeAVIFConfig)
+}
+
+func decodeAVIF(r io.Reader) (image.Image, error) {
+	if !avifCodec() {
+		return nil, errAVIFDecodeUnsupported
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return avifDecode(data)
+}
+
+// decodeAVIFConfig returns the dimensions of the primary image of an AVIF file,
+// taken from the first image spatial extents ('ispe') property of the file.
+func decodeAVIFConfig(r io.Reader) (image.Config, error) {
+	br := bufio.NewReader(r)
+	width, height, err := findISPE(br, -1, 0)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{
+		ColorModel: color.RGBAModel,
+		Width:      width,
+		Height:     height,
+	}, nil
+}
+
+var errAVIFNoISPE = errors.New("avif: no image spatial extents found")
+
+// findISPE walks the ISO base media file format boxes in r, descending into
+// container boxes that lead to the 'ispe' property (meta > iprp > ipco). If
+// limit is non-negative, at most limit bytes are read from r. The depth
+// argument prevents descending into unrelated boxes.
+func findISPE(r *bufio.Reader, limit int64, depth int) (width, height int, err error) {
+	var read int64
+	for limit < 0 || read < limit {
+		var header [8]byte
+		if _, err = io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				err = errAVIFNoISPE
+			}
+			return
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		headerSize := int64(8)
+		if size == 1 {
+			var ext [8]byte
+			if _, err = io.ReadFull(r, ext[:]); err != nil {
+				return
+			}
+			size = int64(binary.BigEndian.Uint64(ext[:]))
+			headerSize += 8
+		}
+		if size != 0 && size < headerSize {
+			err = errors.New("avif: invalid box size")
+			return
+		}
+		body := size - headerSize // If size is 0, the box extends to the end.
+		read += size
+
+		switch {
+		case boxType == "meta" && depth == 0:
+			// meta is a full box: skip version and flags.
+			if _, err = r.Discard(4); err != nil {
+				return
+			}
+			return findISPE(r, body-4, depth+1)
+		case (boxType == "iprp" && depth == 1) || (boxType == "ipco" && depth == 2):
+			return findISPE(r, body, depth+1)
+		case boxType == "ispe" && depth == 3:
+			var data [12]byte // version and flags, width, height
+			if _, err = io.ReadFull(r, data[:]); err != nil {
+				return
+			}
+			width = int(binary.BigEndian.Uint32(data[4:8]))
+			height = int(binary.BigEndian.Uint32(data[8:12]))
+			return
+		}
+
+		if size == 0 {
+			err = errAVIFNoISPE
+			return
+		}
+		if _, err = r.Discard(int(body)); err != nil {
+			if err == io.EOF {
+				err = errAVIFNoISPE
+			}
+			return
+		}
+	}
+	err = errAVIFNoISPE
+	return
+}
@@ -0,0 +1,159 @@
+//go:build libavif && cgo
+
+package images
+
+/*
+#cgo pkg-config: libavif
+#include <stdlib.h>
+#include <string.h>
+#include <avif/avif.h>
+
+// discuit_avif_decode decodes the primary image of the AVIF file in data into
+// 8-bit, non-premultiplied RGBA pixels (of stride width*4), which are to be
+// freed by the caller.
+static avifResult discuit_avif_decode(const uint8_t *data, size_t size, uint32_t *width, uint32_t *height, uint8_t **pixels) {
+	avifDecoder *decoder = avifDecoderCreate();
+	if (decoder == NULL) {
+		return AVIF_RESULT_OUT_OF_MEMORY;
+	}
+	avifResult res = avifDecoderSetIOMemory(decoder, data, size);
+	if (res == AVIF_RESULT_OK) {
+		res = avifDecoderParse(decoder);
+	}
+	if (res == AVIF_RESULT_OK) {
+		res = avifDecoderNextImage(decoder);
+	}
+	if (res != AVIF_RESULT_OK) {
+		avifDecoderDestroy(decoder);
+		return res;
+	}
+
+	avifRGBImage rgb;
+	avifRGBImageSetDefaults(&rgb, decoder->image);
+	rgb.format = AVIF_RGB_FORMAT_RGBA;
+	rgb.depth = 8;
+	res = avifRGBImageAllocatePixels(&rgb);
+	if (res == AVIF_RESULT_OK) {
+		res = avifImageYUVToRGB(decoder->image, &rgb);
+	}
+	if (res == AVIF_RESULT_OK) {
+		size_t stride = (size_t)rgb.width * 4;
+		*pixels = malloc(stride * rgb.height);
+		if (*pixels == NULL) {
+			res = AVIF_RESULT_OUT_OF_MEMORY;
+		} else {
+			for (uint32_t y = 0; y < rgb.height; y++) {
+				memcpy(*pixels + y * stride, rgb.pixels + y * rgb.rowBytes, stride);
+			}
+			*width = rgb.width;
+			*height = rgb.height;
+		}
+	}
+	avifRGBImageFreePixels(&rgb);
+	avifDecoderDestroy(decoder);
+	return res;
+}
+
+// discuit_avif_encode encodes the 8-bit, non-premultiplied RGBA pixels of an
+// image into out, which is to be freed (with avifRWDataFree) by the caller.
+static avifResult discuit_avif_encode(const uint8_t *pixels, uint32_t width, uint32_t height, uint32_t stride, int quality, int speed, avifRWData *out) {
+	avifImage *image = avifImageCreate(width, height, 8, AVIF_PIXEL_FORMAT_YUV420);
+	if (image == NULL) {
+		return AVIF_RESULT_OUT_OF_MEMORY;
+	}
+	avifRGBImage rgb;
+	avifRGBImageSetDefaults(&rgb, image);
+	rgb.format = AVIF_RGB_FORMAT_RGBA;
+	rgb.depth = 8;
+	rgb.pixels = (uint8_t *)pixels;
+	rgb.rowBytes = stride;
+	avifResult res = avifImageRGBToYUV(image, &rgb);
+	if (res == AVIF_RESULT_OK) {
+		avifEncoder *encoder = avifEncoderCreate();
+		if (encoder == NULL) {
+			res = AVIF_RESULT_OUT_OF_MEMORY;
+		} else {
+			encoder->quality = quality;
+			encoder->qualityAlpha = quality;
+			encoder->speed = speed;
+			res = avifEncoderWrite(encoder, image, out);
+			avifEncoderDestroy(encoder);
+		}
+	}
+	avifImageDestroy(image);
+	return res;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"unsafe"
+
+	"golang.org/x/image/draw"
+)
+
+// AVIF images are decoded and encoded with libavif (version 1.0 or later),
+// when the binary is built with the libavif build tag.
+
+// avifQuality (0 to 100) and avifSpeed (0, the slowest, to 10) are the
+// settings of the AVIF encoder.
+const (
+	avifQuality = 60
+	avifSpeed   = 8
+)
+
+func init() {
+	avifDecode = libavifDecode
+	avifEncode = libavifEncode
+}
+
+// avifError returns the error of the libavif result res.
+func avifError(res C.avifResult) error {
+	return fmt.Errorf("avif: %s", C.GoString(C.avifResultToString(res)))
+}
+
+// libavifDecode decodes the primary image of the AVIF file in data.
+func libavifDecode(data []byte) (image.Image, error) {
+	if len(data) == 0 {
+		return nil, errMalformedImage
+	}
+	var (
+		width, height C.uint32_t
+		pixels        *C.uint8_t
+	)
+	res := C.discuit_avif_decode((*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), &width, &height, &pixels)
+	if res != C.AVIF_RESULT_OK {
+		return nil, avifError(res)
+	}
+	defer C.free(unsafe.Pointer(pixels))
+
+	img := image.NewNRGBA(image.Rect(0, 0, int(width), int(height)))
+	copy(img.Pix, unsafe.Slice((*byte)(unsafe.Pointer(pixels)), len(img.Pix)))
+	return img, nil
+}
+
+// libavifEncode encodes img as an AVIF image and writes it to w.
+func libavifEncode(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return errMalformedImage
+	}
+	src, ok := img.(*image.NRGBA)
+	if !ok || bounds.Min != (image.Point{}) {
+		src = image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	}
+
+	var out C.avifRWData
+	res := C.discuit_avif_encode((*C.uint8_t)(unsafe.Pointer(&src.Pix[0])), C.uint32_t(bounds.Dx()), C.uint32_t(bounds.Dy()),
+		C.uint32_t(src.Stride), C.int(avifQuality), C.int(avifSpeed), &out)
+	if res != C.AVIF_RESULT_OK {
+		return avifError(res)
+	}
+	defer C.avifRWDataFree(&out)
+	_, err := w.Write(unsafe.Slice((*byte)(unsafe.Pointer(out.data)), int(out.size)))
+	return err
+}
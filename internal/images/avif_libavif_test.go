@@ -0,0 +1,38 @@
+//go:build libavif && cgo
+
+package images
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestLibavifRoundTrip(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.NRGBA{R: 200, G: 40, B: 90, A: 255})
+		}
+	}
+	data, err := encodeImage(img, ImageFormatAVIF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, format, err := decodeImage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != string(ImageFormatAVIF) || decoded.Bounds() != img.Bounds() {
+		t.Fatalf("decoded a %s image of bounds %v", format, decoded.Bounds())
+	}
+	r, g, b, _ := decoded.At(32, 24).RGBA()
+	if diff := int(r>>8) - 200; diff < -8 || diff > 8 || g>>8 > 60 || b>>8 < 70 || b>>8 > 110 {
+		t.Errorf("decoded pixel %d, %d, %d, want about 200, 40, 90", r>>8, g>>8, b>>8)
+	}
+
+	if _, err := Transform(data, ImageSize{Width: 32, Height: 24}, ImageFitCover, ImageFormatJPEG); err != nil {
+		t.Errorf("transcoding to JPEG: %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+// avifBox returns an ISO base media file format box of type typ with body.
+func avifBox(typ string, body ...[]byte) []byte {
+	b := bytes.Join(body, nil)
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(8+len(b)))
+	copy(header[4:], typ)
+	return append(header, b...)
+}
+
+func TestDecodeAVIFConfig(t *testing.T) {
+	ispe := make([]byte, 12)
+	binary.BigEndian.PutUint32(ispe[4:], 640)
+	binary.BigEndian.PutUint32(ispe[8:], 480)
+
+	data := bytes.Join([][]byte{
+		avifBox("ftyp", []byte("avif"), make([]byte, 4), []byte("avifmif1")),
+		avifBox("meta",
+			make([]byte, 4), // version and flags
+			avifBox("hdlr", make([]byte, 24)),
+			avifBox("iprp", avifBox("ipco", avifBox("colr", []byte("nclx")), avifBox("ispe", ispe))),
+		),
+		avifBox("mdat", make([]byte, 16)),
+	}, nil)
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != string(ImageFormatAVIF) {
+		t.Errorf("expected format avif, got %s", format)
+	}
+	if config.Width != 640 || config.Height != 480 {
+		t.Errorf("expected 640x480, got %dx%d", config.Width, config.Height)
+	}
+
+	if _, _, err := image.Decode(bytes.NewReader(data)); !avifCodec() && err != errAVIFDecodeUnsupported {
+		t.Errorf("expected errAVIFDecodeUnsupported, got %v", err)
+	}
+}
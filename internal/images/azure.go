@@ -0,0 +1,215 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AzureOptions are the settings of the Azure Blob Storage store (see
+// InitAzureStore).
+type AzureOptions struct {
+	Account    string // Storage account name.
+	AccountKey string // Base64 encoded shared key of the account.
+	Container  string
+
+	// If empty, it's https://<account>.blob.core.windows.net. For the Azurite
+	// emulator, it's like http://127.0.0.1:10000/devstoreaccount1.
+	Endpoint   string
+	PathPrefix string // Prefix of the names of all blobs.
+}
+
+// InitAzureStore registers a store named "azure" that saves images to an Azure
+// Blob Storage container. If opts.Container is empty, it does nothing.
+func InitAzureStore(opts AzureOptions) error {
+	if opts.Container == "" {
+		return nil
+	}
+	if opts.Account == "" || opts.AccountKey == "" {
+		return errors.New("Azure storage account and account key are required")
+	}
+	store, err := newAzureStore(opts)
+	if err != nil {
+		return err
+	}
+	return RegisterStore(context.Background(), store)
+}
+
+// azureStore implements the Store interface for Azure Blob Storage, using its
+// REST API with Shared Key authorization.
+type azureStore struct {
+	account   string
+	key       []byte
+	endpoint  string
+	container string
+	prefix    string
+}
+
+// azureAPIVersion is the version of the Blob Storage REST API used.
+const azureAPIVersion = "2021-08-06"
+
+func newAzureStore(opts AzureOptions) (*azureStore, error) {
+	key, err := base64.StdEncoding.DecodeString(opts.AccountKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Azure account key: %w", err)
+	}
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		endpoint = "https://" + opts.Account + ".blob.core.windows.net"
+	}
+	return &azureStore{
+		account:   opts.Account,
+		key:       key,
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		container: opts.Container,
+		prefix:    opts.PathPrefix,
+	}, nil
+}
+
+func (s *azureStore) Name() string {
+	return "azure"
+}
+
+// blobURL returns the URL of the blob of r.
+func (s *azureStore) blobURL(r *ImageRecord) string {
+	key := storeObjectKey(s.prefix, r.ID, r.Format)
+	u := s.endpoint + "/" + url.PathEscape(s.container)
+	for _, segment := range strings.Split(key, "/") {
+		u += "/" + url.PathEscape(segment)
+	}
+	return u
+}
+
+// newRequest returns a request to the REST API signed with the account key.
+func (s *azureStore) newRequest(ctx context.Context, method, u string, body io.Reader, size int64, header http.Header) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	req.Header.Set("Authorization", "SharedKey "+s.account+":"+s.signature(req))
+	return req, nil
+}
+
+// signature returns the Shared Key signature of req (see
+// https://learn.microsoft.com/rest/api/storageservices/authorize-with-shared-key).
+func (s *azureStore) signature(req *http.Request) string {
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+	h := req.Header
+	lines := []string{
+		req.Method,
+		h.Get("Content-Encoding"),
+		h.Get("Content-Language"),
+		contentLength,
+		h.Get("Content-MD5"),
+		h.Get("Content-Type"),
+		"", // Date (x-ms-date is used instead)
+		h.Get("If-Modified-Since"),
+		h.Get("If-Match"),
+		h.Get("If-None-Match"),
+		h.Get("If-Unmodified-Since"),
+		h.Get("Range"),
+	}
+
+	var msHeaders []string
+	for name := range h {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-ms-") {
+			msHeaders = append(msHeaders, name)
+		}
+	}
+	sort.Strings(msHeaders)
+	var b strings.Builder
+	b.WriteString(strings.Join(lines, "\n"))
+	b.WriteString("\n")
+	for _, name := range msHeaders {
+		b.WriteString(name + ":" + strings.TrimSpace(h.Get(name)) + "\n")
+	}
+
+	// The canonicalized resource.
+	b.WriteString("/" + s.account + req.URL.EscapedPath())
+	query := req.URL.Query()
+	var params []string
+	for name := range query {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+	for _, name := range params {
+		values := query[name]
+		sort.Strings(values)
+		b.WriteString("\n" + strings.ToLower(name) + ":" + strings.Join(values, ","))
+	}
+
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(b.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Init implements StoreInitializer. It creates the container if it doesn't
+// exist.
+func (s *azureStore) Init(ctx context.Context) error {
+	req, err := s.newRequest(ctx, http.MethodPut, s.endpoint+"/"+url.PathEscape(s.container)+"?restype=container", nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := doCloudRequest(ctx, "azure", req, false, http.StatusConflict); err != nil {
+		return fmt.Errorf("failed to create container %s: %w", s.container, err)
+	}
+	return nil
+}
+
+// Get retrieves an image from Azure.
+func (s *azureStore) Get(ctx context.Context, r *ImageRecord) ([]byte, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, s.blobURL(r), nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return doCloudRequest(ctx, "azure", req, true)
+}
+
+// Save stores an image in Azure.
+func (s *azureStore) Save(ctx context.Context, r *ImageRecord, image []byte) error {
+	return s.SaveStream(ctx, r, bytes.NewReader(image), int64(len(image)))
+}
+
+// SaveStream implements StreamSaver. The image is uploaded as a block blob in
+// a single request (which is allowed for blobs of up to 5000MB).
+func (s *azureStore) SaveStream(ctx context.Context, r *ImageRecord, src io.Reader, size int64) error {
+	header := http.Header{}
+	header.Set("Content-Type", r.Format.MimeType())
+	header.Set("x-ms-blob-type", "BlockBlob")
+	req, err := s.newRequest(ctx, http.MethodPut, s.blobURL(r), src, size, header)
+	if err != nil {
+		return err
+	}
+	_, err = doCloudRequest(ctx, "azure", req, false)
+	return err
+}
+
+// Delete removes an image from Azure.
+func (s *azureStore) Delete(ctx context.Context, r *ImageRecord) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, s.blobURL(r), nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	_, err = doCloudRequest(ctx, "azure", req, false, http.StatusNotFound)
+	return err
+}
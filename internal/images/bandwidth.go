@@ -0,0 +1,194 @@
+package images
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// The bytes served by Server are counted per image and per day (in UTC), if
+// bandwidth accounting is enabled, and are periodically flushed to the
+// image_bandwidth table (see FlushBandwidth), from where they can be rolled
+// up per owner of the images.
+
+// BandwidthUsage is the number of requests for images, and the number of
+// bytes served for them.
+type BandwidthUsage struct {
+	Requests int64 `json:"requests"`
+	Bytes    int64 `json:"bytes"`
+
+	// Of the requests from pages of other sites (see Access.External), which
+	// are hotlinks unless they're of allowed hosts.
+	ExternalRequests int64 `json:"externalRequests"`
+	ExternalBytes    int64 `json:"externalBytes"`
+}
+
+func (u *BandwidthUsage) add(v BandwidthUsage) {
+	u.Requests += v.Requests
+	u.Bytes += v.Bytes
+	u.ExternalRequests += v.ExternalRequests
+	u.ExternalBytes += v.ExternalBytes
+}
+
+type bandwidthKey struct {
+	day   time.Time // Midnight, in UTC.
+	image uid.ID
+}
+
+var bandwidth = struct {
+	sync.Mutex
+	enabled bool
+	usage   map[bandwidthKey]*BandwidthUsage
+}{usage: make(map[bandwidthKey]*BandwidthUsage)}
+
+// SetBandwidthAccounting enables, or disables, the counting of the bytes
+// served by Server.
+func SetBandwidthAccounting(enabled bool) {
+	bandwidth.Lock()
+	defer bandwidth.Unlock()
+	bandwidth.enabled = enabled
+}
+
+func bandwidthAccounting() bool {
+	bandwidth.Lock()
+	defer bandwidth.Unlock()
+	return bandwidth.enabled
+}
+
+// day returns the midnight (in UTC) of the day of t.
+func day(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// countBandwidth counts the access a toward the bandwidth usage of its image.
+func countBandwidth(a *Access) {
+	if a.ImageID.Zero() {
+		return
+	}
+	v := BandwidthUsage{Requests: 1, Bytes: a.Bytes}
+	if a.External {
+		v.ExternalRequests, v.ExternalBytes = 1, a.Bytes
+	}
+	countUsage(bandwidthKey{day: day(a.Time), image: a.ImageID}, v)
+}
+
+func countUsage(key bandwidthKey, v BandwidthUsage) {
+	bandwidth.Lock()
+	defer bandwidth.Unlock()
+	u := bandwidth.usage[key]
+	if u == nil {
+		u = &BandwidthUsage{}
+		bandwidth.usage[key] = u
+	}
+	u.add(v)
+}
+
+// maxBandwidthRowsPerInsert is the number of rows inserted at a time by
+// FlushBandwidth.
+const maxBandwidthRowsPerInsert = 500
+
+// FlushBandwidth adds the bandwidth usage counted since the last flush to the
+// image_bandwidth table, and returns the days it was counted on (in order). If
+// it fails, the usage is counted toward the next flush.
+func FlushBandwidth(ctx context.Context, db *sql.DB) ([]time.Time, error) {
+	bandwidth.Lock()
+	usage := bandwidth.usage
+	bandwidth.usage = make(map[bandwidthKey]*BandwidthUsage)
+	bandwidth.Unlock()
+	if len(usage) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]bandwidthKey, 0, len(usage))
+	seen := make(map[time.Time]bool)
+	var days []time.Time
+	for key := range usage {
+		keys = append(keys, key)
+		if !seen[key.day] {
+			seen[key.day] = true
+			days = append(days, key.day)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+
+	if err := insertBandwidth(ctx, db, keys, usage); err != nil {
+		for key, u := range usage {
+			countUsage(key, *u)
+		}
+		return nil, fmt.Errorf("failed to flush image bandwidth: %w", err)
+	}
+	return days, nil
+}
+
+func insertBandwidth(ctx context.Context, db *sql.DB, keys []bandwidthKey, usage map[bandwidthKey]*BandwidthUsage) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for len(keys) > 0 {
+		n := min(len(keys), maxBandwidthRowsPerInsert)
+		var args []any
+		for _, key := range keys[:n] {
+			u := usage[key]
+			args = append(args, key.day, key.image, u.Requests, u.Bytes, u.ExternalRequests, u.ExternalBytes)
+		}
+		query := "INSERT INTO image_bandwidth (day, image_id, requests, bytes, external_requests, external_bytes) VALUES " +
+			strings.Repeat("(?, ?, ?, ?, ?, ?), ", n-1) + "(?, ?, ?, ?, ?, ?) " +
+			`ON DUPLICATE KEY UPDATE
+				requests = requests + VALUES(requests),
+				bytes = bytes + VALUES(bytes),
+				external_requests = external_requests + VALUES(external_requests),
+				external_bytes = external_bytes + VALUES(external_bytes)`
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return tx.Commit()
+}
+
+// ImageBandwidth is the bandwidth usage of an image on a day.
+type ImageBandwidth struct {
+	Day     time.Time `json:"day"`
+	ImageID uid.ID    `json:"imageId"`
+	BandwidthUsage
+}
+
+// GetImageBandwidth returns the limit images that used the most bandwidth on
+// day. If external is true, they're ordered by the bytes served to other
+// sites (to find the images that are hotlinked the most).
+func GetImageBandwidth(ctx context.Context, db *sql.DB, day time.Time, external bool, limit int) ([]*ImageBandwidth, error) {
+	order := "bytes"
+	if external {
+		order = "external_bytes"
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT day, image_id, requests, bytes, external_requests, external_bytes
+		FROM image_bandwidth
+		WHERE day = ?
+		ORDER BY `+order+` DESC
+		LIMIT ?`, day, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []*ImageBandwidth{}
+	for rows.Next() {
+		b := &ImageBandwidth{}
+		if err := rows.Scan(&b.Day, &b.ImageID, &b.Requests, &b.Bytes, &b.ExternalRequests, &b.ExternalBytes); err != nil {
+			return nil, err
+		}
+		list = append(list, b)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,46 @@
+package images
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestCountBandwidth(t *testing.T) {
+	bandwidth.Lock()
+	prev := bandwidth.usage
+	bandwidth.usage = make(map[bandwidthKey]*BandwidthUsage)
+	bandwidth.Unlock()
+	t.Cleanup(func() {
+		bandwidth.Lock()
+		bandwidth.usage = prev
+		bandwidth.Unlock()
+	})
+
+	id := uid.New()
+	noon := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	countBandwidth(&Access{Time: noon, ImageID: id, Bytes: 100})
+	countBandwidth(&Access{Time: noon.Add(time.Hour), ImageID: id, Bytes: 50, External: true})
+	countBandwidth(&Access{Time: noon.Add(12 * time.Hour), ImageID: id, Bytes: 10})
+	countBandwidth(&Access{Time: noon, Bytes: 1000}) // A bad URL.
+
+	first := bandwidth.usage[bandwidthKey{day: day(noon), image: id}]
+	want := BandwidthUsage{Requests: 2, Bytes: 150, ExternalRequests: 1, ExternalBytes: 50}
+	if first == nil || *first != want {
+		t.Errorf("got usage %+v on the first day, want %+v", first, want)
+	}
+	second := bandwidth.usage[bandwidthKey{day: day(noon).AddDate(0, 0, 1), image: id}]
+	if second == nil || second.Requests != 1 || second.Bytes != 10 {
+		t.Errorf("got usage %+v on the second day", second)
+	}
+	if n := len(bandwidth.usage); n != 2 {
+		t.Errorf("got %d usages, want 2", n)
+	}
+
+	bandwidth.usage = make(map[bandwidthKey]*BandwidthUsage)
+	if days, err := FlushBandwidth(context.Background(), nil); err != nil || days != nil {
+		t.Errorf("flushing nothing returned %v, %v", days, err)
+	}
+}
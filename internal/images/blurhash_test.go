@@ -0,0 +1,28 @@
+package images
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestBlurhash(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{255, 0, 0, 255}}, image.Point{}, draw.Src)
+
+	// 4x3 components (L), and a DC component of pure red (TI:j).
+	hash := Blurhash(img)
+	if len(hash) != 28 || hash[0] != 'L' || hash[2:6] != "TI:j" {
+		t.Fatalf("unexpected blurhash of a landscape image: %s", hash)
+	}
+
+	portrait := image.NewRGBA(image.Rect(0, 0, 100, 200))
+	if hash := Blurhash(portrait); len(hash) != 28 || hash[0] != 'T' {
+		t.Fatalf("unexpected blurhash of a portrait image: %s", hash)
+	}
+
+	if hash := Blurhash(image.NewRGBA(image.Rect(0, 0, 0, 0))); hash != "" {
+		t.Fatalf("expected no blurhash for an empty image, got %s", hash)
+	}
+}
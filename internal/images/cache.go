@@ -0,0 +1,252 @@
+package images
+
+import (
+	"container/list"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/metrics"
+)
+
+// Transformed images are cached on disk, alongside the originals (see
+// putToCache). The total size of the cache is kept under a limit by evicting
+// the least recently used images, both as images are added and when the cache
+// is swept (see SweepCache).
+
+// diskCache tracks the files of the image cache in LRU order.
+type diskCache struct {
+	mu      sync.Mutex
+	maxSize int64 // If 0, the cache is not limited in size.
+	size    int64
+	lru     *list.List // of *cacheEntry; most recently used first
+	entries map[string]*list.Element
+
+	hits, misses, evictions int64
+	lastSweep               time.Time
+}
+
+var (
+	cacheHits   = metrics.NewCounter("discuit_image_cache_hits_total", "Transformed images served from the image cache.")
+	cacheMisses = metrics.NewCounter("discuit_image_cache_misses_total", "Transformed images not found in the image cache.")
+)
+
+type cacheEntry struct {
+	path string
+	size int64
+}
+
+func newDiskCache() *diskCache {
+	return &diskCache{
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+var cache = newDiskCache()
+
+// SetCacheMaxSize sets the maximum total size, in bytes, of the cached
+// images. If n is 0, the cache is not limited in size. Call SweepCache after
+// calling SetCacheMaxSize, so that the images already cached are accounted
+// for.
+func SetCacheMaxSize(n int64) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.maxSize = n
+}
+
+// SweepCache reconciles the cache with the images folder (which may be
+// changed by other processes), and evicts the least recently used images
+// until the cache is within its size limit. It returns the number of images
+// evicted.
+func SweepCache() (int, error) {
+	return cache.sweep(filesRootFolder)
+}
+
+// CacheStats are the metrics of the image cache since the program started.
+type CacheStats struct {
+	Entries   int       `json:"entries"`
+	Size      int64     `json:"size"`    // In bytes.
+	MaxSize   int64     `json:"maxSize"` // 0 if not limited.
+	Hits      int64     `json:"hits"`
+	Misses    int64     `json:"misses"`
+	HitRate   float64   `json:"hitRate"` // Between 0 and 1.
+	Evictions int64     `json:"evictions"`
+	LastSweep time.Time `json:"lastSweep"`
+}
+
+// GetCacheStats returns the metrics of the image cache.
+func GetCacheStats() CacheStats {
+	return cache.stats()
+}
+
+func (c *diskCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := CacheStats{
+		Entries:   len(c.entries),
+		Size:      c.size,
+		MaxSize:   c.maxSize,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		LastSweep: c.lastSweep,
+	}
+	if total := c.hits + c.misses; total > 0 {
+		s.HitRate = float64(c.hits) / float64(total)
+	}
+	return s
+}
+
+// hit records a read of the cached file at path.
+func (c *diskCache) hit(path string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hits++
+	c.touch(path, size)
+	cacheHits.Inc()
+}
+
+func (c *diskCache) miss() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.misses++
+	cacheMisses.Inc()
+}
+
+// add records the file at path as having been added to the cache, and evicts
+// images if the cache is over its size limit.
+func (c *diskCache) add(path string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.touch(path, size)
+	c.evict()
+}
+
+// touch marks the file at path as the most recently used. c.mu must be held.
+func (c *diskCache) touch(path string, size int64) {
+	if el, ok := c.entries[path]; ok {
+		entry := el.Value.(*cacheEntry)
+		c.size += size - entry.size
+		entry.size = size
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[path] = c.lru.PushFront(&cacheEntry{path: path, size: size})
+	c.size += size
+}
+
+// remove records the file at path as having been removed from the cache.
+func (c *diskCache) remove(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeEntry(path)
+}
+
+func (c *diskCache) removeEntry(path string) {
+	if el, ok := c.entries[path]; ok {
+		c.size -= el.Value.(*cacheEntry).size
+		c.lru.Remove(el)
+		delete(c.entries, path)
+	}
+}
+
+// reset records all the files of the cache as having been removed.
+func (c *diskCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.size = 0
+}
+
+// evict removes the least recently used files until c is within its size
+// limit, and returns the number of files removed. c.mu must be held.
+func (c *diskCache) evict() (n int) {
+	if c.maxSize <= 0 {
+		return 0
+	}
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		entry := c.lru.Back().Value.(*cacheEntry)
+		if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+			log.Printf("images: error evicting cached image %s: %v\n", entry.path, err)
+		}
+		c.removeEntry(entry.path)
+		c.evictions++
+		n++
+	}
+	return n
+}
+
+// isCacheFile reports whether the file with name in the images folder is a
+// cached image (as opposed to an original image).
+func isCacheFile(name string) bool {
+	return strings.Contains(name, "_")
+}
+
+func (c *diskCache) sweep(root string) (int, error) {
+	type file struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			log.Printf("skipping unwalkable directory: %v", err)
+			return nil
+		}
+		if d.IsDir() || !isCacheFile(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // removed since
+		}
+		files = append(files, file{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	onDisk := make(map[string]bool, len(files))
+	for _, f := range files {
+		onDisk[f.path] = true
+	}
+	for path := range c.entries {
+		if !onDisk[path] {
+			c.removeEntry(path)
+		}
+	}
+
+	// Files not yet tracked (cached by another process, or before this one
+	// started) are ranked below all the tracked ones, by modification time.
+	slices.SortFunc(files, func(a, b file) int {
+		return b.modTime.Compare(a.modTime)
+	})
+	for _, f := range files {
+		if el, ok := c.entries[f.path]; ok {
+			entry := el.Value.(*cacheEntry)
+			c.size += f.size - entry.size
+			entry.size = f.size
+			continue
+		}
+		c.entries[f.path] = c.lru.PushBack(&cacheEntry{path: f.path, size: f.size})
+		c.size += f.size
+	}
+
+	c.lastSweep = time.Now()
+	return c.evict(), nil
+}
@@ -0,0 +1,83 @@
+package images
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCacheFile(t *testing.T, path string, size int, modTime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestDiskCacheSweep(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	original := filepath.Join(root, "ab/c/original.jpeg")
+	oldest := filepath.Join(root, "ab/c/original_120_cover.jpeg")
+	older := filepath.Join(root, "ab/d/other_325x250_cover.jpeg")
+	newest := filepath.Join(root, "ab/d/other_720x1440_contain.jpeg")
+	writeCacheFile(t, original, 1000, now.Add(-time.Hour*3))
+	writeCacheFile(t, oldest, 100, now.Add(-time.Hour*2))
+	writeCacheFile(t, older, 100, now.Add(-time.Hour))
+	writeCacheFile(t, newest, 100, now)
+
+	c := newDiskCache()
+	if n, err := c.sweep(root); err != nil || n != 0 {
+		t.Fatalf("sweep of unlimited cache: evicted %d, error %v", n, err)
+	}
+	if s := c.stats(); s.Entries != 3 || s.Size != 300 {
+		t.Fatalf("expected 3 entries of 300 bytes, got %d entries of %d bytes", s.Entries, s.Size)
+	}
+
+	c.maxSize = 200
+	if n, err := c.sweep(root); err != nil || n != 1 {
+		t.Fatalf("expected 1 eviction, got %d (error: %v)", n, err)
+	}
+	if exists(oldest) || !exists(older) || !exists(newest) || !exists(original) {
+		t.Fatal("the least recently modified cache file was not the one evicted")
+	}
+
+	// Reading a file makes it the most recently used.
+	c.hit(older, 100)
+	writeCacheFile(t, oldest, 100, now) // re-cached
+	c.add(oldest, 100)
+	if exists(newest) || !exists(older) || !exists(oldest) {
+		t.Fatal("the least recently used cache file was not the one evicted")
+	}
+
+	// Files removed by others are forgotten.
+	os.Remove(older)
+	if _, err := c.sweep(root); err != nil {
+		t.Fatal(err)
+	}
+	if s := c.stats(); s.Entries != 1 || s.Size != 100 || s.Evictions != 2 {
+		t.Fatalf("unexpected stats after sweep: %+v", s)
+	}
+}
+
+func TestDiskCacheHitRate(t *testing.T) {
+	c := newDiskCache()
+	c.hit("a", 1)
+	c.hit("a", 1)
+	c.hit("b", 1)
+	c.miss()
+	if s := c.stats(); s.HitRate != 0.75 || s.Entries != 2 || s.Size != 2 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
@@ -0,0 +1,75 @@
+package images
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// storeObjectKey returns the key (the path within a bucket or a container) of
+// the image with id and format in object stores. It's the path of the image on
+// disk (see idToFolder), under prefix.
+func storeObjectKey(prefix string, id uid.ID, format ImageFormat) string {
+	folder, filename := idToFolder(id)
+	key := path.Join(folder, filename+format.Extension())
+	if prefix != "" {
+		key = path.Join(prefix, key)
+	}
+	return key
+}
+
+// cloudRequestTimeout is the time limit of each request to the object stores
+// that are accessed over their HTTP APIs (GCS and Azure).
+const cloudRequestTimeout = time.Minute
+
+// cloudHTTPClient is the client of stores accessed over HTTP APIs.
+var cloudHTTPClient = &http.Client{}
+
+// cloudResponseError returns the error of an unsuccessful response of an
+// object store API. The error wraps ErrImageNotFound if the status code of res
+// is 404.
+func cloudResponseError(store string, res *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	msg := strings.TrimSpace(string(body))
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s: %w (%s)", store, ErrImageNotFound, msg)
+	}
+	return fmt.Errorf("%s: %s %s: %s (%s)", store, res.Request.Method, res.Request.URL.Path, res.Status, msg)
+}
+
+// doCloudRequest sends req, limited to cloudRequestTimeout, and returns the
+// body of a successful response. If read is false, the body is discarded.
+// Responses with any of the status codes in ok, besides 2xx responses, are
+// considered successful.
+func doCloudRequest(ctx context.Context, store string, req *http.Request, read bool, ok ...int) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, cloudRequestTimeout)
+	defer cancel()
+	res, err := cloudHTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", store, err)
+	}
+	defer res.Body.Close()
+
+	success := res.StatusCode >= 200 && res.StatusCode < 300
+	for _, code := range ok {
+		success = success || res.StatusCode == code
+	}
+	if !success {
+		return nil, cloudResponseError(store, res)
+	}
+	if !read {
+		io.Copy(io.Discard, res.Body)
+		return nil, nil
+	}
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to read response: %w", store, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,321 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// testStoreRoundTrip saves, gets, and deletes an image in s.
+func testStoreRoundTrip(t *testing.T, s Store) {
+	ctx := context.Background()
+	if i, ok := s.(StoreInitializer); ok {
+		if err := i.Init(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record := &ImageRecord{ID: uid.New(), Format: ImageFormatJPEG}
+	image := bytes.Repeat([]byte("image"), 1000)
+
+	if _, err := s.Get(ctx, record); !errors.Is(err, ErrImageNotFound) {
+		t.Fatalf("expected ErrImageNotFound getting an unsaved image, got %v", err)
+	}
+	if err := s.Save(ctx, record, image); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get(ctx, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, image) {
+		t.Fatalf("got an image of %d bytes, expected the saved image of %d bytes", len(got), len(image))
+	}
+	if err := s.Delete(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, record); !errors.Is(err, ErrImageNotFound) {
+		t.Fatalf("expected ErrImageNotFound getting a deleted image, got %v", err)
+	}
+	if err := s.Delete(ctx, record); err != nil {
+		t.Fatalf("deleting a deleted image: %v", err)
+	}
+}
+
+// fakeGCS is a GCS JSON API server with a single bucket and a token endpoint
+// that accepts JWTs signed with key.
+type fakeGCS struct {
+	bucket string
+	key    *rsa.PublicKey
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+const fakeGCSToken = "fake-access-token"
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		if err := f.verifyAssertion(r.FormValue("assertion")); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"access_token": fakeGCSToken, "expires_in": 3600})
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+fakeGCSToken {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bucketPath := "/storage/v1/b/" + f.bucket
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == bucketPath:
+		w.Write([]byte(`{}`))
+	case r.Method == http.MethodPost && r.URL.Path == "/upload"+bucketPath+"/o":
+		data, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.Query().Get("name")] = data
+		w.Write([]byte(`{}`))
+	case strings.HasPrefix(r.URL.Path, bucketPath+"/o/"):
+		name := strings.TrimPrefix(r.URL.Path, bucketPath+"/o/")
+		data, ok := f.objects[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if r.URL.Query().Get("alt") == "media" {
+				w.Write(data)
+			} else {
+				w.Write([]byte(`{}`))
+			}
+		case http.MethodDelete:
+			delete(f.objects, name)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeGCS) verifyAssertion(jwt string) error {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed jwt")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(f.key, crypto.SHA256, hash[:], sig); err != nil {
+		return err
+	}
+	claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	var c struct{ Scope string }
+	if err := json.Unmarshal(claims, &c); err != nil {
+		return err
+	}
+	if c.Scope != gcsScope {
+		return errors.New("bad scope")
+	}
+	return nil
+}
+
+func TestGCSStore(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeGCS{bucket: "images", key: &key.PublicKey, objects: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentials, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "discuit@example.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    server.URL + "/token",
+	})
+	tokens, err := newGCSTokenSource(credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newGCSStore(server.URL, "images", "prefix", tokens)
+	testStoreRoundTrip(t, store)
+
+	record := &ImageRecord{ID: uid.New(), Format: ImageFormatPNG}
+	if err := store.Save(context.Background(), record, []byte("png")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.objects[storeObjectKey("prefix", record.ID, record.Format)]; !ok {
+		t.Fatal("image not saved under the prefix")
+	}
+}
+
+// fakeAzure is an Azure Blob Storage server that checks the Shared Key
+// signatures of requests.
+type fakeAzure struct {
+	signer *azureStore
+
+	mu         sync.Mutex
+	containers map[string]bool
+	blobs      map[string][]byte
+}
+
+func (f *fakeAzure) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if want := "SharedKey " + f.signer.account + ":" + f.signer.signature(r); r.Header.Get("Authorization") != want {
+		http.Error(w, "bad signature", http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Query().Get("restype") == "container" {
+		name := strings.Trim(r.URL.Path, "/")
+		if f.containers[name] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.containers[name] = true
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	container, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if !f.containers[container] {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		if r.Header.Get("x-ms-blob-type") != "BlockBlob" {
+			http.Error(w, "missing blob type", http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		f.blobs[r.URL.Path] = data
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet, http.MethodDelete:
+		data, ok := f.blobs[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			delete(f.blobs, r.URL.Path)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Write(data)
+	}
+}
+
+func TestAzureStore(t *testing.T) {
+	opts := AzureOptions{
+		Account:    "discuit",
+		AccountKey: base64.StdEncoding.EncodeToString([]byte("secret key")),
+		Container:  "images",
+		PathPrefix: "prefix",
+	}
+	signer, err := newAzureStore(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(&fakeAzure{signer: signer, containers: make(map[string]bool), blobs: make(map[string][]byte)})
+	defer server.Close()
+
+	opts.Endpoint = server.URL
+	store, err := newAzureStore(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStoreRoundTrip(t, store)
+	if err := store.Init(context.Background()); err != nil {
+		t.Fatalf("initializing with an existing container: %v", err)
+	}
+
+	opts.AccountKey = base64.StdEncoding.EncodeToString([]byte("wrong key"))
+	wrongKey, err := newAzureStore(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wrongKey.Init(context.Background()); err == nil {
+		t.Fatal("expected an error with the wrong account key")
+	}
+}
+
+// The tests below run against emulators, if their endpoints are set:
+//
+//	docker run -p 4443:4443 fsouza/fake-gcs-server -scheme http
+//	DISCUIT_TEST_GCS_ENDPOINT=http://localhost:4443 go test ./internal/images
+//
+//	docker run -p 10000:10000 mcr.microsoft.com/azure-storage/azurite azurite-blob --blobHost 0.0.0.0
+//	DISCUIT_TEST_AZURITE_ENDPOINT=http://127.0.0.1:10000/devstoreaccount1 go test ./internal/images
+
+func TestGCSStoreEmulator(t *testing.T) {
+	endpoint := os.Getenv("DISCUIT_TEST_GCS_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("DISCUIT_TEST_GCS_ENDPOINT not set")
+	}
+	bucket := "discuit-test-" + strings.ToLower(uid.New().String())
+	body, _ := json.Marshal(map[string]string{"name": bucket})
+	res, err := http.Post(endpoint+"/storage/v1/b?project=test", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("failed to create bucket: %s", res.Status)
+	}
+	testStoreRoundTrip(t, newGCSStore(endpoint, bucket, "prefix", nil))
+}
+
+func TestAzureStoreEmulator(t *testing.T) {
+	endpoint := os.Getenv("DISCUIT_TEST_AZURITE_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("DISCUIT_TEST_AZURITE_ENDPOINT not set")
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The well-known account of Azurite.
+	store, err := newAzureStore(AzureOptions{
+		Account:    strings.Trim(u.Path, "/"),
+		AccountKey: "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==",
+		Container:  "discuit-test-" + strings.ToLower(uid.New().String()),
+		Endpoint:   endpoint,
+		PathPrefix: "prefix",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStoreRoundTrip(t, store)
+}
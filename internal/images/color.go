@@ -0,0 +1,89 @@
+package images
+
+import (
+	"image"
+	"runtime"
+	"sync"
+)
+
+// averageColorSamples is the max number of pixels, along each axis, sampled
+// by AverageColor.
+const averageColorSamples = 512
+
+// parallelSamplingThreshold is the number of pixels sampled by AverageColor
+// above which sampling is split across goroutines.
+const parallelSamplingThreshold = 64 * 64
+
+// colorSum is the sum of the alpha-premultiplied colors of a set of pixels.
+type colorSum struct {
+	r, g, b, a uint64
+}
+
+func (s *colorSum) add(o colorSum) {
+	s.r += o.r
+	s.g += o.g
+	s.b += o.b
+	s.a += o.a
+}
+
+// AverageColor returns the mean RGB color of img, computed over an evenly
+// spaced grid of at most 512x512 of its pixels. Pixels are weighted by their
+// opacity, so fully transparent pixels don't count. If img is fully
+// transparent (or empty), it returns black.
+func AverageColor(img image.Image) RGB {
+	b := img.Bounds()
+	if b.Empty() {
+		return RGB{}
+	}
+	w, h := min(b.Dx(), averageColorSamples), min(b.Dy(), averageColorSamples)
+
+	// sampleRows returns the sum of the colors of the sampled pixels in rows
+	// [from, to) of the sampling grid.
+	sampleRows := func(from, to int) colorSum {
+		var sum colorSum
+		for y := from; y < to; y++ {
+			py := b.Min.Y + y*b.Dy()/h
+			for x := 0; x < w; x++ {
+				r, g, bl, a := img.At(b.Min.X+x*b.Dx()/w, py).RGBA()
+				sum.r += uint64(r)
+				sum.g += uint64(g)
+				sum.b += uint64(bl)
+				sum.a += uint64(a)
+			}
+		}
+		return sum
+	}
+
+	var total colorSum
+	workers := min(runtime.GOMAXPROCS(0), h)
+	if w*h < parallelSamplingThreshold || workers < 2 {
+		total = sampleRows(0, h)
+	} else {
+		var (
+			mu sync.Mutex
+			wg sync.WaitGroup
+		)
+		rowsPerWorker := (h + workers - 1) / workers
+		for from := 0; from < h; from += rowsPerWorker {
+			wg.Add(1)
+			go func(from, to int) {
+				defer wg.Done()
+				sum := sampleRows(from, to)
+				mu.Lock()
+				total.add(sum)
+				mu.Unlock()
+			}(from, min(from+rowsPerWorker, h))
+		}
+		wg.Wait()
+	}
+
+	if total.a == 0 {
+		return RGB{}
+	}
+	// The colors are premultiplied by alpha, so dividing by the total alpha
+	// yields the opacity-weighted mean of the colors (in [0, 1]).
+	scale := func(v uint64) uint32 {
+		return uint32((v*255 + total.a/2) / total.a)
+	}
+	return RGB{Red: scale(total.r), Green: scale(total.g), Blue: scale(total.b)}
+}
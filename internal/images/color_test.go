@@ -0,0 +1,50 @@
+package images
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestAverageColor(t *testing.T) {
+	// Half red, half blue.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 50))
+	draw.Draw(img, image.Rect(0, 0, 50, 50), &image.Uniform{color.RGBA{255, 0, 0, 255}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(50, 0, 100, 50), &image.Uniform{color.RGBA{0, 0, 255, 255}}, image.Point{}, draw.Src)
+	if c := AverageColor(img); c != (RGB{Red: 128, Green: 0, Blue: 128}) {
+		t.Errorf("half red, half blue: expected rgb(128,0,128), got %v", c)
+	}
+
+	// Transparent pixels don't count.
+	img = image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(img, image.Rect(0, 0, 5, 10), &image.Uniform{color.RGBA{0, 255, 0, 255}}, image.Point{}, draw.Src)
+	if c := AverageColor(img); c != (RGB{Green: 255}) {
+		t.Errorf("half green, half transparent: expected rgb(0,255,0), got %v", c)
+	}
+
+	// Images whose bounds don't start at the origin, sampled in parallel.
+	big := image.NewRGBA(image.Rect(1000, 1000, 3000, 2000))
+	draw.Draw(big, big.Bounds(), &image.Uniform{color.RGBA{10, 20, 30, 255}}, image.Point{}, draw.Src)
+	if c := AverageColor(big); c != (RGB{Red: 10, Green: 20, Blue: 30}) {
+		t.Errorf("offset image: expected rgb(10,20,30), got %v", c)
+	}
+
+	if c := AverageColor(image.NewRGBA(image.Rect(0, 0, 0, 0))); c != (RGB{}) {
+		t.Errorf("empty image: expected black, got %v", c)
+	}
+}
+
+func benchmarkAverageColor(b *testing.B, width, height int) {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		AverageColor(img)
+	}
+}
+
+func BenchmarkAverageColorSmall(b *testing.B) { benchmarkAverageColor(b, 50, 50) }
+func BenchmarkAverageColorLarge(b *testing.B) { benchmarkAverageColor(b, 4000, 3000) }
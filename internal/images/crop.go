@@ -0,0 +1,151 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/discuitnet/discuit/internal/uid"
+	"golang.org/x/image/draw"
+)
+
+// Images can be cropped as they're uploaded, to a rectangle picked by the
+// uploader (see ImageOptions.Crop). Cropping is non-destructive: the uploaded
+// image is saved, uncropped, as well, as the source of the cropped image
+// (ImageRecord.SourceID), along with the crop (ImageRecord.Crop), so that the
+// image can be cropped again later (see RecropImageTx). Sources are referred
+// to by the images cropped from them, and so are garbage collected once those
+// are deleted.
+
+// minCropSize is the minimum width and height of a crop.
+const minCropSize = 16
+
+// ErrInvalidCrop is matched (using errors.Is) by the errors returned for crops
+// that are out of the bounds of their images, too small, or of images that
+// cannot be cropped.
+var ErrInvalidCrop = errors.New("invalid image crop")
+
+// CropRect is a rectangle an image is cropped to, in the pixels of the image
+// as it's displayed (that is, after its EXIF orientation is applied). Its
+// text form is "x,y,width,height".
+type CropRect struct {
+	X, Y          int
+	Width, Height int
+}
+
+// String implements fmt.Stringer.
+func (c CropRect) String() string {
+	return fmt.Sprintf("%d,%d,%d,%d", c.X, c.Y, c.Width, c.Height)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (c CropRect) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (c *CropRect) UnmarshalText(text []byte) error {
+	parts := strings.Split(string(text), ",")
+	if len(parts) != 4 {
+		return fmt.Errorf("%w: %q is not of the form x,y,width,height", ErrInvalidCrop, text)
+	}
+	var n [4]int
+	for i, part := range parts {
+		v, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return fmt.Errorf("%w: %q is not of the form x,y,width,height", ErrInvalidCrop, text)
+		}
+		n[i] = v
+	}
+	c.X, c.Y, c.Width, c.Height = n[0], n[1], n[2], n[3]
+	return nil
+}
+
+// ParseCropRect parses the text form of a CropRect. If s is empty, it returns
+// nil.
+func ParseCropRect(s string) (*CropRect, error) {
+	if s == "" {
+		return nil, nil
+	}
+	c := &CropRect{}
+	if err := c.UnmarshalText([]byte(s)); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Scan implements sql.Scanner.
+func (c *CropRect) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return c.UnmarshalText(v)
+	case string:
+		return c.UnmarshalText([]byte(v))
+	}
+	return fmt.Errorf("cannot scan %T into CropRect", src)
+}
+
+// Value implements driver.Valuer.
+func (c CropRect) Value() (driver.Value, error) {
+	return c.String(), nil
+}
+
+// validate reports whether c is within an image of width by height pixels,
+// and large enough.
+func (c CropRect) validate(width, height int) error {
+	if c.Width < minCropSize || c.Height < minCropSize {
+		return fmt.Errorf("%w: crops must be at least %dx%d", ErrInvalidCrop, minCropSize, minCropSize)
+	}
+	if c.X < 0 || c.Y < 0 || c.X+c.Width > width || c.Y+c.Height > height {
+		return fmt.Errorf("%w: %v is out of the bounds of the %dx%d image", ErrInvalidCrop, c, width, height)
+	}
+	return nil
+}
+
+// cropImage returns the part of img within c, which must be valid for img.
+func cropImage(img image.Image, c CropRect) image.Image {
+	min := img.Bounds().Min
+	dst := image.NewRGBA(image.Rect(0, 0, c.Width, c.Height))
+	draw.Draw(dst, dst.Bounds(), img, image.Pt(min.X+c.X, min.Y+c.Y), draw.Src)
+	return dst
+}
+
+// RecropImageTx saves, within tx, a new image cropped to crop from the source
+// of the image with id (which must itself be a cropped image), processed as
+// per opts (opts.Crop is ignored), and returns its id. The image with id is
+// left as is; delete it once the new image replaces it. The new image is not
+// counted against any upload quota. Call QueuePostProcessing with the image
+// once tx is committed.
+func RecropImageTx(ctx context.Context, tx *sql.Tx, db *sql.DB, id uid.ID, crop CropRect, opts *ImageOptions) (uid.ID, error) {
+	record, err := GetImageRecord(ctx, db, id)
+	if err != nil {
+		return uid.ID{}, err
+	}
+	if record.SourceID == nil {
+		return uid.ID{}, fmt.Errorf("%w: the image was not cropped when uploaded", ErrInvalidCrop)
+	}
+	source, err := GetImageRecord(ctx, db, *record.SourceID)
+	if err != nil {
+		return uid.ID{}, err
+	}
+	if !source.StoreExists() || !record.StoreExists() {
+		return uid.ID{}, ErrStoreNotRegistered
+	}
+	data, err := source.store().Get(ctx, source)
+	if err != nil {
+		return uid.ID{}, fmt.Errorf("error getting source of image %v: %w", id, err)
+	}
+
+	o := ImageOptions{Format: ImageFormatJPEG}
+	if opts != nil {
+		o = *opts
+	}
+	o.Crop, o.Quota = &crop, nil
+	return saveUploadTx(ctx, tx, record.StoreName, bytes.NewReader(data), int64(len(data)), &o, &source.ID)
+}
@@ -0,0 +1,100 @@
+package images
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/png"
+	"testing"
+)
+
+func TestParseCropRect(t *testing.T) {
+	c, err := ParseCropRect("10, 20,300,400")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (CropRect{X: 10, Y: 20, Width: 300, Height: 400}); *c != want {
+		t.Errorf("got %v, want %v", *c, want)
+	}
+	if c, err := ParseCropRect(""); c != nil || err != nil {
+		t.Errorf("got %v, %v for an empty crop", c, err)
+	}
+	for _, s := range []string{"1,2,3", "1,2,3,4,5", "a,2,3,4"} {
+		if _, err := ParseCropRect(s); !errors.Is(err, ErrInvalidCrop) {
+			t.Errorf("%q: got error %v, want ErrInvalidCrop", s, err)
+		}
+	}
+}
+
+func TestCropRectValidate(t *testing.T) {
+	for _, c := range []struct {
+		crop  CropRect
+		valid bool
+	}{
+		{CropRect{0, 0, 100, 50}, true},
+		{CropRect{20, 10, 80, 40}, true},
+		{CropRect{-1, 0, 50, 50}, false},
+		{CropRect{60, 0, 50, 50}, false},
+		{CropRect{0, 10, 50, 50}, false},
+		{CropRect{0, 0, 8, 50}, false},
+	} {
+		if err := c.crop.validate(100, 50); (err == nil) != c.valid {
+			t.Errorf("validate(%v) = %v", c.crop, err)
+		}
+	}
+}
+
+func TestProcessUploadCrop(t *testing.T) {
+	// A 40x20 image, red on the left half and blue on the right.
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if x >= 20 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var data bytes.Buffer
+	if err := png.Encode(&data, img); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	crop := &CropRect{X: 20, Y: 0, Width: 20, Height: 20}
+	p, err := processUpload(&buf, bytes.NewReader(data.Bytes()), &ImageOptions{Format: ImageFormatPNG, Crop: crop})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.width != 20 || p.height != 20 {
+		t.Errorf("expected a 20x20 image, got %dx%d", p.width, p.height)
+	}
+	out, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _, b, _ := out.At(0, 0).RGBA(); r != 0 || b == 0 {
+		t.Error("the crop is not of the right half of the image")
+	}
+
+	crop.X = 30
+	if _, err := processUpload(&buf, bytes.NewReader(data.Bytes()), &ImageOptions{Format: ImageFormatPNG, Crop: crop}); !errors.Is(err, ErrInvalidCrop) {
+		t.Errorf("got error %v for a crop out of bounds, want ErrInvalidCrop", err)
+	}
+}
+
+func TestProcessUploadCropGIF(t *testing.T) {
+	var data bytes.Buffer
+	if err := gif.Encode(&data, image.NewPaletted(image.Rect(0, 0, 40, 40), palette.Plan9), nil); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	_, err := processUpload(&buf, bytes.NewReader(data.Bytes()), &ImageOptions{Crop: &CropRect{0, 0, 20, 20}})
+	if !errors.Is(err, ErrInvalidCrop) {
+		t.Errorf("got error %v for a crop of a GIF image, want ErrInvalidCrop", err)
+	}
+}
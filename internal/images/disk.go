@@ -1,10 +1,12 @@
 package images
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path"
@@ -15,7 +17,7 @@ import (
 // In the working directory of the running process. Beware when running tests.
 var filesRootFolder = ""
 
-// diskStore implements the interface store.
+// diskStore implements the Store interface.
 //
 // All images are stored inside rootFolder, in a sub-sub-folder. To determine
 // the subfolder, the ID of the image is hashed and the hash's first three
@@ -27,11 +29,11 @@ func newDiskStore() *diskStore {
 	return &diskStore{}
 }
 
-func (ds *diskStore) name() string {
+func (ds *diskStore) Name() string {
 	return "disk"
 }
 
-func (ds *diskStore) get(r *ImageRecord) ([]byte, error) {
+func (ds *diskStore) Get(ctx context.Context, r *ImageRecord) ([]byte, error) {
 	filepath, err := ds.imagePath(r.ID, r.Format)
 	if err != nil {
 		return nil, err
@@ -88,7 +90,7 @@ func (ds *diskStore) imagePath(imageID uid.ID, f ImageFormat) (p string, err err
 	return
 }
 
-func (ds *diskStore) save(r *ImageRecord, image []byte) error {
+func (ds *diskStore) Save(ctx context.Context, r *ImageRecord, image []byte) error {
 	filepath, err := ds.imagePath(r.ID, r.Format)
 	if err != nil {
 		return fmt.Errorf("error creating images folder: %v", err)
@@ -99,7 +101,24 @@ func (ds *diskStore) save(r *ImageRecord, image []byte) error {
 	return nil
 }
 
-func (ds *diskStore) delete(r *ImageRecord) error {
+// SaveStream implements StreamSaver.
+func (ds *diskStore) SaveStream(ctx context.Context, r *ImageRecord, src io.Reader, size int64) error {
+	filepath, err := ds.imagePath(r.ID, r.Format)
+	if err != nil {
+		return fmt.Errorf("error creating images folder: %v", err)
+	}
+	file, err := os.OpenFile(filepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating image file %v: %v", filepath, err)
+	}
+	if _, err := io.Copy(file, src); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing image file %v: %v", filepath, err)
+	}
+	return file.Close()
+}
+
+func (ds *diskStore) Delete(ctx context.Context, r *ImageRecord) error {
 	filepath, err := ds.imagePath(r.ID, r.Format)
 	if err != nil {
 		return err
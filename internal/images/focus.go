@@ -0,0 +1,227 @@
+package images
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/draw"
+)
+
+// Variants of images that are fitted into a box as per ImageFitCover lose the
+// parts of the image that overflow the box. Rather than cropping those off
+// equally from both sides, which cuts the heads off people in portraits, the
+// variants are cropped toward the focal point of the image, found when it's
+// uploaded: the busiest part of the image (by the entropy of its pixels),
+// favoring skin tones, or, if a FocalPointDetector is set (say, a face
+// detector), the point it detects. Focal points are found on the stored
+// image, and so after the image is rotated as per its EXIF orientation.
+
+// focusSampleSize is the width and height of the box images are shrunk into
+// to find their focal points, and focusCellSize the width and height of the
+// cells of the shrunk image that are scored.
+const (
+	focusSampleSize = 96
+	focusCellSize   = 8
+)
+
+// FocalPoint is the point of an image that variants of it are cropped toward,
+// as fractions (from 0 to 1) of the width and height of the image, from its
+// top left corner. Its text form is "x,y".
+type FocalPoint struct {
+	X, Y float64
+}
+
+// String implements fmt.Stringer.
+func (p FocalPoint) String() string {
+	return strconv.FormatFloat(p.X, 'f', 3, 64) + "," + strconv.FormatFloat(p.Y, 'f', 3, 64)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (p FocalPoint) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (p *FocalPoint) UnmarshalText(text []byte) error {
+	xs, ys, ok := strings.Cut(string(text), ",")
+	if !ok {
+		return fmt.Errorf("invalid focal point %q", text)
+	}
+	x, err := strconv.ParseFloat(xs, 64)
+	if err != nil {
+		return fmt.Errorf("invalid focal point %q", text)
+	}
+	y, err := strconv.ParseFloat(ys, 64)
+	if err != nil {
+		return fmt.Errorf("invalid focal point %q", text)
+	}
+	*p = FocalPoint{X: x, Y: y}.clamp()
+	return nil
+}
+
+// Scan implements sql.Scanner.
+func (p *FocalPoint) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return p.UnmarshalText(v)
+	case string:
+		return p.UnmarshalText([]byte(v))
+	}
+	return fmt.Errorf("cannot scan %T into FocalPoint", src)
+}
+
+// Value implements driver.Valuer.
+func (p FocalPoint) Value() (driver.Value, error) {
+	return p.String(), nil
+}
+
+// clamp returns p within the image.
+func (p FocalPoint) clamp() FocalPoint {
+	p.X = math.Min(math.Max(p.X, 0), 1)
+	p.Y = math.Min(math.Max(p.Y, 0), 1)
+	return p
+}
+
+// key returns the part of the file names of cached variants that tells apart
+// the variants cropped toward different focal points.
+func (p FocalPoint) key() string {
+	return fmt.Sprintf("%03d-%03d", int(math.Round(p.X*1000)), int(math.Round(p.Y*1000)))
+}
+
+// entropyFocalPoint returns the focal point of img: the weighted center of the
+// cells of the image that are the busiest (that have the most entropy in
+// their luminance), with cells of skin tones weighted up. It returns nil for
+// images with no detail (of a single color, say), which are best cropped in
+// the center.
+func entropyFocalPoint(img image.Image) *FocalPoint {
+	bounds := img.Bounds()
+	if bounds.Dx() == 0 || bounds.Dy() == 0 {
+		return nil
+	}
+	w, h := ImageContainSize(bounds.Dx(), bounds.Dy(), focusSampleSize, focusSampleSize)
+	small := image.NewRGBA(image.Rect(0, 0, max(w, 1), max(h, 1)))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, bounds, draw.Src, nil)
+
+	cols, rows := (small.Rect.Dx()+focusCellSize-1)/focusCellSize, (small.Rect.Dy()+focusCellSize-1)/focusCellSize
+	weights := make([]float64, cols*rows)
+	maxWeight := 0.0
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			cell := image.Rect(col*focusCellSize, row*focusCellSize, (col+1)*focusCellSize, (row+1)*focusCellSize).Intersect(small.Rect)
+			var hist [16]int
+			n, skin := 0, 0
+			for y := cell.Min.Y; y < cell.Max.Y; y++ {
+				for x := cell.Min.X; x < cell.Max.X; x++ {
+					i := small.PixOffset(x, y)
+					yy, cb, cr := color.RGBToYCbCr(small.Pix[i], small.Pix[i+1], small.Pix[i+2])
+					hist[yy>>4]++
+					if cb >= 77 && cb <= 127 && cr >= 133 && cr <= 173 {
+						skin++
+					}
+					n++
+				}
+			}
+			entropy := 0.0
+			for _, c := range hist {
+				if c > 0 {
+					p := float64(c) / float64(n)
+					entropy -= p * math.Log2(p)
+				}
+			}
+			weight := entropy * (1 + 2*float64(skin)/float64(n))
+			weight *= weight
+			weights[row*cols+col] = weight
+			maxWeight = math.Max(maxWeight, weight)
+		}
+	}
+	if maxWeight == 0 {
+		return nil
+	}
+
+	// The center of the cells of at least half the weight of the busiest.
+	var sx, sy, sw float64
+	for i, weight := range weights {
+		if weight < maxWeight/2 {
+			continue
+		}
+		col, row := i%cols, i/cols
+		sx += weight * (float64(col) + 0.5) * focusCellSize
+		sy += weight * (float64(row) + 0.5) * focusCellSize
+		sw += weight
+	}
+	p := FocalPoint{X: sx / sw / float64(small.Rect.Dx()), Y: sy / sw / float64(small.Rect.Dy())}.clamp()
+	return &p
+}
+
+// coverOffset returns the offset, from 0 to length-span, of a span of the
+// length of an image that's kept when the image is cropped, so that the span
+// is centered on focus (a fraction of length) as much as possible.
+func coverOffset(length, span int, focus float64) int {
+	off := int(math.Round(focus*float64(length))) - span/2
+	return min(max(off, 0), length-span)
+}
+
+// A FocalPointDetector finds the focal points of uploaded images, to be used
+// instead of the ones found by their entropy. Implementations must be safe for
+// concurrent use.
+type FocalPointDetector interface {
+	Name() string // The identifier of the detector.
+
+	// DetectFocalPoint returns the focal point of image, which is of format,
+	// or nil if it found nothing of interest in it.
+	DetectFocalPoint(ctx context.Context, image []byte, format ImageFormat) (*FocalPoint, error)
+}
+
+var (
+	focalPointDetectorMu sync.RWMutex // guards focalPointDetector
+	focalPointDetector   FocalPointDetector
+)
+
+// SetFocalPointDetector sets the FocalPointDetector of uploaded images. If d
+// is nil (the default), focal points are found by entropy alone.
+func SetFocalPointDetector(d FocalPointDetector) {
+	focalPointDetectorMu.Lock()
+	defer focalPointDetectorMu.Unlock()
+	focalPointDetector = d
+}
+
+// detectFocalPoint returns the focal point the FocalPointDetector, if one is
+// set, detects in the processed image in src, of format, or fallback if none
+// is set, it detects nothing, or it fails (in which case the error is
+// logged).
+func detectFocalPoint(ctx context.Context, src io.ReadSeeker, format ImageFormat, fallback *FocalPoint) *FocalPoint {
+	focalPointDetectorMu.RLock()
+	d := focalPointDetector
+	focalPointDetectorMu.RUnlock()
+	if d == nil {
+		return fallback
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		log.Printf("images: error reading image for focal point detector %s: %v\n", d.Name(), err)
+		return fallback
+	}
+	data, err := io.ReadAll(src)
+	if err != nil {
+		log.Printf("images: error reading image for focal point detector %s: %v\n", d.Name(), err)
+		return fallback
+	}
+	p, err := d.DetectFocalPoint(ctx, data, format)
+	if err != nil {
+		log.Printf("images: focal point detector %s failed: %v\n", d.Name(), err)
+		return fallback
+	}
+	if p == nil {
+		return fallback
+	}
+	clamped := p.clamp()
+	return &clamped
+}
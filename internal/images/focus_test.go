@@ -0,0 +1,116 @@
+package images
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestEntropyFocalPoint(t *testing.T) {
+	// A flat 300x100 image with noise in its right third.
+	img := image.NewRGBA(image.Rect(0, 0, 300, 100))
+	rng := rand.New(rand.NewSource(1))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 300; x++ {
+			c := color.RGBA{40, 90, 160, 255}
+			if x >= 200 {
+				v := uint8(rng.Intn(256))
+				c = color.RGBA{v, v, v, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	p := entropyFocalPoint(img)
+	if p == nil {
+		t.Fatal("no focal point")
+	}
+	if p.X < 0.67 || p.Y < 0.3 || p.Y > 0.7 {
+		t.Errorf("focal point %v is not in the noisy third", p)
+	}
+
+	if p := entropyFocalPoint(image.NewRGBA(image.Rectangle{})); p != nil {
+		t.Errorf("got focal point %v for an empty image", p)
+	}
+	flat := image.NewRGBA(image.Rect(0, 0, 50, 50))
+	if p := entropyFocalPoint(flat); p != nil {
+		t.Errorf("got focal point %v for a flat image", p)
+	}
+}
+
+func TestResizeImageFocus(t *testing.T) {
+	// A 200x100 image, black but for a white column at x = 180.
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		img.Set(180, y, color.White)
+	}
+	at := func(focus *FocalPoint) image.Rectangle {
+		out := resizeImage(img, ImageSize{Width: 100, Height: 100}, ImageFitCover, focus)
+		if b := out.Bounds(); b.Dx() != 100 || b.Dy() != 100 {
+			t.Fatalf("got a %dx%d image, want 100x100", b.Dx(), b.Dy())
+		}
+		for x := 0; x < 100; x++ {
+			if r, _, _, _ := out.At(x, 50).RGBA(); r > 0x8000 {
+				return image.Rect(x, 0, x+1, 100)
+			}
+		}
+		return image.Rectangle{}
+	}
+	if r := at(nil); !r.Empty() {
+		t.Errorf("center crop kept the column at %v", r)
+	}
+	if r := at(&FocalPoint{X: 0.9, Y: 0.5}); r.Empty() || r.Min.X < 75 {
+		t.Errorf("crop toward the column kept it at %v", r)
+	}
+}
+
+func TestCoverOffset(t *testing.T) {
+	for _, c := range []struct {
+		length, span int
+		focus        float64
+		want         int
+	}{
+		{200, 100, 0.5, 50},
+		{200, 100, 0, 0},
+		{200, 100, 1, 100},
+		{200, 100, 0.7, 90},
+		{100, 100, 0.9, 0},
+	} {
+		if got := coverOffset(c.length, c.span, c.focus); got != c.want {
+			t.Errorf("coverOffset(%d, %d, %v) = %d, want %d", c.length, c.span, c.focus, got, c.want)
+		}
+	}
+}
+
+func TestFacesFocalPoint(t *testing.T) {
+	face := func(left, top, size, confidence float64) rekognitionFace {
+		var f rekognitionFace
+		f.BoundingBox.Left, f.BoundingBox.Top = left, top
+		f.BoundingBox.Width, f.BoundingBox.Height = size, size
+		f.Confidence = confidence
+		return f
+	}
+	if p := facesFocalPoint(nil); p != nil {
+		t.Errorf("got focal point %v for no faces", p)
+	}
+	p := facesFocalPoint([]rekognitionFace{face(0.1, 0.1, 0.2, 99), face(0.7, 0.1, 0.2, 50)})
+	if p == nil || p.X != 0.2 || p.Y != 0.2 {
+		t.Errorf("got focal point %v, want the center of the confident face", p)
+	}
+}
+
+func TestFocalPointText(t *testing.T) {
+	var p FocalPoint
+	if err := p.UnmarshalText([]byte("0.25,1.5")); err != nil {
+		t.Fatal(err)
+	}
+	if p.X != 0.25 || p.Y != 1 {
+		t.Errorf("got %v, want 0.25,1 (clamped)", p)
+	}
+	if s := p.String(); s != "0.250,1.000" {
+		t.Errorf("got %q", s)
+	}
+	if err := p.UnmarshalText([]byte("0.25")); err == nil {
+		t.Error("parsed a focal point with one coordinate")
+	}
+}
@@ -0,0 +1,300 @@
+package images
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// imageReference is a column of a table that refers to images.
+type imageReference struct {
+	table, column string
+}
+
+// imageReferences are the columns that refer to images. An image that's not
+// referred to by any of them is garbage (see GarbageCollect).
+var imageReferences = []imageReference{
+	{"post_images", "image_id"},
+	{"posts", "link_image"},
+	{"communities", "pro_pic_2"},
+	{"communities", "banner_image_2"},
+	{"users", "pro_pic"},
+	{"temp_images", "image_id"},
+	{"images", "source_id"}, // The sources of cropped images.
+}
+
+// RegisterImageReference adds column of table to the columns that refer to
+// images, so that images referred to by it are not garbage collected. Call it
+// before GarbageCollect is first called.
+func RegisterImageReference(table, column string) {
+	imageReferences = append(imageReferences, imageReference{table, column})
+}
+
+// ListableStore is a Store whose files can be listed, so that files with no
+// image record can be found (see GarbageCollect).
+type ListableStore interface {
+	Store
+
+	// Key returns the key of the file of the image of r.
+	Key(r *ImageRecord) string
+
+	// List calls fn with the key and the modification time of each image
+	// file in the store (cached variants of images excluded). It stops if fn
+	// returns an error, and returns the error.
+	List(ctx context.Context, fn func(key string, modTime time.Time) error) error
+
+	// DeleteKey deletes the file with key.
+	DeleteKey(ctx context.Context, key string) error
+}
+
+// gcGracePeriod is how old records and files must be to be garbage collected.
+// Uploaded images are saved to their store before their records are
+// committed, and are referred to by temp_images only after that.
+const gcGracePeriod = time.Hour
+
+// gcBatchSize is the number of image records deleted at a time.
+const gcBatchSize = 100
+
+// GCResult is the result of a garbage collection run.
+type GCResult struct {
+	// Image records that are not referred to (and the files of which were
+	// deleted with them).
+	UnreferencedRecords []uid.ID
+
+	// Files, in stores that can be listed, with no image record.
+	OrphanFiles []OrphanFile
+
+	// Image records the files of which are not in their stores. These are
+	// only reported.
+	MissingFiles []uid.ID
+
+	// Image records of stores that are not registered. These are only
+	// reported.
+	UnknownStore []uid.ID
+}
+
+// OrphanFile is a file with no image record.
+type OrphanFile struct {
+	Store string
+	Key   string
+}
+
+// GarbageCollect finds image records that are not referred to by any row (see
+// RegisterImageReference), files in stores that have no image record, and
+// image records whose files are missing. Unless dryRun is true, unreferenced
+// records are deleted (in batches, with DeleteImagesTx), along with their
+// files, and so are orphan files. Records and files younger than an hour, and
+// records in the trash, are left alone.
+//
+// Only stores that implement ListableStore are checked for orphan and missing
+// files.
+func GarbageCollect(ctx context.Context, db *sql.DB, dryRun bool) (*GCResult, error) {
+	res := &GCResult{}
+	cutoff := time.Now().Add(-gcGracePeriod)
+
+	var referenced []string
+	for _, ref := range imageReferences {
+		referenced = append(referenced, fmt.Sprintf("SELECT %s FROM %s WHERE %s IS NOT NULL", ref.column, ref.table, ref.column))
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT images.id, images.store_name, images.format, images.created_at, images.deleted_at IS NOT NULL, images.id IN (%s)
+		FROM images`, strings.Join(referenced, " UNION ")))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Keys of the files expected to be in each listable store.
+	expected := make(map[string]map[string]uid.ID)
+	for rows.Next() {
+		r := &ImageRecord{}
+		var trashed, isReferenced bool
+		if err := rows.Scan(&r.ID, &r.StoreName, &r.Format, &r.CreatedAt, &trashed, &isReferenced); err != nil {
+			return nil, err
+		}
+		// Images in the trash are left to PurgeTrash.
+		if !isReferenced && !trashed && r.CreatedAt.Before(cutoff) {
+			res.UnreferencedRecords = append(res.UnreferencedRecords, r.ID)
+		}
+		if matchStore(r.StoreName) == nil {
+			res.UnknownStore = append(res.UnknownStore, r.ID)
+			continue
+		}
+		// Files are expected in the replicas of the store as well.
+		for _, name := range append([]string{r.StoreName}, replicasOf(r.StoreName)...) {
+			if ls, ok := matchStore(name).(ListableStore); ok {
+				if expected[name] == nil {
+					expected[name] = make(map[string]uid.ID)
+				}
+				expected[name][ls.Key(r)] = r.ID
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	storesMu.RLock()
+	listable := []ListableStore{}
+	for _, s := range stores {
+		if ls, ok := s.(ListableStore); ok {
+			listable = append(listable, ls)
+		}
+	}
+	storesMu.RUnlock()
+
+	for _, store := range listable {
+		keys := expected[store.Name()]
+		err := store.List(ctx, func(key string, modTime time.Time) error {
+			if _, ok := keys[key]; ok {
+				delete(keys, key)
+			} else if modTime.Before(cutoff) {
+				res.OrphanFiles = append(res.OrphanFiles, OrphanFile{Store: store.Name(), Key: key})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("listing store %s: %w", store.Name(), err)
+		}
+		for _, id := range keys {
+			res.MissingFiles = append(res.MissingFiles, id)
+		}
+	}
+
+	if dryRun {
+		return res, nil
+	}
+
+	for start := 0; start < len(res.UnreferencedRecords); start += gcBatchSize {
+		batch := res.UnreferencedRecords[start:min(start+gcBatchSize, len(res.UnreferencedRecords))]
+		if err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+			return DeleteImagesTx(ctx, tx, db, batch...)
+		}); err != nil {
+			return res, fmt.Errorf("deleting unreferenced images: %w", err)
+		}
+	}
+
+	for _, file := range res.OrphanFiles {
+		store := LookupStore(file.Store).(ListableStore)
+		if err := store.DeleteKey(ctx, file.Key); err != nil {
+			return res, fmt.Errorf("deleting orphan file %s of store %s: %w", file.Key, file.Store, err)
+		}
+	}
+
+	return res, nil
+}
+
+// fileKey returns the path, relative to the root of its store, of the file
+// of the image of r, in stores that lay out files by ID.
+func fileKey(r *ImageRecord) string {
+	folder, filename := idToFolder(r.ID)
+	return path.Join(folder, filename+r.Format.Extension())
+}
+
+// Key implements ListableStore.
+func (ds *diskStore) Key(r *ImageRecord) string {
+	return fileKey(r)
+}
+
+// List implements ListableStore. Cached variants of images, which are kept
+// alongside the images, and hidden (temporary) files are skipped.
+func (ds *diskStore) List(ctx context.Context, fn func(key string, modTime time.Time) error) error {
+	return filepath.WalkDir(filesRootFolder, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == filesRootFolder && os.IsNotExist(err) {
+				return nil
+			}
+			log.Printf("skipping unwalkable directory: %v", err)
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() || isCacheFile(name) || strings.HasPrefix(name, ".") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // Deleted since.
+		}
+		key, err := filepath.Rel(filesRootFolder, p)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(key), info.ModTime())
+	})
+}
+
+// DeleteKey implements ListableStore.
+func (ds *diskStore) DeleteKey(ctx context.Context, key string) error {
+	err := os.Remove(path.Join(filesRootFolder, key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Key implements ListableStore. Keys are relative to the path prefix of s.
+func (s *s3Store) Key(r *ImageRecord) string {
+	return fileKey(r)
+}
+
+// keyPrefix returns the prefix of the keys of all objects of s.
+func (s *s3Store) keyPrefix() string {
+	if s.prefix == "" {
+		return ""
+	}
+	return strings.TrimSuffix(path.Clean(s.prefix), "/") + "/"
+}
+
+// List implements ListableStore.
+func (s *s3Store) List(ctx context.Context, fn func(key string, modTime time.Time) error) error {
+	prefix := s.keyPrefix()
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		pctx, cancel := s.withTimeout(ctx)
+		page, err := paginator.NextPage(pctx)
+		cancel()
+		if err != nil {
+			return err
+		}
+		for _, obj := range page.Contents {
+			var modTime time.Time
+			if obj.LastModified != nil {
+				modTime = *obj.LastModified
+			}
+			if err := fn(strings.TrimPrefix(aws.ToString(obj.Key), prefix), modTime); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteKey implements ListableStore.
+func (s *s3Store) DeleteKey(ctx context.Context, key string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.keyPrefix() + key),
+	})
+	return err
+}
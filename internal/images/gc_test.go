@@ -0,0 +1,77 @@
+package images
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestDiskStoreList(t *testing.T) {
+	root := t.TempDir()
+	prevRoot := filesRootFolder
+	filesRootFolder = root
+	defer func() { filesRootFolder = prevRoot }()
+
+	ds := newDiskStore()
+	record := &ImageRecord{ID: uid.New(), Format: ImageFormatJPEG}
+	key := ds.Key(record)
+	files := []string{
+		key,
+		filepath.Join(filepath.Dir(key), "orphan.webp"),
+		cacheFilepath(&request{id: record.ID, size: ImageSize{300, 300}, fit: ImageFitCover, format: ImageFormatJPEG})[len(root)+1:],
+		".upload-123",
+	}
+	for _, f := range files {
+		p := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if p, err := ds.imagePath(record.ID, record.Format); err != nil || p != filepath.Join(root, key) {
+		t.Fatalf("key %s does not match image path %s (err: %v)", key, p, err)
+	}
+
+	var listed []string
+	err := ds.List(context.Background(), func(key string, modTime time.Time) error {
+		listed = append(listed, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(listed)
+	want := []string{files[1], key}
+	slices.Sort(want)
+	if !slices.Equal(listed, want) {
+		t.Fatalf("listed %v, want %v", listed, want)
+	}
+
+	if err := ds.DeleteKey(context.Background(), files[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, files[1])); !os.IsNotExist(err) {
+		t.Fatalf("orphan file not deleted (err: %v)", err)
+	}
+}
+
+func TestS3StoreKeyPrefix(t *testing.T) {
+	record := &ImageRecord{ID: uid.New(), Format: ImageFormatPNG}
+	for _, prefix := range []string{"", "images", "images/", "/images", "a/b/"} {
+		s, err := newS3Store("us-east-1", "bucket", "key", "secret", "", prefix, s3Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := s.keyPrefix()+s.Key(record), s.objectKey(record.ID, record.Format); got != want {
+			t.Errorf("prefix %q: key prefix and key %q don't make up object key %q", prefix, got, want)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GCSOptions are the settings of the Google Cloud Storage store (see
+// InitGCSStore).
+type GCSOptions struct {
+	Bucket string
+
+	// Path of the JSON key file of the service account the store
+	// authenticates as. It's required unless Endpoint is set, in which case,
+	// if it's empty, requests are unauthenticated (as with emulators like
+	// fake-gcs-server).
+	CredentialsFile string
+
+	Endpoint   string // If empty, it's https://storage.googleapis.com.
+	PathPrefix string // Prefix of the names of all objects.
+}
+
+// InitGCSStore registers a store named "gcs" that saves images to a Google
+// Cloud Storage bucket. If opts.Bucket is empty, it does nothing.
+func InitGCSStore(opts GCSOptions) error {
+	if opts.Bucket == "" {
+		return nil
+	}
+	var tokens *gcsTokenSource
+	if opts.CredentialsFile != "" {
+		data, err := os.ReadFile(opts.CredentialsFile)
+		if err != nil {
+			return fmt.Errorf("failed to read GCS credentials file: %w", err)
+		}
+		if tokens, err = newGCSTokenSource(data); err != nil {
+			return err
+		}
+	} else if opts.Endpoint == "" {
+		return errors.New("GCS credentials file is required")
+	}
+	return RegisterStore(context.Background(), newGCSStore(opts.Endpoint, opts.Bucket, opts.PathPrefix, tokens))
+}
+
+// gcsStore implements the Store interface for Google Cloud Storage, using its
+// JSON API.
+type gcsStore struct {
+	endpoint string
+	bucket   string
+	prefix   string
+	tokens   *gcsTokenSource // If nil, requests are unauthenticated.
+}
+
+func newGCSStore(endpoint, bucket, prefix string, tokens *gcsTokenSource) *gcsStore {
+	if endpoint == "" {
+		endpoint = "https://storage.googleapis.com"
+	}
+	return &gcsStore{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		bucket:   bucket,
+		prefix:   prefix,
+		tokens:   tokens,
+	}
+}
+
+func (s *gcsStore) Name() string {
+	return "gcs"
+}
+
+// objectURL returns the URL of the metadata of the object of r. Its content is
+// at the URL with the query parameter alt=media.
+func (s *gcsStore) objectURL(r *ImageRecord) string {
+	return s.endpoint + "/storage/v1/b/" + url.PathEscape(s.bucket) + "/o/" + url.PathEscape(storeObjectKey(s.prefix, r.ID, r.Format))
+}
+
+// newRequest returns an authorized request to the JSON API.
+func (s *gcsStore) newRequest(ctx context.Context, method, u string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if s.tokens != nil {
+		token, err := s.tokens.token(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// Init implements StoreInitializer. It checks that the bucket exists and that
+// it's accessible with the credentials of s.
+func (s *gcsStore) Init(ctx context.Context) error {
+	req, err := s.newRequest(ctx, http.MethodGet, s.endpoint+"/storage/v1/b/"+url.PathEscape(s.bucket), nil)
+	if err != nil {
+		return err
+	}
+	if _, err := doCloudRequest(ctx, "gcs", req, false); err != nil {
+		return fmt.Errorf("failed to get bucket %s: %w", s.bucket, err)
+	}
+	return nil
+}
+
+// Get retrieves an image from GCS.
+func (s *gcsStore) Get(ctx context.Context, r *ImageRecord) ([]byte, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, s.objectURL(r)+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	return doCloudRequest(ctx, "gcs", req, true)
+}
+
+// Save stores an image in GCS.
+func (s *gcsStore) Save(ctx context.Context, r *ImageRecord, image []byte) error {
+	return s.SaveStream(ctx, r, bytes.NewReader(image), int64(len(image)))
+}
+
+// SaveStream implements StreamSaver. The image is uploaded in a single
+// request, as images are well below the size at which GCS recommends resumable
+// uploads.
+func (s *gcsStore) SaveStream(ctx context.Context, r *ImageRecord, src io.Reader, size int64) error {
+	query := url.Values{}
+	query.Set("uploadType", "media")
+	query.Set("name", storeObjectKey(s.prefix, r.ID, r.Format))
+	u := s.endpoint + "/upload/storage/v1/b/" + url.PathEscape(s.bucket) + "/o?" + query.Encode()
+	req, err := s.newRequest(ctx, http.MethodPost, u, src)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", r.Format.MimeType())
+	_, err = doCloudRequest(ctx, "gcs", req, false)
+	return err
+}
+
+// Delete removes an image from GCS.
+func (s *gcsStore) Delete(ctx context.Context, r *ImageRecord) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, s.objectURL(r), nil)
+	if err != nil {
+		return err
+	}
+	_, err = doCloudRequest(ctx, "gcs", req, false, http.StatusNotFound)
+	return err
+}
+
+// gcsScope is the OAuth scope of the access tokens of a gcsStore.
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// gcsTokenSource gets OAuth access tokens for a service account, by exchanging
+// JWTs signed with the account's private key, and caches them until shortly
+// before they expire.
+type gcsTokenSource struct {
+	email    string
+	key      *rsa.PrivateKey
+	keyID    string
+	tokenURI string
+
+	mu      sync.Mutex
+	current string
+	expires time.Time
+}
+
+// newGCSTokenSource returns a token source for the service account whose JSON
+// key file is data.
+func newGCSTokenSource(data []byte) (*gcsTokenSource, error) {
+	var file struct {
+		Type         string `json:"type"`
+		ClientEmail  string `json:"client_email"`
+		PrivateKey   string `json:"private_key"`
+		PrivateKeyID string `json:"private_key_id"`
+		TokenURI     string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid GCS credentials file: %w", err)
+	}
+	if file.Type != "service_account" {
+		return nil, fmt.Errorf("GCS credentials are not of a service account (type: %q)", file.Type)
+	}
+	block, _ := pem.Decode([]byte(file.PrivateKey))
+	if block == nil {
+		return nil, errors.New("GCS credentials have no private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GCS private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("GCS private key is not an RSA key")
+	}
+	if file.TokenURI == "" {
+		file.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return &gcsTokenSource{
+		email:    file.ClientEmail,
+		key:      key,
+		keyID:    file.PrivateKeyID,
+		tokenURI: file.TokenURI,
+	}, nil
+}
+
+// token returns a valid access token.
+func (ts *gcsTokenSource) token(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.current != "" && time.Now().Before(ts.expires) {
+		return ts.current, nil
+	}
+
+	assertion, err := ts.assertion(time.Now())
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	form.Set("assertion", assertion)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ts.tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	data, err := doCloudRequest(ctx, "gcs token", req, true)
+	if err != nil {
+		return "", err
+	}
+	var res struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return "", fmt.Errorf("gcs token: %w", err)
+	}
+	if res.AccessToken == "" {
+		return "", errors.New("gcs token: no access token in response")
+	}
+	ts.current = res.AccessToken
+	ts.expires = time.Now().Add(time.Duration(res.ExpiresIn)*time.Second - time.Minute)
+	return ts.current, nil
+}
+
+// assertion returns a JWT, issued at now, to be exchanged for an access token.
+func (ts *gcsTokenSource) assertion(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": ts.keyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"iss":   ts.email,
+		"scope": gcsScope,
+		"aud":   ts.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, ts.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
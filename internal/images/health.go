@@ -0,0 +1,103 @@
+package images
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// HealthChecker is implemented by stores that can check whether they're
+// usable (whether images can be saved to them, say), for readiness probes.
+// Stores that don't implement it are taken to be healthy.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// CheckStoreHealth checks the health of the registered store with name. If
+// the store has replicas or fallbacks, their health is checked as well.
+func CheckStoreHealth(ctx context.Context, name string) error {
+	store := openStore(name)
+	if store == nil {
+		return fmt.Errorf("%w: %v", ErrStoreNotRegistered, name)
+	}
+	if hc, ok := store.(HealthChecker); ok {
+		return hc.CheckHealth(ctx)
+	}
+	return nil
+}
+
+// CheckHealth implements HealthChecker. Images cannot be saved unless the
+// primary store and all its replicas are healthy, and images that were not
+// yet moved cannot be read unless the fallbacks are.
+func (s *replicatedStore) CheckHealth(ctx context.Context) error {
+	var errs []error
+	for _, store := range append(s.all(), s.fallbacks...) {
+		if hc, ok := store.(HealthChecker); ok {
+			if err := hc.CheckHealth(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("store %s: %w", store.Name(), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CheckHealth implements HealthChecker. It checks that files can be written
+// to the images folder.
+func (ds *diskStore) CheckHealth(ctx context.Context) error {
+	if filesRootFolder == "" {
+		return errors.New("images folder not set")
+	}
+	if err := mkdirAll(filesRootFolder); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filesRootFolder, ".health-*")
+	if err != nil {
+		return fmt.Errorf("images folder not writable: %w", err)
+	}
+	_, err = file.Write([]byte("ok"))
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if rerr := os.Remove(file.Name()); err == nil {
+		err = rerr
+	}
+	if err != nil {
+		return fmt.Errorf("images folder not writable: %w", err)
+	}
+	return nil
+}
+
+// CheckHealth implements HealthChecker. It checks that the bucket exists and
+// that it's accessible with the credentials of s.
+func (s *s3Store) CheckHealth(ctx context.Context) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	if _, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.bucket)}); err != nil {
+		return fmt.Errorf("failed to head bucket %s: %w", s.bucket, err)
+	}
+	return nil
+}
+
+// CheckHealth implements HealthChecker (see gcsStore.Init).
+func (s *gcsStore) CheckHealth(ctx context.Context) error {
+	return s.Init(ctx)
+}
+
+// CheckHealth implements HealthChecker. It checks that the container exists
+// and that it's accessible with the account key of s.
+func (s *azureStore) CheckHealth(ctx context.Context) error {
+	req, err := s.newRequest(ctx, http.MethodHead, s.endpoint+"/"+url.PathEscape(s.container)+"?restype=container", nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := doCloudRequest(ctx, "azure", req, false); err != nil {
+		return fmt.Errorf("failed to get container %s: %w", s.container, err)
+	}
+	return nil
+}
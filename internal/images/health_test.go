@@ -0,0 +1,25 @@
+package images
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestDiskStoreHealth(t *testing.T) {
+	defer func(saved string) { filesRootFolder = saved }(filesRootFolder)
+	ctx := context.Background()
+
+	filesRootFolder = t.TempDir()
+	if err := newDiskStore().CheckHealth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(filesRootFolder); len(entries) != 0 {
+		t.Errorf("health check left %d files behind", len(entries))
+	}
+
+	filesRootFolder = ""
+	if err := newDiskStore().CheckHealth(ctx); err == nil {
+		t.Error("no error checking the health of a disk store without a folder")
+	}
+}
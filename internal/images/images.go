@@ -1,7 +1,6 @@
 package images
 
 import (
-	"bytes"
 	"context"
 	"crypto/hmac"
 	"crypto/sha256"
@@ -12,10 +11,8 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"image"
 	"io"
-	"log"
-	"math"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
 	"net/url"
@@ -24,12 +21,16 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/discuitnet/discuit/internal/logging"
 	msql "github.com/discuitnet/discuit/internal/sql"
 	"github.com/discuitnet/discuit/internal/uid"
 	"golang.org/x/exp/slices"
 
-	// Register jpeg and png decoding for images pkg.
+	// Register jpeg, png, and gif decoding for images pkg.
+	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
 
@@ -40,8 +41,8 @@ import (
 )
 
 var (
-	// Global registered stores.
-	stores []store
+	storesMu sync.RWMutex // guards stores
+	stores   []Store      // Global registered stores.
 
 	// HMACKey is the key used to set the HMAC portion of an image's URL.
 	HMACKey []byte
@@ -52,10 +53,33 @@ var (
 	FullImageURL = func(s string) string {
 		return "/images/" + s
 	}
+
+	// If URLTTL is non-zero, image URLs expire about URLTTL after they're
+	// generated (expiry times are rounded up, so that the URLs of an image
+	// stay the same for a while and can be cached). Expired URLs are still
+	// accepted for URLExpiryGrace. Changing URLTTL doesn't affect the URLs
+	// already generated, and URLs without an expiry time never expire.
+	URLTTL, URLExpiryGrace time.Duration
 )
 
+// urlExpiry returns the expiry time of an image URL generated at now.
+func urlExpiry(now time.Time) time.Time {
+	if URLTTL <= 0 {
+		return time.Time{}
+	}
+	round := min(URLTTL/4, time.Hour)
+	t := now.Add(URLTTL)
+	if round <= 0 {
+		return t
+	}
+	if r := t.Truncate(round); r.Before(t) {
+		return r.Add(round)
+	}
+	return t
+}
+
 func init() {
-	if err := registerStore(newDiskStore()); err != nil {
+	if err := RegisterStore(context.Background(), newDiskStore()); err != nil {
 		panic(err)
 	}
 }
@@ -69,6 +93,12 @@ type S3Config interface {
 	GetS3SecretKey() string
 	GetS3Endpoint() string
 	GetS3PathPrefix() string
+	GetS3MaxAttempts() int
+	GetS3MaxBackoff() string
+	GetS3RequestTimeout() string
+	GetS3RedirectMode() string
+	GetS3PresignTTL() string
+	GetS3CDNBaseURL() string
 }
 
 // InitS3Store initializes the S3 store if S3 is enabled in the config.
@@ -77,6 +107,36 @@ func InitS3Store(conf s3.Config) error {
 		return nil
 	}
 
+	opts := s3Options{MaxAttempts: conf.GetS3MaxAttempts()}
+	var err error
+	if str := conf.GetS3MaxBackoff(); str != "" {
+		if opts.MaxBackoff, err = time.ParseDuration(str); err != nil {
+			return fmt.Errorf("invalid S3 max backoff: %w", err)
+		}
+	}
+	if str := conf.GetS3RequestTimeout(); str != "" {
+		if opts.RequestTimeout, err = time.ParseDuration(str); err != nil {
+			return fmt.Errorf("invalid S3 request timeout: %w", err)
+		}
+	}
+
+	opts.RedirectMode = s3RedirectMode(conf.GetS3RedirectMode())
+	switch opts.RedirectMode {
+	case s3RedirectNone:
+	case s3RedirectPresign:
+		if str := conf.GetS3PresignTTL(); str != "" {
+			if opts.PresignTTL, err = time.ParseDuration(str); err != nil {
+				return fmt.Errorf("invalid S3 presign TTL: %w", err)
+			}
+		}
+	case s3RedirectCDN:
+		if opts.CDNBaseURL = conf.GetS3CDNBaseURL(); opts.CDNBaseURL == "" {
+			return errors.New("S3 CDN base URL is required for the cdn redirect mode")
+		}
+	default:
+		return fmt.Errorf("invalid S3 redirect mode: %q", opts.RedirectMode)
+	}
+
 	store, err := newS3Store(
 		conf.GetS3Region(),
 		conf.GetS3Bucket(),
@@ -84,57 +144,161 @@ func InitS3Store(conf s3.Config) error {
 		conf.GetS3SecretKey(),
 		conf.GetS3Endpoint(),
 		conf.GetS3PathPrefix(),
+		opts,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to create S3 store: %w", err)
 	}
 
-	return registerStore(store)
+	return RegisterStore(context.Background(), store)
 }
 
 var (
 	ErrImageNotFound          = errors.New("image not found")
 	ErrStoreNotRegistered     = errors.New("store not registered")
 	ErrBadURL                 = errors.New("bad image request url")
+	ErrURLExpired             = errors.New("image url expired")
 	ErrImageFormatUnsupported = errors.New("image format not supported")
 	ErrImageFitUnsupported    = errors.New("invalid image fit")
 )
 
-func registerStore(s store) error {
+// RegisterStore makes s available for saving and retrieving images. Images
+// are associated with the store they're saved in by the name of the store, so
+// stores that were used to save images must be registered, under the same name,
+// every time the process starts. If s implements StoreInitializer, its Init
+// method is called before it's registered. It's safe to call RegisterStore
+// from multiple goroutines.
+func RegisterStore(ctx context.Context, s Store) error {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+
+	name := s.Name()
+	if name == "" {
+		return errors.New("store name is empty")
+	}
 	for _, store := range stores {
-		if store.name() == s.name() {
-			return fmt.Errorf("a store with the name %v is already registered", s.name())
+		if store.Name() == name {
+			return fmt.Errorf("a store with the name %v is already registered", name)
+		}
+	}
+	if i, ok := s.(StoreInitializer); ok {
+		if err := i.Init(ctx); err != nil {
+			return fmt.Errorf("failed to initialize store %v: %w", name, err)
 		}
 	}
 	stores = append(stores, s)
 	return nil
 }
 
-func matchStore(name string) store {
+// CloseStores closes all the registered stores that implement io.Closer. Call
+// it when the process is shutting down.
+func CloseStores() error {
+	storesMu.RLock()
+	defer storesMu.RUnlock()
+
+	var errs []error
+	for _, s := range stores {
+		if c, ok := s.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("failed to close store %v: %w", s.Name(), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LookupStore returns the registered store with name, or nil if there's no
+// such store.
+func LookupStore(name string) Store {
+	return matchStore(name)
+}
+
+func matchStore(name string) Store {
+	storesMu.RLock()
+	defer storesMu.RUnlock()
 	for _, s := range stores {
-		if s.name() == name {
+		if s.Name() == name {
 			return s
 		}
 	}
 	return nil
 }
 
-// GetDefaultStoreName returns the name of the default store to use.
-// Currently returns "s3" if s3Enabled is true, otherwise "disk".
+// defaultStoreName, if non-empty, overrides the store returned by
+// GetDefaultStoreName.
+var defaultStoreName string
+
+// SetDefaultStoreName sets the store that new images are saved to. The store
+// must have been registered.
+func SetDefaultStoreName(name string) error {
+	if matchStore(name) == nil {
+		return fmt.Errorf("%w: %v", ErrStoreNotRegistered, name)
+	}
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	defaultStoreName = name
+	return nil
+}
+
+// GetDefaultStoreName returns the name of the default store to use. Unless it
+// was set with SetDefaultStoreName, it returns "s3" if s3Enabled is true,
+// otherwise "disk".
 func GetDefaultStoreName(s3Enabled bool) string {
+	storesMu.RLock()
+	name := defaultStoreName
+	storesMu.RUnlock()
+	if name != "" {
+		return name
+	}
 	if s3Enabled {
 		return "s3"
 	}
 	return "disk"
 }
 
-// A store saves images to a permanent location. Each store is identified by a
-// name that must be unique to the running process.
-type store interface {
-	get(*ImageRecord) ([]byte, error)
-	save(r *ImageRecord, image []byte) error
-	delete(*ImageRecord) error
-	name() string // The identifier of the store.
+// A Store saves images to a permanent location. Each store is identified by a
+// name that must be unique to the running process. Implementations must be
+// safe for concurrent use.
+type Store interface {
+	Get(context.Context, *ImageRecord) ([]byte, error)
+	Save(ctx context.Context, r *ImageRecord, image []byte) error
+	Delete(context.Context, *ImageRecord) error
+	Name() string // The identifier of the store.
+}
+
+// StreamSaver is implemented by stores that can save an image without having
+// all of it in memory. SaveStream saves the image in src, which is size bytes
+// long, as Save would.
+type StreamSaver interface {
+	SaveStream(ctx context.Context, r *ImageRecord, src io.Reader, size int64) error
+}
+
+// saveToStore saves the image in src, which is size bytes long, to store. The
+// image is streamed to stores that implement StreamSaver, and read into memory
+// for others.
+func saveToStore(ctx context.Context, store Store, r *ImageRecord, src io.Reader, size int64) error {
+	if s, ok := store.(StreamSaver); ok {
+		return s.SaveStream(ctx, r, src, size)
+	}
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return err
+	}
+	return store.Save(ctx, r, data)
+}
+
+// Redirector is implemented by stores that clients can fetch images from
+// directly. RedirectURL returns the URL of the image of r, along with the time
+// the URL expires (zero if it never expires). If the URL is empty, the image
+// is to be served by the images server.
+type Redirector interface {
+	RedirectURL(ctx context.Context, r *ImageRecord) (string, time.Time, error)
+}
+
+// StoreInitializer is implemented by stores that need to be set up (say, to
+// create a bucket or to check credentials) before they're used.
+type StoreInitializer interface {
+	Init(ctx context.Context) error
 }
 
 // ImageFormat represents the type of image.
@@ -145,6 +309,8 @@ const (
 	ImageFormatJPEG = ImageFormat("jpeg")
 	ImageFormatWEBP = ImageFormat("webp")
 	ImageFormatPNG  = ImageFormat("png")
+	ImageFormatGIF  = ImageFormat("gif")
+	ImageFormatAVIF = ImageFormat("avif")
 )
 
 // Valid reports whether f is supported by the image package.
@@ -153,6 +319,8 @@ func (f ImageFormat) Valid() bool {
 		ImageFormatJPEG,
 		ImageFormatWEBP,
 		ImageFormatPNG,
+		ImageFormatGIF,
+		ImageFormatAVIF,
 	}, f)
 }
 
@@ -160,6 +328,59 @@ func (f ImageFormat) Extension() string {
 	return "." + string(f)
 }
 
+// MimeType returns the media type of images of format f.
+func (f ImageFormat) MimeType() string {
+	return "image/" + string(f)
+}
+
+// storedAsUploaded reports whether images of format f are to be saved in their
+// original format, rather than in the format requested when saving. This is
+// so that GIF animations are preserved, and because AVIF images cannot be
+// transcoded without an AVIF codec (see avifCodec). Animated WEBP images are
+// stored as uploaded as well (see webpAnimated).
+func (f ImageFormat) storedAsUploaded() bool {
+	return f == ImageFormatGIF || (f == ImageFormatAVIF && !avifCodec())
+}
+
+// servedAs returns the formats images stored in format f can be requested in:
+// f itself, and the formats they can be transcoded into (none, for AVIF
+// images without an AVIF codec, which cannot be decoded). Images are served in
+// AVIF only with an AVIF codec.
+func (f ImageFormat) servedAs() []ImageFormat {
+	if f == ImageFormatAVIF && !avifCodec() {
+		return []ImageFormat{f}
+	}
+	to := []ImageFormat{ImageFormatJPEG, ImageFormatPNG}
+	if avifCodec() {
+		to = append(to, ImageFormatAVIF)
+	}
+	formats := []ImageFormat{f}
+	for _, to := range to {
+		if to != f {
+			formats = append(formats, to)
+		}
+	}
+	return formats
+}
+
+// imageFormatAliases are alternate filename extensions accepted in image URLs.
+var imageFormatAliases = map[string]ImageFormat{
+	"jpg": ImageFormatJPEG,
+}
+
+// parseImageFormat returns the ImageFormat of the filename extension ext (which
+// is without the leading dot).
+func parseImageFormat(ext string) (ImageFormat, error) {
+	ext = strings.ToLower(ext)
+	if f, ok := imageFormatAliases[ext]; ok {
+		return f, nil
+	}
+	if f := ImageFormat(ext); f.Valid() {
+		return f, nil
+	}
+	return "", ErrImageFormatUnsupported
+}
+
 // RGB represents color values of range (0, 255). It implements sql.Scanner and
 // driver.Valuer interfaces. Use a 12-byte binary database column type to store
 // values of this type in SQL databases.
@@ -363,41 +584,6 @@ func ImageContainSize(imageWidth, imageHeight, boxWidth, boxHeight int) (int, in
 	return int(x), int(y)
 }
 
-// AverageColor returns the average RGB color of img by averaging the colors of
-// at most 10^4 pixels.
-func AverageColor(img image.Image) RGB {
-	width := img.Bounds().Dx()
-	height := img.Bounds().Dy()
-
-	xsteps, ysteps := int(math.Floor(float64(width)/100.0)), int(math.Floor(float64(height)/100.0))
-	if xsteps <= 0 {
-		xsteps = 1
-	}
-	if ysteps <= 0 {
-		ysteps = 1
-	}
-
-	var r, g, b float64
-	for i := 0; i < width; i += xsteps {
-		for j := 0; j < height; j += ysteps {
-			c := img.At(i, j)
-			r2, g2, b2, _ := c.RGBA()
-			r = (r + float64(r2)) / 2
-			g = (g + float64(g2)) / 2
-			b = (b + float64(b2)) / 2
-		}
-	}
-
-	var c RGB
-	x := r + g + b
-	if x > 0 {
-		c.Red = uint32(r / x * 255.0)
-		c.Green = uint32(g / x * 255.0)
-		c.Blue = uint32(b / x * 255.0)
-	}
-	return c
-}
-
 // request is an incoming request for an image.
 type request struct {
 	id     uid.ID    // ID of the image.
@@ -405,6 +591,22 @@ type request struct {
 	fit    ImageFit
 	format ImageFormat // Should never be empty.
 	hash   []byte      // Incoming request hash value from the URL parameters.
+
+	// Checksum of the image (see ImageRecord.Checksum). If set, the cached
+	// variant of r is keyed by it rather than by the ID of the image.
+	checksum string
+
+	// The focal point of the image (see ImageRecord.FocalPoint) that variants
+	// fitted as per ImageFitCover are cropped toward. It's set from the image
+	// record, not from URLs.
+	focus *FocalPoint
+
+	// Set by getImage if the image was read from the cache.
+	cacheHit bool
+
+	// Unix time after which the request's URL is no longer valid. If zero,
+	// it never expires.
+	expires int64
 }
 
 func fromURL(u *url.URL) (_ *request, err error) {
@@ -424,8 +626,8 @@ func fromURL(u *url.URL) (_ *request, err error) {
 		return nil, ErrBadURL
 	}
 
-	if r.format = ImageFormat(extension); !r.format.Valid() {
-		return nil, ErrImageFormatUnsupported
+	if r.format, err = parseImageFormat(extension); err != nil {
+		return nil, err
 	}
 
 	query := u.Query()
@@ -442,6 +644,18 @@ func fromURL(u *url.URL) (_ *request, err error) {
 	if !r.size.Zero() && r.fit == "" {
 		return nil, errors.New("zero size requires a non-empty image fit")
 	}
+	if !variantAllowed(r.size, r.fit, r.format) {
+		return nil, ErrVariantNotAllowed
+	}
+
+	if expires := query.Get("expires"); expires != "" {
+		if r.expires, err = strconv.ParseInt(expires, 10, 64); err != nil || r.expires <= 0 {
+			return nil, ErrBadURL
+		}
+		if time.Now().After(time.Unix(r.expires, 0).Add(URLExpiryGrace)) {
+			return nil, ErrURLExpired
+		}
+	}
 
 	r.hash, err = base64.RawURLEncoding.DecodeString(query.Get("sig"))
 	if err != nil {
@@ -470,13 +684,25 @@ func (r *request) hashData() []byte {
 		fit = string(r.fit)
 	}
 	ext := r.format.Extension()
-	return []byte(id + size + fit + ext)
+	data := id + size + fit + ext
+	if r.expires != 0 {
+		data += "e" + strconv.FormatInt(r.expires, 10)
+	}
+	return []byte(data)
 }
 
 // filename returns a string of the format "{FileHash}_300x400_contain.jpeg"
-// used for storing images for caching purposes.
+// used for storing images for caching purposes. If r.checksum is set, the file
+// hash is the checksum, and variants of the original size are named
+// "{Checksum}_full.webp".
 func (r *request) filename() string {
 	_, s := idToFolder(r.id)
+	if r.checksum != "" {
+		s = r.checksum
+		if r.size.Zero() {
+			s += "_full"
+		}
+	}
 	if !r.size.Zero() {
 		s += "_" + r.size.String()
 		// ImageFit only makes sense if a size (other than that of the original
@@ -486,19 +712,27 @@ func (r *request) filename() string {
 		} else {
 			s += "_" + string(r.fit)
 		}
+		if r.fit == ImageFitCover && r.focus != nil {
+			s += "_" + r.focus.key()
+		}
 	}
 	s += r.format.Extension()
 	return s
 }
 
-// url returns a string of the format "{ID}.jpeg?size=300&fit=contain&sig={MAC}".
-// If key is nil, the signature query parameter is omitted from the URL.
+// url returns a string of the format
+// "{ID}.jpeg?size=300&fit=contain&expires={Unix}&sig={MAC}". If key is nil,
+// the signature query parameter is omitted from the URL, and if r.expires is
+// zero, so is the expires parameter.
 func (r *request) url() string {
 	v := url.Values{}
 	if !r.size.Zero() {
 		v.Set("size", r.size.String())
 		v.Set("fit", string(r.fit))
 	}
+	if r.expires != 0 {
+		v.Set("expires", strconv.FormatInt(r.expires, 10))
+	}
 
 	if HMACKey != nil {
 		v.Set("sig", base64.RawURLEncoding.EncodeToString(r.computeHash()))
@@ -511,7 +745,20 @@ func (r *request) url() string {
 	return r.id.String() + r.format.Extension() + search
 }
 
+// variantsFolder is the folder, in filesRootFolder, of the cached variants
+// that are keyed by checksum.
+const variantsFolder = "variants"
+
+// checksumFolder returns the folder, relative to filesRootFolder, of the
+// cached variants of the images with checksum sum.
+func checksumFolder(sum string) string {
+	return path.Join(variantsFolder, sum[:2], sum[2:4])
+}
+
 func cacheFilepath(r *request) string {
+	if r.checksum != "" {
+		return path.Join(filesRootFolder, checksumFolder(r.checksum), r.filename())
+	}
 	folder, _ := idToFolder(r.id)
 	// Cache files are stored in the same directory as diskStore and alongside
 	// the original image.
@@ -519,46 +766,80 @@ func cacheFilepath(r *request) string {
 }
 
 func getCachedImage(r *request) (image []byte, err error) {
-	return os.ReadFile(cacheFilepath(r))
+	filepath := cacheFilepath(r)
+	if image, err = os.ReadFile(filepath); err != nil {
+		if os.IsNotExist(err) {
+			cache.miss()
+		}
+		return nil, err
+	}
+	cache.hit(filepath, int64(len(image)))
+	return image, nil
 }
 
 func putToCache(image []byte, r *request) error {
-	return os.WriteFile(cacheFilepath(r), image, 0755)
+	filepath := cacheFilepath(r)
+	if err := mkdirAll(path.Dir(filepath)); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath, image, 0755); err != nil {
+		return err
+	}
+	cache.add(filepath, int64(len(image)))
+	return nil
 }
 
 func removeFromCache(image uid.ID) error {
 	folder, filename := idToFolder(image)
 	return filepath.Walk(path.Join(filesRootFolder, folder), func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			log.Printf("skipping unwalkable directory: %v", err)
+			slog.Warn("Skipping unwalkable directory", "error", err)
 			return nil
 		}
 		if info.IsDir() {
 			return nil
 		}
 		base := filepath.Base(path)
-		if strings.HasPrefix(base, filename) && strings.Contains(base, "_") {
-			log.Println("deleting cached image: ", path)
+		if strings.HasPrefix(base, filename) && isCacheFile(base) {
+			slog.Debug("Deleting cached image", "path", path)
 			if err := os.Remove(path); err != nil {
 				return fmt.Errorf("failed to delete cached image %s: %w", image, err)
 			}
+			cache.remove(path)
 		}
 		return nil
 	})
 }
 
+// removeVariantsFromCache removes the cached variants of the images with
+// checksum sum.
+func removeVariantsFromCache(sum string) error {
+	files, err := filepath.Glob(path.Join(filesRootFolder, checksumFolder(sum), sum+"_*"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete cached image %s: %w", file, err)
+		}
+		cache.remove(file)
+	}
+	return nil
+}
+
 // ClearCache removes all cached image files.
 func ClearCache() error {
+	defer cache.reset()
 	return filepath.Walk(path.Join(filesRootFolder), func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			log.Printf("skipping unwalkable directory: %v", err)
+			slog.Warn("Skipping unwalkable directory", "error", err)
 			return nil
 		}
 		if info.IsDir() {
 			return nil
 		}
-		if strings.Contains(filepath.Base(path), "_") {
-			log.Println("deleting cached image: ", path)
+		if isCacheFile(filepath.Base(path)) {
+			slog.Debug("Deleting cached image", "path", path)
 			if err := os.Remove(path); err != nil {
 				return fmt.Errorf("failed to delete cached image: %w", err)
 			}
@@ -567,51 +848,150 @@ func ClearCache() error {
 	})
 }
 
+// redirectURL returns the URL, and its expiry, that the client of r can be
+// redirected to (see Redirector). Only requests for original images are
+// redirected. If the URL is empty, the image is to be served as usual.
+func redirectURL(ctx context.Context, db *sql.DB, r *request) (string, time.Time, error) {
+	if !r.size.Zero() {
+		return "", time.Time{}, nil
+	}
+	record, err := getServedRecord(ctx, db, r.id)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if record.Trashed() {
+		return "", time.Time{}, ErrImageNotFound
+	}
+	if r.format != record.Format {
+		return "", time.Time{}, nil
+	}
+	if rd, ok := record.store().(Redirector); ok {
+		return rd.RedirectURL(ctx, record)
+	}
+	return "", time.Time{}, nil
+}
+
+// getServedRecord returns the record of the image with id, to serve it. It's
+// read from a replica of db, if it has any (see msql.ReadDB), and from db if
+// that fails, as the image may have been saved too recently to be on the
+// replica.
+func getServedRecord(ctx context.Context, db *sql.DB, id uid.ID) (*ImageRecord, error) {
+	if replica := msql.ReadDB(db); replica != db {
+		if record, err := GetImageRecord(ctx, replica, id); err == nil {
+			return record, nil
+		}
+	}
+	return GetImageRecord(ctx, db, id)
+}
+
+// getCachedVariant returns the cached variant of r, or nil if it's not cached.
+func getCachedVariant(r *request) []byte {
+	image, err := getCachedImage(r)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Error("Error reading cached image", "error", err)
+		}
+		return nil
+	}
+	return image
+}
+
 // getImage returns an image (after optionally transforming it) as per the
 // options in r. Make sure to check whether the request has a valid signature by
 // calling r.Valid before calling this function.
+//
+// Transformed images are cached by the checksum of the original image, so that
+// images of identical content share the cache.
 func getImage(ctx context.Context, db *sql.DB, r *request, cacheEnabled bool) ([]byte, error) {
-	if cacheEnabled {
-		if image, err := getCachedImage(r); err != nil {
-			if !os.IsNotExist(err) {
-				log.Printf("getCachedImage error: %v\n", err)
-			}
-			// Failed retreiving from cache, proceed.
-		} else {
+	record, err := getServedRecord(ctx, db, r.id)
+	if err != nil {
+		return nil, err
+	}
+	if record.Trashed() {
+		return nil, ErrImageNotFound
+	}
+
+	original := r.size.Zero() && r.format == record.Format
+	r.focus = record.FocalPoint
+	if cacheEnabled && !original && record.Checksum != nil {
+		r.checksum = *record.Checksum
+		if image := getCachedVariant(r); image != nil {
+			r.cacheHit = true
 			return image, nil
 		}
 	}
 
-	record, err := GetImageRecord(ctx, db, r.id)
+	store := record.store()
+	if store == nil {
+		return nil, fmt.Errorf("image store %v is not found", record.StoreName)
+	}
+
+	image, err := store.Get(ctx, record)
 	if err != nil {
 		return nil, err
 	}
 
-	store := record.store()
-	if store == nil {
-		return nil, fmt.Errorf("image store %v is not found", record.StoreName)
+	if original {
+		return image, nil
 	}
 
-	image, err := store.get(record)
+	if cacheEnabled && r.checksum == "" {
+		// An image saved before checksums were.
+		r.checksum = saveChecksum(ctx, db, record, image)
+		if image := getCachedVariant(r); image != nil {
+			r.cacheHit = true
+			return image, nil
+		}
+	}
+
+	image, err = transformImage(ctx, image, r)
 	if err != nil {
 		return nil, err
 	}
 
-	// For now, we'll just return the original image without any processing
+	if cacheEnabled {
+		if err := putToCache(image, r); err != nil {
+			logging.FromContext(ctx).Error("Error caching image", "image", r.id, "error", err)
+		}
+	}
 	return image, nil
 }
 
+// saveChecksum computes the checksum of image, the image of record, and saves
+// it to record, for images saved before checksums were. A failure to save it
+// is only logged; it's saved on the next request.
+func saveChecksum(ctx context.Context, db *sql.DB, record *ImageRecord, image []byte) string {
+	sum := checksum(image)
+	if _, err := db.ExecContext(ctx, "UPDATE images SET checksum = ? WHERE id = ? AND checksum IS NULL", sum, record.ID); err != nil {
+		logging.FromContext(ctx).Error("Error saving checksum of image", "image", record.ID, "error", err)
+	}
+	record.Checksum = &sum
+	return sum
+}
+
 // ImageOptions hold optional arguments to SaveImage.
 type ImageOptions struct {
 	Width, Height int
 	Format        ImageFormat
 	Fit           ImageFit
+
+	// The owners whose upload quotas the image counts against (see
+	// ChargeQuotasTx).
+	Quota []QuotaOwner
+
+	// If set, the image is cropped to Crop before it's resized, and is saved
+	// uncropped as well, as its source (see RecropImageTx). GIF, animated
+	// WEBP, and (without an AVIF codec) AVIF images cannot be cropped.
+	Crop *CropRect
+
+	// The text that describes the image to those who cannot see it.
+	AltText string
 }
 
 // SaveImage saves the provided image in the image store with the name storeName
 // and creates a row in the images table. The argument opts can be nil, in which
 // case default values are used.
-func SaveImage(ctx context.Context, db *sql.DB, storeName string, file []byte, opts *ImageOptions) (*ImageRecord, error) {
+func SaveImage(ctx context.Context, db *sql.DB, storeName string, file io.Reader, opts *ImageOptions) (*ImageRecord, error) {
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, nil
@@ -620,7 +1000,7 @@ func SaveImage(ctx context.Context, db *sql.DB, storeName string, file []byte, o
 	id, err := SaveImageTx(ctx, tx, storeName, file, opts)
 	if err != nil {
 		if err := tx.Rollback(); err != nil {
-			log.Println("images.SaveImage rollback error: ", err)
+			logging.FromContext(ctx).Error("Error rolling back transaction of image upload", "error", err)
 		}
 		return nil, err
 	}
@@ -628,89 +1008,210 @@ func SaveImage(ctx context.Context, db *sql.DB, storeName string, file []byte, o
 	if err = tx.Commit(); err != nil {
 		return nil, err
 	}
+	QueuePostProcessing(id)
 
 	return GetImageRecord(ctx, db, id)
 }
 
-// If SkipProcessing is set to true, images are saved as is, without compressing
-// nor changing their size or format. Warning: This may lead to inadvertently
-// storing (and leaking) image metadata.
+// If SkipProcessing is set to true, images are saved without compressing nor
+// changing their size or format. Metadata is stripped from them regardless,
+// and images with an EXIF orientation are still rotated (and so re-encoded).
 var SkipProcessing = false
 
-func SaveImageTx(ctx context.Context, tx *sql.Tx, storeName string, file []byte, opts *ImageOptions) (uid.ID, error) {
+// SaveImageTx is like SaveImage, except that the images table row is created
+// within tx. Call QueuePostProcessing with the image once tx is committed.
+//
+// The image is never held in memory whole, unless it has to be decoded for
+// processing or file is in memory already: file is spooled to a temporary
+// file (if it's not an io.ReadSeeker), and the processed image is written to
+// another temporary file and streamed from there to the store.
+func SaveImageTx(ctx context.Context, tx *sql.Tx, storeName string, file io.Reader, opts *ImageOptions) (uid.ID, error) {
 	if opts == nil {
 		opts = &ImageOptions{
 			Format: ImageFormatJPEG,
 		}
 	}
 
-	// For now, we'll just save the original image without any processing
-	img := file
+	store := openStore(storeName)
+	if store == nil {
+		return uid.ID{}, ErrStoreNotRegistered
+	}
 
-	// Get image dimensions
-	decodedImg, _, err := image.Decode(bytes.NewBuffer(img))
+	src, uploadSize, err := spoolUpload(file)
 	if err != nil {
+		return uid.ID{}, fmt.Errorf("error spooling image: %w", err)
+	}
+	if f, ok := src.(*os.File); ok && f != file {
+		defer removeTempFile(f)
+	}
+
+	if err := ChargeQuotasTx(ctx, tx, opts.Quota, uploadSize); err != nil {
 		return uid.ID{}, err
 	}
-	bounds := decodedImg.Bounds()
-	width, height := bounds.Dx(), bounds.Dy()
 
-	store := matchStore(storeName)
+	var source *uid.ID
+	if opts.Crop != nil {
+		uncropped := *opts
+		uncropped.Width, uncropped.Height, uncropped.Crop = 0, 0, nil
+		id, err := saveUploadTx(ctx, tx, storeName, src, uploadSize, &uncropped, nil)
+		if err != nil {
+			return uid.ID{}, err
+		}
+		source = &id
+	}
+	return saveUploadTx(ctx, tx, storeName, src, uploadSize, opts, source)
+}
+
+// saveUploadTx processes the uploaded image in src, which is uploadSize bytes
+// long, as per opts and saves it to the store with the name storeName,
+// creating its images table row within tx. If source is not nil, the image is
+// recorded as cropped from it.
+func saveUploadTx(ctx context.Context, tx *sql.Tx, storeName string, src io.ReadSeeker, uploadSize int64, opts *ImageOptions, source *uid.ID) (uid.ID, error) {
+	store := openStore(storeName)
 	if store == nil {
 		return uid.ID{}, ErrStoreNotRegistered
 	}
 
-	averageColor := AverageColor(decodedImg)
+	out, err := os.CreateTemp("", "discuit-image-*")
+	if err != nil {
+		return uid.ID{}, err
+	}
+	defer removeTempFile(out)
+
+	img, err := processUpload(out, src, opts)
+	if err != nil {
+		return uid.ID{}, err
+	}
+	if err := moderateUpload(ctx, out, img.format); err != nil {
+		return uid.ID{}, err
+	}
+	img.focalPoint = detectFocalPoint(ctx, out, img.format, img.focalPoint)
 
 	id := uid.New()
 	query, args := msql.BuildInsertQuery("images", []msql.ColumnValue{
 		{Name: "id", Value: id},
 		{Name: "store_name", Value: storeName},
-		{Name: "format", Value: opts.Format},
-		{Name: "width", Value: width},
-		{Name: "height", Value: height},
-		{Name: "size", Value: len(img)},
-		{Name: "upload_size", Value: len(file)},
-		{Name: "average_color", Value: averageColor},
+		{Name: "format", Value: img.format},
+		{Name: "width", Value: img.width},
+		{Name: "height", Value: img.height},
+		{Name: "size", Value: img.size},
+		{Name: "upload_size", Value: uploadSize},
+		{Name: "average_color", Value: img.averageColor},
+		{Name: "blurhash", Value: msql.NilIfEmptyString(img.blurhash)},
+		{Name: "checksum", Value: img.checksum},
+		{Name: "phash", Value: img.phash},
+		{Name: "orientation", Value: img.orientation},
+		{Name: "source_id", Value: source},
+		{Name: "crop", Value: opts.Crop},
+		{Name: "focal_point", Value: img.focalPoint},
+		{Name: "alt_text", Value: msql.NilIfEmptyString(opts.AltText)},
 	})
 
 	if _, err = tx.ExecContext(ctx, query, args...); err != nil {
 		return uid.ID{}, err
 	}
 
-	if err = store.save(&ImageRecord{
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		return uid.ID{}, err
+	}
+	if err = saveToStore(ctx, store, &ImageRecord{
 		ID:        id,
 		StoreName: storeName,
-		Format:    opts.Format,
-	}, img); err != nil {
+		Format:    img.format,
+	}, out, img.size); err != nil {
 		return uid.ID{}, fmt.Errorf("error saving image: %v", err)
 	}
 
 	return id, nil
 }
 
+// spoolUpload returns file as an io.ReadSeeker, along with its size. If file
+// is not an io.ReadSeeker, it's copied to a temporary file, which the caller
+// must remove.
+func spoolUpload(file io.Reader) (io.ReadSeeker, int64, error) {
+	if rs, ok := file.(io.ReadSeeker); ok {
+		size, err := rs.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, 0, err
+		}
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			return nil, 0, err
+		}
+		return rs, size, nil
+	}
+
+	f, err := os.CreateTemp("", "discuit-upload-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := io.Copy(f, file)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		removeTempFile(f)
+		return nil, 0, err
+	}
+	return f, size, nil
+}
+
+// removeTempFile closes and removes the temporary file f.
+func removeTempFile(f *os.File) {
+	f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		slog.Error("Error removing temporary file", "path", f.Name(), "error", err)
+	}
+}
+
+// DeleteImagesTx deletes images, or, if the trash is enabled (see
+// TrashRetention), moves them to the trash.
 func DeleteImagesTx(ctx context.Context, tx *sql.Tx, db *sql.DB, images ...uid.ID) error {
+	if TrashEnabled() {
+		return trashImagesTx(ctx, tx, images...)
+	}
+	return PurgeImagesTx(ctx, tx, db, images...)
+}
+
+// PurgeImagesTx permanently deletes images, along with their files, whether
+// they're in the trash or not.
+func PurgeImagesTx(ctx context.Context, tx *sql.Tx, db *sql.DB, images ...uid.ID) error {
 	records, err := GetImageRecords(ctx, db, images...)
 	if err != nil {
 		return err
 	}
 
 	for _, record := range records {
-		if err := record.store().delete(record); err != nil {
+		if err := record.store().Delete(ctx, record); err != nil {
 			return err
 		}
 	}
 
+	args := make([]any, len(images))
+	for i := range images {
+		args[i] = images[i]
+	}
+
 	// Attempt to remove images from cache. Continue even on failure.
 	for _, image := range images {
 		if err := removeFromCache(image); err != nil {
-			log.Printf("error removing images from cache on image id %v", err)
+			logging.FromContext(ctx).Error("Error removing image from cache", "image", image, "error", err)
 		}
 	}
-
-	args := make([]any, len(images))
-	for i := range images {
-		args[i] = images[i]
+	for _, record := range records {
+		if record.Checksum == nil {
+			continue
+		}
+		// The variants are shared with the images of the same content.
+		var others int
+		if err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM images WHERE checksum = ? AND id NOT IN %s", msql.InClauseQuestionMarks(len(images))),
+			append([]any{*record.Checksum}, args...)...).Scan(&others); err != nil {
+			return err
+		}
+		if others == 0 {
+			if err := removeVariantsFromCache(*record.Checksum); err != nil {
+				logging.FromContext(ctx).Error("Error removing variants of image from cache", "image", record.ID, "error", err)
+			}
+		}
 	}
 
 	_, err = tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM images WHERE id IN %s", msql.InClauseQuestionMarks(len(images))), args...)
@@ -731,32 +1232,45 @@ func NewImageProcessor(db *sql.DB, directory string) *ImageProcessor {
 	}
 }
 
-// SaveImage saves an image file with the given options.
+// SaveImage saves an image file with the given options. The file is streamed
+// to disk, rather than read into memory.
 func (p *ImageProcessor) SaveImage(file multipart.File, opts ImageOptions) (*Image, error) {
-	// Read file into memory
-	var buf bytes.Buffer
-	if _, err := io.Copy(&buf, file); err != nil {
+	// Create directory if it doesn't exist
+	if err := os.MkdirAll(p.directory, 0755); err != nil {
 		return nil, err
 	}
-	image := buf.Bytes()
 
-	// Generate a unique ID for the image
-	hash := sha256.Sum256(image)
-	id := hex.EncodeToString(hash[:])[:12]
-	uid, err := uid.FromString(id)
+	// Copy the file to a temporary file in the same directory, hashing it on
+	// the way, and then rename it to its final name (which is derived from the
+	// hash).
+	tmp, err := os.CreateTemp(p.directory, ".upload-*")
 	if err != nil {
-		return nil, fmt.Errorf("invalid image ID: %v", err)
+		return nil, err
 	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name()) // No-op if renamed.
+	}()
 
-	// Create directory if it doesn't exist
-	if err := os.MkdirAll(p.directory, 0755); err != nil {
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), file); err != nil {
 		return nil, err
 	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	// Generate a unique ID for the image
+	id := hex.EncodeToString(hash.Sum(nil))[:12]
+	uid, err := uid.FromString(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image ID: %v", err)
+	}
 
 	// Save the original image
 	filename := fmt.Sprintf("%s.%s", id, opts.Format)
 	filepath := path.Join(p.directory, filename)
-	if err := os.WriteFile(filepath, image, 0644); err != nil {
+	if err := os.Rename(tmp.Name(), filepath); err != nil {
 		return nil, err
 	}
 
@@ -771,7 +1285,9 @@ func (p *ImageProcessor) SaveImage(file multipart.File, opts ImageOptions) (*Ima
 	return img, nil
 }
 
-// ServeImage serves an image file.
+// ServeImage serves an image file. Since the ID of an image is derived from the
+// hash of its content, it's used as the ETag of the image, and the image is
+// cached indefinitely. Conditional and Range requests are supported.
 func (p *ImageProcessor) ServeImage(w http.ResponseWriter, r *http.Request, id string) error {
 	// Find image files matching the ID
 	pattern := path.Join(p.directory, id+".*")
@@ -794,12 +1310,28 @@ func (p *ImageProcessor) ServeImage(w http.ResponseWriter, r *http.Request, id s
 		contentType = "image/png"
 	case ".webp":
 		contentType = "image/webp"
+	case ".gif":
+		contentType = "image/gif"
+	case ".avif":
+		contentType = "image/avif"
 	default:
 		return errors.New("unsupported image format")
 	}
 
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
 	w.Header().Set("Content-Type", contentType)
-	http.ServeFile(w, r, filename)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+id+`"`)
+	http.ServeContent(w, r, "", info.ModTime(), file)
 	return nil
 }
 
@@ -3,8 +3,11 @@ package images
 import (
 	"encoding/json"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/discuitnet/discuit/internal/uid"
 )
@@ -44,6 +47,49 @@ func TestImageParamsFilename(t *testing.T) {
 	}
 }
 
+func TestChecksumCacheFilepath(t *testing.T) {
+	root := t.TempDir()
+	prevRoot := filesRootFolder
+	filesRootFolder = root
+	defer func() { filesRootFolder = prevRoot }()
+
+	sum := checksum([]byte("image"))
+	a := &request{id: uid.New(), size: ImageSize{300, 300}, fit: ImageFitCover, format: ImageFormatJPEG, checksum: sum}
+	b := *a
+	b.id = uid.New()
+	if cacheFilepath(a) != cacheFilepath(&b) {
+		t.Errorf("images of the same content have different cache paths: %s and %s", cacheFilepath(a), cacheFilepath(&b))
+	}
+	full := &request{id: a.id, format: ImageFormatWEBP, checksum: sum}
+	if want := filepath.Join(root, "variants", sum[:2], sum[2:4], sum+"_full.webp"); cacheFilepath(full) != want {
+		t.Errorf("expected cache path %s, got %s", want, cacheFilepath(full))
+	}
+
+	other := &request{id: a.id, format: ImageFormatWEBP, checksum: checksum([]byte("other"))}
+	for _, r := range []*request{a, full, other} {
+		if !isCacheFile(filepath.Base(cacheFilepath(r))) {
+			t.Errorf("%s is not a cache file", cacheFilepath(r))
+		}
+		if err := os.MkdirAll(filepath.Dir(cacheFilepath(r)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := putToCache([]byte("x"), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := removeVariantsFromCache(sum); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []*request{a, full} {
+		if _, err := os.Stat(cacheFilepath(r)); !os.IsNotExist(err) {
+			t.Errorf("%s not removed (err: %v)", cacheFilepath(r), err)
+		}
+	}
+	if _, err := os.Stat(cacheFilepath(other)); err != nil {
+		t.Errorf("variant of another image removed: %v", err)
+	}
+}
+
 func TestFromURL(t *testing.T) {
 	zeroID := uid.From(0, 0)
 	cases := []struct {
@@ -64,6 +110,41 @@ func TestFromURL(t *testing.T) {
 				hash:   []byte("haha"),
 			},
 		},
+		{
+			"/images/000000000000000000000000.JPG?size=300x300&fit=contain&sig=aGFoYQ",
+			false,
+			nil,
+			&request{
+				id:     zeroID,
+				size:   ImageSize{300, 300},
+				format: ImageFormatJPEG,
+				fit:    ImageFitContain,
+				hash:   []byte("haha"),
+			},
+		},
+		{
+			"/images/000000000000000000000000.jpeg?expires=32503680000&sig=aGFoYQ",
+			false,
+			nil,
+			&request{
+				id:      zeroID,
+				format:  ImageFormatJPEG,
+				hash:    []byte("haha"),
+				expires: 32503680000,
+			},
+		},
+		{
+			"/images/000000000000000000000000.jpeg?expires=1000&sig=aGFoYQ",
+			true,
+			ErrURLExpired,
+			nil,
+		},
+		{
+			"/images/000000000000000000000000.jpeg?expires=soon&sig=aGFoYQ",
+			true,
+			ErrBadURL,
+			nil,
+		},
 		{
 			"/images/000000000000000000000000.what?size=300x300&fit=contain&sig=aGFoYQ",
 			true,
@@ -99,6 +180,53 @@ func TestFromURL(t *testing.T) {
 	}
 }
 
+func TestSignedURLExpiry(t *testing.T) {
+	HMACKey = []byte("key")
+	defer func() { HMACKey = nil }()
+
+	r := &request{id: uid.From(0, 0), format: ImageFormatJPEG, expires: time.Now().Add(time.Hour).Unix()}
+	u, _ := url.Parse(r.url())
+	got, err := fromURL(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.valid() {
+		t.Fatal("signature of URL with an expiry time is invalid")
+	}
+	got.expires += 3600 // tampered with
+	if got.valid() {
+		t.Fatal("expiry time is not covered by the signature")
+	}
+
+	// Expired, but within the grace window.
+	r.expires = time.Now().Add(-time.Minute).Unix()
+	u, _ = url.Parse(r.url())
+	URLExpiryGrace = time.Minute * 5
+	defer func() { URLExpiryGrace = 0 }()
+	if _, err := fromURL(u); err != nil {
+		t.Fatalf("URL within grace window rejected: %v", err)
+	}
+}
+
+func TestURLExpiry(t *testing.T) {
+	defer func() { URLTTL = 0 }()
+	now := time.Date(2024, 1, 1, 10, 20, 0, 0, time.UTC)
+	cases := []struct {
+		ttl    time.Duration
+		expect time.Time
+	}{
+		{0, time.Time{}},
+		{time.Hour * 24, time.Date(2024, 1, 2, 11, 0, 0, 0, time.UTC)},
+		{time.Hour, time.Date(2024, 1, 1, 11, 30, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		URLTTL = c.ttl
+		if got := urlExpiry(now); !got.Equal(c.expect) {
+			t.Errorf("urlExpiry with TTL %v: expected %v, got %v", c.ttl, c.expect, got)
+		}
+	}
+}
+
 func TestRGBMarshal(t *testing.T) {
 	cases := []struct {
 		color      RGB
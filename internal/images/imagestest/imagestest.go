@@ -0,0 +1,133 @@
+// Package imagestest provides an in-memory images.Store for tests.
+package imagestest
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/images"
+)
+
+// MemStore is an images.ListableStore that keeps images in memory. It's safe
+// for concurrent use.
+type MemStore struct {
+	name string
+
+	mu     sync.Mutex
+	files  map[string]memFile
+	err    error // Returned by all operations, if set.
+	nSaves int
+}
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemStore returns an empty store named name. Register it with
+// images.RegisterStore to use it.
+func NewMemStore(name string) *MemStore {
+	return &MemStore{name: name, files: make(map[string]memFile)}
+}
+
+// Name implements images.Store.
+func (s *MemStore) Name() string { return s.name }
+
+// Key implements images.ListableStore.
+func (s *MemStore) Key(r *images.ImageRecord) string {
+	return r.ID.String()
+}
+
+// Get implements images.Store.
+func (s *MemStore) Get(ctx context.Context, r *images.ImageRecord) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	f, ok := s.files[s.Key(r)]
+	if !ok {
+		return nil, images.ErrImageNotFound
+	}
+	return bytes.Clone(f.data), nil
+}
+
+// Save implements images.Store.
+func (s *MemStore) Save(ctx context.Context, r *images.ImageRecord, image []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.files[s.Key(r)] = memFile{data: bytes.Clone(image), modTime: time.Now()}
+	s.nSaves++
+	return nil
+}
+
+// Delete implements images.Store.
+func (s *MemStore) Delete(ctx context.Context, r *images.ImageRecord) error {
+	return s.DeleteKey(ctx, s.Key(r))
+}
+
+// DeleteKey implements images.ListableStore.
+func (s *MemStore) DeleteKey(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	delete(s.files, key)
+	return nil
+}
+
+// List implements images.ListableStore. Files are listed in the order of
+// their keys.
+func (s *MemStore) List(ctx context.Context, fn func(key string, modTime time.Time) error) error {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return s.err
+	}
+	keys := make([]string, 0, len(s.files))
+	for key := range s.files {
+		keys = append(keys, key)
+	}
+	files := make(map[string]memFile, len(s.files))
+	for k, f := range s.files {
+		files[k] = f
+	}
+	s.mu.Unlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := fn(key, files[key].modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetErr makes all operations on s fail with err, or succeed again if err is
+// nil.
+func (s *MemStore) SetErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// Len returns the number of images in s.
+func (s *MemStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.files)
+}
+
+// Saves returns the number of images saved to s so far.
+func (s *MemStore) Saves() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nSaves
+}
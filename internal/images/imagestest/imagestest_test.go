@@ -0,0 +1,42 @@
+package imagestest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/images"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+var _ images.ListableStore = (*MemStore)(nil)
+
+func TestMemStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStore("test_mem")
+	r := &images.ImageRecord{ID: uid.New()}
+
+	if _, err := s.Get(ctx, r); err != images.ErrImageNotFound {
+		t.Fatalf("Get of a missing image: %v, want ErrImageNotFound", err)
+	}
+	if err := s.Save(ctx, r, []byte("image")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := s.Get(ctx, r); err != nil || string(data) != "image" {
+		t.Fatalf("Get = %q, %v", data, err)
+	}
+
+	failure := errors.New("down")
+	s.SetErr(failure)
+	if _, err := s.Get(ctx, r); err != failure {
+		t.Errorf("Get of a failing store: %v, want %v", err, failure)
+	}
+	s.SetErr(nil)
+
+	if err := s.Delete(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 0 || s.Saves() != 1 {
+		t.Errorf("Len, Saves = %d, %d; want 0, 1", s.Len(), s.Saves())
+	}
+}
@@ -0,0 +1,202 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/jobs"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Images are post-processed in the background after they're saved: the sizes
+// and formats they're commonly requested in (their variants) are generated
+// and put in the cache before the first request for them arrives, which
+// would otherwise have to wait for the image to be transformed. Post
+// processing is done by jobs (see SetJobQueue).
+
+// Variant is a size, fit, and format in which images are pre-generated.
+type Variant struct {
+	Size   ImageSize
+	Fit    ImageFit
+	Format ImageFormat // If empty, the format of the image.
+}
+
+// DefaultVariants are the copies of post images shown in feeds and on post
+// pages.
+var DefaultVariants = []Variant{
+	{Size: ImageSize{Width: 120, Height: 120}, Fit: ImageFitCover},
+	{Size: ImageSize{Width: 325, Height: 250}, Fit: ImageFitCover},
+	{Size: ImageSize{Width: 720, Height: 1440}, Fit: ImageFitContain},
+}
+
+// ParseVariant parses strings of the form "SIZE[:FIT[:FORMAT]]", like "120",
+// "325x250:cover", and "720x1440:contain:webp". If FIT is omitted,
+// ImageFitDefault is used.
+func ParseVariant(s string) (Variant, error) {
+	var v Variant
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return v, fmt.Errorf("invalid image variant: %s", s)
+	}
+	if err := v.Size.UnmarshalText([]byte(parts[0])); err != nil || v.Size.Zero() {
+		return v, fmt.Errorf("invalid image variant size: %s", s)
+	}
+	v.Fit = ImageFitDefault
+	if len(parts) > 1 {
+		if v.Fit = ImageFit(parts[1]); !v.Fit.Supported() {
+			return v, fmt.Errorf("invalid image variant fit: %s", s)
+		}
+	}
+	if len(parts) > 2 {
+		format, err := parseImageFormat(parts[2])
+		if err != nil {
+			return v, fmt.Errorf("invalid image variant format: %s", s)
+		}
+		v.Format = format
+	}
+	return v, nil
+}
+
+func (v Variant) String() string {
+	s := v.Size.String() + ":" + string(v.Fit)
+	if v.Format != "" {
+		s += ":" + string(v.Format)
+	}
+	return s
+}
+
+// jobPostProcess is the kind of the jobs that generate the variants of an
+// image. They're run on a jobs.Queue, so that jobs queued by one process may
+// be run by another, and failed jobs are retried with backoff.
+const jobPostProcess = "images_post_process"
+
+// postProcessJob is the payload of a jobPostProcess job.
+type postProcessJob struct {
+	ImageID uid.ID `json:"imageId"`
+}
+
+var (
+	jobQueueMu sync.RWMutex // guards jobQueue
+	jobQueue   *jobs.Queue
+)
+
+// SetJobQueue registers the handler of the jobs that generate variants of
+// images (DefaultVariants, if variants is nil) with q, and sets it as the
+// queue QueuePostProcessing adds jobs to. If q is nil, images are not
+// post-processed.
+func SetJobQueue(q *jobs.Queue, db *sql.DB, variants []Variant) {
+	if q != nil {
+		if variants == nil {
+			variants = DefaultVariants
+		}
+		q.Handle(jobPostProcess, func(ctx context.Context, payload json.RawMessage) error {
+			job := &postProcessJob{}
+			if err := json.Unmarshal(payload, job); err != nil {
+				return err
+			}
+			err := generateVariants(ctx, db, job.ImageID, variants)
+			if errors.Is(err, ErrImageNotFound) {
+				return nil // deleted
+			}
+			return err
+		})
+	}
+	jobQueueMu.Lock()
+	defer jobQueueMu.Unlock()
+	jobQueue = q
+}
+
+// generateVariants puts the variants of image, that are not already there,
+// in the cache. Variants in formats that cannot be encoded are skipped.
+func generateVariants(ctx context.Context, db *sql.DB, imageID uid.ID, variants []Variant) error {
+	record, err := GetImageRecord(ctx, db, imageID)
+	if err != nil {
+		return err
+	}
+
+	var data []byte // read only if a variant is missing
+	read := func() error {
+		if data != nil {
+			return nil
+		}
+		store := record.store()
+		if store == nil {
+			return fmt.Errorf("image store %v is not found", record.StoreName)
+		}
+		data, err = store.Get(ctx, record)
+		return err
+	}
+	if record.Checksum == nil {
+		// An image saved before checksums were.
+		if err := read(); err != nil {
+			return err
+		}
+		saveChecksum(ctx, db, record, data)
+	}
+
+	var img image.Image // decoded only if a variant is missing
+	for _, v := range variants {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r := &request{id: imageID, size: v.Size, fit: v.Fit, format: v.Format, checksum: *record.Checksum, focus: record.FocalPoint}
+		if r.format == "" {
+			r.format = record.Format
+		}
+		if _, err := os.Stat(cacheFilepath(r)); err == nil {
+			continue
+		}
+
+		if img == nil {
+			if err := read(); err != nil {
+				return err
+			}
+			if img, _, err = decodeImage(bytes.NewReader(data)); err != nil {
+				if err == errAVIFDecodeUnsupported {
+					return nil
+				}
+				return err
+			}
+		}
+
+		out, err := encodeImage(resizeImage(img, r.size, r.fit, r.focus), r.format)
+		if err != nil {
+			if err == ErrImageFormatUnsupported {
+				continue
+			}
+			return err
+		}
+		if err := putToCache(out, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueuePostProcessing queues the generation of the variants of images, if a
+// job queue is set (see SetJobQueue). Call it only after the transaction that
+// saved the images is committed. Errors are logged, since post-processing
+// failures are not fatal (variants are generated on request anyway).
+func QueuePostProcessing(images ...uid.ID) {
+	jobQueueMu.RLock()
+	q := jobQueue
+	jobQueueMu.RUnlock()
+	if q == nil || len(images) == 0 {
+		return
+	}
+	for _, id := range images {
+		if err := q.Enqueue(jobPostProcess, &postProcessJob{ImageID: id}, time.Now()); err != nil {
+			log.Printf("images: error queuing post-processing of image %v: %v\n", id, err)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package images
+
+import "testing"
+
+func TestParseVariant(t *testing.T) {
+	cases := []struct {
+		in     string
+		expect Variant
+		err    bool
+	}{
+		{"120", Variant{Size: ImageSize{120, 120}, Fit: ImageFitDefault}, false},
+		{"325x250:cover", Variant{Size: ImageSize{325, 250}, Fit: ImageFitCover}, false},
+		{"720x1440:contain:webp", Variant{Size: ImageSize{720, 1440}, Fit: ImageFitContain, Format: ImageFormatWEBP}, false},
+		{"720:contain:jpg", Variant{Size: ImageSize{720, 720}, Fit: ImageFitContain, Format: ImageFormatJPEG}, false},
+		{"", Variant{}, true},
+		{"0", Variant{}, true},
+		{"120:stretch", Variant{}, true},
+		{"120:cover:bmp", Variant{}, true},
+		{"120:cover:jpeg:x", Variant{}, true},
+	}
+	for _, c := range cases {
+		got, err := ParseVariant(c.in)
+		if c.err {
+			if err == nil {
+				t.Errorf("ParseVariant(%q): expected an error", c.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseVariant(%q): %v", c.in, err)
+			continue
+		}
+		if got != c.expect {
+			t.Errorf("ParseVariant(%q): expected %+v, got %+v", c.in, c.expect, got)
+		}
+		if again, err := ParseVariant(got.String()); err != nil || again != got {
+			t.Errorf("ParseVariant(%q) did not round-trip: %+v, %v", got.String(), again, err)
+		}
+	}
+}
@@ -0,0 +1,539 @@
+package images
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"math"
+)
+
+// Image metadata (EXIF, XMP, comments, and so on) is removed from uploaded
+// images, because it often contains information, like the location where a
+// photo was taken, that users don't intend to publish. ICC color profiles are
+// kept, since they affect how the image is displayed.
+
+// Orientation is the EXIF orientation of an image, which tells how the stored
+// pixels are to be transformed before the image is displayed. It's one of 1
+// (no transformation) through 8.
+type Orientation int
+
+const OrientationNormal = Orientation(1)
+
+// swapsDimensions reports whether displaying an image of orientation o swaps
+// its width and height.
+func (o Orientation) swapsDimensions() bool {
+	return o >= 5 && o <= 8
+}
+
+// exifOrientation returns the EXIF orientation of the image in r of format,
+// reading r from its start. If there's no orientation data, it returns
+// OrientationNormal.
+func exifOrientation(r io.ReadSeeker, format ImageFormat) Orientation {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return OrientationNormal
+	}
+	var tiff []byte
+	switch format {
+	case ImageFormatJPEG:
+		tiff = jpegEXIF(bufio.NewReader(r))
+	case ImageFormatPNG:
+		tiff = pngEXIF(bufio.NewReader(r))
+	case ImageFormatWEBP:
+		tiff = webpEXIF(r)
+		tiff = bytes.TrimPrefix(tiff, []byte("Exif\x00\x00"))
+	}
+	if o := tiffOrientation(tiff); o >= 1 && o <= 8 {
+		return o
+	}
+	return OrientationNormal
+}
+
+// tiffOrientation returns the value of the orientation tag in the first IFD of
+// the TIFF structure in data (the format EXIF data is stored in), or 0 if it's
+// not found.
+func tiffOrientation(data []byte) Orientation {
+	if len(data) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	if order.Uint16(data[2:4]) != 42 {
+		return 0
+	}
+	offset := int(order.Uint32(data[4:8]))
+	if offset < 8 || offset+2 > len(data) {
+		return 0
+	}
+	n := int(order.Uint16(data[offset : offset+2]))
+	for i := 0; i < n; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(data) {
+			return 0
+		}
+		const tagOrientation, typeShort = 0x0112, 3
+		if order.Uint16(data[entry:]) == tagOrientation && order.Uint16(data[entry+2:]) == typeShort {
+			return Orientation(order.Uint16(data[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// applyOrientation returns img transformed as per o, so that it's displayed
+// correctly without orientation metadata.
+func applyOrientation(img image.Image, o Orientation) image.Image {
+	if o <= OrientationNormal || o > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o.swapsDimensions() {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // mirrored along the top-left to bottom-right diagonal
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise to display
+				dx, dy = h-1-y, x
+			case 7: // mirrored along the top-right to bottom-left diagonal
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90° counter-clockwise to display
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+var (
+	errMalformedImage = errors.New("malformed image")
+	errStopIteration  = errors.New("stop iteration")
+)
+
+// maxEXIFSize is the maximum size of EXIF data that's read to find the
+// orientation of an image.
+const maxEXIFSize = 1 << 20
+
+// stripMetadata writes the image in src of format to w with all metadata
+// removed, leaving the image data itself untouched. src is read from its
+// start. AVIF images are copied as is.
+func stripMetadata(w io.Writer, src io.ReadSeeker, format ImageFormat) error {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	var err error
+	switch format {
+	case ImageFormatJPEG:
+		err = stripJPEGMetadata(bw, bufio.NewReader(src))
+	case ImageFormatPNG:
+		err = stripPNGMetadata(bw, bufio.NewReader(src))
+	case ImageFormatWEBP:
+		err = stripWEBPMetadata(bw, src)
+	case ImageFormatGIF:
+		err = stripGIFMetadata(bw, bufio.NewReader(src))
+	default:
+		_, err = io.Copy(bw, src)
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// readFull is io.ReadFull, except that running out of input is reported as
+// errMalformedImage.
+func readFull(r io.Reader, buf []byte) error {
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errMalformedImage
+		}
+		return err
+	}
+	return nil
+}
+
+// jpegSegments reads the marker segments of the JPEG image in r that precede
+// the image data, and calls f with each of them. The segment passed to f
+// includes its marker and length. If f returns errStopIteration, reading
+// stops and nil is returned. Otherwise, r is left positioned right after the
+// start of scan marker.
+func jpegSegments(r *bufio.Reader, f func(marker byte, segment []byte) error) error {
+	var soi [2]byte
+	if err := readFull(r, soi[:]); err != nil {
+		return err
+	}
+	if soi[0] != 0xFF || soi[1] != 0xD8 {
+		return errMalformedImage
+	}
+	for {
+		var m [2]byte
+		if err := readFull(r, m[:]); err != nil {
+			return err
+		}
+		if m[0] != 0xFF {
+			return errMalformedImage
+		}
+		for m[1] == 0xFF { // fill bytes
+			b, err := r.ReadByte()
+			if err != nil {
+				return errMalformedImage
+			}
+			m[1] = b
+		}
+		if m[1] == 0xDA { // start of scan
+			return nil
+		}
+		var l [2]byte
+		if err := readFull(r, l[:]); err != nil {
+			return err
+		}
+		length := int(binary.BigEndian.Uint16(l[:]))
+		if length < 2 {
+			return errMalformedImage
+		}
+		segment := make([]byte, 2+length)
+		copy(segment, []byte{0xFF, m[1], l[0], l[1]})
+		if err := readFull(r, segment[4:]); err != nil {
+			return err
+		}
+		if err := f(m[1], segment); err != nil {
+			if err == errStopIteration {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// jpegEXIF returns the TIFF structure of the EXIF segment of the JPEG image in
+// r, or nil if there's none.
+func jpegEXIF(r *bufio.Reader) []byte {
+	var tiff []byte
+	jpegSegments(r, func(marker byte, segment []byte) error {
+		if marker == 0xE1 && bytes.HasPrefix(segment[4:], []byte("Exif\x00\x00")) {
+			tiff = segment[10:]
+			return errStopIteration
+		}
+		return nil
+	})
+	return tiff
+}
+
+func stripJPEGMetadata(w *bufio.Writer, r *bufio.Reader) error {
+	w.Write([]byte{0xFF, 0xD8})
+	err := jpegSegments(r, func(marker byte, segment []byte) error {
+		// Keep APP0 (JFIF), APP2 (ICC profile), and APP14 (Adobe, which
+		// affects color conversion); drop all other application segments
+		// and comments.
+		isApp := marker >= 0xE0 && marker <= 0xEF
+		keep := !isApp || marker == 0xE0 || marker == 0xEE ||
+			(marker == 0xE2 && bytes.HasPrefix(segment[4:], []byte("ICC_PROFILE\x00")))
+		if marker == 0xFE { // comment
+			keep = false
+		}
+		if keep {
+			w.Write(segment)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	w.Write([]byte{0xFF, 0xDA})
+	_, err = io.Copy(w, r)
+	return err
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngChunks reads the chunks of the PNG image in r and calls f with the
+// header (length and type) of each, and with a reader of its body followed by
+// its CRC. The part of the chunk not read by f is skipped. If f returns
+// errStopIteration, reading stops and nil is returned.
+func pngChunks(r *bufio.Reader, f func(typ string, header []byte, body io.Reader) error) error {
+	sig := make([]byte, len(pngSignature))
+	if err := readFull(r, sig); err != nil {
+		return err
+	}
+	if !bytes.Equal(sig, pngSignature) {
+		return errMalformedImage
+	}
+	for {
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errMalformedImage
+		}
+		length := int64(binary.BigEndian.Uint32(header))
+		body := &io.LimitedReader{R: r, N: length + 4}
+		if err := f(string(header[4:]), header, body); err != nil {
+			if err == errStopIteration {
+				return nil
+			}
+			return err
+		}
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			return err
+		}
+		if body.N > 0 {
+			return errMalformedImage
+		}
+	}
+}
+
+// pngEXIF returns the body of the eXIf chunk of the PNG image in r, or nil if
+// there's none.
+func pngEXIF(r *bufio.Reader) []byte {
+	var tiff []byte
+	pngChunks(r, func(typ string, header []byte, body io.Reader) error {
+		switch typ {
+		case "eXIf":
+			if length := binary.BigEndian.Uint32(header); length <= maxEXIFSize {
+				tiff = make([]byte, length)
+				if _, err := io.ReadFull(body, tiff); err != nil {
+					tiff = nil
+				}
+			}
+			return errStopIteration
+		case "IDAT":
+			// The eXIf chunk, if there's one, precedes the image data.
+			return errStopIteration
+		}
+		return nil
+	})
+	return tiff
+}
+
+func stripPNGMetadata(w *bufio.Writer, r *bufio.Reader) error {
+	w.Write(pngSignature)
+	return pngChunks(r, func(typ string, header []byte, body io.Reader) error {
+		switch typ {
+		case "tEXt", "zTXt", "iTXt", "eXIf", "tIME":
+			return nil
+		}
+		w.Write(header)
+		_, err := io.Copy(w, body)
+		return err
+	})
+}
+
+// webpChunks calls f with the type and size of each chunk of the WEBP image in
+// r, with r positioned at the start of the body of the chunk. The rest of the
+// chunk (whatever f does not read of it, and its padding) is skipped.
+func webpChunks(r io.ReadSeeker, f func(typ string, size int64) error) error {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	var header [12]byte
+	if err := readFull(r, header[:]); err != nil {
+		return err
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:]) != "WEBP" {
+		return errMalformedImage
+	}
+	offset := int64(len(header))
+	for {
+		var ch [8]byte
+		if _, err := io.ReadFull(r, ch[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errMalformedImage
+		}
+		size := int64(binary.LittleEndian.Uint32(ch[4:]))
+		if err := f(string(ch[:4]), size); err != nil {
+			if err == errStopIteration {
+				return nil
+			}
+			return err
+		}
+		offset += 8 + size + size%2
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+}
+
+// webpEXIF returns the body of the EXIF chunk of the WEBP image in r, or nil
+// if there's none.
+func webpEXIF(r io.ReadSeeker) []byte {
+	var exif []byte
+	webpChunks(r, func(typ string, size int64) error {
+		if typ != "EXIF" {
+			return nil
+		}
+		if size <= maxEXIFSize {
+			exif = make([]byte, size)
+			if err := readFull(r, exif); err != nil {
+				exif = nil
+			}
+		}
+		return errStopIteration
+	})
+	return exif
+}
+
+func webpMetadataChunk(typ string) bool {
+	return typ == "EXIF" || typ == "XMP "
+}
+
+func stripWEBPMetadata(w *bufio.Writer, r io.ReadSeeker) error {
+	// The size of the output, which is written first, is found in a first
+	// pass over the chunks.
+	size := int64(4) // "WEBP"
+	err := webpChunks(r, func(typ string, n int64) error {
+		if !webpMetadataChunk(typ) {
+			size += 8 + n + n%2
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if size > math.MaxUint32 {
+		return errMalformedImage
+	}
+
+	w.WriteString("RIFF")
+	binary.Write(w, binary.LittleEndian, uint32(size))
+	w.WriteString("WEBP")
+	return webpChunks(r, func(typ string, n int64) error {
+		if webpMetadataChunk(typ) {
+			return nil
+		}
+		w.WriteString(typ)
+		binary.Write(w, binary.LittleEndian, uint32(n))
+		body := n
+		if typ == "VP8X" && n > 0 {
+			flags := make([]byte, 1)
+			if err := readFull(r, flags); err != nil {
+				return err
+			}
+			w.WriteByte(flags[0] &^ (0x08 | 0x04)) // clear the EXIF and XMP flags
+			body--
+		}
+		if _, err := io.CopyN(w, r, body); err != nil {
+			return errMalformedImage
+		}
+		if n%2 == 1 {
+			w.WriteByte(0) // padding
+		}
+		return nil
+	})
+}
+
+// stripGIFMetadata removes comment extensions and XMP application extensions
+// from the GIF image in r.
+func stripGIFMetadata(w *bufio.Writer, r *bufio.Reader) error {
+	header := make([]byte, 13)
+	if err := readFull(r, header); err != nil {
+		return err
+	}
+	if string(header[:6]) != "GIF87a" && string(header[:6]) != "GIF89a" {
+		return errMalformedImage
+	}
+	w.Write(header)
+	if flags := header[10]; flags&0x80 != 0 { // global color table
+		if _, err := io.CopyN(w, r, 3<<((flags&0x07)+1)); err != nil {
+			return errMalformedImage
+		}
+	}
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return errMalformedImage
+		}
+		switch b {
+		case 0x3B: // trailer
+			return w.WriteByte(b)
+		case 0x21: // extension
+			label, err := r.ReadByte()
+			if err != nil {
+				return errMalformedImage
+			}
+			n, err := r.ReadByte()
+			if err != nil {
+				return errMalformedImage
+			}
+			first := make([]byte, n)
+			if err := readFull(r, first); err != nil {
+				return err
+			}
+			isXMP := label == 0xFF && bytes.HasPrefix(first, []byte("XMP DataXMP"))
+			var dst io.Writer = w
+			if label == 0xFE || isXMP {
+				dst = io.Discard
+			}
+			dst.Write([]byte{b, label, n})
+			dst.Write(first)
+			if n > 0 {
+				if err := copyGIFSubBlocks(dst, r); err != nil {
+					return err
+				}
+			}
+		case 0x2C: // image descriptor
+			desc := make([]byte, 9)
+			if err := readFull(r, desc); err != nil {
+				return err
+			}
+			w.WriteByte(b)
+			w.Write(desc)
+			if flags := desc[8]; flags&0x80 != 0 { // local color table
+				if _, err := io.CopyN(w, r, 3<<((flags&0x07)+1)); err != nil {
+					return errMalformedImage
+				}
+			}
+			if _, err := io.CopyN(w, r, 1); err != nil { // LZW minimum code size
+				return errMalformedImage
+			}
+			if err := copyGIFSubBlocks(w, r); err != nil {
+				return err
+			}
+		default:
+			return errMalformedImage
+		}
+	}
+}
+
+// copyGIFSubBlocks copies data sub-blocks from r to w, up to and including the
+// block terminator.
+func copyGIFSubBlocks(w io.Writer, r *bufio.Reader) error {
+	for {
+		n, err := r.ReadByte()
+		if err != nil {
+			return errMalformedImage
+		}
+		if _, err := w.Write([]byte{n}); err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		if _, err := io.CopyN(w, r, int64(n)); err != nil {
+			return errMalformedImage
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"testing"
+)
+
+// jpegWithEXIF returns a JPEG image of width by height with an EXIF segment
+// that has the orientation o and a comment segment.
+func jpegWithEXIF(t *testing.T, width, height int, o Orientation) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// A big-endian TIFF structure with a single IFD entry.
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	tiff = binary.BigEndian.AppendUint16(tiff, 0x0112) // orientation tag
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)      // short
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)      // count
+	tiff = binary.BigEndian.AppendUint16(tiff, uint16(o))
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0) // padding and next IFD offset
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+
+	segment := func(marker byte, body []byte) []byte {
+		s := []byte{0xFF, marker}
+		s = binary.BigEndian.AppendUint16(s, uint16(len(body)+2))
+		return append(s, body...)
+	}
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment(0xE1, app1)...)
+	out = append(out, segment(0xFE, []byte("taken at home"))...)
+	return append(out, data[2:]...)
+}
+
+func TestEXIFOrientation(t *testing.T) {
+	for o := Orientation(1); o <= 8; o++ {
+		data := jpegWithEXIF(t, 4, 2, o)
+		if got := exifOrientation(bytes.NewReader(data), ImageFormatJPEG); got != o {
+			t.Errorf("expected orientation %d, got %d", o, got)
+		}
+	}
+	if got := exifOrientation(bytes.NewReader([]byte("not an image")), ImageFormatJPEG); got != OrientationNormal {
+		t.Errorf("expected orientation %d for invalid data, got %d", OrientationNormal, got)
+	}
+}
+
+func TestStripJPEGMetadata(t *testing.T) {
+	data := jpegWithEXIF(t, 4, 2, 6)
+	var buf bytes.Buffer
+	if err := stripMetadata(&buf, bytes.NewReader(data), ImageFormatJPEG); err != nil {
+		t.Fatal(err)
+	}
+	stripped := buf.Bytes()
+	if bytes.Contains(stripped, []byte("Exif")) || bytes.Contains(stripped, []byte("taken at home")) {
+		t.Error("metadata not stripped")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("stripped image fails to decode: %v", err)
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// A 2x1 image with a red pixel on the left and a blue one on the right.
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, red)
+	img.Set(1, 0, blue)
+
+	cases := []struct {
+		o             Orientation
+		width, height int
+		redX, redY    int
+	}{
+		{1, 2, 1, 0, 0},
+		{2, 2, 1, 1, 0},
+		{3, 2, 1, 1, 0},
+		{6, 1, 2, 0, 0},
+		{8, 1, 2, 0, 1},
+	}
+	for _, c := range cases {
+		got := applyOrientation(img, c.o)
+		if b := got.Bounds(); b.Dx() != c.width || b.Dy() != c.height {
+			t.Errorf("orientation %d: expected %dx%d, got %dx%d", c.o, c.width, c.height, b.Dx(), b.Dy())
+			continue
+		}
+		if r, _, _, _ := got.At(c.redX, c.redY).RGBA(); r != 0xffff {
+			t.Errorf("orientation %d: expected red pixel at (%d, %d)", c.o, c.redX, c.redY)
+		}
+	}
+}
+
+func TestProcessUpload(t *testing.T) {
+	data := jpegWithEXIF(t, 40, 20, 6)
+	var buf bytes.Buffer
+	p, err := processUpload(&buf, bytes.NewReader(data), &ImageOptions{Width: 10, Height: 10, Format: ImageFormatJPEG})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.size != int64(buf.Len()) {
+		t.Errorf("expected size %d, got %d", buf.Len(), p.size)
+	}
+	if p.orientation != 6 {
+		t.Errorf("expected orientation 6, got %d", p.orientation)
+	}
+	if p.width != 5 || p.height != 10 {
+		t.Errorf("expected a 5x10 image, got %dx%d", p.width, p.height)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("Exif")) {
+		t.Error("metadata not stripped")
+	}
+	if sum := checksum(buf.Bytes()); p.checksum != sum {
+		t.Errorf("expected checksum %s, got %s", sum, p.checksum)
+	}
+	if p.phash == nil {
+		t.Error("no perceptual hash")
+	}
+}
+
+func TestStripGIFMetadata(t *testing.T) {
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, image.NewPaletted(image.Rect(0, 0, 4, 4), palette.Plan9), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// Insert a comment extension before the trailer.
+	comment := append([]byte{0x21, 0xFE, 13}, "taken at home"...)
+	comment = append(comment, 0)
+	data = append(data[:len(data)-1:len(data)-1], append(comment, 0x3B)...)
+
+	var out bytes.Buffer
+	if err := stripMetadata(&out, bytes.NewReader(data), ImageFormatGIF); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out.Bytes(), []byte("taken at home")) {
+		t.Error("comment not stripped")
+	}
+	if !bytes.Equal(out.Bytes(), buf.Bytes()) {
+		t.Error("stripped image differs from the original")
+	}
+}
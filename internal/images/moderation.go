@@ -0,0 +1,223 @@
+package images
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Uploaded images can be checked by moderation hooks (say, for nudity or
+// CSAM) before they're saved (see SetModerator). Images rejected by a hook
+// are not saved; SaveImageTx returns a *RejectedError instead, and the
+// rejection is recorded, so that moderators can review automated rejections.
+// Images whose rejection is overturned on review are let through when
+// uploaded again.
+
+// A ModerationHook inspects uploaded images. Implementations must be safe for
+// concurrent use.
+type ModerationHook interface {
+	Name() string // The identifier of the hook.
+
+	// Moderate inspects image, which is of format, and returns whether to
+	// reject it. It returns an error if the image could not be inspected.
+	Moderate(ctx context.Context, image []byte, format ImageFormat) (*ModerationVerdict, error)
+}
+
+// ModerationVerdict is the outcome of a ModerationHook inspecting an image.
+type ModerationVerdict struct {
+	Reject bool
+	Reason string
+	Labels []string // What was detected in the image, if anything.
+}
+
+// RejectedError is returned when an uploaded image is rejected by a
+// moderation hook.
+type RejectedError struct {
+	Hook        string
+	Reason      string
+	RejectionID int // ID of the recorded rejection (0 if not recorded).
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("image rejected by moderation hook %s: %s", e.Hook, e.Reason)
+}
+
+// Moderator runs uploaded images through a set of moderation hooks.
+type Moderator struct {
+	db    *sql.DB
+	hooks []ModerationHook
+
+	// If true, images are accepted when a hook fails to inspect them.
+	// Otherwise, the upload fails.
+	FailOpen bool
+
+	// Time limit for all hooks to inspect an image. If zero, there's no
+	// limit beyond that of the upload's context.
+	Timeout time.Duration
+}
+
+// NewModerator returns a Moderator that runs images through hooks, in order,
+// and that records rejections in db.
+func NewModerator(db *sql.DB, hooks ...ModerationHook) *Moderator {
+	return &Moderator{db: db, hooks: hooks}
+}
+
+var moderator *Moderator
+
+// SetModerator sets the Moderator of uploaded images. If m is nil (the
+// default), uploaded images are not moderated.
+func SetModerator(m *Moderator) {
+	moderator = m
+}
+
+// moderateUpload runs the processed image in src, of format, through the
+// hooks of the package's Moderator, if one is set.
+func moderateUpload(ctx context.Context, src io.ReadSeeker, format ImageFormat) error {
+	m := moderator
+	if m == nil || len(m.hooks) == 0 {
+		return nil
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return err
+	}
+	return m.moderate(ctx, data, format)
+}
+
+func (m *Moderator) moderate(ctx context.Context, image []byte, format ImageFormat) error {
+	hash := sha256.Sum256(image)
+	if overturned, err := m.overturned(ctx, hash[:]); err != nil {
+		return err
+	} else if overturned {
+		return nil
+	}
+
+	if m.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.Timeout)
+		defer cancel()
+	}
+
+	for _, hook := range m.hooks {
+		verdict, err := hook.Moderate(ctx, image, format)
+		if err != nil {
+			if m.FailOpen {
+				log.Printf("Image moderation hook %s failed (image accepted): %v\n", hook.Name(), err)
+				continue
+			}
+			return fmt.Errorf("image moderation hook %s: %w", hook.Name(), err)
+		}
+		if verdict == nil || !verdict.Reject {
+			continue
+		}
+		rerr := &RejectedError{Hook: hook.Name(), Reason: verdict.Reason}
+		if rerr.RejectionID, err = m.recordRejection(context.WithoutCancel(ctx), hook.Name(), verdict, hash[:], format); err != nil {
+			log.Printf("Error recording image moderation rejection: %v\n", err)
+		}
+		return rerr
+	}
+	return nil
+}
+
+// overturned reports whether a rejection of the image with hash has been
+// overturned on review.
+func (m *Moderator) overturned(ctx context.Context, hash []byte) (bool, error) {
+	var n int
+	err := m.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM image_moderation_rejections WHERE image_hash = ? AND overturned = TRUE", hash).Scan(&n)
+	return n > 0, err
+}
+
+func (m *Moderator) recordRejection(ctx context.Context, hook string, v *ModerationVerdict, hash []byte, format ImageFormat) (int, error) {
+	labels, err := json.Marshal(v.Labels)
+	if err != nil {
+		return 0, err
+	}
+	res, err := m.db.ExecContext(ctx, "INSERT INTO image_moderation_rejections (hook, reason, labels, image_hash, image_format) VALUES (?, ?, ?, ?, ?)",
+		hook, msql.NilIfEmptyString(v.Reason), labels, hash, format)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// ModerationRejection is a recorded rejection of an uploaded image by a
+// moderation hook.
+type ModerationRejection struct {
+	ID          int             `json:"id"`
+	Hook        string          `json:"hook"`
+	Reason      msql.NullString `json:"reason"`
+	Labels      []string        `json:"labels"`
+	ImageHash   string          `json:"imageHash"` // SHA-256, hex-encoded.
+	ImageFormat ImageFormat     `json:"imageFormat"`
+	ReviewedAt  msql.NullTime   `json:"reviewedAt"`
+	ReviewedBy  uid.NullID      `json:"reviewedBy"`
+	Overturned  bool            `json:"overturned"`
+	CreatedAt   time.Time       `json:"createdAt"`
+}
+
+// GetModerationRejections returns the most recent rejections, at most limit
+// of them, of uploaded images by moderation hooks. If unreviewed is true,
+// only rejections not yet reviewed are returned.
+func GetModerationRejections(ctx context.Context, db *sql.DB, unreviewed bool, limit int) ([]*ModerationRejection, error) {
+	where := ""
+	if unreviewed {
+		where = "WHERE reviewed_at IS NULL "
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, hook, reason, labels, HEX(image_hash), image_format, reviewed_at, reviewed_by, overturned, created_at
+		FROM image_moderation_rejections `+where+`ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rejections := []*ModerationRejection{}
+	for rows.Next() {
+		r := &ModerationRejection{}
+		var labels []byte
+		if err := rows.Scan(&r.ID, &r.Hook, &r.Reason, &labels, &r.ImageHash, &r.ImageFormat, &r.ReviewedAt, &r.ReviewedBy, &r.Overturned, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		if len(labels) > 0 {
+			if err := json.Unmarshal(labels, &r.Labels); err != nil {
+				return nil, err
+			}
+		}
+		if r.Labels == nil {
+			r.Labels = []string{}
+		}
+		r.ImageHash = strings.ToLower(r.ImageHash)
+		rejections = append(rejections, r)
+	}
+	return rejections, rows.Err()
+}
+
+// ReviewModerationRejection records the review, by reviewer, of the
+// rejection with id. If overturn is true, the image is no longer rejected
+// when uploaded again.
+func ReviewModerationRejection(ctx context.Context, db *sql.DB, id int, reviewer uid.ID, overturn bool) error {
+	res, err := db.ExecContext(ctx, "UPDATE image_moderation_rejections SET reviewed_at = ?, reviewed_by = ?, overturned = ? WHERE id = ?",
+		time.Now(), reviewer, overturn, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
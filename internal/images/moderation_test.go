@@ -0,0 +1,80 @@
+package images
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNSFWEndpointHook(t *testing.T) {
+	var score float64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "image/jpeg" {
+			t.Errorf("Content-Type = %q, want image/jpeg", ct)
+		}
+		if b, _ := io.ReadAll(r.Body); string(b) != "image" {
+			t.Errorf("body = %q, want %q", b, "image")
+		}
+		json.NewEncoder(w).Encode(map[string]any{"score": score, "labels": []string{"porn"}})
+	}))
+	defer srv.Close()
+
+	hook := &NSFWEndpointHook{URL: srv.URL, Threshold: 0.8}
+	tests := []struct {
+		score  float64
+		reject bool
+	}{
+		{0.1, false},
+		{0.8, true},
+		{0.95, true},
+	}
+	for _, test := range tests {
+		score = test.score
+		v, err := hook.Moderate(context.Background(), []byte("image"), ImageFormatJPEG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Reject != test.reject {
+			t.Errorf("score %v: Reject = %v, want %v", test.score, v.Reject, test.reject)
+		}
+	}
+}
+
+func TestNSFWEndpointHookError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model not loaded", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	hook := &NSFWEndpointHook{URL: srv.URL, Threshold: 0.8}
+	if _, err := hook.Moderate(context.Background(), []byte("image"), ImageFormatJPEG); err == nil {
+		t.Error("Moderate returned no error for a failed request")
+	}
+}
+
+func TestRekognitionVerdict(t *testing.T) {
+	hook := &RekognitionHook{RejectLabels: []string{"Explicit Nudity"}, MinConfidence: 80}
+	tests := []struct {
+		name   string
+		labels []rekognitionLabel
+		reject bool
+	}{
+		{"none", nil, false},
+		{"top-level", []rekognitionLabel{{Name: "Explicit Nudity", Confidence: 92}}, true},
+		{"parent", []rekognitionLabel{{Name: "Graphic Male Nudity", ParentName: "Explicit Nudity", Confidence: 85}}, true},
+		{"low confidence", []rekognitionLabel{{Name: "Explicit Nudity", Confidence: 60}}, false},
+		{"other label", []rekognitionLabel{{Name: "Violence", Confidence: 99}}, false},
+	}
+	for _, test := range tests {
+		v := hook.verdict(test.labels)
+		if v.Reject != test.reject {
+			t.Errorf("%s: Reject = %v, want %v", test.name, v.Reject, test.reject)
+		}
+		if v.Reject && v.Reason == "" {
+			t.Errorf("%s: rejected with no reason", test.name)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// NSFWEndpointHook is a ModerationHook that sends images to an HTTP endpoint,
+// such as a locally run NSFW classification model.
+//
+// Images are POSTed, as is, to URL (with their MIME type as Content-Type),
+// which is expected to respond with a JSON object of the form {"score": 0.93,
+// "labels": ["porn"]}, score being the probability, from 0 to 1, of the image
+// being NSFW. Images with a score of Threshold or more are rejected.
+type NSFWEndpointHook struct {
+	URL       string
+	Threshold float64
+	Client    *http.Client // If nil, http.DefaultClient is used.
+}
+
+func (h *NSFWEndpointHook) Name() string {
+	return "nsfw-endpoint"
+}
+
+func (h *NSFWEndpointHook) Moderate(ctx context.Context, data []byte, format ImageFormat) (*ModerationVerdict, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", format.MimeType())
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, fmt.Errorf("nsfw endpoint: %s: %s", res.Status, bytes.TrimSpace(msg))
+	}
+
+	var out struct {
+		Score  *float64 `json:"score"`
+		Labels []string `json:"labels"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if out.Score == nil {
+		return nil, errors.New("nsfw endpoint: response has no score")
+	}
+
+	v := &ModerationVerdict{Labels: out.Labels}
+	if *out.Score >= h.Threshold {
+		v.Reject = true
+		v.Reason = fmt.Sprintf("NSFW score %.2f (threshold %.2f)", *out.Score, h.Threshold)
+	}
+	return v, nil
+}
@@ -0,0 +1,176 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"math/bits"
+	"slices"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+)
+
+// A PerceptualHash is a 64-bit hash of what an image looks like: images that
+// look alike (an image and a resized, re-encoded, or slightly edited copy of
+// it, say) have hashes that differ in few bits (see Distance), unlike their
+// checksums.
+//
+// It's stored in the phash column of the images table, as a signed integer.
+type PerceptualHash uint64
+
+// phashSize is the width and height, in pixels, of the grayscale copy of an
+// image that its perceptual hash is computed from.
+const phashSize = 32
+
+// PHash returns the perceptual hash of img: the signs, relative to their
+// median, of the 8x8 lowest frequencies of the discrete cosine transform of a
+// 32x32 grayscale copy of img.
+func PHash(img image.Image) PerceptualHash {
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return 0
+	}
+	small := scaleImage(img, b, phashSize, phashSize)
+	var pixels [phashSize][phashSize]float64
+	for y := 0; y < phashSize; y++ {
+		for x := 0; x < phashSize; x++ {
+			r, g, b, _ := small.At(x, y).RGBA()
+			pixels[y][x] = 0.299*float64(r>>8) + 0.587*float64(g>>8) + 0.114*float64(b>>8)
+		}
+	}
+
+	// The DCT is separable: transform the rows, and then the columns, keeping
+	// only the 8 lowest frequencies of each.
+	var cos [8][phashSize]float64
+	for u := 0; u < 8; u++ {
+		for x := 0; x < phashSize; x++ {
+			cos[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * phashSize))
+		}
+	}
+	var rows [phashSize][8]float64
+	for y := 0; y < phashSize; y++ {
+		for u := 0; u < 8; u++ {
+			for x := 0; x < phashSize; x++ {
+				rows[y][u] += pixels[y][x] * cos[u][x]
+			}
+		}
+	}
+	var coeffs [64]float64
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < phashSize; y++ {
+				sum += rows[y][u] * cos[v][y]
+			}
+			coeffs[v*8+u] = sum
+		}
+	}
+
+	// The DC coefficient, the average brightness of the image, is left out of
+	// the median.
+	sorted := slices.Clone(coeffs[1:])
+	slices.Sort(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+	var h PerceptualHash
+	for i, c := range coeffs {
+		if c > median {
+			h |= 1 << i
+		}
+	}
+	return h
+}
+
+// newPHash is like PHash, except that it returns a pointer, for
+// processedImage.
+func newPHash(img image.Image) *PerceptualHash {
+	h := PHash(img)
+	return &h
+}
+
+// Distance returns the number of bits h and o differ in, from 0 (the images
+// look the same) to 64. Images that are a distance of up to about 10 apart are
+// likely copies of each other.
+func (h PerceptualHash) Distance(o PerceptualHash) int {
+	return bits.OnesCount64(uint64(h ^ o))
+}
+
+// Value implements the driver.Valuer interface.
+func (h PerceptualHash) Value() (driver.Value, error) {
+	return int64(h), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (h *PerceptualHash) Scan(src any) error {
+	switch v := src.(type) {
+	case int64:
+		*h = PerceptualHash(v)
+	case []byte:
+		var n int64
+		if _, err := fmt.Sscan(string(v), &n); err != nil {
+			return err
+		}
+		*h = PerceptualHash(n)
+	default:
+		return fmt.Errorf("cannot scan %T into PerceptualHash", src)
+	}
+	return nil
+}
+
+// IndexPerceptualHashes computes and saves the perceptual hashes of up to n
+// images, saved after since, that don't have them (those saved before
+// perceptual hashes were). Images that could not be decoded when they were
+// saved are left out. It returns the number of images hashed.
+func IndexPerceptualHashes(ctx context.Context, db *sql.DB, since time.Time, n int) (int, error) {
+	query := msql.BuildSelectQuery("images", imageRecordSelectColumns, nil,
+		"WHERE phash IS NULL AND blurhash IS NOT NULL AND deleted_at IS NULL AND created_at > ? ORDER BY created_at DESC LIMIT ?")
+	rows, err := db.QueryContext(ctx, query, since, n)
+	if err != nil {
+		return 0, err
+	}
+	records, err := scanImageRecords(db, rows)
+	if err != nil {
+		return 0, err
+	}
+
+	hashed := 0
+	for _, record := range records {
+		if err := ctx.Err(); err != nil {
+			return hashed, err
+		}
+		h, err := record.perceptualHash(ctx)
+		if err != nil {
+			// Skipped, to be tried again in the next run.
+			log.Printf("Error computing the perceptual hash of image %v: %v\n", record.ID, err)
+			continue
+		}
+		if _, err := db.ExecContext(ctx, "UPDATE images SET phash = ? WHERE id = ?", h, record.ID); err != nil {
+			return hashed, err
+		}
+		hashed++
+	}
+	return hashed, nil
+}
+
+// perceptualHash reads the image of r from its store and returns its
+// perceptual hash.
+func (r *ImageRecord) perceptualHash(ctx context.Context) (PerceptualHash, error) {
+	store := r.store()
+	if store == nil {
+		return 0, fmt.Errorf("image store %v is not found", r.StoreName)
+	}
+	data, err := store.Get(ctx, r)
+	if err != nil {
+		return 0, err
+	}
+	img, _, err := decodeImage(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	return PHash(img), nil
+}
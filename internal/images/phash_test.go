@@ -0,0 +1,66 @@
+package images
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// phashTestImage returns a width by height image of diagonal stripes, a
+// pattern with plenty of low frequencies, brightened by bright.
+func phashTestImage(width, height, bright int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8(min(255, (x*255/width+y*255/height)/2+bright))
+			if (x*4/width+y*3/height)%2 == 0 {
+				v /= 3
+			}
+			img.Set(x, y, color.RGBA{v, v / 2, 255 - v, 255})
+		}
+	}
+	return img
+}
+
+func TestPHash(t *testing.T) {
+	h := PHash(phashTestImage(400, 300, 0))
+	if h == 0 {
+		t.Fatal("zero perceptual hash")
+	}
+
+	// A resized and brightened copy is a near-duplicate.
+	if d := h.Distance(PHash(phashTestImage(160, 120, 20))); d > 8 {
+		t.Errorf("distance to a resized copy is %d, want at most 8", d)
+	}
+
+	// A transposed image is not.
+	flipped := image.NewRGBA(image.Rect(0, 0, 300, 400))
+	src := phashTestImage(400, 300, 0)
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 400; x++ {
+			flipped.Set(y, x, src.At(x, y))
+		}
+	}
+	if d := h.Distance(PHash(flipped)); d <= 8 {
+		t.Errorf("distance to a different image is %d, want more than 8", d)
+	}
+
+	if h := PHash(image.NewRGBA(image.Rect(0, 0, 0, 0))); h != 0 {
+		t.Errorf("perceptual hash of an empty image is %x, want 0", h)
+	}
+}
+
+func TestPerceptualHashScan(t *testing.T) {
+	want := PerceptualHash(1<<63 | 5)
+	v, err := want.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got PerceptualHash
+	if err := got.Scan(v); err != nil || got != want {
+		t.Errorf("Scan(Value()) = %x, %v; want %x", got, err, want)
+	}
+	if err := got.Scan([]byte("-9223372036854775803")); err != nil || got != want {
+		t.Errorf("Scan of text = %x, %v; want %x", got, err, want)
+	}
+}
@@ -0,0 +1,234 @@
+package images
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Copies of images are generated, and cached, when they're first requested,
+// which makes the first viewers of an image wait. A Prefetcher generates the
+// copies of images that are about to be viewed by many (those of posts on
+// the front page, say) ahead of time: it puts the copies queued to it (see
+// QueuePrefetch) in the cache and, if WarmURLs is set, requests them by their
+// URLs, so that the CDN in front of the image server, if any, caches them as
+// well.
+
+// maxPrefetchRemembered is the number of prefetched copies a Prefetcher
+// remembers past which it forgets those prefetched over Remember ago.
+const maxPrefetchRemembered = 10000
+
+// PrefetcherOptions are the options of a Prefetcher.
+type PrefetcherOptions struct {
+	Workers   int
+	QueueSize int // Copies queued past it are dropped.
+
+	// If true, copies are requested by their URLs once they're cached.
+	WarmURLs bool
+	Client   *http.Client // If nil, http.DefaultClient is used.
+
+	// Copies are not prefetched again for Remember after they're prefetched.
+	Remember time.Duration
+}
+
+// Prefetcher generates the copies of images ahead of their first request.
+type Prefetcher struct {
+	db    *sql.DB
+	opts  PrefetcherOptions
+	queue chan *ImageCopy
+
+	mu   sync.Mutex
+	seen map[prefetchKey]time.Time // When the copies were queued.
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// prefetchKey identifies a copy of an image, whatever its URL.
+type prefetchKey struct {
+	image  string
+	size   ImageSize
+	fit    ImageFit
+	format ImageFormat
+}
+
+func keyOf(c *ImageCopy) prefetchKey {
+	return prefetchKey{
+		image:  c.ImageID.String(),
+		size:   ImageSize{Width: c.BoxWidth, Height: c.BoxHeight},
+		fit:    c.Fit,
+		format: c.Format,
+	}
+}
+
+// NewPrefetcher returns a Prefetcher of the images of db. Call Start to start
+// its workers.
+func NewPrefetcher(db *sql.DB, opts PrefetcherOptions) *Prefetcher {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1000
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.Remember <= 0 {
+		opts.Remember = time.Hour
+	}
+	return &Prefetcher{
+		db:    db,
+		opts:  opts,
+		queue: make(chan *ImageCopy, opts.QueueSize),
+		seen:  make(map[prefetchKey]time.Time),
+	}
+}
+
+// Start starts the workers of p. It returns immediately.
+func (p *Prefetcher) Start() {
+	var ctx context.Context
+	ctx, p.cancel = context.WithCancel(context.Background())
+	for i := 0; i < p.opts.Workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case c := <-p.queue:
+					if err := p.prefetch(ctx, c); err != nil && ctx.Err() == nil {
+						log.Printf("images: error prefetching copy %s of image %v: %v\n", c.Name, c.ImageID, err)
+						p.forget(c)
+					}
+				}
+			}
+		}()
+	}
+}
+
+// Stop stops the workers of p and waits for the copies being prefetched to be
+// done, or for ctx to be canceled. Copies still queued are dropped.
+func (p *Prefetcher) Stop(ctx context.Context) error {
+	if p.cancel == nil {
+		return nil
+	}
+	p.cancel()
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Enqueue queues copies to be prefetched, leaving out those prefetched (or
+// queued) recently, and those of original images. Copies are dropped if the
+// queue is full. It returns the number of copies queued.
+func (p *Prefetcher) Enqueue(copies ...*ImageCopy) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if len(p.seen) > maxPrefetchRemembered {
+		for key, at := range p.seen {
+			if now.Sub(at) > p.opts.Remember {
+				delete(p.seen, key)
+			}
+		}
+	}
+
+	n := 0
+	for _, c := range copies {
+		if c == nil || c.BoxWidth == 0 && c.BoxHeight == 0 {
+			continue
+		}
+		key := keyOf(c)
+		if at, ok := p.seen[key]; ok && now.Sub(at) <= p.opts.Remember {
+			continue
+		}
+		select {
+		case p.queue <- c:
+			p.seen[key] = now
+			n++
+		default:
+			return n // Full.
+		}
+	}
+	return n
+}
+
+// forget lets c be prefetched again.
+func (p *Prefetcher) forget(c *ImageCopy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.seen, keyOf(c))
+}
+
+// prefetch puts c in the cache and, if p.opts.WarmURLs, requests it by its
+// URL.
+func (p *Prefetcher) prefetch(ctx context.Context, c *ImageCopy) error {
+	r := &request{
+		id:     c.ImageID,
+		size:   ImageSize{Width: c.BoxWidth, Height: c.BoxHeight},
+		fit:    c.Fit,
+		format: c.Format,
+	}
+	if _, err := getImage(ctx, p.db, r, true); err != nil {
+		return err
+	}
+	if !p.opts.WarmURLs || c.URL == "" {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.URL, nil)
+	if err != nil {
+		return err
+	}
+	res, err := p.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if _, err := io.Copy(io.Discard, res.Body); err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("warming %s: %s", c.URL, res.Status)
+	}
+	return nil
+}
+
+var (
+	prefetcherMu sync.RWMutex // guards prefetcher
+	prefetcher   *Prefetcher
+)
+
+// SetPrefetcher sets the Prefetcher QueuePrefetch queues copies to.
+func SetPrefetcher(p *Prefetcher) {
+	prefetcherMu.Lock()
+	defer prefetcherMu.Unlock()
+	prefetcher = p
+}
+
+// QueuePrefetch queues copies to be prefetched, if a Prefetcher is set (see
+// SetPrefetcher), and returns the number of copies queued.
+func QueuePrefetch(copies ...*ImageCopy) int {
+	prefetcherMu.RLock()
+	p := prefetcher
+	prefetcherMu.RUnlock()
+	if p == nil || len(copies) == 0 {
+		return 0
+	}
+	return p.Enqueue(copies...)
+}
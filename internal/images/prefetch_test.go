@@ -0,0 +1,43 @@
+package images
+
+import (
+	"testing"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestPrefetcherEnqueue(t *testing.T) {
+	p := NewPrefetcher(nil, PrefetcherOptions{QueueSize: 3, Remember: time.Hour})
+	id := uid.New()
+	tiny := &ImageCopy{ImageID: id, Name: "tiny", BoxWidth: 120, BoxHeight: 120, Fit: ImageFitCover, Format: ImageFormatJPEG, URL: "a"}
+	same := *tiny
+	same.URL = "b" // Of another expiry time, say.
+	original := &ImageCopy{ImageID: id, Format: ImageFormatJPEG}
+
+	if n := p.Enqueue(tiny, &same, original); n != 1 {
+		t.Errorf("queued %d copies, want 1", n)
+	}
+	if n := p.Enqueue(tiny); n != 0 {
+		t.Errorf("queued a copy queued recently")
+	}
+	p.forget(tiny)
+	if n := p.Enqueue(tiny); n != 1 {
+		t.Errorf("did not queue a forgotten copy")
+	}
+
+	var copies []*ImageCopy
+	for i := 0; i < 5; i++ {
+		copies = append(copies, &ImageCopy{ImageID: uid.New(), BoxWidth: 325, BoxHeight: 250, Fit: ImageFitCover, Format: ImageFormatJPEG})
+	}
+	if n := p.Enqueue(copies...); n != 1 {
+		t.Errorf("queued %d copies to a queue with room for 1", n)
+	}
+	if n := p.Enqueue(copies[2]); n != 0 {
+		t.Errorf("queued a copy to a full queue")
+	}
+	<-p.queue
+	if n := p.Enqueue(copies[2]); n != 1 {
+		t.Errorf("did not queue a copy dropped when the queue was full")
+	}
+}
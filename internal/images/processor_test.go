@@ -0,0 +1,67 @@
+package images
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImageProcessorServeImage(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte("0123456789")
+	if err := os.WriteFile(filepath.Join(dir, "abc123.png"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := NewImageProcessor(nil, dir)
+
+	serve := func(header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/images/abc123", nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		if err := p.ServeImage(w, r, "abc123"); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	w := serve(nil)
+	if w.Code != http.StatusOK || w.Body.String() != string(data) {
+		t.Fatalf("got %d %q, want the whole image", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("ETag"); got != `"abc123"` {
+		t.Errorf("ETag = %s", got)
+	}
+	if got := w.Header().Get("Content-Type"); got != "image/png" {
+		t.Errorf("Content-Type = %s", got)
+	}
+
+	if w := serve(http.Header{"If-None-Match": {`"abc123"`}}); w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: got status %d, want 304", w.Code)
+	}
+	if w := serve(http.Header{"If-None-Match": {`"other"`}}); w.Code != http.StatusOK {
+		t.Errorf("If-None-Match (stale): got status %d, want 200", w.Code)
+	}
+
+	w = serve(http.Header{"Range": {"bytes=2-5"}})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "2345" {
+		t.Errorf("Range: got %d %q, want 206 \"2345\"", w.Code, w.Body.String())
+	}
+}
+
+func TestContentETag(t *testing.T) {
+	a, b := contentETag([]byte("a")), contentETag([]byte("b"))
+	if a == b {
+		t.Error("different content has the same ETag")
+	}
+	if a != contentETag([]byte("a")) {
+		t.Error("ETag is not deterministic")
+	}
+	if len(a) != 34 || a[0] != '"' || a[len(a)-1] != '"' {
+		t.Errorf("malformed ETag %s", a)
+	}
+}
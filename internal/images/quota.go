@@ -0,0 +1,214 @@
+package images
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Users and communities have upload quotas: the cumulative size of the images
+// uploaded by (or for) each is tracked, and SaveImageTx refuses uploads that
+// would take an owner over their quota with a *QuotaError. Each owner type has
+// a default quota (see SetDefaultQuota), which admins can override for
+// individual owners. Quotas are of bytes uploaded, not of bytes stored, so
+// deleting images doesn't free up quota; admins can reset usage instead.
+
+// QuotaOwnerType is the type of owner of an upload quota.
+type QuotaOwnerType string
+
+// Valid quota owner types.
+const (
+	QuotaOwnerUser      = QuotaOwnerType("user")
+	QuotaOwnerCommunity = QuotaOwnerType("community")
+)
+
+// Valid reports whether t is a valid quota owner type.
+func (t QuotaOwnerType) Valid() bool {
+	return t == QuotaOwnerUser || t == QuotaOwnerCommunity
+}
+
+// QuotaOwner is a user or a community whose uploads count against its quota.
+type QuotaOwner struct {
+	Type QuotaOwnerType
+	ID   uid.ID
+}
+
+// ErrQuotaExceeded is matched (using errors.Is) by all *QuotaError errors.
+var ErrQuotaExceeded = errors.New("image upload quota exceeded")
+
+// QuotaError is returned when an upload would take its owner over their
+// quota.
+type QuotaError struct {
+	Owner QuotaOwner
+	Used  int64 // Bytes uploaded so far.
+	Limit int64
+	Size  int64 // Size of the upload.
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("image upload quota of %s %v exceeded (%d of %d bytes used; upload of %d bytes)", e.Owner.Type, e.Owner.ID, e.Used, e.Limit, e.Size)
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+var (
+	defaultQuotasMu sync.RWMutex
+	defaultQuotas   = make(map[QuotaOwnerType]int64)
+)
+
+// SetDefaultQuota sets the quota, in bytes, of owners of type t whose quotas
+// are not overridden. If limit is 0 (the default), their uploads are not
+// limited.
+func SetDefaultQuota(t QuotaOwnerType, limit int64) {
+	defaultQuotasMu.Lock()
+	defer defaultQuotasMu.Unlock()
+	defaultQuotas[t] = limit
+}
+
+func defaultQuota(t QuotaOwnerType) int64 {
+	defaultQuotasMu.RLock()
+	defer defaultQuotasMu.RUnlock()
+	return defaultQuotas[t]
+}
+
+// Quota is the upload quota of an owner.
+type Quota struct {
+	OwnerType QuotaOwnerType `json:"ownerType"`
+	OwnerID   uid.ID         `json:"ownerId"`
+	Used      int64          `json:"used"`     // Bytes uploaded.
+	Override  *int64         `json:"override"` // If nil, the default quota applies.
+	Limit     int64          `json:"limit"`    // The quota in effect (0 means unlimited).
+	UpdatedAt *time.Time     `json:"updatedAt"`
+}
+
+// setLimit sets the quota in effect of q.
+func (q *Quota) setLimit() {
+	if q.Override != nil {
+		q.Limit = *q.Override
+	} else {
+		q.Limit = defaultQuota(q.OwnerType)
+	}
+}
+
+// exceededBy reports whether uploading size more bytes takes q over its
+// quota.
+func (q *Quota) exceededBy(size int64) bool {
+	return q.Limit > 0 && q.Used+size > q.Limit
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+const selectQuotasQuery = "SELECT owner_type, owner_id, used_bytes, quota_bytes, updated_at FROM image_quotas "
+
+func scanQuota(row interface{ Scan(...any) error }) (*Quota, error) {
+	q := &Quota{}
+	var (
+		override  sql.NullInt64
+		updatedAt time.Time
+	)
+	if err := row.Scan(&q.OwnerType, &q.OwnerID, &q.Used, &override, &updatedAt); err != nil {
+		return nil, err
+	}
+	if override.Valid {
+		q.Override = &override.Int64
+	}
+	q.UpdatedAt = &updatedAt
+	q.setLimit()
+	return q, nil
+}
+
+func getQuota(ctx context.Context, db queryRower, owner QuotaOwner, forUpdate bool) (*Quota, error) {
+	query := selectQuotasQuery + "WHERE owner_type = ? AND owner_id = ?"
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	q, err := scanQuota(db.QueryRowContext(ctx, query, owner.Type, owner.ID))
+	if err == sql.ErrNoRows {
+		q = &Quota{OwnerType: owner.Type, OwnerID: owner.ID}
+		q.setLimit()
+		return q, nil
+	}
+	return q, err
+}
+
+// GetQuota returns the upload quota of owner.
+func GetQuota(ctx context.Context, db *sql.DB, owner QuotaOwner) (*Quota, error) {
+	return getQuota(ctx, db, owner, false)
+}
+
+// GetQuotas returns the quotas of owners of type t that have uploaded images
+// or whose quotas are overridden, the heaviest uploaders first.
+func GetQuotas(ctx context.Context, db *sql.DB, t QuotaOwnerType, limit int) ([]*Quota, error) {
+	rows, err := db.QueryContext(ctx, selectQuotasQuery+"WHERE owner_type = ? ORDER BY used_bytes DESC LIMIT ?", t, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	quotas := []*Quota{}
+	for rows.Next() {
+		q, err := scanQuota(rows)
+		if err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, q)
+	}
+	return quotas, rows.Err()
+}
+
+// SetQuota overrides the quota of owner with limit (in bytes; 0 means
+// unlimited). If limit is nil, the default quota applies to owner again.
+func SetQuota(ctx context.Context, db *sql.DB, owner QuotaOwner, limit *int64) (*Quota, error) {
+	if limit != nil && *limit < 0 {
+		return nil, errors.New("negative quota")
+	}
+	var override sql.NullInt64
+	if limit != nil {
+		override = sql.NullInt64{Int64: *limit, Valid: true}
+	}
+	if _, err := db.ExecContext(ctx, `INSERT INTO image_quotas (owner_type, owner_id, quota_bytes) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE quota_bytes = VALUES(quota_bytes), updated_at = current_timestamp()`, owner.Type, owner.ID, override); err != nil {
+		return nil, err
+	}
+	return GetQuota(ctx, db, owner)
+}
+
+// ResetQuotaUsage sets the bytes uploaded by owner to 0.
+func ResetQuotaUsage(ctx context.Context, db *sql.DB, owner QuotaOwner) (*Quota, error) {
+	if _, err := db.ExecContext(ctx, "UPDATE image_quotas SET used_bytes = 0, updated_at = current_timestamp() WHERE owner_type = ? AND owner_id = ?", owner.Type, owner.ID); err != nil {
+		return nil, err
+	}
+	return GetQuota(ctx, db, owner)
+}
+
+// ChargeQuotasTx counts an upload of size bytes against the quotas of owners,
+// or returns a *QuotaError if that would take any of them over their quota.
+// The quota rows are locked until tx ends, so that concurrent uploads of an
+// owner cannot overshoot its quota.
+func ChargeQuotasTx(ctx context.Context, tx *sql.Tx, owners []QuotaOwner, size int64) error {
+	for _, owner := range owners {
+		if _, err := tx.ExecContext(ctx, "INSERT IGNORE INTO image_quotas (owner_type, owner_id) VALUES (?, ?)", owner.Type, owner.ID); err != nil {
+			return err
+		}
+		q, err := getQuota(ctx, tx, owner, true)
+		if err != nil {
+			return err
+		}
+		if q.exceededBy(size) {
+			return &QuotaError{Owner: owner, Used: q.Used, Limit: q.Limit, Size: size}
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE image_quotas SET used_bytes = used_bytes + ?, updated_at = current_timestamp() WHERE owner_type = ? AND owner_id = ?", size, owner.Type, owner.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package images
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestQuotaError(t *testing.T) {
+	var err error = &QuotaError{Owner: QuotaOwner{Type: QuotaOwnerUser, ID: uid.New()}, Used: 10, Limit: 12, Size: 5}
+	err = fmt.Errorf("saving image: %w", err)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Error("expected a wrapped *QuotaError to match ErrQuotaExceeded")
+	}
+	var quotaErr *QuotaError
+	if !errors.As(err, &quotaErr) || quotaErr.Limit != 12 {
+		t.Errorf("expected errors.As to find the *QuotaError, got %v", quotaErr)
+	}
+}
+
+func TestQuotaLimit(t *testing.T) {
+	SetDefaultQuota(QuotaOwnerCommunity, 100)
+	defer SetDefaultQuota(QuotaOwnerCommunity, 0)
+
+	q := &Quota{OwnerType: QuotaOwnerCommunity, Used: 90}
+	q.setLimit()
+	if q.Limit != 100 {
+		t.Fatalf("expected the default quota, got %d", q.Limit)
+	}
+	if q.exceededBy(10) {
+		t.Error("an upload that fills the quota exactly should be allowed")
+	}
+	if !q.exceededBy(11) {
+		t.Error("expected an upload over the quota to exceed it")
+	}
+
+	unlimited := int64(0)
+	q.Override = &unlimited
+	q.setLimit()
+	if q.exceededBy(1 << 40) {
+		t.Error("expected an overridden quota of 0 to be unlimited")
+	}
+
+	q = &Quota{OwnerType: QuotaOwnerUser, Used: 1 << 40}
+	q.setLimit()
+	if q.exceededBy(1) {
+		t.Error("expected no limit without a default quota")
+	}
+}
@@ -23,14 +23,22 @@ type ImageRecord struct {
 	storeMetadataRawJSON *string
 	StoreMetadata        map[string]any `json:"storeMetadata"`
 
-	Format       ImageFormat `json:"format"`
-	Width        int         `json:"width"`
-	Height       int         `json:"height"`
-	Size         int         `json:"size"`
-	UploadSize   int         `json:"uploadSize"`
-	AverageColor RGB         `json:"averageColor"`
-	CreatedAt    time.Time   `json:"createdAt"`
-	DeletedAt    *time.Time  `json:"deletedAt"`
+	Format       ImageFormat     `json:"format"`
+	Width        int             `json:"width"`
+	Height       int             `json:"height"`
+	Size         int             `json:"size"`
+	UploadSize   int             `json:"uploadSize"`
+	AverageColor RGB             `json:"averageColor"`
+	Blurhash     *string         `json:"blurhash"`    // Nil for images that could not be decoded.
+	Checksum     *string         `json:"checksum"`    // SHA-256 of the stored image; nil for images saved before checksums.
+	PHash        *PerceptualHash `json:"phash"`       // Nil for images that could not be decoded, or saved before perceptual hashes.
+	Orientation  Orientation     `json:"orientation"` // EXIF orientation of the uploaded image.
+	SourceID     *uid.ID         `json:"sourceId"`    // The uncropped image this image was cropped from, if any.
+	Crop         *CropRect       `json:"crop"`        // The crop of the source, if any.
+	FocalPoint   *FocalPoint     `json:"focalPoint"`  // Nil for images that could not be decoded, or saved before focal points.
+	AltText      *string         `json:"altText"`
+	CreatedAt    time.Time       `json:"createdAt"`
+	DeletedAt    *time.Time      `json:"deletedAt"`
 }
 
 // ImageRecordColumns returns the list of columns of the images table. Use this
@@ -47,6 +55,14 @@ func ImageRecordColumns() []string {
 		"images.size",
 		"images.upload_size",
 		"images.average_color",
+		"images.blurhash",
+		"images.checksum",
+		"images.phash",
+		"images.orientation",
+		"images.source_id",
+		"images.crop",
+		"images.focal_point",
+		"images.alt_text",
 		"images.created_at",
 		"images.deleted_at",
 	}
@@ -67,6 +83,14 @@ func (r *ImageRecord) ScanDestinations() []any {
 		&r.Size,
 		&r.UploadSize,
 		&r.AverageColor,
+		&r.Blurhash,
+		&r.Checksum,
+		&r.PHash,
+		&r.Orientation,
+		&r.SourceID,
+		&r.Crop,
+		&r.FocalPoint,
+		&r.AltText,
 		&r.CreatedAt,
 		&r.DeletedAt,
 	}
@@ -141,8 +165,8 @@ func (r *ImageRecord) UnmarshalMetadataJSON() error {
 	return nil
 }
 
-func (r *ImageRecord) store() store {
-	return matchStore(r.StoreName)
+func (r *ImageRecord) store() Store {
+	return openStore(r.StoreName)
 }
 
 func (r *ImageRecord) StoreExists() bool {
@@ -157,22 +181,34 @@ func (r *ImageRecord) Image() *Image {
 	*m.Height = r.Height
 	*m.Size = r.Size
 	*m.AverageColor = r.AverageColor
+	m.Blurhash = r.Blurhash
+	m.Crop = r.Crop
+	m.AltText = r.AltText
 	m.PostScan()
 	return m
 }
 
 // Image is an image that's to be sent to the client. It is to be derived from
 // an ImageRecord.
+//
+// Its JSON form is the same for every image: the original image (its URL,
+// format, and dimensions), the formats it can be requested in, and its
+// variants (see AppendCopy), keyed by their names.
 type Image struct {
-	ID           *uid.ID      `json:"id"`
-	Format       *ImageFormat `json:"format"`
-	MimeType     *string      `json:"mimetype"`
-	Width        *int         `json:"width"`
-	Height       *int         `json:"height"`
-	Size         *int         `json:"size"`
-	AverageColor *RGB         `json:"averageColor"`
-	URL          *string      `json:"url"`
-	Copies       []*ImageCopy `json:"copies"`
+	ID           *uid.ID               `json:"id"`
+	Format       *ImageFormat          `json:"format"`
+	Formats      []ImageFormat         `json:"formats"` // The formats the image can be requested in.
+	MimeType     *string               `json:"-"`
+	Width        *int                  `json:"width"`
+	Height       *int                  `json:"height"`
+	Size         *int                  `json:"size"` // In bytes.
+	AverageColor *RGB                  `json:"averageColor"`
+	Blurhash     *string               `json:"blurhash"`
+	AltText      *string               `json:"altText"`
+	URL          *string               `json:"url"`
+	Copies       []*ImageCopy          `json:"-"`
+	Variants     map[string]*ImageCopy `json:"variants"`       // Copies, by name.
+	Crop         *CropRect             `json:"crop,omitempty"` // If the image was cropped on upload (see ImageOptions.Crop).
 }
 
 // NewImage returns an Image with all pointer fields allocated and set to zero
@@ -188,6 +224,7 @@ func NewImage() *Image {
 	m.AverageColor = new(RGB)
 	m.URL = new(string)
 	m.Copies = make([]*ImageCopy, 0)
+	m.Variants = make(map[string]*ImageCopy)
 	return m
 }
 
@@ -202,6 +239,9 @@ func ImageColumns(tableAlias string) []string {
 		tableAlias + ".height",
 		tableAlias + ".size",
 		tableAlias + ".average_color",
+		tableAlias + ".blurhash",
+		tableAlias + ".crop",
+		tableAlias + ".alt_text",
 	}
 }
 
@@ -213,6 +253,9 @@ func (m *Image) ScanDestinations() []any {
 		&m.Height,
 		&m.Size,
 		&m.AverageColor,
+		&m.Blurhash,
+		&m.Crop,
+		&m.AltText,
 	}
 }
 
@@ -222,10 +265,14 @@ func (m *Image) PostScan() {
 	if m.Format != nil {
 		s := "image/" + string(*m.Format)
 		m.MimeType = &s
+		m.Formats = m.Format.servedAs()
 	}
 	if m.Copies == nil {
 		m.Copies = make([]*ImageCopy, 0)
 	}
+	if m.Variants == nil {
+		m.Variants = make(map[string]*ImageCopy)
+	}
 	m.SetURL()
 }
 
@@ -238,6 +285,9 @@ func (m *Image) SetURL() {
 		id:     *m.ID,
 		format: *m.Format,
 	}
+	if t := urlExpiry(time.Now()); !t.IsZero() {
+		req.expires = t.Unix()
+	}
 	url := req.url()
 	if FullImageURL != nil {
 		url = FullImageURL(url)
@@ -248,8 +298,9 @@ func (m *Image) SetURL() {
 	*m.URL = url
 }
 
-// AppendCopy is a helper function that appends an ImageCopy to m.Copies slice.
-// If format is zero, m.Format is used.
+// AppendCopy is a helper function that appends an ImageCopy to m.Copies slice,
+// and, if name is not empty, sets it as the variant of m with name (replacing
+// any other). If format is zero, m.Format is used.
 func (m *Image) AppendCopy(name string, boxWidth, boxHeight int, fit ImageFit, format ImageFormat) *ImageCopy {
 	copy := &ImageCopy{
 		ImageID:   *m.ID,
@@ -272,6 +323,12 @@ func (m *Image) AppendCopy(name string, boxWidth, boxHeight int, fit ImageFit, f
 
 	copy.SetURL()
 	m.Copies = append(m.Copies, copy)
+	if name != "" {
+		if m.Variants == nil {
+			m.Variants = make(map[string]*ImageCopy)
+		}
+		m.Variants[name] = copy
+	}
 	return copy
 }
 
@@ -308,6 +365,9 @@ func (c *ImageCopy) SetURL() {
 		fit:    c.Fit,
 		format: c.Format,
 	}
+	if t := urlExpiry(time.Now()); !t.IsZero() {
+		r.expires = t.Unix()
+	}
 	c.URL = r.url()
 	if FullImageURL != nil {
 		c.URL = FullImageURL(c.URL)
@@ -1,6 +1,8 @@
 package images
 
 import (
+	"encoding/json"
+	"slices"
 	"testing"
 
 	"github.com/discuitnet/discuit/internal/uid"
@@ -51,3 +53,53 @@ func TestImagePath(t *testing.T) {
 		}
 	}
 }
+
+func TestImageJSON(t *testing.T) {
+	alt := "A cat."
+	r := &ImageRecord{ID: uid.From(0, 0), Format: ImageFormatJPEG, Width: 800, Height: 600, Size: 1000, AltText: &alt}
+	m := r.Image()
+	m.AppendCopy("small", 400, 400, ImageFitContain, "")
+	m.AppendCopy("large", 1600, 1600, ImageFitContain, "")
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"id", "url", "format", "formats", "width", "height", "size", "averageColor", "blurhash", "altText", "variants"} {
+		if _, ok := got[key]; !ok {
+			t.Errorf("no %q in %s", key, data)
+		}
+	}
+	for _, key := range []string{"copies", "mimetype"} {
+		if _, ok := got[key]; ok {
+			t.Errorf("%q in %s", key, data)
+		}
+	}
+	if got["altText"] != alt {
+		t.Errorf("got altText %v, want %q", got["altText"], alt)
+	}
+
+	variants, _ := got["variants"].(map[string]any)
+	small, _ := variants["small"].(map[string]any)
+	if small == nil || small["url"] == "" || small["width"] != 400.0 || small["height"] != 300.0 {
+		t.Errorf("got small variant %v", variants["small"])
+	}
+	if _, ok := variants["large"]; !ok {
+		t.Errorf("no large variant in %v", variants)
+	}
+
+	want := []ImageFormat{ImageFormatJPEG, ImageFormatPNG}
+	if avifCodec() {
+		want = append(want, ImageFormatAVIF)
+	}
+	if !slices.Equal(m.Formats, want) {
+		t.Errorf("got formats %v for a JPEG image", m.Formats)
+	}
+	if f := ImageFormatAVIF.servedAs(); !avifCodec() && !slices.Equal(f, []ImageFormat{ImageFormatAVIF}) {
+		t.Errorf("got formats %v for an AVIF image", f)
+	}
+}
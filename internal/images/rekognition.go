@@ -0,0 +1,222 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// rekognitionMaxImageSize is the maximum size of images sent to
+// DetectModerationLabels, and rekognitionMaxDimension the maximum width and
+// height of images re-encoded to be sent to it.
+const (
+	rekognitionMaxImageSize = 5 << 20
+	rekognitionMaxDimension = 2048
+)
+
+// RekognitionHook is a ModerationHook that rejects images for which AWS
+// Rekognition's DetectModerationLabels detects any of RejectLabels.
+type RekognitionHook struct {
+	Region      string
+	Credentials aws.CredentialsProvider
+	Client      *http.Client // If nil, http.DefaultClient is used.
+	Endpoint    string       // If empty, the regional endpoint is used.
+
+	// The (top or second level) moderation labels, like "Explicit Nudity",
+	// for which images are rejected, if detected with a confidence (0 to 100)
+	// of at least MinConfidence.
+	RejectLabels  []string
+	MinConfidence float64
+}
+
+func (h *RekognitionHook) Name() string {
+	return "rekognition"
+}
+
+type rekognitionLabel struct {
+	Name       string
+	ParentName string
+	Confidence float64
+}
+
+func (h *RekognitionHook) Moderate(ctx context.Context, data []byte, format ImageFormat) (*ModerationVerdict, error) {
+	data, err := rekognitionImage(data, format)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		ModerationLabels []rekognitionLabel
+	}
+	in := map[string]any{
+		"Image":         map[string]any{"Bytes": data},
+		"MinConfidence": h.MinConfidence,
+	}
+	if err := rekognitionCall(ctx, h.Client, h.Endpoint, h.Region, h.Credentials, "DetectModerationLabels", in, &out); err != nil {
+		return nil, err
+	}
+	return h.verdict(out.ModerationLabels), nil
+}
+
+// rekognitionCall calls the action of the Rekognition API in region (at
+// endpoint, if it's not empty) with the input in, and decodes its output into
+// out. If client is nil, http.DefaultClient is used.
+func rekognitionCall(ctx context.Context, client *http.Client, endpoint, region string, creds aws.CredentialsProvider, action string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	if endpoint == "" {
+		endpoint = "https://rekognition." + region + ".amazonaws.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "RekognitionService."+action)
+
+	credentials, err := creds.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	payloadHash := sha256.Sum256(body)
+	if err := v4.NewSigner().SignHTTP(ctx, credentials, req, hex.EncodeToString(payloadHash[:]), "rekognition", region, time.Now()); err != nil {
+		return err
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("rekognition: %s: %s", res.Status, bytes.TrimSpace(msg))
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// verdict returns the verdict for an image in which labels were detected.
+func (h *RekognitionHook) verdict(labels []rekognitionLabel) *ModerationVerdict {
+	v := &ModerationVerdict{}
+	var rejected []string
+	for _, l := range labels {
+		v.Labels = append(v.Labels, l.Name)
+		if l.Confidence < h.MinConfidence {
+			continue
+		}
+		if slices.Contains(h.RejectLabels, l.Name) || (l.ParentName != "" && slices.Contains(h.RejectLabels, l.ParentName)) {
+			rejected = append(rejected, fmt.Sprintf("%s (%.0f%%)", l.Name, l.Confidence))
+		}
+	}
+	if len(rejected) > 0 {
+		v.Reject = true
+		v.Reason = "detected " + strings.Join(rejected, ", ")
+	}
+	return v
+}
+
+// rekognitionImage returns the image in data, of format, in a form accepted
+// by Rekognition: a JPEG or PNG image of at most rekognitionMaxImageSize
+// bytes.
+func rekognitionImage(data []byte, format ImageFormat) ([]byte, error) {
+	if (format == ImageFormatJPEG || format == ImageFormatPNG) && len(data) <= rekognitionMaxImageSize {
+		return data, nil
+	}
+	img, _, err := decodeImage(bytes.NewReader(data))
+	if err != nil {
+		if err == errAVIFDecodeUnsupported {
+			return nil, ErrImageFormatUnsupported
+		}
+		return nil, err
+	}
+	img = resizeImage(img, ImageSize{Width: rekognitionMaxDimension, Height: rekognitionMaxDimension}, ImageFitContain, nil)
+	data, err = encodeImage(flattenImage(img, image.White), ImageFormatJPEG)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > rekognitionMaxImageSize {
+		return nil, errors.New("rekognition: image too large")
+	}
+	return data, nil
+}
+
+// rekognitionMinFaceConfidence is the minimum confidence (0 to 100) of the
+// faces RekognitionFaceDetector takes into account.
+const rekognitionMinFaceConfidence = 90
+
+// RekognitionFaceDetector is a FocalPointDetector that finds the faces in
+// images with AWS Rekognition's DetectFaces. The focal point of an image is
+// the center of its faces, weighted by their size.
+type RekognitionFaceDetector struct {
+	Region      string
+	Credentials aws.CredentialsProvider
+	Client      *http.Client // If nil, http.DefaultClient is used.
+	Endpoint    string       // If empty, the regional endpoint is used.
+}
+
+func (d *RekognitionFaceDetector) Name() string {
+	return "rekognition-faces"
+}
+
+type rekognitionFace struct {
+	BoundingBox struct {
+		Width, Height, Left, Top float64
+	}
+	Confidence float64
+}
+
+func (d *RekognitionFaceDetector) DetectFocalPoint(ctx context.Context, data []byte, format ImageFormat) (*FocalPoint, error) {
+	data, err := rekognitionImage(data, format)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		FaceDetails []rekognitionFace
+	}
+	in := map[string]any{
+		"Image":      map[string]any{"Bytes": data},
+		"Attributes": []string{"DEFAULT"},
+	}
+	if err := rekognitionCall(ctx, d.Client, d.Endpoint, d.Region, d.Credentials, "DetectFaces", in, &out); err != nil {
+		return nil, err
+	}
+	return facesFocalPoint(out.FaceDetails), nil
+}
+
+// facesFocalPoint returns the center of faces, weighted by their area, or nil
+// if there are no faces (of enough confidence).
+func facesFocalPoint(faces []rekognitionFace) *FocalPoint {
+	var sx, sy, sw float64
+	for _, f := range faces {
+		box := f.BoundingBox
+		if f.Confidence < rekognitionMinFaceConfidence || box.Width <= 0 || box.Height <= 0 {
+			continue
+		}
+		area := box.Width * box.Height
+		sx += area * (box.Left + box.Width/2)
+		sy += area * (box.Top + box.Height/2)
+		sw += area
+	}
+	if sw == 0 {
+		return nil
+	}
+	p := FocalPoint{X: sx / sw, Y: sy / sw}.clamp()
+	return &p
+}
@@ -0,0 +1,358 @@
+package images
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// replicas maps the name of a store to the names of the stores its writes
+// are replicated to (see SetReplicas). Guarded by storesMu.
+var replicas = make(map[string][]string)
+
+// SetReplicas makes the images saved to, and deleted from, the store primary
+// to be saved to, and deleted from, the stores replicaNames as well. Images
+// that cannot be read from primary are read from the replicas, in order. All
+// the stores must be registered. Calling SetReplicas with no replicaNames
+// stops the replication of primary.
+//
+// Only writes made after SetReplicas is called are replicated; use Migrate to
+// copy existing images.
+func SetReplicas(primary string, replicaNames ...string) error {
+	if matchStore(primary) == nil {
+		return fmt.Errorf("%w: %v", ErrStoreNotRegistered, primary)
+	}
+	for i, name := range replicaNames {
+		if name == primary {
+			return fmt.Errorf("store %v cannot be a replica of itself", name)
+		}
+		if slices.Contains(replicaNames[:i], name) {
+			return fmt.Errorf("store %v is listed as a replica more than once", name)
+		}
+		if matchStore(name) == nil {
+			return fmt.Errorf("%w: %v", ErrStoreNotRegistered, name)
+		}
+	}
+
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	if len(replicaNames) == 0 {
+		delete(replicas, primary)
+	} else {
+		replicas[primary] = slices.Clone(replicaNames)
+	}
+	return nil
+}
+
+// replicasOf returns the names of the replicas of the store with name.
+func replicasOf(name string) []string {
+	storesMu.RLock()
+	defer storesMu.RUnlock()
+	return replicas[name]
+}
+
+// fallbacks maps the name of a store to the names of the stores its reads
+// fall back to (see SetFallbacks). Guarded by storesMu.
+var fallbacks = make(map[string][]string)
+
+// SetFallbacks makes the images that cannot be read from the store name to be
+// read from the stores fallbackNames, in order (after its replicas, if it has
+// any). This is for when images are being moved to name from another store
+// (see Migrate): images not yet copied to name are read from where they were.
+// Unlike replicas, fallbacks are not written to, but images deleted from name
+// are deleted from them as well. While a store has fallbacks, clients are not
+// redirected to it (see Redirector), so that its images are served by the
+// images server, which can fall back. All the stores must be registered.
+// Calling SetFallbacks with no fallbackNames removes the fallbacks of name.
+func SetFallbacks(name string, fallbackNames ...string) error {
+	if matchStore(name) == nil {
+		return fmt.Errorf("%w: %v", ErrStoreNotRegistered, name)
+	}
+	for i, fallback := range fallbackNames {
+		if fallback == name {
+			return fmt.Errorf("store %v cannot be a fallback of itself", fallback)
+		}
+		if slices.Contains(fallbackNames[:i], fallback) {
+			return fmt.Errorf("store %v is listed as a fallback more than once", fallback)
+		}
+		if matchStore(fallback) == nil {
+			return fmt.Errorf("%w: %v", ErrStoreNotRegistered, fallback)
+		}
+	}
+
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	if len(fallbackNames) == 0 {
+		delete(fallbacks, name)
+	} else {
+		fallbacks[name] = slices.Clone(fallbackNames)
+	}
+	return nil
+}
+
+// fallbacksOf returns the names of the fallbacks of the store with name.
+func fallbacksOf(name string) []string {
+	storesMu.RLock()
+	defer storesMu.RUnlock()
+	return fallbacks[name]
+}
+
+// openStore returns the registered store with name, or nil if there's no such
+// store. If the store has replicas, writes to the returned store are
+// replicated, and if it has fallbacks, reads from it fall back to them.
+func openStore(name string) Store {
+	primary := matchStore(name)
+	if primary == nil {
+		return nil
+	}
+	replicaNames, fallbackNames := replicasOf(name), fallbacksOf(name)
+	if len(replicaNames) == 0 && len(fallbackNames) == 0 {
+		return primary
+	}
+	rs := &replicatedStore{primary: primary}
+	for _, name := range replicaNames {
+		if s := matchStore(name); s != nil {
+			rs.replicas = append(rs.replicas, s)
+		}
+	}
+	for _, name := range fallbackNames {
+		if s := matchStore(name); s != nil {
+			rs.fallbacks = append(rs.fallbacks, s)
+		}
+	}
+	return rs
+}
+
+// replicatedStore is a Store that saves images to, and deletes images from, a
+// primary store and its replicas, and that reads images from its fallbacks
+// (see SetFallbacks) when they cannot be read from the others. It's named
+// after the primary store.
+type replicatedStore struct {
+	primary   Store
+	replicas  []Store
+	fallbacks []Store
+}
+
+func (s *replicatedStore) Name() string {
+	return s.primary.Name()
+}
+
+// all returns the primary store followed by the replicas.
+func (s *replicatedStore) all() []Store {
+	return append([]Store{s.primary}, s.replicas...)
+}
+
+// Get returns the image from the primary store, or, if that fails, from the
+// first replica, or else the first fallback, that has it.
+func (s *replicatedStore) Get(ctx context.Context, r *ImageRecord) ([]byte, error) {
+	image, err := s.primary.Get(ctx, r)
+	if err == nil {
+		return image, nil
+	}
+	for _, replica := range s.replicas {
+		if ctx.Err() != nil {
+			break
+		}
+		if image, rerr := replica.Get(ctx, r); rerr == nil {
+			log.Printf("Image %v read from replica %s (store %s: %v)\n", r.ID, replica.Name(), s.primary.Name(), err)
+			return image, nil
+		}
+	}
+	for _, fallback := range s.fallbacks {
+		if ctx.Err() != nil {
+			break
+		}
+		if image, ferr := fallback.Get(ctx, r); ferr == nil {
+			return image, nil
+		}
+	}
+	return nil, err
+}
+
+// Save saves the image to all the stores. It fails if saving to any of them
+// fails, in which case the image may be left in some of them (see
+// GarbageCollect).
+func (s *replicatedStore) Save(ctx context.Context, r *ImageRecord, image []byte) error {
+	for _, store := range s.all() {
+		if err := store.Save(ctx, r, image); err != nil {
+			return fmt.Errorf("store %s: %w", store.Name(), err)
+		}
+	}
+	return nil
+}
+
+// SaveStream implements StreamSaver. Unless src is an io.Seeker, the image is
+// read into memory.
+func (s *replicatedStore) SaveStream(ctx context.Context, r *ImageRecord, src io.Reader, size int64) error {
+	rs, ok := src.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(src)
+		if err != nil {
+			return err
+		}
+		return s.Save(ctx, r, data)
+	}
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	for _, store := range s.all() {
+		if _, err := rs.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+		if err := saveToStore(ctx, store, r, rs, size); err != nil {
+			return fmt.Errorf("store %s: %w", store.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Delete deletes the image from all the stores, even if deleting from some of
+// them (the fallbacks included) fails.
+func (s *replicatedStore) Delete(ctx context.Context, r *ImageRecord) error {
+	var errs []error
+	for _, store := range append(s.all(), s.fallbacks...) {
+		if err := store.Delete(ctx, r); err != nil {
+			errs = append(errs, fmt.Errorf("store %s: %w", store.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RedirectURL implements Redirector. Clients are only redirected to the
+// primary store, and not at all while it has fallbacks.
+func (s *replicatedStore) RedirectURL(ctx context.Context, r *ImageRecord) (string, time.Time, error) {
+	if len(s.fallbacks) > 0 {
+		return "", time.Time{}, nil
+	}
+	if rd, ok := s.primary.(Redirector); ok {
+		return rd.RedirectURL(ctx, r)
+	}
+	return "", time.Time{}, nil
+}
+
+// migrateBatchSize is the number of image records fetched at a time by
+// Migrate.
+const migrateBatchSize = 100
+
+// MigrateResult is the result of a Migrate run.
+type MigrateResult struct {
+	Migrated int              // Number of images moved to the new store.
+	Failed   []MigrateFailure // Images left in the old store.
+}
+
+// MigrateFailure is an image that could not be migrated.
+type MigrateFailure struct {
+	ID  uid.ID
+	Err error
+}
+
+// ErrChecksumMismatch is returned (wrapped) when an image copied to a store
+// differs from the original.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Migrate copies the images of the store from to the store to, and, for each
+// image whose copy is verified to be identical (by its SHA-256 checksum),
+// changes the store of its record to the store to. Images that fail to be
+// copied are left in from and reported in the result; Migrate can be run again
+// to retry them. Both stores must be registered, and new images should be
+// saved to the store to (see SetDefaultStoreName) before Migrate is called.
+//
+// The original files are not deleted: being files with no record, they're
+// deleted by GarbageCollect.
+func Migrate(ctx context.Context, db *sql.DB, from, to string) (*MigrateResult, error) {
+	if from == to {
+		return nil, errors.New("cannot migrate images to the same store")
+	}
+	src, dst := matchStore(from), matchStore(to)
+	if src == nil {
+		return nil, fmt.Errorf("%w: %v", ErrStoreNotRegistered, from)
+	}
+	if dst == nil {
+		return nil, fmt.Errorf("%w: %v", ErrStoreNotRegistered, to)
+	}
+
+	res := &MigrateResult{}
+	var last uid.ID
+	for {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		rows, err := db.QueryContext(ctx, "SELECT id FROM images WHERE store_name = ? AND id > ? ORDER BY id LIMIT ?", from, last, migrateBatchSize)
+		if err != nil {
+			return res, err
+		}
+		var ids []uid.ID
+		for rows.Next() {
+			var id uid.ID
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return res, err
+			}
+			ids = append(ids, id)
+		}
+		if err := rows.Err(); err != nil {
+			return res, err
+		}
+		if len(ids) == 0 {
+			return res, nil
+		}
+		last = ids[len(ids)-1]
+
+		records, err := GetImageRecords(ctx, db, ids...)
+		if err != nil {
+			if errors.Is(err, ErrImageNotFound) {
+				continue // Deleted since.
+			}
+			return res, err
+		}
+		for _, record := range records {
+			if err := copyImage(ctx, src, dst, record); err != nil {
+				res.Failed = append(res.Failed, MigrateFailure{ID: record.ID, Err: err})
+				continue
+			}
+			result, err := db.ExecContext(ctx, "UPDATE images SET store_name = ? WHERE id = ? AND store_name = ?", to, record.ID, from)
+			if err != nil {
+				return res, err
+			}
+			if n, err := result.RowsAffected(); err != nil {
+				return res, err
+			} else if n == 0 {
+				// The image was deleted while being copied.
+				if err := dst.Delete(ctx, record); err != nil {
+					log.Printf("Error deleting copy of deleted image %v: %v\n", record.ID, err)
+				}
+				continue
+			}
+			res.Migrated++
+		}
+	}
+}
+
+// copyImage copies the image of r from the store src to the store dst, and
+// verifies that the copy matches the original.
+func copyImage(ctx context.Context, src, dst Store, r *ImageRecord) error {
+	image, err := src.Get(ctx, r)
+	if err != nil {
+		return fmt.Errorf("reading from %s: %w", src.Name(), err)
+	}
+	if err := dst.Save(ctx, r, image); err != nil {
+		return fmt.Errorf("saving to %s: %w", dst.Name(), err)
+	}
+	copied, err := dst.Get(ctx, r)
+	if err != nil {
+		return fmt.Errorf("reading back from %s: %w", dst.Name(), err)
+	}
+	if sha256.Sum256(copied) != sha256.Sum256(image) {
+		return fmt.Errorf("image %v in %s: %w", r.ID, dst.Name(), ErrChecksumMismatch)
+	}
+	return nil
+}
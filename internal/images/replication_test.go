@@ -0,0 +1,174 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// memStore is a Store that keeps images in memory.
+type memStore struct {
+	name    string
+	mu      sync.Mutex
+	images  map[uid.ID][]byte
+	corrupt bool // Whether Get returns a modified image.
+}
+
+func newMemStore(name string) *memStore {
+	return &memStore{name: name, images: make(map[uid.ID][]byte)}
+}
+
+func (s *memStore) Name() string { return s.name }
+
+func (s *memStore) Get(ctx context.Context, r *ImageRecord) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	image, ok := s.images[r.ID]
+	if !ok {
+		return nil, ErrImageNotFound
+	}
+	if s.corrupt {
+		return append(bytes.Clone(image), 0), nil
+	}
+	return image, nil
+}
+
+func (s *memStore) Save(ctx context.Context, r *ImageRecord, image []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.images[r.ID] = bytes.Clone(image)
+	return nil
+}
+
+func (s *memStore) Delete(ctx context.Context, r *ImageRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.images, r.ID)
+	return nil
+}
+
+func TestReplicatedStore(t *testing.T) {
+	ctx := context.Background()
+	primary, replica := newMemStore("test_primary"), newMemStore("test_replica")
+	for _, s := range []Store{primary, replica} {
+		if err := RegisterStore(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SetReplicas("test_primary", "test_primary"); err == nil {
+		t.Error("expected an error making a store a replica of itself")
+	}
+	if err := SetReplicas("test_primary", "test_unregistered"); !errors.Is(err, ErrStoreNotRegistered) {
+		t.Errorf("expected ErrStoreNotRegistered, got %v", err)
+	}
+	if err := SetReplicas("test_primary", "test_replica"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetReplicas("test_primary")
+
+	r := &ImageRecord{ID: uid.New(), StoreName: "test_primary", Format: ImageFormatJPEG}
+	image := []byte("image")
+	if err := saveToStore(ctx, r.store(), r, bytes.NewReader(image), int64(len(image))); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*memStore{primary, replica} {
+		if !bytes.Equal(s.images[r.ID], image) {
+			t.Errorf("image not saved to store %s", s.name)
+		}
+	}
+
+	delete(primary.images, r.ID)
+	if got, err := r.store().Get(ctx, r); err != nil || !bytes.Equal(got, image) {
+		t.Errorf("expected the image to be read from the replica, got %q, %v", got, err)
+	}
+
+	if err := r.store().Delete(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := replica.images[r.ID]; ok {
+		t.Error("image not deleted from the replica")
+	}
+}
+
+func TestCopyImage(t *testing.T) {
+	ctx := context.Background()
+	src, dst := newMemStore("src"), newMemStore("dst")
+	r := &ImageRecord{ID: uid.New(), Format: ImageFormatPNG}
+	src.images[r.ID] = []byte("image")
+
+	if err := copyImage(ctx, src, dst, r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.images[r.ID], src.images[r.ID]) {
+		t.Error("image not copied")
+	}
+
+	dst.corrupt = true
+	if err := copyImage(ctx, src, dst, r); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected ErrChecksumMismatch, got %v", err)
+	}
+
+	missing := &ImageRecord{ID: uid.New(), Format: ImageFormatPNG}
+	if err := copyImage(ctx, src, dst, missing); !errors.Is(err, ErrImageNotFound) {
+		t.Errorf("expected ErrImageNotFound, got %v", err)
+	}
+}
+
+// unhealthyStore is a memStore that fails health checks.
+type unhealthyStore struct {
+	*memStore
+}
+
+func (s unhealthyStore) CheckHealth(ctx context.Context) error {
+	return errors.New("unhealthy")
+}
+
+func TestFallbackStore(t *testing.T) {
+	ctx := context.Background()
+	primary, fallback := newMemStore("test_fb_primary"), unhealthyStore{newMemStore("test_fb_fallback")}
+	for _, s := range []Store{primary, fallback} {
+		if err := RegisterStore(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SetFallbacks("test_fb_primary", "test_fb_primary"); err == nil {
+		t.Error("expected an error making a store a fallback of itself")
+	}
+	if err := CheckStoreHealth(ctx, "test_fb_primary"); err != nil {
+		t.Errorf("got error %v checking a store without fallbacks", err)
+	}
+	if err := SetFallbacks("test_fb_primary", "test_fb_fallback"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetFallbacks("test_fb_primary")
+
+	// Images not yet moved are read from the fallback.
+	r := &ImageRecord{ID: uid.New(), StoreName: "test_fb_primary", Format: ImageFormatJPEG}
+	fallback.images[r.ID] = []byte("old")
+	if got, err := r.store().Get(ctx, r); err != nil || string(got) != "old" {
+		t.Errorf("expected the image to be read from the fallback, got %q, %v", got, err)
+	}
+
+	// Fallbacks are not written to, but are deleted from.
+	saved := &ImageRecord{ID: uid.New(), StoreName: "test_fb_primary", Format: ImageFormatJPEG}
+	if err := r.store().Save(ctx, saved, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fallback.images[saved.ID]; ok {
+		t.Error("image saved to the fallback")
+	}
+	if err := r.store().Delete(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fallback.images[r.ID]; ok {
+		t.Error("image not deleted from the fallback")
+	}
+
+	if err := CheckStoreHealth(ctx, "test_fb_primary"); err == nil {
+		t.Error("no error checking a store with an unhealthy fallback")
+	}
+}
@@ -4,28 +4,74 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"path"
+	"io"
+	"log"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/discuitnet/discuit/internal/tracing"
 	"github.com/discuitnet/discuit/internal/uid"
 )
 
-// s3Store implements the store interface for AWS S3.
+// s3Store implements the Store interface for AWS S3.
 type s3Store struct {
-	client *s3.Client
-	bucket string
-	prefix string
+	client  *s3.Client
+	bucket  string
+	prefix  string
+	timeout time.Duration
+
+	redirectMode s3RedirectMode
+	presigner    *s3.PresignClient
+	presignTTL   time.Duration
+	cdnBaseURL   string
+}
+
+// s3RedirectMode is how requests for images in an s3Store are redirected
+// (see s3Store.RedirectURL).
+type s3RedirectMode string
+
+const (
+	s3RedirectNone    = s3RedirectMode("")        // Images are served by the images server.
+	s3RedirectPresign = s3RedirectMode("presign") // To presigned S3 URLs.
+	s3RedirectCDN     = s3RedirectMode("cdn")     // To a CDN in front of the bucket.
+)
+
+// s3Options are the request options of an s3Store. Zero values mean the
+// defaults of the AWS SDK (and no timeout, for RequestTimeout).
+type s3Options struct {
+	MaxAttempts    int           // Of each request, including the first.
+	MaxBackoff     time.Duration // Max delay between attempts.
+	RequestTimeout time.Duration // Of each operation, including retries.
+
+	RedirectMode s3RedirectMode
+	PresignTTL   time.Duration // Of presigned URLs. If zero, it's 15 minutes.
+	CDNBaseURL   string
 }
 
 // newS3Store creates a new S3 store instance.
-func newS3Store(region, bucket, accessKey, secretKey, endpoint, prefix string) (*s3Store, error) {
+func newS3Store(region, bucket, accessKey, secretKey, endpoint, prefix string, opts s3Options) (*s3Store, error) {
 	// Create AWS config
 	cfg, err := config.LoadDefaultConfig(context.Background(),
 		config.WithRegion(region),
 		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKey, secretKey, "")),
+		config.WithRetryer(func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				if opts.MaxAttempts > 0 {
+					o.MaxAttempts = opts.MaxAttempts
+				}
+				if opts.MaxBackoff > 0 {
+					o.MaxBackoff = opts.MaxBackoff
+				}
+			})
+		}),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
@@ -36,71 +82,201 @@ func newS3Store(region, bucket, accessKey, secretKey, endpoint, prefix string) (
 		if endpoint != "" {
 			o.BaseEndpoint = aws.String(endpoint)
 		}
+		o.APIOptions = append(o.APIOptions, traceS3Operation(bucket))
 	})
 
-	return &s3Store{
-		client: client,
-		bucket: bucket,
-		prefix: prefix,
-	}, nil
+	store := &s3Store{
+		client:       client,
+		bucket:       bucket,
+		prefix:       prefix,
+		timeout:      opts.RequestTimeout,
+		redirectMode: opts.RedirectMode,
+		presignTTL:   opts.PresignTTL,
+		cdnBaseURL:   strings.TrimSuffix(opts.CDNBaseURL, "/"),
+	}
+	if store.presignTTL == 0 {
+		store.presignTTL = time.Minute * 15
+	}
+	if store.redirectMode == s3RedirectPresign {
+		store.presigner = s3.NewPresignClient(client)
+	}
+	return store, nil
+}
+
+// traceS3Operation returns an API option of S3 clients that traces their
+// operations (see package tracing), retries included, on bucket.
+func traceS3Operation(bucket string) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		if len(stack.Deserialize.List()) == 0 {
+			return nil // Presigning, which sends no request.
+		}
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("Tracing", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (out middleware.InitializeOutput, md middleware.Metadata, err error) {
+			op := awsmiddleware.GetOperationName(ctx)
+			ctx, span := tracing.Start(ctx, tracing.KindClient, "S3 "+op,
+				tracing.String("rpc.system", "aws-api"),
+				tracing.String("rpc.service", "S3"),
+				tracing.String("rpc.method", op),
+				tracing.String("aws.s3.bucket", bucket))
+			defer func() { span.Finish(err) }()
+			return next.HandleInitialize(ctx, in)
+		}), middleware.After)
+	}
 }
 
-func (s *s3Store) name() string {
+func (s *s3Store) Name() string {
 	return "s3"
 }
 
-// get retrieves an image from S3.
-func (s *s3Store) get(r *ImageRecord) ([]byte, error) {
-	key := s.objectKey(r.ID, r.Format)
-	
-	result, err := s.client.GetObject(context.Background(), &s3.GetObjectInput{
+// withTimeout returns ctx limited to the request timeout of s.
+func (s *s3Store) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.timeout)
+}
+
+// Get retrieves an image from S3.
+func (s *s3Store) Get(ctx context.Context, r *ImageRecord) ([]byte, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
-		Key:    aws.String(key),
+		Key:    aws.String(s.objectKey(r.ID, r.Format)),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get object from S3: %w", err)
 	}
 	defer result.Body.Close()
 
-	// Read the entire object into memory
-	data := make([]byte, 0)
-	buf := make([]byte, 1024)
-	for {
-		n, err := result.Body.Read(buf)
-		if n > 0 {
-			data = append(data, buf[:n]...)
-		}
-		if err != nil {
-			break
-		}
+	data, err := io.ReadAll(result.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object from S3: %w", err)
 	}
-
 	return data, nil
 }
 
-// save stores an image in S3.
-func (s *s3Store) save(r *ImageRecord, image []byte) error {
+// Save stores an image in S3.
+func (s *s3Store) Save(ctx context.Context, r *ImageRecord, image []byte) error {
+	return s.SaveStream(ctx, r, bytes.NewReader(image), int64(len(image)))
+}
+
+// s3PartSize is the size of the parts of multipart uploads. Images no larger
+// than this are uploaded in a single request.
+const s3PartSize = 8 << 20 // Minimum is 5MB.
+
+// SaveStream implements StreamSaver. Images larger than s3PartSize are uploaded
+// with a multipart upload, so that at most one part of the image is held in
+// memory at a time.
+func (s *s3Store) SaveStream(ctx context.Context, r *ImageRecord, src io.Reader, size int64) error {
 	key := s.objectKey(r.ID, r.Format)
+	if size > s3PartSize {
+		return s.multipartUpload(ctx, key, src)
+	}
 
-	_, err := s.client.PutObject(context.Background(), &s3.PutObjectInput{
+	// The body must be seekable for failed requests to be retried.
+	body, ok := src.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(src)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		Body:          body,
+		ContentLength: aws.Int64(size),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put object to S3: %w", err)
+	}
+	return nil
+}
+
+// multipartUpload uploads the object in src to key in parts of s3PartSize.
+// The request timeout of s applies to each request of the upload separately.
+// The upload is aborted on failure.
+func (s *s3Store) multipartUpload(ctx context.Context, key string, src io.Reader) (err error) {
+	reqCtx, cancel := s.withTimeout(ctx)
+	created, err := s.client.CreateMultipartUpload(reqCtx, &s3.CreateMultipartUploadInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(key),
-		Body:   bytes.NewReader(image),
 	})
+	cancel()
 	if err != nil {
-		return fmt.Errorf("failed to put object to S3: %w", err)
+		return fmt.Errorf("failed to create S3 multipart upload: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			// The upload is aborted even if ctx is canceled, so that its
+			// parts aren't left taking up space in the bucket.
+			abortCtx, cancel := s.withTimeout(context.WithoutCancel(ctx))
+			defer cancel()
+			if _, abortErr := s.client.AbortMultipartUpload(abortCtx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(s.bucket),
+				Key:      aws.String(key),
+				UploadId: created.UploadId,
+			}); abortErr != nil {
+				log.Printf("Failed to abort S3 multipart upload of %s: %v\n", key, abortErr)
+			}
+		}
+	}()
+
+	var parts []types.CompletedPart
+	buf := make([]byte, s3PartSize)
+	for partNumber := int32(1); ; partNumber++ {
+		n, readErr := io.ReadFull(src, buf)
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil && readErr != io.ErrUnexpectedEOF {
+			return readErr
+		}
+		reqCtx, cancel := s.withTimeout(ctx)
+		part, err := s.client.UploadPart(reqCtx, &s3.UploadPartInput{
+			Bucket:        aws.String(s.bucket),
+			Key:           aws.String(key),
+			UploadId:      created.UploadId,
+			PartNumber:    aws.Int32(partNumber),
+			Body:          bytes.NewReader(buf[:n]),
+			ContentLength: aws.Int64(int64(n)),
+		})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to upload part %d to S3: %w", partNumber, err)
+		}
+		parts = append(parts, types.CompletedPart{ETag: part.ETag, PartNumber: aws.Int32(partNumber)})
+		if readErr == io.ErrUnexpectedEOF { // last part
+			break
+		}
 	}
 
+	reqCtx, cancel = s.withTimeout(ctx)
+	defer cancel()
+	_, err = s.client.CompleteMultipartUpload(reqCtx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete S3 multipart upload: %w", err)
+	}
 	return nil
 }
 
-// delete removes an image from S3.
-func (s *s3Store) delete(r *ImageRecord) error {
-	key := s.objectKey(r.ID, r.Format)
+// Delete removes an image from S3.
+func (s *s3Store) Delete(ctx context.Context, r *ImageRecord) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 
-	_, err := s.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(s.bucket),
-		Key:    aws.String(key),
+		Key:    aws.String(s.objectKey(r.ID, r.Format)),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to delete object from S3: %w", err)
@@ -109,12 +285,29 @@ func (s *s3Store) delete(r *ImageRecord) error {
 	return nil
 }
 
+// RedirectURL implements Redirector. The URL is empty if redirects are not
+// enabled for s.
+func (s *s3Store) RedirectURL(ctx context.Context, r *ImageRecord) (string, time.Time, error) {
+	key := s.objectKey(r.ID, r.Format)
+	switch s.redirectMode {
+	case s3RedirectPresign:
+		ctx, cancel := s.withTimeout(ctx)
+		defer cancel()
+		req, err := s.presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+		}, s3.WithPresignExpires(s.presignTTL))
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("failed to presign S3 URL: %w", err)
+		}
+		return req.URL, time.Now().Add(s.presignTTL), nil
+	case s3RedirectCDN:
+		return s.cdnBaseURL + "/" + key, time.Time{}, nil
+	}
+	return "", time.Time{}, nil
+}
+
 // objectKey generates the S3 object key for an image.
 func (s *s3Store) objectKey(id uid.ID, format ImageFormat) string {
-	folder, filename := idToFolder(id)
-	key := path.Join(folder, filename+format.Extension())
-	if s.prefix != "" {
-		key = path.Join(s.prefix, key)
-	}
-	return key
-} 
\ No newline at end of file
+	return storeObjectKey(s.prefix, id, format)
+}
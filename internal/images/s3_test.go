@@ -0,0 +1,60 @@
+package images
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestS3RedirectURL(t *testing.T) {
+	record := &ImageRecord{ID: uid.New(), Format: ImageFormatJPEG}
+
+	cdn, err := newS3Store("us-east-1", "bucket", "key", "secret", "", "images", s3Options{
+		RedirectMode: s3RedirectCDN,
+		CDNBaseURL:   "https://cdn.example.com/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, expires, err := cdn.RedirectURL(context.Background(), record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://cdn.example.com/" + cdn.objectKey(record.ID, record.Format); u != want || !expires.IsZero() {
+		t.Fatalf("expected %s (never expiring), got %s (expiring %v)", want, u, expires)
+	}
+
+	presign, err := newS3Store("us-east-1", "bucket", "key", "secret", "", "", s3Options{
+		RedirectMode: s3RedirectPresign,
+		PresignTTL:   time.Minute * 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, expires, err = presign.RedirectURL(context.Background(), record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(parsed.Path, presign.objectKey(record.ID, record.Format)) || parsed.Query().Get("X-Amz-Expires") != "600" {
+		t.Fatalf("unexpected presigned URL: %s", u)
+	}
+	if d := time.Until(expires); d <= time.Minute*9 || d > time.Minute*10 {
+		t.Fatalf("unexpected expiry of presigned URL: %v", expires)
+	}
+
+	none, err := newS3Store("us-east-1", "bucket", "key", "secret", "", "", s3Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, _, err := none.RedirectURL(context.Background(), record); err != nil || u != "" {
+		t.Fatalf("expected no redirect, got %q (error: %v)", u, err)
+	}
+}
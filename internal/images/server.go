@@ -1,10 +1,15 @@
 package images
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // Server implements the http.Handler interface.
@@ -19,9 +24,40 @@ type Server struct {
 	// so that images can be downloaded dynamically using Javascript APIs in the
 	// web environment.
 	EnableCORS bool
+
+	// If enabled, requests for original images are redirected to the stores
+	// that implement Redirector (say, to S3), to save on bandwidth.
+	Redirect bool
+
+	// If set, requests are logged to AccessLog.
+	AccessLog AccessLogger
+
+	// If set, requests from other sites are denied as per Referrers.
+	Referrers *ReferrerPolicy
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	accounting := bandwidthAccounting()
+	if s.AccessLog == nil && !accounting {
+		s.serve(w, r, &Access{})
+		return
+	}
+	aw := &accessWriter{ResponseWriter: w}
+	a := &Access{Time: time.Now(), Referrer: r.Referer()}
+	_, a.External = referrerHost(r)
+	s.serve(aw, r, a)
+	a.Status, a.Bytes = aw.status, aw.bytes
+	a.DurationMS = float64(time.Since(a.Time).Microseconds()) / 1000
+	if accounting {
+		countBandwidth(a)
+	}
+	if s.AccessLog != nil {
+		s.AccessLog.LogAccess(a)
+	}
+}
+
+// serve serves the request r, and records it in a.
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, a *Access) {
 	if s.EnableCORS {
 		w.Header().Add("Access-Control-Allow-Origin", "*")
 		if r.Method == "OPTIONS" {
@@ -35,28 +71,91 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	imgReq, err := fromURL(r.URL)
 	if err != nil {
-		s.writeError(w, http.StatusBadRequest, "")
+		if err == ErrURLExpired {
+			s.writeError(w, http.StatusForbidden, "URL expired")
+		} else if err == ErrVariantNotAllowed {
+			s.writeError(w, http.StatusNotFound, "Image size not allowed")
+		} else {
+			s.writeError(w, http.StatusBadRequest, "")
+		}
+		return
+	}
+	a.ImageID, a.Format = imgReq.id, imgReq.format
+	if !imgReq.size.Zero() {
+		a.Variant = imgReq.size.String() + ":" + string(imgReq.fit)
+	}
+
+	if s.Referrers != nil && !s.Referrers.allows(r) {
+		a.Hotlink = true
+		s.Referrers.writePlaceholder(w)
 		return
 	}
 
-	if !s.SkipHashCheck {
+	if !s.SkipHashCheck && !imgReq.unsigned() {
 		if !imgReq.valid() {
 			s.writeError(w, http.StatusBadRequest, "Bad signature")
 			return
 		}
 	}
 
+	maxAge := 31536000 // a year
+	if imgReq.expires != 0 {
+		// So that caches don't serve the image past the URL's expiry.
+		maxAge = max(int(time.Until(time.Unix(imgReq.expires, 0)).Seconds()), 0)
+	}
+
+	if s.Redirect {
+		location, expires, err := redirectURL(r.Context(), s.DB, imgReq)
+		if err != nil {
+			s.writeImageError(w, err)
+			return
+		}
+		if location != "" {
+			if !expires.IsZero() {
+				// Leave some time for the client to follow the redirect.
+				maxAge = min(maxAge, max(int(time.Until(expires).Seconds())/2, 0))
+			}
+			w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(maxAge))
+			a.Cache = CacheRedirect
+			http.Redirect(w, r, location, http.StatusFound)
+			return
+		}
+	}
+
 	image, err := getImage(r.Context(), s.DB, imgReq, !s.CacheDisabled)
 	if err != nil {
-		if err == ErrImageNotFound {
-			s.writeError(w, http.StatusNotFound, "Image not found")
-		} else {
-			s.writeInternalServerError(w, err)
-		}
+		s.writeImageError(w, err)
 		return
 	}
-	w.Header().Add("Cache-Control", "public, max-age=31536000, immutable")
-	w.Write(image)
+	a.Cache = CacheMiss
+	if imgReq.cacheHit {
+		a.Cache = CacheHit
+	}
+	w.Header().Set("Content-Type", imgReq.format.MimeType())
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(maxAge)+", immutable")
+	w.Header().Set("ETag", contentETag(image))
+
+	// ServeContent handles conditional (If-None-Match, If-Modified-Since) and
+	// Range requests. Images never change, so they were last modified when
+	// they were created.
+	http.ServeContent(w, r, "", imgReq.id.Time(), bytes.NewReader(image))
+}
+
+// contentETag returns the (strong) ETag of an image with content data.
+func contentETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// writeImageError writes the error err, of getting an image, to w.
+func (s *Server) writeImageError(w http.ResponseWriter, err error) {
+	if err == ErrImageNotFound {
+		s.writeError(w, http.StatusNotFound, "Image not found")
+	} else if err == ErrImageFormatUnsupported {
+		s.writeError(w, http.StatusBadRequest, "Image format not supported")
+	} else {
+		s.writeInternalServerError(w, err)
+	}
 }
 
 func (s *Server) writeError(w http.ResponseWriter, statusCode int, message string) {
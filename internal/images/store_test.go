@@ -0,0 +1,47 @@
+package images
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type testStore struct {
+	name        string
+	initErr     error
+	initialized bool
+}
+
+func (s *testStore) Get(context.Context, *ImageRecord) ([]byte, error) { return nil, ErrImageNotFound }
+func (s *testStore) Save(context.Context, *ImageRecord, []byte) error  { return nil }
+func (s *testStore) Delete(context.Context, *ImageRecord) error        { return nil }
+func (s *testStore) Name() string                                      { return s.name }
+func (s *testStore) Init(ctx context.Context) error                    { s.initialized = true; return s.initErr }
+
+func TestRegisterStore(t *testing.T) {
+	s := &testStore{name: "test_register"}
+	if err := RegisterStore(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if !s.initialized {
+		t.Error("expected Init to be called")
+	}
+	if LookupStore("test_register") != s {
+		t.Error("registered store not found")
+	}
+	if err := RegisterStore(context.Background(), &testStore{name: "test_register"}); err == nil {
+		t.Error("expected an error registering a duplicate store name")
+	}
+
+	failing := &testStore{name: "test_failing", initErr: errors.New("init failed")}
+	if err := RegisterStore(context.Background(), failing); err == nil {
+		t.Error("expected an error when Init fails")
+	}
+	if LookupStore("test_failing") != nil {
+		t.Error("store that failed to initialize was registered")
+	}
+
+	if err := SetDefaultStoreName("test_unregistered"); !errors.Is(err, ErrStoreNotRegistered) {
+		t.Errorf("expected ErrStoreNotRegistered, got %v", err)
+	}
+}
@@ -0,0 +1,301 @@
+package images
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/metrics"
+	"github.com/discuitnet/discuit/internal/tracing"
+	"golang.org/x/image/draw"
+)
+
+var transformDurations = metrics.NewHistogram("discuit_image_transform_duration_seconds",
+	"Durations of the transforms (decoding, resizing, and encoding) of images, by the format encoded in.",
+	nil, "format")
+
+// jpegQuality is the quality used when encoding JPEG images.
+const jpegQuality = 85
+
+// resizeImage returns img fitted into size as per fit. If size is zero, img is
+// returned as is.
+//
+// With ImageFitContain the image is only ever shrunk, keeping its aspect ratio,
+// so that it fits inside size. With ImageFitCover the image is scaled (up or
+// down) so that it covers size entirely, and the overflowing parts are cropped
+// off so that what's kept is centered on focus, as much as possible, or, if
+// focus is nil, equally from both sides.
+func resizeImage(img image.Image, size ImageSize, fit ImageFit, focus *FocalPoint) image.Image {
+	if size.Zero() {
+		return img
+	}
+	if fit == "" {
+		fit = ImageFitDefault
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return img
+	}
+
+	switch fit {
+	case ImageFitCover:
+		// The source rectangle that, when scaled, covers the box exactly.
+		src := bounds
+		center := FocalPoint{X: 0.5, Y: 0.5}
+		if focus != nil {
+			center = *focus
+		}
+		if width*size.Height > height*size.Width {
+			// Image is wider than the box.
+			cropWidth := height * size.Width / size.Height
+			src.Min.X += coverOffset(width, cropWidth, center.X)
+			src.Max.X = src.Min.X + cropWidth
+		} else {
+			cropHeight := width * size.Height / size.Width
+			src.Min.Y += coverOffset(height, cropHeight, center.Y)
+			src.Max.Y = src.Min.Y + cropHeight
+		}
+		return scaleImage(img, src, size.Width, size.Height)
+	default: // ImageFitContain
+		w, h := ImageContainSize(width, height, size.Width, size.Height)
+		if w == width && h == height {
+			return img
+		}
+		return scaleImage(img, bounds, max(w, 1), max(h, 1))
+	}
+}
+
+// scaleImage scales the src rectangle of img to an image of width by height.
+func scaleImage(img image.Image, src image.Rectangle, width, height int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	return dst
+}
+
+// flattenImage draws img onto an opaque background of color bg. Use it before
+// encoding an image with transparency into a format that doesn't support it.
+func flattenImage(img image.Image, bg color.Color) image.Image {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Over)
+	return dst
+}
+
+// encodeImage encodes img into format. WEBP encoding is not supported, nor is
+// AVIF encoding without an AVIF codec (see avifCodec), as there are no pure Go
+// encoders for them, in which case ErrImageFormatUnsupported is returned.
+func encodeImage(img image.Image, format ImageFormat) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeImage(&buf, img, format); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeImage is like encodeImage, except that it writes the encoded image to
+// w.
+func writeImage(w io.Writer, img image.Image, format ImageFormat) error {
+	switch format {
+	case ImageFormatJPEG:
+		return jpeg.Encode(w, flattenImage(img, color.White), &jpeg.Options{Quality: jpegQuality})
+	case ImageFormatPNG:
+		return png.Encode(w, img)
+	case ImageFormatGIF:
+		return gif.Encode(w, img, nil)
+	case ImageFormatAVIF:
+		if avifCodec() {
+			return avifEncode(w, img)
+		}
+	}
+	return ErrImageFormatUnsupported
+}
+
+// transformImage decodes the image in data and returns it resized and
+// re-encoded as per r. Only the first frame of animated GIF and WEBP images is
+// kept.
+func transformImage(ctx context.Context, data []byte, r *request) (_ []byte, err error) {
+	defer transformDurations.ObserveSince(time.Now(), string(r.format))
+	_, span := tracing.Start(ctx, tracing.KindInternal, "image transform",
+		tracing.String("image.format", string(r.format)),
+		tracing.String("image.fit", string(r.fit)),
+		tracing.Int("image.width", r.size.Width),
+		tracing.Int("image.height", r.size.Height),
+		tracing.Int("image.input_bytes", len(data)))
+	defer func() { span.Finish(err) }()
+
+	img, _, err := decodeImage(bytes.NewReader(data))
+	if err != nil {
+		if err == errAVIFDecodeUnsupported {
+			return nil, ErrImageFormatUnsupported
+		}
+		return nil, err
+	}
+	return encodeImage(resizeImage(img, r.size, r.fit, r.focus), r.format)
+}
+
+// Transform decodes the image in data and returns it resized to size (as per
+// fit) and encoded in format, as it would be if it were requested so from the
+// image server.
+func Transform(data []byte, size ImageSize, fit ImageFit, format ImageFormat) ([]byte, error) {
+	return transformImage(context.Background(), data, &request{size: size, fit: fit, format: format})
+}
+
+// processedImage is an uploaded image that's been prepared for storage.
+type processedImage struct {
+	format        ImageFormat
+	width, height int
+	size          int64 // In bytes.
+	averageColor  RGB
+	blurhash      string          // Empty if the image could not be decoded.
+	checksum      string          // Of the processed image; see checksum.
+	phash         *PerceptualHash // Nil if the image could not be decoded.
+	focalPoint    *FocalPoint     // Nil if the image could not be decoded, or has no detail.
+
+	// The EXIF orientation of the uploaded image. It has been applied to the
+	// pixels of the processed image.
+	orientation Orientation
+}
+
+// checksum returns the hex-encoded SHA-256 hash of image. Cached variants are
+// keyed by the checksum of their original, so that images of identical content
+// share their variants.
+func checksum(image []byte) string {
+	sum := sha256.Sum256(image)
+	return hex.EncodeToString(sum[:])
+}
+
+// decodeConfigLimit is the maximum number of bytes read from the start of an
+// image to find its dimensions and format.
+const decodeConfigLimit = 4 << 20
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// processUpload prepares the uploaded image in src for storage and writes the
+// result to dst: it rotates the image as per its EXIF orientation, crops,
+// resizes, and re-encodes it as per opts, and strips all metadata from it.
+// GIF, animated WEBP, and (without an AVIF codec) AVIF images are stored as
+// uploaded, minus metadata.
+// If SkipProcessing is true, images are neither resized nor re-encoded,
+// unless they have to be rotated or cropped.
+//
+// Images are streamed from src to dst where possible, rather than read
+// into memory whole; only images that are re-encoded are decoded.
+func processUpload(dst io.Writer, src io.ReadSeeker, opts *ImageOptions) (*processedImage, error) {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	config, name, err := image.DecodeConfig(io.LimitReader(src, decodeConfigLimit))
+	if err != nil {
+		return nil, err
+	}
+	sourceFormat := ImageFormat(name)
+
+	// decode decodes the source image.
+	decode := func() (image.Image, error) {
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		img, _, err := decodeImage(bufio.NewReader(src))
+		return img, err
+	}
+
+	hash := sha256.New()
+	cw := &countingWriter{w: io.MultiWriter(dst, hash)}
+	p := &processedImage{orientation: exifOrientation(src, sourceFormat)}
+	asUploaded := sourceFormat.storedAsUploaded() || (sourceFormat == ImageFormatWEBP && webpAnimated(src))
+	if asUploaded && opts.Crop != nil {
+		return nil, fmt.Errorf("%w: %s images cannot be cropped", ErrInvalidCrop, sourceFormat)
+	}
+	if asUploaded || (SkipProcessing && p.orientation == OrientationNormal && opts.Crop == nil) {
+		if err := stripMetadata(cw, src, sourceFormat); err != nil {
+			return nil, err
+		}
+		p.format, p.width, p.height, p.size = sourceFormat, config.Width, config.Height, cw.n
+		if asUploaded {
+			// GIF, AVIF, and animated WEBP images carry no EXIF orientation.
+			p.orientation = OrientationNormal
+		}
+		if sourceFormat != ImageFormatAVIF || avifCodec() {
+			img, err := decode()
+			if err != nil {
+				return nil, err
+			}
+			p.averageColor = AverageColor(img)
+			p.blurhash = Blurhash(img)
+			p.phash = newPHash(img)
+			p.focalPoint = entropyFocalPoint(img)
+		}
+		p.checksum = hex.EncodeToString(hash.Sum(nil))
+		return p, nil
+	}
+
+	img, err := decode()
+	if err != nil {
+		return nil, err
+	}
+	img = applyOrientation(img, p.orientation)
+	if opts.Crop != nil {
+		bounds := img.Bounds()
+		if err := opts.Crop.validate(bounds.Dx(), bounds.Dy()); err != nil {
+			return nil, err
+		}
+		img = cropImage(img, *opts.Crop)
+	}
+	p.format = opts.Format
+	if p.format == "" {
+		p.format = ImageFormatJPEG
+	}
+	if SkipProcessing {
+		// Only rotate the image, keeping its format if possible.
+		if sourceFormat == ImageFormatJPEG || sourceFormat == ImageFormatPNG {
+			p.format = sourceFormat
+		}
+	} else {
+		focus := entropyFocalPoint(img)
+		resized := resizeImage(img, ImageSize{Width: opts.Width, Height: opts.Height}, opts.Fit, focus)
+		if resized.Bounds().Dx()*img.Bounds().Dy() != resized.Bounds().Dy()*img.Bounds().Dx() {
+			focus = entropyFocalPoint(resized) // Cropped as well.
+		}
+		img, p.focalPoint = resized, focus
+	}
+	bw := bufio.NewWriter(cw)
+	if err := writeImage(bw, img, p.format); err != nil {
+		return nil, err
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	p.width, p.height, p.size = bounds.Dx(), bounds.Dy(), cw.n
+	p.checksum = hex.EncodeToString(hash.Sum(nil))
+	p.averageColor = AverageColor(img)
+	p.blurhash = Blurhash(img)
+	p.phash = newPHash(img)
+	if p.focalPoint == nil {
+		p.focalPoint = entropyFocalPoint(img)
+	}
+	return p, nil
+}
@@ -0,0 +1,53 @@
+package images
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestResizeImage(t *testing.T) {
+	cases := []struct {
+		width, height int
+		size          ImageSize
+		fit           ImageFit
+		expectSize    ImageSize
+	}{
+		{800, 600, ImageSize{400, 400}, ImageFitContain, ImageSize{400, 300}},
+		{600, 800, ImageSize{400, 400}, ImageFitContain, ImageSize{300, 400}},
+		{200, 100, ImageSize{400, 400}, ImageFitContain, ImageSize{200, 100}}, // never enlarged
+		{800, 600, ImageSize{400, 400}, ImageFitCover, ImageSize{400, 400}},
+		{100, 50, ImageSize{300, 200}, ImageFitCover, ImageSize{300, 200}}, // enlarged
+		{800, 600, ImageSize{}, ImageFitCover, ImageSize{800, 600}},
+	}
+	for _, item := range cases {
+		img := image.NewRGBA(image.Rect(0, 0, item.width, item.height))
+		got := resizeImage(img, item.size, item.fit, nil).Bounds()
+		if got.Dx() != item.expectSize.Width || got.Dy() != item.expectSize.Height {
+			t.Errorf("resizing %dx%d to %v (%s): expected %v, got %dx%d", item.width, item.height, item.size, item.fit, item.expectSize, got.Dx(), got.Dy())
+		}
+	}
+}
+
+func TestEncodeImage(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	for _, format := range []ImageFormat{ImageFormatJPEG, ImageFormatPNG, ImageFormatGIF} {
+		data, err := encodeImage(img, format)
+		if err != nil {
+			t.Errorf("encoding %s: %v", format, err)
+			continue
+		}
+		_, gotFormat, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("decoding encoded %s: %v", format, err)
+		} else if gotFormat != string(format) {
+			t.Errorf("expected format %s, got %s", format, gotFormat)
+		}
+	}
+	if _, err := encodeImage(img, ImageFormatWEBP); err != ErrImageFormatUnsupported {
+		t.Errorf("expected ErrImageFormatUnsupported for webp, got %v", err)
+	}
+	if _, err := encodeImage(img, ImageFormatAVIF); !avifCodec() && err != ErrImageFormatUnsupported {
+		t.Errorf("expected ErrImageFormatUnsupported for avif, got %v", err)
+	}
+}
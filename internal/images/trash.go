@@ -0,0 +1,110 @@
+package images
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// If TrashRetention is non-zero, DeleteImagesTx moves images to the trash
+// instead of deleting them: their records are marked deleted (deleted_at is
+// set), and their files are retained, so that they can be restored (see
+// RestoreImagesTx) until they're purged (see PurgeTrash). Images in the trash
+// are not served.
+var TrashRetention time.Duration
+
+// TrashEnabled reports whether deleted images are moved to the trash.
+func TrashEnabled() bool {
+	return TrashRetention > 0
+}
+
+// Trashed reports whether the image of r is in the trash.
+func (r *ImageRecord) Trashed() bool {
+	return r.DeletedAt != nil
+}
+
+// trashImagesTx moves images to the trash.
+func trashImagesTx(ctx context.Context, tx *sql.Tx, images ...uid.ID) error {
+	args := []any{time.Now()}
+	for _, id := range images {
+		args = append(args, id)
+	}
+	query := fmt.Sprintf("UPDATE images SET deleted_at = ? WHERE id IN %s AND deleted_at IS NULL", msql.InClauseQuestionMarks(len(images)))
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+
+	// The cached variants of images would otherwise still be served.
+	for _, image := range images {
+		if err := removeFromCache(image); err != nil {
+			log.Printf("error removing images from cache on image id %v", err)
+		}
+	}
+	return nil
+}
+
+// RestoreImagesTx takes images out of the trash. It returns ErrImageNotFound
+// if any of the images no longer exists (if it was purged, say).
+func RestoreImagesTx(ctx context.Context, tx *sql.Tx, images ...uid.ID) error {
+	if len(images) == 0 {
+		return nil
+	}
+	args := make([]any, len(images))
+	for i := range images {
+		args[i] = images[i]
+	}
+	in := msql.InClauseQuestionMarks(len(images))
+
+	var n int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM images WHERE id IN "+in, args...).Scan(&n); err != nil {
+		return err
+	}
+	if n != len(images) {
+		return ErrImageNotFound
+	}
+	_, err := tx.ExecContext(ctx, "UPDATE images SET deleted_at = NULL WHERE id IN "+in, args...)
+	return err
+}
+
+// PurgeTrash permanently deletes the images that were moved to the trash
+// before before, along with their files. Unless dryRun is true, in which case
+// nothing is deleted. It returns the IDs of the images purged (or to be
+// purged).
+func PurgeTrash(ctx context.Context, db *sql.DB, before time.Time, dryRun bool) ([]uid.ID, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id FROM images WHERE deleted_at IS NOT NULL AND deleted_at < ?", before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uid.ID
+	for rows.Next() {
+		var id uid.ID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if dryRun {
+		return ids, nil
+	}
+	for start := 0; start < len(ids); start += gcBatchSize {
+		batch := ids[start:min(start+gcBatchSize, len(ids))]
+		if err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+			return PurgeImagesTx(ctx, tx, db, batch...)
+		}); err != nil {
+			return ids[:start], fmt.Errorf("purging images in the trash: %w", err)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,131 @@
+package images
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// golang.org/x/image/webp cannot decode animated WEBP images. Like GIF
+// images, they're stored as uploaded, so that their animation is kept, and
+// only their first frame is decoded (see decodeImage), for their thumbnails,
+// their average colors, and the like.
+
+// webpAnimationFlag is the bit of the flags of the VP8X chunk of WEBP images
+// that's set for animated images.
+const webpAnimationFlag = 0x02
+
+// maxWEBPFrameSize is the maximum size, in bytes, of the first frame of an
+// animated WEBP image that's decoded.
+const maxWEBPFrameSize = 64 << 20
+
+// webpAnimated reports whether the WEBP image in r is animated.
+func webpAnimated(r io.ReadSeeker) bool {
+	animated := false
+	webpChunks(r, func(typ string, size int64) error {
+		if typ == "VP8X" && size >= 1 {
+			var flags [1]byte
+			if readFull(r, flags[:]) == nil {
+				animated = flags[0]&webpAnimationFlag != 0
+			}
+		}
+		return errStopIteration // VP8X, if present, is the first chunk.
+	})
+	return animated
+}
+
+// decodeImage is like image.Decode, except that animated WEBP images are
+// decoded too, to their first frame.
+func decodeImage(r io.Reader) (image.Image, string, error) {
+	br := bufio.NewReader(r)
+	header, _ := br.Peek(21)
+	if len(header) == 21 && string(header[:4]) == "RIFF" && string(header[8:16]) == "WEBPVP8X" && header[20]&webpAnimationFlag != 0 {
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return nil, "", err
+		}
+		img, err := decodeWEBPFirstFrame(bytes.NewReader(data))
+		return img, string(ImageFormatWEBP), err
+	}
+	return image.Decode(br)
+}
+
+// decodeWEBPFirstFrame decodes the first frame of the animated WEBP image in
+// r, drawn onto a transparent canvas of the size of the animation.
+func decodeWEBPFirstFrame(r io.ReadSeeker) (image.Image, error) {
+	var (
+		canvas image.Rectangle
+		frame  []byte // The ANMF chunk of the first frame.
+	)
+	err := webpChunks(r, func(typ string, size int64) error {
+		switch typ {
+		case "VP8X":
+			var b [10]byte
+			if size != 10 || readFull(r, b[:]) != nil {
+				return errMalformedImage
+			}
+			canvas = image.Rect(0, 0, int(uint24(b[4:]))+1, int(uint24(b[7:]))+1)
+		case "ANMF":
+			if size < 16 || size > maxWEBPFrameSize {
+				return errMalformedImage
+			}
+			frame = make([]byte, size)
+			if err := readFull(r, frame); err != nil {
+				return err
+			}
+			return errStopIteration
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if frame == nil || canvas.Empty() {
+		return nil, errMalformedImage
+	}
+
+	// An ANMF chunk is the position and size of the frame, followed by the
+	// chunks of a still image (an optional ALPH chunk, and a VP8 or a VP8L
+	// chunk), which are put in a WEBP file of their own to be decoded.
+	x, y := 2*int(uint24(frame[0:])), 2*int(uint24(frame[3:]))
+	width, height := uint24(frame[6:])+1, uint24(frame[9:])+1
+	chunks := frame[16:]
+	var still bytes.Buffer
+	still.WriteString("WEBPVP8X")
+	binary.Write(&still, binary.LittleEndian, uint32(10))
+	var flags byte
+	if len(chunks) >= 4 && string(chunks[:4]) == "ALPH" {
+		flags |= 0x10
+	}
+	still.Write([]byte{flags, 0, 0, 0})
+	still.Write(putUint24(width - 1))
+	still.Write(putUint24(height - 1))
+	still.Write(chunks)
+	var file bytes.Buffer
+	file.WriteString("RIFF")
+	binary.Write(&file, binary.LittleEndian, uint32(still.Len()))
+	file.Write(still.Bytes())
+
+	img, err := webp.Decode(&file)
+	if err != nil {
+		return nil, err
+	}
+	dst := image.NewRGBA(canvas)
+	draw.Draw(dst, img.Bounds().Add(image.Pt(x, y)), img, img.Bounds().Min, draw.Src)
+	return dst, nil
+}
+
+// uint24 returns the little-endian 24-bit integer at the start of b.
+func uint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+// putUint24 returns n as a little-endian 24-bit integer.
+func putUint24(n uint32) []byte {
+	return []byte{byte(n), byte(n >> 8), byte(n >> 16)}
+}
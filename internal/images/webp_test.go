@@ -0,0 +1,89 @@
+package images
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// stillWEBP is a 1x1 lossless WEBP image, base64-encoded.
+const stillWEBP = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+
+func decodeBase64(t *testing.T, s string) []byte {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// webpChunk returns a WEBP chunk of type typ and body body.
+func webpChunk(typ string, body []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(typ)
+	binary.Write(&b, binary.LittleEndian, uint32(len(body)))
+	b.Write(body)
+	if len(body)%2 == 1 {
+		b.WriteByte(0)
+	}
+	return b.Bytes()
+}
+
+// animatedWEBP returns a 3x3 animated WEBP image of two 1x1 frames, the first
+// of which is at (2, 0).
+func animatedWEBP(t *testing.T) []byte {
+	vp8l := decodeBase64(t, stillWEBP)[12:] // The VP8L chunk of a 1x1 image.
+
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	body.Write(webpChunk("VP8X", []byte{webpAnimationFlag, 0, 0, 0, 2, 0, 0, 2, 0, 0}))
+	body.Write(webpChunk("ANIM", []byte{0, 0, 0, 0, 0, 0}))
+	for _, x := range []byte{1, 0} {
+		frame := append([]byte{x, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 100, 0, 0, 0}, vp8l...)
+		body.Write(webpChunk("ANMF", frame))
+	}
+	return append(webpChunk("RIFF", body.Bytes())[:8], body.Bytes()...)
+}
+
+func TestDecodeAnimatedWEBP(t *testing.T) {
+	data := animatedWEBP(t)
+	if _, err := webp.Decode(bytes.NewReader(data)); err == nil {
+		t.Fatal("golang.org/x/image/webp decodes animated images; decodeImage is not needed")
+	}
+	if !webpAnimated(bytes.NewReader(data)) {
+		t.Fatal("animated image not detected")
+	}
+	img, format, err := decodeImage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "webp" || img.Bounds() != image.Rect(0, 0, 3, 3) {
+		t.Errorf("decoded a %v %s image, want a 3x3 webp image", img.Bounds(), format)
+	}
+
+	if webpAnimated(bytes.NewReader(decodeBase64(t, stillWEBP))) {
+		t.Error("still image detected as animated")
+	}
+}
+
+func TestProcessAnimatedWEBPUpload(t *testing.T) {
+	data := animatedWEBP(t)
+	var buf bytes.Buffer
+	p, err := processUpload(&buf, bytes.NewReader(data), &ImageOptions{Width: 10, Height: 10, Format: ImageFormatJPEG})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.format != ImageFormatWEBP || p.width != 3 || p.height != 3 {
+		t.Errorf("processed into a %dx%d %s image, want the 3x3 webp image as uploaded", p.width, p.height, p.format)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error("animation not stored as uploaded") // Its VP8X chunk was once mangled.
+	}
+	if p.blurhash == "" {
+		t.Error("no blurhash of the first frame")
+	}
+}
@@ -0,0 +1,182 @@
+package images
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Since every resized copy of an image that's requested is cached, allowing
+// arbitrary sizes lets anyone fill the cache with thousands of copies of an
+// image. Once AllowVariants is called, only the sizes and fits of the allowed
+// variants can be requested (original images can always be).
+
+// DefaultAllowedVariants are the copies of images that the API hands out (see
+// Image.AppendCopy); keep in sync. Variants without a format can be requested
+// in any format.
+var DefaultAllowedVariants = []Variant{
+	// Community profile pictures.
+	{Size: ImageSize{Width: 50, Height: 50}, Fit: ImageFitCover},
+	{Size: ImageSize{Width: 120, Height: 120}, Fit: ImageFitCover},
+	{Size: ImageSize{Width: 200, Height: 200}, Fit: ImageFitCover},
+
+	// Community banners.
+	{Size: ImageSize{Width: 720, Height: 240}, Fit: ImageFitCover},
+	{Size: ImageSize{Width: 1440, Height: 480}, Fit: ImageFitCover},
+
+	// Post images.
+	{Size: ImageSize{Width: 325, Height: 250}, Fit: ImageFitCover},
+	{Size: ImageSize{Width: 720, Height: 1440}, Fit: ImageFitContain},
+	{Size: ImageSize{Width: 1080, Height: 2160}, Fit: ImageFitContain},
+	{Size: ImageSize{Width: 2160, Height: 4320}, Fit: ImageFitContain},
+}
+
+// ErrVariantNotAllowed is returned when a variant of an image that's not
+// allowed (see AllowVariants) is requested.
+var ErrVariantNotAllowed = errors.New("image variant not allowed")
+
+var (
+	allowedVariantsMu sync.RWMutex
+	allowedVariants   []Variant // If nil, all variants are allowed.
+)
+
+// AllowVariants adds vs to the variants of images that can be requested. Until
+// it's first called, all variants can be requested.
+func AllowVariants(vs ...Variant) {
+	allowedVariantsMu.Lock()
+	defer allowedVariantsMu.Unlock()
+	if allowedVariants == nil {
+		allowedVariants = []Variant{}
+	}
+	for _, v := range vs {
+		if !v.allowedBy(allowedVariants) {
+			allowedVariants = append(allowedVariants, v)
+		}
+	}
+}
+
+// AllowedVariants returns the variants of images that can be requested, or nil
+// if all variants can be.
+func AllowedVariants() []Variant {
+	allowedVariantsMu.RLock()
+	defer allowedVariantsMu.RUnlock()
+	if allowedVariants == nil {
+		return nil
+	}
+	return append([]Variant{}, allowedVariants...)
+}
+
+// allowedBy reports whether v is in vs. Variants in vs without a format match
+// v whatever its format.
+func (v Variant) allowedBy(vs []Variant) bool {
+	for _, a := range vs {
+		if a.Size == v.Size && a.Fit == v.Fit && (a.Format == "" || a.Format == v.Format) {
+			return true
+		}
+	}
+	return false
+}
+
+// variantAllowed reports whether the copy of an image of size, fit, and format
+// can be requested.
+func variantAllowed(size ImageSize, fit ImageFit, format ImageFormat) bool {
+	if size.Zero() {
+		return true
+	}
+	allowedVariantsMu.RLock()
+	defer allowedVariantsMu.RUnlock()
+	if allowedVariants == nil {
+		return true
+	}
+	return Variant{Size: size, Fit: fit, Format: format}.allowedBy(allowedVariants)
+}
+
+// Unsigned presets are variants that can be requested without a signature (or
+// an expiry time), by URLs of the form "{ID}.{FORMAT}?size=300x400&fit=cover"
+// (see UnsignedURL), so that they can be linked to from static pages and
+// requested by CDNs ahead of time. Since they're a fixed set, they don't let
+// anyone fill the cache any more than the URLs handed out do. All other
+// variants still have to be signed.
+
+var (
+	unsignedPresetsMu sync.RWMutex
+	unsignedPresets   []Variant
+)
+
+// AllowUnsignedPresets adds vs to the variants that can be requested without
+// a signature, and to those that can be requested at all (see AllowVariants).
+func AllowUnsignedPresets(vs ...Variant) {
+	AllowVariants(vs...)
+	unsignedPresetsMu.Lock()
+	defer unsignedPresetsMu.Unlock()
+	for _, v := range vs {
+		if !v.allowedBy(unsignedPresets) {
+			unsignedPresets = append(unsignedPresets, v)
+		}
+	}
+}
+
+// unsignedPreset reports whether the copy of an image of size, fit, and
+// format is an unsigned preset.
+func unsignedPreset(size ImageSize, fit ImageFit, format ImageFormat) bool {
+	if size.Zero() {
+		return false
+	}
+	unsignedPresetsMu.RLock()
+	defer unsignedPresetsMu.RUnlock()
+	return Variant{Size: size, Fit: fit, Format: format}.allowedBy(unsignedPresets)
+}
+
+// unsigned reports whether r is an unsigned request for an unsigned preset.
+func (r *request) unsigned() bool {
+	return len(r.hash) == 0 && r.expires == 0 && unsignedPreset(r.size, r.fit, r.format)
+}
+
+// UnsignedURL returns the URL, without a signature, of the copy v of the image
+// with id (and format). Variants without a format are in format. It returns
+// false if v is not an unsigned preset.
+func UnsignedURL(id uid.ID, format ImageFormat, v Variant) (string, bool) {
+	if v.Format == "" {
+		v.Format = format
+	}
+	if !unsignedPreset(v.Size, v.Fit, v.Format) {
+		return "", false
+	}
+	q := url.Values{}
+	q.Set("size", v.Size.String())
+	q.Set("fit", string(v.Fit))
+	u := id.String() + v.Format.Extension() + "?" + q.Encode()
+	if FullImageURL != nil {
+		u = FullImageURL(u)
+	}
+	return u, true
+}
+
+// VariantURLs returns the canonical signed URLs of the allowed variants of the
+// image with id (and format), keyed by the variants (see Variant.String).
+// Variants without a format are in format. If all variants are allowed, it
+// returns the URLs of DefaultAllowedVariants.
+func VariantURLs(id uid.ID, format ImageFormat) map[string]string {
+	variants := AllowedVariants()
+	if variants == nil {
+		variants = DefaultAllowedVariants
+	}
+	urls := make(map[string]string, len(variants))
+	for _, v := range variants {
+		c := &ImageCopy{
+			ImageID:   id,
+			BoxWidth:  v.Size.Width,
+			BoxHeight: v.Size.Height,
+			Fit:       v.Fit,
+			Format:    v.Format,
+		}
+		if c.Format == "" {
+			c.Format = format
+		}
+		c.SetURL()
+		urls[v.String()] = c.URL
+	}
+	return urls
+}
@@ -0,0 +1,120 @@
+package images
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestAllowVariants(t *testing.T) {
+	defer func(saved []Variant) { allowedVariants = saved }(allowedVariants)
+	allowedVariants = nil
+
+	if !variantAllowed(ImageSize{Width: 333, Height: 333}, ImageFitCover, ImageFormatJPEG) {
+		t.Error("all variants should be allowed before AllowVariants is called")
+	}
+
+	AllowVariants(
+		Variant{Size: ImageSize{Width: 120, Height: 120}, Fit: ImageFitCover},
+		Variant{Size: ImageSize{Width: 720, Height: 1440}, Fit: ImageFitContain, Format: ImageFormatWEBP},
+	)
+	tests := []struct {
+		size   ImageSize
+		fit    ImageFit
+		format ImageFormat
+		want   bool
+	}{
+		{ImageSize{}, "", ImageFormatJPEG, true}, // The original image.
+		{ImageSize{Width: 120, Height: 120}, ImageFitCover, ImageFormatJPEG, true},
+		{ImageSize{Width: 120, Height: 120}, ImageFitCover, ImageFormatWEBP, true},
+		{ImageSize{Width: 120, Height: 120}, ImageFitContain, ImageFormatJPEG, false},
+		{ImageSize{Width: 121, Height: 120}, ImageFitCover, ImageFormatJPEG, false},
+		{ImageSize{Width: 720, Height: 1440}, ImageFitContain, ImageFormatWEBP, true},
+		{ImageSize{Width: 720, Height: 1440}, ImageFitContain, ImageFormatJPEG, false},
+	}
+	for _, test := range tests {
+		if got := variantAllowed(test.size, test.fit, test.format); got != test.want {
+			t.Errorf("variantAllowed(%v, %v, %v) = %v, want %v", test.size, test.fit, test.format, got, test.want)
+		}
+	}
+
+	u, _ := url.Parse("/images/000000000000000000000000.jpeg?size=300x300&fit=contain&sig=aGFoYQ")
+	if _, err := fromURL(u); err != ErrVariantNotAllowed {
+		t.Errorf("fromURL: expected ErrVariantNotAllowed, got %v", err)
+	}
+}
+
+func TestVariantURLs(t *testing.T) {
+	defer func(saved []Variant) { allowedVariants = saved }(allowedVariants)
+	allowedVariants = nil
+	AllowVariants(Variant{Size: ImageSize{Width: 120, Height: 120}, Fit: ImageFitCover})
+	defer func(saved []byte) { HMACKey = saved }(HMACKey)
+	HMACKey = []byte("key")
+
+	urls := VariantURLs(uid.From(0, 0), ImageFormatPNG)
+	if len(urls) != 1 {
+		t.Fatalf("got %d URLs, want 1", len(urls))
+	}
+	s, ok := urls["120:cover"]
+	if !ok {
+		t.Fatalf("no URL for 120:cover in %v", urls)
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := fromURL(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.valid() {
+		t.Error("variant URL has an invalid signature")
+	}
+	if r.format != ImageFormatPNG || r.fit != ImageFitCover {
+		t.Errorf("variant URL %s has the wrong format or fit", s)
+	}
+}
+
+func TestUnsignedPresets(t *testing.T) {
+	defer func(saved []Variant) { allowedVariants = saved }(allowedVariants)
+	defer func(saved []Variant) { unsignedPresets = saved }(unsignedPresets)
+	allowedVariants, unsignedPresets = nil, nil
+	AllowVariants(Variant{Size: ImageSize{Width: 720, Height: 1440}, Fit: ImageFitContain})
+	AllowUnsignedPresets(Variant{Size: ImageSize{Width: 120, Height: 120}, Fit: ImageFitCover, Format: ImageFormatWEBP})
+	defer func(saved []byte) { HMACKey = saved }(HMACKey)
+	HMACKey = []byte("key")
+
+	if _, ok := UnsignedURL(uid.From(0, 0), ImageFormatJPEG, Variant{Size: ImageSize{Width: 720, Height: 1440}, Fit: ImageFitContain}); ok {
+		t.Error("got an unsigned URL of a variant that's not a preset")
+	}
+	s, ok := UnsignedURL(uid.From(0, 0), ImageFormatJPEG, Variant{Size: ImageSize{Width: 120, Height: 120}, Fit: ImageFitCover, Format: ImageFormatWEBP})
+	if !ok {
+		t.Fatal("no unsigned URL of a preset")
+	}
+
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{s, true},
+		{"/images/000000000000000000000000.webp?size=120x120&fit=cover", true},
+		{"/images/000000000000000000000000.webp?size=120x120&fit=cover&expires=99999999999", false}, // Expiring.
+		{"/images/000000000000000000000000.webp?size=120x120&fit=cover&sig=aGFoYQ", false},          // Signed, wrongly.
+		{"/images/000000000000000000000000.webp?size=720x1440&fit=contain", false},                  // Allowed, but not a preset.
+		{"/images/000000000000000000000000.webp", false},                                            // The original.
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := fromURL(u)
+		if err != nil {
+			t.Fatalf("fromURL(%s): %v", test.url, err)
+		}
+		if got := r.unsigned(); got != test.want {
+			t.Errorf("unsigned() of %s = %v, want %v", test.url, got, test.want)
+		}
+	}
+}
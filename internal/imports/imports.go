@@ -0,0 +1,99 @@
+// Package imports parses the exports of communities on other platforms
+// (subreddit exports and Lemmy community dumps) into archives that can be
+// imported into Discuit.
+package imports
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Source platforms.
+const (
+	SourceReddit = "reddit"
+	SourceLemmy  = "lemmy"
+)
+
+// An Archive is the content of a community exported from another platform.
+type Archive struct {
+	Source    string // The platform the content was exported from.
+	Community string // Name of the community on the source platform.
+
+	Posts    []*Post    // Oldest first.
+	Comments []*Comment // Oldest first (so parents come before replies).
+}
+
+// Post is a post in an archive.
+type Post struct {
+	ID        string // ID on the source platform.
+	Author    string // Empty if the author's account was deleted.
+	Title     string
+	Body      string   // For text posts.
+	URL       string   // For link posts.
+	ImageURLs []string // For image posts.
+	CreatedAt time.Time
+}
+
+// Comment is a comment in an archive.
+type Comment struct {
+	ID        string // ID on the source platform.
+	PostID    string
+	ParentID  string // ID of the parent comment; empty for top-level comments.
+	Author    string // Empty if the author's account was deleted.
+	Body      string
+	CreatedAt time.Time
+}
+
+// Authors returns the names of the authors of the posts and comments of a,
+// sorted, without duplicates.
+func (a *Archive) Authors() []string {
+	seen := make(map[string]bool)
+	var names []string
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, p := range a.Posts {
+		add(p.Author)
+	}
+	for _, c := range a.Comments {
+		add(c.Author)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sort sorts the posts and comments of a by when they were created.
+func (a *Archive) sort() {
+	sort.SliceStable(a.Posts, func(i, j int) bool {
+		return a.Posts[i].CreatedAt.Before(a.Posts[j].CreatedAt)
+	})
+	sort.SliceStable(a.Comments, func(i, j int) bool {
+		return a.Comments[i].CreatedAt.Before(a.Comments[j].CreatedAt)
+	})
+}
+
+// readLines calls fn with each non-empty line of r, which is a file of
+// newline-delimited JSON objects.
+func readLines(r io.Reader, fn func(line []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,93 @@
+package imports
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseReddit(t *testing.T) {
+	submissions := `{"id":"b","author":"alice","subreddit":"golang","title":"Gophers &amp; you","is_self":true,"selftext":"Hi","created_utc":200}
+{"id":"a","author":"[deleted]","subreddit":"golang","title":"A link","url":"https://go.dev","created_utc":"100"}
+
+{"id":"c","author":"bob","subreddit":"golang","title":"A picture","url":"https://i.redd.it/x.png","post_hint":"image","created_utc":300}
+`
+	comments := `{"id":"c1","author":"bob","body":"First","link_id":"t3_b","parent_id":"t3_b","created_utc":210}
+{"id":"c2","author":"[deleted]","body":"[removed]","link_id":"t3_b","parent_id":"t1_c1","created_utc":220}
+{"id":"c3","author":"alice","body":"Reply","link_id":"t3_b","parent_id":"t1_c2","created_utc":230}
+{"id":"c4","author":"carol","body":"Elsewhere","link_id":"t3_z","parent_id":"t3_z","created_utc":240}
+`
+	a, err := ParseReddit(strings.NewReader(submissions), strings.NewReader(comments))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Community != "golang" {
+		t.Errorf("got community %q, want golang", a.Community)
+	}
+
+	var ids []string
+	for _, p := range a.Posts {
+		ids = append(ids, p.ID)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got posts %v, want %v", ids, want)
+	}
+	if p := a.Posts[0]; p.Author != "" || p.URL != "https://go.dev" {
+		t.Errorf("unexpected link post %+v", p)
+	}
+	if p := a.Posts[1]; p.Title != "Gophers & you" || p.Body != "Hi" {
+		t.Errorf("unexpected text post %+v", p)
+	}
+	if p := a.Posts[2]; len(p.ImageURLs) != 1 || p.URL != "" {
+		t.Errorf("unexpected image post %+v", p)
+	}
+
+	if len(a.Comments) != 2 {
+		t.Fatalf("got %d comments, want 2", len(a.Comments))
+	}
+	if c := a.Comments[1]; c.ID != "c3" || c.ParentID != "c1" {
+		t.Errorf("expected c3 to be reattached to c1, got %+v", c)
+	}
+	if got, want := a.Authors(), []string{"alice", "bob"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got authors %v, want %v", got, want)
+	}
+}
+
+func TestParseLemmy(t *testing.T) {
+	dump := `{
+	"community": {"name": "golang"},
+	"posts": [
+		{"post": {"id": 1, "name": "Text", "body": "Hi", "published": "2023-06-01T10:00:00.123456"}, "creator": {"name": "alice"}},
+		{"post": {"id": 2, "name": "Image", "body": "Ignored", "url": "https://lemmy.ml/pictrs/image/x.jpeg", "published": "2023-06-02T10:00:00Z"}, "creator": {"name": "bob", "deleted": true}},
+		{"post": {"id": 3, "name": "Gone", "removed": true, "published": "2023-06-03T10:00:00Z"}, "creator": {"name": "bob"}}
+	],
+	"comments": [
+		{"comment": {"id": 10, "post_id": 1, "content": "Top", "path": "0.10", "published": "2023-06-01T11:00:00Z"}, "creator": {"name": "bob"}},
+		{"comment": {"id": 11, "post_id": 1, "content": "Gone", "path": "0.10.11", "deleted": true, "published": "2023-06-01T12:00:00Z"}, "creator": {"name": "bob"}},
+		{"comment": {"id": 12, "post_id": 1, "content": "Reply", "path": "0.10.11.12", "published": "2023-06-01T13:00:00Z"}, "creator": {"name": "alice"}},
+		{"comment": {"id": 13, "post_id": 3, "content": "Orphan", "path": "0.13", "published": "2023-06-03T11:00:00Z"}, "creator": {"name": "alice"}}
+	]
+}`
+	a, err := ParseLemmy(strings.NewReader(dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Posts) != 2 {
+		t.Fatalf("got %d posts, want 2", len(a.Posts))
+	}
+	if p := a.Posts[0]; p.ID != "1" || p.Body != "Hi" || p.CreatedAt.Year() != 2023 {
+		t.Errorf("unexpected text post %+v", p)
+	}
+	if p := a.Posts[1]; len(p.ImageURLs) != 1 || p.Body != "" || p.Author != "" {
+		t.Errorf("unexpected image post %+v", p)
+	}
+	if len(a.Comments) != 2 {
+		t.Fatalf("got %d comments, want 2", len(a.Comments))
+	}
+	if c := a.Comments[0]; c.ID != "10" || c.ParentID != "" {
+		t.Errorf("unexpected top-level comment %+v", c)
+	}
+	if c := a.Comments[1]; c.ID != "12" || c.ParentID != "10" {
+		t.Errorf("expected 12 to be reattached to 10, got %+v", c)
+	}
+}
@@ -0,0 +1,161 @@
+package imports
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Lemmy community dump is a JSON object with the post and comment views of
+// the community, as returned by the /api/v3/post/list and
+// /api/v3/comment/list endpoints of Lemmy.
+
+type lemmyDump struct {
+	Community struct {
+		Name string `json:"name"`
+	} `json:"community"`
+	Posts []struct {
+		Post struct {
+			ID        int       `json:"id"`
+			Name      string    `json:"name"`
+			Body      string    `json:"body"`
+			URL       string    `json:"url"`
+			Deleted   bool      `json:"deleted"`
+			Removed   bool      `json:"removed"`
+			Published lemmyTime `json:"published"`
+		} `json:"post"`
+		Creator lemmyPerson `json:"creator"`
+	} `json:"posts"`
+	Comments []struct {
+		Comment struct {
+			ID        int       `json:"id"`
+			PostID    int       `json:"post_id"`
+			Content   string    `json:"content"`
+			Path      string    `json:"path"` // "0", followed by the IDs of the ancestors and of the comment, separated by dots.
+			Deleted   bool      `json:"deleted"`
+			Removed   bool      `json:"removed"`
+			Published lemmyTime `json:"published"`
+		} `json:"comment"`
+		Creator lemmyPerson `json:"creator"`
+	} `json:"comments"`
+}
+
+type lemmyPerson struct {
+	Name    string `json:"name"`
+	Deleted bool   `json:"deleted"`
+}
+
+func (p lemmyPerson) author() string {
+	if p.Deleted {
+		return ""
+	}
+	return p.Name
+}
+
+// lemmyTime is a timestamp, which older versions of Lemmy have without a time
+// zone (in UTC).
+type lemmyTime time.Time
+
+func (t *lemmyTime) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s == "" {
+		return nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999"} {
+		if v, err := time.Parse(layout, s); err == nil {
+			*t = lemmyTime(v.UTC())
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid timestamp %q", s)
+}
+
+// isImageURL reports whether u is (most likely) the URL of an image.
+func isImageURL(u string) bool {
+	u = strings.ToLower(u)
+	if i := strings.IndexAny(u, "?#"); i != -1 {
+		u = u[:i]
+	}
+	for _, ext := range []string{".jpg", ".jpeg", ".png", ".gif", ".webp"} {
+		if strings.HasSuffix(u, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseLemmy parses a Lemmy community dump. Deleted and removed posts and
+// comments are skipped (the replies of comments are attached to the nearest
+// remaining ancestor).
+func ParseLemmy(r io.Reader) (*Archive, error) {
+	var dump lemmyDump
+	if err := json.NewDecoder(r).Decode(&dump); err != nil {
+		return nil, fmt.Errorf("reading Lemmy dump: %w", err)
+	}
+
+	a := &Archive{Source: SourceLemmy, Community: dump.Community.Name}
+	posts := make(map[string]bool)
+	for _, v := range dump.Posts {
+		if v.Post.Deleted || v.Post.Removed {
+			continue
+		}
+		p := &Post{
+			ID:        strconv.Itoa(v.Post.ID),
+			Author:    v.Creator.author(),
+			Title:     v.Post.Name,
+			Body:      v.Post.Body,
+			CreatedAt: time.Time(v.Post.Published),
+		}
+		if v.Post.URL != "" {
+			if isImageURL(v.Post.URL) {
+				p.ImageURLs = []string{v.Post.URL}
+			} else {
+				p.URL = v.Post.URL
+			}
+			// Link and image posts can't have bodies on Discuit.
+			p.Body = ""
+		}
+		posts[p.ID] = true
+		a.Posts = append(a.Posts, p)
+	}
+
+	removed := make(map[string]bool)
+	for _, v := range dump.Comments {
+		if v.Comment.Deleted || v.Comment.Removed {
+			removed[strconv.Itoa(v.Comment.ID)] = true
+		}
+	}
+	for _, v := range dump.Comments {
+		id, postID := strconv.Itoa(v.Comment.ID), strconv.Itoa(v.Comment.PostID)
+		if removed[id] || !posts[postID] {
+			continue
+		}
+		ancestors := strings.Split(v.Comment.Path, ".")
+		parent := ""
+		// The first element of the path is always "0" and the last is the
+		// comment itself.
+		for i := len(ancestors) - 2; i >= 1; i-- {
+			if !removed[ancestors[i]] {
+				parent = ancestors[i]
+				break
+			}
+		}
+		a.Comments = append(a.Comments, &Comment{
+			ID:        id,
+			PostID:    postID,
+			ParentID:  parent,
+			Author:    v.Creator.author(),
+			Body:      v.Comment.Content,
+			CreatedAt: time.Time(v.Comment.Published),
+		})
+	}
+
+	a.sort()
+	return a, nil
+}
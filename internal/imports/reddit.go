@@ -0,0 +1,180 @@
+package imports
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A subreddit export is a pair of newline-delimited JSON files, one of
+// submissions and one of comments, with one Reddit API object per line (the
+// format of Pushshift dumps and of most subreddit archiving tools).
+
+type redditSubmission struct {
+	ID            string         `json:"id"`
+	Author        string         `json:"author"`
+	Subreddit     string         `json:"subreddit"`
+	Title         string         `json:"title"`
+	Selftext      string         `json:"selftext"`
+	URL           string         `json:"url"`
+	IsSelf        bool           `json:"is_self"`
+	PostHint      string         `json:"post_hint"`
+	IsGallery     bool           `json:"is_gallery"`
+	GalleryData   *redditGallery `json:"gallery_data"`
+	MediaMetadata map[string]struct {
+		Status string `json:"status"`
+		S      struct {
+			U string `json:"u"`
+		} `json:"s"`
+	} `json:"media_metadata"`
+	CreatedUTC redditTime `json:"created_utc"`
+}
+
+type redditGallery struct {
+	Items []struct {
+		MediaID string `json:"media_id"`
+	} `json:"items"`
+}
+
+type redditComment struct {
+	ID         string     `json:"id"`
+	Author     string     `json:"author"`
+	Body       string     `json:"body"`
+	LinkID     string     `json:"link_id"`   // "t3_" followed by the ID of the post.
+	ParentID   string     `json:"parent_id"` // "t1_" (a comment) or "t3_" (the post) followed by an ID.
+	CreatedUTC redditTime `json:"created_utc"`
+}
+
+// redditTime is a Unix timestamp, which exports have as either a number or a
+// string.
+type redditTime time.Time
+
+func (t *redditTime) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("invalid created_utc %s", data)
+	}
+	*t = redditTime(time.Unix(int64(f), 0).UTC())
+	return nil
+}
+
+// redditAuthor returns the name of a Reddit user, or an empty string if the
+// user's account was deleted.
+func redditAuthor(name string) string {
+	if name == "[deleted]" || name == "[removed]" {
+		return ""
+	}
+	return name
+}
+
+// redditDeleted reports whether body is that of a removed post or comment.
+func redditDeleted(body string) bool {
+	return body == "[deleted]" || body == "[removed]"
+}
+
+// ParseReddit parses a subreddit export. Either of submissions and comments
+// can be nil. Comments of posts not in submissions are skipped, as are removed
+// comments (their replies are attached to the nearest remaining ancestor).
+func ParseReddit(submissions, comments io.Reader) (*Archive, error) {
+	a := &Archive{Source: SourceReddit}
+	posts := make(map[string]bool)
+	if submissions != nil {
+		err := readLines(submissions, func(line []byte) error {
+			var s redditSubmission
+			if err := json.Unmarshal(line, &s); err != nil {
+				return err
+			}
+			if s.ID == "" {
+				return fmt.Errorf("submission without an id")
+			}
+			if a.Community == "" {
+				a.Community = s.Subreddit
+			}
+			p := &Post{
+				ID:        s.ID,
+				Author:    redditAuthor(s.Author),
+				Title:     html.UnescapeString(s.Title),
+				CreatedAt: time.Time(s.CreatedUTC),
+			}
+			switch {
+			case s.IsSelf:
+				if !redditDeleted(s.Selftext) {
+					p.Body = html.UnescapeString(s.Selftext)
+				}
+			case s.IsGallery && s.GalleryData != nil:
+				for _, item := range s.GalleryData.Items {
+					if m, ok := s.MediaMetadata[item.MediaID]; ok && m.Status == "valid" && m.S.U != "" {
+						p.ImageURLs = append(p.ImageURLs, html.UnescapeString(m.S.U))
+					}
+				}
+			case s.PostHint == "image":
+				p.ImageURLs = []string{s.URL}
+			default:
+				p.URL = s.URL
+			}
+			posts[p.ID] = true
+			a.Posts = append(a.Posts, p)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading submissions: %w", err)
+		}
+	}
+
+	if comments != nil {
+		var all []*redditComment
+		err := readLines(comments, func(line []byte) error {
+			c := &redditComment{}
+			if err := json.Unmarshal(line, c); err != nil {
+				return err
+			}
+			all = append(all, c)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading comments: %w", err)
+		}
+
+		parents := make(map[string]string) // Comment ID to parent comment ID.
+		for _, c := range all {
+			if id, ok := strings.CutPrefix(c.ParentID, "t1_"); ok {
+				parents[c.ID] = id
+			}
+		}
+		removed := make(map[string]bool)
+		for _, c := range all {
+			if redditDeleted(c.Body) {
+				removed[c.ID] = true
+			}
+		}
+		for _, c := range all {
+			postID := strings.TrimPrefix(c.LinkID, "t3_")
+			if !posts[postID] || removed[c.ID] {
+				continue
+			}
+			parent := parents[c.ID]
+			for parent != "" && removed[parent] {
+				parent = parents[parent]
+			}
+			a.Comments = append(a.Comments, &Comment{
+				ID:        c.ID,
+				PostID:    postID,
+				ParentID:  parent,
+				Author:    redditAuthor(c.Author),
+				Body:      html.UnescapeString(c.Body),
+				CreatedAt: time.Time(c.CreatedUTC),
+			})
+		}
+	}
+
+	a.sort()
+	return a, nil
+}
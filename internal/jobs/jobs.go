@@ -0,0 +1,494 @@
+// Package jobs implements a Redis-backed queue of delayed jobs.
+//
+// Jobs are kept in a sorted set, scored by when they're due. A worker claims
+// a due job by pushing its score forward by a lease (longer than the job is
+// allowed to run), so that if the process running the job exits before the
+// job returns, the job is run again once the lease expires. Since the queue
+// lives in Redis, jobs queued by one process may be run by another, and jobs
+// survive restarts. Jobs are run at least once; a job may be run again if it
+// was interrupted.
+//
+// Jobs may be batched (see Queue.EnqueueBatched): payloads added to a batch
+// before it's run are run as one job.
+//
+// Failed jobs are retried with exponential backoff, and after MaxAttempts
+// failures, they're moved to a dead-letter list, from which they can be
+// inspected and requeued (see Queue.DeadJobs and Queue.RetryDead).
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/logging"
+	"github.com/gomodule/redigo/redis"
+)
+
+// maxDeadJobs is the number of jobs kept in the dead-letter list of a queue.
+const maxDeadJobs = 1000
+
+// Handler runs a job of a kind with its payload.
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+// Job is a job in a queue.
+type Job struct {
+	ID         string          `json:"id"`
+	Kind       string          `json:"kind"`
+	Payload    json.RawMessage `json:"payload"`
+	Attempts   int             `json:"attempts"` // Failed attempts so far.
+	EnqueuedAt time.Time       `json:"enqueuedAt"`
+
+	// Set on batches (see EnqueueBatched): the key of the list of their
+	// payloads, which are read into Payload once they're claimed.
+	Batch string `json:"batch,omitempty"`
+
+	// Set on dead jobs.
+	LastError string    `json:"lastError,omitempty"`
+	DiedAt    time.Time `json:"diedAt,omitempty"`
+}
+
+// Options hold optional arguments to New.
+type Options struct {
+	// Number of jobs run concurrently. Defaults to 2.
+	Workers int
+
+	// How long a job may run before it's canceled. Defaults to 2 minutes.
+	Timeout time.Duration
+
+	// Number of times a job is attempted before it's moved to the
+	// dead-letter list. Defaults to 5.
+	MaxAttempts int
+
+	// How long to wait before retrying a failed job, doubled with each
+	// failure up to MaxBackoff. Defaults to 30 seconds and 30 minutes.
+	Backoff, MaxBackoff time.Duration
+
+	// How often workers check for due jobs when there are none. Defaults to
+	// a second.
+	PollInterval time.Duration
+}
+
+// Queue is a queue of delayed jobs, named so that more than one can share a
+// Redis database.
+type Queue struct {
+	pool     *redis.Pool
+	name     string
+	opts     Options
+	handlers map[string]Handler
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New returns a Queue named name that uses pool, which is closed by Close.
+// Register the handlers of jobs with Handle, and then call Start for jobs to
+// be run.
+func New(pool *redis.Pool, name string, opts Options) *Queue {
+	if opts.Workers <= 0 {
+		opts.Workers = 2
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = time.Minute * 2
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = time.Second * 30
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Minute * 30
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	return &Queue{pool: pool, name: name, opts: opts, handlers: make(map[string]Handler)}
+}
+
+func (q *Queue) scheduledKey() string { return "jobs:" + q.name + ":scheduled" }
+func (q *Queue) deadKey() string      { return "jobs:" + q.name + ":dead" }
+
+func (q *Queue) batchKey(kind, key string) string {
+	return "jobs:" + q.name + ":batch:" + kind + ":" + key
+}
+
+// lease is how long a claimed job is kept from other workers.
+func (q *Queue) lease() time.Duration {
+	return q.opts.Timeout + time.Minute
+}
+
+// Handle registers h as the handler of jobs of kind. Call it before Start.
+func (q *Queue) Handle(kind string, h Handler) {
+	q.handlers[kind] = h
+}
+
+func newJobID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Enqueue queues a job of kind, with payload (marshaled to JSON), to be run
+// at (or soon after) at.
+func (q *Queue) Enqueue(kind string, payload any, at time.Time) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	conn := q.pool.Get()
+	defer conn.Close()
+	return q.schedule(conn, &Job{ID: newJobID(), Kind: kind, Payload: data, EnqueuedAt: time.Now()}, at)
+}
+
+// EnqueueBatched adds payloads (each marshaled to JSON) to the batch of jobs
+// of kind named key, and, unless it's already queued, queues the batch to be
+// run at (or soon after) at. A batch is run as one job of kind whose payload
+// is the JSON array of the payloads added to it before it's run. Payloads
+// added after that are of the next batch of the same name.
+func (q *Queue) EnqueueBatched(kind, key string, at time.Time, payloads ...any) error {
+	if len(payloads) == 0 {
+		return nil
+	}
+	args := []any{q.batchKey(kind, key)}
+	for _, payload := range payloads {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		args = append(args, data)
+	}
+	// The job of a batch is the same until it's claimed, so that it's queued
+	// once.
+	job, err := json.Marshal(&Job{ID: "batch:" + key, Kind: kind, Batch: q.batchKey(kind, key)})
+	if err != nil {
+		return err
+	}
+
+	conn := q.pool.Get()
+	defer conn.Close()
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+	if err := conn.Send("RPUSH", args...); err != nil {
+		return err
+	}
+	if err := conn.Send("ZADD", q.scheduledKey(), "NX", at.UnixMilli(), job); err != nil {
+		return err
+	}
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+func (q *Queue) schedule(conn redis.Conn, job *Job, at time.Time) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Do("ZADD", q.scheduledKey(), at.UnixMilli(), data)
+	return err
+}
+
+// Start starts the workers of q. It returns immediately.
+func (q *Queue) Start() {
+	var ctx context.Context
+	ctx, q.cancel = context.WithCancel(context.Background())
+	for i := 0; i < q.opts.Workers; i++ {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			q.work(ctx)
+		}()
+	}
+}
+
+// Stop stops the workers of q and waits for the jobs being run to return, or
+// for ctx to be canceled. Jobs that are interrupted are run again later.
+func (q *Queue) Stop(ctx context.Context) error {
+	if q.cancel == nil {
+		return nil
+	}
+	q.cancel()
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close closes the Redis pool of q. Call Stop before calling Close.
+func (q *Queue) Close() error {
+	return q.pool.Close()
+}
+
+// sleep returns false if ctx is canceled before d elapses.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// claim returns the raw data of the job that is the most overdue, if any,
+// after moving its score forward by the lease, or nil if there's none. The
+// job is claimed in a transaction, which is aborted (and claim tries again)
+// if the queue changes before it's committed, as when another worker claims
+// the job first.
+func (q *Queue) claim() ([]byte, error) {
+	conn := q.pool.Get()
+	defer conn.Close()
+	for {
+		if _, err := conn.Do("WATCH", q.scheduledKey()); err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		due, err := redis.ByteSlices(conn.Do("ZRANGEBYSCORE", q.scheduledKey(), "-inf", now.UnixMilli(), "LIMIT", 0, 1))
+		if err != nil {
+			return nil, err
+		}
+		if len(due) == 0 {
+			_, err := conn.Do("UNWATCH")
+			return nil, err
+		}
+		if err := conn.Send("MULTI"); err != nil {
+			return nil, err
+		}
+		if err := conn.Send("ZADD", q.scheduledKey(), now.Add(q.lease()).UnixMilli(), due[0]); err != nil {
+			return nil, err
+		}
+		reply, err := conn.Do("EXEC")
+		if err != nil {
+			return nil, err
+		}
+		if reply != nil {
+			return due[0], nil
+		}
+	}
+}
+
+// unbatch replaces job, a claimed batch whose data is data, with a job whose
+// payload is the array of the payloads of the batch, and returns the data of
+// that job, which is leased as data was. Payloads added to the batch from
+// then on are of the next batch.
+func (q *Queue) unbatch(job *Job, data []byte) ([]byte, error) {
+	conn := q.pool.Get()
+	defer conn.Close()
+	for {
+		if _, err := conn.Do("WATCH", job.Batch); err != nil {
+			return nil, err
+		}
+		payloads, err := redis.ByteSlices(conn.Do("LRANGE", job.Batch, 0, -1))
+		if err != nil {
+			return nil, err
+		}
+		unbatched, err := json.Marshal(&Job{
+			ID:         newJobID(),
+			Kind:       job.Kind,
+			Payload:    append(append([]byte("["), bytes.Join(payloads, []byte(","))...), ']'),
+			EnqueuedAt: time.Now(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := conn.Send("MULTI"); err != nil {
+			return nil, err
+		}
+		if err := conn.Send("DEL", job.Batch); err != nil {
+			return nil, err
+		}
+		if err := conn.Send("ZREM", q.scheduledKey(), data); err != nil {
+			return nil, err
+		}
+		if err := conn.Send("ZADD", q.scheduledKey(), time.Now().Add(q.lease()).UnixMilli(), unbatched); err != nil {
+			return nil, err
+		}
+		reply, err := conn.Do("EXEC")
+		if err != nil {
+			return nil, err
+		}
+		if reply != nil {
+			return unbatched, nil
+		}
+	}
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		data, err := q.claim()
+		if err != nil {
+			logging.FromContext(ctx).Error("Error claiming job", "queue", q.name, "error", err)
+			sleep(ctx, time.Second*5)
+			continue
+		}
+		if data == nil {
+			sleep(ctx, q.opts.PollInterval)
+			continue
+		}
+		q.run(ctx, data)
+	}
+}
+
+// run runs the job with data, and then removes it from the queue, schedules
+// it for a retry, or moves it to the dead-letter list.
+func (q *Queue) run(ctx context.Context, data []byte) {
+	job := &Job{}
+	err := json.Unmarshal(data, job)
+	if err == nil && job.Batch != "" {
+		// If the batch cannot be read, it's run once its lease expires.
+		if data, err = q.unbatch(job, data); err != nil {
+			logging.FromContext(ctx).Error("Error reading batch", "queue", q.name, "batch", job.Batch, "error", err)
+			return
+		}
+		job = &Job{}
+		err = json.Unmarshal(data, job)
+	}
+	if err == nil {
+		if h := q.handlers[job.Kind]; h != nil {
+			jobCtx, cancel := context.WithTimeout(ctx, q.opts.Timeout)
+			err = h(jobCtx, job.Payload)
+			cancel()
+		} else {
+			err = errors.New("no handler for jobs of this kind")
+		}
+	}
+
+	conn := q.pool.Get()
+	defer conn.Close()
+
+	logger := logging.FromContext(ctx).With("queue", q.name)
+	if job.ID == "" {
+		logger.Error("Dropping malformed job", "job", string(data), "error", err)
+		if _, err := conn.Do("ZREM", q.scheduledKey(), data); err != nil {
+			logger.Error("Error removing job", "error", err)
+		}
+		return
+	}
+
+	if err != nil && ctx.Err() != nil {
+		// Interrupted by Stop; run it again as soon as possible.
+		if _, err := conn.Do("ZADD", q.scheduledKey(), time.Now().UnixMilli(), data); err != nil {
+			logger.Error("Error requeuing job", "job", job.ID, "error", err)
+		}
+		return
+	}
+	if _, err := conn.Do("ZREM", q.scheduledKey(), data); err != nil {
+		logger.Error("Error removing job", "job", job.ID, "error", err)
+		return
+	}
+	if err == nil {
+		return
+	}
+
+	job.Attempts++
+	if job.Attempts >= q.opts.MaxAttempts || q.handlers[job.Kind] == nil {
+		logger.Error("Giving up on job", "kind", job.Kind, "job", job.ID, "attempts", job.Attempts, "error", err)
+		if err := q.bury(conn, job, err); err != nil {
+			logger.Error("Error moving job to the dead-letter list", "job", job.ID, "error", err)
+		}
+		return
+	}
+	logger.Warn("Error running job", "kind", job.Kind, "job", job.ID, "attempt", job.Attempts, "error", err)
+	if err := q.schedule(conn, job, time.Now().Add(q.backoff(job.Attempts))); err != nil {
+		logger.Error("Error scheduling retry of job", "job", job.ID, "error", err)
+	}
+}
+
+// bury moves job, which failed with jobErr, to the dead-letter list.
+func (q *Queue) bury(conn redis.Conn, job *Job, jobErr error) error {
+	job.LastError = jobErr.Error()
+	job.DiedAt = time.Now()
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Do("LPUSH", q.deadKey(), data); err != nil {
+		return err
+	}
+	_, err = conn.Do("LTRIM", q.deadKey(), 0, maxDeadJobs-1)
+	return err
+}
+
+// backoff returns how long to wait before retrying a job that has failed
+// attempts times.
+func (q *Queue) backoff(attempts int) time.Duration {
+	d := q.opts.Backoff
+	for i := 1; i < attempts && d < q.opts.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, q.opts.MaxBackoff)
+}
+
+// Len returns the number of jobs that are scheduled (including those that are
+// running) and the number of dead jobs.
+func (q *Queue) Len() (scheduled, dead int, err error) {
+	conn := q.pool.Get()
+	defer conn.Close()
+	if scheduled, err = redis.Int(conn.Do("ZCARD", q.scheduledKey())); err != nil {
+		return
+	}
+	dead, err = redis.Int(conn.Do("LLEN", q.deadKey()))
+	return
+}
+
+// DeadJobs returns the jobs in the dead-letter list, the latest first.
+func (q *Queue) DeadJobs() ([]*Job, error) {
+	conn := q.pool.Get()
+	defer conn.Close()
+	items, err := redis.ByteSlices(conn.Do("LRANGE", q.deadKey(), 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]*Job, 0, len(items))
+	for _, data := range items {
+		job := &Job{}
+		if err := json.Unmarshal(data, job); err != nil {
+			return nil, fmt.Errorf("malformed dead job %q: %w", data, err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// RetryDead moves all jobs in the dead-letter list back to the queue, to be
+// run now, with their attempts reset. It returns the number of jobs moved.
+func (q *Queue) RetryDead(ctx context.Context) (int, error) {
+	conn := q.pool.Get()
+	defer conn.Close()
+	n := 0
+	for {
+		data, err := redis.Bytes(conn.Do("RPOP", q.deadKey()))
+		if err == redis.ErrNil {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		job := &Job{}
+		if err := json.Unmarshal(data, job); err != nil {
+			logging.FromContext(ctx).Error("Dropping malformed dead job", "queue", q.name, "job", string(data), "error", err)
+			continue
+		}
+		job.Attempts, job.LastError, job.DiedAt = 0, "", time.Time{}
+		if err := q.schedule(conn, job, time.Now()); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
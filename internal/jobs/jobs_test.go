@@ -0,0 +1,304 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/redistest"
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestQueueBackoff(t *testing.T) {
+	q := New(nil, "test", Options{Backoff: time.Second, MaxBackoff: time.Second * 5})
+	expect := []time.Duration{time.Second, time.Second * 2, time.Second * 4, time.Second * 5, time.Second * 5}
+	for i, d := range expect {
+		if got := q.backoff(i + 1); got != d {
+			t.Errorf("backoff after %d attempts: expected %v, got %v", i+1, d, got)
+		}
+	}
+}
+
+func TestQueueLease(t *testing.T) {
+	q := New(nil, "test", Options{Timeout: time.Minute})
+	if q.lease() <= q.opts.Timeout {
+		t.Errorf("lease %v is not longer than the job timeout %v", q.lease(), q.opts.Timeout)
+	}
+}
+
+// claimJob claims a job of q, and fails the test if there's none.
+func claimJob(t *testing.T, q *Queue) []byte {
+	t.Helper()
+	data, err := q.claim()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data == nil {
+		t.Fatal("no job is claimed")
+	}
+	return data
+}
+
+func expectLen(t *testing.T, q *Queue, scheduled, dead int) {
+	t.Helper()
+	s, d, err := q.Len()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != scheduled || d != dead {
+		t.Errorf("expected %d scheduled and %d dead jobs, got %d and %d", scheduled, dead, s, d)
+	}
+}
+
+func TestQueueClaim(t *testing.T) {
+	q := New(redistest.NewServer().Pool(), "test", Options{})
+	if err := q.Enqueue("later", 1, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue("now", 2, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	job := &Job{}
+	if err := json.Unmarshal(claimJob(t, q), job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Kind != "now" || string(job.Payload) != "2" {
+		t.Errorf("claimed the %s job with payload %s, expected the due one", job.Kind, job.Payload)
+	}
+	// The claimed job is leased, and the other one is not due.
+	if data, err := q.claim(); err != nil || data != nil {
+		t.Errorf("claimed %s (error: %v), expected no job", data, err)
+	}
+	expectLen(t, q, 2, 0)
+}
+
+func TestQueueClaimConcurrent(t *testing.T) {
+	q := New(redistest.NewServer().Pool(), "test", Options{})
+	const n = 50
+	for i := 0; i < n; i++ {
+		if err := q.Enqueue("job", i, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		mu      sync.Mutex
+		claimed = make(map[string]int)
+		wg      sync.WaitGroup
+	)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				data, err := q.claim()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if data == nil {
+					return
+				}
+				mu.Lock()
+				claimed[string(data)]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claimed) != n {
+		t.Errorf("%d jobs claimed, expected %d", len(claimed), n)
+	}
+	for data, count := range claimed {
+		if count != 1 {
+			t.Errorf("job %s claimed %d times", data, count)
+		}
+	}
+}
+
+func TestQueueRetry(t *testing.T) {
+	q := New(redistest.NewServer().Pool(), "test", Options{MaxAttempts: 3, Backoff: time.Hour})
+	runs := 0
+	q.Handle("fail", func(ctx context.Context, payload json.RawMessage) error {
+		runs++
+		return errors.New("failed")
+	})
+	q.Handle("succeed", func(ctx context.Context, payload json.RawMessage) error {
+		runs++
+		return nil
+	})
+
+	ctx := context.Background()
+	if err := q.Enqueue("succeed", nil, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	q.run(ctx, claimJob(t, q))
+	expectLen(t, q, 0, 0)
+
+	if err := q.Enqueue("fail", nil, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	q.run(ctx, claimJob(t, q))
+	if runs != 2 {
+		t.Fatalf("handlers ran %d times, expected 2", runs)
+	}
+	// The failed job is scheduled again, after the backoff.
+	expectLen(t, q, 1, 0)
+	if data, err := q.claim(); err != nil || data != nil {
+		t.Errorf("claimed %s (error: %v) before the backoff elapsed", data, err)
+	}
+
+	// Make it due now.
+	conn := q.pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("ZADD", q.scheduledKey(), 0, mustScheduled(t, q)); err != nil {
+		t.Fatal(err)
+	}
+	job := &Job{}
+	if err := json.Unmarshal(claimJob(t, q), job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Kind != "fail" || job.Attempts != 1 {
+		t.Errorf("retried the %s job after %d attempts, expected the failed job after 1", job.Kind, job.Attempts)
+	}
+}
+
+// mustScheduled returns the data of the only job scheduled in q.
+func mustScheduled(t *testing.T, q *Queue) []byte {
+	t.Helper()
+	conn := q.pool.Get()
+	defer conn.Close()
+	items, err := redis.ByteSlices(conn.Do("ZRANGEBYSCORE", q.scheduledKey(), "-inf", "+inf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("%d jobs scheduled, expected 1", len(items))
+	}
+	return items[0]
+}
+
+func TestQueueDeadLetter(t *testing.T) {
+	q := New(redistest.NewServer().Pool(), "test", Options{
+		MaxAttempts: 2,
+		Backoff:     time.Millisecond,
+		MaxBackoff:  time.Millisecond,
+	})
+	q.Handle("fail", func(ctx context.Context, payload json.RawMessage) error {
+		return errors.New("failed")
+	})
+
+	ctx := context.Background()
+	if err := q.Enqueue("fail", "x", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	q.run(ctx, claimJob(t, q))
+	time.Sleep(time.Millisecond * 5)
+	q.run(ctx, claimJob(t, q))
+	expectLen(t, q, 0, 1)
+
+	dead, err := q.DeadJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 {
+		t.Fatalf("%d dead jobs, expected 1", len(dead))
+	}
+	if job := dead[0]; job.Kind != "fail" || job.Attempts != 2 || job.LastError != "failed" || job.DiedAt.IsZero() {
+		t.Errorf("unexpected dead job: %+v", job)
+	}
+
+	// Jobs of kinds without handlers are buried at once.
+	if err := q.Enqueue("unknown", nil, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	q.run(ctx, claimJob(t, q))
+	expectLen(t, q, 0, 2)
+
+	n, err := q.RetryDead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("requeued %d dead jobs, expected 2", n)
+	}
+	expectLen(t, q, 2, 0)
+	job := &Job{}
+	if err := json.Unmarshal(claimJob(t, q), job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Attempts != 0 || job.LastError != "" || !job.DiedAt.IsZero() {
+		t.Errorf("requeued dead job was not reset: %+v", job)
+	}
+}
+
+func TestQueueBatch(t *testing.T) {
+	q := New(redistest.NewServer().Pool(), "test", Options{MaxAttempts: 3, Backoff: time.Hour})
+	var payloads []string
+	fail := true
+	q.Handle("batch", func(ctx context.Context, payload json.RawMessage) error {
+		payloads = append(payloads, string(payload))
+		if fail {
+			fail = false
+			return errors.New("failed")
+		}
+		return nil
+	})
+
+	ctx := context.Background()
+	if err := q.EnqueueBatched("batch", "a", time.Now(), 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	// Added to the batch queued, which is not queued again.
+	if err := q.EnqueueBatched("batch", "a", time.Now().Add(time.Hour), 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.EnqueueBatched("batch", "b", time.Now().Add(time.Hour), 4); err != nil {
+		t.Fatal(err)
+	}
+	expectLen(t, q, 2, 0)
+
+	q.run(ctx, claimJob(t, q))
+	if len(payloads) != 1 || payloads[0] != "[1,2,3]" {
+		t.Fatalf("the batch was run with %v, expected [1,2,3]", payloads)
+	}
+	// Added to the next batch, since this one has been run.
+	if err := q.EnqueueBatched("batch", "a", time.Now().Add(time.Hour), 5); err != nil {
+		t.Fatal(err)
+	}
+	// The failed batch is retried with its payloads, and the next batch and
+	// batch b are queued.
+	expectLen(t, q, 3, 0)
+
+	conn := q.pool.Get()
+	defer conn.Close()
+	jobs, err := redis.ByteSlices(conn.Do("ZRANGEBYSCORE", q.scheduledKey(), "-inf", "+inf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range jobs {
+		job := &Job{}
+		if err := json.Unmarshal(data, job); err != nil {
+			t.Fatal(err)
+		}
+		if job.Batch != "" {
+			continue
+		}
+		if job.Attempts != 1 || string(job.Payload) != "[1,2,3]" {
+			t.Errorf("the failed batch is retried after %d attempts with %s, expected 1 and [1,2,3]", job.Attempts, job.Payload)
+		}
+		if _, err := conn.Do("ZADD", q.scheduledKey(), 0, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.run(ctx, claimJob(t, q))
+	if len(payloads) != 2 || payloads[1] != "[1,2,3]" {
+		t.Errorf("the batch was retried with %v, expected [1,2,3]", payloads)
+	}
+	expectLen(t, q, 2, 0)
+}
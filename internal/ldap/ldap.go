@@ -0,0 +1,425 @@
+// Package ldap is a minimal LDAPv3 client: just enough of the protocol (simple
+// binds and equality searches) to authenticate users against a directory and
+// to read their attributes.
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Result codes (of RFC 4511).
+const (
+	ResultSuccess            = 0
+	ResultSizeLimitExceeded  = 4
+	ResultInvalidCredentials = 49
+)
+
+// Error is an unsuccessful result of an operation.
+type Error struct {
+	ResultCode int
+	Message    string // The diagnostic message of the server.
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ldap: result code %d", e.ResultCode)
+	}
+	return fmt.Sprintf("ldap: result code %d: %s", e.ResultCode, e.Message)
+}
+
+// IsInvalidCredentials reports whether err is the result of a bind with the
+// wrong DN or password.
+func IsInvalidCredentials(err error) bool {
+	var lerr *Error
+	return errors.As(err, &lerr) && lerr.ResultCode == ResultInvalidCredentials
+}
+
+// Conn is a connection to an LDAP server. It's not safe for concurrent use.
+type Conn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	lastID int32
+}
+
+// Dial connects to the server at rawURL, which is of the form
+// ldap://host[:port] or ldaps://host[:port]. The connection is closed when ctx
+// is done.
+func Dial(ctx context.Context, rawURL string, tlsConfig *tls.Config) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	host, port := u.Hostname(), u.Port()
+	var useTLS bool
+	switch u.Scheme {
+	case "ldap":
+		if port == "" {
+			port = "389"
+		}
+	case "ldaps":
+		useTLS = true
+		if port == "" {
+			port = "636"
+		}
+	default:
+		return nil, fmt.Errorf("ldap: unsupported URL scheme %q", u.Scheme)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+	if useTLS {
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = host
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	return newConn(conn), nil
+}
+
+func newConn(conn net.Conn) *Conn {
+	return &Conn{conn: conn, r: bufio.NewReader(conn)}
+}
+
+// Close sends an unbind request and closes the connection.
+func (c *Conn) Close() error {
+	c.send(asn1.RawValue{Class: asn1.ClassApplication, Tag: appUnbindRequest})
+	return c.conn.Close()
+}
+
+// Protocol operations (the tags of the CHOICE of LDAPMessage).
+const (
+	appBindRequest       = 0
+	appBindResponse      = 1
+	appUnbindRequest     = 2
+	appSearchRequest     = 3
+	appSearchResultEntry = 4
+	appSearchResultDone  = 5
+	appSearchResultRef   = 19
+)
+
+// message is an LDAPMessage (without controls).
+type message struct {
+	ID int32
+	Op asn1.RawValue
+}
+
+// result is an LDAPResult (the referral of which, if any, is ignored).
+type result struct {
+	Code      asn1.Enumerated
+	MatchedDN []byte
+	Message   []byte
+}
+
+func (r *result) err() error {
+	if r.Code == ResultSuccess {
+		return nil
+	}
+	return &Error{ResultCode: int(r.Code), Message: string(r.Message)}
+}
+
+func mustMarshal(v any) []byte {
+	b, err := asn1.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// concat returns the concatenation of the encodings of values.
+func concat(values ...any) []byte {
+	var b []byte
+	for _, v := range values {
+		b = append(b, mustMarshal(v)...)
+	}
+	return b
+}
+
+// send sends op and returns the ID of its message.
+func (c *Conn) send(op asn1.RawValue) (int32, error) {
+	c.lastID++
+	b, err := asn1.Marshal(message{ID: c.lastID, Op: op})
+	if err != nil {
+		return 0, err
+	}
+	_, err = c.conn.Write(b)
+	return c.lastID, err
+}
+
+// receive reads the next message of the response to the message with id.
+func (c *Conn) receive(id int32) (*message, error) {
+	for {
+		data, err := readElement(c.r)
+		if err != nil {
+			return nil, err
+		}
+		var m message
+		if _, err := asn1.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("ldap: malformed message: %w", err)
+		}
+		if m.ID == id {
+			return &m, nil
+		}
+		// Unsolicited notifications (ID 0) are ignored.
+	}
+}
+
+// maxElementSize is the max size of the messages that are read.
+const maxElementSize = 16 << 20
+
+// readElement reads a BER element (tag, length, and contents) from r.
+func readElement(r *bufio.Reader) ([]byte, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if tag&0x1f == 0x1f {
+		return nil, errors.New("ldap: multi-byte tags are not supported")
+	}
+	b := []byte{tag}
+	first, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, first)
+	length := int(first)
+	if first&0x80 != 0 {
+		n := int(first & 0x7f)
+		if n == 0 || n > 4 {
+			return nil, errors.New("ldap: unsupported length encoding")
+		}
+		length = 0
+		for i := 0; i < n; i++ {
+			x, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			b = append(b, x)
+			length = length<<8 | int(x)
+		}
+	}
+	if length > maxElementSize {
+		return nil, errors.New("ldap: message too large")
+	}
+	contents := make([]byte, length)
+	if _, err := io.ReadFull(r, contents); err != nil {
+		return nil, err
+	}
+	return append(b, contents...), nil
+}
+
+// Bind authenticates the connection as dn with a simple bind. Binds with empty
+// passwords are refused, as servers treat them as unauthenticated binds (which
+// succeed for any dn).
+func (c *Conn) Bind(dn, password string) error {
+	if password == "" {
+		return &Error{ResultCode: ResultInvalidCredentials, Message: "empty password"}
+	}
+	id, err := c.send(asn1.RawValue{
+		Class:      asn1.ClassApplication,
+		Tag:        appBindRequest,
+		IsCompound: true,
+		Bytes:      concat(3, []byte(dn), asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: []byte(password)}),
+	})
+	if err != nil {
+		return err
+	}
+	m, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if m.Op.Class != asn1.ClassApplication || m.Op.Tag != appBindResponse {
+		return fmt.Errorf("ldap: unexpected response to bind (tag %d)", m.Op.Tag)
+	}
+	var res result
+	if _, err := asn1.UnmarshalWithParams(m.Op.FullBytes, &res, "application,tag:1"); err != nil {
+		return fmt.Errorf("ldap: malformed bind response: %w", err)
+	}
+	return res.err()
+}
+
+// Entry is an entry of a directory.
+type Entry struct {
+	DN         string
+	Attributes map[string][]string // Keys are lowercase.
+}
+
+// Get returns the first value of the attribute name of e, or an empty string.
+func (e *Entry) Get(name string) string {
+	if values := e.Attributes[strings.ToLower(name)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+type searchEntry struct {
+	DN         []byte
+	Attributes []struct {
+		Type   []byte
+		Values [][]byte `asn1:"set"`
+	}
+}
+
+// SearchEqual returns the entries in the subtree of baseDN whose attribute attr
+// is equal to value, with the attributes in attrs (or all user attributes, if
+// attrs is empty). At most limit entries are returned; if there are more,
+// the error is an *Error with the code ResultSizeLimitExceeded.
+//
+// The filter is encoded as an equalityMatch filter, not as a string, so value
+// need not be escaped.
+func (c *Conn) SearchEqual(baseDN, attr, value string, attrs []string, limit int) ([]*Entry, error) {
+	attrList := make([][]byte, len(attrs))
+	for i, a := range attrs {
+		attrList[i] = []byte(a)
+	}
+	filter := asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 3, IsCompound: true, Bytes: concat([]byte(attr), []byte(value))}
+	id, err := c.send(asn1.RawValue{
+		Class:      asn1.ClassApplication,
+		Tag:        appSearchRequest,
+		IsCompound: true,
+		Bytes: concat(
+			[]byte(baseDN),
+			asn1.Enumerated(2), // scope: wholeSubtree
+			asn1.Enumerated(0), // derefAliases: neverDerefAliases
+			limit,
+			0,     // timeLimit
+			false, // typesOnly
+			filter,
+			attrList,
+		),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*Entry
+	for {
+		m, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		if m.Op.Class != asn1.ClassApplication {
+			return nil, fmt.Errorf("ldap: unexpected response to search (class %d)", m.Op.Class)
+		}
+		switch m.Op.Tag {
+		case appSearchResultEntry:
+			var se searchEntry
+			if _, err := asn1.UnmarshalWithParams(m.Op.FullBytes, &se, "application,tag:4"); err != nil {
+				return nil, fmt.Errorf("ldap: malformed search entry: %w", err)
+			}
+			entry := &Entry{DN: string(se.DN), Attributes: make(map[string][]string)}
+			for _, a := range se.Attributes {
+				name := strings.ToLower(string(a.Type))
+				for _, v := range a.Values {
+					entry.Attributes[name] = append(entry.Attributes[name], string(v))
+				}
+			}
+			entries = append(entries, entry)
+		case appSearchResultRef:
+			// Referrals to other servers are not followed.
+		case appSearchResultDone:
+			var res result
+			if _, err := asn1.UnmarshalWithParams(m.Op.FullBytes, &res, "application,tag:5"); err != nil {
+				return nil, fmt.Errorf("ldap: malformed search result: %w", err)
+			}
+			return entries, res.err()
+		default:
+			return nil, fmt.Errorf("ldap: unexpected response to search (tag %d)", m.Op.Tag)
+		}
+	}
+}
+
+// Config is the configuration of a directory that users are authenticated
+// against.
+type Config struct {
+	URL string // ldap://host[:port] or ldaps://host[:port].
+
+	// The DN and password that users are looked up as. If BindDN is empty,
+	// users are looked up anonymously.
+	BindDN       string
+	BindPassword string
+
+	BaseDN        string   // The subtree users are looked up in.
+	UserAttribute string   // The attribute of entries that usernames match (like uid).
+	Attributes    []string // The attributes of the entries returned by Authenticate.
+
+	TLSConfig *tls.Config
+	Timeout   time.Duration // Of each authentication. If zero, it's 10 seconds.
+}
+
+// ErrInvalidCredentials is returned by Authenticate if there's no user with
+// the username, or if the password is wrong.
+var ErrInvalidCredentials = errors.New("ldap: invalid credentials")
+
+// Authenticate looks up the entry of the user with username and checks their
+// password by binding as them. It returns the entry of the user.
+func (cfg *Config) Authenticate(ctx context.Context, username, password string) (*Entry, error) {
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := Dial(ctx, cfg.URL, cfg.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if cfg.BindDN != "" {
+		if err := conn.Bind(cfg.BindDN, cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("bind as %s: %w", cfg.BindDN, err)
+		}
+	}
+	entries, err := conn.SearchEqual(cfg.BaseDN, cfg.UserAttribute, username, cfg.Attributes, 2)
+	if err != nil {
+		var lerr *Error
+		if errors.As(err, &lerr) && lerr.ResultCode == ResultSizeLimitExceeded {
+			return nil, fmt.Errorf("ldap: more than one entry with %s=%s", cfg.UserAttribute, username)
+		}
+		return nil, err
+	}
+	switch len(entries) {
+	case 0:
+		return nil, ErrInvalidCredentials
+	case 1:
+	default:
+		return nil, fmt.Errorf("ldap: more than one entry with %s=%s", cfg.UserAttribute, username)
+	}
+
+	if err := conn.Bind(entries[0].DN, password); err != nil {
+		if IsInvalidCredentials(err) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	return entries[0], nil
+}
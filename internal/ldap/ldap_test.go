@@ -0,0 +1,178 @@
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"encoding/asn1"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeDirectory is an LDAP server with a few users, all of whose passwords
+// are "secret".
+type fakeDirectory struct {
+	entries []*Entry
+}
+
+func (d *fakeDirectory) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(id int32, op asn1.RawValue) {
+		b, err := asn1.Marshal(message{ID: id, Op: op})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Write(b)
+	}
+	result := func(tag int, code int) asn1.RawValue {
+		return asn1.RawValue{Class: asn1.ClassApplication, Tag: tag, IsCompound: true, Bytes: concat(asn1.Enumerated(code), []byte{}, []byte{})}
+	}
+	for {
+		data, err := readElement(r)
+		if err != nil {
+			return
+		}
+		var m message
+		if _, err := asn1.Unmarshal(data, &m); err != nil {
+			t.Error(err)
+			return
+		}
+		switch m.Op.Tag {
+		case appBindRequest:
+			var req struct {
+				Version int
+				DN      []byte
+				Auth    asn1.RawValue
+			}
+			if _, err := asn1.UnmarshalWithParams(m.Op.FullBytes, &req, "application,tag:0"); err != nil {
+				t.Error(err)
+				return
+			}
+			code := ResultInvalidCredentials
+			if string(req.DN) == "cn=admin,dc=example,dc=com" && string(req.Auth.Bytes) == "admin" {
+				code = ResultSuccess
+			}
+			for _, e := range d.entries {
+				if e.DN == string(req.DN) && string(req.Auth.Bytes) == "secret" {
+					code = ResultSuccess
+				}
+			}
+			reply(m.ID, result(appBindResponse, code))
+		case appSearchRequest:
+			var req struct {
+				BaseDN    []byte
+				Scope     asn1.Enumerated
+				Deref     asn1.Enumerated
+				SizeLimit int
+				TimeLimit int
+				TypesOnly bool
+				Filter    asn1.RawValue
+				Attrs     [][]byte
+			}
+			if _, err := asn1.UnmarshalWithParams(m.Op.FullBytes, &req, "application,tag:3"); err != nil {
+				t.Error(err)
+				return
+			}
+			var ava struct{ Attr, Value []byte }
+			if _, err := asn1.UnmarshalWithParams(req.Filter.FullBytes, &ava, "tag:3"); err != nil {
+				t.Error(err)
+				return
+			}
+			n := 0
+			for _, e := range d.entries {
+				if !strings.HasSuffix(e.DN, string(req.BaseDN)) || e.Get(string(ava.Attr)) != string(ava.Value) {
+					continue
+				}
+				if n++; n > req.SizeLimit {
+					break
+				}
+				var attrs []byte
+				for name, values := range e.Attributes {
+					vals := make([][]byte, len(values))
+					for i, v := range values {
+						vals[i] = []byte(v)
+					}
+					attrs = append(attrs, mustMarshal(struct {
+						Type   []byte
+						Values [][]byte `asn1:"set"`
+					}{[]byte(name), vals})...)
+				}
+				reply(m.ID, asn1.RawValue{
+					Class:      asn1.ClassApplication,
+					Tag:        appSearchResultEntry,
+					IsCompound: true,
+					Bytes:      append(mustMarshal([]byte(e.DN)), mustMarshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: attrs})...),
+				})
+			}
+			code := ResultSuccess
+			if n > req.SizeLimit {
+				code = ResultSizeLimitExceeded
+			}
+			reply(m.ID, result(appSearchResultDone, code))
+		case appUnbindRequest:
+			return
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	dir := &fakeDirectory{entries: []*Entry{
+		{DN: "uid=alice,ou=people,dc=example,dc=com", Attributes: map[string][]string{"uid": {"alice"}, "mail": {"alice@example.com"}}},
+		{DN: "uid=bob,ou=people,dc=example,dc=com", Attributes: map[string][]string{"uid": {"bob"}, "cn": {"Bob"}}},
+		{DN: "uid=bob,ou=staff,dc=example,dc=com", Attributes: map[string][]string{"uid": {"bob"}}},
+	}}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go dir.serve(t, conn)
+		}
+	}()
+
+	cfg := &Config{
+		URL:           "ldap://" + ln.Addr().String(),
+		BindDN:        "cn=admin,dc=example,dc=com",
+		BindPassword:  "admin",
+		BaseDN:        "dc=example,dc=com",
+		UserAttribute: "uid",
+	}
+	ctx := context.Background()
+
+	entry, err := cfg.Authenticate(ctx, "alice", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.DN != "uid=alice,ou=people,dc=example,dc=com" || entry.Get("Mail") != "alice@example.com" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+
+	for _, c := range []struct{ username, password string }{
+		{"alice", "wrong"},
+		{"alice", ""},
+		{"carol", "secret"},
+		{"", ""},
+	} {
+		if _, err := cfg.Authenticate(ctx, c.username, c.password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Authenticate(%q, %q): expected ErrInvalidCredentials, got %v", c.username, c.password, err)
+		}
+	}
+
+	if _, err := cfg.Authenticate(ctx, "bob", "secret"); err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected an ambiguity error for a username with two entries, got %v", err)
+	}
+
+	cfg.BindPassword = "wrong"
+	if _, err := cfg.Authenticate(ctx, "alice", "secret"); err == nil || errors.Is(err, ErrInvalidCredentials) || !IsInvalidCredentials(err) {
+		t.Errorf("expected a failed service bind, got %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Embeddings are the vectors of texts, in the order of the texts.
+type Embeddings struct {
+	Vectors      [][]float32
+	PromptTokens int
+}
+
+// An Embedder turns texts into vectors, so that the similarity of texts can
+// be measured (see Cosine).
+type Embedder interface {
+	Embed(ctx context.Context, model string, texts []string) (*Embeddings, error)
+}
+
+// Embed implements Embedder, with the embeddings API of OpenAI.
+func (p *OpenAI) Embed(ctx context.Context, model string, texts []string) (*Embeddings, error) {
+	apiKey := p.APIKey
+	if apiKey == "" {
+		if apiKey = os.Getenv("OPENAI_API_KEY"); apiKey == "" {
+			return nil, fmt.Errorf("OPENAI_API_KEY environment variable not set")
+		}
+	}
+	baseURL := p.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.openai.com/v1"
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	jsonBody, err := json.Marshal(map[string]any{"model": model, "input": texts})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", baseURL+"/embeddings", bytes.NewReader(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OpenAI API returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+		Usage struct {
+			PromptTokens int `json:"prompt_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	res := &Embeddings{Vectors: make([][]float32, len(texts)), PromptTokens: result.Usage.PromptTokens}
+	for _, d := range result.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return res, fmt.Errorf("embedding of unknown input %d", d.Index)
+		}
+		res.Vectors[d.Index] = d.Embedding
+	}
+	for i, v := range res.Vectors {
+		if v == nil {
+			return res, fmt.Errorf("no embedding of input %d", i)
+		}
+	}
+	return res, nil
+}
+
+// Local is an Embedder that makes no requests: it hashes the words, and the
+// pairs of adjacent words, of texts into vectors of Dimensions dimensions.
+// It catches texts that share most of their wording, not texts that only
+// share their meaning. The model is ignored.
+type Local struct {
+	Dimensions int // If 0, 512.
+}
+
+// Embed implements Embedder. Tokens are counted as words.
+func (l *Local) Embed(ctx context.Context, model string, texts []string) (*Embeddings, error) {
+	dims := l.Dimensions
+	if dims <= 0 {
+		dims = 512
+	}
+	res := &Embeddings{Vectors: make([][]float32, len(texts))}
+	for i, text := range texts {
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		res.PromptTokens += len(words)
+		v := make([]float32, dims)
+		add := func(feature string) {
+			h := fnv.New64a()
+			h.Write([]byte(feature))
+			sum := h.Sum64()
+			sign := float32(1)
+			if sum>>63 == 1 {
+				sign = -1
+			}
+			v[sum%uint64(dims)] += sign
+		}
+		for j, w := range words {
+			add(w)
+			if j > 0 {
+				add(words[j-1] + " " + w)
+			}
+		}
+		res.Vectors[i] = v
+	}
+	return res, nil
+}
+
+// Cosine returns the cosine similarity of a and b: 1 for vectors that point
+// the same way, 0 for orthogonal vectors, and 0 if either is zero or they're
+// of different lengths.
+func Cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
@@ -0,0 +1,52 @@
+package llm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOpenAIEmbed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/embeddings" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		// Out of order, as the API doesn't promise any.
+		w.Write([]byte(`{"data": [{"index": 1, "embedding": [0, 1]}, {"index": 0, "embedding": [1, 0]}], "usage": {"prompt_tokens": 4}}`))
+	}))
+	defer srv.Close()
+
+	p := &OpenAI{APIKey: "key", BaseURL: srv.URL}
+	res, err := p.Embed(context.Background(), "model", []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.PromptTokens != 4 || res.Vectors[0][0] != 1 || res.Vectors[1][1] != 1 {
+		t.Errorf("unexpected embeddings: %+v", res)
+	}
+	if _, err := p.Embed(context.Background(), "model", []string{"a", "b", "c"}); err == nil {
+		t.Error("no error for a missing embedding")
+	}
+}
+
+func TestLocalEmbed(t *testing.T) {
+	res, err := (&Local{}).Embed(context.Background(), "", []string{
+		"The city council voted to raise parking fees again this year.",
+		"The city council voted to raise the parking fees again, this year!",
+		"My tomatoes finally ripened after the long rainy summer.",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sim := Cosine(res.Vectors[0], res.Vectors[1]); sim < 0.8 {
+		t.Errorf("similarity of near-identical texts is %v, want at least 0.8", sim)
+	}
+	if sim := Cosine(res.Vectors[0], res.Vectors[2]); sim > 0.3 {
+		t.Errorf("similarity of unrelated texts is %v, want at most 0.3", sim)
+	}
+	if sim := Cosine(res.Vectors[0], nil); sim != 0 {
+		t.Errorf("similarity to a vector of another length is %v, want 0", sim)
+	}
+}
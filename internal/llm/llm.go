@@ -0,0 +1,139 @@
+// Package llm generates text with large language models.
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/discuitnet/discuit/internal/logging"
+)
+
+// Message is a message of a chat with a model.
+type Message struct {
+	Role    string `json:"role"` // system, user, or assistant.
+	Content string `json:"content"`
+}
+
+// Request is a request for a completion of a chat.
+type Request struct {
+	Model       string
+	Messages    []Message
+	Temperature *float64 // If nil, the default of the model.
+	MaxTokens   int
+}
+
+// Response is the completion of a chat.
+type Response struct {
+	Content          string
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// A Provider completes chats. On errors reported by the model after the
+// request is billed, Complete returns the response (with its usage) along
+// with the error.
+type Provider interface {
+	Complete(ctx context.Context, req *Request) (*Response, error)
+}
+
+// OpenAI is a Provider that uses the chat completions API of OpenAI.
+type OpenAI struct {
+	APIKey  string       // If empty, the OPENAI_API_KEY environment variable.
+	BaseURL string       // If empty, https://api.openai.com/v1.
+	Client  *http.Client // If nil, http.DefaultClient.
+}
+
+// Complete implements Provider.
+func (p *OpenAI) Complete(ctx context.Context, req *Request) (*Response, error) {
+	apiKey := p.APIKey
+	if apiKey == "" {
+		if apiKey = os.Getenv("OPENAI_API_KEY"); apiKey == "" {
+			return nil, fmt.Errorf("OPENAI_API_KEY environment variable not set")
+		}
+	}
+	baseURL := p.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.openai.com/v1"
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	reqBody := map[string]any{
+		"model":    req.Model,
+		"messages": req.Messages,
+	}
+	if req.MaxTokens > 0 {
+		reqBody["max_tokens"] = req.MaxTokens
+	}
+	if req.Temperature != nil {
+		reqBody["temperature"] = *req.Temperature
+	}
+	jsonBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", baseURL+"/chat/completions", bytes.NewReader(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+
+	logger := logging.FromContext(ctx).With("model", req.Model)
+	logger.Debug("Sending request to OpenAI API", "messages", len(req.Messages), "bytes", len(jsonBody))
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	logger.Debug("Received response from OpenAI API", "status", resp.StatusCode, "bytes", len(body))
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OpenAI API returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+		Error struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	res := &Response{
+		PromptTokens:     result.Usage.PromptTokens,
+		CompletionTokens: result.Usage.CompletionTokens,
+	}
+	if result.Error.Message != "" {
+		return res, fmt.Errorf("OpenAI API error: %s (%s)", result.Error.Message, result.Error.Type)
+	}
+	if len(result.Choices) == 0 {
+		return res, fmt.Errorf("no response from ChatGPT API")
+	}
+	res.Content = result.Choices[0].Message.Content
+	return res, nil
+}
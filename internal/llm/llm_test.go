@@ -0,0 +1,40 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOpenAI(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" || r.Header.Get("Authorization") != "Bearer key" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"choices": [{"message": {"content": "hi"}}], "usage": {"prompt_tokens": 3, "completion_tokens": 1}}`))
+	}))
+	defer srv.Close()
+
+	p := &OpenAI{APIKey: "key", BaseURL: srv.URL}
+	temperature := 0.2
+	res, err := p.Complete(context.Background(), &Request{
+		Model:       "model",
+		Messages:    []Message{{Role: "user", Content: "hello"}},
+		Temperature: &temperature,
+		MaxTokens:   10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Content != "hi" || res.PromptTokens != 3 || res.CompletionTokens != 1 {
+		t.Errorf("unexpected response: %+v", res)
+	}
+	if got["model"] != "model" || got["temperature"] != 0.2 || got["max_tokens"] != 10.0 {
+		t.Errorf("unexpected request: %v", got)
+	}
+}
@@ -0,0 +1,63 @@
+// Package llmtest provides a fake llm.Provider for tests.
+package llmtest
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/discuitnet/discuit/internal/llm"
+)
+
+// Fake is an llm.Provider that makes no requests, and records the ones made
+// to it. It's safe for concurrent use.
+type Fake struct {
+	// Respond returns the completion of req. If nil, the completion is
+	// "fake response".
+	Respond func(req *llm.Request) (string, error)
+
+	mu       sync.Mutex
+	requests []*llm.Request
+}
+
+// Complete implements llm.Provider. Tokens are counted as words.
+func (f *Fake) Complete(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	f.mu.Unlock()
+
+	content, err := "fake response", error(nil)
+	if f.Respond != nil {
+		content, err = f.Respond(req)
+	}
+	res := &llm.Response{Content: content, CompletionTokens: len(strings.Fields(content))}
+	for _, m := range req.Messages {
+		res.PromptTokens += len(strings.Fields(m.Content))
+	}
+	return res, err
+}
+
+// Requests returns the requests made to f so far.
+func (f *Fake) Requests() []*llm.Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*llm.Request(nil), f.requests...)
+}
+
+// LastPrompt returns the content of the last message of the last request made
+// to f, or the empty string if none were made.
+func (f *Fake) LastPrompt() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.requests) == 0 {
+		return ""
+	}
+	msgs := f.requests[len(f.requests)-1].Messages
+	if len(msgs) == 0 {
+		return ""
+	}
+	return msgs[len(msgs)-1].Content
+}
@@ -0,0 +1,179 @@
+// Package logging sets up the structured logger of the site (see log/slog),
+// which all logs go through, those of the log package included (at the info
+// level).
+//
+// Logs are written as text or as JSON, above a level that can be changed
+// while the site is running (see SetLevel). Secrets (passwords, tokens, API
+// keys, and so on) are redacted from them, whether they're attributes with
+// names like "password" or are part of messages (see Redact).
+//
+// The logger of a request (see FromContext) has the ID of the request (see
+// NewRequestID) as the request_id attribute, so that all the logs of a
+// request can be found.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Formats of logs.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var level = new(slog.LevelVar) // Info, by default.
+
+// ParseLevel parses a level: debug, info, warn, or error (case insensitive).
+// An empty string is info.
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level %q (must be debug, info, warn, or error)", s)
+}
+
+// ValidFormat reports whether format is a format of logs. An empty string is
+// FormatText.
+func ValidFormat(format string) bool {
+	switch format {
+	case "", FormatText, FormatJSON:
+		return true
+	}
+	return false
+}
+
+// NewHandler returns a handler that writes logs to w in format (FormatText,
+// if empty), redacted, above the level set by SetLevel.
+func NewHandler(w io.Writer, format string) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+	switch format {
+	case "", FormatText:
+		return slog.NewTextHandler(w, opts), nil
+	case FormatJSON:
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q (must be %s or %s)", format, FormatText, FormatJSON)
+}
+
+// Setup makes the logger writing to w in format the default logger (that of
+// both slog and the log package).
+func Setup(w io.Writer, format string) error {
+	h, err := NewHandler(w, format)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// SetLevel sets the level of logs below which they're dropped.
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+type ctxKey struct{}
+
+// WithLogger returns a copy of ctx with the logger l (see FromContext).
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns the logger of ctx, or the default logger if it has
+// none.
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx with the request ID id (see RequestID),
+// whose logger is that of ctx with id as its request_id attribute.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	return WithLogger(ctx, FromContext(ctx).With("request_id", id))
+}
+
+// RequestID returns the request ID of ctx, or an empty string if it has none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a new random ID for a request.
+func NewRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Redacted is what secrets are replaced with in logs.
+const Redacted = "[REDACTED]"
+
+// secretKeys are the substrings of the names of attributes whose values are
+// secrets.
+var secretKeys = []string{"password", "passwd", "secret", "token", "apikey", "api_key", "authorization", "cookie"}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range secretKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactAttr is the ReplaceAttr function of handlers (see
+// slog.HandlerOptions).
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if a.Key != slog.MessageKey && isSecretKey(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	if a.Value.Kind() == slog.KindString {
+		if s := a.Value.String(); s != "" {
+			return slog.String(a.Key, Redact(s))
+		}
+	}
+	return a
+}
+
+// secretPatterns are the patterns of secrets in text, with the replacements
+// of their matches.
+var secretPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`), "${1}" + Redacted},
+	{regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{8,}`), Redacted}, // OpenAI API keys.
+	{regexp.MustCompile(`(?i)((?:password|passwd|secret|token|api_?key)["']?\s*[:=]\s*["']?)[^\s"'&,]+`), "${1}" + Redacted},
+	{regexp.MustCompile(`(://[^:/@\s]+:)[^@/\s]+@`), "${1}" + Redacted + "@"},               // Passwords in URLs.
+	{regexp.MustCompile(`(\b[\w.-]+:)[^@/\s]+@(tcp|unix)\(`), "${1}" + Redacted + "@${2}("}, // In MySQL DSNs.
+}
+
+// Redact returns s with the secrets in it (bearer tokens, API keys, the
+// values of key=value pairs of passwords, and passwords in URLs and in MySQL
+// DSNs) replaced with Redacted.
+func Redact(s string) string {
+	for _, p := range secretPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
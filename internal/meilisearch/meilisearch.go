@@ -0,0 +1,151 @@
+// Package meilisearch is a client of the HTTP API of Meilisearch, or of the
+// little of it that the search of the site needs: the settings of indexes,
+// adding and deleting documents, and searching.
+//
+// Changes to indexes are done by Meilisearch asynchronously, as tasks; the
+// methods that make them return once the tasks are queued.
+package meilisearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client is a client of a Meilisearch server.
+type Client struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+// New returns a client of the Meilisearch server at baseURL, authenticated
+// with apiKey (if it's not empty). If client is nil, http.DefaultClient is
+// used.
+func New(baseURL, apiKey string, client *http.Client) *Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), apiKey: apiKey, client: client}
+}
+
+// Error is an error response of Meilisearch.
+type Error struct {
+	HTTPStatus int    `json:"-"`
+	Message    string `json:"message"`
+	Code       string `json:"code"`
+	Type       string `json:"type"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("meilisearch: %s (%s, HTTP %d)", e.Message, e.Code, e.HTTPStatus)
+}
+
+// do sends a request of method to path, with body, if it's not nil, encoded
+// in JSON, and decodes the response into out, if it's not nil.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		e := &Error{HTTPStatus: res.StatusCode}
+		data, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		if err := json.Unmarshal(data, e); err != nil || e.Message == "" {
+			e.Message = strings.TrimSpace(string(data))
+		}
+		return e
+	}
+	if out == nil {
+		_, err = io.Copy(io.Discard, res.Body)
+		return err
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func indexPath(index string) string {
+	return "/indexes/" + url.PathEscape(index)
+}
+
+// Settings are the settings of an index. Nil fields are left as they are.
+type Settings struct {
+	SearchableAttributes []string `json:"searchableAttributes,omitempty"`
+	FilterableAttributes []string `json:"filterableAttributes,omitempty"`
+	SortableAttributes   []string `json:"sortableAttributes,omitempty"`
+	RankingRules         []string `json:"rankingRules,omitempty"`
+}
+
+// UpdateSettings updates the settings of index, creating it if it doesn't
+// exist.
+func (c *Client) UpdateSettings(ctx context.Context, index string, s *Settings) error {
+	return c.do(ctx, "PATCH", indexPath(index)+"/settings", s, nil)
+}
+
+// AddDocuments adds docs, a slice of documents, to index, replacing those
+// with the same primary keys. Their primary key is the field primaryKey.
+func (c *Client) AddDocuments(ctx context.Context, index string, docs any, primaryKey string) error {
+	return c.do(ctx, "POST", indexPath(index)+"/documents?primaryKey="+url.QueryEscape(primaryKey), docs, nil)
+}
+
+// DeleteDocuments deletes the documents of index with the primary keys ids.
+func (c *Client) DeleteDocuments(ctx context.Context, index string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return c.do(ctx, "POST", indexPath(index)+"/documents/delete-batch", ids, nil)
+}
+
+// SearchRequest is a search of an index.
+type SearchRequest struct {
+	Query                string   `json:"q"`
+	Filter               string   `json:"filter,omitempty"`
+	Sort                 []string `json:"sort,omitempty"`
+	Offset               int      `json:"offset"`
+	Limit                int      `json:"limit"`
+	AttributesToRetrieve []string `json:"attributesToRetrieve,omitempty"`
+}
+
+// SearchResponse is the result of a search.
+type SearchResponse struct {
+	Hits               []json.RawMessage `json:"hits"`
+	EstimatedTotalHits int               `json:"estimatedTotalHits"`
+}
+
+// Search searches index.
+func (c *Client) Search(ctx context.Context, index string, req *SearchRequest) (*SearchResponse, error) {
+	res := &SearchResponse{}
+	if err := c.do(ctx, "POST", indexPath(index)+"/search", req, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Quote returns s as a string literal of filter expressions.
+func Quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
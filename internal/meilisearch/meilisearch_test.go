@@ -0,0 +1,112 @@
+package meilisearch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// request is a request received by the test server.
+type request struct {
+	method, uri, auth string
+	body              string
+}
+
+func newTestServer(t *testing.T, handler func(w http.ResponseWriter, r *request)) (*Client, *[]request) {
+	var reqs []request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req := request{method: r.Method, uri: r.URL.RequestURI(), auth: r.Header.Get("Authorization"), body: string(body)}
+		reqs = append(reqs, req)
+		handler(w, &req)
+	}))
+	t.Cleanup(srv.Close)
+	return New(srv.URL+"/", "key", nil), &reqs
+}
+
+func TestIndexing(t *testing.T) {
+	c, reqs := newTestServer(t, func(w http.ResponseWriter, r *request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"taskUid": 1}`))
+	})
+	ctx := context.Background()
+
+	if err := c.UpdateSettings(ctx, "posts", &Settings{FilterableAttributes: []string{"communityId"}}); err != nil {
+		t.Fatal(err)
+	}
+	docs := []map[string]any{{"id": "a", "title": "Hello"}}
+	if err := c.AddDocuments(ctx, "posts", docs, "id"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteDocuments(ctx, "posts", "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteDocuments(ctx, "posts"); err != nil { // Sends nothing.
+		t.Fatal(err)
+	}
+
+	want := []request{
+		{"PATCH", "/indexes/posts/settings", "Bearer key", `{"filterableAttributes":["communityId"]}`},
+		{"POST", "/indexes/posts/documents?primaryKey=id", "Bearer key", `[{"id":"a","title":"Hello"}]`},
+		{"POST", "/indexes/posts/documents/delete-batch", "Bearer key", `["a","b"]`},
+	}
+	if !reflect.DeepEqual(*reqs, want) {
+		t.Errorf("requests = %+v, want %+v", *reqs, want)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	c, reqs := newTestServer(t, func(w http.ResponseWriter, r *request) {
+		w.Write([]byte(`{"hits": [{"id": "b"}, {"id": "a"}], "estimatedTotalHits": 2}`))
+	})
+	res, err := c.Search(context.Background(), "posts", &SearchRequest{
+		Query:  "go",
+		Filter: "communityId = " + Quote("x"),
+		Sort:   []string{"createdAt:desc"},
+		Limit:  10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, hit := range res.Hits {
+		var doc struct{ ID string }
+		if err := json.Unmarshal(hit, &doc); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, doc.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"b", "a"}) || res.EstimatedTotalHits != 2 {
+		t.Errorf("got hits %v (total %d)", ids, res.EstimatedTotalHits)
+	}
+	wantBody := `{"q":"go","filter":"communityId = \"x\"","sort":["createdAt:desc"],"offset":0,"limit":10}`
+	if got := (*reqs)[0].body; got != wantBody {
+		t.Errorf("search body = %s, want %s", got, wantBody)
+	}
+}
+
+func TestError(t *testing.T) {
+	c, _ := newTestServer(t, func(w http.ResponseWriter, r *request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "Attribute communityId is not filterable.", "code": "invalid_search_filter", "type": "invalid_request"}`))
+	})
+	_, err := c.Search(context.Background(), "posts", &SearchRequest{Query: "go"})
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("got error %v, want an *Error", err)
+	}
+	if e.HTTPStatus != http.StatusBadRequest || e.Code != "invalid_search_filter" {
+		t.Errorf("got %+v", e)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if got, want := Quote(`a"b\c`), `"a\"b\\c"`; got != want {
+		t.Errorf("Quote = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,362 @@
+// Package metrics keeps counters and histograms of the workings of the site
+// (requests served, images transformed, queries run, and so on), and serves
+// them in the text exposition format of Prometheus (see Handler).
+//
+// Metrics are created once, at init time, by the packages that record them:
+//
+//	var requests = metrics.NewCounter("discuit_http_requests_total", "HTTP requests served.", "method", "code")
+//
+//	requests.Inc("GET", "200")
+//
+// Label values must be few (route templates, say, and not URLs), as each set
+// of them is a series of its own.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the default buckets of histograms of durations, in
+// seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// A Registry is a set of metrics.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// Default is the registry of the metrics created by the package-level
+// functions, which is the one served by Handler.
+var Default = NewRegistry()
+
+type metric interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[m.name()] {
+		panic("metrics: metric " + m.name() + " is already registered")
+	}
+	r.names[m.name()] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo writes the metrics of r to w, in the text exposition format of
+// Prometheus, sorted by name.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler returns a handler that serves the metrics of the Default registry.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		Default.WriteTo(w)
+	})
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// vec is what's common to metrics: a name, and the series of each set of
+// label values.
+type vec[T any] struct {
+	metricName string
+	help       string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*T // By the label values, joined by labelSep.
+	newT   func() *T
+}
+
+const labelSep = "\xff"
+
+// get returns the series of the label values lvs. If there's none, it
+// creates it if create is true, and returns nil otherwise. The caller must
+// hold v.mu.
+func (v *vec[T]) get(lvs []string, create bool) *T {
+	if len(lvs) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", v.metricName, len(v.labels), len(lvs)))
+	}
+	key := strings.Join(lvs, labelSep)
+	s := v.series[key]
+	if s == nil && create {
+		s = v.newT()
+		v.series[key] = s
+	}
+	return s
+}
+
+func (v *vec[T]) name() string {
+	return v.metricName
+}
+
+// each calls fn with the label values of each series of v, sorted, and the
+// series. The caller must hold v.mu.
+func (v *vec[T]) each(fn func(lvs []string, s *T)) {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var lvs []string
+		if len(v.labels) > 0 {
+			lvs = strings.Split(key, labelSep)
+		}
+		fn(lvs, v.series[key])
+	}
+}
+
+func (v *vec[T]) writeHeader(w *bufio.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.metricName, escapeHelp(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.metricName, typ)
+}
+
+// A Counter is a metric that only goes up (the number of requests served, say).
+type Counter struct {
+	vec[float64]
+}
+
+// NewCounter creates a counter, named name, with labels, in the Default
+// registry. It panics if there's a metric of the same name.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+// NewCounter creates a counter, named name, with labels, in r. It panics if
+// there's a metric of the same name.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec[float64]{
+		metricName: name,
+		help:       help,
+		labels:     labels,
+		series:     make(map[string]*float64),
+		newT:       func() *float64 { return new(float64) },
+	}}
+	r.register(c)
+	return c
+}
+
+// Inc adds 1 to the series of c of the label values lvs, which are in the
+// order of the labels of c.
+func (c *Counter) Inc(lvs ...string) {
+	c.Add(1, lvs...)
+}
+
+// Add adds n, which must not be negative, to the series of c of the label
+// values lvs.
+func (c *Counter) Add(n float64, lvs ...string) {
+	if n < 0 {
+		panic("metrics: counter " + c.metricName + " cannot decrease")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.get(lvs, true) += n
+}
+
+// Value returns the value of the series of c of the label values lvs.
+func (c *Counter) Value(lvs ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if v := c.get(lvs, false); v != nil {
+		return *v
+	}
+	return 0
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w, "counter")
+	c.each(func(lvs []string, v *float64) {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, formatLabels(c.labels, lvs, "", ""), formatValue(*v))
+	})
+}
+
+// A Histogram is a metric that counts observations (the durations of
+// requests, say) in buckets.
+type Histogram struct {
+	vec[histogramSeries]
+	buckets []float64 // Upper bounds, ascending, without +Inf.
+}
+
+type histogramSeries struct {
+	counts []uint64 // Of each bucket, not cumulative.
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates a histogram, named name, with labels, in the Default
+// registry. If buckets is nil, it's DefaultBuckets. It panics if there's a
+// metric of the same name.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+// NewHistogram creates a histogram, named name, with labels, in r. If
+// buckets is nil, it's DefaultBuckets. It panics if there's a metric of the
+// same name.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	if n := len(buckets); n > 0 && math.IsInf(buckets[n-1], 1) {
+		buckets = buckets[:n-1]
+	}
+	h := &Histogram{buckets: buckets}
+	h.vec = vec[histogramSeries]{
+		metricName: name,
+		help:       help,
+		labels:     labels,
+		series:     make(map[string]*histogramSeries),
+		newT: func() *histogramSeries {
+			return &histogramSeries{counts: make([]uint64, len(buckets)+1)}
+		},
+	}
+	r.register(h)
+	return h
+}
+
+// Observe adds the observation x to the series of h of the label values lvs.
+func (h *Histogram) Observe(x float64, lvs ...string) {
+	i := sort.SearchFloat64s(h.buckets, x) // The first bucket with an upper bound >= x.
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(lvs, true)
+	s.counts[i]++
+	s.sum += x
+	s.count++
+}
+
+// ObserveSince adds the seconds since start to the series of h of the label
+// values lvs.
+func (h *Histogram) ObserveSince(start time.Time, lvs ...string) {
+	h.Observe(time.Since(start).Seconds(), lvs...)
+}
+
+// Count returns the number of observations of the series of h of the label
+// values lvs.
+func (h *Histogram) Count(lvs ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s := h.get(lvs, false); s != nil {
+		return s.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w, "histogram")
+	h.each(func(lvs []string, s *histogramSeries) {
+		var n uint64
+		for i, count := range s.counts {
+			n += count
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = formatValue(h.buckets[i])
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, formatLabels(h.labels, lvs, "le", le), n)
+		}
+		labels := formatLabels(h.labels, lvs, "", "")
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, labels, formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, labels, s.count)
+	})
+}
+
+// formatLabels returns the labels with the values lvs, and the label extra
+// with the value extraValue, if extra is not empty, in braces.
+func formatLabels(labels, lvs []string, extra, extraValue string) string {
+	if len(labels) == 0 && extra == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, label := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(label)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(lvs[i]))
+		b.WriteByte('"')
+	}
+	if extra != "" {
+		if len(labels) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extra)
+		b.WriteString(`="`)
+		b.WriteString(extraValue)
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
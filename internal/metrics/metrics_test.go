@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("requests_total", "Requests served.", "method", "code")
+	durations := r.NewHistogram("duration_seconds", "Durations of \"things\".\nIn seconds.", []float64{1, 0.1}, "op")
+	r.NewCounter("unused_total", "Not counted yet.")
+
+	requests.Inc("GET", "200")
+	requests.Add(2, "GET", "200")
+	requests.Inc("POST", "500")
+	requests.Inc("GET", "a\"b\\c\nd")
+	durations.Observe(0.05, "read")
+	durations.Observe(0.1, "read")
+	durations.Observe(3, "read")
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP duration_seconds Durations of "things".\nIn seconds.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{op="read",le="0.1"} 2
+duration_seconds_bucket{op="read",le="1"} 2
+duration_seconds_bucket{op="read",le="+Inf"} 3
+duration_seconds_sum{op="read"} 3.15
+duration_seconds_count{op="read"} 3
+# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{method="GET",code="200"} 3
+requests_total{method="GET",code="a\"b\\c\nd"} 1
+requests_total{method="POST",code="500"} 1
+# HELP unused_total Not counted yet.
+# TYPE unused_total counter
+`
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if got := requests.Value("GET", "200"); got != 3 {
+		t.Errorf("requests.Value(GET, 200) = %v, want 3", got)
+	}
+	if got := durations.Count("write"); got != 0 {
+		t.Errorf("durations.Count(write) = %v, want 0", got)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("requests_total", "")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a metric twice did not panic")
+		}
+	}()
+	r.NewHistogram("requests_total", "", nil)
+}
@@ -0,0 +1 @@
+drop table push_devices;
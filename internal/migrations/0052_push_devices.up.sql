@@ -0,0 +1,14 @@
+create table if not exists push_devices (
+	id bigint unsigned not null auto_increment,
+	user_id binary (12) not null,
+	platform enum ('fcm', 'apns') not null,
+	token varchar (512) not null,
+	preferences json,
+	created_at datetime not null default current_timestamp(),
+	updated_at datetime,
+	last_used_at datetime not null default current_timestamp(),
+
+	primary key (id),
+	foreign key (user_id) references users (id),
+	unique (platform, token)
+);
@@ -0,0 +1,11 @@
+create table if not exists user_logins (
+	id bigint unsigned not null auto_increment,
+	user_id binary (12) not null,
+	ip varchar (45),
+	user_agent varchar (512),
+	created_at datetime not null default current_timestamp(),
+
+	primary key (id),
+	foreign key (user_id) references users (id),
+	index (user_id, created_at)
+);
@@ -0,0 +1 @@
+alter table images add column orientation tinyint unsigned not null default 1;
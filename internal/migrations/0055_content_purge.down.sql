@@ -0,0 +1,4 @@
+drop table legal_holds;
+
+alter table comments drop column purged_at;
+alter table posts drop column purged_at;
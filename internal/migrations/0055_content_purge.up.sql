@@ -0,0 +1,13 @@
+alter table posts add column purged_at datetime after deleted_content_as;
+alter table comments add column purged_at datetime after deleted_as;
+
+create table if not exists legal_holds (
+	id int unsigned not null auto_increment,
+	target_type enum ('user', 'post', 'comment') not null,
+	target_id binary (12) not null,
+	note text,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (id),
+	unique (target_type, target_id)
+);
@@ -0,0 +1,2 @@
+alter table communities drop column post_cooldown;
+alter table communities drop column comment_cooldown;
@@ -0,0 +1,2 @@
+alter table communities add column post_cooldown int unsigned not null default 0 after posting_restricted;
+alter table communities add column comment_cooldown int unsigned not null default 0 after post_cooldown;
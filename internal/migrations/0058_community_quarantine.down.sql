@@ -0,0 +1,4 @@
+drop table if exists quarantine_acknowledgements;
+
+alter table communities drop column quarantined_at;
+alter table communities drop column quarantine_reason;
@@ -0,0 +1,12 @@
+alter table communities add column quarantined_at datetime after comment_cooldown;
+alter table communities add column quarantine_reason text after quarantined_at;
+
+create table if not exists quarantine_acknowledgements (
+	community_id binary (12) not null,
+	user_id binary (12) not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (community_id, user_id),
+	foreign key (community_id) references communities (id) on delete cascade,
+	foreign key (user_id) references users (id) on delete cascade
+);
@@ -0,0 +1,8 @@
+drop index communities_purge_after on communities;
+
+alter table communities drop column archived_at;
+alter table communities drop column archived_by;
+alter table communities drop column purge_after;
+alter table communities drop column purge_scheduled_by;
+alter table communities drop column purge_started_at;
+alter table communities drop column purged_at;
@@ -0,0 +1,8 @@
+alter table communities add column archived_at datetime after quarantine_reason;
+alter table communities add column archived_by binary (12) after archived_at;
+alter table communities add column purge_after datetime after archived_by;
+alter table communities add column purge_scheduled_by binary (12) after purge_after;
+alter table communities add column purge_started_at datetime after purge_scheduled_by;
+alter table communities add column purged_at datetime after purge_started_at;
+
+create index communities_purge_after on communities (purge_after);
@@ -0,0 +1,17 @@
+create table if not exists image_moderation_rejections (
+	id int unsigned not null auto_increment,
+	hook varchar(64) not null,
+	reason text,
+	labels json,
+	image_hash binary (32) not null,
+	image_format varchar(16) not null,
+	reviewed_at datetime,
+	reviewed_by binary (12),
+	overturned bool not null default false,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (id),
+	key (image_hash),
+	key (reviewed_at),
+	foreign key (reviewed_by) references users (id) on delete set null
+);
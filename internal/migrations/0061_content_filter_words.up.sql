@@ -0,0 +1,13 @@
+create table if not exists content_filter_words (
+	id int unsigned not null auto_increment,
+	community_id binary (12),
+	word varchar(100) not null,
+	action varchar(16) not null,
+	created_by binary (12),
+	created_at datetime not null default current_timestamp(),
+
+	primary key (id),
+	key (community_id),
+	foreign key (community_id) references communities (id) on delete cascade,
+	foreign key (created_by) references users (id) on delete set null
+);
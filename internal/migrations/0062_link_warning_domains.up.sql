@@ -0,0 +1,23 @@
+create table if not exists link_warning_domains (
+	domain varchar(253) not null,
+	reason varchar(32) not null,
+	note text,
+	added_by binary (12),
+	created_at datetime not null default current_timestamp(),
+
+	primary key (domain),
+	foreign key (added_by) references users (id) on delete set null
+);
+
+insert ignore into link_warning_domains (domain, reason) values
+	("bit.ly", "shortener"),
+	("tinyurl.com", "shortener"),
+	("goo.gl", "shortener"),
+	("ow.ly", "shortener"),
+	("is.gd", "shortener"),
+	("buff.ly", "shortener"),
+	("rebrand.ly", "shortener"),
+	("cutt.ly", "shortener"),
+	("shorturl.at", "shortener"),
+	("tiny.cc", "shortener"),
+	("rb.gy", "shortener");
@@ -0,0 +1,3 @@
+alter table users drop column age_confirmed_at;
+
+alter table communities drop column age_restricted;
@@ -0,0 +1,3 @@
+alter table communities add column age_restricted bool not null default false after nsfw;
+
+alter table users add column age_confirmed_at datetime;
@@ -0,0 +1,17 @@
+create table if not exists api_keys (
+	id int not null auto_increment,
+	key_hash binary (32) not null,
+	key_prefix varchar(16) not null,
+	name varchar(255) not null,
+	contact varchar(255),
+	tier varchar(32) not null,
+	scopes varchar(255) not null,
+	created_by binary (12),
+	created_at datetime not null default current_timestamp(),
+	last_used_at datetime,
+	revoked_at datetime,
+
+	primary key (id),
+	unique key (key_hash),
+	foreign key (created_by) references users (id) on delete set null
+);
@@ -0,0 +1,3 @@
+alter table users drop column migrated_from;
+
+alter table users drop column moved_to;
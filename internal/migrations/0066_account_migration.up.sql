@@ -0,0 +1,3 @@
+alter table users add column moved_to varchar(2048);
+
+alter table users add column migrated_from varchar(2048);
@@ -0,0 +1,10 @@
+create table if not exists image_quotas (
+	owner_type varchar(16) not null,
+	owner_id binary (12) not null,
+	used_bytes bigint not null default 0,
+	quota_bytes bigint, /* If null, the default quota of the owner type applies. */
+	updated_at datetime not null default current_timestamp(),
+
+	primary key (owner_type, owner_id),
+	index (owner_type, used_bytes)
+);
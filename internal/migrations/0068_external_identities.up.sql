@@ -0,0 +1,11 @@
+create table if not exists external_identities (
+	provider varchar(16) not null,
+	subject varchar(512) not null,
+	user_id binary (12) not null,
+	created_at datetime not null default current_timestamp(),
+	last_login_at datetime,
+
+	primary key (provider, subject),
+	key (user_id),
+	foreign key (user_id) references users (id) on delete cascade
+);
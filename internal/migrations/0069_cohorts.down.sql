@@ -0,0 +1,7 @@
+drop table if exists cohort_members;
+
+drop table if exists cohort_sections;
+
+drop table if exists cohorts;
+
+alter table communities drop column private;
@@ -0,0 +1,43 @@
+alter table communities add column private bool not null default false after age_restricted;
+
+create table if not exists cohorts (
+	id binary (12) not null,
+	name varchar(255) not null,
+	created_by binary (12),
+	created_at datetime not null default current_timestamp(),
+
+	primary key (id),
+	unique key (name),
+	foreign key (created_by) references users (id) on delete set null
+);
+
+create table if not exists cohort_sections (
+	id binary (12) not null,
+	cohort_id binary (12) not null,
+	name varchar(255) not null,
+	community_id binary (12) not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (id),
+	unique key (cohort_id, name),
+	foreign key (cohort_id) references cohorts (id) on delete cascade,
+	foreign key (community_id) references communities (id) on delete cascade
+);
+
+create table if not exists cohort_members (
+	cohort_id binary (12) not null,
+	email varchar(255) not null,
+	user_id binary (12) not null,
+	section_id binary (12),
+	role varchar(16) not null,
+	invite_code varchar(64) not null,
+	claimed_at datetime,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (cohort_id, email),
+	unique key (invite_code),
+	key (user_id),
+	foreign key (cohort_id) references cohorts (id) on delete cascade,
+	foreign key (user_id) references users (id) on delete cascade,
+	foreign key (section_id) references cohort_sections (id) on delete set null
+);
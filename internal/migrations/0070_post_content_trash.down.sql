@@ -0,0 +1,3 @@
+drop index images_deleted_at on images;
+
+drop table if exists post_content_trash;
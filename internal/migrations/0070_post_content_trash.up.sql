@@ -0,0 +1,13 @@
+create table if not exists post_content_trash (
+	post_id binary (12) not null,
+	body text,
+	link_image binary (12),
+	images text not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (post_id),
+	key (created_at),
+	foreign key (post_id) references posts (id) on delete cascade
+);
+
+create index images_deleted_at on images (deleted_at);
@@ -0,0 +1 @@
+alter table cohorts drop column pseudonym_key;
@@ -0,0 +1 @@
+alter table cohorts add column pseudonym_key binary (32) after created_at;
@@ -0,0 +1,10 @@
+create table if not exists bot_schedule (
+	community_id binary (12) not null,
+	last_run_at datetime,
+	next_run_at datetime,
+	status varchar(16) not null,
+
+	primary key (community_id),
+	key (next_run_at),
+	foreign key (community_id) references communities (id) on delete cascade
+);
@@ -0,0 +1,5 @@
+alter table posts drop column author_anonymized;
+
+drop table if exists consent_records;
+
+drop table if exists consent_forms;
@@ -0,0 +1,26 @@
+create table if not exists consent_forms (
+	version int not null,
+	title varchar(255) not null,
+	body text not null,
+	created_by binary (12),
+	created_at datetime not null default current_timestamp(),
+
+	primary key (version),
+	foreign key (created_by) references users (id) on delete set null
+);
+
+create table if not exists consent_records (
+	id bigint unsigned not null auto_increment,
+	user_id binary (12) not null,
+	form_version int not null,
+	decision varchar(16) not null,
+	created_at datetime not null default current_timestamp(),
+	anonymized_at datetime,
+
+	primary key (id),
+	key (user_id, id),
+	foreign key (user_id) references users (id) on delete cascade,
+	foreign key (form_version) references consent_forms (version)
+);
+
+alter table posts add column author_anonymized bool not null default false after user_group;
@@ -0,0 +1,5 @@
+drop table if exists experiment_assignments;
+
+drop table if exists experiment_arms;
+
+drop table if exists experiments;
@@ -0,0 +1,36 @@
+create table if not exists experiments (
+	id binary (12) not null,
+	name varchar(255) not null,
+	cohort_id binary (12),
+	created_by binary (12),
+	created_at datetime not null default current_timestamp(),
+
+	primary key (id),
+	unique key (name),
+	foreign key (cohort_id) references cohorts (id) on delete cascade,
+	foreign key (created_by) references users (id) on delete set null
+);
+
+create table if not exists experiment_arms (
+	id binary (12) not null,
+	experiment_id binary (12) not null,
+	name varchar(255) not null,
+	weight int not null,
+
+	primary key (id),
+	unique key (experiment_id, name),
+	foreign key (experiment_id) references experiments (id) on delete cascade
+);
+
+create table if not exists experiment_assignments (
+	experiment_id binary (12) not null,
+	user_id binary (12) not null,
+	arm_id binary (12) not null,
+	assigned_at datetime not null default current_timestamp(),
+
+	primary key (experiment_id, user_id),
+	key (arm_id),
+	foreign key (experiment_id) references experiments (id) on delete cascade,
+	foreign key (user_id) references users (id) on delete cascade,
+	foreign key (arm_id) references experiment_arms (id) on delete cascade
+);
@@ -0,0 +1,3 @@
+drop table if exists survey_recipients;
+
+drop table if exists surveys;
@@ -0,0 +1,45 @@
+create table if not exists surveys (
+	id binary (12) not null,
+	title varchar(255) not null,
+	description text not null,
+	kind varchar(16) not null,
+	url varchar(2048),
+	questions json,
+	cohort_id binary (12),
+	section_id binary (12),
+	role varchar(16),
+	experiment_id binary (12),
+	arm_id binary (12),
+	opens_at datetime not null,
+	closes_at datetime,
+	reminder_interval int not null default 0, -- in hours
+	max_reminders int not null default 0,
+	delivered_at datetime,
+	created_by binary (12),
+	created_at datetime not null default current_timestamp(),
+
+	primary key (id),
+	foreign key (cohort_id) references cohorts (id) on delete cascade,
+	foreign key (section_id) references cohort_sections (id) on delete cascade,
+	foreign key (experiment_id) references experiments (id) on delete cascade,
+	foreign key (arm_id) references experiment_arms (id) on delete cascade,
+	foreign key (created_by) references users (id) on delete set null
+);
+
+create table if not exists survey_recipients (
+	survey_id binary (12) not null,
+	user_id binary (12) not null,
+	code varchar(32) not null,
+	notified_at datetime,
+	reminders int not null default 0,
+	reminded_at datetime,
+	completed_at datetime,
+	answers json,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (survey_id, user_id),
+	unique key (code),
+	key (user_id, completed_at),
+	foreign key (survey_id) references surveys (id) on delete cascade,
+	foreign key (user_id) references users (id) on delete cascade
+);
@@ -0,0 +1,18 @@
+create table if not exists community_bot_settings (
+	community_id binary (12) not null,
+	enabled bool not null default true,
+	posts_per_day int not null default 0, -- 0 is once every scheduling window.
+	min_toxicity int not null default 1,
+	max_toxicity int not null default 5,
+	trolling_styles json, -- Names of the allowed styles; null is all of them.
+	updated_by binary (12),
+	updated_at datetime not null default current_timestamp(),
+
+	primary key (community_id),
+	foreign key (community_id) references communities (id) on delete cascade,
+	foreign key (updated_by) references users (id) on delete set null
+);
+
+-- Bots used to skip the cs278 community by name.
+insert ignore into community_bot_settings (community_id, enabled)
+select id, false from communities where name_lc = 'cs278';
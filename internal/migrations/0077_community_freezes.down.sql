@@ -0,0 +1,3 @@
+drop table if exists community_freezes;
+
+drop table if exists experiment_phases;
@@ -0,0 +1,29 @@
+create table if not exists experiment_phases (
+	id binary (12) not null,
+	experiment_id binary (12) not null,
+	name varchar(255) not null,
+	starts_at datetime not null,
+	ends_at datetime not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (id),
+	unique key (experiment_id, name),
+	foreign key (experiment_id) references experiments (id) on delete cascade
+);
+
+create table if not exists community_freezes (
+	id binary (12) not null,
+	community_id binary (12) not null,
+	phase_id binary (12),
+	starts_at datetime not null,
+	ends_at datetime not null,
+	reason varchar(255),
+	created_by binary (12),
+	created_at datetime not null default current_timestamp(),
+
+	primary key (id),
+	key (community_id, ends_at),
+	foreign key (community_id) references communities (id) on delete cascade,
+	foreign key (phase_id) references experiment_phases (id) on delete cascade,
+	foreign key (created_by) references users (id) on delete set null
+);
@@ -0,0 +1,10 @@
+create table if not exists pseudonyms (
+	scope varchar(64) not null,
+	user_id binary (12) not null,
+	pseudonym varchar(32) not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (scope, user_id),
+	unique key (scope, pseudonym),
+	foreign key (user_id) references users (id) on delete cascade
+);
@@ -0,0 +1,3 @@
+drop table if exists bot_persona_users;
+
+drop table if exists bot_personas;
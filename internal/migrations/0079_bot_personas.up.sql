@@ -0,0 +1,26 @@
+create table if not exists bot_personas (
+	id binary (12) not null,
+	name varchar(64) not null,
+	system_prompt text not null,
+	style_constraints json,
+	temperature float not null default 1,
+	model varchar(64) not null,
+	created_by binary (12),
+	created_at datetime not null default current_timestamp(),
+	updated_at datetime,
+
+	primary key (id),
+	unique key (name),
+	foreign key (created_by) references users (id) on delete set null
+);
+
+create table if not exists bot_persona_users (
+	user_id binary (12) not null,
+	persona_id binary (12) not null,
+	assigned_at datetime not null default current_timestamp(),
+
+	primary key (user_id),
+	key (persona_id),
+	foreign key (user_id) references users (id) on delete cascade,
+	foreign key (persona_id) references bot_personas (id) on delete cascade
+);
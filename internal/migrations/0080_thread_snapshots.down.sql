@@ -0,0 +1 @@
+drop table if exists thread_snapshots;
@@ -0,0 +1,10 @@
+create table if not exists thread_snapshots (
+	id binary (12) not null,
+	post_id binary (12) not null,
+	taken_at datetime not null default current_timestamp(),
+	hash char(64) not null,
+	data longtext not null,
+
+	primary key (id),
+	key (post_id, taken_at)
+);
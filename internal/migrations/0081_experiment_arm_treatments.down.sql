@@ -0,0 +1,2 @@
+alter table experiment_arms drop column bot_exposure;
+alter table experiment_arms drop column feed_sort;
@@ -0,0 +1,2 @@
+alter table experiment_arms add column feed_sort varchar(16) after weight;
+alter table experiment_arms add column bot_exposure tinyint unsigned after feed_sort;
@@ -0,0 +1,2 @@
+drop table if exists bot_actions;
+alter table community_bot_settings drop column dry_run;
@@ -0,0 +1,20 @@
+alter table community_bot_settings add column dry_run bool not null default false after trolling_styles;
+
+create table if not exists bot_actions (
+	id binary (12) not null,
+	community_id binary (12) not null,
+	bot_id binary (12),
+	kind varchar(16) not null, -- post, comment, reply, or follow_up.
+	target_id binary (12), -- The post or comment responded to.
+	result_id binary (12), -- The post or comment made; null in dry runs.
+	toxicity int,
+	prompt text not null,
+	response text not null,
+	dry_run bool not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (id),
+	key (community_id, created_at),
+	foreign key (community_id) references communities (id) on delete cascade,
+	foreign key (bot_id) references users (id) on delete set null
+);
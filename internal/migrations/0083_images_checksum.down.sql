@@ -0,0 +1,2 @@
+drop index images_checksum on images;
+alter table images drop column checksum;
@@ -0,0 +1,2 @@
+alter table images add column checksum char(64) after blurhash;
+create index images_checksum on images (checksum);
@@ -0,0 +1,6 @@
+alter table bot_personas drop column reports_reset_at;
+alter table bot_personas drop column disabled_at;
+
+alter table bot_actions drop key result_id;
+
+drop table if exists bot_action_reports;
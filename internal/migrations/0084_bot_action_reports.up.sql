@@ -0,0 +1,15 @@
+create table if not exists bot_action_reports (
+	report_id int unsigned not null,
+	bot_action_id binary (12) not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (report_id),
+	key (bot_action_id),
+	foreign key (report_id) references reports (id) on delete cascade,
+	foreign key (bot_action_id) references bot_actions (id) on delete cascade
+);
+
+alter table bot_actions add key (result_id);
+
+alter table bot_personas add column disabled_at datetime after model;
+alter table bot_personas add column reports_reset_at datetime after disabled_at;
@@ -0,0 +1 @@
+alter table images add column phash bigint after checksum;
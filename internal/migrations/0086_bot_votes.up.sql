@@ -0,0 +1,18 @@
+create table if not exists bot_votes (
+	id binary (12) not null,
+	community_id binary (12) not null,
+	bot_id binary (12) not null,
+	post_id binary (12) not null,
+	up bool not null,
+	toxicity int not null, -- Of the community, at the time of the vote.
+	alignment int not null, -- Of the personas of the bot and of the author of the post: -1, 0, or 1.
+	dry_run bool not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (id),
+	key (bot_id, created_at),
+	key (community_id, created_at),
+	foreign key (community_id) references communities (id) on delete cascade,
+	foreign key (bot_id) references users (id) on delete cascade,
+	foreign key (post_id) references posts (id) on delete cascade
+);
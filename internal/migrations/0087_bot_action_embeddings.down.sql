@@ -0,0 +1 @@
+drop table if exists bot_action_embeddings;
@@ -0,0 +1,12 @@
+create table if not exists bot_action_embeddings (
+	bot_action_id binary (12) not null,
+	community_id binary (12) not null,
+	model varchar(64) not null,
+	vector blob not null, -- float32s, little-endian.
+	created_at datetime not null default current_timestamp(),
+
+	primary key (bot_action_id),
+	key (community_id, created_at),
+	foreign key (bot_action_id) references bot_actions (id) on delete cascade,
+	foreign key (community_id) references communities (id) on delete cascade
+);
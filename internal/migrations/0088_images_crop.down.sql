@@ -0,0 +1,3 @@
+alter table images drop foreign key images_fk_source_id;
+alter table images drop column source_id;
+alter table images drop column crop;
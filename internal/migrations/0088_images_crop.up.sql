@@ -0,0 +1,3 @@
+alter table images add column source_id binary (12) after orientation;
+alter table images add column crop varchar (64) after source_id;
+alter table images add constraint images_fk_source_id foreign key (source_id) references images (id) on delete set null;
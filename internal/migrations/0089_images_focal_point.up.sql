@@ -0,0 +1 @@
+alter table images add column focal_point varchar (32) after crop;
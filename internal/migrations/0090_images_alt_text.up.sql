@@ -0,0 +1 @@
+alter table images add column alt_text varchar (1024) after focal_point;
@@ -0,0 +1,2 @@
+drop table if exists bandwidth_rollups;
+drop table if exists image_bandwidth;
@@ -0,0 +1,28 @@
+/* The bytes served for each image, by day (in UTC). */
+create table if not exists image_bandwidth (
+	day date not null,
+	image_id binary (12) not null,
+	requests bigint not null default 0,
+	bytes bigint not null default 0,
+	external_requests bigint not null default 0, /* Of requests from pages of other sites. */
+	external_bytes bigint not null default 0,
+
+	primary key (day, image_id),
+	index (day, bytes),
+	index (day, external_bytes)
+);
+
+/* The bytes served for the images of users and communities, by day. */
+create table if not exists bandwidth_rollups (
+	day date not null,
+	owner_type varchar(16) not null,
+	owner_id binary (12) not null,
+	requests bigint not null default 0,
+	bytes bigint not null default 0,
+	external_requests bigint not null default 0,
+	external_bytes bigint not null default 0,
+
+	primary key (day, owner_type, owner_id),
+	index (day, owner_type, bytes),
+	index (owner_type, owner_id, day)
+);
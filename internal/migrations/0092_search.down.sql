@@ -0,0 +1,2 @@
+alter table comments drop index comments_search;
+alter table posts drop index posts_search;
@@ -0,0 +1,3 @@
+/* The FULLTEXT indexes posts and comments are searched with (see core.Search). */
+alter table posts add fulltext index posts_search (title, body);
+alter table comments add fulltext index comments_search (body);
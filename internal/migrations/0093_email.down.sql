@@ -0,0 +1,2 @@
+drop table if exists email_preferences;
+drop table if exists email_tokens;
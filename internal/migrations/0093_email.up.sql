@@ -0,0 +1,28 @@
+/* The tokens of email verification and password reset links (see
+core/email.go). Only a hash of each token is stored. */
+create table if not exists email_tokens (
+	id bigint unsigned not null auto_increment,
+	user_id binary (12) not null,
+	purpose enum ('verify_email', 'reset_password') not null,
+	token_hash binary (32) not null,
+	email varchar (320) not null,
+	created_at datetime not null default current_timestamp(),
+	expires_at datetime not null,
+	used_at datetime,
+
+	primary key (id),
+	foreign key (user_id) references users (id),
+	unique (token_hash),
+	index (user_id, purpose)
+);
+
+/* Users without a row have the default preferences. */
+create table if not exists email_preferences (
+	user_id binary (12) not null,
+	weekly_digest bool not null default false,
+	last_digest_at datetime,
+	updated_at datetime not null default current_timestamp(),
+
+	primary key (user_id),
+	foreign key (user_id) references users (id)
+);
@@ -0,0 +1,2 @@
+drop table if exists web_push_queue;
+alter table web_push_subscriptions drop column preferences;
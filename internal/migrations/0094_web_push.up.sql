@@ -0,0 +1,13 @@
+/* Which notifications are pushed to a subscription (as with push_devices). */
+alter table web_push_subscriptions add column preferences json after push_subscription;
+
+/* Notifications yet to be pushed to the browsers of their users. They're
+pushed in batches, a batch being the notifications of a user queued within a
+few seconds of each other. */
+create table if not exists web_push_queue (
+	user_id binary (12) not null,
+	notification_id bigint not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (user_id, notification_id)
+);
@@ -0,0 +1,158 @@
+// Package migrations contains the migrations of the database of the site, as
+// SQL files that are embedded in the binary (so that the binary can migrate a
+// database wherever it's run from).
+//
+// The files are named VERSION_NAME.up.sql and VERSION_NAME.down.sql, where
+// VERSION is a zero-padded number. The version of a database, and whether the
+// last migration run on it failed midway (in which case the database is
+// dirty), are kept in its schema_migrations table.
+package migrations
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+
+	gomigrate "github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/mysql"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// Dir is the directory of the migration files, relative to the root of the
+// repository.
+const Dir = "internal/migrations"
+
+//go:embed *.sql
+var files embed.FS
+
+// Migration is a migration of the database.
+type Migration struct {
+	Version uint   `json:"version"`
+	Name    string `json:"name"`
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// All returns all the migrations, in order.
+func All() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[uint]bool)
+	var list []Migration
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".up.sql")
+		if !ok {
+			continue
+		}
+		version, name, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration file %s has no name", e.Name())
+		}
+		n, err := strconv.ParseUint(version, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %s has no version", e.Name())
+		}
+		if seen[uint(n)] {
+			return nil, fmt.Errorf("migration version %d is repeated", n)
+		}
+		seen[uint(n)] = true
+		list = append(list, Migration{Version: uint(n), Name: name})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// A Migrator runs migrations on a database.
+type Migrator struct {
+	m *gomigrate.Migrate
+}
+
+// New returns a Migrator of the MySQL database of dsn. If logger is not nil,
+// the migrations run are logged to it.
+func New(dsn string, logger gomigrate.Logger) (*Migrator, error) {
+	src, err := iofs.New(files, ".")
+	if err != nil {
+		return nil, err
+	}
+	m, err := gomigrate.NewWithSourceInstance("iofs", src, "mysql://"+dsn)
+	if err != nil {
+		return nil, err
+	}
+	m.Log = logger
+	return &Migrator{m: m}, nil
+}
+
+// Up runs steps migrations up, or all those not yet run if steps is 0. It's
+// not an error if there are none to run.
+func (m *Migrator) Up(steps int) error {
+	if steps < 0 {
+		return errors.New("steps cannot be negative")
+	}
+	var err error
+	if steps == 0 {
+		err = m.m.Up()
+	} else {
+		err = m.m.Steps(steps)
+	}
+	if err == gomigrate.ErrNoChange {
+		err = nil
+	}
+	return err
+}
+
+// Down reverts the last steps migrations run.
+func (m *Migrator) Down(steps int) error {
+	if steps <= 0 {
+		return errors.New("steps must be positive")
+	}
+	return m.m.Steps(-steps)
+}
+
+// Force sets the version of the database to version, and marks it as not
+// dirty, without running any migrations. It's for when a migration failed
+// midway, and the database was fixed by hand.
+func (m *Migrator) Force(version int) error {
+	return m.m.Force(version)
+}
+
+// Status is the migration status of a database.
+type Status struct {
+	Version uint        `json:"version"` // 0 if no migrations are run.
+	Dirty   bool        `json:"dirty"`
+	Applied []Migration `json:"applied"`
+	Pending []Migration `json:"pending"`
+}
+
+// Status returns the migration status of the database.
+func (m *Migrator) Status() (*Status, error) {
+	all, err := All()
+	if err != nil {
+		return nil, err
+	}
+	s := &Status{}
+	if s.Version, s.Dirty, err = m.m.Version(); err != nil && err != gomigrate.ErrNilVersion {
+		return nil, err
+	}
+	for _, mg := range all {
+		if mg.Version <= s.Version {
+			s.Applied = append(s.Applied, mg)
+		} else {
+			s.Pending = append(s.Pending, mg)
+		}
+	}
+	return s, nil
+}
+
+// Close closes the connection of m to the database.
+func (m *Migrator) Close() error {
+	srcErr, dbErr := m.m.Close()
+	return errors.Join(srcErr, dbErr)
+}
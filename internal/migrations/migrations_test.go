@@ -0,0 +1,24 @@
+package migrations
+
+import (
+	"io/fs"
+	"testing"
+)
+
+func TestAll(t *testing.T) {
+	all, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) == 0 {
+		t.Fatal("no migrations")
+	}
+	for i, m := range all {
+		if m.Version != uint(i+1) {
+			t.Fatalf("migration %d is %s, want the versions to have no gaps", i, m)
+		}
+		if _, err := fs.Stat(files, m.String()+".down.sql"); err != nil {
+			t.Errorf("migration %s has no down migration: %v", m, err)
+		}
+	}
+}
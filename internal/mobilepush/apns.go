@@ -0,0 +1,147 @@
+package mobilepush
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	apnsProductionHost = "https://api.push.apple.com"
+	apnsSandboxHost    = "https://api.sandbox.push.apple.com"
+)
+
+// APNs sends messages using the token-based (.p8 key) provider API of the
+// Apple Push Notification service.
+type APNs struct {
+	host   string
+	keyID  string
+	teamID string
+	topic  string // The bundle ID of the app.
+	key    crypto.Signer
+
+	mu        sync.Mutex // guards the following
+	jwt       string
+	jwtIssued time.Time
+}
+
+// NewAPNs returns an APNs sender that signs its requests with the .p8 key at
+// keyFile. If production is false, the sandbox environment is used.
+func NewAPNs(keyFile, keyID, teamID, topic string, production bool) (*APNs, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &APNs{
+		host:   apnsSandboxHost,
+		keyID:  keyID,
+		teamID: teamID,
+		topic:  topic,
+		key:    key,
+	}
+	if production {
+		a.host = apnsProductionHost
+	}
+	return a, nil
+}
+
+// providerToken returns the JWT used for authenticating requests. Apple
+// rejects tokens older than an hour, and also those refreshed more often than
+// every 20 minutes, so a token is reused for 40 minutes.
+func (a *APNs) providerToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.jwt != "" && time.Since(a.jwtIssued) < time.Minute*40 {
+		return a.jwt, nil
+	}
+
+	now := time.Now()
+	token, err := signJWT(a.key, map[string]any{"kid": a.keyID}, map[string]any{
+		"iss": a.teamID,
+		"iat": now.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	a.jwt, a.jwtIssued = token, now
+	return token, nil
+}
+
+// Send implements Sender.
+func (a *APNs) Send(ctx context.Context, token string, m *Message) error {
+	jwt, err := a.providerToken()
+	if err != nil {
+		return err
+	}
+
+	payload := map[string]any{
+		"aps": map[string]any{
+			"alert": map[string]string{
+				"title": m.Title,
+				"body":  m.Body,
+			},
+			"sound": "default",
+		},
+	}
+	for key, val := range m.Data {
+		if key != "aps" {
+			payload[key] = val
+		}
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", a.host+"/3/device/"+token, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+jwt)
+	req.Header.Set("apns-topic", a.topic)
+	req.Header.Set("apns-push-type", "alert")
+	if m.CollapseKey != "" {
+		req.Header.Set("apns-collapse-id", m.CollapseKey)
+	}
+	if m.TTL > 0 {
+		req.Header.Set("apns-expiration", strconv.FormatInt(time.Now().Add(m.TTL).Unix(), 10))
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	body, _ := io.ReadAll(res.Body)
+	var apnsErr struct {
+		Reason string `json:"reason"`
+	}
+	json.Unmarshal(body, &apnsErr)
+	switch apnsErr.Reason {
+	case "BadDeviceToken", "Unregistered", "DeviceTokenNotForTopic":
+		return ErrTokenInvalid
+	}
+	if res.StatusCode == http.StatusGone {
+		return ErrTokenInvalid
+	}
+	return fmt.Errorf("apns send returned status %d: %s", res.StatusCode, apnsErr.Reason)
+}
@@ -0,0 +1,210 @@
+package mobilepush
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+// FCM sends messages using the Firebase Cloud Messaging HTTP v1 API.
+type FCM struct {
+	projectID   string
+	clientEmail string
+	tokenURI    string
+	key         crypto.Signer
+
+	mu          sync.Mutex // guards the following
+	accessToken string
+	expiresAt   time.Time
+}
+
+// NewFCM returns an FCM sender authenticated with the Google service account
+// JSON key file at credentialsFile. If projectID is empty, the project of the
+// service account is used.
+func NewFCM(credentialsFile, projectID string) (*FCM, error) {
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds struct {
+		ProjectID   string `json:"project_id"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("parsing fcm credentials file: %w", err)
+	}
+
+	key, err := parsePrivateKey([]byte(creds.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+
+	if projectID == "" {
+		projectID = creds.ProjectID
+	}
+	if projectID == "" {
+		return nil, errors.New("fcm project id is empty")
+	}
+	if creds.TokenURI == "" {
+		creds.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	return &FCM{
+		projectID:   projectID,
+		clientEmail: creds.ClientEmail,
+		tokenURI:    creds.TokenURI,
+		key:         key,
+	}, nil
+}
+
+// token returns a cached OAuth 2.0 access token, fetching a new one if the
+// cached one is about to expire.
+func (f *FCM) token(ctx context.Context) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.accessToken != "" && time.Until(f.expiresAt) > time.Minute {
+		return f.accessToken, nil
+	}
+
+	now := time.Now()
+	assertion, err := signJWT(f.key, map[string]any{}, map[string]any{
+		"iss":   f.clientEmail,
+		"scope": fcmScope,
+		"aud":   f.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	form.Set("assertion", assertion)
+	req, err := http.NewRequestWithContext(ctx, "POST", f.tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fcm oauth token request returned status %d: %s", res.StatusCode, string(body))
+	}
+
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return "", err
+	}
+
+	f.accessToken = out.AccessToken
+	f.expiresAt = now.Add(time.Duration(out.ExpiresIn) * time.Second)
+	return f.accessToken, nil
+}
+
+// Send implements Sender.
+func (f *FCM) Send(ctx context.Context, token string, m *Message) error {
+	accessToken, err := f.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	type androidConfig struct {
+		CollapseKey string `json:"collapse_key,omitempty"`
+		TTL         string `json:"ttl,omitempty"`
+	}
+	msg := struct {
+		Token        string            `json:"token"`
+		Notification map[string]string `json:"notification"`
+		Data         map[string]string `json:"data,omitempty"`
+		Android      androidConfig     `json:"android"`
+	}{
+		Token: token,
+		Notification: map[string]string{
+			"title": m.Title,
+			"body":  m.Body,
+		},
+		Data: m.Data,
+		Android: androidConfig{
+			CollapseKey: m.CollapseKey,
+		},
+	}
+	if m.TTL > 0 {
+		msg.Android.TTL = strconv.Itoa(int(m.TTL.Seconds())) + "s"
+	}
+
+	data, err := json.Marshal(map[string]any{"message": msg})
+	if err != nil {
+		return err
+	}
+
+	endpoint := "https://fcm.googleapis.com/v1/projects/" + url.PathEscape(f.projectID) + "/messages:send"
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	body, _ := io.ReadAll(res.Body)
+	var fcmErr struct {
+		Error struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+			Details []struct {
+				ErrorCode string `json:"errorCode"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	json.Unmarshal(body, &fcmErr)
+	for _, detail := range fcmErr.Error.Details {
+		// INVALID_ARGUMENT is not treated as an invalid token, since it's
+		// also returned for malformed messages.
+		if detail.ErrorCode == "UNREGISTERED" {
+			return ErrTokenInvalid
+		}
+	}
+	if res.StatusCode == http.StatusNotFound {
+		return ErrTokenInvalid
+	}
+	return fmt.Errorf("fcm send returned status %d (%s): %s", res.StatusCode, fcmErr.Error.Status, fcmErr.Error.Message)
+}
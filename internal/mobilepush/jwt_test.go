@@ -0,0 +1,69 @@
+package mobilepush
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestSignJWT(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := signJWT(ecKey, map[string]any{"kid": "ABC123"}, map[string]any{"iss": "TEAM"})
+	if err != nil {
+		t.Fatalf("signing ES256 jwt: %v", err)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected 3 parts in jwt, got %d", len(parts))
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sig) != 64 {
+		t.Fatalf("expected ES256 signature of 64 bytes, got %d", len(sig))
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(&ecKey.PublicKey, digest[:], r, s) {
+		t.Error("ES256 signature does not verify")
+	}
+
+	if _, err := signJWT(rsaKey, map[string]any{}, map[string]any{"iss": "a@b.c"}); err != nil {
+		t.Errorf("signing RS256 jwt: %v", err)
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if _, err := parsePrivateKey(data); err != nil {
+		t.Errorf("parsing valid key: %v", err)
+	}
+	if _, err := parsePrivateKey([]byte("not a key")); err == nil {
+		t.Error("expected error parsing invalid key")
+	}
+}
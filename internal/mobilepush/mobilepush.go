@@ -0,0 +1,45 @@
+// Package mobilepush delivers push notifications to native mobile apps through
+// Firebase Cloud Messaging (Android) and the Apple Push Notification service
+// (iOS).
+package mobilepush
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ErrTokenInvalid is returned by Sender.Send if the push service reports that
+// the device token is no longer valid (the app was uninstalled, the token
+// expired, or it never belonged to this app). Tokens for which this error is
+// returned should be deleted.
+var ErrTokenInvalid = errors.New("mobilepush: device token is invalid or unregistered")
+
+// Message is a push notification that's to be delivered to a single device.
+type Message struct {
+	Title string
+	Body  string
+
+	// Data is an arbitrary set of key-value pairs delivered to the app
+	// alongside the notification.
+	Data map[string]string
+
+	// Messages with the same CollapseKey replace each other on the device.
+	CollapseKey string
+
+	// TTL is how long the push service should keep trying to deliver the
+	// message. If zero, the push service's default is used.
+	TTL time.Duration
+}
+
+// A Sender delivers messages to devices of a single push service.
+type Sender interface {
+	Send(ctx context.Context, token string, m *Message) error
+}
+
+var httpClient = &http.Client{
+	// The default transport is used so that HTTP/2, which APNs requires, is
+	// negotiated automatically.
+	Timeout: time.Second * 10,
+}
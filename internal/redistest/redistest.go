@@ -0,0 +1,721 @@
+// Package redistest provides an in-memory fake of a Redis server for tests.
+//
+// The fake understands the commands the site uses: of strings (GET, SET with
+// EX, PX, and NX, DEL, EXISTS, INCR, INCRBY, DECR, EXPIRE, and TTL), of lists
+// (LPUSH, RPUSH, LPOP, RPOP, LLEN, LRANGE, and LTRIM), of sorted sets (ZADD
+// with NX, ZREM, ZCARD, and ZRANGEBYSCORE with LIMIT), of pub/sub (PUBLISH,
+// SUBSCRIBE, and UNSUBSCRIBE), and of transactions (MULTI, EXEC, DISCARD,
+// WATCH, and UNWATCH), along with PING. Scripts are not supported.
+package redistest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/clock"
+	"github.com/gomodule/redigo/redis"
+)
+
+var errWrongType = redis.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// Server is an in-memory Redis server. It's safe for concurrent use.
+type Server struct {
+	Clock clock.Clock // Keys expire by it. Defaults to the real clock.
+
+	mu      sync.Mutex
+	values  map[string]any // []byte, [][]byte, or map[string]float64
+	expires map[string]time.Time
+	subs    map[string]map[*conn]bool
+
+	// The number of times each key was written, by which transactions of
+	// connections that watch the key are aborted. A key is taken to be
+	// written by any command that may change it, even if it doesn't.
+	versions map[string]uint64
+}
+
+// NewServer returns an empty server.
+func NewServer() *Server {
+	return &Server{
+		Clock:    clock.Real{},
+		values:   make(map[string]any),
+		expires:  make(map[string]time.Time),
+		subs:     make(map[string]map[*conn]bool),
+		versions: make(map[string]uint64),
+	}
+}
+
+// Conn returns a new connection to s.
+func (s *Server) Conn() redis.Conn {
+	c := &conn{s: s, channels: make(map[string]bool)}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Pool returns a pool of connections to s.
+func (s *Server) Pool() *redis.Pool {
+	return &redis.Pool{
+		Dial: func() (redis.Conn, error) { return s.Conn(), nil },
+	}
+}
+
+// FlushAll deletes all keys of s.
+func (s *Server) FlushAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.values {
+		s.versions[key]++
+	}
+	s.values = make(map[string]any)
+	s.expires = make(map[string]time.Time)
+}
+
+// get returns the value of key, deleting it first if it expired. s.mu must be
+// held.
+func (s *Server) get(key string) any {
+	if at, ok := s.expires[key]; ok && !s.Clock.Now().Before(at) {
+		delete(s.values, key)
+		delete(s.expires, key)
+	}
+	return s.values[key]
+}
+
+func (s *Server) del(key string) bool {
+	_, ok := s.values[key]
+	delete(s.values, key)
+	delete(s.expires, key)
+	return ok
+}
+
+func (s *Server) list(key string) ([][]byte, error) {
+	switch v := s.get(key).(type) {
+	case nil:
+		return nil, nil
+	case [][]byte:
+		return v, nil
+	}
+	return nil, errWrongType
+}
+
+func (s *Server) zset(key string, create bool) (map[string]float64, error) {
+	switch v := s.get(key).(type) {
+	case nil:
+		if !create {
+			return nil, nil
+		}
+		z := make(map[string]float64)
+		s.values[key] = z
+		return z, nil
+	case map[string]float64:
+		return v, nil
+	}
+	return nil, errWrongType
+}
+
+// do runs the command cmd, with args, for c.
+func (s *Server) do(c *conn, cmd string, args []string) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.run(c, cmd, args)
+}
+
+// exec runs the commands queued by c in a transaction, or returns nil if a
+// key c watches was written since it was watched.
+func (s *Server) exec(c *conn, queued [][]string) []any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	watched := c.watched
+	c.watched = nil
+	for key, version := range watched {
+		if s.versions[key] != version {
+			return nil
+		}
+	}
+	replies := make([]any, len(queued))
+	for i, q := range queued {
+		reply, err := s.run(c, q[0], q[1:])
+		if err != nil {
+			reply = err
+		}
+		replies[i] = reply
+	}
+	return replies
+}
+
+// run runs the command cmd, with args, for c. s.mu must be held.
+func (s *Server) run(c *conn, cmd string, args []string) (any, error) {
+	switch cmd {
+	case "SET", "INCR", "DECR", "INCRBY", "EXPIRE", "LPUSH", "RPUSH", "LPOP", "RPOP", "LTRIM", "ZADD", "ZREM":
+		if len(args) > 0 {
+			s.versions[args[0]]++
+		}
+	case "DEL":
+		for _, key := range args {
+			s.versions[key]++
+		}
+	}
+
+	arity := func(n int) error {
+		if len(args) < n {
+			return redis.Error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+		}
+		return nil
+	}
+
+	switch cmd {
+	case "PING":
+		if len(c.channels) > 0 {
+			data := ""
+			if len(args) > 0 {
+				data = args[0]
+			}
+			return []any{[]byte("pong"), []byte(data)}, nil
+		}
+		if len(args) > 0 {
+			return []byte(args[0]), nil
+		}
+		return "PONG", nil
+
+	case "GET":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		switch v := s.get(args[0]).(type) {
+		case nil:
+			return nil, nil
+		case []byte:
+			return v, nil
+		}
+		return nil, errWrongType
+
+	case "SET":
+		if err := arity(2); err != nil {
+			return nil, err
+		}
+		var expireAt time.Time
+		nx := false
+		for i := 2; i < len(args); i++ {
+			switch opt := strings.ToUpper(args[i]); opt {
+			case "NX":
+				nx = true
+			case "EX", "PX":
+				if i+1 >= len(args) {
+					return nil, redis.Error("ERR syntax error")
+				}
+				n, err := strconv.ParseInt(args[i+1], 10, 64)
+				if err != nil {
+					return nil, redis.Error("ERR value is not an integer or out of range")
+				}
+				unit := time.Second
+				if opt == "PX" {
+					unit = time.Millisecond
+				}
+				expireAt = s.Clock.Now().Add(time.Duration(n) * unit)
+				i++
+			default:
+				return nil, redis.Error("ERR syntax error")
+			}
+		}
+		if nx && s.get(args[0]) != nil {
+			return nil, nil
+		}
+		s.del(args[0])
+		s.values[args[0]] = []byte(args[1])
+		if !expireAt.IsZero() {
+			s.expires[args[0]] = expireAt
+		}
+		return "OK", nil
+
+	case "DEL", "EXISTS":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		var n int64
+		for _, key := range args {
+			if s.get(key) == nil {
+				continue
+			}
+			if cmd == "DEL" {
+				s.del(key)
+			}
+			n++
+		}
+		return n, nil
+
+	case "INCR", "DECR", "INCRBY":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		by := int64(1)
+		if cmd == "DECR" {
+			by = -1
+		} else if cmd == "INCRBY" {
+			if err := arity(2); err != nil {
+				return nil, err
+			}
+			var err error
+			if by, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+				return nil, redis.Error("ERR value is not an integer or out of range")
+			}
+		}
+		var n int64
+		switch v := s.get(args[0]).(type) {
+		case nil:
+		case []byte:
+			var err error
+			if n, err = strconv.ParseInt(string(v), 10, 64); err != nil {
+				return nil, redis.Error("ERR value is not an integer or out of range")
+			}
+		default:
+			return nil, errWrongType
+		}
+		n += by
+		s.values[args[0]] = []byte(strconv.FormatInt(n, 10))
+		return n, nil
+
+	case "EXPIRE":
+		if err := arity(2); err != nil {
+			return nil, err
+		}
+		secs, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return nil, redis.Error("ERR value is not an integer or out of range")
+		}
+		if s.get(args[0]) == nil {
+			return int64(0), nil
+		}
+		s.expires[args[0]] = s.Clock.Now().Add(time.Duration(secs) * time.Second)
+		return int64(1), nil
+
+	case "TTL":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		if s.get(args[0]) == nil {
+			return int64(-2), nil
+		}
+		at, ok := s.expires[args[0]]
+		if !ok {
+			return int64(-1), nil
+		}
+		return int64(math.Ceil(at.Sub(s.Clock.Now()).Seconds())), nil
+
+	case "LPUSH", "RPUSH":
+		if err := arity(2); err != nil {
+			return nil, err
+		}
+		l, err := s.list(args[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range args[1:] {
+			if cmd == "LPUSH" {
+				l = append([][]byte{[]byte(v)}, l...)
+			} else {
+				l = append(l, []byte(v))
+			}
+		}
+		s.values[args[0]] = l
+		return int64(len(l)), nil
+
+	case "LPOP", "RPOP":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		l, err := s.list(args[0])
+		if err != nil || len(l) == 0 {
+			return nil, err
+		}
+		var v []byte
+		if cmd == "LPOP" {
+			v, l = l[0], l[1:]
+		} else {
+			v, l = l[len(l)-1], l[:len(l)-1]
+		}
+		if len(l) == 0 {
+			s.del(args[0])
+		} else {
+			s.values[args[0]] = l
+		}
+		return v, nil
+
+	case "LLEN":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		l, err := s.list(args[0])
+		return int64(len(l)), err
+
+	case "LRANGE", "LTRIM":
+		if err := arity(3); err != nil {
+			return nil, err
+		}
+		l, err := s.list(args[0])
+		if err != nil {
+			return nil, err
+		}
+		start, err1 := strconv.Atoi(args[1])
+		stop, err2 := strconv.Atoi(args[2])
+		if err1 != nil || err2 != nil {
+			return nil, redis.Error("ERR value is not an integer or out of range")
+		}
+		from, to := listRange(len(l), start, stop)
+		if cmd == "LTRIM" {
+			if from >= to {
+				s.del(args[0])
+			} else {
+				s.values[args[0]] = append([][]byte(nil), l[from:to]...)
+			}
+			return "OK", nil
+		}
+		items := []any{}
+		for _, v := range l[from:to] {
+			items = append(items, v)
+		}
+		return items, nil
+
+	case "ZADD":
+		if err := arity(3); err != nil {
+			return nil, err
+		}
+		key, nx := args[0], strings.ToUpper(args[1]) == "NX"
+		if nx {
+			args = append([]string{key}, args[2:]...)
+		}
+		if len(args) < 3 || len(args[1:])%2 != 0 {
+			return nil, redis.Error("ERR syntax error")
+		}
+		z, err := s.zset(key, true)
+		if err != nil {
+			return nil, err
+		}
+		var added int64
+		for i := 1; i < len(args); i += 2 {
+			score, err := strconv.ParseFloat(args[i], 64)
+			if err != nil {
+				return nil, redis.Error("ERR value is not a valid float")
+			}
+			if _, ok := z[args[i+1]]; !ok {
+				added++
+			} else if nx {
+				continue
+			}
+			z[args[i+1]] = score
+		}
+		return added, nil
+
+	case "ZREM":
+		if err := arity(2); err != nil {
+			return nil, err
+		}
+		z, err := s.zset(args[0], false)
+		if err != nil {
+			return nil, err
+		}
+		var n int64
+		for _, m := range args[1:] {
+			if _, ok := z[m]; ok {
+				delete(z, m)
+				n++
+			}
+		}
+		if z != nil && len(z) == 0 {
+			s.del(args[0])
+		}
+		return n, nil
+
+	case "ZCARD":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		z, err := s.zset(args[0], false)
+		return int64(len(z)), err
+
+	case "ZRANGEBYSCORE":
+		if err := arity(3); err != nil {
+			return nil, err
+		}
+		z, err := s.zset(args[0], false)
+		if err != nil {
+			return nil, err
+		}
+		min, err1 := parseScore(args[1])
+		max, err2 := parseScore(args[2])
+		if err1 != nil || err2 != nil {
+			return nil, redis.Error("ERR min or max is not a float")
+		}
+		offset, count := 0, -1
+		if len(args) > 3 {
+			if len(args) != 6 || strings.ToUpper(args[3]) != "LIMIT" {
+				return nil, redis.Error("ERR syntax error")
+			}
+			offset, err1 = strconv.Atoi(args[4])
+			count, err2 = strconv.Atoi(args[5])
+			if err1 != nil || err2 != nil {
+				return nil, redis.Error("ERR value is not an integer or out of range")
+			}
+		}
+		members := make([]string, 0, len(z))
+		for m, score := range z {
+			if score >= min && score <= max {
+				members = append(members, m)
+			}
+		}
+		sort.Slice(members, func(i, j int) bool {
+			if z[members[i]] != z[members[j]] {
+				return z[members[i]] < z[members[j]]
+			}
+			return members[i] < members[j]
+		})
+		items := []any{}
+		for i := offset; i < len(members) && (count < 0 || i < offset+count); i++ {
+			items = append(items, []byte(members[i]))
+		}
+		return items, nil
+
+	case "WATCH":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		if c.watched == nil {
+			c.watched = make(map[string]uint64)
+		}
+		for _, key := range args {
+			if _, ok := c.watched[key]; !ok {
+				c.watched[key] = s.versions[key]
+			}
+		}
+		return "OK", nil
+
+	case "UNWATCH":
+		c.watched = nil
+		return "OK", nil
+
+	case "PUBLISH":
+		if err := arity(2); err != nil {
+			return nil, err
+		}
+		var n int64
+		for sub := range s.subs[args[0]] {
+			sub.push([]any{[]byte("message"), []byte(args[0]), []byte(args[1])})
+			n++
+		}
+		return n, nil
+
+	case "SUBSCRIBE", "UNSUBSCRIBE":
+		channels := args
+		if cmd == "UNSUBSCRIBE" && len(channels) == 0 {
+			for ch := range c.channels {
+				channels = append(channels, ch)
+			}
+		}
+		for _, ch := range channels {
+			if cmd == "SUBSCRIBE" {
+				if s.subs[ch] == nil {
+					s.subs[ch] = make(map[*conn]bool)
+				}
+				s.subs[ch][c] = true
+				c.channels[ch] = true
+			} else {
+				delete(s.subs[ch], c)
+				delete(c.channels, ch)
+			}
+			c.push([]any{[]byte(strings.ToLower(cmd)), []byte(ch), int64(len(c.channels))})
+		}
+		return nil, errNoReply
+	}
+	return nil, redis.Error(fmt.Sprintf("ERR unknown command '%s'", cmd))
+}
+
+// errNoReply is returned by Server.do for commands the replies of which are
+// received (with Receive) rather than returned.
+var errNoReply = errors.New("no reply")
+
+// listRange returns the bounds of the slice of a list of length n from start
+// to stop, which are inclusive and may be negative, as in LRANGE.
+func listRange(n, start, stop int) (int, int) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	start = max(start, 0)
+	stop = min(stop+1, n)
+	if start >= stop {
+		return 0, 0
+	}
+	return start, stop
+}
+
+func parseScore(s string) (float64, error) {
+	switch s {
+	case "-inf":
+		return math.Inf(-1), nil
+	case "+inf", "inf":
+		return math.Inf(1), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// conn is a connection to a Server.
+type conn struct {
+	s *Server
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	replies  []any // Pending replies, to be received.
+	closed   bool
+	multi    [][]string // Commands queued by MULTI; nil if not in a transaction.
+	channels map[string]bool
+
+	watched map[string]uint64 // Versions of the keys watched. Guarded by s.mu.
+}
+
+func (c *conn) push(reply any) {
+	c.mu.Lock()
+	c.replies = append(c.replies, reply)
+	c.mu.Unlock()
+	c.cond.Broadcast()
+}
+
+func (c *conn) Close() error {
+	c.s.mu.Lock()
+	for ch := range c.channels {
+		delete(c.s.subs[ch], c)
+	}
+	c.s.mu.Unlock()
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.cond.Broadcast()
+	return nil
+}
+
+func (c *conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errors.New("redistest: connection closed")
+	}
+	return nil
+}
+
+// run runs cmd, and returns its reply (or the error it's replied with).
+func (c *conn) run(cmd string, args []any) (any, error) {
+	cmd = strings.ToUpper(cmd)
+	strArgs := make([]string, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case []byte:
+			strArgs[i] = string(v)
+		case string:
+			strArgs[i] = v
+		case redis.Argument:
+			strArgs[i] = fmt.Sprint(v.RedisArg())
+		default:
+			strArgs[i] = fmt.Sprint(v)
+		}
+	}
+
+	c.mu.Lock()
+	inMulti := c.multi != nil
+	c.mu.Unlock()
+	switch {
+	case cmd == "MULTI":
+		if inMulti {
+			return nil, redis.Error("ERR MULTI calls can not be nested")
+		}
+		c.mu.Lock()
+		c.multi = [][]string{}
+		c.mu.Unlock()
+		return "OK", nil
+	case cmd == "DISCARD" || cmd == "EXEC":
+		if !inMulti {
+			return nil, redis.Error(fmt.Sprintf("ERR %s without MULTI", cmd))
+		}
+		c.mu.Lock()
+		queued := c.multi
+		c.multi = nil
+		c.mu.Unlock()
+		if cmd == "DISCARD" {
+			return c.s.do(c, "UNWATCH", nil)
+		}
+		if replies := c.s.exec(c, queued); replies != nil {
+			return replies, nil
+		}
+		return nil, nil // Aborted.
+	case inMulti && cmd == "WATCH":
+		return nil, redis.Error("ERR WATCH inside MULTI is not allowed")
+	case inMulti:
+		c.mu.Lock()
+		c.multi = append(c.multi, append([]string{cmd}, strArgs...))
+		c.mu.Unlock()
+		return "QUEUED", nil
+	}
+	return c.s.do(c, cmd, strArgs)
+}
+
+func (c *conn) Send(cmd string, args ...any) error {
+	if err := c.Err(); err != nil {
+		return err
+	}
+	reply, err := c.run(cmd, args)
+	if err == errNoReply {
+		return nil
+	}
+	if err != nil {
+		reply = err
+	}
+	c.push(reply)
+	return nil
+}
+
+func (c *conn) Flush() error {
+	return c.Err()
+}
+
+// Receive returns the next pending reply, waiting for one (a pub/sub message)
+// if there's none.
+func (c *conn) Receive() (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.replies) == 0 && !c.closed {
+		c.cond.Wait()
+	}
+	if len(c.replies) == 0 {
+		return nil, errors.New("redistest: connection closed")
+	}
+	reply := c.replies[0]
+	c.replies = c.replies[1:]
+	if err, ok := reply.(redis.Error); ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// Do runs cmd, after discarding the pending replies, and returns its reply. If
+// cmd is empty, Do returns the pending replies instead.
+func (c *conn) Do(cmd string, args ...any) (any, error) {
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	pending := c.replies
+	c.replies = nil
+	c.mu.Unlock()
+	if cmd == "" {
+		return pending, nil
+	}
+
+	reply, err := c.run(cmd, args)
+	if err == errNoReply {
+		return c.Receive()
+	}
+	return reply, err
+}
@@ -0,0 +1,114 @@
+package redistest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/clock"
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestServer(t *testing.T) {
+	s := NewServer()
+	fake := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	s.Clock = fake
+	conn := s.Conn()
+	defer conn.Close()
+
+	if _, err := conn.Do("SET", "a", "1", "EX", 10); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := redis.Int(conn.Do("INCR", "a")); err != nil || n != 2 {
+		t.Fatalf("INCR = %d, %v; want 2", n, err)
+	}
+	if ttl, _ := redis.Int(conn.Do("TTL", "a")); ttl != 10 {
+		t.Errorf("TTL = %d, want 10", ttl)
+	}
+	fake.Advance(time.Second * 10)
+	if _, err := redis.String(conn.Do("GET", "a")); err != redis.ErrNil {
+		t.Errorf("GET of an expired key: %v, want ErrNil", err)
+	}
+
+	conn.Send("MULTI")
+	conn.Send("RPUSH", "l", "x", "y", "z")
+	conn.Send("LTRIM", "l", 1, -1)
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err != nil || len(replies) != 2 {
+		t.Fatalf("EXEC = %v, %v", replies, err)
+	}
+	if items, _ := redis.Strings(conn.Do("LRANGE", "l", 0, -1)); len(items) != 2 || items[0] != "y" {
+		t.Errorf("LRANGE = %v, want [y z]", items)
+	}
+
+	conn.Do("ZADD", "z", 3, "c", 1, "a", 2, "b")
+	if items, _ := redis.Strings(conn.Do("ZRANGEBYSCORE", "z", "-inf", 2, "LIMIT", 0, 1)); len(items) != 1 || items[0] != "a" {
+		t.Errorf("ZRANGEBYSCORE = %v, want [a]", items)
+	}
+	conn.Do("ZADD", "z", "NX", 0, "c")
+	if items, _ := redis.Strings(conn.Do("ZRANGEBYSCORE", "z", "-inf", 0)); len(items) != 0 {
+		t.Errorf("ZADD NX changed the score of an existing member: %v", items)
+	}
+	if _, err := conn.Do("GET", "z"); err == nil {
+		t.Error("GET of a sorted set did not fail")
+	}
+}
+
+func TestServerPubSub(t *testing.T) {
+	s := NewServer()
+	psc := redis.PubSubConn{Conn: s.Conn()}
+	defer psc.Close()
+	if err := psc.Subscribe("ch"); err != nil {
+		t.Fatal(err)
+	}
+	if sub, ok := psc.Receive().(redis.Subscription); !ok || sub.Channel != "ch" {
+		t.Fatalf("expected a subscription to ch, got %v", sub)
+	}
+
+	pub := s.Conn()
+	defer pub.Close()
+	if n, err := redis.Int(pub.Do("PUBLISH", "ch", "hi")); err != nil || n != 1 {
+		t.Fatalf("PUBLISH = %d, %v; want 1", n, err)
+	}
+	if msg, ok := psc.Receive().(redis.Message); !ok || string(msg.Data) != "hi" {
+		t.Fatalf("expected the message hi, got %v", msg)
+	}
+	if err := psc.Ping("x"); err != nil {
+		t.Fatal(err)
+	}
+	if pong, ok := psc.Receive().(redis.Pong); !ok || pong.Data != "x" {
+		t.Errorf("expected a pong, got %v", pong)
+	}
+}
+
+func TestServerWatch(t *testing.T) {
+	s := NewServer()
+	conn, other := s.Conn(), s.Conn()
+	defer conn.Close()
+	defer other.Close()
+
+	exec := func() any {
+		conn.Send("MULTI")
+		conn.Send("INCR", "n")
+		reply, err := conn.Do("EXEC")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+
+	conn.Do("WATCH", "n")
+	if reply := exec(); reply == nil {
+		t.Error("transaction of an unchanged watched key was aborted")
+	}
+	conn.Do("WATCH", "n")
+	other.Do("SET", "n", "5")
+	if reply := exec(); reply != nil {
+		t.Errorf("transaction of a changed watched key was not aborted: %v", reply)
+	}
+	if n, _ := redis.Int(conn.Do("GET", "n")); n != 5 {
+		t.Errorf("GET = %d, want 5", n)
+	}
+	if reply := exec(); reply == nil {
+		t.Error("EXEC did not unwatch keys")
+	}
+}
@@ -0,0 +1,69 @@
+// Package rng provides sources of randomness: the global one of math/rand,
+// and seeded ones, which give the same sequence for the same seed, for tests
+// and for replays.
+package rng
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// Rand is a source of pseudo-random numbers.
+type Rand interface {
+	// Intn returns a number in [0, n). It panics if n <= 0.
+	Intn(n int) int
+
+	// Int63 returns a non-negative 63-bit integer.
+	Int63() int64
+
+	// Float64 returns a number in [0.0, 1.0).
+	Float64() float64
+
+	// Shuffle shuffles the n elements swapped by swap.
+	Shuffle(n int, swap func(i, j int))
+}
+
+// Global is the global source of math/rand.
+type Global struct{}
+
+func (Global) Intn(n int) int                     { return rand.Intn(n) }
+func (Global) Int63() int64                       { return rand.Int63() }
+func (Global) Float64() float64                   { return rand.Float64() }
+func (Global) Shuffle(n int, swap func(i, j int)) { rand.Shuffle(n, swap) }
+
+// Seeded is a source seeded with a fixed seed. Unlike a *rand.Rand, it's safe
+// for concurrent use (though the order of the numbers handed out to
+// concurrent callers is, of course, up to the scheduler).
+type Seeded struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+// New returns a source seeded with seed.
+func New(seed int64) *Seeded {
+	return &Seeded{r: rand.New(rand.NewSource(seed))}
+}
+
+func (s *Seeded) Intn(n int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.r.Intn(n)
+}
+
+func (s *Seeded) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.r.Int63()
+}
+
+func (s *Seeded) Float64() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.r.Float64()
+}
+
+func (s *Seeded) Shuffle(n int, swap func(i, j int)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.r.Shuffle(n, swap)
+}
@@ -0,0 +1,32 @@
+package rng
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSeeded(t *testing.T) {
+	draw := func(r Rand) []int {
+		var nums []int
+		for i := 0; i < 10; i++ {
+			nums = append(nums, r.Intn(100))
+		}
+		s := []int{0, 1, 2, 3, 4, 5, 6, 7}
+		r.Shuffle(len(s), func(i, j int) { s[i], s[j] = s[j], s[i] })
+		return append(nums, s...)
+	}
+
+	a, b := draw(New(42)), draw(New(42))
+	if !slices.Equal(a, b) {
+		t.Errorf("sources of the same seed differ: %v and %v", a, b)
+	}
+	if c := draw(New(43)); slices.Equal(a, c) {
+		t.Errorf("sources of different seeds are the same: %v", a)
+	}
+
+	for i := 0; i < 100; i++ {
+		if f := New(int64(i)).Float64(); f < 0 || f >= 1 {
+			t.Fatalf("Float64() = %v, want a number in [0, 1)", f)
+		}
+	}
+}
@@ -9,4 +9,10 @@ type Config interface {
 	GetS3SecretKey() string
 	GetS3Endpoint() string
 	GetS3PathPrefix() string
+	GetS3MaxAttempts() int
+	GetS3MaxBackoff() string     // A duration string, as in time.ParseDuration.
+	GetS3RequestTimeout() string // A duration string, as in time.ParseDuration.
+	GetS3RedirectMode() string   // Empty, "presign", or "cdn".
+	GetS3PresignTTL() string     // A duration string, as in time.ParseDuration.
+	GetS3CDNBaseURL() string
 } 
\ No newline at end of file
@@ -0,0 +1,518 @@
+// Package saml implements a SAML 2.0 service provider, for logging users in
+// with an identity provider (like Shibboleth, ADFS, or Okta). AuthnRequests are
+// sent with the HTTP-Redirect binding, and responses are received with the
+// HTTP-POST binding. Either the response or the assertion in it must be signed
+// (with RSA); encrypted assertions are not supported.
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// XML namespaces.
+const (
+	nsMetadata  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	nsAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	nsProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	nsDSig      = "http://www.w3.org/2000/09/xmldsig#"
+)
+
+// Algorithms.
+const (
+	algExcC14N     = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	algEnveloped   = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	algRSASHA1     = "http://www.w3.org/2000/09/xmldsig#rsa-sha1"
+	algRSASHA256   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	algSHA1        = "http://www.w3.org/2000/09/xmldsig#sha1"
+	algSHA256      = "http://www.w3.org/2001/04/xmlenc#sha256"
+	bindingPOST    = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	bindingRedir   = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	statusSuccess  = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	methodBearer   = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	nameIDEmail    = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	nameIDPersist  = "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent"
+	nameIDUnspecif = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+)
+
+// IdentityProvider is the identity provider of a ServiceProvider.
+type IdentityProvider struct {
+	EntityID     string
+	SSOURL       string // Of the HTTP-Redirect binding.
+	Certificates []*x509.Certificate
+}
+
+// ParseMetadata parses the metadata of an identity provider. If data is an
+// EntitiesDescriptor, the first entity with an IDPSSODescriptor is used.
+func ParseMetadata(data []byte) (*IdentityProvider, error) {
+	type keyDescriptor struct {
+		Use          string   `xml:"use,attr"`
+		Certificates []string `xml:"KeyInfo>X509Data>X509Certificate"`
+	}
+	type entityDescriptor struct {
+		EntityID string `xml:"entityID,attr"`
+		IDP      *struct {
+			Keys []keyDescriptor `xml:"KeyDescriptor"`
+			SSO  []struct {
+				Binding  string `xml:"Binding,attr"`
+				Location string `xml:"Location,attr"`
+			} `xml:"SingleSignOnService"`
+		} `xml:"IDPSSODescriptor"`
+	}
+	var entities struct {
+		XMLName  xml.Name
+		Entities []entityDescriptor `xml:"EntityDescriptor"`
+	}
+	if err := xml.Unmarshal(data, &entities); err != nil {
+		return nil, fmt.Errorf("saml: invalid metadata: %w", err)
+	}
+	if entities.XMLName.Local == "EntityDescriptor" {
+		var entity entityDescriptor
+		if err := xml.Unmarshal(data, &entity); err != nil {
+			return nil, fmt.Errorf("saml: invalid metadata: %w", err)
+		}
+		entities.Entities = []entityDescriptor{entity}
+	}
+
+	for _, entity := range entities.Entities {
+		if entity.IDP == nil {
+			continue
+		}
+		idp := &IdentityProvider{EntityID: entity.EntityID}
+		for _, sso := range entity.IDP.SSO {
+			if sso.Binding == bindingRedir {
+				idp.SSOURL = sso.Location
+			}
+		}
+		if idp.SSOURL == "" {
+			return nil, errors.New("saml: identity provider has no HTTP-Redirect single sign-on service")
+		}
+		for _, key := range entity.IDP.Keys {
+			if key.Use != "" && key.Use != "signing" {
+				continue
+			}
+			for _, str := range key.Certificates {
+				der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(str), ""))
+				if err != nil {
+					return nil, fmt.Errorf("saml: invalid certificate in metadata: %w", err)
+				}
+				cert, err := x509.ParseCertificate(der)
+				if err != nil {
+					return nil, fmt.Errorf("saml: invalid certificate in metadata: %w", err)
+				}
+				idp.Certificates = append(idp.Certificates, cert)
+			}
+		}
+		if len(idp.Certificates) == 0 {
+			return nil, errors.New("saml: identity provider has no signing certificates")
+		}
+		return idp, nil
+	}
+	return nil, errors.New("saml: no identity provider in metadata")
+}
+
+// ServiceProvider is a SAML service provider.
+type ServiceProvider struct {
+	EntityID string
+	ACSURL   string // URL of the assertion consumer service (HTTP-POST binding).
+	IdP      *IdentityProvider
+
+	// MaxClockSkew is the allowed difference between the clocks of the SP and
+	// the IdP. If zero, it's 3 minutes.
+	MaxClockSkew time.Duration
+}
+
+// Metadata returns the metadata of sp.
+func (sp *ServiceProvider) Metadata() []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<md:EntityDescriptor xmlns:md="` + nsMetadata + `" entityID="` + escape(sp.EntityID) + `">`)
+	b.WriteString(`<md:SPSSODescriptor protocolSupportEnumeration="` + nsProtocol + `" AuthnRequestsSigned="false" WantAssertionsSigned="true">`)
+	for _, format := range []string{nameIDPersist, nameIDEmail, nameIDUnspecif} {
+		b.WriteString(`<md:NameIDFormat>` + format + `</md:NameIDFormat>`)
+	}
+	b.WriteString(`<md:AssertionConsumerService Binding="` + bindingPOST + `" Location="` + escape(sp.ACSURL) + `" index="0" isDefault="true"/>`)
+	b.WriteString(`</md:SPSSODescriptor></md:EntityDescriptor>`)
+	return b.Bytes()
+}
+
+func escape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// newID returns a random ID for requests.
+func newID() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return "_" + hex.EncodeToString(b) // IDs must not start with a digit.
+}
+
+// AuthnRequestURL returns the URL that users are redirected to in order to log
+// in at the identity provider, and the ID of the request, which the
+// InResponseTo of the response must match. relayState is passed back as is
+// with the response.
+func (sp *ServiceProvider) AuthnRequestURL(relayState string) (string, string, error) {
+	id := newID()
+	req := `<samlp:AuthnRequest xmlns:samlp="` + nsProtocol + `" xmlns:saml="` + nsAssertion + `"` +
+		` ID="` + id + `" Version="2.0" IssueInstant="` + time.Now().UTC().Format(time.RFC3339) + `"` +
+		` Destination="` + escape(sp.IdP.SSOURL) + `" AssertionConsumerServiceURL="` + escape(sp.ACSURL) + `"` +
+		` ProtocolBinding="` + bindingPOST + `">` +
+		`<saml:Issuer>` + escape(sp.EntityID) + `</saml:Issuer>` +
+		`<samlp:NameIDPolicy AllowCreate="true"/>` +
+		`</samlp:AuthnRequest>`
+
+	var b bytes.Buffer
+	w, err := flate.NewWriter(&b, flate.DefaultCompression)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := w.Write([]byte(req)); err != nil {
+		return "", "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", "", err
+	}
+
+	query := url.Values{}
+	query.Set("SAMLRequest", base64.StdEncoding.EncodeToString(b.Bytes()))
+	if relayState != "" {
+		query.Set("RelayState", relayState)
+	}
+	sep := "?"
+	if strings.Contains(sp.IdP.SSOURL, "?") {
+		sep = "&"
+	}
+	return sp.IdP.SSOURL + sep + query.Encode(), id, nil
+}
+
+// Assertion is the information about a user asserted by the identity provider.
+type Assertion struct {
+	ID           string
+	InResponseTo string // ID of the AuthnRequest.
+	NameID       string
+	SessionIndex string
+	Attributes   map[string][]string // By Name (and by FriendlyName, if any).
+}
+
+// Attribute returns the first value of the attribute name of a, or an empty
+// string.
+func (a *Assertion) Attribute(name string) string {
+	if values := a.Attributes[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// ErrInvalidResponse is wrapped by the errors of ParseResponse about invalid
+// responses.
+var ErrInvalidResponse = errors.New("saml: invalid response")
+
+func invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidResponse, fmt.Sprintf(format, args...))
+}
+
+// ParseResponse verifies the response of the identity provider (the
+// SAMLResponse parameter of the HTTP-POST binding, base64 encoded) and returns
+// its assertion. Checking that the InResponseTo of the assertion is of a
+// request that was sent (and that it's used only once) is up to the caller.
+func (sp *ServiceProvider) ParseResponse(samlResponse string, now time.Time) (*Assertion, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(samlResponse), ""))
+	if err != nil {
+		return nil, invalid("bad base64: %v", err)
+	}
+	root, err := parseDocument(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+	if !root.is(nsProtocol, "Response") {
+		return nil, invalid("not a Response")
+	}
+
+	// IDs must be unique, so that the element a signature references is
+	// unambiguous.
+	ids := make(map[string]bool)
+	var duplicate bool
+	root.walk(func(e *element) {
+		if id := e.attr("ID"); id != "" {
+			duplicate = duplicate || ids[id]
+			ids[id] = true
+		}
+	})
+	if duplicate {
+		return nil, invalid("duplicate IDs")
+	}
+
+	if dest := root.attr("Destination"); dest != "" && dest != sp.ACSURL {
+		return nil, invalid("wrong destination %q", dest)
+	}
+	status := root.child(nsProtocol, "Status")
+	if status == nil {
+		return nil, invalid("no status")
+	}
+	if code := status.child(nsProtocol, "StatusCode"); code == nil || code.attr("Value") != statusSuccess {
+		msg := ""
+		if m := status.child(nsProtocol, "StatusMessage"); m != nil {
+			msg = m.text()
+		}
+		return nil, invalid("unsuccessful status (%s)", msg)
+	}
+	if len(root.childElements(nsAssertion, "EncryptedAssertion")) > 0 {
+		return nil, invalid("encrypted assertions are not supported")
+	}
+	assertions := root.childElements(nsAssertion, "Assertion")
+	if len(assertions) != 1 {
+		return nil, invalid("%d assertions", len(assertions))
+	}
+	assertion := assertions[0]
+
+	// Either the response, or the assertion, must be signed. Since the
+	// assertion is a child of the response, it's covered by the signature of
+	// the response.
+	responseSigned, err := sp.verifySignature(root)
+	if err != nil {
+		return nil, err
+	}
+	assertionSigned, err := sp.verifySignature(assertion)
+	if err != nil {
+		return nil, err
+	}
+	if !responseSigned && !assertionSigned {
+		return nil, invalid("not signed")
+	}
+
+	return sp.checkAssertion(assertion, now)
+}
+
+// verifySignature verifies the enveloped signature of e, if e has one.
+func (sp *ServiceProvider) verifySignature(e *element) (bool, error) {
+	sigs := e.childElements(nsDSig, "Signature")
+	if len(sigs) == 0 {
+		return false, nil
+	}
+	if len(sigs) > 1 {
+		return false, invalid("multiple signatures of %s", e.local)
+	}
+	sig := sigs[0]
+
+	signedInfo := sig.child(nsDSig, "SignedInfo")
+	sigValue := sig.child(nsDSig, "SignatureValue")
+	if signedInfo == nil || sigValue == nil {
+		return false, invalid("incomplete signature")
+	}
+	c14n := signedInfo.child(nsDSig, "CanonicalizationMethod")
+	if c14n == nil || c14n.attr("Algorithm") != algExcC14N {
+		return false, invalid("unsupported canonicalization method")
+	}
+	refs := signedInfo.childElements(nsDSig, "Reference")
+	if len(refs) != 1 {
+		return false, invalid("signature has %d references", len(refs))
+	}
+	ref := refs[0]
+	if id := e.attr("ID"); id == "" || ref.attr("URI") != "#"+id {
+		return false, invalid("signature of %s references another element", e.local)
+	}
+
+	// The transforms must be the enveloped signature transform and exclusive
+	// canonicalization.
+	var inclusive []string
+	if transforms := ref.child(nsDSig, "Transforms"); transforms != nil {
+		for _, t := range transforms.childElements(nsDSig, "Transform") {
+			switch t.attr("Algorithm") {
+			case algEnveloped:
+			case algExcC14N:
+				inclusive = inclusivePrefixes(t)
+			default:
+				return false, invalid("unsupported transform %q", t.attr("Algorithm"))
+			}
+		}
+	}
+
+	digestMethod, digestValue := ref.child(nsDSig, "DigestMethod"), ref.child(nsDSig, "DigestValue")
+	if digestMethod == nil || digestValue == nil {
+		return false, invalid("incomplete reference")
+	}
+	canonical := canonicalize(e, sig, inclusive)
+	var digest []byte
+	switch digestMethod.attr("Algorithm") {
+	case algSHA256:
+		sum := sha256.Sum256(canonical)
+		digest = sum[:]
+	case algSHA1:
+		sum := sha1.Sum(canonical)
+		digest = sum[:]
+	default:
+		return false, invalid("unsupported digest method")
+	}
+	want, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(digestValue.text()), ""))
+	if err != nil || subtle.ConstantTimeCompare(digest, want) != 1 {
+		return false, invalid("digest mismatch")
+	}
+
+	signatureMethod := signedInfo.child(nsDSig, "SignatureMethod")
+	if signatureMethod == nil {
+		return false, invalid("no signature method")
+	}
+	signed := canonicalize(signedInfo, nil, inclusivePrefixes(c14n))
+	var (
+		hash   crypto.Hash
+		hashed []byte
+	)
+	switch signatureMethod.attr("Algorithm") {
+	case algRSASHA256:
+		sum := sha256.Sum256(signed)
+		hash, hashed = crypto.SHA256, sum[:]
+	case algRSASHA1:
+		sum := sha1.Sum(signed)
+		hash, hashed = crypto.SHA1, sum[:]
+	default:
+		return false, invalid("unsupported signature method")
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(sigValue.text()), ""))
+	if err != nil {
+		return false, invalid("bad signature value")
+	}
+	for _, cert := range sp.IdP.Certificates {
+		key, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			continue
+		}
+		if rsa.VerifyPKCS1v15(key, hash, hashed, signature) == nil {
+			return true, nil
+		}
+	}
+	return false, invalid("bad signature")
+}
+
+// inclusivePrefixes returns the prefixes of the InclusiveNamespaces parameter
+// of the exclusive canonicalization method or transform e.
+func inclusivePrefixes(e *element) []string {
+	if in := e.child(algExcC14N, "InclusiveNamespaces"); in != nil {
+		return strings.Fields(in.attr("PrefixList"))
+	}
+	return nil
+}
+
+// checkAssertion checks the conditions of a (verified) assertion and returns
+// its contents.
+func (sp *ServiceProvider) checkAssertion(e *element, now time.Time) (*Assertion, error) {
+	skew := sp.MaxClockSkew
+	if skew == 0 {
+		skew = 3 * time.Minute
+	}
+	parseTime := func(s string) (time.Time, error) {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return t, invalid("bad time %q", s)
+		}
+		return t, nil
+	}
+
+	if issuer := e.child(nsAssertion, "Issuer"); issuer == nil || (sp.IdP.EntityID != "" && strings.TrimSpace(issuer.text()) != sp.IdP.EntityID) {
+		return nil, invalid("wrong issuer")
+	}
+
+	a := &Assertion{ID: e.attr("ID"), Attributes: make(map[string][]string)}
+
+	subject := e.child(nsAssertion, "Subject")
+	if subject == nil {
+		return nil, invalid("no subject")
+	}
+	if nameID := subject.child(nsAssertion, "NameID"); nameID != nil {
+		a.NameID = strings.TrimSpace(nameID.text())
+	}
+	if a.NameID == "" {
+		return nil, invalid("no name ID")
+	}
+	confirmed := false
+	for _, sc := range subject.childElements(nsAssertion, "SubjectConfirmation") {
+		data := sc.child(nsAssertion, "SubjectConfirmationData")
+		if sc.attr("Method") != methodBearer || data == nil {
+			continue
+		}
+		if data.attr("Recipient") != sp.ACSURL {
+			continue
+		}
+		notOnOrAfter, err := parseTime(data.attr("NotOnOrAfter"))
+		if err != nil {
+			return nil, err
+		}
+		if !now.Before(notOnOrAfter.Add(skew)) {
+			continue
+		}
+		a.InResponseTo = data.attr("InResponseTo")
+		confirmed = true
+		break
+	}
+	if !confirmed {
+		return nil, invalid("no valid bearer subject confirmation")
+	}
+
+	conditions := e.child(nsAssertion, "Conditions")
+	if conditions == nil {
+		return nil, invalid("no conditions")
+	}
+	if s := conditions.attr("NotBefore"); s != "" {
+		t, err := parseTime(s)
+		if err != nil {
+			return nil, err
+		}
+		if now.Add(skew).Before(t) {
+			return nil, invalid("assertion not yet valid")
+		}
+	}
+	if s := conditions.attr("NotOnOrAfter"); s != "" {
+		t, err := parseTime(s)
+		if err != nil {
+			return nil, err
+		}
+		if !now.Before(t.Add(skew)) {
+			return nil, invalid("assertion expired")
+		}
+	}
+	for _, restriction := range conditions.childElements(nsAssertion, "AudienceRestriction") {
+		ok := false
+		for _, audience := range restriction.childElements(nsAssertion, "Audience") {
+			ok = ok || strings.TrimSpace(audience.text()) == sp.EntityID
+		}
+		if !ok {
+			return nil, invalid("not intended for this service provider")
+		}
+	}
+
+	if authn := e.child(nsAssertion, "AuthnStatement"); authn != nil {
+		a.SessionIndex = authn.attr("SessionIndex")
+	}
+	for _, statement := range e.childElements(nsAssertion, "AttributeStatement") {
+		for _, attr := range statement.childElements(nsAssertion, "Attribute") {
+			var values []string
+			for _, v := range attr.childElements(nsAssertion, "AttributeValue") {
+				values = append(values, strings.TrimSpace(v.text()))
+			}
+			for _, name := range []string{attr.attr("Name"), attr.attr("FriendlyName")} {
+				if name != "" {
+					a.Attributes[name] = append(a.Attributes[name], values...)
+				}
+			}
+		}
+	}
+	return a, nil
+}
@@ -0,0 +1,267 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanonicalize(t *testing.T) {
+	doc := `<?xml version="1.0"?>
+<a:root xmlns:a="urn:a" xmlns:b="urn:b" xmlns:unused="urn:unused" xmlns="urn:default">
+	<a:child z="2" b:attr="1" a="&quot;x&#9;y&quot;">1 &lt; 2 &amp;&amp; 3 > 2<!-- comment --><![CDATA[<cdata>]]></a:child>
+	<plain xmlns=""><b:inner/></plain>
+	<default/>
+</a:root>`
+	root, err := parseDocument([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	children := root.childElements("urn:a", "child")
+	if len(children) != 1 {
+		t.Fatalf("expected 1 child, got %d", len(children))
+	}
+	for _, c := range []struct {
+		e         *element
+		inclusive []string
+		want      string
+	}{
+		{
+			children[0], nil,
+			`<a:child xmlns:a="urn:a" xmlns:b="urn:b" a="&quot;x&#x9;y&quot;" z="2" b:attr="1">1 &lt; 2 &amp;&amp; 3 &gt; 2&lt;cdata&gt;</a:child>`,
+		},
+		{
+			children[0], []string{"unused", "#default"},
+			`<a:child xmlns="urn:default" xmlns:a="urn:a" xmlns:b="urn:b" xmlns:unused="urn:unused" a="&quot;x&#x9;y&quot;" z="2" b:attr="1">1 &lt; 2 &amp;&amp; 3 &gt; 2&lt;cdata&gt;</a:child>`,
+		},
+		{
+			root.child("", "plain"), nil,
+			`<plain><b:inner xmlns:b="urn:b"></b:inner></plain>`,
+		},
+		{
+			root.child("urn:default", "default"), nil,
+			`<default xmlns="urn:default"></default>`,
+		},
+	} {
+		if got := string(canonicalize(c.e, nil, c.inclusive)); got != c.want {
+			t.Errorf("canonicalize(%s, %v):\ngot  %s\nwant %s", c.e.local, c.inclusive, got, c.want)
+		}
+	}
+
+	if _, err := parseDocument([]byte(`<!DOCTYPE x [<!ENTITY e "e">]><x>&e;</x>`)); err == nil {
+		t.Error("expected documents with DTDs to be rejected")
+	}
+	if _, err := parseDocument([]byte(`<a><b></a></b>`)); err == nil {
+		t.Error("expected mismatched end elements to be rejected")
+	}
+}
+
+// testIdP is an identity provider that signs responses.
+type testIdP struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testIdP{key: key, cert: cert}
+}
+
+func (idp *testIdP) metadata() string {
+	return `<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="https://idp.example.com">
+	<md:IDPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+		<md:KeyDescriptor use="signing">
+			<ds:KeyInfo xmlns:ds="http://www.w3.org/2000/09/xmldsig#"><ds:X509Data><ds:X509Certificate>
+				` + base64.StdEncoding.EncodeToString(idp.cert.Raw) + `
+			</ds:X509Certificate></ds:X509Data></ds:KeyInfo>
+		</md:KeyDescriptor>
+		<md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST" Location="https://idp.example.com/sso/post"/>
+		<md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="https://idp.example.com/sso"/>
+	</md:IDPSSODescriptor>
+</md:EntityDescriptor>`
+}
+
+// sign inserts an enveloped signature of the element with id in doc, as the
+// child of the element after the string after.
+func (idp *testIdP) sign(t *testing.T, doc, id, after string) string {
+	root, err := parseDocument([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var target *element
+	root.walk(func(e *element) {
+		if e.attr("ID") == id {
+			target = e
+		}
+	})
+	digest := sha256.Sum256(canonicalize(target, nil, nil))
+	signature := `<ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#"><ds:SignedInfo>` +
+		`<ds:CanonicalizationMethod Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"/>` +
+		`<ds:SignatureMethod Algorithm="http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"/>` +
+		`<ds:Reference URI="#` + id + `"><ds:Transforms>` +
+		`<ds:Transform Algorithm="http://www.w3.org/2000/09/xmldsig#enveloped-signature"/>` +
+		`<ds:Transform Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"/>` +
+		`</ds:Transforms><ds:DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"/>` +
+		`<ds:DigestValue>` + base64.StdEncoding.EncodeToString(digest[:]) + `</ds:DigestValue>` +
+		`</ds:Reference></ds:SignedInfo><ds:SignatureValue>SIGNATURE</ds:SignatureValue></ds:Signature>`
+	i := strings.Index(doc, after) + len(after)
+	doc = doc[:i] + signature + doc[i:]
+
+	root, err = parseDocument([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var signedInfo *element
+	root.walk(func(e *element) {
+		if e.is(nsDSig, "SignedInfo") {
+			signedInfo = e
+		}
+	})
+	hashed := sha256.Sum256(canonicalize(signedInfo, nil, nil))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Replace(doc, "SIGNATURE", base64.StdEncoding.EncodeToString(sig), 1)
+}
+
+func testResponse(now time.Time, requestID string) string {
+	ts := func(d time.Duration) string { return now.Add(d).UTC().Format(time.RFC3339) }
+	return `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion"` +
+		` ID="_response" Version="2.0" IssueInstant="` + ts(0) + `" Destination="https://discuit.example.com/api/_saml/acs" InResponseTo="` + requestID + `">
+	<saml:Issuer>https://idp.example.com</saml:Issuer>
+	<samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>
+	<saml:Assertion xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" ID="_assertion" Version="2.0" IssueInstant="` + ts(0) + `">
+		<saml:Issuer>https://idp.example.com</saml:Issuer>
+		<saml:Subject>
+			<saml:NameID Format="urn:oasis:names:tc:SAML:2.0:nameid-format:persistent">alice-1234</saml:NameID>
+			<saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">
+				<saml:SubjectConfirmationData NotOnOrAfter="` + ts(5*time.Minute) + `" Recipient="https://discuit.example.com/api/_saml/acs" InResponseTo="` + requestID + `"/>
+			</saml:SubjectConfirmation>
+		</saml:Subject>
+		<saml:Conditions NotBefore="` + ts(-time.Minute) + `" NotOnOrAfter="` + ts(5*time.Minute) + `">
+			<saml:AudienceRestriction><saml:Audience>https://discuit.example.com/api/_saml/metadata</saml:Audience></saml:AudienceRestriction>
+		</saml:Conditions>
+		<saml:AuthnStatement AuthnInstant="` + ts(0) + `" SessionIndex="_session"/>
+		<saml:AttributeStatement>
+			<saml:Attribute Name="urn:oid:0.9.2342.19200300.100.1.3" FriendlyName="mail">
+				<saml:AttributeValue xsi:type="xs:string">alice@example.com</saml:AttributeValue>
+			</saml:Attribute>
+			<saml:Attribute Name="uid"><saml:AttributeValue xsi:type="xs:string">alice</saml:AttributeValue></saml:Attribute>
+		</saml:AttributeStatement>
+	</saml:Assertion>
+</samlp:Response>`
+}
+
+func TestServiceProvider(t *testing.T) {
+	idp := newTestIdP(t)
+	metadata, err := ParseMetadata([]byte(idp.metadata()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.EntityID != "https://idp.example.com" || metadata.SSOURL != "https://idp.example.com/sso" || len(metadata.Certificates) != 1 {
+		t.Fatalf("unexpected metadata: %+v", metadata)
+	}
+	sp := &ServiceProvider{
+		EntityID: "https://discuit.example.com/api/_saml/metadata",
+		ACSURL:   "https://discuit.example.com/api/_saml/acs",
+		IdP:      metadata,
+	}
+
+	u, requestID, err := sp.AuthnRequestURL("/home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deflated, err := base64.StdEncoding.DecodeString(parsed.Query().Get("SAMLRequest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(request), `ID="`+requestID+`"`) || parsed.Query().Get("RelayState") != "/home" {
+		t.Fatalf("unexpected request: %s", request)
+	}
+
+	now := time.Now()
+	encode := func(doc string) string { return base64.StdEncoding.EncodeToString([]byte(doc)) }
+	signedAssertion := idp.sign(t, testResponse(now, requestID), "_assertion", "<saml:Issuer>https://idp.example.com</saml:Issuer>\n\t\t")
+	signedResponse := idp.sign(t, testResponse(now, requestID), "_response", "<saml:Issuer>https://idp.example.com</saml:Issuer>\n\t")
+
+	for name, doc := range map[string]string{"signed assertion": signedAssertion, "signed response": signedResponse} {
+		a, err := sp.ParseResponse(encode(doc), now)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if a.NameID != "alice-1234" || a.InResponseTo != requestID || a.SessionIndex != "_session" ||
+			a.Attribute("mail") != "alice@example.com" || a.Attribute("urn:oid:0.9.2342.19200300.100.1.3") != "alice@example.com" || a.Attribute("uid") != "alice" {
+			t.Fatalf("%s: unexpected assertion: %+v", name, a)
+		}
+	}
+
+	for name, c := range map[string]struct {
+		doc string
+		now time.Time
+	}{
+		"unsigned":          {testResponse(now, requestID), now},
+		"tampered":          {strings.Replace(signedAssertion, "alice-1234", "admin", 1), now},
+		"tampered response": {strings.Replace(signedResponse, ">alice<", ">mallory<", 1), now},
+		"expired":           {signedAssertion, now.Add(time.Hour)},
+		"not yet valid":     {signedAssertion, now.Add(-time.Hour)},
+		"wrapped": {
+			// The signed assertion moved into an extension, and an unsigned
+			// one put in its place.
+			strings.Replace(signedAssertion, "<samlp:Status>", "<samlp:Extensions>"+signedAssertion[strings.Index(signedAssertion, "<saml:Assertion"):strings.Index(signedAssertion, "</saml:Assertion>")+len("</saml:Assertion>")]+"</samlp:Extensions><samlp:Status>", 1),
+			now,
+		},
+	} {
+		if _, err := sp.ParseResponse(encode(c.doc), c.now); !errors.Is(err, ErrInvalidResponse) {
+			t.Errorf("%s: expected ErrInvalidResponse, got %v", name, err)
+		}
+	}
+
+	other := *sp
+	other.EntityID = "https://other.example.com"
+	if _, err := other.ParseResponse(encode(signedAssertion), now); !errors.Is(err, ErrInvalidResponse) {
+		t.Errorf("wrong audience: expected ErrInvalidResponse, got %v", err)
+	}
+
+	if !bytes.Contains(sp.Metadata(), []byte(`Location="https://discuit.example.com/api/_saml/acs"`)) {
+		t.Errorf("unexpected metadata: %s", sp.Metadata())
+	}
+}
@@ -0,0 +1,310 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+// element is an element of a parsed XML document. Unlike encoding/xml, it
+// keeps the prefixes of names and the namespace declarations of elements, which
+// canonicalization needs.
+type element struct {
+	parent   *element
+	prefix   string
+	local    string
+	attrs    []xml.Attr // Attribute names are unresolved (Space is the prefix).
+	children []any      // *element or string (character data).
+
+	ns map[string]string // Namespaces declared on the element, by prefix.
+}
+
+// maxDocumentDepth is the max depth of elements of parsed documents.
+const maxDocumentDepth = 64
+
+// parseDocument parses data and returns its root element. Comments,
+// processing instructions, and directives are dropped. Documents with DTDs are
+// rejected.
+func parseDocument(data []byte) (*element, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	var root, cur *element
+	depth := 0
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if root != nil && cur == nil {
+				return nil, errors.New("saml: multiple root elements")
+			}
+			if depth++; depth > maxDocumentDepth {
+				return nil, errors.New("saml: document too deep")
+			}
+			el := &element{parent: cur, prefix: t.Name.Space, local: t.Name.Local, ns: make(map[string]string)}
+			for _, a := range t.Attr {
+				switch {
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+					el.ns[""] = a.Value
+				case a.Name.Space == "xmlns":
+					el.ns[a.Name.Local] = a.Value
+				default:
+					el.attrs = append(el.attrs, a)
+				}
+			}
+			if cur == nil {
+				root = el
+			} else {
+				cur.children = append(cur.children, el)
+			}
+			cur = el
+		case xml.EndElement:
+			if cur == nil || t.Name.Space != cur.prefix || t.Name.Local != cur.local {
+				return nil, errors.New("saml: unexpected end element")
+			}
+			cur = cur.parent
+			depth--
+		case xml.CharData:
+			if cur != nil {
+				cur.children = append(cur.children, string(t))
+			}
+		case xml.Directive:
+			if bytes.HasPrefix(bytes.TrimSpace(t), []byte("DOCTYPE")) {
+				return nil, errors.New("saml: documents with DTDs are not accepted")
+			}
+		}
+	}
+	if root == nil || cur != nil {
+		return nil, errors.New("saml: incomplete document")
+	}
+	return root, nil
+}
+
+// lookupNS returns the namespace URI of prefix in the scope of e.
+func (e *element) lookupNS(prefix string) (string, bool) {
+	for el := e; el != nil; el = el.parent {
+		if uri, ok := el.ns[prefix]; ok {
+			return uri, true
+		}
+	}
+	if prefix == "xml" {
+		return "http://www.w3.org/XML/1998/namespace", true
+	}
+	return "", prefix == ""
+}
+
+// space returns the namespace URI of e.
+func (e *element) space() string {
+	uri, _ := e.lookupNS(e.prefix)
+	return uri
+}
+
+// is reports whether e is the element local in the namespace space.
+func (e *element) is(space, local string) bool {
+	return e.local == local && e.space() == space
+}
+
+// attr returns the value of the unprefixed attribute name of e.
+func (e *element) attr(name string) string {
+	for _, a := range e.attrs {
+		if a.Name.Space == "" && a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// childElements returns the child elements of e that are local in the
+// namespace space.
+func (e *element) childElements(space, local string) []*element {
+	var els []*element
+	for _, c := range e.children {
+		if el, ok := c.(*element); ok && el.is(space, local) {
+			els = append(els, el)
+		}
+	}
+	return els
+}
+
+// child returns the first child element of e that is local in the namespace
+// space, or nil.
+func (e *element) child(space, local string) *element {
+	if els := e.childElements(space, local); len(els) > 0 {
+		return els[0]
+	}
+	return nil
+}
+
+// text returns the character data of e (not of its descendants).
+func (e *element) text() string {
+	var b strings.Builder
+	for _, c := range e.children {
+		if s, ok := c.(string); ok {
+			b.WriteString(s)
+		}
+	}
+	return b.String()
+}
+
+// walk calls fn with e and each of its descendants, in document order.
+func (e *element) walk(fn func(*element)) {
+	fn(e)
+	for _, c := range e.children {
+		if el, ok := c.(*element); ok {
+			el.walk(fn)
+		}
+	}
+}
+
+// canonicalize returns the exclusive canonicalization (without comments) of
+// the subtree of e, leaving out the subtree of exclude, if it's not nil (for
+// enveloped signatures). The namespaces with the prefixes in inclusive are
+// treated as in inclusive canonicalization (see the InclusiveNamespaces
+// PrefixList parameter of Exclusive XML Canonicalization).
+func canonicalize(e, exclude *element, inclusive []string) []byte {
+	var b bytes.Buffer
+	writeCanonical(&b, e, exclude, inclusive, map[string]string{})
+	return b.Bytes()
+}
+
+func writeCanonical(b *bytes.Buffer, e, exclude *element, inclusive []string, rendered map[string]string) {
+	// The namespaces visibly utilized by e (and those of inclusive in scope)
+	// that are not already rendered by an output ancestor.
+	prefixes := []string{e.prefix}
+	for _, a := range e.attrs {
+		if a.Name.Space != "" && a.Name.Space != "xml" {
+			prefixes = append(prefixes, a.Name.Space)
+		}
+	}
+	for _, p := range inclusive {
+		if p == "#default" {
+			p = ""
+		}
+		if _, ok := e.lookupNS(p); ok {
+			prefixes = append(prefixes, p)
+		}
+	}
+	type nsDecl struct{ prefix, uri string }
+	var decls []nsDecl
+	seen := make(map[string]bool)
+	for _, p := range prefixes {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		uri, _ := e.lookupNS(p)
+		prev, ok := rendered[p]
+		if p == "" && !ok {
+			prev, ok = "", true // The default namespace is initially empty.
+		}
+		if ok && prev == uri {
+			continue
+		}
+		decls = append(decls, nsDecl{p, uri})
+	}
+	sort.Slice(decls, func(i, j int) bool { return decls[i].prefix < decls[j].prefix })
+	if len(decls) > 0 {
+		next := make(map[string]string, len(rendered)+len(decls))
+		for p, uri := range rendered {
+			next[p] = uri
+		}
+		for _, d := range decls {
+			next[d.prefix] = d.uri
+		}
+		rendered = next
+	}
+
+	type attr struct{ space, qname, local, value string }
+	attrs := make([]attr, 0, len(e.attrs))
+	for _, a := range e.attrs {
+		qname, space := a.Name.Local, ""
+		if a.Name.Space != "" {
+			qname = a.Name.Space + ":" + a.Name.Local
+			space, _ = e.lookupNS(a.Name.Space)
+		}
+		attrs = append(attrs, attr{space, qname, a.Name.Local, a.Value})
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].space != attrs[j].space {
+			return attrs[i].space < attrs[j].space
+		}
+		return attrs[i].local < attrs[j].local
+	})
+
+	qname := e.local
+	if e.prefix != "" {
+		qname = e.prefix + ":" + e.local
+	}
+	b.WriteString("<" + qname)
+	for _, d := range decls {
+		if d.prefix == "" {
+			b.WriteString(` xmlns="`)
+		} else {
+			b.WriteString(` xmlns:` + d.prefix + `="`)
+		}
+		escapeAttr(b, d.uri)
+		b.WriteString(`"`)
+	}
+	for _, a := range attrs {
+		b.WriteString(" " + a.qname + `="`)
+		escapeAttr(b, a.value)
+		b.WriteString(`"`)
+	}
+	b.WriteString(">")
+	for _, c := range e.children {
+		switch c := c.(type) {
+		case string:
+			escapeText(b, c)
+		case *element:
+			if c != exclude {
+				writeCanonical(b, c, exclude, inclusive, rendered)
+			}
+		}
+	}
+	b.WriteString("</" + qname + ">")
+}
+
+func escapeText(b *bytes.Buffer, s string) {
+	for _, r := range s {
+		switch r {
+		case '&':
+			b.WriteString("&amp;")
+		case '<':
+			b.WriteString("&lt;")
+		case '>':
+			b.WriteString("&gt;")
+		case '\r':
+			b.WriteString("&#xD;")
+		default:
+			b.WriteRune(r)
+		}
+	}
+}
+
+func escapeAttr(b *bytes.Buffer, s string) {
+	for _, r := range s {
+		switch r {
+		case '&':
+			b.WriteString("&amp;")
+		case '<':
+			b.WriteString("&lt;")
+		case '"':
+			b.WriteString("&quot;")
+		case '\t':
+			b.WriteString("&#x9;")
+		case '\n':
+			b.WriteString("&#xA;")
+		case '\r':
+			b.WriteString("&#xD;")
+		default:
+			b.WriteRune(r)
+		}
+	}
+}
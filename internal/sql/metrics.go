@@ -0,0 +1,247 @@
+package sql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/metrics"
+	"github.com/discuitnet/discuit/internal/tracing"
+)
+
+var queryDurations = metrics.NewHistogram("discuit_db_query_duration_seconds",
+	"Durations of the database queries, statements, and commits and rollbacks of transactions, until their results are returned.",
+	nil, "op")
+
+// InstrumentConnector returns c with the durations of the queries and
+// statements run on its connections recorded (see package metrics), and with
+// them traced (see package tracing).
+func InstrumentConnector(c driver.Connector) driver.Connector {
+	return &meteredConnector{c}
+}
+
+// maxTracedStatement is the longest a statement is in the attributes of
+// spans.
+const maxTracedStatement = 2048
+
+// startSpan starts the span of a call of op, running query (which is empty
+// for commits and rollbacks).
+func startSpan(ctx context.Context, op, query string) *tracing.Span {
+	attrs := []tracing.Attr{tracing.String("db.system", "mysql"), tracing.String("db.operation", op)}
+	if query != "" {
+		if len(query) > maxTracedStatement {
+			query = query[:maxTracedStatement]
+		}
+		attrs = append(attrs, tracing.String("db.statement", query))
+	}
+	_, span := tracing.Start(ctx, tracing.KindClient, "db "+op, attrs...)
+	return span
+}
+
+// observe records the duration, since start, of a call of op that returned
+// *err, and ends its span. It's to be deferred. Calls the driver skipped (see
+// driver.ErrSkip) are not recorded.
+func observe(op string, start time.Time, span *tracing.Span, err *error) {
+	if *err == driver.ErrSkip {
+		span.Discard()
+		span.End()
+		return
+	}
+	queryDurations.ObserveSince(start, op)
+	span.Finish(*err)
+}
+
+type meteredConnector struct {
+	c driver.Connector
+}
+
+func (m *meteredConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := m.c.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &meteredConn{conn}, nil
+}
+
+func (m *meteredConnector) Driver() driver.Driver {
+	return m.c.Driver()
+}
+
+// meteredConn is a driver.Conn that forwards the optional interfaces of
+// database/sql/driver to the connection it wraps, if it implements them.
+type meteredConn struct {
+	conn driver.Conn
+}
+
+func (c *meteredConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *meteredConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var (
+		stmt driver.Stmt
+		err  error
+	)
+	if p, ok := c.conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &meteredStmt{stmt, query}, nil
+}
+
+func (c *meteredConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *meteredConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *meteredConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var (
+		tx  driver.Tx
+		err error
+	)
+	if b, ok := c.conn.(driver.ConnBeginTx); ok {
+		tx, err = b.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.conn.Begin()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &meteredTx{tx, ctx}, nil
+}
+
+func (c *meteredConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (rows driver.Rows, err error) {
+	q, ok := c.conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	defer observe("query", time.Now(), startSpan(ctx, "query", query), &err)
+	return q.QueryContext(ctx, query, args)
+}
+
+func (c *meteredConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (res driver.Result, err error) {
+	e, ok := c.conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	defer observe("exec", time.Now(), startSpan(ctx, "exec", query), &err)
+	return e.ExecContext(ctx, query, args)
+}
+
+func (c *meteredConn) Ping(ctx context.Context) error {
+	if p, ok := c.conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *meteredConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *meteredConn) IsValid() bool {
+	if v, ok := c.conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *meteredConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if ch, ok := c.conn.(driver.NamedValueChecker); ok {
+		return ch.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type meteredStmt struct {
+	stmt  driver.Stmt
+	query string
+}
+
+func (s *meteredStmt) Close() error {
+	return s.stmt.Close()
+}
+
+func (s *meteredStmt) NumInput() int {
+	return s.stmt.NumInput()
+}
+
+func (s *meteredStmt) Exec(args []driver.Value) (res driver.Result, err error) {
+	defer observe("exec", time.Now(), startSpan(context.Background(), "exec", s.query), &err)
+	return s.stmt.Exec(args)
+}
+
+func (s *meteredStmt) Query(args []driver.Value) (rows driver.Rows, err error) {
+	defer observe("query", time.Now(), startSpan(context.Background(), "query", s.query), &err)
+	return s.stmt.Query(args)
+}
+
+func (s *meteredStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (res driver.Result, err error) {
+	e, ok := s.stmt.(driver.StmtExecContext)
+	if !ok {
+		values, err := namedValuesToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Exec(values)
+	}
+	defer observe("exec", time.Now(), startSpan(ctx, "exec", s.query), &err)
+	return e.ExecContext(ctx, args)
+}
+
+func (s *meteredStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (rows driver.Rows, err error) {
+	q, ok := s.stmt.(driver.StmtQueryContext)
+	if !ok {
+		values, err := namedValuesToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Query(values)
+	}
+	defer observe("query", time.Now(), startSpan(ctx, "query", s.query), &err)
+	return q.QueryContext(ctx, args)
+}
+
+func (s *meteredStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if ch, ok := s.stmt.(driver.NamedValueChecker); ok {
+		return ch.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func namedValuesToValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("sql: driver does not support named parameters")
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
+
+type meteredTx struct {
+	tx  driver.Tx
+	ctx context.Context // Of BeginTx, for the spans of the commit and rollback.
+}
+
+func (t *meteredTx) Commit() (err error) {
+	defer observe("commit", time.Now(), startSpan(t.ctx, "commit", ""), &err)
+	return t.tx.Commit()
+}
+
+func (t *meteredTx) Rollback() (err error) {
+	defer observe("rollback", time.Now(), startSpan(t.ctx, "rollback", ""), &err)
+	return t.tx.Rollback()
+}
@@ -0,0 +1,56 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+// execConnector is a driver.Connector of a database that can only run
+// statements (and not queries), which affect nothing.
+type execConnector struct{}
+
+func (execConnector) Connect(context.Context) (driver.Conn, error) { return execConn{}, nil }
+func (execConnector) Driver() driver.Driver                        { return nil }
+
+type execConn struct{}
+
+var errNoPrepare = errors.New("no prepared statements")
+
+func (execConn) Prepare(string) (driver.Stmt, error) { return nil, errNoPrepare }
+func (execConn) Close() error                        { return nil }
+func (execConn) Begin() (driver.Tx, error)           { return execConn{}, nil }
+func (execConn) Commit() error                       { return nil }
+func (execConn) Rollback() error                     { return nil }
+
+func (execConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+func TestInstrumentConnector(t *testing.T) {
+	db := sql.OpenDB(InstrumentConnector(execConnector{}))
+	defer db.Close()
+
+	execs, commits := queryDurations.Count("exec"), queryDurations.Count("commit")
+	if _, err := db.Exec("DELETE FROM nothing WHERE id = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	err := Transact(context.Background(), db, func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM nothing")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := queryDurations.Count("exec") - execs; n != 2 {
+		t.Errorf("recorded %d statements, want 2", n)
+	}
+	if n := queryDurations.Count("commit") - commits; n != 1 {
+		t.Errorf("recorded %d commits, want 1", n)
+	}
+	if _, err := db.Query("SELECT 1"); err == nil {
+		t.Error("ran a query on a connection that can't")
+	}
+}
@@ -0,0 +1,169 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Replicas are the read replicas of a database. Reads that can stand to be a
+// little stale (feeds, say) are routed to them (see ReadDB), in turn, so as
+// to take load off the primary database.
+//
+// Replicas that fail a health check (see CheckHealth) are not read from until
+// they pass one again. If none of them is healthy, reads go to the primary.
+type Replicas struct {
+	primary  *sql.DB
+	replicas []*replica
+	maxLag   time.Duration // If 0, the lag of replicas is not checked.
+	next     atomic.Uint64
+}
+
+type replica struct {
+	name    string // For logs (the address of the replica, say).
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+// NewReplicas returns the (as yet empty) read replicas of primary. Replicas
+// that lag behind primary by more than maxLag are taken to be unhealthy,
+// unless maxLag is 0.
+func NewReplicas(primary *sql.DB, maxLag time.Duration) *Replicas {
+	return &Replicas{primary: primary, maxLag: maxLag}
+}
+
+// Add adds a replica, with name (for logs), to r. The replica is taken to be
+// healthy until it fails a health check. Add must not be called after r is
+// first used.
+func (r *Replicas) Add(name string, db *sql.DB) {
+	rep := &replica{name: name, db: db}
+	rep.healthy.Store(true)
+	r.replicas = append(r.replicas, rep)
+}
+
+// Read returns the next healthy replica, or the primary database if there's
+// none.
+func (r *Replicas) Read() *sql.DB {
+	n := uint64(len(r.replicas))
+	if n == 0 {
+		return r.primary
+	}
+	start := r.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		if rep := r.replicas[(start+i)%n]; rep.healthy.Load() {
+			return rep.db
+		}
+	}
+	return r.primary
+}
+
+// Healthy returns the number of healthy replicas of r.
+func (r *Replicas) Healthy() int {
+	n := 0
+	for _, rep := range r.replicas {
+		if rep.healthy.Load() {
+			n++
+		}
+	}
+	return n
+}
+
+// CheckHealth checks whether each replica is reachable, and, if there's a
+// maximum lag, whether it's replicating without lagging behind by more than
+// that. It returns the errors of the replicas that are not healthy.
+func (r *Replicas) CheckHealth(ctx context.Context) error {
+	var errs []error
+	for _, rep := range r.replicas {
+		err := rep.db.PingContext(ctx)
+		if err == nil && r.maxLag > 0 {
+			var lag time.Duration
+			if lag, err = replicationLag(ctx, rep.db); err == nil && lag > r.maxLag {
+				err = fmt.Errorf("replication lag of %v", lag)
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("replica %s: %w", rep.name, err))
+			if rep.healthy.Swap(false) {
+				log.Printf("Database replica %s is unhealthy: %v\n", rep.name, err)
+			}
+		} else if !rep.healthy.Swap(true) {
+			log.Printf("Database replica %s is healthy again\n", rep.name)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// replicationLag returns how far the replica db lags behind its primary.
+func replicationLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	rows, err := db.QueryContext(ctx, "SHOW SLAVE STATUS")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		return 0, errors.New("not a replica")
+	}
+	values := make([]sql.RawBytes, len(cols))
+	dest := make([]any, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+	for i, col := range cols {
+		if col != "Seconds_Behind_Master" {
+			continue
+		}
+		if values[i] == nil {
+			return 0, errors.New("not replicating")
+		}
+		seconds, err := strconv.Atoi(string(values[i]))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return 0, errors.New("no Seconds_Behind_Master column")
+}
+
+// Close closes the connections to the replicas of r (but not to the
+// primary).
+func (r *Replicas) Close() error {
+	var errs []error
+	for _, rep := range r.replicas {
+		errs = append(errs, rep.db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+var readReplicas atomic.Pointer[Replicas]
+
+// SetReadReplicas sets the read replicas that ReadDB routes reads to. If r is
+// nil, reads are not routed.
+func SetReadReplicas(r *Replicas) {
+	readReplicas.Store(r)
+}
+
+// ReadDB returns the database on which to run a read-only query that can
+// stand to be a little stale: a healthy replica of db, if it has any (see
+// SetReadReplicas), and db otherwise. Reads that must see writes just made
+// (the checks before a write, say) must not use it.
+func ReadDB(db *sql.DB) *sql.DB {
+	if r := readReplicas.Load(); r != nil && r.primary == db {
+		return r.Read()
+	}
+	return db
+}
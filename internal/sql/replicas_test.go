@@ -0,0 +1,57 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+// downConnector is a driver.Connector of a database that can't be reached.
+type downConnector struct{}
+
+func (downConnector) Connect(context.Context) (driver.Conn, error) {
+	return nil, errors.New("unreachable")
+}
+
+func (downConnector) Driver() driver.Driver { return nil }
+
+func TestReplicas(t *testing.T) {
+	primary := sql.OpenDB(downConnector{})
+	r := NewReplicas(primary, 0)
+	if db := r.Read(); db != primary {
+		t.Error("read from a replica when there's none")
+	}
+
+	a, b := sql.OpenDB(downConnector{}), sql.OpenDB(downConnector{})
+	r.Add("a", a)
+	r.Add("b", b)
+	seen := make(map[*sql.DB]int)
+	for i := 0; i < 4; i++ {
+		seen[r.Read()]++
+	}
+	if seen[a] != 2 || seen[b] != 2 {
+		t.Errorf("reads were not spread over the replicas: %v", seen)
+	}
+
+	SetReadReplicas(r)
+	defer SetReadReplicas(nil)
+	other := sql.OpenDB(downConnector{})
+	if db := ReadDB(other); db != other {
+		t.Error("routed the reads of a database with no replicas")
+	}
+	if db := ReadDB(primary); db != a && db != b {
+		t.Error("did not route the reads of the primary to a replica")
+	}
+
+	if err := r.CheckHealth(context.Background()); err == nil {
+		t.Error("got no error for unreachable replicas")
+	}
+	if n := r.Healthy(); n != 0 {
+		t.Errorf("got %d healthy replicas, want 0", n)
+	}
+	if db := ReadDB(primary); db != primary {
+		t.Error("read from an unhealthy replica")
+	}
+}
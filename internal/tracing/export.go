@@ -0,0 +1,316 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// queueSize is the most spans waiting to be exported; spans ended while
+	// the queue is full are dropped.
+	queueSize = 4096
+
+	// batchSize is the most spans exported in a request.
+	batchSize = 512
+
+	// exportInterval is the longest a span waits to be exported.
+	exportInterval = 5 * time.Second
+
+	// exportTimeout is the timeout of an export request.
+	exportTimeout = 10 * time.Second
+)
+
+// Options are the options of tracing.
+type Options struct {
+	// Endpoint is the base URL of the OTLP/HTTP receiver of an OpenTelemetry
+	// collector (say, http://localhost:4318). Spans are posted to its
+	// /v1/traces path, in the JSON encoding of OTLP.
+	Endpoint string
+
+	// ServiceName is the service.name resource attribute of the spans.
+	ServiceName string
+
+	// SampleRatio is the fraction, in [0, 1], of traces that are recorded.
+	SampleRatio float64
+
+	// Client is the client spans are exported with. If nil,
+	// http.DefaultClient.
+	Client *http.Client
+}
+
+type tracer struct {
+	opts  Options
+	url   string
+	queue chan *Span
+
+	flushc  chan chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+var (
+	tracerMu      sync.RWMutex // guards currentTracer
+	currentTracer *tracer
+)
+
+func getTracer() *tracer {
+	tracerMu.RLock()
+	defer tracerMu.RUnlock()
+	return currentTracer
+}
+
+// Setup turns tracing on, with spans exported as per opts, and returns a
+// function that turns it off, after exporting the spans that have ended (or
+// until ctx is done). It's to be called once.
+func Setup(opts Options) (shutdown func(ctx context.Context) error, err error) {
+	if opts.Endpoint == "" {
+		return nil, errors.New("tracing: no endpoint")
+	}
+	if opts.SampleRatio < 0 || opts.SampleRatio > 1 {
+		return nil, fmt.Errorf("tracing: sample ratio %v is not in [0, 1]", opts.SampleRatio)
+	}
+	if opts.ServiceName == "" {
+		opts.ServiceName = "discuit"
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	t := &tracer{
+		opts:    opts,
+		url:     strings.TrimSuffix(opts.Endpoint, "/") + "/v1/traces",
+		queue:   make(chan *Span, queueSize),
+		flushc:  make(chan chan struct{}),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go t.run()
+
+	tracerMu.Lock()
+	currentTracer = t
+	tracerMu.Unlock()
+
+	return func(ctx context.Context) error {
+		tracerMu.Lock()
+		if currentTracer == t {
+			currentTracer = nil
+		}
+		tracerMu.Unlock()
+		close(t.stop)
+		select {
+		case <-t.stopped:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}, nil
+}
+
+// flush exports the spans that have ended, and waits until they're exported.
+// It's for tests.
+func (t *tracer) flush() {
+	done := make(chan struct{})
+	t.flushc <- done
+	<-done
+}
+
+func (t *tracer) sample() bool {
+	var b [8]byte
+	rand.Read(b[:])
+	return sampleUint64(t.opts.SampleRatio, binary.LittleEndian.Uint64(b[:]))
+}
+
+// export queues s to be exported.
+func (t *tracer) export(s *Span) {
+	select {
+	case t.queue <- s:
+	default:
+		// Dropped, rather than holding up the request that ended it.
+	}
+}
+
+func (t *tracer) run() {
+	defer close(t.stopped)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	send := func() {
+		if len(batch) > 0 {
+			if err := t.send(batch); err != nil {
+				slog.Error("Error exporting traces", "spans", len(batch), "error", err)
+			}
+			batch = nil
+		}
+	}
+	drain := func() {
+		for {
+			select {
+			case s := <-t.queue:
+				if batch = append(batch, s); len(batch) >= batchSize {
+					send()
+				}
+			default:
+				send()
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case s := <-t.queue:
+			if batch = append(batch, s); len(batch) >= batchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case done := <-t.flushc:
+			drain()
+			close(done)
+		case <-t.stop:
+			drain()
+			return
+		}
+	}
+}
+
+// send posts spans to the collector.
+func (t *tracer) send(spans []*Span) error {
+	body, err := json.Marshal(t.encode(spans))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := t.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("tracing: collector responded with %s: %s", res.Status, bytes.TrimSpace(msg))
+	}
+	io.Copy(io.Discard, res.Body)
+	return nil
+}
+
+// The JSON encoding of OTLP (see the opentelemetry-proto repository). IDs are
+// in hex, and 64-bit integers are strings.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 2 is an error.
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+func otlpAttrs(attrs []Attr) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		var v otlpAnyValue
+		switch x := a.Value.(type) {
+		case string:
+			v.StringValue = &x
+		case int64:
+			s := strconv.FormatInt(x, 10)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &x
+		case bool:
+			v.BoolValue = &x
+		default:
+			s := fmt.Sprint(x)
+			v.StringValue = &s
+		}
+		kvs = append(kvs, otlpKeyValue{Key: a.Key, Value: v})
+	}
+	return kvs
+}
+
+func (t *tracer) encode(spans []*Span) otlpRequest {
+	out := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		s.mu.Lock()
+		out[i] = otlpSpan{
+			TraceID:           s.sc.traceID.String(),
+			SpanID:            s.sc.spanID.String(),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        otlpAttrs(s.attrs),
+		}
+		if s.parentID != (SpanID{}) {
+			out[i].ParentSpanID = s.parentID.String()
+		}
+		if s.failed {
+			out[i].Status = &otlpStatus{Code: 2, Message: s.errMsg}
+		}
+		s.mu.Unlock()
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttrs([]Attr{String("service.name", t.opts.ServiceName)})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "github.com/discuitnet/discuit"}, Spans: out}},
+	}}}
+}
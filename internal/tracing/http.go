@@ -0,0 +1,30 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+)
+
+// The W3C Trace Context header that carries the span a request is made in.
+const traceparentHeader = "traceparent"
+
+// Extract returns ctx carrying the remote span of the traceparent header in h,
+// if there's a valid one, so that the spans started in it (see Start) are of
+// its trace. Whether the trace is sampled is decided here regardless of the
+// flags of the header, lest clients have every request of theirs traced.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	t := getTracer()
+	if t == nil {
+		return ctx
+	}
+	v := h.Get(traceparentHeader)
+	if v == "" {
+		return ctx
+	}
+	sc, err := parseTraceparent(v)
+	if err != nil {
+		return ctx
+	}
+	sc.sampled = t.sample()
+	return context.WithValue(ctx, contextKey{}, sc)
+}
@@ -0,0 +1,260 @@
+// Package tracing records traces of the work done for requests (the handling
+// of the request, the queries it runs, the S3 operations and LLM calls it
+// makes, and so on) as spans, and exports them to an OpenTelemetry collector
+// over OTLP/HTTP (see Setup).
+//
+// Tracing is off until Setup is called. While it's off, and for traces that
+// are not sampled, Start returns a nil span, and all the methods of a nil span
+// do nothing, so instrumented code needn't check:
+//
+//	ctx, span := tracing.Start(ctx, tracing.KindClient, "S3 GetObject")
+//	defer func() { span.Finish(err) }()
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SpanKind is the kind of a span (as in OTLP).
+type SpanKind int
+
+// The kinds of spans.
+const (
+	KindInternal SpanKind = 1 // Work within the process.
+	KindServer   SpanKind = 2 // The handling of an incoming request.
+	KindClient   SpanKind = 3 // An outgoing request (a query, an S3 operation, an API call).
+)
+
+// Attr is an attribute of a span.
+type Attr struct {
+	Key   string
+	Value any // A string, an int64, a float64, or a bool.
+}
+
+// String returns a string attribute.
+func String(key, value string) Attr {
+	return Attr{key, value}
+}
+
+// Int returns an integer attribute.
+func Int(key string, value int) Attr {
+	return Attr{key, int64(value)}
+}
+
+// Float returns a floating-point attribute.
+func Float(key string, value float64) Attr {
+	return Attr{key, value}
+}
+
+// Bool returns a boolean attribute.
+func Bool(key string, value bool) Attr {
+	return Attr{key, value}
+}
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// spanContext identifies a span, and carries the sampling decision of its
+// trace to the spans started in it.
+type spanContext struct {
+	traceID TraceID
+	spanID  SpanID
+	sampled bool
+}
+
+type contextKey struct{}
+
+func fromContext(ctx context.Context) (spanContext, bool) {
+	sc, ok := ctx.Value(contextKey{}).(spanContext)
+	return sc, ok
+}
+
+// Span is an operation of a trace. A nil span is a span that's not recorded.
+// It's safe for concurrent use.
+type Span struct {
+	tracer   *tracer
+	sc       spanContext
+	parentID SpanID
+	name     string
+	kind     SpanKind
+	start    time.Time
+
+	mu        sync.Mutex
+	end       time.Time
+	attrs     []Attr
+	errMsg    string
+	failed    bool
+	discarded bool
+}
+
+// Start starts a span named name, of kind, as a child of the span of ctx (or
+// of the remote span extracted into it, see Extract), if any, and returns it
+// along with a context carrying it. The span is to be ended (see Span.End and
+// Span.Finish).
+func Start(ctx context.Context, kind SpanKind, name string, attrs ...Attr) (context.Context, *Span) {
+	t := getTracer()
+	if t == nil {
+		return ctx, nil
+	}
+	parent, hasParent := fromContext(ctx)
+	sc := spanContext{spanID: newSpanID()}
+	if hasParent {
+		sc.traceID, sc.sampled = parent.traceID, parent.sampled
+	} else {
+		sc.traceID, sc.sampled = newTraceID(), t.sample()
+	}
+	ctx = context.WithValue(ctx, contextKey{}, sc)
+	if !sc.sampled {
+		return ctx, nil
+	}
+	s := &Span{tracer: t, sc: sc, name: name, kind: kind, start: time.Now()}
+	if hasParent {
+		s.parentID = parent.spanID
+	}
+	s.attrs = append(s.attrs, attrs...)
+	return ctx, s
+}
+
+// TraceIDFromContext returns the ID of the trace of the span of ctx, and
+// whether there's one that's sampled.
+func TraceIDFromContext(ctx context.Context) (TraceID, bool) {
+	sc, ok := fromContext(ctx)
+	return sc.traceID, ok && sc.sampled
+}
+
+// SetName renames the span (say, once the route of a request is known).
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+// SetAttributes adds attrs to the span.
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// RecordError marks the span as failed with err, if err is not nil.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed, s.errMsg = true, err.Error()
+}
+
+// SetFailed marks the span as failed, with message.
+func (s *Span) SetFailed(message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed, s.errMsg = true, message
+}
+
+// Discard drops the span, so that it's not exported when it ends (say,
+// because the operation it's of turned out not to have been done).
+func (s *Span) Discard() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.discarded = true
+}
+
+// End ends the span, and queues it for export. Calls after the first do
+// nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	discarded := s.discarded
+	s.mu.Unlock()
+	if !discarded {
+		s.tracer.export(s)
+	}
+}
+
+// Finish records err (see RecordError) and ends the span.
+func (s *Span) Finish(err error) {
+	s.RecordError(err)
+	s.End()
+}
+
+func newTraceID() (id TraceID) {
+	rand.Read(id[:])
+	return
+}
+
+func newSpanID() (id SpanID) {
+	rand.Read(id[:])
+	return
+}
+
+// sampleUint64 reports whether a trace is sampled, given a sample ratio in
+// [0, 1] and a uniformly random number.
+func sampleUint64(ratio float64, r uint64) bool {
+	if ratio >= 1 {
+		return true
+	}
+	if ratio <= 0 {
+		return false
+	}
+	return r < uint64(ratio*math.MaxUint64)
+}
+
+// parseTraceparent parses the value of a traceparent header.
+func parseTraceparent(v string) (spanContext, error) {
+	var sc spanContext
+	// version "-" trace-id "-" parent-id "-" trace-flags
+	if len(v) < 55 || v[2] != '-' || v[35] != '-' || v[52] != '-' || (len(v) > 55 && v[55] != '-') {
+		return sc, fmt.Errorf("tracing: malformed traceparent %q", v)
+	}
+	if v[:2] == "ff" {
+		return sc, fmt.Errorf("tracing: invalid traceparent version %q", v[:2])
+	}
+	if _, err := hex.Decode(sc.traceID[:], []byte(v[3:35])); err != nil {
+		return sc, fmt.Errorf("tracing: malformed trace ID in traceparent: %w", err)
+	}
+	if _, err := hex.Decode(sc.spanID[:], []byte(v[36:52])); err != nil {
+		return sc, fmt.Errorf("tracing: malformed parent ID in traceparent: %w", err)
+	}
+	if sc.traceID == (TraceID{}) || sc.spanID == (SpanID{}) {
+		return sc, fmt.Errorf("tracing: zero ID in traceparent %q", v)
+	}
+	flags, err := strconv.ParseUint(v[53:55], 16, 8)
+	if err != nil {
+		return sc, fmt.Errorf("tracing: malformed flags in traceparent: %w", err)
+	}
+	sc.sampled = flags&1 == 1
+	return sc, nil
+}
@@ -0,0 +1,196 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestDisabled(t *testing.T) {
+	ctx, span := Start(context.Background(), KindInternal, "op")
+	if span != nil {
+		t.Fatal("Start returned a span with tracing off")
+	}
+	if _, ok := TraceIDFromContext(ctx); ok {
+		t.Error("TraceIDFromContext reported a trace with tracing off")
+	}
+	// The methods of nil spans do nothing.
+	span.SetName("x")
+	span.SetAttributes(String("k", "v"))
+	span.Finish(errors.New("err"))
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		v       string
+		ok      bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false, false},
+		{"", false, false},
+	}
+	for _, test := range tests {
+		sc, err := parseTraceparent(test.v)
+		if (err == nil) != test.ok {
+			t.Errorf("parseTraceparent(%q) error = %v, want ok = %v", test.v, err, test.ok)
+			continue
+		}
+		if err == nil {
+			if sc.sampled != test.sampled {
+				t.Errorf("parseTraceparent(%q).sampled = %v, want %v", test.v, sc.sampled, test.sampled)
+			}
+			if got := sc.traceID.String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+				t.Errorf("parseTraceparent(%q).traceID = %s", test.v, got)
+			}
+		}
+	}
+}
+
+func TestSampleUint64(t *testing.T) {
+	tests := []struct {
+		ratio float64
+		r     uint64
+		want  bool
+	}{
+		{1, math.MaxUint64, true},
+		{0, 0, false},
+		{0.5, 0, true},
+		{0.5, math.MaxUint64 / 4, true},
+		{0.5, math.MaxUint64 / 4 * 3, false},
+	}
+	for _, test := range tests {
+		if got := sampleUint64(test.ratio, test.r); got != test.want {
+			t.Errorf("sampleUint64(%v, %v) = %v, want %v", test.ratio, test.r, got, test.want)
+		}
+	}
+}
+
+// collector is an OTLP/HTTP receiver that keeps the spans posted to it.
+type collector struct {
+	mu       sync.Mutex
+	spans    []otlpSpan
+	services []string
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var req otlpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, kv := range rs.Resource.Attributes {
+			if kv.Key == "service.name" {
+				c.services = append(c.services, *kv.Value.StringValue)
+			}
+		}
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+}
+
+func setupTest(t *testing.T, ratio float64) *collector {
+	c := &collector{}
+	srv := httptest.NewServer(c)
+	t.Cleanup(srv.Close)
+	shutdown, err := Setup(Options{Endpoint: srv.URL + "/", ServiceName: "test", SampleRatio: ratio})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { shutdown(context.Background()) })
+	return c
+}
+
+func TestExport(t *testing.T) {
+	c := setupTest(t, 1)
+
+	header := http.Header{}
+	header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	ctx := Extract(context.Background(), header)
+
+	ctx, root := Start(ctx, KindServer, "GET /api/posts", String("http.method", "GET"))
+	if root == nil {
+		t.Fatal("Start returned no span with a sample ratio of 1")
+	}
+	_, child := Start(ctx, KindClient, "db query", Int("db.rows", 3), Bool("cached", false), Float("f", 0.5))
+	child.Finish(errors.New("deadlock"))
+	root.SetAttributes(Int("http.status_code", 200))
+	root.End()
+	root.End() // Not exported twice.
+
+	_, discarded := Start(ctx, KindClient, "db exec")
+	discarded.Discard()
+	discarded.End()
+
+	getTracer().flush()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(c.spans))
+	}
+	if c.services[0] != "test" {
+		t.Errorf("service.name = %q, want test", c.services[0])
+	}
+	got := map[string]otlpSpan{}
+	for _, s := range c.spans {
+		got[s.Name] = s
+	}
+	r, ch := got["GET /api/posts"], got["db query"]
+	if r.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || ch.TraceID != r.TraceID {
+		t.Errorf("trace IDs = %s, %s; want those of the traceparent", r.TraceID, ch.TraceID)
+	}
+	if r.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("root parent = %s, want the remote span", r.ParentSpanID)
+	}
+	if ch.ParentSpanID != r.SpanID {
+		t.Errorf("child parent = %s, want %s", ch.ParentSpanID, r.SpanID)
+	}
+	if r.Kind != KindServer || ch.Kind != KindClient {
+		t.Errorf("kinds = %d, %d", r.Kind, ch.Kind)
+	}
+	if ch.Status == nil || ch.Status.Code != 2 || ch.Status.Message != "deadlock" {
+		t.Errorf("child status = %+v, want an error", ch.Status)
+	}
+	if r.Status != nil {
+		t.Errorf("root status = %+v, want none", r.Status)
+	}
+	if len(ch.Attributes) != 3 || *ch.Attributes[0].Value.IntValue != "3" || !(*ch.Attributes[2].Value.DoubleValue == 0.5) {
+		t.Errorf("child attributes = %+v", ch.Attributes)
+	}
+}
+
+func TestNotSampled(t *testing.T) {
+	c := setupTest(t, 0)
+
+	ctx, root := Start(context.Background(), KindServer, "GET /")
+	if root != nil {
+		t.Fatal("Start returned a span with a sample ratio of 0")
+	}
+	if _, child := Start(ctx, KindClient, "db query"); child != nil {
+		t.Fatal("Start returned a span in a trace not sampled")
+	}
+	getTracer().flush()
+	if len(c.spans) != 0 {
+		t.Errorf("got %d spans, want none", len(c.spans))
+	}
+}
@@ -0,0 +1,155 @@
+// Package votebuffer buffers, in Redis, the changes to the vote counters of
+// hot posts, so that they're written to the database in batches instead of
+// with every vote.
+//
+// Every vote on a post marks it (see Buffer.Hot); a post is hot while it gets
+// at least as many votes a minute as the threshold of the buffer. The changes
+// to the counters of hot posts are added up (see Buffer.Add) until they're
+// taken (see Buffer.Take) to be written to the database.
+package votebuffer
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/clock"
+	"github.com/discuitnet/discuit/internal/uid"
+	"github.com/gomodule/redigo/redis"
+)
+
+// hotWindow is the window votes are counted in to tell if a post is hot.
+const hotWindow = time.Minute
+
+// pendingKey is the key of the sorted set of the posts that have buffered
+// changes, scored by when they were first buffered.
+const pendingKey = "vb:pending"
+
+// Delta is a change to the vote counters of a post, and to the points of its
+// author.
+type Delta struct {
+	Upvotes, Downvotes int
+	AuthorPoints       int
+}
+
+// Points returns the change to the points of the post.
+func (d Delta) Points() int {
+	return d.Upvotes - d.Downvotes
+}
+
+// IsZero reports whether d changes nothing.
+func (d Delta) IsZero() bool {
+	return d == Delta{}
+}
+
+// Buffer is a buffer of the changes to the vote counters of hot posts. It's
+// safe for concurrent use, by any number of processes sharing a Redis server.
+type Buffer struct {
+	Clock clock.Clock // Windows of votes are by it. Defaults to the real clock.
+
+	pool      *redis.Pool
+	threshold int
+}
+
+// New returns a buffer, in the Redis server of pool, of the changes to the
+// counters of posts that get at least threshold votes a minute. If threshold
+// is 0, no post is hot, but the changes already buffered can still be taken.
+func New(pool *redis.Pool, threshold int) *Buffer {
+	return &Buffer{Clock: clock.Real{}, pool: pool, threshold: threshold}
+}
+
+func counterKeys(post string) [3]string {
+	return [3]string{"vb:" + post + ":up", "vb:" + post + ":down", "vb:" + post + ":author"}
+}
+
+// Hot records a vote on post, and reports whether post is hot (counting this
+// vote).
+func (b *Buffer) Hot(post uid.ID) (bool, error) {
+	if b.threshold <= 0 {
+		return false, nil
+	}
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	window := b.Clock.Now().Unix() / int64(hotWindow/time.Second)
+	key := "vb:rate:" + post.String() + ":" + strconv.FormatInt(window, 10)
+	n, err := redis.Int(conn.Do("INCR", key))
+	if err != nil {
+		return false, err
+	}
+	if n == 1 {
+		if _, err := conn.Do("EXPIRE", key, int(2*hotWindow/time.Second)); err != nil {
+			return false, err
+		}
+	}
+	return n >= b.threshold, nil
+}
+
+// Add adds d to the buffered changes of post.
+func (b *Buffer) Add(post uid.ID, d Delta) error {
+	if d.IsZero() {
+		return nil
+	}
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	keys := counterKeys(post.String())
+	conn.Send("MULTI")
+	conn.Send("INCRBY", keys[0], d.Upvotes)
+	conn.Send("INCRBY", keys[1], d.Downvotes)
+	conn.Send("INCRBY", keys[2], d.AuthorPoints)
+	conn.Send("ZADD", pendingKey, "NX", b.Clock.Now().Unix(), post.String())
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// Take removes the buffered changes of at most max posts (of all of them, if
+// max is 0), the longest pending first, and returns them.
+func (b *Buffer) Take(max int) (map[uid.ID]Delta, error) {
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	args := []any{pendingKey, "-inf", "+inf"}
+	if max > 0 {
+		args = append(args, "LIMIT", 0, max)
+	}
+	posts, err := redis.Strings(conn.Do("ZRANGEBYSCORE", args...))
+	if err != nil {
+		return nil, err
+	}
+
+	deltas := make(map[uid.ID]Delta, len(posts))
+	for _, post := range posts {
+		id, err := uid.FromString(post)
+		if err != nil {
+			conn.Do("ZREM", pendingKey, post)
+			continue
+		}
+		// Unmarked before its changes are taken, so that changes added in
+		// between mark it again rather than being left unmarked.
+		if _, err := conn.Do("ZREM", pendingKey, post); err != nil {
+			return deltas, err
+		}
+		keys := counterKeys(post)
+		conn.Send("MULTI")
+		for _, key := range keys {
+			conn.Send("GET", key)
+		}
+		conn.Send("DEL", keys[0], keys[1], keys[2])
+		values, err := redis.Values(conn.Do("EXEC"))
+		if err != nil {
+			return deltas, err
+		}
+		var counters [3]int
+		for i := range counters {
+			if values[i] != nil {
+				if counters[i], err = redis.Int(values[i], nil); err != nil {
+					return deltas, err
+				}
+			}
+		}
+		if d := (Delta{counters[0], counters[1], counters[2]}); !d.IsZero() {
+			deltas[id] = d
+		}
+	}
+	return deltas, nil
+}
@@ -0,0 +1,62 @@
+package votebuffer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/clock"
+	"github.com/discuitnet/discuit/internal/redistest"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestBufferHot(t *testing.T) {
+	c := clock.NewFake(time.Unix(1_700_000_040, 0))
+	b := New(redistest.NewServer().Pool(), 3)
+	b.Clock = c
+	post := uid.New()
+	for i, want := range []bool{false, false, true, true} {
+		if hot, err := b.Hot(post); err != nil || hot != want {
+			t.Fatalf("Hot after %d votes = %v, %v; want %v", i+1, hot, err, want)
+		}
+	}
+	c.Advance(hotWindow)
+	if hot, _ := b.Hot(post); hot {
+		t.Error("post still hot in the next window")
+	}
+}
+
+func TestBufferAddTake(t *testing.T) {
+	b := New(redistest.NewServer().Pool(), 1)
+	p1, p2 := uid.New(), uid.New()
+	adds := []struct {
+		post uid.ID
+		d    Delta
+	}{
+		{p1, Delta{Upvotes: 1, AuthorPoints: 1}},
+		{p1, Delta{Upvotes: -1, Downvotes: 1, AuthorPoints: -1}},
+		{p1, Delta{Upvotes: 1, AuthorPoints: 1}},
+		{p2, Delta{Downvotes: 1}},
+		{p2, Delta{Downvotes: -1}}, // Cancels out.
+	}
+	for _, a := range adds {
+		if err := b.Add(a.post, a.d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deltas, err := b.Take(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deltas) != 1 {
+		t.Fatalf("took the changes of %d posts, want 1: %v", len(deltas), deltas)
+	}
+	want := Delta{Upvotes: 1, Downvotes: 1, AuthorPoints: 1}
+	if got := deltas[p1]; got != want || got.Points() != 0 {
+		t.Errorf("changes of p1 = %+v, want %+v", got, want)
+	}
+
+	if deltas, err := b.Take(0); err != nil || len(deltas) != 0 {
+		t.Errorf("second Take = %v, %v; want nothing", deltas, err)
+	}
+}
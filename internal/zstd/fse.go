@@ -0,0 +1,164 @@
+package zstd
+
+// fseTable is an FSE table, built from a normalized distribution as decoders
+// build it (RFC 8878, 4.1.1), with, for encoding, the states that lead to
+// each state after each symbol.
+type fseTable struct {
+	log    uint
+	nbBits []uint8  // Of the states.
+	base   []uint16 // Of the states.
+	enc    [][]uint16
+	first  []uint16 // A state of each symbol.
+}
+
+// newFSETable returns the FSE table of the distribution norm (in which -1 is a
+// probability of "less than 1") of accuracy log.
+func newFSETable(norm []int16, log uint) *fseTable {
+	size := 1 << log
+	t := &fseTable{
+		log:    log,
+		nbBits: make([]uint8, size),
+		base:   make([]uint16, size),
+		enc:    make([][]uint16, len(norm)),
+		first:  make([]uint16, len(norm)),
+	}
+	symbols := make([]uint8, size)
+	next := make([]int, len(norm))
+	high := size - 1
+	for s, c := range norm {
+		if c == -1 {
+			symbols[high] = uint8(s)
+			high--
+			next[s] = 1
+		} else {
+			next[s] = int(c)
+		}
+	}
+	step := size>>1 + size>>3 + 3
+	pos := 0
+	for s, c := range norm {
+		for i := 0; i < int(c); i++ {
+			symbols[pos] = uint8(s)
+			pos = (pos + step) & (size - 1)
+			for pos > high {
+				pos = (pos + step) & (size - 1)
+			}
+		}
+	}
+
+	for s, c := range norm {
+		if c != 0 {
+			t.enc[s] = make([]uint16, size)
+		}
+	}
+	for u := 0; u < size; u++ {
+		s := symbols[u]
+		n := next[s]
+		next[s]++
+		nb := log - highBit(uint32(n))
+		t.nbBits[u] = uint8(nb)
+		t.base[u] = uint16(n<<nb - size)
+		// From the state u, decoders go to base plus the next nb bits, so
+		// encoders go from those states to u.
+		for y := int(t.base[u]); y < int(t.base[u])+1<<nb; y++ {
+			t.enc[s][y] = uint16(u)
+		}
+		t.first[s] = uint16(u)
+	}
+	return t
+}
+
+// encode writes the bits that lead from the state of s to state, and returns
+// the state of s.
+func (t *fseTable) encode(b *bitWriter, state uint16, s uint8) uint16 {
+	x := t.enc[s][state]
+	b.addBits(uint64(state-t.base[x]), uint(t.nbBits[x]))
+	return x
+}
+
+// normalizeCounts returns the distribution of count (whose sum is total) of
+// accuracy log. No probability is more than half (so that every state is
+// left with at least a bit), and so at least two symbols must be counted.
+func normalizeCounts(count []int, total int, log uint) []int16 {
+	size := 1 << log
+	half := size / 2
+	norm := make([]int16, len(count))
+	sum := 0
+	for s, c := range count {
+		if c == 0 {
+			continue
+		}
+		n := min(max(c*size/total, 1), half)
+		norm[s] = int16(n)
+		sum += n
+	}
+	for sum < size {
+		// Add to the symbol whose probability is the most below its count.
+		best := -1
+		for s, c := range count {
+			if c > 0 && int(norm[s]) < half && (best == -1 || c*int(norm[best]) > count[best]*int(norm[s])) {
+				best = s
+			}
+		}
+		norm[best]++
+		sum++
+	}
+	for sum > size {
+		best := -1
+		for s := range count {
+			if norm[s] > 1 && (best == -1 || norm[s] > norm[best]) {
+				best = s
+			}
+		}
+		norm[best]--
+		sum--
+	}
+	return norm
+}
+
+// appendNCount appends the description of the distribution norm (with no
+// probabilities of -1) of accuracy log (RFC 8878, 4.1.1).
+func appendNCount(dst []byte, norm []int16, log uint) []byte {
+	b := bitWriter{out: dst}
+	b.addBits(uint64(log-5), 4)
+	remaining := 1<<log + 1
+	threshold := 1 << log
+	nbBits := log + 1
+	prev0 := false
+	for s := 0; s < len(norm) && remaining > 1; {
+		if prev0 {
+			start := s
+			for norm[s] == 0 {
+				s++
+			}
+			for s >= start+24 {
+				start += 24
+				b.addBits(0xFFFF, 16)
+			}
+			for s >= start+3 {
+				start += 3
+				b.addBits(3, 2)
+			}
+			b.addBits(uint64(s-start), 2)
+		}
+		count := int(norm[s])
+		s++
+		maxv := 2*threshold - 1 - remaining
+		remaining -= count
+		count++
+		if count >= threshold {
+			count += maxv
+		}
+		if count < maxv {
+			b.addBits(uint64(count), nbBits-1)
+		} else {
+			b.addBits(uint64(count), nbBits)
+		}
+		prev0 = count == 1
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	return b.flush()
+}
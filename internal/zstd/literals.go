@@ -0,0 +1,308 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+const (
+	maxHuffmanBits = 11
+
+	// Literals sections shorter than this are left uncompressed.
+	minHuffmanLiterals = 32
+
+	// Literals sections up to this long are Huffman coded in one stream, and
+	// longer ones in four.
+	maxSingleStream = 1023
+
+	huffmanWeightsLog = 6
+)
+
+// Literals section types.
+const (
+	literalsRaw        = 0
+	literalsRLE        = 1
+	literalsCompressed = 2
+)
+
+// huffmanEncoder builds Huffman codes, keeping its buffers between blocks.
+type huffmanEncoder struct {
+	count   [256]int
+	lens    [256]uint8
+	weights [256]uint8
+	codes   [256]uint16
+
+	freq   []int
+	parent []int
+	depth  []int
+	syms   []int
+}
+
+// appendLiterals appends the literals section of lits to dst, Huffman coded if
+// that makes it smaller.
+func (e *encoder) appendLiterals(dst, lits []byte) []byte {
+	if len(lits) >= minHuffmanLiterals {
+		h := &e.huff
+		h.count = [256]int{}
+		for _, c := range lits {
+			h.count[c]++
+		}
+		if h.count[lits[0]] == len(lits) {
+			dst = appendLiteralsHeader(dst, literalsRLE, len(lits))
+			return append(dst, lits[0])
+		}
+		var ok bool
+		if e.tmp, ok = h.compress(e.tmp[:0], lits); ok {
+			return append(dst, e.tmp...)
+		}
+	}
+	dst = appendLiteralsHeader(dst, literalsRaw, len(lits))
+	return append(dst, lits...)
+}
+
+// appendLiteralsHeader appends the header of a raw or an RLE literals section
+// of n literals.
+func appendLiteralsHeader(dst []byte, typ, n int) []byte {
+	switch {
+	case n < 1<<5:
+		return append(dst, byte(typ|n<<3))
+	case n < 1<<12:
+		return append(dst, byte(typ|1<<2|n<<4), byte(n>>4))
+	default:
+		return append(dst, byte(typ|3<<2|n<<4), byte(n>>4), byte(n>>12))
+	}
+}
+
+// compress appends the compressed literals section of lits (whose symbols
+// h.count has counted) to dst. It returns false if the section would not be
+// smaller than the literals.
+func (h *huffmanEncoder) compress(dst, lits []byte) ([]byte, bool) {
+	maxSym := 255
+	for h.count[maxSym] == 0 {
+		maxSym--
+	}
+	h.buildLengths(maxSym)
+
+	maxBits := uint8(0)
+	for _, n := range h.lens[:maxSym+1] {
+		maxBits = max(maxBits, n)
+	}
+	for s, n := range h.lens[:maxSym+1] {
+		h.weights[s] = 0
+		if n > 0 {
+			h.weights[s] = maxBits + 1 - n
+		}
+	}
+	// Codes are assigned as decoders assign them: from the lowest weight up,
+	// and by symbol within weights.
+	code := uint16(0)
+	for w := uint8(1); w <= maxBits; w++ {
+		for s := 0; s <= maxSym; s++ {
+			if h.weights[s] == w {
+				h.codes[s] = code
+				code++
+			}
+		}
+		code >>= 1
+	}
+
+	// The header is written once the size of the rest is known.
+	var headerSize int
+	switch {
+	case len(lits) <= maxSingleStream:
+		headerSize = 3
+	case len(lits) < 1<<14:
+		headerSize = 4
+	default:
+		headerSize = 5
+	}
+	start := len(dst)
+	dst = append(dst, make([]byte, headerSize)...)
+
+	// The tree description: the weights of all symbols but the last (which
+	// is implied).
+	if maxSym <= 128 {
+		dst = append(dst, byte(127+maxSym))
+		for s := 0; s < maxSym; s += 2 {
+			w := h.weights[s] << 4
+			if s+1 < maxSym {
+				w |= h.weights[s+1]
+			}
+			dst = append(dst, w)
+		}
+	} else {
+		var ok bool
+		if dst, ok = appendFSEWeights(dst, h.weights[:maxSym]); !ok {
+			return dst[:start], false
+		}
+	}
+
+	if len(lits) <= maxSingleStream {
+		dst = h.appendStream(dst, lits)
+	} else {
+		jump := len(dst)
+		dst = append(dst, make([]byte, 6)...)
+		seg := (len(lits) + 3) / 4
+		for i := 0; i < 4; i++ {
+			n := len(dst)
+			dst = h.appendStream(dst, lits[i*seg:min((i+1)*seg, len(lits))])
+			if i < 3 {
+				if len(dst)-n > 0xFFFF {
+					return dst[:start], false
+				}
+				binary.LittleEndian.PutUint16(dst[jump+2*i:], uint16(len(dst)-n))
+			}
+		}
+	}
+
+	size := len(dst) - start - headerSize
+	if size+headerSize >= len(lits) {
+		return dst[:start], false
+	}
+	format, sizeBits := 0, 10
+	if headerSize == 4 {
+		format, sizeBits = 2, 14
+	} else if headerSize == 5 {
+		format, sizeBits = 3, 18
+	}
+	v := uint64(literalsCompressed) | uint64(format)<<2 | uint64(len(lits))<<4 | uint64(size)<<(4+sizeBits)
+	for i := 0; i < headerSize; i++ {
+		dst[start+i] = byte(v >> (8 * i))
+	}
+	return dst, true
+}
+
+// appendStream appends the Huffman coded stream of lits to dst. Streams are
+// read backward, so the literals are written from the last.
+func (h *huffmanEncoder) appendStream(dst, lits []byte) []byte {
+	b := bitWriter{out: dst}
+	for i := len(lits) - 1; i >= 0; i-- {
+		c := lits[i]
+		b.addBits(uint64(h.codes[c]), uint(h.lens[c]))
+	}
+	return b.close()
+}
+
+// buildLengths sets the code lengths (h.lens) of the symbols up to maxSym of
+// a Huffman code of h.count, at most maxHuffmanBits long. Codes that would
+// be too long are shortened by flattening the counts until they aren't.
+func (h *huffmanEncoder) buildLengths(maxSym int) {
+	h.freq = h.freq[:0]
+	h.syms = h.syms[:0]
+	for s, c := range h.count[:maxSym+1] {
+		h.lens[s] = 0
+		if c > 0 {
+			h.syms = append(h.syms, s)
+			h.freq = append(h.freq, c)
+		}
+	}
+	for {
+		if h.buildTree() <= maxHuffmanBits {
+			return
+		}
+		for i := range h.syms {
+			h.freq[i] = (h.freq[i] + 1) / 2
+		}
+	}
+}
+
+// buildTree builds the Huffman tree of the symbols h.syms with the counts
+// h.freq, sets their code lengths, and returns the longest.
+func (h *huffmanEncoder) buildTree() uint8 {
+	n := len(h.syms)
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return h.freq[order[i]] < h.freq[order[j]] })
+
+	// Nodes are the leaves (0 to n-1, in order) and then the internal nodes,
+	// which are made in increasing weight, so the two smallest nodes are
+	// always at the heads of the two queues.
+	weight := make([]int, 0, 2*n-1)
+	for _, i := range order {
+		weight = append(weight, h.freq[i])
+	}
+	h.parent = append(h.parent[:0], make([]int, 2*n-1)...)
+	leaf, node := 0, n
+	pop := func() int {
+		if leaf < n && (node >= len(weight) || weight[leaf] <= weight[node]) {
+			leaf++
+			return leaf - 1
+		}
+		node++
+		return node - 1
+	}
+	for len(weight) < 2*n-1 {
+		a, b := pop(), pop()
+		h.parent[a], h.parent[b] = len(weight), len(weight)
+		weight = append(weight, weight[a]+weight[b])
+	}
+
+	h.depth = append(h.depth[:0], make([]int, 2*n-1)...)
+	longest := 0
+	for i := 2*n - 2; i >= 0; i-- {
+		if i != 2*n-2 {
+			h.depth[i] = h.depth[h.parent[i]] + 1
+		}
+		if i < n {
+			h.lens[h.syms[order[i]]] = uint8(h.depth[i])
+			longest = max(longest, h.depth[i])
+		}
+	}
+	return uint8(min(longest, 255))
+}
+
+// appendFSEWeights appends the FSE compressed Huffman weights to dst (RFC
+// 8878, 4.2.1.2). It returns false if they can't be, or if they're too long.
+func appendFSEWeights(dst []byte, weights []uint8) ([]byte, bool) {
+	var count [maxHuffmanBits + 1]int
+	maxWeight, distinct := 0, 0
+	for _, w := range weights {
+		if count[w] == 0 {
+			distinct++
+		}
+		count[w]++
+		maxWeight = max(maxWeight, int(w))
+	}
+	if distinct < 2 {
+		return dst, false
+	}
+	norm := normalizeCounts(count[:maxWeight+1], len(weights), huffmanWeightsLog)
+	t := newFSETable(norm, huffmanWeightsLog)
+
+	start := len(dst)
+	dst = appendNCount(append(dst, 0), norm, huffmanWeightsLog)
+
+	// Weights are coded with two interleaved states, the first of which
+	// codes the first weight, and as decoders stop when the stream runs out
+	// (which every state transition reads at least a bit of), the last
+	// weights are coded by the initial states.
+	b := bitWriter{out: dst}
+	n := len(weights)
+	var s1, s2 uint16
+	if n%2 == 1 {
+		s1 = t.first[weights[n-1]]
+		s2 = t.first[weights[n-2]]
+		s1 = t.encode(&b, s1, weights[n-3])
+		n -= 3
+	} else {
+		s2 = t.first[weights[n-1]]
+		s1 = t.first[weights[n-2]]
+		n -= 2
+	}
+	for ; n > 0; n -= 2 {
+		s2 = t.encode(&b, s2, weights[n-1])
+		s1 = t.encode(&b, s1, weights[n-2])
+	}
+	b.addBits(uint64(s2), huffmanWeightsLog)
+	b.addBits(uint64(s1), huffmanWeightsLog)
+	dst = b.close()
+
+	size := len(dst) - start - 1
+	if size >= 128 {
+		return dst[:start], false
+	}
+	dst[start] = byte(size)
+	return dst, true
+}
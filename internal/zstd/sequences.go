@@ -0,0 +1,119 @@
+package zstd
+
+// The predefined distributions of the codes of literal lengths, match
+// lengths, and offsets (RFC 8878, 3.1.1.3.2.2).
+var (
+	llTable = newFSETable([]int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}, 6)
+	mlTable = newFSETable([]int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}, 6)
+	ofTable = newFSETable([]int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}, 5)
+)
+
+// The baselines and numbers of extra bits of the codes of literal lengths
+// from 16, and of match lengths from 32 (below which the codes are the
+// lengths, less 3 for match lengths).
+var (
+	llBase = []uint32{16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536}
+	llBits = []uint8{1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	mlBase = []uint32{35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051, 4099, 8195, 16387, 32771, 65539}
+	mlBits = []uint8{1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+)
+
+// seqCode is a value coded as a code and extra bits.
+type seqCode struct {
+	code  uint8
+	extra uint32
+	nbits uint8
+}
+
+// lengthCode returns the code of v, the first of whose codes with extra bits
+// is first, with base and nbits.
+func lengthCode(v uint32, first uint8, base []uint32, nbits []uint8) seqCode {
+	i := len(base) - 1
+	for base[i] > v {
+		i--
+	}
+	return seqCode{code: first + uint8(i), extra: v - base[i], nbits: nbits[i]}
+}
+
+func literalLengthCode(n uint32) seqCode {
+	if n < 16 {
+		return seqCode{code: uint8(n)}
+	}
+	return lengthCode(n, 16, llBase, llBits)
+}
+
+func matchLengthCode(n uint32) seqCode {
+	if n < 35 {
+		return seqCode{code: uint8(n - 3)}
+	}
+	return lengthCode(n, 32, mlBase, mlBits)
+}
+
+// offsetCode returns the code of offset, which is never a repeat offset (as
+// those are not used).
+func offsetCode(offset uint32) seqCode {
+	v := offset + 3
+	n := highBit(v)
+	return seqCode{code: uint8(n), extra: v - 1<<n, nbits: uint8(n)}
+}
+
+// appendSequences appends the sequences section of seqs to dst, coded with
+// the predefined tables.
+func (e *encoder) appendSequences(dst []byte, seqs []sequence) []byte {
+	n := len(seqs)
+	switch {
+	case n < 128:
+		dst = append(dst, byte(n))
+	case n < 0x7F00:
+		dst = append(dst, byte(n>>8)+128, byte(n))
+	default:
+		dst = append(dst, 255, byte(n-0x7F00), byte((n-0x7F00)>>8))
+	}
+	if n == 0 {
+		return dst
+	}
+	dst = append(dst, 0) // The predefined modes of all three tables.
+
+	// Sequences are coded from the last, as decoders read the stream
+	// backward. The extra bits of a sequence are read after the state
+	// transitions to it, and the initial states are of the last sequence.
+	b := bitWriter{out: dst}
+	ll := literalLengthCode(seqs[n-1].litLen)
+	ml := matchLengthCode(seqs[n-1].matchLen)
+	of := offsetCode(seqs[n-1].offset)
+	llState := llTable.first[ll.code]
+	mlState := mlTable.first[ml.code]
+	ofState := ofTable.first[of.code]
+	addExtraBits(&b, ll, ml, of)
+	for i := n - 2; i >= 0; i-- {
+		ll = literalLengthCode(seqs[i].litLen)
+		ml = matchLengthCode(seqs[i].matchLen)
+		of = offsetCode(seqs[i].offset)
+		ofState = ofTable.encode(&b, ofState, of.code)
+		mlState = mlTable.encode(&b, mlState, ml.code)
+		llState = llTable.encode(&b, llState, ll.code)
+		addExtraBits(&b, ll, ml, of)
+	}
+	b.addBits(uint64(mlState), mlTable.log)
+	b.addBits(uint64(ofState), ofTable.log)
+	b.addBits(uint64(llState), llTable.log)
+	return b.close()
+}
+
+func addExtraBits(b *bitWriter, ll, ml, of seqCode) {
+	b.addBits(uint64(ll.extra), uint(ll.nbits))
+	b.addBits(uint64(ml.extra), uint(ml.nbits))
+	b.addBits(uint64(of.extra), uint(of.nbits))
+}
@@ -0,0 +1,271 @@
+// Package zstd is a Zstandard (RFC 8878) compressor. It's a small and fast
+// one, made for compressing HTTP responses: matches are found with a hash
+// table (within blocks, not across them), literals are Huffman coded, and
+// sequences are coded with the predefined FSE tables of the format. There's
+// no decompressor; any conforming one decodes the output.
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+const (
+	frameMagic   = 0xFD2FB528
+	windowLog    = 17
+	maxBlockSize = 1 << windowLog // Of both the window and blocks.
+
+	minMatch = 4
+	hashLog  = 15
+
+	// Blocks smaller than this are not worth compressing.
+	minCompressSize = 16
+)
+
+// Block types.
+const (
+	blockRaw        = 0
+	blockCompressed = 2
+)
+
+var errClosed = errors.New("zstd: write to a closed writer")
+
+// Writer is an io.WriteCloser that writes a Zstandard frame of the data
+// written to it. The frame is complete once the Writer is closed.
+type Writer struct {
+	w           io.Writer
+	buf         []byte // Data not yet written in a block.
+	out         []byte
+	enc         encoder
+	wroteHeader bool
+	closed      bool
+	err         error
+}
+
+// NewWriter returns a Writer that writes compressed data to w.
+func NewWriter(w io.Writer) *Writer {
+	z := &Writer{}
+	z.Reset(w)
+	return z
+}
+
+// Reset discards the state of z and makes it write to w, as if it were
+// returned by NewWriter (but without allocating anew).
+func (z *Writer) Reset(w io.Writer) {
+	z.w = w
+	z.buf = z.buf[:0]
+	z.wroteHeader = false
+	z.closed = false
+	z.err = nil
+}
+
+// Write compresses p. The data is written to the underlying writer in blocks,
+// as the blocks fill up, and when z is flushed or closed.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.closed {
+		return 0, errClosed
+	}
+	n := len(p)
+	for len(p) > 0 {
+		if len(z.buf) == maxBlockSize {
+			if err := z.writeBlock(false); err != nil {
+				return n - len(p), err
+			}
+		}
+		k := min(len(p), maxBlockSize-len(z.buf))
+		z.buf = append(z.buf, p[:k]...)
+		p = p[k:]
+	}
+	return n, nil
+}
+
+// Flush writes the data written so far to the underlying writer, so that it
+// can be decompressed (without the rest of the frame).
+func (z *Writer) Flush() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed || (z.wroteHeader && len(z.buf) == 0) {
+		return nil
+	}
+	return z.writeBlock(false)
+}
+
+// Close writes the rest of the data and ends the frame. It doesn't close the
+// underlying writer.
+func (z *Writer) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+	return z.writeBlock(true)
+}
+
+func (z *Writer) writeBlock(last bool) error {
+	z.out = z.out[:0]
+	if !z.wroteHeader {
+		// The frame header: no content size (as it's not known), and so a
+		// window descriptor.
+		z.out = binary.LittleEndian.AppendUint32(z.out, frameMagic)
+		z.out = append(z.out, 0, (windowLog-10)<<3)
+		z.wroteHeader = true
+	}
+	z.out = z.enc.appendBlock(z.out, z.buf, last)
+	z.buf = z.buf[:0]
+	if _, err := z.w.Write(z.out); err != nil {
+		z.err = err
+		return err
+	}
+	return nil
+}
+
+// sequence is a run of literals followed by a match.
+type sequence struct {
+	litLen, matchLen, offset uint32
+}
+
+// encoder compresses blocks.
+type encoder struct {
+	table [1 << hashLog]int32 // Positions (plus cur) of 4-byte hashes.
+	cur   int32               // Added to the positions in table of the current block.
+
+	lits []byte
+	seqs []sequence
+	tmp  []byte
+	huff huffmanEncoder
+}
+
+// appendBlock appends the block of src to dst, compressed if that makes it
+// smaller.
+func (e *encoder) appendBlock(dst, src []byte, last bool) []byte {
+	start := len(dst)
+	dst = append(dst, 0, 0, 0) // The block header.
+	if len(src) >= minCompressSize {
+		dst = e.compress(dst, src)
+		if n := len(dst) - start - 3; n < len(src) {
+			putBlockHeader(dst[start:], last, blockCompressed, n)
+			return dst
+		}
+		dst = dst[:start+3]
+	}
+	putBlockHeader(dst[start:], last, blockRaw, len(src))
+	return append(dst, src...)
+}
+
+func putBlockHeader(b []byte, last bool, typ, size int) {
+	h := uint32(size)<<3 | uint32(typ)<<1
+	if last {
+		h |= 1
+	}
+	b[0], b[1], b[2] = byte(h), byte(h>>8), byte(h>>16)
+}
+
+// compress appends the content of the compressed block of src to dst.
+func (e *encoder) compress(dst, src []byte) []byte {
+	e.findSequences(src)
+	dst = e.appendLiterals(dst, e.lits)
+	return e.appendSequences(dst, e.seqs)
+}
+
+func load32(b []byte, i int) uint32 {
+	return binary.LittleEndian.Uint32(b[i:])
+}
+
+func hash4(v uint32) uint32 {
+	return (v * 2654435761) >> (32 - hashLog)
+}
+
+// findSequences splits src into sequences (e.seqs) and literals (e.lits),
+// greedily taking the match of the previous occurrence of every 4 bytes.
+func (e *encoder) findSequences(src []byte) {
+	e.lits = e.lits[:0]
+	e.seqs = e.seqs[:0]
+	if int64(e.cur)+maxBlockSize > 1<<31-1 {
+		e.table = [1 << hashLog]int32{}
+		e.cur = 0
+	}
+	cur := int(e.cur)
+	defer func() { e.cur += int32(len(src)) }()
+
+	anchor, i := 0, 0
+	limit := len(src) - minMatch
+	for i <= limit {
+		v := load32(src, i)
+		h := hash4(v)
+		cand := int(e.table[h]) - cur
+		e.table[h] = int32(i + cur)
+		if cand < 0 || cand >= i || load32(src, cand) != v {
+			// Skip faster through data that doesn't match.
+			i += 1 + (i-anchor)>>6
+			continue
+		}
+		for i > anchor && cand > 0 && src[i-1] == src[cand-1] {
+			i--
+			cand--
+		}
+		n := minMatch
+		for i+n < len(src) && src[cand+n] == src[i+n] {
+			n++
+		}
+		e.lits = append(e.lits, src[anchor:i]...)
+		e.seqs = append(e.seqs, sequence{
+			litLen:   uint32(i - anchor),
+			matchLen: uint32(n),
+			offset:   uint32(i - cand),
+		})
+		end := i + n
+		for j := i + 1; j < end && j <= limit; j++ {
+			e.table[hash4(load32(src, j))] = int32(j + cur)
+		}
+		i, anchor = end, end
+	}
+	e.lits = append(e.lits, src[anchor:]...)
+}
+
+// bitWriter writes the bitstreams of the format: bits are written from the
+// least significant bit of each byte up, and streams that are read backward
+// end with a 1 bit.
+type bitWriter struct {
+	out   []byte
+	bits  uint64
+	nbits uint
+}
+
+// addBits writes the n low bits of v (n <= 32).
+func (b *bitWriter) addBits(v uint64, n uint) {
+	b.bits |= (v & (1<<n - 1)) << b.nbits
+	b.nbits += n
+	for b.nbits >= 8 {
+		b.out = append(b.out, byte(b.bits))
+		b.bits >>= 8
+		b.nbits -= 8
+	}
+}
+
+// flush writes the remaining bits, padded with zeros to a byte, and returns
+// the output.
+func (b *bitWriter) flush() []byte {
+	if b.nbits > 0 {
+		b.out = append(b.out, byte(b.bits))
+	}
+	b.bits, b.nbits = 0, 0
+	return b.out
+}
+
+// close ends a stream that's read backward and returns the output.
+func (b *bitWriter) close() []byte {
+	b.addBits(1, 1)
+	return b.flush()
+}
+
+func highBit(v uint32) uint {
+	return uint(bits.Len32(v)) - 1
+}
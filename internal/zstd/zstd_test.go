@@ -0,0 +1,143 @@
+package zstd
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// decompress decompresses data with the zstd command (the reference decoder).
+func decompress(t *testing.T, data []byte) []byte {
+	cmd := exec.Command("zstd", "-d", "-c")
+	cmd.Stdin = bytes.NewReader(data)
+	var out, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("zstd -d: %v: %s", err, stderr.String())
+	}
+	return out.Bytes()
+}
+
+func TestWriter(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd command not found")
+	}
+
+	r := rand.New(rand.NewSource(1))
+	var feed strings.Builder
+	for i := 0; i < 3000; i++ {
+		// Bytes over 128 make the Huffman weights FSE coded.
+		fmt.Fprintf(&feed, `{"id":"%x","title":"Post number %d","body":"Lorem ipsum dolor sit amet, %d ünïcödé 🎉","upvotes":%d},`,
+			r.Int63(), i, r.Intn(1000), r.Intn(50))
+	}
+	random := make([]byte, 300<<10)
+	r.Read(random)
+	skewed := make([]byte, 100<<10)
+	for i := range skewed {
+		skewed[i] = 'x'
+		if r.Intn(10) == 0 {
+			skewed[i] = byte(r.Intn(256))
+		}
+	}
+	tests := map[string][]byte{
+		"empty":  nil,
+		"short":  []byte("Hello, world!"),
+		"feed":   []byte(feed.String()),
+		"random": random,
+		"rle":    bytes.Repeat([]byte{'a'}, 200<<10),
+		"skewed": skewed,
+	}
+	for i := 0; i < 100; i++ {
+		b := make([]byte, r.Intn(4000))
+		alphabet := 1 + r.Intn(256)
+		for j := range b {
+			if j > 10 && r.Intn(3) == 0 {
+				b[j] = b[j-1-r.Intn(10)]
+			} else {
+				b[j] = byte(r.Intn(alphabet))
+			}
+		}
+		tests[fmt.Sprintf("random%d", i)] = b
+	}
+
+	var buf bytes.Buffer
+	z := NewWriter(nil)
+	for name, data := range tests {
+		buf.Reset()
+		z.Reset(&buf)
+		for p := data; len(p) > 0; {
+			n := min(len(p), 70000)
+			if _, err := z.Write(p[:n]); err != nil {
+				t.Fatal(err)
+			}
+			p = p[n:]
+		}
+		if err := z.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := decompress(t, buf.Bytes()); !bytes.Equal(got, data) {
+			t.Errorf("%s: decompressed data (%d bytes) differs from the original (%d bytes)", name, len(got), len(data))
+		}
+		if name == "feed" && buf.Len() > len(data)/4 {
+			t.Errorf("feed compressed to %d bytes (of %d), want at most a quarter", buf.Len(), len(data))
+		}
+	}
+}
+
+func TestWriterFlush(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd command not found")
+	}
+
+	var buf bytes.Buffer
+	z := NewWriter(&buf)
+	z.Write([]byte("event: one\n\n"))
+	if err := z.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// What's been flushed is decodable, though the frame isn't complete.
+	cmd := exec.Command("zstd", "-d", "-c")
+	cmd.Stdin = bytes.NewReader(buf.Bytes())
+	out, _ := cmd.Output()
+	if string(out) != "event: one\n\n" {
+		t.Errorf("flushed data decompressed to %q", out)
+	}
+
+	z.Write([]byte("event: two\n\n"))
+	z.Close()
+	if got := decompress(t, buf.Bytes()); string(got) != "event: one\n\nevent: two\n\n" {
+		t.Errorf("got %q", got)
+	}
+	if _, err := z.Write([]byte("more")); err != errClosed {
+		t.Errorf("write after close returned error %v, want %v", err, errClosed)
+	}
+}
+
+func TestNormalizeCounts(t *testing.T) {
+	tests := [][]int{
+		{1, 1},
+		{1000, 1},
+		{5, 0, 0, 7, 1, 200},
+		{0, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3},
+	}
+	for _, count := range tests {
+		total := 0
+		for _, c := range count {
+			total += c
+		}
+		norm := normalizeCounts(count, total, 6)
+		sum := 0
+		for s, n := range norm {
+			if (count[s] > 0) != (n > 0) || n > 32 {
+				t.Errorf("normalizeCounts(%v) = %v: bad probability of %d", count, norm, s)
+			}
+			sum += int(n)
+		}
+		if sum != 64 {
+			t.Errorf("normalizeCounts(%v) = %v, which sums to %d", count, norm, sum)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package program
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/bench"
+	"github.com/discuitnet/discuit/internal/images"
+	"github.com/discuitnet/discuit/internal/rng"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// BenchOptions are the options of Bench.
+type BenchOptions struct {
+	// The volume of synthetic data. Users, communities, posts and comments
+	// are named after benchPrefix; those that already exist (from earlier
+	// runs) are reused.
+	Users             int
+	Communities       int
+	PostsPerCommunity int
+	CommentsPerPost   int
+
+	Ops         int // Operations of each workload.
+	Concurrency int
+	Seed        int64 // Of the synthetic data and of the workloads.
+}
+
+// benchPrefix is the prefix of the names of the users and communities made
+// by Bench.
+const benchPrefix = "bench_"
+
+var benchWords = strings.Fields(`the a of and to in is it that for on with as was at by this be from or
+	have not are but what all were when we there can an your which their said if do will each about how up
+	out them then she many some so these would other into has more her two like him see time could no make
+	than first been its who now people my made over did down only way find use may water long little very
+	after words called just where most know get through back much before go good new write our used me man
+	too any day same right look think also around another came come work three word must because does part`)
+
+// benchData is the synthetic data the workloads of Bench run against.
+type benchData struct {
+	users       []uid.ID
+	communities []uid.ID
+	posts       []*core.Post
+}
+
+// Bench populates the database with synthetic data (as per opts), runs
+// representative workloads against it (feed reads, comment writes, and image
+// transforms), and writes the latencies of their operations to w.
+func (pg *Program) Bench(opts BenchOptions, w io.Writer) error {
+	ctx := pg.ctx
+	r := rng.New(opts.Seed)
+
+	log.Println("Populating synthetic data")
+	data, err := populateBenchData(ctx, pg.db, r, &opts)
+	if err != nil {
+		return fmt.Errorf("error populating synthetic data: %w", err)
+	}
+	log.Printf("Synthetic data: %d users, %d communities, %d posts\n", len(data.users), len(data.communities), len(data.posts))
+
+	img, err := benchImage(1600, 1200)
+	if err != nil {
+		return err
+	}
+
+	pick := func(ids []uid.ID) *uid.ID {
+		id := ids[r.Intn(len(ids))]
+		return &id
+	}
+	workloads := []struct {
+		name string
+		op   bench.Op
+	}{
+		{"feed read (all, hot)", func(ctx context.Context, i int) error {
+			_, err := core.GetFeed(ctx, pg.db, &core.FeedOptions{Sort: core.FeedSortHot, Viewer: pick(data.users), Limit: 20})
+			return err
+		}},
+		{"feed read (home, hot)", func(ctx context.Context, i int) error {
+			_, err := core.GetFeed(ctx, pg.db, &core.FeedOptions{Sort: core.FeedSortHot, Viewer: pick(data.users), Homefeed: true, Limit: 20})
+			return err
+		}},
+		{"feed read (community, new)", func(ctx context.Context, i int) error {
+			_, err := core.GetFeed(ctx, pg.db, &core.FeedOptions{Sort: core.FeedSortLatest, Viewer: pick(data.users), Community: pick(data.communities), Limit: 20})
+			return err
+		}},
+		{"comment write", func(ctx context.Context, i int) error {
+			post := data.posts[r.Intn(len(data.posts))]
+			_, err := core.Comments().Create(ctx, pg.db, &core.NewComment{Post: post, Author: *pick(data.users), Body: benchText(r, 30)})
+			return err
+		}},
+		{"image transform (thumbnail)", func(ctx context.Context, i int) error {
+			_, err := images.Transform(img, images.ImageSize{Width: 120, Height: 120}, images.ImageFitCover, images.ImageFormatJPEG)
+			return err
+		}},
+		{"image transform (large)", func(ctx context.Context, i int) error {
+			_, err := images.Transform(img, images.ImageSize{Width: 1280, Height: 1280}, images.ImageFitContain, images.ImageFormatJPEG)
+			return err
+		}},
+	}
+
+	var results []*bench.Result
+	for _, wl := range workloads {
+		log.Printf("Running %s\n", wl.name)
+		results = append(results, bench.Run(ctx, wl.name, opts.Ops, opts.Concurrency, wl.op))
+	}
+	return bench.WriteReport(w, results)
+}
+
+// populateBenchData adds the synthetic data of opts that's missing from db.
+// Posts (and their comments) are only added to new communities.
+func populateBenchData(ctx context.Context, db *sql.DB, r rng.Rand, opts *BenchOptions) (*benchData, error) {
+	data := &benchData{}
+	for i := 0; i < opts.Users; i++ {
+		name := fmt.Sprintf("%su%d", benchPrefix, i)
+		user, err := core.GetUserByUsername(ctx, db, name, nil)
+		if err != nil {
+			if user, err = core.RegisterUser(ctx, db, name, "", benchPrefix+"password", ""); err != nil {
+				return nil, fmt.Errorf("registering %s: %w", name, err)
+			}
+		}
+		data.users = append(data.users, user.ID)
+	}
+	if len(data.users) == 0 {
+		return nil, fmt.Errorf("no users")
+	}
+
+	for i := 0; i < opts.Communities; i++ {
+		name := fmt.Sprintf("%sc%d", benchPrefix, i)
+		comm, err := core.GetCommunityByName(ctx, db, name, nil)
+		if err == nil {
+			data.communities = append(data.communities, comm.ID)
+			continue
+		}
+		creator := data.users[r.Intn(len(data.users))]
+		if comm, err = core.CreateCommunity(ctx, db, creator, 0, opts.Communities, name, benchText(r, 12)); err != nil {
+			return nil, fmt.Errorf("creating community %s: %w", name, err)
+		}
+		data.communities = append(data.communities, comm.ID)
+
+		// Half the users join each community, for their home feeds.
+		for _, user := range data.users {
+			if user != creator && r.Intn(2) == 0 {
+				if err := comm.Join(ctx, db, user); err != nil {
+					return nil, err
+				}
+			}
+		}
+		for j := 0; j < opts.PostsPerCommunity; j++ {
+			post, err := core.Posts().Create(ctx, db, &core.NewPost{
+				Author:    data.users[r.Intn(len(data.users))],
+				Community: comm.ID,
+				Title:     benchText(r, 8),
+				Content:   &core.TextContent{Body: benchText(r, 60)},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("creating post: %w", err)
+			}
+			var parents []uid.ID
+			for k := 0; k < opts.CommentsPerPost; k++ {
+				var parent *uid.ID
+				if len(parents) > 0 && r.Intn(2) == 0 {
+					parent = &parents[r.Intn(len(parents))]
+				}
+				c, err := core.Comments().Create(ctx, db, &core.NewComment{Post: post, Author: data.users[r.Intn(len(data.users))], Parent: parent, Body: benchText(r, 25)})
+				if err != nil {
+					return nil, fmt.Errorf("creating comment: %w", err)
+				}
+				parents = append(parents, c.ID)
+			}
+		}
+	}
+	if len(data.communities) == 0 {
+		return nil, fmt.Errorf("no communities")
+	}
+
+	args := make([]any, len(data.communities))
+	for i := range data.communities {
+		args[i] = data.communities[i]
+	}
+	rows, err := db.QueryContext(ctx, "SELECT id FROM posts WHERE deleted = FALSE AND community_id IN "+msql.InClauseQuestionMarks(len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var postIDs []uid.ID
+	for rows.Next() {
+		var id uid.ID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		postIDs = append(postIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(postIDs) == 0 {
+		return nil, fmt.Errorf("no posts")
+	}
+	if data.posts, err = core.GetPostsByIDs(ctx, db, nil, false, postIDs...); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// benchText returns n random words.
+func benchText(r rng.Rand, n int) string {
+	words := make([]string, n)
+	for i := range words {
+		words[i] = benchWords[r.Intn(len(benchWords))]
+	}
+	return strings.Join(words, " ")
+}
+
+// benchImage returns a JPEG image of width by height, of a gradient.
+func benchImage(width, height int) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: uint8((x + y) % 256), A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
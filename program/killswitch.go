@@ -0,0 +1,95 @@
+package program
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/gomodule/redigo/redis"
+)
+
+// botKillSwitchChannel is the Redis channel on which flips of the bot kill
+// switch are announced (see core.SetBotKillSwitchNotifier).
+const botKillSwitchChannel = "discuit:bot_kill_switch"
+
+// watchBotKillSwitch loads the bot kill switch, and then reloads it whenever
+// it's flipped, by this or any other instance, until ctx is done.
+func (pg *Program) watchBotKillSwitch(ctx context.Context) error {
+	if err := core.LoadBotKillSwitch(ctx, pg.db); err != nil {
+		return err
+	}
+	core.SetBotKillSwitchNotifier(func(ctx context.Context) error {
+		conn, err := pg.dialRedis()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = conn.Do("PUBLISH", botKillSwitchChannel, "flipped")
+		return err
+	})
+
+	go func() {
+		for ctx.Err() == nil {
+			if err := pg.listenBotKillSwitch(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Bot kill switch subscription error: %v (resubscribing)\n", err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(5 * time.Second):
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// listenBotKillSwitch reloads the bot kill switch whenever it's announced on
+// botKillSwitchChannel, until ctx is done or the subscription fails.
+func (pg *Program) listenBotKillSwitch(ctx context.Context) error {
+	conn, err := pg.dialRedis()
+	if err != nil {
+		return err
+	}
+	psc := redis.PubSubConn{Conn: conn}
+	defer psc.Close()
+	if err := psc.Subscribe(botKillSwitchChannel); err != nil {
+		return err
+	}
+
+	reload := func() {
+		if err := core.LoadBotKillSwitch(ctx, pg.db); err != nil {
+			log.Printf("Error reloading the bot kill switch: %v\n", err)
+		}
+	}
+	done := make(chan error, 1)
+	go func() {
+		for {
+			switch v := psc.Receive().(type) {
+			case redis.Message:
+				reload()
+			case redis.Subscription:
+				// Flips made while unsubscribed.
+				reload()
+			case error:
+				done <- v
+				return
+			}
+		}
+	}()
+
+	// Pings keep the connection from hitting its read timeout.
+	ping := time.NewTicker(15 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-done:
+			return err
+		case <-ping.C:
+			if err := psc.Ping(""); err != nil {
+				return err
+			}
+		}
+	}
+}
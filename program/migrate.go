@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 
+	"github.com/discuitnet/discuit/internal/migrations"
 	gomigrate "github.com/golang-migrate/migrate/v4"
 )
 
@@ -26,30 +27,59 @@ func (ml *migrationsLogger) Verbose() bool {
 	return ml.verbose
 }
 
+// newMigrator returns a Migrator of the database of the site. If log is
+// true, the migrations run are logged.
+func (pg *Program) newMigrator(log bool) (*migrations.Migrator, error) {
+	var logger gomigrate.Logger
+	if log {
+		logger = &migrationsLogger{verbose: false}
+	}
+	return migrations.New(MysqlDSN(pg.conf.DBAddr, pg.conf.DBUser, pg.conf.DBPassword, pg.conf.DBName), logger)
+}
+
 // If steps is 0, all migrations are run. Otherwise, steps migrations are run up
 // or down depending on steps > 0 or not.
 func (pg *Program) Migrate(log bool, steps int) error {
 	fmt.Println("Running migrations")
 
-	m, err := gomigrate.New("file://migrations/", "mysql://"+MysqlDSN(pg.conf.DBAddr, pg.conf.DBUser, pg.conf.DBPassword, pg.conf.DBName))
+	m, err := pg.newMigrator(log)
 	if err != nil {
 		return err
 	}
-	if log {
-		m.Log = &migrationsLogger{verbose: false}
-	}
-
-	if steps == 0 {
-		err = m.Up()
+	if steps >= 0 {
+		err = m.Up(steps)
 	} else {
-		err = m.Steps(steps)
+		err = m.Down(-steps)
 	}
-	if err != nil && err != gomigrate.ErrNoChange {
+	if err != nil {
+		m.Close()
 		return err
 	}
+	return m.Close()
+}
+
+// MigrationsStatus returns the migration status of the database.
+func (pg *Program) MigrationsStatus() (*migrations.Status, error) {
+	m, err := pg.newMigrator(false)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+	return m.Status()
+}
 
-	_, err = m.Close()
-	return err
+// ForceMigrationsVersion sets the migrations version of the database to
+// version, and marks it as not dirty, without running any migrations.
+func (pg *Program) ForceMigrationsVersion(version int) error {
+	m, err := pg.newMigrator(true)
+	if err != nil {
+		return err
+	}
+	if err := m.Force(version); err != nil {
+		m.Close()
+		return err
+	}
+	return m.Close()
 }
 
 // MigrationsVersion returns the last migration number (the value in the
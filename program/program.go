@@ -16,22 +16,43 @@ import (
 
 	"crypto/tls"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/discuitnet/discuit/config"
 	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/email"
+	"github.com/discuitnet/discuit/internal/feedcache"
 	"github.com/discuitnet/discuit/internal/images"
+	"github.com/discuitnet/discuit/internal/imports"
+	"github.com/discuitnet/discuit/internal/jobs"
+	"github.com/discuitnet/discuit/internal/llm"
+	"github.com/discuitnet/discuit/internal/logging"
+	"github.com/discuitnet/discuit/internal/meilisearch"
+	msql "github.com/discuitnet/discuit/internal/sql"
 	"github.com/discuitnet/discuit/internal/taskrunner"
+	"github.com/discuitnet/discuit/internal/tracing"
 	"github.com/discuitnet/discuit/internal/uid"
+	"github.com/discuitnet/discuit/internal/votebuffer"
 	"github.com/discuitnet/discuit/server"
 	"github.com/go-sql-driver/mysql"
 	"github.com/gomodule/redigo/redis"
 )
 
 type Program struct {
-	conf      *config.Config
-	db        *sql.DB
-	imagesDir string
-	ctx       context.Context
-	tr        *taskrunner.TaskRunner
+	conf        *config.Config
+	db          *sql.DB
+	replicas    *msql.Replicas // Of db, if it has any.
+	imagesDir   string
+	ctx         context.Context
+	tr          *taskrunner.TaskRunner
+	botSchedule *core.BotSchedule
+
+	botVoteInterval time.Duration // Of the rounds of bot votes.
+
+	watcher *config.Watcher // Of the config, once the site is served.
+
+	cachePool *redis.Pool // Of the vote buffer and the feed cache (see redisCachePool).
 }
 
 func NewProgram(openDatabase bool) (*Program, error) {
@@ -46,6 +67,10 @@ func NewProgram(openDatabase bool) (*Program, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error parsing the config file: %w", err)
 	}
+	if err := logging.Setup(os.Stderr, pg.conf.LogFormat); err != nil {
+		return nil, err
+	}
+	setLogLevel(pg.conf)
 
 	// Set the images directory:
 	pg.imagesDir = "images" // in the working directory
@@ -57,11 +82,55 @@ func NewProgram(openDatabase bool) (*Program, error) {
 		return nil, fmt.Errorf("error attempting to set the images folder location (%s): %w", pg.imagesDir, err)
 	}
 	images.SetImagesRootFolder(pg.imagesDir)
+	if err := pg.allowImageVariants(pg.conf); err != nil {
+		return nil, err
+	}
+	images.SetCacheMaxSize(int64(pg.conf.ImageCacheMaxSize))
+	images.SetDefaultQuota(images.QuotaOwnerUser, int64(pg.conf.UserImageQuota))
+	images.SetDefaultQuota(images.QuotaOwnerCommunity, int64(pg.conf.CommunityImageQuota))
+	images.TrashRetention = time.Hour * 24 * time.Duration(pg.conf.ImageTrashDays)
 
 	// Initialize S3 store if enabled
 	if err := images.InitS3Store(pg.conf); err != nil {
 		return nil, fmt.Errorf("error initializing S3 store: %w", err)
 	}
+	if err := images.InitGCSStore(images.GCSOptions{
+		Bucket:          pg.conf.GCSBucket,
+		CredentialsFile: pg.conf.GCSCredentialsFile,
+		Endpoint:        pg.conf.GCSEndpoint,
+		PathPrefix:      pg.conf.GCSPathPrefix,
+	}); err != nil {
+		return nil, fmt.Errorf("error initializing GCS store: %w", err)
+	}
+	if err := images.InitAzureStore(images.AzureOptions{
+		Account:    pg.conf.AzureAccount,
+		AccountKey: pg.conf.AzureAccountKey,
+		Container:  pg.conf.AzureContainer,
+		Endpoint:   pg.conf.AzureEndpoint,
+		PathPrefix: pg.conf.AzurePathPrefix,
+	}); err != nil {
+		return nil, fmt.Errorf("error initializing Azure store: %w", err)
+	}
+	if store := pg.conf.ImagesStore; store != "" || pg.conf.StorageBackend != "" {
+		if store == "" {
+			store = pg.conf.StorageBackend
+		}
+		if err := images.SetDefaultStoreName(store); err != nil {
+			return nil, fmt.Errorf("error setting images store: %w", err)
+		}
+	}
+	if pg.conf.ImagesReplicaStores != "" {
+		names := storeNames(pg.conf.ImagesReplicaStores)
+		if err := images.SetReplicas(images.GetDefaultStoreName(pg.conf.S3Enabled), names...); err != nil {
+			return nil, fmt.Errorf("error setting images replica stores: %w", err)
+		}
+	}
+	if pg.conf.ImagesFallbackStores != "" {
+		names := storeNames(pg.conf.ImagesFallbackStores)
+		if err := images.SetFallbacks(images.GetDefaultStoreName(pg.conf.S3Enabled), names...); err != nil {
+			return nil, fmt.Errorf("error setting images fallback stores: %w", err)
+		}
+	}
 
 	pg.tr = taskrunner.New(pg.ctx)
 
@@ -112,9 +181,136 @@ func (pg *Program) startBackgroundTasks(delay time.Duration) {
 	pg.tr.New("Record basic site analytics", func(ctx context.Context) error {
 		return core.RecordBasicSiteStats(ctx, pg.db)
 	}, time.Hour, false)
+	pg.tr.New("Prune stale push devices", func(ctx context.Context) error {
+		n, err := core.PruneStalePushDevices(ctx, pg.db, time.Hour*24*60)
+		if n > 0 {
+			log.Printf("Pruned %d stale push devices\n", n)
+		}
+		return err
+	}, time.Hour*24, false)
+	if pg.conf.EmailBackend != "" && pg.conf.EmailDigests {
+		pg.tr.New("Send weekly email digests", func(ctx context.Context) error {
+			n, err := core.SendWeeklyDigests(ctx, pg.db, 1000)
+			if n > 0 {
+				log.Printf("Sent %d weekly email digests\n", n)
+			}
+			return err
+		}, time.Hour, false)
+	}
+	pg.tr.New("Sweep image cache", func(ctx context.Context) error {
+		n, err := images.SweepCache()
+		if n > 0 {
+			log.Printf("Evicted %d images from the image cache\n", n)
+		}
+		return err
+	}, time.Minute*15, false)
+	pg.tr.New("Roll up image bandwidth", func(ctx context.Context) error {
+		return pg.rollupBandwidth(ctx)
+	}, time.Minute, false)
+	pg.tr.New("Check database replicas", pg.checkReplicas, replicaCheckInterval, false)
+	pg.tr.New("Check counter integrity", func(ctx context.Context) error {
+		// Only reports drift; use the check-integrity command to repair.
+		results, err := core.CheckCounterIntegrity(ctx, pg.db, core.CounterCheckOptions{})
+		for _, res := range results {
+			if len(res.Drifts) > 0 {
+				logCounterCheckResult(res, false)
+			}
+		}
+		return err
+	}, time.Hour*24, false)
+	pg.tr.New("Find garbage images", func(ctx context.Context) error {
+		// Only reports garbage; use the gc-images command to delete it.
+		res, err := images.GarbageCollect(ctx, pg.db, true)
+		if res != nil {
+			logImageGCResult(res, true)
+		}
+		return err
+	}, time.Hour*24, false)
+	if days := pg.conf.PurgeDeletedContentDays; days > 0 {
+		pg.tr.New("Purge deleted content", func(ctx context.Context) error {
+			res, err := core.PurgeDeletedContent(ctx, pg.db, core.PurgeOptions{
+				DeletedBefore: time.Now().Add(-time.Hour * 24 * time.Duration(days)),
+			})
+			if res != nil && (len(res.Posts) > 0 || len(res.Comments) > 0) {
+				logPurgeResult(res, false)
+			}
+			return err
+		}, time.Hour*24, false)
+	}
+
+	if images.TrashEnabled() {
+		pg.tr.New("Purge image trash", func(ctx context.Context) error {
+			return pg.purgeTrash(ctx, false)
+		}, time.Hour*24, false)
+	}
+
+	pg.tr.New("Purge archived communities", func(ctx context.Context) error {
+		results, err := core.PurgeArchivedCommunities(ctx, pg.db, 0)
+		for _, res := range results {
+			log.Printf("Purged %d posts (%d images) and %d comments of archived community %v; %d posts and %d comments under legal hold (done: %v)\n",
+				res.Posts, res.Images, res.Comments, res.CommunityID, res.HeldPosts, res.HeldComments, res.Done)
+		}
+		return err
+	}, time.Hour, false)
+
+	// Bot kill switch flips are announced on Redis (see watchBotKillSwitch);
+	// this catches any announcement that was missed.
+	pg.tr.New("Reload bot kill switch", func(ctx context.Context) error {
+		return core.LoadBotKillSwitch(ctx, pg.db)
+	}, time.Minute, false)
+
+	pg.tr.New("Reload bot prompts", func(ctx context.Context) error {
+		if reloaded, err := core.ReloadBotPrompts(); err != nil {
+			return fmt.Errorf("bot prompts not reloaded: %w", err)
+		} else if reloaded {
+			log.Println("Bot prompts reloaded")
+		}
+		return nil
+	}, time.Minute, false)
+
+	if pg.conf.ImagePrefetchWorkers > 0 && pg.conf.ImagePrefetchFrontPage > 0 {
+		pg.tr.New("Prefetch front page images", func(ctx context.Context) error {
+			_, err := core.PrefetchFrontPageImages(ctx, pg.db, pg.conf.ImagePrefetchFrontPage)
+			return err
+		}, time.Minute, false)
+	}
+
+	if pg.conf.ImageDuplicateAction != string(core.DuplicateImageActionNone) {
+		pg.tr.New("Index perceptual hashes of images", func(ctx context.Context) error {
+			since := time.Now().AddDate(0, 0, -pg.conf.ImageDuplicateDays)
+			_, err := images.IndexPerceptualHashes(ctx, pg.db, since, 500)
+			return err
+		}, 10*time.Minute, false)
+	}
+
+	if pg.conf.BotVotes {
+		pg.tr.New("Simulate bot votes", func(ctx context.Context) error {
+			_, err := core.SimulateBotVotes(ctx, pg.db)
+			return err
+		}, pg.botVoteInterval, false)
+	}
+
+	if pg.conf.BotEmbedder != "" {
+		pg.tr.New("Purge old bot embeddings", func(ctx context.Context) error {
+			_, err := core.PurgeBotEmbeddings(ctx, pg.db)
+			return err
+		}, time.Hour*24, false)
+	}
+
+	if interval, _ := time.ParseDuration(pg.conf.VoteBufferFlushInterval); interval > 0 {
+		pg.tr.New("Flush vote buffer", func(ctx context.Context) error {
+			_, err := core.FlushVoteBuffer(ctx, pg.db)
+			return err
+		}, interval, false)
+	}
+
+	pg.tr.New("Snapshot bot threads", func(ctx context.Context) error {
+		_, err := core.SnapshotBotThreads(ctx, pg.db)
+		return err
+	}, time.Hour, false)
 
 	// Add bot scheduler
-	// botScheduler := core.NewBotScheduler(pg.db)
+	// botScheduler := core.NewBotScheduler(pg.db, pg.botSchedule)
 	// botScheduler.Start(pg.ctx)
 
 	go func() {
@@ -146,6 +342,9 @@ func (pg *Program) OpenDatabase() (*sql.DB, error) {
 	if pg.db, err = openDatabase(pg.conf.DBAddr, pg.conf.DBUser, pg.conf.DBPassword, pg.conf.DBName); err != nil {
 		return nil, fmt.Errorf("error opening database: %w", err)
 	}
+	if err = pg.openReplicas(); err != nil {
+		return nil, fmt.Errorf("error opening database replicas: %w", err)
+	}
 	return pg.db, nil
 }
 
@@ -154,8 +353,28 @@ func (pg *Program) Serve() error {
 		return fmt.Errorf("error creating sentinel users: %w", err)
 	}
 
-	// Set the bots file path
-	core.SetBotsFilePath("bots.txt")
+	// Bots were once told apart by being listed in bots.txt.
+	if n, err := core.PromoteBotsFromFile(context.Background(), pg.db, "bots.txt"); err != nil {
+		return fmt.Errorf("error promoting the users of bots.txt to bots: %w", err)
+	} else if n > 0 {
+		log.Printf("Made %d users listed in bots.txt bots\n", n)
+	}
+	if err := pg.applyConfig(pg.conf); err != nil {
+		return err
+	}
+	if err := core.LoadBotPrompts(pg.conf.BotPromptsDir); err != nil {
+		return fmt.Errorf("error loading bot prompts: %w", err)
+	}
+	stopTracing, err := pg.startTracing()
+	if err != nil {
+		return fmt.Errorf("error setting up tracing: %w", err)
+	}
+	if err := pg.setSearchBackend(); err != nil {
+		return err
+	}
+	if err := pg.setEmailOptions(); err != nil {
+		return err
+	}
 
 	// Create the default badges:
 	if err := core.NewBadgeType(pg.db, "supporter"); err != nil {
@@ -178,11 +397,30 @@ func (pg *Program) Serve() error {
 	}
 	defer site.Close()
 
-	var https bool = pg.conf.CertFile != ""
+	imageJobs, err := pg.startImageJobs()
+	if err != nil {
+		return err
+	}
+	prefetcher := pg.startImagePrefetcher()
+	delayedJobs := pg.startJobs()
+
+	if err := pg.setupImageModeration(); err != nil {
+		return err
+	}
+	if err := pg.setupFocalPointDetection(); err != nil {
+		return err
+	}
+
+	https := pg.conf.HTTPS()
+	hsts := pg.conf.HSTSHeader()
+	acmeManager := pg.acmeManager()
 
 	server := &http.Server{
 		Addr: pg.conf.Addr,
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if hsts != "" {
+				w.Header().Set("Strict-Transport-Security", hsts)
+			}
 			// Redirect all www. requests to a non-www. host.
 			if withoutWWW, found := strings.CutPrefix(r.Host, "www."); found {
 				url := *r.URL
@@ -198,22 +436,42 @@ func (pg *Program) Serve() error {
 			site.ServeHTTP(w, r)
 		}),
 	}
+	if acmeManager != nil {
+		// Which negotiates HTTP/2, as does ListenAndServeTLS with a
+		// certificate file.
+		server.TLSConfig = acmeManager.TLSConfig()
+	}
 
 	stopCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill) // interrupt context
 	defer stop()
 
+	if err := pg.watchBotKillSwitch(stopCtx); err != nil {
+		return fmt.Errorf("error loading bot kill switch: %w", err)
+	}
+	if err := pg.watchConfig(stopCtx, site); err != nil {
+		return err
+	}
+
 	var redirectServer *http.Server
 
 	// Optionally start a server to redirect traffic from HTTP to HTTPS.
-	if https && pg.conf.Addr[strings.Index(pg.conf.Addr, ":"):] == ":443" {
+	redirectAddr := pg.conf.HTTPRedirectAddr
+	if redirectAddr == "" && pg.conf.Addr[strings.Index(pg.conf.Addr, ":"):] == ":443" {
+		redirectAddr = ":80"
+	}
+	if https && redirectAddr != "" {
+		var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			url := *r.URL
+			url.Scheme = "https"
+			url.Host = r.Host
+			http.Redirect(w, r, url.String(), http.StatusMovedPermanently)
+		})
+		if acmeManager != nil {
+			handler = acmeManager.HTTPHandler(handler) // For HTTP-01 challenges.
+		}
 		redirectServer = &http.Server{
-			Addr: ":80",
-			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				url := *r.URL
-				url.Scheme = "https"
-				url.Host = r.Host
-				http.Redirect(w, r, url.String(), http.StatusMovedPermanently)
-			}),
+			Addr:    redirectAddr,
+			Handler: handler,
 		}
 		go func() {
 			log.Println("Starting redirect server (HTTP -> HTTPS) on " + redirectServer.Addr)
@@ -234,6 +492,8 @@ func (pg *Program) Serve() error {
 			}
 		}
 		if https {
+			// With an ACME manager, the certificates come from the TLS
+			// config, and the file names are empty.
 			if err := server.ListenAndServeTLS(pg.conf.CertFile, pg.conf.KeyFile); err != nil && !errors.Is(err, http.ErrServerClosed) {
 				log.Printf("ListenAndServeTLS (main) error: %v\n", err)
 			}
@@ -273,9 +533,579 @@ func (pg *Program) Serve() error {
 	}
 
 	pg.stopBackgroundTasks(stopCtx)
+	pg.flushVoteBuffer(stopCtx)
+	if err := pg.rollupBandwidth(stopCtx); err != nil {
+		log.Printf("Error rolling up image bandwidth: %v\n", err)
+	}
+	if imageJobs != nil {
+		pg.stopImageJobs(stopCtx, imageJobs)
+	}
+	if prefetcher != nil {
+		pg.stopImagePrefetcher(stopCtx, prefetcher)
+	}
+	if delayedJobs != nil {
+		pg.stopJobs(stopCtx, delayedJobs)
+	}
+	if err := stopTracing(stopCtx); err != nil {
+		log.Printf("Error exporting the last traces: %v\n", err)
+	}
+	return nil
+}
+
+// setSearchBackend sets the search engine of posts and comments (see
+// core.SetSearchBackend), if it's not the database.
+func (pg *Program) setSearchBackend() error {
+	if pg.conf.SearchBackend != "meilisearch" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(pg.ctx, time.Second*30)
+	defer cancel()
+	client := meilisearch.New(pg.conf.MeilisearchURL, pg.conf.MeilisearchAPIKey, nil)
+	b, err := core.NewMeilisearchBackend(ctx, client, pg.conf.MeilisearchIndexPrefix)
+	if err != nil {
+		return fmt.Errorf("error setting up Meilisearch: %w", err)
+	}
+	core.SetSearchBackend(b)
+	return nil
+}
+
+// setEmailOptions enables sending emails (see core.SetEmailOptions), if an
+// email backend is configured.
+func (pg *Program) setEmailOptions() error {
+	var sender email.Sender
+	switch pg.conf.EmailBackend {
+	case "":
+		return nil
+	case "smtp":
+		sender = &email.SMTP{
+			Addr:     pg.conf.SMTPAddr,
+			Username: pg.conf.SMTPUsername,
+			Password: pg.conf.SMTPPassword,
+		}
+	case "ses":
+		ses := &email.SES{Region: pg.conf.SESRegion}
+		if pg.conf.SESAccessKey != "" {
+			ses.Credentials = credentials.NewStaticCredentialsProvider(pg.conf.SESAccessKey, pg.conf.SESSecretKey, "")
+		} else {
+			cfg, err := awsconfig.LoadDefaultConfig(pg.ctx, awsconfig.WithRegion(pg.conf.SESRegion))
+			if err != nil {
+				return fmt.Errorf("error loading AWS credentials for SES: %w", err)
+			}
+			ses.Credentials = cfg.Credentials
+		}
+		sender = ses
+	default:
+		return fmt.Errorf("invalid email backend %q", pg.conf.EmailBackend)
+	}
+	core.SetEmailOptions(&core.EmailOptions{
+		Sender:   sender,
+		From:     pg.conf.EmailFrom,
+		SiteName: pg.conf.SiteName,
+		SiteURL:  strings.TrimSuffix(pg.conf.SiteURL, "/"),
+		Secret:   pg.conf.HMACSecret,
+	})
+	return nil
+}
+
+// ReindexSearch indexes all the posts and comments in the search backend, if
+// it's not the database.
+func (pg *Program) ReindexSearch(batchSize int) error {
+	if pg.conf.SearchBackend != "meilisearch" {
+		return errors.New("the search backend is the database, whose indexes are always up to date")
+	}
+	if err := pg.setSearchBackend(); err != nil {
+		return err
+	}
+	n, err := core.ReindexSearch(pg.ctx, pg.db, batchSize)
+	log.Printf("Indexed %d posts and comments\n", n)
+	return err
+}
+
+// startTracing turns tracing on, if there's a tracing endpoint, and returns a
+// function that turns it off.
+func (pg *Program) startTracing() (func(context.Context) error, error) {
+	if pg.conf.TracingEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	stop, err := tracing.Setup(tracing.Options{
+		Endpoint:    pg.conf.TracingEndpoint,
+		ServiceName: pg.conf.TracingServiceName,
+		SampleRatio: pg.conf.TracingSampleRatio,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Tracing requests to %s (sample ratio: %v)\n", pg.conf.TracingEndpoint, pg.conf.TracingSampleRatio)
+	return stop, nil
+}
+
+// rollupBandwidth saves the image bandwidth usage counted since it was last
+// called, and recomputes the rollups of the days of it.
+func (pg *Program) rollupBandwidth(ctx context.Context) error {
+	days, err := images.FlushBandwidth(ctx, pg.db)
+	if err != nil {
+		return err
+	}
+	for _, day := range days {
+		if err := core.RollupBandwidth(ctx, pg.db, day); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyConfig applies the settings of conf that can be changed while the site
+// is running, both on start and whenever the config is reloaded (see
+// watchConfig).
+func (pg *Program) applyConfig(conf *config.Config) error {
+	setLogLevel(conf)
+	if err := core.SetBotUserWeighting(core.BotUserWeighting(conf.BotUserWeighting)); err != nil {
+		return err
+	}
+	core.SetBotDryRun(conf.BotDryRun)
+	if err := pg.setDuplicateImageCheck(conf); err != nil {
+		return err
+	}
+	if err := pg.setBotSchedule(conf); err != nil {
+		return err
+	}
+	if err := pg.setBotVoting(conf); err != nil {
+		return err
+	}
+	if err := pg.setBotSimilarity(conf); err != nil {
+		return err
+	}
+	core.SetBotBudget(core.BotBudgetLimits{
+		MaxRequestsPerMinute: conf.BotMaxRequestsPerMinute,
+		MaxTokensPerDay:      conf.BotMaxTokensPerDay,
+		MaxCostPerDay:        conf.BotMaxCostPerDay,
+		InputTokenPrice:      conf.BotInputTokenPrice,
+		OutputTokenPrice:     conf.BotOutputTokenPrice,
+	})
+	images.SetDefaultQuota(images.QuotaOwnerUser, int64(conf.UserImageQuota))
+	images.SetDefaultQuota(images.QuotaOwnerCommunity, int64(conf.CommunityImageQuota))
+	images.SetBandwidthAccounting(conf.BandwidthAccounting)
+	core.Posts().SetLimits(core.PostLimits{
+		ImagePostsDisabled: conf.DisableImagePosts,
+		MaxImages:          conf.MaxImagesPerPost,
+	})
+	pg.setVoteBuffer(conf)
+	pg.setFeedCache(conf)
+	return nil
+}
+
+// redisCachePool returns the pool of Redis connections of the vote buffer and
+// the feed cache.
+func (pg *Program) redisCachePool() *redis.Pool {
+	if pg.cachePool == nil {
+		pg.cachePool = &redis.Pool{
+			MaxIdle:     8,
+			IdleTimeout: 240 * time.Second,
+			Dial:        pg.dialRedis,
+		}
+	}
+	return pg.cachePool
+}
+
+// setVoteBuffer sets the buffer of the vote counters of hot posts (see
+// core.SetVoteBuffer) to one with the threshold of conf. Changes buffered by
+// the previous buffer are kept, as they're in the same Redis server.
+func (pg *Program) setVoteBuffer(conf *config.Config) {
+	core.SetVoteBuffer(votebuffer.New(pg.redisCachePool(), conf.VoteBufferThreshold))
+}
+
+// setFeedCache sets the cache of the feeds of logged out users (see
+// core.SetFeedCache) to one with the TTL of conf, or disables it if there's
+// none.
+func (pg *Program) setFeedCache(conf *config.Config) {
+	ttl, _ := time.ParseDuration(conf.FeedCacheTTL) // Validated by config.Parse.
+	if ttl <= 0 {
+		core.SetFeedCache(nil)
+		return
+	}
+	core.SetFeedCache(feedcache.New(pg.redisCachePool(), ttl, ttl))
+}
+
+// flushVoteBuffer writes all the changes in the vote buffer to the database.
+func (pg *Program) flushVoteBuffer(ctx context.Context) {
+	for {
+		n, err := core.FlushVoteBuffer(ctx, pg.db)
+		if err != nil {
+			log.Printf("Error flushing the vote buffer: %v\n", err)
+			return
+		}
+		if n == 0 {
+			return
+		}
+	}
+}
+
+// setLogLevel sets the level of logs to that of conf.
+func setLogLevel(conf *config.Config) {
+	level, _ := logging.ParseLevel(conf.LogLevel) // Validated by config.Parse.
+	logging.SetLevel(level)
+}
+
+// watchConfig reloads the config on SIGHUP, and whenever the config file
+// changes if conf.ConfigReloadInterval is set, until ctx is done (see
+// config.Watcher). Reloaded configs are applied to the site and to the
+// subsystems that can be reconfigured while running; image variants can be
+// added but not removed.
+func (pg *Program) watchConfig(ctx context.Context, site *server.Server) error {
+	var poll time.Duration
+	if pg.conf.ConfigReloadInterval != "" {
+		var err error
+		if poll, err = time.ParseDuration(pg.conf.ConfigReloadInterval); err != nil {
+			return fmt.Errorf("invalid config reload interval: %w", err)
+		}
+	}
+	pg.watcher = config.NewWatcher("config.yaml", pg.conf)
+	pg.watcher.Subscribe(pg.applyConfig)
+	pg.watcher.Subscribe(pg.allowImageVariants)
+	pg.watcher.Subscribe(func(conf *config.Config) error {
+		site.SetConfig(conf)
+		return nil
+	})
+	go pg.watcher.Watch(ctx, poll)
+	return nil
+}
+
+// setBotSchedule sets the schedule of the bot scheduler to that of the config.
+func (pg *Program) setBotSchedule(conf *config.Config) error {
+	loc, err := time.LoadLocation(conf.BotScheduleTimezone)
+	if err != nil {
+		return fmt.Errorf("invalid bot schedule time zone: %w", err)
+	}
+	interval, err := time.ParseDuration(conf.BotScheduleInterval)
+	if err != nil {
+		return fmt.Errorf("invalid bot schedule interval: %w", err)
+	}
+	schedule := &core.BotSchedule{
+		Location:    loc,
+		StartHour:   conf.BotScheduleStartHour,
+		EndHour:     conf.BotScheduleEndHour,
+		Interval:    interval,
+		Batches:     conf.BotScheduleBatches,
+		Concurrency: conf.BotScheduleConcurrency,
+	}
+	if err := schedule.Validate(); err != nil {
+		return err
+	}
+	pg.botSchedule = schedule
+	return nil
+}
+
+// setBotVoting sets how bots vote on posts (see core.SetBotVoting).
+func (pg *Program) setBotVoting(conf *config.Config) error {
+	interval, err := time.ParseDuration(conf.BotVoteInterval)
+	if err != nil {
+		return fmt.Errorf("invalid bot vote interval: %w", err)
+	}
+	if interval < time.Minute {
+		return errors.New("bot vote interval must be at least a minute")
+	}
+	voting := core.BotVoting{
+		Enabled:               conf.BotVotes,
+		VotesPerRound:         conf.BotVotesPerRound,
+		MaxVotesPerBotPerHour: conf.BotMaxVotesPerHour,
+		UpvoteChance:          conf.BotUpvoteChance,
+		DownvoteChance:        conf.BotDownvoteChance,
+	}
+	if err := voting.Validate(); err != nil {
+		return err
+	}
+	core.SetBotVoting(voting)
+	pg.botVoteInterval = interval
+	return nil
+}
+
+// setBotSimilarity sets how the posts of bots are checked for similarity to
+// their recent posts (see core.SetBotSimilarity).
+func (pg *Program) setBotSimilarity(conf *config.Config) error {
+	s := core.BotSimilarity{
+		Model:     conf.BotEmbeddingModel,
+		Threshold: conf.BotSimilarityThreshold,
+		Retries:   conf.BotSimilarityRetries,
+	}
+	switch conf.BotEmbedder {
+	case "":
+		core.SetBotSimilarity(core.BotSimilarity{})
+		return nil
+	case "openai":
+		s.Embedder = &llm.OpenAI{}
+	case "local":
+		s.Embedder, s.Model = &llm.Local{}, "local"
+	default:
+		return fmt.Errorf("invalid bot embedder %q (must be openai or local)", conf.BotEmbedder)
+	}
+	if s.Threshold <= 0 || s.Threshold > 1 {
+		return errors.New("bot similarity threshold must be between 0 and 1")
+	}
+	if s.Retries < 0 {
+		return errors.New("bot similarity retries cannot be negative")
+	}
+	core.SetBotSimilarity(s)
 	return nil
 }
 
+// setDuplicateImageCheck sets how images posted in communities are checked
+// for near-duplicates (see core.SetDuplicateImageCheck).
+func (pg *Program) setDuplicateImageCheck(conf *config.Config) error {
+	check := core.DuplicateImageCheck{
+		Action:      core.DuplicateImageAction(conf.ImageDuplicateAction),
+		Window:      time.Duration(conf.ImageDuplicateDays) * 24 * time.Hour,
+		MaxDistance: conf.ImageDuplicateDistance,
+	}
+	if err := check.Validate(); err != nil {
+		return err
+	}
+	core.SetDuplicateImageCheck(check)
+	return nil
+}
+
+// allowImageVariants sets the variants of images that can be requested (see
+// images.AllowVariants), and those that can be requested unsigned (see
+// images.AllowUnsignedPresets).
+// storeNames returns the names of the image stores in list, which is comma
+// separated.
+func storeNames(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (pg *Program) allowImageVariants(conf *config.Config) error {
+	images.AllowVariants(images.DefaultAllowedVariants...)
+	for _, list := range [][]string{conf.ImageVariants, conf.ImageSizeWhitelist} {
+		for _, s := range list {
+			v, err := images.ParseVariant(s)
+			if err != nil {
+				return fmt.Errorf("invalid image variant in config: %w", err)
+			}
+			images.AllowVariants(v)
+		}
+	}
+	for _, s := range conf.ImageUnsignedPresets {
+		v, err := images.ParseVariant(s)
+		if err != nil {
+			return fmt.Errorf("invalid unsigned image preset in config: %w", err)
+		}
+		images.AllowUnsignedPresets(v)
+	}
+	return nil
+}
+
+// startImageJobs starts the workers that generate the variants of uploaded
+// images (see images.SetJobQueue). It returns nil if they're disabled.
+func (pg *Program) startImageJobs() (*jobs.Queue, error) {
+	if pg.conf.ImageJobWorkers <= 0 {
+		return nil, nil
+	}
+	var variants []images.Variant
+	for _, s := range pg.conf.ImageVariants {
+		v, err := images.ParseVariant(s)
+		if err != nil {
+			return nil, fmt.Errorf("config imageVariants: %w", err)
+		}
+		variants = append(variants, v)
+	}
+	pool := &redis.Pool{
+		MaxIdle:     pg.conf.ImageJobWorkers + 1,
+		IdleTimeout: 240 * time.Second,
+		Dial:        pg.dialRedis,
+	}
+	q := jobs.New(pool, "images", jobs.Options{
+		Workers:    pg.conf.ImageJobWorkers,
+		Backoff:    time.Second * 10,
+		MaxBackoff: time.Minute * 10,
+	})
+	images.SetJobQueue(q, pg.db, variants)
+	q.Start()
+	log.Printf("Started %d image post-processing workers\n", pg.conf.ImageJobWorkers)
+	return q, nil
+}
+
+// jobQueue returns the queue of delayed jobs (see core.SetJobQueue), with
+// workers workers.
+func (pg *Program) jobQueue(workers int) *jobs.Queue {
+	pool := &redis.Pool{
+		MaxIdle:     workers + 1,
+		IdleTimeout: 240 * time.Second,
+		Dial:        pg.dialRedis,
+	}
+	return jobs.New(pool, "core", jobs.Options{Workers: workers})
+}
+
+// startJobs starts the workers that run delayed jobs. It returns nil if
+// they're disabled.
+func (pg *Program) startJobs() *jobs.Queue {
+	if pg.conf.JobWorkers <= 0 {
+		return nil
+	}
+	q := pg.jobQueue(pg.conf.JobWorkers)
+	core.SetJobQueue(pg.db, q)
+	q.Start()
+	log.Printf("Started %d job workers\n", pg.conf.JobWorkers)
+	return q
+}
+
+func (pg *Program) stopJobs(ctx context.Context, q *jobs.Queue) {
+	core.SetJobQueue(pg.db, nil)
+	if err := q.Stop(ctx); err != nil {
+		log.Printf("Job workers stop error: %v\n", err)
+	} else {
+		log.Println("Gracefully exited job workers")
+	}
+	if err := q.Close(); err != nil {
+		log.Printf("Error closing jobs redis pool: %v\n", err)
+	}
+}
+
+// DeadJobs prints the delayed jobs that failed too many times to be retried.
+// If retry is true, they're queued again.
+func (pg *Program) DeadJobs(retry bool) error {
+	q := pg.jobQueue(1)
+	defer q.Close()
+
+	if retry {
+		n, err := q.RetryDead(context.Background())
+		log.Printf("Requeued %d dead jobs\n", n)
+		return err
+	}
+	dead, err := q.DeadJobs()
+	if err != nil {
+		return err
+	}
+	for _, job := range dead {
+		log.Printf("%s job %s (payload: %s) died at %v after %d attempts: %s\n",
+			job.Kind, job.ID, job.Payload, job.DiedAt.Format(time.RFC3339), job.Attempts, job.LastError)
+	}
+	scheduled, _, err := q.Len()
+	if err != nil {
+		return err
+	}
+	log.Printf("%d dead jobs; %d jobs scheduled\n", len(dead), scheduled)
+	return nil
+}
+
+// rekognitionCredentials returns the region and the credentials of AWS
+// Rekognition, which default to those of S3.
+func (pg *Program) rekognitionCredentials() (string, aws.CredentialsProvider, error) {
+	accessKey, secretKey := pg.conf.RekognitionAccessKey, pg.conf.RekognitionSecretKey
+	if accessKey == "" {
+		accessKey, secretKey = pg.conf.S3AccessKey, pg.conf.S3SecretKey
+	}
+	region := pg.conf.RekognitionRegion
+	if region == "" {
+		region = pg.conf.S3Region
+	}
+	if region == "" {
+		return "", nil, errors.New("config rekognitionRegion is not set")
+	}
+	return region, credentials.NewStaticCredentialsProvider(accessKey, secretKey, ""), nil
+}
+
+// setupFocalPointDetection sets up the detection of the faces in uploaded
+// images, as their focal points, if it's enabled.
+func (pg *Program) setupFocalPointDetection() error {
+	if !pg.conf.RekognitionFaces {
+		return nil
+	}
+	region, creds, err := pg.rekognitionCredentials()
+	if err != nil {
+		return err
+	}
+	images.SetFocalPointDetector(&images.RekognitionFaceDetector{
+		Region:      region,
+		Credentials: creds,
+	})
+	log.Println("The focal points of uploaded images are found by AWS Rekognition")
+	return nil
+}
+
+// setupImageModeration sets up the moderation hooks that uploaded images are
+// checked by, if any are enabled.
+func (pg *Program) setupImageModeration() error {
+	var hooks []images.ModerationHook
+	if pg.conf.RekognitionEnabled {
+		region, creds, err := pg.rekognitionCredentials()
+		if err != nil {
+			return err
+		}
+		hooks = append(hooks, &images.RekognitionHook{
+			Region:        region,
+			Credentials:   creds,
+			RejectLabels:  pg.conf.RekognitionRejectLabels,
+			MinConfidence: float64(pg.conf.RekognitionMinConfidence),
+		})
+	}
+	if pg.conf.NSFWEndpoint != "" {
+		hooks = append(hooks, &images.NSFWEndpointHook{
+			URL:       pg.conf.NSFWEndpoint,
+			Threshold: float64(pg.conf.NSFWThreshold) / 100,
+		})
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	m := images.NewModerator(pg.db, hooks...)
+	m.FailOpen = pg.conf.ImageModerationFailOpen
+	if pg.conf.ImageModerationTimeout != "" {
+		timeout, err := time.ParseDuration(pg.conf.ImageModerationTimeout)
+		if err != nil {
+			return fmt.Errorf("config imageModerationTimeout: %w", err)
+		}
+		m.Timeout = timeout
+	}
+	images.SetModerator(m)
+	log.Printf("Uploaded images are checked by %d moderation hook(s)\n", len(hooks))
+	return nil
+}
+
+func (pg *Program) stopImageJobs(ctx context.Context, q *jobs.Queue) {
+	images.SetJobQueue(nil, nil, nil)
+	if err := q.Stop(ctx); err != nil {
+		log.Printf("Image post-processing workers stop error: %v\n", err)
+	} else {
+		log.Println("Gracefully exited image post-processing workers")
+	}
+	if err := q.Close(); err != nil {
+		log.Printf("Error closing image jobs redis pool: %v\n", err)
+	}
+}
+
+// startImagePrefetcher starts the workers that prefetch the thumbnails of
+// images (see images.Prefetcher). It returns nil if they're disabled.
+func (pg *Program) startImagePrefetcher() *images.Prefetcher {
+	if pg.conf.ImagePrefetchWorkers <= 0 {
+		return nil
+	}
+	p := images.NewPrefetcher(pg.db, images.PrefetcherOptions{
+		Workers:  pg.conf.ImagePrefetchWorkers,
+		WarmURLs: pg.conf.ImagePrefetchWarmURLs,
+		Client:   &http.Client{Timeout: time.Minute},
+	})
+	p.Start()
+	images.SetPrefetcher(p)
+	core.SetImagePrefetchMinMembers(pg.conf.ImagePrefetchMinMembers)
+	log.Printf("Started %d image prefetch workers\n", pg.conf.ImagePrefetchWorkers)
+	return p
+}
+
+func (pg *Program) stopImagePrefetcher(ctx context.Context, p *images.Prefetcher) {
+	images.SetPrefetcher(nil)
+	if err := p.Stop(ctx); err != nil {
+		log.Printf("Image prefetch workers stop error: %v\n", err)
+	} else {
+		log.Println("Gracefully exited image prefetch workers")
+	}
+}
+
 func (pg *Program) Config() *config.Config {
 	var c = new(config.Config)
 	*c = *pg.conf
@@ -283,10 +1113,17 @@ func (pg *Program) Config() *config.Config {
 }
 
 func (pg *Program) Close() error {
+	err := images.CloseStores()
+	if pg.replicas != nil {
+		msql.SetReadReplicas(nil)
+		err = errors.Join(err, pg.replicas.Close())
+	}
 	if pg.db != nil {
-		return pg.db.Close()
+		if dbErr := pg.db.Close(); dbErr != nil {
+			err = errors.Join(err, dbErr)
+		}
 	}
-	return nil
+	return err
 }
 
 // openDatabase returns a connection to mysql.
@@ -295,7 +1132,7 @@ func openDatabase(addr, user, password, dbName string) (*sql.DB, error) {
 		return nil, errors.New("no database selected")
 	}
 
-	db, err := sql.Open("mysql", MysqlDSN(addr, user, password, dbName))
+	db, err := openMysql(MysqlDSN(addr, user, password, dbName))
 	if err != nil {
 		return nil, err
 	}
@@ -307,6 +1144,20 @@ func openDatabase(addr, user, password, dbName string) (*sql.DB, error) {
 	return db, nil
 }
 
+// openMysql opens the MySQL database of dsn, with the durations of its queries
+// recorded (see msql.InstrumentConnector).
+func openMysql(dsn string) (*sql.DB, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(msql.InstrumentConnector(connector)), nil
+}
+
 // createSentinelUsers creates the ghost user only if migrations have been run. If
 // migrations have not yet been run, the function exists silently without
 // returning an error
@@ -394,12 +1245,29 @@ func (pg *Program) HardReset() error {
 		return err
 	}
 
+	conn, err := pg.dialRedis()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.Do("flushall"); err != nil {
+		return err
+	}
+
+	log.Println("Redis flushed")
+	log.Println("Reset complete")
+
+	return nil
+}
+
+// dialRedis returns a new connection to the Redis server.
+func (pg *Program) dialRedis() (redis.Conn, error) {
 	// Handle Redis connection with proper URL parsing
-	var conn redis.Conn
 	if strings.HasPrefix(pg.conf.RedisAddress, "redis://") || strings.HasPrefix(pg.conf.RedisAddress, "rediss://") {
 		// For rediss:// URLs, we need to use TLS
 		if strings.HasPrefix(pg.conf.RedisAddress, "rediss://") {
-			conn, err = redis.DialURL(pg.conf.RedisAddress,
+			return redis.DialURL(pg.conf.RedisAddress,
 				redis.DialUseTLS(true),
 				redis.DialTLSConfig(&tls.Config{
 					InsecureSkipVerify: true,
@@ -410,7 +1278,7 @@ func (pg *Program) HardReset() error {
 				redis.DialWriteTimeout(30*time.Second),
 			)
 		} else {
-			conn, err = redis.DialURL(pg.conf.RedisAddress,
+			return redis.DialURL(pg.conf.RedisAddress,
 				redis.DialConnectTimeout(10*time.Second),
 				redis.DialReadTimeout(30*time.Second),
 				redis.DialWriteTimeout(30*time.Second),
@@ -418,25 +1286,12 @@ func (pg *Program) HardReset() error {
 		}
 	} else {
 		// Fall back to standard TCP connection
-		conn, err = redis.Dial("tcp", pg.conf.RedisAddress,
+		return redis.Dial("tcp", pg.conf.RedisAddress,
 			redis.DialConnectTimeout(10*time.Second),
 			redis.DialReadTimeout(30*time.Second),
 			redis.DialWriteTimeout(30*time.Second),
 		)
 	}
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-
-	if _, err = conn.Do("flushall"); err != nil {
-		return err
-	}
-
-	log.Println("Redis flushed")
-	log.Println("Reset complete")
-
-	return nil
 }
 
 func (pg *Program) NewBadgeType(name string) error {
@@ -489,6 +1344,204 @@ func (pg *Program) FixPostHotScores() error {
 	return core.UpdateAllPostsHotness(pg.ctx, pg.db)
 }
 
+// CheckCounterIntegrity verifies denormalized counters against the tables
+// they're derived from and logs the counters that have drifted. If repair is
+// true, drifted counters are fixed.
+func (pg *Program) CheckCounterIntegrity(checks []string, batchSize int, repair bool) error {
+	results, err := core.CheckCounterIntegrity(pg.ctx, pg.db, core.CounterCheckOptions{
+		Checks:    checks,
+		BatchSize: batchSize,
+		Repair:    repair,
+	})
+	for _, res := range results {
+		logCounterCheckResult(res, repair)
+	}
+	return err
+}
+
+func logCounterCheckResult(res *core.CounterCheckResult, repaired bool) {
+	for _, d := range res.Drifts {
+		log.Printf("Counter drift: %v\n", d)
+	}
+	if repaired {
+		log.Printf("Counter check %s: %d rows checked, %d counters drifted, %d rows fixed\n", res.Check, res.RowsChecked, len(res.Drifts), res.RowsFixed)
+	} else {
+		log.Printf("Counter check %s: %d rows checked, %d counters drifted\n", res.Check, res.RowsChecked, len(res.Drifts))
+	}
+}
+
+// PurgeDeletedContent permanently erases the content of posts and comments
+// deleted by their authors more than days days ago. If dryRun is true, the
+// items that would be purged are only logged.
+func (pg *Program) PurgeDeletedContent(days, batchSize int, dryRun bool) error {
+	res, err := core.PurgeDeletedContent(pg.ctx, pg.db, core.PurgeOptions{
+		DeletedBefore: time.Now().Add(-time.Hour * 24 * time.Duration(days)),
+		BatchSize:     batchSize,
+		DryRun:        dryRun,
+	})
+	if res != nil {
+		if dryRun {
+			for _, id := range res.Posts {
+				log.Printf("Would purge post %v\n", id)
+			}
+			for _, id := range res.Comments {
+				log.Printf("Would purge comment %v\n", id)
+			}
+		}
+		logPurgeResult(res, dryRun)
+	}
+	return err
+}
+
+// PurgeTrash permanently deletes the images, and the content of posts, that
+// have been in the trash for longer than the trash retention window (see
+// images.TrashRetention). Unless dryRun is true, in which case it only reports
+// what would be purged.
+func (pg *Program) PurgeTrash(dryRun bool) error {
+	if !images.TrashEnabled() {
+		return errors.New("the image trash is not enabled (see imageTrashDays)")
+	}
+	return pg.purgeTrash(pg.ctx, dryRun)
+}
+
+func (pg *Program) purgeTrash(ctx context.Context, dryRun bool) error {
+	before := time.Now().Add(-images.TrashRetention)
+	n, err := core.PurgePostContentTrash(ctx, pg.db, before, dryRun)
+	if err != nil {
+		return err
+	}
+	ids, err := images.PurgeTrash(ctx, pg.db, before, dryRun)
+	if dryRun {
+		for _, id := range ids {
+			log.Printf("Would purge image %v\n", id)
+		}
+		log.Printf("Trash purge (dry run): %d images and the content of %d posts to purge\n", len(ids), n)
+	} else if len(ids) > 0 || n > 0 {
+		log.Printf("Purged %d images and the content of %d posts from the trash\n", len(ids), n)
+	}
+	return err
+}
+
+// GarbageCollectImages finds, and unless dryRun is true, deletes image
+// records that are not referred to and image files with no record (see
+// images.GarbageCollect).
+func (pg *Program) GarbageCollectImages(dryRun bool) error {
+	res, err := images.GarbageCollect(pg.ctx, pg.db, dryRun)
+	if res != nil {
+		if dryRun {
+			for _, id := range res.UnreferencedRecords {
+				log.Printf("Would delete unreferenced image %v\n", id)
+			}
+			for _, f := range res.OrphanFiles {
+				log.Printf("Would delete orphan file %s of store %s\n", f.Key, f.Store)
+			}
+		}
+		for _, id := range res.MissingFiles {
+			log.Printf("File of image %v is missing\n", id)
+		}
+		for _, id := range res.UnknownStore {
+			log.Printf("Store of image %v is not registered\n", id)
+		}
+		logImageGCResult(res, dryRun)
+	}
+	return err
+}
+
+// MigrateImages moves all the images of the store from to the store to (see
+// images.Migrate).
+func (pg *Program) MigrateImages(from, to string) error {
+	res, err := images.Migrate(pg.ctx, pg.db, from, to)
+	if res != nil {
+		for _, f := range res.Failed {
+			log.Printf("Failed to migrate image %v: %v\n", f.ID, f.Err)
+		}
+		log.Printf("Migrated %d images from store %s to store %s; %d failed\n", res.Migrated, from, to, len(res.Failed))
+	}
+	return err
+}
+
+// Import imports archive into the community named community (see
+// core.ImportArchive) and logs the report of the import.
+func (pg *Program) Import(archive *imports.Archive, community string, dryRun, fetchImages bool) error {
+	report, err := core.ImportArchive(pg.ctx, pg.db, archive, community, &core.ImportOptions{
+		DryRun:      dryRun,
+		FetchImages: fetchImages,
+		S3Enabled:   pg.conf.S3Enabled,
+	})
+	if report != nil {
+		for _, problem := range report.Problems {
+			log.Println(problem)
+		}
+		prefix := "Import"
+		if dryRun {
+			prefix = "Import (dry run)"
+		}
+		log.Printf("%s from %s into %s: users: %v; posts: %v; comments: %v\n", prefix, archive.Source, community, report.Users, report.Posts, report.Comments)
+	}
+	return err
+}
+
+func logImageGCResult(res *images.GCResult, dryRun bool) {
+	if dryRun {
+		log.Printf("Image garbage collection (dry run): %d unreferenced images and %d orphan files to delete; %d images with missing files; %d images of unregistered stores\n",
+			len(res.UnreferencedRecords), len(res.OrphanFiles), len(res.MissingFiles), len(res.UnknownStore))
+	} else {
+		log.Printf("Image garbage collection: deleted %d unreferenced images and %d orphan files; %d images with missing files; %d images of unregistered stores\n",
+			len(res.UnreferencedRecords), len(res.OrphanFiles), len(res.MissingFiles), len(res.UnknownStore))
+	}
+}
+
+func logPurgeResult(res *core.PurgeResult, dryRun bool) {
+	if dryRun {
+		log.Printf("Purge (dry run): %d posts and %d comments to purge; %d posts and %d comments under legal hold\n",
+			len(res.Posts), len(res.Comments), res.HeldPosts, res.HeldComments)
+	} else {
+		log.Printf("Purged %d posts (%d images) and %d comments; %d posts and %d comments under legal hold\n",
+			len(res.Posts), res.Images, len(res.Comments), res.HeldPosts, res.HeldComments)
+	}
+}
+
+// SetLegalHold places (or, if remove is true, removes) a legal hold on the
+// item of type target, which is identified by a username, a post's public
+// ID, or a comment ID.
+func (pg *Program) SetLegalHold(target core.LegalHoldTarget, item, note string, remove bool) error {
+	var id uid.ID
+	switch target {
+	case core.LegalHoldUser:
+		user, err := core.GetUserByUsername(pg.ctx, pg.db, item, nil)
+		if err != nil {
+			return err
+		}
+		id = user.ID
+	case core.LegalHoldPost:
+		post, err := core.GetPost(pg.ctx, pg.db, nil, item, nil, true)
+		if err != nil {
+			return err
+		}
+		id = post.ID
+	case core.LegalHoldComment:
+		var err error
+		if id, err = uid.FromString(item); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid legal hold target: %s", target)
+	}
+
+	if remove {
+		if err := core.RemoveLegalHold(pg.ctx, pg.db, target, id); err != nil {
+			return err
+		}
+		log.Printf("Legal hold on %s %s removed\n", target, item)
+		return nil
+	}
+	if err := core.PlaceLegalHold(pg.ctx, pg.db, target, id, note); err != nil {
+		return err
+	}
+	log.Printf("Legal hold placed on %s %s\n", target, item)
+	return nil
+}
+
 func (pg *Program) DeleteUser(user string, purge bool) error {
 	site, err := server.New(pg.db, pg.conf)
 	if err != nil {
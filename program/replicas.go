@@ -0,0 +1,66 @@
+package program
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/go-sql-driver/mysql"
+)
+
+// replicaCheckInterval is how often the health of the read replicas of the
+// database is checked.
+const replicaCheckInterval = 10 * time.Second
+
+// openReplicas opens the read replicas of the database (see
+// Config.DBReplicas), and routes reads to them (see msql.ReadDB). Replicas
+// that cannot be reached are opened regardless, as unhealthy ones.
+func (pg *Program) openReplicas() error {
+	if strings.TrimSpace(pg.conf.DBReplicas) == "" {
+		return nil
+	}
+	var maxLag time.Duration
+	if pg.conf.DBReplicaMaxLag != "" {
+		var err error
+		if maxLag, err = time.ParseDuration(pg.conf.DBReplicaMaxLag); err != nil {
+			return err
+		}
+	}
+
+	replicas := msql.NewReplicas(pg.db, maxLag)
+	for _, dsn := range strings.Split(pg.conf.DBReplicas, ",") {
+		if dsn = strings.TrimSpace(dsn); dsn == "" {
+			continue
+		}
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return fmt.Errorf("invalid replica DSN: %w", err)
+		}
+		cfg.ParseTime = true
+		cfg.Collation = "utf8mb4_unicode_ci"
+		cfg.Loc = time.Local
+		db, err := openMysql(cfg.FormatDSN())
+		if err != nil {
+			replicas.Close()
+			return err
+		}
+		replicas.Add(cfg.Addr, db)
+	}
+	replicas.CheckHealth(pg.ctx) // Unhealthy replicas are logged.
+
+	pg.replicas = replicas
+	msql.SetReadReplicas(replicas)
+	return nil
+}
+
+// checkReplicas checks the health of the read replicas of the database, if
+// there are any.
+func (pg *Program) checkReplicas(ctx context.Context) error {
+	if pg.replicas == nil {
+		return nil
+	}
+	pg.replicas.CheckHealth(ctx) // Changes in health are logged.
+	return nil
+}
@@ -0,0 +1,26 @@
+package program
+
+import (
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeManager returns the manager of the certificates of the site, obtained
+// (and renewed) with ACME, if they're to be (see config.Config.ACMEDomains),
+// or nil. Its TLS config answers TLS-ALPN-01 challenges, and its HTTPHandler
+// HTTP-01 challenges.
+func (pg *Program) acmeManager() *autocert.Manager {
+	if pg.conf.CertFile != "" || pg.conf.ACMEDomains == "" {
+		return nil
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(pg.conf.ACMECacheDir),
+		HostPolicy: autocert.HostWhitelist(pg.conf.ACMEDomainList()...),
+		Email:      pg.conf.ACMEEmail,
+	}
+	if pg.conf.ACMEDirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: pg.conf.ACMEDirectoryURL}
+	}
+	return m
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+)
+
+// /api/_account_key [GET]
+func (s *Server) getAccountSigningKey(w *responseWriter, r *request) error {
+	return w.writeJSON(map[string]any{
+		"publicKey": []byte(core.AccountSigningPublicKey()),
+	})
+}
+
+// /api/_account_export [GET]
+func (s *Server) exportAccount(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	if err := s.rateLimit(r, "account_export_"+r.viewer.String(), time.Hour, 5); err != nil {
+		return err
+	}
+
+	content := r.urlQueryParams().Get("content") == "true"
+	file, err := core.ExportAccount(r.ctx, s.db, *r.viewer, requestBaseURL(r.req), content)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="discuit-account.json"`)
+	return w.writeJSON(file)
+}
+
+// /api/_account_import [POST]
+func (s *Server) importAccount(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	if err := s.rateLimit(r, "account_import_"+r.viewer.String(), time.Hour, 5); err != nil {
+		return err
+	}
+
+	file := &core.AccountExportFile{}
+	if err := r.unmarshalJSONBody(file); err != nil {
+		return err
+	}
+	content := r.urlQueryParams().Get("content") == "true"
+	res, err := core.ImportAccount(r.ctx, s.db, *r.viewer, file, content)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(res)
+}
+
+// /api/_account_moved [POST]
+func (s *Server) setAccountMovedTo(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	if err := s.rateLimit(r, "account_moved_"+r.viewer.String(), time.Hour, 10); err != nil {
+		return err
+	}
+
+	var body struct {
+		MovedTo string `json:"movedTo"`
+	}
+	if err := r.unmarshalJSONBody(&body); err != nil {
+		return err
+	}
+	user, err := core.GetUser(r.ctx, s.db, *r.viewer, r.viewer)
+	if err != nil {
+		return err
+	}
+	self := core.UserProfileURL(requestBaseURL(r.req), user.Username)
+	if err := user.SetMovedTo(r.ctx, s.db, self, body.MovedTo); err != nil {
+		return err
+	}
+	return w.writeJSON(user)
+}
+
+// redirectMovedUser redirects requests for the profile pages of users who
+// moved to other instances (see core.User.SetMovedTo) to their new profile
+// pages, and reports whether it did.
+func (s *Server) redirectMovedUser(w http.ResponseWriter, r *http.Request) bool {
+	username, found := strings.CutPrefix(r.URL.Path, "/@")
+	if !found || username == "" || strings.Contains(username, "/") {
+		return false
+	}
+	user, err := core.GetUserByUsername(r.Context(), s.db, username, nil)
+	if err != nil || user.Deleted || !user.MovedTo.Valid {
+		return false
+	}
+	http.Redirect(w, r, user.MovedTo.String, http.StatusMovedPermanently)
+	return true
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// activityHeartbeatInterval is how often a comment is sent on idle activity
+// streams, so that proxies don't close them.
+const activityHeartbeatInterval = time.Second * 15
+
+// /api/activity/stream [GET]
+//
+// Streams activity events (see core.ActivityEvent), to admins, as server-sent
+// events. Query parameters: community (a community ID), arm (an experiment
+// arm ID), and types (comma separated activity types), to filter the events.
+func (s *Server) streamActivity(w *responseWriter, r *request) error {
+	if _, err := getLoggedInAdmin(s.db, r); err != nil {
+		return err
+	}
+
+	filter := &core.ActivityFilter{}
+	if v := r.urlQueryParamsValue("community"); v != "" {
+		id, err := uid.FromString(v)
+		if err != nil {
+			return httperr.NewBadRequest("invalid_community", "Invalid community ID.")
+		}
+		filter.CommunityID = &id
+	}
+	if v := r.urlQueryParamsValue("arm"); v != "" {
+		id, err := uid.FromString(v)
+		if err != nil {
+			return httperr.NewBadRequest("invalid_arm", "Invalid experiment arm ID.")
+		}
+		filter.ArmID = &id
+	}
+	if v := r.urlQueryParamsValue("types"); v != "" {
+		for _, t := range strings.Split(v, ",") {
+			t := core.ActivityType(strings.TrimSpace(t))
+			if !t.Valid() {
+				return httperr.NewBadRequest("invalid_type", fmt.Sprintf("Invalid activity type: %s.", t))
+			}
+			filter.Types = append(filter.Types, t)
+		}
+	}
+
+	sub := core.SubscribeActivity()
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no") // For nginx.
+	w.WriteHeader(200)
+	w.Flush()
+
+	heartbeat := time.NewTicker(activityHeartbeatInterval)
+	defer heartbeat.Stop()
+	var dropped int64
+	for {
+		select {
+		case <-r.ctx.Done():
+			return nil
+		case <-heartbeat.C:
+			// Let the client know of the events it missed by falling behind.
+			if n := sub.Dropped(); n != dropped {
+				dropped = n
+				fmt.Fprintf(w, "event: dropped\ndata: %d\n\n", n)
+			} else {
+				fmt.Fprint(w, ": heartbeat\n\n")
+			}
+		case e := <-sub.Events():
+			match, err := filter.Match(r.ctx, s.db, e)
+			if err != nil {
+				log.Printf("Error filtering activity event: %v\n", err)
+				continue
+			}
+			if !match {
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		}
+		w.Flush()
+	}
+}
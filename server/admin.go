@@ -2,11 +2,17 @@ package server
 
 import (
 	"database/sql"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/discuitnet/discuit/core"
 	"github.com/discuitnet/discuit/core/sitesettings"
 	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/images"
+	"github.com/discuitnet/discuit/internal/uid"
 )
 
 // getLoggedInAdmin returns the logged in admin, if the
@@ -102,6 +108,47 @@ func (s *Server) adminActions(w *responseWriter, r *request) error {
 		if err = comm.SetDefault(r.ctx, s.db, action == "add_default_forum"); err != nil {
 			return err
 		}
+	case "quarantine_community", "unquarantine_community":
+		name, ok := reqBody["name"].(string)
+		if !ok {
+			return invalidJSONErr
+		}
+		comm, err := core.GetCommunityByName(r.ctx, s.db, name, r.viewer)
+		if err != nil {
+			return err
+		}
+		if action == "quarantine_community" {
+			reason, _ := reqBody["reason"].(string)
+			err = comm.Quarantine(r.ctx, s.db, reason)
+		} else {
+			err = comm.Unquarantine(r.ctx, s.db)
+		}
+		if err != nil {
+			return err
+		}
+	case "schedule_community_purge", "cancel_community_purge":
+		name, ok := reqBody["name"].(string)
+		if !ok {
+			return invalidJSONErr
+		}
+		comm, err := core.GetCommunityByName(r.ctx, s.db, name, r.viewer)
+		if err != nil {
+			return err
+		}
+		if action == "schedule_community_purge" {
+			days := 30.0
+			if _, ok := reqBody["days"]; ok {
+				if days, ok = reqBody["days"].(float64); !ok || days < 0 {
+					return invalidJSONErr
+				}
+			}
+			err = comm.SchedulePurge(r.ctx, s.db, *r.viewer, time.Duration(days*24)*time.Hour)
+		} else {
+			err = comm.CancelPurge(r.ctx, s.db)
+		}
+		if err != nil {
+			return err
+		}
 	default:
 		return httperr.NewBadRequest("invalid_action", "Unsupported admin action.")
 	}
@@ -157,6 +204,64 @@ func (s *Server) getUsers(w *responseWriter, r *request) error {
 	return w.writeJSON(res)
 }
 
+// /api/users/{username}/activity [GET]
+//
+// Accepted query parameters are types (a comma separated list of activity
+// types), since and until (RFC 3339 timestamps), limit, and next.
+func (s *Server) getUserActivity(w *responseWriter, r *request) error {
+	_, err := getLoggedInAdmin(s.db, r)
+	if err != nil {
+		return err
+	}
+
+	user, err := core.GetUserByUsername(r.ctx, s.db, r.muxVar("username"), nil)
+	if err != nil {
+		return err
+	}
+
+	query := r.req.URL.Query()
+	var filter core.UserActivityFilter
+	if types := query.Get("types"); types != "" {
+		for _, t := range strings.Split(types, ",") {
+			filter.Types = append(filter.Types, core.UserActivityType(strings.TrimSpace(t)))
+		}
+	}
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		if v := query.Get(p.name); v != "" {
+			if *p.t, err = time.Parse(time.RFC3339, v); err != nil {
+				return httperr.NewBadRequest("invalid_"+p.name, "Invalid "+p.name+" timestamp.")
+			}
+		}
+	}
+
+	limit := 50
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > 200 {
+			return httperr.NewBadRequest("invalid_limit", "Limit must be between 1 and 200.")
+		}
+	}
+
+	var nextPtr *string
+	if next := query.Get("next"); next != "" {
+		nextPtr = &next
+	}
+
+	items, nextNext, err := core.GetUserActivity(r.ctx, s.db, user.ID, filter, limit, nextPtr)
+	if err != nil {
+		return err
+	}
+
+	res := struct {
+		Items []*core.UserActivity `json:"items"`
+		Next  *string              `json:"next"`
+	}{items, nextNext}
+
+	return w.writeJSON(res)
+}
+
 func (s *Server) handleSiteSettings(w *responseWriter, r *request) error {
 	_, err := getLoggedInAdmin(s.db, r)
 	if err != nil {
@@ -194,6 +299,14 @@ func (s *Server) getBasicSiteStats(w *responseWriter, r *request) error {
 	return w.writeJSON(events)
 }
 
+// /api/analytics/image_cache [GET]
+func (s *Server) getImageCacheStats(w *responseWriter, r *request) error {
+	if _, err := getLoggedInAdmin(s.db, r); err != nil {
+		return err
+	}
+	return w.writeJSON(images.GetCacheStats())
+}
+
 func (s *Server) getCommunityRequests(w *responseWriter, r *request) error {
 	_, err := getLoggedInAdmin(s.db, r)
 	if err != nil {
@@ -207,3 +320,225 @@ func (s *Server) getCommunityRequests(w *responseWriter, r *request) error {
 
 	return w.writeJSON(request)
 }
+
+// /api/image_moderation/rejections [GET]
+//
+// Returns the most recent rejections of uploaded images by moderation hooks.
+// If the query parameter unreviewed is true, only the rejections yet to be
+// reviewed are returned.
+func (s *Server) getImageModerationRejections(w *responseWriter, r *request) error {
+	if _, err := getLoggedInAdmin(s.db, r); err != nil {
+		return err
+	}
+
+	limit := 100
+	if v := r.urlQueryParamsValue("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > 500 {
+			return httperr.NewBadRequest("invalid_limit", "Invalid limit.")
+		}
+	}
+	unreviewed := r.urlQueryParamsValue("unreviewed") == "true"
+
+	rejections, err := images.GetModerationRejections(r.ctx, s.db, unreviewed, limit)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(rejections)
+}
+
+// /api/image_moderation/rejections/{rejectionID} [PUT]
+//
+// Marks a rejection as reviewed. If overturn is true in the request body, the
+// rejected image is accepted when uploaded again.
+func (s *Server) reviewImageModerationRejection(w *responseWriter, r *request) error {
+	admin, err := getLoggedInAdmin(s.db, r)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.Atoi(r.muxVar("rejectionID"))
+	if err != nil {
+		return httperr.NewBadRequest("invalid_id", "Invalid rejection ID.")
+	}
+
+	var body struct {
+		Overturn bool `json:"overturn"`
+	}
+	if err := r.unmarshalJSONBody(&body); err != nil {
+		return err
+	}
+
+	if err := images.ReviewModerationRejection(r.ctx, s.db, id, admin.ID, body.Overturn); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return httperr.NewNotFound("rejection_not_found", "Rejection not found.")
+		}
+		return err
+	}
+	return w.writeString(`{"success":true}`)
+}
+
+// /api/image_quotas [GET]
+//
+// Returns the upload quotas of the users (or, if the query parameter type is
+// community, of the communities) that uploaded the most.
+func (s *Server) getImageQuotas(w *responseWriter, r *request) error {
+	if _, err := getLoggedInAdmin(s.db, r); err != nil {
+		return err
+	}
+
+	ownerType := images.QuotaOwnerUser
+	if v := r.urlQueryParamsValue("type"); v != "" {
+		if ownerType = images.QuotaOwnerType(v); !ownerType.Valid() {
+			return httperr.NewBadRequest("invalid_owner_type", "Invalid quota owner type.")
+		}
+	}
+	limit := 100
+	if v := r.urlQueryParamsValue("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > 500 {
+			return httperr.NewBadRequest("invalid_limit", "Invalid limit.")
+		}
+	}
+
+	quotas, err := images.GetQuotas(r.ctx, s.db, ownerType, limit)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(quotas)
+}
+
+// /api/image_quotas/{ownerType}/{ownerID} [GET, PUT]
+//
+// A PUT request overrides the quota of the owner with the number of bytes in
+// the override field of the body (or, if it's null, reverts it to the default
+// quota), and resets the bytes uploaded by the owner if resetUsage is true.
+func (s *Server) handleImageQuota(w *responseWriter, r *request) error {
+	if _, err := getLoggedInAdmin(s.db, r); err != nil {
+		return err
+	}
+
+	owner := images.QuotaOwner{Type: images.QuotaOwnerType(r.muxVar("ownerType"))}
+	if !owner.Type.Valid() {
+		return httperr.NewBadRequest("invalid_owner_type", "Invalid quota owner type.")
+	}
+	var err error
+	if owner.ID, err = uid.FromString(r.muxVar("ownerID")); err != nil {
+		return httperr.NewBadRequest("invalid_id", "Invalid owner ID.")
+	}
+
+	if r.req.Method == "PUT" {
+		var body struct {
+			Override   *int64 `json:"override"`
+			ResetUsage bool   `json:"resetUsage"`
+		}
+		if err := r.unmarshalJSONBody(&body); err != nil {
+			return err
+		}
+		if body.Override != nil && *body.Override < 0 {
+			return httperr.NewBadRequest("invalid_quota", "Quota cannot be negative.")
+		}
+		if _, err := images.SetQuota(r.ctx, s.db, owner, body.Override); err != nil {
+			return err
+		}
+		if body.ResetUsage {
+			if _, err := images.ResetQuotaUsage(r.ctx, s.db, owner); err != nil {
+				return err
+			}
+		}
+	}
+
+	quota, err := images.GetQuota(r.ctx, s.db, owner)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(quota)
+}
+
+// /api/link_warnings [GET, POST]
+//
+// A POST request adds a domain to the link warning list (or updates it). Its
+// body is of the form {"domain": "...", "reason": "shortener|scam|malware|other",
+// "note": "..."}.
+func (s *Server) handleLinkWarnings(w *responseWriter, r *request) error {
+	admin, err := getLoggedInAdmin(s.db, r)
+	if err != nil {
+		return err
+	}
+
+	if r.req.Method == "POST" {
+		var body struct {
+			Domain string                 `json:"domain"`
+			Reason core.LinkWarningReason `json:"reason"`
+			Note   string                 `json:"note"`
+		}
+		if err := r.unmarshalJSONBody(&body); err != nil {
+			return err
+		}
+		domain, err := core.AddWarnedDomain(r.ctx, s.db, admin.ID, body.Domain, body.Reason, body.Note)
+		if err != nil {
+			return err
+		}
+		return w.writeJSON(domain)
+	}
+
+	domains, err := core.GetWarnedDomains(r.ctx, s.db)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(domains)
+}
+
+// /api/link_warnings/{domain} [DELETE]
+func (s *Server) deleteLinkWarning(w *responseWriter, r *request) error {
+	admin, err := getLoggedInAdmin(s.db, r)
+	if err != nil {
+		return err
+	}
+	if err := core.RemoveWarnedDomain(r.ctx, s.db, admin.ID, r.muxVar("domain")); err != nil {
+		return err
+	}
+	return w.writeString(`{"success":true}`)
+}
+
+// /api/bots/usage [GET]
+//
+// Returns the OpenAI API usage of bots (of this process), and its limits.
+func (s *Server) getBotUsage(w *responseWriter, r *request) error {
+	if _, err := getLoggedInAdmin(s.db, r); err != nil {
+		return err
+	}
+	return w.writeJSON(core.GetBotUsage())
+}
+
+// /api/bots/kill_switch [GET, PUT]
+//
+// PUT turns the bot kill switch on or off, with a JSON body of the form
+// {"halted": true, "reason": "..."}.
+func (s *Server) handleBotKillSwitch(w *responseWriter, r *request) error {
+	admin, err := getLoggedInAdmin(s.db, r)
+	if err != nil {
+		return err
+	}
+
+	if r.req.Method == "PUT" {
+		var body struct {
+			Halted bool   `json:"halted"`
+			Reason string `json:"reason"`
+		}
+		if err := r.unmarshalJSONBody(&body); err != nil {
+			return err
+		}
+		ks, err := core.SetBotKillSwitch(r.ctx, s.db, admin.ID, body.Halted, body.Reason)
+		if err != nil {
+			return err
+		}
+		return w.writeJSON(ks)
+	}
+
+	ks, err := core.GetBotKillSwitch(r.ctx, s.db)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(ks)
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/sessions"
+)
+
+// Requests made with an admin-issued API key (see core.APIKey), given in an
+// "Authorization: Bearer <key>" or an "X-Api-Key" header, skip sessions
+// altogether: they're anonymous, limited to the GET routes in
+// routeAPIKeyScopes that the key has the scope of, and rate limited by the
+// tier of the key.
+
+// defaultAPIKeyTiers are the built-in API key tiers. Each tier is a comma
+// separated list of rate limits of the form "requests/interval".
+var defaultAPIKeyTiers = map[string]string{
+	"basic":    "60/1m,10000/24h",
+	"research": "300/1m,100000/24h",
+	"archive":  "1200/1m,1000000/24h",
+}
+
+// routeAPIKeyScopes are the routes that can be requested with API keys, keyed
+// like routeLatencyBudgets, along with the scope needed for each.
+var routeAPIKeyScopes = map[string]core.APIKeyScope{
+	"GET /api/posts":                           core.APIKeyScopePosts,
+	"GET /api/posts/{postID}":                  core.APIKeyScopePosts,
+	"GET /api/posts/{postID}/comments":         core.APIKeyScopeComments,
+	"GET /api/comments/{commentID}":            core.APIKeyScopeComments,
+	"GET /api/communities":                     core.APIKeyScopeCommunities,
+	"GET /api/communities/{communityID}":       core.APIKeyScopeCommunities,
+	"GET /api/communities/{communityID}/rules": core.APIKeyScopeCommunities,
+	"GET /api/communities/{communityID}/mods":  core.APIKeyScopeCommunities,
+	"GET /api/users/{username}":                core.APIKeyScopeUsers,
+	"GET /api/users/{username}/feed":           core.APIKeyScopeUsers,
+}
+
+var (
+	errAPIKeyRoute = httperr.NewForbidden("api_key_route", "This endpoint cannot be requested with an API key.")
+	errAPIKeyScope = httperr.NewForbidden("api_key_scope", "The API key does not have the scope needed for this endpoint.")
+	errAPIKeyTier  = httperr.NewForbidden("api_key_tier", "The tier of the API key no longer exists.")
+)
+
+// apiRateLimit is a rate limit of n requests per interval.
+type apiRateLimit struct {
+	n        int
+	interval time.Duration
+}
+
+// parseAPIKeyTier parses the rate limits of an API key tier (see
+// defaultAPIKeyTiers).
+func parseAPIKeyTier(s string) ([]apiRateLimit, error) {
+	var limits []apiRateLimit
+	for _, part := range strings.Split(s, ",") {
+		nstr, dstr, found := strings.Cut(strings.TrimSpace(part), "/")
+		if !found {
+			return nil, fmt.Errorf("rate limit %q is not of the form requests/interval", part)
+		}
+		n, err := strconv.Atoi(nstr)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid number of requests in rate limit %q", part)
+		}
+		d, err := time.ParseDuration(dstr)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid interval in rate limit %q", part)
+		}
+		limits = append(limits, apiRateLimit{n: n, interval: d})
+	}
+	return limits, nil
+}
+
+// newAPIKeyTiers returns the API key tiers, which are those of
+// defaultAPIKeyTiers overridden (and added to) by overrides.
+func newAPIKeyTiers(overrides map[string]string) (map[string][]apiRateLimit, error) {
+	tiers := make(map[string][]apiRateLimit)
+	for _, m := range []map[string]string{defaultAPIKeyTiers, overrides} {
+		for name, s := range m {
+			limits, err := parseAPIKeyTier(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid API key tier %s: %w", name, err)
+			}
+			tiers[name] = limits
+		}
+	}
+	return tiers, nil
+}
+
+// requestAPIKey returns the API key r is made with, if any.
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-Api-Key"); key != "" {
+		return key
+	}
+	if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// serveAPIKeyRequest serves r, which is made with the API key secret, with h.
+func (s *Server) serveAPIKeyRequest(w http.ResponseWriter, r *http.Request, secret string, h handler) {
+	req := newRequest(r, &sessions.Session{Values: make(map[string]interface{})})
+	if err := s.authorizeAPIKey(req, secret); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	if err := h(&responseWriter{w: w}, req); err != nil {
+		s.writeHandlerError(w, r, err)
+	}
+}
+
+// authorizeAPIKey returns an error if r cannot be made with the API key secret.
+func (s *Server) authorizeAPIKey(r *request, secret string) error {
+	key, err := core.AuthenticateAPIKey(r.ctx, s.db, secret)
+	if err != nil {
+		return err
+	}
+	route := routeKey(r.req)
+	scope, ok := routeAPIKeyScopes[route]
+	if !ok {
+		return errAPIKeyRoute
+	}
+	if !key.HasScope(scope) {
+		return errAPIKeyScope
+	}
+	limits, ok := s.apiKeyTiers[key.Tier]
+	if !ok {
+		return errAPIKeyTier
+	}
+	for i, limit := range limits {
+		if err := s.rateLimit(r, fmt.Sprintf("api_key_%d_%d", key.ID, i), limit.interval, limit.n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// /api/api_keys [GET, POST]
+func (s *Server) handleAPIKeys(w *responseWriter, r *request) error {
+	admin, err := getLoggedInAdmin(s.db, r)
+	if err != nil {
+		return err
+	}
+
+	if r.req.Method == "POST" {
+		var body struct {
+			Name    string             `json:"name"`
+			Contact string             `json:"contact"`
+			Tier    string             `json:"tier"`
+			Scopes  []core.APIKeyScope `json:"scopes"`
+		}
+		if err := r.unmarshalJSONBody(&body); err != nil {
+			return err
+		}
+		if _, ok := s.apiKeyTiers[body.Tier]; !ok {
+			return httperr.NewBadRequest("invalid_tier", "Invalid API key tier.")
+		}
+		key, secret, err := core.CreateAPIKey(r.ctx, s.db, admin.ID, body.Name, body.Contact, body.Tier, body.Scopes)
+		if err != nil {
+			return err
+		}
+		return w.writeJSON(struct {
+			*core.APIKey
+			Key string `json:"key"` // Shown only once.
+		}{key, secret})
+	}
+
+	keys, err := core.GetAPIKeys(r.ctx, s.db)
+	if err != nil {
+		return err
+	}
+	tiers := make([]string, 0, len(s.apiKeyTiers))
+	for name := range s.apiKeyTiers {
+		tiers = append(tiers, name)
+	}
+	sort.Strings(tiers)
+	return w.writeJSON(struct {
+		Keys  []*core.APIKey `json:"keys"`
+		Tiers []string       `json:"tiers"`
+	}{keys, tiers})
+}
+
+// /api/api_keys/{keyID} [DELETE]
+func (s *Server) revokeAPIKey(w *responseWriter, r *request) error {
+	admin, err := getLoggedInAdmin(s.db, r)
+	if err != nil {
+		return err
+	}
+	id, err := strconv.Atoi(r.muxVar("keyID"))
+	if err != nil {
+		return httperr.NewBadRequest("invalid_id", "Invalid API key ID.")
+	}
+	if err := core.RevokeAPIKey(r.ctx, s.db, admin.ID, id); err != nil {
+		return err
+	}
+	return w.writeString(`{"success":true}`)
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/images"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// /api/bandwidth [GET]
+//
+// Returns the users (or, if the query parameter type is community, the
+// communities, or, if it's image, the images) whose images used the most
+// bandwidth on the day in the query parameter day (YYYY-MM-DD, in UTC; today
+// by default). If the query parameter sort is external, they're ordered by
+// the bytes served to other sites.
+func (s *Server) getBandwidth(w *responseWriter, r *request) error {
+	if _, err := getLoggedInAdmin(s.db, r); err != nil {
+		return err
+	}
+
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+	if v := r.urlQueryParamsValue("day"); v != "" {
+		var err error
+		if day, err = time.Parse(time.DateOnly, v); err != nil {
+			return httperr.NewBadRequest("invalid_day", "Invalid day.")
+		}
+	}
+	var external bool
+	switch r.urlQueryParamsValue("sort") {
+	case "", "bytes":
+	case "external":
+		external = true
+	default:
+		return httperr.NewBadRequest("invalid_sort", "Invalid sort.")
+	}
+	limit := 100
+	if v := r.urlQueryParamsValue("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > 500 {
+			return httperr.NewBadRequest("invalid_limit", "Invalid limit.")
+		}
+	}
+
+	if r.urlQueryParamsValue("type") == "image" {
+		list, err := images.GetImageBandwidth(r.ctx, s.db, day, external, limit)
+		if err != nil {
+			return err
+		}
+		return w.writeJSON(list)
+	}
+
+	ownerType := images.QuotaOwnerUser
+	if v := r.urlQueryParamsValue("type"); v != "" {
+		if ownerType = images.QuotaOwnerType(v); !ownerType.Valid() {
+			return httperr.NewBadRequest("invalid_owner_type", "Invalid owner type.")
+		}
+	}
+	rollups, err := core.GetBandwidthRollups(r.ctx, s.db, ownerType, day, external, limit)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(rollups)
+}
+
+// /api/bandwidth/{ownerType}/{ownerID} [GET]
+//
+// Returns the daily bandwidth usage of the images of a user or a community,
+// for the number of days in the query parameter days (30 by default).
+func (s *Server) getOwnerBandwidth(w *responseWriter, r *request) error {
+	if _, err := getLoggedInAdmin(s.db, r); err != nil {
+		return err
+	}
+
+	owner := images.QuotaOwner{Type: images.QuotaOwnerType(r.muxVar("ownerType"))}
+	if !owner.Type.Valid() {
+		return httperr.NewBadRequest("invalid_owner_type", "Invalid owner type.")
+	}
+	var err error
+	if owner.ID, err = uid.FromString(r.muxVar("ownerID")); err != nil {
+		return httperr.NewBadRequest("invalid_id", "Invalid owner ID.")
+	}
+	days := 30
+	if v := r.urlQueryParamsValue("days"); v != "" {
+		if days, err = strconv.Atoi(v); err != nil || days < 1 || days > 366 {
+			return httperr.NewBadRequest("invalid_days", "Invalid number of days.")
+		}
+	}
+
+	since := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -(days - 1))
+	rollups, err := core.GetOwnerBandwidth(r.ctx, s.db, owner, since)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(rollups)
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+)
+
+// Request bodies of API requests are limited in size: requests with larger
+// bodies are rejected with a 413, before the body is read if its length is
+// known, and otherwise as soon as more than the limit has been read.
+
+// defaultMaxBodySize is the body size limit of routes that are neither in
+// routeBodyLimits nor configured otherwise. It's enough for any JSON body the
+// API accepts.
+const defaultMaxBodySize = 1 << 20 // 1 MiB
+
+// multipartOverhead is allowed on top of the max image size for the
+// boundaries and headers of multipart forms.
+const multipartOverhead = 64 << 10
+
+// routeBodyLimits are the built-in body size limits of routes that need less
+// (or more) than defaultMaxBodySize, keyed like routeLatencyBudgets. Image upload routes
+// are limited by the max image size (see newBodyLimits).
+var routeBodyLimits = map[string]int{
+	// Post and comment bodies are at most 20,000 runes, which could be up to
+	// 240 KB when JSON encoded (as \uXXXX\uXXXX surrogate pairs).
+	"POST /api/posts":                                   256 << 10,
+	"PUT /api/posts/{postID}":                           256 << 10,
+	"POST /api/posts/{postID}/comments":                 256 << 10,
+	"PUT /api/posts/{postID}/comments/{commentID}":      256 << 10,
+	"POST /api/_login":                                  4 << 10,
+	"POST /api/_signup":                                 16 << 10,
+	"POST /api/_postVote":                               4 << 10,
+	"POST /api/_commentVote":                            4 << 10,
+	"PUT /api/votes":                                    4 << 10,
+	"POST /api/_report":                                 16 << 10,
+	"POST /api/communities/{communityID}/rules":         16 << 10,
+	"PUT /api/communities/{communityID}/rules/{ruleID}": 16 << 10,
+	"POST /api/_account_import":                         32 << 20, // With the user's posts and comments.
+	"POST /api/cohorts/{cohortID}/roster":               2 << 20,  // A CSV roster file.
+}
+
+// imageUploadRoutes are the routes that accept multipart image uploads.
+var imageUploadRoutes = []string{
+	"POST /api/_uploads",
+	"POST /api/users/{username}/pro_pic",
+	"POST /api/communities/{communityID}/pro_pic",
+	"POST /api/communities/{communityID}/banner_image",
+}
+
+var errBodyTooLarge = &httperr.Error{
+	HTTPStatus: http.StatusRequestEntityTooLarge,
+	Code:       "body_too_large",
+	Message:    "Request body too large.",
+}
+
+// bodyLimits holds the body size limits of routes.
+type bodyLimits struct {
+	defaultLimit int64 // If 0, routes without a limit are not limited.
+	limits       map[string]int64
+}
+
+// newBodyLimits returns the body size limits of routes, which are those of
+// routeBodyLimits (and of imageUploadRoutes, maxImageSize plus
+// multipartOverhead) overridden by overrides. If defaultLimit is not 0, it
+// replaces defaultMaxBodySize (-1 disables the default limit).
+func newBodyLimits(defaultLimit, maxImageSize int, overrides map[string]int) *bodyLimits {
+	bl := &bodyLimits{
+		defaultLimit: defaultMaxBodySize,
+		limits:       make(map[string]int64),
+	}
+	if defaultLimit > 0 {
+		bl.defaultLimit = int64(defaultLimit)
+	} else if defaultLimit < 0 {
+		bl.defaultLimit = 0
+	}
+	for route, n := range routeBodyLimits {
+		bl.limits[route] = int64(n)
+	}
+	for _, route := range imageUploadRoutes {
+		bl.limits[route] = int64(maxImageSize + multipartOverhead)
+	}
+	for route, n := range overrides {
+		bl.limits[route] = int64(n)
+	}
+	return bl
+}
+
+// limit returns the body size limit of route, or 0 if route has no limit.
+func (bl *bodyLimits) limit(route string) int64 {
+	if n, ok := bl.limits[route]; ok {
+		return max(n, 0)
+	}
+	return bl.defaultLimit
+}
+
+// withBodyLimit is a mux middleware that enforces the body size limit of the
+// matched route.
+func (s *Server) withBodyLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := s.bodyLimits.limit(routeKey(r))
+		if limit > 0 && r.Body != nil && r.Body != http.NoBody {
+			if r.ContentLength > limit {
+				s.writeError(w, r, errBodyTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isBodyTooLarge reports whether err was caused by a request body exceeding
+// its size limit.
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// /api/bots/personas [GET, POST]
+func (s *Server) handleBotPersonas(w *responseWriter, r *request) error {
+	admin, err := getLoggedInAdmin(s.db, r)
+	if err != nil {
+		return err
+	}
+
+	if r.req.Method == "POST" {
+		persona := &core.BotPersona{}
+		if err := r.unmarshalJSONBody(persona); err != nil {
+			return err
+		}
+		if persona, err = core.CreateBotPersona(r.ctx, s.db, admin.ID, persona); err != nil {
+			return err
+		}
+		return w.writeJSON(persona)
+	}
+
+	personas, err := core.GetBotPersonas(r.ctx, s.db)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(personas)
+}
+
+// /api/bots/personas/{personaID} [GET, PUT, DELETE]
+func (s *Server) handleBotPersona(w *responseWriter, r *request) error {
+	admin, err := getLoggedInAdmin(s.db, r)
+	if err != nil {
+		return err
+	}
+	id, err := uid.FromString(r.muxVar("personaID"))
+	if err != nil {
+		return httperr.NewBadRequest("invalid_id", "Invalid persona ID.")
+	}
+	persona, err := core.GetBotPersona(r.ctx, s.db, id)
+	if err != nil {
+		return err
+	}
+
+	switch r.req.Method {
+	case "PUT":
+		to := &core.BotPersona{}
+		if err := r.unmarshalJSONBody(to); err != nil {
+			return err
+		}
+		if err := persona.Update(r.ctx, s.db, admin.ID, to); err != nil {
+			return err
+		}
+	case "DELETE":
+		if err := persona.Delete(r.ctx, s.db, admin.ID); err != nil {
+			return err
+		}
+	}
+	return w.writeJSON(persona)
+}
+
+// /api/bots/personas/{personaID}/disabled [PUT]
+func (s *Server) setBotPersonaDisabled(w *responseWriter, r *request) error {
+	admin, err := getLoggedInAdmin(s.db, r)
+	if err != nil {
+		return err
+	}
+	id, err := uid.FromString(r.muxVar("personaID"))
+	if err != nil {
+		return httperr.NewBadRequest("invalid_id", "Invalid persona ID.")
+	}
+	persona, err := core.GetBotPersona(r.ctx, s.db, id)
+	if err != nil {
+		return err
+	}
+
+	body := struct {
+		Disabled bool `json:"disabled"`
+	}{}
+	if err := r.unmarshalJSONBody(&body); err != nil {
+		return err
+	}
+	if err := persona.SetDisabled(r.ctx, s.db, admin.ID, body.Disabled); err != nil {
+		return err
+	}
+	return w.writeJSON(persona)
+}
+
+// /api/bots/report_stats [GET]
+func (s *Server) getBotReportStats(w *responseWriter, r *request) error {
+	admin, err := getLoggedInAdmin(s.db, r)
+	if err != nil {
+		return err
+	}
+	stats, err := core.GetBotReportStats(r.ctx, s.db, admin.ID)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(stats)
+}
+
+// /api/users/{username}/bot_persona [GET, PUT]
+//
+// PUT assigns a persona to the bot user, with a JSON body of the form
+// {"personaId": "..."}, or with a null personaId, leaves it without one.
+func (s *Server) handleBotUserPersona(w *responseWriter, r *request) error {
+	admin, err := getLoggedInAdmin(s.db, r)
+	if err != nil {
+		return err
+	}
+	bot, err := core.GetUserByUsername(r.ctx, s.db, r.muxVar("username"), nil)
+	if err != nil {
+		return err
+	}
+
+	if r.req.Method == "PUT" {
+		var body struct {
+			PersonaID *uid.ID `json:"personaId"`
+		}
+		if err := r.unmarshalJSONBody(&body); err != nil {
+			return err
+		}
+		if err := core.AssignBotPersona(r.ctx, s.db, admin.ID, bot.ID, body.PersonaID); err != nil {
+			return err
+		}
+	}
+
+	persona, err := core.GetBotUserPersona(r.ctx, s.db, bot.ID)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(persona)
+}
+
+// /api/posts/{postID}/snapshots [GET]
+//
+// Returns the snapshots of the thread of the post (see
+// core.SnapshotBotThreads), oldest first.
+func (s *Server) getThreadSnapshots(w *responseWriter, r *request) error {
+	if _, err := getLoggedInAdmin(s.db, r); err != nil {
+		return err
+	}
+	post, err := core.GetPost(r.ctx, s.db, nil, r.muxVar("postID"), nil, true)
+	if err != nil {
+		return err
+	}
+	snapshots, err := core.GetThreadSnapshots(r.ctx, s.db, post.ID)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(snapshots)
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// /api/cohorts [GET, POST]
+func (s *Server) handleCohorts(w *responseWriter, r *request) error {
+	admin, err := getLoggedInAdmin(s.db, r)
+	if err != nil {
+		return err
+	}
+
+	if r.req.Method == "POST" {
+		var body struct {
+			Name string `json:"name"`
+		}
+		if err := r.unmarshalJSONBody(&body); err != nil {
+			return err
+		}
+		cohort, err := core.CreateCohort(r.ctx, s.db, admin.ID, body.Name)
+		if err != nil {
+			return err
+		}
+		return w.writeJSON(cohort)
+	}
+
+	cohorts, err := core.GetCohorts(r.ctx, s.db)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(cohorts)
+}
+
+// requestCohort returns the cohort of the cohortID route variable of r, which
+// is requested by an admin.
+func (s *Server) requestCohort(r *request) (*core.User, *core.Cohort, error) {
+	admin, err := getLoggedInAdmin(s.db, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	id, err := uid.FromString(r.muxVar("cohortID"))
+	if err != nil {
+		return nil, nil, httperr.NewBadRequest("invalid_id", "Invalid cohort ID.")
+	}
+	cohort, err := core.GetCohort(r.ctx, s.db, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return admin, cohort, nil
+}
+
+// /api/cohorts/{cohortID} [GET]
+func (s *Server) getCohort(w *responseWriter, r *request) error {
+	_, cohort, err := s.requestCohort(r)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(cohort)
+}
+
+// /api/cohorts/{cohortID}/roster [GET, POST]
+//
+// A roster file (see core.ParseRoster) is posted as the body of the request.
+// With the format=csv query parameter, invites are returned as a CSV file.
+func (s *Server) handleCohortRoster(w *responseWriter, r *request) error {
+	admin, cohort, err := s.requestCohort(r)
+	if err != nil {
+		return err
+	}
+	csv := r.urlQueryParamsValue("format") == "csv"
+
+	if r.req.Method == "POST" {
+		entries, err := core.ParseRoster(r.req.Body)
+		if err != nil {
+			return err
+		}
+		report, err := core.ImportRoster(r.ctx, s.db, admin.ID, cohort, entries)
+		if err != nil {
+			return err
+		}
+		if csv {
+			return writeRosterCSV(w, report.Invites)
+		}
+		return w.writeJSON(report)
+	}
+
+	invites, err := core.GetCohortRoster(r.ctx, s.db, cohort)
+	if err != nil {
+		return err
+	}
+	if csv {
+		return writeRosterCSV(w, invites)
+	}
+	return w.writeJSON(invites)
+}
+
+func writeRosterCSV(w *responseWriter, invites []*core.RosterInvite) error {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="invites.csv"`)
+	return core.WriteRosterInvitesCSV(w, invites)
+}
+
+// /api/cohorts/{cohortID}/participation [GET]
+//
+// Participation is counted since the since query parameter (an RFC 3339
+// timestamp), or since the cohort was created. With the format=csv query
+// parameter, the participation of each person is returned as a CSV file. See
+// pseudonymizeView for the pseudonymize and scope query parameters.
+func (s *Server) getCohortParticipation(w *responseWriter, r *request) error {
+	_, cohort, err := s.requestCohort(r)
+	if err != nil {
+		return err
+	}
+
+	since := cohort.CreatedAt
+	if v := r.urlQueryParamsValue("since"); v != "" {
+		if since, err = time.Parse(time.RFC3339, v); err != nil {
+			return httperr.NewBadRequest("invalid_since", "Invalid since timestamp.")
+		}
+	}
+	p, err := core.GetCohortParticipation(r.ctx, s.db, cohort, since)
+	if err != nil {
+		return err
+	}
+	if err := s.pseudonymizeView(r, p); err != nil {
+		return err
+	}
+
+	if r.urlQueryParamsValue("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="participation.csv"`)
+		return p.WriteCSV(w)
+	}
+	return w.writeJSON(p)
+}
+
+// /api/cohorts/{cohortID}/engagement [GET]
+//
+// Query parameters: from and to (the date range, as YYYY-MM-DD dates, both
+// inclusive, or as RFC 3339 timestamps, to being exclusive; by default, from
+// the creation of the cohort to now), pseudonymize (none, stable, or random;
+// stable by default), and format (csv or json; json by default).
+func (s *Server) getCohortEngagement(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	id, err := uid.FromString(r.muxVar("cohortID"))
+	if err != nil {
+		return httperr.NewBadRequest("invalid_id", "Invalid cohort ID.")
+	}
+	cohort, err := core.GetCohort(r.ctx, s.db, id)
+	if err != nil {
+		return err
+	}
+
+	from, to := cohort.CreatedAt, time.Now()
+	if v := r.urlQueryParamsValue("from"); v != "" {
+		if from, err = parseRangeTime(v, false); err != nil {
+			return httperr.NewBadRequest("invalid_from", "Invalid from date.")
+		}
+	}
+	if v := r.urlQueryParamsValue("to"); v != "" {
+		if to, err = parseRangeTime(v, true); err != nil {
+			return httperr.NewBadRequest("invalid_to", "Invalid to date.")
+		}
+	}
+	p := core.PseudonymizeStable
+	if v := r.urlQueryParamsValue("pseudonymize"); v != "" {
+		p = core.Pseudonymization(v)
+	}
+
+	e, err := cohort.GetEngagement(r.ctx, s.db, *r.viewer, from, to, p)
+	if err != nil {
+		return err
+	}
+	if r.urlQueryParamsValue("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="engagement.csv"`)
+		return e.WriteCSV(w)
+	}
+	return w.writeJSON(e)
+}
+
+// parseRangeTime parses v, either a date (YYYY-MM-DD) or an RFC 3339
+// timestamp. If end is true, a date is taken to be the end of a date range,
+// and the returned time is the end of the day.
+func parseRangeTime(v string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
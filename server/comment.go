@@ -1,11 +1,6 @@
 package server
 
 import (
-	"context"
-	"time"
-
-	"log"
-
 	"github.com/discuitnet/discuit/core"
 	"github.com/discuitnet/discuit/internal/httperr"
 	msql "github.com/discuitnet/discuit/internal/sql"
@@ -18,9 +13,32 @@ func (s *Server) getPostComments(w *responseWriter, r *request) error {
 	if err != nil {
 		return err
 	}
+	if err = core.CheckAgeRestriction(r.ctx, s.db, post.CommunityAgeRestricted, r.viewer); err != nil {
+		return err
+	}
+	if err = core.CheckCommunityAccess(r.ctx, s.db, post.CommunityID, post.CommunityPrivate, r.viewer); err != nil {
+		return err
+	}
 
 	query := r.urlQueryParams()
 
+	maxDepth, err := s.commentRenderDepth(r)
+	if err != nil {
+		return err
+	}
+
+	// The rest of a thread cut off by the depth limit.
+	if token := query.Get("continue"); token != "" {
+		comments, conts, err := post.GetCommentSubtree(r.ctx, s.db, r.viewer, token, maxDepth)
+		if err != nil {
+			return err
+		}
+		return w.writeJSON(struct {
+			Comments      []*core.Comment             `json:"comments"`
+			Continuations []*core.CommentContinuation `json:"continuations,omitempty"`
+		}{comments, conts})
+	}
+
 	// Reply comments.
 	parentIDText := query.Get("parentId")
 	if parentIDText != "" {
@@ -56,17 +74,35 @@ func (s *Server) getPostComments(w *responseWriter, r *request) error {
 		return err
 	}
 
+	comments, conts := core.LimitCommentDepth(post.Comments, 0, maxDepth)
 	res := struct {
-		Comments []*core.Comment `json:"comments"`
-		Next     msql.NullString `json:"next"`
+		Comments      []*core.Comment             `json:"comments"`
+		Next          msql.NullString             `json:"next"`
+		Continuations []*core.CommentContinuation `json:"continuations,omitempty"`
 	}{
-		Comments: post.Comments,
-		Next:     post.CommentsNext,
+		Comments:      comments,
+		Next:          post.CommentsNext,
+		Continuations: conts,
 	}
 
 	return w.writeJSON(res)
 }
 
+// commentRenderDepth returns the number of levels of comment trees to return
+// for the request (0 for no limit), which is the maxDepth URL query parameter
+// capped to the configured limit.
+func (s *Server) commentRenderDepth(r *request) (int, error) {
+	limit := s.config.MaxCommentRenderDepth
+	depth, err := r.urlQueryParamsValueInt("maxDepth", limit)
+	if err != nil || depth < 0 {
+		return 0, httperr.NewBadRequest("invalid_max_depth", "Invalid maxDepth.")
+	}
+	if limit > 0 && (depth == 0 || depth > limit) {
+		depth = limit
+	}
+	return depth, nil
+}
+
 // /api/:commentID [GET]
 func (s *Server) getComment(w *responseWriter, r *request) error {
 	commentID, err := strToID(r.muxVar("commentID"))
@@ -88,13 +124,6 @@ func (s *Server) addComment(w *responseWriter, r *request) error {
 		return errNotLoggedIn
 	}
 
-	if err := s.rateLimit(r, "add_comment_1_"+r.viewer.String(), time.Second*5, 2); err != nil {
-		return err
-	}
-	if err := s.rateLimit(r, "add_comment_2_"+r.viewer.String(), time.Hour*24, 300); err != nil {
-		return err
-	}
-
 	req := struct {
 		ParentCommentID uid.NullID `json:"parentCommentId"`
 		Body            string     `json:"body"`
@@ -103,6 +132,10 @@ func (s *Server) addComment(w *responseWriter, r *request) error {
 		return err
 	}
 
+	if err := s.checkConsent(r); err != nil {
+		return err
+	}
+
 	postID := r.muxVar("postID")
 	post, err := core.GetPost(r.ctx, s.db, nil, postID, nil, true)
 	if err != nil {
@@ -121,39 +154,17 @@ func (s *Server) addComment(w *responseWriter, r *request) error {
 		parentID = &req.ParentCommentID.ID
 	}
 
-	comment, err := post.AddComment(r.ctx, s.db, *r.viewer, as, parentID, req.Body)
+	comment, err := core.Comments().Create(r.ctx, s.db, &core.NewComment{
+		Post:           post,
+		Author:         *r.viewer,
+		Parent:         parentID,
+		Body:           req.Body,
+		UserGroup:      as,
+		SkipRateLimits: s.rateLimitsSkipped(r),
+	})
 	if err != nil {
 		return err
 	}
-
-	// +1 your own comment.
-	comment.Vote(r.ctx, s.db, *r.viewer, true)
-
-	// Trigger bot response only if the author is not a bot
-	go func() {
-		// Create a new context for the bot check
-		botCheckCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
-		// Check if the author is a bot
-		isBot, err := core.IsUserBot(botCheckCtx, s.db, *r.viewer)
-		if err != nil {
-			log.Printf("Error checking if user is bot: %v", err)
-			return
-		}
-		if isBot {
-			return // Skip bot response if author is a bot
-		}
-
-		// Create a new context for the bot response
-		botCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
-		if err := core.BotRespondToComment(botCtx, s.db, post, comment); err != nil {
-			log.Printf("Error generating bot response to comment: %v", err)
-		}
-	}()
-
 	return w.writeJSON(comment)
 }
 
@@ -249,6 +260,9 @@ func (s *Server) commentVote(w *responseWriter, r *request) error {
 	if err := s.rateLimitVoting(r, *r.viewer); err != nil {
 		return err
 	}
+	if err := s.checkConsent(r); err != nil {
+		return err
+	}
 
 	req := struct {
 		CommentID uid.ID `json:"commentId"`
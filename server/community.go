@@ -3,7 +3,6 @@ package server
 import (
 	"context"
 	"database/sql"
-	"io"
 	"log"
 	"net/http"
 	"strconv"
@@ -12,6 +11,7 @@ import (
 
 	"github.com/discuitnet/discuit/core"
 	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/images"
 	msql "github.com/discuitnet/discuit/internal/sql"
 	"github.com/discuitnet/discuit/internal/uid"
 	"github.com/gorilla/mux"
@@ -86,11 +86,11 @@ func (s *Server) getCommunities(w *responseWriter, r *request) error {
 	var err error
 
 	if search != "" { // Search communities.
-		comms, err = core.GetCommunitiesPrefix(r.ctx, s.db, search)
+		comms, err = core.GetCommunitiesPrefix(r.ctx, s.db, search, r.viewer)
 	} else {
 		switch set {
 		case core.CommunitiesSetAll, core.CommunitiesSetDefault:
-			comms, err = core.GetCommunities(r.ctx, s.db, sort, set, limit, nil)
+			comms, err = core.GetCommunities(r.ctx, s.db, sort, set, limit, r.viewer)
 		case core.CommunitiesSetSubscribed:
 			if !r.loggedIn {
 				return errNotLoggedIn
@@ -143,6 +143,12 @@ func (s *Server) getCommunity(w *responseWriter, r *request) error {
 	if err != nil {
 		return err
 	}
+	if err = core.CheckAgeRestriction(r.ctx, s.db, comm.AgeRestricted, r.viewer); err != nil {
+		return err
+	}
+	if err = core.CheckCommunityAccess(r.ctx, s.db, comm.ID, comm.Private, r.viewer); err != nil {
+		return err
+	}
 
 	if err = comm.PopulateMods(r.ctx, s.db); err != nil {
 		return err
@@ -197,8 +203,11 @@ func (s *Server) updateCommunity(w *responseWriter, r *request) error {
 		return err
 	}
 	comm.NSFW = rcomm.NSFW
+	comm.AgeRestricted = rcomm.AgeRestricted
 	comm.About = rcomm.About
 	comm.PostingRestricted = rcomm.PostingRestricted
+	comm.PostCooldown = rcomm.PostCooldown
+	comm.CommentCooldown = rcomm.CommentCooldown
 
 	if err = comm.Update(r.ctx, s.db, *r.viewer); err != nil {
 		return err
@@ -221,8 +230,9 @@ func (s *Server) joinCommunity(w *responseWriter, r *request) error {
 	}
 
 	req := struct {
-		CommunityID uid.ID `json:"communityId"`
-		Leave       bool   `json:"leave"`
+		CommunityID           uid.ID `json:"communityId"`
+		Leave                 bool   `json:"leave"`
+		AcknowledgeQuarantine bool   `json:"acknowledgeQuarantine"`
 	}{}
 	if err := r.unmarshalJSONBody(&req); err != nil {
 		log.Printf("Error unmarshaling join request: %v, request body: %v", err, r.req.Body)
@@ -248,6 +258,11 @@ func (s *Server) joinCommunity(w *responseWriter, r *request) error {
 	if req.Leave {
 		err = community.Leave(r.ctx, s.db, user.ID)
 	} else {
+		if req.AcknowledgeQuarantine {
+			if err = community.AcknowledgeQuarantine(r.ctx, s.db, user.ID); err != nil {
+				return err
+			}
+		}
 		err = community.Join(r.ctx, s.db, user.ID)
 	}
 	if err != nil {
@@ -641,6 +656,56 @@ func (s *Server) deleteReport(w *responseWriter, r *request) error {
 	return w.writeJSON(report)
 }
 
+// /api/communities/{communityID}/archive [POST, DELETE]
+func (s *Server) handleCommunityArchive(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	cid, err := strToID(r.muxVar("communityID"))
+	if err != nil {
+		return err
+	}
+
+	comm, err := core.GetCommunityByID(r.ctx, s.db, cid, r.viewer)
+	if err != nil {
+		return err
+	}
+
+	if r.req.Method == "POST" {
+		err = comm.Archive(r.ctx, s.db, *r.viewer)
+	} else {
+		err = comm.Unarchive(r.ctx, s.db, *r.viewer)
+	}
+	if err != nil {
+		return err
+	}
+
+	return w.writeJSON(comm)
+}
+
+// /api/communities/{communityID}/quarantine_ack [POST]
+func (s *Server) acknowledgeCommunityQuarantine(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	cid, err := strToID(r.muxVar("communityID"))
+	if err != nil {
+		return err
+	}
+
+	comm, err := core.GetCommunityByID(r.ctx, s.db, cid, r.viewer)
+	if err != nil {
+		return err
+	}
+	if err = comm.AcknowledgeQuarantine(r.ctx, s.db, *r.viewer); err != nil {
+		return err
+	}
+
+	return w.writeJSON(comm)
+}
+
 // /api/communities/{communityID}/banned [GET, POST, DELETE]
 func (s *Server) handleCommunityBanned(w *responseWriter, r *request) error {
 	if !r.loggedIn {
@@ -727,7 +792,7 @@ func (s *Server) handleCommunityBanned(w *responseWriter, r *request) error {
 	return httperr.NewBadRequest("", "Unsupported HTTP method.")
 }
 
-// /api/communities/{communityID}/pro_pic [POST, DELETE]
+// /api/communities/{communityID}/pro_pic [POST, PUT, DELETE]
 func (s *Server) handleCommunityProPic(w *responseWriter, r *request) error {
 	if !r.loggedIn {
 		return errNotLoggedIn
@@ -751,22 +816,27 @@ func (s *Server) handleCommunityProPic(w *responseWriter, r *request) error {
 	}
 
 	if r.req.Method == "POST" {
-		r.req.Body = http.MaxBytesReader(w, r.req.Body, int64(s.config.MaxImageSize)) // limit max upload size
-		if err := r.req.ParseMultipartForm(int64(s.config.MaxImageSize)); err != nil {
-			return httperr.NewBadRequest("file_size_exceeded", "Max file size exceeded.")
-		}
-
-		file, _, err := r.req.FormFile("image")
+		file, err := r.formFile(w, "image", s.liveConfig().MaxImageSize, s.config.MaxMultipartMemory)
 		if err != nil {
 			return err
 		}
 		defer file.Close()
-
-		buf, err := io.ReadAll(file)
+		crop, err := images.ParseCropRect(r.req.FormValue("crop"))
 		if err != nil {
 			return err
 		}
-		if err = comm.UpdateProPic(r.ctx, s.db, buf, s.config.S3Enabled); err != nil {
+
+		if err = comm.UpdateProPic(r.ctx, s.db, file, crop, s.config.S3Enabled); err != nil {
+			return err
+		}
+	} else if r.req.Method == "PUT" {
+		var body struct {
+			Crop images.CropRect `json:"crop"`
+		}
+		if err := r.unmarshalJSONBody(&body); err != nil {
+			return err
+		}
+		if err := comm.RecropProPic(r.ctx, s.db, body.Crop); err != nil {
 			return err
 		}
 	} else if r.req.Method == "DELETE" {
@@ -778,7 +848,7 @@ func (s *Server) handleCommunityProPic(w *responseWriter, r *request) error {
 	return w.writeJSON(comm)
 }
 
-// /api/communities/{communityID}/banner_image [POST, DELETE]
+// /api/communities/{communityID}/banner_image [POST, PUT, DELETE]
 func (s *Server) handleCommunityBannerImage(w *responseWriter, r *request) error {
 	if !r.loggedIn {
 		return errNotLoggedIn
@@ -802,22 +872,27 @@ func (s *Server) handleCommunityBannerImage(w *responseWriter, r *request) error
 	}
 
 	if r.req.Method == "POST" {
-		r.req.Body = http.MaxBytesReader(w, r.req.Body, int64(s.config.MaxImageSize)) // limit max upload size
-		if err := r.req.ParseMultipartForm(int64(s.config.MaxImageSize)); err != nil {
-			return httperr.NewBadRequest("file_size_exceeded", "Max file size exceeded.")
-		}
-
-		file, _, err := r.req.FormFile("image")
+		file, err := r.formFile(w, "image", s.liveConfig().MaxImageSize, s.config.MaxMultipartMemory)
 		if err != nil {
 			return err
 		}
 		defer file.Close()
-
-		buf, err := io.ReadAll(file)
+		crop, err := images.ParseCropRect(r.req.FormValue("crop"))
 		if err != nil {
 			return err
 		}
-		if err = comm.UpdateBannerImage(r.ctx, s.db, buf, s.config.S3Enabled); err != nil {
+
+		if err = comm.UpdateBannerImage(r.ctx, s.db, file, crop, s.config.S3Enabled); err != nil {
+			return err
+		}
+	} else if r.req.Method == "PUT" {
+		var body struct {
+			Crop images.CropRect `json:"crop"`
+		}
+		if err := r.unmarshalJSONBody(&body); err != nil {
+			return err
+		}
+		if err := comm.RecropBannerImage(r.ctx, s.db, body.Crop); err != nil {
 			return err
 		}
 	} else if r.req.Method == "DELETE" {
@@ -896,3 +971,139 @@ func (s *Server) deleteCommunityRequest(w *responseWriter, r *request) error {
 	w.WriteHeader(http.StatusOK)
 	return nil
 }
+
+// /api/communities/{communityID}/bot_settings [GET, PUT]
+func (s *Server) handleCommunityBotSettings(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	cid, err := strToID(r.muxVar("communityID"))
+	if err != nil {
+		return err
+	}
+
+	var settings *core.CommunityBotSettings
+	if r.req.Method == "PUT" {
+		body := &core.CommunityBotSettings{}
+		if err := r.unmarshalJSONBody(body); err != nil {
+			return err
+		}
+		if settings, err = core.UpdateCommunityBotSettings(r.ctx, s.db, cid, *r.viewer, body); err != nil {
+			return err
+		}
+	} else {
+		if is, err := core.UserModOrAdmin(r.ctx, s.db, cid, *r.viewer); err != nil {
+			return err
+		} else if !is {
+			return errNotAdminNorMod
+		}
+		if settings, err = core.GetCommunityBotSettings(r.ctx, s.db, cid); err != nil {
+			return err
+		}
+	}
+
+	return w.writeJSON(struct {
+		*core.CommunityBotSettings
+		AllTrollingStyles []string `json:"allTrollingStyles"`
+	}{settings, core.BotTrollingStyles()})
+}
+
+// /api/communities/{communityID}/bot_actions [GET]
+//
+// Returns the actions of bots in the community, newest first, or with the
+// dryRun=true query parameter, only those of dry runs. Pages are fetched with
+// the next query parameter.
+func (s *Server) getCommunityBotActions(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	cid, err := strToID(r.muxVar("communityID"))
+	if err != nil {
+		return err
+	}
+	if is, err := core.UserModOrAdmin(r.ctx, s.db, cid, *r.viewer); err != nil {
+		return err
+	} else if !is {
+		return errNotAdminNorMod
+	}
+
+	var nextPtr *string
+	if next := r.urlQueryParamsValue("next"); next != "" {
+		nextPtr = &next
+	}
+
+	actions, nextNext, err := core.GetBotActions(r.ctx, s.db, cid, r.urlQueryParamsValue("dryRun") == "true", 50, nextPtr)
+	if err != nil {
+		return err
+	}
+
+	res := struct {
+		Actions []*core.BotAction `json:"actions"`
+		Next    *string           `json:"next"`
+	}{actions, nextNext}
+
+	return w.writeJSON(res)
+}
+
+// /api/communities/{communityID}/freezes [GET, POST]
+//
+// GET returns the freezes of the community that have not ended, or with the
+// all=true query parameter, all of them.
+func (s *Server) handleCommunityFreezes(w *responseWriter, r *request) error {
+	admin, err := getLoggedInAdmin(s.db, r)
+	if err != nil {
+		return err
+	}
+	cid, err := strToID(r.muxVar("communityID"))
+	if err != nil {
+		return err
+	}
+
+	if r.req.Method == "POST" {
+		var body struct {
+			StartsAt time.Time `json:"startsAt"` // If zero, now.
+			EndsAt   time.Time `json:"endsAt"`
+			Reason   string    `json:"reason"`
+		}
+		if err := r.unmarshalJSONBody(&body); err != nil {
+			return err
+		}
+		freeze, err := core.FreezeCommunity(r.ctx, s.db, admin.ID, cid, body.StartsAt, body.EndsAt, body.Reason)
+		if err != nil {
+			return err
+		}
+		return w.writeJSON(freeze)
+	}
+
+	freezes, err := core.GetCommunityFreezes(r.ctx, s.db, cid, r.urlQueryParamsValue("all") == "true")
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(freezes)
+}
+
+// /api/communities/{communityID}/freezes/{freezeID} [DELETE]
+//
+// Lifts the freeze (or cancels it, if it has not started).
+func (s *Server) liftCommunityFreeze(w *responseWriter, r *request) error {
+	admin, err := getLoggedInAdmin(s.db, r)
+	if err != nil {
+		return err
+	}
+	cid, err := strToID(r.muxVar("communityID"))
+	if err != nil {
+		return err
+	}
+	id, err := strToID(r.muxVar("freezeID"))
+	if err != nil {
+		return err
+	}
+
+	if err := core.LiftCommunityFreeze(r.ctx, s.db, admin.ID, cid, id); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/discuitnet/discuit/internal/httputil"
+)
+
+// Responses are compressed with zstd or gzip, as negotiated with clients,
+// unless they're short, of content types that don't compress (like images),
+// or of routes that opt out.
+
+// defaultCompressionMinSize is the size below which responses are not
+// compressed, unless configured otherwise. Shorter responses fit in a packet
+// or two anyway.
+const defaultCompressionMinSize = 1024
+
+// uncompressedRoutes are the built-in routes (keyed like routeLatencyBudgets)
+// whose responses are not compressed.
+var uncompressedRoutes = []string{
+	"GET /images/", // Images are compressed already.
+}
+
+// compression holds the settings of the compression of responses.
+type compression struct {
+	minSize int // If -1, responses are not compressed.
+	skip    map[string]bool
+}
+
+// newCompression returns the compression settings of responses. If minSize is
+// not 0, it replaces defaultCompressionMinSize (-1 disables compression).
+// Routes are the routes that opt out, on top of uncompressedRoutes.
+func newCompression(minSize int, routes []string) *compression {
+	c := &compression{
+		minSize: defaultCompressionMinSize,
+		skip:    make(map[string]bool),
+	}
+	if minSize != 0 {
+		c.minSize = minSize
+	}
+	for _, route := range uncompressedRoutes {
+		c.skip[route] = true
+	}
+	for _, route := range routes {
+		c.skip[route] = true
+	}
+	return c
+}
+
+// withCompression is a mux middleware that compresses the responses of the
+// matched route (see httputil.CompressHandler).
+func (s *Server) withCompression(next http.Handler) http.Handler {
+	if s.compression.minSize < 0 {
+		return next
+	}
+	return httputil.CompressHandler(next, httputil.CompressOptions{
+		MinSize: s.compression.minSize,
+		Skip: func(r *http.Request) bool {
+			return s.compression.skip[routeKey(r)]
+		},
+	})
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/core/sitesettings"
+)
+
+// checkConsent returns an error if consent is required (see the
+// ConsentRequired site setting) and the logged in user has not consented.
+func (s *Server) checkConsent(r *request) error {
+	settings, err := sitesettings.GetSiteSettings(r.ctx, s.db)
+	if err != nil {
+		return err
+	}
+	if !settings.ConsentRequired {
+		return nil
+	}
+	return core.CheckConsent(r.ctx, s.db, *r.viewer)
+}
+
+// /api/consent [GET, POST, DELETE]
+//
+// GET returns the latest consent form (null if there's none) and the consent
+// decision of the logged in user (null if none). POST records a decision on
+// the latest consent form, and DELETE withdraws consent.
+func (s *Server) handleConsent(w *responseWriter, r *request) error {
+	if r.req.Method != "GET" && !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	switch r.req.Method {
+	case "POST":
+		var body struct {
+			Version int  `json:"version"`
+			Consent bool `json:"consent"`
+		}
+		if err := r.unmarshalJSONBody(&body); err != nil {
+			return err
+		}
+		consent, err := core.RecordConsent(r.ctx, s.db, *r.viewer, body.Version, body.Consent)
+		if err != nil {
+			return err
+		}
+		return w.writeJSON(consent)
+	case "DELETE":
+		consent, err := core.WithdrawConsent(r.ctx, s.db, *r.viewer)
+		if err != nil {
+			return err
+		}
+		return w.writeJSON(consent)
+	}
+
+	res := struct {
+		Form    *core.ConsentForm `json:"form"`
+		Consent *core.UserConsent `json:"consent"`
+		Current bool              `json:"current"` // Whether the user consented to the form.
+	}{}
+	var err error
+	if res.Form, err = core.GetLatestConsentForm(r.ctx, s.db); err != nil {
+		return err
+	}
+	if r.loggedIn {
+		if res.Consent, err = core.GetUserConsent(r.ctx, s.db, *r.viewer); err != nil {
+			return err
+		}
+		res.Current = res.Form != nil && res.Consent.Valid(res.Form.Version)
+	}
+	return w.writeJSON(res)
+}
+
+// /api/consent/forms [GET, POST]
+func (s *Server) handleConsentForms(w *responseWriter, r *request) error {
+	admin, err := getLoggedInAdmin(s.db, r)
+	if err != nil {
+		return err
+	}
+
+	if r.req.Method == "POST" {
+		var body struct {
+			Title string `json:"title"`
+			Body  string `json:"body"`
+		}
+		if err := r.unmarshalJSONBody(&body); err != nil {
+			return err
+		}
+		form, err := core.CreateConsentForm(r.ctx, s.db, admin.ID, body.Title, body.Body)
+		if err != nil {
+			return err
+		}
+		return w.writeJSON(form)
+	}
+
+	forms, err := core.GetConsentForms(r.ctx, s.db)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(forms)
+}
+
+// /api/consent/records [GET]
+//
+// With the format=csv query parameter, the consent statuses are returned as a
+// CSV file. See pseudonymizeView for the pseudonymize and scope query
+// parameters.
+func (s *Server) getConsentRecords(w *responseWriter, r *request) error {
+	if _, err := getLoggedInAdmin(s.db, r); err != nil {
+		return err
+	}
+
+	statuses, err := core.GetConsentStatuses(r.ctx, s.db)
+	if err != nil {
+		return err
+	}
+	if err := s.pseudonymizeView(r, statuses); err != nil {
+		return err
+	}
+	if r.urlQueryParamsValue("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="consent.csv"`)
+		return core.WriteConsentStatusesCSV(w, statuses)
+	}
+	return w.writeJSON(statuses)
+}
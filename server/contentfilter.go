@@ -0,0 +1,86 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// filterListCommunity returns the community whose content filter list the
+// request is for, or nil if it's for the site-level list. It returns an error
+// if the viewer may not see the list.
+func (s *Server) filterListCommunity(r *request) (*uid.ID, error) {
+	if !r.loggedIn {
+		return nil, errNotLoggedIn
+	}
+	if r.muxVar("communityID") == "" {
+		if _, err := getLoggedInAdmin(s.db, r); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	cid, err := strToID(r.muxVar("communityID"))
+	if err != nil {
+		return nil, err
+	}
+	if is, err := core.UserModOrAdmin(r.ctx, s.db, cid, *r.viewer); err != nil {
+		return nil, err
+	} else if !is {
+		return nil, errNotAdminNorMod
+	}
+	return &cid, nil
+}
+
+// /api/content_filter [GET, POST]
+// /api/communities/{communityID}/content_filter [GET, POST]
+//
+// A POST request adds a word to the list (or changes its action). Its body is
+// of the form {"word": "...", "action": "block|remove|mask|allow"}.
+func (s *Server) handleContentFilter(w *responseWriter, r *request) error {
+	community, err := s.filterListCommunity(r)
+	if err != nil {
+		return err
+	}
+
+	if r.req.Method == "POST" {
+		var body struct {
+			Word   string            `json:"word"`
+			Action core.FilterAction `json:"action"`
+		}
+		if err := r.unmarshalJSONBody(&body); err != nil {
+			return err
+		}
+		word, err := core.AddFilterWord(r.ctx, s.db, *r.viewer, community, body.Word, body.Action)
+		if err != nil {
+			return err
+		}
+		return w.writeJSON(word)
+	}
+
+	words, err := core.GetFilterWords(r.ctx, s.db, community)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(words)
+}
+
+// /api/content_filter/{wordID} [DELETE]
+// /api/communities/{communityID}/content_filter/{wordID} [DELETE]
+func (s *Server) deleteContentFilterWord(w *responseWriter, r *request) error {
+	community, err := s.filterListCommunity(r)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.Atoi(r.muxVar("wordID"))
+	if err != nil {
+		return httperr.NewNotFound("filter_word_not_found", "Filter word not found.")
+	}
+	if err := core.RemoveFilterWord(r.ctx, s.db, *r.viewer, community, id); err != nil {
+		return err
+	}
+	return w.writeString(`{"success":true}`)
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/discuitnet/discuit/config"
+	"github.com/discuitnet/discuit/internal/httputil"
+	"github.com/gorilla/mux"
+)
+
+// The CORS policy of the API distinguishes two groups of requests. Public
+// reads (GET requests of all routes, except those configured to be private)
+// are allowed from any of the public origins (all origins, by default), but
+// without credentials, so they're served as to logged out users. Everything
+// else (writes, and the reads of private routes) is allowed only from trusted
+// origins (none, by default), and with cookies only if so configured.
+
+// newCORSHandler returns the handler of API requests, which applies the CORS
+// policy of conf to the requests to s.router.
+func (s *Server) newCORSHandler(conf *config.Config) http.Handler {
+	private := make(map[string]bool)
+	for _, route := range conf.CORSPrivateRoutes {
+		private[route] = true
+	}
+	maxAge, _ := time.ParseDuration(conf.CORSMaxAge)
+	return httputil.CORSHandler(s.router, httputil.CORSOptions{
+		PublicOrigins: conf.CORSPublicOriginList(),
+		Origins:       conf.CORSOriginList(),
+		Methods:       conf.CORSMethodList(),
+		Credentials:   conf.CORSCredentials,
+		AllowHeaders:  []string{"Content-Type", "Authorization", "X-Api-Key", "X-Csrf-Token", requestIDHeader},
+		ExposeHeaders: []string{"Csrf-Token", requestIDHeader},
+		MaxAge:        maxAge,
+		Public: func(r *http.Request, method string) bool {
+			// The route is not matched yet (and for preflight requests, it's
+			// that of method, not of OPTIONS).
+			path := r.URL.Path
+			req := r.Clone(r.Context())
+			req.Method = http.MethodGet
+			var match mux.RouteMatch
+			if s.router.Match(req, &match) && match.Route != nil {
+				if tpl, err := match.Route.GetPathTemplate(); err == nil {
+					path = tpl
+				}
+			}
+			return !private["GET "+path]
+		},
+	})
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/httputil"
+	"github.com/discuitnet/discuit/internal/logging"
+)
+
+// sendVerificationEmail sends user an email to verify their email address, if
+// emails are enabled, in the background (so that slow mail servers don't
+// hold up the request).
+func (s *Server) sendVerificationEmail(user *core.User) {
+	if !core.EmailEnabled() || !user.Email.Valid || user.Email.String == "" {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := core.SendVerificationEmail(ctx, s.db, user); err != nil {
+			logging.FromContext(ctx).Error("Error sending verification email", "user", user.ID, "error", err)
+		}
+	}()
+}
+
+// /api/_email/verify [POST]
+func (s *Server) verifyEmail(w *responseWriter, r *request) error {
+	if err := s.rateLimit(r, "verify_email_1_"+httputil.GetIP(r.req), time.Hour, 20); err != nil {
+		return err
+	}
+	values, err := r.unmarshalJSONBodyToStringsMap(true)
+	if err != nil {
+		return err
+	}
+	if _, err := core.VerifyEmail(r.ctx, s.db, values["token"]); err != nil {
+		return err
+	}
+	return w.writeString(`{"success":true}`)
+}
+
+// /api/_email/verification [POST]
+func (s *Server) resendVerificationEmail(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	if err := s.rateLimit(r, "verification_email_1_"+r.viewer.String(), time.Hour, 3); err != nil {
+		return err
+	}
+	user, err := core.GetUser(r.ctx, s.db, *r.viewer, r.viewer)
+	if err != nil {
+		return err
+	}
+	if err := core.SendVerificationEmail(r.ctx, s.db, user); err != nil {
+		return err
+	}
+	return w.writeString(`{"success":true}`)
+}
+
+// /api/_password_reset [POST]
+func (s *Server) requestPasswordReset(w *responseWriter, r *request) error {
+	if r.loggedIn {
+		return httperr.NewBadRequest("already_logged_in", "You are already logged in")
+	}
+	if s.config.AuthBackend != "" {
+		return errExternalAuth
+	}
+	values, err := r.unmarshalJSONBodyToStringsMap(true)
+	if err != nil {
+		return err
+	}
+	address := strings.ToLower(values["email"])
+	if err := s.rateLimit(r, "password_reset_1_"+httputil.GetIP(r.req), time.Hour, 10); err != nil {
+		return err
+	}
+	if err := s.rateLimit(r, "password_reset_2_"+address, time.Hour, 3); err != nil {
+		return err
+	}
+	if err := core.RequestPasswordReset(r.ctx, s.db, values["email"]); err != nil {
+		return err
+	}
+	return w.writeString(`{"success":true}`)
+}
+
+// /api/_password_reset/confirm [POST]
+func (s *Server) resetPassword(w *responseWriter, r *request) error {
+	if s.config.AuthBackend != "" {
+		return errExternalAuth
+	}
+	if err := s.rateLimit(r, "password_reset_confirm_1_"+httputil.GetIP(r.req), time.Hour, 20); err != nil {
+		return err
+	}
+	values, err := r.unmarshalJSONBodyToStringsMap(true)
+	if err != nil {
+		return err
+	}
+	if values["password"] != values["repeatPassword"] {
+		return httperr.NewBadRequest("password_not_match", "Passwords do not match.")
+	}
+	if _, err := core.ResetPassword(r.ctx, s.db, values["token"], values["password"]); err != nil {
+		return err
+	}
+	return w.writeString(`{"success":true}`)
+}
+
+// /api/_email/preferences [GET, PUT]
+func (s *Server) handleEmailPreferences(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	if r.req.Method == "PUT" {
+		if err := s.rateLimit(r, "email_preferences_1_"+r.viewer.String(), time.Hour, 50); err != nil {
+			return err
+		}
+		prefs := &core.EmailPreferences{}
+		if err := r.unmarshalJSONBody(prefs); err != nil {
+			return err
+		}
+		if err := core.UpdateEmailPreferences(r.ctx, s.db, *r.viewer, prefs); err != nil {
+			return err
+		}
+	}
+	prefs, err := core.GetEmailPreferences(r.ctx, s.db, *r.viewer)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(prefs)
+}
+
+// /api/_email/unsubscribe [POST]
+//
+// The token is in the query of the URL, as mail clients post to the URL in
+// the List-Unsubscribe header of emails as it is (RFC 8058). It's not wrapped
+// with withHandler because those requests have no CSRF token (nor any need
+// of one, as the token is proof enough).
+func (s *Server) unsubscribeFromEmails(w http.ResponseWriter, r *http.Request) {
+	if err := core.UnsubscribeFromEmails(r.Context(), s.db, r.URL.Query().Get("token")); err != nil {
+		s.writeHandlerError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Write([]byte(`{"success":true}`))
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// /api/experiments [GET, POST]
+func (s *Server) handleExperiments(w *responseWriter, r *request) error {
+	admin, err := getLoggedInAdmin(s.db, r)
+	if err != nil {
+		return err
+	}
+
+	if r.req.Method == "POST" {
+		var body struct {
+			Name     string                `json:"name"`
+			CohortID *uid.ID               `json:"cohortId"`
+			Arms     []*core.ExperimentArm `json:"arms"`
+		}
+		if err := r.unmarshalJSONBody(&body); err != nil {
+			return err
+		}
+		experiment, err := core.CreateExperiment(r.ctx, s.db, admin.ID, body.Name, body.CohortID, body.Arms)
+		if err != nil {
+			return err
+		}
+		return w.writeJSON(experiment)
+	}
+
+	experiments, err := core.GetExperiments(r.ctx, s.db)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(experiments)
+}
+
+// /api/experiments/{experimentID} [GET]
+func (s *Server) getExperiment(w *responseWriter, r *request) error {
+	if _, err := getLoggedInAdmin(s.db, r); err != nil {
+		return err
+	}
+	id, err := uid.FromString(r.muxVar("experimentID"))
+	if err != nil {
+		return httperr.NewBadRequest("invalid_id", "Invalid experiment ID.")
+	}
+	experiment, err := core.GetExperiment(r.ctx, s.db, id)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(experiment)
+}
+
+// /api/experiments/{experimentID}/phases [POST]
+//
+// Adds a phase to the experiment. The communities of freezeCommunities are
+// frozen for the duration of the phase.
+func (s *Server) addExperimentPhase(w *responseWriter, r *request) error {
+	admin, err := getLoggedInAdmin(s.db, r)
+	if err != nil {
+		return err
+	}
+	id, err := uid.FromString(r.muxVar("experimentID"))
+	if err != nil {
+		return httperr.NewBadRequest("invalid_id", "Invalid experiment ID.")
+	}
+	experiment, err := core.GetExperiment(r.ctx, s.db, id)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Name              string    `json:"name"`
+		StartsAt          time.Time `json:"startsAt"`
+		EndsAt            time.Time `json:"endsAt"`
+		FreezeCommunities []uid.ID  `json:"freezeCommunities"`
+	}
+	if err := r.unmarshalJSONBody(&body); err != nil {
+		return err
+	}
+	phase, err := experiment.AddPhase(r.ctx, s.db, admin.ID, body.Name, body.StartsAt, body.EndsAt, body.FreezeCommunities)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(phase)
+}
+
+// /api/experiments/{experimentID}/arms/{armID} [PUT]
+//
+// Sets the treatments (feedSort and botExposure) of the arm.
+func (s *Server) updateExperimentArm(w *responseWriter, r *request) error {
+	admin, err := getLoggedInAdmin(s.db, r)
+	if err != nil {
+		return err
+	}
+	id, err := uid.FromString(r.muxVar("experimentID"))
+	if err != nil {
+		return httperr.NewBadRequest("invalid_id", "Invalid experiment ID.")
+	}
+	armID, err := uid.FromString(r.muxVar("armID"))
+	if err != nil {
+		return httperr.NewBadRequest("invalid_arm_id", "Invalid experiment arm ID.")
+	}
+	experiment, err := core.GetExperiment(r.ctx, s.db, id)
+	if err != nil {
+		return err
+	}
+
+	to := &core.ExperimentArm{}
+	if err := r.unmarshalJSONBody(to); err != nil {
+		return err
+	}
+	if err := experiment.SetArmTreatments(r.ctx, s.db, admin.ID, armID, to); err != nil {
+		return err
+	}
+	return w.writeJSON(experiment.Arm(armID))
+}
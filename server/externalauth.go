@@ -0,0 +1,216 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/config"
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/ldap"
+	"github.com/discuitnet/discuit/internal/saml"
+	"github.com/gomodule/redigo/redis"
+)
+
+var (
+	errExternalAuth       = httperr.NewForbidden("external_auth", "Log in with your organization's account.")
+	errSAMLNotEnabled     = httperr.NewNotFound("saml_not_enabled", "SAML login is not enabled.")
+	errSAMLInvalidRequest = httperr.NewForbidden("saml_invalid_response", "The login response of the identity provider is invalid or has expired.")
+)
+
+// initExternalAuth sets up the external authentication backend of
+// conf.AuthBackend, if any.
+func (s *Server) initExternalAuth(conf *config.Config) error {
+	switch conf.AuthBackend {
+	case "ldap":
+		s.ldap = &ldap.Config{
+			URL:           conf.LDAPURL,
+			BindDN:        conf.LDAPBindDN,
+			BindPassword:  conf.LDAPBindPassword,
+			BaseDN:        conf.LDAPBaseDN,
+			UserAttribute: conf.LDAPUserAttribute,
+			TLSConfig:     &tls.Config{InsecureSkipVerify: conf.LDAPInsecureSkipVerify},
+		}
+		for _, attr := range []string{conf.AuthUsernameAttribute, conf.AuthEmailAttribute, conf.AuthAboutAttribute} {
+			if attr != "" {
+				s.ldap.Attributes = append(s.ldap.Attributes, attr)
+			}
+		}
+	case "saml":
+		data, err := os.ReadFile(conf.SAMLIdPMetadataFile)
+		if err != nil {
+			return fmt.Errorf("failed to read SAML IdP metadata: %w", err)
+		}
+		idp, err := saml.ParseMetadata(data)
+		if err != nil {
+			return err
+		}
+		base := strings.TrimSuffix(conf.SAMLBaseURL, "/")
+		s.samlSP = &saml.ServiceProvider{
+			EntityID: conf.SAMLEntityID,
+			ACSURL:   base + "/api/_saml/acs",
+			IdP:      idp,
+		}
+		if s.samlSP.EntityID == "" {
+			s.samlSP.EntityID = base + "/api/_saml/metadata"
+		}
+	}
+	return nil
+}
+
+// authenticate returns the user with the login credentials username and
+// password.
+func (s *Server) authenticate(ctx context.Context, username, password string) (*core.User, error) {
+	if s.ldap != nil {
+		user, err := s.loginLDAP(ctx, username, password)
+		if !errors.Is(err, ldap.ErrInvalidCredentials) {
+			return user, err
+		}
+		if !s.config.AuthLocalLogin {
+			return nil, core.ErrWrongPassword
+		}
+	} else if s.config.AuthBackend != "" && !s.config.AuthLocalLogin {
+		return nil, errExternalAuth
+	}
+	return core.MatchLoginCredentials(ctx, s.db, username, password)
+}
+
+// loginLDAP returns the user linked to the directory entry of username,
+// creating one if there's none.
+func (s *Server) loginLDAP(ctx context.Context, username, password string) (*core.User, error) {
+	entry, err := s.ldap.Authenticate(ctx, username, password)
+	if err != nil {
+		return nil, err
+	}
+	id := &core.ExternalIdentity{
+		Provider: core.ExternalProviderLDAP,
+		Subject:  strings.ToLower(entry.DN),
+		Username: username,
+	}
+	if attr := s.config.AuthUsernameAttribute; attr != "" && entry.Get(attr) != "" {
+		id.Username = entry.Get(attr)
+	}
+	if attr := s.config.AuthEmailAttribute; attr != "" {
+		id.Email = entry.Get(attr)
+	}
+	if attr := s.config.AuthAboutAttribute; attr != "" {
+		id.About = entry.Get(attr)
+	}
+	return core.LoginExternalIdentity(ctx, s.db, id, true)
+}
+
+// localPath returns path if it's a path on this site, and "/" otherwise (so
+// that redirects to it cannot lead to other sites).
+func localPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/"
+	}
+	return path
+}
+
+// samlRequestRedisKey returns the Redis key where the AuthnRequest with id is
+// stored until it's answered.
+func samlRequestRedisKey(id string) string {
+	return "saml_request:" + id
+}
+
+// samlRequestTTL is how long users have to log in at the identity provider.
+const samlRequestTTL = 10 * time.Minute
+
+// /api/_saml/login [GET]
+func (s *Server) samlLogin(w *responseWriter, r *request) error {
+	if s.samlSP == nil {
+		return errSAMLNotEnabled
+	}
+	u, id, err := s.samlSP.AuthnRequestURL(localPath(r.urlQueryParamsValue("redirect")))
+	if err != nil {
+		return err
+	}
+
+	conn := s.redisPool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("SET", samlRequestRedisKey(id), "1", "EX", int(samlRequestTTL/time.Second)); err != nil {
+		return err
+	}
+
+	w.Header().Set("Location", u)
+	w.WriteHeader(http.StatusSeeOther)
+	return nil
+}
+
+// /api/_saml/metadata [GET]
+func (s *Server) samlMetadata(w *responseWriter, r *request) error {
+	if s.samlSP == nil {
+		return errSAMLNotEnabled
+	}
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	_, err := w.Write(s.samlSP.Metadata())
+	return err
+}
+
+// /api/_saml/acs [POST]
+//
+// The assertion consumer service. It's not wrapped with withHandler because
+// responses are posted by the browser from the identity provider's site,
+// without a CSRF token.
+func (s *Server) samlACS(w http.ResponseWriter, r *http.Request) {
+	if err := s.serveSAMLACS(w, r); err != nil {
+		s.writeHandlerError(w, r, err)
+	}
+}
+
+func (s *Server) serveSAMLACS(w http.ResponseWriter, r *http.Request) error {
+	if s.samlSP == nil {
+		return errSAMLNotEnabled
+	}
+	assertion, err := s.samlSP.ParseResponse(r.PostFormValue("SAMLResponse"), time.Now())
+	if err != nil {
+		if errors.Is(err, saml.ErrInvalidResponse) {
+			log.Printf("Rejected SAML response: %v\n", err)
+			return errSAMLInvalidRequest
+		}
+		return err
+	}
+
+	// Each request is answered only once, so that responses cannot be
+	// replayed.
+	conn := s.redisPool.Get()
+	n, err := redis.Int(conn.Do("DEL", samlRequestRedisKey(assertion.InResponseTo)))
+	conn.Close()
+	if err != nil {
+		return err
+	}
+	if assertion.InResponseTo == "" || n == 0 {
+		return errSAMLInvalidRequest
+	}
+
+	id := &core.ExternalIdentity{
+		Provider: core.ExternalProviderSAML,
+		Subject:  assertion.NameID,
+		Username: assertion.Attribute(s.config.AuthUsernameAttribute),
+		Email:    assertion.Attribute(s.config.AuthEmailAttribute),
+		About:    assertion.Attribute(s.config.AuthAboutAttribute),
+	}
+	user, err := core.LoginExternalIdentity(r.Context(), s.db, id, true)
+	if err != nil {
+		return err
+	}
+
+	ses, err := s.sessions.Get(r)
+	if err != nil {
+		return err
+	}
+	if err := s.loginUser(user, ses, w, r); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, localPath(r.PostFormValue("RelayState")), http.StatusSeeOther)
+	return nil
+}
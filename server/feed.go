@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/discuitnet/discuit/core"
 	"github.com/discuitnet/discuit/internal/httperr"
@@ -127,17 +128,17 @@ func (s *Server) feed(w *responseWriter, r *request) error {
 			cid = &c
 		}
 		if cid != nil {
-			homeFeed = false
-		}
-		set, err = core.GetFeed(r.ctx, s.db, &core.FeedOptions{
-			Sort:        sort,
-			DefaultSort: sort == s.config.DefaultFeedSort,
-			Viewer:      r.viewer,
-			Community:   cid,
-			Homefeed:    homeFeed,
-			Limit:       limit,
-			Next:        nextText,
-		})
+			set, err = s.communityFeed(r, *cid, sort, limit, nextText)
+		} else {
+			set, err = core.GetFeed(r.ctx, s.db, &core.FeedOptions{
+				Sort:        sort,
+				DefaultSort: sort == s.config.DefaultFeedSort,
+				Viewer:      r.viewer,
+				Homefeed:    homeFeed,
+				Limit:       limit,
+				Next:        nextText,
+			})
+		}
 		if err != nil {
 			return err
 		}
@@ -196,3 +197,48 @@ func (s *Server) feed(w *responseWriter, r *request) error {
 
 	return w.writeJSON(set)
 }
+
+// communityFeed returns a page of the feed of the community with id. The
+// period of the posts can be set with the since and until query parameters
+// (dates or RFC 3339 timestamps), and mods and admins can see deleted posts
+// as well by setting includeDeleted to true.
+func (s *Server) communityFeed(r *request, id uid.ID, sort core.FeedSort, limit int, next string) (*core.FeedResultSet, error) {
+	query := r.urlQueryParams()
+	opts := &core.CommunityPostsOptions{
+		Sort:        sort,
+		DefaultSort: sort == s.config.DefaultFeedSort,
+		Viewer:      r.viewer,
+		PinnedFirst: sort == s.config.DefaultFeedSort,
+		Limit:       limit,
+		Next:        next,
+	}
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+		end  bool
+	}{{"since", &opts.Since, false}, {"until", &opts.Until, true}} {
+		if v := query.Get(p.name); v != "" {
+			t, err := parseRangeTime(v, p.end)
+			if err != nil {
+				return nil, httperr.NewBadRequest("invalid_"+p.name, "Invalid "+p.name+" timestamp.")
+			}
+			*p.t = t
+		}
+	}
+	if query.Get("includeDeleted") == "true" {
+		if !r.loggedIn {
+			return nil, errNotLoggedIn
+		}
+		comm, err := core.GetCommunityByID(r.ctx, s.db, id, r.viewer)
+		if err != nil {
+			return nil, err
+		}
+		if ok, err := userModOrAdmin(r.ctx, s.db, *r.viewer, comm); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, errNotAdminNorMod
+		}
+		opts.IncludeDeleted = true
+	}
+	return core.GetCommunityPosts(r.ctx, s.db, id, opts)
+}
@@ -3,6 +3,8 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -36,6 +38,14 @@ func (rw *responseWriter) WriteHeader(statusCode int) {
 	rw.w.WriteHeader(statusCode)
 }
 
+// Flush sends any buffered data to the client, if the underlying
+// http.ResponseWriter supports it.
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func (rw *responseWriter) writeJSON(v any) error {
 	return json.NewEncoder(rw).Encode(v)
 }
@@ -161,6 +171,29 @@ func (r *request) urlQueryParamsValueInt(key string, defaultValue int) (int, err
 	return strconv.Atoi(valueString)
 }
 
+// formFile returns the file uploaded as the form field key of a multipart
+// request, which must be no larger than maxSize bytes. Up to maxMemory bytes
+// of the form are kept in memory; the rest is spooled to temporary files
+// (which are removed once the request is served).
+func (r *request) formFile(w *responseWriter, key string, maxSize, maxMemory int) (multipart.File, error) {
+	r.req.Body = http.MaxBytesReader(w, r.req.Body, int64(maxSize)) // limit max upload size
+	if err := r.req.ParseMultipartForm(int64(maxMemory)); err != nil {
+		if isBodyTooLarge(err) || errors.Is(err, multipart.ErrMessageTooLarge) {
+			return nil, &httperr.Error{
+				HTTPStatus: http.StatusRequestEntityTooLarge,
+				Code:       "file_size_exceeded",
+				Message:    "Max file size exceeded.",
+			}
+		}
+		return nil, httperr.NewBadRequest("invalid_form", "Invalid multipart form.")
+	}
+	file, _, err := r.req.FormFile(key)
+	if err != nil {
+		return nil, httperr.NewBadRequest("no_file", "No file uploaded.")
+	}
+	return file, nil
+}
+
 // The error returned from handler is used to handle http error cases (non-1xx
 // and non-2xx http responses) in conjunction with httperr.Error. The caller of
 // handler should check the error and write the appropriate error message, with
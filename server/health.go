@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/images"
+)
+
+// readyzTimeout is the time limit of the checks of readyz.
+const readyzTimeout = 5 * time.Second
+
+// readyz reports whether the site can serve requests: whether the database,
+// Redis, and the image stores (see images.HealthChecker) are reachable. It
+// responds with 503 Service Unavailable if any of them is not, and logs why.
+//
+// /readyz [GET]
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyzTimeout)
+	defer cancel()
+
+	checks := []struct {
+		name  string
+		check func(context.Context) error
+	}{
+		{"database", s.db.PingContext},
+		{"redis", func(ctx context.Context) error {
+			conn, err := s.redisPool.GetContext(ctx)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			_, err = conn.Do("PING")
+			return err
+		}},
+		{"images", func(ctx context.Context) error {
+			return images.CheckStoreHealth(ctx, images.GetDefaultStoreName(s.config.S3Enabled))
+		}},
+	}
+
+	res := struct {
+		Ready  bool              `json:"ready"`
+		Checks map[string]string `json:"checks"`
+	}{Ready: true, Checks: make(map[string]string)}
+	for _, c := range checks {
+		if err := c.check(ctx); err != nil {
+			log.Printf("Readiness check %s failed: %v\n", c.name, err)
+			res.Ready = false
+			res.Checks[c.name] = "failing"
+		} else {
+			res.Checks[c.name] = "ok"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if !res.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(res)
+}
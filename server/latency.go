@@ -0,0 +1,282 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/gorilla/mux"
+)
+
+// Every API request has a latency budget: a time limit after which its
+// context is canceled and a 503 is returned to the client (whatever the
+// handler eventually writes is discarded). This keeps one slow dependency
+// from tying up requests indefinitely.
+
+// defaultLatencyBudget is the budget of routes that are neither in
+// routeLatencyBudgets nor configured otherwise.
+const defaultLatencyBudget = time.Second * 10
+
+// routeLatencyBudgets are the built-in budgets of routes that need more (or
+// less) time than defaultLatencyBudget. The keys are of the form "METHOD
+// /path/template", with the path template as registered in New.
+var routeLatencyBudgets = map[string]time.Duration{
+	"POST /api/_uploads":                               time.Minute,
+	"POST /api/users/{username}/pro_pic":               time.Minute,
+	"POST /api/communities/{communityID}/pro_pic":      time.Minute,
+	"POST /api/communities/{communityID}/banner_image": time.Minute,
+	"POST /api/posts":                                  time.Second * 30, // Fetches link previews.
+	"GET /api/_link_info":                              time.Second * 20,
+	"GET /api/activity/stream":                         0, // Streams events until the client leaves.
+}
+
+var errLatencyBudgetExceeded = &httperr.Error{
+	HTTPStatus: http.StatusServiceUnavailable,
+	Code:       "latency_budget_exceeded",
+	Message:    "The server took too long to respond. Please try again.",
+}
+
+// latencyBudgets holds the latency budgets of routes and counts the requests
+// that exceeded them.
+type latencyBudgets struct {
+	defaultBudget time.Duration // If 0, routes without a budget have no limit.
+	budgets       map[string]time.Duration
+
+	mu       sync.Mutex
+	timeouts map[string]*routeTimeouts
+}
+
+// routeTimeouts are the timeout metrics of a route.
+type routeTimeouts struct {
+	Route    string    `json:"route"`
+	Budget   string    `json:"budget"`
+	Count    int       `json:"count"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// newLatencyBudgets returns the latency budgets of routes, which are those of
+// routeLatencyBudgets overridden by overrides (route to duration string). If
+// defaultBudget is not empty, it replaces defaultLatencyBudget ("0" disables
+// the default limit).
+func newLatencyBudgets(defaultBudget string, overrides map[string]string) (*latencyBudgets, error) {
+	lb := &latencyBudgets{
+		defaultBudget: defaultLatencyBudget,
+		budgets:       make(map[string]time.Duration),
+		timeouts:      make(map[string]*routeTimeouts),
+	}
+	if defaultBudget != "" {
+		d, err := time.ParseDuration(defaultBudget)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default latency budget: %w", err)
+		}
+		lb.defaultBudget = d
+	}
+	for route, d := range routeLatencyBudgets {
+		lb.budgets[route] = d
+	}
+	for route, s := range overrides {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid latency budget of route %s: %w", route, err)
+		}
+		lb.budgets[route] = d
+	}
+	return lb, nil
+}
+
+// routeKey returns the key of the route that r matched, in the format of the
+// keys of routeLatencyBudgets.
+func routeKey(r *http.Request) string {
+	path := r.URL.Path
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			path = tpl
+		}
+	}
+	return r.Method + " " + path
+}
+
+// budget returns the latency budget of route, or 0 if route has no limit.
+func (lb *latencyBudgets) budget(route string) time.Duration {
+	if d, ok := lb.budgets[route]; ok {
+		return d
+	}
+	return lb.defaultBudget
+}
+
+func (lb *latencyBudgets) recordTimeout(route string, budget time.Duration) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	t, ok := lb.timeouts[route]
+	if !ok {
+		t = &routeTimeouts{Route: route}
+		lb.timeouts[route] = t
+	}
+	t.Budget = budget.String()
+	t.Count++
+	t.LastSeen = time.Now()
+}
+
+// Timeouts returns the timeout metrics of all routes that have timed out at
+// least once, most timeouts first.
+func (lb *latencyBudgets) Timeouts() []routeTimeouts {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	list := make([]routeTimeouts, 0, len(lb.timeouts))
+	for _, t := range lb.timeouts {
+		list = append(list, *t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Route < list[j].Route
+	})
+	return list
+}
+
+// withLatencyBudget is a mux middleware that enforces the latency budget of
+// the matched route.
+func (s *Server) withLatencyBudget(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeKey(r)
+		budget := s.latencyBudgets.budget(route)
+		if budget <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), budget)
+		defer cancel()
+
+		tw := &timeoutWriter{w: w, header: make(http.Header)}
+		done := make(chan struct{})
+		panicChan := make(chan any, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicChan <- p
+				}
+			}()
+			next.ServeHTTP(tw, r.WithContext(ctx))
+			close(done)
+		}()
+
+		select {
+		case p := <-panicChan:
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				// The handler returned, but likely with an error caused by
+				// the canceled context.
+				s.latencyBudgets.recordTimeout(route, budget)
+			}
+			tw.writeBuffered()
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.timedOut = true
+			s.latencyBudgets.recordTimeout(route, budget)
+			log.Printf("Latency budget (%v) exceeded: %s\n", budget, route)
+			if !tw.flushed {
+				s.writeError(w, r, errLatencyBudgetExceeded)
+			}
+		}
+	})
+}
+
+// timeoutWriter buffers the response of a handler, so that it can be
+// discarded if the handler doesn't finish in time, until the handler flushes
+// it, after which it's written to w as it's written (and, if the handler
+// doesn't finish in time, cut short).
+type timeoutWriter struct {
+	w        http.ResponseWriter
+	mu       sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	code     int
+	timedOut bool
+	flushed  bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	if tw.flushed {
+		return tw.w.Write(p)
+	}
+	return tw.buf.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.code != 0 {
+		return
+	}
+	tw.code = code
+}
+
+// Flush writes the response so far to w and flushes it, if w supports it.
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.writeBuffered()
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying http.ResponseWriter (for
+// http.ResponseController).
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.w
+}
+
+// writeBuffered writes the header, if it's not written yet, and the buffered
+// body to w. tw.mu must be held.
+func (tw *timeoutWriter) writeBuffered() {
+	if !tw.flushed {
+		dst := tw.w.Header()
+		for k, v := range tw.header {
+			dst[k] = v
+		}
+		if tw.code == 0 {
+			tw.code = http.StatusOK
+		}
+		tw.w.WriteHeader(tw.code)
+		tw.flushed = true
+	}
+	tw.w.Write(tw.buf.Bytes())
+	tw.buf.Reset()
+}
+
+// /api/analytics/timeouts [GET]
+func (s *Server) getLatencyTimeouts(w *responseWriter, r *request) error {
+	if _, err := getLoggedInAdmin(s.db, r); err != nil {
+		return err
+	}
+	return w.writeJSON(s.latencyBudgets.Timeouts())
+}
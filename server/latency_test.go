@@ -0,0 +1,111 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newLatencyTestServer(t *testing.T, budget string) *Server {
+	lb, err := newLatencyBudgets("0", map[string]string{"GET /test": budget})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Server{latencyBudgets: lb}
+}
+
+func TestLatencyBudgetExceeded(t *testing.T) {
+	s := newLatencyTestServer(t, "20ms")
+	release := make(chan struct{})
+	lateWrite := make(chan error, 1)
+	h := s.withLatencyBudget(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", "1")
+		<-r.Context().Done()
+		<-release
+		_, err := w.Write([]byte("too late"))
+		lateWrite <- err
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/test", nil))
+	close(release)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if !strings.Contains(w.Body.String(), errLatencyBudgetExceeded.Code) {
+		t.Errorf("body %q is not the latency budget error", w.Body.String())
+	}
+	if w.Header().Get("X-Handler") != "" {
+		t.Error("the headers of the timed out handler were written")
+	}
+	if err := <-lateWrite; err != http.ErrHandlerTimeout {
+		t.Errorf("write after the timeout returned %v, want %v", err, http.ErrHandlerTimeout)
+	}
+	if strings.Contains(w.Body.String(), "too late") {
+		t.Error("write after the timeout was not discarded")
+	}
+
+	timeouts := s.latencyBudgets.Timeouts()
+	if len(timeouts) != 1 || timeouts[0].Route != "GET /test" || timeouts[0].Count != 1 {
+		t.Errorf("unexpected timeouts: %+v", timeouts)
+	}
+}
+
+func TestLatencyBudgetMet(t *testing.T) {
+	s := newLatencyTestServer(t, "1m")
+	h := s.withLatencyBudget(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", "1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("done"))
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/test", nil))
+	if w.Code != http.StatusCreated || w.Body.String() != "done" || w.Header().Get("X-Handler") != "1" {
+		t.Errorf("got status %d, body %q, and headers %v", w.Code, w.Body.String(), w.Header())
+	}
+	if timeouts := s.latencyBudgets.Timeouts(); len(timeouts) != 0 {
+		t.Errorf("unexpected timeouts: %+v", timeouts)
+	}
+}
+
+func TestLatencyBudgetFlush(t *testing.T) {
+	s := newLatencyTestServer(t, "1m")
+	w := httptest.NewRecorder()
+	flushed, resume := make(chan struct{}), make(chan struct{})
+	h := s.withLatencyBudget(http.HandlerFunc(func(tw http.ResponseWriter, r *http.Request) {
+		if u, ok := tw.(interface{ Unwrap() http.ResponseWriter }); !ok || u.Unwrap() != w {
+			t.Error("Unwrap does not return the underlying writer")
+		}
+		tw.Write([]byte("a"))
+		if err := http.NewResponseController(tw).Flush(); err != nil {
+			t.Errorf("Flush: %v", err)
+		}
+		close(flushed)
+		<-resume
+		tw.Write([]byte("b"))
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/test", nil))
+		close(done)
+	}()
+
+	select {
+	case <-flushed:
+	case <-time.After(time.Second * 5):
+		t.Fatal("handler did not flush")
+	}
+	if !w.Flushed || w.Body.String() != "a" || w.Code != http.StatusOK {
+		t.Errorf("after Flush: flushed %v, status %d, body %q", w.Flushed, w.Code, w.Body.String())
+	}
+	close(resume)
+	<-done
+	if w.Body.String() != "ab" {
+		t.Errorf("body %q, want %q", w.Body.String(), "ab")
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/discuitnet/discuit/internal/logging"
+)
+
+// requestIDHeader is the header of the ID of a request (see
+// logging.RequestID), in both requests and responses.
+const requestIDHeader = "X-Request-Id"
+
+// validRequestID matches the request IDs that are taken from the requests
+// themselves (as set, say, by a reverse proxy).
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// withRequestID returns r with a request ID, and with a logger with that ID,
+// in its context (see logging.WithRequestID). The ID is that of the request
+// header, if there's a valid one, and a new one otherwise. It's set as a
+// response header too.
+func withRequestID(w http.ResponseWriter, r *http.Request) (*http.Request, string) {
+	id := r.Header.Get(requestIDHeader)
+	if !validRequestID.MatchString(id) {
+		id = logging.NewRequestID()
+	}
+	w.Header().Set(requestIDHeader, id)
+	return r.WithContext(logging.WithRequestID(r.Context(), id)), id
+}
+
+// secretHeaders are the request headers whose values are not logged.
+var secretHeaders = []string{"Authorization", "Cookie", "X-Csrf-Token", "X-Api-Key"}
+
+// redactedHeader returns a copy of h with the values of secretHeaders
+// redacted.
+func redactedHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, name := range secretHeaders {
+		if _, ok := h[name]; ok {
+			h[name] = []string{logging.Redacted}
+		}
+	}
+	return h
+}
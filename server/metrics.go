@@ -0,0 +1,96 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/metrics"
+	"github.com/gorilla/mux"
+)
+
+var (
+	httpRequests = metrics.NewCounter("discuit_http_requests_total",
+		"HTTP requests served, by route (the path template of the route matched) and status code.",
+		"method", "route", "code")
+	httpRequestDurations = metrics.NewHistogram("discuit_http_request_duration_seconds",
+		"Durations of HTTP requests, by route.",
+		nil, "method", "route")
+)
+
+// withMetrics records the request, its status code, and its duration (see
+// package metrics). It's a middleware of both the API router and the static
+// router.
+func (s *Server) withMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		route := "unknown" // Not the path, lest every URL be a series of its own.
+		if cr := mux.CurrentRoute(r); cr != nil {
+			if tpl, err := cr.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		if sw.code == 0 {
+			sw.code = http.StatusOK
+		}
+		httpRequests.Inc(r.Method, route, strconv.Itoa(sw.code))
+		httpRequestDurations.ObserveSince(start, r.Method, route)
+	})
+}
+
+// statusWriter is an http.ResponseWriter that keeps the status code written.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if sw.code == 0 {
+		sw.code = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.code == 0 {
+		sw.code = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying http.ResponseWriter (for
+// http.ResponseController).
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// serveMetrics serves the metrics of the site to requests that have the
+// metrics token (see config.Config.MetricsToken). If there's no token, it
+// responds with a 404.
+//
+// /metrics [GET]
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	token := s.liveConfig().MetricsToken
+	if token == "" {
+		http.NotFound(w, r)
+		return
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	metrics.Handler().ServeHTTP(w, r)
+}
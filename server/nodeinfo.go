@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/core/sitesettings"
+)
+
+// The instance is described to fediverse and self-hosting directories by the
+// NodeInfo 2.1 protocol (see https://nodeinfo.diaspora.software). Usage counts
+// come from the hourly basic site stats (see core.RecordBasicSiteStats), and
+// are zero until the stats are first recorded.
+
+const nodeInfoSchema = "http://nodeinfo.diaspora.software/ns/schema/2.1"
+
+// softwareVersion returns the version of the running binary: the version of
+// the main module if it's known, and otherwise the VCS revision it was built
+// from.
+func softwareVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && setting.Value != "" {
+			return setting.Value[:min(len(setting.Value), 12)]
+		}
+	}
+	return "unknown"
+}
+
+// requestBaseURL returns the scheme and host r was made to.
+func requestBaseURL(r *http.Request) string {
+	scheme := "https"
+	if r.TLS == nil && r.Header.Get("X-Forwarded-Proto") != "https" {
+		scheme = "http"
+	}
+	return scheme + "://" + r.Host
+}
+
+// /.well-known/nodeinfo [GET]
+func (s *Server) serveNodeInfoLinks(w http.ResponseWriter, r *http.Request) {
+	s.writeNodeInfoJSON(w, r, "application/json", map[string]any{
+		"links": []map[string]string{
+			{"rel": nodeInfoSchema, "href": requestBaseURL(r) + "/nodeinfo/2.1"},
+		},
+	})
+}
+
+// /nodeinfo/2.1 [GET]
+func (s *Server) serveNodeInfo(w http.ResponseWriter, r *http.Request) {
+	stats, err := core.GetLatestBasicSiteStats(r.Context(), s.db)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	if stats == nil {
+		stats = &core.BasicSiteStats{}
+	}
+	settings, err := sitesettings.GetSiteSettings(r.Context(), s.db)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	type users struct {
+		Total          int `json:"total"`
+		ActiveMonth    int `json:"activeMonth"`
+		ActiveHalfyear int `json:"activeHalfyear"`
+	}
+	info := struct {
+		Version  string `json:"version"`
+		Software struct {
+			Name       string `json:"name"`
+			Version    string `json:"version"`
+			Repository string `json:"repository"`
+			Homepage   string `json:"homepage"`
+		} `json:"software"`
+		Protocols []string `json:"protocols"`
+		Services  struct {
+			Inbound  []string `json:"inbound"`
+			Outbound []string `json:"outbound"`
+		} `json:"services"`
+		OpenRegistrations bool `json:"openRegistrations"`
+		Usage             struct {
+			Users         users `json:"users"`
+			LocalPosts    int   `json:"localPosts"`
+			LocalComments int   `json:"localComments"`
+		} `json:"usage"`
+		Metadata map[string]any `json:"metadata"`
+	}{
+		Version:           "2.1",
+		Protocols:         []string{},
+		OpenRegistrations: !settings.SignupsDisabled && !settings.SignupsRosterOnly,
+		Metadata: map[string]any{
+			"nodeName":        s.config.SiteName,
+			"nodeDescription": s.config.SiteDescription,
+		},
+	}
+	info.Software.Name = "discuit"
+	info.Software.Version = softwareVersion()
+	info.Software.Repository = "https://github.com/discuitnet/discuit"
+	info.Software.Homepage = "https://discuit.org"
+	info.Services.Inbound, info.Services.Outbound = []string{}, []string{}
+	info.Usage.Users = users{
+		Total:          stats.TotalSignups,
+		ActiveMonth:    stats.UsersLastMonth,
+		ActiveHalfyear: stats.UsersLastHalfYear,
+	}
+	info.Usage.LocalPosts = stats.TotalPosts
+	info.Usage.LocalComments = stats.TotalComments
+
+	s.writeNodeInfoJSON(w, r, `application/json; profile="`+nodeInfoSchema+`#"`, info)
+}
+
+func (s *Server) writeNodeInfoJSON(w http.ResponseWriter, r *http.Request, contentType string, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "public, max-age=1800")
+	w.Write(b)
+}
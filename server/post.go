@@ -1,15 +1,12 @@
 package server
 
 import (
-	"context"
-	"io"
-	"log"
-	"net/http"
 	"strings"
 	"time"
 
 	"github.com/discuitnet/discuit/core"
 	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/images"
 	"github.com/discuitnet/discuit/internal/uid"
 )
 
@@ -19,13 +16,6 @@ func (s *Server) addPost(w *responseWriter, r *request) error {
 		return errNotLoggedIn
 	}
 
-	if err := s.rateLimit(r, "add_post_1_"+r.viewer.String(), time.Second*10, 1); err != nil {
-		return err
-	}
-	if err := s.rateLimit(r, "add_post_2_"+r.viewer.String(), time.Hour*24, 70); err != nil {
-		return err
-	}
-
 	req := struct {
 		PostType  core.PostType       `json:"type"`
 		Title     string              `json:"title"`
@@ -43,9 +33,8 @@ func (s *Server) addPost(w *responseWriter, r *request) error {
 		return err
 	}
 
-	// Disallow image post creation if image posts are disabled in config.
-	if s.config.DisableImagePosts && req.PostType == core.PostTypeImage {
-		return httperr.NewForbidden("no_image_posts", "Image posts are not allowed")
+	if err := s.checkConsent(r); err != nil {
+		return err
 	}
 
 	comm, err := core.GetCommunityByName(r.ctx, s.db, req.Community, nil)
@@ -53,15 +42,13 @@ func (s *Server) addPost(w *responseWriter, r *request) error {
 		return err
 	}
 
-	var post *core.Post
+	var content core.PostContent
 	switch req.PostType {
 	case core.PostTypeText:
-		post, err = core.CreateTextPost(r.ctx, s.db, *r.viewer, comm.ID, req.Title, req.Body)
+		content = &core.TextContent{Body: req.Body}
 	case core.PostTypeImage:
-		var images []*core.ImageUpload
-		if req.Images != nil {
-			images = req.Images
-		} else {
+		images := req.Images
+		if images == nil {
 			imageID, idErr := uid.FromString(req.ImageId)
 			if idErr != nil {
 				return httperr.NewBadRequest("invalid_image_id", "Invalid image ID.")
@@ -70,53 +57,25 @@ func (s *Server) addPost(w *responseWriter, r *request) error {
 				{ImageID: imageID},
 			}
 		}
-		if len(images) > s.config.MaxImagesPerPost {
-			return httperr.NewBadRequest("too-many-images", "Maximum images count exceeded.")
-		}
-		post, err = core.CreateImagePost(r.ctx, s.db, *r.viewer, comm.ID, req.Title, images)
+		content = &core.ImageContent{Images: images}
 	case core.PostTypeLink:
-		post, err = core.CreateLinkPost(r.ctx, s.db, *r.viewer, comm.ID, req.Title, req.URL)
+		content = &core.LinkContent{URL: req.URL}
 	default:
 		return httperr.NewBadRequest("invalid_post_type", "Invalid post type.")
 	}
+
+	post, err := core.Posts().Create(r.ctx, s.db, &core.NewPost{
+		Author:         *r.viewer,
+		Community:      comm.ID,
+		Title:          req.Title,
+		Content:        content,
+		UserGroup:      req.UserGroup,
+		SkipRateLimits: s.rateLimitsSkipped(r),
+	})
 	if err != nil {
 		return err
 	}
 
-	if req.UserGroup != core.UserGroupNormal {
-		if err := post.ChangeUserGroup(r.ctx, s.db, *r.viewer, req.UserGroup); err != nil {
-			return err
-		}
-	}
-
-	// +1 your own post.
-	post.Vote(r.ctx, s.db, *r.viewer, true)
-
-	// Trigger bot response only if the author is not a bot
-	go func() {
-		// Create a new context for the bot check
-		botCheckCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
-		// Check if the author is a bot
-		isBot, err := core.IsUserBot(botCheckCtx, s.db, *r.viewer)
-		if err != nil {
-			log.Printf("Error checking if user is bot: %v", err)
-			return
-		}
-		if isBot {
-			return // Skip bot response if author is a bot
-		}
-
-		// Create a new context for the bot response
-		botCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
-		if err := core.BotRespondToPost(botCtx, s.db, post, comm); err != nil {
-			log.Printf("Error generating bot response to post: %v", err)
-		}
-	}()
-
 	return w.writeJSON(post)
 }
 
@@ -127,6 +86,12 @@ func (s *Server) getPost(w *responseWriter, r *request) error {
 	if err != nil {
 		return err
 	}
+	if err = core.CheckAgeRestriction(r.ctx, s.db, post.CommunityAgeRestricted, r.viewer); err != nil {
+		return err
+	}
+	if err = core.CheckCommunityAccess(r.ctx, s.db, post.CommunityID, post.CommunityPrivate, r.viewer); err != nil {
+		return err
+	}
 
 	if _, err = post.GetComments(r.ctx, s.db, r.viewer, nil); err != nil {
 		return err
@@ -270,6 +235,27 @@ func (s *Server) deletePost(w *responseWriter, r *request) error {
 	return w.writeJSON(post)
 }
 
+// /api/posts/:postID/restore [POST]
+//
+// Restores a deleted post (and its content, if it's still in the trash).
+func (s *Server) restorePost(w *responseWriter, r *request) error {
+	admin, err := getLoggedInAdmin(s.db, r)
+	if err != nil {
+		return err
+	}
+	post, err := core.GetPost(r.ctx, s.db, nil, r.muxVar("postID"), &admin.ID, true)
+	if err != nil {
+		return err
+	}
+	if err := post.Restore(r.ctx, s.db, admin.ID); err != nil {
+		return err
+	}
+	if post, err = core.GetPost(r.ctx, s.db, nil, post.PublicID, &admin.ID, true); err != nil {
+		return err
+	}
+	return w.writeJSON(post)
+}
+
 // /api/_postVote [ POST ]
 func (s *Server) postVote(w *responseWriter, r *request) error {
 	if !r.loggedIn {
@@ -279,6 +265,9 @@ func (s *Server) postVote(w *responseWriter, r *request) error {
 	if err := s.rateLimitVoting(r, *r.viewer); err != nil {
 		return err
 	}
+	if err := s.checkConsent(r); err != nil {
+		return err
+	}
 
 	req := struct {
 		PostID uid.ID `json:"postId"`
@@ -325,26 +314,35 @@ func (s *Server) imageUpload(w *responseWriter, r *request) error {
 		return err
 	}
 
-	r.req.Body = http.MaxBytesReader(w, r.req.Body, int64(s.config.MaxImageSize)) // limit max upload size
-	if err := r.req.ParseMultipartForm(int64(s.config.MaxImageSize)); err != nil {
-		return httperr.NewBadRequest("file_size_exceeded", "Max file size exceeded.")
-	}
-
-	file, _, err := r.req.FormFile("image")
+	file, err := r.formFile(w, "image", s.liveConfig().MaxImageSize, s.config.MaxMultipartMemory)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
-
-	fileData, err := io.ReadAll(file)
+	crop, err := images.ParseCropRect(r.req.FormValue("crop"))
 	if err != nil {
 		return err
 	}
 
-	image, err := core.SavePostImage(r.ctx, s.db, *r.viewer, fileData, s.config.S3Enabled)
+	image, err := core.SavePostImage(r.ctx, s.db, *r.viewer, file, crop, r.req.FormValue("altText"), s.config.S3Enabled)
 	if err != nil {
 		return err
 	}
 
-	return w.writeJSON(image.Image())
+	// If the image is to be posted in a community, warn of (or reject) reposts.
+	res := struct {
+		*images.Image
+		Duplicates []*core.DuplicateImage `json:"duplicates,omitempty"`
+	}{Image: image.Image()}
+	if communityID := r.req.FormValue("communityId"); communityID != "" {
+		community, err := uid.FromString(communityID)
+		if err != nil {
+			return httperr.NewBadRequest("invalid_community_id", "Invalid community ID.")
+		}
+		if res.Duplicates, err = core.CheckDuplicateImages(r.ctx, s.db, community, image.ID); err != nil {
+			return err
+		}
+	}
+
+	return w.writeJSON(res)
 }
@@ -0,0 +1,19 @@
+package server
+
+import (
+	"github.com/discuitnet/discuit/core"
+)
+
+// pseudonymizeView pseudonymizes view if the pseudonymize query parameter of r
+// is true, with the pseudonyms of the scope query parameter (or of the default
+// scope).
+func (s *Server) pseudonymizeView(r *request, view core.ResearchView) error {
+	if r.urlQueryParamsValue("pseudonymize") != "true" {
+		return nil
+	}
+	scope := r.urlQueryParamsValue("scope")
+	if scope == "" {
+		scope = core.DefaultPseudonymScope
+	}
+	return core.Pseudonymize(r.ctx, s.db, scope, view)
+}
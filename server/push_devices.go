@@ -0,0 +1,96 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/discuitnet/discuit/config"
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/mobilepush"
+)
+
+// enableMobilePushNotifications registers the FCM and APNs senders that are
+// configured in conf.
+func enableMobilePushNotifications(conf *config.Config) error {
+	if conf.FCMCredentialsFile != "" {
+		fcm, err := mobilepush.NewFCM(conf.FCMCredentialsFile, conf.FCMProjectID)
+		if err != nil {
+			return fmt.Errorf("error creating fcm sender: %w", err)
+		}
+		core.EnableMobilePushNotifications(core.PushPlatformFCM, fcm)
+	}
+	if conf.APNsKeyFile != "" {
+		apns, err := mobilepush.NewAPNs(conf.APNsKeyFile, conf.APNsKeyID, conf.APNsTeamID, conf.APNsTopic, conf.APNsProduction)
+		if err != nil {
+			return fmt.Errorf("error creating apns sender: %w", err)
+		}
+		core.EnableMobilePushNotifications(core.PushPlatformAPNs, apns)
+	}
+	return nil
+}
+
+// /api/push_devices [GET, POST]
+func (s *Server) handlePushDevices(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	if r.req.Method == "POST" {
+		if err := s.rateLimit(r, "push_devices_1_"+r.viewer.String(), time.Hour, 100); err != nil {
+			return err
+		}
+		reqBody := struct {
+			Platform core.PushPlatform `json:"platform"`
+			Token    string            `json:"token"`
+		}{}
+		if err := r.unmarshalJSONBody(&reqBody); err != nil {
+			return err
+		}
+		device, err := core.RegisterPushDevice(r.ctx, s.db, *r.viewer, reqBody.Platform, reqBody.Token)
+		if err != nil {
+			return err
+		}
+		return w.writeJSON(device)
+	}
+
+	devices, err := core.GetPushDevices(r.ctx, s.db, *r.viewer)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(devices)
+}
+
+// /api/push_devices/{deviceID} [GET, PUT, DELETE]
+func (s *Server) handlePushDevice(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	id, err := strconv.Atoi(r.muxVar("deviceID"))
+	if err != nil {
+		return httperr.NewBadRequest("invalid_id", "Invalid device ID.")
+	}
+
+	device, err := core.GetPushDevice(r.ctx, s.db, *r.viewer, id)
+	if err != nil {
+		return err
+	}
+
+	switch r.req.Method {
+	case "PUT":
+		if err := r.unmarshalJSONBody(&device.Preferences); err != nil {
+			return err
+		}
+		if err := device.UpdatePreferences(r.ctx, s.db); err != nil {
+			return err
+		}
+	case "DELETE":
+		if err := core.DeletePushDevice(r.ctx, s.db, *r.viewer, id); err != nil {
+			return err
+		}
+	}
+
+	return w.writeJSON(device)
+}
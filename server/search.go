@@ -0,0 +1,53 @@
+package server
+
+import (
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httputil"
+)
+
+// /api/search [GET] (?q=text&kind=posts&sort=relevance&community=name&author=username&limit=n&next=cursor)
+func (s *Server) search(w *responseWriter, r *request) error {
+	bucket := "search_ip_" + httputil.GetIP(r.req)
+	if r.loggedIn {
+		bucket = "search_user_" + r.viewer.String()
+	}
+	if err := s.rateLimit(r, bucket, time.Minute, 30); err != nil {
+		return err
+	}
+
+	query := r.urlQueryParams()
+	limit, err := getFeedLimit(query, s.config.PaginationLimit, s.config.PaginationLimitMax)
+	if err != nil {
+		return err
+	}
+	opts := &core.SearchOptions{
+		Query:  query.Get("q"),
+		Kind:   core.SearchKind(query.Get("kind")),
+		Sort:   core.SearchSort(query.Get("sort")),
+		Viewer: r.viewer,
+		Limit:  limit,
+		Next:   query.Get("next"),
+	}
+	if name := query.Get("community"); name != "" {
+		comm, err := core.GetCommunityByName(r.ctx, s.db, name, r.viewer)
+		if err != nil {
+			return err
+		}
+		opts.Community = &comm.ID
+	}
+	if username := query.Get("author"); username != "" {
+		user, err := core.GetUserByUsername(r.ctx, s.db, username, r.viewer)
+		if err != nil {
+			return err
+		}
+		opts.Author = &user.ID
+	}
+
+	set, err := core.Search(r.ctx, s.db, opts)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(set)
+}
@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -17,6 +18,7 @@ import (
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"crypto/tls"
@@ -26,7 +28,10 @@ import (
 	"github.com/discuitnet/discuit/internal/httperr"
 	"github.com/discuitnet/discuit/internal/httputil"
 	"github.com/discuitnet/discuit/internal/images"
+	"github.com/discuitnet/discuit/internal/ldap"
+	"github.com/discuitnet/discuit/internal/logging"
 	"github.com/discuitnet/discuit/internal/ratelimits"
+	"github.com/discuitnet/discuit/internal/saml"
 	"github.com/discuitnet/discuit/internal/sessions"
 	"github.com/discuitnet/discuit/internal/uid"
 	"github.com/discuitnet/discuit/internal/utils"
@@ -49,6 +54,10 @@ var (
 type Server struct {
 	config *config.Config
 
+	// The config as last reloaded (see SetConfig), for the settings that take
+	// effect without a restart.
+	live atomic.Pointer[config.Config]
+
 	db        *sql.DB
 	redisPool *redis.Pool
 
@@ -61,7 +70,7 @@ type Server struct {
 	sessions *sessions.RedisStore
 
 	// react serve
-	reactPath  string
+	ui         *uiFiles
 	reactIndex string
 
 	httpLogger        *log.Logger
@@ -69,7 +78,20 @@ type Server struct {
 	http500Logger     *log.Logger
 	http500LoggerFile *os.File
 
+	imageAccessLog     images.AccessLogger // Nil unless image requests are logged.
+	imageAccessLogFile *os.File
+
 	webPushVAPIDKeys core.VAPIDKeys
+
+	latencyBudgets *latencyBudgets
+	bodyLimits     *bodyLimits
+	compression    *compression
+	apiHandler     http.Handler // s.router, with the CORS policy of the API.
+	apiKeyTiers    map[string][]apiRateLimit
+
+	// External authentication (at most one is set; see config.AuthBackend).
+	ldap   *ldap.Config
+	samlSP *saml.ServiceProvider
 }
 
 func New(db *sql.DB, conf *config.Config) (*Server, error) {
@@ -127,31 +149,63 @@ func New(db *sql.DB, conf *config.Config) (*Server, error) {
 		staticRouter: mux.NewRouter(),
 		sessions:     redisStore,
 		config:       conf,
-		reactPath:    "./ui/dist/",
-		reactIndex:   "index.html",
+		reactIndex:   "/index.html",
 	}
+	s.live.Store(conf)
 
-	if keys, err := core.GetApplicationVAPIDKeys(context.Background(), db); err != nil {
-		log.Printf("Error generating vapid keys: %v (you might want to run migrations)\n", err)
-	} else {
-		if !conf.IsDevelopment {
-			s.webPushVAPIDKeys = *keys
-			core.EnablePushNotifications(keys, "discuit@previnder.com")
-		}
+	if s.ui, err = newUIFiles(); err != nil {
+		return nil, fmt.Errorf("reading embedded ui: %w", err)
+	}
+
+	if s.latencyBudgets, err = newLatencyBudgets(conf.DefaultLatencyBudget, conf.LatencyBudgets); err != nil {
+		return nil, err
+	}
+	s.bodyLimits = newBodyLimits(conf.MaxBodySize, conf.MaxImageSize, conf.BodyLimits)
+	s.compression = newCompression(conf.CompressionMinSize, conf.UncompressedRoutes)
+	s.apiHandler = s.newCORSHandler(conf)
+	if s.apiKeyTiers, err = newAPIKeyTiers(conf.APIKeyTiers); err != nil {
+		return nil, err
+	}
+
+	s.enableWebPushNotifications(conf)
+
+	if err := enableMobilePushNotifications(conf); err != nil {
+		return nil, err
+	}
+
+	if err := s.initExternalAuth(conf); err != nil {
+		return nil, err
 	}
 
 	s.openLoggers()
+	core.Posts().SetRateLimiter(rateLimiter{s})
+	core.Comments().SetRateLimiter(rateLimiter{s})
 
 	// API routes.
+	r.Use(s.withTracing)
+	r.Use(s.withMetrics)
+	r.Use(s.withCompression)
+	r.Use(s.withLatencyBudget)
+	r.Use(s.withBodyLimit)
 	r.Handle("/api/_initial", s.withHandler(s.initial)).Methods("GET")
 	r.Handle("/api/_login", s.withHandler(s.login)).Methods("POST")
 	r.Handle("/api/_signup", s.withHandler(s.signup)).Methods("POST")
+	r.Handle("/api/_password_reset", s.withHandler(s.requestPasswordReset)).Methods("POST")
+	r.Handle("/api/_password_reset/confirm", s.withHandler(s.resetPassword)).Methods("POST")
+	r.Handle("/api/_email/verify", s.withHandler(s.verifyEmail)).Methods("POST")
+	r.Handle("/api/_email/verification", s.withHandler(s.resendVerificationEmail)).Methods("POST")
+	r.Handle("/api/_email/preferences", s.withHandler(s.handleEmailPreferences)).Methods("GET", "PUT")
+	r.HandleFunc("/api/_email/unsubscribe", s.unsubscribeFromEmails).Methods("POST")
 	r.Handle("/api/_user", s.withHandler(s.getLoggedInUser)).Methods("GET")
+	r.Handle("/api/_saml/login", s.withHandler(s.samlLogin)).Methods("GET")
+	r.Handle("/api/_saml/metadata", s.withHandler(s.samlMetadata)).Methods("GET")
+	r.HandleFunc("/api/_saml/acs", s.samlACS).Methods("POST")
 
 	r.Handle("/api/users/{username}", s.withHandler(s.getUser)).Methods("GET")
 	r.Handle("/api/users/{username}", s.withHandler(s.deleteUser)).Methods("DELETE")
 	r.Handle("/api/users/{username}/feed", s.withHandler(s.getUsersFeed)).Methods("GET")
-	r.Handle("/api/users/{username}/pro_pic", s.withHandler(s.handleUserProPic)).Methods("POST", "DELETE")
+	r.Handle("/api/users/{username}/pro_pic", s.withHandler(s.handleUserProPic)).Methods("POST", "PUT", "DELETE")
+	r.Handle("/api/users/{username}/activity", s.withHandler(s.getUserActivity)).Methods("GET")
 	r.Handle("/api/users/{username}/badges", s.withHandler(s.addBadge)).Methods("POST")
 	r.Handle("/api/users/{username}/badges/{badgeId}", s.withHandler(s.deleteBadge)).Methods("DELETE")
 	r.Handle("/api/hidden_posts", s.withHandler(s.handleHiddenPosts)).Methods("POST")
@@ -170,11 +224,13 @@ func New(db *sql.DB, conf *config.Config) (*Server, error) {
 	r.Handle("/api/mutes/communities/{mutedCommunityID}", s.withHandler(s.deleteCommunityMute)).Methods("DELETE")
 	r.Handle("/api/mutes/{muteID}", s.withHandler(s.deleteMute)).Methods("DELETE")
 
+	r.Handle("/api/search", s.withHandler(s.search)).Methods("GET")
 	r.Handle("/api/posts", s.withHandler(s.feed)).Methods("GET")
 	r.Handle("/api/posts", s.withHandler(s.addPost)).Methods("POST")
 	r.Handle("/api/posts/{postID}", s.withHandler(s.getPost)).Methods("GET")
 	r.Handle("/api/posts/{postID}", s.withHandler(s.updatePost)).Methods("PUT")
 	r.Handle("/api/posts/{postID}", s.withHandler(s.deletePost)).Methods("DELETE")
+	r.Handle("/api/posts/{postID}/restore", s.withHandler(s.restorePost)).Methods("POST")
 	r.Handle("/api/_postVote", s.withHandler(s.postVote)).Methods("POST")
 	r.Handle("/api/_uploads", s.withHandler(s.imageUpload)).Methods("POST")
 
@@ -184,6 +240,7 @@ func New(db *sql.DB, conf *config.Config) (*Server, error) {
 	r.Handle("/api/posts/{postID}/comments/{commentID}", s.withHandler(s.deleteComment)).Methods("DELETE")
 	r.Handle("/api/comments/{commentID}", s.withHandler(s.getComment)).Methods("GET")
 	r.Handle("/api/_commentVote", s.withHandler(s.commentVote)).Methods("POST")
+	r.Handle("/api/votes", s.withHandler(s.handleVotes)).Methods("GET", "PUT")
 
 	r.Handle("/api/communities", s.withHandler(s.getCommunities)).Methods("GET")
 	r.Handle("/api/communities", s.withHandler(s.createCommunity)).Methods("POST")
@@ -196,6 +253,10 @@ func New(db *sql.DB, conf *config.Config) (*Server, error) {
 	r.Handle("/api/communities/{communityID}/rules/{ruleID}", s.withHandler(s.getCommunityRule)).Methods("GET")
 	r.Handle("/api/communities/{communityID}/rules/{ruleID}", s.withHandler(s.updateCommunityRule)).Methods("PUT")
 	r.Handle("/api/communities/{communityID}/rules/{ruleID}", s.withHandler(s.deleteCommunityRule)).Methods("DELETE")
+	r.Handle("/api/communities/{communityID}/bot_settings", s.withHandler(s.handleCommunityBotSettings)).Methods("GET", "PUT")
+	r.Handle("/api/communities/{communityID}/bot_actions", s.withHandler(s.getCommunityBotActions)).Methods("GET")
+	r.Handle("/api/communities/{communityID}/freezes", s.withHandler(s.handleCommunityFreezes)).Methods("GET", "POST")
+	r.Handle("/api/communities/{communityID}/freezes/{freezeID}", s.withHandler(s.liftCommunityFreeze)).Methods("DELETE")
 
 	r.Handle("/api/communities/{communityID}/mods", s.withHandler(s.getCommunityMods)).Methods("GET")
 	r.Handle("/api/communities/{communityID}/mods", s.withHandler(s.addCommunityMod)).Methods("POST")
@@ -205,16 +266,23 @@ func New(db *sql.DB, conf *config.Config) (*Server, error) {
 	r.Handle("/api/communities/{communityID}/reports/{reportID}", s.withHandler(s.deleteReport)).Methods("DELETE")
 
 	r.Handle("/api/communities/{communityID}/banned", s.withHandler(s.handleCommunityBanned)).Methods("GET", "POST", "DELETE")
+	r.Handle("/api/communities/{communityID}/quarantine_ack", s.withHandler(s.acknowledgeCommunityQuarantine)).Methods("POST")
+	r.Handle("/api/communities/{communityID}/archive", s.withHandler(s.handleCommunityArchive)).Methods("POST", "DELETE")
+	r.Handle("/api/communities/{communityID}/content_filter", s.withHandler(s.handleContentFilter)).Methods("GET", "POST")
+	r.Handle("/api/communities/{communityID}/content_filter/{wordID}", s.withHandler(s.deleteContentFilterWord)).Methods("DELETE")
 
-	r.Handle("/api/communities/{communityID}/pro_pic", s.withHandler(s.handleCommunityProPic)).Methods("POST", "DELETE")
-	r.Handle("/api/communities/{communityID}/banner_image", s.withHandler(s.handleCommunityBannerImage)).Methods("POST", "DELETE")
+	r.Handle("/api/communities/{communityID}/pro_pic", s.withHandler(s.handleCommunityProPic)).Methods("POST", "PUT", "DELETE")
+	r.Handle("/api/communities/{communityID}/banner_image", s.withHandler(s.handleCommunityBannerImage)).Methods("POST", "PUT", "DELETE")
 
 	r.Handle("/api/notifications", s.withHandler(s.getNotifications)).Methods("GET")
 	r.Handle("/api/notifications", s.withHandler(s.updateNotifications)).Methods("POST")
 	r.Handle("/api/notifications/{notificationID}", s.withHandler(s.getNotification)).Methods("GET", "PUT")
 	r.Handle("/api/notifications/{notificationID}", s.withHandler(s.deleteNotification)).Methods("DELETE")
 
-	r.Handle("/api/push_subscriptions", s.withHandler(s.pushSubscriptions)).Methods("POST")
+	r.Handle("/api/push_subscriptions", s.withHandler(s.handlePushSubscriptions)).Methods("GET", "POST")
+	r.Handle("/api/push_subscriptions/{subscriptionID}", s.withHandler(s.handlePushSubscription)).Methods("GET", "PUT", "DELETE")
+	r.Handle("/api/push_devices", s.withHandler(s.handlePushDevices)).Methods("GET", "POST")
+	r.Handle("/api/push_devices/{deviceID}", s.withHandler(s.handlePushDevice)).Methods("GET", "PUT", "DELETE")
 
 	r.Handle("/api/community_requests", s.withHandler(s.createCommunityRequest)).Methods("POST")
 	r.Handle("/api/community_requests", s.withHandler(s.getCommunityRequests)).Methods("GET")
@@ -223,8 +291,43 @@ func New(db *sql.DB, conf *config.Config) (*Server, error) {
 	r.Handle("/api/_report", s.withHandler(s.report)).Methods("POST")
 
 	r.Handle("/api/_settings", s.withHandler(s.updateUserSettings)).Methods("POST")
+	r.Handle("/api/_account_key", s.withHandler(s.getAccountSigningKey)).Methods("GET")
+	r.Handle("/api/_account_export", s.withHandler(s.exportAccount)).Methods("GET")
+	r.Handle("/api/_account_import", s.withHandler(s.importAccount)).Methods("POST")
+	r.Handle("/api/_account_moved", s.withHandler(s.setAccountMovedTo)).Methods("POST")
 
 	r.Handle("/api/_admin", s.withHandler(s.adminActions)).Methods("POST")
+	r.Handle("/api/content_filter", s.withHandler(s.handleContentFilter)).Methods("GET", "POST")
+	r.Handle("/api/content_filter/{wordID}", s.withHandler(s.deleteContentFilterWord)).Methods("DELETE")
+	r.Handle("/api/link_warnings", s.withHandler(s.handleLinkWarnings)).Methods("GET", "POST")
+	r.Handle("/api/link_warnings/{domain}", s.withHandler(s.deleteLinkWarning)).Methods("DELETE")
+	r.Handle("/api/api_keys", s.withHandler(s.handleAPIKeys)).Methods("GET", "POST")
+	r.Handle("/api/api_keys/{keyID}", s.withHandler(s.revokeAPIKey)).Methods("DELETE")
+	r.Handle("/api/cohorts", s.withHandler(s.handleCohorts)).Methods("GET", "POST")
+	r.Handle("/api/cohorts/{cohortID}", s.withHandler(s.getCohort)).Methods("GET")
+	r.Handle("/api/cohorts/{cohortID}/roster", s.withHandler(s.handleCohortRoster)).Methods("GET", "POST")
+	r.Handle("/api/cohorts/{cohortID}/participation", s.withHandler(s.getCohortParticipation)).Methods("GET")
+	r.Handle("/api/cohorts/{cohortID}/engagement", s.withHandler(s.getCohortEngagement)).Methods("GET")
+	r.Handle("/api/consent", s.withHandler(s.handleConsent)).Methods("GET", "POST", "DELETE")
+	r.Handle("/api/consent/forms", s.withHandler(s.handleConsentForms)).Methods("GET", "POST")
+	r.Handle("/api/consent/records", s.withHandler(s.getConsentRecords)).Methods("GET")
+	r.Handle("/api/experiments", s.withHandler(s.handleExperiments)).Methods("GET", "POST")
+	r.Handle("/api/experiments/{experimentID}", s.withHandler(s.getExperiment)).Methods("GET")
+	r.Handle("/api/experiments/{experimentID}/phases", s.withHandler(s.addExperimentPhase)).Methods("POST")
+	r.Handle("/api/experiments/{experimentID}/arms/{armID}", s.withHandler(s.updateExperimentArm)).Methods("PUT")
+	r.Handle("/api/surveys", s.withHandler(s.handleSurveys)).Methods("GET", "POST")
+	r.Handle("/api/surveys/{surveyID}", s.withHandler(s.getSurvey)).Methods("GET")
+	r.Handle("/api/surveys/{surveyID}/response", s.withHandler(s.completeSurvey)).Methods("POST")
+	r.Handle("/api/surveys/{surveyID}/results", s.withHandler(s.getSurveyResults)).Methods("GET")
+	r.Handle("/api/bots/usage", s.withHandler(s.getBotUsage)).Methods("GET")
+	r.Handle("/api/bots/kill_switch", s.withHandler(s.handleBotKillSwitch)).Methods("GET", "PUT")
+	r.Handle("/api/bots/personas", s.withHandler(s.handleBotPersonas)).Methods("GET", "POST")
+	r.Handle("/api/bots/personas/{personaID}", s.withHandler(s.handleBotPersona)).Methods("GET", "PUT", "DELETE")
+	r.Handle("/api/bots/personas/{personaID}/disabled", s.withHandler(s.setBotPersonaDisabled)).Methods("PUT")
+	r.Handle("/api/bots/report_stats", s.withHandler(s.getBotReportStats)).Methods("GET")
+	r.Handle("/api/users/{username}/bot_persona", s.withHandler(s.handleBotUserPersona)).Methods("GET", "PUT")
+	r.Handle("/api/posts/{postID}/snapshots", s.withHandler(s.getThreadSnapshots)).Methods("GET")
+	r.Handle("/api/activity/stream", s.withHandler(s.streamActivity)).Methods("GET")
 	r.Handle("/api/users", s.withHandler(s.getUsers)).Methods("GET")
 	r.Handle("/api/comments", s.withHandler(s.getComments)).Methods("GET")
 
@@ -232,17 +335,60 @@ func New(db *sql.DB, conf *config.Config) (*Server, error) {
 
 	r.Handle("/api/analytics", s.withHandler(s.handleAnalytics)).Methods("POST")
 	r.Handle("/api/analytics/bss", s.withHandler(s.getBasicSiteStats)).Methods("GET")
+	r.Handle("/api/analytics/timeouts", s.withHandler(s.getLatencyTimeouts)).Methods("GET")
+	r.Handle("/api/analytics/image_cache", s.withHandler(s.getImageCacheStats)).Methods("GET")
+	r.Handle("/api/image_moderation/rejections", s.withHandler(s.getImageModerationRejections)).Methods("GET")
+	r.Handle("/api/image_moderation/rejections/{rejectionID}", s.withHandler(s.reviewImageModerationRejection)).Methods("PUT")
 	r.Handle("/api/site_settings", s.withHandler(s.handleSiteSettings)).Methods("GET", "PUT")
+	r.Handle("/api/image_quotas", s.withHandler(s.getImageQuotas)).Methods("GET")
+	r.Handle("/api/image_quotas/{ownerType}/{ownerID}", s.withHandler(s.handleImageQuota)).Methods("GET", "PUT")
+	r.Handle("/api/bandwidth", s.withHandler(s.getBandwidth)).Methods("GET")
+	r.Handle("/api/bandwidth/{ownerType}/{ownerID}", s.withHandler(s.getOwnerBandwidth)).Methods("GET")
 
 	r.NotFoundHandler = http.HandlerFunc(s.apiNotFoundHandler)
 	r.MethodNotAllowedHandler = http.HandlerFunc(s.apiMethodNotAllowedHandler)
 
 	images.HMACKey = []byte(conf.HMACSecret)
-	s.staticRouter.PathPrefix("/images/").Handler(&images.Server{
+	core.SetAccountSigningSecret(conf.HMACSecret)
+	if conf.ImageURLTTL != "" {
+		if images.URLTTL, err = time.ParseDuration(conf.ImageURLTTL); err != nil {
+			return nil, fmt.Errorf("invalid imageURLTTL: %w", err)
+		}
+	}
+	if conf.ImageURLExpiryGrace != "" {
+		if images.URLExpiryGrace, err = time.ParseDuration(conf.ImageURLExpiryGrace); err != nil {
+			return nil, fmt.Errorf("invalid imageURLExpiryGrace: %w", err)
+		}
+	}
+	s.staticRouter.HandleFunc("/.well-known/nodeinfo", s.serveNodeInfoLinks).Methods("GET")
+	s.staticRouter.HandleFunc("/nodeinfo/2.1", s.serveNodeInfo).Methods("GET")
+	s.staticRouter.Use(s.withTracing)
+	s.staticRouter.Use(s.withMetrics)
+	s.staticRouter.Use(s.withCompression)
+	s.staticRouter.HandleFunc("/readyz", s.readyz).Methods("GET")
+	s.staticRouter.HandleFunc("/metrics", s.serveMetrics).Methods("GET")
+	imagesServer := &images.Server{
 		SkipHashCheck: conf.IsDevelopment,
 		DB:            db,
 		EnableCORS:    true,
-	})
+		Redirect:      conf.S3Enabled && conf.S3RedirectMode != "",
+		AccessLog:     s.imageAccessLog,
+	}
+	if conf.ImageHotlinkAllowedReferrers != "" {
+		policy := &images.ReferrerPolicy{}
+		for _, host := range strings.Split(conf.ImageHotlinkAllowedReferrers, ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				policy.Allowed = append(policy.Allowed, host)
+			}
+		}
+		if conf.ImageHotlinkPlaceholder != "" {
+			if policy.Placeholder, err = os.ReadFile(conf.ImageHotlinkPlaceholder); err != nil {
+				return nil, fmt.Errorf("reading image hotlink placeholder: %w", err)
+			}
+		}
+		imagesServer.Referrers = policy
+	}
+	s.staticRouter.PathPrefix("/images/").Handler(imagesServer)
 
 	if conf.UIProxy != "" {
 		s.staticRouter.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -286,10 +432,25 @@ func (s *Server) openLoggers() {
 			log.Fatal("cannot open logfile for writing: ", err)
 		}
 		out500 = s.http500LoggerFile
+
+		if s.config.ImageAccessLog {
+			s.imageAccessLogFile, err = os.OpenFile("./logs/images.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				log.Fatal("cannot open logfile for writing: ", err)
+			}
+		}
 	}
 	s.httpLogger = log.New(out, "", log.Ldate|log.Ltime|log.Lmicroseconds|log.LUTC)
 
 	s.http500Logger = log.New(out500, "", 0)
+
+	if s.config.ImageAccessLog {
+		var out io.Writer = os.Stdout
+		if s.imageAccessLogFile != nil {
+			out = s.imageAccessLogFile
+		}
+		s.imageAccessLog = images.NewAccessLog(out, s.config.ImageAccessLogSampleRate)
+	}
 }
 
 func (s *Server) closeLoggers() {
@@ -297,6 +458,9 @@ func (s *Server) closeLoggers() {
 	s.http500Logger.SetOutput(os.Stdout)
 	s.httpLoggerFile.Close()
 	s.http500LoggerFile.Close()
+	if s.imageAccessLogFile != nil {
+		s.imageAccessLogFile.Close()
+	}
 }
 
 // Close closes the server.
@@ -339,6 +503,11 @@ func updateUserLastSeen(ctx context.Context, w http.ResponseWriter, r *http.Requ
 
 func (s *Server) withHandler(h handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := requestAPIKey(r); key != "" {
+			s.serveAPIKeyRequest(w, r, key, h)
+			return
+		}
+
 		ses, err := s.sessions.Get(r)
 		if err != nil {
 			s.writeError(w, r, err)
@@ -363,12 +532,22 @@ func (s *Server) withHandler(h handler) http.Handler {
 		}
 
 		if err = h(&responseWriter{w: w}, newRequest(r, ses)); err != nil {
-			s.writeError(w, r, err)
+			s.writeHandlerError(w, r, err)
 			return
 		}
 	})
 }
 
+// writeHandlerError writes err, returned by the handler of r, to w.
+func (s *Server) writeHandlerError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+		err = errLatencyBudgetExceeded // See withLatencyBudget.
+	} else if isBodyTooLarge(err) {
+		err = errBodyTooLarge // See withBodyLimit.
+	}
+	s.writeError(w, r, err)
+}
+
 // setCsrfCookie sets the CSRF cookie if the cookie is not present or if the
 // cookie is invalid. It also includes the CSRF token in a "Csrf-Token" HTTP
 // header (this header is sent on every response).
@@ -444,17 +623,18 @@ func constructLogLine(fields []logField, color string) string {
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	beginT := time.Now()
+	r, requestID := withRequestID(w, r)
 
 	if r.URL.Path == "/robots.txt" {
 		http.ServeFile(w, r, "./robots.txt")
 	} else if r.URL.Path == "/manifest.json" {
 		w.Header().Add("Cache-Control", "no-cache")
-		http.ServeFile(w, r, "./ui/dist/manifest.json")
+		s.ui.ServeHTTP(w, r)
 	} else {
 		if strings.HasPrefix(r.URL.Path, "/api/") {
 			w.Header().Add("Content-Type", "application/json; charset=UTF-8")
 			w.Header().Add("Cache-Control", "no-store")
-			httputil.GzipHandler(s.router).ServeHTTP(w, r)
+			s.apiHandler.ServeHTTP(w, r)
 		} else {
 			s.staticRouter.ServeHTTP(w, r)
 		}
@@ -468,6 +648,7 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	took := time.Since(beginT)
 
 	logFields := []logField{
+		{name: "id", val: requestID},
 		{name: "took", val: took},
 		{name: "url", val: r.URL},
 		{name: "ip", val: httputil.GetIP(r)},
@@ -477,12 +658,7 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	s.httpLogger.Println(constructLogLine(logFields, ""))
 	if s.config.IsDevelopment && os.Getenv("NO_HTTP_LOG_LINE") != "true" {
-		logFields[len(logFields)-1].off = true
-		var color string
-		if took > time.Millisecond*10 {
-			color = "\033[0;33m"
-		}
-		log.Println(constructLogLine(logFields, color))
+		logging.FromContext(r.Context()).Info("HTTP request", "took", took, "method", r.Method, "url", r.URL.String())
 	}
 }
 
@@ -495,12 +671,13 @@ func (s *Server) apiMethodNotAllowedHandler(w http.ResponseWriter, r *http.Reque
 }
 
 func (s *Server) logInternalServerError(r *http.Request, err error) {
-	log.Println("500 Internal server error: ", err)
+	logging.FromContext(r.Context()).Error("500 Internal server error", "error", err)
 
 	stack := debug.Stack()
 	stack64 := base64.StdEncoding.EncodeToString(stack)
 	line := struct {
 		Error         string      `json:"error"`
+		RequestID     string      `json:"requestId"`
 		Method        string      `json:"method"`
 		URL           string      `json:"url"`
 		Proto         string      `json:"proto"`
@@ -511,14 +688,15 @@ func (s *Server) logInternalServerError(r *http.Request, err error) {
 		Stack         string      `json:"stack"`
 	}{
 		Error:         err.Error(),
+		RequestID:     logging.RequestID(r.Context()),
 		Stack:         stack64,
 		Method:        r.Method,
-		URL:           r.URL.String(),
+		URL:           logging.Redact(r.URL.String()),
 		Proto:         r.Proto,
-		Header:        r.Header,
+		Header:        redactedHeader(r.Header),
 		ContentLength: r.ContentLength,
 		RemoteAddr:    r.RemoteAddr,
-		RequestURI:    r.RequestURI,
+		RequestURI:    logging.Redact(r.RequestURI),
 	}
 
 	js, _ := json.Marshal(line)
@@ -529,7 +707,39 @@ func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
 	statusCode := http.StatusInternalServerError
 	var res []byte
 
-	if httpErr, ok := err.(*httperr.Error); ok {
+	var cooldownErr *core.CooldownError
+	var rejectedErr *images.RejectedError
+	var quotaErr *images.QuotaError
+	if errors.As(err, &cooldownErr) {
+		statusCode = http.StatusTooManyRequests
+		w.Header().Set("Retry-After", strconv.Itoa(cooldownErr.RemainingSeconds()))
+		res, _ = json.Marshal(cooldownErr)
+	} else if errors.As(err, &rejectedErr) {
+		statusCode = http.StatusUnprocessableEntity
+		res, _ = json.Marshal(httperr.Error{
+			HTTPStatus: statusCode,
+			Code:       "image_rejected",
+			Message:    "Image rejected by automated moderation.",
+		})
+	} else if errors.Is(err, images.ErrInvalidCrop) {
+		statusCode = http.StatusBadRequest
+		res, _ = json.Marshal(httperr.Error{
+			HTTPStatus: statusCode,
+			Code:       "invalid_crop",
+			Message:    "Invalid image crop.",
+		})
+	} else if errors.As(err, &quotaErr) {
+		statusCode = http.StatusForbidden
+		message := "You have reached your image upload quota."
+		if quotaErr.Owner.Type == images.QuotaOwnerCommunity {
+			message = "The community has reached its image upload quota."
+		}
+		res, _ = json.Marshal(httperr.Error{
+			HTTPStatus: statusCode,
+			Code:       "image_quota_exceeded",
+			Message:    message,
+		})
+	} else if httpErr, ok := err.(*httperr.Error); ok {
 		if httpErr.Message == "" {
 			httpErr.Message = http.StatusText(httpErr.HTTPStatus) + "."
 		}
@@ -698,7 +908,7 @@ func (s *Server) insertMetaTags(doc *html.Node, r *http.Request) {
 
 	absoluteURL := func(path string) string {
 		scheme := "http://"
-		if s.config.CertFile != "" {
+		if s.config.HTTPS() {
 			scheme = "https://"
 		}
 		return scheme + filepath.Join(r.Host, path)
@@ -860,6 +1070,10 @@ func (s *Server) serveSPA(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.redirectMovedUser(w, r) {
+		return
+	}
+
 	ses, err := s.sessions.Get(r)
 	if err == nil {
 		s.setInitialCookies(w, r, ses)
@@ -914,7 +1128,7 @@ func (s *Server) serveSPA(w http.ResponseWriter, r *http.Request) {
 	}
 
 	serveIndexFile := func(fileNotFound bool) {
-		file, err := os.Open(filepath.Join(s.reactPath, s.reactIndex))
+		file, err := s.ui.open(s.reactIndex)
 		if err != nil {
 			skipServiceWorkerCache(w.Header())
 			serveIndexFileNotFound(fmt.Errorf("opening index.html file: %w", err))
@@ -953,17 +1167,16 @@ func (s *Server) serveSPA(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Cache-Control", "private, max-age=0")
 	}
 
-	fpath := filepath.Join(s.reactPath, path)
-	_, err = os.Stat(fpath)
-	if os.IsNotExist(err) {
-		serveIndexFile(true)
-		return
-	} else if err != nil {
+	exists, err := s.ui.exists(path)
+	if err != nil {
 		http.Error(w, "500: Internal server error", http.StatusInternalServerError)
 		return
+	} else if !exists {
+		serveIndexFile(true)
+		return
 	}
 
-	httputil.FileServer(http.Dir(s.reactPath)).ServeHTTP(w, r)
+	s.ui.ServeHTTP(w, r)
 }
 
 // isLoggedIn returns whether user is logged in and the user's ID if so. ID is
@@ -1006,6 +1219,10 @@ func (s *Server) loginUser(u *core.User, ses *sessions.Session, w http.ResponseW
 		return err
 	}
 
+	if err := core.RecordUserLogin(r.Context(), s.db, u.ID, httputil.GetIP(r), r.UserAgent()); err != nil {
+		log.Printf("Error recording login of user %v: %v\n", u.Username, err)
+	}
+
 	ses.Values["uid"] = u.ID.String()
 	return ses.Save(w, r)
 }
@@ -1043,8 +1260,13 @@ func (s *Server) LogoutAllSessionsOfUser(u *core.User) error {
 		}
 	}
 
-	_, err = conn.Do("DEL", userSessionsSetRedisKey(u.UsernameLowerCase))
-	return err
+	if _, err = conn.Do("DEL", userSessionsSetRedisKey(u.UsernameLowerCase)); err != nil {
+		return err
+	}
+
+	// Native apps don't have sessions of their own to log out of, so stop
+	// sending notifications to them as well.
+	return core.DeleteAllPushDevices(context.Background(), s.db, u.ID)
 }
 
 // strToID always returns either a nil-error or an error of type httperr.Error.
@@ -1055,28 +1277,27 @@ func strToID(s string) (id uid.ID, err error) {
 	return
 }
 
+// SetConfig sets the config of s to conf, a reloaded config (see
+// config.Watcher). Only the rate limits, and the limits of image uploads (up
+// to the body size limits set on start), take effect; other settings take
+// effect on restart.
+func (s *Server) SetConfig(conf *config.Config) {
+	s.live.Store(conf)
+}
+
+// liveConfig returns the config last set by SetConfig.
+func (s *Server) liveConfig() *config.Config {
+	return s.live.Load()
+}
+
 // rateLimit returns an error if the rate limit is reached for the bucket or if
 // some other error occurs in the process of checking it. If rateLimit returns
 // a non-nil error, the handler should return immediately.
 func (s *Server) rateLimit(r *request, bucketID string, interval time.Duration, maxTokens int) error {
-	if s.config.DisableRateLimits {
-		return nil // skip rate limits
-	}
-
-	if s.config.AdminAPIKey != "" {
-		adminKey := r.urlQueryParamsValue("adminKey")
-		if adminKey == s.config.AdminAPIKey {
-			return nil // skip rate limits
-		}
-	}
-
-	conn, err := s.redisPool.Dial()
-	if err != nil {
-		return err
+	if s.rateLimitsSkipped(r) {
+		return nil
 	}
-	defer conn.Close()
-
-	if ok, err := ratelimits.Limit(conn, bucketID, interval, maxTokens); err != nil {
+	if ok, err := (rateLimiter{s}).Limit(r.ctx, bucketID, interval, maxTokens); err != nil {
 		return err
 	} else if !ok {
 		return &httperr.Error{
@@ -1088,6 +1309,34 @@ func (s *Server) rateLimit(r *request, bucketID string, interval time.Duration,
 	return nil
 }
 
+// rateLimitsSkipped reports whether rate limits are not applied to r: if
+// they're disabled, or if r is made with the admin API key.
+func (s *Server) rateLimitsSkipped(r *request) bool {
+	conf := s.liveConfig()
+	if conf.DisableRateLimits {
+		return true
+	}
+	return conf.AdminAPIKey != "" && r.urlQueryParamsValue("adminKey") == conf.AdminAPIKey
+}
+
+// rateLimiter is the core.RateLimiter of the site, which keeps its buckets in
+// Redis.
+type rateLimiter struct {
+	s *Server
+}
+
+func (l rateLimiter) Limit(ctx context.Context, bucketID string, interval time.Duration, maxTokens int) (bool, error) {
+	if l.s.liveConfig().DisableRateLimits {
+		return true, nil
+	}
+	conn, err := l.s.redisPool.Dial()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	return ratelimits.Limit(conn, bucketID, interval, maxTokens)
+}
+
 func (s *Server) rateLimitUpdateContent(r *request, userID uid.ID) error {
 	if err := s.rateLimit(r, "update_stuff_1_"+userID.String(), time.Second*1, 1); err != nil {
 		return err
@@ -1112,8 +1361,23 @@ func (s *Server) getLinkInfo(w *responseWriter, r *request) error {
 		return err
 	}
 
-	url := r.urlQueryParamsValue("url")
-	res, err := httputil.Get(url)
+	link := r.urlQueryParamsValue("url")
+
+	// Links to warned domains are not fetched.
+	if u, err := url.Parse(link); err == nil && u.Hostname() != "" {
+		warning, err := core.GetLinkWarning(r.ctx, s.db, u.Hostname())
+		if err != nil {
+			return err
+		}
+		if warning != nil {
+			return w.writeJSON(struct {
+				Title   string            `json:"title"`
+				Warning *core.LinkWarning `json:"warning"`
+			}{Warning: warning})
+		}
+	}
+
+	res, err := httputil.Get(link)
 	if err != nil {
 		return err
 	}
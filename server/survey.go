@@ -0,0 +1,144 @@
+package server
+
+import (
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// /api/surveys [GET, POST]
+//
+// GET returns the pending surveys of the logged in user, or with the all=true
+// query parameter, all surveys (to admins). POST creates a survey.
+func (s *Server) handleSurveys(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	if r.req.Method == "POST" || r.urlQueryParamsValue("all") == "true" {
+		admin, err := getLoggedInAdmin(s.db, r)
+		if err != nil {
+			return err
+		}
+		if r.req.Method == "GET" {
+			surveys, err := core.GetSurveys(r.ctx, s.db)
+			if err != nil {
+				return err
+			}
+			return w.writeJSON(surveys)
+		}
+		survey := &core.Survey{}
+		if err := r.unmarshalJSONBody(survey); err != nil {
+			return err
+		}
+		if survey, err = core.CreateSurvey(r.ctx, s.db, admin.ID, survey); err != nil {
+			return err
+		}
+		return w.writeJSON(survey)
+	}
+
+	surveys, err := core.GetPendingSurveys(r.ctx, s.db, *r.viewer)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(surveys)
+}
+
+func requestSurveyID(r *request) (uid.ID, error) {
+	id, err := uid.FromString(r.muxVar("surveyID"))
+	if err != nil {
+		return id, httperr.NewBadRequest("invalid_id", "Invalid survey ID.")
+	}
+	return id, nil
+}
+
+// /api/surveys/{surveyID} [GET]
+//
+// Returns the survey as delivered to the logged in user, or to admins, the
+// survey itself.
+func (s *Server) getSurvey(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	id, err := requestSurveyID(r)
+	if err != nil {
+		return err
+	}
+
+	prompt, notFound := core.GetSurveyPrompt(r.ctx, s.db, id, *r.viewer)
+	if notFound == nil {
+		return w.writeJSON(prompt)
+	} else if !httperr.IsNotFound(notFound) {
+		return notFound
+	}
+	if is, err := core.IsAdmin(s.db, r.viewer); err != nil {
+		return err
+	} else if !is {
+		return notFound
+	}
+	survey, err := core.GetSurvey(r.ctx, s.db, id)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(survey)
+}
+
+// /api/surveys/{surveyID}/response [POST]
+//
+// Completes the survey. The answers to built-in forms are posted as a JSON
+// object, keyed by question id; the body is ignored for external surveys.
+func (s *Server) completeSurvey(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	id, err := requestSurveyID(r)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Answers map[string]string `json:"answers"`
+	}
+	if r.req.ContentLength != 0 {
+		if err := r.unmarshalJSONBody(&body); err != nil {
+			return err
+		}
+	}
+	prompt, err := core.CompleteSurvey(r.ctx, s.db, id, *r.viewer, body.Answers)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(prompt)
+}
+
+// /api/surveys/{surveyID}/results [GET]
+//
+// With the format=csv query parameter, the responses are returned as a CSV
+// file. See pseudonymizeView for the pseudonymize and scope query parameters.
+func (s *Server) getSurveyResults(w *responseWriter, r *request) error {
+	if _, err := getLoggedInAdmin(s.db, r); err != nil {
+		return err
+	}
+	id, err := requestSurveyID(r)
+	if err != nil {
+		return err
+	}
+	survey, err := core.GetSurvey(r.ctx, s.db, id)
+	if err != nil {
+		return err
+	}
+
+	results, err := core.GetSurveyResults(r.ctx, s.db, survey)
+	if err != nil {
+		return err
+	}
+	if err := s.pseudonymizeView(r, results); err != nil {
+		return err
+	}
+	if r.urlQueryParamsValue("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="survey.csv"`)
+		return results.WriteCSV(w)
+	}
+	return w.writeJSON(results)
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/discuitnet/discuit/internal/logging"
+	"github.com/discuitnet/discuit/internal/tracing"
+	"github.com/gorilla/mux"
+)
+
+// withTracing traces the request (see package tracing), as a span named by
+// its route, of the trace of its traceparent header if it has one. The
+// request's logger gets the ID of the trace, if it's sampled. It's a
+// middleware of both the API router and the static router.
+func (s *Server) withTracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if cr := mux.CurrentRoute(r); cr != nil {
+			if tpl, err := cr.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		ctx := tracing.Extract(r.Context(), r.Header)
+		ctx, span := tracing.Start(ctx, tracing.KindServer, r.Method+" "+route,
+			tracing.String("http.request.method", r.Method),
+			tracing.String("http.route", route),
+			tracing.String("url.path", logging.Redact(r.URL.Path)),
+			tracing.String("request_id", logging.RequestID(ctx)))
+		if span == nil {
+			if ctx != r.Context() {
+				// It carries the decision not to sample the trace, for the
+				// spans started in it.
+				r = r.WithContext(ctx)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer span.End()
+		if id, ok := tracing.TraceIDFromContext(ctx); ok {
+			ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("trace_id", id.String()))
+		}
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		if sw.code == 0 {
+			sw.code = http.StatusOK
+		}
+		span.SetAttributes(tracing.Int("http.response.status_code", sw.code))
+		if sw.code >= 500 {
+			span.SetFailed(http.StatusText(sw.code))
+		}
+	})
+}
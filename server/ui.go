@@ -0,0 +1,102 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/discuitnet/discuit/internal/httputil"
+	"github.com/discuitnet/discuit/ui"
+)
+
+// uiDir is the directory the built frontend is served from, if it's not
+// embedded in the binary.
+const uiDir = "./ui/dist"
+
+// hashedAssetsDir is the directory to which Vite writes the files it names by
+// their content (like /assets/index-4ed993c7.js). Since a file there never
+// changes (a change gives it a new name), it's cached for good.
+const hashedAssetsDir = "/assets/"
+
+// uiFiles are the files of the built frontend.
+type uiFiles struct {
+	fsys    fs.FS
+	handler http.Handler
+
+	// The ETags of files, by path. They're computed once, when the server
+	// starts, so they're only kept for the files embedded in the binary (the
+	// files in uiDir may be rebuilt while the server runs).
+	etags map[string]string
+}
+
+// newUIFiles returns the files of the frontend embedded in the binary, if
+// there is one, or else those in uiDir.
+func newUIFiles() (*uiFiles, error) {
+	if ui.Dist == nil {
+		return newUIFilesOf(os.DirFS(uiDir), false)
+	}
+	return newUIFilesOf(ui.Dist, true)
+}
+
+// newUIFilesOf returns the files of fsys, with the ETags of the files computed
+// if fingerprint is true.
+func newUIFilesOf(fsys fs.FS, fingerprint bool) (*uiFiles, error) {
+	u := &uiFiles{
+		fsys:    fsys,
+		handler: httputil.FileServer(http.FS(fsys)),
+	}
+	if !fingerprint {
+		return u, nil
+	}
+	u.etags = make(map[string]string)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		// Weak, since the same tag is sent for the compressed versions of the
+		// file (see httputil.FileServer).
+		u.etags["/"+name] = `W/"` + hex.EncodeToString(sum[:12]) + `"`
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// open opens the file at name (a clean path that begins with a slash).
+func (u *uiFiles) open(name string) (fs.File, error) {
+	return u.fsys.Open(strings.TrimPrefix(name, "/"))
+}
+
+// exists reports whether there's a file at name (a clean path that begins
+// with a slash).
+func (u *uiFiles) exists(name string) (bool, error) {
+	_, err := fs.Stat(u.fsys, strings.TrimPrefix(name, "/"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ServeHTTP serves the file at the path of r.
+func (u *uiFiles) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	if strings.HasPrefix(name, hashedAssetsDir) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	}
+	if etag, ok := u.etags[name]; ok {
+		w.Header().Set("ETag", etag)
+	}
+	u.handler.ServeHTTP(w, r)
+}
@@ -2,18 +2,17 @@ package server
 
 import (
 	"database/sql"
-	"io"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/SherClockHolmes/webpush-go"
 	"github.com/discuitnet/discuit/core"
 	"github.com/discuitnet/discuit/core/sitesettings"
 	"github.com/discuitnet/discuit/internal/hcaptcha"
 	"github.com/discuitnet/discuit/internal/httperr"
 	"github.com/discuitnet/discuit/internal/httputil"
+	"github.com/discuitnet/discuit/internal/images"
 	"github.com/discuitnet/discuit/internal/uid"
 	"github.com/gorilla/mux"
 )
@@ -123,15 +122,18 @@ func (s *Server) deleteUser(w *responseWriter, r *request) error {
 func (s *Server) initial(w *responseWriter, r *request) error {
 	var err error
 	response := struct {
-		SignupsDisabled bool                `json:"signupsDisabled"`
-		ReportReasons   []core.ReportReason `json:"reportReasons"`
-		User            *core.User          `json:"user"`
-		Lists           []*core.List        `json:"lists"`
-		Communities     []*core.Community   `json:"communities"`
-		NoUsers         int                 `json:"noUsers"`
-		BannedFrom      []uid.ID            `json:"bannedFrom"`
-		VAPIDPublicKey  string              `json:"vapidPublicKey"`
-		Mutes           struct {
+		SignupsDisabled   bool                `json:"signupsDisabled"`
+		SignupsRosterOnly bool                `json:"signupsRosterOnly"`
+		ConsentRequired   bool                `json:"consentRequired"`
+		AuthBackend       string              `json:"authBackend"`
+		ReportReasons     []core.ReportReason `json:"reportReasons"`
+		User              *core.User          `json:"user"`
+		Lists             []*core.List        `json:"lists"`
+		Communities       []*core.Community   `json:"communities"`
+		NoUsers           int                 `json:"noUsers"`
+		BannedFrom        []uid.ID            `json:"bannedFrom"`
+		VAPIDPublicKey    string              `json:"vapidPublicKey"`
+		Mutes             struct {
 			CommunityMutes []*core.Mute `json:"communityMutes"`
 			UserMutes      []*core.Mute `json:"userMutes"`
 		} `json:"mutes"`
@@ -147,7 +149,10 @@ func (s *Server) initial(w *responseWriter, r *request) error {
 	if err != nil {
 		return err
 	}
-	response.SignupsDisabled = siteSettings.SignupsDisabled
+	response.SignupsDisabled = siteSettings.SignupsDisabled || s.config.AuthBackend != ""
+	response.SignupsRosterOnly = siteSettings.SignupsRosterOnly
+	response.ConsentRequired = siteSettings.ConsentRequired
+	response.AuthBackend = s.config.AuthBackend
 
 	if r.loggedIn {
 		if response.User, err = core.GetUser(r.ctx, s.db, *r.viewer, r.viewer); err != nil {
@@ -240,7 +245,7 @@ func (s *Server) login(w *responseWriter, r *request) error {
 		return err
 	}
 
-	user, err := core.MatchLoginCredentials(r.ctx, s.db, username, password)
+	user, err := s.authenticate(r.ctx, username, password)
 	if err != nil {
 		return err
 	}
@@ -259,12 +264,10 @@ func (s *Server) signup(w *responseWriter, r *request) error {
 	}
 
 	// Verify that signups are not disabled.
-	if settings, err := sitesettings.GetSiteSettings(r.ctx, s.db); err != nil {
-		return err
-	} else if settings.SignupsDisabled {
-		return httperr.NewForbidden("signups-disabled", "Creating new accounts is disabled.")
+	if s.config.AuthBackend != "" {
+		// Accounts are created on first login.
+		return errExternalAuth
 	}
-
 	values, err := r.unmarshalJSONBodyToStringsMap(true)
 	if err != nil {
 		return err
@@ -274,6 +277,19 @@ func (s *Server) signup(w *responseWriter, r *request) error {
 	email := values["email"]
 	password := values["password"]
 	captchaToken := values["captchaToken"]
+	inviteCode := values["inviteCode"] // Of a cohort roster.
+
+	// Invites are for accounts that already exist, so they can be claimed even
+	// if signups are disabled.
+	if inviteCode == "" {
+		if settings, err := sitesettings.GetSiteSettings(r.ctx, s.db); err != nil {
+			return err
+		} else if settings.SignupsDisabled {
+			return httperr.NewForbidden("signups-disabled", "Creating new accounts is disabled.")
+		} else if settings.SignupsRosterOnly {
+			return httperr.NewForbidden("signups-roster-only", "Only people with an invite code can sign up.")
+		}
+	}
 
 	// Verify captcha.
 	if s.config.CaptchaSecret != "" {
@@ -292,13 +308,19 @@ func (s *Server) signup(w *responseWriter, r *request) error {
 		return err
 	}
 
-	user, err := core.RegisterUser(r.ctx, s.db, username, email, password, httputil.GetIP(r.req))
+	var user *core.User
+	if inviteCode != "" {
+		user, err = core.ClaimCohortInvite(r.ctx, s.db, inviteCode, password)
+	} else {
+		user, err = core.RegisterUser(r.ctx, s.db, username, email, password, httputil.GetIP(r.req))
+	}
 	if err != nil {
 		return err
 	}
 
 	// Try logging in user.
 	s.loginUser(user, r.ses, w, r.req)
+	s.sendVerificationEmail(user)
 
 	w.WriteHeader(http.StatusCreated)
 	return w.writeJSON(user)
@@ -455,24 +477,6 @@ func (s *Server) deleteNotification(w *responseWriter, r *request) error {
 	return w.writeJSON(notif)
 }
 
-// /api/push_subscriptions [POST]
-func (s *Server) pushSubscriptions(w *responseWriter, r *request) error {
-	if !r.loggedIn {
-		return errNotLoggedIn
-	}
-
-	var sub webpush.Subscription
-	if err := r.unmarshalJSONBody(&sub); err != nil {
-		return err
-	}
-
-	if err := core.SaveWebPushSubscription(r.ctx, s.db, r.ses.ID, *r.viewer, sub); err != nil {
-		return err
-	}
-
-	return w.writeString(`{"success":true}`)
-}
-
 // /api/_settings [POST]
 func (s *Server) updateUserSettings(w *responseWriter, r *request) error {
 	if !r.loggedIn {
@@ -494,6 +498,7 @@ func (s *Server) updateUserSettings(w *responseWriter, r *request) error {
 	query := r.urlQueryParams()
 	switch query.Get("action") {
 	case "updateProfile":
+		previousEmail := user.Email
 		if err = r.unmarshalJSONBody(&user); err != nil {
 			return err
 		}
@@ -501,6 +506,9 @@ func (s *Server) updateUserSettings(w *responseWriter, r *request) error {
 		if err = user.Update(r.ctx, s.db); err != nil {
 			return err
 		}
+		if user.Email != previousEmail {
+			s.sendVerificationEmail(user)
+		}
 	case "changePassword":
 		values, err := r.unmarshalJSONBodyToStringsMap(true)
 		if err != nil {
@@ -515,6 +523,16 @@ func (s *Server) updateUserSettings(w *responseWriter, r *request) error {
 		if err = user.ChangePassword(r.ctx, s.db, password, newPassword); err != nil {
 			return err
 		}
+	case "confirmAge":
+		var body struct {
+			Confirmed bool `json:"confirmed"`
+		}
+		if err = r.unmarshalJSONBody(&body); err != nil {
+			return err
+		}
+		if err = user.ConfirmAge(r.ctx, s.db, body.Confirmed); err != nil {
+			return err
+		}
 	default:
 		return httperr.NewBadRequest("invalid_action", "Unsupported action.")
 	}
@@ -522,7 +540,7 @@ func (s *Server) updateUserSettings(w *responseWriter, r *request) error {
 	return w.writeJSON(user)
 }
 
-// /api/users/{username}/pro_pic [POST, DELETE]
+// /api/users/{username}/pro_pic [POST, PUT, DELETE]
 func (s *Server) handleUserProPic(w *responseWriter, r *request) error {
 	if !r.loggedIn {
 		return errNotLoggedIn
@@ -539,22 +557,27 @@ func (s *Server) handleUserProPic(w *responseWriter, r *request) error {
 	}
 
 	if r.req.Method == "POST" {
-		r.req.Body = http.MaxBytesReader(w, r.req.Body, int64(s.config.MaxImageSize)) // limit max upload size
-		if err := r.req.ParseMultipartForm(int64(s.config.MaxImageSize)); err != nil {
-			return httperr.NewBadRequest("file_size_exceeded", "Max file size exceeded.")
-		}
-
-		file, _, err := r.req.FormFile("image")
+		file, err := r.formFile(w, "image", s.liveConfig().MaxImageSize, s.config.MaxMultipartMemory)
 		if err != nil {
 			return err
 		}
 		defer file.Close()
-
-		data, err := io.ReadAll(file)
+		crop, err := images.ParseCropRect(r.req.FormValue("crop"))
 		if err != nil {
 			return err
 		}
-		if err := user.UpdateProPic(r.ctx, s.db, data, s.config.S3Enabled); err != nil {
+
+		if err := user.UpdateProPic(r.ctx, s.db, file, crop, s.config.S3Enabled); err != nil {
+			return err
+		}
+	} else if r.req.Method == "PUT" {
+		var body struct {
+			Crop images.CropRect `json:"crop"`
+		}
+		if err := r.unmarshalJSONBody(&body); err != nil {
+			return err
+		}
+		if err := user.RecropProPic(r.ctx, s.db, body.Crop); err != nil {
 			return err
 		}
 	} else if r.req.Method == "DELETE" {
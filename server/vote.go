@@ -0,0 +1,66 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// maxVoteStatesIDs is the max number of items whose vote states can be
+// fetched at once (a page of posts or comments).
+const maxVoteStatesIDs = 100
+
+// /api/votes [GET, PUT]
+func (s *Server) handleVotes(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	if r.req.Method == "GET" {
+		var target core.ContentType
+		if err := target.UnmarshalText([]byte(r.urlQueryParamsValue("type"))); err != nil {
+			return httperr.NewBadRequest("invalid_target_type", "Invalid vote target type.")
+		}
+		var ids []uid.ID
+		if param := r.urlQueryParamsValue("ids"); param != "" {
+			for _, str := range strings.Split(param, ",") {
+				id, err := uid.FromString(str)
+				if err != nil {
+					return httperr.NewBadRequest("invalid_id", "Invalid ID: "+str+".")
+				}
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) > maxVoteStatesIDs {
+			return httperr.NewBadRequest("too_many_ids", "Too many IDs.")
+		}
+		states, err := core.GetVoteStates(r.ctx, s.db, target, *r.viewer, ids)
+		if err != nil {
+			return err
+		}
+		return w.writeJSON(map[string]any{"votes": states})
+	}
+
+	// PUT
+	if err := s.rateLimitVoting(r, *r.viewer); err != nil {
+		return err
+	}
+	if err := s.checkConsent(r); err != nil {
+		return err
+	}
+	req := struct {
+		TargetType core.ContentType `json:"targetType"`
+		TargetID   uid.ID           `json:"targetId"`
+		Vote       core.VoteState   `json:"vote"`
+	}{}
+	if err := r.unmarshalJSONBody(&req); err != nil {
+		return err
+	}
+	res, err := core.SetVote(r.ctx, s.db, req.TargetType, req.TargetID, *r.viewer, req.Vote)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(res)
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/SherClockHolmes/webpush-go"
+	"github.com/discuitnet/discuit/config"
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/logging"
+)
+
+// enableWebPushNotifications enables web push notifications with the VAPID
+// keys of conf or, if there are none, with those stored in the database
+// (except in development).
+func (s *Server) enableWebPushNotifications(conf *config.Config) {
+	var keys *core.VAPIDKeys
+	if conf.VAPIDPublicKey != "" {
+		keys = &core.VAPIDKeys{Public: conf.VAPIDPublicKey, Private: conf.VAPIDPrivateKey}
+	} else {
+		ctx := context.Background()
+		var err error
+		if keys, err = core.GetApplicationVAPIDKeys(ctx, s.db); err != nil {
+			logging.FromContext(ctx).Error("Error generating vapid keys (you might want to run migrations)", "error", err)
+			return
+		}
+		if conf.IsDevelopment {
+			return
+		}
+	}
+
+	email := conf.VAPIDEmail
+	if email == "" {
+		email = conf.EmailContact
+	}
+	if email == "" {
+		email = "discuit@previnder.com"
+	}
+	s.webPushVAPIDKeys = *keys
+	core.EnablePushNotifications(keys, email)
+}
+
+// /api/push_subscriptions [GET, POST]
+func (s *Server) handlePushSubscriptions(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	if r.req.Method == "POST" {
+		if err := s.rateLimit(r, "push_subscriptions_1_"+r.viewer.String(), time.Hour, 100); err != nil {
+			return err
+		}
+		var sub webpush.Subscription
+		if err := r.unmarshalJSONBody(&sub); err != nil {
+			return err
+		}
+		if err := core.SaveWebPushSubscription(r.ctx, s.db, r.ses.ID, *r.viewer, sub); err != nil {
+			return err
+		}
+		return w.writeString(`{"success":true}`)
+	}
+
+	subs, err := core.GetWebPushSubscriptions(r.ctx, s.db, *r.viewer, r.ses.ID)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(subs)
+}
+
+// /api/push_subscriptions/{subscriptionID} [GET, PUT, DELETE]
+func (s *Server) handlePushSubscription(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	id, err := strconv.Atoi(r.muxVar("subscriptionID"))
+	if err != nil {
+		return httperr.NewBadRequest("invalid_id", "Invalid subscription ID.")
+	}
+
+	sub, err := core.GetWebPushSubscription(r.ctx, s.db, *r.viewer, id, r.ses.ID)
+	if err != nil {
+		return err
+	}
+
+	switch r.req.Method {
+	case "PUT":
+		if err := r.unmarshalJSONBody(&sub.Preferences); err != nil {
+			return err
+		}
+		if err := sub.UpdatePreferences(r.ctx, s.db); err != nil {
+			return err
+		}
+	case "DELETE":
+		if err := core.DeleteUserWebPushSubscription(r.ctx, s.db, *r.viewer, id); err != nil {
+			return err
+		}
+	}
+
+	return w.writeJSON(sub)
+}
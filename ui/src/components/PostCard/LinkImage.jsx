@@ -12,13 +12,10 @@ const LinkImage = ({ image, loading = 'lazy', isImagePost = false }) => {
 
   const { src, size } = (() => {
     const imageCopyName = isImagePost ? 'tiny' : window.innerWidth > 768 ? 'desktop' : 'mobile';
-    const matches = image.copies.filter((copy) => copy.name === imageCopyName);
-    let copy;
-    if (matches.length === 0) {
+    let copy = image.variants && image.variants[imageCopyName];
+    if (!copy) {
       copy = image;
       console.error(`LinkImage.jsx: No matching image copy found (for image: ${image.url})`);
-    } else {
-      copy = matches[0];
     }
     return {
       src: copy.url,
@@ -42,7 +39,7 @@ const LinkImage = ({ image, loading = 'lazy', isImagePost = false }) => {
     >
       <img
         src={src}
-        alt=""
+        alt={image.altText ?? ''}
         loading={loading}
         width={size.width}
         height={size.height}
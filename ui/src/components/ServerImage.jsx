@@ -5,11 +5,10 @@ import Image from './Image';
 const ServerImage = ({ onLoad, image, sizes, style = {}, ...props }) => {
   let src = image.url,
     srcset = '';
-  if (image.copies) {
-    for (let i = 0; i < image.copies.length; i++) {
-      const copy = image.copies[i];
-      srcset += (i > 0 ? ', ' : '') + `${copy.url} ${copy.width}w`;
-    }
+  if (image.variants) {
+    Object.values(image.variants).forEach((variant, i) => {
+      srcset += (i > 0 ? ', ' : '') + `${variant.url} ${variant.width}w`;
+    });
   }
   srcset += (srcset !== '' ? ', ' : '') + `${src} ${image.width}w`;
   if (!sizes) sizes = '(max-width: 768px) 358px, 647px';
@@ -20,7 +19,7 @@ const ServerImage = ({ onLoad, image, sizes, style = {}, ...props }) => {
       srcSet={srcset}
       sizes={sizes}
       src={src}
-      alt=""
+      alt={image.altText ?? ''}
       style={style}
       backgroundColor={style.backgroundColor ?? image.averageColor}
       {...props}
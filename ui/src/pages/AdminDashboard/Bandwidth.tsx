@@ -0,0 +1,171 @@
+import { useEffect, useState } from 'react';
+import { useDispatch } from 'react-redux';
+import { Link } from 'react-router-dom';
+import Button from '../../components/Button';
+import Dropdown from '../../components/Dropdown';
+import PageLoading from '../../components/PageLoading';
+import SimpleFeed, { SimpleFeedItem } from '../../components/SimpleFeed';
+import { TableRow } from '../../components/Table';
+import { mfetchjson } from '../../helper';
+import { useLoading } from '../../hooks';
+import { snackAlertError } from '../../slices/mainSlice';
+
+interface BandwidthUsage {
+  requests: number;
+  bytes: number;
+  externalRequests: number;
+  externalBytes: number;
+}
+
+interface Row extends BandwidthUsage {
+  day: string;
+  ownerType?: 'user' | 'community';
+  ownerId?: string;
+  ownerName?: string;
+  imageId?: string;
+}
+
+type RowType = 'user' | 'community' | 'image';
+type Sort = 'bytes' | 'external';
+
+const typeOptions: { [key in RowType]: string } = {
+  community: 'Communities',
+  user: 'Users',
+  image: 'Images',
+};
+
+const sortOptions: { [key in Sort]: string } = {
+  bytes: 'Most bytes',
+  external: 'Most bytes to other sites',
+};
+
+function printBytes(n: number): string {
+  const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return `${i === 0 ? n : n.toFixed(1)} ${units[i]}`;
+}
+
+function today(): string {
+  return new Date().toISOString().slice(0, 10);
+}
+
+export default function Bandwidth() {
+  const [type, setType] = useState<RowType>('community');
+  const [sort, setSort] = useState<Sort>('bytes');
+  const [day, setDay] = useState(today());
+  const [rows, setRows] = useState<Row[] | null>(null);
+  const [loading, setLoading] = useLoading('loading');
+
+  const dispatch = useDispatch();
+  useEffect(() => {
+    const f = async () => {
+      try {
+        const params = new URLSearchParams({ type, sort, day });
+        setRows((await mfetchjson(`/api/bandwidth?${params.toString()}`)) as Row[]);
+        setLoading('loaded');
+      } catch (error) {
+        dispatch(snackAlertError(error));
+        setLoading('error');
+      }
+    };
+    f();
+  }, [type, sort, day]);
+
+  const renderName = (row: Row) => {
+    if (row.imageId) {
+      return (
+        <a className="table-column" href={`/images/${row.imageId}.jpeg`} target="_blank" rel="noreferrer">
+          {row.imageId}
+        </a>
+      );
+    }
+    if (!row.ownerName) {
+      return <div className="table-column">{row.ownerId}</div>;
+    }
+    const to = row.ownerType === 'user' ? `/@${row.ownerName}` : `/${row.ownerName}`;
+    return (
+      <Link className="table-column" to={to}>
+        {row.ownerType === 'user' ? `@${row.ownerName}` : row.ownerName}
+      </Link>
+    );
+  };
+
+  const handleRenderItem = (row: Row) => {
+    return (
+      <TableRow columns={5}>
+        {renderName(row)}
+        <div className="table-column">{row.requests}</div>
+        <div className="table-column">{printBytes(row.bytes)}</div>
+        <div className="table-column">{row.externalRequests}</div>
+        <div className="table-column">{printBytes(row.externalBytes)}</div>
+      </TableRow>
+    );
+  };
+
+  const handleRenderHead = () => {
+    return (
+      <TableRow columns={5} head>
+        <div className="table-column">{type === 'image' ? 'Image' : 'Name'}</div>
+        <div className="table-column">Requests</div>
+        <div className="table-column">Bytes</div>
+        <div className="table-column">External requests</div>
+        <div className="table-column">External bytes</div>
+      </TableRow>
+    );
+  };
+
+  const renderDropdown = <T extends string>(
+    options: { [key in T]: string },
+    value: T,
+    onChange: (value: T) => void
+  ) => (
+    <Dropdown target={<Button>{options[value]}</Button>}>
+      <div className="dropdown-list">
+        {(Object.keys(options) as T[])
+          .filter((key) => key !== value)
+          .map((key) => (
+            <Button className="button-clear dropdown-item" onClick={() => onChange(key)} key={key}>
+              {options[key]}
+            </Button>
+          ))}
+      </div>
+    </Dropdown>
+  );
+
+  const feedItems: SimpleFeedItem<Row>[] = [];
+  rows?.forEach((row) =>
+    feedItems.push({ item: row, key: `${row.ownerType}-${row.ownerId || row.imageId}` })
+  );
+
+  return (
+    <div className="dashboard-page-bandwidth document">
+      <div className="dashboard-page-title">Bandwidth</div>
+      <div className="dashboard-page-content">
+        <div className="flex" style={{ gap: '8px', marginBottom: '16px' }}>
+          {renderDropdown(typeOptions, type, setType)}
+          {renderDropdown(sortOptions, sort, setSort)}
+          <input
+            type="date"
+            value={day}
+            max={today()}
+            onChange={(e) => e.target.value && setDay(e.target.value)}
+          />
+        </div>
+        {loading !== 'loaded' ? (
+          <PageLoading />
+        ) : (
+          <SimpleFeed
+            className="table"
+            items={feedItems}
+            onRenderItem={handleRenderItem}
+            onRenderHead={handleRenderHead}
+          />
+        )}
+      </div>
+    </div>
+  );
+}
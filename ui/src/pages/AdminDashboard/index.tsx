@@ -5,6 +5,7 @@ import { Route, Switch, useRouteMatch } from 'react-router-dom';
 import { ButtonHamburger } from '../../components/Button';
 import { RootState } from '../../store';
 import Forbidden from '../Forbidden';
+import Bandwidth from './Bandwidth';
 import BasicSiteAnalytics from './BasicSiteAnalytics';
 import Comments from './Comments';
 import Communities from './Communities';
@@ -94,6 +95,9 @@ function AdminDashboard() {
             <Route exact path={`${path}/bss`}>
               <BasicSiteAnalytics />
             </Route>
+            <Route exact path={`${path}/bandwidth`}>
+              <Bandwidth />
+            </Route>
             <Route exact path={`${path}/new-community-requests`}>
               <NewCommunityRequests />
             </Route>
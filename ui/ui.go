@@ -0,0 +1,13 @@
+// Package ui holds the built frontend, if it's embedded in the binary.
+//
+// To embed it, build the frontend (npm run build, in this directory) and then
+// the binary with the embedui tag (go build -tags embedui), which is what
+// build.sh does if EMBED_UI is set. Binaries so built don't need the ui/dist
+// directory, nor a UIProxy.
+package ui
+
+import "io/fs"
+
+// Dist is the built frontend (the contents of ui/dist) embedded in the
+// binary, or nil if the binary is built without the embedui tag.
+var Dist fs.FS